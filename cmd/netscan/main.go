@@ -0,0 +1,172 @@
+// netscan discovers the hosts on a network.
+//
+// It sweeps a network with ARP requests on a raw socket, and optionally
+// ICMP Echo Requests and TCP SYNs, at a fixed probe rate, and prints the
+// hosts that answered with the vendor of their MAC address. Sending raw
+// frames needs root.
+//
+// Usage:
+//
+//	sudo go run ./cmd/netscan -i eth0 192.168.1.0/24
+//	sudo go run ./cmd/netscan -i eth0 -probes arp,icmp,tcp -ports 22,80,443 -rate 500 192.168.1.0/24
+//
+// The source address defaults to the interface's first IPv4 address. IP
+// probes to hosts that do not answer ARP, such as those behind a router,
+// go to -gateway-mac, which defaults to broadcast. Interrupting the scan
+// prints the hosts found so far.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/scan"
+)
+
+var (
+	interfaceName = flag.String("i", "eth0", "Network interface name")
+	srcAddr       = flag.String("src", "", "Source IP address (default: the interface's)")
+	probes        = flag.String("probes", "arp", "Probes to send: arp, icmp and tcp, comma separated")
+	ports         = flag.String("ports", "22,80,443", "Ports of TCP probes, comma separated")
+	rate          = flag.Float64("rate", scan.DefaultRate, "Probes per second")
+	timeout       = flag.Duration("timeout", scan.DefaultTimeout, "Time to wait for replies after each sweep")
+	retries       = flag.Int("retries", 1, "Sweeps repeated for the hosts that have not answered")
+	gatewayMAC    = flag.String("gateway-mac", "", "MAC address of IP probes to hosts that do not answer ARP")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: netscan -i <if> [flags] <network/prefix>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	targets, err := parseNetwork(flag.Arg(0))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	config, err := buildConfig()
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	iface, err := ethernet.OpenInterface(*interfaceName)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer iface.Close()
+
+	scanner, err := scan.New(iface, config)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	// Stop on interrupt and print the hosts found so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Scanning %d addresses of %s on %s (%s)\n", len(targets), flag.Arg(0), iface.Name(), config.Probes)
+	hosts, stats, err := scanner.Scan(ctx, targets)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fatalf("%v", err)
+	}
+
+	for _, h := range hosts {
+		line := h.String()
+		if h.Probes&scan.ProbeARP == 0 {
+			line += " (via " + h.Probes.String() + ")"
+		}
+		if len(h.Ports) > 0 {
+			line += fmt.Sprintf(" open %s", joinPorts(h.Ports))
+		}
+		fmt.Printf("%s %.3f ms\n", line, float64(h.RTT.Microseconds())/1000.0)
+	}
+	fmt.Printf("\n%d hosts up, %d probes sent (%d failed), %d replies\n",
+		len(hosts), stats.Sent, stats.SendErrors, stats.Replies)
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "netscan: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// parseNetwork returns the host addresses of a network in CIDR notation.
+func parseNetwork(s string) ([]common.IPv4Address, error) {
+	network, err := common.ParsePrefix(s)
+	if err != nil {
+		return nil, err
+	}
+	return scan.Hosts(network)
+}
+
+// buildConfig builds the scanner's configuration from the flags.
+func buildConfig() (scan.Config, error) {
+	config := scan.Config{Rate: *rate, Timeout: *timeout, Retries: *retries}
+
+	var err error
+	if config.Probes, err = scan.ParseProbes(*probes); err != nil {
+		return config, err
+	}
+	for _, field := range strings.Split(*ports, ",") {
+		port, err := strconv.ParseUint(strings.TrimSpace(field), 10, 16)
+		if err != nil || port == 0 {
+			return config, fmt.Errorf("invalid port %q", field)
+		}
+		config.Ports = append(config.Ports, uint16(port))
+	}
+	if *gatewayMAC != "" {
+		if config.Gateway, err = common.ParseMAC(*gatewayMAC); err != nil {
+			return config, fmt.Errorf("invalid gateway MAC address: %w", err)
+		}
+	}
+
+	if *srcAddr != "" {
+		if config.Source, err = common.ParseIPv4(*srcAddr); err != nil {
+			return config, fmt.Errorf("invalid source address: %w", err)
+		}
+		return config, nil
+	}
+	config.Source, err = interfaceAddress(*interfaceName)
+	return config, err
+}
+
+// interfaceAddress returns the first IPv4 address of an interface.
+func interfaceAddress(name string) (common.IPv4Address, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return common.IPv4Address{}, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return common.IPv4Address{}, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			var ip common.IPv4Address
+			copy(ip[:], ipNet.IP.To4())
+			return ip, nil
+		}
+	}
+	return common.IPv4Address{}, fmt.Errorf("%s has no IPv4 address; use -src", name)
+}
+
+func joinPorts(ports []uint16) string {
+	s := make([]string, len(ports))
+	for i, p := range ports {
+		s[i] = strconv.Itoa(int(p))
+	}
+	return strings.Join(s, ",")
+}
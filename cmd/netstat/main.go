@@ -0,0 +1,139 @@
+// netstat prints the sockets of an application built on the stack.
+//
+// The application serves its socket table with pkg/netstat's Handler; this
+// tool fetches it as JSON over a host TCP connection and prints it in the
+// style of netstat(8).
+//
+// Usage:
+//
+//	go run ./cmd/netstat [-t] [-u] [-l | -a] [-json] [-w 2s] http://192.168.1.100:8080/netstat
+//
+// Without -l or -a only connected sockets are shown; with -l only
+// listening TCP sockets and UDP bindings, with -a both.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/http"
+	"github.com/therealutkarshpriyadarshi/network/pkg/netstat"
+)
+
+var (
+	showTCP    = flag.Bool("t", false, "Show TCP sockets")
+	showUDP    = flag.Bool("u", false, "Show UDP sockets")
+	listening  = flag.Bool("l", false, "Show only listening sockets")
+	all        = flag.Bool("a", false, "Show listening and connected sockets")
+	printJSON  = flag.Bool("json", false, "Print the raw JSON snapshot")
+	interval   = flag.Duration("w", 0, "Refresh at this interval until interrupted")
+	reqTimeout = flag.Duration("timeout", 5*time.Second, "Request timeout")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: netstat [flags] URL\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	target, err := snapshotURL(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "netstat: %v\n", err)
+		os.Exit(2)
+	}
+	client := &http.Client{Dial: dial}
+
+	for {
+		if err := show(client, target); err != nil {
+			fmt.Fprintf(os.Stderr, "netstat: %v\n", err)
+			os.Exit(1)
+		}
+		if *interval <= 0 {
+			return
+		}
+		time.Sleep(*interval)
+		fmt.Println()
+	}
+}
+
+// snapshotURL adds the JSON format parameter to the endpoint URL.
+func snapshotURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" {
+		return "", fmt.Errorf("unsupported URL %q: want http://host:port/path", raw)
+	}
+	q := u.Query()
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// dial opens a host TCP connection to the application.
+func dial(host string, port uint16) (http.Conn, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))), *reqTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(*reqTimeout))
+	return conn, nil
+}
+
+// show fetches one snapshot and prints the selected sockets.
+func show(client *http.Client, target string) error {
+	resp, err := client.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+
+	var snap netstat.Snapshot
+	if err := json.Unmarshal(body, &snap); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	selected := snap.Filter(keep)
+
+	if *printJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(selected)
+	}
+	return selected.WriteTable(os.Stdout)
+}
+
+// keep applies the protocol and listening flags to an entry.
+func keep(e *netstat.Entry) bool {
+	if (*showTCP || *showUDP) && !(*showTCP && e.Proto == "tcp" || *showUDP && e.Proto == "udp") {
+		return false
+	}
+	switch {
+	case *all:
+		return true
+	case *listening:
+		return e.Listening()
+	default:
+		return !e.Listening()
+	}
+}
@@ -0,0 +1,240 @@
+// pktgen generates traffic on an interface for performance testing.
+//
+// It either synthesizes TCP, UDP or ICMP flows at a target packet rate, or
+// replays a pcap capture with its original timing (or faster), sending
+// frames in batches on a raw socket, or with -xdp on an AF_XDP socket
+// bypassing the kernel stack, and reports the packet and bit rates
+// achieved. Sending raw frames needs root.
+//
+// Usage:
+//
+//	sudo go run ./cmd/pktgen -i eth0 -src 192.168.1.200 -dst 192.168.1.100 \
+//		-dst-mac 02:00:00:00:00:02 -proto udp -dport 9 -size 64 -rate 100000 -d 10s
+//	sudo go run ./cmd/pktgen -i eth0 -replay capture.pcap -speed 2 -loop 5
+//	sudo go run ./cmd/pktgen -i eth0 -xdp -queue 0 -src 192.168.1.200 -dst 192.168.1.100
+//
+// Synthetic frames go to -dst-mac, which defaults to broadcast. -flows
+// spreads the traffic over that many source ports (or echo identifiers) to
+// exercise flow hashing on the receiver. -v prints the first frames to be
+// sent, decoded. Interrupting the run prints what was sent so far.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/decode"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/pcap"
+	"github.com/therealutkarshpriyadarshi/network/pkg/pktgen"
+)
+
+var (
+	interfaceName = flag.String("i", "eth0", "Network interface name")
+	proto         = flag.String("proto", "udp", "Protocol of synthetic flows: udp, tcp or icmp")
+	srcAddr       = flag.String("src", "", "Source IP address")
+	dstAddr       = flag.String("dst", "", "Destination IP address")
+	dstMAC        = flag.String("dst-mac", "ff:ff:ff:ff:ff:ff", "Destination MAC address")
+	srcPort       = flag.Uint("sport", 40000, "First source port (ICMP: echo identifier)")
+	dstPort       = flag.Uint("dport", 9, "Destination port")
+	flows         = flag.Int("flows", 1, "Number of flows")
+	size          = flag.Int("size", 64, "Payload bytes per packet")
+	rate          = flag.Float64("rate", 0, "Packets per second (0 for as fast as possible)")
+	count         = flag.Int("n", 0, "Packets to send (0 for no limit)")
+	duration      = flag.Duration("d", 0, "How long to send (0 for no limit)")
+	batchSize     = flag.Int("batch", pktgen.DefaultBatchSize, "Frames per batch")
+	replayFile    = flag.String("replay", "", "Replay this pcap file instead of synthesizing flows")
+	speed         = flag.Float64("speed", 1, "Replay speed (1 for original timing, 0 for as fast as possible)")
+	loops         = flag.Int("loop", 1, "Times to replay the capture")
+	verbose       = flag.Bool("v", false, "Print the frames to be sent, decoded")
+	useXDP        = flag.Bool("xdp", false, "Send on an AF_XDP socket")
+	xdpQueue      = flag.Int("queue", 0, "NIC queue of the AF_XDP socket")
+	xdpMode       = flag.String("xdp-mode", "auto", "AF_XDP mode: auto, copy or zero-copy")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: pktgen -i <if> (-src <ip> -dst <ip> | -replay <file>) [flags]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 || (*replayFile == "" && (*srcAddr == "" || *dstAddr == "")) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	iface, err := openDevice()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer iface.Close()
+
+	gen, err := pktgen.New(iface, pktgen.Config{Rate: *rate, Count: *count, Duration: *duration, BatchSize: *batchSize})
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	// Stop on interrupt and report what was sent
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		gen.Stop()
+	}()
+
+	var report pktgen.Report
+	if *replayFile != "" {
+		packets, err := readCapture(*replayFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		fmt.Printf("Replaying %d packets from %s on %s\n", len(packets), *replayFile, iface.Name())
+		if *verbose {
+			for i, pkt := range packets {
+				if !printFrame(i, len(packets), decode.Frame(pkt.Data)) {
+					break
+				}
+			}
+		}
+		report, err = gen.Replay(packets, *speed, *loops)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	} else {
+		frames, err := buildFrames(iface)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		fmt.Printf("Sending %s %s -> %s (%d flows, %d-byte frames) on %s\n",
+			*proto, *srcAddr, *dstAddr, len(frames), frames[0].Size(), iface.Name())
+		if *verbose {
+			for i, frame := range frames {
+				if !printFrame(i, len(frames), decode.FromFrame(frame)) {
+					break
+				}
+			}
+		}
+		report, err = gen.Run(frames)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+	fmt.Println(report)
+}
+
+// maxPrinted is the number of frames -v prints.
+const maxPrinted = 10
+
+// printFrame prints the i'th of n frames, and reports whether to go on to
+// the next one.
+func printFrame(i, n int, pkt *decode.Packet) bool {
+	if i == maxPrinted {
+		fmt.Printf("  ... %d more\n", n-maxPrinted)
+		return false
+	}
+	fmt.Printf("  %s\n", pkt)
+	return true
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "pktgen: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// openDevice opens the interface, on an AF_XDP socket with -xdp.
+func openDevice() (ethernet.Device, error) {
+	if !*useXDP {
+		return ethernet.OpenInterface(*interfaceName)
+	}
+	config := ethernet.XDPConfig{Queue: *xdpQueue}
+	switch *xdpMode {
+	case "auto":
+	case "copy":
+		config.Mode = ethernet.XDPModeCopy
+	case "zero-copy":
+		config.Mode = ethernet.XDPModeZeroCopy
+	default:
+		return nil, fmt.Errorf("invalid AF_XDP mode %q", *xdpMode)
+	}
+	xdp, err := ethernet.OpenXDPInterface(*interfaceName, config)
+	if err != nil {
+		return nil, err
+	}
+	mode := "copy"
+	if xdp.ZeroCopy() {
+		mode = "zero-copy"
+	}
+	fmt.Printf("AF_XDP socket on %s queue %d, %s mode\n", xdp.Name(), xdp.Queue(), mode)
+	return xdp, nil
+}
+
+// buildFrames builds the frames of the synthetic flows.
+func buildFrames(iface ethernet.Device) ([]*ethernet.Frame, error) {
+	protocol, err := pktgen.ParseProtocol(*proto)
+	if err != nil {
+		return nil, err
+	}
+	src, err := common.ParseIPv4(*srcAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source address: %w", err)
+	}
+	dst, err := common.ParseIPv4(*dstAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination address: %w", err)
+	}
+	hw, err := net.ParseMAC(*dstMAC)
+	if err != nil || len(hw) != 6 {
+		return nil, fmt.Errorf("invalid destination MAC address %q", *dstMAC)
+	}
+	if *srcPort > 65535 || *dstPort > 65535 {
+		return nil, fmt.Errorf("invalid port")
+	}
+
+	config := pktgen.FlowConfig{
+		Protocol:        protocol,
+		SourceMAC:       iface.MACAddress(),
+		Source:          src,
+		Destination:     dst,
+		SourcePort:      uint16(*srcPort),
+		DestinationPort: uint16(*dstPort),
+		Flows:           *flows,
+		PayloadSize:     *size,
+	}
+	copy(config.DestinationMAC[:], hw)
+	return pktgen.BuildFrames(config)
+}
+
+// readCapture reads the packets of an Ethernet capture.
+func readCapture(name string) ([]*pcap.Packet, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if r.LinkType() != pcap.LinkTypeEthernet {
+		return nil, fmt.Errorf("%s: link type %d is not Ethernet", name, r.LinkType())
+	}
+
+	var packets []*pcap.Packet
+	for {
+		pkt, err := r.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		packets = append(packets, pkt)
+	}
+	return packets, nil
+}
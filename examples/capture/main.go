@@ -1,6 +1,7 @@
 // Package main provides an example of capturing and displaying Ethernet frames.
-// This program demonstrates the Phase 1 capabilities: raw socket packet capture
-// and Ethernet frame parsing.
+// Frames are read from a raw socket and printed one per line in the style of
+// tcpdump by the decode package; -v adds a line per protocol layer and -x a
+// hex dump of the frame.
 //
 // Usage:
 //
@@ -18,16 +19,18 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/decode"
 	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
 )
 
 var (
-	ifaceFlag = flag.String("i", "", "Network interface to capture on (e.g., eth0, wlan0)")
-	countFlag = flag.Int("c", 0, "Number of packets to capture (0 = unlimited)")
-	hexFlag   = flag.Bool("x", false, "Display hex dump of packets")
-	verboseFlag = flag.Bool("v", false, "Verbose output")
+	ifaceFlag   = flag.String("i", "", "Network interface to capture on (e.g., eth0, wlan0)")
+	countFlag   = flag.Int("c", 0, "Number of packets to capture (0 = unlimited)")
+	hexFlag     = flag.Bool("x", false, "Display hex dump of packets")
+	verboseFlag = flag.Bool("v", false, "Verbose output (interface details and every protocol layer)")
 )
 
 func main() {
@@ -100,19 +103,24 @@ func main() {
 	done := make(chan bool)
 
 	go func() {
+		// Read the frames queued on the socket a batch at a time
+		frames := make([]*ethernet.Frame, ethernet.MaxBatchSize)
 		for {
-			frame, err := iface.ReadFrame()
+			n, err := ethernet.ReadFrames(iface, frames)
 			if err != nil {
-				log.Printf("Error reading frame: %v", err)
+				log.Printf("Error reading frames: %v", err)
 				continue
 			}
 
-			packetCount++
-			displayFrame(packetCount, frame, *hexFlag)
+			for _, frame := range frames[:n] {
+				packetCount++
+				displayFrame(packetCount, frame, *hexFlag)
+				frame.Release()
 
-			if *countFlag > 0 && packetCount >= *countFlag {
-				done <- true
-				return
+				if *countFlag > 0 && packetCount >= *countFlag {
+					done <- true
+					return
+				}
 			}
 		}
 	}()
@@ -128,127 +136,19 @@ func main() {
 	fmt.Printf("\nCaptured %d packets\n", packetCount)
 }
 
-// displayFrame prints information about a captured frame.
+// displayFrame prints a captured frame: a one-line decode, or one line per
+// layer in verbose mode, and optionally a hex dump of the whole frame.
 func displayFrame(num int, frame *ethernet.Frame, showHex bool) {
-	// Basic frame info
-	fmt.Printf("[%d] %s\n", num, frame)
-	fmt.Printf("     %s -> %s\n", frame.Source, frame.Destination)
-
-	// Frame type
-	if frame.IsBroadcast() {
-		fmt.Printf("     Type: Broadcast\n")
-	} else if frame.IsMulticast() {
-		fmt.Printf("     Type: Multicast\n")
-	} else {
-		fmt.Printf("     Type: Unicast\n")
-	}
-
-	// Payload info
-	if len(frame.Payload) > 0 {
-		fmt.Printf("     Payload: %d bytes\n", len(frame.Payload))
-
-		// Try to identify protocol from payload
-		if len(frame.Payload) >= 1 {
-			displayProtocolInfo(frame)
-		}
-	}
-
-	// Hex dump if requested
-	if showHex && len(frame.Payload) > 0 {
-		// Limit hex dump to first 64 bytes
-		dumpSize := len(frame.Payload)
-		if dumpSize > 64 {
-			dumpSize = 64
-		}
-		fmt.Printf("     Hex dump (first %d bytes):\n", dumpSize)
-		dump := common.HexDump(frame.Payload[:dumpSize])
-		// Indent the hex dump
-		for _, line := range []byte(dump) {
-			if line == '\n' {
-				fmt.Print("\n")
-			} else {
-				if line == dump[0] || dump[int(line)-1] == '\n' {
-					fmt.Print("       ")
-				}
-				fmt.Printf("%c", line)
-			}
-		}
-	}
-
-	fmt.Println()
-}
-
-// displayProtocolInfo attempts to display protocol-specific information.
-func displayProtocolInfo(frame *ethernet.Frame) {
-	switch frame.EtherType {
-	case common.EtherTypeIPv4:
-		displayIPv4Info(frame.Payload)
-	case common.EtherTypeARP:
-		displayARPInfo(frame.Payload)
-	case common.EtherTypeIPv6:
-		fmt.Printf("     Protocol: IPv6 (not yet implemented)\n")
-	default:
-		fmt.Printf("     Protocol: %s\n", frame.EtherType)
-	}
-}
-
-// displayIPv4Info displays basic IPv4 packet information.
-func displayIPv4Info(payload []byte) {
-	if len(payload) < 20 {
-		fmt.Printf("     Protocol: IPv4 (truncated)\n")
-		return
-	}
+	pkt := decode.FromFrame(frame)
+	fmt.Printf("[%d] %s %s\n", num, time.Now().Format("15:04:05.000000"), pkt)
 
-	// Parse basic IP header fields
-	version := payload[0] >> 4
-	ihl := payload[0] & 0x0F
-	protocol := payload[9]
-	srcIP := common.IPv4Address{payload[12], payload[13], payload[14], payload[15]}
-	dstIP := common.IPv4Address{payload[16], payload[17], payload[18], payload[19]}
-
-	fmt.Printf("     Protocol: IPv4 (v%d, IHL=%d)\n", version, ihl)
-	fmt.Printf("     IP: %s -> %s\n", srcIP, dstIP)
-
-	// Display transport protocol
-	switch common.Protocol(protocol) {
-	case common.ProtocolTCP:
-		fmt.Printf("     Transport: TCP\n")
-	case common.ProtocolUDP:
-		fmt.Printf("     Transport: UDP\n")
-	case common.ProtocolICMP:
-		fmt.Printf("     Transport: ICMP\n")
-	default:
-		fmt.Printf("     Transport: Protocol %d\n", protocol)
-	}
-}
-
-// displayARPInfo displays basic ARP packet information.
-func displayARPInfo(payload []byte) {
-	if len(payload) < 28 {
-		fmt.Printf("     Protocol: ARP (truncated)\n")
-		return
+	indent := common.NewIndentWriter(os.Stdout, "     ")
+	if *verboseFlag {
+		fmt.Fprintln(indent, pkt.Verbose())
 	}
 
-	// Parse ARP header
-	opcode := uint16(payload[6])<<8 | uint16(payload[7])
-
-	var operation string
-	switch opcode {
-	case 1:
-		operation = "Request"
-	case 2:
-		operation = "Reply"
-	default:
-		operation = fmt.Sprintf("Unknown(%d)", opcode)
+	if showHex {
+		common.HexDumpConfig{}.Write(indent, frame.Serialize())
+		fmt.Println()
 	}
-
-	// Sender and target addresses
-	senderMAC := common.MACAddress{payload[8], payload[9], payload[10], payload[11], payload[12], payload[13]}
-	senderIP := common.IPv4Address{payload[14], payload[15], payload[16], payload[17]}
-	targetMAC := common.MACAddress{payload[18], payload[19], payload[20], payload[21], payload[22], payload[23]}
-	targetIP := common.IPv4Address{payload[24], payload[25], payload[26], payload[27]}
-
-	fmt.Printf("     Protocol: ARP %s\n", operation)
-	fmt.Printf("     Sender: %s (%s)\n", senderIP, senderMAC)
-	fmt.Printf("     Target: %s (%s)\n", targetIP, targetMAC)
 }
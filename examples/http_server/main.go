@@ -1,7 +1,7 @@
 // HTTP Server Example
 //
 // This example demonstrates a simple HTTP/1.1 server using the custom TCP implementation.
-// The server can handle basic GET requests and serve static files.
+// The server uses pkg/http to serve static files over persistent connections.
 //
 // Usage:
 //   sudo go run main.go -i eth0 -addr 192.168.1.100 -port 8080 -dir ./www
@@ -10,21 +10,34 @@
 //   curl http://192.168.1.100:8080/
 //   curl http://192.168.1.100:8080/test.html
 //
+// Stack counters are served in Prometheus format at /metrics, and the
+// socket table at /netstat:
+//   curl http://192.168.1.100:8080/metrics
+//   go run ./cmd/netstat -a http://192.168.1.100:8080/netstat
+//
+// Serve HTTPS with -tls. Without -cert and -key a self-signed certificate
+// for the listen address is generated:
+//   sudo go run main.go -i eth0 -addr 192.168.1.100 -port 8443 -tls
+//   curl -k https://192.168.1.100:8443/
+//
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/http"
+	"github.com/therealutkarshpriyadarshi/network/pkg/metrics"
+	"github.com/therealutkarshpriyadarshi/network/pkg/netstat"
 	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tls"
 )
 
 var (
@@ -32,41 +45,11 @@ var (
 	listenAddr    = flag.String("addr", "192.168.1.100", "IP address to listen on")
 	listenPort    = flag.Int("port", 8080, "Port to listen on")
 	documentRoot  = flag.String("dir", "./www", "Document root directory")
+	useTLS        = flag.Bool("tls", false, "Serve HTTPS")
+	certFile      = flag.String("cert", "", "PEM certificate for -tls (self-signed if empty)")
+	keyFile       = flag.String("key", "", "PEM private key for -tls")
 )
 
-const (
-	// HTTP status codes
-	StatusOK                  = 200
-	StatusBadRequest          = 400
-	StatusNotFound            = 404
-	StatusMethodNotAllowed    = 405
-	StatusInternalServerError = 500
-)
-
-var statusText = map[int]string{
-	StatusOK:                  "OK",
-	StatusBadRequest:          "Bad Request",
-	StatusNotFound:            "Not Found",
-	StatusMethodNotAllowed:    "Method Not Allowed",
-	StatusInternalServerError: "Internal Server Error",
-}
-
-// HTTPRequest represents a parsed HTTP request.
-type HTTPRequest struct {
-	Method  string
-	Path    string
-	Version string
-	Headers map[string]string
-	Body    []byte
-}
-
-// HTTPResponse represents an HTTP response.
-type HTTPResponse struct {
-	StatusCode int
-	Headers    map[string]string
-	Body       []byte
-}
-
 func main() {
 	flag.Parse()
 
@@ -93,7 +76,7 @@ func main() {
     <p>Current time: ` + time.Now().Format(time.RFC3339) + `</p>
 </body>
 </html>`
-		if err := ioutil.WriteFile(indexPath, []byte(defaultHTML), 0644); err != nil {
+		if err := os.WriteFile(indexPath, []byte(defaultHTML), 0644); err != nil {
 			log.Printf("Failed to create default index.html: %v", err)
 		}
 	}
@@ -120,123 +103,110 @@ func main() {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	log.Printf("HTTP server listening on http://%s:%d", *listenAddr, *listenPort)
-
-	// Accept connections in a loop
-	for {
-		conn, err := socket.Accept()
+	var listener net.Listener = tcp.NewListener(socket)
+	scheme := "http"
+	if *useTLS {
+		config, err := tlsConfig()
 		if err != nil {
-			log.Printf("Accept error: %v", err)
-			continue
+			log.Fatalf("Failed to set up TLS: %v", err)
 		}
+		listener = tls.WrapListener(listener, config)
+		scheme = "https"
+	}
 
-		log.Printf("Accepted connection from %s:%d",
-			conn.GetRemoteAddr(), conn.GetRemotePort())
+	log.Printf("HTTP server listening on %s://%s:%d", scheme, *listenAddr, *listenPort)
 
-		// Handle connection in a goroutine
-		go handleHTTPConnection(conn)
+	// Serve requests until the socket is closed
+	server := &http.Server{Handler: logRequests(http.HandlerFunc(route))}
+	if err := server.Serve(listener); err != nil {
+		log.Fatalf("Server error: %v", err)
 	}
 }
 
-func handleHTTPConnection(conn *tcp.Socket) {
-	defer func() {
-		conn.Close()
-		log.Printf("Closed connection from %s:%d",
-			conn.GetRemoteAddr(), conn.GetRemotePort())
-	}()
-
-	buf := make([]byte, 8192)
-
-	// Receive HTTP request
-	n, err := conn.Recv(buf)
-	if err != nil {
-		log.Printf("Receive error: %v", err)
-		return
+// tlsConfig loads the server certificate, or generates a self-signed one.
+func tlsConfig() (*tls.Config, error) {
+	var certPEM, keyPEM []byte
+	var err error
+	if *certFile != "" {
+		if certPEM, err = os.ReadFile(*certFile); err != nil {
+			return nil, err
+		}
+		if keyPEM, err = os.ReadFile(*keyFile); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Printf("Generating self-signed certificate for %s", *listenAddr)
+		if certPEM, keyPEM, err = tls.GenerateSelfSignedCert(*listenAddr); err != nil {
+			return nil, err
+		}
 	}
 
-	requestData := buf[:n]
-	log.Printf("Received %d bytes from %s:%d",
-		n, conn.GetRemoteAddr(), conn.GetRemotePort())
-
-	// Parse HTTP request
-	req, err := parseHTTPRequest(requestData)
+	cert, err := tls.LoadCertificate(certPEM, keyPEM)
 	if err != nil {
-		log.Printf("Failed to parse HTTP request: %v", err)
-		sendErrorResponse(conn, StatusBadRequest, err.Error())
-		return
+		return nil, err
 	}
 
-	log.Printf("Request: %s %s %s", req.Method, req.Path, req.Version)
+	config := tls.DefaultConfig()
+	config.Certificates = append(config.Certificates, cert)
+	config.NextProtos = []string{"http/1.1"}
+	return config, nil
+}
 
-	// Handle request
-	var resp *HTTPResponse
-	switch req.Method {
-	case "GET":
-		resp = handleGET(req)
-	case "HEAD":
-		resp = handleGET(req)
-		resp.Body = nil // HEAD doesn't include body
-	default:
-		resp = createErrorResponse(StatusMethodNotAllowed,
-			fmt.Sprintf("Method %s not allowed", req.Method))
-	}
+// statusRecorder remembers the status written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
 
-	// Send response
-	responseData := serializeHTTPResponse(resp)
-	sent, err := conn.Send(responseData)
-	if err != nil {
-		log.Printf("Send error: %v", err)
-		return
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
 	}
-
-	log.Printf("Sent %d bytes (status %d) to %s:%d",
-		sent, resp.StatusCode, conn.GetRemoteAddr(), conn.GetRemotePort())
+	r.ResponseWriter.WriteHeader(code)
 }
 
-func parseHTTPRequest(data []byte) (*HTTPRequest, error) {
-	req := &HTTPRequest{
-		Headers: make(map[string]string),
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
 	}
+	return r.ResponseWriter.Write(p)
+}
 
-	scanner := bufio.NewScanner(strings.NewReader(string(data)))
-
-	// Parse request line
-	if !scanner.Scan() {
-		return nil, fmt.Errorf("empty request")
-	}
+// logRequests logs each request with its status and duration.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		log.Printf("%s %s %s %d (%v) from %s",
+			r.Method, r.Target, r.Proto, rec.status, time.Since(start), r.RemoteAddr)
+	})
+}
 
-	requestLine := scanner.Text()
-	parts := strings.SplitN(requestLine, " ", 3)
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("invalid request line: %s", requestLine)
+// route serves /metrics and /netstat from the stack and everything else
+// from the document root.
+func route(w http.ResponseWriter, r *http.Request) {
+	switch r.Path {
+	case "/metrics":
+		metrics.Handler().ServeHTTP(w, r)
+	case "/netstat":
+		netstat.Handler().ServeHTTP(w, r)
+	default:
+		serveFile(w, r)
 	}
+}
 
-	req.Method = parts[0]
-	req.Path = parts[1]
-	req.Version = parts[2]
-
-	// Parse headers
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" || line == "\r" {
-			break // End of headers
-		}
-
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-			req.Headers[key] = value
-		}
+// serveFile serves GET and HEAD requests from the document root.
+func serveFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		sendError(w, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s not allowed", r.Method))
+		return
 	}
 
-	return req, nil
-}
-
-func handleGET(req *HTTPRequest) *HTTPResponse {
 	// Clean path to prevent directory traversal
-	path := filepath.Clean(req.Path)
-	if path == "." || path == "/" {
+	path := filepath.Clean("/" + r.Path)
+	if path == "/" {
 		path = "/index.html"
 	}
 
@@ -247,48 +217,37 @@ func handleGET(req *HTTPRequest) *HTTPResponse {
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return createErrorResponse(StatusNotFound,
-				fmt.Sprintf("File not found: %s", req.Path))
+			sendError(w, http.StatusNotFound, fmt.Sprintf("File not found: %s", r.Path))
+			return
 		}
-		return createErrorResponse(StatusInternalServerError,
-			fmt.Sprintf("Error accessing file: %v", err))
+		sendError(w, http.StatusInternalServerError, fmt.Sprintf("Error accessing file: %v", err))
+		return
 	}
 
 	// If directory, try to serve index.html
 	if fileInfo.IsDir() {
 		filePath = filepath.Join(filePath, "index.html")
 		if _, err := os.Stat(filePath); err != nil {
-			return createErrorResponse(StatusNotFound,
-				"Directory listing not allowed")
+			sendError(w, http.StatusNotFound, "Directory listing not allowed")
+			return
 		}
 	}
 
 	// Read file
-	content, err := ioutil.ReadFile(filePath)
+	content, err := os.ReadFile(filePath)
 	if err != nil {
-		return createErrorResponse(StatusInternalServerError,
-			fmt.Sprintf("Error reading file: %v", err))
-	}
-
-	// Determine content type
-	contentType := getContentType(filePath)
-
-	// Create response
-	resp := &HTTPResponse{
-		StatusCode: StatusOK,
-		Headers: map[string]string{
-			"Content-Type":   contentType,
-			"Content-Length": fmt.Sprintf("%d", len(content)),
-			"Server":         "Custom-TCP-Stack/1.0",
-			"Date":           time.Now().UTC().Format(time.RFC1123),
-		},
-		Body: content,
+		sendError(w, http.StatusInternalServerError, fmt.Sprintf("Error reading file: %v", err))
+		return
 	}
 
-	return resp
+	w.Header().Set("Content-Type", getContentType(filePath))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+	w.Header().Set("Server", "Custom-TCP-Stack/1.0")
+	w.Write(content)
 }
 
-func createErrorResponse(statusCode int, message string) *HTTPResponse {
+// sendError replies with an HTML error page.
+func sendError(w http.ResponseWriter, statusCode int, message string) {
 	body := fmt.Sprintf(`<!DOCTYPE html>
 <html>
 <head>
@@ -298,48 +257,13 @@ func createErrorResponse(statusCode int, message string) *HTTPResponse {
     <h1>%d %s</h1>
     <p>%s</p>
 </body>
-</html>`, statusCode, statusText[statusCode], statusCode, statusText[statusCode], message)
-
-	return &HTTPResponse{
-		StatusCode: statusCode,
-		Headers: map[string]string{
-			"Content-Type":   "text/html",
-			"Content-Length": fmt.Sprintf("%d", len(body)),
-			"Server":         "Custom-TCP-Stack/1.0",
-			"Date":           time.Now().UTC().Format(time.RFC1123),
-		},
-		Body: []byte(body),
-	}
-}
-
-func sendErrorResponse(conn *tcp.Socket, statusCode int, message string) {
-	resp := createErrorResponse(statusCode, message)
-	responseData := serializeHTTPResponse(resp)
-	conn.Send(responseData)
-}
-
-func serializeHTTPResponse(resp *HTTPResponse) []byte {
-	var builder strings.Builder
-
-	// Status line
-	builder.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n",
-		resp.StatusCode, statusText[resp.StatusCode]))
-
-	// Headers
-	for key, value := range resp.Headers {
-		builder.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
-	}
-
-	// End of headers
-	builder.WriteString("\r\n")
-
-	// Body
-	result := []byte(builder.String())
-	if resp.Body != nil {
-		result = append(result, resp.Body...)
-	}
+</html>`, statusCode, http.StatusText(statusCode), statusCode, http.StatusText(statusCode), message)
 
-	return result
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	w.Header().Set("Server", "Custom-TCP-Stack/1.0")
+	w.WriteHeader(statusCode)
+	w.Write([]byte(body))
 }
 
 func getContentType(filePath string) string {
@@ -0,0 +1,330 @@
+// HTTP Client Example
+//
+// This example fetches a URL with pkg/http over the custom TCP/IP stack:
+// frames are read from a raw interface, passed through the hook pipeline,
+// and delivered to tcp.Socket connections opened with Connect.
+//
+// Usage:
+//   sudo go run examples/httpget/main.go -i eth0 -addr 192.168.1.200 \
+//     -gateway 192.168.1.1 http://example.com/
+//
+// The address must not be configured on the host: the kernel would answer
+// the server's segments for its own address with resets. Host names are
+// resolved with the system resolver. Pass -n to repeat the request over the
+// same persistent connection.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+	"github.com/therealutkarshpriyadarshi/network/pkg/http"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+var (
+	interfaceName = flag.String("i", "eth0", "Network interface name")
+	localAddr     = flag.String("addr", "", "Source IP address for the stack (not configured on the host)")
+	netmask       = flag.String("netmask", "255.255.255.0", "Netmask of the local network")
+	gatewayAddr   = flag.String("gateway", "", "Default gateway")
+	method        = flag.String("X", "GET", "Request method")
+	data          = flag.String("d", "", "Request body")
+	showHeaders   = flag.Bool("v", false, "Print response status and headers")
+	count         = flag.Int("n", 1, "Number of times to fetch the URL")
+	maxRedirects  = flag.Int("max-redirects", http.DefaultMaxRedirects, "Redirects to follow (0 for none)")
+	headers       headerFlags
+)
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string     { return strings.Join(*h, ", ") }
+func (h *headerFlags) Set(v string) error { *h = append(*h, v); return nil }
+
+// connKey identifies a TCP connection from the remote side.
+type connKey struct {
+	remoteAddr common.IPv4Address
+	remotePort uint16
+	localPort  uint16
+}
+
+// stack is the minimal host stack the client runs on.
+type stack struct {
+	iface    *ethernet.Interface
+	arp      *arp.Handler
+	pipeline *hook.Pipeline
+	addr     common.IPv4Address
+	mask     common.IPv4Address
+	gateway  common.IPv4Address
+
+	mu       sync.Mutex
+	sockets  map[connKey]*tcp.Socket
+	nextPort uint16
+}
+
+func main() {
+	flag.Var(&headers, "H", "Request header (\"Name: value\"), may be repeated")
+	flag.Parse()
+
+	if flag.NArg() != 1 || *localAddr == "" || *gatewayAddr == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s -i <if> -addr <ip> -gateway <ip> [options] <url>\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	s, err := newStack(*interfaceName, mustParseIP(*localAddr), mustParseIP(*netmask), mustParseIP(*gatewayAddr))
+	if err != nil {
+		log.Fatalf("Failed to start stack: %v", err)
+	}
+	defer s.iface.Close()
+	go s.run()
+
+	dialer := &http.TCPDialer{
+		NewSocket: s.newSocket,
+		Release:   s.release,
+		Resolve:   resolve,
+	}
+	client := &http.Client{Dial: dialer.Dial, MaxRedirects: *maxRedirects}
+	if *maxRedirects == 0 {
+		client.MaxRedirects = -1
+	}
+	defer client.CloseIdleConnections()
+
+	for i := 0; i < *count; i++ {
+		start := time.Now()
+		if err := fetch(client, flag.Arg(0)); err != nil {
+			log.Fatalf("Request failed: %v", err)
+		}
+		if *count > 1 {
+			log.Printf("Request %d took %v (%d idle connections)", i+1, time.Since(start), client.IdleConnections())
+		}
+	}
+}
+
+func mustParseIP(s string) common.IPv4Address {
+	addr, err := common.ParseIPv4(s)
+	if err != nil {
+		log.Fatalf("Invalid IP address %q: %v", s, err)
+	}
+	return addr
+}
+
+// resolve looks up the IPv4 address of a host name.
+func resolve(host string) (common.IPv4Address, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return common.IPv4Address{}, err
+	}
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil {
+			return common.IPv4Address{v4[0], v4[1], v4[2], v4[3]}, nil
+		}
+	}
+	return common.IPv4Address{}, fmt.Errorf("no IPv4 address for %s", host)
+}
+
+// fetch sends one request and writes the response body to stdout.
+func fetch(client *http.Client, url string) error {
+	var body io.Reader
+	if *data != "" {
+		body = strings.NewReader(*data)
+	}
+	req, err := http.NewRequest(*method, url, body)
+	if err != nil {
+		return err
+	}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return fmt.Errorf("invalid header %q", h)
+		}
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if *showHeaders {
+		fmt.Fprintf(os.Stderr, "%s %s\n", resp.Proto, resp.Status)
+		for name, values := range resp.Header {
+			for _, v := range values {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", name, v)
+			}
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+func newStack(ifname string, addr, mask, gateway common.IPv4Address) (*stack, error) {
+	iface, err := ethernet.OpenInterface(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stack{
+		iface:    iface,
+		arp:      arp.NewHandler(iface, addr),
+		pipeline: hook.NewPipeline(),
+		addr:     addr,
+		mask:     mask,
+		gateway:  gateway,
+		sockets:  make(map[connKey]*tcp.Socket),
+		nextPort: uint16(49152 + rand.Intn(16384)),
+	}
+
+	p := s.pipeline
+	p.SetHandler(hook.EthernetRx, p.DemuxEthernet(s.handleARP))
+	p.SetHandler(hook.IPRx, p.DemuxIP(nil))
+	p.SetHandler(hook.TCPRx, s.deliver)
+	p.SetHandler(hook.TCPTx, p.EncapsulateTCP())
+	p.SetHandler(hook.IPTx, s.transmit)
+
+	// Only packets for the stack's own address are received
+	p.Register(hook.IPRx, -100, "local-address", func(pkt *hook.Packet) hook.Verdict {
+		if pkt.IP.Destination != s.addr {
+			return hook.Drop
+		}
+		return hook.Continue
+	})
+	return s, nil
+}
+
+// run reads frames from the interface into the pipeline, a batch per
+// system call.
+func (s *stack) run() {
+	frames := make([]*ethernet.Frame, ethernet.MaxBatchSize)
+	for {
+		n, err := ethernet.ReadFrames(s.iface, frames)
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		for _, frame := range frames[:n] {
+			s.pipeline.ReceiveFrame(s.iface.Name(), frame)
+		}
+	}
+}
+
+// handleARP answers and learns from ARP frames.
+func (s *stack) handleARP(pkt *hook.Packet) error {
+	if pkt.Frame.EtherType != common.EtherTypeARP {
+		return nil
+	}
+	packet, err := arp.Parse(pkt.Frame.Payload)
+	if err != nil {
+		return err
+	}
+	return s.arp.HandlePacket(packet)
+}
+
+// newSocket creates a socket on a free local port.
+func (s *stack) newSocket() (*tcp.Socket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	port := s.nextPort
+	s.nextPort++
+	if s.nextPort == 0 {
+		s.nextPort = 49152
+	}
+
+	sock := tcp.NewSocket(s.addr, port)
+	sock.SetSendFunc(s.pipeline.TCPSendFunc())
+
+	// The remote end is known once Connect has set it; register by local
+	// port until then
+	s.sockets[connKey{localPort: port}] = sock
+	return sock, nil
+}
+
+// release unregisters a closed socket.
+func (s *stack) release(sock *tcp.Socket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, registered := range s.sockets {
+		if registered == sock {
+			delete(s.sockets, key)
+		}
+	}
+}
+
+// deliver passes a received segment to its socket.
+func (s *stack) deliver(pkt *hook.Packet) error {
+	s.mu.Lock()
+	key := connKey{remoteAddr: pkt.Source, remotePort: pkt.TCP.SourcePort, localPort: pkt.TCP.DestinationPort}
+	sock, ok := s.sockets[key]
+	if !ok {
+		// Match a socket that is still connecting and remember its remote end
+		if sock, ok = s.sockets[connKey{localPort: key.localPort}]; ok &&
+			sock.GetRemoteAddr() == key.remoteAddr && sock.GetRemotePort() == key.remotePort {
+			delete(s.sockets, connKey{localPort: key.localPort})
+			s.sockets[key] = sock
+		} else {
+			ok = false
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return sock.HandleIncomingSegment(pkt.TCP, pkt.Source, pkt.Destination)
+}
+
+// transmit sends a packet to its next hop.
+func (s *stack) transmit(pkt *hook.Packet) error {
+	nextHop := pkt.IP.Destination
+	for i := range nextHop {
+		if nextHop[i]&s.mask[i] != s.addr[i]&s.mask[i] {
+			nextHop = s.gateway
+			break
+		}
+	}
+
+	data, err := pkt.IP.Serialize()
+	if err != nil {
+		return err
+	}
+
+	write := func(mac common.MACAddress) {
+		frame := ethernet.NewFrame(mac, s.iface.MACAddress(), common.EtherTypeIPv4, data)
+		if err := s.iface.WriteFrame(frame); err != nil {
+			log.Printf("Failed to send: %v", err)
+		}
+	}
+
+	if mac, found := s.arp.Cache().Get(nextHop); found {
+		write(mac)
+		return nil
+	}
+
+	// Resolve in the background so the reader can process the ARP reply
+	go func() {
+		mac, err := s.arp.Resolve(nextHop)
+		if err != nil {
+			log.Printf("Failed to resolve %s: %v", nextHop, err)
+			return
+		}
+		write(mac)
+	}()
+	return nil
+}
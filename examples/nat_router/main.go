@@ -0,0 +1,388 @@
+// NAT Router Example
+//
+// This example forwards IPv4 traffic between an internal and an external
+// interface, masquerading every internal host behind one public address.
+//
+// Usage:
+//   sudo go run examples/nat_router/main.go -inside eth1 -inside-ip 192.168.1.1 \
+//     -outside eth0 -public-ip 203.0.113.1 -gateway 203.0.113.254
+//
+// Internal hosts should use the inside address as their default gateway.
+// A stateful firewall on the forward path admits new connections only from
+// the inside; the outside can reach internal hosts only with replies.
+// The kernel must not also route for these interfaces (disable ip_forward
+// and drop its replies to translated ports, or use dedicated interfaces).
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/filter"
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/nat"
+	"github.com/therealutkarshpriyadarshi/network/pkg/rss"
+)
+
+var (
+	insideName  = flag.String("inside", "", "Internal interface")
+	insideAddr  = flag.String("inside-ip", "", "Router address on the internal network")
+	outsideName = flag.String("outside", "", "External interface")
+	publicAddr  = flag.String("public-ip", "", "Public address shared by internal hosts")
+	gatewayAddr = flag.String("gateway", "", "Upstream gateway on the external network")
+	fullCone    = flag.Bool("full-cone", false, "Accept inbound packets from any remote once a mapping exists")
+	workers     = flag.Int("workers", 0, "Receive workers, flows are hashed across them (default one per CPU)")
+	pin         = flag.Bool("pin", false, "Pin the readers and workers to CPUs on the inside interface's NUMA node")
+	verbose     = flag.Bool("v", false, "Log dropped packets")
+)
+
+// port is one side of the router.
+type port struct {
+	iface *ethernet.Interface
+	arp   *arp.Handler
+}
+
+// router forwards packets between the internal and external ports. The
+// translation and filtering steps are hooks in its pipeline: inbound
+// translation at ip-rx, then filtering and outbound translation at ip-tx.
+type router struct {
+	inside   *port
+	outside  *port
+	insideIP common.IPv4Address
+	gateway  common.IPv4Address
+	nat      *nat.NAT
+	firewall filter.PacketFilter
+	pipeline *hook.Pipeline
+	rx       *rss.Dispatcher
+}
+
+func main() {
+	flag.Parse()
+
+	if *insideName == "" || *outsideName == "" || *insideAddr == "" || *publicAddr == "" || *gatewayAddr == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s -inside <if> -inside-ip <ip> -outside <if> -public-ip <ip> -gateway <ip>\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	insideIP := mustParseIP(*insideAddr)
+	publicIP := mustParseIP(*publicAddr)
+	gateway := mustParseIP(*gatewayAddr)
+
+	translator, err := nat.New(nat.Config{
+		PublicIP:                     publicIP,
+		EndpointIndependentFiltering: *fullCone,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create NAT: %v", err)
+	}
+	stopExpiry := translator.StartExpiry(10 * time.Second)
+	defer close(stopExpiry)
+
+	firewall, err := newFirewall(*insideName)
+	if err != nil {
+		log.Fatalf("Failed to configure firewall: %v", err)
+	}
+	stopTracking := firewall.Tracker().StartExpiry(10 * time.Second)
+	defer close(stopTracking)
+
+	r := &router{
+		inside:   openPort(*insideName, insideIP),
+		outside:  openPort(*outsideName, publicIP),
+		insideIP: insideIP,
+		gateway:  gateway,
+		nat:      translator,
+		firewall: firewall,
+		pipeline: hook.NewPipeline(),
+	}
+	if err := r.setupPipeline(); err != nil {
+		log.Fatalf("Failed to set up pipeline: %v", err)
+	}
+	rxConfig := rss.Config{Workers: *workers}
+	readers := []int{-1, -1}
+	if *pin {
+		layout, err := rss.SuggestLayout(*insideName, len(readers), *workers)
+		if err != nil {
+			log.Fatalf("Failed to read CPU topology: %v", err)
+		}
+		readers, rxConfig.CPUs = layout.Readers, layout.Workers
+		fmt.Printf("Readers on CPUs %v, workers on CPUs %v\n", layout.Readers, layout.Workers)
+	}
+	r.rx, err = rss.New(rxConfig, r.pipeline.ReceiveFrame)
+	if err != nil {
+		log.Fatalf("Failed to start receive workers: %v", err)
+	}
+	defer r.rx.Close()
+	defer r.inside.iface.Close()
+	defer r.outside.iface.Close()
+
+	fmt.Printf("=== NAT Router ===\n\n")
+	fmt.Printf("Inside:  %s (%s)\n", *insideName, insideIP)
+	fmt.Printf("Outside: %s (%s via %s)\n", *outsideName, publicIP, gateway)
+	fmt.Printf("Workers: %d\n\n", r.rx.Workers())
+
+	go r.run(r.inside, readers[0])
+	go r.run(r.outside, readers[1])
+
+	// Print statistics until interrupted
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			printStats(translator, r.rx)
+		case <-sigChan:
+			fmt.Printf("\n")
+			printStats(translator, r.rx)
+			for _, conn := range translator.Connections() {
+				fmt.Printf("  %s\n", conn)
+			}
+			return
+		}
+	}
+}
+
+func mustParseIP(s string) common.IPv4Address {
+	addr, err := common.ParseIPv4(s)
+	if err != nil {
+		log.Fatalf("Invalid IP address %q: %v", s, err)
+	}
+	return addr
+}
+
+// newFirewall builds the forward chain: replies and related ICMP errors are
+// accepted, invalid packets dropped, and only the inside may open connections.
+func newFirewall(inside string) (*filter.Filter, error) {
+	fw := filter.New()
+	if err := fw.SetPolicy(filter.HookForward, filter.ActionDrop); err != nil {
+		return nil, err
+	}
+
+	rules := []*filter.Rule{
+		{States: filter.StateEstablished | filter.StateRelated, Action: filter.ActionAccept, Comment: "replies"},
+		{States: filter.StateInvalid, Action: filter.ActionDrop, Comment: "invalid"},
+		{InInterface: inside, States: filter.StateNew, Action: filter.ActionAccept, Comment: "inside to outside"},
+	}
+	for _, rule := range rules {
+		if err := fw.AppendRule(filter.HookForward.String(), rule); err != nil {
+			return nil, err
+		}
+	}
+	return fw, nil
+}
+
+func openPort(name string, addr common.IPv4Address) *port {
+	iface, err := ethernet.OpenInterface(name)
+	if err != nil {
+		log.Fatalf("Failed to open interface %s: %v", name, err)
+	}
+	handler := arp.NewHandler(iface, addr)
+	handler.SetTimeout(time.Second)
+	return &port{iface: iface, arp: handler}
+}
+
+// setupPipeline connects the stages of the forwarding path and registers
+// the NAT and firewall hooks.
+func (r *router) setupPipeline() error {
+	p := r.pipeline
+	p.SetHandler(hook.EthernetRx, p.DemuxEthernet(r.handleARP))
+	p.SetHandler(hook.IPRx, r.route)
+	p.SetHandler(hook.IPTx, r.transmit)
+
+	hooks := []struct {
+		point    hook.Point
+		priority int
+		name     string
+		fn       hook.Func
+	}{
+		{hook.IPRx, -100, "nat-inbound", r.translateInbound},
+		{hook.IPTx, 0, "firewall", r.filterForward},
+		{hook.IPTx, 100, "nat-outbound", r.translateOutbound},
+	}
+	for _, h := range hooks {
+		if _, err := p.Register(h.point, h.priority, h.name, h.fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run reads frames from one port, a batch per system call, and hands them
+// to the receive workers, which run the pipeline. The reader is pinned to
+// cpu unless it is negative.
+func (r *router) run(p *port, cpu int) {
+	if cpu >= 0 {
+		if err := rss.PinThread(cpu); err != nil {
+			log.Printf("%s reader: %v", p.iface.Name(), err)
+		}
+	}
+	frames := make([]*ethernet.Frame, ethernet.MaxBatchSize)
+	for {
+		n, err := ethernet.ReadFrames(p.iface, frames)
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		for _, frame := range frames[:n] {
+			r.rx.Dispatch(p.iface.Name(), frame)
+		}
+	}
+}
+
+// portFor returns the port for an interface name.
+func (r *router) portFor(name string) *port {
+	if name == r.inside.iface.Name() {
+		return r.inside
+	}
+	return r.outside
+}
+
+// handleARP passes ARP frames to the handler of the receiving port.
+func (r *router) handleARP(pkt *hook.Packet) error {
+	if pkt.Frame.EtherType != common.EtherTypeARP {
+		return nil
+	}
+	packet, err := arp.Parse(pkt.Frame.Payload)
+	if err != nil {
+		return err
+	}
+	return r.portFor(pkt.InInterface).arp.HandlePacket(packet)
+}
+
+// translateInbound rewrites packets from the external network to their
+// internal destination.
+func (r *router) translateInbound(pkt *hook.Packet) hook.Verdict {
+	if pkt.InInterface != r.outside.iface.Name() {
+		return hook.Continue
+	}
+	if err := r.nat.Inbound(pkt.IP); err != nil {
+		if *verbose {
+			log.Printf("Dropped inbound %s: %v", pkt.IP, err)
+		}
+		return hook.Drop
+	}
+	return hook.Continue
+}
+
+// route picks the output port for a received packet and forwards it.
+func (r *router) route(pkt *hook.Packet) error {
+	if pkt.InInterface == r.inside.iface.Name() {
+		// Packets for the router itself and multicast/broadcast are not
+		// forwarded; packets for the public address are hairpinned by the NAT
+		if pkt.IP.Destination == r.insideIP || pkt.IP.Destination[0] >= 224 {
+			return nil
+		}
+		pkt.OutInterface = r.outside.iface.Name()
+	} else {
+		pkt.OutInterface = r.inside.iface.Name()
+	}
+
+	if !pkt.IP.DecrementTTL() {
+		return nil
+	}
+	return r.pipeline.Process(hook.IPTx, pkt)
+}
+
+// filterForward checks forwarded packets against the firewall. Packets are
+// filtered on internal addresses: after inbound translation and before
+// outbound translation.
+func (r *router) filterForward(pkt *hook.Packet) hook.Verdict {
+	check := &filter.Packet{InInterface: pkt.InInterface, OutInterface: pkt.OutInterface, IP: pkt.IP}
+	if v := r.firewall.Check(filter.HookForward, check); v != filter.VerdictAccept {
+		if *verbose {
+			log.Printf("Firewall %s %s", v, pkt.IP)
+		}
+		return hook.Drop
+	}
+	return hook.Continue
+}
+
+// translateOutbound rewrites packets from the internal network to the
+// public address, sending hairpinned packets back inside.
+func (r *router) translateOutbound(pkt *hook.Packet) hook.Verdict {
+	if pkt.InInterface != r.inside.iface.Name() {
+		return hook.Continue
+	}
+	hairpin, err := r.nat.Outbound(pkt.IP)
+	if err != nil {
+		if *verbose {
+			log.Printf("Dropped outbound %s: %v", pkt.IP, err)
+		}
+		return hook.Drop
+	}
+	if hairpin {
+		pkt.OutInterface = r.inside.iface.Name()
+	}
+	return hook.Continue
+}
+
+// transmit sends a forwarded packet on its output port.
+func (r *router) transmit(pkt *hook.Packet) error {
+	if pkt.OutInterface == r.outside.iface.Name() {
+		r.send(r.outside, r.gateway, pkt.IP)
+	} else {
+		r.send(r.inside, pkt.IP.Destination, pkt.IP)
+	}
+	return nil
+}
+
+// send transmits a packet to a next hop on a port. Address resolution runs
+// in its own goroutine when the next hop is not cached, so that the reader
+// for the port can process the ARP reply.
+func (r *router) send(p *port, nextHop common.IPv4Address, pkt *ip.Packet) {
+	data, err := pkt.Serialize()
+	if err != nil {
+		return
+	}
+
+	write := func(mac common.MACAddress) {
+		frame := ethernet.NewFrame(mac, p.iface.MACAddress(), common.EtherTypeIPv4, data)
+		if err := p.iface.WriteFrame(frame); err != nil && *verbose {
+			log.Printf("Failed to send on %s: %v", p.iface.Name(), err)
+		}
+	}
+
+	if mac, found := p.arp.Cache().Get(nextHop); found {
+		write(mac)
+		return
+	}
+
+	go func() {
+		mac, err := p.arp.Resolve(nextHop)
+		if err != nil {
+			if *verbose {
+				log.Printf("Failed to resolve %s: %v", nextHop, err)
+			}
+			return
+		}
+		write(mac)
+	}()
+}
+
+func printStats(n *nat.NAT, rx *rss.Dispatcher) {
+	stats := n.Stats()
+	fmt.Printf("[%s] out=%d in=%d hairpin=%d dropped=%d mappings=%d connections=%d\n",
+		time.Now().Format("15:04:05"), stats.Outbound, stats.Inbound, stats.Hairpinned,
+		stats.Dropped, stats.Mappings, stats.Connections)
+	for i, w := range rx.Stats() {
+		cpu := "any"
+		if w.CPU >= 0 {
+			cpu = fmt.Sprint(w.CPU)
+		}
+		fmt.Printf("  worker %d (CPU %s): processed=%d dropped=%d errors=%d depth=%d\n",
+			i, cpu, w.Processed, w.Dropped, w.Errors, w.Depth)
+	}
+}
@@ -0,0 +1,259 @@
+// SNTP Client Example
+//
+// This example queries an NTP server with pkg/ntp over the custom UDP/IP
+// stack and prints how far the local clock is off, like "ntpdate -q": frames
+// are read from a raw interface, passed through the hook pipeline, and
+// delivered to a udp.Socket wrapped in a udp.PacketConn.
+//
+// Usage:
+//   sudo go run examples/ntpdate/main.go -i eth0 -addr 192.168.1.200 \
+//     -gateway 192.168.1.1 pool.ntp.org
+//
+// The address must not be configured on the host, or the kernel would
+// answer the server's replies with ICMP port unreachable. Host names are
+// resolved with the system resolver. The local clock is not changed.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ntp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	interfaceName = flag.String("i", "eth0", "Network interface name")
+	localAddr     = flag.String("addr", "", "Source IP address for the stack (not configured on the host)")
+	netmask       = flag.String("netmask", "255.255.255.0", "Netmask of the local network")
+	gatewayAddr   = flag.String("gateway", "", "Default gateway")
+	samples       = flag.Int("n", ntp.DefaultSamples, "Number of queries")
+	timeout       = flag.Duration("timeout", ntp.DefaultTimeout, "Time to wait for each reply")
+	verbose       = flag.Bool("v", false, "Print every sample")
+)
+
+// stack is the minimal host stack the client runs on.
+type stack struct {
+	iface    *ethernet.Interface
+	arp      *arp.Handler
+	pipeline *hook.Pipeline
+	addr     common.IPv4Address
+	mask     common.IPv4Address
+	gateway  common.IPv4Address
+	socket   *udp.Socket
+	port     uint16
+}
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() != 1 || *localAddr == "" || *gatewayAddr == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s -i <if> -addr <ip> -gateway <ip> [options] <server>\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	server, err := resolve(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to resolve %s: %v", flag.Arg(0), err)
+	}
+
+	s, err := newStack(*interfaceName, mustParseIP(*localAddr), mustParseIP(*netmask), mustParseIP(*gatewayAddr))
+	if err != nil {
+		log.Fatalf("Failed to start stack: %v", err)
+	}
+	defer s.iface.Close()
+	go s.run()
+
+	conn := udp.NewPacketConn(s.socket, s.send)
+	defer conn.Close()
+
+	client := ntp.NewClientWithConfig(conn, ntp.Config{Samples: *samples, Timeout: *timeout})
+	result, err := client.Measure(udp.Address{IP: server, Port: ntp.Port})
+	if err != nil {
+		log.Fatalf("Query failed: %v", err)
+	}
+
+	if *verbose {
+		for i, sample := range result.Samples {
+			fmt.Printf("sample %d: offset %+.6f, delay %.6f\n", i+1, sample.Offset.Seconds(), sample.Delay.Seconds())
+		}
+	}
+	fmt.Printf("server %s, stratum %d, offset %+.6f, delay %.6f\n",
+		server, result.Stratum, result.Offset.Seconds(), result.Delay.Seconds())
+	fmt.Println(time.Now().Add(result.Offset).Format("2 Jan 15:04:05.000 MST"))
+}
+
+func mustParseIP(s string) common.IPv4Address {
+	addr, err := common.ParseIPv4(s)
+	if err != nil {
+		log.Fatalf("Invalid IP address %q: %v", s, err)
+	}
+	return addr
+}
+
+// resolve looks up the IPv4 address of a host name.
+func resolve(host string) (common.IPv4Address, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return common.IPv4Address{}, err
+	}
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil {
+			return common.IPv4Address{v4[0], v4[1], v4[2], v4[3]}, nil
+		}
+	}
+	return common.IPv4Address{}, fmt.Errorf("no IPv4 address for %s", host)
+}
+
+func newStack(ifname string, addr, mask, gateway common.IPv4Address) (*stack, error) {
+	iface, err := ethernet.OpenInterface(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stack{
+		iface:    iface,
+		arp:      arp.NewHandler(iface, addr),
+		pipeline: hook.NewPipeline(),
+		addr:     addr,
+		mask:     mask,
+		gateway:  gateway,
+		socket:   udp.NewSocket(),
+		port:     uint16(49152 + rand.Intn(16384)),
+	}
+	if err := s.socket.Bind(udp.Address{IP: addr, Port: s.port}); err != nil {
+		iface.Close()
+		return nil, err
+	}
+
+	p := s.pipeline
+	p.SetHandler(hook.EthernetRx, p.DemuxEthernet(s.handleARP))
+	p.SetHandler(hook.IPRx, s.deliver)
+	p.SetHandler(hook.IPTx, s.transmit)
+
+	// Only packets for the stack's own address are received
+	p.Register(hook.IPRx, -100, "local-address", func(pkt *hook.Packet) hook.Verdict {
+		if pkt.IP.Destination != s.addr {
+			return hook.Drop
+		}
+		return hook.Continue
+	})
+	return s, nil
+}
+
+// run reads frames from the interface into the pipeline, a batch per
+// system call.
+func (s *stack) run() {
+	frames := make([]*ethernet.Frame, ethernet.MaxBatchSize)
+	for {
+		n, err := ethernet.ReadFrames(s.iface, frames)
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		for _, frame := range frames[:n] {
+			s.pipeline.ReceiveFrame(s.iface.Name(), frame)
+		}
+	}
+}
+
+// handleARP answers and learns from ARP frames.
+func (s *stack) handleARP(pkt *hook.Packet) error {
+	if pkt.Frame.EtherType != common.EtherTypeARP {
+		return nil
+	}
+	packet, err := arp.Parse(pkt.Frame.Payload)
+	if err != nil {
+		return err
+	}
+	return s.arp.HandlePacket(packet)
+}
+
+// deliver passes a received datagram for the client's port to its socket.
+func (s *stack) deliver(pkt *hook.Packet) error {
+	if pkt.IP.Protocol != common.ProtocolUDP || pkt.IP.IsFragment() {
+		return nil
+	}
+	datagram, err := udp.Parse(pkt.IP.Payload)
+	if err != nil {
+		return err
+	}
+	if datagram.DestinationPort != s.port {
+		return nil
+	}
+	if datagram.Checksum != 0 && !datagram.VerifyChecksum(pkt.IP.Source, pkt.IP.Destination) {
+		return fmt.Errorf("UDP checksum verification failed")
+	}
+	return s.socket.Receive(datagram.Data, udp.Address{IP: pkt.IP.Source, Port: datagram.SourcePort})
+}
+
+// send wraps a datagram from the socket in an IP packet and sends it down
+// the pipeline.
+func (s *stack) send(datagram *udp.Packet, to udp.Address) error {
+	checksum, err := datagram.CalculateChecksum(s.addr, to.IP)
+	if err != nil {
+		return err
+	}
+	datagram.Checksum = checksum
+	data, err := datagram.Serialize()
+	if err != nil {
+		return err
+	}
+	pkt := ip.NewPacket(s.addr, to.IP, common.ProtocolUDP, data)
+	if datagram.TTL != 0 {
+		pkt.TTL = datagram.TTL
+	}
+	pkt.SetTOS(datagram.TOS)
+	return s.pipeline.Process(hook.IPTx, &hook.Packet{IP: pkt})
+}
+
+// transmit sends a packet to its next hop.
+func (s *stack) transmit(pkt *hook.Packet) error {
+	nextHop := pkt.IP.Destination
+	for i := range nextHop {
+		if nextHop[i]&s.mask[i] != s.addr[i]&s.mask[i] {
+			nextHop = s.gateway
+			break
+		}
+	}
+
+	data, err := pkt.IP.Serialize()
+	if err != nil {
+		return err
+	}
+
+	write := func(mac common.MACAddress) {
+		frame := ethernet.NewFrame(mac, s.iface.MACAddress(), common.EtherTypeIPv4, data)
+		if err := s.iface.WriteFrame(frame); err != nil {
+			log.Printf("Failed to send: %v", err)
+		}
+	}
+
+	if mac, found := s.arp.Cache().Get(nextHop); found {
+		write(mac)
+		return nil
+	}
+
+	// Resolve in the background so the reader can process the ARP reply
+	go func() {
+		mac, err := s.arp.Resolve(nextHop)
+		if err != nil {
+			log.Printf("Failed to resolve %s: %v", nextHop, err)
+			return
+		}
+		write(mac)
+	}()
+	return nil
+}
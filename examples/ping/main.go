@@ -2,7 +2,10 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -16,45 +19,37 @@ import (
 	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
 	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
 	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
-)
-
-const (
-	defaultCount    = 4
-	defaultInterval = 1 * time.Second
-	defaultTimeout  = 5 * time.Second
-	defaultDataSize = 56 // Standard ping data size
+	"github.com/therealutkarshpriyadarshi/network/pkg/ping"
 )
 
 var (
-	count    = flag.Int("c", defaultCount, "Number of pings to send")
-	interval = flag.Duration("i", defaultInterval, "Interval between pings")
-	timeout  = flag.Duration("W", defaultTimeout, "Timeout for each ping")
-	dataSize = flag.Int("s", defaultDataSize, "Size of ping data")
+	count    = flag.Int("c", 4, "Number of pings to send to each destination (0 until interrupted)")
+	interval = flag.Duration("i", ping.DefaultInterval, "Interval between pings")
+	timeout  = flag.Duration("W", ping.DefaultTimeout, "Timeout for each ping")
+	dataSize = flag.Int("s", ping.DefaultSize, "Size of ping data")
+	pattern  = flag.String("p", "", "Hex bytes to fill the ping data with (e.g. ff00)")
 )
 
-type pingStats struct {
-	transmitted int
-	received    int
-	minRTT      time.Duration
-	maxRTT      time.Duration
-	totalRTT    time.Duration
-}
-
 func main() {
 	flag.Parse()
 
 	if flag.NArg() < 1 {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] <destination>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <destination>...\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	destination := flag.Arg(0)
-
-	// Parse destination IP
-	dstIP, err := common.ParseIPv4(destination)
+	var destinations []common.IPv4Address
+	for _, arg := range flag.Args() {
+		dstIP, err := common.ParseIPv4(arg)
+		if err != nil {
+			log.Fatalf("Invalid destination IP: %v", err)
+		}
+		destinations = append(destinations, dstIP)
+	}
+	fill, err := hex.DecodeString(*pattern)
 	if err != nil {
-		log.Fatalf("Invalid destination IP: %v", err)
+		log.Fatalf("Invalid pattern: %v", err)
 	}
 
 	// Get local network interface
@@ -63,23 +58,10 @@ func main() {
 		log.Fatalf("Failed to get network interface: %v", err)
 	}
 
-	fmt.Printf("PING %s (%s) %d bytes of data.\n", destination, dstIP, *dataSize)
-
-	// Run ping
-	stats, err := runPing(iface, srcIP, dstIP, *count, *interval, *timeout, *dataSize)
-	if err != nil {
-		log.Fatalf("Ping failed: %v", err)
-	}
-
-	// Print statistics
-	printStats(destination, stats)
-}
-
-func runPing(iface *net.Interface, srcIP, dstIP common.IPv4Address, count int, interval, timeout time.Duration, dataSize int) (*pingStats, error) {
 	// Create raw socket
 	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create socket: %w", err)
+		log.Fatalf("Failed to create socket: %v", err)
 	}
 	defer syscall.Close(fd)
 
@@ -89,80 +71,51 @@ func runPing(iface *net.Interface, srcIP, dstIP common.IPv4Address, count int, i
 		Ifindex:  iface.Index,
 	}
 	if err := syscall.Bind(fd, &addr); err != nil {
-		return nil, fmt.Errorf("failed to bind socket: %w", err)
+		log.Fatalf("Failed to bind socket: %v", err)
 	}
 
-	stats := &pingStats{
-		minRTT: time.Duration(1<<63 - 1), // Max duration
+	pinger, err := ping.New(ping.Config{
+		Send: func(msg *icmp.Message, dst common.IPv4Address) error {
+			return send(fd, iface, srcIP, dst, msg)
+		},
+		ID:       uint16(os.Getpid() & 0xFFFF),
+		Count:    *count,
+		Interval: *interval,
+		Timeout:  *timeout,
+		Size:     *dataSize,
+		Pattern:  fill,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create pinger: %v", err)
 	}
+	go receive(fd, srcIP, pinger)
 
-	// Set up signal handler for graceful exit
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-
-	// Send pings
-	seq := uint16(0)
-	pid := uint16(os.Getpid() & 0xFFFF)
-
-	for i := 0; i < count; i++ {
-		select {
-		case <-sigChan:
-			fmt.Println("\nInterrupted.")
-			return stats, nil
-		default:
-		}
-
-		seq++
-		start := time.Now()
-
-		// Send ICMP echo request
-		err := sendPing(fd, iface, srcIP, dstIP, pid, seq, dataSize)
-		if err != nil {
-			log.Printf("Failed to send ping: %v", err)
-			continue
-		}
-		stats.transmitted++
-
-		// Wait for reply
-		replied, rtt := waitForReply(fd, dstIP, pid, seq, timeout)
-		if replied {
-			stats.received++
-			stats.totalRTT += rtt
-			if rtt < stats.minRTT {
-				stats.minRTT = rtt
-			}
-			if rtt > stats.maxRTT {
-				stats.maxRTT = rtt
-			}
+	for _, dst := range destinations {
+		fmt.Printf("PING %s %d bytes of data.\n", dst, *dataSize)
+	}
 
-			fmt.Printf("%d bytes from %s: icmp_seq=%d ttl=64 time=%.3f ms\n",
-				dataSize+8, dstIP, seq, float64(rtt.Microseconds())/1000.0)
-		} else {
-			fmt.Printf("From %s: icmp_seq=%d Destination Host Unreachable\n", dstIP, seq)
+	// Stop on interrupt, printing the statistics so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := pinger.Run(ctx, destinations...); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("Ping failed: %v", err)
 		}
+	}()
 
-		// Wait for interval (unless last ping)
-		if i < count-1 {
-			elapsed := time.Since(start)
-			if elapsed < interval {
-				time.Sleep(interval - elapsed)
-			}
+	for r := range pinger.Results() {
+		printResult(r)
+	}
+	for _, dst := range destinations {
+		if stats, ok := pinger.Stats(dst); ok {
+			printStats(dst, stats)
 		}
 	}
-
-	return stats, nil
 }
 
-func sendPing(fd int, iface *net.Interface, srcIP, dstIP common.IPv4Address, id, seq uint16, dataSize int) error {
-	// Create ICMP echo request
-	data := make([]byte, dataSize)
-	// Fill with pattern
-	for i := range data {
-		data[i] = byte(i % 256)
-	}
-
-	icmpMsg := icmp.NewEchoRequest(id, seq, data)
-	icmpData, err := icmpMsg.Serialize()
+// send transmits an ICMP message to dst in an Ethernet frame.
+func send(fd int, iface *net.Interface, srcIP, dstIP common.IPv4Address, msg *icmp.Message) error {
+	icmpData, err := msg.Serialize()
 	if err != nil {
 		return fmt.Errorf("failed to serialize ICMP: %w", err)
 	}
@@ -176,99 +129,80 @@ func sendPing(fd int, iface *net.Interface, srcIP, dstIP common.IPv4Address, id,
 
 	// Note: In a real implementation, you would need to resolve the MAC address
 	// using ARP first. For simplicity, we're using broadcast MAC here.
-	dstMAC := common.BroadcastMAC
-
-	// Create Ethernet frame
 	ethFrame := &ethernet.Frame{
-		Destination: dstMAC,
+		Destination: common.BroadcastMAC,
 		Source:      bytesToMAC(iface.HardwareAddr),
 		EtherType:   common.EtherTypeIPv4,
 		Payload:     ipData,
 	}
 
-	frameData := ethFrame.Serialize()
-
-	// Send packet
-	err = syscall.Sendto(fd, frameData, 0, &syscall.SockaddrLinklayer{
+	err = syscall.Sendto(fd, ethFrame.Serialize(), 0, &syscall.SockaddrLinklayer{
 		Protocol: htons(syscall.ETH_P_ALL),
 		Ifindex:  iface.Index,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to send packet: %w", err)
 	}
-
 	return nil
 }
 
-func waitForReply(fd int, expectedSrc common.IPv4Address, expectedID, expectedSeq uint16, timeout time.Duration) (bool, time.Duration) {
-	deadline := time.Now().Add(timeout)
+// receive passes the IPv4 packets addressed to srcIP to the pinger, until
+// the socket is closed.
+func receive(fd int, srcIP common.IPv4Address, pinger *ping.Pinger) {
 	buf := make([]byte, 65535)
-
-	// Set socket timeout
-	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
-	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
-
-	for time.Now().Before(deadline) {
-		start := time.Now()
-
+	for {
 		n, _, err := syscall.Recvfrom(fd, buf, 0)
 		if err != nil {
-			return false, 0
+			if err == syscall.EINTR {
+				continue
+			}
+			return
 		}
 
-		// Parse Ethernet frame
 		frame, err := ethernet.Parse(buf[:n])
-		if err != nil {
+		if err != nil || frame.EtherType != common.EtherTypeIPv4 {
 			continue
 		}
-
-		// Check if IPv4
-		if frame.EtherType != common.EtherTypeIPv4 {
-			continue
-		}
-
-		// Parse IP packet
-		ipPkt, err := ip.Parse(frame.Payload)
-		if err != nil {
-			continue
-		}
-
-		// Check if from expected source and ICMP
-		if ipPkt.Source != expectedSrc || ipPkt.Protocol != common.ProtocolICMP {
-			continue
-		}
-
-		// Parse ICMP message
-		icmpMsg, err := icmp.Parse(ipPkt.Payload)
-		if err != nil {
+		pkt, err := ip.Parse(frame.Payload)
+		if err != nil || pkt.Destination != srcIP {
 			continue
 		}
+		pinger.HandlePacket(pkt)
+	}
+}
 
-		// Check if echo reply with matching ID and sequence
-		if icmpMsg.IsEchoReply() && icmpMsg.ID == expectedID && icmpMsg.Sequence == expectedSeq {
-			rtt := time.Since(start)
-			return true, rtt
+func printResult(r ping.Result) {
+	switch {
+	case errors.Is(r.Err, ping.ErrTimeout):
+		fmt.Printf("Request timeout for %s icmp_seq=%d\n", r.Target, r.Sequence)
+	case r.Err != nil:
+		fmt.Printf("From %s icmp_seq=%d %v\n", r.From, r.Sequence, r.Err)
+	default:
+		dup := ""
+		if r.Duplicate {
+			dup = " (DUP!)"
 		}
+		fmt.Printf("%d bytes from %s: icmp_seq=%d ttl=%d time=%.3f ms%s\n",
+			r.Size, r.From, r.Sequence, r.TTL, milliseconds(r.RTT), dup)
 	}
-
-	return false, 0
 }
 
-func printStats(destination string, stats *pingStats) {
-	fmt.Printf("\n--- %s ping statistics ---\n", destination)
+func printStats(dst common.IPv4Address, stats ping.Stats) {
+	fmt.Printf("\n--- %s ping statistics ---\n", dst)
 	fmt.Printf("%d packets transmitted, %d received, %.1f%% packet loss\n",
-		stats.transmitted, stats.received,
-		float64(stats.transmitted-stats.received)/float64(stats.transmitted)*100.0)
-
-	if stats.received > 0 {
-		avgRTT := stats.totalRTT / time.Duration(stats.received)
-		fmt.Printf("rtt min/avg/max = %.3f/%.3f/%.3f ms\n",
-			float64(stats.minRTT.Microseconds())/1000.0,
-			float64(avgRTT.Microseconds())/1000.0,
-			float64(stats.maxRTT.Microseconds())/1000.0)
+		stats.Transmitted, stats.Received, stats.Loss()*100.0)
+
+	if stats.Received > 0 {
+		fmt.Printf("rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n",
+			milliseconds(stats.MinRTT), milliseconds(stats.AvgRTT),
+			milliseconds(stats.MaxRTT), milliseconds(stats.StdDevRTT))
 	}
 }
 
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
 func getNetworkInterface() (*net.Interface, common.IPv4Address, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
@@ -0,0 +1,252 @@
+// SOCKS5 Proxy Example
+//
+// This example runs a SOCKS5 proxy (RFC 1928, CONNECT without
+// authentication) that bridges kernel networking and the custom TCP/IP
+// stack, so ordinary applications can be routed through the stack to test
+// it against real servers. Clients connect to the proxy through the kernel
+// and their connections are opened with the custom stack: frames are read
+// from a raw interface, passed through the hook pipeline, and delivered to
+// tcp.Sockets by a tcp.Demultiplexer.
+//
+// Usage:
+//   sudo go run ./examples/socks5 -i eth0 -addr 192.168.1.200 \
+//     -gateway 192.168.1.1 -listen 127.0.0.1:1080
+//   curl --socks5-hostname 127.0.0.1:1080 http://example.com/
+//
+// With -stack-port, the proxy also accepts clients on the custom stack's
+// address and opens their connections through the kernel, the other way
+// round:
+//   curl --socks5 192.168.1.200:1080 http://example.com/
+//
+// The address must not be configured on the host: the kernel would answer
+// segments for its own address with resets. Host names are resolved with
+// the system resolver; the custom stack only connects to IPv4 addresses.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+var (
+	interfaceName = flag.String("i", "eth0", "Network interface name")
+	localAddr     = flag.String("addr", "", "IP address for the stack (not configured on the host)")
+	netmask       = flag.String("netmask", "255.255.255.0", "Netmask of the local network")
+	gatewayAddr   = flag.String("gateway", "", "Default gateway")
+	listenAddr    = flag.String("listen", "127.0.0.1:1080", "Kernel address to accept clients on (empty for none)")
+	stackPort     = flag.Int("stack-port", 0, "Port of the stack's address to accept clients on (0 for none)")
+	dialTimeout   = flag.Duration("timeout", 10*time.Second, "Timeout for kernel connections")
+)
+
+// stack is the minimal host stack the proxy runs on.
+type stack struct {
+	iface    *ethernet.Interface
+	arp      *arp.Handler
+	pipeline *hook.Pipeline
+	demux    *tcp.Demultiplexer
+	addr     common.IPv4Address
+	mask     common.IPv4Address
+	gateway  common.IPv4Address
+}
+
+func main() {
+	flag.Parse()
+
+	if *localAddr == "" || *gatewayAddr == "" || (*listenAddr == "" && *stackPort == 0) {
+		fmt.Fprintf(os.Stderr, "Usage: %s -i <if> -addr <ip> -gateway <ip> [options]\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	s, err := newStack(*interfaceName, mustParseIP(*localAddr), mustParseIP(*netmask), mustParseIP(*gatewayAddr))
+	if err != nil {
+		log.Fatalf("Failed to start stack: %v", err)
+	}
+	defer s.iface.Close()
+	go s.run()
+
+	errs := make(chan error, 2)
+	if *listenAddr != "" {
+		ln, err := net.Listen("tcp", *listenAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", *listenAddr, err)
+		}
+		go func() { errs <- serve("kernel->stack", ln, s.dial) }()
+	}
+	if *stackPort != 0 {
+		ln, err := s.listen(uint16(*stackPort))
+		if err != nil {
+			log.Fatalf("Failed to listen on %s:%d: %v", s.addr, *stackPort, err)
+		}
+		go func() { errs <- serve("stack->kernel", ln, dialKernel) }()
+	}
+	log.Fatalf("Proxy stopped: %v", <-errs)
+}
+
+func mustParseIP(s string) common.IPv4Address {
+	addr, err := common.ParseIPv4(s)
+	if err != nil {
+		log.Fatalf("Invalid IP address %q: %v", s, err)
+	}
+	return addr
+}
+
+// resolve looks up the IPv4 address of a host name.
+func resolve(host string) (common.IPv4Address, error) {
+	if addr, err := common.ParseIPv4(host); err == nil {
+		return addr, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return common.IPv4Address{}, err
+	}
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil {
+			return common.IPv4Address{v4[0], v4[1], v4[2], v4[3]}, nil
+		}
+	}
+	return common.IPv4Address{}, &socksError{replyAddressNotSupported, fmt.Errorf("no IPv4 address for %s", host)}
+}
+
+// dialKernel opens a connection through the kernel.
+func dialKernel(host string, port uint16) (net.Conn, error) {
+	return net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))), *dialTimeout)
+}
+
+func newStack(ifname string, addr, mask, gateway common.IPv4Address) (*stack, error) {
+	iface, err := ethernet.OpenInterface(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stack{
+		iface:    iface,
+		arp:      arp.NewHandler(iface, addr),
+		pipeline: hook.NewPipeline(),
+		demux:    tcp.NewDemultiplexer(),
+		addr:     addr,
+		mask:     mask,
+		gateway:  gateway,
+	}
+
+	p := s.pipeline
+	s.demux.SetSendFunc(p.TCPSendFunc())
+	p.SetHandler(hook.EthernetRx, p.DemuxEthernet(s.handleARP))
+	p.SetHandler(hook.IPRx, p.DemuxIP(nil))
+	p.SetHandler(hook.TCPRx, func(pkt *hook.Packet) error {
+		return s.demux.Deliver(pkt.TCP, pkt.Source, pkt.Destination)
+	})
+	p.SetHandler(hook.TCPTx, p.EncapsulateTCP())
+	p.SetHandler(hook.IPTx, s.transmit)
+
+	// Only packets for the stack's own address are received
+	p.Register(hook.IPRx, -100, "local-address", func(pkt *hook.Packet) hook.Verdict {
+		if pkt.IP.Destination != s.addr {
+			return hook.Drop
+		}
+		return hook.Continue
+	})
+	return s, nil
+}
+
+// dial opens a connection through the custom stack.
+func (s *stack) dial(host string, port uint16) (net.Conn, error) {
+	addr, err := resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	sock := tcp.NewSocket(s.addr, 0)
+	if err := s.demux.Connect(sock, addr, port); err != nil {
+		sock.Close()
+		return nil, err
+	}
+	return tcp.NewNetConn(sock), nil
+}
+
+// listen accepts connections on a port of the stack's address.
+func (s *stack) listen(port uint16) (net.Listener, error) {
+	sock := tcp.NewSocket(s.addr, port)
+	if err := s.demux.Listen(sock, 128); err != nil {
+		return nil, err
+	}
+	return tcp.NewListener(sock), nil
+}
+
+// run reads frames from the interface into the pipeline, a batch per
+// system call.
+func (s *stack) run() {
+	frames := make([]*ethernet.Frame, ethernet.MaxBatchSize)
+	for {
+		n, err := ethernet.ReadFrames(s.iface, frames)
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		for _, frame := range frames[:n] {
+			s.pipeline.ReceiveFrame(s.iface.Name(), frame)
+		}
+	}
+}
+
+// handleARP answers and learns from ARP frames.
+func (s *stack) handleARP(pkt *hook.Packet) error {
+	if pkt.Frame.EtherType != common.EtherTypeARP {
+		return nil
+	}
+	packet, err := arp.Parse(pkt.Frame.Payload)
+	if err != nil {
+		return err
+	}
+	return s.arp.HandlePacket(packet)
+}
+
+// transmit sends a packet to its next hop.
+func (s *stack) transmit(pkt *hook.Packet) error {
+	nextHop := pkt.IP.Destination
+	for i := range nextHop {
+		if nextHop[i]&s.mask[i] != s.addr[i]&s.mask[i] {
+			nextHop = s.gateway
+			break
+		}
+	}
+
+	data, err := pkt.IP.Serialize()
+	if err != nil {
+		return err
+	}
+
+	write := func(mac common.MACAddress) {
+		frame := ethernet.NewFrame(mac, s.iface.MACAddress(), common.EtherTypeIPv4, data)
+		if err := s.iface.WriteFrame(frame); err != nil {
+			log.Printf("Failed to send: %v", err)
+		}
+	}
+
+	if mac, found := s.arp.Cache().Get(nextHop); found {
+		write(mac)
+		return nil
+	}
+
+	// Resolve in the background so the reader can process the ARP reply
+	go func() {
+		mac, err := s.arp.Resolve(nextHop)
+		if err != nil {
+			log.Printf("Failed to resolve %s: %v", nextHop, err)
+			return
+		}
+		write(mac)
+	}()
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SOCKS protocol constants (RFC 1928)
+const (
+	socksVersion = 5
+
+	methodNoAuth       = 0x00
+	methodNoAcceptable = 0xFF
+
+	cmdConnect = 1
+
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+
+	replySucceeded           = 0
+	replyGeneralFailure      = 1
+	replyNetworkUnreachable  = 3
+	replyHostUnreachable     = 4
+	replyConnectionRefused   = 5
+	replyCommandNotSupported = 7
+	replyAddressNotSupported = 8
+
+	// handshakeTimeout bounds the negotiation and request, not the relay
+	handshakeTimeout = 30 * time.Second
+)
+
+// dialFunc opens a connection to a host name or address and port.
+type dialFunc func(host string, port uint16) (net.Conn, error)
+
+// socksError is a request the proxy refuses with a reply code.
+type socksError struct {
+	reply byte
+	err   error
+}
+
+func (e *socksError) Error() string { return e.err.Error() }
+
+// serve accepts SOCKS clients on ln and connects them with dial.
+func serve(name string, ln net.Listener, dial dialFunc) error {
+	log.Printf("%s: SOCKS5 proxy listening on %s", name, ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := handle(conn, dial); err != nil {
+				log.Printf("%s: %s: %v", name, conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// handle serves one SOCKS client: it negotiates, connects to the requested
+// destination, and relays between the two until both sides are done.
+func handle(client net.Conn, dial dialFunc) error {
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := negotiate(client); err != nil {
+		return err
+	}
+	host, port, err := readRequest(client)
+	if err != nil {
+		var serr *socksError
+		if errors.As(err, &serr) {
+			writeReply(client, serr.reply, nil)
+		}
+		return err
+	}
+
+	target, err := dial(host, port)
+	if err != nil {
+		writeReply(client, dialReply(err), nil)
+		return fmt.Errorf("failed to connect to %s: %w", net.JoinHostPort(host, strconv.Itoa(int(port))), err)
+	}
+	defer target.Close()
+	if err := writeReply(client, replySucceeded, target.LocalAddr()); err != nil {
+		return err
+	}
+	client.SetDeadline(time.Time{})
+
+	log.Printf("%s <-> %s", client.RemoteAddr(), target.RemoteAddr())
+	relay(client, target)
+	return nil
+}
+
+// negotiate reads the client's methods and selects "no authentication".
+func negotiate(conn net.Conn) error {
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return fmt.Errorf("failed to read greeting: %w", err)
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return fmt.Errorf("failed to read methods: %w", err)
+	}
+	for _, m := range methods {
+		if m == methodNoAuth {
+			_, err := conn.Write([]byte{socksVersion, methodNoAuth})
+			return err
+		}
+	}
+	conn.Write([]byte{socksVersion, methodNoAcceptable})
+	return fmt.Errorf("no acceptable authentication method")
+}
+
+// readRequest reads a CONNECT request and returns its destination.
+func readRequest(conn net.Conn) (string, uint16, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return "", 0, fmt.Errorf("failed to read request: %w", err)
+	}
+	if header[0] != socksVersion {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	var host string
+	switch header[3] {
+	case atypIPv4:
+		var addr [4]byte
+		if _, err := io.ReadFull(conn, addr[:]); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(addr[:]).String()
+	case atypIPv6:
+		var addr [16]byte
+		if _, err := io.ReadFull(conn, addr[:]); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(addr[:]).String()
+	case atypDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	default:
+		return "", 0, &socksError{replyAddressNotSupported, fmt.Errorf("unsupported address type %d", header[3])}
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", 0, err
+	}
+	if header[1] != cmdConnect {
+		return "", 0, &socksError{replyCommandNotSupported, fmt.Errorf("unsupported command %d", header[1])}
+	}
+	return host, binary.BigEndian.Uint16(port[:]), nil
+}
+
+// writeReply sends a reply with the address the proxy connected from, or
+// the zero address.
+func writeReply(conn net.Conn, reply byte, bound net.Addr) error {
+	msg := []byte{socksVersion, reply, 0, atypIPv4, 0, 0, 0, 0, 0, 0}
+	if host, port, err := net.SplitHostPort(addrString(bound)); err == nil {
+		if ip := net.ParseIP(host).To4(); ip != nil {
+			copy(msg[4:8], ip)
+		}
+		if p, err := strconv.Atoi(port); err == nil {
+			binary.BigEndian.PutUint16(msg[8:], uint16(p))
+		}
+	}
+	_, err := conn.Write(msg)
+	return err
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// dialReply returns the reply code for a failed connection.
+func dialReply(err error) byte {
+	var serr *socksError
+	var nerr net.Error
+	switch {
+	case errors.As(err, &serr):
+		return serr.reply
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return replyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return replyNetworkUnreachable
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return replyHostUnreachable
+	default:
+		return replyGeneralFailure
+	}
+}
+
+// relay copies between a and b in both directions. When one direction ends
+// it is shut down on the other connection if that supports half-close;
+// otherwise both connections are closed.
+func relay(a, b net.Conn) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+			return
+		}
+		a.Close()
+		b.Close()
+	}
+	wg.Add(2)
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+}
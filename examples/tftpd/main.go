@@ -0,0 +1,268 @@
+// TFTP Server Example
+//
+// This example serves the files of a directory with pkg/tftp over the custom
+// UDP/IP stack: frames are read from a raw interface, passed through the
+// hook pipeline, and delivered through a udp.Demultiplexer to the server's
+// port and to the port each transfer opens.
+//
+// Usage:
+//   sudo go run examples/tftpd/main.go -i eth0 -addr 192.168.1.200 \
+//     -gateway 192.168.1.1 -root /srv/tftp
+//
+// Then, from another host:
+//   tftp 192.168.1.200 -c get boot.img
+//
+// The address must not be configured on the host, or the kernel would
+// answer clients with ICMP port unreachable. Files are only written with
+// -write, and never outside the root directory.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tftp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	interfaceName = flag.String("i", "eth0", "Network interface name")
+	localAddr     = flag.String("addr", "", "IP address for the stack (not configured on the host)")
+	netmask       = flag.String("netmask", "255.255.255.0", "Netmask of the local network")
+	gatewayAddr   = flag.String("gateway", "", "Default gateway")
+	rootDir       = flag.String("root", ".", "Directory to serve")
+	allowWrite    = flag.Bool("write", false, "Allow clients to write files")
+	maxBlockSize  = flag.Int("blksize", tftp.MaxBlockSize, "Largest block size to grant")
+)
+
+// stack is the minimal host stack the server runs on.
+type stack struct {
+	iface    *ethernet.Interface
+	arp      *arp.Handler
+	pipeline *hook.Pipeline
+	addr     common.IPv4Address
+	mask     common.IPv4Address
+	gateway  common.IPv4Address
+	demux    *udp.Demultiplexer
+}
+
+func main() {
+	flag.Parse()
+
+	if *localAddr == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s -i <if> -addr <ip> [options]\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	root, err := os.OpenRoot(*rootDir)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", *rootDir, err)
+	}
+	defer root.Close()
+
+	gateway := common.IPv4Address{}
+	if *gatewayAddr != "" {
+		gateway = mustParseIP(*gatewayAddr)
+	}
+	s, err := newStack(*interfaceName, mustParseIP(*localAddr), mustParseIP(*netmask), gateway)
+	if err != nil {
+		log.Fatalf("Failed to start stack: %v", err)
+	}
+	defer s.iface.Close()
+	go s.run()
+
+	config := tftp.ServerConfig{
+		Listen: tftp.StackListener(s.demux, s.addr, s.send),
+		ReadFile: func(name string) (io.ReadCloser, error) {
+			return root.Open(clean(name))
+		},
+		MaxBlockSize: *maxBlockSize,
+		OnTransfer: func(tr tftp.Transfer) {
+			if tr.Err != nil {
+				log.Printf("%s %s from %s: %v", tr.Opcode, tr.Filename, tr.Peer, tr.Err)
+				return
+			}
+			log.Printf("%s %s from %s: %d bytes, blksize %d", tr.Opcode, tr.Filename, tr.Peer, tr.Bytes, tr.BlockSize)
+		},
+	}
+	if *allowWrite {
+		config.WriteFile = func(name string) (io.WriteCloser, error) {
+			// Existing files are not overwritten
+			return root.OpenFile(clean(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		}
+	}
+
+	server, err := tftp.NewServer(config)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+	conn, err := config.Listen(tftp.Port)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	log.Printf("Serving %s on %s:%d", *rootDir, s.addr, tftp.Port)
+	if err := server.Serve(conn); err != nil {
+		log.Fatalf("Serve failed: %v", err)
+	}
+}
+
+// clean turns a requested file name into a path relative to the root.
+// Clients often ask for absolute paths; the root refuses any that still
+// escape it.
+func clean(name string) string {
+	return strings.TrimPrefix(filepath.Clean("/"+name), "/")
+}
+
+func mustParseIP(s string) common.IPv4Address {
+	addr, err := common.ParseIPv4(s)
+	if err != nil {
+		log.Fatalf("Invalid IP address %q: %v", s, err)
+	}
+	return addr
+}
+
+func newStack(ifname string, addr, mask, gateway common.IPv4Address) (*stack, error) {
+	iface, err := ethernet.OpenInterface(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stack{
+		iface:    iface,
+		arp:      arp.NewHandler(iface, addr),
+		pipeline: hook.NewPipeline(),
+		addr:     addr,
+		mask:     mask,
+		gateway:  gateway,
+		demux:    udp.NewDemultiplexer(),
+	}
+
+	p := s.pipeline
+	p.SetHandler(hook.EthernetRx, p.DemuxEthernet(s.handleARP))
+	p.SetHandler(hook.IPRx, s.deliver)
+	p.SetHandler(hook.IPTx, s.transmit)
+
+	// Only packets for the stack's own address are received
+	p.Register(hook.IPRx, -100, "local-address", func(pkt *hook.Packet) hook.Verdict {
+		if pkt.IP.Destination != s.addr {
+			return hook.Drop
+		}
+		return hook.Continue
+	})
+	return s, nil
+}
+
+// run reads frames from the interface into the pipeline, a batch per
+// system call.
+func (s *stack) run() {
+	frames := make([]*ethernet.Frame, ethernet.MaxBatchSize)
+	for {
+		n, err := ethernet.ReadFrames(s.iface, frames)
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		for _, frame := range frames[:n] {
+			s.pipeline.ReceiveFrame(s.iface.Name(), frame)
+		}
+	}
+}
+
+// handleARP answers and learns from ARP frames.
+func (s *stack) handleARP(pkt *hook.Packet) error {
+	if pkt.Frame.EtherType != common.EtherTypeARP {
+		return nil
+	}
+	packet, err := arp.Parse(pkt.Frame.Payload)
+	if err != nil {
+		return err
+	}
+	return s.arp.HandlePacket(packet)
+}
+
+// deliver passes a received datagram to the socket bound to its port.
+func (s *stack) deliver(pkt *hook.Packet) error {
+	if pkt.IP.Protocol != common.ProtocolUDP || pkt.IP.IsFragment() {
+		return nil
+	}
+	datagram, err := udp.Parse(pkt.IP.Payload)
+	if err != nil {
+		return err
+	}
+	if datagram.Checksum != 0 && !datagram.VerifyChecksum(pkt.IP.Source, pkt.IP.Destination) {
+		return fmt.Errorf("UDP checksum verification failed")
+	}
+	return s.demux.Deliver(datagram, udp.Address{IP: pkt.IP.Source, Port: datagram.SourcePort})
+}
+
+// send wraps a datagram from a socket in an IP packet and sends it down the
+// pipeline.
+func (s *stack) send(datagram *udp.Packet, to udp.Address) error {
+	checksum, err := datagram.CalculateChecksum(s.addr, to.IP)
+	if err != nil {
+		return err
+	}
+	datagram.Checksum = checksum
+	data, err := datagram.Serialize()
+	if err != nil {
+		return err
+	}
+	pkt := ip.NewPacket(s.addr, to.IP, common.ProtocolUDP, data)
+	if datagram.TTL != 0 {
+		pkt.TTL = datagram.TTL
+	}
+	pkt.SetTOS(datagram.TOS)
+	return s.pipeline.Process(hook.IPTx, &hook.Packet{IP: pkt})
+}
+
+// transmit sends a packet to its next hop.
+func (s *stack) transmit(pkt *hook.Packet) error {
+	nextHop := pkt.IP.Destination
+	for i := range nextHop {
+		if nextHop[i]&s.mask[i] != s.addr[i]&s.mask[i] {
+			nextHop = s.gateway
+			break
+		}
+	}
+
+	data, err := pkt.IP.Serialize()
+	if err != nil {
+		return err
+	}
+
+	write := func(mac common.MACAddress) {
+		frame := ethernet.NewFrame(mac, s.iface.MACAddress(), common.EtherTypeIPv4, data)
+		if err := s.iface.WriteFrame(frame); err != nil {
+			log.Printf("Failed to send: %v", err)
+		}
+	}
+
+	if mac, found := s.arp.Cache().Get(nextHop); found {
+		write(mac)
+		return nil
+	}
+
+	// Resolve in the background so the reader can process the ARP reply
+	go func() {
+		mac, err := s.arp.Resolve(nextHop)
+		if err != nil {
+			log.Printf("Failed to resolve %s: %v", nextHop, err)
+			return
+		}
+		write(mac)
+	}()
+	return nil
+}
@@ -3,6 +3,7 @@ module github.com/therealutkarshpriyadarshi/network
 go 1.24.7
 
 require (
+	github.com/google/gopacket v1.1.19
 	golang.org/x/net v0.47.0 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 )
@@ -0,0 +1,169 @@
+// Package fuzzseed provides seed corpora for the parser fuzz targets.
+//
+// The seeds come from testdata/seed.pcap, a capture of well-formed frames
+// for every protocol the stack parses. Layers are located by their raw
+// offsets rather than with the protocol packages, so that the fuzz tests of
+// those packages can use this package without an import cycle.
+//
+// To regenerate the capture, run go generate in this directory.
+package fuzzseed
+
+//go:generate go run gen.go
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/pcap"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86DD
+	etherTypeVLAN = 0x8100
+
+	protocolUDP = 17
+
+	ethernetHeaderLength = 14
+	ipv6HeaderLength     = 40
+	udpHeaderLength      = 8
+)
+
+// Frames returns the Ethernet frames of the seed capture.
+func Frames(tb testing.TB) [][]byte {
+	tb.Helper()
+
+	_, file, _, _ := runtime.Caller(0)
+	f, err := os.Open(filepath.Join(filepath.Dir(file), "testdata", "seed.pcap"))
+	if err != nil {
+		tb.Fatalf("failed to open seed capture: %v", err)
+	}
+	defer f.Close()
+
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		tb.Fatalf("failed to read seed capture: %v", err)
+	}
+	var frames [][]byte
+	for {
+		pkt, err := r.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			return frames
+		}
+		if err != nil {
+			tb.Fatalf("failed to read seed capture: %v", err)
+		}
+		frames = append(frames, pkt.Data)
+	}
+}
+
+// EtherPayloads returns the payloads of the seed frames of the given
+// EtherType, after any VLAN tag.
+func EtherPayloads(tb testing.TB, etherType uint16) [][]byte {
+	tb.Helper()
+
+	var payloads [][]byte
+	for _, frame := range Frames(tb) {
+		if t, payload, ok := etherPayload(frame); ok && t == etherType {
+			payloads = append(payloads, payload)
+		}
+	}
+	return payloads
+}
+
+// IPv4Payloads returns the payloads of the seed IPv4 packets of the given
+// protocol. Fragments after the first are left out.
+func IPv4Payloads(tb testing.TB, protocol uint8) [][]byte {
+	tb.Helper()
+
+	var payloads [][]byte
+	for _, pkt := range EtherPayloads(tb, etherTypeIPv4) {
+		if len(pkt) < 20 || pkt[9] != protocol || binary.BigEndian.Uint16(pkt[6:8])&0x1fff != 0 {
+			continue
+		}
+		headerLength := int(pkt[0]&0x0f) * 4
+		totalLength := int(binary.BigEndian.Uint16(pkt[2:4]))
+		if headerLength < 20 || totalLength < headerLength || totalLength > len(pkt) {
+			continue
+		}
+		payloads = append(payloads, pkt[headerLength:totalLength])
+	}
+	return payloads
+}
+
+// IPv6Payloads returns the payloads of the seed IPv6 packets whose fixed
+// header's Next Header is nextHeader.
+func IPv6Payloads(tb testing.TB, nextHeader uint8) [][]byte {
+	tb.Helper()
+
+	var payloads [][]byte
+	for _, pkt := range EtherPayloads(tb, etherTypeIPv6) {
+		if len(pkt) < ipv6HeaderLength || pkt[6] != nextHeader {
+			continue
+		}
+		end := ipv6HeaderLength + int(binary.BigEndian.Uint16(pkt[4:6]))
+		if end > len(pkt) {
+			continue
+		}
+		payloads = append(payloads, pkt[ipv6HeaderLength:end])
+	}
+	return payloads
+}
+
+// UDPPayloads returns the payloads of the seed UDP datagrams, over IPv4 or
+// IPv6, to or from port.
+func UDPPayloads(tb testing.TB, port uint16) [][]byte {
+	tb.Helper()
+
+	var payloads [][]byte
+	datagrams := append(IPv4Payloads(tb, protocolUDP), IPv6Payloads(tb, protocolUDP)...)
+	for _, d := range datagrams {
+		if len(d) < udpHeaderLength {
+			continue
+		}
+		if binary.BigEndian.Uint16(d[0:2]) != port && binary.BigEndian.Uint16(d[2:4]) != port {
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(d[4:6]))
+		if length < udpHeaderLength || length > len(d) {
+			continue
+		}
+		payloads = append(payloads, d[udpHeaderLength:length])
+	}
+	return payloads
+}
+
+// Add adds each seed to the corpus of f.
+func Add(f *testing.F, seeds [][]byte) {
+	f.Helper()
+
+	if len(seeds) == 0 {
+		f.Fatal("no seeds")
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+}
+
+// etherPayload returns the EtherType and payload of an Ethernet frame,
+// skipping a VLAN tag.
+func etherPayload(frame []byte) (uint16, []byte, bool) {
+	if len(frame) < ethernetHeaderLength {
+		return 0, nil, false
+	}
+	etherType := binary.BigEndian.Uint16(frame[12:14])
+	payload := frame[ethernetHeaderLength:]
+	if etherType == etherTypeVLAN {
+		if len(payload) < 4 {
+			return 0, nil, false
+		}
+		etherType = binary.BigEndian.Uint16(payload[2:4])
+		payload = payload[4:]
+	}
+	return etherType, payload, true
+}
@@ -0,0 +1,130 @@
+//go:build ignore
+
+// gen writes testdata/seed.pcap, the seed capture of the parser fuzz
+// targets: one well-formed frame of each kind the stack parses.
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ipv6"
+	"github.com/therealutkarshpriyadarshi/network/pkg/pcap"
+	"github.com/therealutkarshpriyadarshi/network/pkg/quic"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	clientMAC = common.MACAddress{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	serverMAC = common.MACAddress{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	client    = common.IPv4Address{192, 168, 1, 1}
+	server    = common.IPv4Address{192, 168, 1, 2}
+	client6   = common.IPv6Address{0xfe, 0x80, 15: 1}
+	server6   = common.IPv6Address{0xfe, 0x80, 15: 2}
+)
+
+func main() {
+	f, err := os.Create("testdata/seed.pcap")
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := pcap.NewWriter(f, pcap.LinkTypeEthernet)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ts := time.Unix(1700000000, 0)
+	for _, frame := range frames() {
+		if err := w.WritePacket(ts, frame.Serialize()); err != nil {
+			log.Fatal(err)
+		}
+		ts = ts.Add(time.Millisecond)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func frames() []*ethernet.Frame {
+	frames := []*ethernet.Frame{
+		ethernet.NewFrame(common.BroadcastMAC, clientMAC, common.EtherTypeARP,
+			arp.NewRequest(clientMAC, client, server).Serialize()),
+		ethernet.NewFrame(clientMAC, serverMAC, common.EtherTypeARP,
+			arp.NewReply(serverMAC, server, clientMAC, client).Serialize()),
+	}
+	ipv4 := func(pkt *ip.Packet) {
+		frames = append(frames, ethernet.NewFrame(serverMAC, clientMAC, common.EtherTypeIPv4, must(pkt.Serialize())))
+	}
+
+	// UDP, with a checksum
+	datagram := udp.NewPacket(40000, 9, []byte("hello"))
+	datagram.Checksum = must(datagram.CalculateChecksum(client, server))
+	ipv4(ip.NewPacket(client, server, common.ProtocolUDP, must(datagram.Serialize())))
+
+	// A TCP SYN with the usual options, and a data segment
+	syn := tcp.NewSegment(40001, 80, 1000, 0, tcp.FlagSYN, 65535, nil)
+	syn.Options = append(append(append(append(tcp.BuildMSSOption(1460),
+		tcp.BuildSACKPermittedOption()...),
+		tcp.BuildTimestampOption(1, 0)...),
+		tcp.OptionKindNOP),
+		tcp.BuildWindowScaleOption(7)...)
+	syn.Checksum = must(syn.CalculateChecksum(client, server))
+	ipv4(ip.NewPacket(client, server, common.ProtocolTCP, must(syn.Serialize())))
+
+	ack := tcp.NewSegment(80, 40001, 5000, 1001, tcp.FlagACK|tcp.FlagPSH, 502, []byte("HTTP/1.1 200 OK\r\n\r\n"))
+	ack.Options = append(tcp.BuildSACKOption([]tcp.SACKBlock{{LeftEdge: 2000, RightEdge: 3000}}), tcp.OptionKindNOP, tcp.OptionKindNOP)
+	ack.Checksum = must(ack.CalculateChecksum(server, client))
+	ipv4(ip.NewPacket(server, client, common.ProtocolTCP, must(ack.Serialize())))
+
+	// ICMP echo, and an unreachable quoting the UDP datagram
+	ipv4(ip.NewPacket(client, server, common.ProtocolICMP, must(icmp.NewEchoRequest(1, 1, []byte("ping")).Serialize())))
+	quoted := must(ip.NewPacket(client, server, common.ProtocolUDP, must(datagram.Serialize())).Serialize())
+	ipv4(ip.NewPacket(server, client, common.ProtocolICMP, must(icmp.NewDestinationUnreachable(icmp.CodePortUnreachable, quoted).Serialize())))
+
+	// IPv4 with options, and a first fragment
+	withOptions := ip.NewPacket(client, server, common.ProtocolUDP, must(datagram.Serialize()))
+	withOptions.Options = []byte{7, 7, 4, 0, 0, 0, 0} // Record Route
+	ipv4(withOptions)
+
+	fragment := ip.NewPacket(client, server, common.ProtocolUDP, make([]byte, 64))
+	fragment.Flags = ip.FlagMoreFragments
+	ipv4(fragment)
+
+	// QUIC Initial on UDP 443, without packet protection
+	payload := must((&quic.CryptoFrame{Data: []byte{1, 0, 0, 4, 3, 3, 0, 0}}).Serialize())
+	payload = append(payload, must((&quic.PingFrame{}).Serialize())...)
+	payload = append(payload, make([]byte, 16)...)
+	initial := quic.NewInitialPacket([]byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{9, 10, 11, 12}, nil, payload)
+	initial.PacketNumber = 1
+	initial.PacketNumLen = 1
+	quicDatagram := udp.NewPacket(40002, 443, must(initial.Serialize()))
+	quicDatagram.Checksum = must(quicDatagram.CalculateChecksum(client, server))
+	ipv4(ip.NewPacket(client, server, common.ProtocolUDP, must(quicDatagram.Serialize())))
+
+	// UDP over a VLAN
+	tagged := ethernet.NewFrame(serverMAC, clientMAC, common.EtherTypeIPv4,
+		must(ip.NewPacket(client, server, common.ProtocolUDP, must(datagram.Serialize())).Serialize()))
+	tagged.VLAN = []ethernet.VLANTag{{TPID: common.EtherTypeVLAN, VID: 100}}
+	frames = append(frames, tagged)
+
+	// UDP over IPv6
+	datagram6 := udp.NewPacket(40003, 9, []byte("hello"))
+	frames = append(frames, ethernet.NewFrame(serverMAC, clientMAC, common.EtherTypeIPv6,
+		must(ipv6.NewPacket(client6, server6, common.ProtocolUDP, must(datagram6.Serialize())).Serialize())))
+
+	return frames
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		log.Fatal(err)
+	}
+	return v
+}
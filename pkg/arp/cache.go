@@ -2,10 +2,13 @@ package arp
 
 import (
 	"fmt"
+	"math/bits"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"golang.org/x/sys/cpu"
 )
 
 // DefaultCacheTimeout is the default time after which an ARP cache entry expires.
@@ -23,20 +26,54 @@ func (e *CacheEntry) IsExpired() bool {
 	return time.Now().After(e.ExpiresAt)
 }
 
+// cacheShards is the number of shards of a Cache, a power of two.
+const cacheShards = 64
+
 // Cache implements a thread-safe ARP cache that maps IP addresses to MAC addresses.
 // Entries automatically expire after a configured timeout.
+// Updates changing the MAC address of an entry can be detected and
+// rejected with SetPinning.
+//
+// Lookups take no lock, so that consulting the cache for every transmitted
+// packet scales with the number of cores. The entries are spread over
+// shards by address; each shard publishes its table through an atomic
+// pointer and replaces it, read-copy-update style, when an address is
+// added or removed. Refreshing an existing entry swaps the entry alone.
 type Cache struct {
-	mu      sync.RWMutex
-	entries map[common.IPv4Address]*CacheEntry
+	shards  [cacheShards]cacheShard
 	timeout time.Duration
+
+	// Spoofing detection, replaced as a whole by the setters
+	configMu sync.Mutex
+	config   atomic.Pointer[pinningConfig]
+}
+
+// cacheShard is a part of the cache. Writers hold mu; readers load table.
+type cacheShard struct {
+	mu      sync.Mutex
+	table   atomic.Pointer[cacheTable]
+	pending map[common.IPv4Address]*pendingChange // Guarded by mu
+	_       cpu.CacheLinePad                      // Keeps shards off each other's cache lines
+}
+
+// cacheTable maps addresses to the slots holding their entries. A
+// published table is never modified: writers copy it to add or remove an
+// address.
+type cacheTable map[common.IPv4Address]*cacheSlot
+
+// cacheSlot holds the current entry of an address.
+type cacheSlot struct {
+	entry atomic.Pointer[CacheEntry]
 }
 
 // NewCache creates a new ARP cache with the specified timeout.
 func NewCache(timeout time.Duration) *Cache {
-	return &Cache{
-		entries: make(map[common.IPv4Address]*CacheEntry),
-		timeout: timeout,
+	c := &Cache{timeout: timeout}
+	for i := range c.shards {
+		c.shards[i].table.Store(&cacheTable{})
 	}
+	c.config.Store(&pinningConfig{probeTimeout: DefaultProbeTimeout})
+	return c
 }
 
 // NewDefaultCache creates a new ARP cache with the default timeout.
@@ -44,109 +81,170 @@ func NewDefaultCache() *Cache {
 	return NewCache(DefaultCacheTimeout)
 }
 
-// Add adds or updates an entry in the ARP cache.
+// shard returns the shard of an address.
+func (c *Cache) shard(ip common.IPv4Address) *cacheShard {
+	// Fibonacci hashing spreads the addresses of a subnet, which differ in
+	// their last bits
+	h := ip.ToUint32() * 0x9e3779b1
+	return &c.shards[h>>(32-bits.TrailingZeros(cacheShards))]
+}
+
+// lookup returns the entry of an address, or nil.
+func (s *cacheShard) lookup(ip common.IPv4Address) *CacheEntry {
+	if slot := (*s.table.Load())[ip]; slot != nil {
+		return slot.entry.Load()
+	}
+	return nil
+}
+
+// store sets the entry of an address. Called with s.mu held.
+func (s *cacheShard) store(ip common.IPv4Address, entry *CacheEntry) {
+	table := *s.table.Load()
+	if slot := table[ip]; slot != nil {
+		slot.entry.Store(entry)
+		return
+	}
+	next := make(cacheTable, len(table)+1)
+	for addr, slot := range table {
+		next[addr] = slot
+	}
+	slot := &cacheSlot{}
+	slot.entry.Store(entry)
+	next[ip] = slot
+	s.table.Store(&next)
+}
+
+// remove removes the entries of addresses for which drop returns true, and
+// returns how many it removed. Called with s.mu held.
+func (s *cacheShard) remove(drop func(common.IPv4Address, *CacheEntry) bool) int {
+	table := *s.table.Load()
+	next := make(cacheTable, len(table))
+	for addr, slot := range table {
+		if !drop(addr, slot.entry.Load()) {
+			next[addr] = slot
+		}
+	}
+	removed := len(table) - len(next)
+	if removed > 0 {
+		s.table.Store(&next)
+	}
+	return removed
+}
+
+// Add adds or updates an entry in the ARP cache. An update changing the
+// MAC address of a valid entry is subject to the pinning mode.
 func (c *Cache) Add(ip common.IPv4Address, mac common.MACAddress) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	config := c.config.Load()
+	s := c.shard(ip)
+	s.mu.Lock()
+	var oldMAC common.MACAddress
+	if entry := s.lookup(ip); entry != nil {
+		oldMAC = entry.MAC
+	}
+	change, probe := c.update(s, config, ip, mac)
+	s.mu.Unlock()
 
-	c.entries[ip] = &CacheEntry{
-		MAC:       mac,
-		ExpiresAt: time.Now().Add(c.timeout),
+	if change != nil && config.onMACChange != nil {
+		config.onMACChange(*change)
+	}
+	if probe {
+		config.probe(ip, oldMAC)
 	}
 }
 
 // Get retrieves a MAC address for the given IP address.
 // Returns the MAC address and true if found and not expired, or zero MAC and false otherwise.
 func (c *Cache) Get(ip common.IPv4Address) (common.MACAddress, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	entry, exists := c.entries[ip]
-	if !exists {
-		return common.MACAddress{}, false
-	}
-
-	// Check if entry has expired
-	if entry.IsExpired() {
+	entry := c.shard(ip).lookup(ip)
+	if entry == nil || entry.IsExpired() {
 		return common.MACAddress{}, false
 	}
-
 	return entry.MAC, true
 }
 
 // Delete removes an entry from the ARP cache.
 func (c *Cache) Delete(ip common.IPv4Address) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	s := c.shard(ip)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	delete(c.entries, ip)
+	if s.lookup(ip) != nil {
+		s.remove(func(addr common.IPv4Address, _ *CacheEntry) bool { return addr == ip })
+	}
+	s.cancelProbe(ip)
 }
 
 // Clear removes all entries from the ARP cache.
 func (c *Cache) Clear() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	c.entries = make(map[common.IPv4Address]*CacheEntry)
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		s.table.Store(&cacheTable{})
+		for ip := range s.pending {
+			s.cancelProbe(ip)
+		}
+		s.mu.Unlock()
+	}
 }
 
 // Cleanup removes all expired entries from the cache.
 // This should be called periodically to prevent the cache from growing indefinitely.
 func (c *Cache) Cleanup() int {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	removed := 0
-	for ip, entry := range c.entries {
-		if entry.IsExpired() {
-			delete(c.entries, ip)
-			removed++
-		}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		removed += s.remove(func(_ common.IPv4Address, entry *CacheEntry) bool { return entry.IsExpired() })
+		s.mu.Unlock()
 	}
-
 	return removed
 }
 
 // Size returns the number of entries currently in the cache (including expired ones).
 func (c *Cache) Size() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	size := 0
+	for i := range c.shards {
+		size += len(*c.shards[i].table.Load())
+	}
+	return size
+}
 
-	return len(c.entries)
+// each calls fn with every entry in the cache. The shards are read one
+// after the other, so concurrent updates may or may not be seen.
+func (c *Cache) each(fn func(common.IPv4Address, *CacheEntry)) {
+	for i := range c.shards {
+		for ip, slot := range *c.shards[i].table.Load() {
+			fn(ip, slot.entry.Load())
+		}
+	}
 }
 
 // Entries returns a snapshot of all non-expired entries in the cache.
 // The returned map is a copy and can be safely modified by the caller.
 func (c *Cache) Entries() map[common.IPv4Address]common.MACAddress {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
 	snapshot := make(map[common.IPv4Address]common.MACAddress)
-	for ip, entry := range c.entries {
+	c.each(func(ip common.IPv4Address, entry *CacheEntry) {
 		if !entry.IsExpired() {
 			snapshot[ip] = entry.MAC
 		}
-	}
-
+	})
 	return snapshot
 }
 
 // String returns a human-readable representation of the cache.
 func (c *Cache) String() string {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	result := fmt.Sprintf("ARP Cache (%d entries):\n", len(c.entries))
-	for ip, entry := range c.entries {
+	var lines string
+	n := 0
+	c.each(func(ip common.IPv4Address, entry *CacheEntry) {
 		status := "valid"
 		if entry.IsExpired() {
 			status = "expired"
 		}
 		ttl := time.Until(entry.ExpiresAt)
-		result += fmt.Sprintf("  %s -> %s (%s, TTL: %v)\n", ip, entry.MAC, status, ttl)
-	}
-
-	return result
+		lines += fmt.Sprintf("  %s -> %s (%s, TTL: %v)\n", ip, entry.MAC.StringWithVendor(), status, ttl)
+		n++
+	})
+	return fmt.Sprintf("ARP Cache (%d entries):\n", n) + lines
 }
 
 // StartCleanupRoutine starts a goroutine that periodically cleans up expired entries.
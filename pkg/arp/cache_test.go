@@ -1,6 +1,7 @@
 package arp
 
 import (
+	"sync"
 	"testing"
 	"time"
 
@@ -357,3 +358,144 @@ func TestStartCleanupRoutine(t *testing.T) {
 	// Note: We can't guarantee exact timing, but size should be small or zero
 	time.Sleep(50 * time.Millisecond) // Give cleanup time to finish
 }
+
+func TestCacheShards(t *testing.T) {
+	cache := NewCache(time.Minute)
+
+	// A /24 spreads over many shards
+	used := make(map[*cacheShard]bool)
+	for i := 0; i < 256; i++ {
+		ip := common.IPv4Address{10, 0, 0, byte(i)}
+		cache.Add(ip, common.MACAddress{0x02, 0, 0, 0, 0, byte(i)})
+		used[cache.shard(ip)] = true
+	}
+	if len(used) < cacheShards/2 {
+		t.Errorf("256 addresses use %d of %d shards", len(used), cacheShards)
+	}
+	if got := cache.Size(); got != 256 {
+		t.Fatalf("Size() = %d, want 256", got)
+	}
+
+	// Refreshing an entry keeps the shard's table
+	ip := common.IPv4Address{10, 0, 0, 7}
+	table := cache.shard(ip).table.Load()
+	cache.Add(ip, common.MACAddress{0x02, 0, 0, 0, 0, 0xff})
+	if cache.shard(ip).table.Load() != table {
+		t.Error("Add() of an existing address replaced the table")
+	}
+	if mac, _ := cache.Get(ip); mac != (common.MACAddress{0x02, 0, 0, 0, 0, 0xff}) {
+		t.Errorf("Get() after refresh = %s", mac)
+	}
+
+	cache.Delete(ip)
+	if _, found := cache.Get(ip); found || cache.Size() != 255 {
+		t.Errorf("after Delete() Get() found = %v, Size() = %d, want false, 255", found, cache.Size())
+	}
+	if len(cache.Entries()) != 255 {
+		t.Errorf("Entries() = %d entries, want 255", len(cache.Entries()))
+	}
+}
+
+func TestCacheConcurrentReaders(t *testing.T) {
+	cache := NewCache(time.Minute)
+	ip := common.IPv4Address{10, 0, 0, 1}
+	mac := common.MACAddress{0x02, 0, 0, 0, 0, 1}
+	cache.Add(ip, mac)
+
+	// Readers see the stable entry while writers add and remove others in
+	// the same shards
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			other := common.IPv4Address{10, 1, byte(i >> 8), byte(i)}
+			cache.Add(other, mac)
+			cache.Delete(other)
+			if i%64 == 0 {
+				cache.Cleanup()
+			}
+		}
+	}()
+	for i := 0; i < 10000; i++ {
+		if got, found := cache.Get(ip); !found || got != mac {
+			t.Fatalf("Get() = %s, %v during updates, want %s, true", got, found, mac)
+		}
+	}
+	close(stop)
+	<-done
+}
+
+// lockedCache is a map behind a single lock, as Cache was before it was
+// sharded, for comparison in the benchmarks.
+type lockedCache struct {
+	mu      sync.RWMutex
+	entries map[common.IPv4Address]*CacheEntry
+}
+
+func (c *lockedCache) Get(ip common.IPv4Address) (common.MACAddress, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[ip]
+	if !ok || entry.IsExpired() {
+		return common.MACAddress{}, false
+	}
+	return entry.MAC, true
+}
+
+// BenchmarkCacheGet looks up the neighbours of a /24 from every core, as
+// transmitting packets does. Lookups take no lock and share no writes, so
+// the time per lookup should fall linearly with cores: run with
+// -cpu 1,2,4,8 and compare with the single lock.
+func BenchmarkCacheGet(b *testing.B) {
+	cache := NewDefaultCache()
+	locked := &lockedCache{entries: make(map[common.IPv4Address]*CacheEntry)}
+	for i := 0; i < 256; i++ {
+		ip := common.IPv4Address{10, 0, 0, byte(i)}
+		cache.Add(ip, common.MACAddress{0x02, 0, 0, 0, 0, byte(i)})
+		locked.entries[ip] = &CacheEntry{ExpiresAt: time.Now().Add(time.Hour)}
+	}
+
+	for _, bc := range []struct {
+		name string
+		get  func(common.IPv4Address) (common.MACAddress, bool)
+	}{
+		{"Sharded", cache.Get},
+		{"Locked", locked.Get},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				ip := common.IPv4Address{10, 0, 0, 0}
+				for pb.Next() {
+					ip[3]++
+					bc.get(ip)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkCacheGetWithUpdates is BenchmarkCacheGet while the entries are
+// refreshed, as replies arriving do, one update per 100 lookups.
+func BenchmarkCacheGetWithUpdates(b *testing.B) {
+	cache := NewDefaultCache()
+	for i := 0; i < 256; i++ {
+		cache.Add(common.IPv4Address{10, 0, 0, byte(i)}, common.MACAddress{0x02, 0, 0, 0, 0, byte(i)})
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		ip := common.IPv4Address{10, 0, 0, 0}
+		for i := 0; pb.Next(); i++ {
+			ip[3]++
+			if i%100 == 0 {
+				cache.Add(ip, common.MACAddress{0x02, 0, 0, 0, 0, ip[3]})
+			} else {
+				cache.Get(ip)
+			}
+		}
+	})
+}
@@ -0,0 +1,22 @@
+package arp
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/internal/fuzzseed"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func FuzzParse(f *testing.F) {
+	fuzzseed.Add(f, fuzzseed.EtherPayloads(f, uint16(common.EtherTypeARP)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt, err := Parse(data)
+		if err != nil {
+			return
+		}
+		if diff := common.DiffBytes(data[:PacketSize], pkt.Serialize()); diff != "" {
+			t.Errorf("Serialize() differs from the parsed packet: %s", diff)
+		}
+	})
+}
@@ -7,8 +7,12 @@ import (
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
 	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
 )
 
+// logger is the "arp" logging subsystem.
+var logger = logging.New("arp")
+
 // DefaultRequestTimeout is the default timeout for ARP requests.
 const DefaultRequestTimeout = 3 * time.Second
 
@@ -18,18 +22,42 @@ const DefaultMaxRetries = 3
 // Handler handles ARP protocol operations including resolving IP addresses,
 // responding to requests, and maintaining the ARP cache.
 type Handler struct {
-	iface        *ethernet.Interface
+	iface        ethernet.Device
 	cache        *Cache
 	localIP      common.IPv4Address
+	netmask      common.IPv4Address
 	requestQueue map[common.IPv4Address]chan common.MACAddress
+	proxies      []ProxyNetwork // Guarded by mu
 	mu           sync.RWMutex
 	timeout      time.Duration
 	maxRetries   int
 }
 
+// ProxyNetwork is a remote network a handler answers ARP requests for
+// (proxy ARP, RFC 1027), in address/mask form.
+type ProxyNetwork struct {
+	Network common.IPv4Address
+	Mask    common.IPv4Address
+}
+
+// newProxyNetwork returns the network of an address and mask.
+func newProxyNetwork(addr, mask common.IPv4Address) ProxyNetwork {
+	n := ProxyNetwork{Mask: mask}
+	for i := range addr {
+		n.Network[i] = addr[i] & mask[i]
+	}
+	return n
+}
+
+// Contains returns whether ip is in the network.
+func (n ProxyNetwork) Contains(ip common.IPv4Address) bool {
+	return ip.ToUint32()&n.Mask.ToUint32() == n.Network.ToUint32()&n.Mask.ToUint32()
+}
+
 // NewHandler creates a new ARP handler for the given interface.
-func NewHandler(iface *ethernet.Interface, localIP common.IPv4Address) *Handler {
-	return &Handler{
+// Any ethernet.Device can be used, including raw interfaces and TAP devices.
+func NewHandler(iface ethernet.Device, localIP common.IPv4Address) *Handler {
+	h := &Handler{
 		iface:        iface,
 		cache:        NewDefaultCache(),
 		localIP:      localIP,
@@ -37,6 +65,8 @@ func NewHandler(iface *ethernet.Interface, localIP common.IPv4Address) *Handler
 		timeout:      DefaultRequestTimeout,
 		maxRetries:   DefaultMaxRetries,
 	}
+	h.cache.SetProbe(h.probe, 0)
+	return h
 }
 
 // SetTimeout sets the timeout for ARP requests.
@@ -49,6 +79,87 @@ func (h *Handler) SetMaxRetries(retries int) {
 	h.maxRetries = retries
 }
 
+// SetNetmask sets the mask of the interface's subnet, so that Resolve
+// recognizes the subnet's directed broadcast address.
+func (h *Handler) SetNetmask(mask common.IPv4Address) {
+	h.netmask = mask
+}
+
+// AddProxyNetwork makes the handler answer ARP requests for the addresses
+// of a remote network with its own MAC address, for a router in front of
+// hosts that do not know how to reach that network. It fails for a network
+// overlapping the interface's own subnet (see SetNetmask), whose hosts
+// answer for themselves.
+func (h *Handler) AddProxyNetwork(network, mask common.IPv4Address) error {
+	n := newProxyNetwork(network, mask)
+	if h.onLink(network) || (h.netmask != common.IPv4Address{} && n.Contains(h.localIP)) {
+		return fmt.Errorf("proxy network %s/%s overlaps the local subnet", n.Network, mask)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, p := range h.proxies {
+		if p == n {
+			return nil
+		}
+	}
+	h.proxies = append(h.proxies, n)
+	return nil
+}
+
+// RemoveProxyNetwork stops the handler answering for a network added with
+// AddProxyNetwork.
+func (h *Handler) RemoveProxyNetwork(network, mask common.IPv4Address) {
+	n := newProxyNetwork(network, mask)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, p := range h.proxies {
+		if p == n {
+			h.proxies = append(h.proxies[:i], h.proxies[i+1:]...)
+			return
+		}
+	}
+}
+
+// ProxyNetworks returns the networks the handler answers for.
+func (h *Handler) ProxyNetworks() []ProxyNetwork {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]ProxyNetwork(nil), h.proxies...)
+}
+
+// onLink returns whether ip is in the interface's own subnet, which is
+// known once SetNetmask is called.
+func (h *Handler) onLink(ip common.IPv4Address) bool {
+	if h.netmask == (common.IPv4Address{}) {
+		return false
+	}
+	return ProxyNetwork{Network: h.localIP, Mask: h.netmask}.Contains(ip)
+}
+
+// proxiesFor returns whether the handler answers a request for another
+// address on behalf of its network. It does not for an address on the
+// local subnet, nor for a request from the target's own network, which
+// reaches the target directly, nor for a probe or announcement (RFC 5227),
+// so that a proxy never defends an address another host claims.
+func (h *Handler) proxiesFor(packet *Packet) bool {
+	target, sender := packet.TargetIP, packet.SenderIP
+	if sender == (common.IPv4Address{}) || sender == target || h.onLink(target) ||
+		target.IsBroadcast() || target.IsMulticast() {
+		return false
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, n := range h.proxies {
+		if n.Contains(target) {
+			return !n.Contains(sender)
+		}
+	}
+	return false
+}
+
 // Cache returns the ARP cache.
 func (h *Handler) Cache() *Cache {
 	return h.cache
@@ -57,16 +168,38 @@ func (h *Handler) Cache() *Cache {
 // Resolve resolves an IP address to a MAC address using ARP.
 // It first checks the cache, and if not found, sends an ARP request.
 // This function blocks until a response is received or timeout occurs.
+// Broadcast and multicast addresses resolve at once, without ARP, to the
+// broadcast address and the group's multicast address.
 func (h *Handler) Resolve(targetIP common.IPv4Address) (common.MACAddress, error) {
+	if mac, ok := h.mappedMAC(targetIP); ok {
+		return mac, nil
+	}
+
 	// Check cache first
 	if mac, found := h.cache.Get(targetIP); found {
+		counters.cacheHits.Add(1)
 		return mac, nil
 	}
+	counters.cacheMisses.Add(1)
 
 	// Send ARP request and wait for reply
 	return h.sendRequestAndWait(targetIP)
 }
 
+// mappedMAC returns the MAC address ip maps to without ARP, if it is a
+// broadcast or multicast address.
+func (h *Handler) mappedMAC(ip common.IPv4Address) (common.MACAddress, bool) {
+	switch {
+	case ip.IsBroadcast():
+		return common.BroadcastMAC, true
+	case ip.IsMulticast():
+		return common.IPv4MulticastMAC(ip), true
+	case h.netmask != common.IPv4Address{} && ip == common.DirectedBroadcast(h.localIP, h.netmask):
+		return common.BroadcastMAC, true
+	}
+	return common.MACAddress{}, false
+}
+
 // sendRequestAndWait sends an ARP request and waits for a reply.
 func (h *Handler) sendRequestAndWait(targetIP common.IPv4Address) (common.MACAddress, error) {
 	// Create a response channel for this IP
@@ -84,6 +217,8 @@ func (h *Handler) sendRequestAndWait(targetIP common.IPv4Address) (common.MACAdd
 		case mac := <-responseChan:
 			return mac, nil
 		case <-time.After(h.timeout):
+			counters.timeouts.Add(1)
+			logger.Warn("resolution timed out", logging.F("ip", targetIP), logging.F("iface", h.iface.Name()))
 			return common.MACAddress{}, fmt.Errorf("ARP request timeout for %s", targetIP)
 		}
 	}
@@ -114,6 +249,8 @@ func (h *Handler) sendRequestAndWait(targetIP common.IPv4Address) (common.MACAdd
 		}
 	}
 
+	counters.timeouts.Add(1)
+	logger.Warn("resolution failed", logging.F("ip", targetIP), logging.F("iface", h.iface.Name()), logging.F("err", lastErr))
 	return common.MACAddress{}, lastErr
 }
 
@@ -131,12 +268,22 @@ func (h *Handler) SendRequest(targetIP common.IPv4Address) error {
 	)
 
 	// Send the frame
-	return h.iface.WriteFrame(frame)
+	if err := h.iface.WriteFrame(frame); err != nil {
+		return err
+	}
+	counters.requestsSent.Add(1)
+	if logger.Enabled(logging.LevelTrace) {
+		logger.Packet("tx", arpPacket, logging.F("iface", h.iface.Name()))
+	}
+	return nil
 }
 
 // HandlePacket processes an incoming ARP packet.
 // This should be called when an ARP packet is received from the network.
 func (h *Handler) HandlePacket(packet *Packet) error {
+	if logger.Enabled(logging.LevelTrace) {
+		logger.Packet("rx", packet, logging.F("iface", h.iface.Name()))
+	}
 	if packet.IsRequest() {
 		return h.handleRequest(packet)
 	} else if packet.IsReply() {
@@ -148,13 +295,20 @@ func (h *Handler) HandlePacket(packet *Packet) error {
 // handleRequest processes an ARP request.
 // If the request is for our IP, send a reply.
 func (h *Handler) handleRequest(packet *Packet) error {
+	counters.requestsReceived.Add(1)
+
 	// Update cache with sender's information
 	h.cache.Add(packet.SenderIP, packet.SenderMAC)
 
 	// Check if the request is for our IP
 	if packet.TargetIP != h.localIP {
-		// Not for us, ignore
-		return nil
+		if !h.proxiesFor(packet) {
+			// Not for us, ignore
+			return nil
+		}
+		counters.proxyReplies.Add(1)
+		logger.Debug("proxy reply", logging.F("ip", packet.TargetIP), logging.F("to", packet.SenderIP))
+		return h.sendReply(packet.TargetIP, packet.SenderMAC, packet.SenderIP)
 	}
 
 	// Send ARP reply
@@ -164,8 +318,11 @@ func (h *Handler) handleRequest(packet *Packet) error {
 // handleReply processes an ARP reply.
 // Update the cache and notify any waiting goroutines.
 func (h *Handler) handleReply(packet *Packet) error {
+	counters.repliesReceived.Add(1)
+
 	// Update cache
 	h.cache.Add(packet.SenderIP, packet.SenderMAC)
+	logger.Debug("resolved", logging.F("ip", packet.SenderIP), logging.F("mac", packet.SenderMAC))
 
 	// Notify any waiting goroutines
 	h.mu.RLock()
@@ -185,8 +342,14 @@ func (h *Handler) handleReply(packet *Packet) error {
 
 // SendReply sends an ARP reply to the given MAC/IP address.
 func (h *Handler) SendReply(targetMAC common.MACAddress, targetIP common.IPv4Address) error {
+	return h.sendReply(h.localIP, targetMAC, targetIP)
+}
+
+// sendReply sends an ARP reply mapping senderIP to the interface's MAC
+// address.
+func (h *Handler) sendReply(senderIP common.IPv4Address, targetMAC common.MACAddress, targetIP common.IPv4Address) error {
 	// Create ARP reply packet
-	arpPacket := NewReply(h.iface.MACAddress(), h.localIP, targetMAC, targetIP)
+	arpPacket := NewReply(h.iface.MACAddress(), senderIP, targetMAC, targetIP)
 
 	// Create Ethernet frame
 	frame := ethernet.NewFrame(
@@ -197,7 +360,26 @@ func (h *Handler) SendReply(targetMAC common.MACAddress, targetIP common.IPv4Add
 	)
 
 	// Send the frame
-	return h.iface.WriteFrame(frame)
+	if err := h.iface.WriteFrame(frame); err != nil {
+		return err
+	}
+	counters.repliesSent.Add(1)
+	if logger.Enabled(logging.LevelTrace) {
+		logger.Packet("tx", arpPacket, logging.F("iface", h.iface.Name()))
+	}
+	return nil
+}
+
+// probe sends an ARP request for ip to the MAC address the cache has for
+// it, to learn whether the host is still there (see PinningConfirm).
+func (h *Handler) probe(ip common.IPv4Address, mac common.MACAddress) {
+	arpPacket := NewRequest(h.iface.MACAddress(), h.localIP, ip)
+	frame := ethernet.NewFrame(mac, h.iface.MACAddress(), common.EtherTypeARP, arpPacket.Serialize())
+	if err := h.iface.WriteFrame(frame); err != nil {
+		logger.Warn("probe failed", logging.F("ip", ip), logging.F("mac", mac), logging.F("err", err))
+		return
+	}
+	counters.requestsSent.Add(1)
 }
 
 // Announce sends a gratuitous ARP to announce our IP/MAC mapping.
@@ -213,7 +395,11 @@ func (h *Handler) Announce() error {
 		arpPacket.Serialize(),
 	)
 
-	return h.iface.WriteFrame(frame)
+	if err := h.iface.WriteFrame(frame); err != nil {
+		return err
+	}
+	counters.requestsSent.Add(1)
+	return nil
 }
 
 // Start starts the ARP handler, processing incoming ARP packets.
@@ -1,10 +1,12 @@
 package arp
 
 import (
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
 )
 
 // mockInterface is a minimal mock of ethernet.Interface for testing
@@ -14,6 +16,7 @@ type mockInterface struct {
 	index      int
 	lastFrame  []byte
 	frameQueue chan []byte
+	sent       []*ethernet.Frame
 }
 
 func newMockInterface() *mockInterface {
@@ -37,6 +40,19 @@ func (m *mockInterface) Index() int {
 	return m.index
 }
 
+func (m *mockInterface) ReadFrame() (*ethernet.Frame, error) {
+	return nil, errors.New("no frames")
+}
+
+func (m *mockInterface) WriteFrame(frame *ethernet.Frame) error {
+	m.sent = append(m.sent, frame)
+	return nil
+}
+
+func (m *mockInterface) Close() error {
+	return nil
+}
+
 // TestHandleRequest tests handling of ARP requests (cache update only)
 func TestHandleRequest(t *testing.T) {
 	localIP := common.IPv4Address{192, 168, 1, 1}
@@ -260,6 +276,102 @@ func TestCacheAccessor(t *testing.T) {
 	}
 }
 
+func TestResolveBroadcastMulticast(t *testing.T) {
+	handler := &Handler{
+		cache:   NewDefaultCache(),
+		localIP: common.IPv4Address{192, 168, 1, 1},
+	}
+	handler.SetNetmask(common.IPv4Address{255, 255, 255, 0})
+
+	tests := []struct {
+		name string
+		ip   common.IPv4Address
+		want common.MACAddress
+	}{
+		{"limited broadcast", common.IPv4Broadcast, common.BroadcastMAC},
+		{"directed broadcast", common.IPv4Address{192, 168, 1, 255}, common.BroadcastMAC},
+		{"multicast", common.IPv4Address{239, 129, 2, 3}, common.MACAddress{0x01, 0x00, 0x5e, 0x01, 0x02, 0x03}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No interface is needed: no ARP request is sent
+			got, err := handler.Resolve(tt.ip)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve(%v) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyARP(t *testing.T) {
+	iface := newMockInterface()
+	handler := NewHandler(iface, common.IPv4Address{192, 168, 1, 1})
+	handler.SetNetmask(common.IPv4Address{255, 255, 255, 0})
+	mask16 := common.IPv4Address{255, 255, 0, 0}
+	if err := handler.AddProxyNetwork(common.IPv4Address{10, 1, 2, 3}, mask16); err != nil {
+		t.Fatalf("AddProxyNetwork() error = %v", err)
+	}
+	if err := handler.AddProxyNetwork(common.IPv4Address{192, 168, 0, 0}, mask16); err == nil {
+		t.Error("AddProxyNetwork(local subnet) error = nil, want an error")
+	}
+	if got := handler.ProxyNetworks(); len(got) != 1 || got[0].Network != (common.IPv4Address{10, 1, 0, 0}) {
+		t.Errorf("ProxyNetworks() = %v, want 10.1.0.0/16", got)
+	}
+
+	hostMAC := common.MACAddress{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	tests := []struct {
+		name      string
+		sender    common.IPv4Address
+		target    common.IPv4Address
+		wantReply bool
+	}{
+		{"proxied network", common.IPv4Address{192, 168, 1, 20}, common.IPv4Address{10, 1, 5, 5}, true},
+		{"local subnet", common.IPv4Address{192, 168, 1, 20}, common.IPv4Address{192, 168, 1, 30}, false},
+		{"other network", common.IPv4Address{192, 168, 1, 20}, common.IPv4Address{10, 2, 5, 5}, false},
+		{"sender in the proxied network", common.IPv4Address{10, 1, 9, 9}, common.IPv4Address{10, 1, 5, 5}, false},
+		{"probe", common.IPv4Address{}, common.IPv4Address{10, 1, 5, 5}, false},
+		{"announcement", common.IPv4Address{10, 1, 5, 5}, common.IPv4Address{10, 1, 5, 5}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iface.sent = nil
+			if err := handler.HandlePacket(NewRequest(hostMAC, tt.sender, tt.target)); err != nil {
+				t.Fatalf("HandlePacket() error = %v", err)
+			}
+			if !tt.wantReply {
+				if len(iface.sent) != 0 {
+					t.Errorf("sent %d frames, want none", len(iface.sent))
+				}
+				return
+			}
+			if len(iface.sent) != 1 {
+				t.Fatalf("sent %d frames, want a reply", len(iface.sent))
+			}
+			reply, err := Parse(iface.sent[0].Payload)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reply.IsReply() || reply.SenderIP != tt.target || reply.SenderMAC != iface.mac || reply.TargetMAC != hostMAC {
+				t.Errorf("reply = %+v, want %v at %v", reply, tt.target, iface.mac)
+			}
+		})
+	}
+
+	handler.RemoveProxyNetwork(common.IPv4Address{10, 1, 0, 0}, mask16)
+	iface.sent = nil
+	if err := handler.HandlePacket(NewRequest(hostMAC, common.IPv4Address{192, 168, 1, 20}, common.IPv4Address{10, 1, 5, 5})); err != nil {
+		t.Fatalf("HandlePacket() error = %v", err)
+	}
+	if len(iface.sent) != 0 {
+		t.Error("answered for a removed proxy network")
+	}
+}
+
 func TestHandlerString(t *testing.T) {
 	// Skip this test because String() method requires a valid interface
 	// which we can't easily mock in a unit test. The String() method
@@ -0,0 +1,185 @@
+package arp
+
+import (
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// DefaultProbeTimeout is how long a cache in PinningConfirm mode waits for
+// the old MAC address of an entry to answer a probe.
+const DefaultProbeTimeout = time.Second
+
+// PinningMode is how a Cache treats an update that changes the MAC address
+// of a valid entry, as an ARP spoofing attack would.
+type PinningMode int
+
+const (
+	// PinningOff replaces the MAC address (the default).
+	PinningOff PinningMode = iota
+
+	// PinningDetect replaces the MAC address and reports the change.
+	PinningDetect
+
+	// PinningReject keeps the old MAC address until the entry expires,
+	// and reports the change as rejected.
+	PinningReject
+
+	// PinningConfirm probes the old MAC address, and replaces it only if
+	// it does not answer within the probe timeout: a host that is still
+	// there means the new address is spoofed. The outcome is reported.
+	PinningConfirm
+)
+
+// String returns the mode name.
+func (m PinningMode) String() string {
+	switch m {
+	case PinningOff:
+		return "off"
+	case PinningDetect:
+		return "detect"
+	case PinningReject:
+		return "reject"
+	case PinningConfirm:
+		return "confirm"
+	default:
+		return "unknown"
+	}
+}
+
+// MACChange describes an update that changed, or tried to change, the MAC
+// address of a valid cache entry.
+type MACChange struct {
+	IP       common.IPv4Address
+	OldMAC   common.MACAddress
+	NewMAC   common.MACAddress
+	Accepted bool // Whether the entry now has NewMAC
+}
+
+// pendingChange is a change waiting for the old MAC address to answer a
+// probe.
+type pendingChange struct {
+	mac   common.MACAddress
+	timer *time.Timer
+}
+
+// pinningConfig is the spoofing detection configuration of a Cache.
+type pinningConfig struct {
+	pinning      PinningMode
+	onMACChange  func(MACChange)
+	probe        func(common.IPv4Address, common.MACAddress)
+	probeTimeout time.Duration
+}
+
+// setConfig replaces the configuration with a copy changed by fn.
+func (c *Cache) setConfig(fn func(*pinningConfig)) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	config := *c.config.Load()
+	fn(&config)
+	c.config.Store(&config)
+}
+
+// SetPinning sets how the cache treats updates changing the MAC address
+// of an entry.
+func (c *Cache) SetPinning(mode PinningMode) {
+	c.setConfig(func(config *pinningConfig) { config.pinning = mode })
+}
+
+// OnMACChange registers a callback invoked for every update that changes
+// the MAC address of a valid entry, once the change is accepted or
+// rejected, unless pinning is off.
+func (c *Cache) OnMACChange(f func(MACChange)) {
+	c.setConfig(func(config *pinningConfig) { config.onMACChange = f })
+}
+
+// SetProbe sets the function PinningConfirm probes the old MAC address of
+// an entry with, and how long to wait for an answer, DefaultProbeTimeout
+// if zero. An answer is any update with the old MAC address. A Handler
+// sets a probe sending an ARP request to the old address.
+func (c *Cache) SetProbe(probe func(ip common.IPv4Address, mac common.MACAddress), timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	c.setConfig(func(config *pinningConfig) {
+		config.probe = probe
+		config.probeTimeout = timeout
+	})
+}
+
+// update adds or updates an entry of shard s, applying the pinning mode.
+// It returns the change to report, if any, and whether to probe the old
+// MAC address. Called with s.mu held.
+func (c *Cache) update(s *cacheShard, config *pinningConfig, ip common.IPv4Address, mac common.MACAddress) (*MACChange, bool) {
+	entry := s.lookup(ip)
+	exists := entry != nil
+	if !exists || entry.IsExpired() || entry.MAC == mac {
+		var change *MACChange
+		if p, ok := s.pending[ip]; ok && exists && entry.MAC == mac {
+			// The old address answered the probe
+			p.timer.Stop()
+			delete(s.pending, ip)
+			change = &MACChange{IP: ip, OldMAC: mac, NewMAC: p.mac}
+		}
+		s.store(ip, &CacheEntry{MAC: mac, ExpiresAt: time.Now().Add(c.timeout)})
+		return change, false
+	}
+
+	counters.macChanges.Add(1)
+	change := &MACChange{IP: ip, OldMAC: entry.MAC, NewMAC: mac}
+	switch config.pinning {
+	case PinningReject:
+		return change, false
+
+	case PinningConfirm:
+		if _, probing := s.pending[ip]; probing {
+			return nil, false // Held until the probe is answered or times out
+		}
+		if s.pending == nil {
+			s.pending = make(map[common.IPv4Address]*pendingChange)
+		}
+		s.pending[ip] = &pendingChange{
+			mac:   mac,
+			timer: time.AfterFunc(config.probeTimeout, func() { c.confirm(ip, mac) }),
+		}
+		return nil, config.probe != nil
+	}
+
+	s.store(ip, &CacheEntry{MAC: mac, ExpiresAt: time.Now().Add(c.timeout)})
+	if config.pinning != PinningDetect {
+		return nil, false
+	}
+	change.Accepted = true
+	return change, false
+}
+
+// confirm accepts a change whose probe went unanswered.
+func (c *Cache) confirm(ip common.IPv4Address, mac common.MACAddress) {
+	s := c.shard(ip)
+	s.mu.Lock()
+	p, ok := s.pending[ip]
+	if !ok || p.mac != mac {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.pending, ip)
+
+	change := MACChange{IP: ip, NewMAC: mac, Accepted: true}
+	if entry := s.lookup(ip); entry != nil {
+		change.OldMAC = entry.MAC
+	}
+	s.store(ip, &CacheEntry{MAC: mac, ExpiresAt: time.Now().Add(c.timeout)})
+	s.mu.Unlock()
+
+	if onMACChange := c.config.Load().onMACChange; onMACChange != nil {
+		onMACChange(change)
+	}
+}
+
+// cancelProbe drops the pending change of an entry. Called with s.mu held.
+func (s *cacheShard) cancelProbe(ip common.IPv4Address) {
+	if p, ok := s.pending[ip]; ok {
+		p.timer.Stop()
+		delete(s.pending, ip)
+	}
+}
@@ -0,0 +1,147 @@
+package arp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+var (
+	pinIP    = common.IPv4Address{192, 168, 1, 2}
+	pinMAC   = common.MACAddress{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	spoofMAC = common.MACAddress{0xde, 0xad, 0xbe, 0xef, 0x00, 0x01}
+)
+
+// changeLog returns a callback appending the changes it is called with.
+func changeLog(changes *[]MACChange) func(MACChange) {
+	return func(c MACChange) { *changes = append(*changes, c) }
+}
+
+func TestCachePinning(t *testing.T) {
+	tests := []struct {
+		mode        PinningMode
+		wantMAC     common.MACAddress
+		wantChanges []MACChange
+	}{
+		{PinningOff, spoofMAC, nil},
+		{PinningDetect, spoofMAC, []MACChange{{IP: pinIP, OldMAC: pinMAC, NewMAC: spoofMAC, Accepted: true}}},
+		{PinningReject, pinMAC, []MACChange{{IP: pinIP, OldMAC: pinMAC, NewMAC: spoofMAC}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			cache := NewCache(time.Minute)
+			cache.SetPinning(tt.mode)
+			var changes []MACChange
+			cache.OnMACChange(changeLog(&changes))
+
+			cache.Add(pinIP, pinMAC)
+			cache.Add(pinIP, pinMAC) // A refresh is no change
+			before := GetStats().MACChanges
+			cache.Add(pinIP, spoofMAC)
+
+			if got, _ := cache.Get(pinIP); got != tt.wantMAC {
+				t.Errorf("Get() = %v, want %v", got, tt.wantMAC)
+			}
+			if len(changes) != len(tt.wantChanges) || (len(changes) > 0 && changes[0] != tt.wantChanges[0]) {
+				t.Errorf("changes = %+v, want %+v", changes, tt.wantChanges)
+			}
+			if got := GetStats().MACChanges - before; got != 1 {
+				t.Errorf("MACChanges grew by %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestCachePinningExpired(t *testing.T) {
+	cache := NewCache(10 * time.Millisecond)
+	cache.SetPinning(PinningReject)
+	var changes []MACChange
+	cache.OnMACChange(changeLog(&changes))
+
+	cache.Add(pinIP, pinMAC)
+	time.Sleep(20 * time.Millisecond)
+	cache.Add(pinIP, spoofMAC)
+
+	// An expired entry is no longer pinned
+	if got, _ := cache.Get(pinIP); got != spoofMAC {
+		t.Errorf("Get() = %v, want %v", got, spoofMAC)
+	}
+	if len(changes) != 0 {
+		t.Errorf("changes = %+v, want none", changes)
+	}
+}
+
+func TestCachePinningConfirm(t *testing.T) {
+	tests := []struct {
+		name         string
+		answered     bool // The old MAC address answers the probe
+		wantMAC      common.MACAddress
+		wantAccepted bool
+	}{
+		{"old host answers", true, pinMAC, false},
+		{"old host gone", false, spoofMAC, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCache(time.Minute)
+			cache.SetPinning(PinningConfirm)
+			changes := make(chan MACChange, 1)
+			cache.OnMACChange(func(c MACChange) { changes <- c })
+			var probes []common.MACAddress
+			cache.SetProbe(func(ip common.IPv4Address, mac common.MACAddress) {
+				probes = append(probes, mac)
+				if tt.answered {
+					cache.Add(ip, mac)
+				}
+			}, 20*time.Millisecond)
+
+			cache.Add(pinIP, pinMAC)
+			cache.Add(pinIP, spoofMAC)
+			if len(probes) != 1 || probes[0] != pinMAC {
+				t.Fatalf("probed %v, want %v", probes, pinMAC)
+			}
+			if got, _ := cache.Get(pinIP); !tt.answered && got != pinMAC {
+				t.Errorf("Get() while probing = %v, want %v", got, pinMAC)
+			}
+
+			select {
+			case c := <-changes:
+				if c.Accepted != tt.wantAccepted || c.OldMAC != pinMAC || c.NewMAC != spoofMAC {
+					t.Errorf("change = %+v, want accepted = %v", c, tt.wantAccepted)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no change reported")
+			}
+			if got, _ := cache.Get(pinIP); got != tt.wantMAC {
+				t.Errorf("Get() = %v, want %v", got, tt.wantMAC)
+			}
+		})
+	}
+}
+
+func TestHandlerProbe(t *testing.T) {
+	iface := newMockInterface()
+	handler := NewHandler(iface, common.IPv4Address{192, 168, 1, 1})
+	handler.Cache().SetPinning(PinningConfirm)
+
+	handler.Cache().Add(pinIP, pinMAC)
+	if err := handler.HandlePacket(NewReply(spoofMAC, pinIP, iface.mac, handler.localIP)); err != nil {
+		t.Fatalf("HandlePacket() error = %v", err)
+	}
+
+	// The probe goes to the old address only
+	if len(iface.sent) != 1 || iface.sent[0].Destination != pinMAC {
+		t.Fatalf("sent %v, want a probe to %v", iface.sent, pinMAC)
+	}
+	probe, err := Parse(iface.sent[0].Payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !probe.IsRequest() || probe.TargetIP != pinIP {
+		t.Errorf("probe = %+v, want a request for %v", probe, pinIP)
+	}
+	handler.Cache().Clear() // Cancels the probe
+}
@@ -0,0 +1,44 @@
+package arp
+
+import "sync/atomic"
+
+// Stats holds ARP counters summed over all handlers.
+type Stats struct {
+	RequestsSent     uint64 // Requests sent, including retries and announcements
+	RequestsReceived uint64 // Requests received
+	RepliesSent      uint64 // Replies sent
+	RepliesReceived  uint64 // Replies received
+	CacheHits        uint64 // Resolve calls answered from the cache
+	CacheMisses      uint64 // Resolve calls that needed a request
+	Timeouts         uint64 // Resolutions that got no reply
+	ProxyReplies     uint64 // Replies sent for a proxied network, included in RepliesSent
+	MACChanges       uint64 // Updates changing the MAC address of a valid cache entry
+}
+
+// counters are the package-wide counters behind GetStats.
+var counters struct {
+	requestsSent     atomic.Uint64
+	requestsReceived atomic.Uint64
+	repliesSent      atomic.Uint64
+	repliesReceived  atomic.Uint64
+	cacheHits        atomic.Uint64
+	cacheMisses      atomic.Uint64
+	timeouts         atomic.Uint64
+	proxyReplies     atomic.Uint64
+	macChanges       atomic.Uint64
+}
+
+// GetStats returns a snapshot of the ARP counters.
+func GetStats() Stats {
+	return Stats{
+		RequestsSent:     counters.requestsSent.Load(),
+		RequestsReceived: counters.requestsReceived.Load(),
+		RepliesSent:      counters.repliesSent.Load(),
+		RepliesReceived:  counters.repliesReceived.Load(),
+		CacheHits:        counters.cacheHits.Load(),
+		CacheMisses:      counters.cacheMisses.Load(),
+		Timeouts:         counters.timeouts.Load(),
+		ProxyReplies:     counters.proxyReplies.Load(),
+		MACChanges:       counters.macChanges.Load(),
+	}
+}
@@ -0,0 +1,249 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// BPDU format (IEEE 802.1D-2004 Section 9.3), carried in 802.3 frames with
+// an LLC header (DSAP 0x42, SSAP 0x42, UI):
+// +-------------------+-------------+----------+-----------+
+// | Protocol ID (16)  | Version (8) | Type (8) | Flags (8) |
+// +-------------------+-------------+----------+-----------+
+// | Root ID (64)                                          |
+// | Root Path Cost (32)                                   |
+// | Bridge ID (64)                                        |
+// | Port ID (16)      | Message Age (16)                  |
+// | Max Age (16)      | Hello Time (16)                   |
+// | Forward Delay (16)| Version 1 Length (8, RST only)    |
+// +-------------------------------------------------------+
+//
+// Topology Change Notifications stop after the type. Times are in units
+// of 1/256 second.
+
+const (
+	// ConfigBPDUSize is the size of a configuration BPDU (35 bytes).
+	ConfigBPDUSize = 35
+
+	// TCNBPDUSize is the size of a topology change notification (4 bytes).
+	TCNBPDUSize = 4
+
+	// RSTBPDUSize is the size of a rapid spanning tree BPDU (36 bytes).
+	RSTBPDUSize = 36
+
+	// llcHeaderSize is the size of the LLC header of a BPDU frame.
+	llcHeaderSize = 3
+
+	// llcSAP is the LLC service access point of the spanning tree protocol.
+	llcSAP = 0x42
+
+	// llcUI is the LLC control field of unnumbered information.
+	llcUI = 0x03
+
+	// maxLengthField is the largest 802.3 length; larger values of the
+	// field are EtherTypes.
+	maxLengthField = 1500
+)
+
+// STPAddress is the destination of BPDUs, one of the reserved addresses
+// bridges never forward.
+var STPAddress = common.MACAddress{0x01, 0x80, 0xc2, 0x00, 0x00, 0x00}
+
+// BPDU protocol versions.
+const (
+	VersionSTP  = 0
+	VersionRSTP = 2
+)
+
+// BPDUType is the type of a BPDU.
+type BPDUType uint8
+
+// BPDU types.
+const (
+	BPDUConfig BPDUType = 0x00 // Configuration
+	BPDURST    BPDUType = 0x02 // Rapid spanning tree
+	BPDUTCN    BPDUType = 0x80 // Topology change notification
+)
+
+// String returns the name of the BPDU type.
+func (t BPDUType) String() string {
+	switch t {
+	case BPDUConfig:
+		return "Config"
+	case BPDURST:
+		return "RST"
+	case BPDUTCN:
+		return "TCN"
+	default:
+		return fmt.Sprintf("Unknown(0x%02x)", uint8(t))
+	}
+}
+
+// BPDU flags. STP uses only TopologyChange and TopologyChangeAck; RSTP
+// adds the others, with the port role in bits 2 and 3.
+const (
+	FlagTopologyChange    = 1 << 0
+	FlagProposal          = 1 << 1
+	FlagLearning          = 1 << 4
+	FlagForwarding        = 1 << 5
+	FlagAgreement         = 1 << 6
+	FlagTopologyChangeAck = 1 << 7
+
+	flagRoleShift = 2
+	flagRoleMask  = 0x3 << flagRoleShift
+)
+
+// BPDU port roles, of RST BPDUs.
+const (
+	BPDURoleUnknown    = 0
+	BPDURoleAlternate  = 1 // Alternate or backup
+	BPDURoleRoot       = 2
+	BPDURoleDesignated = 3
+)
+
+// BridgeID identifies a bridge: a priority, then a MAC address breaking
+// ties. The lowest ID is the best.
+type BridgeID struct {
+	Priority uint16
+	Address  common.MACAddress
+}
+
+// Uint64 returns the ID as a number, comparing as IDs do.
+func (id BridgeID) Uint64() uint64 {
+	var b [8]byte
+	binary.BigEndian.PutUint16(b[0:2], id.Priority)
+	copy(b[2:], id.Address[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// String returns the ID as priority.address, as brctl shows it.
+func (id BridgeID) String() string {
+	return fmt.Sprintf("%04x.%x", id.Priority, id.Address[:])
+}
+
+func parseBridgeID(b []byte) BridgeID {
+	id := BridgeID{Priority: binary.BigEndian.Uint16(b[0:2])}
+	copy(id.Address[:], b[2:8])
+	return id
+}
+
+func putBridgeID(b []byte, id BridgeID) {
+	binary.BigEndian.PutUint16(b[0:2], id.Priority)
+	copy(b[2:8], id.Address[:])
+}
+
+// BPDU is a bridge protocol data unit.
+type BPDU struct {
+	Version      uint8
+	Type         BPDUType
+	Flags        uint8
+	RootID       BridgeID
+	RootPathCost uint32
+	BridgeID     BridgeID
+	PortID       uint16
+	MessageAge   time.Duration
+	MaxAge       time.Duration
+	HelloTime    time.Duration
+	ForwardDelay time.Duration
+}
+
+// Role returns the port role of an RST BPDU.
+func (b *BPDU) Role() int {
+	return int(b.Flags&flagRoleMask) >> flagRoleShift
+}
+
+// ParseBPDU parses a BPDU, following the LLC header.
+func ParseBPDU(data []byte) (*BPDU, error) {
+	if len(data) < TCNBPDUSize {
+		return nil, fmt.Errorf("BPDU too short: %d bytes", len(data))
+	}
+	if id := binary.BigEndian.Uint16(data[0:2]); id != 0 {
+		return nil, fmt.Errorf("unknown protocol identifier: 0x%04x", id)
+	}
+	b := &BPDU{Version: data[2], Type: BPDUType(data[3])}
+	switch b.Type {
+	case BPDUTCN:
+		return b, nil
+	case BPDUConfig:
+		if len(data) < ConfigBPDUSize {
+			return nil, fmt.Errorf("configuration BPDU too short: %d bytes", len(data))
+		}
+	case BPDURST:
+		if len(data) < RSTBPDUSize {
+			return nil, fmt.Errorf("RST BPDU too short: %d bytes", len(data))
+		}
+	default:
+		return nil, fmt.Errorf("unknown BPDU type: 0x%02x", uint8(b.Type))
+	}
+
+	b.Flags = data[4]
+	b.RootID = parseBridgeID(data[5:13])
+	b.RootPathCost = binary.BigEndian.Uint32(data[13:17])
+	b.BridgeID = parseBridgeID(data[17:25])
+	b.PortID = binary.BigEndian.Uint16(data[25:27])
+	b.MessageAge = parseTime(data[27:29])
+	b.MaxAge = parseTime(data[29:31])
+	b.HelloTime = parseTime(data[31:33])
+	b.ForwardDelay = parseTime(data[33:35])
+	return b, nil
+}
+
+// Serialize converts the BPDU to bytes, without the LLC header.
+func (b *BPDU) Serialize() []byte {
+	size := ConfigBPDUSize
+	switch b.Type {
+	case BPDUTCN:
+		size = TCNBPDUSize
+	case BPDURST:
+		size = RSTBPDUSize
+	}
+	data := make([]byte, size)
+	data[2] = b.Version
+	data[3] = uint8(b.Type)
+	if b.Type == BPDUTCN {
+		return data
+	}
+	data[4] = b.Flags
+	putBridgeID(data[5:13], b.RootID)
+	binary.BigEndian.PutUint32(data[13:17], b.RootPathCost)
+	putBridgeID(data[17:25], b.BridgeID)
+	binary.BigEndian.PutUint16(data[25:27], b.PortID)
+	putTime(data[27:29], b.MessageAge)
+	putTime(data[29:31], b.MaxAge)
+	putTime(data[31:33], b.HelloTime)
+	putTime(data[33:35], b.ForwardDelay)
+	return data // An RST BPDU's Version 1 Length is 0
+}
+
+func parseTime(b []byte) time.Duration {
+	return time.Duration(binary.BigEndian.Uint16(b)) * time.Second / 256
+}
+
+func putTime(b []byte, d time.Duration) {
+	binary.BigEndian.PutUint16(b, uint16(d*256/time.Second))
+}
+
+// bpduFrame returns the frame carrying a BPDU from src.
+func bpduFrame(src common.MACAddress, b *BPDU) *ethernet.Frame {
+	payload := append([]byte{llcSAP, llcSAP, llcUI}, b.Serialize()...)
+	return ethernet.NewFrame(STPAddress, src, common.EtherType(len(payload)), payload)
+}
+
+// parseBPDUFrame returns the BPDU a frame carries, or nil if it carries
+// none.
+func parseBPDUFrame(frame *ethernet.Frame) (*BPDU, error) {
+	// BPDUs are 802.3 frames, whose length field trims the padding
+	length := int(frame.EtherType)
+	if length > maxLengthField || length > len(frame.Payload) || length < llcHeaderSize {
+		return nil, fmt.Errorf("not an LLC frame")
+	}
+	llc := frame.Payload[:llcHeaderSize]
+	if llc[0] != llcSAP || llc[1] != llcSAP || llc[2] != llcUI {
+		return nil, fmt.Errorf("not a spanning tree LLC frame")
+	}
+	return ParseBPDU(frame.Payload[llcHeaderSize:length])
+}
@@ -0,0 +1,115 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+func TestBPDURoundTrip(t *testing.T) {
+	bpdu := &BPDU{
+		Version:      VersionSTP,
+		Type:         BPDUConfig,
+		Flags:        FlagTopologyChange | FlagTopologyChangeAck,
+		RootID:       BridgeID{Priority: 0x1000, Address: common.MACAddress{0x02, 0, 0, 0, 0, 0x0a}},
+		RootPathCost: 20000,
+		BridgeID:     BridgeID{Priority: 0x8000, Address: common.MACAddress{0x02, 0, 0, 0, 0, 0x0b}},
+		PortID:       0x8002,
+		MessageAge:   time.Second,
+		MaxAge:       20 * time.Second,
+		HelloTime:    2 * time.Second,
+		ForwardDelay: 15 * time.Second,
+	}
+	data := bpdu.Serialize()
+	if len(data) != ConfigBPDUSize {
+		t.Fatalf("Serialize() = %d bytes, want %d", len(data), ConfigBPDUSize)
+	}
+	// Times are in 1/256 second: 20 s is 0x1400
+	if data[29] != 0x14 || data[30] != 0x00 {
+		t.Errorf("max age bytes = % x, want 14 00", data[29:31])
+	}
+
+	got, err := ParseBPDU(data)
+	if err != nil {
+		t.Fatalf("ParseBPDU() error = %v", err)
+	}
+	if *got != *bpdu {
+		t.Errorf("ParseBPDU() = %+v, want %+v", got, bpdu)
+	}
+}
+
+func TestParseBPDURST(t *testing.T) {
+	rst := &BPDU{
+		Version:  VersionRSTP,
+		Type:     BPDURST,
+		Flags:    BPDURoleDesignated<<flagRoleShift | FlagLearning | FlagForwarding,
+		RootID:   BridgeID{Priority: 0x8000},
+		BridgeID: BridgeID{Priority: 0x8000},
+		MaxAge:   20 * time.Second,
+	}
+	data := rst.Serialize()
+	if len(data) != RSTBPDUSize {
+		t.Fatalf("Serialize() = %d bytes, want %d", len(data), RSTBPDUSize)
+	}
+	got, err := ParseBPDU(data)
+	if err != nil {
+		t.Fatalf("ParseBPDU() error = %v", err)
+	}
+	if got.Type != BPDURST || got.Role() != BPDURoleDesignated || got.Flags&FlagForwarding == 0 {
+		t.Errorf("ParseBPDU() = %+v, want a designated forwarding RST BPDU", got)
+	}
+}
+
+func TestParseBPDUInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte{0, 0, 0}},
+		{"protocol identifier", []byte{0, 1, 0, 0x80}},
+		{"unknown type", []byte{0, 0, 0, 0x42}},
+		{"short config", append([]byte{0, 0, 0, 0}, make([]byte, 20)...)},
+		{"short RST", append([]byte{0, 0, 2, 2}, make([]byte, 31)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseBPDU(tt.data); err == nil {
+				t.Error("ParseBPDU() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestBPDUFrame(t *testing.T) {
+	src := common.MACAddress{0x02, 0, 0, 0, 0, 1}
+	frame, err := ethernet.Parse(bpduFrame(src, &BPDU{Type: BPDUTCN}).Serialize())
+	if err != nil {
+		t.Fatalf("ethernet.Parse() error = %v", err)
+	}
+	if frame.Destination != STPAddress || frame.EtherType != llcHeaderSize+TCNBPDUSize {
+		t.Fatalf("frame = %s, want an 802.3 frame of length 7 to %s", frame, STPAddress)
+	}
+
+	// The length field trims the padding to the minimum frame size
+	bpdu, err := parseBPDUFrame(frame)
+	if err != nil {
+		t.Fatalf("parseBPDUFrame() error = %v", err)
+	}
+	if bpdu.Type != BPDUTCN {
+		t.Errorf("Type = %s, want TCN", bpdu.Type)
+	}
+
+	frame.Payload[0] = 0xaa // SNAP
+	if _, err := parseBPDUFrame(frame); err == nil {
+		t.Error("parseBPDUFrame() of a SNAP frame succeeded")
+	}
+}
+
+func TestBridgeIDString(t *testing.T) {
+	id := BridgeID{Priority: 0x8000, Address: common.MACAddress{0x02, 0, 0, 0, 0, 0x0a}}
+	if got := id.String(); got != "8000.02000000000a" {
+		t.Errorf("String() = %q, want 8000.02000000000a", got)
+	}
+}
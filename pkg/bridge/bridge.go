@@ -0,0 +1,550 @@
+// Package bridge implements a software Ethernet bridge (IEEE 802.1D)
+// joining several devices into one broadcast domain.
+//
+// The bridge learns which port each MAC address is behind from the source
+// of the frames it receives, forwards frames to the port their destination
+// was learned on, and floods broadcast, multicast and unknown unicast
+// frames to every other port. Learned entries age out.
+//
+// With Config.STP set, the bridge runs the spanning tree protocol: it
+// exchanges BPDUs with the other bridges and blocks the ports that would
+// close a loop, moving ports it unblocks through listening and learning
+// before forwarding. RSTP BPDUs are parsed, and answered with STP ones as
+// an RSTP bridge expects of an STP neighbour.
+//
+// The bridge is itself a Device, like a Linux br0: frames for its MAC
+// address, and broadcast and multicast frames, are delivered to ReadFrame,
+// and WriteFrame sends frames out through the ports.
+package bridge
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+const (
+	// DefaultAgeingTime is how long a learned entry lives without a frame
+	// from its MAC address refreshing it.
+	DefaultAgeingTime = 300 * time.Second
+
+	// DefaultPriority is the default bridge priority; the lowest bridge ID
+	// becomes the root.
+	DefaultPriority = 0x8000
+
+	// DefaultPathCost is the default cost of a port, that of a 1 Gb/s link
+	// (IEEE 802.1D-2004 Table 17-3).
+	DefaultPathCost = 20000
+
+	// Default spanning tree timers (IEEE 802.1D-2004 Table 17-1).
+	DefaultHelloTime    = 2 * time.Second
+	DefaultMaxAge       = 20 * time.Second
+	DefaultForwardDelay = 15 * time.Second
+
+	// localQueueSize is how many frames are queued for ReadFrame; more are
+	// dropped.
+	localQueueSize = 256
+
+	// tickInterval is the resolution of the bridge's timers.
+	tickInterval = 500 * time.Millisecond
+)
+
+// ErrClosed is returned by a closed bridge.
+var ErrClosed = errors.New("bridge closed")
+
+// PortState is the forwarding state of a port.
+type PortState int
+
+const (
+	PortDisabled   PortState = iota // Down
+	PortBlocking                    // Discarding frames, receiving BPDUs
+	PortListening                   // Waiting for the tree to settle
+	PortLearning                    // Learning addresses, not forwarding
+	PortForwarding                  // Learning and forwarding
+)
+
+// String returns the name of the state.
+func (s PortState) String() string {
+	switch s {
+	case PortDisabled:
+		return "disabled"
+	case PortBlocking:
+		return "blocking"
+	case PortListening:
+		return "listening"
+	case PortLearning:
+		return "learning"
+	case PortForwarding:
+		return "forwarding"
+	default:
+		return fmt.Sprintf("PortState(%d)", int(s))
+	}
+}
+
+// PortRole is the role of a port in the spanning tree.
+type PortRole int
+
+const (
+	RoleDisabled   PortRole = iota // Down
+	RoleRoot                       // Towards the root bridge
+	RoleDesignated                 // Towards a segment the bridge serves
+	RoleAlternate                  // Blocked, closing a loop
+)
+
+// String returns the name of the role.
+func (r PortRole) String() string {
+	switch r {
+	case RoleDisabled:
+		return "disabled"
+	case RoleRoot:
+		return "root"
+	case RoleDesignated:
+		return "designated"
+	case RoleAlternate:
+		return "alternate"
+	default:
+		return fmt.Sprintf("PortRole(%d)", int(r))
+	}
+}
+
+// Config configures a bridge.
+type Config struct {
+	Name       string            // Device name (default "br0")
+	MAC        common.MACAddress // Bridge address (zero = random locally administered)
+	AgeingTime time.Duration     // 0 = DefaultAgeingTime
+
+	// STP enables the spanning tree protocol. Without it every port
+	// forwards as soon as it is added, and BPDUs are dropped.
+	STP bool
+
+	// Priority is the bridge priority, in steps of 0x1000; 0 means
+	// DefaultPriority, so a preferred root takes a low non-zero value.
+	Priority uint16
+
+	HelloTime    time.Duration // 0 = DefaultHelloTime
+	MaxAge       time.Duration // 0 = DefaultMaxAge
+	ForwardDelay time.Duration // 0 = DefaultForwardDelay
+}
+
+// PortInfo describes a port.
+type PortInfo struct {
+	Number   int
+	Name     string
+	State    PortState
+	Role     PortRole
+	PathCost uint32
+}
+
+// Stats are the counters of a bridge.
+type Stats struct {
+	Forwarded uint64 // Frames sent to the port of their destination
+	Flooded   uint64 // Frames sent to every port
+	Delivered uint64 // Frames delivered to ReadFrame
+	Dropped   uint64 // Frames filtered by a port state, the FDB, or a full queue
+	BPDUsIn   uint64
+	BPDUsOut  uint64
+}
+
+// port is a bridge port.
+type port struct {
+	number int
+	id     uint16 // Port ID of BPDUs: priority and number
+	dev    ethernet.Device
+	cost   uint32
+	state  PortState
+	role   PortRole
+	stp    portSTP
+}
+
+// Bridge is a learning Ethernet bridge.
+type Bridge struct {
+	config Config
+	id     BridgeID
+	fdb    *fdb
+	now    func() time.Time
+
+	mu    sync.RWMutex
+	ports []*port // By number, from 1
+	stp   bridgeSTP
+
+	local     chan *ethernet.Frame
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	forwarded atomic.Uint64
+	flooded   atomic.Uint64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	bpdusIn   atomic.Uint64
+	bpdusOut  atomic.Uint64
+}
+
+// Compile-time check that Bridge implements ethernet.Device.
+var _ ethernet.Device = (*Bridge)(nil)
+
+// New creates a bridge with no ports.
+func New(config Config) (*Bridge, error) {
+	b, err := newBridge(config, time.Now)
+	if err != nil {
+		return nil, err
+	}
+	b.start()
+	return b, nil
+}
+
+// newBridge creates a bridge on a clock, without starting its timers.
+func newBridge(config Config, now func() time.Time) (*Bridge, error) {
+	if config.Name == "" {
+		config.Name = "br0"
+	}
+	if config.MAC == (common.MACAddress{}) {
+		if _, err := rand.Read(config.MAC[:]); err != nil {
+			return nil, fmt.Errorf("failed to generate MAC address: %w", err)
+		}
+		config.MAC[0] = config.MAC[0]&^0x01 | 0x02 // Unicast, locally administered
+	}
+	if config.MAC.IsMulticast() {
+		return nil, fmt.Errorf("bridge address %s is multicast", config.MAC)
+	}
+	if config.AgeingTime == 0 {
+		config.AgeingTime = DefaultAgeingTime
+	}
+	if config.Priority == 0 {
+		config.Priority = DefaultPriority
+	}
+	if config.HelloTime == 0 {
+		config.HelloTime = DefaultHelloTime
+	}
+	if config.MaxAge == 0 {
+		config.MaxAge = DefaultMaxAge
+	}
+	if config.ForwardDelay == 0 {
+		config.ForwardDelay = DefaultForwardDelay
+	}
+	if config.AgeingTime < 0 || config.HelloTime < 0 || config.MaxAge < 0 || config.ForwardDelay < 0 {
+		return nil, fmt.Errorf("negative ageing time or spanning tree timer")
+	}
+
+	b := &Bridge{
+		config: config,
+		id:     BridgeID{Priority: config.Priority, Address: config.MAC},
+		fdb:    newFDB(),
+		now:    now,
+		local:  make(chan *ethernet.Frame, localQueueSize),
+		done:   make(chan struct{}),
+	}
+	b.stp.init(b)
+	return b, nil
+}
+
+// start runs the bridge's timers until Close.
+func (b *Bridge) start() {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.tick()
+			case <-b.done:
+				return
+			}
+		}
+	}()
+}
+
+// AddPort adds dev as a port and starts receiving frames on it, returning
+// the port number. The bridge takes over dev, and closes it on Close.
+func (b *Bridge) AddPort(dev ethernet.Device) (int, error) {
+	b.mu.Lock()
+	select {
+	case <-b.done:
+		b.mu.Unlock()
+		return 0, ErrClosed
+	default:
+	}
+	if len(b.ports) >= 0xff {
+		b.mu.Unlock()
+		return 0, fmt.Errorf("too many ports")
+	}
+	p := &port{
+		number: len(b.ports) + 1,
+		dev:    dev,
+		cost:   DefaultPathCost,
+	}
+	p.id = 0x8000 | uint16(p.number)
+	b.ports = append(b.ports, p)
+	if b.config.STP {
+		b.stp.enable(p)
+	} else {
+		p.state, p.role = PortForwarding, RoleDesignated
+	}
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go b.read(p)
+	return p.number, nil
+}
+
+// SetPathCost sets the spanning tree cost of a port.
+func (b *Bridge) SetPathCost(number int, cost uint32) error {
+	if cost == 0 {
+		return fmt.Errorf("path cost must be positive")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := b.port(number)
+	if p == nil {
+		return fmt.Errorf("no port %d", number)
+	}
+	p.cost = cost
+	if b.config.STP {
+		b.stp.update()
+	}
+	return nil
+}
+
+// Ports describes the bridge's ports.
+func (b *Bridge) Ports() []PortInfo {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	infos := make([]PortInfo, len(b.ports))
+	for i, p := range b.ports {
+		infos[i] = PortInfo{Number: p.number, Name: p.dev.Name(), State: p.state, Role: p.role, PathCost: p.cost}
+	}
+	return infos
+}
+
+// FDB returns the forwarding database, sorted by MAC address.
+func (b *Bridge) FDB() []Entry {
+	return b.fdb.list()
+}
+
+// ID returns the bridge ID.
+func (b *Bridge) ID() BridgeID {
+	return b.id
+}
+
+// Root returns the ID of the root bridge: the bridge's own without STP.
+func (b *Bridge) Root() BridgeID {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.stp.root
+}
+
+// Stats returns the bridge's counters.
+func (b *Bridge) Stats() Stats {
+	return Stats{
+		Forwarded: b.forwarded.Load(),
+		Flooded:   b.flooded.Load(),
+		Delivered: b.delivered.Load(),
+		Dropped:   b.dropped.Load(),
+		BPDUsIn:   b.bpdusIn.Load(),
+		BPDUsOut:  b.bpdusOut.Load(),
+	}
+}
+
+// Name returns the bridge name.
+func (b *Bridge) Name() string {
+	return b.config.Name
+}
+
+// MACAddress returns the bridge address.
+func (b *Bridge) MACAddress() common.MACAddress {
+	return b.config.MAC
+}
+
+// Index returns 0: the bridge has no kernel interface.
+func (b *Bridge) Index() int {
+	return 0
+}
+
+// ReadFrame blocks until a frame for the bridge itself is received.
+func (b *Bridge) ReadFrame() (*ethernet.Frame, error) {
+	select {
+	case frame := <-b.local:
+		return frame, nil
+	case <-b.done:
+		return nil, ErrClosed
+	}
+}
+
+// WriteFrame sends a frame out through the ports, as if received on none.
+func (b *Bridge) WriteFrame(frame *ethernet.Frame) error {
+	select {
+	case <-b.done:
+		return ErrClosed
+	default:
+	}
+	b.forward(nil, frame)
+	return nil
+}
+
+// Close stops the bridge and closes its ports.
+func (b *Bridge) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.mu.RLock()
+		for _, p := range b.ports {
+			p.dev.Close()
+		}
+		b.mu.RUnlock()
+	})
+	b.wg.Wait()
+	return nil
+}
+
+// port returns the port with a number, or nil.
+func (b *Bridge) port(number int) *port {
+	if number < 1 || number > len(b.ports) {
+		return nil
+	}
+	return b.ports[number-1]
+}
+
+// read passes the frames received on a port to the bridge until the port
+// fails, which disables it.
+func (b *Bridge) read(p *port) {
+	defer b.wg.Done()
+	for {
+		frame, err := p.dev.ReadFrame()
+		if err != nil {
+			select {
+			case <-b.done:
+			default:
+				b.disable(p)
+			}
+			return
+		}
+		b.receive(p, frame)
+	}
+}
+
+// disable takes a failed port out of the bridge.
+func (b *Bridge) disable(p *port) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p.state, p.role = PortDisabled, RoleDisabled
+	b.fdb.flush(p.number)
+	if b.config.STP {
+		b.stp.disable(p)
+	}
+}
+
+// receive processes a frame received on a port.
+func (b *Bridge) receive(p *port, frame *ethernet.Frame) {
+	// Reserved addresses are for the link, and never forwarded
+	if isReserved(frame.Destination) {
+		if frame.Destination == STPAddress && b.config.STP {
+			b.receiveBPDU(p, frame)
+		}
+		frame.Release()
+		return
+	}
+
+	b.mu.RLock()
+	state := p.state
+	b.mu.RUnlock()
+	if state < PortLearning || frame.Source.IsMulticast() {
+		b.dropped.Add(1)
+		frame.Release()
+		return
+	}
+	b.fdb.learn(frame.Source, p.number, b.now())
+	if state != PortForwarding {
+		b.dropped.Add(1)
+		frame.Release()
+		return
+	}
+	b.forward(p, frame)
+}
+
+// forward sends a frame received on in, or sent by the bridge itself if
+// in is nil, towards its destination.
+func (b *Bridge) forward(in *port, frame *ethernet.Frame) {
+	dst := frame.Destination
+	if in != nil && dst == b.config.MAC {
+		b.deliver(frame)
+		return
+	}
+
+	if !dst.IsMulticast() {
+		if number := b.fdb.lookup(dst); number != 0 {
+			b.mu.RLock()
+			out := b.port(number)
+			forwarding := out != nil && out != in && out.state == PortForwarding
+			b.mu.RUnlock()
+			if !forwarding {
+				b.dropped.Add(1) // Behind the port it came from, or a blocked one
+				frame.Release()
+				return
+			}
+			out.dev.WriteFrame(frame)
+			b.forwarded.Add(1)
+			frame.Release()
+			return
+		}
+	}
+
+	b.mu.RLock()
+	outs := make([]*port, 0, len(b.ports))
+	for _, p := range b.ports {
+		if p != in && p.state == PortForwarding {
+			outs = append(outs, p)
+		}
+	}
+	b.mu.RUnlock()
+	for _, p := range outs {
+		p.dev.WriteFrame(frame)
+	}
+	b.flooded.Add(1)
+
+	if in != nil && dst.IsMulticast() {
+		b.deliver(frame) // The bridge is on the segment too
+		return
+	}
+	frame.Release()
+}
+
+// deliver queues a frame for ReadFrame.
+func (b *Bridge) deliver(frame *ethernet.Frame) {
+	select {
+	case b.local <- frame:
+		b.delivered.Add(1)
+	default:
+		b.dropped.Add(1)
+		frame.Release()
+	}
+}
+
+// tick runs the bridge's timers.
+func (b *Bridge) tick() {
+	now := b.now()
+	ageing := b.config.AgeingTime
+	if b.config.STP {
+		b.mu.Lock()
+		b.stp.tick(now)
+		if b.stp.topologyChange(now) {
+			// Stations may have moved: age out quickly (IEEE
+			// 802.1D-2004 Section 17.19.20)
+			ageing = b.stp.forwardDelay
+		}
+		b.mu.Unlock()
+	}
+	b.fdb.expire(now.Add(-ageing))
+}
+
+// isReserved reports whether mac is one of the addresses 01:80:C2:00:00:0X
+// that bridges do not forward.
+func isReserved(mac common.MACAddress) bool {
+	return mac[0] == 0x01 && mac[1] == 0x80 && mac[2] == 0xc2 && mac[3] == 0 && mac[4] == 0 && mac[5] <= 0x0f
+}
@@ -0,0 +1,202 @@
+package bridge
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+)
+
+var (
+	hostA = common.MACAddress{0x02, 0, 0, 0, 0xa0, 0x01}
+	hostB = common.MACAddress{0x02, 0, 0, 0, 0xb0, 0x01}
+)
+
+// clock is a fake time source.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// testBridge returns a bridge on c, its timers driven by the test.
+func testBridge(t *testing.T, c *clock, config Config) *Bridge {
+	t.Helper()
+	b, err := newBridge(config, c.Now)
+	if err != nil {
+		t.Fatalf("newBridge() error = %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// addHost adds a port to b and returns the endpoint of a host on it.
+func addHost(t *testing.T, b *Bridge) (*memory.Endpoint, int) {
+	t.Helper()
+	bridgeSide, host := memory.NewPipe(memory.Config{})
+	n, err := b.AddPort(bridgeSide)
+	if err != nil {
+		t.Fatalf("AddPort() error = %v", err)
+	}
+	return host, n
+}
+
+// expectFrame reads a frame on host and checks its source.
+func expectFrame(t *testing.T, host *memory.Endpoint, src common.MACAddress) {
+	t.Helper()
+	data, err := host.ReadPacketTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("no frame from %s: %v", src, err)
+	}
+	frame, err := ethernet.Parse(data)
+	if err != nil {
+		t.Fatalf("ethernet.Parse() error = %v", err)
+	}
+	if frame.Source != src {
+		t.Fatalf("frame from %s, want from %s", frame.Source, src)
+	}
+}
+
+// expectNoFrame checks that host receives nothing.
+func expectNoFrame(t *testing.T, host *memory.Endpoint) {
+	t.Helper()
+	if data, err := host.ReadPacketTimeout(50 * time.Millisecond); err == nil {
+		frame, _ := ethernet.Parse(data)
+		t.Fatalf("unexpected frame %s", frame)
+	}
+}
+
+func TestBridgeLearning(t *testing.T) {
+	c := &clock{now: time.Unix(1000, 0)}
+	b := testBridge(t, c, Config{})
+	h1, p1 := addHost(t, b)
+	h2, _ := addHost(t, b)
+	h3, _ := addHost(t, b)
+
+	// An unknown destination is flooded to every other port
+	h1.WriteFrame(ethernet.NewFrame(hostB, hostA, common.EtherTypeIPv4, []byte{0x45}))
+	expectFrame(t, h2, hostA)
+	expectFrame(t, h3, hostA)
+
+	// The reply goes only to the port hostA was learned on
+	h2.WriteFrame(ethernet.NewFrame(hostA, hostB, common.EtherTypeIPv4, []byte{0x45}))
+	expectFrame(t, h1, hostB)
+	expectNoFrame(t, h3)
+
+	entries := b.FDB()
+	if len(entries) != 2 || entries[0].MAC != hostA || entries[0].Port != p1 {
+		t.Errorf("FDB() = %+v, want hostA on port %d and hostB", entries, p1)
+	}
+	if s := b.Stats(); s.Flooded != 1 || s.Forwarded != 1 {
+		t.Errorf("Stats() = %+v, want 1 flooded and 1 forwarded", s)
+	}
+
+	// Frames for a station behind the port they came from are filtered
+	h1.WriteFrame(ethernet.NewFrame(hostA, common.MACAddress{0x02, 0, 0, 0, 0xa0, 0x02}, common.EtherTypeIPv4, []byte{0x45}))
+	expectNoFrame(t, h2)
+
+	// Entries age out
+	c.Advance(DefaultAgeingTime + time.Second)
+	b.tick()
+	if entries := b.FDB(); len(entries) != 0 {
+		t.Errorf("FDB() after ageing = %+v, want empty", entries)
+	}
+}
+
+func TestBridgeLocalDelivery(t *testing.T) {
+	c := &clock{now: time.Unix(1000, 0)}
+	mac := common.MACAddress{0x02, 0, 0, 0, 0, 0xbb}
+	b := testBridge(t, c, Config{MAC: mac})
+	h1, _ := addHost(t, b)
+	h2, _ := addHost(t, b)
+
+	// Broadcasts reach the bridge and the other ports
+	h1.WriteFrame(ethernet.NewFrame(common.BroadcastMAC, hostA, common.EtherTypeARP, make([]byte, 28)))
+	expectFrame(t, h2, hostA)
+	frame, err := b.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame() error = %v", err)
+	}
+	if frame.Source != hostA {
+		t.Errorf("ReadFrame() source = %s, want %s", frame.Source, hostA)
+	}
+
+	// Frames for the bridge stop there
+	h1.WriteFrame(ethernet.NewFrame(mac, hostA, common.EtherTypeIPv4, []byte{0x45}))
+	if frame, err := b.ReadFrame(); err != nil || frame.Destination != mac {
+		t.Errorf("ReadFrame() = %v, %v, want a frame for %s", frame, err, mac)
+	}
+	expectNoFrame(t, h2)
+
+	// The bridge's own frames go to the learned port
+	if err := b.WriteFrame(ethernet.NewFrame(hostA, mac, common.EtherTypeIPv4, []byte{0x45})); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	expectFrame(t, h1, mac)
+	expectNoFrame(t, h2)
+}
+
+func TestBridgeDropsReserved(t *testing.T) {
+	c := &clock{now: time.Unix(1000, 0)}
+	b := testBridge(t, c, Config{})
+	h1, _ := addHost(t, b)
+	h2, _ := addHost(t, b)
+
+	lldp := common.MACAddress{0x01, 0x80, 0xc2, 0, 0, 0x0e}
+	h1.WriteFrame(ethernet.NewFrame(lldp, hostA, common.EtherTypeLLDP, nil))
+	h1.WriteFrame(bpduFrame(hostA, &BPDU{Type: BPDUTCN}))
+	expectNoFrame(t, h2)
+}
+
+func TestBridgePortFailure(t *testing.T) {
+	c := &clock{now: time.Unix(1000, 0)}
+	b := testBridge(t, c, Config{})
+	h1, p1 := addHost(t, b)
+	h2, _ := addHost(t, b)
+
+	h1.WriteFrame(ethernet.NewFrame(hostB, hostA, common.EtherTypeIPv4, []byte{0x45}))
+	expectFrame(t, h2, hostA)
+	h1.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for b.Ports()[p1-1].State != PortDisabled {
+		if time.Now().After(deadline) {
+			t.Fatal("port not disabled after its device failed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if entries := b.FDB(); len(entries) != 0 {
+		t.Errorf("FDB() = %+v, want entries of the failed port removed", entries)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"multicast address", Config{MAC: common.MACAddress{0x01, 0, 0, 0, 0, 1}}},
+		{"negative timer", Config{ForwardDelay: -time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); err == nil {
+				t.Error("New() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// Entry is a forwarding database entry: the port a MAC address is behind.
+type Entry struct {
+	MAC     common.MACAddress
+	Port    int       // Port number
+	Updated time.Time // When the entry was learned or last refreshed
+}
+
+// fdb is the forwarding database of a bridge.
+type fdb struct {
+	mu      sync.RWMutex
+	entries map[common.MACAddress]*Entry
+}
+
+func newFDB() *fdb {
+	return &fdb{entries: make(map[common.MACAddress]*Entry)}
+}
+
+// learn records that mac is behind port.
+func (f *fdb) learn(mac common.MACAddress, port int, now time.Time) {
+	f.mu.RLock()
+	e, ok := f.entries[mac]
+	fresh := ok && e.Port == port && now.Sub(e.Updated) < time.Second
+	f.mu.RUnlock()
+	if fresh {
+		return // Spare the write lock for a stream of frames
+	}
+
+	f.mu.Lock()
+	f.entries[mac] = &Entry{MAC: mac, Port: port, Updated: now}
+	f.mu.Unlock()
+}
+
+// lookup returns the port mac is behind, or 0 if unknown.
+func (f *fdb) lookup(mac common.MACAddress) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if e, ok := f.entries[mac]; ok {
+		return e.Port
+	}
+	return 0
+}
+
+// expire removes the entries not refreshed since before.
+func (f *fdb) expire(before time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for mac, e := range f.entries {
+		if e.Updated.Before(before) {
+			delete(f.entries, mac)
+		}
+	}
+}
+
+// flush removes the entries of a port, or every entry for port 0.
+func (f *fdb) flush(port int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for mac, e := range f.entries {
+		if port == 0 || e.Port == port {
+			delete(f.entries, mac)
+		}
+	}
+}
+
+// list returns copies of the entries, sorted by MAC address.
+func (f *fdb) list() []Entry {
+	f.mu.RLock()
+	entries := make([]Entry, 0, len(f.entries))
+	for _, e := range f.entries {
+		entries = append(entries, *e)
+	}
+	f.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].MAC[:], entries[j].MAC[:]) < 0
+	})
+	return entries
+}
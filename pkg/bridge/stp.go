@@ -0,0 +1,373 @@
+package bridge
+
+import (
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// messageAgeIncrement is added to the age of the root's information each
+// time a bridge relays it.
+const messageAgeIncrement = time.Second
+
+// vector is a spanning tree priority vector: the root a bridge port
+// offers, its cost, and the bridge and port offering it. The lowest
+// vector is the best.
+type vector struct {
+	root   BridgeID
+	cost   uint32
+	bridge BridgeID
+	port   uint16
+}
+
+// compare returns a negative number if v is better than w, a positive one
+// if it is worse, and 0 if they are equal.
+func (v vector) compare(w vector) int {
+	switch {
+	case v.root != w.root:
+		return cmp64(v.root.Uint64(), w.root.Uint64())
+	case v.cost != w.cost:
+		return cmp64(uint64(v.cost), uint64(w.cost))
+	case v.bridge != w.bridge:
+		return cmp64(v.bridge.Uint64(), w.bridge.Uint64())
+	default:
+		return cmp64(uint64(v.port), uint64(w.port))
+	}
+}
+
+func cmp64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// portSTP is the spanning tree state of a port.
+type portSTP struct {
+	// designated is the best information for the port's segment: that of
+	// the bridge designated for it if received, or the bridge's own.
+	designated vector
+	received   bool
+
+	// Times of the received information
+	messageAge   time.Duration
+	maxAge       time.Duration
+	helloTime    time.Duration
+	forwardDelay time.Duration
+	infoExpires  time.Time
+
+	forwardAt  time.Time // When a listening or learning port moves on
+	tcaPending bool      // The next BPDU acknowledges a topology change
+}
+
+// bridgeSTP is the spanning tree state of a bridge (IEEE 802.1D-1998
+// Section 8). The bridge's lock guards it.
+type bridgeSTP struct {
+	b        *Bridge
+	root     BridgeID
+	cost     uint32 // Root path cost
+	rootPort *port  // nil if the bridge is the root
+
+	// Timers in use: the root's, learned from the root port
+	maxAge       time.Duration
+	helloTime    time.Duration
+	forwardDelay time.Duration
+
+	nextHello  time.Time // As the root
+	tcUntil    time.Time // As the root, topology changes are flagged until
+	tcFlag     bool      // The root flags a topology change
+	tcnPending bool      // Notifying the root of a topology change
+	nextTCN    time.Time
+}
+
+func (s *bridgeSTP) init(b *Bridge) {
+	s.b = b
+	s.root = b.id
+	s.maxAge = b.config.MaxAge
+	s.helloTime = b.config.HelloTime
+	s.forwardDelay = b.config.ForwardDelay
+}
+
+// enable brings a new port into the tree.
+func (s *bridgeSTP) enable(p *port) {
+	p.state = PortBlocking
+	s.update()
+	if p.role == RoleDesignated {
+		s.sendConfig(p, s.b.now())
+	}
+}
+
+// disable takes a failed port out of the tree.
+func (s *bridgeSTP) disable(p *port) {
+	p.stp = portSTP{}
+	s.update()
+}
+
+// update selects the root port and the roles of the other ports from the
+// information received on them (IEEE 802.1D-1998 Sections 8.6.8 and
+// 8.6.9).
+func (s *bridgeSTP) update() {
+	now := s.b.now()
+	wasRoot := s.rootPort == nil
+
+	s.root, s.cost, s.rootPort = s.b.id, 0, nil
+	var best vector
+	for _, p := range s.b.ports {
+		v := p.stp.designated
+		if p.state == PortDisabled || !p.stp.received || v.bridge == s.b.id || v.root.Uint64() >= s.b.id.Uint64() {
+			continue
+		}
+		c := vector{root: v.root, cost: v.cost + p.cost, bridge: v.bridge, port: v.port}
+		if s.rootPort == nil || c.compare(best) < 0 || (c == best && p.id < s.rootPort.id) {
+			best, s.rootPort = c, p
+		}
+	}
+	if s.rootPort != nil {
+		s.root, s.cost = best.root, best.cost
+		s.maxAge = s.rootPort.stp.maxAge
+		s.helloTime = s.rootPort.stp.helloTime
+		s.forwardDelay = s.rootPort.stp.forwardDelay
+	} else {
+		s.maxAge = s.b.config.MaxAge
+		s.helloTime = s.b.config.HelloTime
+		s.forwardDelay = s.b.config.ForwardDelay
+	}
+
+	for _, p := range s.b.ports {
+		if p.state == PortDisabled {
+			continue
+		}
+		ours := vector{root: s.root, cost: s.cost, bridge: s.b.id, port: p.id}
+		role := RoleAlternate
+		switch {
+		case p == s.rootPort:
+			role = RoleRoot
+		case !p.stp.received || ours.compare(p.stp.designated) <= 0:
+			role = RoleDesignated
+			p.stp.designated, p.stp.received = ours, false
+		}
+		s.setRole(p, role, now)
+	}
+
+	if !wasRoot && s.rootPort == nil {
+		// Claim the root, as no better bridge is heard from any more
+		s.tcnPending = false
+		s.sendConfigs(now)
+		s.nextHello = now.Add(s.helloTime)
+	}
+}
+
+// setRole assigns a role to a port, and moves it towards the state the
+// role calls for.
+func (s *bridgeSTP) setRole(p *port, role PortRole, now time.Time) {
+	p.role = role
+	switch role {
+	case RoleRoot, RoleDesignated:
+		if p.state == PortBlocking {
+			p.state = PortListening
+			p.stp.forwardAt = now.Add(s.forwardDelay)
+		}
+	case RoleAlternate:
+		if p.state != PortBlocking {
+			wasForwarding := p.state == PortForwarding
+			p.state = PortBlocking
+			s.b.fdb.flush(p.number)
+			if wasForwarding {
+				s.topologyChangeDetected(now)
+			}
+		}
+	}
+}
+
+// receiveBPDU processes a frame sent to STPAddress.
+func (b *Bridge) receiveBPDU(p *port, frame *ethernet.Frame) {
+	bpdu, err := parseBPDUFrame(frame)
+	if err != nil {
+		return
+	}
+	b.bpdusIn.Add(1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p.state == PortDisabled {
+		return
+	}
+	now := b.now()
+	switch bpdu.Type {
+	case BPDUTCN:
+		b.stp.receiveTCN(p, now)
+	case BPDUConfig, BPDURST:
+		b.stp.receiveConfig(p, bpdu, now)
+	}
+}
+
+// receiveConfig processes a configuration BPDU (IEEE 802.1D-1998 Section
+// 8.7.1).
+func (s *bridgeSTP) receiveConfig(p *port, bpdu *BPDU, now time.Time) {
+	if bpdu.MessageAge >= bpdu.MaxAge {
+		return // Too old to trust
+	}
+	v := vector{root: bpdu.RootID, cost: bpdu.RootPathCost, bridge: bpdu.BridgeID, port: bpdu.PortID}
+	fromDesignated := p.stp.received && v.bridge == p.stp.designated.bridge && v.port == p.stp.designated.port
+	if v.compare(p.stp.designated) >= 0 && !fromDesignated {
+		// Inferior information: assert ours on the segment
+		if p.role == RoleDesignated {
+			s.sendConfig(p, now)
+		}
+		return
+	}
+
+	p.stp.designated, p.stp.received = v, true
+	p.stp.messageAge = bpdu.MessageAge
+	p.stp.maxAge = bpdu.MaxAge
+	p.stp.helloTime = bpdu.HelloTime
+	p.stp.forwardDelay = bpdu.ForwardDelay
+	p.stp.infoExpires = now.Add(bpdu.MaxAge - bpdu.MessageAge)
+	s.update()
+
+	if p == s.rootPort {
+		s.tcFlag = bpdu.Flags&FlagTopologyChange != 0
+		if bpdu.Flags&FlagTopologyChangeAck != 0 {
+			s.tcnPending = false
+		}
+		s.sendConfigs(now) // Relay the root's information
+	}
+}
+
+// receiveTCN processes a topology change notification (IEEE 802.1D-1998
+// Section 8.7.2).
+func (s *bridgeSTP) receiveTCN(p *port, now time.Time) {
+	if p.role != RoleDesignated {
+		return
+	}
+	p.stp.tcaPending = true
+	s.topologyChangeDetected(now)
+	s.sendConfig(p, now)
+}
+
+// topologyChangeDetected starts flagging a topology change as the root,
+// or notifies the root of it.
+func (s *bridgeSTP) topologyChangeDetected(now time.Time) {
+	if s.rootPort == nil {
+		s.tcUntil = now.Add(s.maxAge + s.forwardDelay)
+		return
+	}
+	if !s.tcnPending {
+		s.tcnPending = true
+		s.sendTCN(now)
+	}
+}
+
+// topologyChange reports whether a topology change is in progress, in
+// which learned entries age out after the forward delay.
+func (s *bridgeSTP) topologyChange(now time.Time) bool {
+	if s.rootPort == nil {
+		return now.Before(s.tcUntil)
+	}
+	return s.tcFlag
+}
+
+// tick runs the spanning tree timers.
+func (s *bridgeSTP) tick(now time.Time) {
+	expired := false
+	for _, p := range s.b.ports {
+		if p.state == PortDisabled {
+			continue
+		}
+		if p.stp.received && !now.Before(p.stp.infoExpires) {
+			p.stp.received = false // The designated bridge went quiet
+			expired = true
+		}
+		if (p.state == PortListening || p.state == PortLearning) && !now.Before(p.stp.forwardAt) {
+			if p.state == PortListening {
+				p.state = PortLearning
+				p.stp.forwardAt = now.Add(s.forwardDelay)
+				continue
+			}
+			p.state = PortForwarding
+			if s.hasDesignatedPort() {
+				s.topologyChangeDetected(now)
+			}
+		}
+	}
+	if expired {
+		s.update()
+	}
+
+	if s.rootPort == nil && !now.Before(s.nextHello) {
+		s.sendConfigs(now)
+		s.nextHello = now.Add(s.helloTime)
+	}
+	if s.tcnPending && !now.Before(s.nextTCN) {
+		s.sendTCN(now)
+	}
+}
+
+// hasDesignatedPort reports whether the bridge serves any segment.
+func (s *bridgeSTP) hasDesignatedPort() bool {
+	for _, p := range s.b.ports {
+		if p.role == RoleDesignated {
+			return true
+		}
+	}
+	return false
+}
+
+// sendConfigs sends a configuration BPDU on each designated port.
+func (s *bridgeSTP) sendConfigs(now time.Time) {
+	for _, p := range s.b.ports {
+		if p.state != PortDisabled && p.role == RoleDesignated {
+			s.sendConfig(p, now)
+		}
+	}
+}
+
+// sendConfig sends a configuration BPDU on a port.
+func (s *bridgeSTP) sendConfig(p *port, now time.Time) {
+	var age time.Duration
+	if s.rootPort != nil {
+		age = s.rootPort.stp.messageAge + messageAgeIncrement
+		if age >= s.maxAge {
+			return
+		}
+	}
+	var flags uint8
+	if s.topologyChange(now) {
+		flags |= FlagTopologyChange
+	}
+	if p.stp.tcaPending {
+		flags |= FlagTopologyChangeAck
+		p.stp.tcaPending = false
+	}
+	s.send(p, &BPDU{
+		Version:      VersionSTP,
+		Type:         BPDUConfig,
+		Flags:        flags,
+		RootID:       s.root,
+		RootPathCost: s.cost,
+		BridgeID:     s.b.id,
+		PortID:       p.id,
+		MessageAge:   age,
+		MaxAge:       s.maxAge,
+		HelloTime:    s.helloTime,
+		ForwardDelay: s.forwardDelay,
+	})
+}
+
+// sendTCN notifies the designated bridge of the root port's segment of a
+// topology change, and repeats it each hello time until acknowledged.
+func (s *bridgeSTP) sendTCN(now time.Time) {
+	s.nextTCN = now.Add(s.helloTime)
+	if s.rootPort != nil {
+		s.send(s.rootPort, &BPDU{Version: VersionSTP, Type: BPDUTCN})
+	}
+}
+
+func (s *bridgeSTP) send(p *port, bpdu *BPDU) {
+	if err := p.dev.WriteFrame(bpduFrame(p.dev.MACAddress(), bpdu)); err == nil {
+		s.b.bpdusOut.Add(1)
+	}
+}
@@ -0,0 +1,199 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+)
+
+// triangle is three STP bridges linked in a loop, with a host on B and C:
+//
+//	A.1 - B.1, A.2 - C.1, B.2 - C.2, host on B.3 and C.3
+//
+// A has the lowest priority, so it becomes the root, and C's port towards
+// B closes the loop.
+type triangle struct {
+	t       *testing.T
+	clock   *clock
+	a, b, c *Bridge
+	ac      *memory.Endpoint // A's end of the A-C link
+	hostB   *memory.Endpoint
+	hostC   *memory.Endpoint
+}
+
+func newTriangle(t *testing.T) *triangle {
+	tr := &triangle{t: t, clock: &clock{now: time.Unix(1000, 0)}}
+	tr.a = testBridge(t, tr.clock, Config{STP: true, Priority: 0x1000, MAC: common.MACAddress{0x02, 0, 0, 0, 0, 0x0a}})
+	tr.b = testBridge(t, tr.clock, Config{STP: true, MAC: common.MACAddress{0x02, 0, 0, 0, 0, 0x0b}})
+	tr.c = testBridge(t, tr.clock, Config{STP: true, MAC: common.MACAddress{0x02, 0, 0, 0, 0, 0x0c}})
+
+	link := func(x, y *Bridge) *memory.Endpoint {
+		ex, ey := memory.NewPipe(memory.Config{})
+		if _, err := x.AddPort(ex); err != nil {
+			t.Fatalf("AddPort() error = %v", err)
+		}
+		if _, err := y.AddPort(ey); err != nil {
+			t.Fatalf("AddPort() error = %v", err)
+		}
+		return ex
+	}
+	link(tr.a, tr.b)
+	tr.ac = link(tr.a, tr.c)
+	link(tr.b, tr.c)
+	tr.hostB, _ = addHost(t, tr.b)
+	tr.hostC, _ = addHost(t, tr.c)
+	return tr
+}
+
+// settle waits for the BPDUs in flight to be processed.
+func (tr *triangle) settle() {
+	received := func() uint64 {
+		return tr.a.Stats().BPDUsIn + tr.b.Stats().BPDUsIn + tr.c.Stats().BPDUsIn
+	}
+	last := received()
+	for i := 0; i < 100; i++ {
+		time.Sleep(5 * time.Millisecond)
+		n := received()
+		if n == last {
+			return
+		}
+		last = n
+	}
+}
+
+// run advances the clock by d, running the bridges' timers.
+func (tr *triangle) run(d time.Duration) {
+	tr.settle()
+	for elapsed := time.Duration(0); elapsed < d; elapsed += tickInterval {
+		tr.clock.Advance(tickInterval)
+		for _, b := range []*Bridge{tr.a, tr.b, tr.c} {
+			b.tick()
+		}
+		tr.settle()
+	}
+}
+
+// expectPort checks the role and state of a port.
+func (tr *triangle) expectPort(b *Bridge, number int, role PortRole, state PortState) {
+	tr.t.Helper()
+	p := b.Ports()[number-1]
+	if p.Role != role || p.State != state {
+		tr.t.Errorf("%s port %d = %s %s, want %s %s", b.ID(), number, p.Role, p.State, role, state)
+	}
+}
+
+// dataFrames returns the frames other than BPDUs host receives.
+func dataFrames(host *memory.Endpoint) []*ethernet.Frame {
+	var frames []*ethernet.Frame
+	for {
+		data, err := host.ReadPacketTimeout(50 * time.Millisecond)
+		if err != nil {
+			return frames
+		}
+		if frame, err := ethernet.Parse(data); err == nil && frame.Destination != STPAddress {
+			frames = append(frames, frame)
+		}
+	}
+}
+
+func TestSTPTriangle(t *testing.T) {
+	tr := newTriangle(t)
+	tr.run(2*DefaultForwardDelay + time.Second)
+
+	for _, b := range []*Bridge{tr.a, tr.b, tr.c} {
+		if b.Root() != tr.a.ID() {
+			t.Errorf("%s Root() = %s, want %s", b.ID(), b.Root(), tr.a.ID())
+		}
+	}
+	tr.expectPort(tr.a, 1, RoleDesignated, PortForwarding)
+	tr.expectPort(tr.a, 2, RoleDesignated, PortForwarding)
+	tr.expectPort(tr.b, 1, RoleRoot, PortForwarding)
+	tr.expectPort(tr.b, 2, RoleDesignated, PortForwarding)
+	tr.expectPort(tr.c, 1, RoleRoot, PortForwarding)
+	tr.expectPort(tr.c, 2, RoleAlternate, PortBlocking)
+
+	// A broadcast arrives once, rather than circling the loop
+	dataFrames(tr.hostC)
+	tr.hostB.WriteFrame(ethernet.NewFrame(common.BroadcastMAC, hostB, common.EtherTypeARP, make([]byte, 28)))
+	if frames := dataFrames(tr.hostC); len(frames) != 1 {
+		t.Errorf("host on C received %d copies of a broadcast, want 1", len(frames))
+	}
+}
+
+func TestSTPLinkFailure(t *testing.T) {
+	tr := newTriangle(t)
+	tr.run(2*DefaultForwardDelay + time.Second)
+
+	// With the A-C link down, C reaches the root through B
+	tr.ac.Close()
+	tr.run(time.Second)
+	tr.expectPort(tr.c, 1, RoleDisabled, PortDisabled)
+	tr.expectPort(tr.c, 2, RoleRoot, PortListening)
+
+	tr.run(2 * DefaultForwardDelay)
+	tr.expectPort(tr.c, 2, RoleRoot, PortForwarding)
+	if tr.c.Root() != tr.a.ID() {
+		t.Errorf("C Root() = %s, want %s", tr.c.Root(), tr.a.ID())
+	}
+
+	dataFrames(tr.hostC)
+	tr.hostB.WriteFrame(ethernet.NewFrame(common.BroadcastMAC, hostB, common.EtherTypeARP, make([]byte, 28)))
+	if frames := dataFrames(tr.hostC); len(frames) != 1 {
+		t.Errorf("host on C received %d copies of a broadcast, want 1", len(frames))
+	}
+}
+
+func TestSTPRootFailure(t *testing.T) {
+	tr := newTriangle(t)
+	tr.run(2*DefaultForwardDelay + time.Second)
+
+	// With A gone, B takes over as the root
+	tr.a.Close()
+	tr.run(2*DefaultForwardDelay + time.Second)
+	for _, b := range []*Bridge{tr.b, tr.c} {
+		if b.Root() != tr.b.ID() {
+			t.Errorf("%s Root() = %s, want %s", b.ID(), b.Root(), tr.b.ID())
+		}
+	}
+	tr.expectPort(tr.c, 2, RoleRoot, PortForwarding)
+}
+
+func TestSTPSilentLink(t *testing.T) {
+	tr := newTriangle(t)
+	tr.run(2*DefaultForwardDelay + time.Second)
+
+	// When A's BPDUs stop arriving over a link that stays up, C keeps
+	// their information until it ages out, then turns to B
+	tr.ac.SetConfig(memory.Config{LossRate: 1})
+	tr.run(DefaultMaxAge - 2*time.Second)
+	tr.expectPort(tr.c, 1, RoleRoot, PortForwarding)
+	tr.run(2 * time.Second)
+	tr.expectPort(tr.c, 2, RoleRoot, PortListening)
+	tr.expectPort(tr.c, 1, RoleDesignated, PortForwarding)
+}
+
+func TestVectorCompare(t *testing.T) {
+	low := BridgeID{Priority: 0x1000}
+	high := BridgeID{Priority: 0x8000}
+	tests := []struct {
+		name string
+		v, w vector
+		want int
+	}{
+		{"root", vector{root: low, cost: 100}, vector{root: high}, -1},
+		{"cost", vector{root: low, cost: 10}, vector{root: low, cost: 20}, -1},
+		{"bridge", vector{root: low, bridge: high}, vector{root: low, bridge: low}, 1},
+		{"port", vector{root: low, port: 2}, vector{root: low, port: 1}, 1},
+		{"equal", vector{root: low, port: 1}, vector{root: low, port: 1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.v.compare(tt.w); got != tt.want {
+				t.Errorf("compare() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
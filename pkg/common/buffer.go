@@ -2,10 +2,8 @@ package common
 
 import (
 	"encoding/binary"
-	"encoding/hex"
 	"fmt"
 	"io"
-	"strings"
 )
 
 // PacketBuffer provides a buffer for reading and writing network packets.
@@ -210,52 +208,8 @@ func (pb *PacketBuffer) HexDump() string {
 }
 
 // HexDump formats a byte slice as a hex dump with offsets and ASCII representation.
-// This is useful for debugging network packets.
+// This is useful for debugging network packets. HexDumpConfig configures
+// the layout.
 func HexDump(data []byte) string {
-	var sb strings.Builder
-	const bytesPerLine = 16
-
-	for i := 0; i < len(data); i += bytesPerLine {
-		// Write offset
-		sb.WriteString(fmt.Sprintf("%04x  ", i))
-
-		// Write hex bytes
-		lineEnd := i + bytesPerLine
-		if lineEnd > len(data) {
-			lineEnd = len(data)
-		}
-
-		line := data[i:lineEnd]
-		hexStr := hex.EncodeToString(line)
-
-		// Format hex with spaces every 2 characters
-		for j := 0; j < len(hexStr); j += 2 {
-			sb.WriteString(hexStr[j : j+2])
-			sb.WriteString(" ")
-			if j == 14 {
-				sb.WriteString(" ") // Extra space in the middle
-			}
-		}
-
-		// Pad if line is short
-		for j := len(line); j < bytesPerLine; j++ {
-			sb.WriteString("   ")
-			if j == 7 {
-				sb.WriteString(" ")
-			}
-		}
-
-		// Write ASCII representation
-		sb.WriteString(" |")
-		for _, b := range line {
-			if b >= 32 && b <= 126 {
-				sb.WriteByte(b)
-			} else {
-				sb.WriteByte('.')
-			}
-		}
-		sb.WriteString("|\n")
-	}
-
-	return sb.String()
+	return HexDumpConfig{}.Dump(data)
 }
@@ -14,6 +14,8 @@ type BufferPool struct {
 const (
 	SmallBufferSize  = 512    // For headers and small packets
 	MediumBufferSize = 1500   // MTU size
+	FrameBufferSize  = 2048   // Largest Ethernet frame, with VLAN tags and FCS
+	JumboBufferSize  = 9216   // Largest jumbo frame (MTU 9000), with VLAN tags and FCS
 	LargeBufferSize  = 65536  // Max IP packet size
 )
 
@@ -21,6 +23,8 @@ const (
 var (
 	SmallBufferPool  = NewBufferPool(SmallBufferSize)
 	MediumBufferPool = NewBufferPool(MediumBufferSize)
+	FrameBufferPool  = NewBufferPool(FrameBufferSize)
+	JumboBufferPool  = NewBufferPool(JumboBufferSize)
 	LargeBufferPool  = NewBufferPool(LargeBufferSize)
 )
 
@@ -62,6 +66,12 @@ func GetBuffer(size int) []byte {
 	} else if size <= MediumBufferSize {
 		buf := MediumBufferPool.Get()
 		return buf[:size]
+	} else if size <= FrameBufferSize {
+		buf := FrameBufferPool.Get()
+		return buf[:size]
+	} else if size <= JumboBufferSize {
+		buf := JumboBufferPool.Get()
+		return buf[:size]
 	} else if size <= LargeBufferSize {
 		buf := LargeBufferPool.Get()
 		return buf[:size]
@@ -81,6 +91,10 @@ func PutBuffer(buf []byte) {
 		SmallBufferPool.Put(buf[:SmallBufferSize])
 	} else if capacity == MediumBufferSize {
 		MediumBufferPool.Put(buf[:MediumBufferSize])
+	} else if capacity == FrameBufferSize {
+		FrameBufferPool.Put(buf[:FrameBufferSize])
+	} else if capacity == JumboBufferSize {
+		JumboBufferPool.Put(buf[:JumboBufferSize])
 	} else if capacity == LargeBufferSize {
 		LargeBufferPool.Put(buf[:LargeBufferSize])
 	}
@@ -45,6 +45,7 @@ func TestGlobalBufferPools(t *testing.T) {
 	}{
 		{"Small", 256},
 		{"Medium", 1024},
+		{"Jumbo", 9000},
 		{"Large", 32768},
 	}
 
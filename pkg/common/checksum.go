@@ -59,14 +59,14 @@ func UpdateChecksum(oldChecksum uint16, oldData, newData []byte) uint16 {
 	}
 
 	// Convert checksum to one's complement sum
-	sum := ^uint32(oldChecksum)
+	sum := uint32(^oldChecksum)
 
 	// Subtract old data
 	for i := 0; i < len(oldData)-1; i += 2 {
 		sum += 0xFFFF - uint32(binary.BigEndian.Uint16(oldData[i:i+2]))
 	}
 	if len(oldData)%2 == 1 {
-		sum += 0xFF00 - (uint32(oldData[len(oldData)-1]) << 8)
+		sum += 0xFFFF - (uint32(oldData[len(oldData)-1]) << 8)
 	}
 
 	// Add new data
@@ -106,14 +106,111 @@ func (ph PseudoHeader) Bytes() []byte {
 	return b
 }
 
+// PseudoHeaderIPv6 represents the pseudo-header used for TCP and UDP
+// checksum calculation over IPv6 (RFC 8200 Section 8.1): the source and
+// destination addresses, the upper-layer packet length, and the next
+// header value of the upper-layer protocol.
+type PseudoHeaderIPv6 struct {
+	SourceAddr      IPv6Address
+	DestinationAddr IPv6Address
+	Length          uint32
+	NextHeader      Protocol
+}
+
+// Bytes serializes the pseudo-header to bytes for checksum calculation.
+func (ph PseudoHeaderIPv6) Bytes() []byte {
+	b := make([]byte, 40)
+	copy(b[0:16], ph.SourceAddr[:])
+	copy(b[16:32], ph.DestinationAddr[:])
+	binary.BigEndian.PutUint32(b[32:36], ph.Length)
+	b[39] = uint8(ph.NextHeader) // Preceded by three zero bytes
+	return b
+}
+
 // CalculateChecksumWithPseudoHeader calculates checksum including pseudo-header.
 // This is used for TCP and UDP checksums.
 func CalculateChecksumWithPseudoHeader(pseudoHeader PseudoHeader, data []byte) uint16 {
-	// Combine pseudo-header and data
-	phBytes := pseudoHeader.Bytes()
-	combined := make([]byte, len(phBytes)+len(data))
-	copy(combined, phBytes)
-	copy(combined[len(phBytes):], data)
+	var c Checksummer
+	c.AddPseudoHeader(pseudoHeader)
+	c.Add(data)
+	return c.Sum()
+}
+
+// Checksummer computes the Internet checksum incrementally over several
+// pieces, such as a pseudo-header, the header fields of a segment and its
+// data, without copying them into one buffer. The zero value is ready to
+// use. The pieces are summed as if they were concatenated, so a piece may
+// have an odd length.
+type Checksummer struct {
+	sum uint64
+	odd bool // The last piece ended in the middle of a 16-bit word
+}
+
+// Add adds data to the checksum.
+func (c *Checksummer) Add(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	// Finish the word started by the last piece
+	if c.odd {
+		c.sum += uint64(data[0])
+		data = data[1:]
+		c.odd = false
+	}
+
+	// Process 16-bit words
+	for len(data) >= 2 {
+		c.sum += uint64(binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
 
-	return CalculateChecksum(combined)
+	// Start a word with the last byte
+	if len(data) == 1 {
+		c.sum += uint64(data[0]) << 8
+		c.odd = true
+	}
+}
+
+// AddUint16 adds a big-endian 16-bit field to the checksum.
+func (c *Checksummer) AddUint16(v uint16) {
+	if c.odd {
+		// The high byte finishes the current word, the low byte starts the next
+		c.sum += uint64(v>>8) + uint64(v&0xFF)<<8
+		return
+	}
+	c.sum += uint64(v)
+}
+
+// AddUint32 adds a big-endian 32-bit field to the checksum.
+func (c *Checksummer) AddUint32(v uint32) {
+	c.AddUint16(uint16(v >> 16))
+	c.AddUint16(uint16(v))
+}
+
+// AddPseudoHeader adds a TCP or UDP pseudo-header to the checksum.
+func (c *Checksummer) AddPseudoHeader(ph PseudoHeader) {
+	c.Add(ph.SourceAddr[:])
+	c.Add(ph.DestinationAddr[:])
+	c.AddUint16(uint16(ph.Protocol))
+	c.AddUint16(ph.Length)
+}
+
+// AddPseudoHeaderIPv6 adds an IPv6 TCP or UDP pseudo-header to the
+// checksum.
+func (c *Checksummer) AddPseudoHeaderIPv6(ph PseudoHeaderIPv6) {
+	c.Add(ph.SourceAddr[:])
+	c.Add(ph.DestinationAddr[:])
+	c.AddUint32(ph.Length)
+	c.AddUint32(uint32(ph.NextHeader)) // Three zero bytes, then the next header
+}
+
+// Sum returns the checksum of everything added so far: the one's complement
+// of the folded one's complement sum, as CalculateChecksum returns.
+func (c *Checksummer) Sum() uint16 {
+	sum := c.sum
+	for sum > 0xFFFF {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	return ^uint16(sum)
 }
@@ -0,0 +1,25 @@
+package common
+
+import "golang.org/x/sys/cpu"
+
+func init() {
+	// SSE2 is part of amd64; AVX2 also needs the OS to save the YMM
+	// registers, which cpu checks
+	if cpu.X86.HasAVX2 {
+		checksumKernel, checksumKernelName = checksumAVX2, "avx2"
+	} else {
+		checksumKernel, checksumKernelName = checksumSSE2, "sse2"
+	}
+}
+
+// checksumAVX2 sums data, a multiple of 64 bytes long, as little-endian
+// 32-bit words, zero-extending them into four 256-bit accumulators of
+// 64-bit lanes, which cannot overflow.
+//
+//go:noescape
+func checksumAVX2(data []byte) uint64
+
+// checksumSSE2 is checksumAVX2 with 128-bit accumulators.
+//
+//go:noescape
+func checksumSSE2(data []byte) uint64
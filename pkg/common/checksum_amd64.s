@@ -1,185 +1,96 @@
-// +build amd64
-
 #include "textflag.h"
 
 // func checksumAVX2(data []byte) uint64
 TEXT ·checksumAVX2(SB), NOSPLIT, $0-32
-    MOVQ data+0(FP), SI      // SI = data pointer
-    MOVQ data+8(FP), CX      // CX = length
-    XORQ AX, AX              // AX = sum (accumulator)
-
-    // Check if we have at least 32 bytes
-    CMPQ CX, $32
-    JL   scalar_checksum
+	MOVQ data_base+0(FP), SI
+	MOVQ data_len+8(FP), CX
+	SHRQ $6, CX              // CX = 64-byte blocks
 
-    // Zero out AVX2 accumulator
-    VPXOR Y0, Y0, Y0         // Y0 = 0 (main accumulator)
-    VPXOR Y1, Y1, Y1         // Y1 = 0 (secondary accumulator)
+	VPXOR Y0, Y0, Y0
+	VPXOR Y1, Y1, Y1
+	VPXOR Y2, Y2, Y2
+	VPXOR Y3, Y3, Y3
+	TESTQ CX, CX
+	JZ    avx2_fold
 
 avx2_loop:
-    CMPQ CX, $32
-    JL   avx2_remainder
-
-    // Load 32 bytes
-    VMOVDQU (SI), Y2
-
-    // Unpack bytes to words and accumulate
-    VPXOR Y3, Y3, Y3
-    VPUNPCKLBW Y3, Y2, Y4    // Low 16 bytes -> 16 words
-    VPUNPCKHBW Y3, Y2, Y5    // High 16 bytes -> 16 words
-
-    VPADDW Y4, Y0, Y0        // Add to accumulator
-    VPADDW Y5, Y1, Y1
-
-    ADDQ $32, SI
-    SUBQ $32, CX
-    JMP  avx2_loop
-
-avx2_remainder:
-    // Combine Y0 and Y1
-    VPADDW Y1, Y0, Y0
-
-    // Horizontal sum of Y0
-    VEXTRACTI128 $1, Y0, X1  // Extract high 128 bits
-    VPADDW X1, X0, X0        // Add high and low
-
-    // Sum 8 words in X0
-    VPSRLDQ $8, X0, X1
-    VPADDW X1, X0, X0
-    VPSRLDQ $4, X0, X1
-    VPADDW X1, X0, X0
-    VPSRLDQ $2, X0, X1
-    VPADDW X1, X0, X0
-
-    // Extract result to AX
-    VMOVQ X0, AX
-    ANDQ $0xFFFF, AX
-
-    // Process remaining bytes with scalar code
-    JMP scalar_checksum
-
-scalar_checksum:
-    // Process remaining bytes in 2-byte chunks
-    XORQ DX, DX              // DX = temp register
-
-scalar_loop:
-    CMPQ CX, $2
-    JL   last_byte
-
-    MOVWLZX (SI), DX         // Load 2 bytes
-    ADDQ DX, AX
-    ADDQ $2, SI
-    SUBQ $2, CX
-    JMP  scalar_loop
-
-last_byte:
-    CMPQ CX, $1
-    JNE  done
-
-    MOVBLZX (SI), DX         // Load 1 byte
-    SHLQ $8, DX              // Shift to high byte
-    ADDQ DX, AX
-
-done:
-    MOVQ AX, ret+24(FP)
-    RET
+	// Zero-extend each 16 bytes to four 64-bit lanes and accumulate
+	VPMOVZXDQ 0(SI), Y4
+	VPMOVZXDQ 16(SI), Y5
+	VPMOVZXDQ 32(SI), Y6
+	VPMOVZXDQ 48(SI), Y7
+	VPADDQ    Y4, Y0, Y0
+	VPADDQ    Y5, Y1, Y1
+	VPADDQ    Y6, Y2, Y2
+	VPADDQ    Y7, Y3, Y3
+	ADDQ      $64, SI
+	DECQ      CX
+	JNZ       avx2_loop
+
+avx2_fold:
+	// Add the accumulators, then their lanes
+	VPADDQ       Y1, Y0, Y0
+	VPADDQ       Y3, Y2, Y2
+	VPADDQ       Y2, Y0, Y0
+	VEXTRACTI128 $1, Y0, X1
+	VPADDQ       X1, X0, X0
+	VPSHUFD      $0x4e, X0, X1
+	VPADDQ       X1, X0, X0
+	VMOVQ        X0, AX
+	VZEROUPPER
+	MOVQ         AX, ret+24(FP)
+	RET
 
 // func checksumSSE2(data []byte) uint64
 TEXT ·checksumSSE2(SB), NOSPLIT, $0-32
-    MOVQ data+0(FP), SI      // SI = data pointer
-    MOVQ data+8(FP), CX      // CX = length
-    XORQ AX, AX              // AX = sum
-
-    // Check if we have at least 16 bytes
-    CMPQ CX, $16
-    JL   sse2_scalar
-
-    // Zero out SSE2 accumulator
-    PXOR X0, X0              // X0 = main accumulator
-    PXOR X1, X1              // X1 = secondary accumulator
+	MOVQ data_base+0(FP), SI
+	MOVQ data_len+8(FP), CX
+	SHRQ $6, CX              // CX = 64-byte blocks
+
+	PXOR  X0, X0
+	PXOR  X1, X1
+	PXOR  X2, X2
+	PXOR  X3, X3
+	PXOR  X8, X8             // X8 = 0, for unpacking
+	TESTQ CX, CX
+	JZ    sse2_fold
 
 sse2_loop:
-    CMPQ CX, $16
-    JL   sse2_remainder
-
-    // Load 16 bytes
-    MOVOU (SI), X2
-
-    // Unpack and accumulate
-    PXOR X3, X3
-    MOVOA X2, X4
-    PUNPCKLBW X3, X4         // Low 8 bytes -> 8 words
-    PUNPCKHBW X3, X2         // High 8 bytes -> 8 words
-
-    PADDW X4, X0
-    PADDW X2, X1
-
-    ADDQ $16, SI
-    SUBQ $16, CX
-    JMP  sse2_loop
-
-sse2_remainder:
-    // Combine accumulators
-    PADDW X1, X0
-
-    // Horizontal sum
-    MOVOA X0, X1
-    PSRLDQ $8, X1
-    PADDW X1, X0
-    MOVOA X0, X1
-    PSRLDQ $4, X1
-    PADDW X1, X0
-    MOVOA X0, X1
-    PSRLDQ $2, X1
-    PADDW X1, X0
-
-    // Extract result
-    MOVQ X0, AX
-    ANDQ $0xFFFF, AX
-
-sse2_scalar:
-    // Process remaining bytes
-    XORQ DX, DX
-
-sse2_scalar_loop:
-    CMPQ CX, $2
-    JL   sse2_last_byte
-
-    MOVWLZX (SI), DX
-    ADDQ DX, AX
-    ADDQ $2, SI
-    SUBQ $2, CX
-    JMP  sse2_scalar_loop
-
-sse2_last_byte:
-    CMPQ CX, $1
-    JNE  sse2_done
-
-    MOVBLZX (SI), DX
-    SHLQ $8, DX
-    ADDQ DX, AX
-
-sse2_done:
-    MOVQ AX, ret+24(FP)
-    RET
-
-// func hasAVX2() bool
-TEXT ·hasAVX2(SB), NOSPLIT, $0-1
-    // Check CPUID for AVX2 support
-    MOVQ $7, AX
-    MOVQ $0, CX
-    CPUID
-    SHRQ $5, BX              // Bit 5 of EBX indicates AVX2
-    ANDQ $1, BX
-    MOVB BX, ret+0(FP)
-    RET
-
-// func hasSSE2() bool
-TEXT ·hasSSE2(SB), NOSPLIT, $0-1
-    // Check CPUID for SSE2 support
-    MOVQ $1, AX
-    CPUID
-    SHRQ $26, DX             // Bit 26 of EDX indicates SSE2
-    ANDQ $1, DX
-    MOVB DX, ret+0(FP)
-    RET
+	// Interleave each 32-bit word with zero into a 64-bit lane
+	MOVOU     0(SI), X4
+	MOVOU     16(SI), X5
+	MOVOU     32(SI), X6
+	MOVOU     48(SI), X7
+	MOVOA     X4, X9
+	PUNPCKLLQ X8, X4
+	PUNPCKHLQ X8, X9
+	PADDQ     X4, X0
+	PADDQ     X9, X1
+	MOVOA     X5, X9
+	PUNPCKLLQ X8, X5
+	PUNPCKHLQ X8, X9
+	PADDQ     X5, X2
+	PADDQ     X9, X3
+	MOVOA     X6, X9
+	PUNPCKLLQ X8, X6
+	PUNPCKHLQ X8, X9
+	PADDQ     X6, X0
+	PADDQ     X9, X1
+	MOVOA     X7, X9
+	PUNPCKLLQ X8, X7
+	PUNPCKHLQ X8, X9
+	PADDQ     X7, X2
+	PADDQ     X9, X3
+	ADDQ      $64, SI
+	DECQ      CX
+	JNZ       sse2_loop
+
+sse2_fold:
+	PADDQ  X1, X0
+	PADDQ  X3, X2
+	PADDQ  X2, X0
+	PSHUFD $0x4e, X0, X1
+	PADDQ  X1, X0
+	MOVQ   X0, AX
+	MOVQ   AX, ret+24(FP)
+	RET
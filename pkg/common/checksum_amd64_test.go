@@ -0,0 +1,12 @@
+package common
+
+import "golang.org/x/sys/cpu"
+
+// checksumKernels returns the kernels the CPU can run.
+func checksumKernels() map[string]func([]byte) uint64 {
+	kernels := map[string]func([]byte) uint64{"sse2": checksumSSE2}
+	if cpu.X86.HasAVX2 {
+		kernels["avx2"] = checksumAVX2
+	}
+	return kernels
+}
@@ -0,0 +1,16 @@
+package common
+
+import "golang.org/x/sys/cpu"
+
+func init() {
+	if cpu.ARM64.HasASIMD {
+		checksumKernel, checksumKernelName = checksumNEON, "neon"
+	}
+}
+
+// checksumNEON sums data, a multiple of 64 bytes long, as little-endian
+// 32-bit words, widening them into four accumulators of 64-bit lanes,
+// which cannot overflow.
+//
+//go:noescape
+func checksumNEON(data []byte) uint64
@@ -0,0 +1,37 @@
+#include "textflag.h"
+
+// func checksumNEON(data []byte) uint64
+TEXT ·checksumNEON(SB), NOSPLIT, $0-32
+	MOVD data_base+0(FP), R0
+	MOVD data_len+8(FP), R1
+	LSR  $6, R1, R1          // R1 = 64-byte blocks
+
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+	VEOR V2.B16, V2.B16, V2.B16
+	VEOR V3.B16, V3.B16, V3.B16
+	CBZ  R1, fold
+
+loop:
+	// Widen the low and high halves of each 16 bytes into 64-bit lanes
+	VLD1.P  64(R0), [V4.S4, V5.S4, V6.S4, V7.S4]
+	VUADDW  V4.S2, V0.D2, V0.D2
+	VUADDW2 V4.S4, V1.D2, V1.D2
+	VUADDW  V5.S2, V2.D2, V2.D2
+	VUADDW2 V5.S4, V3.D2, V3.D2
+	VUADDW  V6.S2, V0.D2, V0.D2
+	VUADDW2 V6.S4, V1.D2, V1.D2
+	VUADDW  V7.S2, V2.D2, V2.D2
+	VUADDW2 V7.S4, V3.D2, V3.D2
+	SUB     $1, R1, R1
+	CBNZ    R1, loop
+
+fold:
+	VADD V1.D2, V0.D2, V0.D2
+	VADD V3.D2, V2.D2, V2.D2
+	VADD V2.D2, V0.D2, V0.D2
+	VMOV V0.D[0], R2
+	VMOV V0.D[1], R3
+	ADD  R3, R2, R2
+	MOVD R2, ret+24(FP)
+	RET
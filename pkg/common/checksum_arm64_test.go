@@ -0,0 +1,12 @@
+package common
+
+import "golang.org/x/sys/cpu"
+
+// checksumKernels returns the kernels the CPU can run.
+func checksumKernels() map[string]func([]byte) uint64 {
+	kernels := map[string]func([]byte) uint64{}
+	if cpu.ARM64.HasASIMD {
+		kernels["neon"] = checksumNEON
+	}
+	return kernels
+}
@@ -0,0 +1,8 @@
+//go:build !amd64 && !arm64
+
+package common
+
+// checksumKernels returns the kernels the CPU can run.
+func checksumKernels() map[string]func([]byte) uint64 {
+	return nil
+}
@@ -1,80 +1,56 @@
-// +build amd64
-
 package common
 
-import (
-	"sync"
-	"unsafe"
-)
+import "math/bits"
 
-// CPU capabilities cache
+// checksumKernel sums data, a multiple of checksumBlock bytes long, as
+// little-endian 32-bit words. The architecture sets it to the fastest
+// kernel of the CPU, or leaves it nil.
 var (
-	cpuOnce      sync.Once
-	cpuHasAVX2   bool
-	cpuHasSSE2   bool
+	checksumKernel     func(data []byte) uint64
+	checksumKernelName = "generic"
 )
 
-// initCPUCaps initializes CPU capabilities cache
-func initCPUCaps() {
-	cpuOnce.Do(func() {
-		cpuHasAVX2 = hasAVX2()
-		cpuHasSSE2 = hasSSE2()
-	})
+const (
+	checksumBlock = 64 // Bytes summed by a kernel iteration
+
+	// checksumMinSIMD is the length below which a kernel is not worth
+	// calling.
+	checksumMinSIMD = 64
+)
+
+// ChecksumKernel returns the name of the checksum kernel used by the SIMD
+// functions: "avx2", "sse2", "neon" or "generic".
+func ChecksumKernel() string {
+	return checksumKernelName
 }
 
-// CalculateChecksumSIMD uses SIMD instructions for ultra-fast checksum calculation
-// This function is optimized for amd64 with AVX2 support
+// CalculateChecksumSIMD computes the Internet checksum of data like
+// CalculateChecksum, with the architecture's vector kernel for all but the
+// tail of data.
 func CalculateChecksumSIMD(data []byte) uint16 {
-	if len(data) == 0 {
-		return 0
-	}
-
-	// For very small packets, use the optimized scalar implementation
-	// SIMD overhead is not worth it for small data
-	if len(data) < 64 {
+	if checksumKernel == nil || len(data) < checksumMinSIMD {
 		return CalculateChecksumFast(data)
 	}
+	return ^uint16(checksumSumSIMD(data))
+}
 
-	// Initialize CPU capabilities once
-	initCPUCaps()
-
-	var sum uint64
-
-	// Process 32-byte chunks with AVX2 (if available)
-	if cpuHasAVX2 {
-		sum = checksumAVX2(data)
-	} else if cpuHasSSE2 {
-		// Fallback to SSE2
-		sum = checksumSSE2(data)
-	} else {
-		// No SIMD support, use optimized scalar
-		return CalculateChecksumFast(data)
+// checksumSumSIMD returns the ones' complement sum of data folded to 16
+// bits.
+func checksumSumSIMD(data []byte) uint32 {
+	n := len(data) &^ (checksumBlock - 1)
+	sum := checksumKernel(data[:n])
+	for sum > 0xFFFF {
+		sum = (sum & 0xFFFF) + (sum >> 16)
 	}
-
-	// Fold 64-bit sum to 16-bit
+	// The sum of little-endian words is the byte-swapped sum of the
+	// big-endian ones (RFC 1071 section 2)
+	sum = uint64(bits.ReverseBytes16(uint16(sum))) + uint64(calculateChecksumPartial(data[n:]))
 	for sum > 0xFFFF {
 		sum = (sum & 0xFFFF) + (sum >> 16)
 	}
-
-	return ^uint16(sum)
+	return uint32(sum)
 }
 
-// checksumAVX2 processes data in 32-byte chunks using AVX2
-//go:noescape
-func checksumAVX2(data []byte) uint64
-
-// checksumSSE2 processes data in 16-byte chunks using SSE2
-//go:noescape
-func checksumSSE2(data []byte) uint64
-
-// hasAVX2 checks if CPU supports AVX2
-//go:noescape
-func hasAVX2() bool
-
-// hasSSE2 checks if CPU supports SSE2
-//go:noescape
-func hasSSE2() bool
-
 // CalculateChecksumWithPseudoHeaderSIMD combines pseudo-header and data checksums using SIMD
 func CalculateChecksumWithPseudoHeaderSIMD(ph *PseudoHeader, data []byte) uint16 {
 	// Use stack-allocated buffer for pseudo-header to avoid heap allocation
@@ -102,14 +78,9 @@ func CalculateChecksumWithPseudoHeaderSIMD(ph *PseudoHeader, data []byte) uint16
 	sum += uint64(uint32(phBytes[4])<<24 | uint32(phBytes[5])<<16 | uint32(phBytes[6])<<8 | uint32(phBytes[7]))
 	sum += uint64(uint32(phBytes[8])<<24 | uint32(phBytes[9])<<16 | uint32(phBytes[10])<<8 | uint32(phBytes[11]))
 
-	// Initialize CPU capabilities once
-	initCPUCaps()
-
 	// Add data checksum using SIMD
-	if len(data) >= 64 && cpuHasAVX2 {
-		sum += checksumAVX2(data)
-	} else if len(data) >= 32 && cpuHasSSE2 {
-		sum += checksumSSE2(data)
+	if checksumKernel != nil && len(data) >= checksumMinSIMD {
+		sum += uint64(checksumSumSIMD(data))
 	} else {
 		// Small data, process with scalar code
 		sum += uint64(calculateChecksumPartial(data))
@@ -125,23 +96,23 @@ func CalculateChecksumWithPseudoHeaderSIMD(ph *PseudoHeader, data []byte) uint16
 
 // calculateChecksumPartial returns partial sum (not inverted)
 func calculateChecksumPartial(data []byte) uint32 {
-	var sum uint32
+	var sum uint64 // Wide enough for the carries of 32-bit words
 	length := len(data)
 
 	// Process 4-byte chunks
 	i := 0
 	for i+3 < length {
-		sum += uint32(data[i])<<24 | uint32(data[i+1])<<16 | uint32(data[i+2])<<8 | uint32(data[i+3])
+		sum += uint64(uint32(data[i])<<24 | uint32(data[i+1])<<16 | uint32(data[i+2])<<8 | uint32(data[i+3]))
 		i += 4
 	}
 
 	// Process remaining bytes
 	for i < length {
 		if i+1 < length {
-			sum += uint32(data[i])<<8 | uint32(data[i+1])
+			sum += uint64(data[i])<<8 | uint64(data[i+1])
 			i += 2
 		} else {
-			sum += uint32(data[i]) << 8
+			sum += uint64(data[i]) << 8
 			i++
 		}
 	}
@@ -151,7 +122,7 @@ func calculateChecksumPartial(data []byte) uint32 {
 		sum = (sum & 0xFFFF) + (sum >> 16)
 	}
 
-	return sum
+	return uint32(sum)
 }
 
 // UpdateChecksumSIMD performs incremental checksum update (RFC 1624)
@@ -264,11 +235,3 @@ func (cb *ChecksumBatch) Reset() {
 	cb.packets = cb.packets[:0]
 	cb.results = cb.results[:0]
 }
-
-// Inline assembly helpers for direct memory access
-func ptr(b []byte) unsafe.Pointer {
-	if len(b) == 0 {
-		return unsafe.Pointer(nil)
-	}
-	return unsafe.Pointer(&b[0])
-}
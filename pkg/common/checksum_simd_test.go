@@ -0,0 +1,71 @@
+package common
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// checksumKernelReference is what a checksum kernel computes.
+func checksumKernelReference(data []byte) uint64 {
+	var sum uint64
+	for i := 0; i+4 <= len(data); i += 4 {
+		sum += uint64(binary.LittleEndian.Uint32(data[i:]))
+	}
+	return sum
+}
+
+func TestCalculateChecksumSIMD(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	buf := make([]byte, 70000)
+	rng.Read(buf)
+
+	lengths := []int{0, 1, 2, 63, 64, 65, 127, 128, 129, 191, 1499, 1500, 4096, 9001, 65535, 65536}
+	for _, n := range lengths {
+		// Unaligned starts, and all-ones data for the most carries
+		for _, offset := range []int{0, 1, 3} {
+			data := buf[offset : offset+n]
+			if got, want := CalculateChecksumSIMD(data), CalculateChecksum(data); got != want {
+				t.Errorf("CalculateChecksumSIMD() of %d bytes at offset %d = %04x, want %04x (%s kernel)",
+					n, offset, got, want, ChecksumKernel())
+			}
+		}
+	}
+	ones := make([]byte, 65536)
+	for i := range ones {
+		ones[i] = 0xff
+	}
+	if got, want := CalculateChecksumSIMD(ones), CalculateChecksumFast(ones); got != want {
+		t.Errorf("CalculateChecksumSIMD() of all ones = %04x, want %04x", got, want)
+	}
+}
+
+func TestCalculateChecksumWithPseudoHeaderSIMD(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	ph := PseudoHeader{
+		SourceAddr:      IPv4Address{192, 168, 1, 1},
+		DestinationAddr: IPv4Address{10, 0, 0, 1},
+		Protocol:        ProtocolTCP,
+	}
+	for _, n := range []int{0, 20, 33, 64, 100, 1460, 8961} {
+		data := make([]byte, n)
+		rng.Read(data)
+		ph.Length = uint16(n)
+		if got, want := CalculateChecksumWithPseudoHeaderSIMD(&ph, data), CalculateChecksumWithPseudoHeader(ph, data); got != want {
+			t.Errorf("CalculateChecksumWithPseudoHeaderSIMD() of %d bytes = %04x, want %04x", n, got, want)
+		}
+	}
+}
+
+func TestChecksumKernels(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	data := make([]byte, 64*129)
+	rng.Read(data)
+	for name, kernel := range checksumKernels() {
+		for _, n := range []int{0, 64, 128, 64 * 129} {
+			if got, want := kernel(data[:n]), checksumKernelReference(data[:n]); got != want {
+				t.Errorf("%s kernel of %d bytes = %x, want %x", name, n, got, want)
+			}
+		}
+	}
+}
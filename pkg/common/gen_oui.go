@@ -0,0 +1,95 @@
+//go:build ignore
+
+// gen_oui writes oui_table.go, the OUI table of LookupVendor, from
+// oui.csv, a list of MA-L assignments in the format of the IEEE registry
+// (https://standards-oui.ieee.org/oui/oui.csv). The registry's file can
+// replace oui.csv as it is for the full table.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"go/format"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type entry struct {
+	oui    uint32
+	vendor string
+}
+
+func main() {
+	f, err := os.Open("oui.csv")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	entries, err := read(f)
+	if err != nil {
+		log.Fatalf("oui.csv: %v", err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gen_oui.go from oui.csv; DO NOT EDIT.\n\n")
+	b.WriteString("package common\n\n")
+	b.WriteString("// ouiTable holds the vendors of MA-L assignments, sorted by OUI.\n")
+	b.WriteString("var ouiTable = []ouiEntry{\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "\t{0x%06X, %q},\n", e.oui, e.vendor)
+	}
+	b.WriteString("}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("oui_table.go", src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// read returns the MA-L assignments of a registry file, sorted by OUI.
+func read(r io.Reader) ([]entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) < 3 || header[1] != "Assignment" || header[2] != "Organization Name" {
+		return nil, fmt.Errorf("unexpected header %q", header)
+	}
+
+	seen := make(map[uint32]bool)
+	var entries []entry
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 || record[0] != "MA-L" {
+			continue
+		}
+		oui, err := strconv.ParseUint(record[1], 16, 24)
+		if err != nil || len(record[1]) != 6 {
+			return nil, fmt.Errorf("invalid assignment %q", record[1])
+		}
+		if seen[uint32(oui)] {
+			continue
+		}
+		seen[uint32(oui)] = true
+		entries = append(entries, entry{uint32(oui), strings.TrimSpace(record[2])})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].oui < entries[j].oui })
+	return entries, nil
+}
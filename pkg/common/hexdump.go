@@ -0,0 +1,262 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultHexDumpWidth is the number of bytes per line of a hex dump, if not
+// configured.
+const DefaultHexDumpWidth = 16
+
+// HexDumpConfig configures the layout of a hex dump. The zero value gives
+// the layout of HexDump:
+//
+//	0000  45 00 00 1c 00 00 40 00  40 01 00 00 0a 00 00 01  |E.....@.@.......|
+type HexDumpConfig struct {
+	Width int // Bytes per line; 0 means DefaultHexDumpWidth
+
+	// Group is the number of bytes after which an extra space is written;
+	// 0 means half the width, and a negative number never.
+	Group int
+
+	// Offset is the offset of the first byte, for dumping part of a
+	// packet.
+	Offset int
+
+	HideOffsets bool // Leave out the offset column
+	HideASCII   bool // Leave out the ASCII column
+
+	// Indent is written at the start of each line.
+	Indent string
+}
+
+// layout returns the configured width and group.
+func (c HexDumpConfig) layout() (width, group int) {
+	width, group = c.Width, c.Group
+	if width <= 0 {
+		width = DefaultHexDumpWidth
+	}
+	if group == 0 {
+		group = width / 2
+	}
+	return width, group
+}
+
+// Dump returns a hex dump of data.
+func (c HexDumpConfig) Dump(data []byte) string {
+	var b strings.Builder
+	c.Write(&b, data)
+	return b.String()
+}
+
+// Write writes a hex dump of data to w.
+func (c HexDumpConfig) Write(w io.Writer, data []byte) error {
+	width, _ := c.layout()
+	var b bytes.Buffer
+	for i := 0; i < len(data); i += width {
+		c.line(&b, i, data[i:min(i+width, len(data))], nil)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// line writes the line of a dump for the bytes at offset i. Bytes marked
+// in diff are flagged on a line underneath.
+func (c HexDumpConfig) line(b *bytes.Buffer, i int, line []byte, diff []bool) {
+	width, group := c.layout()
+	lineStart := b.Len()
+	b.WriteString(c.Indent)
+	if !c.HideOffsets {
+		fmt.Fprintf(b, "%04x  ", c.Offset+i)
+	}
+	start := b.Len()
+	for j := 0; j < width; j++ {
+		if j < len(line) {
+			fmt.Fprintf(b, "%02x ", line[j])
+		} else {
+			b.WriteString("   ")
+		}
+		if group > 0 && (j+1)%group == 0 && j+1 < width {
+			b.WriteByte(' ')
+		}
+	}
+	hexEnd := b.Len()
+	if !c.HideASCII {
+		b.WriteString(" |")
+		for _, v := range line {
+			if v >= 32 && v <= 126 {
+				b.WriteByte(v)
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('|')
+	}
+	b.WriteByte('\n')
+
+	if diff == nil {
+		return
+	}
+	// The marker line lines up with the hex column
+	column := hexEnd - start
+	marks := make([]byte, 0, column)
+	for j := 0; j < width && len(marks) < column; j++ {
+		if diff[j] {
+			marks = append(marks, '^', '^', ' ')
+		} else {
+			marks = append(marks, ' ', ' ', ' ')
+		}
+		if group > 0 && (j+1)%group == 0 && j+1 < width {
+			marks = append(marks, ' ')
+		}
+	}
+	b.WriteString(strings.Repeat(" ", start-lineStart))
+	b.Write(bytes.TrimRight(marks, " "))
+	b.WriteByte('\n')
+}
+
+// HexDumper is an io.WriteCloser that writes a hex dump of the bytes
+// written to it, a line at a time. Close writes the last, partial line.
+type HexDumper struct {
+	w       io.Writer
+	config  HexDumpConfig
+	pending []byte // Bytes of the line being filled
+	n       int    // Bytes dumped
+	err     error
+}
+
+// NewHexDumper returns a HexDumper writing a dump of the given layout to
+// w.
+func NewHexDumper(w io.Writer, config HexDumpConfig) *HexDumper {
+	width, _ := config.layout()
+	return &HexDumper{w: w, config: config, pending: make([]byte, 0, width)}
+}
+
+// Write dumps data, writing each line once it is complete.
+func (d *HexDumper) Write(data []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n := len(data)
+	for len(data) > 0 {
+		room := cap(d.pending) - len(d.pending)
+		chunk := data[:min(room, len(data))]
+		d.pending = append(d.pending, chunk...)
+		data = data[len(chunk):]
+		if len(d.pending) == cap(d.pending) {
+			if err := d.flush(); err != nil {
+				return n - len(data), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the last line, if partial.
+func (d *HexDumper) Close() error {
+	if d.err == nil && len(d.pending) > 0 {
+		d.flush()
+	}
+	return d.err
+}
+
+func (d *HexDumper) flush() error {
+	var b bytes.Buffer
+	d.config.line(&b, d.n, d.pending, nil)
+	d.n += len(d.pending)
+	d.pending = d.pending[:0]
+	_, d.err = d.w.Write(b.Bytes())
+	return d.err
+}
+
+// IndentWriter is an io.Writer that writes a prefix at the start of each
+// line written through it.
+type IndentWriter struct {
+	w      io.Writer
+	indent []byte
+	mid    bool // In the middle of a line
+}
+
+// NewIndentWriter returns an IndentWriter indenting the lines written to w.
+func NewIndentWriter(w io.Writer, indent string) *IndentWriter {
+	return &IndentWriter{w: w, indent: []byte(indent)}
+}
+
+// Write writes data, indenting each line it starts.
+func (iw *IndentWriter) Write(data []byte) (int, error) {
+	var b bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		if !iw.mid {
+			b.Write(iw.indent)
+		}
+		b.Write(line)
+		iw.mid = line[len(line)-1] != '\n'
+	}
+	if _, err := iw.w.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// DiffBytes returns a report of the differences between two byte slices,
+// such as a packet serialized in a test and the one it should be, or ""
+// if they are equal. Each line of 16 bytes that differs is dumped from
+// want (marked -) and got (marked +), with the differing bytes flagged
+// underneath:
+//
+//	2 bytes differ, first at offset 0x0a (length 20)
+//	-0000  45 00 00 1c 00 00 40 00  40 01 00 00 0a 00 00 01  |E.....@.@.......|
+//	+0000  45 00 00 1c 00 00 40 00  40 01 12 34 0a 00 00 01  |E.....@.@..4....|
+//	                                      ^^ ^^
+func DiffBytes(want, got []byte) string {
+	n := max(len(want), len(got))
+	differ, first := 0, -1
+	for i := 0; i < n; i++ {
+		if i >= len(want) || i >= len(got) || want[i] != got[i] {
+			differ++
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	if differ == 0 {
+		return ""
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d bytes differ, first at offset 0x%02x", differ, first)
+	if len(want) == len(got) {
+		fmt.Fprintf(&b, " (length %d)\n", len(want))
+	} else {
+		fmt.Fprintf(&b, " (length %d, want %d)\n", len(got), len(want))
+	}
+
+	const width = DefaultHexDumpWidth
+	last := -1 // Last line dumped
+	for i := first - first%width; i < n; i += width {
+		diff := make([]bool, width)
+		changed := false
+		for j := range diff {
+			k := i + j
+			if k < n && (k >= len(want) || k >= len(got) || want[k] != got[k]) {
+				diff[j], changed = true, true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if last >= 0 && i != last+width {
+			b.WriteString("...\n")
+		}
+		last = i
+		HexDumpConfig{Indent: "-"}.line(&b, i, want[min(i, len(want)):min(i+width, len(want))], nil)
+		HexDumpConfig{Indent: "+"}.line(&b, i, got[min(i, len(got)):min(i+width, len(got))], diff)
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+var hexDumpData = []byte("E\x00\x00\x1c\x00\x00@\x00@\x01\x00\x00\n\x00\x00\x01\n\x00\x00\x02")
+
+func TestHexDumpConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config HexDumpConfig
+		want   string
+	}{
+		{
+			name:   "default",
+			config: HexDumpConfig{},
+			want: "0000  45 00 00 1c 00 00 40 00  40 01 00 00 0a 00 00 01  |E.....@.@.......|\n" +
+				"0010  0a 00 00 02                                       |....|\n",
+		},
+		{
+			name:   "width and offset",
+			config: HexDumpConfig{Width: 8, Offset: 0x20},
+			want: "0020  45 00 00 1c  00 00 40 00  |E.....@.|\n" +
+				"0028  40 01 00 00  0a 00 00 01  |@.......|\n" +
+				"0030  0a 00 00 02               |....|\n",
+		},
+		{
+			name:   "no groups, offsets or ASCII",
+			config: HexDumpConfig{Width: 10, Group: -1, HideOffsets: true, HideASCII: true, Indent: "> "},
+			want: "> 45 00 00 1c 00 00 40 00 40 01 \n" +
+				"> 00 00 0a 00 00 01 0a 00 00 02 \n",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.config.Dump(hexDumpData); got != tt.want {
+			t.Errorf("%s: Dump() =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+
+	if got, want := HexDump(hexDumpData), (HexDumpConfig{}).Dump(hexDumpData); got != want {
+		t.Errorf("HexDump() =\n%s\nwant\n%s", got, want)
+	}
+	if got := HexDump(nil); got != "" {
+		t.Errorf("HexDump(nil) = %q, want empty", got)
+	}
+}
+
+func TestHexDumper(t *testing.T) {
+	var b strings.Builder
+	d := NewHexDumper(&b, HexDumpConfig{Width: 8})
+	// Writes that do not line up with lines
+	for _, chunk := range [][]byte{hexDumpData[:3], hexDumpData[3:11], hexDumpData[11:]} {
+		if _, err := d.Write(chunk); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if got := strings.Count(b.String(), "\n"); got != 2 {
+		t.Errorf("lines written before Close() = %d, want 2", got)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got, want := b.String(), (HexDumpConfig{Width: 8}).Dump(hexDumpData); got != want {
+		t.Errorf("HexDumper output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestIndentWriter(t *testing.T) {
+	var b strings.Builder
+	w := NewIndentWriter(&b, "  ")
+	for _, s := range []string{"one\ntw", "o\n", "\nthree"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if got, want := b.String(), "  one\n  two\n  \n  three"; got != want {
+		t.Errorf("IndentWriter output = %q, want %q", got, want)
+	}
+}
+
+func TestDiffBytes(t *testing.T) {
+	if got := DiffBytes(hexDumpData, append([]byte(nil), hexDumpData...)); got != "" {
+		t.Errorf("DiffBytes() of equal slices = %q, want empty", got)
+	}
+
+	got := append([]byte(nil), hexDumpData...)
+	got[10], got[11] = 0x12, 0x34
+	want := "2 bytes differ, first at offset 0x0a (length 20)\n" +
+		"-0000  45 00 00 1c 00 00 40 00  40 01 00 00 0a 00 00 01  |E.....@.@.......|\n" +
+		"+0000  45 00 00 1c 00 00 40 00  40 01 12 34 0a 00 00 01  |E.....@.@..4....|\n" +
+		"                                      ^^ ^^\n"
+	if diff := DiffBytes(hexDumpData, got); diff != want {
+		t.Errorf("DiffBytes() =\n%s\nwant\n%s", diff, want)
+	}
+
+	// Lines in between that match are skipped, and a short slice differs
+	// in its missing bytes
+	long := make([]byte, 64)
+	short := append([]byte(nil), long[:60]...)
+	short[0] = 1
+	want = "5 bytes differ, first at offset 0x00 (length 60, want 64)\n" +
+		"-0000  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|\n" +
+		"+0000  01 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|\n" +
+		"       ^^\n" +
+		"...\n" +
+		"-0030  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|\n" +
+		"+0030  00 00 00 00 00 00 00 00  00 00 00 00              |............|\n" +
+		"                                            ^^ ^^ ^^ ^^\n"
+	if diff := DiffBytes(long, short); diff != want {
+		t.Errorf("DiffBytes() =\n%s\nwant\n%s", diff, want)
+	}
+}
@@ -0,0 +1,49 @@
+package common
+
+import "sort"
+
+//go:generate go run gen_oui.go
+
+// ouiEntry is the vendor an OUI is assigned to.
+type ouiEntry struct {
+	oui    uint32
+	vendor string
+}
+
+// OUI returns the Organizationally Unique Identifier of a MAC address, its
+// first three bytes without the group bit (so that 01:00:5e:00:00:01 has
+// the OUI of IANA, 00:00:5e).
+func (m MACAddress) OUI() uint32 {
+	return uint32(m[0]&^0x01)<<16 | uint32(m[1])<<8 | uint32(m[2])
+}
+
+// IsLocal returns true if the address is locally administered rather than
+// assigned by the IEEE, as for virtual interfaces.
+func (m MACAddress) IsLocal() bool {
+	return m[0]&0x02 != 0
+}
+
+// LookupVendor returns the organization the OUI of a MAC address is
+// assigned to. It reports false for an OUI not in the table, which is
+// generated from the IEEE registry, and for a locally administered
+// address.
+func LookupVendor(mac MACAddress) (string, bool) {
+	if mac.IsLocal() {
+		return "", false
+	}
+	oui := mac.OUI()
+	i := sort.Search(len(ouiTable), func(i int) bool { return ouiTable[i].oui >= oui })
+	if i == len(ouiTable) || ouiTable[i].oui != oui {
+		return "", false
+	}
+	return ouiTable[i].vendor, true
+}
+
+// StringWithVendor returns the MAC address in standard format followed by
+// its vendor in parentheses, if known (e.g., "00:50:56:01:02:03 (VMware)").
+func (m MACAddress) StringWithVendor() string {
+	if vendor, ok := LookupVendor(m); ok {
+		return m.String() + " (" + vendor + ")"
+	}
+	return m.String()
+}
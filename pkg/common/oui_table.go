@@ -0,0 +1,57 @@
+// Code generated by gen_oui.go from oui.csv; DO NOT EDIT.
+
+package common
+
+// ouiTable holds the vendors of MA-L assignments, sorted by OUI.
+var ouiTable = []ouiEntry{
+	{0x00000C, "Cisco Systems"},
+	{0x000048, "Seiko Epson"},
+	{0x00005E, "ICANN, IANA Department"},
+	{0x0002B3, "Intel Corporation"},
+	{0x000393, "Apple"},
+	{0x0003FF, "Microsoft"},
+	{0x00044B, "NVIDIA"},
+	{0x0004F2, "Polycom"},
+	{0x000569, "VMware"},
+	{0x00090F, "Fortinet"},
+	{0x000B82, "Grandstream Networks"},
+	{0x000C29, "VMware"},
+	{0x000C42, "Routerboard.com"},
+	{0x000D93, "Apple"},
+	{0x000DB9, "PC Engines"},
+	{0x000EC6, "ASIX Electronics"},
+	{0x000FB5, "NETGEAR"},
+	{0x001018, "Broadcom"},
+	{0x001132, "Synology"},
+	{0x0012FB, "Samsung Electronics"},
+	{0x001422, "Dell"},
+	{0x00146C, "NETGEAR"},
+	{0x00155D, "Microsoft"},
+	{0x00156D, "Ubiquiti Networks"},
+	{0x00163E, "Xensource"},
+	{0x001788, "Philips Lighting"},
+	{0x00180A, "Cisco Meraki"},
+	{0x001A11, "Google"},
+	{0x001B17, "Palo Alto Networks"},
+	{0x001B21, "Intel Corporate"},
+	{0x001C42, "Parallels"},
+	{0x001D7E, "Cisco-Linksys"},
+	{0x002590, "Super Micro Computer"},
+	{0x002722, "Ubiquiti Networks"},
+	{0x005056, "VMware"},
+	{0x0050F2, "Microsoft"},
+	{0x008077, "Brother Industries"},
+	{0x009027, "Intel Corporation"},
+	{0x00A0C9, "Intel Corporation"},
+	{0x00E04C, "Realtek Semiconductor"},
+	{0x080027, "PCS Systemtechnik (VirtualBox)"},
+	{0x18B430, "Nest Labs"},
+	{0x24A43C, "Ubiquiti Networks"},
+	{0x3CFDFE, "Intel Corporate"},
+	{0x44650D, "Amazon Technologies"},
+	{0x4C5E0C, "Routerboard.com"},
+	{0xB827EB, "Raspberry Pi Foundation"},
+	{0xDCA632, "Raspberry Pi Trading"},
+	{0xE45F01, "Raspberry Pi Trading"},
+	{0xF4F5D8, "Google"},
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestLookupVendor(t *testing.T) {
+	tests := []struct {
+		mac    MACAddress
+		vendor string
+		ok     bool
+	}{
+		{MACAddress{0x00, 0x50, 0x56, 0x01, 0x02, 0x03}, "VMware", true},
+		{MACAddress{0xb8, 0x27, 0xeb, 0x00, 0x00, 0x01}, "Raspberry Pi Foundation", true},
+		{MACAddress{0x00, 0x00, 0x0c, 0x07, 0xac, 0x01}, "Cisco Systems", true},
+		{MACAddress{0x01, 0x00, 0x5e, 0x00, 0x00, 0x01}, "ICANN, IANA Department", true}, // Group bit ignored
+		{MACAddress{0x00, 0x00, 0x01, 0x00, 0x00, 0x01}, "", false},
+		{MACAddress{0x02, 0x50, 0x56, 0x01, 0x02, 0x03}, "", false}, // Locally administered
+		{BroadcastMAC, "", false},
+	}
+	for _, tt := range tests {
+		vendor, ok := LookupVendor(tt.mac)
+		if vendor != tt.vendor || ok != tt.ok {
+			t.Errorf("LookupVendor(%s) = %q, %v, want %q, %v", tt.mac, vendor, ok, tt.vendor, tt.ok)
+		}
+	}
+}
+
+func TestMACAddressStringWithVendor(t *testing.T) {
+	tests := []struct {
+		mac  MACAddress
+		want string
+	}{
+		{MACAddress{0x00, 0x0c, 0x29, 0xaa, 0xbb, 0xcc}, "00:0c:29:aa:bb:cc (VMware)"},
+		{MACAddress{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}, "02:00:00:00:00:01"},
+	}
+	for _, tt := range tests {
+		if got := tt.mac.StringWithVendor(); got != tt.want {
+			t.Errorf("StringWithVendor() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestMACAddressOUI(t *testing.T) {
+	mac := MACAddress{0x01, 0x00, 0x5e, 0x7f, 0x00, 0x01}
+	if got := mac.OUI(); got != 0x00005e {
+		t.Errorf("OUI() = %06x, want 00005e", got)
+	}
+	if mac.IsLocal() {
+		t.Error("IsLocal() = true, want false")
+	}
+	if !(MACAddress{0x02}).IsLocal() {
+		t.Error("IsLocal() of 02:00:00:00:00:00 = false, want true")
+	}
+}
+
+func TestOUITableSorted(t *testing.T) {
+	if !sort.SliceIsSorted(ouiTable, func(i, j int) bool { return ouiTable[i].oui < ouiTable[j].oui }) {
+		t.Error("ouiTable is not sorted; run go generate")
+	}
+}
@@ -0,0 +1,132 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// PooledBuffer is a reference-counted byte buffer drawn from a per-size-class
+// pool. It is used on the packet hot path so that reading, parsing and
+// serializing a packet does not allocate.
+//
+// A new buffer holds one reference. Each Retain adds one and each Release
+// drops one; when the last reference is released the buffer goes back to its
+// pool and must no longer be used, including any slices obtained from Bytes.
+// A buffer that is never released is simply reclaimed by the garbage
+// collector.
+type PooledBuffer struct {
+	data  []byte // Full-capacity slice, owned by the buffer for its lifetime
+	n     int    // Length returned by Bytes
+	dirty int    // Highest length handed out, cleared on release
+	class *sync.Pool
+	refs  atomic.Int32
+}
+
+// pooledClasses are the size classes of pooled buffers, smallest first.
+// Larger requests are allocated directly and never pooled.
+var pooledClasses = []struct {
+	size int
+	pool *sync.Pool
+}{
+	{SmallBufferSize, new(sync.Pool)},
+	{FrameBufferSize, new(sync.Pool)},
+	{JumboBufferSize, new(sync.Pool)},
+	{LargeBufferSize, new(sync.Pool)},
+}
+
+// pooledInUse counts buffers obtained but not yet released.
+var pooledInUse atomic.Int64
+
+// NewPooledBuffer returns a buffer of the given length holding one reference.
+// The contents are zero.
+func NewPooledBuffer(size int) *PooledBuffer {
+	var b *PooledBuffer
+	for _, class := range pooledClasses {
+		if size > class.size {
+			continue
+		}
+		if v := class.pool.Get(); v != nil {
+			b = v.(*PooledBuffer)
+		} else {
+			b = &PooledBuffer{data: make([]byte, class.size), class: class.pool}
+		}
+		break
+	}
+	if b == nil {
+		// Too large for any class
+		b = &PooledBuffer{data: make([]byte, size)}
+	}
+
+	b.n = size
+	b.dirty = size
+	b.refs.Store(1)
+	pooledInUse.Add(1)
+	return b
+}
+
+// Bytes returns the buffer's contents. The slice is only valid until the
+// last reference is released.
+func (b *PooledBuffer) Bytes() []byte {
+	return b.data[:b.n:b.n]
+}
+
+// Len returns the length of the buffer.
+func (b *PooledBuffer) Len() int {
+	return b.n
+}
+
+// Cap returns the largest length the buffer can be resized to.
+func (b *PooledBuffer) Cap() int {
+	return len(b.data)
+}
+
+// SetLen resizes the buffer, for example to the number of bytes a read
+// actually returned. It panics if n is out of range.
+func (b *PooledBuffer) SetLen(n int) {
+	if n < 0 || n > len(b.data) {
+		panic("common: PooledBuffer length out of range")
+	}
+	b.n = n
+	if n > b.dirty {
+		b.dirty = n
+	}
+}
+
+// Retain adds a reference, for handing the buffer to another owner, and
+// returns the buffer.
+func (b *PooledBuffer) Retain() *PooledBuffer {
+	if b.refs.Add(1) <= 1 {
+		panic("common: Retain of released PooledBuffer")
+	}
+	return b
+}
+
+// Release drops a reference, returning the buffer to its pool when none are
+// left. It panics if the buffer was already fully released.
+func (b *PooledBuffer) Release() {
+	refs := b.refs.Add(-1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		panic("common: PooledBuffer released too many times")
+	}
+
+	pooledInUse.Add(-1)
+	if b.class == nil {
+		return
+	}
+
+	// Clear the bytes that were used so stale packet data never leaks into
+	// the next owner
+	clear(b.data[:b.dirty])
+	b.n = 0
+	b.dirty = 0
+	b.class.Put(b)
+}
+
+// PooledBuffersInUse returns the number of pooled buffers that have been
+// obtained but not fully released. It is meant for tests and leak checks.
+func PooledBuffersInUse() int64 {
+	return pooledInUse.Load()
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestPooledBuffer(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		wantCap int
+	}{
+		{"Small", 64, SmallBufferSize},
+		{"Frame", 1526, FrameBufferSize},
+		{"Jumbo", 9026, JumboBufferSize},
+		{"Large", 16384, LargeBufferSize},
+		{"Oversized", LargeBufferSize + 1, LargeBufferSize + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inUse := PooledBuffersInUse()
+			b := NewPooledBuffer(tt.size)
+			if b.Len() != tt.size || len(b.Bytes()) != tt.size {
+				t.Errorf("Len() = %d, want %d", b.Len(), tt.size)
+			}
+			if b.Cap() != tt.wantCap {
+				t.Errorf("Cap() = %d, want %d", b.Cap(), tt.wantCap)
+			}
+			if got := PooledBuffersInUse(); got != inUse+1 {
+				t.Errorf("PooledBuffersInUse() = %d, want %d", got, inUse+1)
+			}
+
+			// A second reference keeps the buffer alive
+			b.Retain()
+			b.Release()
+			if got := PooledBuffersInUse(); got != inUse+1 {
+				t.Errorf("PooledBuffersInUse() after one Release = %d, want %d", got, inUse+1)
+			}
+			b.Release()
+			if got := PooledBuffersInUse(); got != inUse {
+				t.Errorf("PooledBuffersInUse() after last Release = %d, want %d", got, inUse)
+			}
+		})
+	}
+}
+
+func TestPooledBufferCleared(t *testing.T) {
+	// Fill a buffer past the length it is released at, so clearing has to
+	// cover everything that was handed out
+	b := NewPooledBuffer(100)
+	for i := range b.Bytes() {
+		b.Bytes()[i] = 0xFF
+	}
+	b.SetLen(10)
+	b.Release()
+
+	// Whether or not the pool hands the same buffer back, it must be zeroed
+	for i := 0; i < 10; i++ {
+		b := NewPooledBuffer(100)
+		for j, v := range b.Bytes() {
+			if v != 0 {
+				t.Fatalf("byte %d = 0x%02x, want 0", j, v)
+			}
+		}
+		b.Release()
+	}
+}
+
+func TestPooledBufferSetLen(t *testing.T) {
+	b := NewPooledBuffer(10)
+	defer b.Release()
+
+	b.SetLen(b.Cap())
+	if b.Len() != SmallBufferSize {
+		t.Errorf("Len() = %d, want %d", b.Len(), SmallBufferSize)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("SetLen() beyond Cap() did not panic")
+		}
+	}()
+	b.SetLen(b.Cap() + 1)
+}
+
+func TestPooledBufferOverRelease(t *testing.T) {
+	b := NewPooledBuffer(10)
+	b.Release()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("second Release() did not panic")
+		}
+	}()
+	b.Release()
+}
+
+func TestPooledBufferAllocs(t *testing.T) {
+	// Warm the pool
+	NewPooledBuffer(1500).Release()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		b := NewPooledBuffer(1500)
+		b.Bytes()[0] = 1
+		b.Release()
+	})
+	if allocs != 0 {
+		t.Errorf("NewPooledBuffer()/Release() allocs = %v, want 0", allocs)
+	}
+}
+
+func BenchmarkPooledBuffer(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := NewPooledBuffer(1500)
+		buf.Bytes()[0] = byte(i)
+		buf.Release()
+	}
+}
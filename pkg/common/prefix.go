@@ -0,0 +1,281 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Prefix is an IPv4 or IPv6 network: the address of the network and the
+// length of its prefix. Host bits are always zero. The zero Prefix is
+// ::/0.
+type Prefix struct {
+	addr IPv6Address // IPv4-mapped for an IPv4 prefix
+	bits int         // Of the IPv4 or IPv6 address
+	is4  bool
+}
+
+// NewPrefix returns the IPv4 prefix of an address: the network of length
+// bits it is in.
+func NewPrefix(addr IPv4Address, bits int) (Prefix, error) {
+	if bits < 0 || bits > 32 {
+		return Prefix{}, fmt.Errorf("invalid IPv4 prefix length %d", bits)
+	}
+	mask := NetmaskFromLength(bits)
+	for i := range addr {
+		addr[i] &= mask[i]
+	}
+	return Prefix{addr: addr.To6(), bits: bits, is4: true}, nil
+}
+
+// NewPrefixIPv6 returns the IPv6 prefix of an address: the network of
+// length bits it is in.
+func NewPrefixIPv6(addr IPv6Address, bits int) (Prefix, error) {
+	if bits < 0 || bits > 128 {
+		return Prefix{}, fmt.Errorf("invalid IPv6 prefix length %d", bits)
+	}
+	return Prefix{addr: maskIPv6(addr, bits), bits: bits}, nil
+}
+
+// PrefixFromNetmask returns the IPv4 prefix of an address with a netmask,
+// which must be contiguous.
+func PrefixFromNetmask(addr, mask IPv4Address) (Prefix, error) {
+	bits, ok := NetmaskLength(mask)
+	if !ok {
+		return Prefix{}, fmt.Errorf("invalid netmask %s", mask)
+	}
+	return NewPrefix(addr, bits)
+}
+
+// ParsePrefix parses a prefix in CIDR notation, "10.0.0.0/8" or
+// "2001:db8::/32". Host bits are cleared, so "10.1.2.3/8" is 10.0.0.0/8.
+func ParsePrefix(s string) (Prefix, error) {
+	addrStr, bitsStr, ok := strings.Cut(s, "/")
+	if !ok {
+		return Prefix{}, fmt.Errorf("invalid prefix %q: no prefix length", s)
+	}
+	bits, err := strconv.Atoi(bitsStr)
+	if err != nil || bitsStr == "" || bitsStr[0] == '+' || bitsStr[0] == '-' {
+		return Prefix{}, fmt.Errorf("invalid prefix length in %q", s)
+	}
+
+	var p Prefix
+	if strings.Contains(addrStr, ":") {
+		var addr IPv6Address
+		if addr, err = ParseIPv6(addrStr); err == nil {
+			p, err = NewPrefixIPv6(addr, bits)
+		}
+	} else {
+		var addr IPv4Address
+		if addr, err = ParseIPv4(addrStr); err == nil {
+			p, err = NewPrefix(addr, bits)
+		}
+	}
+	if err != nil {
+		return Prefix{}, fmt.Errorf("invalid prefix %q: %w", s, err)
+	}
+	return p, nil
+}
+
+// NetmaskFromLength returns the IPv4 netmask with a prefix length's
+// leading ones, which is clamped to 0..32.
+func NetmaskFromLength(bits int) IPv4Address {
+	var mask IPv4Address
+	switch {
+	case bits >= 32:
+		mask = IPv4Address{0xff, 0xff, 0xff, 0xff}
+	case bits > 0:
+		binary.BigEndian.PutUint32(mask[:], ^uint32(0)<<(32-bits))
+	}
+	return mask
+}
+
+// NetmaskLength returns the prefix length of an IPv4 netmask, and whether
+// the netmask is contiguous (all ones, then all zeros).
+func NetmaskLength(mask IPv4Address) (int, bool) {
+	m := mask.ToUint32()
+	ones := bits.LeadingZeros32(^m)
+	return ones, bits.TrailingZeros32(m) == 32-ones
+}
+
+// Is4 returns true for an IPv4 prefix.
+func (p Prefix) Is4() bool {
+	return p.is4
+}
+
+// Bits returns the prefix length.
+func (p Prefix) Bits() int {
+	return p.bits
+}
+
+// Addr returns the network address of an IPv4 prefix, or 0.0.0.0 for an
+// IPv6 one.
+func (p Prefix) Addr() IPv4Address {
+	if !p.is4 {
+		return IPv4Address{}
+	}
+	addr, _ := p.addr.To4()
+	return addr
+}
+
+// AddrIPv6 returns the network address of an IPv6 prefix, or the
+// IPv4-mapped network address of an IPv4 one.
+func (p Prefix) AddrIPv6() IPv6Address {
+	return p.addr
+}
+
+// Netmask returns the netmask of an IPv4 prefix.
+func (p Prefix) Netmask() IPv4Address {
+	if !p.is4 {
+		return IPv4Address{}
+	}
+	return NetmaskFromLength(p.bits)
+}
+
+// Broadcast returns the directed broadcast address of an IPv4 prefix, its
+// last address.
+func (p Prefix) Broadcast() IPv4Address {
+	if !p.is4 {
+		return IPv4Address{}
+	}
+	return DirectedBroadcast(p.Addr(), p.Netmask())
+}
+
+// Size returns the number of addresses in the prefix, saturating at the
+// largest uint64 for IPv6 prefixes of 64 bits or less.
+func (p Prefix) Size() uint64 {
+	host := 128 - p.bits
+	if p.is4 {
+		host = 32 - p.bits
+	}
+	if host >= 64 {
+		return ^uint64(0)
+	}
+	return 1 << host
+}
+
+// Contains returns true if an IPv4 prefix contains the address.
+func (p Prefix) Contains(addr IPv4Address) bool {
+	return p.is4 && maskIPv6(addr.To6(), 96+p.bits) == p.addr
+}
+
+// ContainsIPv6 returns true if an IPv6 prefix contains the address.
+func (p Prefix) ContainsIPv6(addr IPv6Address) bool {
+	return !p.is4 && maskIPv6(addr, p.bits) == p.addr
+}
+
+// Overlaps returns true if the prefixes have addresses in common: if one
+// contains the other. Prefixes of different families never overlap.
+func (p Prefix) Overlaps(q Prefix) bool {
+	if p.is4 != q.is4 {
+		return false
+	}
+	bits := min(p.bits, q.bits)
+	if p.is4 {
+		bits += 96
+	}
+	return maskIPv6(p.addr, bits) == maskIPv6(q.addr, bits)
+}
+
+// Iterate calls fn with each address of an IPv4 prefix in order, network
+// and broadcast addresses included, until fn returns false.
+func (p Prefix) Iterate(fn func(addr IPv4Address) bool) {
+	if !p.is4 {
+		return
+	}
+	first := uint64(p.Addr().ToUint32())
+	for a := first; a < first+p.Size(); a++ {
+		if !fn(IPv4FromUint32(uint32(a))) {
+			return
+		}
+	}
+}
+
+// IterateIPv6 calls fn with each address of an IPv6 prefix in order,
+// until fn returns false.
+func (p Prefix) IterateIPv6(fn func(addr IPv6Address) bool) {
+	if p.is4 {
+		return
+	}
+	addr := p.addr
+	for {
+		if !fn(addr) {
+			return
+		}
+		// Increment, stopping on leaving the prefix
+		for i := len(addr) - 1; ; i-- {
+			if i < 0 {
+				return
+			}
+			addr[i]++
+			if addr[i] != 0 {
+				break
+			}
+		}
+		if !p.ContainsIPv6(addr) {
+			return
+		}
+	}
+}
+
+// Subnets calls fn with each subnet of length bits of the prefix in order,
+// until fn returns false. It fails if bits is shorter than the prefix or
+// longer than an address.
+func (p Prefix) Subnets(bits int, fn func(subnet Prefix) bool) error {
+	offset, length := 0, 128
+	if p.is4 {
+		offset, length = 96, 32
+	}
+	if bits < p.bits || bits > length {
+		return fmt.Errorf("invalid subnet length %d of %s", bits, p)
+	}
+	if bits == p.bits {
+		fn(p)
+		return nil
+	}
+
+	subnet := Prefix{addr: p.addr, bits: bits, is4: p.is4}
+	last := offset + bits - 1 // Bit incremented for the next subnet
+	for {
+		if !fn(subnet) {
+			return nil
+		}
+		// Add one at the last bit of the subnet, carrying up to the prefix
+		for i := last; ; i-- {
+			if i < offset+p.bits {
+				return nil
+			}
+			mask := byte(0x80) >> (i % 8)
+			subnet.addr[i/8] ^= mask
+			if subnet.addr[i/8]&mask != 0 {
+				break
+			}
+		}
+	}
+}
+
+// String returns the prefix in CIDR notation.
+func (p Prefix) String() string {
+	if p.is4 {
+		return fmt.Sprintf("%s/%d", p.Addr(), p.bits)
+	}
+	return fmt.Sprintf("%s/%d", p.addr, p.bits)
+}
+
+// maskIPv6 clears the bits of an address after the first bits.
+func maskIPv6(addr IPv6Address, bits int) IPv6Address {
+	for i := range addr {
+		switch {
+		case bits >= 8:
+			bits -= 8
+		case bits > 0:
+			addr[i] &= ^byte(0xff >> bits)
+			bits = 0
+		default:
+			addr[i] = 0
+		}
+	}
+	return addr
+}
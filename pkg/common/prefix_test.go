@@ -0,0 +1,244 @@
+package common
+
+import (
+	"testing"
+)
+
+func mustParsePrefix(t *testing.T, s string) Prefix {
+	t.Helper()
+	p, err := ParsePrefix(s)
+	if err != nil {
+		t.Fatalf("ParsePrefix(%q) error = %v", s, err)
+	}
+	return p
+}
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		is4  bool
+		bits int
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8", true, 8},
+		{"10.1.2.3/8", "10.0.0.0/8", true, 8},
+		{"192.168.1.77/26", "192.168.1.64/26", true, 26},
+		{"0.0.0.0/0", "0.0.0.0/0", true, 0},
+		{"10.0.0.1/32", "10.0.0.1/32", true, 32},
+		{"2001:db8::1/32", "2001:db8::/32", false, 32},
+		{"fe80::1:2:3:4/64", "fe80::/64", false, 64},
+		{"2001:db8::ff/121", "2001:db8::80/121", false, 121},
+		{"::/0", "::/0", false, 0},
+	}
+	for _, tt := range tests {
+		p, err := ParsePrefix(tt.s)
+		if err != nil {
+			t.Fatalf("ParsePrefix(%q) error = %v", tt.s, err)
+		}
+		if p.String() != tt.want || p.Is4() != tt.is4 || p.Bits() != tt.bits {
+			t.Errorf("ParsePrefix(%q) = %s (IPv4 %v, %d bits), want %s", tt.s, p, p.Is4(), p.Bits(), tt.want)
+		}
+		if back := mustParsePrefix(t, p.String()); back != p {
+			t.Errorf("ParsePrefix(%q) = %s, want %s", p.String(), back, p)
+		}
+	}
+
+	for _, s := range []string{"10.0.0.0", "10.0.0.0/33", "10.0.0.0/-1", "10.0.0.0/+8", "10.0.0.0/", "10.0.0/8", "2001:db8::/129", "x/8"} {
+		if _, err := ParsePrefix(s); err == nil {
+			t.Errorf("ParsePrefix(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestNetmaskLength(t *testing.T) {
+	tests := []struct {
+		mask IPv4Address
+		bits int
+		ok   bool
+	}{
+		{IPv4Address{0, 0, 0, 0}, 0, true},
+		{IPv4Address{255, 0, 0, 0}, 8, true},
+		{IPv4Address{255, 255, 255, 192}, 26, true},
+		{IPv4Address{255, 255, 255, 255}, 32, true},
+		{IPv4Address{255, 0, 255, 0}, 8, false},
+		{IPv4Address{0, 0, 0, 1}, 0, false},
+	}
+	for _, tt := range tests {
+		bits, ok := NetmaskLength(tt.mask)
+		if bits != tt.bits || ok != tt.ok {
+			t.Errorf("NetmaskLength(%s) = %d, %v, want %d, %v", tt.mask, bits, ok, tt.bits, tt.ok)
+		}
+		if tt.ok && NetmaskFromLength(tt.bits) != tt.mask {
+			t.Errorf("NetmaskFromLength(%d) = %s, want %s", tt.bits, NetmaskFromLength(tt.bits), tt.mask)
+		}
+	}
+
+	if _, err := PrefixFromNetmask(IPv4Address{10, 0, 0, 1}, IPv4Address{255, 0, 255, 0}); err == nil {
+		t.Error("PrefixFromNetmask() with a discontiguous netmask succeeded, want error")
+	}
+	p, err := PrefixFromNetmask(IPv4Address{172, 16, 5, 4}, IPv4Address{255, 240, 0, 0})
+	if err != nil || p.String() != "172.16.0.0/12" {
+		t.Errorf("PrefixFromNetmask() = %s, %v, want 172.16.0.0/12", p, err)
+	}
+}
+
+func TestPrefixAddresses(t *testing.T) {
+	p := mustParsePrefix(t, "192.168.1.64/26")
+	if got, want := p.Addr(), (IPv4Address{192, 168, 1, 64}); got != want {
+		t.Errorf("Addr() = %s, want %s", got, want)
+	}
+	if got, want := p.Netmask(), (IPv4Address{255, 255, 255, 192}); got != want {
+		t.Errorf("Netmask() = %s, want %s", got, want)
+	}
+	if got, want := p.Broadcast(), (IPv4Address{192, 168, 1, 127}); got != want {
+		t.Errorf("Broadcast() = %s, want %s", got, want)
+	}
+	if got := p.Size(); got != 64 {
+		t.Errorf("Size() = %d, want 64", got)
+	}
+	if got, want := p.AddrIPv6(), (IPv4Address{192, 168, 1, 64}).To6(); got != want {
+		t.Errorf("AddrIPv6() = %s, want %s", got, want)
+	}
+
+	v6 := mustParsePrefix(t, "2001:db8::/120")
+	if v6.Addr() != (IPv4Address{}) || v6.Netmask() != (IPv4Address{}) || v6.Size() != 256 {
+		t.Errorf("IPv6 prefix Addr() = %s, Netmask() = %s, Size() = %d", v6.Addr(), v6.Netmask(), v6.Size())
+	}
+	if got := mustParsePrefix(t, "::/0").Size(); got != ^uint64(0) {
+		t.Errorf("Size() of ::/0 = %d, want saturated", got)
+	}
+}
+
+func TestPrefixContains(t *testing.T) {
+	p := mustParsePrefix(t, "10.1.0.0/16")
+	tests := []struct {
+		addr IPv4Address
+		want bool
+	}{
+		{IPv4Address{10, 1, 0, 0}, true},
+		{IPv4Address{10, 1, 255, 255}, true},
+		{IPv4Address{10, 2, 0, 0}, false},
+		{IPv4Address{11, 1, 0, 1}, false},
+	}
+	for _, tt := range tests {
+		if got := p.Contains(tt.addr); got != tt.want {
+			t.Errorf("Contains(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if !mustParsePrefix(t, "0.0.0.0/0").Contains(IPv4Address{1, 2, 3, 4}) {
+		t.Error("0.0.0.0/0 does not contain 1.2.3.4")
+	}
+
+	v6 := mustParsePrefix(t, "2001:db8::/32")
+	in, _ := ParseIPv6("2001:db8:ffff::1")
+	out, _ := ParseIPv6("2001:db9::1")
+	if !v6.ContainsIPv6(in) || v6.ContainsIPv6(out) {
+		t.Errorf("ContainsIPv6() of %s = %v, of %s = %v", in, v6.ContainsIPv6(in), out, v6.ContainsIPv6(out))
+	}
+
+	// Families do not mix
+	if v6.Contains(IPv4Address{32, 1, 13, 184}) || p.ContainsIPv6((IPv4Address{10, 1, 0, 1}).To6()) {
+		t.Error("prefix contains an address of the other family")
+	}
+}
+
+func TestPrefixOverlaps(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"10.0.0.0/8", "10.1.0.0/16", true},
+		{"10.1.0.0/16", "10.0.0.0/8", true},
+		{"10.1.0.0/16", "10.2.0.0/16", false},
+		{"10.0.0.0/8", "10.0.0.0/8", true},
+		{"0.0.0.0/0", "192.168.0.0/24", true},
+		{"192.168.0.0/24", "192.168.1.0/24", false},
+		{"2001:db8::/32", "2001:db8:1::/48", true},
+		{"2001:db8::/32", "2001:db9::/32", false},
+		{"0.0.0.0/0", "::/0", false},
+		{"::ffff:0:0/96", "10.0.0.0/8", false},
+	}
+	for _, tt := range tests {
+		if got := mustParsePrefix(t, tt.a).Overlaps(mustParsePrefix(t, tt.b)); got != tt.want {
+			t.Errorf("%s.Overlaps(%s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixIterate(t *testing.T) {
+	var got []IPv4Address
+	mustParsePrefix(t, "10.0.0.4/30").Iterate(func(addr IPv4Address) bool {
+		got = append(got, addr)
+		return true
+	})
+	want := []IPv4Address{{10, 0, 0, 4}, {10, 0, 0, 5}, {10, 0, 0, 6}, {10, 0, 0, 7}}
+	if len(got) != len(want) {
+		t.Fatalf("Iterate() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Iterate() address %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	// The last address of the space, and stopping early
+	n := 0
+	mustParsePrefix(t, "255.255.255.254/31").Iterate(func(IPv4Address) bool { n++; return true })
+	if n != 2 {
+		t.Errorf("Iterate() of 255.255.255.254/31 = %d addresses, want 2", n)
+	}
+	n = 0
+	mustParsePrefix(t, "0.0.0.0/0").Iterate(func(IPv4Address) bool { n++; return n < 3 })
+	if n != 3 {
+		t.Errorf("Iterate() called fn %d times after it returned false, want 3", n)
+	}
+
+	var got6 []string
+	mustParsePrefix(t, "2001:db8::fe/127").IterateIPv6(func(addr IPv6Address) bool {
+		got6 = append(got6, addr.String())
+		return true
+	})
+	if len(got6) != 2 || got6[0] != "2001:db8::fe" || got6[1] != "2001:db8::ff" {
+		t.Errorf("IterateIPv6() = %v, want 2001:db8::fe and ::ff", got6)
+	}
+}
+
+func TestPrefixSubnets(t *testing.T) {
+	tests := []struct {
+		prefix string
+		bits   int
+		want   []string
+	}{
+		{"10.0.0.0/24", 26, []string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/26", "10.0.0.192/26"}},
+		{"10.0.0.0/15", 16, []string{"10.0.0.0/16", "10.1.0.0/16"}},
+		{"10.0.0.0/24", 24, []string{"10.0.0.0/24"}},
+		{"255.255.255.252/30", 31, []string{"255.255.255.252/31", "255.255.255.254/31"}},
+		{"2001:db8::/47", 48, []string{"2001:db8::/48", "2001:db8:1::/48"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		err := mustParsePrefix(t, tt.prefix).Subnets(tt.bits, func(p Prefix) bool {
+			got = append(got, p.String())
+			return true
+		})
+		if err != nil {
+			t.Fatalf("Subnets(%d) of %s error = %v", tt.bits, tt.prefix, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("Subnets(%d) of %s = %v, want %v", tt.bits, tt.prefix, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Subnets(%d) of %s = %v, want %v", tt.bits, tt.prefix, got, tt.want)
+				break
+			}
+		}
+	}
+
+	p := mustParsePrefix(t, "10.0.0.0/24")
+	for _, bits := range []int{23, 33} {
+		if err := p.Subnets(bits, func(Prefix) bool { return true }); err == nil {
+			t.Errorf("Subnets(%d) of %s succeeded, want error", bits, p)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SocketOption names an option set with a socket's SetSockOpt and read
+// with GetSockOpt, after the Berkeley socket option it corresponds to. Each
+// option takes values of one type, given with its constant.
+type SocketOption int
+
+// Socket options.
+const (
+	OptReuseAddr         SocketOption = iota + 1 // bool, SO_REUSEADDR
+	OptSendBuffer                                // int bytes, SO_SNDBUF
+	OptReceiveBuffer                             // int bytes, SO_RCVBUF
+	OptTTL                                       // int 1-255, IP_TTL
+	OptTOS                                       // int 0-255, IP_TOS
+	OptLinger                                    // time.Duration, SO_LINGER; negative disables lingering
+	OptKeepAlive                                 // bool, SO_KEEPALIVE
+	OptKeepAliveIdle                             // time.Duration, TCP_KEEPIDLE
+	OptKeepAliveInterval                         // time.Duration, TCP_KEEPINTVL
+	OptKeepAliveCount                            // int, TCP_KEEPCNT
+	OptNoDelay                                   // bool, TCP_NODELAY
+	OptBroadcast                                 // bool, SO_BROADCAST
+	OptMulticastTTL                              // int 1-255, IP_MULTICAST_TTL
+	OptOOBInline                                 // bool, SO_OOBINLINE
+	OptMaxPacingRate                             // int bytes per second, SO_MAX_PACING_RATE; 0 for no limit
+	OptBindToDevice                              // string interface name, SO_BINDTODEVICE; "" for any
+)
+
+// MaxDeviceNameLength is the longest interface name OptBindToDevice takes,
+// Linux's IFNAMSIZ less its NUL.
+const MaxDeviceNameLength = 15
+
+var (
+	// ErrOptionNotSupported is returned for an option the socket does not
+	// have.
+	ErrOptionNotSupported = errors.New("socket option not supported")
+
+	// ErrInvalidOptionValue is returned for a value of the wrong type or
+	// out of range for the option.
+	ErrInvalidOptionValue = errors.New("invalid socket option value")
+)
+
+var socketOptionNames = map[SocketOption]string{
+	OptReuseAddr:         "SO_REUSEADDR",
+	OptSendBuffer:        "SO_SNDBUF",
+	OptReceiveBuffer:     "SO_RCVBUF",
+	OptTTL:               "IP_TTL",
+	OptTOS:               "IP_TOS",
+	OptLinger:            "SO_LINGER",
+	OptKeepAlive:         "SO_KEEPALIVE",
+	OptKeepAliveIdle:     "TCP_KEEPIDLE",
+	OptKeepAliveInterval: "TCP_KEEPINTVL",
+	OptKeepAliveCount:    "TCP_KEEPCNT",
+	OptNoDelay:           "TCP_NODELAY",
+	OptBroadcast:         "SO_BROADCAST",
+	OptMulticastTTL:      "IP_MULTICAST_TTL",
+	OptOOBInline:         "SO_OOBINLINE",
+	OptMaxPacingRate:     "SO_MAX_PACING_RATE",
+	OptBindToDevice:      "SO_BINDTODEVICE",
+}
+
+// String returns the name of the corresponding Berkeley socket option.
+func (o SocketOption) String() string {
+	if name, ok := socketOptionNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("SocketOption(%d)", int(o))
+}
+
+// BoolOption returns value as the bool opt takes.
+func BoolOption(opt SocketOption, value any) (bool, error) {
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s: %w: %T, want bool", opt, ErrInvalidOptionValue, value)
+	}
+	return b, nil
+}
+
+// IntOption returns value as the int opt takes, checking it is within
+// [lo, hi].
+func IntOption(opt SocketOption, value any, lo, hi int) (int, error) {
+	n, ok := value.(int)
+	if !ok {
+		return 0, fmt.Errorf("%s: %w: %T, want int", opt, ErrInvalidOptionValue, value)
+	}
+	if n < lo || n > hi {
+		return 0, fmt.Errorf("%s: %w: %d not in [%d, %d]", opt, ErrInvalidOptionValue, n, lo, hi)
+	}
+	return n, nil
+}
+
+// DurationOption returns value as the time.Duration opt takes, checking
+// it is at least min.
+func DurationOption(opt SocketOption, value any, min time.Duration) (time.Duration, error) {
+	d, ok := value.(time.Duration)
+	if !ok {
+		return 0, fmt.Errorf("%s: %w: %T, want time.Duration", opt, ErrInvalidOptionValue, value)
+	}
+	if d < min {
+		return 0, fmt.Errorf("%s: %w: %v less than %v", opt, ErrInvalidOptionValue, d, min)
+	}
+	return d, nil
+}
+
+// StringOption returns value as the string opt takes, checking it is at
+// most max bytes long.
+func StringOption(opt SocketOption, value any, max int) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: %w: %T, want string", opt, ErrInvalidOptionValue, value)
+	}
+	if len(s) > max {
+		return "", fmt.Errorf("%s: %w: %q longer than %d bytes", opt, ErrInvalidOptionValue, s, max)
+	}
+	return s, nil
+}
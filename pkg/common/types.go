@@ -57,6 +57,44 @@ func (ip IPv4Address) ToUint32() uint32 {
 	return binary.BigEndian.Uint32(ip[:])
 }
 
+// IPv4Broadcast is the limited broadcast address (255.255.255.255).
+var IPv4Broadcast = IPv4Address{255, 255, 255, 255}
+
+// IsBroadcast returns true if this is the limited broadcast address.
+func (ip IPv4Address) IsBroadcast() bool {
+	return ip == IPv4Broadcast
+}
+
+// IsMulticast returns true if this is a multicast address (224.0.0.0/4).
+func (ip IPv4Address) IsMulticast() bool {
+	return ip[0]&0xf0 == 0xe0
+}
+
+// DirectedBroadcast returns the broadcast address of the subnet addr is
+// in, given the subnet's mask (e.g., 192.168.1.255 for 192.168.1.7/24).
+func DirectedBroadcast(addr, mask IPv4Address) IPv4Address {
+	var bcast IPv4Address
+	for i := range addr {
+		bcast[i] = addr[i] | ^mask[i]
+	}
+	return bcast
+}
+
+// IPv4MulticastMAC returns the Ethernet address for an IPv4 multicast group
+// (01:00:5e followed by the low 23 bits of the group, RFC 1112).
+func IPv4MulticastMAC(group IPv4Address) MACAddress {
+	return MACAddress{0x01, 0x00, 0x5e, group[1] & 0x7f, group[2], group[3]}
+}
+
+// To6 returns the IPv4-mapped IPv6 address of ip (::ffff:a.b.c.d, RFC
+// 4291 Section 2.5.5.2).
+func (ip IPv4Address) To6() IPv6Address {
+	var addr IPv6Address
+	addr[10], addr[11] = 0xff, 0xff
+	copy(addr[12:], ip[:])
+	return addr
+}
+
 // ParseIPv4 parses a string IPv4 address (e.g., "192.168.1.1").
 func ParseIPv4(s string) (IPv4Address, error) {
 	ip := net.ParseIP(s)
@@ -118,6 +156,27 @@ func (ip IPv6Address) IsLinkLocal() bool {
 	return ip[0] == 0xfe && (ip[1]&0xc0) == 0x80
 }
 
+// To4 returns the IPv4 address of an IPv4-mapped address, and whether ip
+// is one.
+func (ip IPv6Address) To4() (IPv4Address, bool) {
+	var v4 IPv4Address
+	for i := 0; i < 10; i++ {
+		if ip[i] != 0 {
+			return v4, false
+		}
+	}
+	if ip[10] != 0xff || ip[11] != 0xff {
+		return v4, false
+	}
+	copy(v4[:], ip[12:])
+	return v4, true
+}
+
+// IsUnspecified returns true if this is the unspecified address (::).
+func (ip IPv6Address) IsUnspecified() bool {
+	return ip == IPv6Address{}
+}
+
 // IsMulticast returns true if this is a multicast address (ff00::/8).
 func (ip IPv6Address) IsMulticast() bool {
 	return ip[0] == 0xff
@@ -131,6 +190,14 @@ const (
 	EtherTypeIPv4 EtherType = 0x0800 // Internet Protocol version 4
 	EtherTypeARP  EtherType = 0x0806 // Address Resolution Protocol
 	EtherTypeIPv6 EtherType = 0x86DD // Internet Protocol version 6
+	EtherTypeVLAN EtherType = 0x8100 // IEEE 802.1Q VLAN tag (C-tag)
+	EtherTypeQinQ EtherType = 0x88A8 // IEEE 802.1ad service VLAN tag (S-tag)
+	EtherTypeLLDP EtherType = 0x88CC // Link Layer Discovery Protocol
+
+	EtherTypeEAPOL EtherType = 0x888E // IEEE 802.1X EAP over LAN
+
+	EtherTypePPPoEDiscovery EtherType = 0x8863 // PPP over Ethernet discovery stage
+	EtherTypePPPoESession   EtherType = 0x8864 // PPP over Ethernet session stage
 )
 
 // String returns a human-readable name for the EtherType.
@@ -142,6 +209,18 @@ func (et EtherType) String() string {
 		return "ARP"
 	case EtherTypeIPv6:
 		return "IPv6"
+	case EtherTypeVLAN:
+		return "802.1Q"
+	case EtherTypeQinQ:
+		return "802.1ad"
+	case EtherTypeLLDP:
+		return "LLDP"
+	case EtherTypeEAPOL:
+		return "EAPOL"
+	case EtherTypePPPoEDiscovery:
+		return "PPPoE-Discovery"
+	case EtherTypePPPoESession:
+		return "PPPoE-Session"
 	default:
 		return fmt.Sprintf("Unknown(0x%04x)", uint16(et))
 	}
@@ -153,11 +232,14 @@ type Protocol uint8
 // Common protocol numbers.
 const (
 	ProtocolICMP   Protocol = 1   // Internet Control Message Protocol
+	ProtocolIGMP   Protocol = 2   // Internet Group Management Protocol
 	ProtocolTCP    Protocol = 6   // Transmission Control Protocol
 	ProtocolUDP    Protocol = 17  // User Datagram Protocol
 	ProtocolIPv6   Protocol = 41  // IPv6 encapsulation
+	ProtocolESP    Protocol = 50  // IPsec Encapsulating Security Payload
 	ProtocolICMPv6 Protocol = 58  // ICMPv6
 	ProtocolNoNext Protocol = 59  // No Next Header for IPv6
+	ProtocolSCTP   Protocol = 132 // Stream Control Transmission Protocol
 	ProtocolFragment Protocol = 44 // IPv6 Fragment Header
 	ProtocolRouting Protocol = 43 // IPv6 Routing Header
 )
@@ -167,16 +249,22 @@ func (p Protocol) String() string {
 	switch p {
 	case ProtocolICMP:
 		return "ICMP"
+	case ProtocolIGMP:
+		return "IGMP"
 	case ProtocolTCP:
 		return "TCP"
 	case ProtocolUDP:
 		return "UDP"
 	case ProtocolIPv6:
 		return "IPv6"
+	case ProtocolESP:
+		return "ESP"
 	case ProtocolICMPv6:
 		return "ICMPv6"
 	case ProtocolNoNext:
 		return "NoNext"
+	case ProtocolSCTP:
+		return "SCTP"
 	case ProtocolFragment:
 		return "Fragment"
 	case ProtocolRouting:
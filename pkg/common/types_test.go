@@ -167,6 +167,65 @@ func TestIPv4FromUint32(t *testing.T) {
 	}
 }
 
+func TestIPv4AddressClass(t *testing.T) {
+	tests := []struct {
+		ip            IPv4Address
+		wantBroadcast bool
+		wantMulticast bool
+	}{
+		{IPv4Address{255, 255, 255, 255}, true, false},
+		{IPv4Address{224, 0, 0, 1}, false, true},
+		{IPv4Address{239, 255, 255, 250}, false, true},
+		{IPv4Address{240, 0, 0, 1}, false, false},
+		{IPv4Address{192, 168, 1, 255}, false, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.ip.IsBroadcast(); got != tt.wantBroadcast {
+			t.Errorf("%v.IsBroadcast() = %v, want %v", tt.ip, got, tt.wantBroadcast)
+		}
+		if got := tt.ip.IsMulticast(); got != tt.wantMulticast {
+			t.Errorf("%v.IsMulticast() = %v, want %v", tt.ip, got, tt.wantMulticast)
+		}
+	}
+}
+
+func TestDirectedBroadcast(t *testing.T) {
+	tests := []struct {
+		addr, mask IPv4Address
+		want       IPv4Address
+	}{
+		{IPv4Address{192, 168, 1, 7}, IPv4Address{255, 255, 255, 0}, IPv4Address{192, 168, 1, 255}},
+		{IPv4Address{10, 1, 2, 3}, IPv4Address{255, 0, 0, 0}, IPv4Address{10, 255, 255, 255}},
+		{IPv4Address{172, 16, 5, 9}, IPv4Address{255, 255, 252, 0}, IPv4Address{172, 16, 7, 255}},
+	}
+
+	for _, tt := range tests {
+		if got := DirectedBroadcast(tt.addr, tt.mask); got != tt.want {
+			t.Errorf("DirectedBroadcast(%v, %v) = %v, want %v", tt.addr, tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestIPv4MappedIPv6(t *testing.T) {
+	v4 := IPv4Address{192, 0, 2, 1}
+	mapped := v4.To6()
+	if got := mapped.String(); got != "192.0.2.1" {
+		t.Errorf("To6().String() = %q, want %q", got, "192.0.2.1")
+	}
+	if got, ok := mapped.To4(); !ok || got != v4 {
+		t.Errorf("To4() = %v, %v, want %v, true", got, ok, v4)
+	}
+
+	v6, _ := ParseIPv6("2001:db8::1")
+	if _, ok := v6.To4(); ok {
+		t.Error("To4() of 2001:db8::1 succeeded")
+	}
+	if _, ok := (IPv6Address{}).To4(); ok {
+		t.Error("To4() of :: succeeded")
+	}
+}
+
 func TestEtherType(t *testing.T) {
 	tests := []struct {
 		etherType EtherType
@@ -0,0 +1,297 @@
+// Package decode turns raw frames into a layered, human-readable decode.
+//
+// Frame decodes an Ethernet frame layer by layer: Ethernet, any VLAN tags,
+// IPv4, IPv6 or ARP, then TCP, UDP or ICMP, and finally guesses the
+// application protocol of the payload (HTTP, TLS, DNS, ...) from its ports
+// and first bytes. Decoding never fails: a malformed or truncated layer
+// stops the decode and is recorded in Packet.Err, keeping the layers
+// decoded before it.
+//
+// A Packet renders as a tcpdump-style line with String, or one line per
+// layer with Verbose. Packets are fmt.Stringers, so they can be passed
+// directly to logging.Subsystem.Packet.
+package decode
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ipv6"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// IPv6 extension headers skipped on the way to the upper-layer header.
+const (
+	ipv6HopByHop    common.Protocol = 0
+	ipv6DestOptions common.Protocol = 60
+)
+
+// Packet is a decoded packet. Only the fields of the layers present are
+// set; the decoded headers alias the data they were decoded from.
+type Packet struct {
+	Length int // Bytes decoded, including every header
+
+	Ethernet *ethernet.Frame
+	ARP      *arp.Packet
+	IPv4     *ip.Packet
+	IPv6     *ipv6.Packet
+	TCP      *tcp.Segment
+	UDP      *udp.Packet
+	ICMP     *icmp.Message
+
+	// Protocol is the upper-layer protocol of an IP packet, after any IPv6
+	// extension headers. Fragment is set for IPv4 and IPv6 fragments; only
+	// the first fragment of a datagram has an upper-layer header to decode.
+	Protocol common.Protocol
+	Fragment bool
+
+	// Payload is what is left once every known header has been removed:
+	// the application data of a TCP segment or UDP datagram, or the body of
+	// a layer that is not decoded. Application names the protocol Payload
+	// appears to carry, or is empty if it is not recognized.
+	Payload     []byte
+	Application string
+
+	// Err is why the decode stopped early, if it did, and Truncated names
+	// the layer that failed to decode
+	Err       error
+	Truncated string
+
+	// pseudo holds the pseudo-header addresses of a segment decoded by
+	// FromTCP, which has no IP header
+	pseudo *[2]common.IPv4Address
+}
+
+// Frame decodes an Ethernet frame.
+func Frame(data []byte) *Packet {
+	p := &Packet{Length: len(data)}
+	frame := &ethernet.Frame{}
+	if err := frame.Decode(data); err != nil {
+		p.fail("ethernet", err)
+		return p
+	}
+	p.decodeEthernet(frame)
+	return p
+}
+
+// FromFrame decodes an Ethernet frame that has already been parsed.
+func FromFrame(frame *ethernet.Frame) *Packet {
+	p := &Packet{Length: frame.HeaderLen() + len(frame.Payload)}
+	p.decodeEthernet(frame)
+	return p
+}
+
+// IP decodes an IPv4 or IPv6 packet without a link-layer header, as read
+// from a TUN device or a raw (LinkTypeRaw) capture.
+func IP(data []byte) *Packet {
+	p := &Packet{Length: len(data)}
+	if len(data) == 0 {
+		p.fail("ip", fmt.Errorf("empty packet"))
+		return p
+	}
+	switch data[0] >> 4 {
+	case ip.IPv4Version:
+		p.decodeIPv4(data)
+	case ipv6.IPv6Version:
+		p.decodeIPv6(data)
+	default:
+		p.fail("ip", fmt.Errorf("invalid IP version: %d", data[0]>>4))
+	}
+	return p
+}
+
+// FromIPv4 decodes an IPv4 packet that has already been parsed.
+func FromIPv4(pkt *ip.Packet) *Packet {
+	p := &Packet{Length: pkt.Size()}
+	p.IPv4 = pkt
+	p.decodeIPv4Payload()
+	return p
+}
+
+// FromTCP decodes a TCP segment that has already been parsed, with the
+// addresses of its pseudo-header.
+func FromTCP(seg *tcp.Segment, src, dst common.IPv4Address) *Packet {
+	p := &Packet{
+		Length:   int(seg.DataOffset)*4 + len(seg.Data),
+		Protocol: common.ProtocolTCP,
+		pseudo:   &[2]common.IPv4Address{src, dst},
+	}
+	p.TCP = seg
+	p.Payload = seg.Data
+	p.Application = guessTCP(seg.SourcePort, seg.DestinationPort, seg.Data)
+	return p
+}
+
+// fail records the error that stopped the decode at layer.
+func (p *Packet) fail(layer string, err error) {
+	p.Truncated = layer
+	p.Err = fmt.Errorf("%s: %w", layer, err)
+}
+
+func (p *Packet) decodeEthernet(frame *ethernet.Frame) {
+	p.Ethernet = frame
+	p.Payload = frame.Payload
+
+	switch frame.EtherType {
+	case common.EtherTypeIPv4:
+		p.decodeIPv4(frame.Payload)
+	case common.EtherTypeIPv6:
+		p.decodeIPv6(frame.Payload)
+	case common.EtherTypeARP:
+		pkt, err := arp.Parse(frame.Payload)
+		if err != nil {
+			p.fail("arp", err)
+			return
+		}
+		p.ARP = pkt
+		p.Payload = nil
+	}
+}
+
+func (p *Packet) decodeIPv4(data []byte) {
+	pkt := &ip.Packet{}
+	if err := pkt.Decode(data); err != nil {
+		p.fail("ip", err)
+		return
+	}
+	p.IPv4 = pkt
+	p.decodeIPv4Payload()
+}
+
+func (p *Packet) decodeIPv4Payload() {
+	p.Protocol = p.IPv4.Protocol
+	p.Payload = p.IPv4.Payload
+	if p.IPv4.IsFragment() {
+		p.Fragment = true
+		if p.IPv4.FragmentOffset != 0 {
+			return
+		}
+	}
+	p.decodeTransport()
+}
+
+func (p *Packet) decodeIPv6(data []byte) {
+	pkt, err := ipv6.Parse(data)
+	if err != nil {
+		p.fail("ip6", err)
+		return
+	}
+	p.IPv6 = pkt
+	p.Protocol = pkt.NextHeader
+	p.Payload = pkt.Payload
+
+	// Skip extension headers to find the upper-layer protocol
+	for {
+		switch p.Protocol {
+		case ipv6HopByHop, common.ProtocolRouting, ipv6DestOptions:
+			if len(p.Payload) < 8 || len(p.Payload) < (int(p.Payload[1])+1)*8 {
+				p.fail("ip6", fmt.Errorf("extension header %d truncated", p.Protocol))
+				return
+			}
+			length := (int(p.Payload[1]) + 1) * 8
+			p.Protocol = common.Protocol(p.Payload[0])
+			p.Payload = p.Payload[length:]
+		case common.ProtocolFragment:
+			if len(p.Payload) < 8 {
+				p.fail("ip6", fmt.Errorf("fragment header truncated"))
+				return
+			}
+			p.Protocol = common.Protocol(p.Payload[0])
+			offsetFlags := binary.BigEndian.Uint16(p.Payload[2:4])
+			p.Payload = p.Payload[8:]
+			p.Fragment = true
+			if offsetFlags>>3 != 0 {
+				return
+			}
+		default:
+			p.decodeTransport()
+			return
+		}
+	}
+}
+
+// decodeTransport decodes the upper-layer header in p.Payload.
+func (p *Packet) decodeTransport() {
+	fail := p.fail
+	if p.Fragment {
+		// In a first fragment the header describes the whole datagram, so
+		// it can fail to decode without the packet being malformed
+		fail = func(string, error) {}
+	}
+
+	switch p.Protocol {
+	case common.ProtocolTCP:
+		seg := &tcp.Segment{}
+		if err := seg.Decode(p.Payload); err != nil {
+			fail("tcp", err)
+			return
+		}
+		p.TCP = seg
+		p.Payload = seg.Data
+		p.Application = guessTCP(seg.SourcePort, seg.DestinationPort, seg.Data)
+	case common.ProtocolUDP:
+		pkt := &udp.Packet{}
+		if err := pkt.Decode(p.Payload); err != nil {
+			fail("udp", err)
+			return
+		}
+		p.UDP = pkt
+		p.Payload = pkt.Data
+		p.Application = guessUDP(pkt.SourcePort, pkt.DestinationPort, pkt.Data)
+	case common.ProtocolICMP:
+		if p.IPv6 != nil {
+			return
+		}
+		msg, err := icmp.Parse(p.Payload)
+		if err != nil {
+			fail("icmp", err)
+			return
+		}
+		p.ICMP = msg
+		p.Payload = msg.Data
+	case common.ProtocolICMPv6:
+		// Rendered from Payload: type, code, checksum and the message body
+		if len(p.Payload) < 4 {
+			fail("icmp6", fmt.Errorf("message too short: %d bytes", len(p.Payload)))
+		}
+	}
+}
+
+// Layers returns the names of the decoded layers, outermost first.
+func (p *Packet) Layers() []string {
+	var layers []string
+	if p.Ethernet != nil {
+		layers = append(layers, "Ethernet")
+		for _, tag := range p.Ethernet.VLAN {
+			layers = append(layers, tag.TPID.String())
+		}
+	}
+	switch {
+	case p.ARP != nil:
+		layers = append(layers, "ARP")
+	case p.IPv4 != nil:
+		layers = append(layers, "IPv4")
+	case p.IPv6 != nil:
+		layers = append(layers, "IPv6")
+	}
+	switch {
+	case p.TCP != nil:
+		layers = append(layers, "TCP")
+	case p.UDP != nil:
+		layers = append(layers, "UDP")
+	case p.ICMP != nil:
+		layers = append(layers, "ICMP")
+	case p.Protocol == common.ProtocolICMPv6 && !p.Fragment:
+		layers = append(layers, "ICMPv6")
+	}
+	if p.Application != "" {
+		layers = append(layers, p.Application)
+	}
+	return layers
+}
@@ -0,0 +1,376 @@
+package decode
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/internal/fuzzseed"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ipv6"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	srcMAC = common.MACAddress{0x02, 0, 0, 0, 0, 0x01}
+	dstMAC = common.MACAddress{0x02, 0, 0, 0, 0, 0x02}
+
+	vendorMAC = common.MACAddress{0x00, 0x0c, 0x29, 0xaa, 0xbb, 0xcc}
+
+	srcIP = common.IPv4Address{10, 0, 0, 1}
+	dstIP = common.IPv4Address{10, 0, 0, 2}
+)
+
+// ipv4Frame builds an Ethernet frame carrying an IPv4 packet.
+func ipv4Frame(t *testing.T, protocol common.Protocol, payload []byte) []byte {
+	t.Helper()
+	pkt := ip.NewPacket(srcIP, dstIP, protocol, payload)
+	data, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	return ethernet.NewFrame(dstMAC, srcMAC, common.EtherTypeIPv4, data).Serialize()
+}
+
+// tcpFrame builds an Ethernet frame carrying a TCP segment.
+func tcpFrame(t *testing.T, seg *tcp.Segment) []byte {
+	t.Helper()
+	data, err := seg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	return ipv4Frame(t, common.ProtocolTCP, data)
+}
+
+// udpFrame builds an Ethernet frame carrying a UDP datagram.
+func udpFrame(t *testing.T, srcPort, dstPort uint16, payload []byte) []byte {
+	t.Helper()
+	data, err := udp.NewPacket(srcPort, dstPort, payload).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	return ipv4Frame(t, common.ProtocolUDP, data)
+}
+
+// icmpFrame builds an Ethernet frame carrying an ICMP message.
+func icmpFrame(t *testing.T, msg *icmp.Message) []byte {
+	t.Helper()
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	return ipv4Frame(t, common.ProtocolICMP, data)
+}
+
+func TestFrameString(t *testing.T) {
+	syn := tcp.NewSegment(40000, 80, 1000, 0, tcp.FlagSYN, 65535, nil)
+	syn.Options = append(append(tcp.BuildMSSOption(1460), tcp.OptionKindNOP), tcp.BuildWindowScaleOption(7)...)
+	syn.Options = append(syn.Options, tcp.BuildSACKPermittedOption()...)
+	syn.Options = append(syn.Options, tcp.OptionKindNOP, tcp.OptionKindNOP)
+	syn.Options = append(syn.Options, tcp.BuildTimestampOption(1, 0)...)
+	syn.DataOffset = uint8(5 + len(syn.Options)/4)
+
+	request := tcp.NewSegment(40000, 80, 1001, 5001, tcp.FlagPSH|tcp.FlagACK, 502, []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+
+	sack := tcp.NewSegment(80, 40000, 5001, 1001, tcp.FlagACK, 502, nil)
+	sack.Options = append([]byte{tcp.OptionKindNOP, tcp.OptionKindNOP}, tcp.BuildSACKOption([]tcp.SACKBlock{{LeftEdge: 2001, RightEdge: 3001}})...)
+	sack.DataOffset = uint8(5 + len(sack.Options)/4)
+
+	dns := make([]byte, 12)
+	dns[5] = 1 // One question
+
+	// A port unreachable quoting a UDP datagram sent from srcIP to dstIP
+	quoted := ip.NewPacket(srcIP, dstIP, common.ProtocolUDP, []byte{0x9c, 0x40, 0, 53, 0, 8, 0, 0})
+	original, err := quoted.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	vlan := ethernet.NewFrame(dstMAC, srcMAC, common.EtherTypeARP, arp.NewRequest(srcMAC, srcIP, dstIP).Serialize())
+	vlan.VLAN = []ethernet.VLANTag{ethernet.NewVLANTag(100)}
+
+	tests := []struct {
+		name   string
+		frame  []byte
+		want   string
+		layers []string
+	}{
+		{
+			name:   "ARP request",
+			frame:  ethernet.NewFrame(common.BroadcastMAC, srcMAC, common.EtherTypeARP, arp.NewRequest(srcMAC, srcIP, dstIP).Serialize()).Serialize(),
+			want:   "ARP, Request who-has 10.0.0.2 tell 10.0.0.1, length 28",
+			layers: []string{"Ethernet", "ARP"},
+		},
+		{
+			name:   "ARP reply",
+			frame:  ethernet.NewFrame(srcMAC, dstMAC, common.EtherTypeARP, arp.NewReply(dstMAC, dstIP, srcMAC, srcIP).Serialize()).Serialize(),
+			want:   "ARP, Reply 10.0.0.2 is-at 02:00:00:00:00:02, length 28",
+			layers: []string{"Ethernet", "ARP"},
+		},
+		{
+			name:   "ARP reply with vendor",
+			frame:  ethernet.NewFrame(srcMAC, vendorMAC, common.EtherTypeARP, arp.NewReply(vendorMAC, dstIP, srcMAC, srcIP).Serialize()).Serialize(),
+			want:   "ARP, Reply 10.0.0.2 is-at 00:0c:29:aa:bb:cc (VMware), length 28",
+			layers: []string{"Ethernet", "ARP"},
+		},
+		{
+			name:   "VLAN tagged",
+			frame:  vlan.Serialize(),
+			want:   "vlan 100, ARP, Request who-has 10.0.0.2 tell 10.0.0.1, length 28",
+			layers: []string{"Ethernet", "802.1Q", "ARP"},
+		},
+		{
+			name:   "TCP SYN with options",
+			frame:  tcpFrame(t, syn),
+			want:   "IP 10.0.0.1.40000 > 10.0.0.2.80: Flags [S], seq 1000, win 65535, options [mss 1460,nop,wscale 7,sackOK,nop,nop,TS val 1 ecr 0,eol], length 0",
+			layers: []string{"Ethernet", "IPv4", "TCP"},
+		},
+		{
+			name:   "HTTP request",
+			frame:  tcpFrame(t, request),
+			want:   "IP 10.0.0.1.40000 > 10.0.0.2.80: Flags [P.], seq 1001:1028, ack 5001, win 502, length 27: HTTP, GET / HTTP/1.1",
+			layers: []string{"Ethernet", "IPv4", "TCP", "HTTP"},
+		},
+		{
+			name:   "SACK",
+			frame:  tcpFrame(t, sack),
+			want:   "IP 10.0.0.1.80 > 10.0.0.2.40000: Flags [.], ack 1001, win 502, options [nop,nop,sack 1 {2001:3001}], length 0",
+			layers: []string{"Ethernet", "IPv4", "TCP"},
+		},
+		{
+			name:   "DNS query",
+			frame:  udpFrame(t, 40000, 53, dns),
+			want:   "IP 10.0.0.1.40000 > 10.0.0.2.53: UDP, length 12: DNS, query id 0, 1 questions, 0 answers",
+			layers: []string{"Ethernet", "IPv4", "UDP", "DNS"},
+		},
+		{
+			name:   "unrecognized UDP",
+			frame:  udpFrame(t, 40000, 9, []byte("discard")),
+			want:   "IP 10.0.0.1.40000 > 10.0.0.2.9: UDP, length 7",
+			layers: []string{"Ethernet", "IPv4", "UDP"},
+		},
+		{
+			name:   "echo request",
+			frame:  icmpFrame(t, icmp.NewEchoRequest(1, 2, make([]byte, 56))),
+			want:   "IP 10.0.0.1 > 10.0.0.2: ICMP echo request, id 1, seq 2, length 64",
+			layers: []string{"Ethernet", "IPv4", "ICMP"},
+		},
+		{
+			name:   "port unreachable",
+			frame:  icmpFrame(t, icmp.NewDestinationUnreachable(icmp.CodePortUnreachable, original)),
+			want:   "IP 10.0.0.1 > 10.0.0.2: ICMP 10.0.0.2 udp port 53 unreachable, length 36",
+			layers: []string{"Ethernet", "IPv4", "ICMP"},
+		},
+		{
+			name:   "unknown protocol",
+			frame:  ipv4Frame(t, 47, []byte{1, 2, 3, 4}),
+			want:   "IP 10.0.0.1 > 10.0.0.2: ip-proto-47, length 4",
+			layers: []string{"Ethernet", "IPv4"},
+		},
+		{
+			name:   "unknown EtherType",
+			frame:  ethernet.NewFrame(dstMAC, srcMAC, 0x88b5, []byte{1, 2, 3}).Serialize(),
+			want:   "ethertype 0x88b5, length 46",
+			layers: []string{"Ethernet"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Frame(tt.frame)
+			if p.Err != nil {
+				t.Fatalf("Frame() error = %v", p.Err)
+			}
+			if got := p.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+			if got := p.Layers(); !reflect.DeepEqual(got, tt.layers) {
+				t.Errorf("Layers() = %v, want %v", got, tt.layers)
+			}
+		})
+	}
+}
+
+func TestFrameFragment(t *testing.T) {
+	pkt := ip.NewPacket(srcIP, dstIP, common.ProtocolUDP, make([]byte, 16))
+	pkt.FragmentOffset = 185
+	data, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	p := Frame(ethernet.NewFrame(dstMAC, srcMAC, common.EtherTypeIPv4, data).Serialize())
+	if !p.Fragment || p.UDP != nil {
+		t.Fatalf("Fragment = %v, UDP = %v, want a fragment with no UDP layer", p.Fragment, p.UDP)
+	}
+	if want := "IP 10.0.0.1 > 10.0.0.2: UDP fragment, length 16"; p.String() != want {
+		t.Errorf("String() = %q, want %q", p.String(), want)
+	}
+}
+
+func TestFrameTruncated(t *testing.T) {
+	seg, err := tcp.NewSegment(40000, 80, 1, 0, tcp.FlagSYN, 1024, nil).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		frame     []byte
+		truncated string
+		want      string
+	}{
+		{
+			name:      "short Ethernet header",
+			frame:     make([]byte, 10),
+			truncated: "ethernet",
+			want:      "[|ethernet]",
+		},
+		{
+			name:      "short IPv4 header",
+			frame:     ethernet.NewFrame(dstMAC, srcMAC, common.EtherTypeIPv4, []byte{0x45, 0, 0}).Serialize()[:17],
+			truncated: "ip",
+			want:      "IPv4, length 3 [|ip]",
+		},
+		{
+			name:      "short TCP header",
+			frame:     ipv4Frame(t, common.ProtocolTCP, seg[:12]),
+			truncated: "tcp",
+			want:      "IP 10.0.0.1 > 10.0.0.2: TCP, length 12 [|tcp]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Frame(tt.frame)
+			if p.Err == nil {
+				t.Fatalf("Frame() error = nil, want an error")
+			}
+			if p.Truncated != tt.truncated {
+				t.Errorf("Truncated = %q, want %q", p.Truncated, tt.truncated)
+			}
+			if got := p.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(p.Verbose(), "Error: "+tt.truncated) {
+				t.Errorf("Verbose() = %q, want the decode error", p.Verbose())
+			}
+		})
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	seg := tcp.NewSegment(40000, 443, 7, 9, tcp.FlagACK|tcp.FlagPSH, 1000, []byte{22, 3, 1, 0, 4, 1, 0, 0, 0})
+	p := Frame(tcpFrame(t, seg))
+	if p.Err != nil {
+		t.Fatalf("Frame() error = %v", p.Err)
+	}
+
+	lines := strings.Split(p.Verbose(), "\n")
+	prefixes := []string{
+		"Ethernet 02:00:00:00:00:01 > 02:00:00:00:00:02, ethertype IPv4 (0x0800), length 63",
+		"IPv4 10.0.0.1 > 10.0.0.2, tos 0x00, ttl 64, id 0, offset 0, flags [none], proto TCP (6), length 49",
+		"TCP 40000 > 443, Flags [P.], cksum 0x0000, seq 7, ack 9, win 1000, urg 0, options [], length 9",
+		"Payload 9 bytes, TLS: handshake, client hello",
+	}
+	if len(lines) != len(prefixes) {
+		t.Fatalf("Verbose() = %d lines, want %d:\n%s", len(lines), len(prefixes), p.Verbose())
+	}
+	for i, want := range prefixes {
+		if !strings.HasPrefix(lines[i], want) {
+			t.Errorf("Verbose() line %d = %q, want prefix %q", i, lines[i], want)
+		}
+	}
+}
+
+func TestIP(t *testing.T) {
+	src, _ := common.ParseIPv6("fe80::1")
+	dst, _ := common.ParseIPv6("ff02::1:2")
+	datagram, err := udp.NewPacket(546, 547, make([]byte, 4)).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	// Reach the UDP header through a hop-by-hop options header
+	hopByHop := append([]byte{uint8(common.ProtocolUDP), 0, 1, 4, 0, 0, 0, 0}, datagram...)
+	data, err := ipv6.NewPacket(src, dst, ipv6HopByHop, hopByHop).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	p := IP(data)
+	if p.Err != nil {
+		t.Fatalf("IP() error = %v", p.Err)
+	}
+	if p.Protocol != common.ProtocolUDP {
+		t.Errorf("Protocol = %v, want %v", p.Protocol, common.ProtocolUDP)
+	}
+	if want := "IP6 fe80::1.546 > ff02::1:2.547: UDP, length 4"; p.String() != want {
+		t.Errorf("String() = %q, want %q", p.String(), want)
+	}
+
+	if p := IP([]byte{0x20}); p.Err == nil {
+		t.Errorf("IP() error = nil for IP version 2")
+	}
+}
+
+func TestFromTCP(t *testing.T) {
+	seg := tcp.NewSegment(80, 40000, 5000, 1001, tcp.FlagFIN|tcp.FlagACK, 100, nil)
+	p := FromTCP(seg, dstIP, srcIP)
+	if want := "IP 10.0.0.2.80 > 10.0.0.1.40000: Flags [F.], seq 5000, ack 1001, win 100, length 0"; p.String() != want {
+		t.Errorf("String() = %q, want %q", p.String(), want)
+	}
+	if got, want := p.Layers(), []string{"TCP"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Layers() = %v, want %v", got, want)
+	}
+}
+
+func TestGuessUDP(t *testing.T) {
+	dhcp := make([]byte, 240)
+	copy(dhcp[236:], []byte{0x63, 0x82, 0x53, 0x63})
+
+	tests := []struct {
+		name     string
+		src, dst uint16
+		data     []byte
+		want     string
+	}{
+		{"DNS", 40000, 53, make([]byte, 12), "DNS"},
+		{"short DNS", 40000, 53, make([]byte, 4), ""},
+		{"mDNS", 5353, 5353, make([]byte, 12), "mDNS"},
+		{"DHCP", 68, 67, dhcp, "DHCP"},
+		{"BOOTP without cookie", 68, 67, make([]byte, 240), ""},
+		{"NTP", 40000, 123, make([]byte, 48), "NTP"},
+		{"TFTP read request", 40000, 69, []byte{0, 1, 'f', 0}, "TFTP"},
+		{"TFTP data", 40000, 69, []byte{0, 3, 0, 1}, ""},
+		{"QUIC", 40000, 443, []byte{0xc0, 0, 0, 0, 1}, "QUIC"},
+		{"SNMP", 40000, 161, []byte{0x30, 0x26}, "SNMP"},
+		{"syslog", 40000, 514, []byte("<13>hello"), "Syslog"},
+		{"RIP", 520, 520, []byte{2, 2, 0, 0}, "RIP"},
+		{"unknown", 40000, 9, []byte("x"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := guessUDP(tt.src, tt.dst, tt.data); got != tt.want {
+				t.Errorf("guessUDP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeedFrames(t *testing.T) {
+	for i, frame := range fuzzseed.Frames(t) {
+		p := Frame(frame)
+		if p.Err != nil {
+			t.Errorf("frame %d: Frame() error = %v", i, p.Err)
+		}
+		t.Logf("%s", p)
+	}
+}
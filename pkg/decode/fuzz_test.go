@@ -0,0 +1,29 @@
+package decode
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/internal/fuzzseed"
+)
+
+func FuzzFrame(f *testing.F) {
+	fuzzseed.Add(f, fuzzseed.Frames(f))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := Frame(data)
+		if p.Err != nil && p.Truncated == "" {
+			t.Errorf("Truncated = %q with error %v", p.Truncated, p.Err)
+		}
+
+		// Rendering must cope with whatever was decoded
+		_ = p.String()
+		_ = p.Verbose()
+		_ = p.Layers()
+
+		if len(data) > 14 {
+			q := IP(data[14:])
+			_ = q.String()
+			_ = q.Verbose()
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Well-known ports the payload heuristics look for.
+const (
+	portDNS    = 53
+	portDHCPv4 = 67
+	portDHCPc  = 68
+	portTFTP   = 69
+	portNTP    = 123
+	portSNMP   = 161
+	portTrap   = 162
+	portHTTPS  = 443
+	portSyslog = 514
+	portRIP    = 520
+	portMDNS   = 5353
+)
+
+// httpPrefixes are the first bytes of HTTP/1.x requests and responses.
+var httpPrefixes = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("HEAD "),
+	[]byte("DELETE "), []byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "),
+	[]byte("TRACE "), []byte("HTTP/1."),
+}
+
+// dhcpMagicCookie follows the fixed BOOTP fields of a DHCP message.
+const dhcpMagicCookie = 0x63825363
+
+// hasPort reports whether either port is port.
+func hasPort(src, dst, port uint16) bool {
+	return src == port || dst == port
+}
+
+// guessTCP names the application protocol a TCP payload appears to carry.
+// Stream contents are checked before ports, since a segment in the middle
+// of a stream need not start a message.
+func guessTCP(src, dst uint16, data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	for _, prefix := range httpPrefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return "HTTP"
+		}
+	}
+	if isTLSRecord(data) {
+		return "TLS"
+	}
+	if bytes.HasPrefix(data, []byte("SSH-")) {
+		return "SSH"
+	}
+	if hasPort(src, dst, portDNS) && len(data) >= 14 {
+		// Messages are prefixed with a 2-byte length
+		return "DNS"
+	}
+	return ""
+}
+
+// isTLSRecord reports whether data starts with a TLS record header: a
+// known content type and a 3.x protocol version.
+func isTLSRecord(data []byte) bool {
+	if len(data) < 5 {
+		return false
+	}
+	contentType := data[0]
+	return contentType >= 20 && contentType <= 23 && data[1] == 3 && data[2] <= 4
+}
+
+// guessUDP names the application protocol a UDP payload appears to carry,
+// from its ports and just enough of its contents to rule out chance
+// matches.
+func guessUDP(src, dst uint16, data []byte) string {
+	switch {
+	case hasPort(src, dst, portDNS) && len(data) >= 12:
+		return "DNS"
+	case hasPort(src, dst, portMDNS) && len(data) >= 12:
+		return "mDNS"
+	case (hasPort(src, dst, portDHCPv4) || hasPort(src, dst, portDHCPc)) &&
+		len(data) >= 240 && binary.BigEndian.Uint32(data[236:240]) == dhcpMagicCookie:
+		return "DHCP"
+	case hasPort(src, dst, portNTP) && len(data) >= 48:
+		return "NTP"
+	case dst == portTFTP && len(data) >= 4 && binary.BigEndian.Uint16(data[0:2]) >= 1 && binary.BigEndian.Uint16(data[0:2]) <= 2:
+		// Only requests go to port 69; transfers use ephemeral ports
+		return "TFTP"
+	case hasPort(src, dst, portHTTPS) && len(data) >= 1 && data[0]&0x40 != 0:
+		// The fixed bit is set in every QUIC v1 packet
+		return "QUIC"
+	case hasPort(src, dst, portSNMP) || hasPort(src, dst, portTrap):
+		if len(data) >= 2 && data[0] == 0x30 {
+			return "SNMP"
+		}
+	case hasPort(src, dst, portSyslog) && len(data) >= 1 && data[0] == '<':
+		return "Syslog"
+	case hasPort(src, dst, portRIP) && len(data) >= 4 && (data[0] == 1 || data[0] == 2):
+		return "RIP"
+	}
+	return ""
+}
+
+// applicationDetail returns a short description of an application payload
+// for the renderers, or "" if there is nothing more to say than its name.
+func applicationDetail(application string, data []byte) string {
+	switch application {
+	case "HTTP":
+		// The request or status line
+		line := data
+		if i := bytes.IndexAny(line, "\r\n"); i >= 0 {
+			line = line[:i]
+		}
+		if len(line) > 80 {
+			line = line[:80]
+		}
+		return printable(line)
+	case "DNS", "mDNS":
+		return dnsDetail(data)
+	case "TLS":
+		return tlsDetail(data)
+	case "QUIC":
+		if data[0]&0x80 != 0 {
+			return "long header"
+		}
+		return "short header"
+	}
+	return ""
+}
+
+// dnsDetail describes a DNS message header.
+func dnsDetail(data []byte) string {
+	if len(data) >= 2 && len(data) == int(binary.BigEndian.Uint16(data[0:2]))+2 {
+		// TCP messages are prefixed with their length
+		data = data[2:]
+	}
+	if len(data) < 12 {
+		return ""
+	}
+	id := binary.BigEndian.Uint16(data[0:2])
+	flags := binary.BigEndian.Uint16(data[2:4])
+	kind := "query"
+	if flags&0x8000 != 0 {
+		kind = "response"
+	}
+	return fmt.Sprintf("%s id %d, %d questions, %d answers", kind, id,
+		binary.BigEndian.Uint16(data[4:6]), binary.BigEndian.Uint16(data[6:8]))
+}
+
+// tlsDetail describes the first record of a TLS payload.
+func tlsDetail(data []byte) string {
+	switch data[0] {
+	case 20:
+		return "change cipher spec"
+	case 21:
+		return "alert"
+	case 22:
+		if len(data) > 5 {
+			switch data[5] {
+			case 1:
+				return "handshake, client hello"
+			case 2:
+				return "handshake, server hello"
+			}
+		}
+		return "handshake"
+	default:
+		return "application data"
+	}
+}
+
+// printable replaces the non-printable bytes of data with dots.
+func printable(data []byte) string {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		if b < 0x20 || b > 0x7e {
+			b = '.'
+		}
+		out[i] = b
+	}
+	return string(out)
+}
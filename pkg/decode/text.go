@@ -0,0 +1,436 @@
+package decode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+// String renders the packet on one line in the style of tcpdump, such as
+//
+//	IP 10.0.0.1.40000 > 10.0.0.2.80: Flags [S], seq 1000, win 65535, options [mss 1460], length 0
+//
+// A packet whose decode stopped early ends with "[|layer]", naming the
+// layer that could not be decoded.
+func (p *Packet) String() string {
+	var b strings.Builder
+	if p.Ethernet != nil {
+		for _, tag := range p.Ethernet.VLAN {
+			fmt.Fprintf(&b, "vlan %d, ", tag.VID)
+		}
+	}
+
+	switch {
+	case p.ARP != nil:
+		writeARP(&b, p.ARP)
+	case p.IPv4 != nil || p.IPv6 != nil || p.pseudo != nil:
+		p.writeIP(&b)
+	case p.Ethernet != nil:
+		fmt.Fprintf(&b, "%s, length %d", etherTypeName(p.Ethernet.EtherType), len(p.Ethernet.Payload))
+	}
+
+	if p.Err != nil {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "[|%s]", p.Truncated)
+	}
+	return b.String()
+}
+
+// Verbose renders the packet with one line for each layer, outermost
+// first, followed by the decode error, if any.
+func (p *Packet) Verbose() string {
+	var lines []string
+	if f := p.Ethernet; f != nil {
+		lines = append(lines, fmt.Sprintf("Ethernet %s > %s, ethertype %s (0x%04x), length %d",
+			f.Source.StringWithVendor(), f.Destination.StringWithVendor(), f.EtherType, uint16(f.EtherType), p.Length))
+		for _, tag := range f.VLAN {
+			line := fmt.Sprintf("%s vlan %d, p %d", tag.TPID, tag.VID, tag.Priority)
+			if tag.DEI {
+				line += ", DEI"
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if a := p.ARP; a != nil {
+		lines = append(lines, fmt.Sprintf("ARP hw type %d, proto 0x%04x, op %s, sender %s (%s), target %s (%s)",
+			a.HardwareType, a.ProtocolType, a.Operation, a.SenderIP, a.SenderMAC, a.TargetIP, a.TargetMAC))
+	}
+	if v4 := p.IPv4; v4 != nil {
+		line := fmt.Sprintf("IPv4 %s > %s, tos 0x%02x, ttl %d, id %d, offset %d, flags [%s], proto %s (%d), length %d, cksum 0x%04x",
+			v4.Source, v4.Destination, v4.DSCP<<2|v4.ECN, v4.TTL, v4.Identification, uint32(v4.FragmentOffset)*8,
+			ipFlags(v4.Flags), protocolName(v4.Protocol), uint8(v4.Protocol), v4.TotalLength, v4.Checksum)
+		if len(v4.Options) > 0 {
+			line += fmt.Sprintf(", options %d bytes", len(v4.Options))
+		}
+		lines = append(lines, line)
+	}
+	if v6 := p.IPv6; v6 != nil {
+		lines = append(lines, fmt.Sprintf("IPv6 %s > %s, class 0x%02x, flowlabel 0x%05x, hlim %d, next-header %s (%d), payload length %d",
+			v6.Source, v6.Destination, v6.TrafficClass, v6.FlowLabel, v6.HopLimit,
+			protocolName(v6.NextHeader), uint8(v6.NextHeader), v6.PayloadLen))
+	}
+
+	switch {
+	case p.TCP != nil:
+		s := p.TCP
+		lines = append(lines, fmt.Sprintf("TCP %d > %d, Flags [%s], cksum 0x%04x, seq %d, ack %d, win %d, urg %d, options [%s], length %d",
+			s.SourcePort, s.DestinationPort, tcpFlags(s.Flags), s.Checksum, s.SequenceNumber, s.AckNumber,
+			s.WindowSize, s.UrgentPointer, tcpOptions(s.Options), len(s.Data)))
+	case p.UDP != nil:
+		u := p.UDP
+		lines = append(lines, fmt.Sprintf("UDP %d > %d, length %d, cksum 0x%04x",
+			u.SourcePort, u.DestinationPort, u.Length, u.Checksum))
+	case p.ICMP != nil:
+		m := p.ICMP
+		lines = append(lines, fmt.Sprintf("ICMP %s, code %d, id %d, seq %d, cksum 0x%04x, length %d",
+			icmpTypeName(m.Type), m.Code, m.ID, m.Sequence, m.Checksum, icmp.MinHeaderLength+len(m.Data)))
+	case p.Fragment:
+		lines = append(lines, fmt.Sprintf("Fragment of %s, %d bytes", protocolName(p.Protocol), len(p.Payload)))
+	case p.Protocol == common.ProtocolICMPv6 && p.Err == nil && len(p.Payload) >= 4:
+		lines = append(lines, fmt.Sprintf("ICMPv6 %s, code %d, cksum 0x%04x, length %d",
+			icmpv6TypeName(p.Payload[0]), p.Payload[1], binary.BigEndian.Uint16(p.Payload[2:4]), len(p.Payload)))
+	}
+
+	if p.TCP != nil || p.UDP != nil {
+		line := fmt.Sprintf("Payload %d bytes", len(p.Payload))
+		if p.Application != "" {
+			line += ", " + p.Application
+			if detail := applicationDetail(p.Application, p.Payload); detail != "" {
+				line += ": " + detail
+			}
+		}
+		lines = append(lines, line)
+	}
+	if p.Err != nil {
+		lines = append(lines, fmt.Sprintf("Error: %v", p.Err))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeARP renders an ARP packet as tcpdump does.
+func writeARP(b *strings.Builder, a *arp.Packet) {
+	length := 8 + 2*int(a.HardwareLength) + 2*int(a.ProtocolLength)
+	switch a.Operation {
+	case arp.OperationRequest:
+		fmt.Fprintf(b, "ARP, Request who-has %s tell %s, length %d", a.TargetIP, a.SenderIP, length)
+	case arp.OperationReply:
+		fmt.Fprintf(b, "ARP, Reply %s is-at %s, length %d", a.SenderIP, a.SenderMAC.StringWithVendor(), length)
+	default:
+		fmt.Fprintf(b, "ARP, %s, length %d", a.Operation, length)
+	}
+}
+
+// writeIP renders an IP packet and its upper layer.
+func (p *Packet) writeIP(b *strings.Builder) {
+	var src, dst string
+	switch {
+	case p.IPv4 != nil:
+		b.WriteString("IP ")
+		src, dst = p.IPv4.Source.String(), p.IPv4.Destination.String()
+	case p.IPv6 != nil:
+		b.WriteString("IP6 ")
+		src, dst = p.IPv6.Source.String(), p.IPv6.Destination.String()
+	default:
+		b.WriteString("IP ")
+		src, dst = p.pseudo[0].String(), p.pseudo[1].String()
+	}
+
+	switch {
+	case p.TCP != nil:
+		fmt.Fprintf(b, "%s.%d > %s.%d: ", src, p.TCP.SourcePort, dst, p.TCP.DestinationPort)
+	case p.UDP != nil:
+		fmt.Fprintf(b, "%s.%d > %s.%d: ", src, p.UDP.SourcePort, dst, p.UDP.DestinationPort)
+	default:
+		fmt.Fprintf(b, "%s > %s: ", src, dst)
+	}
+
+	switch {
+	case p.TCP != nil:
+		writeTCP(b, p.TCP)
+	case p.UDP != nil:
+		fmt.Fprintf(b, "UDP, length %d", len(p.UDP.Data))
+	case p.ICMP != nil:
+		writeICMP(b, p.ICMP)
+	case p.Fragment:
+		fmt.Fprintf(b, "%s fragment, length %d", protocolName(p.Protocol), len(p.Payload))
+	case p.Protocol == common.ProtocolICMPv6 && p.Err == nil:
+		writeICMPv6(b, p.Payload)
+	default:
+		fmt.Fprintf(b, "%s, length %d", protocolName(p.Protocol), len(p.Payload))
+	}
+
+	if p.Application != "" {
+		fmt.Fprintf(b, ": %s", p.Application)
+		if detail := applicationDetail(p.Application, p.Payload); detail != "" {
+			fmt.Fprintf(b, ", %s", detail)
+		}
+	}
+}
+
+// writeTCP renders a TCP segment as tcpdump does, with absolute sequence
+// numbers.
+func writeTCP(b *strings.Builder, s *tcp.Segment) {
+	fmt.Fprintf(b, "Flags [%s]", tcpFlags(s.Flags))
+	if len(s.Data) > 0 {
+		fmt.Fprintf(b, ", seq %d:%d", s.SequenceNumber, s.SequenceNumber+uint32(len(s.Data)))
+	} else if s.Flags&(tcp.FlagSYN|tcp.FlagFIN|tcp.FlagRST) != 0 {
+		fmt.Fprintf(b, ", seq %d", s.SequenceNumber)
+	}
+	if s.HasFlag(tcp.FlagACK) {
+		fmt.Fprintf(b, ", ack %d", s.AckNumber)
+	}
+	fmt.Fprintf(b, ", win %d", s.WindowSize)
+	if s.HasFlag(tcp.FlagURG) {
+		fmt.Fprintf(b, ", urg %d", s.UrgentPointer)
+	}
+	if len(s.Options) > 0 {
+		fmt.Fprintf(b, ", options [%s]", tcpOptions(s.Options))
+	}
+	fmt.Fprintf(b, ", length %d", len(s.Data))
+}
+
+// tcpFlags renders TCP flags with tcpdump's letters, "." standing for ACK.
+func tcpFlags(flags uint8) string {
+	letters := []struct {
+		flag   uint8
+		letter byte
+	}{
+		{tcp.FlagFIN, 'F'}, {tcp.FlagSYN, 'S'}, {tcp.FlagRST, 'R'}, {tcp.FlagPSH, 'P'},
+		{tcp.FlagACK, '.'}, {tcp.FlagURG, 'U'}, {tcp.FlagECE, 'E'}, {tcp.FlagCWR, 'W'},
+	}
+	var out []byte
+	for _, l := range letters {
+		if flags&l.flag != 0 {
+			out = append(out, l.letter)
+		}
+	}
+	if len(out) == 0 {
+		return "none"
+	}
+	return string(out)
+}
+
+// tcpOptions renders TCP options in order, stopping at the first malformed
+// one.
+func tcpOptions(options []byte) string {
+	var parts []string
+	for i := 0; i < len(options); {
+		kind := options[i]
+		if kind == tcp.OptionKindEOL {
+			parts = append(parts, "eol")
+			break
+		}
+		if kind == tcp.OptionKindNOP {
+			parts = append(parts, "nop")
+			i++
+			continue
+		}
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			parts = append(parts, "bad opt")
+			break
+		}
+		value := options[i+2 : i+int(options[i+1])]
+		i += int(options[i+1])
+
+		switch {
+		case kind == tcp.OptionKindMSS && len(value) == 2:
+			parts = append(parts, fmt.Sprintf("mss %d", binary.BigEndian.Uint16(value)))
+		case kind == tcp.OptionKindWindowScale && len(value) == 1:
+			parts = append(parts, fmt.Sprintf("wscale %d", value[0]))
+		case kind == tcp.OptionKindSACKPermitted && len(value) == 0:
+			parts = append(parts, "sackOK")
+		case kind == tcp.OptionKindSACK && len(value)%8 == 0:
+			sack := fmt.Sprintf("sack %d ", len(value)/8)
+			for j := 0; j < len(value); j += 8 {
+				sack += fmt.Sprintf("{%d:%d}", binary.BigEndian.Uint32(value[j:]), binary.BigEndian.Uint32(value[j+4:]))
+			}
+			parts = append(parts, sack)
+		case kind == tcp.OptionKindTimestamp && len(value) == 8:
+			parts = append(parts, fmt.Sprintf("TS val %d ecr %d", binary.BigEndian.Uint32(value[0:4]), binary.BigEndian.Uint32(value[4:8])))
+		case kind == tcp.OptionKindTFO && len(value) == 0:
+			parts = append(parts, "tfo cookiereq")
+		case kind == tcp.OptionKindTFO:
+			parts = append(parts, fmt.Sprintf("tfo cookie %x", value))
+		default:
+			parts = append(parts, fmt.Sprintf("unknown-%d", kind))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// writeICMP renders an ICMP message as tcpdump does, naming the datagram
+// an error reports on when it is quoted.
+func writeICMP(b *strings.Builder, m *icmp.Message) {
+	length := icmp.MinHeaderLength + len(m.Data)
+	switch m.Type {
+	case icmp.TypeEchoRequest, icmp.TypeEchoReply:
+		fmt.Fprintf(b, "ICMP %s, id %d, seq %d, length %d", icmpTypeName(m.Type), m.ID, m.Sequence, length)
+		return
+	case icmp.TypeDestinationUnreachable:
+		orig, err := m.Original()
+		if err != nil {
+			break
+		}
+		switch m.Code {
+		case icmp.CodePortUnreachable:
+			_, port := orig.Ports()
+			fmt.Fprintf(b, "ICMP %s %s port %d unreachable, length %d",
+				orig.Destination, strings.ToLower(orig.Protocol.String()), port, length)
+		case icmp.CodeFragmentationNeeded:
+			fmt.Fprintf(b, "ICMP %s unreachable - need to frag (mtu %d), length %d", orig.Destination, m.NextHopMTU(), length)
+		case icmp.CodeProtocolUnreachable:
+			fmt.Fprintf(b, "ICMP %s protocol %d unreachable, length %d", orig.Destination, uint8(orig.Protocol), length)
+		case icmp.CodeHostUnreachable:
+			fmt.Fprintf(b, "ICMP host %s unreachable, length %d", orig.Destination, length)
+		case icmp.CodeNetUnreachable:
+			fmt.Fprintf(b, "ICMP net %s unreachable, length %d", orig.Destination, length)
+		default:
+			fmt.Fprintf(b, "ICMP %s unreachable, code %d, length %d", orig.Destination, m.Code, length)
+		}
+		return
+	}
+	if m.Code != 0 {
+		fmt.Fprintf(b, "ICMP %s, code %d, length %d", icmpTypeName(m.Type), m.Code, length)
+		return
+	}
+	fmt.Fprintf(b, "ICMP %s, length %d", icmpTypeName(m.Type), length)
+}
+
+// icmpTypeName returns the name tcpdump uses for an ICMP type.
+func icmpTypeName(t icmp.Type) string {
+	switch t {
+	case icmp.TypeEchoReply:
+		return "echo reply"
+	case icmp.TypeDestinationUnreachable:
+		return "unreachable"
+	case icmp.TypeSourceQuench:
+		return "source quench"
+	case icmp.TypeRedirect:
+		return "redirect"
+	case icmp.TypeEchoRequest:
+		return "echo request"
+	case icmp.TypeRouterAdvertisement:
+		return "router advertisement"
+	case icmp.TypeRouterSolicitation:
+		return "router solicitation"
+	case icmp.TypeTimeExceeded:
+		return "time exceeded"
+	case icmp.TypeParameterProblem:
+		return "parameter problem"
+	case icmp.TypeTimestampRequest:
+		return "time stamp request"
+	case icmp.TypeTimestampReply:
+		return "time stamp reply"
+	case icmp.TypeAddressMaskRequest:
+		return "address mask request"
+	case icmp.TypeAddressMaskReply:
+		return "address mask reply"
+	default:
+		return fmt.Sprintf("type-#%d", uint8(t))
+	}
+}
+
+// writeICMPv6 renders an ICMPv6 message, naming the target of neighbor
+// discovery messages.
+func writeICMPv6(b *strings.Builder, msg []byte) {
+	fmt.Fprintf(b, "ICMP6, %s", icmpv6TypeName(msg[0]))
+	switch msg[0] {
+	case 128, 129:
+		if len(msg) >= 8 {
+			fmt.Fprintf(b, ", id %d, seq %d", binary.BigEndian.Uint16(msg[4:6]), binary.BigEndian.Uint16(msg[6:8]))
+		}
+	case 135, 136:
+		if len(msg) >= 24 {
+			var target common.IPv6Address
+			copy(target[:], msg[8:24])
+			if msg[0] == 135 {
+				fmt.Fprintf(b, ", who has %s", target)
+			} else {
+				fmt.Fprintf(b, ", tgt is %s", target)
+			}
+		}
+	}
+	fmt.Fprintf(b, ", length %d", len(msg))
+}
+
+// icmpv6TypeName returns the name tcpdump uses for an ICMPv6 type.
+func icmpv6TypeName(t uint8) string {
+	switch t {
+	case 1:
+		return "destination unreachable"
+	case 2:
+		return "packet too big"
+	case 3:
+		return "time exceeded in-transit"
+	case 4:
+		return "parameter problem"
+	case 128:
+		return "echo request"
+	case 129:
+		return "echo reply"
+	case 130:
+		return "multicast listener query"
+	case 131:
+		return "multicast listener report"
+	case 133:
+		return "router solicitation"
+	case 134:
+		return "router advertisement"
+	case 135:
+		return "neighbor solicitation"
+	case 136:
+		return "neighbor advertisement"
+	case 137:
+		return "redirect"
+	case 143:
+		return "multicast listener report v2"
+	default:
+		return fmt.Sprintf("unknown icmp6 type (%d)", t)
+	}
+}
+
+// ipFlags renders the DF and MF flags of an IPv4 header.
+func ipFlags(flags ip.IPv4Flags) string {
+	var set []string
+	if flags&ip.FlagDontFragment != 0 {
+		set = append(set, "DF")
+	}
+	if flags&ip.FlagMoreFragments != 0 {
+		set = append(set, "MF")
+	}
+	if len(set) == 0 {
+		return "none"
+	}
+	return strings.Join(set, ",")
+}
+
+// etherTypeName names an EtherType, falling back to its number.
+func etherTypeName(et common.EtherType) string {
+	switch et {
+	case common.EtherTypeIPv4, common.EtherTypeARP, common.EtherTypeIPv6,
+		common.EtherTypeVLAN, common.EtherTypeQinQ, common.EtherTypeLLDP:
+		return et.String()
+	default:
+		return fmt.Sprintf("ethertype 0x%04x", uint16(et))
+	}
+}
+
+// protocolName names an IP protocol, falling back to its number.
+func protocolName(proto common.Protocol) string {
+	switch proto {
+	case common.ProtocolICMP, common.ProtocolIGMP, common.ProtocolTCP, common.ProtocolUDP,
+		common.ProtocolIPv6, common.ProtocolICMPv6:
+		return proto.String()
+	default:
+		return fmt.Sprintf("ip-proto-%d", uint8(proto))
+	}
+}
@@ -0,0 +1,370 @@
+// Package dgram provides congestion-controlled but unreliable datagram
+// delivery over UDP, in the manner of DCCP with TCP-like congestion control
+// (RFC 4340 and RFC 4341). The receiver acknowledges every datagram; the
+// sender keeps those in flight within a congestion window driven by TCP's
+// congestion control, paces them over the round-trip time, and counts the
+// ones lost, which are never retransmitted. It suits media, where a late
+// datagram is worth no more than a lost one.
+package dgram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+const (
+	// DefaultMaxDatagramSize is the largest payload sent if not
+	// configured, which fits the minimum IPv6 MTU with room for headers.
+	DefaultMaxDatagramSize = 1200
+
+	// DefaultSendQueue is how many datagrams wait for the congestion
+	// window or pacing before Send refuses more, if not configured.
+	DefaultSendQueue = 64
+
+	// DefaultReceiveQueue is how many datagrams wait for Recv before
+	// further ones are dropped, if not configured.
+	DefaultReceiveQueue = 64
+
+	// maxDatagramSize is the largest payload a UDP datagram over IPv4
+	// carries after the header of this package
+	maxDatagramSize = 65507 - headerLength
+
+	// tickInterval is how often pacing and the feedback timer are checked
+	tickInterval = time.Millisecond
+
+	// dupAckThreshold is how many later datagrams are acknowledged before
+	// one is declared lost, the count of duplicate ACKs TCP waits for
+	dupAckThreshold = 3
+)
+
+var (
+	// ErrClosed is returned when using a closed Conn.
+	ErrClosed = errors.New("dgram: connection closed")
+
+	// ErrTooLarge is returned by Send for a datagram over MaxDatagramSize.
+	ErrTooLarge = errors.New("dgram: datagram too large")
+
+	// ErrQueueFull is returned by Send when the send queue is full; the
+	// datagram is dropped.
+	ErrQueueFull = errors.New("dgram: send queue full")
+)
+
+// CongestionController is the congestion control a Conn drives, the
+// interface of TCP's: a datagram acknowledged counts as an ACK of its
+// bytes, a loss as the duplicate ACKs that trigger fast retransmit, and
+// the expiry of the feedback timer as a retransmission timeout.
+// *tcp.CongestionControl implements it.
+type CongestionController interface {
+	GetCwnd() uint32
+	OnAck(bytesAcked uint32, seqNum uint32)
+	OnDuplicateAck(seqNum uint32) bool
+	OnTimeout()
+}
+
+// Config configures a Conn. The zero value selects the defaults.
+type Config struct {
+	MaxDatagramSize int // 0 means DefaultMaxDatagramSize
+	SendQueue       int // 0 means DefaultSendQueue
+	ReceiveQueue    int // 0 means DefaultReceiveQueue
+
+	// Congestion returns the congestion control of a connection sending
+	// packets of up to mss bytes; nil means TCP's.
+	Congestion func(mss uint16) CongestionController
+}
+
+// Stats holds the counters of a Conn.
+type Stats struct {
+	PacketsSent     uint64
+	BytesSent       uint64 // Payload only
+	PacketsAcked    uint64
+	PacketsLost     uint64 // Declared lost from ACKs or the feedback timeout
+	LossEvents      uint64 // Congestion responses, at most one per window
+	Timeouts        uint64 // Expiries of the feedback timer
+	SendDrops       uint64 // Datagrams Send refused as the queue was full
+	PacketsReceived uint64
+	Duplicates      uint64
+	ReceiveDrops    uint64 // Datagrams received too late or with the queue full
+
+	Cwnd       uint32        // Congestion window, in bytes
+	FlightSize int           // Bytes sent and neither acknowledged nor lost
+	SRTT       time.Duration // Smoothed round-trip time, 0 before a sample
+	PacingRate uint64        // Bytes per second, 0 before an RTT sample
+}
+
+// LossRate returns the fraction of the datagrams acknowledged or declared
+// lost that were lost.
+func (s Stats) LossRate() float64 {
+	if s.PacketsAcked+s.PacketsLost == 0 {
+		return 0
+	}
+	return float64(s.PacketsLost) / float64(s.PacketsAcked+s.PacketsLost)
+}
+
+// Conn is a flow of datagrams to and from a single peer over a
+// PacketConn. Datagrams from other addresses are ignored.
+type Conn struct {
+	pc     net.PacketConn
+	remote net.Addr
+	config Config
+	now    func() time.Time
+
+	recv chan []byte
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	out    [][]byte // Packets to write once mu is released
+
+	// Sender
+	cc         CongestionController
+	rtt        *tcp.RTTEstimator
+	nextSeq    uint32
+	sendQueue  [][]byte
+	inFlight   []*sentPacket // Oldest first
+	flightSize int
+	recovering bool   // A loss event happened, up to recoverSeq
+	recoverSeq uint32 // Last sent at the loss event; later losses are a new one
+	nextSend   time.Time
+	feedbackAt time.Time // Zero if nothing is in flight
+
+	// Receiver
+	received   bool
+	rcvHighest uint32
+	rcvVector  uint64
+
+	stats Stats
+}
+
+// sentPacket is a datagram in flight.
+type sentPacket struct {
+	seq  uint32
+	size int // With the header
+	sent time.Time
+}
+
+// Dial returns a Conn exchanging datagrams with remote over pc. The Conn
+// reads from pc until closed, so pc must not be shared.
+func Dial(pc net.PacketConn, remote net.Addr, config *Config) (*Conn, error) {
+	if pc == nil || remote == nil {
+		return nil, errors.New("dgram: PacketConn and remote address required")
+	}
+	c, err := newConn(pc, remote, config)
+	if err != nil {
+		return nil, err
+	}
+	go c.readLoop()
+	go c.run()
+	return c, nil
+}
+
+// newConn returns a Conn without the goroutines reading from pc and
+// ticking.
+func newConn(pc net.PacketConn, remote net.Addr, config *Config) (*Conn, error) {
+	var cfg Config
+	if config != nil {
+		cfg = *config
+	}
+	if cfg.MaxDatagramSize == 0 {
+		cfg.MaxDatagramSize = DefaultMaxDatagramSize
+	}
+	if cfg.MaxDatagramSize < 0 || cfg.MaxDatagramSize > maxDatagramSize {
+		return nil, fmt.Errorf("dgram: invalid MaxDatagramSize %d", cfg.MaxDatagramSize)
+	}
+	if cfg.SendQueue == 0 {
+		cfg.SendQueue = DefaultSendQueue
+	}
+	if cfg.ReceiveQueue == 0 {
+		cfg.ReceiveQueue = DefaultReceiveQueue
+	}
+	if cfg.SendQueue < 0 || cfg.ReceiveQueue < 0 {
+		return nil, fmt.Errorf("dgram: invalid queue sizes %d and %d", cfg.SendQueue, cfg.ReceiveQueue)
+	}
+	if cfg.Congestion == nil {
+		cfg.Congestion = func(mss uint16) CongestionController {
+			return tcp.NewCongestionControl(mss)
+		}
+	}
+
+	return &Conn{
+		pc:     pc,
+		remote: remote,
+		config: cfg,
+		now:    time.Now,
+		recv:   make(chan []byte, cfg.ReceiveQueue),
+		done:   make(chan struct{}),
+		cc:     cfg.Congestion(uint16(headerLength + cfg.MaxDatagramSize)),
+		rtt:    tcp.NewRTTEstimator(),
+	}, nil
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Send queues a datagram, sent as soon as the congestion window and
+// pacing allow. It does not wait for the datagram to be delivered, which
+// it may never be.
+func (c *Conn) Send(b []byte) error {
+	if len(b) > c.config.MaxDatagramSize {
+		return ErrTooLarge
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if len(c.sendQueue) >= c.config.SendQueue {
+		c.stats.SendDrops++
+		return ErrQueueFull
+	}
+	c.sendQueue = append(c.sendQueue, slices.Clone(b))
+	c.flush(c.now())
+	return nil
+}
+
+// Recv returns the next datagram received, in the order they arrived.
+func (c *Conn) Recv(ctx context.Context) ([]byte, error) {
+	select {
+	case b := <-c.recv:
+		return b, nil
+	default:
+	}
+	select {
+	case b := <-c.recv:
+		return b, nil
+	case <-c.done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns the connection's counters.
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats
+	s.Cwnd = c.cc.GetCwnd()
+	s.FlightSize = c.flightSize
+	s.SRTT = c.rtt.GetSRTT()
+	if s.SRTT > 0 {
+		s.PacingRate = uint64(float64(s.Cwnd) / s.SRTT.Seconds())
+	}
+	return s
+}
+
+// Close stops the connection, dropping the datagrams not yet sent. It
+// does not close the PacketConn.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.sendQueue = nil
+	close(c.done)
+	c.mu.Unlock()
+
+	// Unblock the read loop
+	return c.pc.SetReadDeadline(time.Now())
+}
+
+// unlock releases c.mu, then writes the packets queued while it was held.
+// Write errors are ignored: the datagrams are lost, as congestion control
+// will notice.
+func (c *Conn) unlock() {
+	out := c.out
+	c.out = nil
+	c.mu.Unlock()
+	for _, b := range out {
+		_, _ = c.pc.WriteTo(b, c.remote)
+	}
+}
+
+// readLoop reads datagrams until the Conn or the PacketConn is closed.
+func (c *Conn) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := c.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-c.done:
+				// Give the PacketConn back without the deadline that
+				// stopped the loop
+				_ = c.pc.SetReadDeadline(time.Time{})
+				return
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			c.Close()
+			return
+		}
+		if from.String() != c.remote.String() {
+			continue
+		}
+		c.handlePacket(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// run ticks the connection until it is closed.
+func (c *Conn) run() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.tick()
+		}
+	}
+}
+
+// handlePacket processes a packet from the peer.
+func (c *Conn) handlePacket(data []byte) {
+	p, err := parse(data)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	if c.closed {
+		return
+	}
+	switch p.typ {
+	case typeData:
+		c.handleData(p)
+	case typeAck:
+		now := c.now()
+		c.handleAck(p, now)
+		c.flush(now)
+	}
+}
+
+// tick handles the expiry of the feedback timer, and sends what pacing
+// held back.
+func (c *Conn) tick() {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.closed {
+		return
+	}
+	now := c.now()
+	if !c.feedbackAt.IsZero() && !now.Before(c.feedbackAt) {
+		c.feedbackTimeout()
+	}
+	c.flush(now)
+}
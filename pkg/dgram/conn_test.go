@@ -0,0 +1,466 @@
+package dgram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	senderAddr   = udp.Address{IP: common.IPv4Address{10, 0, 0, 1}, Port: 5004}
+	receiverAddr = udp.Address{IP: common.IPv4Address{10, 0, 0, 2}, Port: 5006}
+)
+
+// wire connects a sender and a receiver Conn: packets written are queued,
+// and delivered by pump. drop, if set, loses the packets it returns true
+// for. The Conns are ticked by advance, not by goroutines.
+type wire struct {
+	t                *testing.T
+	now              time.Time
+	sender, receiver *Conn
+	q                []wirePacket
+	drop             func(*packet) bool
+	dataSent         int // DATA packets written, dropped ones too
+}
+
+type wirePacket struct {
+	data []byte
+	to   *Conn
+}
+
+func newWire(t *testing.T, config *Config) *wire {
+	t.Helper()
+	w := &wire{t: t, now: time.Unix(1000, 0)}
+	w.sender = w.conn(senderAddr, receiverAddr, config)
+	w.receiver = w.conn(receiverAddr, senderAddr, config)
+	return w
+}
+
+func (w *wire) conn(local, remote udp.Address, config *Config) *Conn {
+	w.t.Helper()
+	pc := &wirePacketConn{w: w, local: local}
+	c, err := newConn(pc, remote, config)
+	if err != nil {
+		w.t.Fatalf("newConn() error = %v", err)
+	}
+	c.now = func() time.Time { return w.now }
+	pc.c = c
+	return c
+}
+
+// pump delivers the packets queued, and those written in answer, until
+// there are none.
+func (w *wire) pump() {
+	for len(w.q) > 0 {
+		p := w.q[0]
+		w.q = w.q[1:]
+		p.to.handlePacket(p.data)
+	}
+}
+
+// advance moves the clock forward by d a tick at a time, ticking both
+// Conns and pumping the packets.
+func (w *wire) advance(d time.Duration) {
+	for end := w.now.Add(d); w.now.Before(end); {
+		w.now = w.now.Add(tickInterval)
+		w.sender.tick()
+		w.receiver.tick()
+		w.pump()
+	}
+}
+
+// send queues n datagrams of size bytes on the sender.
+func (w *wire) send(n, size int) {
+	w.t.Helper()
+	for i := 0; i < n; i++ {
+		if err := w.sender.Send(make([]byte, size)); err != nil {
+			w.t.Fatalf("Send() error = %v", err)
+		}
+	}
+}
+
+// received returns how many datagrams wait for Recv on the receiver.
+func (w *wire) received() int {
+	return len(w.receiver.recv)
+}
+
+// wirePacketConn is the PacketConn of a Conn on the wire.
+type wirePacketConn struct {
+	w     *wire
+	local udp.Address
+	c     *Conn
+}
+
+func (pc *wirePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	w := pc.w
+	to := w.receiver
+	if pc.c == w.receiver {
+		to = w.sender
+	}
+	p, err := parse(b)
+	if err != nil {
+		w.t.Errorf("wrote an invalid packet: %v", err)
+		return len(b), nil
+	}
+	if p.typ == typeData {
+		w.dataSent++
+	}
+	if w.drop == nil || !w.drop(p) {
+		w.q = append(w.q, wirePacket{b, to})
+	}
+	return len(b), nil
+}
+
+func (pc *wirePacketConn) ReadFrom([]byte) (int, net.Addr, error) {
+	return 0, nil, net.ErrClosed
+}
+
+func (pc *wirePacketConn) Close() error                     { return nil }
+func (pc *wirePacketConn) LocalAddr() net.Addr              { return pc.local }
+func (pc *wirePacketConn) SetDeadline(time.Time) error      { return nil }
+func (pc *wirePacketConn) SetReadDeadline(time.Time) error  { return nil }
+func (pc *wirePacketConn) SetWriteDeadline(time.Time) error { return nil }
+
+func TestDelivery(t *testing.T) {
+	w := newWire(t, &Config{ReceiveQueue: 100})
+	initial := w.sender.Stats().Cwnd
+	for i := 0; i < 20; i++ {
+		w.send(5, 1000)
+		w.advance(10 * time.Millisecond)
+	}
+	if got := w.received(); got != 100 {
+		t.Fatalf("received %d datagrams, want 100", got)
+	}
+	s := w.sender.Stats()
+	if s.PacketsSent != 100 || s.PacketsAcked != 100 || s.PacketsLost != 0 || s.FlightSize != 0 || s.BytesSent != 100000 {
+		t.Errorf("Stats() = %+v, want 100 sent and acked", s)
+	}
+	if s.Cwnd <= initial {
+		t.Errorf("Cwnd = %d, want more than the initial %d", s.Cwnd, initial)
+	}
+	if s.SRTT == 0 || s.PacingRate == 0 || s.LossRate() != 0 {
+		t.Errorf("SRTT = %v, PacingRate = %d, LossRate() = %v", s.SRTT, s.PacingRate, s.LossRate())
+	}
+	if r := w.receiver.Stats(); r.PacketsReceived != 100 || r.Duplicates != 0 || r.ReceiveDrops != 0 {
+		t.Errorf("receiver Stats() = %+v", r)
+	}
+
+	b, err := w.receiver.Recv(context.Background())
+	if err != nil || len(b) != 1000 {
+		t.Errorf("Recv() = %d bytes, %v", len(b), err)
+	}
+}
+
+func TestCongestionWindow(t *testing.T) {
+	w := newWire(t, nil)
+	// The initial window holds two full packets
+	w.send(10, DefaultMaxDatagramSize)
+	if w.dataSent != 2 {
+		t.Fatalf("sent %d datagrams before an ACK, want 2", w.dataSent)
+	}
+	if s := w.sender.Stats(); s.FlightSize != 2*(headerLength+DefaultMaxDatagramSize) {
+		t.Errorf("FlightSize = %d, want two packets", s.FlightSize)
+	}
+	w.pump()
+	// Slow start: each ACK opens room for two more
+	if w.dataSent != 10 {
+		t.Errorf("sent %d datagrams after the ACKs, want 10", w.dataSent)
+	}
+}
+
+func TestLossWithoutRetransmission(t *testing.T) {
+	w := newWire(t, nil)
+	w.send(2, 100)
+	w.advance(10 * time.Millisecond)
+
+	// Lose two datagrams of the same window
+	w.drop = func(p *packet) bool { return p.typ == typeData && (p.seq == 3 || p.seq == 5) }
+	w.send(10, 100)
+	w.advance(10 * time.Millisecond)
+
+	if w.dataSent != 12 {
+		t.Errorf("sent %d datagrams, want 12 without retransmissions", w.dataSent)
+	}
+	if got := w.received(); got != 10 {
+		t.Errorf("received %d datagrams, want 10", got)
+	}
+	s := w.sender.Stats()
+	if s.PacketsLost != 2 || s.LossEvents != 1 || s.PacketsAcked != 10 || s.FlightSize != 0 {
+		t.Errorf("Stats() = %+v, want 2 lost in 1 loss event", s)
+	}
+	if got, want := s.LossRate(), 2.0/12; got != want {
+		t.Errorf("LossRate() = %v, want %v", got, want)
+	}
+
+	// A loss after the window is a new event
+	w.drop = func(p *packet) bool { return p.typ == typeData && p.seq == 13 }
+	w.send(5, 100)
+	w.advance(10 * time.Millisecond)
+	if s := w.sender.Stats(); s.PacketsLost != 3 || s.LossEvents != 2 {
+		t.Errorf("Stats() = %+v, want 3 lost in 2 loss events", s)
+	}
+}
+
+func TestReordering(t *testing.T) {
+	w := newWire(t, nil)
+	w.send(4, 100)
+	// Deliver the first datagram after the next two: not enough later
+	// ones are acknowledged to declare it lost
+	w.q[0], w.q[2] = w.q[2], w.q[0]
+	w.pump()
+	w.advance(10 * time.Millisecond)
+	if s := w.sender.Stats(); s.PacketsLost != 0 || s.PacketsAcked != 4 {
+		t.Errorf("Stats() = %+v, want 4 acked and none lost", s)
+	}
+	if got := w.received(); got != 4 {
+		t.Errorf("received %d datagrams, want 4", got)
+	}
+}
+
+func TestFeedbackTimeout(t *testing.T) {
+	w := newWire(t, nil)
+	w.send(4, 100)
+	w.pump()
+	// Every ACK is lost
+	w.drop = func(p *packet) bool { return p.typ == typeAck }
+	w.send(6, 100)
+	w.advance(500 * time.Millisecond)
+	if s := w.sender.Stats(); s.Timeouts != 0 || s.FlightSize == 0 {
+		t.Fatalf("Stats() = %+v before the timeout", s)
+	}
+
+	w.advance(time.Second)
+	s := w.sender.Stats()
+	if s.Timeouts != 1 || s.FlightSize != 0 || s.PacketsLost != 6 {
+		t.Errorf("Stats() = %+v, want a timeout losing 6", s)
+	}
+	if s.Cwnd != headerLength+DefaultMaxDatagramSize {
+		t.Errorf("Cwnd = %d, want a packet", s.Cwnd)
+	}
+}
+
+func TestPacing(t *testing.T) {
+	w := newWire(t, nil)
+	// Answer the first datagrams after a round-trip time of 100ms
+	w.drop = func(p *packet) bool { return p.typ == typeAck }
+	w.send(2, 100)
+	w.pump()
+	w.now = w.now.Add(100 * time.Millisecond)
+	w.receiver.mu.Lock()
+	ack := (&packet{typ: typeAck, seq: w.receiver.rcvHighest, vector: w.receiver.rcvVector}).marshal()
+	w.receiver.mu.Unlock()
+	w.sender.handlePacket(ack)
+
+	s := w.sender.Stats()
+	if s.SRTT != 100*time.Millisecond {
+		t.Fatalf("SRTT = %v, want 100ms", s.SRTT)
+	}
+	if want := uint64(s.Cwnd) * 10; s.PacingRate != want {
+		t.Errorf("PacingRate = %d, want %d", s.PacingRate, want)
+	}
+
+	// A window is spread over the round trip
+	w.drop = func(*packet) bool { return true }
+	sent := w.dataSent
+	w.send(8, 100)
+	if got := w.dataSent - sent; got != 1 {
+		t.Errorf("sent %d datagrams at once, want 1", got)
+	}
+	interval := time.Duration(int64(s.SRTT) * (headerLength + 100) / int64(s.Cwnd))
+	w.advance(4 * interval)
+	if got := w.dataSent - sent; got < 4 || got > 6 {
+		t.Errorf("sent %d datagrams in 4 intervals of %v, want about 5", got, interval)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	w := newWire(t, nil)
+	data := (&packet{typ: typeData, seq: 100, payload: []byte("x")}).marshal()
+	w.receiver.handlePacket(data)
+	w.receiver.handlePacket(data)
+	w.receiver.handlePacket((&packet{typ: typeData, seq: 300}).marshal())
+	// Now too old to tell from a duplicate
+	w.receiver.handlePacket(data)
+	if got := w.received(); got != 2 {
+		t.Errorf("received %d datagrams, want 2", got)
+	}
+	if s := w.receiver.Stats(); s.PacketsReceived != 4 || s.Duplicates != 1 || s.ReceiveDrops != 1 {
+		t.Errorf("Stats() = %+v, want 1 duplicate and 1 drop", s)
+	}
+}
+
+func TestQueues(t *testing.T) {
+	w := newWire(t, &Config{SendQueue: 2, ReceiveQueue: 3})
+	w.drop = func(p *packet) bool { return p.typ == typeData }
+	w.send(4, DefaultMaxDatagramSize) // Two sent, two queued
+	if err := w.sender.Send(nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Send() with the queue full error = %v, want ErrQueueFull", err)
+	}
+	if err := w.sender.Send(make([]byte, DefaultMaxDatagramSize+1)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Send() of a large datagram error = %v, want ErrTooLarge", err)
+	}
+
+	for seq := uint32(0); seq < 5; seq++ {
+		w.receiver.handlePacket((&packet{typ: typeData, seq: seq}).marshal())
+	}
+	if s := w.sender.Stats(); s.SendDrops != 1 {
+		t.Errorf("SendDrops = %d, want 1", s.SendDrops)
+	}
+	if s := w.receiver.Stats(); s.ReceiveDrops != 2 || w.received() != 3 {
+		t.Errorf("ReceiveDrops = %d with %d queued, want 2 with 3", s.ReceiveDrops, w.received())
+	}
+}
+
+// countingController is a CongestionController over TCP's that counts
+// the calls.
+type countingController struct {
+	*tcp.CongestionControl
+	acks, dupAcks, timeouts int
+}
+
+func (c *countingController) OnAck(bytesAcked, seqNum uint32) {
+	c.acks++
+	c.CongestionControl.OnAck(bytesAcked, seqNum)
+}
+
+func (c *countingController) OnDuplicateAck(seqNum uint32) bool {
+	c.dupAcks++
+	return c.CongestionControl.OnDuplicateAck(seqNum)
+}
+
+func (c *countingController) OnTimeout() {
+	c.timeouts++
+	c.CongestionControl.OnTimeout()
+}
+
+func TestCongestionController(t *testing.T) {
+	var ccs []*countingController
+	w := newWire(t, &Config{MaxDatagramSize: 500, Congestion: func(mss uint16) CongestionController {
+		if mss != headerLength+500 {
+			t.Errorf("mss = %d, want %d", mss, headerLength+500)
+		}
+		cc := &countingController{CongestionControl: tcp.NewCongestionControl(mss)}
+		ccs = append(ccs, cc)
+		return cc
+	}})
+	cc := ccs[0] // The sender's
+
+	w.drop = func(p *packet) bool { return p.typ == typeData && p.seq == 1 }
+	w.send(6, 100)
+	w.advance(10 * time.Millisecond)
+	if cc.acks != 5 || cc.dupAcks != dupAckThreshold || cc.timeouts != 0 {
+		t.Errorf("%d ACKs, %d duplicate ACKs, %d timeouts; want 5, %d, 0", cc.acks, cc.dupAcks, cc.timeouts, dupAckThreshold)
+	}
+	// The window is halved as for a fast retransmit
+	if cc.GetState() != tcp.FastRecovery || cc.GetSsthresh() >= 65535 {
+		t.Errorf("state = %v with ssthresh %d, want fast recovery", cc.GetState(), cc.GetSsthresh())
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	pc := &wirePacketConn{}
+	tests := []Config{
+		{MaxDatagramSize: -1},
+		{MaxDatagramSize: 65507},
+		{SendQueue: -1},
+		{ReceiveQueue: -1},
+	}
+	for _, config := range tests {
+		t.Run(fmt.Sprintf("%+v", config), func(t *testing.T) {
+			if _, err := newConn(pc, receiverAddr, &config); err == nil {
+				t.Error("newConn() succeeded, want error")
+			}
+		})
+	}
+	if _, err := Dial(nil, receiverAddr, nil); err == nil {
+		t.Error("Dial() without a PacketConn succeeded, want error")
+	}
+}
+
+// TestDial runs a Conn pair over UDP sockets of this stack.
+func TestDial(t *testing.T) {
+	senderSocket, receiverSocket := udp.NewSocket(), udp.NewSocket()
+	if err := senderSocket.Bind(senderAddr); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	if err := receiverSocket.Bind(receiverAddr); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	var mu sync.Mutex
+	sent := 0
+	senderPC := udp.NewPacketConn(senderSocket, func(pkt *udp.Packet, to udp.Address) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// Lose every tenth datagram towards the receiver
+		if sent++; sent%10 == 0 {
+			return nil
+		}
+		return receiverSocket.Receive(pkt.Data, senderAddr)
+	})
+	receiverPC := udp.NewPacketConn(receiverSocket, func(pkt *udp.Packet, to udp.Address) error {
+		return senderSocket.Receive(pkt.Data, receiverAddr)
+	})
+	t.Cleanup(func() {
+		senderPC.Close()
+		receiverPC.Close()
+	})
+
+	sender, err := Dial(senderPC, receiverAddr, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer sender.Close()
+	receiver, err := Dial(receiverPC, senderAddr, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	const n = 50
+	go func() {
+		for i := 0; i < n; i++ {
+			for sender.Send([]byte{byte(i)}) != nil {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	got := 0
+	for got < n-n/10 {
+		if _, err := receiver.Recv(ctx); err != nil {
+			t.Fatalf("Recv() after %d datagrams error = %v", got, err)
+		}
+		got++
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := sender.Stats()
+		if s.PacketsAcked+s.PacketsLost == n {
+			if s.PacketsLost == 0 {
+				t.Errorf("Stats() = %+v, want losses counted", s)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Stats() = %+v, want every datagram acknowledged or lost", s)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	receiver.Close()
+	if _, err := receiver.Recv(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Recv() after Close() error = %v, want ErrClosed", err)
+	}
+	if err := receiver.Send([]byte("x")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Send() after Close() error = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,98 @@
+package dgram
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// Packet types.
+const (
+	typeData = 1 // Sequence number and payload
+	typeAck  = 2 // Highest sequence number received and the 64 before it
+)
+
+// Header lengths. Every packet starts with its type and three reserved
+// bytes, then the sequence number of the DATA or the highest one an ACK
+// acknowledges.
+const (
+	headerLength = 8
+	ackLength    = headerLength + 8
+)
+
+// ackVectorLength is how many sequence numbers below the highest an ACK
+// reports on.
+const ackVectorLength = 64
+
+var errInvalidPacket = errors.New("dgram: invalid packet")
+
+// packet is a DATA or ACK packet.
+type packet struct {
+	typ uint8
+	seq uint32
+
+	// vector has bit i set if seq-1-i was received (ACK only)
+	vector uint64
+
+	payload []byte // DATA only
+}
+
+// parse decodes a packet. The payload of a DATA packet aliases data.
+func parse(data []byte) (*packet, error) {
+	if len(data) < headerLength || data[1] != 0 || data[2] != 0 || data[3] != 0 {
+		return nil, errInvalidPacket
+	}
+	p := &packet{typ: data[0], seq: binary.BigEndian.Uint32(data[4:8])}
+	switch p.typ {
+	case typeData:
+		p.payload = data[headerLength:]
+	case typeAck:
+		if len(data) != ackLength {
+			return nil, errInvalidPacket
+		}
+		p.vector = binary.BigEndian.Uint64(data[headerLength:])
+	default:
+		return nil, errInvalidPacket
+	}
+	return p, nil
+}
+
+// marshal encodes the packet.
+func (p *packet) marshal() []byte {
+	b := make([]byte, headerLength, headerLength+max(len(p.payload), ackLength-headerLength))
+	b[0] = p.typ
+	binary.BigEndian.PutUint32(b[4:8], p.seq)
+	if p.typ == typeAck {
+		return binary.BigEndian.AppendUint64(b, p.vector)
+	}
+	return append(b, p.payload...)
+}
+
+// acked reports whether an ACK acknowledges seq.
+func (p *packet) acked(seq uint32) bool {
+	d := p.seq - seq
+	switch {
+	case d == 0:
+		return true
+	case d <= ackVectorLength:
+		return p.vector&(1<<(d-1)) != 0
+	default:
+		return false
+	}
+}
+
+// ackedAfter returns how many sequence numbers after seq, up to the
+// highest, an ACK acknowledges, or -1 if seq is beyond what it reports on.
+func (p *packet) ackedAfter(seq uint32) int {
+	d := p.seq - seq
+	if d > ackVectorLength {
+		return -1
+	}
+	// The highest, and the bits for the sequence numbers between
+	return 1 + bits.OnesCount64(p.vector&(1<<(d-1)-1))
+}
+
+// seqBefore reports whether sequence number a comes before b.
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
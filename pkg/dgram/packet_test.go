@@ -0,0 +1,84 @@
+package dgram
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		p    packet
+	}{
+		{"data", packet{typ: typeData, seq: 0x01020304, payload: []byte("frame")}},
+		{"empty data", packet{typ: typeData, seq: 7, payload: []byte{}}},
+		{"ack", packet{typ: typeAck, seq: 0xffffffff, vector: 0x8000000000000005}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.p.marshal()
+			got, err := parse(data)
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			if got.typ != tt.p.typ || got.seq != tt.p.seq || got.vector != tt.p.vector || !bytes.Equal(got.payload, tt.p.payload) {
+				t.Errorf("parse() = %+v, want %+v", got, tt.p)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short", []byte{typeData, 0, 0, 0, 1}},
+		{"unknown type", []byte{3, 0, 0, 0, 0, 0, 0, 1}},
+		{"reserved bits", []byte{typeData, 0, 1, 0, 0, 0, 0, 1}},
+		{"short ack", []byte{typeAck, 0, 0, 0, 0, 0, 0, 1, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parse(tt.data); err == nil {
+				t.Error("parse() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestAckVector(t *testing.T) {
+	// 100, 99, 97 and 37 received
+	a := &packet{typ: typeAck, seq: 100, vector: 1 | 1<<2 | 1<<62}
+	tests := []struct {
+		seq   uint32
+		acked bool
+		after int
+	}{
+		{100, true, 0},
+		{99, true, 1},
+		{98, false, 2},
+		{97, true, 2},
+		{96, false, 3},
+		{37, true, 3},
+		{36, false, 4},
+		{35, false, -1},
+	}
+	for _, tt := range tests {
+		if got := a.acked(tt.seq); got != tt.acked {
+			t.Errorf("acked(%d) = %v, want %v", tt.seq, got, tt.acked)
+		}
+		if tt.seq == a.seq {
+			continue
+		}
+		if got := a.ackedAfter(tt.seq); got != tt.after {
+			t.Errorf("ackedAfter(%d) = %d, want %d", tt.seq, got, tt.after)
+		}
+	}
+
+	// Across the wrap of the sequence numbers
+	a = &packet{typ: typeAck, seq: 1, vector: 1 << 1}
+	if !a.acked(0xffffffff) || a.acked(0) || a.ackedAfter(0xffffffff) != 1 {
+		t.Error("ACK vector does not wrap")
+	}
+}
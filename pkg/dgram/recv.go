@@ -0,0 +1,38 @@
+package dgram
+
+// handleData delivers a datagram unless it is a duplicate, or too old to
+// tell, and sends an ACK of it. Called with c.mu held.
+func (c *Conn) handleData(p *packet) {
+	c.stats.PacketsReceived++
+	deliver := true
+	switch d := c.rcvHighest - p.seq; {
+	case !c.received:
+		c.received = true
+		c.rcvHighest = p.seq
+	case seqBefore(c.rcvHighest, p.seq):
+		shift := p.seq - c.rcvHighest
+		if shift > ackVectorLength {
+			c.rcvVector = 0
+		} else {
+			c.rcvVector = c.rcvVector<<shift | 1<<(shift-1)
+		}
+		c.rcvHighest = p.seq
+	case d == 0 || d <= ackVectorLength && c.rcvVector&(1<<(d-1)) != 0:
+		c.stats.Duplicates++
+		deliver = false
+	case d > ackVectorLength:
+		c.stats.ReceiveDrops++
+		deliver = false
+	default:
+		c.rcvVector |= 1 << (d - 1)
+	}
+
+	if deliver {
+		select {
+		case c.recv <- p.payload:
+		default:
+			c.stats.ReceiveDrops++
+		}
+	}
+	c.out = append(c.out, (&packet{typ: typeAck, seq: c.rcvHighest, vector: c.rcvVector}).marshal())
+}
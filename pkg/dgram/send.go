@@ -0,0 +1,131 @@
+package dgram
+
+import "time"
+
+// flush sends the queued datagrams as far as the congestion window and
+// pacing allow. One datagram may be in flight whatever the window. Called
+// with c.mu held.
+func (c *Conn) flush(now time.Time) {
+	for len(c.sendQueue) > 0 {
+		payload := c.sendQueue[0]
+		size := headerLength + len(payload)
+		if c.flightSize > 0 && c.flightSize+size > int(c.cc.GetCwnd()) {
+			return
+		}
+		if now.Before(c.nextSend) {
+			return
+		}
+		c.sendQueue = c.sendQueue[1:]
+
+		p := &sentPacket{seq: c.nextSeq, size: size, sent: now}
+		c.nextSeq++
+		c.inFlight = append(c.inFlight, p)
+		c.flightSize += size
+		c.out = append(c.out, (&packet{typ: typeData, seq: p.seq, payload: payload}).marshal())
+		c.stats.PacketsSent++
+		c.stats.BytesSent += uint64(len(payload))
+		c.pace(now, size)
+		if c.feedbackAt.IsZero() {
+			c.feedbackAt = now.Add(c.rtt.GetRTO())
+		}
+	}
+}
+
+// pace schedules the next datagram after one of size bytes, so that a
+// window is spread over the round-trip time. Before an RTT sample
+// datagrams go as the window allows.
+func (c *Conn) pace(now time.Time, size int) {
+	srtt := c.rtt.GetSRTT()
+	if srtt == 0 {
+		return
+	}
+	interval := time.Duration(int64(srtt) * int64(size) / int64(c.cc.GetCwnd()))
+	// A tick of credit, so that the granularity of the ticker does not
+	// lower the rate
+	if earliest := now.Add(-tickInterval); c.nextSend.Before(earliest) {
+		c.nextSend = earliest
+	}
+	c.nextSend = c.nextSend.Add(interval)
+}
+
+// handleAck frees the datagrams an ACK acknowledges, and declares lost
+// those before which enough later ones were acknowledged.
+func (c *Conn) handleAck(a *packet, now time.Time) {
+	if !seqBefore(a.seq, c.nextSeq) {
+		return // Acknowledges a datagram never sent
+	}
+	progress := false
+	remaining := c.inFlight[:0]
+	for _, p := range c.inFlight {
+		if !a.acked(p.seq) {
+			remaining = append(remaining, p)
+			continue
+		}
+		progress = true
+		c.flightSize -= p.size
+		c.stats.PacketsAcked++
+		c.cc.OnAck(uint32(p.size), p.seq)
+		if p.seq == a.seq {
+			c.rtt.UpdateRTT(max(now.Sub(p.sent), time.Nanosecond))
+		}
+	}
+	clear(c.inFlight[len(remaining):])
+	c.inFlight = remaining
+
+	remaining = c.inFlight[:0]
+	for _, p := range c.inFlight {
+		if seqBefore(p.seq, a.seq) {
+			if n := a.ackedAfter(p.seq); n < 0 || n >= dupAckThreshold {
+				c.lost(p)
+				continue
+			}
+		}
+		remaining = append(remaining, p)
+	}
+	clear(c.inFlight[len(remaining):])
+	c.inFlight = remaining
+
+	switch {
+	case len(c.inFlight) == 0:
+		c.feedbackAt = time.Time{}
+	case progress:
+		c.feedbackAt = now.Add(c.rtt.GetRTO())
+	}
+}
+
+// lost counts a datagram lost, and responds to congestion if it was sent
+// after the last loss event.
+func (c *Conn) lost(p *sentPacket) {
+	c.flightSize -= p.size
+	c.stats.PacketsLost++
+	if c.recovering && !seqBefore(c.recoverSeq, p.seq) {
+		return
+	}
+	c.recovering = true
+	c.recoverSeq = c.nextSeq - 1
+	c.stats.LossEvents++
+	// As TCP would see the duplicate ACKs; the fast retransmit they call
+	// for is not done
+	for i := 0; i < dupAckThreshold; i++ {
+		if c.cc.OnDuplicateAck(c.recoverSeq) {
+			break
+		}
+	}
+}
+
+// feedbackTimeout handles the expiry of the feedback timer: no ACK came
+// for a retransmission timeout, so every datagram in flight is lost and
+// the window collapses.
+func (c *Conn) feedbackTimeout() {
+	for _, p := range c.inFlight {
+		c.flightSize -= p.size
+		c.stats.PacketsLost++
+	}
+	c.inFlight = nil
+	c.feedbackAt = time.Time{}
+	c.recovering = true
+	c.recoverSeq = c.nextSeq - 1
+	c.cc.OnTimeout()
+	c.rtt.BackoffRTO()
+	c.stats.Timeouts++
+}
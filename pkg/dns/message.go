@@ -0,0 +1,397 @@
+// Package dns implements DNS messages (RFC 1035) and a small DNS server,
+// authoritative for the zones it is given and forwarding other queries to
+// upstream resolvers.
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DNS message format (RFC 1035 Section 4.1):
+// +---------------------+
+// | Header (12 bytes)   |
+// +---------------------+
+// | Question            | The question for the name server
+// +---------------------+
+// | Answer              | Resource records answering the question
+// +---------------------+
+// | Authority           | Resource records pointing toward an authority
+// +---------------------+
+// | Additional          | Resource records holding additional information
+// +---------------------+
+
+const (
+	// Port is the DNS port.
+	Port = 53
+
+	// HeaderSize is the size of the message header (12 bytes).
+	HeaderSize = 12
+
+	// MaxUDPSize is the largest message sent over UDP to a client that
+	// does not advertise a larger one with EDNS (RFC 1035 Section 4.2.1).
+	MaxUDPSize = 512
+
+	// MaxEDNSSize caps the UDP payload size a client may advertise.
+	MaxEDNSSize = 4096
+
+	// MaxMessageSize is the largest message, as limited by the length
+	// prefix of messages over TCP.
+	MaxMessageSize = 65535
+)
+
+// Type is the type of a resource record or question.
+type Type uint16
+
+// Resource record types.
+const (
+	TypeA     Type = 1
+	TypeNS    Type = 2
+	TypeCNAME Type = 5
+	TypeSOA   Type = 6
+	TypePTR   Type = 12
+	TypeMX    Type = 15
+	TypeTXT   Type = 16
+	TypeAAAA  Type = 28
+	TypeOPT   Type = 41  // EDNS pseudo-record (RFC 6891)
+	TypeANY   Type = 255 // Questions only
+)
+
+var typeNames = map[Type]string{
+	TypeA:     "A",
+	TypeNS:    "NS",
+	TypeCNAME: "CNAME",
+	TypeSOA:   "SOA",
+	TypePTR:   "PTR",
+	TypeMX:    "MX",
+	TypeTXT:   "TXT",
+	TypeAAAA:  "AAAA",
+	TypeOPT:   "OPT",
+	TypeANY:   "ANY",
+}
+
+// String returns the mnemonic of the type, or TYPEn (RFC 3597).
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", uint16(t))
+}
+
+// Class is the class of a resource record or question.
+type Class uint16
+
+// Classes.
+const (
+	ClassINET Class = 1
+	ClassANY  Class = 255 // Questions only
+)
+
+// String returns the mnemonic of the class, or CLASSn.
+func (c Class) String() string {
+	switch c {
+	case ClassINET:
+		return "IN"
+	case ClassANY:
+		return "ANY"
+	default:
+		return fmt.Sprintf("CLASS%d", uint16(c))
+	}
+}
+
+// Opcode is the kind of query of a message.
+type Opcode uint8
+
+// OpcodeQuery is a standard query, the only kind the server answers.
+const OpcodeQuery Opcode = 0
+
+// RCode is the response code of a message.
+type RCode uint8
+
+// Response codes.
+const (
+	RCodeSuccess        RCode = 0 // NOERROR
+	RCodeFormatError    RCode = 1 // FORMERR
+	RCodeServerFailure  RCode = 2 // SERVFAIL
+	RCodeNameError      RCode = 3 // NXDOMAIN
+	RCodeNotImplemented RCode = 4 // NOTIMP
+	RCodeRefused        RCode = 5 // REFUSED
+)
+
+// String returns the mnemonic of the response code.
+func (r RCode) String() string {
+	switch r {
+	case RCodeSuccess:
+		return "NOERROR"
+	case RCodeFormatError:
+		return "FORMERR"
+	case RCodeServerFailure:
+		return "SERVFAIL"
+	case RCodeNameError:
+		return "NXDOMAIN"
+	case RCodeNotImplemented:
+		return "NOTIMP"
+	case RCodeRefused:
+		return "REFUSED"
+	default:
+		return fmt.Sprintf("RCODE%d", uint8(r))
+	}
+}
+
+// Header flags.
+const (
+	flagResponse           = 1 << 15
+	flagAuthoritative      = 1 << 10
+	flagTruncated          = 1 << 9
+	flagRecursionDesired   = 1 << 8
+	flagRecursionAvailable = 1 << 7
+	opcodeShift            = 11
+)
+
+// Header is the header of a message, without the section counts.
+type Header struct {
+	ID                 uint16
+	Response           bool
+	Opcode             Opcode
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	RCode              RCode
+}
+
+// Question is an entry of the question section.
+type Question struct {
+	Name  string // Fully qualified, with the trailing dot
+	Type  Type
+	Class Class
+}
+
+// String returns the question as dig shows it.
+func (q Question) String() string {
+	return fmt.Sprintf("%s %s %s", q.Name, q.Class, q.Type)
+}
+
+// Resource is a resource record.
+type Resource struct {
+	Name  string // Fully qualified, with the trailing dot
+	Type  Type
+	Class Class
+	TTL   uint32
+
+	// Data is the record data in wire format, with any names in it
+	// uncompressed.
+	Data []byte
+}
+
+// Message is a DNS message.
+type Message struct {
+	Header
+	Questions  []Question
+	Answers    []Resource
+	Authority  []Resource
+	Additional []Resource
+}
+
+// Parse parses a message. Compressed names are expanded, including those
+// in the data of the record types that hold names.
+func Parse(data []byte) (*Message, error) {
+	if len(data) < HeaderSize {
+		return nil, fmt.Errorf("message too short: %d bytes (minimum %d)", len(data), HeaderSize)
+	}
+	flags := binary.BigEndian.Uint16(data[2:4])
+	m := &Message{Header: Header{
+		ID:                 binary.BigEndian.Uint16(data[0:2]),
+		Response:           flags&flagResponse != 0,
+		Opcode:             Opcode(flags>>opcodeShift) & 0xf,
+		Authoritative:      flags&flagAuthoritative != 0,
+		Truncated:          flags&flagTruncated != 0,
+		RecursionDesired:   flags&flagRecursionDesired != 0,
+		RecursionAvailable: flags&flagRecursionAvailable != 0,
+		RCode:              RCode(flags & 0xf),
+	}}
+	counts := [4]int{}
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(data[4+2*i:]))
+	}
+
+	off := HeaderSize
+	for i := 0; i < counts[0]; i++ {
+		name, next, err := readName(data, off)
+		if err != nil {
+			return nil, fmt.Errorf("question %d: %w", i, err)
+		}
+		if next+4 > len(data) {
+			return nil, fmt.Errorf("question %d truncated", i)
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:  name,
+			Type:  Type(binary.BigEndian.Uint16(data[next:])),
+			Class: Class(binary.BigEndian.Uint16(data[next+2:])),
+		})
+		off = next + 4
+	}
+
+	sections := []*[]Resource{&m.Answers, &m.Authority, &m.Additional}
+	for s, section := range sections {
+		for i := 0; i < counts[s+1]; i++ {
+			r, next, err := readResource(data, off)
+			if err != nil {
+				return nil, fmt.Errorf("record %d of section %d: %w", i, s+1, err)
+			}
+			*section = append(*section, r)
+			off = next
+		}
+	}
+	return m, nil
+}
+
+func readResource(data []byte, off int) (Resource, int, error) {
+	name, off, err := readName(data, off)
+	if err != nil {
+		return Resource{}, 0, err
+	}
+	if off+10 > len(data) {
+		return Resource{}, 0, fmt.Errorf("record header truncated")
+	}
+	r := Resource{
+		Name:  name,
+		Type:  Type(binary.BigEndian.Uint16(data[off:])),
+		Class: Class(binary.BigEndian.Uint16(data[off+2:])),
+		TTL:   binary.BigEndian.Uint32(data[off+4:]),
+	}
+	length := int(binary.BigEndian.Uint16(data[off+8:]))
+	off += 10
+	if off+length > len(data) {
+		return Resource{}, 0, fmt.Errorf("record data truncated: %d bytes, have %d", length, len(data)-off)
+	}
+	r.Data, err = expandData(data, off, length, r.Type)
+	if err != nil {
+		return Resource{}, 0, fmt.Errorf("%s record data: %w", r.Type, err)
+	}
+	return r, off + length, nil
+}
+
+// Serialize converts the message to bytes, compressing the names of
+// questions and record owners.
+func (m *Message) Serialize() ([]byte, error) {
+	sections := [][]Resource{m.Answers, m.Authority, m.Additional}
+	for _, n := range []int{len(m.Questions), len(m.Answers), len(m.Authority), len(m.Additional)} {
+		if n > 0xffff {
+			return nil, fmt.Errorf("too many entries in a section: %d", n)
+		}
+	}
+
+	data := make([]byte, HeaderSize, MaxUDPSize)
+	binary.BigEndian.PutUint16(data[0:2], m.ID)
+	binary.BigEndian.PutUint16(data[2:4], m.flags())
+	binary.BigEndian.PutUint16(data[4:6], uint16(len(m.Questions)))
+	for i, s := range sections {
+		binary.BigEndian.PutUint16(data[6+2*i:], uint16(len(s)))
+	}
+
+	c := compressor{}
+	var err error
+	for _, q := range m.Questions {
+		if data, err = c.appendName(data, q.Name); err != nil {
+			return nil, err
+		}
+		data = binary.BigEndian.AppendUint16(data, uint16(q.Type))
+		data = binary.BigEndian.AppendUint16(data, uint16(q.Class))
+	}
+	for _, s := range sections {
+		for _, r := range s {
+			if len(r.Data) > 0xffff {
+				return nil, fmt.Errorf("%s record data too long: %d bytes", r.Type, len(r.Data))
+			}
+			if data, err = c.appendName(data, r.Name); err != nil {
+				return nil, err
+			}
+			data = binary.BigEndian.AppendUint16(data, uint16(r.Type))
+			data = binary.BigEndian.AppendUint16(data, uint16(r.Class))
+			data = binary.BigEndian.AppendUint32(data, r.TTL)
+			data = binary.BigEndian.AppendUint16(data, uint16(len(r.Data)))
+			data = append(data, r.Data...)
+		}
+	}
+	if len(data) > MaxMessageSize {
+		return nil, fmt.Errorf("message too large: %d bytes (maximum %d)", len(data), MaxMessageSize)
+	}
+	return data, nil
+}
+
+func (m *Message) flags() uint16 {
+	flags := uint16(m.Opcode&0xf)<<opcodeShift | uint16(m.RCode&0xf)
+	for _, f := range []struct {
+		set  bool
+		flag uint16
+	}{
+		{m.Response, flagResponse},
+		{m.Authoritative, flagAuthoritative},
+		{m.Truncated, flagTruncated},
+		{m.RecursionDesired, flagRecursionDesired},
+		{m.RecursionAvailable, flagRecursionAvailable},
+	} {
+		if f.set {
+			flags |= f.flag
+		}
+	}
+	return flags
+}
+
+// SerializeTruncated serializes the message to at most size bytes. Records
+// that do not fit are left out: additional ones silently, answer and
+// authority ones setting the truncated flag, so the client asks again over
+// TCP (RFC 2181 Section 9).
+func (m *Message) SerializeTruncated(size int) ([]byte, error) {
+	data, err := m.Serialize()
+	if err != nil || len(data) <= size {
+		return data, err
+	}
+
+	t := *m
+	for len(t.Additional) > 0 {
+		t.Additional = t.Additional[:len(t.Additional)-1]
+		if data, err = t.Serialize(); err != nil || len(data) <= size {
+			return data, err
+		}
+	}
+	t.Truncated = true
+	for len(t.Authority) > 0 || len(t.Answers) > 0 {
+		if len(t.Authority) > 0 {
+			t.Authority = t.Authority[:len(t.Authority)-1]
+		} else {
+			t.Answers = t.Answers[:len(t.Answers)-1]
+		}
+		if data, err = t.Serialize(); err != nil || len(data) <= size {
+			return data, err
+		}
+	}
+	return t.Serialize()
+}
+
+// MaxResponseSize returns the size of the largest response a query may
+// get over UDP: MaxUDPSize, or the payload size advertised in the query's
+// EDNS record, up to MaxEDNSSize.
+func (m *Message) MaxResponseSize() int {
+	for _, r := range m.Additional {
+		if r.Type == TypeOPT {
+			return min(max(int(r.Class), MaxUDPSize), MaxEDNSSize)
+		}
+	}
+	return MaxUDPSize
+}
+
+// String returns a summary of the message.
+func (m *Message) String() string {
+	kind := "query"
+	if m.Response {
+		kind = "response " + m.RCode.String()
+	}
+	q := ""
+	if len(m.Questions) > 0 {
+		q = " " + m.Questions[0].String()
+	}
+	return fmt.Sprintf("DNS{id=%d %s%s an=%d ns=%d ar=%d}",
+		m.ID, kind, q, len(m.Answers), len(m.Authority), len(m.Additional))
+}
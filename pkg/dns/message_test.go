@@ -0,0 +1,178 @@
+package dns
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &Message{
+		Header: Header{ID: 0x1234, Response: true, Authoritative: true, RecursionDesired: true, RCode: RCodeNameError},
+		Questions: []Question{
+			{Name: "www.example.com.", Type: TypeA, Class: ClassINET},
+		},
+		Answers: []Resource{
+			{Name: "www.example.com.", Type: TypeCNAME, Class: ClassINET, TTL: 300, Data: mustName(t, "web.example.com.")},
+			{Name: "web.example.com.", Type: TypeA, Class: ClassINET, TTL: 300, Data: []byte{192, 0, 2, 1}},
+		},
+		Authority: []Resource{
+			{Name: "example.com.", Type: TypeNS, Class: ClassINET, TTL: 3600, Data: mustName(t, "ns1.example.com.")},
+		},
+		Additional: []Resource{
+			{Name: "ns1.example.com.", Type: TypeA, Class: ClassINET, TTL: 3600, Data: []byte{192, 0, 2, 53}},
+		},
+	}
+
+	data, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	// Owner names after the first are compressed
+	if n := bytes.Count(data, []byte("\x07example\x03com\x00")); n != 3 {
+		t.Errorf("serialized message has %d copies of example.com, want 3 (the question and two record data)", n)
+	}
+
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Parse() = %+v, want %+v", got, m)
+	}
+}
+
+func TestParseCompressedData(t *testing.T) {
+	// An answer whose CNAME data points back into the question
+	data := []byte{
+		0, 1, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0,
+		3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0, 0, 1, 0, 1,
+		0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 6, 3, 'w', 'e', 'b', 0xc0, 16,
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(m.Answers) != 1 {
+		t.Fatalf("len(Answers) = %d, want 1", len(m.Answers))
+	}
+	a := m.Answers[0]
+	if a.Name != "www.example." || a.target() != "web.example." {
+		t.Errorf("answer = %s -> %s, want www.example. -> web.example.", a.Name, a.target())
+	}
+	if want := mustName(t, "web.example."); !bytes.Equal(a.Data, want) {
+		t.Errorf("Data = %x, want the uncompressed name %x", a.Data, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	header := []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"short header", header[:8], "too short"},
+		{"missing question", header, "truncated"},
+		{"pointer loop", append(append([]byte(nil), header...), 0xc0, 12, 0, 1, 0, 1), "loop"},
+		{"label past end", append(append([]byte(nil), header...), 5, 'a', 'b'), "truncated"},
+		{"extended label", append(append([]byte(nil), header...), 0x40, 0, 0, 1, 0, 1), "label type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSerializeInvalidName(t *testing.T) {
+	tests := []string{"relative.example", "a..b.", strings.Repeat("x", 64) + ".com."}
+	for _, name := range tests {
+		m := &Message{Questions: []Question{{Name: name, Type: TypeA, Class: ClassINET}}}
+		if _, err := m.Serialize(); err == nil {
+			t.Errorf("Serialize() with name %q succeeded, want error", name)
+		}
+	}
+}
+
+func TestSerializeTruncated(t *testing.T) {
+	m := &Message{
+		Header:    Header{Response: true},
+		Questions: []Question{{Name: "big.example.", Type: TypeTXT, Class: ClassINET}},
+	}
+	for i := 0; i < 4; i++ {
+		m.Answers = append(m.Answers, Resource{Name: "big.example.", Type: TypeTXT, Class: ClassINET, Data: make([]byte, 200)})
+	}
+	m.Additional = []Resource{{Name: "extra.example.", Type: TypeA, Class: ClassINET, Data: make([]byte, 4)}}
+
+	full, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		size          int
+		wantAnswers   int
+		wantAdditonal int
+		wantTruncated bool
+	}{
+		{"fits", len(full), 4, 1, false},
+		{"without additional", len(full) - 1, 4, 0, false},
+		{"truncated", MaxUDPSize, 2, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := m.SerializeTruncated(tt.size)
+			if err != nil {
+				t.Fatalf("SerializeTruncated() error = %v", err)
+			}
+			if len(data) > tt.size {
+				t.Errorf("len(SerializeTruncated()) = %d, want at most %d", len(data), tt.size)
+			}
+			got, err := Parse(data)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(got.Answers) != tt.wantAnswers || len(got.Additional) != tt.wantAdditonal || got.Truncated != tt.wantTruncated {
+				t.Errorf("answers = %d, additional = %d, truncated = %v; want %d, %d, %v",
+					len(got.Answers), len(got.Additional), got.Truncated, tt.wantAnswers, tt.wantAdditonal, tt.wantTruncated)
+			}
+		})
+	}
+	if m.Truncated || len(m.Answers) != 4 {
+		t.Error("SerializeTruncated() modified the message")
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  []Resource
+		want int
+	}{
+		{"no EDNS", nil, MaxUDPSize},
+		{"EDNS", []Resource{{Name: ".", Type: TypeOPT, Class: 1232}}, 1232},
+		{"EDNS below minimum", []Resource{{Name: ".", Type: TypeOPT, Class: 100}}, MaxUDPSize},
+		{"EDNS above maximum", []Resource{{Name: ".", Type: TypeOPT, Class: 65000}}, MaxEDNSSize},
+	}
+	for _, tt := range tests {
+		m := &Message{Additional: tt.opt}
+		if got := m.MaxResponseSize(); got != tt.want {
+			t.Errorf("%s: MaxResponseSize() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func mustName(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := appendName(nil, name)
+	if err != nil {
+		t.Fatalf("appendName(%q) error = %v", name, err)
+	}
+	return data
+}
@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxNameLength is the longest name in wire format (RFC 1035 Section
+	// 2.3.4).
+	maxNameLength = 255
+
+	// maxLabelLength is the longest label.
+	maxLabelLength = 63
+
+	// pointerMask marks a compression pointer in place of a label length.
+	pointerMask = 0xc0
+
+	// maxPointer is the largest offset a compression pointer holds.
+	maxPointer = 0x3fff
+)
+
+// readName reads the name at off, following compression pointers, and
+// returns it with the offset after it.
+func readName(data []byte, off int) (string, int, error) {
+	var b strings.Builder
+	next := -1 // Offset after the name, once a pointer is followed
+	length := 0
+	for jumps := 0; ; {
+		if off >= len(data) {
+			return "", 0, fmt.Errorf("name truncated")
+		}
+		l := int(data[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			if b.Len() == 0 {
+				return ".", next, nil
+			}
+			return b.String(), next, nil
+		case l&pointerMask == pointerMask:
+			if off+1 >= len(data) {
+				return "", 0, fmt.Errorf("compression pointer truncated")
+			}
+			if jumps++; jumps > maxNameLength/2 {
+				return "", 0, fmt.Errorf("compression pointer loop")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = (l&^pointerMask)<<8 | int(data[off+1])
+		case l&pointerMask != 0:
+			return "", 0, fmt.Errorf("unsupported label type 0x%02x", l&pointerMask)
+		default:
+			if off+1+l > len(data) {
+				return "", 0, fmt.Errorf("label truncated")
+			}
+			if length += l + 1; length > maxNameLength {
+				return "", 0, fmt.Errorf("name too long")
+			}
+			for _, c := range data[off+1 : off+1+l] {
+				if c == '.' || c == '\\' || c <= ' ' || c >= 0x7f {
+					fmt.Fprintf(&b, "\\%03d", c)
+				} else {
+					b.WriteByte(c)
+				}
+			}
+			b.WriteByte('.')
+			off += 1 + l
+		}
+	}
+}
+
+// appendName appends the uncompressed wire format of a fully qualified
+// name.
+func appendName(data []byte, name string) ([]byte, error) {
+	labels, err := splitName(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range labels {
+		data = append(data, byte(len(l)))
+		data = append(data, l...)
+	}
+	return append(data, 0), nil
+}
+
+// splitName returns the labels of a fully qualified name, checking their
+// lengths. Escaped characters are not supported.
+func splitName(name string) ([]string, error) {
+	if !strings.HasSuffix(name, ".") {
+		return nil, fmt.Errorf("name %q is not fully qualified", name)
+	}
+	if name == "." {
+		return nil, nil
+	}
+	if len(name)+1 > maxNameLength {
+		return nil, fmt.Errorf("name %q too long", name)
+	}
+	labels := strings.Split(name[:len(name)-1], ".")
+	for _, l := range labels {
+		if l == "" || len(l) > maxLabelLength {
+			return nil, fmt.Errorf("invalid label %q in name %q", l, name)
+		}
+	}
+	return labels, nil
+}
+
+// compressor appends names to a message, pointing to earlier occurrences
+// of their suffixes.
+type compressor map[string]int
+
+func (c compressor) appendName(data []byte, name string) ([]byte, error) {
+	labels, err := splitName(name)
+	if err != nil {
+		return nil, err
+	}
+	for i := range labels {
+		suffix := CanonicalName(strings.Join(labels[i:], ".") + ".")
+		if off, ok := c[suffix]; ok {
+			return append(data, byte(pointerMask|off>>8), byte(off)), nil
+		}
+		if len(data) <= maxPointer {
+			c[suffix] = len(data)
+		}
+		data = append(data, byte(len(labels[i])))
+		data = append(data, labels[i]...)
+	}
+	return append(data, 0), nil
+}
+
+// CanonicalName returns name in lower case and fully qualified, the form
+// names are compared in.
+func CanonicalName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// IsSubdomain reports whether name is domain or below it. Both must be
+// canonical.
+func IsSubdomain(name, domain string) bool {
+	return domain == "." || name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// parentName returns the name one label up from a canonical name, "." for
+// a top-level one.
+func parentName(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 && i < len(name)-1 {
+		return name[i+1:]
+	}
+	return "."
+}
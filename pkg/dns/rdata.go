@@ -0,0 +1,244 @@
+package dns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// expandData returns the data of a record at off, with the names in it
+// uncompressed.
+func expandData(msg []byte, off, length int, t Type) ([]byte, error) {
+	end := off + length
+	raw := msg[off:end]
+	var prefix int // Fixed fields before the names
+	var names int  // Names
+	var suffix int // Fixed fields after the names
+	switch t {
+	case TypeNS, TypeCNAME, TypePTR:
+		names = 1
+	case TypeMX:
+		prefix, names = 2, 1
+	case TypeSOA:
+		names, suffix = 2, 20
+	default:
+		return append([]byte(nil), raw...), nil
+	}
+
+	if prefix > length {
+		return nil, fmt.Errorf("too short: %d bytes", length)
+	}
+	data := append([]byte(nil), raw[:prefix]...)
+	off += prefix
+	for i := 0; i < names; i++ {
+		name, next, err := readName(msg[:end], off)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = appendName(data, name); err != nil {
+			return nil, err
+		}
+		off = next
+	}
+	if end-off != suffix {
+		return nil, fmt.Errorf("%d bytes after names, want %d", end-off, suffix)
+	}
+	return append(data, msg[off:end]...), nil
+}
+
+// target returns the name an NS, CNAME, PTR or MX record points to, or ""
+// for other records.
+func (r *Resource) target() string {
+	var off int
+	switch r.Type {
+	case TypeNS, TypeCNAME, TypePTR:
+	case TypeMX:
+		off = 2
+	default:
+		return ""
+	}
+	if off > len(r.Data) {
+		return ""
+	}
+	name, _, err := readName(r.Data, off)
+	if err != nil {
+		return ""
+	}
+	return CanonicalName(name)
+}
+
+// String returns the record in the zone file format.
+func (r Resource) String() string {
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", r.Name, r.TTL, r.Class, r.Type, r.formatData())
+}
+
+// formatData returns the record data in the zone file format, or in the
+// generic format of RFC 3597 if the type is unknown or the data invalid.
+func (r *Resource) formatData() string {
+	d := r.Data
+	switch r.Type {
+	case TypeA:
+		if len(d) == 4 {
+			return common.IPv4Address(d).String()
+		}
+	case TypeAAAA:
+		if len(d) == 16 {
+			return common.IPv6Address(d).String()
+		}
+	case TypeNS, TypeCNAME, TypePTR:
+		if name, next, err := readName(d, 0); err == nil && next == len(d) {
+			return name
+		}
+	case TypeMX:
+		if len(d) > 2 {
+			if name, next, err := readName(d, 2); err == nil && next == len(d) {
+				return fmt.Sprintf("%d %s", binary.BigEndian.Uint16(d), name)
+			}
+		}
+	case TypeSOA:
+		mname, off, err := readName(d, 0)
+		if err != nil {
+			break
+		}
+		rname, off, err := readName(d, off)
+		if err != nil || len(d)-off != 20 {
+			break
+		}
+		v := make([]uint32, 5)
+		for i := range v {
+			v[i] = binary.BigEndian.Uint32(d[off+4*i:])
+		}
+		return fmt.Sprintf("%s %s %d %d %d %d %d", mname, rname, v[0], v[1], v[2], v[3], v[4])
+	case TypeTXT:
+		var parts []string
+		for len(d) > 0 && int(d[0]) < len(d) {
+			parts = append(parts, strconv.Quote(string(d[1:1+d[0]])))
+			d = d[1+d[0]:]
+		}
+		if len(d) == 0 {
+			return strings.Join(parts, " ")
+		}
+	}
+	return fmt.Sprintf("\\# %d %s", len(r.Data), hex.EncodeToString(r.Data))
+}
+
+// parseData returns the wire format of the data of a record of type t
+// from the fields of its zone file line. Relative names are completed
+// with origin.
+func parseData(t Type, fields []string, origin string) ([]byte, error) {
+	want := map[Type]int{TypeA: 1, TypeAAAA: 1, TypeNS: 1, TypeCNAME: 1, TypePTR: 1, TypeMX: 2, TypeSOA: 7}
+	if n, ok := want[t]; ok && len(fields) != n {
+		return nil, fmt.Errorf("%s record needs %d fields, has %d", t, n, len(fields))
+	}
+
+	switch t {
+	case TypeA:
+		addr, err := common.ParseIPv4(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return addr[:], nil
+	case TypeAAAA:
+		addr, err := common.ParseIPv6(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return addr[:], nil
+	case TypeNS, TypeCNAME, TypePTR:
+		return appendName(nil, absoluteName(fields[0], origin))
+	case TypeMX:
+		pref, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MX preference %q", fields[0])
+		}
+		return appendName(binary.BigEndian.AppendUint16(nil, uint16(pref)), absoluteName(fields[1], origin))
+	case TypeSOA:
+		data, err := appendName(nil, absoluteName(fields[0], origin))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = appendName(data, absoluteName(fields[1], origin)); err != nil {
+			return nil, err
+		}
+		for _, f := range fields[2:] {
+			v, err := parseTTL(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SOA field %q", f)
+			}
+			data = binary.BigEndian.AppendUint32(data, v)
+		}
+		return data, nil
+	case TypeTXT:
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("TXT record needs a string")
+		}
+		var data []byte
+		for _, f := range fields {
+			s, err := unquote(f)
+			if err != nil {
+				return nil, err
+			}
+			if len(s) > 255 {
+				return nil, fmt.Errorf("TXT string too long: %d bytes", len(s))
+			}
+			data = append(append(data, byte(len(s))), s...)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("unsupported record type %s", t)
+}
+
+// absoluteName completes a zone file name: @ is the origin, and names
+// without the trailing dot are relative to it.
+func absoluteName(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return CanonicalName(name)
+	case origin == ".":
+		return CanonicalName(name + ".")
+	default:
+		return CanonicalName(name + "." + origin)
+	}
+}
+
+// parseTTL parses a number of seconds, optionally with the s, m, h, d or w
+// unit BIND accepts.
+func parseTTL(s string) (uint32, error) {
+	unit := uint64(1)
+	if n := len(s); n > 1 {
+		switch s[n-1] {
+		case 's', 'S':
+			s = s[:n-1]
+		case 'm', 'M':
+			s, unit = s[:n-1], 60
+		case 'h', 'H':
+			s, unit = s[:n-1], 3600
+		case 'd', 'D':
+			s, unit = s[:n-1], 86400
+		case 'w', 'W':
+			s, unit = s[:n-1], 604800
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || v*unit > 1<<32-1 {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return uint32(v * unit), nil
+}
+
+func unquote(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	u, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	return u, nil
+}
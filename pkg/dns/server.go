@@ -0,0 +1,502 @@
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultTimeout is how long a forwarded query waits for each
+	// upstream's answer.
+	DefaultTimeout = 2 * time.Second
+
+	// DefaultIdleTimeout is how long a TCP connection is kept open
+	// without a query.
+	DefaultIdleTimeout = 10 * time.Second
+)
+
+// ErrNoUpstream is returned when no upstream answers a forwarded query.
+var ErrNoUpstream = errors.New("no upstream answered")
+
+// ListenFunc opens a UDP connection on a local port, or on an ephemeral
+// port if port is 0. udp.Listen opens one on the stack's sockets.
+type ListenFunc func(port uint16) (net.PacketConn, error)
+
+// DialFunc opens a TCP connection to an upstream, such as a tcp.NetConn
+// over the stack.
+type DialFunc func(addr net.Addr) (net.Conn, error)
+
+// ServerConfig configures a Server.
+type ServerConfig struct {
+	// Zones are the zones the server answers for authoritatively.
+	Zones []*Zone
+
+	// Upstreams are the resolvers queries for other names are forwarded
+	// to, tried in order, if they ask for recursion. Without any, such
+	// queries are refused.
+	Upstreams []net.Addr
+
+	// Listen opens the UDP connection of each forwarded query, from an
+	// ephemeral port so that answers are hard to spoof. It is required
+	// with Upstreams.
+	Listen ListenFunc
+
+	// Dial, if not nil, opens the TCP connection over which a query is
+	// sent again when an upstream's answer is truncated. Truncated
+	// answers are relayed as they are otherwise.
+	Dial DialFunc
+
+	Timeout     time.Duration // Per upstream; 0 means DefaultTimeout
+	IdleTimeout time.Duration // Of TCP connections; 0 means DefaultIdleTimeout
+}
+
+// Stats holds a server's counters.
+type Stats struct {
+	Queries       uint64 // Queries received
+	Authoritative uint64 // Answered from a zone
+	Forwarded     uint64 // Answered by an upstream
+	Refused       uint64 // Not for a zone and not forwarded
+	Failed        uint64 // Answered with SERVFAIL as no upstream answered
+	Truncated     uint64 // Answers truncated to fit a UDP datagram
+	Malformed     uint64 // Queries that could not be parsed
+}
+
+// Server is a DNS server. It answers queries for its zones from their
+// data, and forwards the others to upstream resolvers, over UDP, and over
+// TCP when an answer does not fit a datagram.
+//
+// Answers from zones are limited to MaxUDPSize over UDP, as the server
+// does not implement EDNS; answers from upstreams are relayed up to the
+// size the client advertises.
+type Server struct {
+	config ServerConfig
+	zones  []*Zone // The longest origin first
+
+	mu        sync.Mutex
+	listeners map[io.Closer]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+
+	queries       atomic.Uint64
+	authoritative atomic.Uint64
+	forwarded     atomic.Uint64
+	refused       atomic.Uint64
+	failed        atomic.Uint64
+	truncated     atomic.Uint64
+	malformed     atomic.Uint64
+}
+
+// NewServer creates a server.
+func NewServer(config ServerConfig) (*Server, error) {
+	if len(config.Zones) == 0 && len(config.Upstreams) == 0 {
+		return nil, fmt.Errorf("neither zones nor upstreams")
+	}
+	if len(config.Upstreams) > 0 && config.Listen == nil {
+		return nil, fmt.Errorf("listen function is nil")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+
+	zones := append([]*Zone(nil), config.Zones...)
+	sort.SliceStable(zones, func(i, j int) bool { return len(zones[i].origin) > len(zones[j].origin) })
+	for i := 1; i < len(zones); i++ {
+		if zones[i].origin == zones[i-1].origin {
+			return nil, fmt.Errorf("duplicate zone %s", zones[i].origin)
+		}
+	}
+
+	return &Server{
+		config:    config,
+		zones:     zones,
+		listeners: make(map[io.Closer]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Stats returns the server's counters.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Queries:       s.queries.Load(),
+		Authoritative: s.authoritative.Load(),
+		Forwarded:     s.forwarded.Load(),
+		Refused:       s.refused.Load(),
+		Failed:        s.failed.Load(),
+		Truncated:     s.truncated.Load(),
+		Malformed:     s.malformed.Load(),
+	}
+}
+
+// Serve answers the queries received on conn, usually bound to Port,
+// until conn or the server is closed. It returns nil once the server is
+// closed.
+func (s *Server) Serve(conn net.PacketConn) error {
+	if !s.track(conn) {
+		return fmt.Errorf("server closed")
+	}
+	defer s.untrack(conn)
+
+	buf := make([]byte, MaxMessageSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		query := append([]byte(nil), buf[:n]...)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if reply := s.respond(query, true); reply != nil {
+				conn.WriteTo(reply, from)
+			}
+		}()
+	}
+}
+
+// ServeTCP accepts connections on l, usually a tcp.Listener on Port, and
+// serves each with ServeConn, until l or the server is closed. It returns
+// nil once the server is closed.
+func (s *Server) ServeTCP(l net.Listener) error {
+	if !s.track(l) {
+		return fmt.Errorf("server closed")
+	}
+	defer s.untrack(l)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn answers the queries of a TCP connection (RFC 7766), each
+// prefixed with its length, until the client closes it or sends none for
+// the idle timeout. It closes conn.
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.config.IdleTimeout))
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+		reply := s.respond(query, false)
+		if reply == nil {
+			continue
+		}
+		if err := writeTCPMessage(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// Close stops serving: it closes the connections and listeners Serve and
+// ServeTCP were given and the TCP connections being served, and waits for
+// the queries in progress.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) track(l io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(l io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// respond returns the reply to a query received over UDP or TCP, or nil if
+// it gets none.
+func (s *Server) respond(data []byte, udp bool) []byte {
+	s.queries.Add(1)
+	query, err := Parse(data)
+	if err != nil {
+		s.malformed.Add(1)
+		if len(data) < HeaderSize || binary.BigEndian.Uint16(data[2:4])&flagResponse != 0 {
+			return nil
+		}
+		reply := &Message{Header: Header{ID: binary.BigEndian.Uint16(data), Response: true, RCode: RCodeFormatError}}
+		out, _ := reply.Serialize()
+		return out
+	}
+	if query.Response {
+		return nil
+	}
+
+	reply := &Message{
+		Header: Header{
+			ID:                 query.ID,
+			Response:           true,
+			Opcode:             query.Opcode,
+			RecursionDesired:   query.RecursionDesired,
+			RecursionAvailable: len(s.config.Upstreams) > 0,
+		},
+		Questions: query.Questions,
+	}
+	switch {
+	case query.Opcode != OpcodeQuery:
+		reply.RCode = RCodeNotImplemented
+	case len(query.Questions) != 1:
+		s.malformed.Add(1)
+		reply.RCode = RCodeFormatError
+	default:
+		q := query.Questions[0]
+		if z := s.zoneFor(q.Name); z != nil && (q.Class == ClassINET || q.Class == ClassANY) {
+			s.authoritative.Add(1)
+			a := z.lookup(q)
+			reply.RCode = a.rcode
+			reply.Authoritative = a.authoritative
+			reply.Answers, reply.Authority, reply.Additional = a.answers, a.authority, a.additional
+			break
+		}
+		if !query.RecursionDesired || len(s.config.Upstreams) == 0 {
+			s.refused.Add(1)
+			reply.RCode = RCodeRefused
+			break
+		}
+		answer, err := s.forward(query, data)
+		if err != nil {
+			s.failed.Add(1)
+			reply.RCode = RCodeServerFailure
+			break
+		}
+		s.forwarded.Add(1)
+		return s.fit(answer, query, udp)
+	}
+
+	limit := MaxMessageSize
+	if udp {
+		limit = MaxUDPSize
+	}
+	out, err := reply.SerializeTruncated(limit)
+	if err != nil {
+		return nil
+	}
+	if isTruncated(out) {
+		s.truncated.Add(1)
+	}
+	return out
+}
+
+// fit returns an upstream's answer, truncated if it was received over TCP
+// and is larger than the client takes over UDP.
+func (s *Server) fit(answer []byte, query *Message, udp bool) []byte {
+	if !udp || len(answer) <= query.MaxResponseSize() {
+		return answer
+	}
+	m, err := Parse(answer)
+	if err != nil {
+		return nil
+	}
+	out, err := m.SerializeTruncated(query.MaxResponseSize())
+	if err != nil {
+		return nil
+	}
+	if isTruncated(out) {
+		s.truncated.Add(1)
+	}
+	return out
+}
+
+// isTruncated reports whether a serialized message has the truncated flag.
+func isTruncated(data []byte) bool {
+	return binary.BigEndian.Uint16(data[2:4])&flagTruncated != 0
+}
+
+// zoneFor returns the most specific zone a name is in, or nil.
+func (s *Server) zoneFor(name string) *Zone {
+	name = CanonicalName(name)
+	for _, z := range s.zones {
+		if IsSubdomain(name, z.origin) {
+			return z
+		}
+	}
+	return nil
+}
+
+// forward sends a query to each upstream in turn until one answers, and
+// returns the answer with the query's ID.
+func (s *Server) forward(query *Message, data []byte) ([]byte, error) {
+	lastErr := ErrNoUpstream
+	for _, upstream := range s.config.Upstreams {
+		answer, err := s.exchange(upstream, query, data)
+		if err == nil {
+			binary.BigEndian.PutUint16(answer, query.ID)
+			return answer, nil
+		}
+		lastErr = fmt.Errorf("%w: %s: %v", ErrNoUpstream, upstream, err)
+	}
+	return nil, lastErr
+}
+
+// exchange sends a query to an upstream over UDP with a new random ID, and
+// returns the answer, retried over TCP if it is truncated. Datagrams that
+// do not answer the query are ignored.
+func (s *Server) exchange(upstream net.Addr, query *Message, data []byte) ([]byte, error) {
+	conn, err := s.config.Listen(0)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	out := append([]byte(nil), data...)
+	id := randomID()
+	binary.BigEndian.PutUint16(out, id)
+	if _, err := conn.WriteTo(out, upstream); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(s.config.Timeout)
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, MaxMessageSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		if from.String() != upstream.String() {
+			continue
+		}
+		answer, err := Parse(buf[:n])
+		if err != nil || !answers(answer, id, query) {
+			continue
+		}
+		if answer.Truncated && s.config.Dial != nil {
+			return s.exchangeTCP(upstream, query, out, deadline)
+		}
+		return append([]byte(nil), buf[:n]...), nil
+	}
+}
+
+// exchangeTCP sends a query to an upstream over TCP and returns the
+// answer.
+func (s *Server) exchangeTCP(upstream net.Addr, query *Message, data []byte, deadline time.Time) ([]byte, error) {
+	conn, err := s.config.Dial(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect for TCP fallback: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	if err := writeTCPMessage(conn, data); err != nil {
+		return nil, err
+	}
+	for {
+		out, err := readTCPMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		if answer, err := Parse(out); err == nil && answers(answer, binary.BigEndian.Uint16(data), query) {
+			return out, nil
+		}
+	}
+}
+
+// answers reports whether a message answers a query sent with id.
+func answers(m *Message, id uint16, query *Message) bool {
+	if !m.Response || m.ID != id || len(m.Questions) != len(query.Questions) {
+		return false
+	}
+	for i, q := range m.Questions {
+		want := query.Questions[i]
+		if q.Type != want.Type || q.Class != want.Class || CanonicalName(q.Name) != CanonicalName(want.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+func randomID() uint16 {
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// readTCPMessage reads a message prefixed with its length.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeTCPMessage writes a message prefixed with its length.
+func writeTCPMessage(w io.Writer, data []byte) error {
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("message too large: %d bytes", len(data))
+	}
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...))
+	return err
+}
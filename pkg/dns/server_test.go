@@ -0,0 +1,524 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	serverIP   = common.IPv4Address{192, 168, 1, 1}
+	clientIP   = common.IPv4Address{192, 168, 1, 10}
+	upstreamIP = common.IPv4Address{192, 168, 1, 53}
+)
+
+// network connects hosts' UDP demultiplexers.
+type network struct {
+	hosts map[common.IPv4Address]*udp.Demultiplexer
+}
+
+func newNetwork() *network {
+	return &network{hosts: map[common.IPv4Address]*udp.Demultiplexer{
+		serverIP:   udp.NewDemultiplexer(),
+		clientIP:   udp.NewDemultiplexer(),
+		upstreamIP: udp.NewDemultiplexer(),
+	}}
+}
+
+// listen returns the ListenFunc of a host.
+func (n *network) listen(host common.IPv4Address) ListenFunc {
+	return func(port uint16) (net.PacketConn, error) {
+		return udp.Listen(n.hosts[host], host, port, func(datagram *udp.Packet, to udp.Address) error {
+			n.hosts[to.IP].Deliver(datagram, udp.Address{IP: host, Port: datagram.SourcePort})
+			return nil
+		})
+	}
+}
+
+// upstream is a fake resolver answering with handle, over UDP on the
+// network and over TCP on the connections Dial returns.
+type upstream struct {
+	handle func(query *Message, udp bool) *Message
+
+	mu      sync.Mutex
+	queries []*Message
+	tcp     int
+}
+
+func (u *upstream) answer(data []byte, udp bool) []byte {
+	query, err := Parse(data)
+	if err != nil {
+		return nil
+	}
+	u.mu.Lock()
+	u.queries = append(u.queries, query)
+	if !udp {
+		u.tcp++
+	}
+	u.mu.Unlock()
+	reply := u.handle(query, udp)
+	if reply == nil {
+		return nil
+	}
+	reply.ID, reply.Response, reply.Questions = query.ID, true, query.Questions
+	out, err := reply.Serialize()
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// counts returns the number of queries received, and of those over TCP.
+func (u *upstream) counts() (int, int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.queries), u.tcp
+}
+
+func (u *upstream) serve(t *testing.T, n *network) net.Addr {
+	t.Helper()
+	conn, err := n.listen(upstreamIP)(Port)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, MaxMessageSize)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply := u.answer(buf[:n], true); reply != nil {
+				conn.WriteTo(reply, from)
+			}
+		}
+	}()
+	return conn.LocalAddr()
+}
+
+func (u *upstream) dial(addr net.Addr) (net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		for {
+			query, err := readTCPMessage(server)
+			if err != nil {
+				return
+			}
+			if reply := u.answer(query, false); reply != nil {
+				writeTCPMessage(server, reply)
+			}
+		}
+	}()
+	return client, nil
+}
+
+func testServer(t *testing.T, n *network, config ServerConfig) *Server {
+	t.Helper()
+	if config.Zones == nil {
+		config.Zones = []*Zone{mustZone(t)}
+	}
+	s, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	conn, err := n.listen(serverIP)(Port)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(conn) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() error = %v", err)
+		}
+	})
+	return s
+}
+
+// query sends a query to the server over UDP and returns the answer.
+func query(t *testing.T, n *network, q *Message) *Message {
+	t.Helper()
+	data, err := q.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	reply, err := exchangeUDP(n, data)
+	if err != nil {
+		t.Fatalf("query error = %v", err)
+	}
+	m, err := Parse(reply)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return m
+}
+
+func exchangeUDP(n *network, data []byte) ([]byte, error) {
+	conn, err := n.listen(clientIP)(0)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.WriteTo(data, udp.Address{IP: serverIP, Port: Port}); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, MaxMessageSize)
+	size, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+func question(name string, t Type, rd bool) *Message {
+	return &Message{
+		Header:    Header{ID: 0xbeef, RecursionDesired: rd},
+		Questions: []Question{{Name: name, Type: t, Class: ClassINET}},
+	}
+}
+
+func TestServerAuthoritative(t *testing.T) {
+	n := newNetwork()
+	s := testServer(t, n, ServerConfig{})
+
+	m := query(t, n, question("www.example.com.", TypeA, true))
+	if m.ID != 0xbeef || !m.Response || !m.Authoritative || m.RCode != RCodeSuccess || !m.RecursionDesired || m.RecursionAvailable {
+		t.Errorf("header = %+v, want an authoritative answer to 0xbeef without recursion", m.Header)
+	}
+	if len(m.Answers) != 1 || !bytes.Equal(m.Answers[0].Data, []byte{192, 0, 2, 10}) {
+		t.Errorf("answers = %v, want www.example.com. A 192.0.2.10", m.Answers)
+	}
+
+	m = query(t, n, question("missing.example.com.", TypeA, false))
+	if m.RCode != RCodeNameError || len(m.Authority) != 1 || m.Authority[0].Type != TypeSOA {
+		t.Errorf("answer = %v, want NXDOMAIN with the SOA", m)
+	}
+
+	if got := s.Stats(); got.Queries != 2 || got.Authoritative != 2 {
+		t.Errorf("Stats() = %+v, want 2 authoritative queries", got)
+	}
+}
+
+func TestServerRefused(t *testing.T) {
+	tests := []struct {
+		name      string
+		upstreams bool
+		rd        bool
+	}{
+		{"no upstreams", false, true},
+		{"no recursion desired", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newNetwork()
+			u := &upstream{handle: func(*Message, bool) *Message { return &Message{} }}
+			config := ServerConfig{}
+			if tt.upstreams {
+				config.Upstreams = []net.Addr{u.serve(t, n)}
+				config.Listen = n.listen(serverIP)
+			}
+			s := testServer(t, n, config)
+
+			m := query(t, n, question("www.example.net.", TypeA, tt.rd))
+			if m.RCode != RCodeRefused || m.Authoritative {
+				t.Errorf("RCode = %v, want %v", m.RCode, RCodeRefused)
+			}
+			if queries, _ := u.counts(); s.Stats().Refused != 1 || queries != 0 {
+				t.Errorf("Stats() = %+v with %d upstream queries, want 1 refused", s.Stats(), queries)
+			}
+		})
+	}
+}
+
+func TestServerForward(t *testing.T) {
+	n := newNetwork()
+	u := &upstream{handle: func(q *Message, _ bool) *Message {
+		return &Message{
+			Header:  Header{RecursionAvailable: true},
+			Answers: []Resource{{Name: q.Questions[0].Name, Type: TypeA, Class: ClassINET, TTL: 60, Data: []byte{198, 51, 100, 7}}},
+		}
+	}}
+	s := testServer(t, n, ServerConfig{
+		Upstreams: []net.Addr{u.serve(t, n)},
+		Listen:    n.listen(serverIP),
+	})
+
+	m := query(t, n, question("www.example.net.", TypeA, true))
+	if m.ID != 0xbeef || m.RCode != RCodeSuccess || m.Authoritative {
+		t.Errorf("header = %+v, want a non-authoritative answer to 0xbeef", m.Header)
+	}
+	if len(m.Answers) != 1 || !bytes.Equal(m.Answers[0].Data, []byte{198, 51, 100, 7}) {
+		t.Errorf("answers = %v, want the upstream's", m.Answers)
+	}
+	if got := s.Stats(); got.Forwarded != 1 {
+		t.Errorf("Stats().Forwarded = %d, want 1", got.Forwarded)
+	}
+	// Names of the zones are never forwarded
+	query(t, n, question("www.example.com.", TypeA, true))
+	if queries, _ := u.counts(); queries != 1 {
+		t.Errorf("upstream got %d queries, want 1", queries)
+	}
+}
+
+func TestServerForwardFailure(t *testing.T) {
+	n := newNetwork()
+	u := &upstream{handle: func(*Message, bool) *Message { return nil }}
+	s := testServer(t, n, ServerConfig{
+		// The first upstream has no socket
+		Upstreams: []net.Addr{udp.Address{IP: upstreamIP, Port: 5353}, u.serve(t, n)},
+		Listen:    n.listen(serverIP),
+		Timeout:   50 * time.Millisecond,
+	})
+
+	m := query(t, n, question("www.example.net.", TypeA, true))
+	if m.RCode != RCodeServerFailure {
+		t.Errorf("RCode = %v, want %v", m.RCode, RCodeServerFailure)
+	}
+	if queries, _ := u.counts(); queries != 1 || s.Stats().Failed != 1 {
+		t.Errorf("upstream got %d queries and Stats() = %+v, want 1 query and 1 failure", queries, s.Stats())
+	}
+}
+
+func TestServerForwardTCPFallback(t *testing.T) {
+	big := func(q *Message) *Message {
+		m := &Message{}
+		for i := 0; i < 10; i++ {
+			m.Answers = append(m.Answers, Resource{Name: q.Questions[0].Name, Type: TypeTXT, Class: ClassINET, Data: append([]byte{100}, bytes.Repeat([]byte{'x'}, 100)...)})
+		}
+		return m
+	}
+
+	tests := []struct {
+		name          string
+		dial          bool
+		edns          int
+		wantTCP       int
+		wantAnswers   int
+		wantTruncated bool
+	}{
+		{"without dial", false, 0, 0, 0, true},
+		{"with dial", true, 0, 1, 4, true},
+		{"with dial and EDNS", true, 4096, 1, 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newNetwork()
+			u := &upstream{}
+			u.handle = func(q *Message, udp bool) *Message {
+				if udp {
+					return &Message{Header: Header{Truncated: true}}
+				}
+				return big(q)
+			}
+			config := ServerConfig{Upstreams: []net.Addr{u.serve(t, n)}, Listen: n.listen(serverIP)}
+			if tt.dial {
+				config.Dial = u.dial
+			}
+			testServer(t, n, config)
+
+			q := question("big.example.net.", TypeTXT, true)
+			if tt.edns > 0 {
+				q.Additional = []Resource{{Name: ".", Type: TypeOPT, Class: Class(tt.edns)}}
+			}
+			m := query(t, n, q)
+			_, tcp := u.counts()
+			if tcp != tt.wantTCP || len(m.Answers) != tt.wantAnswers || m.Truncated != tt.wantTruncated {
+				t.Errorf("TCP queries = %d, answers = %d, truncated = %v; want %d, %d, %v",
+					tcp, len(m.Answers), m.Truncated, tt.wantTCP, tt.wantAnswers, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestServerIgnoresSpoofedAnswers(t *testing.T) {
+	n := newNetwork()
+	conn, err := n.listen(upstreamIP)(Port)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer conn.Close()
+	other, err := n.listen(upstreamIP)(Port + 1)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer other.Close()
+	go func() {
+		buf := make([]byte, MaxMessageSize)
+		size, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		q, err := Parse(buf[:size])
+		if err != nil {
+			return
+		}
+		reply := func(id uint16, addr byte) []byte {
+			m := &Message{
+				Header:    Header{ID: id, Response: true},
+				Questions: q.Questions,
+				Answers:   []Resource{{Name: q.Questions[0].Name, Type: TypeA, Class: ClassINET, Data: []byte{198, 51, 100, addr}}},
+			}
+			data, _ := m.Serialize()
+			return data
+		}
+		conn.WriteTo(reply(q.ID+1, 1), from) // Wrong ID
+		other.WriteTo(reply(q.ID, 2), from)  // Wrong source
+		conn.WriteTo(buf[:size], from)       // Not a response
+		conn.WriteTo(reply(q.ID, 7), from)
+	}()
+
+	testServer(t, n, ServerConfig{
+		Upstreams: []net.Addr{conn.LocalAddr()},
+		Listen:    n.listen(serverIP),
+	})
+	m := query(t, n, question("www.example.net.", TypeA, true))
+	if len(m.Answers) != 1 || !bytes.Equal(m.Answers[0].Data, []byte{198, 51, 100, 7}) {
+		t.Errorf("answers = %v, want the upstream's genuine answer", m.Answers)
+	}
+}
+
+func TestServerMalformed(t *testing.T) {
+	n := newNetwork()
+	s := testServer(t, n, ServerConfig{})
+
+	tests := []struct {
+		name      string
+		data      []byte
+		wantRCode RCode
+	}{
+		{"truncated question", []byte{0x12, 0x34, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 3, 'w'}, RCodeFormatError},
+		{"no question", []byte{0x12, 0x34, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, RCodeFormatError},
+		{"notify", []byte{0x12, 0x34, 0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0}, RCodeNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := exchangeUDP(n, tt.data)
+			if err != nil {
+				t.Fatalf("query error = %v", err)
+			}
+			m, err := Parse(reply)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if m.ID != 0x1234 || m.RCode != tt.wantRCode {
+				t.Errorf("reply ID = %#x, RCode = %v; want 0x1234, %v", m.ID, m.RCode, tt.wantRCode)
+			}
+		})
+	}
+
+	// Responses are never answered
+	if _, err := exchangeUDP(n, []byte{0x12, 0x34, 0x80, 0, 0, 1, 0, 0, 0, 0, 0, 0, 3}); err == nil {
+		t.Error("server answered a malformed response")
+	}
+	if got := s.Stats().Malformed; got != 3 {
+		t.Errorf("Stats().Malformed = %d, want 3", got)
+	}
+}
+
+func TestServerTruncatesAuthoritativeAnswers(t *testing.T) {
+	var zone strings.Builder
+	zone.WriteString("@ IN SOA ns1 hostmaster 1 2 3 4 5\n")
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&zone, "many IN A 192.0.2.%d\n", i+1)
+	}
+	z, err := ParseZone(strings.NewReader(zone.String()), "example.org.")
+	if err != nil {
+		t.Fatalf("ParseZone() error = %v", err)
+	}
+	n := newNetwork()
+	s := testServer(t, n, ServerConfig{Zones: []*Zone{z}})
+
+	m := query(t, n, question("many.example.org.", TypeA, false))
+	if !m.Truncated || len(m.Answers) >= 40 {
+		t.Errorf("truncated = %v with %d answers, want a truncated answer", m.Truncated, len(m.Answers))
+	}
+	if s.Stats().Truncated != 1 {
+		t.Errorf("Stats().Truncated = %d, want 1", s.Stats().Truncated)
+	}
+
+	// Over TCP the whole answer is sent
+	client, server := net.Pipe()
+	go s.ServeConn(server)
+	defer client.Close()
+	data, _ := question("many.example.org.", TypeA, false).Serialize()
+	if err := writeTCPMessage(client, data); err != nil {
+		t.Fatalf("writeTCPMessage() error = %v", err)
+	}
+	reply, err := readTCPMessage(client)
+	if err != nil {
+		t.Fatalf("readTCPMessage() error = %v", err)
+	}
+	if m, err := Parse(reply); err != nil || m.Truncated || len(m.Answers) != 40 {
+		t.Errorf("TCP answer = %v, %v; want all 40 records", m, err)
+	}
+}
+
+func TestServerConnIdleTimeout(t *testing.T) {
+	n := newNetwork()
+	s := testServer(t, n, ServerConfig{IdleTimeout: 20 * time.Millisecond})
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(server)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn() did not return after the idle timeout")
+	}
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("connection still open after the idle timeout")
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	z := mustZone(t)
+	up := []net.Addr{udp.Address{IP: upstreamIP, Port: Port}}
+	tests := []struct {
+		name    string
+		config  ServerConfig
+		wantErr string
+	}{
+		{"empty", ServerConfig{}, "neither zones nor upstreams"},
+		{"no listen", ServerConfig{Upstreams: up}, "listen function is nil"},
+		{"duplicate zone", ServerConfig{Zones: []*Zone{z, z}}, "duplicate zone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewServer(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewServer() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServeAfterClose(t *testing.T) {
+	n := newNetwork()
+	s, err := NewServer(ServerConfig{Zones: []*Zone{mustZone(t)}})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	s.Close()
+	conn, err := n.listen(serverIP)(Port)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer conn.Close()
+	if err := s.Serve(conn); err == nil {
+		t.Error("Serve() after Close() succeeded")
+	}
+}
@@ -0,0 +1,404 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DefaultTTL is the TTL of zone file records before a $TTL directive or an
+// explicit TTL gives one.
+const DefaultTTL = 3600
+
+// maxCNAMEChain bounds how many CNAME records an answer follows within a
+// zone.
+const maxCNAMEChain = 8
+
+// Zone is the data of a zone the server is authoritative for.
+type Zone struct {
+	origin  string
+	soa     Resource
+	records map[string][]Resource // By canonical owner name
+	names   map[string]bool       // Owner names and their ancestors in the zone
+}
+
+// NewZone creates a zone from its records, which must all be at or below
+// origin and include an SOA record at it.
+func NewZone(origin string, records []Resource) (*Zone, error) {
+	z := &Zone{
+		origin:  CanonicalName(origin),
+		records: make(map[string][]Resource),
+		names:   make(map[string]bool),
+	}
+	for _, r := range records {
+		if err := z.add(r); err != nil {
+			return nil, err
+		}
+	}
+	soa := z.find(z.origin, TypeSOA)
+	if len(soa) != 1 {
+		return nil, fmt.Errorf("zone %s has %d SOA records at its apex, want 1", z.origin, len(soa))
+	}
+	z.soa = soa[0]
+	return z, nil
+}
+
+func (z *Zone) add(r Resource) error {
+	name := CanonicalName(r.Name)
+	if !IsSubdomain(name, z.origin) {
+		return fmt.Errorf("record %s is outside zone %s", r.Name, z.origin)
+	}
+	if r.Class == 0 {
+		r.Class = ClassINET
+	}
+	existing := z.records[name]
+	for _, e := range existing {
+		if (e.Type == TypeCNAME) != (r.Type == TypeCNAME) {
+			return fmt.Errorf("%s has a CNAME record and other data", r.Name)
+		}
+	}
+	r.Name = name
+	z.records[name] = append(existing, r)
+	for n := name; !z.names[n]; n = parentName(n) {
+		z.names[n] = true
+		if n == z.origin {
+			break
+		}
+	}
+	return nil
+}
+
+// Origin returns the name of the zone's apex.
+func (z *Zone) Origin() string {
+	return z.origin
+}
+
+// Records returns the records of the zone.
+func (z *Zone) Records() []Resource {
+	var records []Resource
+	for _, rs := range z.records {
+		records = append(records, rs...)
+	}
+	return records
+}
+
+// LoadZone reads a zone file.
+func LoadZone(filename, origin string) (*Zone, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	z, err := ParseZone(f, origin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return z, nil
+}
+
+// ParseZone reads a zone in a subset of the master file format of RFC
+// 1035 Section 5:
+//
+//	$ORIGIN example.com.
+//	$TTL 1h
+//	@     IN SOA ns1 hostmaster 2024010101 2h 15m 2w 5m
+//	      IN NS  ns1
+//	ns1   IN A   192.0.2.1
+//	www   300 IN A 192.0.2.10
+//	      IN AAAA 2001:db8::10
+//	mail  IN MX  10 mx.example.net.
+//	txt   IN TXT "v=spf1 -all"
+//
+// A line starting with a blank has the owner of the previous record.
+// Comments start with a semicolon, and parentheses continue a record on
+// the following lines. The supported types are A, AAAA, NS, CNAME, PTR,
+// MX, SOA and TXT; $INCLUDE and escaped characters are not supported.
+func ParseZone(r io.Reader, origin string) (*Zone, error) {
+	origin = CanonicalName(origin)
+	zoneOrigin := origin
+	ttl := uint32(DefaultTTL)
+	owner := ""
+	var records []Resource
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		fields, depth, err := tokenize(nil, line, 0)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		start := lineNo
+		for depth > 0 {
+			if !scanner.Scan() {
+				return nil, fmt.Errorf("line %d: unclosed parenthesis", start)
+			}
+			lineNo++
+			if fields, depth, err = tokenize(fields, scanner.Text(), depth); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: $ORIGIN needs a name", start)
+			}
+			origin = absoluteName(fields[1], origin)
+			continue
+		case "$TTL":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: $TTL needs a value", start)
+			}
+			if ttl, err = parseTTL(fields[1]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", start, err)
+			}
+			continue
+		case "$INCLUDE":
+			return nil, fmt.Errorf("line %d: $INCLUDE is not supported", start)
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			owner = absoluteName(fields[0], origin)
+			fields = fields[1:]
+		} else if owner == "" {
+			return nil, fmt.Errorf("line %d: no owner name", start)
+		}
+		rr, err := parseRecord(owner, fields, ttl, origin)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", start, err)
+		}
+		records = append(records, rr)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewZone(zoneOrigin, records)
+}
+
+// parseRecord parses the fields of a record after its owner: an optional
+// TTL and class in either order, the type, and the data.
+func parseRecord(owner string, fields []string, ttl uint32, origin string) (Resource, error) {
+	r := Resource{Name: owner, Class: ClassINET, TTL: ttl}
+	for i := 0; i < 2 && len(fields) > 0; i++ {
+		if strings.EqualFold(fields[0], "IN") {
+			fields = fields[1:]
+		} else if v, err := parseTTL(fields[0]); err == nil {
+			r.TTL = v
+			fields = fields[1:]
+		}
+	}
+	if len(fields) == 0 {
+		return Resource{}, fmt.Errorf("no record type")
+	}
+	t, ok := typeByName(fields[0])
+	if !ok {
+		return Resource{}, fmt.Errorf("unknown record type %q", fields[0])
+	}
+	data, err := parseData(t, fields[1:], origin)
+	if err != nil {
+		return Resource{}, err
+	}
+	r.Type, r.Data = t, data
+	return r, nil
+}
+
+func typeByName(s string) (Type, bool) {
+	for t, name := range typeNames {
+		if strings.EqualFold(s, name) && t != TypeOPT && t != TypeANY {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// tokenize appends the fields of a zone file line to fields, keeping
+// quoted strings whole and dropping parentheses and the comment. depth is
+// the number of parentheses open before the line, and it returns the
+// number open after it.
+func tokenize(fields []string, line string, depth int) ([]string, int, error) {
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == ';':
+			i = len(line)
+		case c == '(' || c == ')':
+			if c == '(' {
+				depth++
+			} else if depth--; depth < 0 {
+				return nil, 0, fmt.Errorf("unbalanced parenthesis")
+			}
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(line) && line[j] != '"' {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(line) {
+				return nil, 0, fmt.Errorf("unterminated string")
+			}
+			fields = append(fields, line[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r;()\"", rune(line[j])) {
+				j++
+			}
+			fields = append(fields, line[i:j])
+			i = j
+		}
+	}
+	return fields, depth, nil
+}
+
+// answer is the zone's answer to a question.
+type answer struct {
+	rcode         RCode
+	authoritative bool // False for a referral to a delegated zone
+	answers       []Resource
+	authority     []Resource
+	additional    []Resource
+}
+
+// lookup answers a question for a name in the zone (RFC 1034 Section
+// 4.3.2): with the records asked for, a referral to the servers of a
+// delegated subzone, or a negative answer carrying the SOA record. A
+// CNAME is followed while its target is in the zone, and wildcard records
+// answer for names that do not exist.
+func (z *Zone) lookup(q Question) *answer {
+	a := &answer{authoritative: true}
+	name := CanonicalName(q.Name)
+	for chain := 0; ; chain++ {
+		if ns := z.delegation(name); ns != nil {
+			if len(a.answers) > 0 {
+				return a // A CNAME into a delegated zone is answered as is
+			}
+			a.authoritative = false
+			a.authority = ns
+			a.additional = z.glue(ns)
+			return a
+		}
+
+		records, exists := z.records[name]
+		if !exists && !z.names[name] {
+			records = z.wildcard(name)
+			exists = records != nil
+		}
+		if !exists && !z.names[name] {
+			// The code is that of the last name of a CNAME chain (RFC
+			// 6604 Section 3)
+			a.rcode = RCodeNameError
+			a.authority = []Resource{z.negative()}
+			return a
+		}
+
+		var matched []Resource
+		var cname *Resource
+		for i, r := range records {
+			switch {
+			case r.Type == q.Type || q.Type == TypeANY:
+				matched = append(matched, r)
+			case r.Type == TypeCNAME:
+				cname = &records[i]
+			}
+		}
+		for i := range matched {
+			matched[i].Name = name
+		}
+		if len(matched) > 0 {
+			a.answers = append(a.answers, matched...)
+			a.additional = z.glue(matched)
+			return a
+		}
+		if cname == nil {
+			a.authority = []Resource{z.negative()}
+			return a
+		}
+
+		c := *cname
+		c.Name = name
+		a.answers = append(a.answers, c)
+		target := c.target()
+		if target == "" || !IsSubdomain(target, z.origin) || chain >= maxCNAMEChain {
+			return a
+		}
+		name = target
+	}
+}
+
+// delegation returns the NS records of the zone cut name is at or below,
+// or nil if name is not delegated.
+func (z *Zone) delegation(name string) []Resource {
+	var cuts []string
+	for n := name; n != z.origin && IsSubdomain(n, z.origin); n = parentName(n) {
+		cuts = append(cuts, n)
+	}
+	// The cut closest to the apex wins
+	for i := len(cuts) - 1; i >= 0; i-- {
+		if ns := z.find(cuts[i], TypeNS); ns != nil {
+			return ns
+		}
+	}
+	return nil
+}
+
+// wildcard returns the records of the wildcard of the closest existing
+// ancestor of a name that does not exist, or nil.
+func (z *Zone) wildcard(name string) []Resource {
+	for n := parentName(name); IsSubdomain(n, z.origin); n = parentName(n) {
+		if z.names[n] {
+			return z.records["*."+n]
+		}
+		if n == "." {
+			break
+		}
+	}
+	return nil
+}
+
+// glue returns the address records in the zone of the names NS and MX
+// records point to.
+func (z *Zone) glue(records []Resource) []Resource {
+	var glue []Resource
+	for _, r := range records {
+		if r.Type != TypeNS && r.Type != TypeMX {
+			continue
+		}
+		target := r.target()
+		glue = append(glue, z.find(target, TypeA)...)
+		glue = append(glue, z.find(target, TypeAAAA)...)
+	}
+	return glue
+}
+
+// negative returns the SOA record of a negative answer, whose TTL is the
+// smaller of its own and the SOA minimum (RFC 2308 Section 3).
+func (z *Zone) negative() Resource {
+	soa := z.soa
+	if n := len(soa.Data); n >= 4 {
+		soa.TTL = min(soa.TTL, binary.BigEndian.Uint32(soa.Data[n-4:]))
+	}
+	return soa
+}
+
+func (z *Zone) find(name string, t Type) []Resource {
+	var found []Resource
+	for _, r := range z.records[name] {
+		if r.Type == t {
+			found = append(found, r)
+		}
+	}
+	return found
+}
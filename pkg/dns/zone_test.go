@@ -0,0 +1,214 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+)
+
+const testZone = `
+$ORIGIN example.com.
+$TTL 1h
+@       IN SOA ns1 hostmaster (
+                2024010101 ; serial
+                2h 15m 2w
+                5m )
+        IN NS  ns1
+        IN MX  10 mail
+ns1     IN A   192.0.2.1
+mail    IN A   192.0.2.25
+www     300 IN A 192.0.2.10
+        IN AAAA 2001:db8::10
+alias   IN CNAME www
+chain   IN CNAME alias
+outside IN CNAME www.example.net.
+*.apps  IN A   192.0.2.80
+txt     IN TXT "v=spf1 -all" "second string"
+a.b.c   IN A   192.0.2.99
+sub     IN NS  ns.sub
+ns.sub  IN A   192.0.2.53
+`
+
+func mustZone(t *testing.T) *Zone {
+	t.Helper()
+	z, err := ParseZone(strings.NewReader(testZone), "example.com")
+	if err != nil {
+		t.Fatalf("ParseZone() error = %v", err)
+	}
+	return z
+}
+
+func TestParseZone(t *testing.T) {
+	z := mustZone(t)
+	if z.Origin() != "example.com." {
+		t.Errorf("Origin() = %q, want example.com.", z.Origin())
+	}
+
+	want := map[string]string{
+		"example.com.\tSOA":           "example.com.\t3600\tIN\tSOA\tns1.example.com. hostmaster.example.com. 2024010101 7200 900 1209600 300",
+		"example.com.\tMX":            "example.com.\t3600\tIN\tMX\t10 mail.example.com.",
+		"www.example.com.\tA":         "www.example.com.\t300\tIN\tA\t192.0.2.10",
+		"www.example.com.\tAAAA":      "www.example.com.\t3600\tIN\tAAAA\t2001:db8::10",
+		"outside.example.com.\tCNAME": "outside.example.com.\t3600\tIN\tCNAME\twww.example.net.",
+		"txt.example.com.\tTXT":       "txt.example.com.\t3600\tIN\tTXT\t\"v=spf1 -all\" \"second string\"",
+	}
+	records := z.Records()
+	if len(records) != 15 {
+		t.Errorf("len(Records()) = %d, want 15", len(records))
+	}
+	for _, r := range records {
+		key := r.Name + "\t" + r.Type.String()
+		if w, ok := want[key]; ok {
+			if got := r.String(); got != w {
+				t.Errorf("record = %q, want %q", got, w)
+			}
+			delete(want, key)
+		}
+	}
+	for key := range want {
+		t.Errorf("no %s record", key)
+	}
+}
+
+func TestParseZoneErrors(t *testing.T) {
+	soa := "@ IN SOA ns1 hostmaster 1 2 3 4 5\n"
+	tests := []struct {
+		name    string
+		zone    string
+		wantErr string
+	}{
+		{"no SOA", "ns1 IN A 192.0.2.1\n", "0 SOA records"},
+		{"two SOA", soa + soa, "2 SOA records"},
+		{"outside zone", soa + "www.example.net. IN A 192.0.2.1\n", "outside zone"},
+		{"CNAME and other data", soa + "www IN A 192.0.2.1\nwww IN CNAME ns1\n", "CNAME record and other data"},
+		{"unknown type", soa + "www IN HINFO a b\n", "line 2: unknown record type"},
+		{"bad address", soa + "www IN A 192.0.2\n", "line 2:"},
+		{"wrong field count", soa + "www IN MX mail\n", "MX record needs 2 fields"},
+		{"no owner", "  IN A 192.0.2.1\n", "line 1: no owner name"},
+		{"unclosed parenthesis", "@ IN SOA ns1 hostmaster ( 1 2\n3 4 5\n", "line 1: unclosed parenthesis"},
+		{"unbalanced parenthesis", soa + "www IN A 192.0.2.1 )\n", "unbalanced"},
+		{"unterminated string", soa + "txt IN TXT \"open\n", "unterminated string"},
+		{"include", "$INCLUDE other.zone\n", "not supported"},
+		{"bad TTL", "$TTL forever\n", "invalid TTL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseZone(strings.NewReader(tt.zone), "example.com.")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseZone() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestZoneLookup(t *testing.T) {
+	z := mustZone(t)
+	tests := []struct {
+		name            string
+		q               Question
+		wantRCode       RCode
+		wantAuthority   bool
+		wantAnswers     []string
+		wantAuthorities []string
+		wantAdditional  []string
+	}{
+		{
+			name:          "address",
+			q:             Question{Name: "WWW.example.com.", Type: TypeA, Class: ClassINET},
+			wantAuthority: true,
+			wantAnswers:   []string{"www.example.com. A"},
+		},
+		{
+			name:           "MX with glue",
+			q:              Question{Name: "example.com.", Type: TypeMX, Class: ClassINET},
+			wantAuthority:  true,
+			wantAnswers:    []string{"example.com. MX"},
+			wantAdditional: []string{"mail.example.com. A"},
+		},
+		{
+			name:          "any",
+			q:             Question{Name: "www.example.com.", Type: TypeANY, Class: ClassINET},
+			wantAuthority: true,
+			wantAnswers:   []string{"www.example.com. A", "www.example.com. AAAA"},
+		},
+		{
+			name:          "CNAME chain",
+			q:             Question{Name: "chain.example.com.", Type: TypeAAAA, Class: ClassINET},
+			wantAuthority: true,
+			wantAnswers:   []string{"chain.example.com. CNAME", "alias.example.com. CNAME", "www.example.com. AAAA"},
+		},
+		{
+			name:          "CNAME asked for",
+			q:             Question{Name: "alias.example.com.", Type: TypeCNAME, Class: ClassINET},
+			wantAuthority: true,
+			wantAnswers:   []string{"alias.example.com. CNAME"},
+		},
+		{
+			name:          "CNAME out of zone",
+			q:             Question{Name: "outside.example.com.", Type: TypeA, Class: ClassINET},
+			wantAuthority: true,
+			wantAnswers:   []string{"outside.example.com. CNAME"},
+		},
+		{
+			name:          "wildcard",
+			q:             Question{Name: "shop.apps.example.com.", Type: TypeA, Class: ClassINET},
+			wantAuthority: true,
+			wantAnswers:   []string{"shop.apps.example.com. A"},
+		},
+		{
+			name:            "NODATA",
+			q:               Question{Name: "www.example.com.", Type: TypeMX, Class: ClassINET},
+			wantAuthority:   true,
+			wantAuthorities: []string{"example.com. SOA"},
+		},
+		{
+			name:            "empty non-terminal",
+			q:               Question{Name: "b.c.example.com.", Type: TypeA, Class: ClassINET},
+			wantAuthority:   true,
+			wantAuthorities: []string{"example.com. SOA"},
+		},
+		{
+			name:            "NXDOMAIN",
+			q:               Question{Name: "missing.example.com.", Type: TypeA, Class: ClassINET},
+			wantRCode:       RCodeNameError,
+			wantAuthority:   true,
+			wantAuthorities: []string{"example.com. SOA"},
+		},
+		{
+			name:            "referral",
+			q:               Question{Name: "host.sub.example.com.", Type: TypeA, Class: ClassINET},
+			wantAuthorities: []string{"sub.example.com. NS"},
+			wantAdditional:  []string{"ns.sub.example.com. A"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := z.lookup(tt.q)
+			if a.rcode != tt.wantRCode || a.authoritative != tt.wantAuthority {
+				t.Errorf("lookup() rcode = %v, authoritative = %v; want %v, %v", a.rcode, a.authoritative, tt.wantRCode, tt.wantAuthority)
+			}
+			checkRecords(t, "answers", a.answers, tt.wantAnswers)
+			checkRecords(t, "authority", a.authority, tt.wantAuthorities)
+			checkRecords(t, "additional", a.additional, tt.wantAdditional)
+		})
+	}
+}
+
+func TestZoneNegativeTTL(t *testing.T) {
+	z := mustZone(t)
+	a := z.lookup(Question{Name: "missing.example.com.", Type: TypeA, Class: ClassINET})
+	if len(a.authority) != 1 || a.authority[0].TTL != 300 {
+		t.Fatalf("authority = %v, want the SOA with the minimum TTL 300", a.authority)
+	}
+}
+
+// checkRecords compares records with the names and types in want.
+func checkRecords(t *testing.T, section string, records []Resource, want []string) {
+	t.Helper()
+	var got []string
+	for _, r := range records {
+		got = append(got, r.Name+" "+r.Type.String())
+	}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("%s = %v, want %v", section, got, want)
+	}
+}
@@ -0,0 +1,155 @@
+package eapol
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+)
+
+// EAP packet format (RFC 3748 Section 4):
+// +----------+--------+-------------+----------+-----------+
+// | Code (8) | ID (8) | Length (16) | Type (8) | Type-Data |
+// +----------+--------+-------------+----------+-----------+
+//
+// Success and Failure packets end after the length.
+
+// eapHeaderSize is the size of the EAP header, without the type.
+const eapHeaderSize = 4
+
+// Code is an EAP packet code.
+type Code uint8
+
+// EAP codes.
+const (
+	CodeRequest  Code = 1
+	CodeResponse Code = 2
+	CodeSuccess  Code = 3
+	CodeFailure  Code = 4
+)
+
+// String returns the name of the code.
+func (c Code) String() string {
+	switch c {
+	case CodeRequest:
+		return "Request"
+	case CodeResponse:
+		return "Response"
+	case CodeSuccess:
+		return "Success"
+	case CodeFailure:
+		return "Failure"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(c))
+	}
+}
+
+// MethodType is the type of an EAP Request or Response.
+type MethodType uint8
+
+// EAP method types.
+const (
+	MethodIdentity     MethodType = 1
+	MethodNotification MethodType = 2
+	MethodNak          MethodType = 3 // Legacy Nak: the types the peer would rather use
+	MethodMD5Challenge MethodType = 4
+)
+
+// String returns the name of the method type.
+func (t MethodType) String() string {
+	switch t {
+	case MethodIdentity:
+		return "Identity"
+	case MethodNotification:
+		return "Notification"
+	case MethodNak:
+		return "Nak"
+	case MethodMD5Challenge:
+		return "MD5-Challenge"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+// EAP is an EAP packet.
+type EAP struct {
+	Code Code
+	ID   uint8
+	Type MethodType // Of Requests and Responses
+	Data []byte     // Type-Data
+}
+
+// ParseEAP parses an EAP packet.
+func ParseEAP(data []byte) (*EAP, error) {
+	if len(data) < eapHeaderSize {
+		return nil, fmt.Errorf("EAP packet too short: %d bytes", len(data))
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < eapHeaderSize || length > len(data) {
+		return nil, fmt.Errorf("invalid EAP length: %d", length)
+	}
+	e := &EAP{Code: Code(data[0]), ID: data[1]}
+	if e.Code == CodeRequest || e.Code == CodeResponse {
+		if length == eapHeaderSize {
+			return nil, fmt.Errorf("EAP %s without a type", e.Code)
+		}
+		e.Type = MethodType(data[eapHeaderSize])
+		e.Data = data[eapHeaderSize+1 : length]
+	}
+	return e, nil
+}
+
+// Serialize converts the packet to bytes.
+func (e *EAP) Serialize() []byte {
+	length := eapHeaderSize
+	if e.Code == CodeRequest || e.Code == CodeResponse {
+		length += 1 + len(e.Data)
+	}
+	data := make([]byte, eapHeaderSize, length)
+	data[0] = uint8(e.Code)
+	data[1] = e.ID
+	binary.BigEndian.PutUint16(data[2:4], uint16(length))
+	if length > eapHeaderSize {
+		data = append(data, uint8(e.Type))
+		data = append(data, e.Data...)
+	}
+	return data
+}
+
+// Method is an EAP authentication method.
+type Method interface {
+	// Type returns the method type the method answers.
+	Type() MethodType
+
+	// Respond returns the Type-Data of the Response to a Request of the
+	// method's type.
+	Respond(id uint8, data []byte) ([]byte, error)
+}
+
+// MD5Challenge is EAP-MD5: the password is proved by hashing it with the
+// authenticator's challenge. It offers no mutual authentication or keys,
+// and suits only wired ports. Name, if set, is sent after the hash.
+type MD5Challenge struct {
+	Password string
+	Name     string
+}
+
+// Type returns MethodMD5Challenge.
+func (m *MD5Challenge) Type() MethodType {
+	return MethodMD5Challenge
+}
+
+// Respond returns the hash of the request ID, password and challenge, as
+// in CHAP (RFC 1994 Section 4.1).
+func (m *MD5Challenge) Respond(id uint8, data []byte) ([]byte, error) {
+	if len(data) < 1 || int(data[0]) == 0 || 1+int(data[0]) > len(data) {
+		return nil, fmt.Errorf("invalid MD5 challenge")
+	}
+	challenge := data[1 : 1+int(data[0])]
+
+	h := md5.New()
+	h.Write([]byte{id})
+	h.Write([]byte(m.Password))
+	h.Write(challenge)
+	response := append([]byte{md5.Size}, h.Sum(nil)...)
+	return append(response, m.Name...), nil
+}
@@ -0,0 +1,73 @@
+package eapol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEAPRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		eap  EAP
+		want []byte
+	}{
+		{"identity request", EAP{Code: CodeRequest, ID: 1, Type: MethodIdentity}, []byte{1, 1, 0, 5, 1}},
+		{"identity response", EAP{Code: CodeResponse, ID: 1, Type: MethodIdentity, Data: []byte("bob")}, []byte{2, 1, 0, 8, 1, 'b', 'o', 'b'}},
+		{"success", EAP{Code: CodeSuccess, ID: 2}, []byte{3, 2, 0, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.eap.Serialize()
+			if !bytes.Equal(data, tt.want) {
+				t.Fatalf("Serialize() = % x, want % x", data, tt.want)
+			}
+			got, err := ParseEAP(data)
+			if err != nil {
+				t.Fatalf("ParseEAP() error = %v", err)
+			}
+			if got.Code != tt.eap.Code || got.ID != tt.eap.ID || got.Type != tt.eap.Type || !bytes.Equal(got.Data, tt.eap.Data) {
+				t.Errorf("ParseEAP() = %+v, want %+v", got, tt.eap)
+			}
+		})
+	}
+}
+
+func TestParseEAPInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte{1, 1, 0}},
+		{"length below header", []byte{3, 1, 0, 3}},
+		{"length past end", []byte{1, 1, 0, 9, 1}},
+		{"request without type", []byte{1, 1, 0, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseEAP(tt.data); err == nil {
+				t.Error("ParseEAP() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestMD5Challenge(t *testing.T) {
+	m := &MD5Challenge{Password: "password", Name: "host"}
+	challenge := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	got, err := m.Respond(1, append([]byte{16}, challenge...))
+	if err != nil {
+		t.Fatalf("Respond() error = %v", err)
+	}
+	hash, _ := hex.DecodeString("51b4c75f261478f67e37e429440e294d")
+	want := append(append([]byte{16}, hash...), "host"...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Respond() = % x, want % x", got, want)
+	}
+
+	for _, data := range [][]byte{nil, {0}, {16, 1, 2}} {
+		if _, err := m.Respond(1, data); err == nil {
+			t.Errorf("Respond(% x) succeeded, want error", data)
+		}
+	}
+}
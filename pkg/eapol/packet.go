@@ -0,0 +1,100 @@
+// Package eapol implements an IEEE 802.1X supplicant: EAP over LAN
+// (EAPOL) framing, EAP (RFC 3748) packets, and the supplicant state
+// machine that authenticates a port to a port-secured switch.
+//
+// Authentication methods plug in through the Method interface; EAP-MD5
+// (RFC 3748 Section 5.4) is provided. The Supplicant answers the
+// authenticator's Identity requests itself, and Naks the methods it has
+// no Method for.
+package eapol
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// EAPOL packet format (IEEE 802.1X-2004 Section 7.5), the payload of frames
+// of EtherType 0x888E:
+// +-------------+----------+-------------+---------------------+
+// | Version (8) | Type (8) | Length (16) | Body (Length bytes) |
+// +-------------+----------+-------------+---------------------+
+
+const (
+	// HeaderSize is the size of the EAPOL header (4 bytes).
+	HeaderSize = 4
+
+	// DefaultVersion is the protocol version sent, that of 802.1X-2004.
+	DefaultVersion = 2
+)
+
+// PAEGroupAddress is the destination of EAPOL frames to an unknown
+// authenticator. Bridges do not forward it, so it reaches the switch port.
+var PAEGroupAddress = common.MACAddress{0x01, 0x80, 0xc2, 0x00, 0x00, 0x03}
+
+// PacketType is the type of an EAPOL packet.
+type PacketType uint8
+
+// EAPOL packet types.
+const (
+	PacketEAP      PacketType = 0 // Carries an EAP packet
+	PacketStart    PacketType = 1 // Asks the authenticator to start
+	PacketLogoff   PacketType = 2 // Returns the port to unauthorized
+	PacketKey      PacketType = 3 // Key material, after authentication
+	PacketASFAlert PacketType = 4 // Encapsulated ASF alert
+)
+
+// String returns the name of the packet type.
+func (t PacketType) String() string {
+	switch t {
+	case PacketEAP:
+		return "EAP-Packet"
+	case PacketStart:
+		return "EAPOL-Start"
+	case PacketLogoff:
+		return "EAPOL-Logoff"
+	case PacketKey:
+		return "EAPOL-Key"
+	case PacketASFAlert:
+		return "EAPOL-Encapsulated-ASF-Alert"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+// Packet is an EAPOL packet.
+type Packet struct {
+	Version uint8
+	Type    PacketType
+	Body    []byte
+}
+
+// Parse parses an EAPOL packet. Trailing bytes beyond its length, as
+// Ethernet padding, are ignored.
+func Parse(data []byte) (*Packet, error) {
+	if len(data) < HeaderSize {
+		return nil, fmt.Errorf("EAPOL packet too short: %d bytes", len(data))
+	}
+	if data[0] == 0 {
+		return nil, fmt.Errorf("invalid EAPOL version 0")
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if HeaderSize+length > len(data) {
+		return nil, fmt.Errorf("length %d exceeds packet of %d bytes", length, len(data))
+	}
+	return &Packet{
+		Version: data[0],
+		Type:    PacketType(data[1]),
+		Body:    data[HeaderSize : HeaderSize+length],
+	}, nil
+}
+
+// Serialize converts the packet to bytes.
+func (p *Packet) Serialize() []byte {
+	data := make([]byte, HeaderSize, HeaderSize+len(p.Body))
+	data[0] = p.Version
+	data[1] = uint8(p.Type)
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(p.Body)))
+	return append(data, p.Body...)
+}
@@ -0,0 +1,51 @@
+package eapol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	p := &Packet{Version: DefaultVersion, Type: PacketEAP, Body: []byte{1, 2, 0, 5, 1}}
+	data := p.Serialize()
+	want := []byte{0x02, 0x00, 0x00, 0x05, 1, 2, 0, 5, 1}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("Serialize() = % x, want % x", data, want)
+	}
+
+	// Ethernet padding after the packet is ignored
+	got, err := Parse(append(data, 0, 0, 0))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Version != DefaultVersion || got.Type != PacketEAP || !bytes.Equal(got.Body, p.Body) {
+		t.Errorf("Parse() = %+v, want %+v", got, p)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte{2, 1, 0}},
+		{"version 0", []byte{0, 1, 0, 0}},
+		{"length past end", []byte{2, 0, 0, 4, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Error("Parse() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestPacketTypeString(t *testing.T) {
+	if got := PacketStart.String(); got != "EAPOL-Start" {
+		t.Errorf("String() = %q, want EAPOL-Start", got)
+	}
+	if got := PacketType(9).String(); got != "Unknown(9)" {
+		t.Errorf("String() = %q, want Unknown(9)", got)
+	}
+}
@@ -0,0 +1,409 @@
+package eapol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+const (
+	// DefaultStartPeriod is the interval between EAPOL-Start packets while
+	// no authenticator answers (startPeriod).
+	DefaultStartPeriod = 30 * time.Second
+
+	// DefaultMaxStart is how many EAPOL-Start packets are sent before the
+	// port is assumed not to be access controlled (maxStart).
+	DefaultMaxStart = 3
+
+	// DefaultAuthPeriod is how long the supplicant waits for the next
+	// request of an exchange before starting over (authPeriod).
+	DefaultAuthPeriod = 30 * time.Second
+
+	// DefaultHeldPeriod is how long the supplicant waits after a failure
+	// before trying again (heldPeriod).
+	DefaultHeldPeriod = 60 * time.Second
+
+	// tickInterval is the resolution of the supplicant's timers.
+	tickInterval = time.Second
+)
+
+// ErrAuthenticationFailed is returned by Wait when the authenticator
+// rejects the supplicant.
+var ErrAuthenticationFailed = errors.New("authentication failed")
+
+// State is a state of the supplicant PAE state machine (IEEE 802.1X-2004
+// Section 8.2.11), simplified.
+type State int
+
+const (
+	StateDisconnected   State = iota // Not started
+	StateConnecting                  // Sending EAPOL-Start
+	StateAuthenticating              // In an EAP exchange
+	StateAuthenticated               // The port is authorized
+	StateHeld                        // Rejected, waiting to retry
+	StateLogoff                      // Logged off
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateAuthenticating:
+		return "authenticating"
+	case StateAuthenticated:
+		return "authenticated"
+	case StateHeld:
+		return "held"
+	case StateLogoff:
+		return "logoff"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Config configures a supplicant.
+type Config struct {
+	Identity string   // Sent in answer to Identity requests
+	Methods  []Method // Authentication methods offered, one per type
+
+	StartPeriod time.Duration // 0 = DefaultStartPeriod
+	MaxStart    int           // 0 = DefaultMaxStart
+	AuthPeriod  time.Duration // 0 = DefaultAuthPeriod
+	HeldPeriod  time.Duration // 0 = DefaultHeldPeriod
+}
+
+// Supplicant authenticates the port of a device to the switch it is
+// plugged into.
+//
+// The supplicant does not read the device: frames received on it are
+// passed to HandleFrame, which ignores those that are not EAPOL, so the
+// supplicant can share the device with the stack's other protocols.
+type Supplicant struct {
+	dev     ethernet.Device
+	config  Config
+	methods map[MethodType]Method
+	now     func() time.Time
+
+	mu            sync.Mutex
+	state         State
+	authenticator common.MACAddress // PAEGroupAddress until one answers
+	startCount    int
+	deadline      time.Time // Of the current state's timer
+	lastID        int       // Of the last request answered, -1 for none
+	lastResponse  []byte    // EAPOL packet answering it
+	changed       chan struct{}
+	onStateChange func(State)
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New creates a supplicant on dev.
+func New(dev ethernet.Device, config Config) (*Supplicant, error) {
+	if config.Identity == "" {
+		return nil, fmt.Errorf("no identity")
+	}
+	if config.StartPeriod == 0 {
+		config.StartPeriod = DefaultStartPeriod
+	}
+	if config.MaxStart == 0 {
+		config.MaxStart = DefaultMaxStart
+	}
+	if config.AuthPeriod == 0 {
+		config.AuthPeriod = DefaultAuthPeriod
+	}
+	if config.HeldPeriod == 0 {
+		config.HeldPeriod = DefaultHeldPeriod
+	}
+
+	methods := make(map[MethodType]Method)
+	for _, m := range config.Methods {
+		switch t := m.Type(); t {
+		case MethodIdentity, MethodNotification, MethodNak:
+			return nil, fmt.Errorf("method type %s is handled by the supplicant", t)
+		default:
+			if _, ok := methods[t]; ok {
+				return nil, fmt.Errorf("duplicate method %s", t)
+			}
+			methods[t] = m
+		}
+	}
+
+	return &Supplicant{
+		dev:           dev,
+		config:        config,
+		methods:       methods,
+		now:           time.Now,
+		authenticator: PAEGroupAddress,
+		lastID:        -1,
+		changed:       make(chan struct{}),
+		stop:          make(chan struct{}),
+	}, nil
+}
+
+// OnStateChange registers a callback invoked with each new state.
+func (s *Supplicant) OnStateChange(f func(State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onStateChange = f
+}
+
+// State returns the supplicant's state.
+func (s *Supplicant) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Authenticator returns the MAC address of the authenticator, or
+// PAEGroupAddress if none has answered.
+func (s *Supplicant) Authenticator() common.MACAddress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authenticator
+}
+
+// Start sends an EAPOL-Start and runs the supplicant's timers until Close.
+func (s *Supplicant) Start() error {
+	if err := s.update(s.connect); err != nil {
+		return err
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.update(s.tick)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until the port is authorized, the authenticator rejects the
+// supplicant, or ctx is done.
+func (s *Supplicant) Wait(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		state, changed := s.state, s.changed
+		s.mu.Unlock()
+
+		switch state {
+		case StateAuthenticated:
+			return nil
+		case StateHeld:
+			return ErrAuthenticationFailed
+		case StateLogoff:
+			return fmt.Errorf("supplicant logged off")
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Logoff sends an EAPOL-Logoff, returning the port to unauthorized. Start
+// authenticates again.
+func (s *Supplicant) Logoff() error {
+	return s.update(func() error {
+		s.setState(StateLogoff)
+		return s.send(s.authenticator, PacketLogoff, nil)
+	})
+}
+
+// Close stops the supplicant's timers and logs off. The device is left
+// open.
+func (s *Supplicant) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.wg.Wait()
+	if state := s.State(); state == StateDisconnected || state == StateLogoff {
+		return nil
+	}
+	return s.Logoff()
+}
+
+// HandleFrame processes a received Ethernet frame. Frames other than
+// EAPOL, and EAPOL packets other than EAP, are ignored.
+func (s *Supplicant) HandleFrame(frame *ethernet.Frame) error {
+	if frame.EtherType != common.EtherTypeEAPOL || frame.Source == s.dev.MACAddress() {
+		return nil
+	}
+	if frame.Destination != s.dev.MACAddress() && frame.Destination != PAEGroupAddress {
+		return nil
+	}
+	pkt, err := Parse(frame.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to parse EAPOL packet: %w", err)
+	}
+	if pkt.Type != PacketEAP {
+		return nil
+	}
+	e, err := ParseEAP(pkt.Body)
+	if err != nil {
+		return fmt.Errorf("failed to parse EAP packet: %w", err)
+	}
+
+	return s.update(func() error {
+		return s.handleEAP(frame.Source, e)
+	})
+}
+
+// handleEAP processes an EAP packet from src.
+func (s *Supplicant) handleEAP(src common.MACAddress, e *EAP) error {
+	if s.state == StateDisconnected || s.state == StateLogoff {
+		return nil
+	}
+
+	switch e.Code {
+	case CodeRequest:
+		// A retransmitted request gets the same response (RFC 3748
+		// Section 4.1)
+		if int(e.ID) == s.lastID && src == s.authenticator {
+			return s.sendPacket(s.authenticator, s.lastResponse)
+		}
+		t, data, err := s.respond(e)
+		if err != nil {
+			return fmt.Errorf("failed to answer %s request: %w", e.Type, err)
+		}
+		s.authenticator = src
+		s.setState(StateAuthenticating)
+		s.deadline = s.now().Add(s.config.AuthPeriod)
+
+		response := &EAP{Code: CodeResponse, ID: e.ID, Type: t, Data: data}
+		s.lastID = int(e.ID)
+		s.lastResponse = (&Packet{Version: DefaultVersion, Type: PacketEAP, Body: response.Serialize()}).Serialize()
+		return s.sendPacket(s.authenticator, s.lastResponse)
+
+	case CodeSuccess:
+		if s.state == StateConnecting || s.state == StateAuthenticating {
+			s.setState(StateAuthenticated)
+			s.lastID = -1
+		}
+	case CodeFailure:
+		if s.state == StateConnecting || s.state == StateAuthenticating {
+			s.setState(StateHeld)
+			s.lastID = -1
+			s.deadline = s.now().Add(s.config.HeldPeriod)
+		}
+	}
+	return nil
+}
+
+// respond returns the type and Type-Data of the response to a request:
+// the identity, an acknowledgement of a notification, the answer of a
+// method, or a Nak listing the methods the supplicant has.
+func (s *Supplicant) respond(e *EAP) (MethodType, []byte, error) {
+	switch e.Type {
+	case MethodIdentity:
+		return MethodIdentity, []byte(s.config.Identity), nil
+	case MethodNotification:
+		return MethodNotification, nil, nil
+	case MethodNak:
+		return 0, nil, fmt.Errorf("Nak is not a request type")
+	}
+	if m, ok := s.methods[e.Type]; ok {
+		data, err := m.Respond(e.ID, e.Data)
+		return e.Type, data, err
+	}
+
+	// An empty list would suggest no alternative; 0 says so explicitly
+	// (RFC 3748 Section 5.3.1)
+	desired := []byte{0}
+	if len(s.methods) > 0 {
+		desired = desired[:0]
+		for t := range s.methods {
+			desired = append(desired, uint8(t))
+		}
+		sort.Slice(desired, func(i, j int) bool { return desired[i] < desired[j] })
+	}
+	return MethodNak, desired, nil
+}
+
+// connect restarts authentication with an EAPOL-Start.
+func (s *Supplicant) connect() error {
+	s.setState(StateConnecting)
+	s.authenticator = PAEGroupAddress
+	s.lastID = -1
+	s.lastResponse = nil
+	s.startCount = 1
+	s.deadline = s.now().Add(s.config.StartPeriod)
+	return s.send(s.authenticator, PacketStart, nil)
+}
+
+// tick runs the timer of the current state.
+func (s *Supplicant) tick() error {
+	if s.now().Before(s.deadline) {
+		return nil
+	}
+	switch s.state {
+	case StateConnecting:
+		if s.startCount >= s.config.MaxStart {
+			// Nobody answered: the port is not access controlled
+			s.setState(StateAuthenticated)
+			return nil
+		}
+		s.startCount++
+		s.deadline = s.now().Add(s.config.StartPeriod)
+		return s.send(s.authenticator, PacketStart, nil)
+	case StateAuthenticating, StateHeld:
+		return s.connect()
+	}
+	return nil
+}
+
+// update runs f with the lock held, then reports any state change to the
+// callback.
+func (s *Supplicant) update(f func() error) error {
+	s.mu.Lock()
+	prev := s.state
+	err := f()
+	state, callback := s.state, s.onStateChange
+	s.mu.Unlock()
+
+	if state != prev && callback != nil {
+		callback(state)
+	}
+	return err
+}
+
+// setState moves to a state, waking Wait.
+func (s *Supplicant) setState(state State) {
+	if state == s.state {
+		return
+	}
+	s.state = state
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// send sends an EAPOL packet.
+func (s *Supplicant) send(dst common.MACAddress, t PacketType, body []byte) error {
+	return s.sendPacket(dst, (&Packet{Version: DefaultVersion, Type: t, Body: body}).Serialize())
+}
+
+// sendPacket sends a serialized EAPOL packet.
+func (s *Supplicant) sendPacket(dst common.MACAddress, data []byte) error {
+	frame := ethernet.NewFrame(dst, s.dev.MACAddress(), common.EtherTypeEAPOL, data)
+	if err := s.dev.WriteFrame(frame); err != nil {
+		return fmt.Errorf("failed to send EAPOL packet: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package eapol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+)
+
+var authMAC = common.MACAddress{0x02, 0, 0, 0, 0, 0xaa}
+
+// clock is a fake time source.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// testSupplicant returns a started supplicant, the far end of its link, and
+// its clock.
+func testSupplicant(t *testing.T, config Config) (*Supplicant, *memory.Endpoint, *clock) {
+	t.Helper()
+	a, b := memory.NewPipe(memory.Config{})
+	t.Cleanup(func() { a.Close() })
+	if config.Identity == "" {
+		config.Identity = "host"
+	}
+	s, err := New(a, config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c := &clock{now: time.Unix(1000, 0)}
+	s.now = c.Now
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	if dst, pkt := readEAPOL(t, b); dst != PAEGroupAddress || pkt.Type != PacketStart {
+		t.Fatalf("first packet = %s to %s, want EAPOL-Start to %s", pkt.Type, dst, PAEGroupAddress)
+	}
+	return s, b, c
+}
+
+// readEAPOL returns the destination and packet of the next frame sent.
+func readEAPOL(t *testing.T, b *memory.Endpoint) (common.MACAddress, *Packet) {
+	t.Helper()
+	data, err := b.ReadPacketTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("ReadPacketTimeout() error = %v", err)
+	}
+	frame, err := ethernet.Parse(data)
+	if err != nil {
+		t.Fatalf("ethernet.Parse() error = %v", err)
+	}
+	if frame.EtherType != common.EtherTypeEAPOL {
+		t.Fatalf("EtherType = %s, want EAPOL", frame.EtherType)
+	}
+	pkt, err := Parse(frame.Payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return frame.Destination, pkt
+}
+
+// readResponse returns the next EAP response sent to the authenticator.
+func readResponse(t *testing.T, b *memory.Endpoint) *EAP {
+	t.Helper()
+	dst, pkt := readEAPOL(t, b)
+	if dst != authMAC || pkt.Type != PacketEAP {
+		t.Fatalf("packet = %s to %s, want EAP to %s", pkt.Type, dst, authMAC)
+	}
+	e, err := ParseEAP(pkt.Body)
+	if err != nil {
+		t.Fatalf("ParseEAP() error = %v", err)
+	}
+	if e.Code != CodeResponse {
+		t.Fatalf("Code = %s, want Response", e.Code)
+	}
+	return e
+}
+
+// receive passes an EAP packet from the authenticator to s.
+func receive(t *testing.T, s *Supplicant, e *EAP) {
+	t.Helper()
+	body := (&Packet{Version: DefaultVersion, Type: PacketEAP, Body: e.Serialize()}).Serialize()
+	if err := s.HandleFrame(ethernet.NewFrame(PAEGroupAddress, authMAC, common.EtherTypeEAPOL, body)); err != nil {
+		t.Fatalf("HandleFrame() error = %v", err)
+	}
+}
+
+func TestSupplicantMD5(t *testing.T) {
+	s, b, _ := testSupplicant(t, Config{Methods: []Method{&MD5Challenge{Password: "password"}}})
+	var states []State
+	s.OnStateChange(func(state State) { states = append(states, state) })
+
+	receive(t, s, &EAP{Code: CodeRequest, ID: 1, Type: MethodIdentity})
+	if e := readResponse(t, b); e.ID != 1 || e.Type != MethodIdentity || string(e.Data) != "host" {
+		t.Errorf("identity response = %+v, want ID 1 identity \"host\"", e)
+	}
+	if s.Authenticator() != authMAC {
+		t.Errorf("Authenticator() = %s, want %s", s.Authenticator(), authMAC)
+	}
+
+	challenge := &EAP{Code: CodeRequest, ID: 2, Type: MethodMD5Challenge, Data: append([]byte{16}, make([]byte, 16)...)}
+	receive(t, s, challenge)
+	first := readResponse(t, b)
+	if first.ID != 2 || first.Type != MethodMD5Challenge || len(first.Data) != 17 {
+		t.Fatalf("MD5 response = %+v, want ID 2 with a 16-byte value", first)
+	}
+
+	// A retransmitted request is answered the same
+	receive(t, s, challenge)
+	if again := readResponse(t, b); !bytes.Equal(again.Data, first.Data) {
+		t.Errorf("response to retransmission = % x, want % x", again.Data, first.Data)
+	}
+
+	receive(t, s, &EAP{Code: CodeSuccess, ID: 2})
+	if err := s.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	want := []State{StateAuthenticating, StateAuthenticated}
+	if len(states) != len(want) || states[0] != want[0] || states[1] != want[1] {
+		t.Errorf("state changes = %v, want %v", states, want)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if dst, pkt := readEAPOL(t, b); dst != authMAC || pkt.Type != PacketLogoff {
+		t.Errorf("packet on Close = %s to %s, want EAPOL-Logoff to %s", pkt.Type, dst, authMAC)
+	}
+}
+
+func TestSupplicantNak(t *testing.T) {
+	tests := []struct {
+		name    string
+		methods []Method
+		want    []byte
+	}{
+		{"with MD5", []Method{&MD5Challenge{Password: "password"}}, []byte{uint8(MethodMD5Challenge)}},
+		{"no methods", nil, []byte{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, b, _ := testSupplicant(t, Config{Methods: tt.methods})
+			receive(t, s, &EAP{Code: CodeRequest, ID: 7, Type: 13}) // EAP-TLS
+			e := readResponse(t, b)
+			if e.Type != MethodNak || !bytes.Equal(e.Data, tt.want) {
+				t.Errorf("response = %s % x, want Nak % x", e.Type, e.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupplicantFailure(t *testing.T) {
+	s, b, c := testSupplicant(t, Config{})
+	receive(t, s, &EAP{Code: CodeRequest, ID: 1, Type: MethodIdentity})
+	readResponse(t, b)
+	receive(t, s, &EAP{Code: CodeFailure, ID: 1})
+	if err := s.Wait(context.Background()); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("Wait() error = %v, want %v", err, ErrAuthenticationFailed)
+	}
+
+	// Authentication restarts once the held period passes
+	c.Advance(DefaultHeldPeriod - time.Second)
+	s.update(s.tick)
+	if s.State() != StateHeld {
+		t.Fatalf("State() = %s before the held period passed, want held", s.State())
+	}
+	c.Advance(time.Second)
+	s.update(s.tick)
+	if s.State() != StateConnecting {
+		t.Fatalf("State() = %s after the held period, want connecting", s.State())
+	}
+	if dst, pkt := readEAPOL(t, b); dst != PAEGroupAddress || pkt.Type != PacketStart {
+		t.Errorf("packet = %s to %s, want EAPOL-Start to %s", pkt.Type, dst, PAEGroupAddress)
+	}
+}
+
+func TestSupplicantNoAuthenticator(t *testing.T) {
+	s, b, c := testSupplicant(t, Config{MaxStart: 2})
+	c.Advance(DefaultStartPeriod)
+	s.update(s.tick)
+	if _, pkt := readEAPOL(t, b); pkt.Type != PacketStart {
+		t.Fatalf("packet = %s, want EAPOL-Start", pkt.Type)
+	}
+
+	// After MaxStart starts the port is taken to be uncontrolled
+	c.Advance(DefaultStartPeriod)
+	s.update(s.tick)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Wait(ctx); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+}
+
+func TestSupplicantIgnoresFrames(t *testing.T) {
+	s, _, _ := testSupplicant(t, Config{})
+	frames := []*ethernet.Frame{
+		ethernet.NewFrame(PAEGroupAddress, authMAC, common.EtherTypeIPv4, []byte{0x45}),
+		ethernet.NewFrame(PAEGroupAddress, authMAC, common.EtherTypeEAPOL, (&Packet{Version: 2, Type: PacketKey, Body: []byte{2}}).Serialize()),
+		ethernet.NewFrame(common.MACAddress{0x02, 0, 0, 0, 0, 0xbb}, authMAC, common.EtherTypeEAPOL, (&Packet{Version: 2, Type: PacketEAP, Body: []byte{3, 1, 0, 4}}).Serialize()),
+	}
+	for _, frame := range frames {
+		if err := s.HandleFrame(frame); err != nil {
+			t.Errorf("HandleFrame() error = %v", err)
+		}
+	}
+	if s.State() != StateConnecting {
+		t.Errorf("State() = %s, want connecting", s.State())
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	a, _ := memory.NewPipe(memory.Config{})
+	defer a.Close()
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"no identity", Config{}},
+		{"duplicate method", Config{Identity: "host", Methods: []Method{&MD5Challenge{}, &MD5Challenge{}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(a, tt.config); err == nil {
+				t.Error("New() succeeded, want error")
+			}
+		})
+	}
+}
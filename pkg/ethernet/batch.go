@@ -0,0 +1,369 @@
+package ethernet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"golang.org/x/sys/unix"
+)
+
+// MaxBatchSize is the most frames WriteFrames and ReadFrames pass to the
+// kernel in one system call.
+const MaxBatchSize = 64
+
+// BatchWriter is implemented by devices that can send several frames at
+// once, more cheaply than one WriteFrame call each.
+type BatchWriter interface {
+	// WriteFrames sends frames in order and returns how many were sent.
+	// An error is returned with the count of the frames sent before it.
+	WriteFrames(frames []*Frame) (int, error)
+}
+
+// Compile-time check that Interface implements BatchWriter.
+var _ BatchWriter = (*Interface)(nil)
+
+// WriteFrames sends frames on dev, in one batch if it is a BatchWriter and
+// with WriteFrame otherwise, and returns how many were sent.
+func WriteFrames(dev Device, frames []*Frame) (int, error) {
+	if bw, ok := dev.(BatchWriter); ok {
+		return bw.WriteFrames(frames)
+	}
+	for i, frame := range frames {
+		if err := dev.WriteFrame(frame); err != nil {
+			return i, err
+		}
+	}
+	return len(frames), nil
+}
+
+// BatchReader is implemented by devices that can receive several frames at
+// once, more cheaply than one ReadFrame call each.
+type BatchReader interface {
+	// ReadFrames reads frames into the buffers and returns how many were
+	// read; frames[:n] are resliced to the frames' lengths.
+	ReadFrames(frames [][]byte) (int, error)
+}
+
+// Compile-time check that Interface implements BatchReader.
+var _ BatchReader = (*Interface)(nil)
+
+// batchReader is implemented by this package's devices, which receive a
+// batch of frames into caller buffers, reporting in lens[k] the length of
+// the frame in bufs[k] or -1 where one was dropped.
+type batchReader interface {
+	readBatch(bufs [][]byte, lens []int) (int, error)
+}
+
+// ReadFrames reads frames from dev into frames, in one batch from an
+// Interface or XDPInterface and with a single ReadFrame otherwise, and
+// returns how many were read. As with ReadFrame, each frame's Payload
+// lives in a pooled buffer until the frame is released.
+func ReadFrames(dev Device, frames []*Frame) (int, error) {
+	if len(frames) == 0 {
+		return 0, nil
+	}
+	br, ok := dev.(batchReader)
+	if !ok {
+		frame, err := dev.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		frames[0] = frame
+		return 1, nil
+	}
+
+	size := MaxTaggedFrameSize
+	if md, ok := dev.(MTUDevice); ok {
+		size = TaggedFrameSize(md.MTU())
+	}
+	batch := min(len(frames), MaxBatchSize)
+	bufs := make([]*common.PooledBuffer, batch)
+	data := make([][]byte, batch)
+	for k := range bufs {
+		bufs[k] = common.NewPooledBuffer(size)
+		data[k] = bufs[k].Bytes()
+	}
+	lens := make([]int, batch)
+	got, err := br.readBatch(data, lens)
+
+	n := 0
+	for k, buf := range bufs {
+		if k >= got || lens[k] < 0 {
+			buf.Release()
+			continue
+		}
+		buf.SetLen(lens[k])
+		frame, perr := ParseBuffer(buf)
+		if perr != nil {
+			counters.rxDropped.Add(1)
+			continue
+		}
+		frames[n] = frame
+		n++
+	}
+	return n, err
+}
+
+// SetPollTimeout sets how long ReadFrames waits for a frame to arrive
+// before returning none. Zero, the default, waits indefinitely.
+func (i *Interface) SetPollTimeout(d time.Duration) {
+	i.pollTimeout.Store(int64(d))
+}
+
+// ReadFrames reads up to len(frames) frames, MaxBatchSize at most, with
+// one recvmmsg(2) call. It waits for the first frame, up to the poll
+// timeout, and then takes the frames already queued without waiting.
+//
+// Each buffer is filled up to its capacity, which should be at least
+// TaggedFrameSize(i.MTU()), and frames[:n] are resliced to the frames read, with
+// the buffers of dropped frames moved after them. The frames are as
+// ReadFrame would parse them: an offloaded VLAN tag is put back, the FCS is
+// checked and removed if validation is enabled, and on a VLAN sub-interface
+// frames for other VLANs are dropped and the sub-interface's tags removed.
+// A poll timeout returns 0 frames and no error.
+func (i *Interface) ReadFrames(frames [][]byte) (int, error) {
+	lens := make([]int, min(len(frames), MaxBatchSize))
+	got, err := i.readBatch(frames, lens)
+	return compactFrames(frames, lens, got), err
+}
+
+// compactFrames reslices the first got buffers to the lengths readBatch
+// left in lens, moving those of dropped frames to the end, and returns the
+// number of frames.
+func compactFrames(frames [][]byte, lens []int, got int) int {
+	n := 0
+	for k := 0; k < got; k++ {
+		if lens[k] < 0 {
+			continue
+		}
+		frames[n], frames[k] = frames[k], frames[n]
+		frames[n] = frames[n][:lens[k]]
+		n++
+	}
+	return n
+}
+
+// readBatch receives up to len(lens) frames into bufs with one system call
+// and returns how many messages were received. lens[k] is set to the
+// length of the frame left in bufs[k], or -1 if it was dropped.
+func (i *Interface) readBatch(bufs [][]byte, lens []int) (int, error) {
+	if len(lens) == 0 {
+		return 0, nil
+	}
+
+	flags := unix.MSG_WAITFORONE
+	if timeout := time.Duration(i.pollTimeout.Load()); timeout > 0 {
+		ready, err := pollSocket(i.fd, unix.POLLIN, timeout)
+		if err != nil || !ready {
+			return 0, err
+		}
+		flags = unix.MSG_DONTWAIT
+	}
+
+	oobSize := syscall.CmsgSpace(sizeofTpacketAuxdata)
+	oob := make([]byte, len(lens)*oobSize)
+	iovecs := make([]syscall.Iovec, len(lens))
+	msgs := make([]mmsghdr, len(lens))
+	for k := range msgs {
+		// Leave room to put back an offloaded tag
+		buf := bufs[k][:cap(bufs[k])]
+		if len(buf) < HeaderSize+VLANTagSize {
+			return 0, fmt.Errorf("frame buffer %d too small: %d bytes", k, len(buf))
+		}
+		iovecs[k].Base = &buf[0]
+		iovecs[k].SetLen(len(buf) - VLANTagSize)
+		msgs[k].hdr.Iov = &iovecs[k]
+		msgs[k].hdr.Iovlen = 1
+		msgs[k].hdr.Control = &oob[k*oobSize]
+		msgs[k].hdr.SetControllen(oobSize)
+	}
+
+	r, _, errno := syscall.Syscall6(unix.SYS_RECVMMSG, uintptr(i.fd),
+		uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), uintptr(flags), 0, 0)
+	runtime.KeepAlive(iovecs)
+	runtime.KeepAlive(oob)
+	runtime.KeepAlive(bufs)
+	counters.rxSyscalls.Add(1)
+	if errno != 0 {
+		if errno == syscall.EAGAIN {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to receive packets: %w", errno)
+	}
+
+	got := int(r)
+	fcs := i.fcsValidate.Load()
+	for k := 0; k < got; k++ {
+		n := int(msgs[k].len)
+		counters.framesReceived.Add(1)
+		counters.bytesReceived.Add(uint64(n))
+		control := oob[k*oobSize : k*oobSize+int(msgs[k].hdr.Controllen)]
+		lens[k] = i.receivedFrame(bufs[k][:cap(bufs[k])], n, control, fcs)
+	}
+	return got, nil
+}
+
+// pollSocket waits up to timeout, or indefinitely if it is zero, for a
+// socket to be ready for the events.
+func pollSocket(fd int, events int16, timeout time.Duration) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: events}}
+	ms := -1
+	if timeout > 0 {
+		ms = int(max(timeout.Milliseconds(), 1))
+	}
+	for {
+		n, err := unix.Poll(fds, ms)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to poll socket: %w", err)
+		}
+		return n > 0, nil
+	}
+}
+
+// receivedFrame rewrites a frame received into buf[:n] in place into the
+// frame ReadFrame would parse, and returns its length, or -1 if the frame
+// is dropped. buf has room for a tag after the frame.
+func (i *Interface) receivedFrame(buf []byte, n int, oob []byte, fcs bool) int {
+	minSize := HeaderSize
+	if fcs {
+		minSize += FCSSize
+	}
+	if n < minSize {
+		counters.rxDropped.Add(1)
+		return -1
+	}
+
+	n = putBackVLAN(buf, n, oob)
+	if fcs {
+		if !VerifyFCS(buf[:n]) {
+			i.fcsErrors.Add(1)
+			counters.fcsErrors.Add(1)
+			return -1
+		}
+		n -= FCSSize
+	}
+
+	// Remove our tags, dropping frames that don't carry them, with the same
+	// TPIDs
+	if len(i.vlans) > 0 {
+		strip := len(i.vlans) * VLANTagSize
+		if n < HeaderSize+strip {
+			counters.rxDropped.Add(1)
+			return -1
+		}
+		for j, want := range i.vlans {
+			tag := buf[12+j*VLANTagSize:]
+			tpid := common.EtherType(binary.BigEndian.Uint16(tag[0:2]))
+			if tpid != want.TPID || parseVLANTag(tpid, binary.BigEndian.Uint16(tag[2:4])).VID != want.VID {
+				counters.rxDropped.Add(1)
+				return -1
+			}
+		}
+		copy(buf[12:], buf[12+strip:n])
+		n -= strip
+	}
+	return n
+}
+
+// mmsghdr is struct mmsghdr, the message header of sendmmsg(2) and
+// recvmmsg(2).
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// WriteFrames sends frames with sendmmsg(2), up to MaxBatchSize per system
+// call. Frames are tagged and given an FCS as WriteFrame does.
+func (i *Interface) WriteFrames(frames []*Frame) (int, error) {
+	sent := 0
+	for len(frames) > 0 {
+		batch := frames[:min(len(frames), MaxBatchSize)]
+		n, err := i.writeBatch(batch)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+		if n == 0 {
+			return sent, fmt.Errorf("failed to send frames: none sent")
+		}
+		frames = frames[n:]
+	}
+	return sent, nil
+}
+
+// writeBatch sends up to MaxBatchSize frames in one system call and returns
+// how many the kernel took.
+func (i *Interface) writeBatch(frames []*Frame) (int, error) {
+	fcs := i.fcsGenerate.Load()
+	bufs := make([]*common.PooledBuffer, 0, len(frames))
+	defer func() {
+		for _, buf := range bufs {
+			buf.Release()
+		}
+	}()
+
+	addrs := make([]syscall.RawSockaddrLinklayer, len(frames))
+	iovecs := make([]syscall.Iovec, len(frames))
+	msgs := make([]mmsghdr, len(frames))
+	for n, frame := range frames {
+		if len(i.vlans) > 0 {
+			tagged := *frame
+			tagged.VLAN = append(append([]VLANTag(nil), i.vlans...), frame.VLAN...)
+			frame = &tagged
+		}
+
+		size := frame.Size()
+		if fcs {
+			size += FCSSize
+		}
+		buf := common.NewPooledBuffer(size)
+		bufs = append(bufs, buf)
+		data := buf.Bytes()[:size]
+		written, err := frame.SerializeTo(data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to serialize frame: %w", err)
+		}
+		if fcs {
+			binary.LittleEndian.PutUint32(data[written:], CalculateFCS(data[:written]))
+		}
+
+		addrs[n] = syscall.RawSockaddrLinklayer{
+			Family:   syscall.AF_PACKET,
+			Protocol: htons(syscall.ETH_P_ALL),
+			Ifindex:  int32(i.index),
+			Halen:    6,
+		}
+		copy(addrs[n].Addr[:], frame.Destination[:])
+		iovecs[n].Base = &data[0]
+		iovecs[n].SetLen(len(data))
+		msgs[n].hdr.Name = (*byte)(unsafe.Pointer(&addrs[n]))
+		msgs[n].hdr.Namelen = syscall.SizeofSockaddrLinklayer
+		msgs[n].hdr.Iov = &iovecs[n]
+		msgs[n].hdr.Iovlen = 1
+	}
+
+	r, _, errno := syscall.Syscall6(unix.SYS_SENDMMSG, uintptr(i.fd),
+		uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), 0, 0, 0)
+	runtime.KeepAlive(addrs)
+	runtime.KeepAlive(iovecs)
+	runtime.KeepAlive(bufs)
+	if errno != 0 {
+		counters.txErrors.Add(1)
+		return 0, fmt.Errorf("failed to send frames: %w", errno)
+	}
+
+	sent := int(r)
+	counters.framesSent.Add(uint64(sent))
+	for n := 0; n < sent; n++ {
+		counters.bytesSent.Add(uint64(msgs[n].len))
+	}
+	return sent, nil
+}
@@ -0,0 +1,288 @@
+package ethernet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// frameDevice is a Device without batch support that fails its failAt'th
+// write.
+type frameDevice struct {
+	written []*Frame
+	failAt  int
+}
+
+func (d *frameDevice) Name() string                  { return "test0" }
+func (d *frameDevice) MACAddress() common.MACAddress { return common.MACAddress{} }
+func (d *frameDevice) Index() int                    { return 1 }
+func (d *frameDevice) ReadFrame() (*Frame, error)    { return nil, errors.New("not supported") }
+func (d *frameDevice) Close() error                  { return nil }
+
+func (d *frameDevice) WriteFrame(frame *Frame) error {
+	if len(d.written) == d.failAt {
+		return errors.New("write failed")
+	}
+	d.written = append(d.written, frame)
+	return nil
+}
+
+func TestWriteFramesFallback(t *testing.T) {
+	frames := make([]*Frame, 5)
+	for i := range frames {
+		frames[i] = NewFrame(common.BroadcastMAC, common.MACAddress{}, common.EtherTypeIPv4, []byte{byte(i)})
+	}
+
+	dev := &frameDevice{failAt: -1}
+	if n, err := WriteFrames(dev, frames); n != 5 || err != nil {
+		t.Errorf("WriteFrames() = %d, %v, want 5, nil", n, err)
+	}
+	for i, frame := range dev.written {
+		if frame != frames[i] {
+			t.Errorf("frame %d written out of order", i)
+		}
+	}
+
+	dev = &frameDevice{failAt: 3}
+	if n, err := WriteFrames(dev, frames); n != 3 || err == nil {
+		t.Errorf("WriteFrames() = %d, %v, want 3 and an error", n, err)
+	}
+}
+
+// socketInterface returns an Interface reading the datagrams written to
+// the returned socket, standing in for a packet socket.
+func socketInterface(t testing.TB) (*Interface, int) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatalf("Socketpair() error = %v", err)
+	}
+	t.Cleanup(func() {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+	})
+	return &Interface{name: "test0", fd: fds[0], index: 1}, fds[1]
+}
+
+func testFrame(i int, tags ...VLANTag) []byte {
+	frame := NewFrame(common.BroadcastMAC, common.MACAddress{2, 0, 0, 0, 0, 1}, common.EtherTypeIPv4, []byte{byte(i), 1, 2, 3})
+	frame.VLAN = tags
+	return frame.Serialize()
+}
+
+func TestInterfaceReadFrames(t *testing.T) {
+	withFCS := func(data []byte) []byte {
+		return binary.LittleEndian.AppendUint32(data, CalculateFCS(data))
+	}
+	// A frame with its outer tag removed, padding and all
+	untagged := func(data []byte) []byte {
+		return append(append([]byte(nil), data[:12]...), data[12+VLANTagSize:]...)
+	}
+	badFCS := withFCS(testFrame(9))
+	badFCS[len(badFCS)-1] ^= 0xff
+
+	tests := []struct {
+		name  string
+		vlans []VLANTag
+		fcs   bool
+		sent  [][]byte
+		want  [][]byte
+	}{
+		{
+			name: "plain",
+			sent: [][]byte{testFrame(0), testFrame(1), testFrame(2)},
+			want: [][]byte{testFrame(0), testFrame(1), testFrame(2)},
+		},
+		{
+			name:  "sub-interface",
+			vlans: []VLANTag{NewVLANTag(100)},
+			sent:  [][]byte{testFrame(0, NewVLANTag(100)), testFrame(1, NewVLANTag(200)), testFrame(2), testFrame(3, NewVLANTag(100), NewVLANTag(5))},
+			want:  [][]byte{untagged(testFrame(0, NewVLANTag(100))), untagged(testFrame(3, NewVLANTag(100), NewVLANTag(5)))},
+		},
+		{
+			// The outer tag of the second frame is a customer tag
+			name:  "QinQ sub-interface",
+			vlans: []VLANTag{NewServiceVLANTag(100), NewVLANTag(5)},
+			sent:  [][]byte{testFrame(0, NewServiceVLANTag(100), NewVLANTag(5)), testFrame(1, NewVLANTag(100), NewVLANTag(5))},
+			want:  [][]byte{untagged(untagged(testFrame(0, NewServiceVLANTag(100), NewVLANTag(5))))},
+		},
+		{
+			name: "fcs",
+			fcs:  true,
+			sent: [][]byte{withFCS(testFrame(0)), badFCS, withFCS(testFrame(2))},
+			want: [][]byte{testFrame(0), testFrame(2)},
+		},
+	}
+	for _, tt := range tests {
+		iface, peer := socketInterface(t)
+		iface.vlans = tt.vlans
+		iface.SetFCSValidation(tt.fcs)
+		for _, data := range tt.sent {
+			if _, err := syscall.Write(peer, data); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+
+		frames := make([][]byte, 8)
+		for k := range frames {
+			frames[k] = make([]byte, MaxTaggedFrameSize)
+		}
+		n, err := iface.ReadFrames(frames)
+		if err != nil {
+			t.Fatalf("%s: ReadFrames() error = %v", tt.name, err)
+		}
+		if n != len(tt.want) {
+			t.Fatalf("%s: ReadFrames() = %d, want %d", tt.name, n, len(tt.want))
+		}
+		for k, want := range tt.want {
+			if diff := common.DiffBytes(want, frames[k]); diff != "" {
+				t.Errorf("%s: frame %d:\n%s", tt.name, k, diff)
+			}
+		}
+		// Every buffer is still there to be reused
+		seen := map[*byte]bool{}
+		for _, frame := range frames {
+			seen[&frame[:1][0]] = true
+		}
+		if len(seen) != len(frames) {
+			t.Errorf("%s: %d distinct buffers after ReadFrames(), want %d", tt.name, len(seen), len(frames))
+		}
+	}
+}
+
+func TestReceivedFrameAuxData(t *testing.T) {
+	oob := make([]byte, syscall.CmsgSpace(sizeofTpacketAuxdata))
+	hdr := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	hdr.Level = syscall.SOL_PACKET
+	hdr.Type = packetAuxData
+	hdr.SetLen(syscall.CmsgLen(sizeofTpacketAuxdata))
+	data := oob[syscall.CmsgLen(0):]
+	binary.NativeEndian.PutUint32(data[0:4], tpStatusVLANValid)
+	binary.NativeEndian.PutUint16(data[16:18], 100)
+
+	sent := testFrame(0)
+	buf := make([]byte, MaxTaggedFrameSize)
+
+	// The offloaded tag is put back
+	iface := &Interface{}
+	n := iface.receivedFrame(buf, copy(buf, sent), oob, false)
+	if n != len(sent)+VLANTagSize {
+		t.Fatalf("receivedFrame() = %d, want %d", n, len(sent)+VLANTagSize)
+	}
+	frame, err := Parse(buf[:n])
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(frame.VLAN) != 1 || frame.VLAN[0].VID != 100 || frame.EtherType != common.EtherTypeIPv4 {
+		t.Errorf("frame has tags %v and EtherType %v, want VLAN 100 and IPv4", frame.VLAN, frame.EtherType)
+	}
+
+	// and removed again on the sub-interface
+	iface = &Interface{vlans: []VLANTag{NewVLANTag(100)}}
+	n = iface.receivedFrame(buf, copy(buf, sent), oob, false)
+	if diff := common.DiffBytes(sent, buf[:max(n, 0)]); diff != "" {
+		t.Errorf("receivedFrame() on the sub-interface:\n%s", diff)
+	}
+
+	// The FCS was computed with the tag on the wire, so it is checked with
+	// the tag put back
+	tagged := testFrame(0, NewVLANTag(100))
+	wire := binary.LittleEndian.AppendUint32(tagged, CalculateFCS(tagged))
+	stripped := append(append([]byte(nil), wire[:12]...), wire[12+VLANTagSize:]...)
+	iface = &Interface{}
+	n = iface.receivedFrame(buf, copy(buf, stripped), oob, true)
+	if diff := common.DiffBytes(tagged, buf[:max(n, 0)]); diff != "" {
+		t.Errorf("receivedFrame() with FCS validation:\n%s", diff)
+	}
+	stripped[len(stripped)-1] ^= 0xff
+	if n := iface.receivedFrame(buf, copy(buf, stripped), oob, true); n != -1 || iface.FCSErrors() != 1 {
+		t.Errorf("receivedFrame() with a bad FCS = %d, %d FCS errors, want -1, 1", n, iface.FCSErrors())
+	}
+}
+
+func TestReadFramesPollTimeout(t *testing.T) {
+	iface, peer := socketInterface(t)
+	iface.SetPollTimeout(10 * time.Millisecond)
+
+	frames := make([]*Frame, 4)
+	start := time.Now()
+	if n, err := ReadFrames(iface, frames); n != 0 || err != nil {
+		t.Fatalf("ReadFrames() = %d, %v, want 0, nil", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("ReadFrames() returned after %v, want the poll timeout", elapsed)
+	}
+
+	for k := 0; k < 2; k++ {
+		syscall.Write(peer, testFrame(k))
+	}
+	n, err := ReadFrames(iface, frames)
+	if n != 2 || err != nil {
+		t.Fatalf("ReadFrames() = %d, %v, want 2, nil", n, err)
+	}
+	for k, frame := range frames[:n] {
+		if frame.Payload[0] != byte(k) {
+			t.Errorf("frame %d has payload %v", k, frame.Payload)
+		}
+		frame.Release()
+	}
+}
+
+// BenchmarkReadFrames compares reading bursts of frames one system call
+// each with reading them in batches, and reports the frames per call.
+func BenchmarkReadFrames(b *testing.B) {
+	const burst = 32
+	data := testFrame(0)
+
+	read := map[string]func(iface *Interface, frames []*Frame) (int, error){
+		"ReadFrame": func(iface *Interface, frames []*Frame) (int, error) {
+			frame, err := iface.ReadFrame()
+			if err != nil {
+				return 0, err
+			}
+			frames[0] = frame
+			return 1, nil
+		},
+		"ReadFrames": func(iface *Interface, frames []*Frame) (int, error) {
+			return ReadFrames(iface, frames)
+		},
+	}
+	for _, name := range []string{"ReadFrame", "ReadFrames"} {
+		b.Run(fmt.Sprintf("%s/burst=%d", name, burst), func(b *testing.B) {
+			iface, peer := socketInterface(b)
+			frames := make([]*Frame, burst)
+			before := GetStats()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for k := 0; k < burst; k++ {
+					if _, err := syscall.Write(peer, data); err != nil {
+						b.Fatalf("Write() error = %v", err)
+					}
+				}
+				b.StartTimer()
+				for got := 0; got < burst; {
+					n, err := read[name](iface, frames)
+					if err != nil {
+						b.Fatalf("%s() error = %v", name, err)
+					}
+					for _, frame := range frames[:n] {
+						frame.Release()
+					}
+					got += n
+				}
+			}
+			b.StopTimer()
+			after := GetStats()
+			frames64 := float64(after.FramesReceived - before.FramesReceived)
+			b.ReportMetric(frames64/float64(after.RxSyscalls-before.RxSyscalls), "frames/syscall")
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/frames64, "ns/frame")
+		})
+	}
+}
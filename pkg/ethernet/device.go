@@ -0,0 +1,44 @@
+package ethernet
+
+import "github.com/therealutkarshpriyadarshi/network/pkg/common"
+
+// Device is a link-layer endpoint that sends and receives Ethernet frames.
+//
+// Interface (a raw AF_PACKET socket bound to a physical NIC) implements Device,
+// as do virtual backends such as TAP devices. Protocol handlers should accept a
+// Device rather than a concrete *Interface so they can run over either.
+type Device interface {
+	// Name returns the device name (e.g., "eth0", "tap0").
+	Name() string
+
+	// MACAddress returns the hardware address used as the source of outgoing frames.
+	MACAddress() common.MACAddress
+
+	// Index returns the kernel interface index.
+	Index() int
+
+	// ReadFrame blocks until a frame is received.
+	ReadFrame() (*Frame, error)
+
+	// WriteFrame transmits a frame.
+	WriteFrame(frame *Frame) error
+
+	// Close releases the device.
+	Close() error
+}
+
+// MTUDevice is implemented by devices that know their MTU, the largest
+// payload of the frames they carry, when it may differ from
+// MaxPayloadSize, as with jumbo frames.
+type MTUDevice interface {
+	Device
+
+	// MTU returns the device MTU.
+	MTU() int
+}
+
+// Compile-time checks that Interface implements Device and MTUDevice.
+var (
+	_ Device    = (*Interface)(nil)
+	_ MTUDevice = (*Interface)(nil)
+)
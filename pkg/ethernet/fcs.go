@@ -0,0 +1,57 @@
+package ethernet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// The Frame Check Sequence is a CRC-32 (IEEE 802.3 polynomial) over the
+// destination MAC through the end of the payload (including padding and VLAN
+// tags). It is transmitted least significant byte first.
+
+// ErrFCSMismatch is returned when a frame's FCS does not match its contents.
+var ErrFCSMismatch = errors.New("ethernet FCS mismatch")
+
+// CalculateFCS computes the Frame Check Sequence for a serialized frame
+// (without FCS).
+func CalculateFCS(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+// AppendFCS appends the FCS for data to the end of data.
+func AppendFCS(data []byte) []byte {
+	return binary.LittleEndian.AppendUint32(data, CalculateFCS(data))
+}
+
+// VerifyFCS checks the trailing 4-byte FCS of a frame.
+func VerifyFCS(data []byte) bool {
+	if len(data) < HeaderSize+FCSSize {
+		return false
+	}
+	body := data[:len(data)-FCSSize]
+	fcs := binary.LittleEndian.Uint32(data[len(data)-FCSSize:])
+	return CalculateFCS(body) == fcs
+}
+
+// SerializeWithFCS converts the frame to bytes and appends the FCS.
+func (f *Frame) SerializeWithFCS() []byte {
+	return AppendFCS(f.Serialize())
+}
+
+// ParseWithFCS parses a frame that ends with an FCS, validating it.
+// The FCS is removed from the payload. If the FCS does not match,
+// the returned error wraps ErrFCSMismatch.
+func ParseWithFCS(data []byte) (*Frame, error) {
+	if len(data) < HeaderSize+FCSSize {
+		return nil, fmt.Errorf("ethernet frame too short for FCS: %d bytes", len(data))
+	}
+
+	if !VerifyFCS(data) {
+		return nil, fmt.Errorf("%w: got 0x%08x, want 0x%08x", ErrFCSMismatch,
+			binary.LittleEndian.Uint32(data[len(data)-FCSSize:]), CalculateFCS(data[:len(data)-FCSSize]))
+	}
+
+	return Parse(data[:len(data)-FCSSize])
+}
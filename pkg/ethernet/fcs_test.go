@@ -0,0 +1,67 @@
+package ethernet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestCalculateFCS(t *testing.T) {
+	// Standard CRC-32/IEEE check value
+	if got := CalculateFCS([]byte("123456789")); got != 0xCBF43926 {
+		t.Errorf("CalculateFCS() = 0x%08x, want 0xcbf43926", got)
+	}
+}
+
+func TestSerializeWithFCS(t *testing.T) {
+	frame := NewFrame(common.BroadcastMAC, common.MACAddress{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		common.EtherTypeARP, bytes.Repeat([]byte{0x01}, 28))
+
+	data := frame.SerializeWithFCS()
+	if len(data) != MinFrameSize {
+		t.Errorf("len(SerializeWithFCS()) = %d, want %d", len(data), MinFrameSize)
+	}
+	if !bytes.Equal(data[:len(data)-FCSSize], frame.Serialize()) {
+		t.Error("SerializeWithFCS() body differs from Serialize()")
+	}
+	if !VerifyFCS(data) {
+		t.Error("VerifyFCS() = false for freshly serialized frame")
+	}
+
+	// A correct FCS makes the CRC over the whole frame equal the magic residue
+	if residue := CalculateFCS(data) ^ 0xFFFFFFFF; residue != 0xDEBB20E3 {
+		t.Errorf("CRC residue = 0x%08x, want 0xdebb20e3", residue)
+	}
+}
+
+func TestParseWithFCS(t *testing.T) {
+	frame := NewFrame(common.BroadcastMAC, common.MACAddress{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		common.EtherTypeIPv4, bytes.Repeat([]byte{0xAB}, 100))
+	frame.VLAN = []VLANTag{NewVLANTag(42)}
+	data := frame.SerializeWithFCS()
+
+	parsed, err := ParseWithFCS(data)
+	if err != nil {
+		t.Fatalf("ParseWithFCS() error = %v", err)
+	}
+	if !bytes.Equal(parsed.Payload, frame.Payload) {
+		t.Error("Payload mismatch (FCS not stripped?)")
+	}
+	if parsed.VLANID() != 42 {
+		t.Errorf("VLANID() = %d, want 42", parsed.VLANID())
+	}
+
+	// Flip a payload bit
+	corrupt := append([]byte(nil), data...)
+	corrupt[30] ^= 0x01
+	if _, err := ParseWithFCS(corrupt); !errors.Is(err, ErrFCSMismatch) {
+		t.Errorf("ParseWithFCS() error = %v, want ErrFCSMismatch", err)
+	}
+
+	// Too short to hold a header and FCS
+	if _, err := ParseWithFCS(data[:HeaderSize]); err == nil {
+		t.Error("ParseWithFCS() should return error for too short frame")
+	}
+}
@@ -4,6 +4,7 @@ package ethernet
 import (
 	"encoding/binary"
 	"fmt"
+	"strings"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
 )
@@ -32,6 +33,14 @@ const (
 	// MaxPayloadSize is the maximum payload size (1500 bytes, MTU).
 	MaxPayloadSize = 1500
 
+	// MinMTU is the smallest MTU SetMTU takes, the smallest IPv4 packet
+	// every link must carry (RFC 791).
+	MinMTU = 68
+
+	// JumboMTU is the MTU of the usual jumbo frames (9000 bytes), on links
+	// whose NICs and switches carry them.
+	JumboMTU = 9000
+
 	// FCSSize is the size of the Frame Check Sequence (4 bytes).
 	FCSSize = 4
 )
@@ -40,49 +49,116 @@ const (
 type Frame struct {
 	Destination common.MACAddress
 	Source      common.MACAddress
+	VLAN        []VLANTag // 802.1Q/802.1ad tags, outermost first (nil if untagged)
 	EtherType   common.EtherType
 	Payload     []byte
+
+	// buf is the pooled buffer Payload points into, for frames read from
+	// an Interface
+	buf *common.PooledBuffer
 }
 
 // Parse parses an Ethernet frame from raw bytes.
+// VLAN tags (including stacked QinQ tags) are stripped into Frame.VLAN and
+// EtherType is set to the type of the encapsulated payload.
 // Note: This does not validate or parse the FCS (Frame Check Sequence),
 // as that's typically handled by the network hardware.
+// Use ParseWithFCS for frames that still carry one.
 func Parse(data []byte) (*Frame, error) {
-	if len(data) < HeaderSize {
-		return nil, fmt.Errorf("ethernet frame too short: %d bytes", len(data))
+	frame := &Frame{}
+	if err := frame.Decode(data); err != nil {
+		return nil, err
 	}
+	return frame, nil
+}
 
-	frame := &Frame{}
+// Decode parses an Ethernet frame from raw bytes into f, as Parse does,
+// reusing f's VLAN slice. Payload aliases data, so data must not be reused
+// while the frame is in use. Decoding into a frame that is reused for each
+// packet does not allocate.
+func (f *Frame) Decode(data []byte) error {
+	if len(data) < HeaderSize {
+		return fmt.Errorf("ethernet frame too short: %d bytes", len(data))
+	}
 
 	// Parse destination MAC (6 bytes)
-	copy(frame.Destination[:], data[0:6])
+	copy(f.Destination[:], data[0:6])
 
 	// Parse source MAC (6 bytes)
-	copy(frame.Source[:], data[6:12])
+	copy(f.Source[:], data[6:12])
 
 	// Parse EtherType (2 bytes, big endian)
-	frame.EtherType = common.EtherType(binary.BigEndian.Uint16(data[12:14]))
+	f.EtherType = common.EtherType(binary.BigEndian.Uint16(data[12:14]))
+	offset := HeaderSize
+
+	// Strip VLAN tags: each one is followed by another EtherType
+	tags := f.VLAN[:0]
+	for isVLANTPID(f.EtherType) {
+		if len(data) < offset+VLANTagSize {
+			return fmt.Errorf("ethernet frame too short for VLAN tag: %d bytes", len(data))
+		}
+		tci := binary.BigEndian.Uint16(data[offset : offset+2])
+		tags = append(tags, parseVLANTag(f.EtherType, tci))
+		f.EtherType = common.EtherType(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		offset += VLANTagSize
+	}
+	f.VLAN = tags
+	if len(tags) == 0 {
+		f.VLAN = nil
+	}
 
 	// Remaining bytes are payload (minus FCS if present)
 	// In raw socket captures, the FCS is usually not included
-	frame.Payload = data[HeaderSize:]
+	f.Payload = data[offset:]
+
+	return nil
+}
 
+// ParseBuffer parses a frame held in a pooled buffer, taking over the
+// caller's reference: the frame's Release returns the buffer to its pool,
+// and on error the buffer is released immediately.
+func ParseBuffer(buf *common.PooledBuffer) (*Frame, error) {
+	frame, err := Parse(buf.Bytes())
+	if err != nil {
+		buf.Release()
+		return nil, err
+	}
+	frame.buf = buf
 	return frame, nil
 }
 
+// Release returns the buffer of a frame read from an Interface to its pool.
+// The frame's Payload, and anything parsed from it without copying, must
+// not be used afterwards. Release is a no-op for other frames; a frame that
+// is never released is reclaimed by the garbage collector instead.
+func (f *Frame) Release() {
+	if f.buf == nil {
+		return
+	}
+	f.buf.Release()
+	f.buf = nil
+	f.Payload = nil
+}
+
 // Serialize converts the frame to bytes for transmission.
+// Any VLAN tags are inserted after the source MAC, outermost first.
 // This does not add the FCS (Frame Check Sequence) as that's typically
-// added by the network hardware.
+// added by the network hardware. Use SerializeWithFCS to append one.
 func (f *Frame) Serialize() []byte {
-	// Calculate total frame size
-	frameSize := HeaderSize + len(f.Payload)
+	frame := make([]byte, f.Size())
+	f.SerializeTo(frame) // Cannot fail, frame is exactly Size() bytes
+	return frame
+}
 
-	// If payload is too small, we need to pad it
-	if len(f.Payload) < MinPayloadSize {
-		frameSize = HeaderSize + MinPayloadSize
+// SerializeTo writes the frame into b, as Serialize does, and returns the
+// number of bytes written (Size). Padding is written as zeros, so b may be
+// a reused buffer.
+func (f *Frame) SerializeTo(b []byte) (int, error) {
+	size := f.Size()
+	if len(b) < size {
+		return 0, fmt.Errorf("buffer too small for frame: %d bytes (need %d)", len(b), size)
 	}
-
-	frame := make([]byte, frameSize)
+	frame := b[:size]
 
 	// Write destination MAC
 	copy(frame[0:6], f.Destination[:])
@@ -90,28 +166,63 @@ func (f *Frame) Serialize() []byte {
 	// Write source MAC
 	copy(frame[6:12], f.Source[:])
 
-	// Write EtherType
-	binary.BigEndian.PutUint16(frame[12:14], uint16(f.EtherType))
+	// Write VLAN tags
+	offset := 12
+	for _, tag := range f.VLAN {
+		putVLANTag(frame[offset:offset+VLANTagSize], tag)
+		offset += VLANTagSize
+	}
 
-	// Write payload
-	copy(frame[HeaderSize:], f.Payload)
+	// Write EtherType
+	binary.BigEndian.PutUint16(frame[offset:offset+2], uint16(f.EtherType))
+	offset += 2
 
-	// Padding is implicitly zero bytes if payload is too small
+	// Write payload, then zero padding if the payload is too small
+	offset += copy(frame[offset:], f.Payload)
+	clear(frame[offset:])
 
-	return frame
+	return size, nil
 }
 
 // Size returns the total size of the frame in bytes.
+// Frames shorter than the minimum (60 bytes without FCS) are padded;
+// VLAN tags count towards the minimum.
 func (f *Frame) Size() int {
-	size := HeaderSize + len(f.Payload)
-	if len(f.Payload) < MinPayloadSize {
+	size := f.HeaderLen() + len(f.Payload)
+	if size < HeaderSize+MinPayloadSize {
 		size = HeaderSize + MinPayloadSize
 	}
 	return size
 }
 
+// HeaderLen returns the size of the header including any VLAN tags.
+func (f *Frame) HeaderLen() int {
+	return HeaderSize + len(f.VLAN)*VLANTagSize
+}
+
+// IsTagged returns true if the frame carries at least one VLAN tag.
+func (f *Frame) IsTagged() bool {
+	return len(f.VLAN) > 0
+}
+
+// VLANID returns the innermost (customer) VLAN ID, or 0 if untagged.
+func (f *Frame) VLANID() uint16 {
+	if len(f.VLAN) == 0 {
+		return 0
+	}
+	return f.VLAN[len(f.VLAN)-1].VID
+}
+
 // String returns a human-readable representation of the frame.
 func (f *Frame) String() string {
+	if len(f.VLAN) > 0 {
+		vids := make([]string, len(f.VLAN))
+		for i, tag := range f.VLAN {
+			vids[i] = fmt.Sprintf("%d", tag.VID)
+		}
+		return fmt.Sprintf("Ethernet{Dst=%s, Src=%s, VLAN=%s, Type=%s, PayloadLen=%d}",
+			f.Destination, f.Source, strings.Join(vids, "."), f.EtherType, len(f.Payload))
+	}
 	return fmt.Sprintf("Ethernet{Dst=%s, Src=%s, Type=%s, PayloadLen=%d}",
 		f.Destination, f.Source, f.EtherType, len(f.Payload))
 }
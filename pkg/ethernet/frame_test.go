@@ -259,6 +259,97 @@ func TestFrameString(t *testing.T) {
 	}
 }
 
+func TestFrameDecode(t *testing.T) {
+	dst := common.BroadcastMAC
+	src := common.MACAddress{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	tagged := NewFrame(dst, src, common.EtherTypeIPv4, []byte{1, 2, 3})
+	tagged.VLAN = []VLANTag{{TPID: common.EtherTypeVLAN, VID: 100}}
+	untagged := NewFrame(dst, src, common.EtherTypeARP, []byte{4, 5, 6})
+
+	// Decoding into the same frame must not leave state from the last one
+	var frame Frame
+	if err := frame.Decode(tagged.Serialize()); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if frame.VLANID() != 100 || frame.EtherType != common.EtherTypeIPv4 {
+		t.Errorf("Decode() = %v, want VLAN 100 IPv4", &frame)
+	}
+	if err := frame.Decode(untagged.Serialize()); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if frame.VLAN != nil || frame.EtherType != common.EtherTypeARP || !bytes.Equal(frame.Payload[:3], []byte{4, 5, 6}) {
+		t.Errorf("Decode() = %v, want untagged ARP", &frame)
+	}
+
+	if err := frame.Decode(make([]byte, HeaderSize-1)); err == nil {
+		t.Error("Decode() of a short frame succeeded")
+	}
+
+	data := tagged.Serialize()
+	allocs := testing.AllocsPerRun(100, func() {
+		frame.Decode(data)
+	})
+	if allocs != 0 {
+		t.Errorf("Decode() allocs = %v, want 0", allocs)
+	}
+}
+
+func TestFrameSerializeTo(t *testing.T) {
+	dst := common.BroadcastMAC
+	src := common.MACAddress{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	frame := NewFrame(dst, src, common.EtherTypeIPv4, []byte{1, 2, 3})
+
+	// Padding must be zeroed even in a dirty buffer
+	buf := bytes.Repeat([]byte{0xFF}, 100)
+	n, err := frame.SerializeTo(buf)
+	if err != nil {
+		t.Fatalf("SerializeTo() error = %v", err)
+	}
+	if want := frame.Serialize(); !bytes.Equal(buf[:n], want) {
+		t.Errorf("SerializeTo() = %x, want %x", buf[:n], want)
+	}
+
+	if _, err := frame.SerializeTo(buf[:frame.Size()-1]); err == nil {
+		t.Error("SerializeTo() into a short buffer succeeded")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		frame.SerializeTo(buf)
+	})
+	if allocs != 0 {
+		t.Errorf("SerializeTo() allocs = %v, want 0", allocs)
+	}
+}
+
+func TestParseBuffer(t *testing.T) {
+	src := common.MACAddress{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	data := NewFrame(common.BroadcastMAC, src, common.EtherTypeIPv4, []byte{1, 2, 3}).Serialize()
+	inUse := common.PooledBuffersInUse()
+
+	buf := common.NewPooledBuffer(len(data))
+	copy(buf.Bytes(), data)
+	frame, err := ParseBuffer(buf)
+	if err != nil {
+		t.Fatalf("ParseBuffer() error = %v", err)
+	}
+	if frame.Source != src || !bytes.Equal(frame.Payload[:3], []byte{1, 2, 3}) {
+		t.Errorf("ParseBuffer() = %v", frame)
+	}
+	frame.Release()
+	frame.Release() // A second release is a no-op
+	if frame.Payload != nil {
+		t.Error("Payload still set after Release()")
+	}
+
+	// The buffer is released on error too
+	if _, err := ParseBuffer(common.NewPooledBuffer(HeaderSize - 1)); err == nil {
+		t.Error("ParseBuffer() of a short frame succeeded")
+	}
+	if got := common.PooledBuffersInUse(); got != inUse {
+		t.Errorf("PooledBuffersInUse() = %d, want %d", got, inUse)
+	}
+}
+
 // Benchmark tests
 func BenchmarkParse(b *testing.B) {
 	data := make([]byte, MaxFrameSize)
@@ -286,3 +377,28 @@ func BenchmarkSerialize(b *testing.B) {
 		frame.Serialize()
 	}
 }
+
+func BenchmarkDecode(b *testing.B) {
+	data := make([]byte, MaxFrameSize)
+	copy(data[0:6], common.BroadcastMAC[:])
+	data[12] = 0x08
+	data[13] = 0x00
+
+	b.ReportAllocs()
+	var frame Frame
+	for i := 0; i < b.N; i++ {
+		frame.Decode(data)
+	}
+}
+
+func BenchmarkSerializeTo(b *testing.B) {
+	src := common.MACAddress{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	frame := NewFrame(common.BroadcastMAC, src, common.EtherTypeIPv4, make([]byte, 1000))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := common.NewPooledBuffer(frame.Size())
+		frame.SerializeTo(buf.Bytes())
+		buf.Release()
+	}
+}
@@ -0,0 +1,31 @@
+package ethernet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/internal/fuzzseed"
+)
+
+func FuzzParse(f *testing.F) {
+	fuzzseed.Add(f, fuzzseed.Frames(f))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := Parse(data)
+		if err != nil {
+			return
+		}
+
+		again, err := Parse(frame.Serialize())
+		if err != nil {
+			t.Fatalf("Parse() of serialized frame error = %v", err)
+		}
+		if again.EtherType != frame.EtherType || len(again.VLAN) != len(frame.VLAN) {
+			t.Errorf("reparsed EtherType = %v with %d tags, want %v with %d",
+				again.EtherType, len(again.VLAN), frame.EtherType, len(frame.VLAN))
+		}
+		if !bytes.HasPrefix(again.Payload, frame.Payload) {
+			t.Errorf("reparsed payload = %x, want prefix %x", again.Payload, frame.Payload)
+		}
+	})
+}
@@ -1,11 +1,25 @@
 package ethernet
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"golang.org/x/sys/unix"
+)
+
+// Linux packet socket constants not exported by the syscall package.
+const (
+	packetAuxData         = 8      // PACKET_AUXDATA socket option
+	tpStatusVLANValid     = 1 << 4 // TP_STATUS_VLAN_VALID
+	tpStatusVLANTPIDValid = 1 << 6 // TP_STATUS_VLAN_TPID_VALID
+	sizeofTpacketAuxdata  = 20     // sizeof(struct tpacket_auxdata)
+	soNoFCS               = 43     // SO_NOFCS socket option
 )
 
 // Interface represents a network interface for sending and receiving Ethernet frames.
@@ -14,17 +28,42 @@ type Interface struct {
 	fd         int               // Raw socket file descriptor
 	macAddress common.MACAddress // Hardware address of this interface
 	index      int               // Interface index
+	device     string            // Kernel interface name, the parent's for a VLAN sub-interface
+	vlans      []VLANTag         // Tags of a VLAN sub-interface, outermost first
+	mtu        atomic.Int32      // MTU of the kernel interface
+
+	// FCS handling (both off by default: the NIC adds and strips the FCS)
+	fcsGenerate atomic.Bool   // Append our own FCS on transmit
+	fcsValidate atomic.Bool   // Received frames include an FCS to verify and strip
+	fcsErrors   atomic.Uint64 // Received frames dropped for a bad FCS
+
+	pollTimeout atomic.Int64 // Longest wait of ReadFrames, as a time.Duration
 }
 
 // OpenInterface opens a network interface for raw packet capture and transmission.
 // This requires root/sudo privileges on Linux.
 //
 // The interface parameter is the name of the network interface (e.g., "eth0", "wlan0").
+//
+// If no kernel interface has the given name and it has the form "eth0.100"
+// (802.1Q) or "eth0.10.100" (QinQ), a VLAN sub-interface of the parent is
+// opened instead: tags are inserted on transmit, and only frames carrying
+// matching tags are returned (with those tags stripped) on receive.
 func OpenInterface(ifname string) (*Interface, error) {
 	// Get interface information
 	iface, err := net.InterfaceByName(ifname)
+	var vlans []VLANTag
 	if err != nil {
-		return nil, fmt.Errorf("failed to get interface %s: %w", ifname, err)
+		// Fall back to a VLAN sub-interface of an existing parent
+		parent, tags, ok := parseVLANName(ifname)
+		if !ok {
+			return nil, fmt.Errorf("failed to get interface %s: %w", ifname, err)
+		}
+		iface, err = net.InterfaceByName(parent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get parent interface %s: %w", parent, err)
+		}
+		vlans = tags
 	}
 
 	// Parse MAC address
@@ -54,12 +93,48 @@ func OpenInterface(ifname string) (*Interface, error) {
 		return nil, fmt.Errorf("failed to bind socket to interface: %w", err)
 	}
 
-	return &Interface{
+	// Ask for auxiliary data so tags stripped by VLAN offload can be restored
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_PACKET, packetAuxData, 1); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to enable packet auxdata: %w", err)
+	}
+
+	i := &Interface{
 		name:       ifname,
 		fd:         fd,
 		macAddress: mac,
 		index:      iface.Index,
-	}, nil
+		device:     iface.Name,
+		vlans:      vlans,
+	}
+	i.mtu.Store(int32(iface.MTU))
+	return i, nil
+}
+
+// OpenVLANInterface opens a VLAN sub-interface on top of parent.
+// Tags are given outermost first; use NewServiceVLANTag for the outer tag of
+// a QinQ sub-interface.
+func OpenVLANInterface(parent string, tags ...VLANTag) (*Interface, error) {
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no VLAN tags given")
+	}
+
+	name := parent
+	for _, tag := range tags {
+		if err := tag.Validate(); err != nil {
+			return nil, err
+		}
+		name += fmt.Sprintf(".%d", tag.VID)
+	}
+
+	i, err := OpenInterface(parent)
+	if err != nil {
+		return nil, err
+	}
+	i.name = name
+	i.vlans = append([]VLANTag(nil), tags...)
+
+	return i, nil
 }
 
 // Close closes the network interface.
@@ -85,31 +160,235 @@ func (i *Interface) Index() int {
 	return i.index
 }
 
+// MTU returns the MTU of the interface, read when it was opened or set
+// with SetMTU. A VLAN sub-interface has its parent's.
+func (i *Interface) MTU() int {
+	return int(i.mtu.Load())
+}
+
+// SetMTU changes the MTU of the kernel interface, the parent of a VLAN
+// sub-interface, to as much as JumboMTU or more for jumbo frames if the
+// NIC takes them. Frames up to the new MTU are received whole from then
+// on. Like opening the interface it needs root/sudo privileges.
+func (i *Interface) SetMTU(mtu int) error {
+	if mtu < MinMTU || mtu > math.MaxUint16 {
+		return fmt.Errorf("invalid MTU %d for %s", mtu, i.name)
+	}
+	req, err := unix.NewIfreq(i.device)
+	if err != nil {
+		return fmt.Errorf("failed to set MTU for %s: %w", i.name, err)
+	}
+	req.SetUint32(uint32(mtu))
+	if err := unix.IoctlIfreq(i.fd, unix.SIOCSIFMTU, req); err != nil {
+		return fmt.Errorf("failed to set MTU for %s: %w", i.name, err)
+	}
+	i.mtu.Store(int32(mtu))
+	return nil
+}
+
+// VLANs returns the tags of a VLAN sub-interface (nil for a plain interface).
+func (i *Interface) VLANs() []VLANTag {
+	return i.vlans
+}
+
 // ReadFrame reads an Ethernet frame from the interface.
 // This is a blocking call that waits for incoming packets.
+// On a VLAN sub-interface, frames for other VLANs are skipped.
+// The frame's Payload lives in a pooled buffer; call Frame.Release once it
+// is no longer needed so the buffer can be reused for the next frame.
 func (i *Interface) ReadFrame() (*Frame, error) {
-	// Buffer for receiving packet (max Ethernet frame size)
-	buf := make([]byte, MaxFrameSize)
+	for {
+		frame, err := i.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(i.vlans) == 0 {
+			return frame, nil
+		}
+
+		// Strip our tags, skipping frames that don't carry them
+		if inner, ok := stripVLANs(frame.VLAN, i.vlans); ok {
+			frame.VLAN = inner
+			return frame, nil
+		}
+		frame.Release()
+		counters.rxDropped.Add(1)
+	}
+}
+
+// SetFCSGeneration enables or disables software FCS generation on transmit.
+// When enabled, WriteFrame appends a CRC-32 FCS and asks the kernel (SO_NOFCS)
+// not to let the NIC add its own. Not all drivers support this.
+func (i *Interface) SetFCSGeneration(enable bool) error {
+	value := 0
+	if enable {
+		value = 1
+	}
+	if err := syscall.SetsockoptInt(i.fd, syscall.SOL_SOCKET, soNoFCS, value); err != nil {
+		return fmt.Errorf("failed to set SO_NOFCS: %w", err)
+	}
+	i.fcsGenerate.Store(enable)
+	return nil
+}
+
+// SetFCSValidation enables or disables FCS validation on receive.
+// The NIC must be configured to deliver the FCS (e.g., "ethtool -K eth0 rx-fcs on");
+// frames with a bad FCS are dropped and counted in FCSErrors. VLAN offload
+// may stay on: the FCS covers the tag as sent, so an offloaded tag is put
+// back before it is checked.
+func (i *Interface) SetFCSValidation(enable bool) {
+	i.fcsValidate.Store(enable)
+}
+
+// FCSErrors returns the number of received frames dropped for a bad FCS.
+func (i *Interface) FCSErrors() uint64 {
+	return i.fcsErrors.Load()
+}
+
+// readFrame reads and parses a single frame from the raw socket.
+// Frames with a bad FCS are counted and skipped.
+func (i *Interface) readFrame() (*Frame, error) {
+	for {
+		frame, err := i.readRawFrame()
+		if errors.Is(err, ErrFCSMismatch) {
+			i.fcsErrors.Add(1)
+			counters.fcsErrors.Add(1)
+			continue
+		}
+		return frame, err
+	}
+}
 
-	// Read from socket
-	n, _, err := syscall.Recvfrom(i.fd, buf, 0)
+// readRawFrame reads and parses a single frame from the raw socket.
+func (i *Interface) readRawFrame() (*Frame, error) {
+	// Buffer for receiving packet (max frame size for the MTU, room for VLAN tags)
+	buf := common.NewPooledBuffer(TaggedFrameSize(i.MTU()))
+	oob := common.NewPooledBuffer(syscall.CmsgSpace(sizeofTpacketAuxdata))
+	defer oob.Release()
+
+	// Read from socket, leaving room to put back an offloaded tag
+	data := buf.Bytes()
+	n, oobn, _, _, err := syscall.Recvmsg(i.fd, data[:len(data)-VLANTagSize], oob.Bytes(), 0)
+	counters.rxSyscalls.Add(1)
 	if err != nil {
+		buf.Release()
 		return nil, fmt.Errorf("failed to receive packet: %w", err)
 	}
+	counters.framesReceived.Add(1)
+	counters.bytesReceived.Add(uint64(n))
+	buf.SetLen(putBackVLAN(data, n, oob.Bytes()[:oobn]))
 
 	// Parse the frame
-	frame, err := Parse(buf[:n])
+	var frame *Frame
+	if i.fcsValidate.Load() {
+		frame, err = ParseWithFCS(buf.Bytes())
+	} else {
+		frame, err = Parse(buf.Bytes())
+	}
 	if err != nil {
+		buf.Release()
+		if !errors.Is(err, ErrFCSMismatch) {
+			counters.rxDropped.Add(1)
+		}
 		return nil, fmt.Errorf("failed to parse frame: %w", err)
 	}
+	frame.buf = buf
 
 	return frame, nil
 }
 
+// putBackVLAN puts an outer tag removed by VLAN offload, which the kernel
+// reports in auxiliary data instead, back in front of the EtherType of the
+// frame in buf[:n], and returns the frame's length. buf has room for a tag
+// after the frame. It comes before the FCS check: the FCS covers the tag.
+func putBackVLAN(buf []byte, n int, oob []byte) int {
+	if tag, ok := auxDataVLAN(oob); ok && n >= HeaderSize {
+		copy(buf[12+VLANTagSize:n+VLANTagSize], buf[12:n])
+		putVLANTag(buf[12:], tag)
+		n += VLANTagSize
+	}
+	return n
+}
+
+// auxDataVLAN extracts an offloaded VLAN tag from PACKET_AUXDATA control messages.
+func auxDataVLAN(oob []byte) (VLANTag, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return VLANTag{}, false
+	}
+
+	for _, msg := range msgs {
+		if msg.Header.Level != syscall.SOL_PACKET || msg.Header.Type != packetAuxData {
+			continue
+		}
+		if len(msg.Data) < sizeofTpacketAuxdata {
+			continue
+		}
+
+		// struct tpacket_auxdata is in host byte order
+		status := binary.NativeEndian.Uint32(msg.Data[0:4])
+		if status&tpStatusVLANValid == 0 {
+			continue
+		}
+		tci := binary.NativeEndian.Uint16(msg.Data[16:18])
+		tpid := common.EtherTypeVLAN
+		if status&tpStatusVLANTPIDValid != 0 {
+			tpid = common.EtherType(binary.NativeEndian.Uint16(msg.Data[18:20]))
+		}
+		return parseVLANTag(tpid, tci), true
+	}
+
+	return VLANTag{}, false
+}
+
+// stripVLANs removes the expected outer tags from a frame's tags.
+// It returns the remaining inner tags and whether all expected tags matched,
+// in both TPID and VID.
+func stripVLANs(tags, expected []VLANTag) ([]VLANTag, bool) {
+	if len(tags) < len(expected) {
+		return nil, false
+	}
+	for j, want := range expected {
+		if tags[j].TPID != want.TPID || tags[j].VID != want.VID {
+			return nil, false
+		}
+	}
+
+	inner := tags[len(expected):]
+	if len(inner) == 0 {
+		inner = nil
+	}
+	return inner, true
+}
+
 // WriteFrame sends an Ethernet frame to the interface.
+// On a VLAN sub-interface, the sub-interface's tags are inserted in front of
+// any tags already on the frame.
 func (i *Interface) WriteFrame(frame *Frame) error {
-	// Serialize frame
-	data := frame.Serialize()
+	// Insert sub-interface tags without modifying the caller's frame
+	if len(i.vlans) > 0 {
+		tagged := *frame
+		tagged.VLAN = append(append([]VLANTag(nil), i.vlans...), frame.VLAN...)
+		frame = &tagged
+	}
+
+	// Serialize frame into a pooled buffer
+	fcs := i.fcsGenerate.Load()
+	size := frame.Size()
+	if fcs {
+		size += FCSSize
+	}
+	buf := common.NewPooledBuffer(size)
+	defer buf.Release()
+	data := buf.Bytes()
+	n, err := frame.SerializeTo(data)
+	if err != nil {
+		return fmt.Errorf("failed to serialize frame: %w", err)
+	}
+	if fcs {
+		binary.LittleEndian.PutUint32(data[n:], CalculateFCS(data[:n]))
+	}
 
 	// Send to socket
 	addr := syscall.SockaddrLinklayer{
@@ -119,10 +398,13 @@ func (i *Interface) WriteFrame(frame *Frame) error {
 	}
 	copy(addr.Addr[:], frame.Destination[:])
 
-	err := syscall.Sendto(i.fd, data, 0, &addr)
+	err = syscall.Sendto(i.fd, data, 0, &addr)
 	if err != nil {
+		counters.txErrors.Add(1)
 		return fmt.Errorf("failed to send frame: %w", err)
 	}
+	counters.framesSent.Add(1)
+	counters.bytesSent.Add(uint64(len(data)))
 
 	return nil
 }
@@ -0,0 +1,41 @@
+package ethernet
+
+import "sync/atomic"
+
+// Stats holds frame counters summed over all interfaces.
+type Stats struct {
+	FramesReceived uint64 // Frames read from the socket, including dropped ones
+	FramesSent     uint64 // Frames sent on the socket
+	BytesReceived  uint64 // Bytes of frames read from the socket
+	BytesSent      uint64 // Bytes of frames sent on the socket
+	RxDropped      uint64 // Received frames dropped as malformed or for another VLAN
+	FCSErrors      uint64 // Received frames dropped for a bad FCS
+	TxErrors       uint64 // Frames the socket failed to send
+	RxSyscalls     uint64 // Receive system calls, each of one frame or a batch
+}
+
+// counters are the package-wide counters behind GetStats.
+var counters struct {
+	framesReceived atomic.Uint64
+	framesSent     atomic.Uint64
+	bytesReceived  atomic.Uint64
+	bytesSent      atomic.Uint64
+	rxDropped      atomic.Uint64
+	fcsErrors      atomic.Uint64
+	txErrors       atomic.Uint64
+	rxSyscalls     atomic.Uint64
+}
+
+// GetStats returns a snapshot of the frame counters.
+func GetStats() Stats {
+	return Stats{
+		FramesReceived: counters.framesReceived.Load(),
+		FramesSent:     counters.framesSent.Load(),
+		BytesReceived:  counters.bytesReceived.Load(),
+		BytesSent:      counters.bytesSent.Load(),
+		RxDropped:      counters.rxDropped.Load(),
+		FCSErrors:      counters.fcsErrors.Load(),
+		TxErrors:       counters.txErrors.Load(),
+		RxSyscalls:     counters.rxSyscalls.Load(),
+	}
+}
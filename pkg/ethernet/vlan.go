@@ -0,0 +1,143 @@
+package ethernet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// VLAN tag format (IEEE 802.1Q), inserted between the source MAC and EtherType:
+// +------------+-----+-----+------------+
+// | TPID (16b) | PCP | DEI | VID (12b)  |
+// |            | (3b)| (1b)|            |
+// +------------+-----+-----+------------+
+//
+// QinQ (IEEE 802.1ad) stacks an outer service tag (TPID 0x88A8) in front of
+// the customer tag (TPID 0x8100).
+
+const (
+	// VLANTagSize is the size of a single VLAN tag (4 bytes).
+	VLANTagSize = 4
+
+	// MaxVLANID is the largest usable VLAN identifier (4094; 4095 is reserved).
+	MaxVLANID = 4094
+
+	// MaxTaggedFrameSize is the largest frame we expect to receive, allowing
+	// for two stacked (QinQ) tags (1526 bytes).
+	MaxTaggedFrameSize = MaxFrameSize + 2*VLANTagSize
+
+	// etherTypeQinQLegacy is the pre-standard 802.1ad TPID still used by some vendors.
+	etherTypeQinQLegacy common.EtherType = 0x9100
+)
+
+// TaggedFrameSize returns the largest frame we expect to receive on a link
+// with the given MTU, allowing for two stacked tags and the FCS: at least
+// MaxTaggedFrameSize, and 9026 bytes for JumboMTU.
+func TaggedFrameSize(mtu int) int {
+	return max(HeaderSize+mtu+2*VLANTagSize+FCSSize, MaxTaggedFrameSize)
+}
+
+// VLANTag represents an 802.1Q or 802.1ad VLAN tag.
+type VLANTag struct {
+	TPID     common.EtherType // Tag protocol identifier (0x8100 or 0x88A8)
+	Priority uint8            // Priority code point (0-7)
+	DEI      bool             // Drop eligible indicator
+	VID      uint16           // VLAN identifier (0-4094)
+}
+
+// NewVLANTag creates an 802.1Q (customer) tag with the given VLAN ID.
+func NewVLANTag(vid uint16) VLANTag {
+	return VLANTag{
+		TPID: common.EtherTypeVLAN,
+		VID:  vid,
+	}
+}
+
+// NewServiceVLANTag creates an 802.1ad (service) tag with the given VLAN ID.
+func NewServiceVLANTag(vid uint16) VLANTag {
+	return VLANTag{
+		TPID: common.EtherTypeQinQ,
+		VID:  vid,
+	}
+}
+
+// TCI returns the 16-bit tag control information.
+func (t VLANTag) TCI() uint16 {
+	tci := uint16(t.Priority&0x07)<<13 | t.VID&0x0FFF
+	if t.DEI {
+		tci |= 0x1000
+	}
+	return tci
+}
+
+// Validate checks that the tag fields are within range.
+func (t VLANTag) Validate() error {
+	if !isVLANTPID(t.TPID) {
+		return fmt.Errorf("invalid VLAN TPID: 0x%04x", uint16(t.TPID))
+	}
+	if t.Priority > 7 {
+		return fmt.Errorf("invalid VLAN priority: %d", t.Priority)
+	}
+	if t.VID > MaxVLANID {
+		return fmt.Errorf("invalid VLAN ID: %d", t.VID)
+	}
+	return nil
+}
+
+// String returns a human-readable representation of the tag.
+func (t VLANTag) String() string {
+	return fmt.Sprintf("VLAN{TPID=%s, VID=%d, PCP=%d, DEI=%t}", t.TPID, t.VID, t.Priority, t.DEI)
+}
+
+// parseVLANTag decodes a tag from its TPID and TCI.
+func parseVLANTag(tpid common.EtherType, tci uint16) VLANTag {
+	return VLANTag{
+		TPID:     tpid,
+		Priority: uint8(tci >> 13),
+		DEI:      tci&0x1000 != 0,
+		VID:      tci & 0x0FFF,
+	}
+}
+
+// putVLANTag encodes a tag into 4 bytes.
+func putVLANTag(b []byte, t VLANTag) {
+	tpid := t.TPID
+	if tpid == 0 {
+		tpid = common.EtherTypeVLAN
+	}
+	binary.BigEndian.PutUint16(b[0:2], uint16(tpid))
+	binary.BigEndian.PutUint16(b[2:4], t.TCI())
+}
+
+// isVLANTPID returns true if the EtherType introduces a VLAN tag.
+func isVLANTPID(et common.EtherType) bool {
+	return et == common.EtherTypeVLAN || et == common.EtherTypeQinQ || et == etherTypeQinQLegacy
+}
+
+// parseVLANName splits a sub-interface name such as "eth0.100" (802.1Q) or
+// "eth0.10.100" (QinQ: service VLAN 10, customer VLAN 100) into the parent
+// interface name and its tags, outermost first. ok is false if the name has
+// no VLAN suffix.
+func parseVLANName(name string) (parent string, tags []VLANTag, ok bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return "", nil, false
+	}
+
+	vids := make([]uint16, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		vid, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || vid == 0 || vid > MaxVLANID {
+			return "", nil, false
+		}
+		vids = append(vids, uint16(vid))
+	}
+
+	if len(vids) == 1 {
+		return parts[0], []VLANTag{NewVLANTag(vids[0])}, true
+	}
+	return parts[0], []VLANTag{NewServiceVLANTag(vids[0]), NewVLANTag(vids[1])}, true
+}
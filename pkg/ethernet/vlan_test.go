@@ -0,0 +1,283 @@
+package ethernet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestParseVLANTagged(t *testing.T) {
+	data := []byte{
+		// Destination MAC
+		0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+		// Source MAC
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55,
+		// 802.1Q tag: PCP=5, DEI=1, VID=100
+		0x81, 0x00, 0xB0, 0x64,
+		// EtherType - IPv4
+		0x08, 0x00,
+		// Payload
+		0x45, 0x00,
+	}
+
+	frame, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if frame.EtherType != common.EtherTypeIPv4 {
+		t.Errorf("EtherType = %v, want %v", frame.EtherType, common.EtherTypeIPv4)
+	}
+	if len(frame.VLAN) != 1 {
+		t.Fatalf("len(VLAN) = %d, want 1", len(frame.VLAN))
+	}
+
+	want := VLANTag{TPID: common.EtherTypeVLAN, Priority: 5, DEI: true, VID: 100}
+	if frame.VLAN[0] != want {
+		t.Errorf("VLAN[0] = %v, want %v", frame.VLAN[0], want)
+	}
+	if !bytes.Equal(frame.Payload, []byte{0x45, 0x00}) {
+		t.Errorf("Payload = %x, want 4500", frame.Payload)
+	}
+	if frame.VLANID() != 100 {
+		t.Errorf("VLANID() = %d, want 100", frame.VLANID())
+	}
+}
+
+func TestParseQinQ(t *testing.T) {
+	data := []byte{
+		0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55,
+		// 802.1ad service tag: VID=10
+		0x88, 0xA8, 0x00, 0x0A,
+		// 802.1Q customer tag: VID=200
+		0x81, 0x00, 0x00, 0xC8,
+		// EtherType - ARP
+		0x08, 0x06,
+	}
+
+	frame, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if frame.EtherType != common.EtherTypeARP {
+		t.Errorf("EtherType = %v, want %v", frame.EtherType, common.EtherTypeARP)
+	}
+	if len(frame.VLAN) != 2 {
+		t.Fatalf("len(VLAN) = %d, want 2", len(frame.VLAN))
+	}
+	if frame.VLAN[0].TPID != common.EtherTypeQinQ || frame.VLAN[0].VID != 10 {
+		t.Errorf("outer tag = %v, want 802.1ad VID 10", frame.VLAN[0])
+	}
+	if frame.VLAN[1].TPID != common.EtherTypeVLAN || frame.VLAN[1].VID != 200 {
+		t.Errorf("inner tag = %v, want 802.1Q VID 200", frame.VLAN[1])
+	}
+	if frame.VLANID() != 200 {
+		t.Errorf("VLANID() = %d, want 200", frame.VLANID())
+	}
+}
+
+func TestParseTruncatedVLAN(t *testing.T) {
+	data := []byte{
+		0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55,
+		0x81, 0x00, 0x00,
+	}
+
+	if _, err := Parse(data); err == nil {
+		t.Error("Parse() should return error for truncated VLAN tag")
+	}
+}
+
+func TestSerializeVLANRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xAB}, 100)
+	frame := NewFrame(common.BroadcastMAC, common.MACAddress{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		common.EtherTypeIPv6, payload)
+	frame.VLAN = []VLANTag{
+		NewServiceVLANTag(10),
+		{TPID: common.EtherTypeVLAN, Priority: 3, VID: 4094},
+	}
+
+	data := frame.Serialize()
+	if len(data) != HeaderSize+2*VLANTagSize+len(payload) {
+		t.Errorf("Serialize() length = %d, want %d", len(data), HeaderSize+2*VLANTagSize+len(payload))
+	}
+	if got := binary.BigEndian.Uint16(data[12:14]); got != uint16(common.EtherTypeQinQ) {
+		t.Errorf("outer TPID = 0x%04x, want 0x88a8", got)
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(parsed.VLAN) != 2 || parsed.VLAN[0] != frame.VLAN[0] || parsed.VLAN[1] != frame.VLAN[1] {
+		t.Errorf("VLAN = %v, want %v", parsed.VLAN, frame.VLAN)
+	}
+	if parsed.EtherType != common.EtherTypeIPv6 {
+		t.Errorf("EtherType = %v, want %v", parsed.EtherType, common.EtherTypeIPv6)
+	}
+	if !bytes.Equal(parsed.Payload, payload) {
+		t.Error("Payload mismatch")
+	}
+}
+
+func TestSerializeVLANPadding(t *testing.T) {
+	frame := NewFrame(common.BroadcastMAC, common.MACAddress{}, common.EtherTypeARP, []byte{1, 2, 3})
+	frame.VLAN = []VLANTag{NewVLANTag(5)}
+
+	// Tags count towards the 60-byte minimum
+	if frame.Size() != HeaderSize+MinPayloadSize {
+		t.Errorf("Size() = %d, want %d", frame.Size(), HeaderSize+MinPayloadSize)
+	}
+	if len(frame.Serialize()) != frame.Size() {
+		t.Errorf("len(Serialize()) = %d, want %d", len(frame.Serialize()), frame.Size())
+	}
+	if frame.HeaderLen() != HeaderSize+VLANTagSize {
+		t.Errorf("HeaderLen() = %d, want %d", frame.HeaderLen(), HeaderSize+VLANTagSize)
+	}
+}
+
+func TestVLANTagValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tag     VLANTag
+		wantErr bool
+	}{
+		{"valid 802.1Q", NewVLANTag(100), false},
+		{"valid 802.1ad", NewServiceVLANTag(4094), false},
+		{"VID too large", NewVLANTag(4095), true},
+		{"priority too large", VLANTag{TPID: common.EtherTypeVLAN, Priority: 8}, true},
+		{"bad TPID", VLANTag{TPID: common.EtherTypeIPv4, VID: 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tag.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseVLANName(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantParent string
+		wantVIDs   []uint16
+		wantOK     bool
+	}{
+		{"eth0.100", "eth0", []uint16{100}, true},
+		{"eth0.10.200", "eth0", []uint16{10, 200}, true},
+		{"eth0", "", nil, false},
+		{"eth0.0", "", nil, false},
+		{"eth0.4095", "", nil, false},
+		{"eth0.abc", "", nil, false},
+		{".100", "", nil, false},
+		{"eth0.1.2.3", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent, tags, ok := parseVLANName(tt.name)
+			if ok != tt.wantOK {
+				t.Fatalf("parseVLANName(%q) ok = %v, want %v", tt.name, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if parent != tt.wantParent {
+				t.Errorf("parent = %q, want %q", parent, tt.wantParent)
+			}
+			if len(tags) != len(tt.wantVIDs) {
+				t.Fatalf("len(tags) = %d, want %d", len(tags), len(tt.wantVIDs))
+			}
+			for i, vid := range tt.wantVIDs {
+				if tags[i].VID != vid {
+					t.Errorf("tags[%d].VID = %d, want %d", i, tags[i].VID, vid)
+				}
+			}
+			if len(tags) == 2 && tags[0].TPID != common.EtherTypeQinQ {
+				t.Errorf("outer TPID = %v, want %v", tags[0].TPID, common.EtherTypeQinQ)
+			}
+		})
+	}
+}
+
+func TestStripVLANs(t *testing.T) {
+	expected := []VLANTag{NewServiceVLANTag(10)}
+
+	inner, ok := stripVLANs([]VLANTag{NewServiceVLANTag(10), NewVLANTag(20)}, expected)
+	if !ok || len(inner) != 1 || inner[0].VID != 20 {
+		t.Errorf("stripVLANs() = %v, %v, want [VID 20], true", inner, ok)
+	}
+
+	inner, ok = stripVLANs([]VLANTag{NewServiceVLANTag(10)}, expected)
+	if !ok || inner != nil {
+		t.Errorf("stripVLANs() = %v, %v, want nil, true", inner, ok)
+	}
+
+	if _, ok := stripVLANs([]VLANTag{NewServiceVLANTag(11)}, expected); ok {
+		t.Error("stripVLANs() should not match a different VID")
+	}
+	qinq := []VLANTag{NewServiceVLANTag(10), NewVLANTag(20)}
+	if _, ok := stripVLANs([]VLANTag{NewVLANTag(10), NewVLANTag(20)}, qinq); ok {
+		t.Error("stripVLANs() should not match a different outer TPID")
+	}
+	if _, ok := stripVLANs(nil, expected); ok {
+		t.Error("stripVLANs() should not match an untagged frame")
+	}
+}
+
+func TestAuxDataVLAN(t *testing.T) {
+	// Build a SOL_PACKET/PACKET_AUXDATA control message by hand
+	oob := make([]byte, syscall.CmsgSpace(sizeofTpacketAuxdata))
+	hdr := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	hdr.Level = syscall.SOL_PACKET
+	hdr.Type = packetAuxData
+	hdr.SetLen(syscall.CmsgLen(sizeofTpacketAuxdata))
+
+	data := oob[syscall.CmsgLen(0):]
+	binary.NativeEndian.PutUint32(data[0:4], tpStatusVLANValid|tpStatusVLANTPIDValid)
+	binary.NativeEndian.PutUint16(data[16:18], 0x6064) // PCP=3, VID=100
+	binary.NativeEndian.PutUint16(data[18:20], uint16(common.EtherTypeQinQ))
+
+	tag, ok := auxDataVLAN(oob)
+	if !ok {
+		t.Fatal("auxDataVLAN() found no tag")
+	}
+	want := VLANTag{TPID: common.EtherTypeQinQ, Priority: 3, VID: 100}
+	if tag != want {
+		t.Errorf("auxDataVLAN() = %v, want %v", tag, want)
+	}
+
+	// Without the valid flag no tag is reported
+	binary.NativeEndian.PutUint32(data[0:4], 0)
+	if _, ok := auxDataVLAN(oob); ok {
+		t.Error("auxDataVLAN() should ignore auxdata without TP_STATUS_VLAN_VALID")
+	}
+}
+
+func TestTaggedFrameSize(t *testing.T) {
+	tests := []struct {
+		mtu  int
+		want int
+	}{
+		{MinMTU, MaxTaggedFrameSize},
+		{MaxPayloadSize, MaxTaggedFrameSize},
+		{JumboMTU, 9026},
+	}
+	for _, tt := range tests {
+		if got := TaggedFrameSize(tt.mtu); got != tt.want {
+			t.Errorf("TaggedFrameSize(%d) = %d, want %d", tt.mtu, got, tt.want)
+		}
+	}
+	if size := TaggedFrameSize(JumboMTU); size > common.JumboBufferSize {
+		t.Errorf("TaggedFrameSize(JumboMTU) = %d, more than JumboBufferSize %d", size, common.JumboBufferSize)
+	}
+}
@@ -0,0 +1,721 @@
+package ethernet
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"golang.org/x/sys/unix"
+)
+
+// AF_XDP defaults.
+const (
+	DefaultXDPFrameCount = 4096 // UMEM frames, half for receive and half for transmit
+	DefaultXDPFrameSize  = 2048 // Bytes per UMEM frame
+	DefaultXDPRingSize   = 2048 // Descriptors per ring
+)
+
+// XDPMode selects whether an AF_XDP socket copies frames between the NIC
+// and the UMEM or has the NIC DMA them directly.
+type XDPMode int
+
+const (
+	XDPModeAuto     XDPMode = iota // Zero-copy if the driver supports it, copy otherwise
+	XDPModeCopy                    // Always copy
+	XDPModeZeroCopy                // Zero-copy, failing if the driver cannot
+)
+
+// String returns the mode's name.
+func (m XDPMode) String() string {
+	switch m {
+	case XDPModeAuto:
+		return "auto"
+	case XDPModeCopy:
+		return "copy"
+	case XDPModeZeroCopy:
+		return "zero-copy"
+	default:
+		return fmt.Sprintf("XDPMode(%d)", int(m))
+	}
+}
+
+// XDPConfig configures an XDPInterface.
+type XDPConfig struct {
+	// Queue is the NIC receive queue the socket is bound to. Frames the
+	// NIC steers to other queues go to the kernel stack as usual.
+	Queue int
+
+	FrameCount int // UMEM frames; 0 means DefaultXDPFrameCount
+	FrameSize  int // Bytes per UMEM frame, 2048 or 4096; 0 means DefaultXDPFrameSize
+	RingSize   int // Descriptors per ring, a power of two; 0 means DefaultXDPRingSize
+
+	Mode XDPMode
+
+	// Generic attaches the XDP program in generic (SKB) mode, for drivers
+	// without native XDP support. It rules out zero-copy.
+	Generic bool
+}
+
+// XDPStats holds the kernel's counters for an AF_XDP socket.
+type XDPStats struct {
+	RxDropped       uint64 // Frames dropped for lack of a fill ring frame or other reasons
+	RxInvalid       uint64 // Invalid fill ring descriptors
+	TxInvalid       uint64 // Invalid transmit descriptors
+	RxRingFull      uint64 // Frames dropped because the receive ring was full
+	FillRingEmpty   uint64 // Times the fill ring was found empty
+	TxRingEmptyRead uint64 // Times the transmit ring was found empty
+}
+
+// XDPInterface sends and receives frames on one queue of a network
+// interface with an AF_XDP socket, bypassing the kernel network stack.
+//
+// Frames live in a UMEM, a region of memory shared with the kernel and
+// split into fixed-size frames. The fill ring hands the kernel UMEM frames
+// to receive into, and received frames come back on the receive ring;
+// frames to send go out on the transmit ring and come back on the
+// completion ring once sent. An XDP program attached to the interface
+// redirects the queue's frames to the socket.
+//
+// XDPInterface is a Device, BatchWriter and BatchReader like Interface, but
+// has no VLAN sub-interfaces or software FCS. It needs root, and one
+// XDPInterface per interface, as it attaches its own XDP program.
+type XDPInterface struct {
+	name       string
+	index      int
+	macAddress common.MACAddress
+	queue      int
+	fd         int // AF_XDP socket
+	zeroCopy   bool
+
+	umem      []byte
+	frameSize int
+	ringSize  uint32
+
+	rxMu sync.Mutex // Guards the receive and fill rings
+	rx   xdpRing
+	fill xdpRing
+
+	txMu sync.Mutex // Guards the transmit and completion rings and free
+	tx   xdpRing
+	comp xdpRing
+	free []uint64 // UMEM frames not in use for transmit
+
+	// The XDP program, its map of sockets, and the link attaching it
+	mapFD, progFD, linkFD int
+
+	pollTimeout atomic.Int64 // Longest wait of ReadFrames, as a time.Duration
+	closeOnce   sync.Once
+}
+
+// Compile-time checks that XDPInterface is a batching Device.
+var (
+	_ Device      = (*XDPInterface)(nil)
+	_ BatchWriter = (*XDPInterface)(nil)
+	_ BatchReader = (*XDPInterface)(nil)
+)
+
+// xdpRing is one of the four rings of an AF_XDP socket, mapped from the
+// kernel. Each ring has a single producer and a single consumer: the fill
+// and transmit rings are produced by us, the receive and completion rings
+// by the kernel.
+type xdpRing struct {
+	mem      []byte
+	producer *uint32
+	consumer *uint32
+	flags    *uint32
+	descs    unsafe.Pointer // uint64 addresses, or unix.XDPDesc for rx and tx
+	mask     uint32
+}
+
+// addr returns the i'th entry of a fill or completion ring.
+func (r *xdpRing) addr(i uint32) *uint64 {
+	return (*uint64)(unsafe.Add(r.descs, uintptr(i&r.mask)*8))
+}
+
+// desc returns the i'th entry of a receive or transmit ring.
+func (r *xdpRing) desc(i uint32) *unix.XDPDesc {
+	return (*unix.XDPDesc)(unsafe.Add(r.descs, uintptr(i&r.mask)*unsafe.Sizeof(unix.XDPDesc{})))
+}
+
+// needsWakeup reports whether the kernel must be kicked with a system call
+// to process the ring.
+func (r *xdpRing) needsWakeup() bool {
+	return atomic.LoadUint32(r.flags)&unix.XDP_RING_NEED_WAKEUP != 0
+}
+
+// OpenXDPInterface opens an AF_XDP socket on one queue of a network
+// interface, and attaches an XDP program redirecting that queue's frames
+// to it.
+func OpenXDPInterface(ifname string, config XDPConfig) (*XDPInterface, error) {
+	if config.FrameCount == 0 {
+		config.FrameCount = DefaultXDPFrameCount
+	}
+	if config.FrameSize == 0 {
+		config.FrameSize = DefaultXDPFrameSize
+	}
+	if config.RingSize == 0 {
+		config.RingSize = DefaultXDPRingSize
+	}
+	if config.FrameSize != 2048 && config.FrameSize != 4096 {
+		return nil, fmt.Errorf("invalid XDP frame size %d: must be 2048 or 4096", config.FrameSize)
+	}
+	if config.RingSize <= 0 || config.RingSize&(config.RingSize-1) != 0 {
+		return nil, fmt.Errorf("invalid XDP ring size %d: must be a power of two", config.RingSize)
+	}
+	if config.FrameCount < 2 {
+		return nil, fmt.Errorf("invalid XDP frame count %d", config.FrameCount)
+	}
+	if config.Queue < 0 {
+		return nil, fmt.Errorf("invalid XDP queue %d", config.Queue)
+	}
+	if config.Generic && config.Mode == XDPModeZeroCopy {
+		return nil, fmt.Errorf("zero-copy is not possible in generic XDP mode")
+	}
+
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface %s: %w", ifname, err)
+	}
+
+	fd, err := unix.Socket(unix.AF_XDP, unix.SOCK_RAW, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AF_XDP socket: %w", err)
+	}
+	x := &XDPInterface{
+		name:      ifname,
+		index:     iface.Index,
+		queue:     config.Queue,
+		fd:        fd,
+		frameSize: config.FrameSize,
+		ringSize:  uint32(config.RingSize),
+		mapFD:     -1,
+		progFD:    -1,
+		linkFD:    -1,
+	}
+	copy(x.macAddress[:], iface.HardwareAddr)
+
+	if err := x.setup(config); err != nil {
+		x.Close()
+		return nil, err
+	}
+	return x, nil
+}
+
+// setup registers the UMEM, maps the rings, binds the socket and attaches
+// the XDP program.
+func (x *XDPInterface) setup(config XDPConfig) error {
+	var err error
+	x.umem, err = unix.Mmap(-1, 0, config.FrameCount*config.FrameSize,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("failed to allocate UMEM: %w", err)
+	}
+	reg := unix.XDPUmemReg{
+		Addr: uint64(uintptr(unsafe.Pointer(&x.umem[0]))),
+		Len:  uint64(len(x.umem)),
+		Size: uint32(config.FrameSize),
+	}
+	if err := setsockoptXDP(x.fd, unix.XDP_UMEM_REG, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return fmt.Errorf("failed to register UMEM: %w", err)
+	}
+
+	for _, opt := range []int{unix.XDP_UMEM_FILL_RING, unix.XDP_UMEM_COMPLETION_RING, unix.XDP_RX_RING, unix.XDP_TX_RING} {
+		if err := unix.SetsockoptInt(x.fd, unix.SOL_XDP, opt, config.RingSize); err != nil {
+			return fmt.Errorf("failed to size XDP ring %d: %w", opt, err)
+		}
+	}
+	var off unix.XDPMmapOffsets
+	if err := getsockoptXDP(x.fd, unix.XDP_MMAP_OFFSETS, unsafe.Pointer(&off), unsafe.Sizeof(off)); err != nil {
+		return fmt.Errorf("failed to get XDP ring offsets: %w", err)
+	}
+	rings := []struct {
+		ring     *xdpRing
+		off      unix.XDPRingOffset
+		pgoff    int64
+		descSize uintptr
+	}{
+		{&x.fill, off.Fr, unix.XDP_UMEM_PGOFF_FILL_RING, 8},
+		{&x.comp, off.Cr, unix.XDP_UMEM_PGOFF_COMPLETION_RING, 8},
+		{&x.rx, off.Rx, unix.XDP_PGOFF_RX_RING, unsafe.Sizeof(unix.XDPDesc{})},
+		{&x.tx, off.Tx, unix.XDP_PGOFF_TX_RING, unsafe.Sizeof(unix.XDPDesc{})},
+	}
+	for _, r := range rings {
+		if err := x.mapRing(r.ring, r.off, r.pgoff, r.descSize); err != nil {
+			return err
+		}
+	}
+
+	// Half the frames are handed to the kernel to receive into, the rest
+	// are kept for transmit
+	receive := min(config.FrameCount/2, config.RingSize)
+	for k := 0; k < receive; k++ {
+		*x.fill.addr(uint32(k)) = uint64(k * x.frameSize)
+	}
+	atomic.StoreUint32(x.fill.producer, uint32(receive))
+	for k := receive; k < config.FrameCount; k++ {
+		x.free = append(x.free, uint64(k*x.frameSize))
+	}
+
+	if err := x.bind(config.Mode); err != nil {
+		return err
+	}
+	return x.attach(config.Generic)
+}
+
+// mapRing maps one of the socket's rings.
+func (x *XDPInterface) mapRing(r *xdpRing, off unix.XDPRingOffset, pgoff int64, descSize uintptr) error {
+	mem, err := unix.Mmap(x.fd, pgoff, int(off.Desc)+int(x.ringSize)*int(descSize),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("failed to map XDP ring: %w", err)
+	}
+	base := unsafe.Pointer(&mem[0])
+	*r = xdpRing{
+		mem:      mem,
+		producer: (*uint32)(unsafe.Add(base, off.Producer)),
+		consumer: (*uint32)(unsafe.Add(base, off.Consumer)),
+		flags:    (*uint32)(unsafe.Add(base, off.Flags)),
+		descs:    unsafe.Add(base, off.Desc),
+		mask:     x.ringSize - 1,
+	}
+	return nil
+}
+
+// bind binds the socket to the queue, in zero-copy mode if possible.
+func (x *XDPInterface) bind(mode XDPMode) error {
+	addr := &unix.SockaddrXDP{Ifindex: uint32(x.index), QueueID: uint32(x.queue)}
+	if mode != XDPModeCopy {
+		addr.Flags = unix.XDP_USE_NEED_WAKEUP | unix.XDP_ZEROCOPY
+		err := x.bindQueue(addr)
+		if err == nil {
+			x.zeroCopy = true
+			return nil
+		}
+		if mode == XDPModeZeroCopy {
+			return fmt.Errorf("failed to bind AF_XDP socket to %s queue %d in zero-copy mode: %w", x.name, x.queue, err)
+		}
+	}
+	addr.Flags = unix.XDP_USE_NEED_WAKEUP | unix.XDP_COPY
+	if err := x.bindQueue(addr); err != nil {
+		return fmt.Errorf("failed to bind AF_XDP socket to %s queue %d: %w", x.name, x.queue, err)
+	}
+	return nil
+}
+
+// xdpBindRetries is how many times a bind to a busy queue is retried: the
+// kernel releases the queue of a closed socket asynchronously.
+const xdpBindRetries = 20
+
+// bindQueue binds the socket, waiting for the queue to be released by a
+// socket closed just before.
+func (x *XDPInterface) bindQueue(addr *unix.SockaddrXDP) error {
+	for try := 0; ; try++ {
+		err := unix.Bind(x.fd, addr)
+		if err != unix.EBUSY || try == xdpBindRetries {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// bpf(2) attributes, the leading fields of union bpf_attr for each command.
+type (
+	bpfMapCreateAttr struct {
+		mapType    uint32
+		keySize    uint32
+		valueSize  uint32
+		maxEntries uint32
+	}
+	bpfMapUpdateAttr struct {
+		mapFD uint32
+		_     uint32
+		key   uint64
+		value uint64
+		flags uint64
+	}
+	bpfProgLoadAttr struct {
+		progType uint32
+		insnCnt  uint32
+		insns    uint64
+		license  uint64
+	}
+	bpfLinkCreateAttr struct {
+		progFD     uint32
+		ifindex    uint32
+		attachType uint32
+		flags      uint32
+	}
+)
+
+// bpfInsn is struct bpf_insn, one eBPF instruction.
+type bpfInsn struct {
+	code byte
+	regs byte // Destination register in the low nibble, source in the high
+	off  int16
+	imm  int32
+}
+
+// xdpProgram returns the XDP program redirecting frames to the socket in
+// the map at their receive queue's index:
+//
+//	return bpf_redirect_map(&xsks, ctx->rx_queue_index, XDP_PASS);
+//
+// Frames of queues without a socket fall back to XDP_PASS, the kernel
+// stack.
+func xdpProgram(mapFD int) []bpfInsn {
+	const (
+		ldxw        = 0x61 // BPF_LDX | BPF_MEM | BPF_W
+		ldImm64     = 0x18 // BPF_LD | BPF_DW | BPF_IMM
+		mov64Imm    = 0xb7 // BPF_ALU64 | BPF_MOV | BPF_K
+		call        = 0x85 // BPF_JMP | BPF_CALL
+		exit        = 0x95 // BPF_JMP | BPF_EXIT
+		pseudoMapFD = 1    // BPF_PSEUDO_MAP_FD
+		redirectMap = 51   // BPF_FUNC_redirect_map
+		xdpPass     = 2    // XDP_PASS
+		rxQueue     = 16   // offsetof(struct xdp_md, rx_queue_index)
+	)
+	return []bpfInsn{
+		{code: ldxw, regs: 2 | 1<<4, off: rxQueue},                   // r2 = ctx->rx_queue_index
+		{code: ldImm64, regs: 1 | pseudoMapFD<<4, imm: int32(mapFD)}, // r1 = &xsks
+		{},
+		{code: mov64Imm, regs: 3, imm: xdpPass}, // r3 = XDP_PASS
+		{code: call, imm: redirectMap},
+		{code: exit},
+	}
+}
+
+// attach loads the XDP program and its socket map, adds the socket and
+// attaches the program to the interface. Closing the link detaches it.
+func (x *XDPInterface) attach(generic bool) error {
+	var err error
+	create := bpfMapCreateAttr{mapType: unix.BPF_MAP_TYPE_XSKMAP, keySize: 4, valueSize: 4, maxEntries: uint32(x.queue + 1)}
+	if x.mapFD, err = bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&create), unsafe.Sizeof(create)); err != nil {
+		return fmt.Errorf("failed to create XSK map: %w", err)
+	}
+	key, value := uint32(x.queue), uint32(x.fd)
+	update := bpfMapUpdateAttr{
+		mapFD: uint32(x.mapFD),
+		key:   uint64(uintptr(unsafe.Pointer(&key))),
+		value: uint64(uintptr(unsafe.Pointer(&value))),
+	}
+	_, err = bpf(unix.BPF_MAP_UPDATE_ELEM, unsafe.Pointer(&update), unsafe.Sizeof(update))
+	runtime.KeepAlive(&key)
+	runtime.KeepAlive(&value)
+	if err != nil {
+		return fmt.Errorf("failed to add socket to XSK map: %w", err)
+	}
+
+	insns := xdpProgram(x.mapFD)
+	license := []byte("GPL\x00")
+	load := bpfProgLoadAttr{
+		progType: unix.BPF_PROG_TYPE_XDP,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	x.progFD, err = bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&load), unsafe.Sizeof(load))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return fmt.Errorf("failed to load XDP program: %w", err)
+	}
+
+	link := bpfLinkCreateAttr{progFD: uint32(x.progFD), ifindex: uint32(x.index), attachType: unix.BPF_XDP}
+	if generic {
+		link.flags = unix.XDP_FLAGS_SKB_MODE
+	}
+	if x.linkFD, err = bpf(unix.BPF_LINK_CREATE, unsafe.Pointer(&link), unsafe.Sizeof(link)); err != nil {
+		return fmt.Errorf("failed to attach XDP program to %s: %w", x.name, err)
+	}
+	return nil
+}
+
+// Close detaches the XDP program and closes the socket.
+func (x *XDPInterface) Close() error {
+	var err error
+	x.closeOnce.Do(func() {
+		for _, fd := range []int{x.linkFD, x.progFD, x.mapFD} {
+			if fd >= 0 {
+				unix.Close(fd)
+			}
+		}
+		err = unix.Close(x.fd)
+		for _, r := range []*xdpRing{&x.fill, &x.comp, &x.rx, &x.tx} {
+			if r.mem != nil {
+				unix.Munmap(r.mem)
+			}
+		}
+		if x.umem != nil {
+			unix.Munmap(x.umem)
+		}
+	})
+	return err
+}
+
+// Name returns the interface name.
+func (x *XDPInterface) Name() string {
+	return x.name
+}
+
+// MACAddress returns the MAC address of the interface.
+func (x *XDPInterface) MACAddress() common.MACAddress {
+	return x.macAddress
+}
+
+// Index returns the interface index.
+func (x *XDPInterface) Index() int {
+	return x.index
+}
+
+// Queue returns the queue the socket is bound to.
+func (x *XDPInterface) Queue() int {
+	return x.queue
+}
+
+// ZeroCopy returns true if the socket is bound in zero-copy mode.
+func (x *XDPInterface) ZeroCopy() bool {
+	return x.zeroCopy
+}
+
+// XDPStats returns the kernel's counters for the socket.
+func (x *XDPInterface) XDPStats() (XDPStats, error) {
+	var s unix.XDPStatistics
+	if err := getsockoptXDP(x.fd, unix.XDP_STATISTICS, unsafe.Pointer(&s), unsafe.Sizeof(s)); err != nil {
+		return XDPStats{}, fmt.Errorf("failed to get XDP statistics: %w", err)
+	}
+	return XDPStats{
+		RxDropped:       s.Rx_dropped,
+		RxInvalid:       s.Rx_invalid_descs,
+		TxInvalid:       s.Tx_invalid_descs,
+		RxRingFull:      s.Rx_ring_full,
+		FillRingEmpty:   s.Rx_fill_ring_empty_descs,
+		TxRingEmptyRead: s.Tx_ring_empty_descs,
+	}, nil
+}
+
+// SetPollTimeout sets how long ReadFrames waits for a frame to arrive
+// before returning none. Zero, the default, waits indefinitely.
+func (x *XDPInterface) SetPollTimeout(d time.Duration) {
+	x.pollTimeout.Store(int64(d))
+}
+
+// ReadFrame reads an Ethernet frame, waiting for one to arrive. The frame's
+// Payload lives in a pooled buffer, as with Interface.ReadFrame.
+func (x *XDPInterface) ReadFrame() (*Frame, error) {
+	var frames [1]*Frame
+	for {
+		n, err := ReadFrames(x, frames[:])
+		if err != nil {
+			return nil, err
+		}
+		if n == 1 {
+			return frames[0], nil
+		}
+	}
+}
+
+// ReadFrames copies up to len(frames) received frames out of the UMEM,
+// as Interface.ReadFrames does: frames[:n] are resliced to the frames read.
+func (x *XDPInterface) ReadFrames(frames [][]byte) (int, error) {
+	lens := make([]int, min(len(frames), MaxBatchSize))
+	got, err := x.readBatch(frames, lens)
+	return compactFrames(frames, lens, got), err
+}
+
+// readBatch copies up to len(lens) frames from the receive ring into bufs,
+// waiting up to the poll timeout for the first, and gives their UMEM
+// frames back to the kernel on the fill ring.
+func (x *XDPInterface) readBatch(bufs [][]byte, lens []int) (int, error) {
+	if len(lens) == 0 {
+		return 0, nil
+	}
+	x.rxMu.Lock()
+	defer x.rxMu.Unlock()
+
+	cons := atomic.LoadUint32(x.rx.consumer)
+	avail := atomic.LoadUint32(x.rx.producer) - cons
+	if avail == 0 {
+		// Polling also wakes the driver to refill from the fill ring
+		ready, err := pollSocket(x.fd, unix.POLLIN, time.Duration(x.pollTimeout.Load()))
+		counters.rxSyscalls.Add(1)
+		if err != nil || !ready {
+			return 0, err
+		}
+		if avail = atomic.LoadUint32(x.rx.producer) - cons; avail == 0 {
+			return 0, nil
+		}
+	}
+
+	n := min(int(avail), len(lens))
+	fill := atomic.LoadUint32(x.fill.producer)
+	for k := 0; k < n; k++ {
+		desc := x.rx.desc(cons + uint32(k))
+		data := x.umem[desc.Addr : desc.Addr+uint64(desc.Len)]
+		counters.framesReceived.Add(1)
+		counters.bytesReceived.Add(uint64(len(data)))
+		if buf := bufs[k][:cap(bufs[k])]; len(data) >= HeaderSize && len(data) <= len(buf) {
+			lens[k] = copy(buf, data)
+		} else {
+			counters.rxDropped.Add(1)
+			lens[k] = -1
+		}
+		// The address may be past headroom; the frame starts at a multiple
+		// of the frame size
+		*x.fill.addr(fill + uint32(k)) = desc.Addr &^ uint64(x.frameSize-1)
+	}
+	atomic.StoreUint32(x.rx.consumer, cons+uint32(n))
+	atomic.StoreUint32(x.fill.producer, fill+uint32(n))
+	return n, nil
+}
+
+// WriteFrame sends an Ethernet frame.
+func (x *XDPInterface) WriteFrame(frame *Frame) error {
+	_, err := x.WriteFrames([]*Frame{frame})
+	return err
+}
+
+// WriteFrames serializes frames into UMEM frames and puts them on the
+// transmit ring, kicking the kernel once per batch. It waits for ring
+// space while earlier frames are being sent.
+func (x *XDPInterface) WriteFrames(frames []*Frame) (int, error) {
+	x.txMu.Lock()
+	defer x.txMu.Unlock()
+
+	sent := 0
+	for len(frames) > 0 {
+		n, err := x.writeBatch(frames)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+		if n == 0 {
+			if err := x.waitTx(); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		frames = frames[n:]
+	}
+	return sent, nil
+}
+
+// xdpTxWait bounds how long WriteFrames waits for transmit ring space.
+const xdpTxWait = 100 * time.Millisecond
+
+// writeBatch puts as many frames on the transmit ring as there is room
+// for, and returns how many it queued.
+func (x *XDPInterface) writeBatch(frames []*Frame) (int, error) {
+	x.reclaim()
+	prod := atomic.LoadUint32(x.tx.producer)
+	room := x.ringSize - (prod - atomic.LoadUint32(x.tx.consumer))
+	n := min(len(frames), len(x.free), int(room))
+
+	var err error
+	queued := 0
+	for ; queued < n; queued++ {
+		addr := x.free[len(x.free)-1]
+		written, serr := frames[queued].SerializeTo(x.umem[addr : addr+uint64(x.frameSize)])
+		if serr != nil {
+			err = fmt.Errorf("failed to serialize frame: %w", serr)
+			break
+		}
+		x.free = x.free[:len(x.free)-1]
+		*x.tx.desc(prod + uint32(queued)) = unix.XDPDesc{Addr: addr, Len: uint32(written)}
+		counters.bytesSent.Add(uint64(written))
+	}
+	if queued > 0 {
+		atomic.StoreUint32(x.tx.producer, prod+uint32(queued))
+		counters.framesSent.Add(uint64(queued))
+		if kerr := x.kick(); kerr != nil && err == nil {
+			err = kerr
+		}
+	}
+	return queued, err
+}
+
+// reclaim takes back the UMEM frames of sent frames from the completion
+// ring.
+func (x *XDPInterface) reclaim() {
+	cons := atomic.LoadUint32(x.comp.consumer)
+	done := atomic.LoadUint32(x.comp.producer) - cons
+	for k := uint32(0); k < done; k++ {
+		x.free = append(x.free, *x.comp.addr(cons + k))
+	}
+	atomic.StoreUint32(x.comp.consumer, cons+done)
+}
+
+// kick tells the kernel there are frames to send, if it needs telling. In
+// copy mode each system call sends a limited number of frames, so it is
+// repeated until the ring is empty.
+func (x *XDPInterface) kick() error {
+	for x.tx.needsWakeup() {
+		_, _, errno := unix.Syscall6(unix.SYS_SENDTO, uintptr(x.fd), 0, 0, unix.MSG_DONTWAIT, 0, 0)
+		switch errno {
+		case 0, unix.EAGAIN:
+		case unix.EBUSY, unix.ENOBUFS, unix.ENETDOWN:
+			// The kernel is busy or out of buffers; the frames stay on
+			// the ring for the next kick
+			return nil
+		default:
+			counters.txErrors.Add(1)
+			return fmt.Errorf("failed to send frames: %w", errno)
+		}
+		if x.zeroCopy || atomic.LoadUint32(x.tx.consumer) == atomic.LoadUint32(x.tx.producer) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// waitTx waits for frames to complete, so that their UMEM frames and ring
+// slots can be reused.
+func (x *XDPInterface) waitTx() error {
+	deadline := time.Now().Add(xdpTxWait)
+	for time.Now().Before(deadline) {
+		if err := x.kick(); err != nil {
+			return err
+		}
+		if _, err := pollSocket(x.fd, unix.POLLOUT, time.Millisecond); err != nil {
+			return err
+		}
+		if atomic.LoadUint32(x.comp.producer) != atomic.LoadUint32(x.comp.consumer) {
+			return nil
+		}
+	}
+	counters.txErrors.Add(1)
+	return fmt.Errorf("failed to send frames: transmit ring full")
+}
+
+// bpf calls bpf(2) with an attribute structure.
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r), nil
+}
+
+// setsockoptXDP sets an SOL_XDP socket option with a structure value.
+func setsockoptXDP(fd, opt int, value unsafe.Pointer, size uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, uintptr(fd), unix.SOL_XDP, uintptr(opt), uintptr(value), size, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// getsockoptXDP gets an SOL_XDP socket option with a structure value.
+func getsockoptXDP(fd, opt int, value unsafe.Pointer, size uintptr) error {
+	length := uint32(size)
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.SOL_XDP, uintptr(opt),
+		uintptr(value), uintptr(unsafe.Pointer(&length)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
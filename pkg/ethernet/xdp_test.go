@@ -0,0 +1,152 @@
+package ethernet
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// etherTypeTest is the local experimental EtherType, to tell the test's
+// frames from the kernel's.
+const etherTypeTest common.EtherType = 0x88b5
+
+// vethPair creates a veth pair that is removed when the test ends, and
+// returns the names of its ends.
+func vethPair(t testing.TB) (string, string) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("AF_XDP tests require root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("AF_XDP tests require the ip command")
+	}
+	id := fmt.Sprintf("%d%d", os.Getpid()%10000, time.Now().UnixNano()%1000)
+	a, b := "xda"+id, "xdb"+id
+	ip := func(args ...string) {
+		if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			t.Fatalf("ip %v: %v: %s", args, err, out)
+		}
+	}
+	ip("link", "add", a, "type", "veth", "peer", "name", b)
+	t.Cleanup(func() { exec.Command("ip", "link", "del", a).Run() })
+	for _, name := range []string{a, b} {
+		os.WriteFile("/proc/sys/net/ipv6/conf/"+name+"/disable_ipv6", []byte("1"), 0)
+		ip("link", "set", name, "up")
+	}
+	return a, b
+}
+
+func TestXDPProgram(t *testing.T) {
+	insns := xdpProgram(7)
+	if len(insns) != 6 {
+		t.Fatalf("xdpProgram() has %d instructions, want 6", len(insns))
+	}
+	if load := insns[1]; load.code != 0x18 || load.regs != 0x11 || load.imm != 7 {
+		t.Errorf("map load = %+v, want ld_imm64 r1 of map fd 7", load)
+	}
+	if last := insns[len(insns)-1]; last.code != 0x95 {
+		t.Errorf("last instruction = %+v, want exit", last)
+	}
+}
+
+func TestOpenXDPInterfaceConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config XDPConfig
+	}{
+		{"frame size", XDPConfig{FrameSize: 1000}},
+		{"ring size", XDPConfig{RingSize: 1000}},
+		{"queue", XDPConfig{Queue: -1}},
+		{"generic zero-copy", XDPConfig{Generic: true, Mode: XDPModeZeroCopy}},
+	}
+	for _, tt := range tests {
+		if _, err := OpenXDPInterface("lo", tt.config); err == nil {
+			t.Errorf("%s: OpenXDPInterface() succeeded, want an error", tt.name)
+		}
+	}
+}
+
+// readTestFrames reads frames of etherTypeTest from dev until it has n of
+// them or times out, and returns their first payload bytes.
+func readTestFrames(t *testing.T, dev Device, n int) []byte {
+	t.Helper()
+	var got []byte
+	frames := make([]*Frame, MaxBatchSize)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(got) < n && time.Now().Before(deadline) {
+		m, err := ReadFrames(dev, frames)
+		if err != nil {
+			t.Fatalf("ReadFrames() error = %v", err)
+		}
+		for _, frame := range frames[:m] {
+			if frame.EtherType == etherTypeTest {
+				got = append(got, frame.Payload[0])
+			}
+			frame.Release()
+		}
+	}
+	return got
+}
+
+func TestXDPInterface(t *testing.T) {
+	a, b := vethPair(t)
+	xdp, err := OpenXDPInterface(a, XDPConfig{FrameCount: 256, RingSize: 64})
+	if err != nil {
+		t.Fatalf("OpenXDPInterface() error = %v", err)
+	}
+	defer xdp.Close()
+	xdp.SetPollTimeout(50 * time.Millisecond)
+	peer, err := OpenInterface(b)
+	if err != nil {
+		t.Fatalf("OpenInterface() error = %v", err)
+	}
+	defer peer.Close()
+	peer.SetPollTimeout(50 * time.Millisecond)
+
+	// More frames than the transmit half of the UMEM, so that frames are
+	// reused once sent
+	const count = 200
+	frames := make([]*Frame, count)
+	for i := range frames {
+		frames[i] = NewFrame(peer.MACAddress(), xdp.MACAddress(), etherTypeTest, []byte{byte(i), 0, 0, 0})
+	}
+
+	if n, err := WriteFrames(xdp, frames); n != count || err != nil {
+		t.Fatalf("XDPInterface.WriteFrames() = %d, %v, want %d, nil", n, err, count)
+	}
+	if got := readTestFrames(t, peer, count); len(got) != count {
+		t.Errorf("peer received %d frames, want %d", len(got), count)
+	}
+
+	for i := range frames {
+		frames[i].Source, frames[i].Destination = peer.MACAddress(), xdp.MACAddress()
+	}
+	// In bursts the fill ring has room for, but more frames in all
+	var got []byte
+	for i := 0; i < count; i += 50 {
+		if n, err := WriteFrames(peer, frames[i:i+50]); n != 50 || err != nil {
+			t.Fatalf("Interface.WriteFrames() = %d, %v, want 50, nil", n, err)
+		}
+		got = append(got, readTestFrames(t, xdp, 50)...)
+	}
+	if len(got) != count {
+		t.Fatalf("XDPInterface received %d frames, want %d", len(got), count)
+	}
+	for i, v := range got {
+		if v != byte(i) {
+			t.Fatalf("frame %d has payload %d, want %d", i, v, byte(i))
+		}
+	}
+
+	stats, err := xdp.XDPStats()
+	if err != nil {
+		t.Fatalf("XDPStats() error = %v", err)
+	}
+	if stats.RxInvalid != 0 || stats.TxInvalid != 0 {
+		t.Errorf("XDPStats() = %+v, want no invalid descriptors", stats)
+	}
+}
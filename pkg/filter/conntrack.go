@@ -0,0 +1,476 @@
+package filter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+// State is a connection tracking state. States are bit flags so that a
+// rule can match several of them.
+type State uint8
+
+const (
+	StateNew         State = 1 << iota // Packet starts a new connection
+	StateEstablished                   // Packet belongs to a connection seen in both directions
+	StateRelated                       // ICMP error about a tracked connection
+	StateInvalid                       // Packet cannot belong to a valid connection
+)
+
+// String returns the state names separated by commas.
+func (s State) String() string {
+	var names []string
+	for _, st := range []struct {
+		state State
+		name  string
+	}{
+		{StateNew, "NEW"},
+		{StateEstablished, "ESTABLISHED"},
+		{StateRelated, "RELATED"},
+		{StateInvalid, "INVALID"},
+	} {
+		if s&st.state != 0 {
+			names = append(names, st.name)
+		}
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, ",")
+}
+
+// Timeouts holds idle timeouts for tracked connections.
+type Timeouts struct {
+	TCPEstablished time.Duration // Established TCP connections
+	TCPTransitory  time.Duration // TCP connections opening or closing
+	UDP            time.Duration // UDP flows without replies
+	UDPStream      time.Duration // UDP flows with replies
+	ICMP           time.Duration // ICMP and other protocols
+}
+
+// DefaultTimeouts returns the Linux conntrack default timeouts.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		TCPEstablished: 5 * 24 * time.Hour,
+		TCPTransitory:  2 * time.Minute,
+		UDP:            30 * time.Second,
+		UDPStream:      120 * time.Second,
+		ICMP:           30 * time.Second,
+	}
+}
+
+// Tuple identifies one direction of a connection.
+type Tuple struct {
+	Protocol        common.Protocol
+	Source          common.IPv4Address
+	Destination     common.IPv4Address
+	SourcePort      uint16 // TCP/UDP source port, or ICMP echo request identifier
+	DestinationPort uint16 // TCP/UDP destination port, or ICMP echo reply identifier
+}
+
+// Reverse returns the tuple of the reply direction.
+func (t Tuple) Reverse() Tuple {
+	return Tuple{
+		Protocol:        t.Protocol,
+		Source:          t.Destination,
+		Destination:     t.Source,
+		SourcePort:      t.DestinationPort,
+		DestinationPort: t.SourcePort,
+	}
+}
+
+// String returns a human-readable representation of the tuple.
+func (t Tuple) String() string {
+	return fmt.Sprintf("%s %s:%d -> %s:%d", t.Protocol, t.Source, t.SourcePort, t.Destination, t.DestinationPort)
+}
+
+// parseTuple extracts a tuple from addresses and transport header bytes.
+// Returns false if the transport header is too short.
+func parseTuple(proto common.Protocol, src, dst common.IPv4Address, th []byte) (Tuple, bool) {
+	t := Tuple{Protocol: proto, Source: src, Destination: dst}
+
+	switch proto {
+	case common.ProtocolTCP, common.ProtocolUDP:
+		if len(th) < 4 {
+			return t, false
+		}
+		t.SourcePort = binary.BigEndian.Uint16(th[0:2])
+		t.DestinationPort = binary.BigEndian.Uint16(th[2:4])
+	case common.ProtocolICMP:
+		if len(th) < icmp.MinHeaderLength {
+			return t, false
+		}
+		switch icmp.Type(th[0]) {
+		case icmp.TypeEchoRequest:
+			t.SourcePort = binary.BigEndian.Uint16(th[4:6])
+		case icmp.TypeEchoReply:
+			t.DestinationPort = binary.BigEndian.Uint16(th[4:6])
+		}
+	}
+
+	return t, true
+}
+
+// isICMPError reports whether an ICMP message quotes the packet that caused it.
+func isICMPError(msg []byte) bool {
+	if len(msg) == 0 {
+		return false
+	}
+	switch icmp.Type(msg[0]) {
+	case icmp.TypeDestinationUnreachable, icmp.TypeSourceQuench, icmp.TypeRedirect,
+		icmp.TypeTimeExceeded, icmp.TypeParameterProblem:
+		return true
+	default:
+		return false
+	}
+}
+
+// tcpState is the tracked state of a TCP connection.
+type tcpState uint8
+
+const (
+	tcpSynSent tcpState = iota
+	tcpSynReceived
+	tcpEstablished
+	tcpClosing
+	tcpClosed
+)
+
+// String returns the name of the TCP state.
+func (s tcpState) String() string {
+	switch s {
+	case tcpSynSent:
+		return "SYN_SENT"
+	case tcpSynReceived:
+		return "SYN_RECV"
+	case tcpEstablished:
+		return "ESTABLISHED"
+	case tcpClosing:
+		return "CLOSING"
+	default:
+		return "CLOSED"
+	}
+}
+
+// conn is a tracked connection.
+type conn struct {
+	original  Tuple
+	replied   bool
+	tcp       tcpState
+	finOrig   bool
+	finReply  bool
+	packets   uint64
+	bytes     uint64
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// Connection is a snapshot of a tracked connection.
+type Connection struct {
+	Original  Tuple     // Tuple of the direction that opened the connection
+	State     State     // StateNew until a reply is seen, then StateEstablished
+	TCPState  string    // TCP state name (TCP only)
+	Packets   uint64    // Packets in both directions
+	Bytes     uint64    // Bytes in both directions
+	FirstSeen time.Time // When the connection was created
+	LastSeen  time.Time // When the last packet was seen
+}
+
+// lookupResult is the tracking state of a packet before the filter verdict.
+type lookupResult struct {
+	tuple Tuple
+	state State
+	conn  *conn // Existing connection, if any
+	reply bool  // Packet travels in the reply direction
+}
+
+// ConnTracker tracks connections through the filter.
+type ConnTracker struct {
+	mu       sync.Mutex
+	conns    map[Tuple]*conn // Keyed by both original and reply tuples
+	timeouts Timeouts
+	now      func() time.Time
+}
+
+// NewConnTracker creates a connection tracker.
+func NewConnTracker(timeouts Timeouts) *ConnTracker {
+	return &ConnTracker{
+		conns:    make(map[Tuple]*conn),
+		timeouts: timeouts,
+		now:      time.Now,
+	}
+}
+
+// State returns the tracking state a packet would have, without updating
+// the tracker.
+func (t *ConnTracker) State(pkt *ip.Packet) State {
+	return t.lookup(pkt).state
+}
+
+// lookup classifies a packet against the tracked connections.
+func (t *ConnTracker) lookup(pkt *ip.Packet) lookupResult {
+	res := lookupResult{tuple: Tuple{Protocol: pkt.Protocol, Source: pkt.Source, Destination: pkt.Destination}}
+
+	// Only the first fragment carries the transport header
+	if pkt.FragmentOffset != 0 {
+		res.state = StateInvalid
+		return res
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// ICMP errors are related to the connection of the packet they quote
+	if pkt.Protocol == common.ProtocolICMP && isICMPError(pkt.Payload) {
+		res.state = StateInvalid
+		if quoted, ok := parseQuoted(pkt.Payload); ok {
+			if c := t.conns[quoted]; c != nil && !t.expired(c, t.now()) {
+				res.state = StateRelated
+			}
+		}
+		return res
+	}
+
+	tuple, ok := parseTuple(pkt.Protocol, pkt.Source, pkt.Destination, pkt.Payload)
+	res.tuple = tuple
+	if !ok || (pkt.Protocol == common.ProtocolTCP && len(pkt.Payload) < tcp.MinHeaderLength) {
+		res.state = StateInvalid
+		return res
+	}
+
+	var flags uint8
+	if pkt.Protocol == common.ProtocolTCP {
+		flags = pkt.Payload[13]
+	}
+	isSYN := flags&tcp.FlagSYN != 0 && flags&tcp.FlagACK == 0
+
+	c := t.conns[tuple]
+	if c != nil && t.expired(c, t.now()) {
+		c = nil
+	}
+	if c != nil && c.tcp == tcpClosed && isSYN && tuple == c.original {
+		// A new SYN reopens a closed connection
+		c = nil
+	}
+
+	if c == nil {
+		// A TCP connection can only start with a SYN
+		if pkt.Protocol == common.ProtocolTCP && !isSYN {
+			res.state = StateInvalid
+		} else {
+			res.state = StateNew
+		}
+		return res
+	}
+
+	res.conn = c
+	res.reply = tuple != c.original
+	if res.reply || c.replied {
+		res.state = StateEstablished
+	} else {
+		res.state = StateNew
+	}
+	return res
+}
+
+// parseQuoted extracts the tuple of the packet quoted in an ICMP error.
+func parseQuoted(msg []byte) (Tuple, bool) {
+	if len(msg) < icmp.MinHeaderLength+ip.MinHeaderLength {
+		return Tuple{}, false
+	}
+	inner := msg[icmp.MinHeaderLength:]
+	headerLen := int(inner[0]&0x0F) * 4
+	if inner[0]>>4 != ip.IPv4Version || headerLen < ip.MinHeaderLength || len(inner) < headerLen {
+		return Tuple{}, false
+	}
+
+	var src, dst common.IPv4Address
+	copy(src[:], inner[12:16])
+	copy(dst[:], inner[16:20])
+	return parseTuple(common.Protocol(inner[9]), src, dst, inner[headerLen:])
+}
+
+// confirm records an accepted packet, creating its connection if needed.
+func (t *ConnTracker) confirm(res lookupResult, pkt *ip.Packet) {
+	if res.state == StateInvalid || res.state == StateRelated {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	c := res.conn
+	if c == nil {
+		c = &conn{original: res.tuple, firstSeen: now}
+		t.conns[res.tuple] = c
+		t.conns[res.tuple.Reverse()] = c
+	}
+
+	c.lastSeen = now
+	c.packets++
+	c.bytes += uint64(ip.MinHeaderLength + len(pkt.Options) + len(pkt.Payload))
+	if res.reply {
+		c.replied = true
+	}
+	if pkt.Protocol == common.ProtocolTCP {
+		c.updateTCP(pkt.Payload[13], res.reply)
+	}
+}
+
+// updateTCP advances the TCP state of a connection for a segment.
+func (c *conn) updateTCP(flags uint8, reply bool) {
+	switch {
+	case flags&tcp.FlagRST != 0:
+		c.tcp = tcpClosed
+	case flags&tcp.FlagSYN != 0:
+		if reply && flags&tcp.FlagACK != 0 && c.tcp == tcpSynSent {
+			c.tcp = tcpSynReceived
+		}
+	case flags&tcp.FlagFIN != 0:
+		if reply {
+			c.finReply = true
+		} else {
+			c.finOrig = true
+		}
+		if c.finOrig && c.finReply {
+			c.tcp = tcpClosed
+		} else {
+			c.tcp = tcpClosing
+		}
+	case flags&tcp.FlagACK != 0:
+		if c.tcp == tcpSynReceived && !reply {
+			c.tcp = tcpEstablished
+		}
+	}
+}
+
+// timeout returns the idle timeout of a connection in its current state.
+func (t *ConnTracker) timeout(c *conn) time.Duration {
+	switch c.original.Protocol {
+	case common.ProtocolTCP:
+		if c.tcp == tcpEstablished {
+			return t.timeouts.TCPEstablished
+		}
+		return t.timeouts.TCPTransitory
+	case common.ProtocolUDP:
+		if c.replied {
+			return t.timeouts.UDPStream
+		}
+		return t.timeouts.UDP
+	default:
+		return t.timeouts.ICMP
+	}
+}
+
+// expired reports whether a connection has been idle past its timeout.
+func (t *ConnTracker) expired(c *conn, now time.Time) bool {
+	return now.Sub(c.lastSeen) > t.timeout(c)
+}
+
+// Expire removes connections idle past their timeout.
+// Returns the number of connections removed.
+func (t *ConnTracker) Expire() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	removed := 0
+	for tuple, c := range t.conns {
+		if tuple == c.original && t.expired(c, now) {
+			delete(t.conns, c.original)
+			delete(t.conns, c.original.Reverse())
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// StartExpiry starts a goroutine that periodically removes expired connections.
+// Returns a channel that can be closed to stop the routine.
+func (t *ConnTracker) StartExpiry(interval time.Duration) chan<- struct{} {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.Expire()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// Flush removes all tracked connections.
+func (t *ConnTracker) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.conns = make(map[Tuple]*conn)
+}
+
+// Len returns the number of tracked connections.
+func (t *ConnTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count := 0
+	for tuple, c := range t.conns {
+		if tuple == c.original {
+			count++
+		}
+	}
+	return count
+}
+
+// Connections returns a snapshot of the tracked connections ordered by
+// their original tuple.
+func (t *ConnTracker) Connections() []Connection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns := make([]Connection, 0, len(t.conns)/2)
+	for tuple, c := range t.conns {
+		if tuple != c.original {
+			continue
+		}
+
+		snapshot := Connection{
+			Original:  c.original,
+			State:     StateNew,
+			Packets:   c.packets,
+			Bytes:     c.bytes,
+			FirstSeen: c.firstSeen,
+			LastSeen:  c.lastSeen,
+		}
+		if c.replied {
+			snapshot.State = StateEstablished
+		}
+		if c.original.Protocol == common.ProtocolTCP {
+			snapshot.TCPState = c.tcp.String()
+		}
+		conns = append(conns, snapshot)
+	}
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].Original.String() < conns[j].Original.String()
+	})
+
+	return conns
+}
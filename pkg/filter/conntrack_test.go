@@ -0,0 +1,188 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+// statefulFilter allows connections from the LAN and replies to them.
+func statefulFilter(t *testing.T) *Filter {
+	t.Helper()
+	f := New()
+	f.SetPolicy(HookForward, ActionDrop)
+	rules := []*Rule{
+		{States: StateEstablished | StateRelated, Action: ActionAccept},
+		{States: StateInvalid, Action: ActionDrop},
+		{InInterface: "lan", States: StateNew, Action: ActionAccept},
+	}
+	for _, r := range rules {
+		if err := f.AppendRule("FORWARD", r); err != nil {
+			t.Fatalf("AppendRule() error = %v", err)
+		}
+	}
+	return f
+}
+
+func outbound(pkt *ip.Packet) *Packet {
+	return &Packet{InInterface: "lan", OutInterface: "wan", IP: pkt}
+}
+
+func inbound(pkt *ip.Packet) *Packet {
+	return &Packet{InInterface: "wan", OutInterface: "lan", IP: pkt}
+}
+
+func TestStatefulTCP(t *testing.T) {
+	f := statefulFilter(t)
+
+	steps := []struct {
+		name     string
+		pkt      *Packet
+		want     Verdict
+		tcpState string
+	}{
+		{"unsolicited SYN", inbound(tcpPacket(wanHost, lanHost, 443, 40000, tcp.FlagSYN)), VerdictDrop, ""},
+		{"SYN", outbound(tcpPacket(lanHost, wanHost, 40000, 443, tcp.FlagSYN)), VerdictAccept, "SYN_SENT"},
+		{"SYN-ACK", inbound(tcpPacket(wanHost, lanHost, 443, 40000, tcp.FlagSYN|tcp.FlagACK)), VerdictAccept, "SYN_RECV"},
+		{"ACK", outbound(tcpPacket(lanHost, wanHost, 40000, 443, tcp.FlagACK)), VerdictAccept, "ESTABLISHED"},
+		{"data", inbound(tcpPacket(wanHost, lanHost, 443, 40000, tcp.FlagACK|tcp.FlagPSH)), VerdictAccept, "ESTABLISHED"},
+		{"FIN", outbound(tcpPacket(lanHost, wanHost, 40000, 443, tcp.FlagFIN|tcp.FlagACK)), VerdictAccept, "CLOSING"},
+		{"FIN reply", inbound(tcpPacket(wanHost, lanHost, 443, 40000, tcp.FlagFIN|tcp.FlagACK)), VerdictAccept, "CLOSED"},
+	}
+
+	for _, step := range steps {
+		if v := f.Check(HookForward, step.pkt); v != step.want {
+			t.Errorf("%s: Check() = %s, want %s", step.name, v, step.want)
+		}
+		if step.tcpState == "" {
+			continue
+		}
+		conns := f.Tracker().Connections()
+		if len(conns) != 1 {
+			t.Fatalf("%s: %d connections, want 1", step.name, len(conns))
+		}
+		if conns[0].TCPState != step.tcpState {
+			t.Errorf("%s: TCPState = %s, want %s", step.name, conns[0].TCPState, step.tcpState)
+		}
+	}
+
+	conns := f.Tracker().Connections()
+	if conns[0].Packets != 6 || conns[0].State != StateEstablished {
+		t.Errorf("connection = %+v, want 6 packets ESTABLISHED", conns[0])
+	}
+
+	// Mid-stream segments of unknown connections are invalid
+	stray := outbound(tcpPacket(lanHost, wanHost, 41000, 443, tcp.FlagACK))
+	if state := f.Tracker().State(stray.IP); state != StateInvalid {
+		t.Errorf("State() = %s, want INVALID", state)
+	}
+	if v := f.Check(HookForward, stray); v != VerdictDrop {
+		t.Errorf("Check() stray ACK = %s, want DROP", v)
+	}
+}
+
+func TestStatefulUDPAndExpiry(t *testing.T) {
+	f := statefulFilter(t)
+	now := time.Now()
+	f.Tracker().now = func() time.Time { return now }
+
+	query := outbound(udpPacket(lanHost, wanHost, 5353, 53))
+	answer := inbound(udpPacket(wanHost, lanHost, 53, 5353))
+
+	if v := f.Check(HookForward, answer); v != VerdictDrop {
+		t.Errorf("unsolicited answer = %s, want DROP", v)
+	}
+	if v := f.Check(HookForward, query); v != VerdictAccept {
+		t.Fatalf("query = %s, want ACCEPT", v)
+	}
+	if state := f.Tracker().State(answer.IP); state != StateEstablished {
+		t.Errorf("answer state = %s, want ESTABLISHED", state)
+	}
+	if v := f.Check(HookForward, answer); v != VerdictAccept {
+		t.Errorf("answer = %s, want ACCEPT", v)
+	}
+
+	// Replied UDP flows use the stream timeout
+	now = now.Add(DefaultTimeouts().UDP + time.Second)
+	if removed := f.Tracker().Expire(); removed != 0 {
+		t.Errorf("Expire() = %d, want 0", removed)
+	}
+
+	now = now.Add(DefaultTimeouts().UDPStream)
+	if v := f.Check(HookForward, answer); v != VerdictDrop {
+		t.Errorf("answer after timeout = %s, want DROP", v)
+	}
+	if removed := f.Tracker().Expire(); removed != 1 {
+		t.Errorf("Expire() = %d, want 1", removed)
+	}
+	if f.Tracker().Len() != 0 {
+		t.Errorf("Len() = %d, want 0", f.Tracker().Len())
+	}
+}
+
+func TestDroppedPacketsNotTracked(t *testing.T) {
+	f := statefulFilter(t)
+
+	// The WAN may not open connections, so nothing is tracked
+	f.Check(HookForward, inbound(udpPacket(wanHost, lanHost, 53, 5353)))
+	if f.Tracker().Len() != 0 {
+		t.Errorf("Len() = %d after drop, want 0", f.Tracker().Len())
+	}
+}
+
+func TestRelatedICMP(t *testing.T) {
+	f := statefulFilter(t)
+
+	query := udpPacket(lanHost, wanHost, 5353, 53)
+	f.Check(HookForward, outbound(query))
+
+	// Port unreachable quoting the query is related
+	quoted, _ := query.Serialize()
+	msg, _ := icmp.NewDestinationUnreachable(icmp.CodePortUnreachable, quoted[:ip.MinHeaderLength+8]).Serialize()
+	unreach := inbound(ip.NewPacket(wanHost, lanHost, common.ProtocolICMP, msg))
+
+	if state := f.Tracker().State(unreach.IP); state != StateRelated {
+		t.Errorf("State() = %s, want RELATED", state)
+	}
+	if v := f.Check(HookForward, unreach); v != VerdictAccept {
+		t.Errorf("Check() = %s, want ACCEPT", v)
+	}
+
+	// An error about an unknown flow is invalid
+	other, _ := udpPacket(lanHost, wanHost, 6000, 53).Serialize()
+	msg, _ = icmp.NewDestinationUnreachable(icmp.CodePortUnreachable, other[:ip.MinHeaderLength+8]).Serialize()
+	if v := f.Check(HookForward, inbound(ip.NewPacket(wanHost, lanHost, common.ProtocolICMP, msg))); v != VerdictDrop {
+		t.Errorf("Check() unrelated error = %s, want DROP", v)
+	}
+}
+
+func TestICMPEchoTracking(t *testing.T) {
+	f := statefulFilter(t)
+
+	req, _ := icmp.NewEchoRequest(42, 1, nil).Serialize()
+	f.Check(HookForward, outbound(ip.NewPacket(lanHost, wanHost, common.ProtocolICMP, req)))
+
+	reply, _ := icmp.NewEchoReply(42, 1, nil).Serialize()
+	if v := f.Check(HookForward, inbound(ip.NewPacket(wanHost, lanHost, common.ProtocolICMP, reply))); v != VerdictAccept {
+		t.Errorf("echo reply = %s, want ACCEPT", v)
+	}
+
+	// A reply with another identifier was never requested
+	stray, _ := icmp.NewEchoReply(43, 1, nil).Serialize()
+	if v := f.Check(HookForward, inbound(ip.NewPacket(wanHost, lanHost, common.ProtocolICMP, stray))); v != VerdictDrop {
+		t.Errorf("stray echo reply = %s, want DROP", v)
+	}
+}
+
+func TestStateString(t *testing.T) {
+	if s := (StateEstablished | StateRelated).String(); s != "ESTABLISHED,RELATED" {
+		t.Errorf("String() = %s, want ESTABLISHED,RELATED", s)
+	}
+	if s := State(0).String(); s != "NONE" {
+		t.Errorf("String() = %s, want NONE", s)
+	}
+}
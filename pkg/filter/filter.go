@@ -0,0 +1,535 @@
+// Package filter implements a stateful packet filter with ordered rule chains.
+//
+// The filter is modeled on the netfilter "filter" table: packets are checked
+// against the built-in INPUT, FORWARD or OUTPUT chain depending on their path
+// through the stack, rules are evaluated in order, and the first matching
+// rule with a terminal action decides the verdict. Rules may jump to
+// user-defined chains and match on connection tracking state.
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+// maxJumpDepth bounds chain jumps so that rule loops cannot recurse forever.
+const maxJumpDepth = 16
+
+// Hook identifies the path of a packet through the stack.
+type Hook uint8
+
+const (
+	HookInput   Hook = iota // Packets addressed to the local host
+	HookForward             // Packets routed through the local host
+	HookOutput              // Packets sent by the local host
+)
+
+// String returns the name of the built-in chain for the hook.
+func (h Hook) String() string {
+	switch h {
+	case HookInput:
+		return "INPUT"
+	case HookForward:
+		return "FORWARD"
+	case HookOutput:
+		return "OUTPUT"
+	default:
+		return fmt.Sprintf("Hook(%d)", h)
+	}
+}
+
+// Action is what a rule does with a matching packet.
+type Action uint8
+
+const (
+	ActionAccept Action = iota // Let the packet through
+	ActionDrop                 // Silently discard the packet
+	ActionReject               // Discard the packet and notify the sender
+	ActionJump                 // Continue evaluation in the rule's target chain
+	ActionReturn               // Resume evaluation in the calling chain
+)
+
+// String returns a human-readable name for the action.
+func (a Action) String() string {
+	switch a {
+	case ActionAccept:
+		return "ACCEPT"
+	case ActionDrop:
+		return "DROP"
+	case ActionReject:
+		return "REJECT"
+	case ActionJump:
+		return "JUMP"
+	case ActionReturn:
+		return "RETURN"
+	default:
+		return fmt.Sprintf("Action(%d)", a)
+	}
+}
+
+// Verdict is the final decision for a packet.
+type Verdict uint8
+
+const (
+	VerdictAccept Verdict = iota // Continue processing the packet
+	VerdictDrop                  // Discard the packet
+	VerdictReject                // Discard the packet and send RejectReply to the sender
+)
+
+// String returns a human-readable name for the verdict.
+func (v Verdict) String() string {
+	switch v {
+	case VerdictAccept:
+		return "ACCEPT"
+	case VerdictDrop:
+		return "DROP"
+	case VerdictReject:
+		return "REJECT"
+	default:
+		return fmt.Sprintf("Verdict(%d)", v)
+	}
+}
+
+// Packet is a packet being checked, with the interfaces it traverses.
+type Packet struct {
+	InInterface  string     // Interface the packet arrived on (empty for HookOutput)
+	OutInterface string     // Interface the packet leaves on (empty for HookInput)
+	IP           *ip.Packet // The packet itself
+}
+
+// PacketFilter is consulted by the stack on the input, forward and output
+// paths. Packets with a verdict other than VerdictAccept must not be
+// processed further.
+type PacketFilter interface {
+	Check(hook Hook, pkt *Packet) Verdict
+}
+
+// Prefix is an IPv4 network in address/mask form.
+type Prefix struct {
+	Address common.IPv4Address
+	Mask    common.IPv4Address
+}
+
+// ParsePrefix parses an address in CIDR notation ("10.0.0.0/8").
+// A bare address is treated as a /32.
+func ParsePrefix(s string) (Prefix, error) {
+	cidr := s
+	if !strings.Contains(s, "/") {
+		cidr += "/32"
+	}
+	p, err := common.ParsePrefix(cidr)
+	if err != nil {
+		return Prefix{}, err
+	}
+	if !p.Is4() {
+		return Prefix{}, fmt.Errorf("invalid prefix %q: not IPv4", s)
+	}
+	return Prefix{Address: p.Addr(), Mask: p.Netmask()}, nil
+}
+
+// MustParsePrefix is like ParsePrefix but panics on error.
+func MustParsePrefix(s string) *Prefix {
+	p, err := ParsePrefix(s)
+	if err != nil {
+		panic(err)
+	}
+	return &p
+}
+
+// Contains reports whether the prefix contains the address.
+func (p Prefix) Contains(addr common.IPv4Address) bool {
+	for i := range addr {
+		if addr[i]&p.Mask[i] != p.Address[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the prefix in CIDR notation.
+func (p Prefix) String() string {
+	bits, _ := common.NetmaskLength(p.Mask)
+	return fmt.Sprintf("%s/%d", p.Address, bits)
+}
+
+// PortRange is an inclusive range of TCP or UDP ports.
+type PortRange struct {
+	Start uint16
+	End   uint16
+}
+
+// Port returns a range matching a single port.
+func Port(port uint16) *PortRange {
+	return &PortRange{Start: port, End: port}
+}
+
+// Contains reports whether the range contains the port.
+func (r PortRange) Contains(port uint16) bool {
+	return port >= r.Start && port <= r.End
+}
+
+// Rule matches packets and decides what to do with them. Zero-valued match
+// fields match any packet.
+type Rule struct {
+	InInterface      string          // Input interface name
+	OutInterface     string          // Output interface name
+	Source           *Prefix         // Source address or network
+	Destination      *Prefix         // Destination address or network
+	Protocol         common.Protocol // IP protocol
+	SourcePorts      *PortRange      // TCP/UDP source ports
+	DestinationPorts *PortRange      // TCP/UDP destination ports
+	TCPFlags         uint8           // Required values of the flags in TCPFlagsMask
+	TCPFlagsMask     uint8           // TCP flags to examine
+	States           State           // Connection tracking states (any of)
+
+	Action  Action // What to do with matching packets
+	Target  string // Chain for ActionJump
+	Comment string // Free-form description
+
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+// Packets returns the number of packets the rule has matched.
+func (r *Rule) Packets() uint64 {
+	return r.packets.Load()
+}
+
+// Bytes returns the number of bytes the rule has matched.
+func (r *Rule) Bytes() uint64 {
+	return r.bytes.Load()
+}
+
+// String returns a human-readable representation of the rule.
+func (r *Rule) String() string {
+	var parts []string
+	if r.InInterface != "" {
+		parts = append(parts, "in="+r.InInterface)
+	}
+	if r.OutInterface != "" {
+		parts = append(parts, "out="+r.OutInterface)
+	}
+	if r.Source != nil {
+		parts = append(parts, "src="+r.Source.String())
+	}
+	if r.Destination != nil {
+		parts = append(parts, "dst="+r.Destination.String())
+	}
+	if r.Protocol != 0 {
+		parts = append(parts, "proto="+r.Protocol.String())
+	}
+	if r.SourcePorts != nil {
+		parts = append(parts, fmt.Sprintf("sport=%d-%d", r.SourcePorts.Start, r.SourcePorts.End))
+	}
+	if r.DestinationPorts != nil {
+		parts = append(parts, fmt.Sprintf("dport=%d-%d", r.DestinationPorts.Start, r.DestinationPorts.End))
+	}
+	if r.TCPFlagsMask != 0 {
+		parts = append(parts, fmt.Sprintf("flags=0x%02x/0x%02x", r.TCPFlags, r.TCPFlagsMask))
+	}
+	if r.States != 0 {
+		parts = append(parts, "state="+r.States.String())
+	}
+
+	action := r.Action.String()
+	if r.Action == ActionJump {
+		action = r.Target
+	}
+	parts = append(parts, "-> "+action)
+	if r.Comment != "" {
+		parts = append(parts, fmt.Sprintf("(%s)", r.Comment))
+	}
+	return strings.Join(parts, " ")
+}
+
+// matches reports whether the rule matches a packet.
+func (r *Rule) matches(pkt *Packet, tuple Tuple, flags uint8, state State) bool {
+	if r.InInterface != "" && r.InInterface != pkt.InInterface {
+		return false
+	}
+	if r.OutInterface != "" && r.OutInterface != pkt.OutInterface {
+		return false
+	}
+	if r.Source != nil && !r.Source.Contains(pkt.IP.Source) {
+		return false
+	}
+	if r.Destination != nil && !r.Destination.Contains(pkt.IP.Destination) {
+		return false
+	}
+	if r.Protocol != 0 && r.Protocol != pkt.IP.Protocol {
+		return false
+	}
+
+	// Port matches only apply to TCP and UDP
+	hasPorts := tuple.Protocol == common.ProtocolTCP || tuple.Protocol == common.ProtocolUDP
+	if r.SourcePorts != nil && (!hasPorts || !r.SourcePorts.Contains(tuple.SourcePort)) {
+		return false
+	}
+	if r.DestinationPorts != nil && (!hasPorts || !r.DestinationPorts.Contains(tuple.DestinationPort)) {
+		return false
+	}
+
+	if r.TCPFlagsMask != 0 {
+		if tuple.Protocol != common.ProtocolTCP || flags&r.TCPFlagsMask != r.TCPFlags {
+			return false
+		}
+	}
+	if r.States != 0 && r.States&state == 0 {
+		return false
+	}
+
+	return true
+}
+
+// chain is an ordered list of rules.
+type chain struct {
+	rules   []*Rule
+	policy  Action // Verdict when no rule matches (built-in chains only)
+	builtin bool
+}
+
+// Filter is a stateful packet filter.
+type Filter struct {
+	mu      sync.RWMutex
+	chains  map[string]*chain
+	tracker *ConnTracker
+}
+
+// New creates a filter whose built-in chains accept everything, with a
+// connection tracker using the default timeouts.
+func New() *Filter {
+	f := &Filter{
+		chains:  make(map[string]*chain),
+		tracker: NewConnTracker(DefaultTimeouts()),
+	}
+	for _, hook := range []Hook{HookInput, HookForward, HookOutput} {
+		f.chains[hook.String()] = &chain{policy: ActionAccept, builtin: true}
+	}
+	return f
+}
+
+// Tracker returns the filter's connection tracker.
+func (f *Filter) Tracker() *ConnTracker {
+	return f.tracker
+}
+
+// SetPolicy sets the verdict of a built-in chain for packets no rule decides.
+func (f *Filter) SetPolicy(hook Hook, policy Action) error {
+	if policy != ActionAccept && policy != ActionDrop {
+		return fmt.Errorf("invalid policy: %s", policy)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.chains[hook.String()]
+	if !ok {
+		return fmt.Errorf("unknown hook: %s", hook)
+	}
+	c.policy = policy
+	return nil
+}
+
+// NewChain creates a user-defined chain that rules can jump to.
+func (f *Filter) NewChain(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if name == "" {
+		return fmt.Errorf("chain name is required")
+	}
+	if _, exists := f.chains[name]; exists {
+		return fmt.Errorf("chain %s already exists", name)
+	}
+	f.chains[name] = &chain{policy: ActionReturn}
+	return nil
+}
+
+// DeleteChain removes an empty user-defined chain that no rule jumps to.
+func (f *Filter) DeleteChain(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.chains[name]
+	if !ok {
+		return fmt.Errorf("chain %s does not exist", name)
+	}
+	if c.builtin {
+		return fmt.Errorf("cannot delete built-in chain %s", name)
+	}
+	if len(c.rules) > 0 {
+		return fmt.Errorf("chain %s is not empty", name)
+	}
+	for other, oc := range f.chains {
+		for _, r := range oc.rules {
+			if r.Action == ActionJump && r.Target == name {
+				return fmt.Errorf("chain %s is referenced from %s", name, other)
+			}
+		}
+	}
+
+	delete(f.chains, name)
+	return nil
+}
+
+// AppendRule adds a rule to the end of a chain.
+func (f *Filter) AppendRule(chainName string, rule *Rule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.checkRule(chainName, rule)
+	if err != nil {
+		return err
+	}
+	c.rules = append(c.rules, rule)
+	return nil
+}
+
+// InsertRule adds a rule at a position in a chain (0 is the first rule).
+func (f *Filter) InsertRule(chainName string, index int, rule *Rule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.checkRule(chainName, rule)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index > len(c.rules) {
+		return fmt.Errorf("rule index %d out of range", index)
+	}
+
+	c.rules = append(c.rules, nil)
+	copy(c.rules[index+1:], c.rules[index:])
+	c.rules[index] = rule
+	return nil
+}
+
+// DeleteRule removes the rule at a position in a chain.
+func (f *Filter) DeleteRule(chainName string, index int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.chains[chainName]
+	if !ok {
+		return fmt.Errorf("chain %s does not exist", chainName)
+	}
+	if index < 0 || index >= len(c.rules) {
+		return fmt.Errorf("rule index %d out of range", index)
+	}
+
+	c.rules = append(c.rules[:index], c.rules[index+1:]...)
+	return nil
+}
+
+// Flush removes all rules from a chain.
+func (f *Filter) Flush(chainName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.chains[chainName]
+	if !ok {
+		return fmt.Errorf("chain %s does not exist", chainName)
+	}
+	c.rules = nil
+	return nil
+}
+
+// Rules returns the rules of a chain in evaluation order.
+func (f *Filter) Rules(chainName string) []*Rule {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	c, ok := f.chains[chainName]
+	if !ok {
+		return nil
+	}
+	return append([]*Rule(nil), c.rules...)
+}
+
+// checkRule validates a rule for a chain. Must be called with f.mu held.
+func (f *Filter) checkRule(chainName string, rule *Rule) (*chain, error) {
+	c, ok := f.chains[chainName]
+	if !ok {
+		return nil, fmt.Errorf("chain %s does not exist", chainName)
+	}
+	if rule.Action > ActionReturn {
+		return nil, fmt.Errorf("invalid action: %s", rule.Action)
+	}
+	if rule.Action == ActionJump {
+		if _, ok := f.chains[rule.Target]; !ok {
+			return nil, fmt.Errorf("jump target chain %s does not exist", rule.Target)
+		}
+		if f.chains[rule.Target].builtin {
+			return nil, fmt.Errorf("cannot jump to built-in chain %s", rule.Target)
+		}
+	}
+	if (rule.SourcePorts != nil || rule.DestinationPorts != nil) &&
+		rule.Protocol != common.ProtocolTCP && rule.Protocol != common.ProtocolUDP {
+		return nil, fmt.Errorf("port match requires protocol TCP or UDP")
+	}
+	return c, nil
+}
+
+// Check evaluates a packet against the chain for a hook and returns the
+// verdict. Accepted packets update the connection tracker.
+func (f *Filter) Check(hook Hook, pkt *Packet) Verdict {
+	lookup := f.tracker.lookup(pkt.IP)
+
+	flags := uint8(0)
+	if pkt.IP.Protocol == common.ProtocolTCP && len(pkt.IP.Payload) > 13 {
+		flags = pkt.IP.Payload[13]
+	}
+
+	f.mu.RLock()
+	action := f.evaluate(hook.String(), pkt, lookup.tuple, flags, lookup.state, 0)
+	f.mu.RUnlock()
+
+	switch action {
+	case ActionAccept:
+		f.tracker.confirm(lookup, pkt.IP)
+		return VerdictAccept
+	case ActionReject:
+		return VerdictReject
+	default:
+		return VerdictDrop
+	}
+}
+
+// evaluate runs a chain and returns the terminal action, or ActionReturn if
+// a user-defined chain ends without a decision. Must be called with f.mu held.
+func (f *Filter) evaluate(name string, pkt *Packet, tuple Tuple, flags uint8, state State, depth int) Action {
+	if depth > maxJumpDepth {
+		return ActionDrop
+	}
+
+	c := f.chains[name]
+	for _, r := range c.rules {
+		if !r.matches(pkt, tuple, flags, state) {
+			continue
+		}
+
+		r.packets.Add(1)
+		r.bytes.Add(uint64(ip.MinHeaderLength + len(pkt.IP.Options) + len(pkt.IP.Payload)))
+
+		switch r.Action {
+		case ActionJump:
+			if action := f.evaluate(r.Target, pkt, tuple, flags, state, depth+1); action != ActionReturn {
+				return action
+			}
+		case ActionReturn:
+			if c.builtin {
+				return c.policy
+			}
+			return ActionReturn
+		default:
+			return r.Action
+		}
+	}
+
+	return c.policy
+}
@@ -0,0 +1,293 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	lanHost = common.IPv4Address{192, 168, 1, 10}
+	wanHost = common.IPv4Address{198, 51, 100, 7}
+)
+
+// tcpPacket builds an IPv4 TCP packet.
+func tcpPacket(src, dst common.IPv4Address, srcPort, dstPort uint16, flags uint8) *ip.Packet {
+	seg := tcp.NewSegment(srcPort, dstPort, 1000, 2000, flags, 65535, nil)
+	data, _ := seg.Serialize()
+	return ip.NewPacket(src, dst, common.ProtocolTCP, data)
+}
+
+// udpPacket builds an IPv4 UDP packet.
+func udpPacket(src, dst common.IPv4Address, srcPort, dstPort uint16) *ip.Packet {
+	data, _ := udp.NewPacket(srcPort, dstPort, []byte("data")).Serialize()
+	return ip.NewPacket(src, dst, common.ProtocolUDP, data)
+}
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		inside  common.IPv4Address
+		outside common.IPv4Address
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8", common.IPv4Address{10, 255, 0, 1}, common.IPv4Address{11, 0, 0, 1}},
+		{"192.168.1.77/24", "192.168.1.0/24", common.IPv4Address{192, 168, 1, 1}, common.IPv4Address{192, 168, 2, 1}},
+		{"172.16.0.0/12", "172.16.0.0/12", common.IPv4Address{172, 31, 255, 255}, common.IPv4Address{172, 32, 0, 0}},
+		{"1.2.3.4", "1.2.3.4/32", common.IPv4Address{1, 2, 3, 4}, common.IPv4Address{1, 2, 3, 5}},
+		{"0.0.0.0/0", "0.0.0.0/0", common.IPv4Address{8, 8, 8, 8}, common.IPv4Address{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p, err := ParsePrefix(tt.input)
+			if err != nil {
+				t.Fatalf("ParsePrefix() error = %v", err)
+			}
+			if p.String() != tt.want {
+				t.Errorf("String() = %s, want %s", p, tt.want)
+			}
+			if !p.Contains(tt.inside) {
+				t.Errorf("Contains(%s) = false, want true", tt.inside)
+			}
+			if tt.want != "0.0.0.0/0" && p.Contains(tt.outside) {
+				t.Errorf("Contains(%s) = true, want false", tt.outside)
+			}
+		})
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "10.0.0/8", "10.0.0.0/x"} {
+		if _, err := ParsePrefix(bad); err == nil {
+			t.Errorf("ParsePrefix(%q) should return error", bad)
+		}
+	}
+}
+
+func TestRuleMatching(t *testing.T) {
+	synToWeb := &Packet{InInterface: "eth0", IP: tcpPacket(wanHost, lanHost, 40000, 80, tcp.FlagSYN)}
+
+	tests := []struct {
+		name string
+		rule *Rule
+		want bool
+	}{
+		{"empty rule", &Rule{}, true},
+		{"interface", &Rule{InInterface: "eth0"}, true},
+		{"wrong interface", &Rule{InInterface: "eth1"}, false},
+		{"out interface", &Rule{OutInterface: "eth1"}, false},
+		{"source prefix", &Rule{Source: MustParsePrefix("198.51.100.0/24")}, true},
+		{"wrong source", &Rule{Source: MustParsePrefix("10.0.0.0/8")}, false},
+		{"destination", &Rule{Destination: MustParsePrefix("192.168.1.10")}, true},
+		{"protocol", &Rule{Protocol: common.ProtocolTCP}, true},
+		{"wrong protocol", &Rule{Protocol: common.ProtocolUDP}, false},
+		{"destination port", &Rule{Protocol: common.ProtocolTCP, DestinationPorts: Port(80)}, true},
+		{"port range", &Rule{Protocol: common.ProtocolTCP, SourcePorts: &PortRange{Start: 32768, End: 60999}}, true},
+		{"wrong port", &Rule{Protocol: common.ProtocolTCP, DestinationPorts: Port(443)}, false},
+		{"SYN only", &Rule{TCPFlags: tcp.FlagSYN, TCPFlagsMask: tcp.FlagSYN | tcp.FlagACK}, true},
+		{"SYN-ACK", &Rule{TCPFlags: tcp.FlagSYN | tcp.FlagACK, TCPFlagsMask: tcp.FlagSYN | tcp.FlagACK}, false},
+		{"new state", &Rule{States: StateNew}, true},
+		{"established state", &Rule{States: StateEstablished | StateRelated}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New()
+			f.SetPolicy(HookInput, ActionDrop)
+			tt.rule.Action = ActionAccept
+			if err := f.AppendRule("INPUT", tt.rule); err != nil {
+				t.Fatalf("AppendRule() error = %v", err)
+			}
+
+			got := f.Check(HookInput, synToWeb) == VerdictAccept
+			if got != tt.want {
+				t.Errorf("rule %s matched = %v, want %v", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainOrderAndCounters(t *testing.T) {
+	f := New()
+
+	drop := &Rule{Protocol: common.ProtocolUDP, DestinationPorts: Port(53), Action: ActionDrop}
+	accept := &Rule{Protocol: common.ProtocolUDP, Action: ActionAccept}
+	f.AppendRule("OUTPUT", accept)
+
+	// Inserting at the front takes precedence
+	if err := f.InsertRule("OUTPUT", 0, drop); err != nil {
+		t.Fatalf("InsertRule() error = %v", err)
+	}
+
+	pkt := &Packet{OutInterface: "eth0", IP: udpPacket(lanHost, wanHost, 5000, 53)}
+	if v := f.Check(HookOutput, pkt); v != VerdictDrop {
+		t.Errorf("Check() = %s, want DROP", v)
+	}
+	if drop.Packets() != 1 || accept.Packets() != 0 {
+		t.Errorf("counters = %d/%d, want 1/0", drop.Packets(), accept.Packets())
+	}
+	if drop.Bytes() != uint64(ip.MinHeaderLength+udp.HeaderLength+4) {
+		t.Errorf("Bytes() = %d, want %d", drop.Bytes(), ip.MinHeaderLength+udp.HeaderLength+4)
+	}
+
+	if err := f.DeleteRule("OUTPUT", 0); err != nil {
+		t.Fatalf("DeleteRule() error = %v", err)
+	}
+	if v := f.Check(HookOutput, pkt); v != VerdictAccept {
+		t.Errorf("Check() after delete = %s, want ACCEPT", v)
+	}
+
+	f.Flush("OUTPUT")
+	if len(f.Rules("OUTPUT")) != 0 {
+		t.Errorf("Rules() = %d after flush, want 0", len(f.Rules("OUTPUT")))
+	}
+}
+
+func TestUserChains(t *testing.T) {
+	f := New()
+	f.SetPolicy(HookForward, ActionDrop)
+
+	if err := f.NewChain("web"); err != nil {
+		t.Fatalf("NewChain() error = %v", err)
+	}
+	f.AppendRule("web", &Rule{Source: MustParsePrefix("198.51.100.0/24"), Action: ActionReturn})
+	f.AppendRule("web", &Rule{Protocol: common.ProtocolTCP, DestinationPorts: Port(443), Action: ActionAccept})
+	f.AppendRule("web", &Rule{Protocol: common.ProtocolTCP, DestinationPorts: Port(80), Action: ActionReject})
+
+	if err := f.AppendRule("FORWARD", &Rule{Protocol: common.ProtocolTCP, Action: ActionJump, Target: "web"}); err != nil {
+		t.Fatalf("AppendRule() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		src  common.IPv4Address
+		port uint16
+		want Verdict
+	}{
+		{"accepted in chain", common.IPv4Address{203, 0, 113, 5}, 443, VerdictAccept},
+		{"rejected in chain", common.IPv4Address{203, 0, 113, 5}, 80, VerdictReject},
+		{"falls through to policy", common.IPv4Address{203, 0, 113, 5}, 22, VerdictDrop},
+		{"returned early", wanHost, 443, VerdictDrop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt := &Packet{IP: tcpPacket(tt.src, lanHost, 40000, tt.port, tcp.FlagSYN)}
+			if v := f.Check(HookForward, pkt); v != tt.want {
+				t.Errorf("Check() = %s, want %s", v, tt.want)
+			}
+		})
+	}
+
+	// Referenced chains cannot be deleted
+	if err := f.DeleteChain("web"); err == nil {
+		t.Error("DeleteChain() should reject a non-empty referenced chain")
+	}
+}
+
+func TestRuleValidation(t *testing.T) {
+	f := New()
+
+	tests := []struct {
+		name  string
+		chain string
+		rule  *Rule
+	}{
+		{"unknown chain", "nope", &Rule{}},
+		{"unknown jump target", "INPUT", &Rule{Action: ActionJump, Target: "nope"}},
+		{"jump to built-in", "INPUT", &Rule{Action: ActionJump, Target: "OUTPUT"}},
+		{"ports without protocol", "INPUT", &Rule{DestinationPorts: Port(22)}},
+		{"invalid action", "INPUT", &Rule{Action: Action(99)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := f.AppendRule(tt.chain, tt.rule); err == nil {
+				t.Error("AppendRule() should return error")
+			}
+		})
+	}
+
+	if err := f.SetPolicy(HookInput, ActionReject); err == nil {
+		t.Error("SetPolicy() should only allow ACCEPT or DROP")
+	}
+	if err := f.DeleteChain("INPUT"); err == nil {
+		t.Error("DeleteChain() should reject built-in chains")
+	}
+}
+
+func TestJumpLoop(t *testing.T) {
+	f := New()
+	f.NewChain("a")
+	f.NewChain("b")
+	f.AppendRule("a", &Rule{Action: ActionJump, Target: "b"})
+	f.AppendRule("b", &Rule{Action: ActionJump, Target: "a"})
+	f.AppendRule("INPUT", &Rule{Action: ActionJump, Target: "a"})
+
+	pkt := &Packet{IP: udpPacket(wanHost, lanHost, 1, 2)}
+	if v := f.Check(HookInput, pkt); v != VerdictDrop {
+		t.Errorf("Check() with jump loop = %s, want DROP", v)
+	}
+}
+
+func TestRejectReply(t *testing.T) {
+	// TCP SYN gets RST+ACK acknowledging the SYN
+	syn := tcpPacket(wanHost, lanHost, 40000, 23, tcp.FlagSYN)
+	reply, err := RejectReply(syn)
+	if err != nil {
+		t.Fatalf("RejectReply() error = %v", err)
+	}
+	if reply.Source != lanHost || reply.Destination != wanHost {
+		t.Errorf("reply %s -> %s, want %s -> %s", reply.Source, reply.Destination, lanHost, wanHost)
+	}
+	rst, err := tcp.Parse(reply.Payload)
+	if err != nil {
+		t.Fatalf("tcp.Parse() error = %v", err)
+	}
+	if rst.Flags != tcp.FlagRST|tcp.FlagACK || rst.AckNumber != 1001 || rst.SourcePort != 23 || rst.DestinationPort != 40000 {
+		t.Errorf("RST = %s, want RST|ACK ack=1001 23 -> 40000", rst)
+	}
+	if !rst.VerifyChecksum(reply.Source, reply.Destination) {
+		t.Error("RST checksum invalid")
+	}
+
+	// A segment with ACK gets a RST with SEQ=SEG.ACK
+	ack := tcpPacket(wanHost, lanHost, 40000, 23, tcp.FlagACK)
+	reply, _ = RejectReply(ack)
+	rst, _ = tcp.Parse(reply.Payload)
+	if rst.Flags != tcp.FlagRST || rst.SequenceNumber != 2000 {
+		t.Errorf("RST = %s, want RST seq=2000", rst)
+	}
+
+	// UDP gets ICMP port unreachable quoting the original header
+	u := udpPacket(wanHost, lanHost, 5000, 161)
+	reply, err = RejectReply(u)
+	if err != nil {
+		t.Fatalf("RejectReply() error = %v", err)
+	}
+	msg, err := icmp.Parse(reply.Payload)
+	if err != nil {
+		t.Fatalf("icmp.Parse() error = %v", err)
+	}
+	if msg.Type != icmp.TypeDestinationUnreachable || msg.Code != icmp.CodePortUnreachable {
+		t.Errorf("reply = %s, want port unreachable", msg)
+	}
+	if len(msg.Data) != ip.MinHeaderLength+8 {
+		t.Errorf("quoted %d bytes, want %d", len(msg.Data), ip.MinHeaderLength+8)
+	}
+
+	// No replies to RSTs, ICMP errors or multicast
+	noReply := []*ip.Packet{
+		tcpPacket(wanHost, lanHost, 1, 2, tcp.FlagRST),
+		reply,
+		udpPacket(wanHost, common.IPv4Address{224, 0, 0, 251}, 5353, 5353),
+	}
+	for i, pkt := range noReply {
+		if r, err := RejectReply(pkt); err != nil || r != nil {
+			t.Errorf("case %d: RejectReply() = %v, %v, want nil", i, r, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package filter
+
+import (
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+// RejectReply builds the packet to send back for a rejected packet: a TCP
+// RST for TCP segments, and an ICMP port or protocol unreachable otherwise.
+// Returns nil if no reply may be sent (ICMP errors, RSTs, non-first
+// fragments and multicast or broadcast destinations).
+func RejectReply(pkt *ip.Packet) (*ip.Packet, error) {
+	if pkt.FragmentOffset != 0 || pkt.Destination[0] >= 224 {
+		return nil, nil
+	}
+
+	switch pkt.Protocol {
+	case common.ProtocolTCP:
+		return rejectTCP(pkt)
+	case common.ProtocolUDP:
+		return rejectICMP(pkt, icmp.CodePortUnreachable)
+	case common.ProtocolICMP:
+		// Never answer an ICMP error with another (RFC 1122 3.2.2)
+		if isICMPError(pkt.Payload) {
+			return nil, nil
+		}
+		return rejectICMP(pkt, icmp.CodeProtocolUnreachable)
+	default:
+		return rejectICMP(pkt, icmp.CodeProtocolUnreachable)
+	}
+}
+
+// rejectTCP builds a RST for a segment as specified in RFC 793 (Reset Generation).
+func rejectTCP(pkt *ip.Packet) (*ip.Packet, error) {
+	var seg tcp.Segment
+	if err := seg.Decode(pkt.Payload); err != nil {
+		return nil, fmt.Errorf("failed to parse rejected segment: %w", err)
+	}
+	if seg.HasFlag(tcp.FlagRST) {
+		return nil, nil
+	}
+
+	var rst *tcp.Segment
+	if seg.HasFlag(tcp.FlagACK) {
+		// <SEQ=SEG.ACK><CTL=RST>
+		rst = tcp.NewSegment(seg.DestinationPort, seg.SourcePort, seg.AckNumber, 0, tcp.FlagRST, 0, nil)
+	} else {
+		// <SEQ=0><ACK=SEG.SEQ+SEG.LEN><CTL=RST,ACK>
+		segLen := uint32(len(seg.Data))
+		if seg.HasFlag(tcp.FlagSYN) {
+			segLen++
+		}
+		if seg.HasFlag(tcp.FlagFIN) {
+			segLen++
+		}
+		rst = tcp.NewSegment(seg.DestinationPort, seg.SourcePort, 0, seg.SequenceNumber+segLen, tcp.FlagRST|tcp.FlagACK, 0, nil)
+	}
+
+	checksum, err := rst.CalculateChecksum(pkt.Destination, pkt.Source)
+	if err != nil {
+		return nil, err
+	}
+	rst.Checksum = checksum
+
+	data, err := rst.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return ip.NewPacket(pkt.Destination, pkt.Source, common.ProtocolTCP, data), nil
+}
+
+// rejectICMP builds an ICMP destination unreachable quoting the IP header
+// and first 8 bytes of the rejected packet.
+func rejectICMP(pkt *ip.Packet, code icmp.Code) (*ip.Packet, error) {
+	original, err := pkt.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize rejected packet: %w", err)
+	}
+
+	quoteLen := int(pkt.IHL)*4 + 8
+	if quoteLen > len(original) {
+		quoteLen = len(original)
+	}
+
+	msg := icmp.NewDestinationUnreachable(code, original[:quoteLen])
+	data, err := msg.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return ip.NewPacket(pkt.Destination, pkt.Source, common.ProtocolICMP, data), nil
+}
@@ -0,0 +1,263 @@
+package flow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTemplateInterval is how often an Exporter resends its
+	// template, so that a collector started, or a message lost, after the
+	// first one can decode the records.
+	DefaultTemplateInterval = time.Minute
+
+	// DefaultMaxMessageSize is the largest export message an Exporter
+	// sends, which keeps its UDP datagrams from being fragmented.
+	DefaultMaxMessageSize = 1400
+
+	// templateID is the ID of the template describing the records; IDs
+	// below 256 name sets rather than templates.
+	templateID = 256
+)
+
+// Format is an export protocol.
+type Format uint8
+
+const (
+	FormatIPFIX     Format = iota // IPFIX, RFC 7011
+	FormatNetFlowV9               // NetFlow version 9, RFC 3954
+)
+
+// String returns the format name.
+func (f Format) String() string {
+	switch f {
+	case FormatIPFIX:
+		return "IPFIX"
+	case FormatNetFlowV9:
+		return "NetFlow v9"
+	default:
+		return fmt.Sprintf("Format(%d)", f)
+	}
+}
+
+// field is a template field: an information element and its length.
+type field struct {
+	id     uint16
+	length uint16
+}
+
+// The fields of the records, in order. Both formats share the information
+// element numbers of the addresses, ports, protocol, flags and counters;
+// NetFlow v9 has no end reason, and times flows in milliseconds since the
+// exporter started rather than since the epoch.
+var (
+	ipfixFields = []field{
+		{8, 4},   // sourceIPv4Address
+		{12, 4},  // destinationIPv4Address
+		{7, 2},   // sourceTransportPort
+		{11, 2},  // destinationTransportPort
+		{4, 1},   // protocolIdentifier
+		{6, 1},   // tcpControlBits, in reduced-size encoding
+		{2, 8},   // packetDeltaCount
+		{1, 8},   // octetDeltaCount
+		{152, 8}, // flowStartMilliseconds
+		{153, 8}, // flowEndMilliseconds
+		{136, 1}, // flowEndReason
+	}
+	netflowFields = []field{
+		{8, 4},  // IPV4_SRC_ADDR
+		{12, 4}, // IPV4_DST_ADDR
+		{7, 2},  // L4_SRC_PORT
+		{11, 2}, // L4_DST_PORT
+		{4, 1},  // PROTOCOL
+		{6, 1},  // TCP_FLAGS
+		{2, 8},  // IN_PKTS
+		{1, 8},  // IN_BYTES
+		{22, 4}, // FIRST_SWITCHED
+		{21, 4}, // LAST_SWITCHED
+	}
+)
+
+// ExporterConfig configures an Exporter.
+type ExporterConfig struct {
+	Format           Format        // Default FormatIPFIX
+	DomainID         uint32        // IPFIX observation domain ID, or NetFlow v9 source ID
+	TemplateInterval time.Duration // Default DefaultTemplateInterval
+	MaxMessageSize   int           // Default DefaultMaxMessageSize
+}
+
+// Exporter sends flow records to a collector over UDP, usually through a
+// udp.PacketConn over the stack.
+type Exporter struct {
+	conn      net.PacketConn
+	collector net.Addr
+	config    ExporterConfig
+	fields    []field
+	size      int // Of a record
+	now       func() time.Time
+	boot      time.Time // NetFlow v9 system uptime origin
+
+	mu           sync.Mutex
+	sequence     uint32    // Messages (NetFlow v9) or records (IPFIX) sent
+	templateSent time.Time // Zero until the template is first sent
+}
+
+// NewExporter creates an exporter sending to collector through conn.
+func NewExporter(conn net.PacketConn, collector net.Addr, config ExporterConfig) (*Exporter, error) {
+	if config.TemplateInterval == 0 {
+		config.TemplateInterval = DefaultTemplateInterval
+	}
+	if config.MaxMessageSize == 0 {
+		config.MaxMessageSize = DefaultMaxMessageSize
+	}
+
+	e := &Exporter{conn: conn, collector: collector, config: config, now: time.Now}
+	switch config.Format {
+	case FormatIPFIX:
+		e.fields = ipfixFields
+	case FormatNetFlowV9:
+		e.fields = netflowFields
+	default:
+		return nil, fmt.Errorf("unsupported export format: %v", config.Format)
+	}
+	for _, f := range e.fields {
+		e.size += int(f.length)
+	}
+	if config.TemplateInterval < 0 {
+		return nil, fmt.Errorf("invalid template interval: %v", config.TemplateInterval)
+	}
+	if config.MaxMessageSize < e.headerLen()+e.templateLen()+4+e.size {
+		return nil, fmt.Errorf("max message size %d too small for a record", config.MaxMessageSize)
+	}
+	e.boot = e.now()
+	return e, nil
+}
+
+// Export sends records to the collector, in as many messages as they
+// need. The first message carries the template when it is due.
+func (e *Exporter) Export(records []Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for len(records) > 0 {
+		now := e.now()
+		withTemplate := e.templateSent.IsZero() || now.Sub(e.templateSent) >= e.config.TemplateInterval
+
+		room := e.config.MaxMessageSize - e.headerLen() - 4
+		if withTemplate {
+			room -= e.templateLen()
+		}
+		n := min(len(records), room/e.size)
+
+		msg := e.message(now, records[:n], withTemplate)
+		if _, err := e.conn.WriteTo(msg, e.collector); err != nil {
+			return fmt.Errorf("failed to send flow export: %w", err)
+		}
+		if withTemplate {
+			e.templateSent = now
+		}
+		if e.config.Format == FormatIPFIX {
+			e.sequence += uint32(n)
+		} else {
+			e.sequence++
+		}
+		records = records[n:]
+	}
+	return nil
+}
+
+// headerLen returns the length of the message header.
+func (e *Exporter) headerLen() int {
+	if e.config.Format == FormatIPFIX {
+		return 16
+	}
+	return 20
+}
+
+// templateLen returns the length of the template set.
+func (e *Exporter) templateLen() int {
+	return 8 + 4*len(e.fields)
+}
+
+// message builds an export message. Called with e.mu held.
+func (e *Exporter) message(now time.Time, records []Record, withTemplate bool) []byte {
+	b := make([]byte, e.headerLen(), e.config.MaxMessageSize)
+
+	if withTemplate {
+		setID := uint16(2)
+		if e.config.Format == FormatNetFlowV9 {
+			setID = 0
+		}
+		b = binary.BigEndian.AppendUint16(b, setID)
+		b = binary.BigEndian.AppendUint16(b, uint16(e.templateLen()))
+		b = binary.BigEndian.AppendUint16(b, templateID)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(e.fields)))
+		for _, f := range e.fields {
+			b = binary.BigEndian.AppendUint16(b, f.id)
+			b = binary.BigEndian.AppendUint16(b, f.length)
+		}
+	}
+
+	set := len(b)
+	b = binary.BigEndian.AppendUint16(b, templateID)
+	b = binary.BigEndian.AppendUint16(b, 0) // Set length, below
+	for _, r := range records {
+		b = e.appendRecord(b, r)
+	}
+	for (len(b)-set)%4 != 0 {
+		b = append(b, 0)
+	}
+	binary.BigEndian.PutUint16(b[set+2:], uint16(len(b)-set))
+
+	if e.config.Format == FormatIPFIX {
+		binary.BigEndian.PutUint16(b[0:], 10)
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+		binary.BigEndian.PutUint32(b[4:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(b[8:], e.sequence)
+		binary.BigEndian.PutUint32(b[12:], e.config.DomainID)
+		return b
+	}
+
+	count := len(records)
+	if withTemplate {
+		count++
+	}
+	binary.BigEndian.PutUint16(b[0:], 9)
+	binary.BigEndian.PutUint16(b[2:], uint16(count))
+	binary.BigEndian.PutUint32(b[4:], e.uptime(now))
+	binary.BigEndian.PutUint32(b[8:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(b[12:], e.sequence)
+	binary.BigEndian.PutUint32(b[16:], e.config.DomainID)
+	return b
+}
+
+// appendRecord appends a data record in the exporter's format.
+func (e *Exporter) appendRecord(b []byte, r Record) []byte {
+	b = append(b, r.Source[:]...)
+	b = append(b, r.Destination[:]...)
+	b = binary.BigEndian.AppendUint16(b, r.SourcePort)
+	b = binary.BigEndian.AppendUint16(b, r.DestinationPort)
+	b = append(b, byte(r.Protocol), r.TCPFlags)
+	b = binary.BigEndian.AppendUint64(b, r.Packets)
+	b = binary.BigEndian.AppendUint64(b, r.Bytes)
+
+	if e.config.Format == FormatIPFIX {
+		b = binary.BigEndian.AppendUint64(b, uint64(r.Start.UnixMilli()))
+		b = binary.BigEndian.AppendUint64(b, uint64(r.End.UnixMilli()))
+		return append(b, byte(r.EndReason))
+	}
+	b = binary.BigEndian.AppendUint32(b, e.uptime(r.Start))
+	return binary.BigEndian.AppendUint32(b, e.uptime(r.End))
+}
+
+// uptime returns t in milliseconds since the exporter started, the NetFlow
+// v9 system uptime.
+func (e *Exporter) uptime(t time.Time) uint32 {
+	if t.Before(e.boot) {
+		return 0
+	}
+	return uint32(t.Sub(e.boot).Milliseconds())
+}
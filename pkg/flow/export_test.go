@@ -0,0 +1,236 @@
+package flow
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var collector = udp.Address{IP: common.IPv4Address{10, 0, 0, 9}, Port: 4739}
+
+// newTestExporter returns an exporter on a clock the test advances, and
+// the messages it sends.
+func newTestExporter(t *testing.T, config ExporterConfig, sendErr error) (*Exporter, *time.Time, *[][]byte) {
+	t.Helper()
+
+	sock := udp.NewSocket()
+	if err := sock.Bind(udp.Address{IP: hostA, Port: 40000}); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	var sent [][]byte
+	conn := udp.NewPacketConn(sock, func(pkt *udp.Packet, to udp.Address) error {
+		if to != collector {
+			t.Errorf("sent to %v, want %v", to, collector)
+		}
+		sent = append(sent, append([]byte(nil), pkt.Data...))
+		return sendErr
+	})
+	t.Cleanup(func() { conn.Close() })
+
+	e, err := NewExporter(conn, collector, config)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	clock := time.Unix(5000, 0)
+	e.now = func() time.Time { return clock }
+	e.boot = clock
+	return e, &clock, &sent
+}
+
+// testRecords returns n records of flows from hostA starting at start.
+func testRecords(n int, start time.Time) []Record {
+	records := make([]Record, n)
+	for i := range records {
+		records[i] = Record{
+			Key:       Key{common.ProtocolTCP, hostA, hostB, uint16(1000 + i), 80},
+			Packets:   10,
+			Bytes:     1500,
+			Start:     start,
+			End:       start.Add(2 * time.Second),
+			TCPFlags:  0x1b,
+			EndReason: EndOfFlow,
+		}
+	}
+	return records
+}
+
+// exportSet is a set of an export message.
+type exportSet struct {
+	id   uint16
+	body []byte
+}
+
+// parseSets splits the sets after a message header.
+func parseSets(t *testing.T, msg []byte, headerLen int) []exportSet {
+	t.Helper()
+	var sets []exportSet
+	for b := msg[headerLen:]; len(b) > 0; {
+		length := int(binary.BigEndian.Uint16(b[2:4]))
+		if length < 4 || length > len(b) {
+			t.Fatalf("set length %d, %d bytes left", length, len(b))
+		}
+		sets = append(sets, exportSet{binary.BigEndian.Uint16(b[0:2]), b[4:length]})
+		b = b[length:]
+	}
+	return sets
+}
+
+func TestExportIPFIX(t *testing.T) {
+	e, clock, sent := newTestExporter(t, ExporterConfig{DomainID: 42}, nil)
+	records := testRecords(2, clock.Add(-3*time.Second))
+
+	if err := e.Export(records); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if len(*sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(*sent))
+	}
+	msg := (*sent)[0]
+
+	header := []uint32{
+		uint32(binary.BigEndian.Uint16(msg[0:2])),
+		uint32(binary.BigEndian.Uint16(msg[2:4])),
+		binary.BigEndian.Uint32(msg[4:8]),
+		binary.BigEndian.Uint32(msg[8:12]),
+		binary.BigEndian.Uint32(msg[12:16]),
+	}
+	want := []uint32{10, uint32(len(msg)), 5000, 0, 42}
+	for i := range want {
+		if header[i] != want[i] {
+			t.Errorf("header = %v, want %v", header, want)
+			break
+		}
+	}
+
+	sets := parseSets(t, msg, 16)
+	if len(sets) != 2 || sets[0].id != 2 || sets[1].id != templateID {
+		t.Fatalf("sets %v, want a template and a data set", sets)
+	}
+	tmpl := sets[0].body
+	if id, count := binary.BigEndian.Uint16(tmpl[0:2]), binary.BigEndian.Uint16(tmpl[2:4]); id != templateID || int(count) != len(ipfixFields) {
+		t.Errorf("template %d with %d fields, want %d with %d", id, count, templateID, len(ipfixFields))
+	}
+
+	data := sets[1].body
+	if len(data) < 2*e.size {
+		t.Fatalf("data set of %d bytes, want 2 records of %d", len(data), e.size)
+	}
+	r := data[e.size : 2*e.size]
+	if port := binary.BigEndian.Uint16(r[8:10]); port != 1001 {
+		t.Errorf("source port = %d, want 1001", port)
+	}
+	if packets, bytes := binary.BigEndian.Uint64(r[14:22]), binary.BigEndian.Uint64(r[22:30]); packets != 10 || bytes != 1500 {
+		t.Errorf("counters = %d packets, %d bytes, want 10, 1500", packets, bytes)
+	}
+	if start, end := binary.BigEndian.Uint64(r[30:38]), binary.BigEndian.Uint64(r[38:46]); start != 4997000 || end != 4999000 {
+		t.Errorf("times = %d, %d, want 4997000, 4999000", start, end)
+	}
+	if reason := EndReason(r[46]); reason != EndOfFlow {
+		t.Errorf("end reason = %v, want %v", reason, EndOfFlow)
+	}
+
+	// The sequence number counts records; the template is not resent
+	if err := e.Export(records[:1]); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	msg = (*sent)[1]
+	if seq := binary.BigEndian.Uint32(msg[8:12]); seq != 2 {
+		t.Errorf("sequence = %d, want 2", seq)
+	}
+	if sets := parseSets(t, msg, 16); len(sets) != 1 {
+		t.Errorf("%d sets, want the data set only", len(sets))
+	}
+}
+
+func TestExportNetFlowV9(t *testing.T) {
+	e, clock, sent := newTestExporter(t, ExporterConfig{Format: FormatNetFlowV9, DomainID: 7}, nil)
+	*clock = clock.Add(10 * time.Second)
+	records := testRecords(3, clock.Add(-3*time.Second))
+
+	if err := e.Export(records); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	msg := (*sent)[0]
+	if version, count := binary.BigEndian.Uint16(msg[0:2]), binary.BigEndian.Uint16(msg[2:4]); version != 9 || count != 4 {
+		t.Errorf("version %d with %d records, want 9 with 4", version, count)
+	}
+	if uptime, seq, source := binary.BigEndian.Uint32(msg[4:8]), binary.BigEndian.Uint32(msg[12:16]), binary.BigEndian.Uint32(msg[16:20]); uptime != 10000 || seq != 0 || source != 7 {
+		t.Errorf("uptime %d, sequence %d, source %d, want 10000, 0, 7", uptime, seq, source)
+	}
+
+	sets := parseSets(t, msg, 20)
+	if len(sets) != 2 || sets[0].id != 0 || sets[1].id != templateID {
+		t.Fatalf("sets %v, want a template and a data set", sets)
+	}
+	if len(sets[1].body)%4 != 0 {
+		t.Errorf("data set body of %d bytes, want padding to 4", len(sets[1].body))
+	}
+	r := sets[1].body[:e.size]
+	if first, last := binary.BigEndian.Uint32(r[30:34]), binary.BigEndian.Uint32(r[34:38]); first != 7000 || last != 9000 {
+		t.Errorf("switched = %d, %d, want 7000, 9000", first, last)
+	}
+
+	// The sequence number counts messages
+	e.Export(records)
+	if seq := binary.BigEndian.Uint32((*sent)[1][12:16]); seq != 1 {
+		t.Errorf("sequence = %d, want 1", seq)
+	}
+}
+
+func TestExportSplit(t *testing.T) {
+	e, clock, sent := newTestExporter(t, ExporterConfig{MaxMessageSize: 256, TemplateInterval: time.Minute}, nil)
+	records := testRecords(10, *clock)
+
+	if err := e.Export(records); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	total := 0
+	for i, msg := range *sent {
+		if len(msg) > 256 {
+			t.Errorf("message %d of %d bytes, want at most 256", i, len(msg))
+		}
+		sets := parseSets(t, msg, 16)
+		if withTemplate := len(sets) == 2; withTemplate != (i == 0) {
+			t.Errorf("message %d has template = %v", i, withTemplate)
+		}
+		total += len(sets[len(sets)-1].body) / e.size
+	}
+	if total != 10 {
+		t.Errorf("exported %d records, want 10", total)
+	}
+
+	// The template is resent once the interval passes
+	*clock = clock.Add(time.Minute)
+	e.Export(records[:1])
+	if sets := parseSets(t, (*sent)[len(*sent)-1], 16); len(sets) != 2 {
+		t.Errorf("%d sets after the template interval, want 2", len(sets))
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	sendErr := errors.New("link down")
+	e, _, _ := newTestExporter(t, ExporterConfig{}, sendErr)
+	if err := e.Export(testRecords(1, time.Now())); !errors.Is(err, sendErr) {
+		t.Errorf("Export() error = %v, want %v", err, sendErr)
+	}
+
+	tests := []struct {
+		name   string
+		config ExporterConfig
+	}{
+		{"unknown format", ExporterConfig{Format: 9}},
+		{"negative template interval", ExporterConfig{TemplateInterval: -time.Second}},
+		{"message too small", ExporterConfig{MaxMessageSize: 64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewExporter(nil, collector, tt.config); err == nil {
+				t.Error("NewExporter() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+// Package flow tracks the IPv4 flows passing through the stack and exports
+// them as flow records.
+//
+// A flow is the packets sharing a 5-tuple in one direction, as NetFlow and
+// IPFIX (RFC 7011) count them: each direction of a connection is a flow of
+// its own. A Table counts the packets and bytes of each flow from a hook on
+// the capture or forwarding path, and expires flows that go idle, run too
+// long or end, handing their records to an Exporter:
+//
+//	table, _ := flow.NewTable(flow.Config{})
+//	exporter, _ := flow.NewExporter(conn, collector, flow.ExporterConfig{})
+//	table.OnExpire(func(records []flow.Record) { exporter.Export(records) })
+//	p.Register(hook.IPRx, 0, "flow", table.Hook)
+//	stop := table.StartExpiry(time.Second)
+package flow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+const (
+	// DefaultIdleTimeout is how long a flow is kept without packets.
+	DefaultIdleTimeout = 15 * time.Second
+
+	// DefaultActiveTimeout is how long a flow is counted before its record
+	// is exported and counting starts over, so long connections are
+	// reported while they last.
+	DefaultActiveTimeout = 30 * time.Minute
+
+	// DefaultMaxFlows is how many flows a Table tracks at once.
+	DefaultMaxFlows = 65536
+)
+
+// EndReason is why a flow record was exported, with the values of the
+// IPFIX flowEndReason information element.
+type EndReason uint8
+
+const (
+	EndIdleTimeout   EndReason = 1 // No packets for the idle timeout
+	EndActiveTimeout EndReason = 2 // Counted for the active timeout
+	EndOfFlow        EndReason = 3 // TCP FIN or RST seen
+	EndForced        EndReason = 4 // Table flushed
+	EndLackResources EndReason = 5 // Evicted to make room for a new flow
+)
+
+// String returns the reason name.
+func (r EndReason) String() string {
+	switch r {
+	case EndIdleTimeout:
+		return "idle timeout"
+	case EndActiveTimeout:
+		return "active timeout"
+	case EndOfFlow:
+		return "end of flow"
+	case EndForced:
+		return "forced end"
+	case EndLackResources:
+		return "lack of resources"
+	default:
+		return fmt.Sprintf("EndReason(%d)", r)
+	}
+}
+
+// Key identifies a flow.
+type Key struct {
+	Protocol        common.Protocol
+	Source          common.IPv4Address
+	Destination     common.IPv4Address
+	SourcePort      uint16 // TCP/UDP source port
+	DestinationPort uint16 // TCP/UDP destination port, or ICMP type and code
+}
+
+// String returns a human-readable representation of the key.
+func (k Key) String() string {
+	return fmt.Sprintf("%s %s:%d -> %s:%d", k.Protocol, k.Source, k.SourcePort, k.Destination, k.DestinationPort)
+}
+
+// Record is the state of a flow.
+type Record struct {
+	Key
+	Packets   uint64
+	Bytes     uint64    // IP bytes, headers included
+	Start     time.Time // First packet
+	End       time.Time // Last packet
+	TCPFlags  uint8     // Union of the TCP flags seen, the flow's TCP state as NetFlow reports it
+	EndReason EndReason // Why the record was exported; zero for an active flow
+}
+
+// Config configures a Table.
+type Config struct {
+	IdleTimeout   time.Duration // Default DefaultIdleTimeout
+	ActiveTimeout time.Duration // Default DefaultActiveTimeout
+	MaxFlows      int           // Default DefaultMaxFlows
+}
+
+// Stats holds the counters of a Table.
+type Stats struct {
+	Packets  uint64 // Packets counted
+	Flows    uint64 // Flows created
+	Exported uint64 // Records handed to the expiry callback
+	Evicted  uint64 // Flows expired early to make room
+}
+
+// Table tracks flows.
+type Table struct {
+	config Config
+	now    func() time.Time
+
+	mu       sync.Mutex
+	flows    map[Key]*Record
+	onExpire func([]Record)
+	stats    Stats
+}
+
+// NewTable creates a flow table.
+func NewTable(config Config) (*Table, error) {
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+	if config.ActiveTimeout == 0 {
+		config.ActiveTimeout = DefaultActiveTimeout
+	}
+	if config.MaxFlows == 0 {
+		config.MaxFlows = DefaultMaxFlows
+	}
+	if config.IdleTimeout < 0 || config.ActiveTimeout < 0 {
+		return nil, fmt.Errorf("invalid flow timeouts: idle %v, active %v", config.IdleTimeout, config.ActiveTimeout)
+	}
+	if config.MaxFlows < 0 {
+		return nil, fmt.Errorf("invalid max flows: %d", config.MaxFlows)
+	}
+
+	return &Table{
+		config: config,
+		now:    time.Now,
+		flows:  make(map[Key]*Record),
+	}, nil
+}
+
+// OnExpire registers a callback invoked with the records of expired flows.
+// It is called without the table's lock held, from Observe when a flow is
+// evicted and from Expire and Flush otherwise.
+func (t *Table) OnExpire(f func([]Record)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onExpire = f
+}
+
+// Hook is a hook counting the IPv4 packets passing its point. It always
+// returns Continue.
+func (t *Table) Hook(pkt *hook.Packet) hook.Verdict {
+	if pkt.IP != nil {
+		t.Observe(pkt.IP)
+	}
+	return hook.Continue
+}
+
+// Observe counts a packet in its flow, creating the flow if needed.
+func (t *Table) Observe(pkt *ip.Packet) {
+	key, flags := keyOf(pkt)
+	now := t.now()
+	size := uint64(ip.MinHeaderLength + len(pkt.Options) + len(pkt.Payload))
+
+	t.mu.Lock()
+	var expired []Record
+	r := t.flows[key]
+	if r != nil {
+		// A timed out flow starts over. One ended by a FIN keeps counting
+		// the last ACKs until the next Expire.
+		if reason := t.reason(r, now); reason == EndIdleTimeout || reason == EndActiveTimeout {
+			expired = append(expired, t.remove(r, reason))
+			r = nil
+		}
+	}
+	if r == nil {
+		if len(t.flows) >= t.config.MaxFlows {
+			if oldest := t.oldest(); oldest != nil {
+				expired = append(expired, t.remove(oldest, EndLackResources))
+				t.stats.Evicted++
+			}
+		}
+		r = &Record{Key: key, Start: now}
+		t.flows[key] = r
+		t.stats.Flows++
+	}
+	r.Packets++
+	r.Bytes += size
+	r.End = now
+	r.TCPFlags |= flags
+	t.stats.Packets++
+	onExpire := t.export(expired)
+	t.mu.Unlock()
+
+	if onExpire != nil {
+		onExpire(expired)
+	}
+}
+
+// Expire removes the flows idle past the idle timeout, counted for the
+// active timeout, or ended by a FIN or RST, and hands their records to the
+// expiry callback. Returns the number of flows removed.
+func (t *Table) Expire() int {
+	t.mu.Lock()
+	now := t.now()
+	var expired []Record
+	for _, r := range t.flows {
+		if reason := t.reason(r, now); reason != 0 {
+			expired = append(expired, t.remove(r, reason))
+		}
+	}
+	sortRecords(expired)
+	onExpire := t.export(expired)
+	t.mu.Unlock()
+
+	if onExpire != nil {
+		onExpire(expired)
+	}
+	return len(expired)
+}
+
+// StartExpiry starts a goroutine that periodically expires flows.
+// Returns a channel that can be closed to stop the routine.
+func (t *Table) StartExpiry(interval time.Duration) chan<- struct{} {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.Expire()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// Flush removes every flow, handing their records to the expiry callback.
+// Returns the number of flows removed.
+func (t *Table) Flush() int {
+	t.mu.Lock()
+	expired := make([]Record, 0, len(t.flows))
+	for _, r := range t.flows {
+		expired = append(expired, t.remove(r, EndForced))
+	}
+	sortRecords(expired)
+	onExpire := t.export(expired)
+	t.mu.Unlock()
+
+	if onExpire != nil {
+		onExpire(expired)
+	}
+	return len(expired)
+}
+
+// Len returns the number of tracked flows.
+func (t *Table) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.flows)
+}
+
+// Flows returns a snapshot of the tracked flows ordered by key.
+func (t *Table) Flows() []Record {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	records := make([]Record, 0, len(t.flows))
+	for _, r := range t.flows {
+		records = append(records, *r)
+	}
+	sortRecords(records)
+	return records
+}
+
+// Stats returns the table's counters.
+func (t *Table) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// reason returns why a flow should be expired, or zero if it should not.
+// Called with t.mu held.
+func (t *Table) reason(r *Record, now time.Time) EndReason {
+	switch {
+	case now.Sub(r.End) >= t.config.IdleTimeout:
+		return EndIdleTimeout
+	case now.Sub(r.Start) >= t.config.ActiveTimeout:
+		return EndActiveTimeout
+	case r.TCPFlags&(tcp.FlagFIN|tcp.FlagRST) != 0:
+		return EndOfFlow
+	default:
+		return 0
+	}
+}
+
+// remove removes a flow, returning its record. Called with t.mu held.
+func (t *Table) remove(r *Record, reason EndReason) Record {
+	delete(t.flows, r.Key)
+	record := *r
+	record.EndReason = reason
+	return record
+}
+
+// oldest returns the flow that saw a packet least recently. Called with
+// t.mu held.
+func (t *Table) oldest() *Record {
+	var oldest *Record
+	for _, r := range t.flows {
+		if oldest == nil || r.End.Before(oldest.End) {
+			oldest = r
+		}
+	}
+	return oldest
+}
+
+// export counts the expired records, returning the callback to hand them
+// to, if any. Called with t.mu held.
+func (t *Table) export(records []Record) func([]Record) {
+	if len(records) == 0 {
+		return nil
+	}
+	t.stats.Exported += uint64(len(records))
+	return t.onExpire
+}
+
+// keyOf returns the flow key and TCP flags of a packet. Fragments after the
+// first carry no transport header, and are keyed without ports.
+func keyOf(pkt *ip.Packet) (Key, uint8) {
+	key := Key{Protocol: pkt.Protocol, Source: pkt.Source, Destination: pkt.Destination}
+	if pkt.FragmentOffset != 0 {
+		return key, 0
+	}
+
+	th := pkt.Payload
+	switch pkt.Protocol {
+	case common.ProtocolTCP, common.ProtocolUDP:
+		if len(th) < 4 {
+			return key, 0
+		}
+		key.SourcePort = binary.BigEndian.Uint16(th[0:2])
+		key.DestinationPort = binary.BigEndian.Uint16(th[2:4])
+		if pkt.Protocol == common.ProtocolTCP && len(th) >= tcp.MinHeaderLength {
+			return key, th[13]
+		}
+	case common.ProtocolICMP:
+		if len(th) >= icmp.MinHeaderLength {
+			key.DestinationPort = binary.BigEndian.Uint16(th[0:2])
+		}
+	}
+	return key, 0
+}
+
+// sortRecords orders records by key.
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key.String() < records[j].Key.String()
+	})
+}
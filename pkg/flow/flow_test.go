@@ -0,0 +1,218 @@
+package flow
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+var (
+	hostA = common.IPv4Address{10, 0, 0, 1}
+	hostB = common.IPv4Address{10, 0, 0, 2}
+)
+
+// tcpPacket returns a TCP packet from hostA:sport to hostB:80 with flags
+// and size bytes of data.
+func tcpPacket(sport uint16, flags uint8, size int) *ip.Packet {
+	th := make([]byte, tcp.MinHeaderLength+size)
+	binary.BigEndian.PutUint16(th[0:2], sport)
+	binary.BigEndian.PutUint16(th[2:4], 80)
+	th[12] = 5 << 4
+	th[13] = flags
+	return ip.NewPacket(hostA, hostB, common.ProtocolTCP, th)
+}
+
+// newTestTable returns a table on a clock the test advances.
+func newTestTable(t *testing.T, config Config) (*Table, *time.Time, *[]Record) {
+	t.Helper()
+	table, err := NewTable(config)
+	if err != nil {
+		t.Fatalf("NewTable() error = %v", err)
+	}
+	clock := time.Unix(1000, 0)
+	table.now = func() time.Time { return clock }
+	var expired []Record
+	table.OnExpire(func(records []Record) { expired = append(expired, records...) })
+	return table, &clock, &expired
+}
+
+func TestKeyOf(t *testing.T) {
+	unreachable := make([]byte, icmp.MinHeaderLength)
+	unreachable[0], unreachable[1] = byte(icmp.TypeDestinationUnreachable), 3
+	fragment := tcpPacket(1234, tcp.FlagACK, 0)
+	fragment.FragmentOffset = 10
+
+	tests := []struct {
+		name      string
+		pkt       *ip.Packet
+		wantKey   Key
+		wantFlags uint8
+	}{
+		{"tcp", tcpPacket(1234, tcp.FlagSYN, 0), Key{common.ProtocolTCP, hostA, hostB, 1234, 80}, tcp.FlagSYN},
+		{"udp", ip.NewPacket(hostA, hostB, common.ProtocolUDP, []byte{0, 53, 0x30, 0x39, 0, 8, 0, 0}), Key{common.ProtocolUDP, hostA, hostB, 53, 12345}, 0},
+		{"icmp", ip.NewPacket(hostA, hostB, common.ProtocolICMP, unreachable), Key{common.ProtocolICMP, hostA, hostB, 0, 3<<8 | 3}, 0},
+		{"fragment", fragment, Key{common.ProtocolTCP, hostA, hostB, 0, 0}, 0},
+		{"truncated", ip.NewPacket(hostA, hostB, common.ProtocolUDP, []byte{0}), Key{common.ProtocolUDP, hostA, hostB, 0, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, flags := keyOf(tt.pkt)
+			if key != tt.wantKey || flags != tt.wantFlags {
+				t.Errorf("keyOf() = %v, %#x, want %v, %#x", key, flags, tt.wantKey, tt.wantFlags)
+			}
+		})
+	}
+}
+
+func TestTableObserve(t *testing.T) {
+	table, clock, _ := newTestTable(t, Config{})
+
+	table.Observe(tcpPacket(1234, tcp.FlagSYN, 0))
+	*clock = clock.Add(time.Second)
+	table.Observe(tcpPacket(1234, tcp.FlagACK|tcp.FlagPSH, 100))
+	table.Observe(tcpPacket(5678, tcp.FlagSYN, 0))
+
+	records := table.Flows()
+	if len(records) != 2 {
+		t.Fatalf("Flows() = %d records, want 2", len(records))
+	}
+	want := Record{
+		Key:      Key{common.ProtocolTCP, hostA, hostB, 1234, 80},
+		Packets:  2,
+		Bytes:    2*(ip.MinHeaderLength+tcp.MinHeaderLength) + 100,
+		Start:    time.Unix(1000, 0),
+		End:      time.Unix(1001, 0),
+		TCPFlags: tcp.FlagSYN | tcp.FlagACK | tcp.FlagPSH,
+	}
+	if !reflect.DeepEqual(records[0], want) {
+		t.Errorf("Flows()[0] = %+v, want %+v", records[0], want)
+	}
+
+	wantStats := Stats{Packets: 3, Flows: 2}
+	if got := table.Stats(); got != wantStats {
+		t.Errorf("Stats() = %+v, want %+v", got, wantStats)
+	}
+}
+
+func TestTableExpire(t *testing.T) {
+	tests := []struct {
+		name       string
+		flags      uint8
+		advance    time.Duration // After each packet
+		packets    int
+		wantReason EndReason
+	}{
+		{"idle", tcp.FlagACK, 0, 1, EndIdleTimeout},
+		{"active", tcp.FlagACK, time.Second, 8, EndActiveTimeout},
+		{"fin", tcp.FlagFIN | tcp.FlagACK, 0, 1, EndOfFlow},
+		{"rst", tcp.FlagRST, 0, 1, EndOfFlow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, clock, expired := newTestTable(t, Config{IdleTimeout: 5 * time.Second, ActiveTimeout: 8 * time.Second})
+			for i := 0; i < tt.packets; i++ {
+				table.Observe(tcpPacket(1234, tt.flags, 0))
+				*clock = clock.Add(tt.advance)
+			}
+			if tt.wantReason == EndIdleTimeout {
+				if n := table.Expire(); n != 0 {
+					t.Fatalf("Expire() before the timeout = %d, want 0", n)
+				}
+				*clock = clock.Add(5 * time.Second)
+			}
+
+			if n := table.Expire(); n != 1 {
+				t.Fatalf("Expire() = %d, want 1", n)
+			}
+			if len(*expired) != 1 || (*expired)[0].EndReason != tt.wantReason {
+				t.Fatalf("expired %+v, want one record ended by %v", *expired, tt.wantReason)
+			}
+			if table.Len() != 0 {
+				t.Errorf("Len() = %d, want 0", table.Len())
+			}
+		})
+	}
+}
+
+func TestTableRestart(t *testing.T) {
+	table, clock, expired := newTestTable(t, Config{IdleTimeout: 5 * time.Second})
+
+	table.Observe(tcpPacket(1234, tcp.FlagFIN|tcp.FlagACK, 0))
+	table.Observe(tcpPacket(1234, tcp.FlagACK, 0)) // Still the ended flow
+	*clock = clock.Add(5 * time.Second)
+	table.Observe(tcpPacket(1234, tcp.FlagSYN, 0)) // Starts it over
+
+	if len(*expired) != 1 || (*expired)[0].Packets != 2 || (*expired)[0].EndReason != EndIdleTimeout {
+		t.Fatalf("expired %+v, want the first two packets", *expired)
+	}
+	if records := table.Flows(); len(records) != 1 || records[0].Packets != 1 || records[0].TCPFlags != tcp.FlagSYN {
+		t.Errorf("Flows() = %+v, want the new SYN only", records)
+	}
+}
+
+func TestTableEvict(t *testing.T) {
+	table, clock, expired := newTestTable(t, Config{MaxFlows: 2})
+
+	for _, port := range []uint16{1, 2, 1, 3} { // Port 2 is the oldest when 3 arrives
+		table.Observe(tcpPacket(port, tcp.FlagACK, 0))
+		*clock = clock.Add(time.Millisecond)
+	}
+
+	if len(*expired) != 1 || (*expired)[0].SourcePort != 2 || (*expired)[0].EndReason != EndLackResources {
+		t.Fatalf("expired %+v, want port 2 evicted", *expired)
+	}
+	if got := table.Stats().Evicted; got != 1 {
+		t.Errorf("Evicted = %d, want 1", got)
+	}
+
+	if n := table.Flush(); n != 2 {
+		t.Errorf("Flush() = %d, want 2", n)
+	}
+	if len(*expired) != 3 || (*expired)[1].EndReason != EndForced {
+		t.Errorf("expired %+v, want two flows flushed", *expired)
+	}
+}
+
+func TestTableHook(t *testing.T) {
+	table, _, _ := newTestTable(t, Config{})
+	p := hook.NewPipeline()
+	if _, err := p.Register(hook.IPRx, 0, "flow", table.Hook); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	if v := p.Run(hook.IPRx, &hook.Packet{IP: tcpPacket(1234, tcp.FlagSYN, 0)}); v != hook.Continue {
+		t.Errorf("Run() = %s, want CONTINUE", v)
+	}
+	p.Run(hook.IPRx, &hook.Packet{})
+	if table.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", table.Len())
+	}
+}
+
+func TestNewTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"defaults", Config{}, false},
+		{"negative idle timeout", Config{IdleTimeout: -time.Second}, true},
+		{"negative active timeout", Config{ActiveTimeout: -time.Second}, true},
+		{"negative max flows", Config{MaxFlows: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTable(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
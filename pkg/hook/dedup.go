@@ -0,0 +1,167 @@
+package hook
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultDedupWindow is how long a Deduplicator remembers a packet.
+	// Mirrored copies of a frame arrive well within it.
+	DefaultDedupWindow = 50 * time.Millisecond
+
+	// DefaultDedupEntries is how many packets a Deduplicator remembers.
+	DefaultDedupEntries = 4096
+
+	// dedupPayloadBytes is how much of the payload is hashed: enough for
+	// the ports and sequence number of a TCP segment, which tell apart
+	// packets whose IP identification wrapped.
+	dedupPayloadBytes = 16
+)
+
+// DedupConfig configures a Deduplicator.
+type DedupConfig struct {
+	Window     time.Duration // How long a packet is remembered (default DefaultDedupWindow)
+	MaxEntries int           // Packets remembered at once (default DefaultDedupEntries)
+}
+
+// DedupStats holds the counters of a Deduplicator.
+type DedupStats struct {
+	Packets    uint64 // IPv4 packets checked
+	Duplicates uint64 // Packets dropped as duplicates
+	Evicted    uint64 // Packets forgotten before their window ended, for lack of room
+}
+
+// Deduplicator drops IPv4 packets seen twice within a short window, as a
+// port mirroring both directions of a link, or two taps, deliver them. Its
+// Check method is a hook, registered at ip-rx ahead of the hooks and
+// stages that count packets:
+//
+//	d, _ := hook.NewDeduplicator(hook.DedupConfig{})
+//	p.Register(hook.IPRx, -2000, "dedup", d.Check)
+//
+// Packets are compared by a hash of the header fields a copy shares with
+// the original (addresses, protocol, identification, fragment offset and
+// length, but not the TTL or checksum, which a router in between changes)
+// and the start of the payload.
+type Deduplicator struct {
+	config DedupConfig
+	now    func() time.Time
+
+	mu   sync.Mutex
+	seen map[uint64]time.Time // Hash -> when last seen
+	ring []dedupEntry         // Hashes in arrival order, oldest at head
+	head int
+	size int
+
+	stats struct {
+		packets    atomic.Uint64
+		duplicates atomic.Uint64
+		evicted    atomic.Uint64
+	}
+}
+
+// dedupEntry is a packet in a Deduplicator's arrival order.
+type dedupEntry struct {
+	hash uint64
+	at   time.Time
+}
+
+// NewDeduplicator creates a deduplicator.
+func NewDeduplicator(config DedupConfig) (*Deduplicator, error) {
+	if config.Window == 0 {
+		config.Window = DefaultDedupWindow
+	}
+	if config.MaxEntries == 0 {
+		config.MaxEntries = DefaultDedupEntries
+	}
+	if config.Window < 0 {
+		return nil, fmt.Errorf("invalid dedup window: %v", config.Window)
+	}
+	if config.MaxEntries < 0 {
+		return nil, fmt.Errorf("invalid dedup entries: %d", config.MaxEntries)
+	}
+
+	return &Deduplicator{
+		config: config,
+		now:    time.Now,
+		seen:   make(map[uint64]time.Time, config.MaxEntries),
+		ring:   make([]dedupEntry, config.MaxEntries),
+	}, nil
+}
+
+// Check drops a packet seen within the window, and remembers the others.
+// Packets without an IPv4 packet continue.
+func (d *Deduplicator) Check(pkt *Packet) Verdict {
+	if pkt.IP == nil {
+		return Continue
+	}
+	d.stats.packets.Add(1)
+	hash := dedupHash(pkt)
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(now)
+	if _, ok := d.seen[hash]; ok {
+		d.stats.duplicates.Add(1)
+		return Drop
+	}
+
+	if d.size == len(d.ring) {
+		d.pop()
+		d.stats.evicted.Add(1)
+	}
+	d.ring[(d.head+d.size)%len(d.ring)] = dedupEntry{hash, now}
+	d.size++
+	d.seen[hash] = now
+	return Continue
+}
+
+// Stats returns the deduplicator's counters.
+func (d *Deduplicator) Stats() DedupStats {
+	return DedupStats{
+		Packets:    d.stats.packets.Load(),
+		Duplicates: d.stats.duplicates.Load(),
+		Evicted:    d.stats.evicted.Load(),
+	}
+}
+
+// expire forgets the packets seen before the window. Called with d.mu held.
+func (d *Deduplicator) expire(now time.Time) {
+	for d.size > 0 && now.Sub(d.ring[d.head].at) >= d.config.Window {
+		d.pop()
+	}
+}
+
+// pop forgets the oldest packet. Called with d.mu held.
+func (d *Deduplicator) pop() {
+	e := d.ring[d.head]
+	if d.seen[e.hash] == e.at {
+		delete(d.seen, e.hash)
+	}
+	d.head = (d.head + 1) % len(d.ring)
+	d.size--
+}
+
+// dedupHash hashes the fields a copy of the packet shares with it.
+func dedupHash(pkt *Packet) uint64 {
+	p := pkt.IP
+	var header [16]byte
+	copy(header[0:4], p.Source[:])
+	copy(header[4:8], p.Destination[:])
+	header[8] = byte(p.Protocol)
+	header[9] = byte(p.Flags)
+	binary.BigEndian.PutUint16(header[10:12], p.Identification)
+	binary.BigEndian.PutUint16(header[12:14], p.FragmentOffset)
+	binary.BigEndian.PutUint16(header[14:16], p.TotalLength)
+
+	h := fnv.New64a()
+	h.Write(header[:])
+	h.Write(p.Payload[:min(len(p.Payload), dedupPayloadBytes)])
+	return h.Sum64()
+}
@@ -0,0 +1,100 @@
+package hook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+// dedupPacket returns an IP packet from hostA to hostB.
+func dedupPacket(id uint16, ttl uint8, payload string) *Packet {
+	return &Packet{Point: IPRx, IP: &ip.Packet{
+		Source:         hostA,
+		Destination:    hostB,
+		Protocol:       common.ProtocolUDP,
+		Identification: id,
+		TTL:            ttl,
+		TotalLength:    uint16(20 + len(payload)),
+		Payload:        []byte(payload),
+	}}
+}
+
+func TestDeduplicator(t *testing.T) {
+	clock := time.Unix(0, 0)
+	d, err := NewDeduplicator(DedupConfig{Window: 50 * time.Millisecond, MaxEntries: 2})
+	if err != nil {
+		t.Fatalf("NewDeduplicator() error = %v", err)
+	}
+	d.now = func() time.Time { return clock }
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		pkt     *Packet
+		want    Verdict
+	}{
+		{"first copy", 0, dedupPacket(1, 64, "hello"), Continue},
+		{"second copy", 0, dedupPacket(1, 64, "hello"), Drop},
+		{"copy routed once more", 10 * time.Millisecond, dedupPacket(1, 63, "hello"), Drop},
+		{"other identification", 0, dedupPacket(2, 64, "hello"), Continue},
+		{"other payload", 0, dedupPacket(1, 64, "world"), Continue}, // Evicts id 1 "hello"
+		{"evicted packet", 0, dedupPacket(1, 64, "hello"), Continue},
+		{"within window", 10 * time.Millisecond, dedupPacket(1, 64, "world"), Drop},
+		{"after window", 50 * time.Millisecond, dedupPacket(1, 64, "world"), Continue},
+		{"no IP packet", 0, &Packet{Point: IPRx}, Continue},
+	}
+	for _, s := range steps {
+		clock = clock.Add(s.advance)
+		if got := d.Check(s.pkt); got != s.want {
+			t.Errorf("%s: Check() = %s, want %s", s.name, got, s.want)
+		}
+	}
+
+	want := DedupStats{Packets: 8, Duplicates: 3, Evicted: 2}
+	if got := d.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDeduplicatorPipeline(t *testing.T) {
+	d, err := NewDeduplicator(DedupConfig{})
+	if err != nil {
+		t.Fatalf("NewDeduplicator() error = %v", err)
+	}
+	p := NewPipeline()
+	if _, err := p.Register(IPRx, -2000, "dedup", d.Check); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	if v := p.Run(IPRx, dedupPacket(7, 64, "data")); v != Continue {
+		t.Errorf("Run() = %s, want CONTINUE", v)
+	}
+	if v := p.Run(IPRx, dedupPacket(7, 64, "data")); v != Drop {
+		t.Errorf("Run() duplicate = %s, want DROP", v)
+	}
+}
+
+func TestDedupConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DedupConfig
+		wantErr bool
+	}{
+		{"defaults", DedupConfig{}, false},
+		{"negative window", DedupConfig{Window: -time.Second}, true},
+		{"negative entries", DedupConfig{MaxEntries: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDeduplicator(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewDeduplicator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (d.config.Window != DefaultDedupWindow || d.config.MaxEntries != DefaultDedupEntries) {
+				t.Errorf("config = %+v, want defaults", d.config)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package hook
+
+import (
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+// RouteDSCP returns a hook that gives the IPv4 packets passing its point
+// without a DSCP the default DSCP of the route to their destination. It
+// always returns Continue, and belongs at ip-tx, after the sockets have set
+// their traffic class:
+//
+//	p.Register(hook.IPTx, 0, "route-dscp", hook.RouteDSCP(routes))
+func RouteDSCP(routes *ip.RoutingTable) Func {
+	return func(pkt *Packet) Verdict {
+		if pkt.IP == nil || pkt.IP.DSCP != 0 {
+			return Continue
+		}
+		if route, _, err := routes.LookupFlow(ip.PacketFlowKey(pkt.IP)); err == nil {
+			pkt.IP.ApplyRouteDSCP(route)
+		}
+		return Continue
+	}
+}
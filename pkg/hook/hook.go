@@ -0,0 +1,317 @@
+// Package hook implements an ordered packet hook pipeline for the stack.
+//
+// A Pipeline has a hook point at each layer boundary: ethernet-rx, ip-rx,
+// ip-tx, tcp-rx and tcp-tx. Hooks registered at a point run in priority
+// order for every packet passing it, and may inspect or modify the packet,
+// drop it, or steal it. A packet that passes every hook is handed to the
+// point's handler, the next stage of the stack. Packets can also be injected
+// at any point, as if they had arrived there.
+//
+// Hooks are the extension point for logging, NAT, filtering and fault
+// injection, in place of per-component callbacks.
+package hook
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+// Point identifies a hook point in the stack.
+type Point uint8
+
+const (
+	EthernetRx Point = iota // Frames received from a device
+	IPRx                    // IPv4 packets received, before routing or delivery
+	IPTx                    // IPv4 packets about to be transmitted or forwarded
+	TCPRx                   // TCP segments received, before socket delivery
+	TCPTx                   // TCP segments sent by a socket, before encapsulation
+	numPoints
+)
+
+// String returns the name of the hook point.
+func (p Point) String() string {
+	switch p {
+	case EthernetRx:
+		return "ethernet-rx"
+	case IPRx:
+		return "ip-rx"
+	case IPTx:
+		return "ip-tx"
+	case TCPRx:
+		return "tcp-rx"
+	case TCPTx:
+		return "tcp-tx"
+	default:
+		return fmt.Sprintf("Point(%d)", p)
+	}
+}
+
+// Verdict is a hook's decision for a packet.
+type Verdict uint8
+
+const (
+	Continue Verdict = iota // Pass the packet to the next hook or stage
+	Drop                    // Discard the packet
+	Stolen                  // The hook took ownership of the packet; stop processing it
+)
+
+// String returns a human-readable name for the verdict.
+func (v Verdict) String() string {
+	switch v {
+	case Continue:
+		return "CONTINUE"
+	case Drop:
+		return "DROP"
+	case Stolen:
+		return "STOLEN"
+	default:
+		return fmt.Sprintf("Verdict(%d)", v)
+	}
+}
+
+var (
+	// ErrDropped is returned by Process when a hook drops the packet.
+	ErrDropped = errors.New("packet dropped by hook")
+
+	// ErrInvalidPoint is returned for an unknown hook point.
+	ErrInvalidPoint = errors.New("invalid hook point")
+)
+
+// Packet is a packet passing a hook point. Which layer fields are set
+// depends on the point: Frame at ethernet-rx, IP at the ip points (and
+// ethernet-rx once demultiplexed), TCP with its pseudo-header addresses at
+// the tcp points.
+//
+// On the receive path the stages decode the IP and TCP headers in place:
+// the options, payload and data of IP and TCP alias the frame's payload,
+// without copies or allocations of their own. The frame may be reused
+// once ReceiveFrame returns, and the IP header, which DemuxEthernet and
+// EncapsulateTCP take from ip.AcquirePacket, is released once the packet
+// has passed ip-rx or ip-tx, so a hook that steals a packet, or a handler
+// that keeps one, must Clone what it keeps. The same goes for segments at
+// tcp-tx, which their connection recycles once they are acknowledged.
+//
+// Hooks may modify the layer fields in place or replace them. Hooks that
+// change a TCP segment must update its checksum (common.UpdateChecksum
+// supports incremental updates); IPv4 header checksums are recomputed when
+// the packet is serialized.
+type Packet struct {
+	Point        Point  // Point the packet is passing (set by the pipeline)
+	InInterface  string // Device the packet was received on, if any
+	OutInterface string // Device the packet will be sent on, if known
+	Injected     bool   // The packet was injected rather than passed up or down the stack
+
+	Frame *ethernet.Frame
+	IP    *ip.Packet
+	TCP   *tcp.Segment
+
+	// Pseudo-header addresses of the TCP segment
+	Source      common.IPv4Address
+	Destination common.IPv4Address
+
+	// What TCP points to once the receive stages decoded it, so that the
+	// packet is the one allocation on the receive path
+	tcpHeader tcp.Segment
+}
+
+// Func is a hook function.
+type Func func(pkt *Packet) Verdict
+
+// Handler is the stage that receives packets passing a hook point.
+type Handler func(pkt *Packet) error
+
+// Handle identifies a registered hook.
+type Handle uint64
+
+// Info describes a registered hook.
+type Info struct {
+	Handle   Handle
+	Name     string
+	Priority int
+	Packets  uint64 // Packets the hook has seen
+	Drops    uint64 // Packets the hook dropped
+}
+
+// entry is a registered hook.
+type entry struct {
+	handle   Handle
+	name     string
+	priority int
+	fn       Func
+	packets  atomic.Uint64
+	drops    atomic.Uint64
+}
+
+// Pipeline holds the hooks and handlers for every hook point.
+//
+// Hook lists are replaced on registration rather than modified, so packet
+// processing never takes a lock and hooks may register or unregister hooks
+// (including themselves) while running.
+type Pipeline struct {
+	mu       sync.Mutex
+	hooks    [numPoints]atomic.Pointer[[]*entry]
+	handlers [numPoints]atomic.Pointer[Handler]
+	next     Handle
+	points   map[Handle]Point
+}
+
+// NewPipeline creates a pipeline with no hooks or handlers.
+func NewPipeline() *Pipeline {
+	return &Pipeline{
+		points: make(map[Handle]Point),
+	}
+}
+
+// Register adds a hook at a point. Hooks run in ascending priority order;
+// hooks with equal priority run in registration order.
+func (p *Pipeline) Register(point Point, priority int, name string, fn Func) (Handle, error) {
+	if point >= numPoints {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidPoint, point)
+	}
+	if fn == nil {
+		return 0, fmt.Errorf("hook %q has no function", name)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.next++
+	e := &entry{handle: p.next, name: name, priority: priority, fn: fn}
+
+	// Insert after every hook of lower or equal priority
+	var hooks []*entry
+	if current := p.hooks[point].Load(); current != nil {
+		hooks = append(hooks, *current...)
+	}
+	i := sort.Search(len(hooks), func(i int) bool { return hooks[i].priority > priority })
+	hooks = append(hooks, nil)
+	copy(hooks[i+1:], hooks[i:])
+	hooks[i] = e
+
+	p.hooks[point].Store(&hooks)
+	p.points[e.handle] = point
+	return e.handle, nil
+}
+
+// Unregister removes a hook. Returns false if the hook is not registered.
+func (p *Pipeline) Unregister(h Handle) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	point, ok := p.points[h]
+	if !ok {
+		return false
+	}
+	delete(p.points, h)
+
+	current := *p.hooks[point].Load()
+	hooks := make([]*entry, 0, len(current)-1)
+	for _, e := range current {
+		if e.handle != h {
+			hooks = append(hooks, e)
+		}
+	}
+	p.hooks[point].Store(&hooks)
+	return true
+}
+
+// Hooks returns the hooks registered at a point, in the order they run.
+func (p *Pipeline) Hooks(point Point) []Info {
+	if point >= numPoints {
+		return nil
+	}
+	current := p.hooks[point].Load()
+	if current == nil {
+		return nil
+	}
+
+	infos := make([]Info, 0, len(*current))
+	for _, e := range *current {
+		infos = append(infos, Info{
+			Handle:   e.handle,
+			Name:     e.name,
+			Priority: e.priority,
+			Packets:  e.packets.Load(),
+			Drops:    e.drops.Load(),
+		})
+	}
+	return infos
+}
+
+// SetHandler sets the stage that receives packets passing a point.
+// A nil handler discards them.
+func (p *Pipeline) SetHandler(point Point, h Handler) {
+	if point >= numPoints {
+		return
+	}
+	if h == nil {
+		p.handlers[point].Store(nil)
+		return
+	}
+	p.handlers[point].Store(&h)
+}
+
+// Run runs the hooks at a point and returns the verdict. Processing stops at
+// the first hook that drops or steals the packet.
+func (p *Pipeline) Run(point Point, pkt *Packet) Verdict {
+	if point >= numPoints {
+		return Drop
+	}
+	pkt.Point = point
+
+	current := p.hooks[point].Load()
+	if current == nil {
+		return Continue
+	}
+
+	for _, e := range *current {
+		e.packets.Add(1)
+		switch v := e.fn(pkt); v {
+		case Continue:
+		case Stolen:
+			return Stolen
+		default:
+			e.drops.Add(1)
+			return Drop
+		}
+	}
+	return Continue
+}
+
+// Process runs the hooks at a point and passes the packet to the point's
+// handler if every hook lets it continue. Returns ErrDropped if a hook
+// dropped the packet, and nil if one stole it.
+func (p *Pipeline) Process(point Point, pkt *Packet) error {
+	if point >= numPoints {
+		return fmt.Errorf("%w: %d", ErrInvalidPoint, point)
+	}
+
+	switch p.Run(point, pkt) {
+	case Stolen:
+		return nil
+	case Drop:
+		return fmt.Errorf("%w at %s", ErrDropped, point)
+	}
+
+	h := p.handlers[point].Load()
+	if h == nil {
+		return nil
+	}
+	return (*h)(pkt)
+}
+
+// Inject processes a packet at a point as if it had arrived there, for
+// tests, fault injection or hooks that emit additional packets. Hooks can
+// recognize injected packets by the Injected field.
+func (p *Pipeline) Inject(point Point, pkt *Packet) error {
+	pkt.Injected = true
+	return p.Process(point, pkt)
+}
@@ -0,0 +1,589 @@
+package hook
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	hostA = common.IPv4Address{10, 0, 0, 1}
+	hostB = common.IPv4Address{10, 0, 0, 2}
+)
+
+// recordHook returns a hook that appends its name to order and returns v.
+func recordHook(order *[]string, name string, v Verdict) Func {
+	return func(pkt *Packet) Verdict {
+		*order = append(*order, name)
+		return v
+	}
+}
+
+func TestHookOrder(t *testing.T) {
+	p := NewPipeline()
+	var order []string
+
+	p.Register(IPRx, 10, "late", recordHook(&order, "late", Continue))
+	p.Register(IPRx, -10, "early", recordHook(&order, "early", Continue))
+	p.Register(IPRx, 0, "first", recordHook(&order, "first", Continue))
+	p.Register(IPRx, 0, "second", recordHook(&order, "second", Continue))
+	p.Register(IPTx, 0, "other point", recordHook(&order, "other point", Continue))
+
+	if v := p.Run(IPRx, &Packet{}); v != Continue {
+		t.Errorf("Run() = %s, want CONTINUE", v)
+	}
+
+	want := []string{"early", "first", "second", "late"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+}
+
+func TestVerdicts(t *testing.T) {
+	tests := []struct {
+		name        string
+		verdict     Verdict
+		wantErr     error
+		wantHandled bool
+		wantAfter   bool
+	}{
+		{"continue", Continue, nil, true, true},
+		{"drop", Drop, ErrDropped, false, false},
+		{"stolen", Stolen, nil, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPipeline()
+			var order []string
+			p.Register(TCPRx, 0, "hook", recordHook(&order, "hook", tt.verdict))
+			p.Register(TCPRx, 1, "after", recordHook(&order, "after", Continue))
+
+			handled := false
+			p.SetHandler(TCPRx, func(pkt *Packet) error {
+				handled = true
+				return nil
+			})
+
+			err := p.Process(TCPRx, &Packet{})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Process() error = %v, want %v", err, tt.wantErr)
+			}
+			if handled != tt.wantHandled {
+				t.Errorf("handled = %v, want %v", handled, tt.wantHandled)
+			}
+			if ranAfter := len(order) == 2; ranAfter != tt.wantAfter {
+				t.Errorf("later hook ran = %v, want %v", ranAfter, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestModifyAndCounters(t *testing.T) {
+	p := NewPipeline()
+
+	// Rewrite the destination and drop anything for hostA
+	p.Register(IPTx, 0, "rewrite", func(pkt *Packet) Verdict {
+		if pkt.IP.Destination == hostB {
+			pkt.IP.Destination = hostA
+			pkt.OutInterface = "eth1"
+		}
+		return Continue
+	})
+	drop, _ := p.Register(IPTx, 1, "drop", func(pkt *Packet) Verdict {
+		if pkt.IP.Protocol == common.ProtocolUDP {
+			return Drop
+		}
+		return Continue
+	})
+
+	var got *Packet
+	p.SetHandler(IPTx, func(pkt *Packet) error {
+		got = pkt
+		return nil
+	})
+
+	if err := p.Process(IPTx, &Packet{IP: ip.NewPacket(hostA, hostB, common.ProtocolTCP, nil)}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if got == nil || got.IP.Destination != hostA || got.OutInterface != "eth1" || got.Point != IPTx {
+		t.Errorf("handler got %+v, want modified packet", got)
+	}
+
+	if err := p.Process(IPTx, &Packet{IP: ip.NewPacket(hostA, hostA, common.ProtocolUDP, nil)}); !errors.Is(err, ErrDropped) {
+		t.Errorf("Process() error = %v, want ErrDropped", err)
+	}
+
+	infos := p.Hooks(IPTx)
+	if len(infos) != 2 {
+		t.Fatalf("Hooks() = %d entries, want 2", len(infos))
+	}
+	if infos[0].Name != "rewrite" || infos[0].Packets != 2 || infos[0].Drops != 0 {
+		t.Errorf("Hooks()[0] = %+v, want rewrite with 2 packets", infos[0])
+	}
+	if infos[1].Handle != drop || infos[1].Packets != 2 || infos[1].Drops != 1 {
+		t.Errorf("Hooks()[1] = %+v, want drop with 2 packets and 1 drop", infos[1])
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	p := NewPipeline()
+	var order []string
+
+	h, err := p.Register(EthernetRx, 0, "a", recordHook(&order, "a", Continue))
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	p.Register(EthernetRx, 0, "b", recordHook(&order, "b", Continue))
+
+	if !p.Unregister(h) {
+		t.Fatal("Unregister() = false, want true")
+	}
+	if p.Unregister(h) {
+		t.Error("Unregister() twice = true, want false")
+	}
+
+	p.Run(EthernetRx, &Packet{})
+	if !reflect.DeepEqual(order, []string{"b"}) {
+		t.Errorf("order = %v, want [b]", order)
+	}
+
+	if _, err := p.Register(numPoints, 0, "bad", recordHook(&order, "bad", Continue)); !errors.Is(err, ErrInvalidPoint) {
+		t.Errorf("Register() invalid point error = %v, want ErrInvalidPoint", err)
+	}
+	if _, err := p.Register(IPRx, 0, "nil", nil); err == nil {
+		t.Error("Register() nil function succeeded, want error")
+	}
+}
+
+func TestInject(t *testing.T) {
+	p := NewPipeline()
+
+	// Duplicate every transmitted packet once
+	var sent []*Packet
+	p.Register(IPTx, 0, "duplicate", func(pkt *Packet) Verdict {
+		if !pkt.Injected {
+			copy := *pkt.IP
+			p.Inject(IPTx, &Packet{IP: &copy})
+		}
+		return Continue
+	})
+	p.SetHandler(IPTx, func(pkt *Packet) error {
+		sent = append(sent, pkt)
+		return nil
+	})
+
+	if err := p.Process(IPTx, &Packet{IP: ip.NewPacket(hostA, hostB, common.ProtocolTCP, nil)}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("sent %d packets, want 2", len(sent))
+	}
+	if !sent[0].Injected || sent[1].Injected {
+		t.Errorf("Injected = %v, %v, want true, false", sent[0].Injected, sent[1].Injected)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var records []logging.Record
+	logging.SetLogger(logging.LoggerFunc(func(r logging.Record) {
+		records = append(records, r)
+	}))
+	logger := logging.New("hook-trace-test")
+	t.Cleanup(func() {
+		logging.SetLogger(nil)
+		logging.SetLevel(logger.Name(), logging.DefaultLevel)
+	})
+
+	p := NewPipeline()
+	p.Register(TCPTx, 0, "trace", Trace(logger))
+	seg := tcp.NewSegment(40000, 80, 1, 0, tcp.FlagSYN, 1024, nil)
+
+	// Nothing is logged until tracing is enabled
+	if err := p.Process(TCPTx, &Packet{TCP: seg, Source: hostA, Destination: hostB}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("logged %d records with tracing disabled, want 0", len(records))
+	}
+
+	logging.SetLevel(logger.Name(), logging.LevelTrace)
+	if err := p.Process(TCPTx, &Packet{TCP: seg, Source: hostA, Destination: hostB}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("logged %d records, want 1", len(records))
+	}
+	fields := make(map[string]any)
+	for _, f := range records[0].Fields {
+		fields[f.Key] = f.Value
+	}
+	if fields["dir"] != "tcp-tx" {
+		t.Errorf("dir = %v, want tcp-tx", fields["dir"])
+	}
+	want := "IP 10.0.0.1.40000 > 10.0.0.2.80: Flags [S], seq 1, win 1024, length 0"
+	if hdr, ok := fields["hdr"].(fmt.Stringer); !ok || hdr.String() != want {
+		t.Errorf("hdr = %v, want %q", fields["hdr"], want)
+	}
+}
+
+func TestRouteDSCP(t *testing.T) {
+	routes := ip.NewRoutingTable()
+	routes.AddRoute(&ip.Route{Destination: hostB, Netmask: common.IPv4Address{255, 255, 255, 255}, Interface: "eth0", DSCP: 8})
+
+	tests := []struct {
+		name string
+		dst  common.IPv4Address
+		dscp uint8 // Set by the socket
+		want uint8
+	}{
+		{"route default", hostB, 0, 8},
+		{"socket class", hostB, 46, 46},
+		{"no route", common.IPv4Address{192, 0, 2, 1}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt := &Packet{IP: ip.NewPacket(hostA, tt.dst, common.ProtocolUDP, nil)}
+			pkt.IP.DSCP = tt.dscp
+			if v := RouteDSCP(routes)(pkt); v != Continue {
+				t.Errorf("RouteDSCP() = %s, want CONTINUE", v)
+			}
+			if pkt.IP.DSCP != tt.want {
+				t.Errorf("DSCP = %d, want %d", pkt.IP.DSCP, tt.want)
+			}
+		})
+	}
+}
+
+func TestStages(t *testing.T) {
+	p := NewPipeline()
+	var seen []Point
+	for point := EthernetRx; point < numPoints; point++ {
+		p.Register(point, 0, "trace", func(pkt *Packet) Verdict {
+			seen = append(seen, pkt.Point)
+			return Continue
+		})
+	}
+
+	var other []*Packet
+	var delivered []*Packet
+	var transmitted []*ip.Packet
+	p.SetHandler(EthernetRx, p.DemuxEthernet(func(pkt *Packet) error {
+		other = append(other, pkt)
+		return nil
+	}))
+	p.SetHandler(IPRx, p.DemuxIP(nil))
+	p.SetHandler(TCPRx, func(pkt *Packet) error {
+		delivered = append(delivered, pkt)
+		return nil
+	})
+	p.SetHandler(TCPTx, p.EncapsulateTCP())
+	p.SetHandler(IPTx, func(pkt *Packet) error {
+		transmitted = append(transmitted, pkt.IP.Clone()) // Released once ip-tx is passed
+		return nil
+	})
+
+	// Receive path: frame -> ip-rx -> tcp-rx
+	seg := tcp.NewSegment(40000, 80, 1, 0, tcp.FlagSYN, 65535, nil)
+	seg.Checksum, _ = seg.CalculateChecksum(hostB, hostA)
+	segData, _ := seg.Serialize()
+	ipData, _ := ip.NewPacket(hostB, hostA, common.ProtocolTCP, segData).Serialize()
+	frame := ethernet.NewFrame(common.MACAddress{0x02, 0, 0, 0, 0, 1}, common.MACAddress{0x02, 0, 0, 0, 0, 2}, common.EtherTypeIPv4, ipData)
+
+	if err := p.ReceiveFrame("eth0", frame); err != nil {
+		t.Fatalf("ReceiveFrame() error = %v", err)
+	}
+	if len(delivered) != 1 {
+		t.Fatalf("delivered %d segments, want 1", len(delivered))
+	}
+	got := delivered[0]
+	if got.InInterface != "eth0" || got.TCP.DestinationPort != 80 || got.Source != hostB || got.Destination != hostA {
+		t.Errorf("delivered %+v, want SYN to port 80 from %s on eth0", got, hostB)
+	}
+
+	// Non-IPv4 frames go to the other handler
+	p.ReceiveFrame("eth0", ethernet.NewFrame(common.BroadcastMAC, common.MACAddress{0x02, 0, 0, 0, 0, 2}, common.EtherTypeARP, make([]byte, 28)))
+	if len(other) != 1 {
+		t.Errorf("other handler got %d frames, want 1", len(other))
+	}
+
+	// Transmit path: tcp-tx -> ip-tx
+	reply := tcp.NewSegment(80, 40000, 100, 2, tcp.FlagSYN|tcp.FlagACK, 65535, nil)
+	if err := p.TCPSendFunc()(reply, hostA, hostB); err != nil {
+		t.Fatalf("TCPSendFunc() error = %v", err)
+	}
+	if len(transmitted) != 1 {
+		t.Fatalf("transmitted %d packets, want 1", len(transmitted))
+	}
+	if pkt := transmitted[0]; pkt.Source != hostA || pkt.Destination != hostB || pkt.Protocol != common.ProtocolTCP {
+		t.Errorf("transmitted %s, want TCP %s -> %s", pkt, hostA, hostB)
+	}
+
+	want := []Point{EthernetRx, IPRx, TCPRx, EthernetRx, TCPTx, IPTx}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("points = %v, want %v", seen, want)
+	}
+}
+
+func TestEncapsulateGSO(t *testing.T) {
+	p := NewPipeline()
+	var txHooks int
+	p.Register(TCPTx, 0, "count", func(pkt *Packet) Verdict {
+		txHooks++
+		return Continue
+	})
+	var transmitted []*ip.Packet
+	p.SetHandler(TCPTx, p.EncapsulateTCP())
+	p.SetHandler(IPTx, func(pkt *Packet) error {
+		transmitted = append(transmitted, pkt.IP.Clone())
+		return nil
+	})
+
+	// 3.5 MSS of data in one super-segment
+	data := make([]byte, 3*tcp.DefaultMSS+tcp.DefaultMSS/2)
+	seg := tcp.NewSegment(80, 40000, 1000, 1, tcp.FlagACK|tcp.FlagPSH, 65535, data)
+	seg.GSOSize = tcp.DefaultMSS
+	seg.TTL, seg.TOS = 7, 0xb8 // DSCP EF
+	if err := p.TCPSendFunc()(seg, hostA, hostB); err != nil {
+		t.Fatalf("TCPSendFunc() error = %v", err)
+	}
+
+	if txHooks != 1 {
+		t.Errorf("tcp-tx hooks ran %d times, want 1", txHooks)
+	}
+	if len(transmitted) != 4 {
+		t.Fatalf("transmitted %d packets, want 4", len(transmitted))
+	}
+	seq := uint32(1000)
+	for i, pkt := range transmitted {
+		wire, err := tcp.Parse(pkt.Payload)
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if wire.SequenceNumber != seq {
+			t.Errorf("packet %d: seq = %d, want %d", i, wire.SequenceNumber, seq)
+		}
+		if pkt.TTL != 7 || pkt.DSCP != 46 {
+			t.Errorf("packet %d: TTL = %d, DSCP = %d, want 7 and 46", i, pkt.TTL, pkt.DSCP)
+		}
+		if !wire.VerifyChecksum(hostA, hostB) {
+			t.Errorf("packet %d: bad checksum", i)
+		}
+		if len(wire.Data) > tcp.DefaultMSS {
+			t.Errorf("packet %d: %d bytes of data, want at most %d", i, len(wire.Data), tcp.DefaultMSS)
+		}
+		seq += uint32(len(wire.Data))
+	}
+	if seq != 1000+uint32(len(data)) {
+		t.Errorf("sent %d bytes, want %d", seq-1000, len(data))
+	}
+}
+
+func TestRespondICMP(t *testing.T) {
+	r, err := icmp.NewResponder(icmp.ResponderConfig{
+		IsLocal: func(addr common.IPv4Address) bool { return addr == hostA },
+	})
+	if err != nil {
+		t.Fatalf("NewResponder() error = %v", err)
+	}
+
+	p := NewPipeline()
+	var other []*Packet
+	var transmitted []*Packet
+	p.SetHandler(IPRx, p.DemuxIP(p.RespondICMP(r, func(pkt *Packet) error {
+		other = append(other, pkt)
+		return nil
+	})))
+	p.SetHandler(IPTx, func(pkt *Packet) error {
+		transmitted = append(transmitted, pkt)
+		return nil
+	})
+
+	receive := func(dst common.IPv4Address, msg *icmp.Message) {
+		t.Helper()
+		data, _ := msg.Serialize()
+		if err := p.Process(IPRx, &Packet{InInterface: "eth0", IP: ip.NewPacket(hostB, dst, common.ProtocolICMP, data)}); err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+
+	receive(hostA, icmp.NewEchoRequest(1, 2, []byte("ping")))
+	if len(transmitted) != 1 {
+		t.Fatalf("transmitted %d packets, want 1", len(transmitted))
+	}
+	pkt := transmitted[0]
+	if pkt.OutInterface != "eth0" || pkt.IP.Source != hostA || pkt.IP.Destination != hostB || pkt.IP.Protocol != common.ProtocolICMP {
+		t.Errorf("transmitted %s on %q, want ICMP %s -> %s on eth0", pkt.IP, pkt.OutInterface, hostA, hostB)
+	}
+	reply, err := icmp.Parse(pkt.IP.Payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !reply.IsEchoReply() || reply.ID != 1 || reply.Sequence != 2 || string(reply.Data) != "ping" {
+		t.Errorf("reply = %v, want Echo Reply 1/2", reply)
+	}
+
+	// Requests for other hosts go to the other handler
+	receive(hostB, icmp.NewEchoRequest(1, 3, nil))
+	if len(transmitted) != 1 || len(other) != 1 {
+		t.Errorf("transmitted %d, other %d; want 1, 1", len(transmitted), len(other))
+	}
+}
+
+func TestDeliverICMPErrors(t *testing.T) {
+	d := udp.NewDemultiplexer()
+	s := udp.NewSocket()
+	s.Bind(udp.Address{IP: hostA})
+	port, err := d.Bind(s, 0)
+	if err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+
+	p := NewPipeline()
+	var other []*Packet
+	p.SetHandler(IPRx, p.DemuxIP(p.DeliverICMPErrors(nil, d, func(pkt *Packet) error {
+		other = append(other, pkt)
+		return nil
+	})))
+
+	// A Port Unreachable from hostB quoting a datagram the socket sent it
+	datagram, _ := udp.NewPacket(port, 53, []byte("query")).Serialize()
+	sent, _ := ip.NewPacket(hostA, hostB, common.ProtocolUDP, datagram).Serialize()
+	data, _ := icmp.NewDestinationUnreachable(icmp.CodePortUnreachable, sent[:ip.MinHeaderLength+8]).Serialize()
+	if err := p.Process(IPRx, &Packet{IP: ip.NewPacket(hostB, hostA, common.ProtocolICMP, data)}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if len(other) != 0 {
+		t.Errorf("other handler got %d packets, want 0", len(other))
+	}
+	if _, _, err := s.RecvFrom(time.Second); !errors.Is(err, icmp.ErrPortUnreachable) {
+		t.Errorf("RecvFrom() error = %v, want %v", err, icmp.ErrPortUnreachable)
+	}
+}
+
+func TestConcurrentRegistration(t *testing.T) {
+	p := NewPipeline()
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			h, _ := p.Register(IPRx, i%5, "churn", func(pkt *Packet) Verdict { return Continue })
+			p.Unregister(h)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			p.Process(IPRx, &Packet{})
+		}
+	}()
+	wg.Wait()
+
+	if n := len(p.Hooks(IPRx)); n != 0 {
+		t.Errorf("Hooks() = %d entries, want 0", n)
+	}
+}
+
+// receivePipeline returns a pipeline decoding received frames up to
+// tcp-rx, where it counts the segments delivered, and a frame carrying a
+// TCP segment with data.
+func receivePipeline() (*Pipeline, *ethernet.Frame, *int) {
+	p := NewPipeline()
+	delivered := new(int)
+	p.SetHandler(EthernetRx, p.DemuxEthernet(nil))
+	p.SetHandler(IPRx, p.DemuxIP(nil))
+	p.SetHandler(TCPRx, func(pkt *Packet) error {
+		*delivered++
+		return nil
+	})
+
+	seg := tcp.NewSegment(40000, 80, 1, 1, tcp.FlagACK|tcp.FlagPSH, 65535, make([]byte, 1400))
+	seg.Options = tcp.BuildMSSOption(1460)
+	seg.Checksum, _ = seg.CalculateChecksum(hostB, hostA)
+	segData, _ := seg.Serialize()
+	ipData, _ := ip.NewPacket(hostB, hostA, common.ProtocolTCP, segData).Serialize()
+	frame := ethernet.NewFrame(common.MACAddress{0x02, 0, 0, 0, 0, 1}, common.MACAddress{0x02, 0, 0, 0, 0, 2}, common.EtherTypeIPv4, ipData)
+	return p, frame, delivered
+}
+
+func TestReceivePathAllocs(t *testing.T) {
+	p, frame, delivered := receivePipeline()
+
+	// Headers are decoded in place: the packet is the one allocation
+	allocs := testing.AllocsPerRun(100, func() {
+		p.ReceiveFrame("eth0", frame)
+	})
+	if *delivered == 0 {
+		t.Fatal("no segments delivered")
+	}
+	if allocs > 1 {
+		t.Errorf("ReceiveFrame() allocs = %v, want 1", allocs)
+	}
+
+	// The layers alias the frame, until cloned
+	var kept, cloned *Packet
+	p.SetHandler(TCPRx, func(pkt *Packet) error {
+		kept = pkt
+		cloned = &Packet{IP: pkt.IP.Clone(), TCP: pkt.TCP.Clone()}
+		return nil
+	})
+	if err := p.ReceiveFrame("eth0", frame); err != nil {
+		t.Fatalf("ReceiveFrame() error = %v", err)
+	}
+	frame.Payload[len(frame.Payload)-1] = 0xFF
+	if kept.TCP.Data[len(kept.TCP.Data)-1] != 0xFF {
+		t.Error("decoded segment data does not alias the frame")
+	}
+	if cloned.TCP.Data[len(cloned.TCP.Data)-1] != 0 || cloned.IP.Payload[len(cloned.IP.Payload)-1] != 0 {
+		t.Error("cloned layers changed with the frame")
+	}
+}
+
+func BenchmarkReceiveFrame(b *testing.B) {
+	p, frame, _ := receivePipeline()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame.Payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.ReceiveFrame("eth0", frame)
+	}
+}
+
+func TestTransmitPathAllocs(t *testing.T) {
+	p := NewPipeline()
+	sent := 0
+	p.SetHandler(TCPTx, p.EncapsulateTCP())
+	p.SetHandler(IPTx, func(pkt *Packet) error {
+		if _, err := pkt.IP.Serialize(); err != nil {
+			return err
+		}
+		sent++
+		return nil
+	})
+	send := p.TCPSendFunc()
+	seg := tcp.NewSegment(80, 40000, 1, 1, tcp.FlagACK, 65535, make([]byte, 1400))
+
+	// The packet and the buffer the segment is serialized into
+	allocs := testing.AllocsPerRun(100, func() {
+		send(seg, hostA, hostB)
+	})
+	if sent == 0 {
+		t.Fatal("no packets sent")
+	}
+	if allocs > 2 {
+		t.Errorf("TCPSendFunc() allocs = %v, want 2", allocs)
+	}
+}
@@ -0,0 +1,207 @@
+package hook
+
+import (
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// The stages below connect the hook points into a path through the stack.
+// Each is a Handler for one point that decodes or encapsulates the packet
+// and processes it at the next point, so that for example
+//
+//	p.SetHandler(hook.EthernetRx, p.DemuxEthernet(arpHandler))
+//	p.SetHandler(hook.IPRx, p.DemuxIP(p.RespondICMP(responder, p.DeliverICMPErrors(tcpDemux, udpDemux, nil))))
+//	p.SetHandler(hook.TCPRx, deliverToSocket)
+//
+// runs every received TCP segment through the ethernet-rx, ip-rx and tcp-rx
+// hooks before it reaches a socket.
+
+// ReceiveFrame processes a frame received on a device at ethernet-rx.
+func (p *Pipeline) ReceiveFrame(device string, frame *ethernet.Frame) error {
+	return p.Process(EthernetRx, &Packet{InInterface: device, Frame: frame})
+}
+
+// TCPSendFunc returns a send function for tcp.Socket.SetSendFunc that
+// processes outgoing segments at tcp-tx.
+func (p *Pipeline) TCPSendFunc() func(*tcp.Segment, common.IPv4Address, common.IPv4Address) error {
+	return func(seg *tcp.Segment, src, dst common.IPv4Address) error {
+		return p.Process(TCPTx, &Packet{TCP: seg, Source: src, Destination: dst})
+	}
+}
+
+// DemuxEthernet returns an ethernet-rx handler that decodes IPv4 frames in
+// place and processes them at ip-rx. Other frames are passed to other, if not nil.
+func (p *Pipeline) DemuxEthernet(other Handler) Handler {
+	return func(pkt *Packet) error {
+		if pkt.Frame.EtherType != common.EtherTypeIPv4 {
+			if other != nil {
+				return other(pkt)
+			}
+			return nil
+		}
+
+		hdr := ip.AcquirePacket()
+		if err := hdr.Decode(pkt.Frame.Payload); err != nil {
+			ip.ReleasePacket(hdr)
+			return fmt.Errorf("failed to parse IPv4 packet: %w", err)
+		}
+		pkt.IP = hdr
+		err := p.Process(IPRx, pkt)
+		releaseIP(pkt, hdr)
+		return err
+	}
+}
+
+// DemuxIP returns an ip-rx handler that decodes TCP segments in place and
+// processes them at tcp-rx. Other packets, including fragments, are passed to other,
+// if not nil.
+func (p *Pipeline) DemuxIP(other Handler) Handler {
+	return func(pkt *Packet) error {
+		if pkt.IP.Protocol != common.ProtocolTCP || pkt.IP.IsFragment() {
+			if other != nil {
+				return other(pkt)
+			}
+			return nil
+		}
+
+		if err := pkt.tcpHeader.Decode(pkt.IP.Payload); err != nil {
+			return fmt.Errorf("failed to parse TCP segment: %w", err)
+		}
+		pkt.TCP = &pkt.tcpHeader
+		pkt.Source = pkt.IP.Source
+		pkt.Destination = pkt.IP.Destination
+		return p.Process(TCPRx, pkt)
+	}
+}
+
+// RespondICMP returns an ip-rx handler that answers the ICMP queries r
+// accepts, processing the replies at ip-tx on the interface the request
+// arrived on. Other packets are passed to other, if not nil.
+func (p *Pipeline) RespondICMP(r *icmp.Responder, other Handler) Handler {
+	return func(pkt *Packet) error {
+		if pkt.IP.Protocol != common.ProtocolICMP || pkt.IP.IsFragment() {
+			if other != nil {
+				return other(pkt)
+			}
+			return nil
+		}
+
+		msg, err := icmp.Parse(pkt.IP.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to parse ICMP message: %w", err)
+		}
+		reply := r.Respond(msg, pkt.IP.Source, pkt.IP.Destination)
+		if reply == nil {
+			if other != nil {
+				return other(pkt)
+			}
+			return nil
+		}
+
+		data, err := reply.Serialize()
+		if err != nil {
+			return fmt.Errorf("failed to serialize ICMP reply: %w", err)
+		}
+		return p.Process(IPTx, &Packet{
+			OutInterface: pkt.InInterface,
+			IP:           ip.NewPacket(pkt.IP.Destination, pkt.IP.Source, common.ProtocolICMP, data),
+		})
+	}
+}
+
+// DeliverICMPErrors returns an ip-rx handler that reports ICMP errors about
+// TCP segments and UDP datagrams the stack sent to the connection or socket
+// that sent them, through tcpDemux and udpDemux; either may be nil. Other
+// packets are passed to other, if not nil.
+func (p *Pipeline) DeliverICMPErrors(tcpDemux *tcp.Demultiplexer, udpDemux *udp.Demultiplexer, other Handler) Handler {
+	return func(pkt *Packet) error {
+		if pkt.IP.Protocol == common.ProtocolICMP && !pkt.IP.IsFragment() {
+			msg, err := icmp.Parse(pkt.IP.Payload)
+			if err != nil {
+				return fmt.Errorf("failed to parse ICMP message: %w", err)
+			}
+			if msg.VerifyChecksum() && msg.Err() != nil {
+				if tcpDemux != nil && tcpDemux.DeliverICMPError(msg) {
+					return nil
+				}
+				if udpDemux != nil && udpDemux.DeliverICMPError(msg) {
+					return nil
+				}
+			}
+		}
+
+		if other != nil {
+			return other(pkt)
+		}
+		return nil
+	}
+}
+
+// EncapsulateTCP returns a tcp-tx handler that wraps segments in IPv4
+// packets and processes them at ip-tx. The segment is serialized after room
+// for the IP header, so serializing the packet does not copy it again.
+//
+// GSO super-segments (see tcp.Socket.SetGSO) are split into wire segments
+// here, after the tcp-tx hooks have seen the super-segment once, and each
+// wire segment passes ip-tx as its own packet.
+func (p *Pipeline) EncapsulateTCP() Handler {
+	return func(pkt *Packet) error {
+		if pkt.TCP.GSOSize == 0 {
+			return p.encapsulateTCP(pkt)
+		}
+
+		segs, err := pkt.TCP.SplitGSO(pkt.Source, pkt.Destination)
+		if err != nil {
+			return fmt.Errorf("failed to segment TCP super-segment: %w", err)
+		}
+		// Send every wire segment even if one is dropped, as a NIC would
+		var first error
+		for _, seg := range segs {
+			wire := *pkt
+			wire.TCP = seg
+			if err := p.encapsulateTCP(&wire); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+}
+
+// encapsulateTCP wraps one wire segment in an IPv4 packet and processes it
+// at ip-tx.
+func (p *Pipeline) encapsulateTCP(pkt *Packet) error {
+	buf := make([]byte, ip.MinHeaderLength+pkt.TCP.Size())
+	n, err := pkt.TCP.SerializeTo(buf[ip.MinHeaderLength:])
+	if err != nil {
+		return fmt.Errorf("failed to serialize TCP segment: %w", err)
+	}
+	hdr := ip.AcquirePacket()
+	hdr.ResetInPlace(pkt.Source, pkt.Destination, common.ProtocolTCP, buf[:ip.MinHeaderLength+n])
+	if pkt.TCP.TTL != 0 {
+		hdr.TTL = pkt.TCP.TTL
+	}
+	hdr.SetTOS(pkt.TCP.TOS)
+	if pkt.TCP.Device != "" {
+		pkt.OutInterface = pkt.TCP.Device
+	}
+	pkt.IP = hdr
+	err = p.Process(IPTx, pkt)
+	releaseIP(pkt, hdr)
+	return err
+}
+
+// releaseIP gives back the IP header a stage took from ip.AcquirePacket
+// once the packet has passed the next point, leaving the packet without
+// it.
+func releaseIP(pkt *Packet, hdr *ip.Packet) {
+	if pkt.IP == hdr {
+		pkt.IP = nil
+	}
+	ip.ReleasePacket(hdr)
+}
@@ -0,0 +1,44 @@
+package hook
+
+import (
+	"github.com/therealutkarshpriyadarshi/network/pkg/decode"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+// Trace returns a hook that logs the packets passing its point to logger
+// at LevelTrace, decoded as by tcpdump, with the hook point as the
+// direction. It always returns Continue, and only decodes packets while
+// trace logging is enabled for logger, so it can stay registered:
+//
+//	p.Register(hook.IPRx, -1000, "trace", hook.Trace(logging.New("ip-rx")))
+func Trace(logger *logging.Subsystem) Func {
+	return func(pkt *Packet) Verdict {
+		if !logger.Enabled(logging.LevelTrace) {
+			return Continue
+		}
+		var fields []logging.Field
+		if pkt.InInterface != "" {
+			fields = append(fields, logging.F("in", pkt.InInterface))
+		}
+		if pkt.OutInterface != "" {
+			fields = append(fields, logging.F("out", pkt.OutInterface))
+		}
+		logger.Packet(pkt.Point.String(), pkt.Decode(), fields...)
+		return Continue
+	}
+}
+
+// Decode decodes the outermost layer set on the packet and everything it
+// carries.
+func (pkt *Packet) Decode() *decode.Packet {
+	switch {
+	case pkt.Frame != nil:
+		return decode.FromFrame(pkt.Frame)
+	case pkt.IP != nil:
+		return decode.FromIPv4(pkt.IP)
+	case pkt.TCP != nil:
+		return decode.FromTCP(pkt.TCP, pkt.Source, pkt.Destination)
+	default:
+		return &decode.Packet{}
+	}
+}
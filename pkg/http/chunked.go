@@ -0,0 +1,166 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxChunkLineLength bounds a chunk size line, including extensions.
+const maxChunkLineLength = 4096
+
+// ErrMalformedChunk is returned when a chunked body cannot be decoded.
+var ErrMalformedChunk = errors.New("malformed chunked encoding")
+
+// chunkedReader decodes a body in chunked transfer coding (RFC 7230 4.1).
+// Trailer fields are added to trailer once the last chunk has been read.
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining uint64 // Bytes left in the current chunk
+	trailer   Header
+	done      bool
+	err       error
+}
+
+func newChunkedReader(r *bufio.Reader, trailer Header) *chunkedReader {
+	return &chunkedReader{r: r, trailer: trailer}
+}
+
+// Read reads body data, crossing chunk boundaries as needed.
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if c.remaining == 0 {
+		size, err := c.readChunkSize()
+		if err != nil {
+			c.err = err
+			return 0, err
+		}
+		if size == 0 {
+			if err := c.readTrailer(); err != nil {
+				c.err = err
+				return 0, err
+			}
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= uint64(n)
+
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		c.err = err
+		return n, err
+	}
+
+	// Every chunk's data is followed by CRLF
+	if c.remaining == 0 {
+		if err := c.readCRLF(); err != nil {
+			c.err = err
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readChunkSize reads a chunk size line, ignoring any chunk extensions.
+func (c *chunkedReader) readChunkSize() (uint64, error) {
+	line, err := readLine(c.r, maxChunkLineLength)
+	if err != nil {
+		// The body must end with the last chunk, not the connection
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimRight(line, " \t")
+
+	size, err := strconv.ParseUint(line, 16, 62)
+	if err != nil || line == "" {
+		return 0, fmt.Errorf("%w: invalid chunk size %q", ErrMalformedChunk, line)
+	}
+	return size, nil
+}
+
+// readTrailer reads the trailer section following the last chunk.
+func (c *chunkedReader) readTrailer() error {
+	for {
+		line, err := readLine(c.r, maxChunkLineLength)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+		key, value, err := parseHeaderLine(line)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedChunk, err)
+		}
+		if c.trailer != nil {
+			c.trailer.Add(key, value)
+		}
+	}
+}
+
+// readCRLF consumes the line break that ends a chunk's data.
+func (c *chunkedReader) readCRLF() error {
+	line, err := readLine(c.r, 2)
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return fmt.Errorf("%w: missing CRLF after chunk data", ErrMalformedChunk)
+	}
+	return nil
+}
+
+// chunkedWriter encodes a body in chunked transfer coding. Each Write
+// becomes one chunk; Close writes the last chunk and an empty trailer.
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	// A zero-length chunk would end the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(c.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := io.WriteString(c.w, "\r\n"); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (c *chunkedWriter) Close() error {
+	_, err := io.WriteString(c.w, "0\r\n\r\n")
+	return err
+}
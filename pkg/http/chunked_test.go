@@ -0,0 +1,79 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestChunkedReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		trailer string
+		wantErr error
+	}{
+		{"single chunk", "5\r\nhello\r\n0\r\n\r\n", "hello", "", nil},
+		{"several chunks", "3\r\nabc\r\na\r\n0123456789\r\n0\r\n\r\n", "abc0123456789", "", nil},
+		{"extensions", "3;ext=1\r\nabc\r\n0;last\r\n\r\n", "abc", "", nil},
+		{"trailer", "1\r\nx\r\n0\r\nExpires: never\r\n\r\n", "x", "never", nil},
+		{"upper-case hex", "A\r\n0123456789\r\n0\r\n\r\n", "0123456789", "", nil},
+		{"bare LF", "3\nabc\n0\n\n", "abc", "", nil},
+		{"invalid size", "zz\r\nabc\r\n0\r\n\r\n", "", "", ErrMalformedChunk},
+		{"empty size", "\r\nabc\r\n", "", "", ErrMalformedChunk},
+		{"missing CRLF", "3\r\nabcd\r\n0\r\n\r\n", "abc", "", ErrMalformedChunk},
+		{"oversized chunk", "ffffffffffffffffff\r\n", "", "", ErrMalformedChunk},
+		{"truncated data", "5\r\nab", "ab", "", io.ErrUnexpectedEOF},
+		{"missing last chunk", "3\r\nabc\r\n", "abc", "", io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trailer := make(Header)
+			cr := newChunkedReader(bufio.NewReader(strings.NewReader(tt.input)), trailer)
+			got, err := io.ReadAll(cr)
+
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadAll() error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("data = %q, want %q", got, tt.want)
+			}
+			if trailer.Get("Expires") != tt.trailer {
+				t.Errorf("trailer Expires = %q, want %q", trailer.Get("Expires"), tt.trailer)
+			}
+		})
+	}
+}
+
+func TestChunkedRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	cw := &chunkedWriter{w: &buf}
+
+	parts := []string{"first", "", strings.Repeat("z", 300), "last"}
+	for _, p := range parts {
+		if _, err := cw.Write([]byte(p)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	cw.Close()
+
+	if !strings.HasPrefix(buf.String(), "5\r\nfirst\r\n12c\r\n") {
+		t.Errorf("encoding = %q, want 5\\r\\nfirst\\r\\n12c...", buf.String()[:20])
+	}
+
+	got, err := io.ReadAll(newChunkedReader(bufio.NewReader(&buf), nil))
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if want := strings.Join(parts, ""); string(got) != want {
+		t.Errorf("round trip = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,723 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxRedirects is how many redirects a client follows by default.
+	DefaultMaxRedirects = 10
+
+	// DefaultMaxIdleConnsPerHost is how many idle connections a client
+	// keeps per host by default.
+	DefaultMaxIdleConnsPerHost = 2
+
+	// DefaultIdleConnTimeout is how long an idle connection is kept.
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// defaultUserAgent is sent unless the request sets a User-Agent.
+	defaultUserAgent = "network-stack-http-client/1.0"
+)
+
+var (
+	// ErrTooManyRedirects is returned when a client exceeds its redirect limit.
+	ErrTooManyRedirects = errors.New("http: too many redirects")
+
+	// ErrUnsupportedScheme is returned for URLs other than http.
+	ErrUnsupportedScheme = errors.New("http: unsupported URL scheme")
+
+	// ErrNoDialer is returned by a client without a Dial function.
+	ErrNoDialer = errors.New("http: client has no dialer")
+)
+
+// DialFunc opens a connection to a host and port.
+type DialFunc func(host string, port uint16) (Conn, error)
+
+// Response is an HTTP response received by a client.
+type Response struct {
+	Status     string // e.g. "200 OK"
+	StatusCode int
+	Proto      string
+	ProtoMajor int
+	ProtoMinor int
+
+	Header Header
+
+	// ContentLength is the body length, or -1 if it is unknown.
+	ContentLength int64
+
+	// TransferEncoding lists the transfer codings applied to the body.
+	TransferEncoding []string
+
+	// Close is set if the server closes the connection after this response.
+	Close bool
+
+	// Body is the response body. It is never nil and must be closed; a
+	// body read to the end lets the connection be reused.
+	Body io.ReadCloser
+
+	// Trailer holds trailer fields of a chunked body, filled once the body
+	// has been read to the end.
+	Trailer Header
+
+	// Request is the request that was sent to obtain this response.
+	Request *Request
+}
+
+// Location returns the resolved target of a redirect response.
+func (r *Response) Location() (*url.URL, error) {
+	loc := r.Header.Get("Location")
+	if loc == "" {
+		return nil, fmt.Errorf("no Location header")
+	}
+	target, err := url.Parse(loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Location %q: %w", loc, err)
+	}
+	if r.Request != nil && r.Request.URL != nil {
+		target = r.Request.URL.ResolveReference(target)
+	}
+	return target, nil
+}
+
+// NewRequest builds a client request. A body from bytes.Buffer,
+// bytes.Reader or strings.Reader is sent with a Content-Length and can be
+// resent on redirect; other bodies are sent chunked.
+func NewRequest(method, rawURL string, body io.Reader) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedScheme, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("URL %q has no host", rawURL)
+	}
+
+	req := &Request{
+		Method:     method,
+		URL:        u,
+		Target:     u.RequestURI(),
+		Path:       u.Path,
+		RawQuery:   u.RawQuery,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(Header),
+		Host:       u.Host,
+		Body:       noBody{},
+	}
+
+	switch b := body.(type) {
+	case nil:
+	case *bytes.Buffer:
+		data := b.Bytes()
+		req.setBytesBody(data)
+	case *bytes.Reader:
+		data, _ := io.ReadAll(b)
+		req.setBytesBody(data)
+	case *strings.Reader:
+		data, _ := io.ReadAll(b)
+		req.setBytesBody(data)
+	default:
+		req.ContentLength = -1
+		if rc, ok := body.(io.ReadCloser); ok {
+			req.Body = rc
+		} else {
+			req.Body = io.NopCloser(body)
+		}
+	}
+	return req, nil
+}
+
+// setBytesBody sets a body that can be resent.
+func (req *Request) setBytesBody(data []byte) {
+	req.ContentLength = int64(len(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.Body, _ = req.GetBody()
+}
+
+// Client sends HTTP/1.1 requests, reusing connections to the same host and
+// following redirects.
+type Client struct {
+	// Dial opens connections (required).
+	Dial DialFunc
+
+	// MaxRedirects is how many redirects to follow (DefaultMaxRedirects if
+	// zero, none if negative).
+	MaxRedirects int
+
+	// MaxIdleConnsPerHost bounds the idle connections kept per host
+	// (DefaultMaxIdleConnsPerHost if zero, none if negative).
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept
+	// (DefaultIdleConnTimeout if zero).
+	IdleConnTimeout time.Duration
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn // Idle connections by "host:port", most recent last
+
+	// now returns the current time (overridden in tests).
+	now func() time.Time
+}
+
+// persistConn is a client connection that may carry several requests.
+type persistConn struct {
+	key       string
+	conn      Conn
+	br        *bufio.Reader
+	bw        *bufio.Writer
+	idleSince time.Time
+	reused    bool
+}
+
+// Get issues a GET request.
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Head issues a HEAD request.
+func (c *Client) Head(rawURL string) (*Response, error) {
+	req, err := NewRequest("HEAD", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post issues a POST request with a body of the given content type.
+func (c *Client) Post(rawURL, contentType string, body io.Reader) (*Response, error) {
+	req, err := NewRequest("POST", rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// Do sends a request and returns the response, following redirects. The
+// response of the last request is returned: redirects that cannot be
+// followed (no Location, or a body that cannot be resent) are returned as is.
+func (c *Client) Do(req *Request) (*Response, error) {
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = DefaultMaxRedirects
+	}
+
+	for redirects := 0; ; redirects++ {
+		resp, err := c.send(req)
+		if err != nil {
+			return nil, err
+		}
+
+		next, ok := redirectRequest(req, resp)
+		if !ok || maxRedirects < 0 {
+			return resp, nil
+		}
+
+		// Release the connection before following the redirect
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+		resp.Body.Close()
+
+		if redirects >= maxRedirects {
+			return nil, fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, redirects)
+		}
+		req = next
+	}
+}
+
+// redirectRequest builds the request that follows a redirect response
+// (RFC 7231 6.4). Returns false if the response is not a redirect to follow.
+func redirectRequest(req *Request, resp *Response) (*Request, bool) {
+	method := req.Method
+	keepBody := false
+	switch resp.StatusCode {
+	case StatusMovedPermanently, StatusFound, StatusSeeOther:
+		// Clients change POST to GET (RFC 7231 6.4.2, 6.4.3, 6.4.4)
+		if method != "GET" && method != "HEAD" {
+			method = "GET"
+		}
+	case StatusTemporaryRedirect, StatusPermanentRedirect:
+		keepBody = true
+		if req.GetBody == nil && req.ContentLength != 0 {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	target, err := resp.Location()
+	if err != nil || target.Scheme != "http" {
+		return nil, false
+	}
+
+	next, err := NewRequest(method, target.String(), nil)
+	if err != nil {
+		return nil, false
+	}
+	for key, values := range req.Header {
+		next.Header[key] = append([]string(nil), values...)
+	}
+
+	// Credentials are not forwarded to another host
+	if target.Host != req.URL.Host {
+		next.Header.Del("Authorization")
+		next.Header.Del("Cookie")
+	}
+
+	if keepBody && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, false
+		}
+		next.Body, next.GetBody, next.ContentLength = body, req.GetBody, req.ContentLength
+	} else {
+		next.Header.Del("Content-Type")
+		next.Header.Del("Content-Length")
+	}
+	return next, true
+}
+
+// send sends one request, on an idle connection if there is one. A request
+// that fails on a reused connection before any response arrives is retried
+// once on a new connection, since the server may have closed it while idle.
+func (c *Client) send(req *Request) (*Response, error) {
+	host, port, err := hostPort(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	key := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	for attempt := 0; ; attempt++ {
+		pc, err := c.getConn(key, host, port, attempt == 0)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.roundTrip(pc, req)
+		if err == nil {
+			return resp, nil
+		}
+		pc.conn.Close()
+
+		if !pc.reused || attempt > 0 || !errors.Is(err, errConnReset) || !canRetry(req) {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// errConnReset marks a failure before any response byte was read.
+var errConnReset = errors.New("connection closed before response")
+
+// canRetry reports whether a request can be sent again.
+func canRetry(req *Request) bool {
+	return req.ContentLength == 0 || req.GetBody != nil
+}
+
+// roundTrip writes a request and reads the response header.
+func (c *Client) roundTrip(pc *persistConn, req *Request) (*Response, error) {
+	if err := writeRequest(pc.bw, req); err != nil {
+		return nil, fmt.Errorf("%w: %v", errConnReset, err)
+	}
+
+	// Skip interim responses
+	for {
+		if _, err := pc.br.Peek(1); err != nil {
+			return nil, fmt.Errorf("%w: %v", errConnReset, err)
+		}
+		resp, err := ReadResponse(pc.br, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 || resp.StatusCode == 101 {
+			c.attachConn(pc, resp)
+			return resp, nil
+		}
+	}
+}
+
+// attachConn arranges for the connection to return to the pool, or close,
+// once the response body is done.
+func (c *Client) attachConn(pc *persistConn, resp *Response) {
+	reusable := !resp.Close && !resp.Request.Close && !resp.Request.Header.hasToken("Connection", "close")
+	resp.Body = &clientBody{
+		body: resp.Body,
+		done: func(complete bool) {
+			if complete && reusable {
+				c.putIdle(pc)
+			} else {
+				pc.conn.Close()
+			}
+		},
+	}
+}
+
+// getConn returns an idle connection for key or dials a new one.
+func (c *Client) getConn(key, host string, port uint16, allowIdle bool) (*persistConn, error) {
+	if allowIdle {
+		if pc := c.takeIdle(key); pc != nil {
+			return pc, nil
+		}
+	}
+
+	if c.Dial == nil {
+		return nil, ErrNoDialer
+	}
+	conn, err := c.Dial(host, port)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", key, err)
+	}
+	return &persistConn{
+		key:  key,
+		conn: conn,
+		br:   bufio.NewReader(conn),
+		bw:   bufio.NewWriter(conn),
+	}, nil
+}
+
+// takeIdle removes the most recently used idle connection for key,
+// closing those that have been idle too long.
+func (c *Client) takeIdle(key string) *persistConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.currentTime()
+	conns := c.idle[key]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if now.Sub(pc.idleSince) > c.idleConnTimeout() {
+			pc.conn.Close()
+			continue
+		}
+		c.idle[key] = conns
+		pc.reused = true
+		return pc
+	}
+	delete(c.idle, key)
+	return nil
+}
+
+// putIdle returns a connection to the pool.
+func (c *Client) putIdle(pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	limit := c.MaxIdleConnsPerHost
+	if limit == 0 {
+		limit = DefaultMaxIdleConnsPerHost
+	}
+	if limit < 0 || len(c.idle[pc.key]) >= limit {
+		pc.conn.Close()
+		return
+	}
+
+	if c.idle == nil {
+		c.idle = make(map[string][]*persistConn)
+	}
+	pc.idleSince = c.currentTime()
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+}
+
+// CloseIdleConnections closes all idle connections.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, conns := range c.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+	}
+	c.idle = nil
+}
+
+// IdleConnections returns the number of idle connections in the pool.
+func (c *Client) IdleConnections() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, conns := range c.idle {
+		n += len(conns)
+	}
+	return n
+}
+
+func (c *Client) idleConnTimeout() time.Duration {
+	if c.IdleConnTimeout > 0 {
+		return c.IdleConnTimeout
+	}
+	return DefaultIdleConnTimeout
+}
+
+func (c *Client) currentTime() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// hostPort returns the host and port of an http URL.
+func hostPort(u *url.URL) (string, uint16, error) {
+	if u == nil {
+		return "", 0, fmt.Errorf("request has no URL")
+	}
+	if u.Scheme != "http" {
+		return "", 0, fmt.Errorf("%w: %q", ErrUnsupportedScheme, u.Scheme)
+	}
+	port := uint16(80)
+	if p := u.Port(); p != "" {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || n == 0 {
+			return "", 0, fmt.Errorf("invalid port %q", p)
+		}
+		port = uint16(n)
+	}
+	return u.Hostname(), port, nil
+}
+
+// writeRequest writes a request line, header and body.
+func writeRequest(w *bufio.Writer, req *Request) error {
+	target := req.Target
+	if target == "" {
+		target = req.URL.RequestURI()
+	}
+	fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", req.Method, target)
+
+	h := req.Header.Clone()
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	h.Set("Host", host)
+	if h.Get("User-Agent") == "" {
+		h.Set("User-Agent", defaultUserAgent)
+	}
+
+	body := req.Body
+	if body == nil {
+		body = noBody{}
+	}
+	var chunked bool
+	switch {
+	case req.ContentLength > 0:
+		h.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+		h.Del("Transfer-Encoding")
+	case req.ContentLength < 0:
+		h.Set("Transfer-Encoding", "chunked")
+		h.Del("Content-Length")
+		chunked = true
+	default:
+		h.Del("Transfer-Encoding")
+		// Methods that normally carry a body say when it is empty
+		if req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+			h.Set("Content-Length", "0")
+		}
+	}
+	h.write(w)
+	w.WriteString("\r\n")
+
+	var err error
+	switch {
+	case chunked:
+		cw := &chunkedWriter{w: w}
+		if _, err = io.Copy(cw, body); err == nil {
+			err = cw.Close()
+		}
+	case req.ContentLength > 0:
+		var n int64
+		n, err = io.Copy(w, io.LimitReader(body, req.ContentLength))
+		if err == nil && n != req.ContentLength {
+			err = fmt.Errorf("body has %d bytes, want Content-Length %d", n, req.ContentLength)
+		}
+	}
+	body.Close()
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// ReadResponse reads a response to req from r (RFC 7230 3.3.3).
+func ReadResponse(r *bufio.Reader, req *Request) (*Response, error) {
+	line, err := readLine(r, DefaultMaxHeaderBytes)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	// HTTP-version SP status-code SP reason-phrase
+	proto, status, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, fmt.Errorf("malformed status line %q", line)
+	}
+	major, minor, ok := parseHTTPVersion(proto)
+	if !ok {
+		return nil, fmt.Errorf("malformed HTTP version %q", proto)
+	}
+	code, _, _ := strings.Cut(status, " ")
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || len(code) != 3 || statusCode < 100 {
+		return nil, fmt.Errorf("malformed status code %q", code)
+	}
+
+	resp := &Response{
+		Status:     strings.TrimSpace(status),
+		StatusCode: statusCode,
+		Proto:      proto,
+		ProtoMajor: major,
+		ProtoMinor: minor,
+		Header:     make(Header),
+		Request:    req,
+	}
+
+	limit := DefaultMaxHeaderBytes
+	for {
+		line, err := readLine(r, limit)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		limit -= len(line)
+		key, value, err := parseHeaderLine(line)
+		if err != nil {
+			return nil, err
+		}
+		resp.Header.Add(key, value)
+	}
+
+	if major < 1 || (major == 1 && minor == 0) {
+		resp.Close = !resp.Header.hasToken("Connection", "keep-alive")
+	} else {
+		resp.Close = resp.Header.hasToken("Connection", "close")
+	}
+
+	if err := resp.setupBody(r); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// setupBody determines how the response body is delimited.
+func (resp *Response) setupBody(r *bufio.Reader) error {
+	// Responses to HEAD and 1xx, 204 and 304 responses have no body
+	if (resp.Request != nil && resp.Request.Method == "HEAD") || !bodyAllowed(resp.StatusCode) {
+		resp.ContentLength = 0
+		if n, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil && resp.Request != nil && resp.Request.Method == "HEAD" {
+			resp.ContentLength = n
+		}
+		resp.Body = noBody{}
+		return nil
+	}
+
+	if codings := resp.Header.Values("Transfer-Encoding"); len(codings) > 0 {
+		for _, value := range codings {
+			for _, coding := range strings.Split(value, ",") {
+				if coding = strings.TrimSpace(coding); coding != "" {
+					resp.TransferEncoding = append(resp.TransferEncoding, strings.ToLower(coding))
+				}
+			}
+		}
+		if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+			return fmt.Errorf("unsupported transfer coding %v", resp.TransferEncoding)
+		}
+		resp.ContentLength = -1
+		resp.Trailer = make(Header)
+		resp.Body = &body{src: newChunkedReader(r, resp.Trailer)}
+		return nil
+	}
+
+	if cl := resp.Header.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(strings.TrimSpace(cl), 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid Content-Length %q", cl)
+		}
+		resp.ContentLength = n
+		if n == 0 {
+			resp.Body = noBody{}
+			return nil
+		}
+		resp.Body = &body{src: io.LimitReader(r, n)}
+		return nil
+	}
+
+	// The body runs to the end of the connection
+	resp.ContentLength = -1
+	resp.Close = true
+	resp.Body = &body{src: r}
+	return nil
+}
+
+// clientBody reports when a response body has been consumed, so that its
+// connection can be reused.
+type clientBody struct {
+	body   io.ReadCloser
+	done   func(complete bool)
+	sawEOF bool
+	closed bool
+}
+
+func (b *clientBody) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	n, err := b.body.Read(p)
+	if err == io.EOF && !b.sawEOF {
+		b.sawEOF = true
+		b.done(true)
+	} else if err != nil && err != io.EOF && !b.sawEOF {
+		b.sawEOF = true
+		b.done(false)
+	}
+	return n, err
+}
+
+// Close releases the connection. A body closed before it was read to the
+// end closes the connection instead of returning it to the pool.
+func (b *clientBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if !b.sawEOF {
+		// Empty bodies are complete without a read
+		if _, ok := b.body.(noBody); ok {
+			b.done(true)
+		} else {
+			b.done(false)
+		}
+		b.sawEOF = true
+	}
+	return nil
+}
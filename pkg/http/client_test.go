@@ -0,0 +1,371 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClient returns a client whose connections are served in memory by s,
+// and a counter of the connections it dialed.
+func testClient(t *testing.T, s *Server) (*Client, *atomic.Int32) {
+	t.Helper()
+	var dials atomic.Int32
+	c := &Client{
+		Dial: func(host string, port uint16) (Conn, error) {
+			dials.Add(1)
+			client, server := net.Pipe()
+			go s.ServeConn(server)
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+			return client, nil
+		},
+	}
+	t.Cleanup(c.CloseIdleConnections)
+	return c, &dials
+}
+
+// readBody reads and closes a response body.
+func readBody(t *testing.T, resp *Response) string {
+	t.Helper()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body error = %v", err)
+	}
+	resp.Body.Close()
+	return string(data)
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	c, dials := testClient(t, &Server{Handler: echoHandler})
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(fmt.Sprintf("http://example.com/n/%d", i))
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if got, want := readBody(t, resp), fmt.Sprintf("GET /n/%d ", i); got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+		if resp.StatusCode != StatusOK || resp.Status != "200 OK" {
+			t.Errorf("status = %d %q, want 200 OK", resp.StatusCode, resp.Status)
+		}
+	}
+
+	if n := dials.Load(); n != 1 {
+		t.Errorf("dialed %d connections, want 1", n)
+	}
+	if n := c.IdleConnections(); n != 1 {
+		t.Errorf("IdleConnections() = %d, want 1", n)
+	}
+}
+
+func TestClientConnectionNotReused(t *testing.T) {
+	tests := []struct {
+		name  string
+		fetch func(c *Client) (*Response, error)
+		drain bool
+	}{
+		{"server closes", func(c *Client) (*Response, error) {
+			req, _ := NewRequest("GET", "http://a/", nil)
+			req.Header.Set("Connection", "close")
+			return c.Do(req)
+		}, true},
+		{"body not read", func(c *Client) (*Response, error) {
+			return c.Get("http://a/")
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, dials := testClient(t, &Server{Handler: echoHandler})
+			for i := 0; i < 2; i++ {
+				resp, err := tt.fetch(c)
+				if err != nil {
+					t.Fatalf("request error = %v", err)
+				}
+				if tt.drain {
+					readBody(t, resp)
+				} else {
+					resp.Body.Close()
+				}
+			}
+			if n := dials.Load(); n != 2 {
+				t.Errorf("dialed %d connections, want 2", n)
+			}
+			if n := c.IdleConnections(); n != 0 {
+				t.Errorf("IdleConnections() = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestClientRequestBodies(t *testing.T) {
+	c, _ := testClient(t, &Server{Handler: echoHandler})
+
+	// Known length
+	resp, err := c.Post("http://a/form", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if got := readBody(t, resp); got != "POST /form hello" {
+		t.Errorf("body = %q, want %q", got, "POST /form hello")
+	}
+
+	// Unknown length is sent chunked
+	pr, pw := io.Pipe()
+	go func() {
+		io.WriteString(pw, "streamed ")
+		io.WriteString(pw, "body")
+		pw.Close()
+	}()
+	req, _ := NewRequest("PUT", "http://a/up", pr)
+	if req.ContentLength != -1 {
+		t.Errorf("ContentLength = %d, want -1", req.ContentLength)
+	}
+	resp, err = c.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if got := readBody(t, resp); got != "PUT /up streamed body" {
+		t.Errorf("body = %q, want %q", got, "PUT /up streamed body")
+	}
+}
+
+func TestClientResponseFraming(t *testing.T) {
+	block := strings.Repeat("b", 3000)
+	mux := HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.Path {
+		case "/chunked":
+			for i := 0; i < 3; i++ {
+				io.WriteString(w, block)
+			}
+		case "/length":
+			w.Header().Set("Content-Length", "3000")
+			io.WriteString(w, block)
+		case "/empty":
+			w.WriteHeader(StatusNoContent)
+		}
+	})
+	c, dials := testClient(t, &Server{Handler: mux})
+
+	tests := []struct {
+		method string
+		path   string
+		want   int   // Body length
+		length int64 // Response ContentLength
+	}{
+		{"GET", "/chunked", 9000, -1},
+		{"GET", "/length", 3000, 3000},
+		{"HEAD", "/length", 0, 3000},
+		{"GET", "/empty", 0, 0},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest(tt.method, "http://a"+tt.path, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error = %v", tt.method, tt.path, err)
+		}
+		if got := readBody(t, resp); len(got) != tt.want {
+			t.Errorf("%s %s: body length = %d, want %d", tt.method, tt.path, len(got), tt.want)
+		}
+		if resp.ContentLength != tt.length {
+			t.Errorf("%s %s: ContentLength = %d, want %d", tt.method, tt.path, resp.ContentLength, tt.length)
+		}
+	}
+
+	// Every response left the connection reusable
+	if n := dials.Load(); n != 1 {
+		t.Errorf("dialed %d connections, want 1", n)
+	}
+}
+
+func TestClientRedirects(t *testing.T) {
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.Path {
+		case "/found":
+			w.Header().Set("Location", "/target")
+			w.WriteHeader(StatusFound)
+		case "/temporary":
+			w.Header().Set("Location", "http://a/target")
+			w.WriteHeader(StatusTemporaryRedirect)
+		case "/loop":
+			w.Header().Set("Location", "/loop")
+			w.WriteHeader(StatusMovedPermanently)
+		case "/nowhere":
+			w.WriteHeader(StatusFound)
+		default:
+			echoHandler(w, r)
+		}
+	})
+	c, _ := testClient(t, &Server{Handler: handler})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   string
+		status int
+	}{
+		{"302 changes POST to GET", "POST", "/found", "GET /target ", StatusOK},
+		{"307 keeps method and body", "POST", "/temporary", "POST /target data", StatusOK},
+		{"missing Location", "GET", "/nowhere", "", StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := NewRequest(tt.method, "http://a"+tt.path, strings.NewReader("data"))
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			if got := readBody(t, resp); got != tt.want || resp.StatusCode != tt.status {
+				t.Errorf("response = %d %q, want %d %q", resp.StatusCode, got, tt.status, tt.want)
+			}
+		})
+	}
+
+	if _, err := c.Get("http://a/loop"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("Get() loop error = %v, want ErrTooManyRedirects", err)
+	}
+
+	// Redirects are returned as is when following is disabled
+	c.MaxRedirects = -1
+	resp, err := c.Get("http://a/found")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if loc, _ := resp.Location(); resp.StatusCode != StatusFound || loc.String() != "http://a/target" {
+		t.Errorf("response = %d %v, want 302 to http://a/target", resp.StatusCode, loc)
+	}
+}
+
+// staleServer answers one request per connection and then closes it
+// without announcing so, like a server whose keep-alive timeout expired.
+func staleServer(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	req, err := readRequest(r, DefaultMaxHeaderBytes)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(req.Path), req.Path)
+}
+
+func TestClientRetriesStaleConnection(t *testing.T) {
+	var mu sync.Mutex
+	dials := 0
+	c := &Client{
+		Dial: func(host string, port uint16) (Conn, error) {
+			mu.Lock()
+			dials++
+			mu.Unlock()
+			client, server := net.Pipe()
+			go staleServer(server)
+			return client, nil
+		},
+	}
+
+	for _, path := range []string{"/one", "/two"} {
+		resp, err := c.Get("http://a" + path)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", path, err)
+		}
+		if got := readBody(t, resp); got != path {
+			t.Errorf("body = %q, want %q", got, path)
+		}
+		// Let the server close its side before the next request
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if dials != 2 {
+		t.Errorf("dialed %d connections, want 2", dials)
+	}
+}
+
+func TestClientIdleTimeout(t *testing.T) {
+	c, dials := testClient(t, &Server{Handler: echoHandler})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	resp, _ := c.Get("http://a/")
+	readBody(t, resp)
+
+	now = now.Add(DefaultIdleConnTimeout + time.Second)
+	resp, err := c.Get("http://a/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	readBody(t, resp)
+
+	if n := dials.Load(); n != 2 {
+		t.Errorf("dialed %d connections, want 2", n)
+	}
+}
+
+func TestReadResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		status  int
+		body    string
+		close   bool
+		wantErr bool
+	}{
+		{"content length", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nhi", 200, "hi", false, false},
+		{"read to close", "HTTP/1.1 200 OK\r\n\r\nuntil the end", 200, "until the end", true, false},
+		{"HTTP/1.0 default close", "HTTP/1.0 200 OK\r\nContent-Length: 1\r\n\r\nx", 200, "x", true, false},
+		{"no reason phrase", "HTTP/1.1 404\r\nContent-Length: 0\r\n\r\n", 404, "", false, false},
+		{"not modified", "HTTP/1.1 304 Not Modified\r\nContent-Length: 50\r\n\r\n", 304, "", false, false},
+		{"bad status", "HTTP/1.1 20x OK\r\n\r\n", 0, "", false, true},
+		{"bad version", "HTTP/x 200 OK\r\n\r\n", 0, "", false, true},
+		{"bad length", "HTTP/1.1 200 OK\r\nContent-Length: nope\r\n\r\n", 0, "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := NewRequest("GET", "http://a/", nil)
+			resp, err := ReadResponse(bufio.NewReader(strings.NewReader(tt.input)), req)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("ReadResponse() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadResponse() error = %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status || string(body) != tt.body || resp.Close != tt.close {
+				t.Errorf("response = %d %q close=%v, want %d %q close=%v",
+					resp.StatusCode, body, resp.Close, tt.status, tt.body, tt.close)
+			}
+		})
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	if _, err := NewRequest("GET", "https://a/", nil); !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("NewRequest() https error = %v, want ErrUnsupportedScheme", err)
+	}
+	if _, err := NewRequest("GET", "http:///path", nil); err == nil {
+		t.Error("NewRequest() without host succeeded, want error")
+	}
+
+	req, err := NewRequest("GET", "http://example.com:8080/a/b?q=1", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if req.Target != "/a/b?q=1" || req.Host != "example.com:8080" || req.ContentLength != 0 {
+		t.Errorf("request = %s %s %d, want /a/b?q=1 example.com:8080 0", req.Target, req.Host, req.ContentLength)
+	}
+	if host, port, _ := hostPort(req.URL); host != "example.com" || port != 8080 {
+		t.Errorf("hostPort() = %s %d, want example.com 8080", host, port)
+	}
+}
@@ -0,0 +1,150 @@
+// Package http implements an HTTP/1.1 server and client (RFC 7230, RFC 7231)
+// over the stack's TCP sockets.
+//
+// The server supports persistent connections, request bodies delimited by
+// Content-Length or chunked transfer coding, and chunked responses when a
+// handler streams a body of unknown length. Handlers follow the shape of
+// the standard library's http.Handler.
+//
+// The client pools connections per host and follows redirects; it dials
+// through a DialFunc, normally a TCPDialer using tcp.Socket.Connect.
+package http
+
+import (
+	"bufio"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// HTTP status codes.
+const (
+	StatusContinue              = 100
+	StatusOK                    = 200
+	StatusCreated               = 201
+	StatusNoContent             = 204
+	StatusMovedPermanently      = 301
+	StatusFound                 = 302
+	StatusSeeOther              = 303
+	StatusNotModified           = 304
+	StatusTemporaryRedirect     = 307
+	StatusPermanentRedirect     = 308
+	StatusBadRequest            = 400
+	StatusForbidden             = 403
+	StatusNotFound              = 404
+	StatusMethodNotAllowed      = 405
+	StatusLengthRequired        = 411
+	StatusRequestEntityTooLarge = 413
+	StatusURITooLong            = 414
+	StatusHeaderFieldsTooLarge  = 431
+	StatusInternalServerError   = 500
+	StatusNotImplemented        = 501
+	StatusServiceUnavailable    = 503
+	StatusVersionNotSupported   = 505
+)
+
+var statusText = map[int]string{
+	StatusContinue:              "Continue",
+	StatusOK:                    "OK",
+	StatusCreated:               "Created",
+	StatusNoContent:             "No Content",
+	StatusMovedPermanently:      "Moved Permanently",
+	StatusFound:                 "Found",
+	StatusSeeOther:              "See Other",
+	StatusNotModified:           "Not Modified",
+	StatusTemporaryRedirect:     "Temporary Redirect",
+	StatusPermanentRedirect:     "Permanent Redirect",
+	StatusBadRequest:            "Bad Request",
+	StatusForbidden:             "Forbidden",
+	StatusNotFound:              "Not Found",
+	StatusMethodNotAllowed:      "Method Not Allowed",
+	StatusLengthRequired:        "Length Required",
+	StatusRequestEntityTooLarge: "Request Entity Too Large",
+	StatusURITooLong:            "URI Too Long",
+	StatusHeaderFieldsTooLarge:  "Request Header Fields Too Large",
+	StatusInternalServerError:   "Internal Server Error",
+	StatusNotImplemented:        "Not Implemented",
+	StatusServiceUnavailable:    "Service Unavailable",
+	StatusVersionNotSupported:   "HTTP Version Not Supported",
+}
+
+// StatusText returns the reason phrase for a status code, or "" if unknown.
+func StatusText(code int) string {
+	return statusText[code]
+}
+
+// Header holds HTTP header fields, keyed by canonical field name.
+type Header map[string][]string
+
+// Get returns the first value of a field, or "" if it is not present.
+func (h Header) Get(key string) string {
+	if values := h[textproto.CanonicalMIMEHeaderKey(key)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns all values of a field.
+func (h Header) Values(key string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(key)]
+}
+
+// Set replaces the values of a field.
+func (h Header) Set(key, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{value}
+}
+
+// Add appends a value to a field.
+func (h Header) Add(key, value string) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Del removes a field.
+func (h Header) Del(key string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// Clone returns a copy of the header.
+func (h Header) Clone() Header {
+	clone := make(Header, len(h))
+	for key, values := range h {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// hasToken reports whether a comma-separated field (such as Connection)
+// contains a token, ignoring case.
+func (h Header) hasToken(key, token string) bool {
+	for _, value := range h.Values(key) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headerValueReplacer strips line breaks from field values so that a
+// handler cannot split a response.
+var headerValueReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// write writes the fields in sorted order, each terminated by CRLF.
+func (h Header) write(w *bufio.Writer) {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range h[key] {
+			w.WriteString(key)
+			w.WriteString(": ")
+			w.WriteString(headerValueReplacer.Replace(strings.TrimSpace(value)))
+			w.WriteString("\r\n")
+		}
+	}
+}
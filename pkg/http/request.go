@@ -0,0 +1,382 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrLineTooLong is returned when a request line or header field
+	// exceeds the configured limit.
+	ErrLineTooLong = errors.New("header line too long")
+
+	// ErrBodyClosed is returned when reading a body after Close.
+	ErrBodyClosed = errors.New("read on closed body")
+)
+
+// Request is an HTTP request received by a Server or sent by a Client.
+type Request struct {
+	Method     string
+	URL        *url.URL
+	Target     string // Request target as sent (e.g., "/index.html?lang=en")
+	Path       string // Target without the query
+	RawQuery   string // Query without the leading '?'
+	Proto      string // "HTTP/1.0" or "HTTP/1.1"
+	ProtoMajor int
+	ProtoMinor int
+
+	Header Header
+	Host   string
+
+	// ContentLength is the body length, or -1 if it is chunked.
+	ContentLength int64
+
+	// TransferEncoding lists the transfer codings applied to the body.
+	TransferEncoding []string
+
+	// Close is set if the client asked to close the connection after
+	// this request.
+	Close bool
+
+	// Body is the request body. It is never nil and returns io.EOF
+	// immediately if the request has no body.
+	Body io.ReadCloser
+
+	// GetBody returns a new copy of Body, so that a client can resend the
+	// request on redirect or retry. Nil if the body cannot be resent.
+	GetBody func() (io.ReadCloser, error)
+
+	// Trailer holds trailer fields of a chunked body, filled once the body
+	// has been read to the end.
+	Trailer Header
+
+	// RemoteAddr is the client's address, if the connection reports one.
+	RemoteAddr string
+}
+
+// ProtoAtLeast reports whether the request's protocol version is at least major.minor.
+func (r *Request) ProtoAtLeast(major, minor int) bool {
+	return r.ProtoMajor > major || (r.ProtoMajor == major && r.ProtoMinor >= minor)
+}
+
+// Query parses the query string.
+func (r *Request) Query() url.Values {
+	values, _ := url.ParseQuery(r.RawQuery)
+	return values
+}
+
+// expectsContinue reports whether the client waits for 100 Continue before
+// sending the body.
+func (r *Request) expectsContinue() bool {
+	return r.ProtoAtLeast(1, 1) && strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// wantsKeepAlive reports whether the connection may be reused after this request.
+func (r *Request) wantsKeepAlive() bool {
+	if r.ProtoAtLeast(1, 1) {
+		return !r.Header.hasToken("Connection", "close")
+	}
+	return r.Header.hasToken("Connection", "keep-alive")
+}
+
+// statusError is a request parsing error with the status to respond with.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, StatusText(e.code), e.msg)
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &statusError{code: StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+// readRequest reads a request line, header and body framing from r. At most
+// maxHeaderBytes are read before the end of the header.
+func readRequest(r *bufio.Reader, maxHeaderBytes int) (*Request, error) {
+	limit := maxHeaderBytes
+
+	// Ignore empty lines before the request line (RFC 7230 3.5)
+	var line string
+	for {
+		var err error
+		line, err = readLine(r, limit)
+		if err != nil {
+			if errors.Is(err, ErrLineTooLong) {
+				return nil, &statusError{code: StatusURITooLong, msg: "request line too long"}
+			}
+			return nil, err
+		}
+		if line != "" {
+			break
+		}
+	}
+	limit -= len(line)
+
+	req := &Request{
+		Header:        make(Header),
+		ContentLength: 0,
+	}
+	if err := req.parseRequestLine(line); err != nil {
+		return nil, err
+	}
+
+	// Header fields up to the empty line
+	for {
+		line, err := readLine(r, limit)
+		if err != nil {
+			if errors.Is(err, ErrLineTooLong) {
+				return nil, &statusError{code: StatusHeaderFieldsTooLarge, msg: "header too large"}
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		limit -= len(line)
+
+		// Obsolete line folding is rejected (RFC 7230 3.2.4)
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, badRequest("obsolete line folding")
+		}
+		key, value, err := parseHeaderLine(line)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		req.Header.Add(key, value)
+	}
+
+	// HTTP/1.1 requests must identify the host (RFC 7230 5.4)
+	hosts := req.Header.Values("Host")
+	if len(hosts) > 1 || (len(hosts) == 0 && req.ProtoAtLeast(1, 1)) {
+		return nil, badRequest("missing or repeated Host header")
+	}
+	if len(hosts) == 1 {
+		req.Host = hosts[0]
+	}
+
+	req.Close = !req.wantsKeepAlive()
+
+	if err := req.setupBody(r); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// parseRequestLine parses "METHOD SP request-target SP HTTP-version".
+func (req *Request) parseRequestLine(line string) error {
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return badRequest("invalid request line %q", line)
+	}
+	req.Method, req.Target, req.Proto = parts[0], parts[1], parts[2]
+
+	major, minor, ok := parseHTTPVersion(req.Proto)
+	if !ok {
+		return badRequest("invalid HTTP version %q", req.Proto)
+	}
+	if major != 1 {
+		return &statusError{code: StatusVersionNotSupported, msg: req.Proto}
+	}
+	req.ProtoMajor, req.ProtoMinor = major, minor
+
+	req.Path = req.Target
+	if i := strings.IndexByte(req.Target, '?'); i >= 0 {
+		req.Path, req.RawQuery = req.Target[:i], req.Target[i+1:]
+	}
+	if u, err := url.ParseRequestURI(req.Target); err == nil {
+		req.URL = u
+	}
+	return nil
+}
+
+// parseHTTPVersion parses "HTTP/x.y".
+func parseHTTPVersion(proto string) (major, minor int, ok bool) {
+	if len(proto) != len("HTTP/1.1") || !strings.HasPrefix(proto, "HTTP/") || proto[6] != '.' {
+		return 0, 0, false
+	}
+	if proto[5] < '0' || proto[5] > '9' || proto[7] < '0' || proto[7] > '9' {
+		return 0, 0, false
+	}
+	return int(proto[5] - '0'), int(proto[7] - '0'), true
+}
+
+// setupBody determines the body length from the framing headers (RFC 7230 3.3.3).
+func (req *Request) setupBody(r *bufio.Reader) error {
+	codings := req.Header.Values("Transfer-Encoding")
+	lengths := req.Header.Values("Content-Length")
+
+	if len(codings) > 0 {
+		// A message with both is a request smuggling vector; reject it
+		if len(lengths) > 0 {
+			return badRequest("both Transfer-Encoding and Content-Length")
+		}
+		for _, value := range codings {
+			for _, coding := range strings.Split(value, ",") {
+				if coding = strings.TrimSpace(coding); coding != "" {
+					req.TransferEncoding = append(req.TransferEncoding, strings.ToLower(coding))
+				}
+			}
+		}
+		if len(req.TransferEncoding) != 1 || req.TransferEncoding[0] != "chunked" {
+			return &statusError{code: StatusNotImplemented, msg: "unsupported transfer coding"}
+		}
+
+		req.ContentLength = -1
+		req.Trailer = make(Header)
+		req.Body = &body{src: newChunkedReader(r, req.Trailer)}
+		return nil
+	}
+
+	if len(lengths) > 0 {
+		// Repeated identical values are tolerated (RFC 7230 3.3.2)
+		for _, value := range lengths[1:] {
+			if strings.TrimSpace(value) != strings.TrimSpace(lengths[0]) {
+				return badRequest("conflicting Content-Length")
+			}
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(lengths[0]), 10, 64)
+		if err != nil || n < 0 {
+			return badRequest("invalid Content-Length %q", lengths[0])
+		}
+		req.ContentLength = n
+	}
+
+	if req.ContentLength == 0 {
+		req.Body = noBody{}
+		return nil
+	}
+	req.Body = &body{src: io.LimitReader(r, req.ContentLength)}
+	return nil
+}
+
+// readLine reads a line terminated by LF (with an optional preceding CR)
+// and returns it without the terminator.
+func readLine(r *bufio.Reader, max int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > max+2 {
+			return "", ErrLineTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		break
+	}
+
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	if len(line) > max {
+		return "", ErrLineTooLong
+	}
+	return string(line), nil
+}
+
+// parseHeaderLine splits "Name: value". Whitespace before the colon is not
+// allowed (RFC 7230 3.2.4).
+func parseHeaderLine(line string) (string, string, error) {
+	i := strings.IndexByte(line, ':')
+	if i <= 0 {
+		return "", "", fmt.Errorf("malformed header field %q", line)
+	}
+	key := line[:i]
+	for _, c := range key {
+		if !isTokenChar(c) {
+			return "", "", fmt.Errorf("invalid header field name %q", key)
+		}
+	}
+	return key, strings.Trim(line[i+1:], " \t"), nil
+}
+
+// isTokenChar reports whether c may appear in a token (RFC 7230 3.2.6).
+func isTokenChar(c rune) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
+
+// noBody is the body of a request without one.
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+// body is a request body delimited by Content-Length or chunked coding.
+type body struct {
+	src io.Reader
+
+	// beforeRead is called once before the first read, to send
+	// 100 Continue to a client waiting for it.
+	beforeRead func()
+
+	sawEOF bool
+	closed bool
+	err    error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.beforeRead != nil {
+		b.beforeRead()
+		b.beforeRead = nil
+	}
+
+	n, err := b.src.Read(p)
+	if err == io.EOF {
+		// A Content-Length body that ends early was truncated
+		if lr, ok := b.src.(*io.LimitedReader); ok && lr.N > 0 {
+			err = io.ErrUnexpectedEOF
+		} else {
+			b.sawEOF = true
+		}
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+// drain discards up to max unread body bytes so that the next request on
+// the connection can be read. Returns false if the body was not fully consumed.
+func (b *body) drain(max int64) bool {
+	if b.sawEOF {
+		return true
+	}
+	if b.err != nil {
+		return false
+	}
+	b.closed = false
+	n, err := io.Copy(io.Discard, io.LimitReader(b, max+1))
+	return err == nil && n <= max && b.sawEOF
+}
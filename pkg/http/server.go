@@ -0,0 +1,501 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxHeaderBytes bounds the request line and header fields.
+	DefaultMaxHeaderBytes = 64 * 1024
+
+	// responseBufferSize is how much of a body is buffered before the
+	// header is sent; bodies that fit get a Content-Length.
+	responseBufferSize = 4096
+
+	// maxDrainBytes is how much of an unread request body is discarded to
+	// keep the connection alive; larger bodies close the connection.
+	maxDrainBytes = 256 * 1024
+
+	// TimeFormat is the format of the Date header (RFC 7231 7.1.1.1).
+	TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+)
+
+var (
+	// ErrServerClosed is returned by Serve after Close.
+	ErrServerClosed = errors.New("http: server closed")
+
+	// ErrBodyNotAllowed is returned by ResponseWriter.Write for responses
+	// that cannot have a body.
+	ErrBodyNotAllowed = errors.New("http: response status does not allow a body")
+
+	// ErrContentLength is returned by ResponseWriter.Write when the handler
+	// writes more than the Content-Length it declared.
+	ErrContentLength = errors.New("http: wrote more than the declared Content-Length")
+)
+
+// Conn is a connection the server reads requests from and writes responses
+// to. A Conn that also has a RemoteAddr() net.Addr method fills in
+// Request.RemoteAddr.
+type Conn interface {
+	io.ReadWriteCloser
+}
+
+// Handler responds to an HTTP request.
+type Handler interface {
+	ServeHTTP(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+// ServeHTTP calls f(w, r).
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// ResponseWriter is used by a handler to build a response.
+type ResponseWriter interface {
+	// Header returns the response header, which may be changed until the
+	// status is written.
+	Header() Header
+
+	// Write writes body data, calling WriteHeader(StatusOK) first if needed.
+	Write(p []byte) (int, error)
+
+	// WriteHeader sets the response status. Only the first call has an effect.
+	WriteHeader(statusCode int)
+}
+
+// Flusher is implemented by ResponseWriters that can send buffered data to
+// the client before the handler returns. Flushing before the body length is
+// known makes the response chunked.
+type Flusher interface {
+	Flush()
+}
+
+// Error replies with a plain-text error message.
+func Error(w ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, message)
+}
+
+// NotFound replies with 404 Not Found.
+func NotFound(w ResponseWriter, r *Request) {
+	Error(w, "404 page not found", StatusNotFound)
+}
+
+// Server serves HTTP/1.1 on persistent connections.
+type Server struct {
+	// Handler responds to requests.
+	Handler Handler
+
+	// MaxHeaderBytes bounds the request header (DefaultMaxHeaderBytes if zero).
+	MaxHeaderBytes int
+
+	// MaxKeepAliveRequests closes a connection after this many requests
+	// (unlimited if zero).
+	MaxKeepAliveRequests int
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[Conn]struct{}
+	closed    bool
+
+	// now returns the current time for the Date header (overridden in tests).
+	now func() time.Time
+}
+
+// Serve accepts connections from l and serves each in its own goroutine.
+// It returns when Accept fails, or ErrServerClosed after Close.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, nil) {
+		return ErrServerClosed
+	}
+	defer s.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return fmt.Errorf("accept failed: %w", err)
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// Close closes all listeners and connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	var firstErr error
+	for l := range s.listeners {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.listeners = nil
+	s.conns = nil
+	return firstErr
+}
+
+// ServeConn serves requests on a connection until either side closes it,
+// then closes it.
+func (s *Server) ServeConn(c Conn) {
+	if !s.track(nil, c) {
+		c.Close()
+		return
+	}
+	defer func() {
+		s.untrack(nil, c)
+		c.Close()
+	}()
+
+	remoteAddr := ""
+	if ra, ok := c.(interface{ RemoteAddr() net.Addr }); ok && ra.RemoteAddr() != nil {
+		remoteAddr = ra.RemoteAddr().String()
+	}
+
+	br := bufio.NewReader(c)
+	bw := bufio.NewWriterSize(c, responseBufferSize)
+
+	for served := 1; ; served++ {
+		req, err := readRequest(br, s.maxHeaderBytes())
+		if err != nil {
+			var se *statusError
+			if errors.As(err, &se) {
+				s.writeError(bw, se)
+			}
+			return
+		}
+		req.RemoteAddr = remoteAddr
+
+		w := s.newResponse(req, bw)
+		if s.MaxKeepAliveRequests > 0 && served >= s.MaxKeepAliveRequests {
+			w.closeAfter = true
+		}
+
+		if !s.serveRequest(w, req) {
+			return
+		}
+		if err := bw.Flush(); err != nil || w.closeAfter {
+			return
+		}
+
+		// Discard what the handler did not read so the next request line
+		// can be found; a client still waiting for 100 Continue will not
+		// send the body at all
+		if b, ok := req.Body.(*body); ok {
+			if b.beforeRead != nil || !b.drain(maxDrainBytes) {
+				return
+			}
+		}
+	}
+}
+
+// serveRequest runs the handler and completes the response. Returns false
+// if the handler panicked and the connection must be closed.
+func (s *Server) serveRequest(w *response, req *Request) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			// Report the failure if nothing has been sent yet
+			if !w.committed {
+				w.header = make(Header)
+				w.status = 0
+				w.buf = nil
+				w.closeAfter = true
+				Error(w, StatusText(StatusInternalServerError), StatusInternalServerError)
+				w.finish()
+				w.bw.Flush()
+			}
+			ok = false
+		}
+	}()
+
+	handler := s.Handler
+	if handler == nil {
+		handler = HandlerFunc(NotFound)
+	}
+	handler.ServeHTTP(w, req)
+	w.finish()
+	return true
+}
+
+// writeError responds to a request that could not be parsed and closes
+// the connection.
+func (s *Server) writeError(bw *bufio.Writer, se *statusError) {
+	text := StatusText(se.code)
+	fmt.Fprintf(bw, "HTTP/1.1 %d %s\r\n", se.code, text)
+	fmt.Fprintf(bw, "Connection: close\r\nContent-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(bw, "Content-Length: %d\r\n\r\n%d %s", len(text)+4, se.code, text)
+	bw.Flush()
+}
+
+func (s *Server) maxHeaderBytes() int {
+	if s.MaxHeaderBytes > 0 {
+		return s.MaxHeaderBytes
+	}
+	return DefaultMaxHeaderBytes
+}
+
+func (s *Server) currentTime() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// track registers a listener or connection for Close. Returns false if
+// the server is already closed.
+func (s *Server) track(l net.Listener, c Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	if l != nil {
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[l] = struct{}{}
+	}
+	if c != nil {
+		if s.conns == nil {
+			s.conns = make(map[Conn]struct{})
+		}
+		s.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (s *Server) untrack(l net.Listener, c Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l != nil {
+		delete(s.listeners, l)
+	}
+	if c != nil {
+		delete(s.conns, c)
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// response is the ResponseWriter for one request.
+type response struct {
+	server *Server
+	req    *Request
+	bw     *bufio.Writer
+	header Header
+	status int
+
+	// The first responseBufferSize bytes of the body are held back so that
+	// short bodies can be sent with a Content-Length
+	buf       []byte
+	committed bool // Status line and header have been written
+
+	contentLength int64 // Declared Content-Length, or -1
+	written       int64 // Body bytes written after the header
+	chunked       *chunkedWriter
+
+	continueSent bool
+	closeAfter   bool
+}
+
+func (s *Server) newResponse(req *Request, bw *bufio.Writer) *response {
+	w := &response{
+		server:        s,
+		req:           req,
+		bw:            bw,
+		header:        make(Header),
+		contentLength: -1,
+		closeAfter:    req.Close,
+	}
+
+	// Send 100 Continue when the handler first reads the body
+	if b, ok := req.Body.(*body); ok && req.expectsContinue() {
+		b.beforeRead = func() {
+			if !w.committed {
+				w.continueSent = true
+				fmt.Fprintf(bw, "HTTP/1.1 100 Continue\r\n\r\n")
+				bw.Flush()
+			}
+		}
+	}
+	return w
+}
+
+func (w *response) Header() Header {
+	return w.header
+}
+
+func (w *response) WriteHeader(statusCode int) {
+	if w.status != 0 {
+		return
+	}
+	if statusCode < 200 || statusCode > 999 {
+		// Informational responses are sent by the server itself
+		statusCode = StatusInternalServerError
+	}
+	w.status = statusCode
+
+	if cl := w.header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			w.contentLength = n
+		} else {
+			w.header.Del("Content-Length")
+		}
+	}
+}
+
+func (w *response) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(StatusOK)
+	}
+	if !bodyAllowed(w.status) {
+		return 0, ErrBodyNotAllowed
+	}
+
+	if !w.committed {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) > responseBufferSize {
+			if err := w.commit(false); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	return w.writeBody(p)
+}
+
+// Flush sends the header and any buffered body data to the client.
+func (w *response) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(StatusOK)
+	}
+	if !w.committed {
+		w.commit(false)
+	}
+	w.bw.Flush()
+}
+
+// commit writes the status line and header, choosing how the body is
+// delimited: the declared or buffered Content-Length, chunked coding, or
+// closing the connection for HTTP/1.0 clients.
+func (w *response) commit(final bool) error {
+	w.committed = true
+	h := w.header
+
+	if w.header.hasToken("Connection", "close") {
+		w.closeAfter = true
+	}
+
+	switch {
+	case !bodyAllowed(w.status):
+		h.Del("Content-Length")
+		h.Del("Transfer-Encoding")
+	case w.contentLength >= 0:
+		h.Del("Transfer-Encoding")
+	case final:
+		w.contentLength = int64(len(w.buf))
+		h.Set("Content-Length", strconv.Itoa(len(w.buf)))
+	case w.req.Method == "HEAD":
+		// The length of a streamed HEAD body is unknown; send no framing
+	case w.req.ProtoAtLeast(1, 1):
+		h.Set("Transfer-Encoding", "chunked")
+		w.chunked = &chunkedWriter{w: w.bw}
+	default:
+		// HTTP/1.0 has no chunking; the end of the body is the end of the connection
+		w.closeAfter = true
+	}
+
+	// A body the client did not send after Expect: 100-continue stays
+	// unread, so the connection cannot be reused (RFC 7231 5.1.1)
+	if w.req.expectsContinue() && !w.continueSent && w.req.ContentLength != 0 {
+		w.closeAfter = true
+	}
+
+	switch {
+	case w.closeAfter:
+		h.Set("Connection", "close")
+	case !w.req.ProtoAtLeast(1, 1):
+		h.Set("Connection", "keep-alive")
+	}
+	if h.Get("Date") == "" {
+		h.Set("Date", w.server.currentTime().UTC().Format(TimeFormat))
+	}
+
+	text := StatusText(w.status)
+	if text == "" {
+		text = "status code " + strconv.Itoa(w.status)
+	}
+	fmt.Fprintf(w.bw, "HTTP/1.1 %d %s\r\n", w.status, text)
+	h.write(w.bw)
+	w.bw.WriteString("\r\n")
+
+	buffered := w.buf
+	w.buf = nil
+	_, err := w.writeBody(buffered)
+	return err
+}
+
+// writeBody writes body data after the header, applying chunked coding
+// and discarding the body of HEAD responses.
+func (w *response) writeBody(p []byte) (int, error) {
+	if w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength {
+		return 0, ErrContentLength
+	}
+	w.written += int64(len(p))
+
+	if w.req.Method == "HEAD" || len(p) == 0 {
+		return len(p), nil
+	}
+	if w.chunked != nil {
+		return w.chunked.Write(p)
+	}
+	return w.bw.Write(p)
+}
+
+// finish completes the response after the handler returns.
+func (w *response) finish() {
+	if w.status == 0 {
+		w.WriteHeader(StatusOK)
+	}
+	if !w.committed {
+		w.commit(true)
+	}
+	if w.chunked != nil {
+		w.chunked.Close()
+	}
+
+	// A short body leaves the client waiting for the rest
+	if w.contentLength >= 0 && w.written < w.contentLength && w.req.Method != "HEAD" {
+		w.closeAfter = true
+	}
+}
+
+// bodyAllowed reports whether a response status permits a body (RFC 7230 3.3.3).
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != StatusNoContent && status != StatusNotModified
+}
@@ -0,0 +1,416 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testResponse is a response read by the test client.
+type testResponse struct {
+	status int
+	header Header
+	body   string
+}
+
+// startConn serves one in-memory connection and returns the client side.
+func startConn(t *testing.T, s *Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	client, server := net.Pipe()
+	go s.ServeConn(server)
+	t.Cleanup(func() { client.Close() })
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client, bufio.NewReader(client)
+}
+
+// readResponse reads one response, delimiting the body the way a client
+// would. HEAD responses must be read with head set.
+func readResponse(t *testing.T, r *bufio.Reader, head bool) *testResponse {
+	t.Helper()
+	line, err := readLine(r, 1024)
+	if err != nil {
+		t.Fatalf("readLine() error = %v", err)
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || parts[0] != "HTTP/1.1" {
+		t.Fatalf("status line = %q", line)
+	}
+	resp := &testResponse{header: make(Header)}
+	resp.status, _ = strconv.Atoi(parts[1])
+
+	for {
+		line, err := readLine(r, 4096)
+		if err != nil {
+			t.Fatalf("readLine() error = %v", err)
+		}
+		if line == "" {
+			break
+		}
+		key, value, err := parseHeaderLine(line)
+		if err != nil {
+			t.Fatalf("parseHeaderLine() error = %v", err)
+		}
+		resp.header.Add(key, value)
+	}
+
+	if head || resp.status == StatusContinue || !bodyAllowed(resp.status) {
+		return resp
+	}
+
+	var body []byte
+	switch {
+	case resp.header.Get("Transfer-Encoding") == "chunked":
+		body, err = io.ReadAll(newChunkedReader(r, nil))
+	case resp.header.Get("Content-Length") != "":
+		n, _ := strconv.Atoi(resp.header.Get("Content-Length"))
+		body = make([]byte, n)
+		_, err = io.ReadFull(r, body)
+	default:
+		body, err = io.ReadAll(r)
+	}
+	if err != nil {
+		t.Fatalf("reading body error = %v", err)
+	}
+	resp.body = string(body)
+	return resp
+}
+
+// echoHandler replies with the method, path and request body.
+var echoHandler = HandlerFunc(func(w ResponseWriter, r *Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		Error(w, err.Error(), StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	if v := r.Trailer.Get("Checksum"); v != "" {
+		w.Header().Set("X-Trailer-Checksum", v)
+	}
+	fmt.Fprintf(w, "%s %s %s", r.Method, r.Path, data)
+})
+
+func TestKeepAlive(t *testing.T) {
+	s := &Server{Handler: echoHandler}
+	client, r := startConn(t, s)
+
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(client, "GET /page/%d?x=1 HTTP/1.1\r\nHost: example.com\r\n\r\n", i)
+		resp := readResponse(t, r, false)
+
+		want := fmt.Sprintf("GET /page/%d ", i)
+		if resp.status != StatusOK || resp.body != want {
+			t.Errorf("request %d: %d %q, want 200 %q", i, resp.status, resp.body, want)
+		}
+		if got := resp.header.Get("Content-Length"); got != strconv.Itoa(len(want)) {
+			t.Errorf("Content-Length = %q, want %d", got, len(want))
+		}
+		if resp.header.Get("Connection") != "" || resp.header.Get("Date") == "" {
+			t.Errorf("header = %v, want Date and no Connection", resp.header)
+		}
+	}
+}
+
+func TestConnectionClose(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		want    string // Connection header in the response
+		closed  bool
+	}{
+		{"HTTP/1.1 close", "GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n", "close", true},
+		{"HTTP/1.0 default", "GET / HTTP/1.0\r\n\r\n", "close", true},
+		{"HTTP/1.0 keep-alive", "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n", "keep-alive", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, r := startConn(t, &Server{Handler: echoHandler})
+			io.WriteString(client, tt.request)
+
+			resp := readResponse(t, r, false)
+			if got := resp.header.Get("Connection"); got != tt.want {
+				t.Errorf("Connection = %q, want %q", got, tt.want)
+			}
+
+			client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			_, err := r.ReadByte()
+			if closed := err == io.EOF; closed != tt.closed {
+				t.Errorf("closed = %v (err %v), want %v", closed, err, tt.closed)
+			}
+		})
+	}
+
+	// The server closes after MaxKeepAliveRequests
+	client, r := startConn(t, &Server{Handler: echoHandler, MaxKeepAliveRequests: 2})
+	for i := 0; i < 2; i++ {
+		io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+		resp := readResponse(t, r, false)
+		if closing := resp.header.Get("Connection") == "close"; closing != (i == 1) {
+			t.Errorf("request %d: Connection = %q", i, resp.header.Get("Connection"))
+		}
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	client, r := startConn(t, &Server{Handler: echoHandler})
+
+	// Content-Length body
+	io.WriteString(client, "POST /form HTTP/1.1\r\nHost: a\r\nContent-Length: 11\r\n\r\nhello world")
+	if resp := readResponse(t, r, false); resp.body != "POST /form hello world" {
+		t.Errorf("body = %q, want %q", resp.body, "POST /form hello world")
+	}
+
+	// Chunked body with extensions and a trailer
+	io.WriteString(client, "PUT /up HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5;name=value\r\nhello\r\n6\r\n world\r\n0\r\nChecksum: abc\r\n\r\n")
+	resp := readResponse(t, r, false)
+	if resp.body != "PUT /up hello world" {
+		t.Errorf("body = %q, want %q", resp.body, "PUT /up hello world")
+	}
+	if got := resp.header.Get("X-Trailer-Checksum"); got != "abc" {
+		t.Errorf("trailer Checksum = %q, want abc", got)
+	}
+}
+
+func TestUnreadBodyDiscarded(t *testing.T) {
+	ignore := HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Path)
+	})
+	client, r := startConn(t, &Server{Handler: ignore})
+
+	// The handler never reads these bodies; the next request must still parse
+	go io.WriteString(client, "POST /a HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nabcde"+
+		"POST /b HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nxyz\r\n0\r\n\r\n"+
+		"GET /c HTTP/1.1\r\nHost: a\r\n\r\n")
+
+	for _, want := range []string{"/a", "/b", "/c"} {
+		if resp := readResponse(t, r, false); resp.body != want {
+			t.Errorf("body = %q, want %q", resp.body, want)
+		}
+	}
+}
+
+func TestChunkedResponse(t *testing.T) {
+	block := strings.Repeat("x", 1000)
+	stream := HandlerFunc(func(w ResponseWriter, r *Request) {
+		for i := 0; i < 10; i++ {
+			io.WriteString(w, block)
+		}
+	})
+
+	// HTTP/1.1 clients get a chunked body and a reusable connection
+	client, r := startConn(t, &Server{Handler: stream})
+	for i := 0; i < 2; i++ {
+		io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+		resp := readResponse(t, r, false)
+		if resp.header.Get("Transfer-Encoding") != "chunked" || resp.header.Get("Content-Length") != "" {
+			t.Errorf("header = %v, want chunked", resp.header)
+		}
+		if resp.body != strings.Repeat(block, 10) {
+			t.Errorf("body length = %d, want %d", len(resp.body), 10*len(block))
+		}
+	}
+
+	// HTTP/1.0 clients get the body delimited by closing the connection
+	client, r = startConn(t, &Server{Handler: stream})
+	io.WriteString(client, "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+	resp := readResponse(t, r, false)
+	if resp.header.Get("Connection") != "close" || resp.header.Get("Transfer-Encoding") != "" {
+		t.Errorf("header = %v, want Connection: close and no chunking", resp.header)
+	}
+	if len(resp.body) != 10*len(block) {
+		t.Errorf("body length = %d, want %d", len(resp.body), 10*len(block))
+	}
+
+	// Flushing before the handler returns also chunks a short body
+	flush := HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "part1,")
+		w.(Flusher).Flush()
+		io.WriteString(w, "part2")
+	})
+	client, r = startConn(t, &Server{Handler: flush})
+	io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	if resp := readResponse(t, r, false); resp.body != "part1,part2" || resp.header.Get("Transfer-Encoding") != "chunked" {
+		t.Errorf("flushed response = %v %q, want chunked part1,part2", resp.header, resp.body)
+	}
+}
+
+func TestDeclaredContentLength(t *testing.T) {
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Length", "10000")
+		block := []byte(strings.Repeat("y", 1000))
+		for i := 0; i < 10; i++ {
+			w.Write(block)
+		}
+		if _, err := w.Write([]byte("extra")); err != ErrContentLength {
+			t.Errorf("Write() past Content-Length error = %v, want ErrContentLength", err)
+		}
+	})
+	client, r := startConn(t, &Server{Handler: handler})
+
+	io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	resp := readResponse(t, r, false)
+	if resp.header.Get("Transfer-Encoding") != "" || len(resp.body) != 10000 {
+		t.Errorf("response = %v with %d bytes, want 10000 unchunked", resp.header, len(resp.body))
+	}
+
+	// HEAD reports the length without a body
+	io.WriteString(client, "HEAD / HTTP/1.1\r\nHost: a\r\n\r\n")
+	resp = readResponse(t, r, true)
+	if resp.header.Get("Content-Length") != "10000" {
+		t.Errorf("HEAD Content-Length = %q, want 10000", resp.header.Get("Content-Length"))
+	}
+
+	// The connection is still in sync after HEAD
+	io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	if resp := readResponse(t, r, false); len(resp.body) != 10000 {
+		t.Errorf("body after HEAD = %d bytes, want 10000", len(resp.body))
+	}
+}
+
+func TestNoBodyStatus(t *testing.T) {
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteHeader(StatusNoContent)
+		if _, err := w.Write([]byte("x")); err != ErrBodyNotAllowed {
+			t.Errorf("Write() error = %v, want ErrBodyNotAllowed", err)
+		}
+	})
+	client, r := startConn(t, &Server{Handler: handler})
+
+	io.WriteString(client, "DELETE /x HTTP/1.1\r\nHost: a\r\n\r\n")
+	resp := readResponse(t, r, false)
+	if resp.status != StatusNoContent || resp.header.Get("Content-Length") != "" {
+		t.Errorf("response = %d %v, want 204 without Content-Length", resp.status, resp.header)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	client, r := startConn(t, &Server{Handler: echoHandler})
+
+	io.WriteString(client, "POST /up HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\nExpect: 100-continue\r\n\r\n")
+	if resp := readResponse(t, r, false); resp.status != StatusContinue {
+		t.Fatalf("interim status = %d, want 100", resp.status)
+	}
+	io.WriteString(client, "data")
+
+	if resp := readResponse(t, r, false); resp.status != StatusOK || resp.body != "POST /up data" {
+		t.Errorf("response = %d %q, want 200 %q", resp.status, resp.body, "POST /up data")
+	}
+
+	// A handler that rejects without reading gets no 100 Continue, and the
+	// connection is closed since the body was never sent
+	reject := HandlerFunc(func(w ResponseWriter, r *Request) {
+		Error(w, "too large", StatusRequestEntityTooLarge)
+	})
+	client, r = startConn(t, &Server{Handler: reject})
+	io.WriteString(client, "POST /up HTTP/1.1\r\nHost: a\r\nContent-Length: 4000000\r\nExpect: 100-continue\r\n\r\n")
+	resp := readResponse(t, r, false)
+	if resp.status != StatusRequestEntityTooLarge || resp.header.Get("Connection") != "close" {
+		t.Errorf("response = %d %v, want 413 with Connection: close", resp.status, resp.header)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		want    int
+	}{
+		{"missing host", "GET / HTTP/1.1\r\n\r\n", StatusBadRequest},
+		{"malformed request line", "GET /\r\nHost: a\r\n\r\n", StatusBadRequest},
+		{"bad version", "GET / HTTX/1.1\r\nHost: a\r\n\r\n", StatusBadRequest},
+		{"HTTP/2", "GET / HTTP/2.0\r\nHost: a\r\n\r\n", StatusVersionNotSupported},
+		{"space before colon", "GET / HTTP/1.1\r\nHost : a\r\n\r\n", StatusBadRequest},
+		{"folded header", "GET / HTTP/1.1\r\nHost: a\r\nX-A: 1\r\n  2\r\n\r\n", StatusBadRequest},
+		{"length and chunked", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n", StatusBadRequest},
+		{"conflicting lengths", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\n", StatusBadRequest},
+		{"negative length", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -1\r\n\r\n", StatusBadRequest},
+		{"unknown coding", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", StatusNotImplemented},
+		{"header too large", "GET / HTTP/1.1\r\nHost: a\r\nX-Big: " + strings.Repeat("a", 2000) + "\r\n\r\n", StatusHeaderFieldsTooLarge},
+		{"request line too long", "GET /" + strings.Repeat("a", 2000) + " HTTP/1.1\r\nHost: a\r\n\r\n", StatusURITooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, r := startConn(t, &Server{Handler: echoHandler, MaxHeaderBytes: 1024})
+			go io.WriteString(client, tt.request)
+
+			resp := readResponse(t, r, false)
+			if resp.status != tt.want {
+				t.Errorf("status = %d, want %d", resp.status, tt.want)
+			}
+			if resp.header.Get("Connection") != "close" {
+				t.Errorf("Connection = %q, want close", resp.header.Get("Connection"))
+			}
+		})
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("X-Partial", "1")
+		panic("handler bug")
+	})
+	client, r := startConn(t, &Server{Handler: handler})
+
+	io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	resp := readResponse(t, r, false)
+	if resp.status != StatusInternalServerError || resp.header.Get("X-Partial") != "" {
+		t.Errorf("response = %d %v, want 500 without handler headers", resp.status, resp.header)
+	}
+}
+
+// pipeListener hands out in-memory connections.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, io.EOF
+	}
+}
+
+func (l *pipeListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func TestServeAndClose(t *testing.T) {
+	l := &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+	s := &Server{Handler: echoHandler}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+
+	client, server := net.Pipe()
+	l.conns <- server
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+
+	io.WriteString(client, "GET /hi HTTP/1.1\r\nHost: a\r\n\r\n")
+	if resp := readResponse(t, r, false); resp.body != "GET /hi " {
+		t.Errorf("body = %q, want %q", resp.body, "GET /hi ")
+	}
+
+	s.Close()
+	if err := <-done; err != ErrServerClosed {
+		t.Errorf("Serve() error = %v, want ErrServerClosed", err)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("read after Close error = %v, want EOF", err)
+	}
+}
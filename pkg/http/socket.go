@@ -0,0 +1,65 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+// TCPDialer opens client connections with tcp.Socket.Connect. The network
+// stack provides the sockets: NewSocket must return an unconnected socket
+// with a local address, a free local port and a send function, registered
+// to receive the segments of its connection.
+type TCPDialer struct {
+	// NewSocket returns a socket to connect (required).
+	NewSocket func() (*tcp.Socket, error)
+
+	// Release is called with the socket once its connection is closed, to
+	// unregister it from the stack (optional).
+	Release func(*tcp.Socket)
+
+	// Resolve maps host names to addresses (optional). IP literals are
+	// used directly; other hosts fail without a resolver.
+	Resolve func(host string) (common.IPv4Address, error)
+}
+
+// Dial connects to host and port. It satisfies DialFunc.
+func (d *TCPDialer) Dial(host string, port uint16) (Conn, error) {
+	addr, err := common.ParseIPv4(host)
+	if err != nil {
+		if d.Resolve == nil {
+			return nil, fmt.Errorf("cannot resolve %q: no resolver", host)
+		}
+		if addr, err = d.Resolve(host); err != nil {
+			return nil, fmt.Errorf("failed to resolve %q: %w", host, err)
+		}
+	}
+
+	s, err := d.NewSocket()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket: %w", err)
+	}
+	if err := s.Connect(addr, port); err != nil {
+		if d.Release != nil {
+			d.Release(s)
+		}
+		return nil, err
+	}
+	return &dialedConn{NetConn: tcp.NewNetConn(s), release: d.Release}, nil
+}
+
+// dialedConn releases a dialed socket from the stack when it is closed.
+type dialedConn struct {
+	*tcp.NetConn
+	release func(*tcp.Socket)
+}
+
+func (c *dialedConn) Close() error {
+	err := c.NetConn.Close()
+	if c.release != nil {
+		c.release(c.Socket())
+		c.release = nil
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+package icmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/internal/fuzzseed"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func FuzzParse(f *testing.F) {
+	fuzzseed.Add(f, fuzzseed.IPv4Payloads(f, uint8(common.ProtocolICMP)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := Parse(data)
+		if err != nil {
+			return
+		}
+		_ = msg.String()
+		msg.NextHopMTU()
+		msg.Original()
+
+		serialized, err := msg.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		if !bytes.Equal(serialized[4:], data[4:]) {
+			t.Errorf("Serialize() = %x, want %x", serialized, data)
+		}
+	})
+}
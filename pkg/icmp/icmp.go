@@ -18,10 +18,14 @@ const (
 	TypeSourceQuench           Type = 4  // Source Quench (deprecated)
 	TypeRedirect               Type = 5  // Redirect
 	TypeEchoRequest            Type = 8  // Echo Request
+	TypeRouterAdvertisement    Type = 9  // Router Advertisement (RFC 1256)
+	TypeRouterSolicitation     Type = 10 // Router Solicitation (RFC 1256)
 	TypeTimeExceeded           Type = 11 // Time Exceeded
 	TypeParameterProblem       Type = 12 // Parameter Problem
 	TypeTimestampRequest       Type = 13 // Timestamp Request
 	TypeTimestampReply         Type = 14 // Timestamp Reply
+	TypeAddressMaskRequest     Type = 17 // Address Mask Request (RFC 950)
+	TypeAddressMaskReply       Type = 18 // Address Mask Reply (RFC 950)
 )
 
 // Code represents an ICMP message code.
@@ -145,6 +149,10 @@ func (t Type) String() string {
 		return "Redirect"
 	case TypeEchoRequest:
 		return "EchoRequest"
+	case TypeRouterAdvertisement:
+		return "RouterAdvertisement"
+	case TypeRouterSolicitation:
+		return "RouterSolicitation"
 	case TypeTimeExceeded:
 		return "TimeExceeded"
 	case TypeParameterProblem:
@@ -153,6 +161,10 @@ func (t Type) String() string {
 		return "TimestampRequest"
 	case TypeTimestampReply:
 		return "TimestampReply"
+	case TypeAddressMaskRequest:
+		return "AddressMaskRequest"
+	case TypeAddressMaskReply:
+		return "AddressMaskReply"
 	default:
 		return fmt.Sprintf("Unknown(%d)", uint8(t))
 	}
@@ -220,6 +220,7 @@ func TestType_String(t *testing.T) {
 		{TypeEchoReply, "EchoReply"},
 		{TypeDestinationUnreachable, "DestinationUnreachable"},
 		{TypeTimeExceeded, "TimeExceeded"},
+		{TypeRouterAdvertisement, "RouterAdvertisement"},
 		{Type(99), "Unknown(99)"},
 	}
 
@@ -0,0 +1,115 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+const (
+	// DefaultAdvertisementLifetime is the lifetime routers advertise by
+	// default, three times the default maximum advertisement interval of
+	// RFC 1256.
+	DefaultAdvertisementLifetime = 30 * time.Minute
+
+	// PreferenceNever is the preference level of an advertised address
+	// that must not be used as a default router.
+	PreferenceNever int32 = math.MinInt32
+
+	// routerEntrySize is the size of an advertised address and its
+	// preference level, in 32-bit words.
+	routerEntrySize = 2
+)
+
+// RouterAddress is an address in a Router Advertisement, with its
+// preference as a default router relative to the others on the subnet.
+type RouterAddress struct {
+	Address    common.IPv4Address
+	Preference int32 // Higher is preferred; PreferenceNever for none
+}
+
+// RouterAdvertisement is the content of an ICMP Router Advertisement
+// (RFC 1256).
+type RouterAdvertisement struct {
+	// Lifetime is how long the addresses may be considered valid, in whole
+	// seconds up to 65535.
+	Lifetime  time.Duration
+	Addresses []RouterAddress
+}
+
+// NewRouterAdvertisement creates a Router Advertisement message.
+func NewRouterAdvertisement(ra *RouterAdvertisement) (*Message, error) {
+	if len(ra.Addresses) > math.MaxUint8 {
+		return nil, fmt.Errorf("too many router addresses: %d (maximum %d)", len(ra.Addresses), math.MaxUint8)
+	}
+	if ra.Lifetime < 0 || ra.Lifetime > math.MaxUint16*time.Second {
+		return nil, fmt.Errorf("invalid router advertisement lifetime: %v", ra.Lifetime)
+	}
+
+	data := make([]byte, 0, len(ra.Addresses)*routerEntrySize*4)
+	for _, a := range ra.Addresses {
+		data = append(data, a.Address[:]...)
+		data = binary.BigEndian.AppendUint32(data, uint32(a.Preference))
+	}
+	return &Message{
+		Type:     TypeRouterAdvertisement,
+		ID:       uint16(len(ra.Addresses))<<8 | routerEntrySize,
+		Sequence: uint16(ra.Lifetime / time.Second),
+		Data:     data,
+	}, nil
+}
+
+// NewRouterSolicitation creates a Router Solicitation message, asking the
+// routers on the link to advertise.
+func NewRouterSolicitation() *Message {
+	return &Message{Type: TypeRouterSolicitation}
+}
+
+// RouterAdvertisement parses a Router Advertisement message. Entries
+// larger than an address and a preference level, from later versions of
+// the protocol, are read for those alone.
+func (m *Message) RouterAdvertisement() (*RouterAdvertisement, error) {
+	if m.Type != TypeRouterAdvertisement {
+		return nil, fmt.Errorf("%s message is not a router advertisement", m.Type)
+	}
+	if m.Code != 0 {
+		return nil, fmt.Errorf("router advertisement with code %d", m.Code)
+	}
+	count, entrySize := int(m.ID>>8), int(m.ID&0xff)
+	if entrySize < routerEntrySize {
+		return nil, fmt.Errorf("router advertisement address entry size %d (minimum %d)", entrySize, routerEntrySize)
+	}
+	if len(m.Data) < count*entrySize*4 {
+		return nil, fmt.Errorf("router advertisement too short: %d bytes for %d addresses", len(m.Data), count)
+	}
+
+	ra := &RouterAdvertisement{
+		Lifetime:  time.Duration(m.Sequence) * time.Second,
+		Addresses: make([]RouterAddress, count),
+	}
+	for i := range ra.Addresses {
+		entry := m.Data[i*entrySize*4:]
+		copy(ra.Addresses[i].Address[:], entry[0:4])
+		ra.Addresses[i].Preference = int32(binary.BigEndian.Uint32(entry[4:8]))
+	}
+	return ra, nil
+}
+
+// DefaultRouter returns the most preferred address of an advertisement,
+// or false if every address is PreferenceNever.
+func (ra *RouterAdvertisement) DefaultRouter() (common.IPv4Address, bool) {
+	var best *RouterAddress
+	for i := range ra.Addresses {
+		a := &ra.Addresses[i]
+		if a.Preference != PreferenceNever && (best == nil || a.Preference > best.Preference) {
+			best = a
+		}
+	}
+	if best == nil {
+		return common.IPv4Address{}, false
+	}
+	return best.Address, true
+}
@@ -0,0 +1,98 @@
+package icmp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestRouterAdvertisement(t *testing.T) {
+	ra := &RouterAdvertisement{
+		Lifetime: DefaultAdvertisementLifetime,
+		Addresses: []RouterAddress{
+			{Address: common.IPv4Address{10, 0, 0, 1}, Preference: 10},
+			{Address: common.IPv4Address{10, 0, 0, 2}, Preference: -5},
+			{Address: common.IPv4Address{10, 0, 0, 3}, Preference: PreferenceNever},
+		},
+	}
+	msg, err := NewRouterAdvertisement(ra)
+	if err != nil {
+		t.Fatalf("NewRouterAdvertisement() error = %v", err)
+	}
+	raw, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	// Type 9, 3 addresses of 2 words, lifetime 1800 seconds
+	if want := []byte{9, 0, raw[2], raw[3], 3, 2, 0x07, 0x08}; !bytes.Equal(raw[:8], want) || len(raw) != 8+3*8 {
+		t.Errorf("Serialize() = % x", raw)
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	got, err := parsed.RouterAdvertisement()
+	if err != nil {
+		t.Fatalf("RouterAdvertisement() error = %v", err)
+	}
+	if got.Lifetime != 30*time.Minute || len(got.Addresses) != 3 || got.Addresses[1] != ra.Addresses[1] || got.Addresses[2].Preference != PreferenceNever {
+		t.Errorf("RouterAdvertisement() = %+v, want %+v", got, ra)
+	}
+	if addr, ok := got.DefaultRouter(); !ok || addr != (common.IPv4Address{10, 0, 0, 1}) {
+		t.Errorf("DefaultRouter() = %s, %v; want 10.0.0.1", addr, ok)
+	}
+
+	never := &RouterAdvertisement{Addresses: ra.Addresses[2:]}
+	if _, ok := never.DefaultRouter(); ok {
+		t.Error("DefaultRouter() with every preference PreferenceNever succeeded")
+	}
+}
+
+func TestRouterAdvertisementEntrySize(t *testing.T) {
+	// Entries of 3 words, the third ignored
+	msg := &Message{
+		Type:     TypeRouterAdvertisement,
+		ID:       2<<8 | 3,
+		Sequence: 600,
+		Data: []byte{
+			10, 0, 0, 1, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff,
+			10, 0, 0, 2, 0, 0, 0, 2, 0xff, 0xff, 0xff, 0xff,
+		},
+	}
+	ra, err := msg.RouterAdvertisement()
+	if err != nil {
+		t.Fatalf("RouterAdvertisement() error = %v", err)
+	}
+	if len(ra.Addresses) != 2 || ra.Addresses[1] != (RouterAddress{common.IPv4Address{10, 0, 0, 2}, 2}) || ra.Lifetime != 10*time.Minute {
+		t.Errorf("RouterAdvertisement() = %+v", ra)
+	}
+}
+
+func TestRouterAdvertisementInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{"solicitation", NewRouterSolicitation()},
+		{"code", &Message{Type: TypeRouterAdvertisement, Code: 1, ID: 0<<8 | 2}},
+		{"entry size", &Message{Type: TypeRouterAdvertisement, ID: 1<<8 | 1, Data: make([]byte, 8)}},
+		{"truncated", &Message{Type: TypeRouterAdvertisement, ID: 2<<8 | 2, Data: make([]byte, 12)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.msg.RouterAdvertisement(); err == nil {
+				t.Error("RouterAdvertisement() succeeded, want error")
+			}
+		})
+	}
+
+	if _, err := NewRouterAdvertisement(&RouterAdvertisement{Addresses: make([]RouterAddress, 256)}); err == nil {
+		t.Error("NewRouterAdvertisement() with 256 addresses succeeded, want error")
+	}
+	if _, err := NewRouterAdvertisement(&RouterAdvertisement{Lifetime: 20 * time.Hour}); err == nil {
+		t.Error("NewRouterAdvertisement() with a 20 hour lifetime succeeded, want error")
+	}
+}
@@ -0,0 +1,136 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+const (
+	// minIPv4HeaderLength is the length of an IPv4 header without options.
+	minIPv4HeaderLength = 20
+
+	// quotedPayloadLength is how much of the original datagram's payload
+	// an ICMP error carries at least, enough for the TCP or UDP ports and
+	// the TCP sequence number (RFC 792).
+	quotedPayloadLength = 8
+)
+
+// Destination Unreachable codes of RFC 1122 and RFC 1812.
+const (
+	CodeDestNetUnknown          Code = 6  // Destination Network Unknown
+	CodeDestHostUnknown         Code = 7  // Destination Host Unknown
+	CodeSourceHostIsolated      Code = 8  // Source Host Isolated
+	CodeNetProhibited           Code = 9  // Network Administratively Prohibited
+	CodeHostProhibited          Code = 10 // Host Administratively Prohibited
+	CodeNetUnreachableTOS       Code = 11 // Network Unreachable for TOS
+	CodeHostUnreachableTOS      Code = 12 // Host Unreachable for TOS
+	CodeCommunicationProhibited Code = 13 // Communication Administratively Prohibited
+)
+
+// Errors reported by Destination Unreachable and Time Exceeded messages,
+// named as a socket would report them.
+var (
+	ErrNetUnreachable      = errors.New("network is unreachable")
+	ErrHostUnreachable     = errors.New("no route to host")
+	ErrProtocolUnreachable = errors.New("protocol not available")
+	ErrPortUnreachable     = errors.New("connection refused")
+	ErrMessageTooLong      = errors.New("message too long")
+	ErrProhibited          = errors.New("communication administratively prohibited")
+	ErrTimeExceeded        = errors.New("time exceeded in transit")
+)
+
+// Original is the start of the datagram an ICMP error reports on: its IPv4
+// header and the first bytes of its payload. The datagram was sent by the
+// host receiving the error, so Source is a local address.
+type Original struct {
+	Source      common.IPv4Address
+	Destination common.IPv4Address
+	Protocol    common.Protocol
+	Payload     []byte // At least 8 bytes
+}
+
+// Ports returns the source and destination ports of a TCP or UDP datagram.
+func (o *Original) Ports() (src, dst uint16) {
+	return binary.BigEndian.Uint16(o.Payload[0:2]), binary.BigEndian.Uint16(o.Payload[2:4])
+}
+
+// Original parses the datagram an error message was sent about.
+func (m *Message) Original() (*Original, error) {
+	if !m.IsError() {
+		return nil, fmt.Errorf("%s message carries no original datagram", m.Type)
+	}
+	if len(m.Data) < minIPv4HeaderLength {
+		return nil, fmt.Errorf("original datagram too short: %d bytes", len(m.Data))
+	}
+	if version := m.Data[0] >> 4; version != 4 {
+		return nil, fmt.Errorf("original datagram has IP version %d", version)
+	}
+	headerLength := int(m.Data[0]&0x0f) * 4
+	if headerLength < minIPv4HeaderLength || len(m.Data) < headerLength+quotedPayloadLength {
+		return nil, fmt.Errorf("original datagram too short: %d bytes with a %d byte header", len(m.Data), headerLength)
+	}
+
+	o := &Original{
+		Protocol: common.Protocol(m.Data[9]),
+		Payload:  m.Data[headerLength:],
+	}
+	copy(o.Source[:], m.Data[12:16])
+	copy(o.Destination[:], m.Data[16:20])
+	return o, nil
+}
+
+// NextHopMTU returns the MTU a Fragmentation Needed message reports (RFC
+// 1191), or 0 if it reports none, as older routers do.
+func (m *Message) NextHopMTU() uint16 {
+	if m.Type != TypeDestinationUnreachable || m.Code != CodeFragmentationNeeded {
+		return 0
+	}
+	return m.Sequence // The low 16 bits of the unused field
+}
+
+// Err returns the error a Destination Unreachable or Time Exceeded message
+// reports, or nil for other messages.
+func (m *Message) Err() error {
+	switch m.Type {
+	case TypeTimeExceeded:
+		return ErrTimeExceeded
+	case TypeDestinationUnreachable:
+	default:
+		return nil
+	}
+
+	switch m.Code {
+	case CodeNetUnreachable, CodeDestNetUnknown, CodeNetUnreachableTOS:
+		return ErrNetUnreachable
+	case CodeProtocolUnreachable:
+		return ErrProtocolUnreachable
+	case CodePortUnreachable:
+		return ErrPortUnreachable
+	case CodeFragmentationNeeded:
+		return ErrMessageTooLong
+	case CodeNetProhibited, CodeHostProhibited, CodeCommunicationProhibited:
+		return ErrProhibited
+	default:
+		return ErrHostUnreachable
+	}
+}
+
+// IsHardError reports whether the message reports a condition that will
+// not go away by itself, so that a connection being opened should be
+// aborted (RFC 1122 Section 4.2.3.9). Unreachable networks and hosts and
+// expired TTLs are soft errors: routing may recover.
+func (m *Message) IsHardError() bool {
+	if m.Type != TypeDestinationUnreachable {
+		return false
+	}
+	switch m.Code {
+	case CodeProtocolUnreachable, CodePortUnreachable, CodeDestNetUnknown, CodeDestHostUnknown,
+		CodeSourceHostIsolated, CodeNetProhibited, CodeHostProhibited, CodeCommunicationProhibited:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,91 @@
+package icmp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// quote returns the IPv4 header and first 8 bytes of a UDP datagram from
+// 10.0.0.1:5353 to 10.0.0.2:53.
+func quote() []byte {
+	return []byte{
+		0x45, 0x00, 0x00, 0x24, 0x00, 0x00, 0x40, 0x00, // Version, IHL, length, DF
+		0x40, 0x11, 0x00, 0x00, // TTL, protocol (UDP), checksum
+		10, 0, 0, 1, // Source
+		10, 0, 0, 2, // Destination
+		0x14, 0xe9, 0x00, 0x35, 0x00, 0x10, 0x00, 0x00, // UDP header
+	}
+}
+
+func TestOriginal(t *testing.T) {
+	msg := NewDestinationUnreachable(CodePortUnreachable, quote())
+	orig, err := msg.Original()
+	if err != nil {
+		t.Fatalf("Original() error = %v", err)
+	}
+	if orig.Source != (common.IPv4Address{10, 0, 0, 1}) || orig.Destination != (common.IPv4Address{10, 0, 0, 2}) || orig.Protocol != common.ProtocolUDP {
+		t.Errorf("Original() = %+v, want UDP 10.0.0.1 -> 10.0.0.2", orig)
+	}
+	if src, dst := orig.Ports(); src != 5353 || dst != 53 {
+		t.Errorf("Ports() = %d, %d; want 5353, 53", src, dst)
+	}
+
+	badVersion := quote()
+	badVersion[0] = 0x65
+	invalid := []struct {
+		name string
+		msg  *Message
+	}{
+		{"echo reply", NewEchoReply(1, 1, quote())},
+		{"truncated header", NewTimeExceeded(CodeTTLExceeded, quote()[:16])},
+		{"truncated payload", NewTimeExceeded(CodeTTLExceeded, quote()[:24])},
+		{"IP version 6", NewTimeExceeded(CodeTTLExceeded, badVersion)},
+	}
+	for _, tt := range invalid {
+		if _, err := tt.msg.Original(); err == nil {
+			t.Errorf("%s: Original() error = nil, want an error", tt.name)
+		}
+	}
+}
+
+func TestMessageErr(t *testing.T) {
+	tests := []struct {
+		typ      Type
+		code     Code
+		wantErr  error
+		wantHard bool
+	}{
+		{TypeDestinationUnreachable, CodeNetUnreachable, ErrNetUnreachable, false},
+		{TypeDestinationUnreachable, CodeHostUnreachable, ErrHostUnreachable, false},
+		{TypeDestinationUnreachable, CodeProtocolUnreachable, ErrProtocolUnreachable, true},
+		{TypeDestinationUnreachable, CodePortUnreachable, ErrPortUnreachable, true},
+		{TypeDestinationUnreachable, CodeFragmentationNeeded, ErrMessageTooLong, false},
+		{TypeDestinationUnreachable, CodeHostProhibited, ErrProhibited, true},
+		{TypeTimeExceeded, CodeTTLExceeded, ErrTimeExceeded, false},
+		{TypeEchoReply, 0, nil, false},
+	}
+
+	for _, tt := range tests {
+		msg := &Message{Type: tt.typ, Code: tt.code}
+		if err := msg.Err(); !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+			t.Errorf("%s/%d: Err() = %v, want %v", tt.typ, tt.code, err, tt.wantErr)
+		}
+		if got := msg.IsHardError(); got != tt.wantHard {
+			t.Errorf("%s/%d: IsHardError() = %v, want %v", tt.typ, tt.code, got, tt.wantHard)
+		}
+	}
+
+	// RFC 1191 puts the next-hop MTU in the low half of the unused field
+	msg := NewDestinationUnreachable(CodeFragmentationNeeded, quote())
+	msg.Sequence = 1400
+	raw, _ := msg.Serialize()
+	parsed, _ := Parse(raw)
+	if got := parsed.NextHopMTU(); got != 1400 {
+		t.Errorf("NextHopMTU() = %d, want 1400", got)
+	}
+	if got := NewDestinationUnreachable(CodePortUnreachable, nil).NextHopMTU(); got != 0 {
+		t.Errorf("NextHopMTU() of Port Unreachable = %d, want 0", got)
+	}
+}
@@ -0,0 +1,39 @@
+package icmp
+
+import (
+	"encoding/binary"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// Redirect codes.
+const (
+	CodeRedirectNet     Code = 0 // Redirect Datagrams for the Network
+	CodeRedirectHost    Code = 1 // Redirect Datagrams for the Host
+	CodeRedirectTOSNet  Code = 2 // Redirect Datagrams for the Type of Service and Network
+	CodeRedirectTOSHost Code = 3 // Redirect Datagrams for the Type of Service and Host
+)
+
+// NewRedirect creates a Redirect message telling a host to send datagrams
+// like original, the IPv4 header and first 8 bytes of payload of one it
+// sent, to gateway instead.
+func NewRedirect(code Code, gateway common.IPv4Address, original []byte) *Message {
+	return &Message{
+		Type:     TypeRedirect,
+		Code:     code,
+		ID:       binary.BigEndian.Uint16(gateway[0:2]),
+		Sequence: binary.BigEndian.Uint16(gateway[2:4]),
+		Data:     original,
+	}
+}
+
+// Gateway returns the gateway a Redirect message names, or 0.0.0.0 for
+// other messages.
+func (m *Message) Gateway() common.IPv4Address {
+	var gateway common.IPv4Address
+	if m.Type == TypeRedirect {
+		binary.BigEndian.PutUint16(gateway[0:2], m.ID)
+		binary.BigEndian.PutUint16(gateway[2:4], m.Sequence)
+	}
+	return gateway
+}
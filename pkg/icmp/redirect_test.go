@@ -0,0 +1,32 @@
+package icmp
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestRedirect(t *testing.T) {
+	gateway := common.IPv4Address{10, 0, 0, 254}
+	raw, err := NewRedirect(CodeRedirectHost, gateway, quote()).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	msg, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if msg.Type != TypeRedirect || msg.Code != CodeRedirectHost || !msg.VerifyChecksum() {
+		t.Errorf("Parse() = %v, want a host redirect", msg)
+	}
+	if got := msg.Gateway(); got != gateway {
+		t.Errorf("Gateway() = %s, want %s", got, gateway)
+	}
+	if orig, err := msg.Original(); err != nil || orig.Destination != (common.IPv4Address{10, 0, 0, 2}) {
+		t.Errorf("Original() = %+v, %v; want the quoted datagram", orig, err)
+	}
+
+	if got := NewEchoRequest(0x0a00, 0x00fe, nil).Gateway(); got != (common.IPv4Address{}) {
+		t.Errorf("Gateway() of an Echo Request = %s, want 0.0.0.0", got)
+	}
+}
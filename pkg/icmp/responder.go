@@ -0,0 +1,203 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+const (
+	// DefaultResponseRate is the default number of replies sent per second.
+	DefaultResponseRate = 1000
+
+	// DefaultResponseBurst is the default number of replies that may be
+	// sent at once after a quiet period.
+	DefaultResponseBurst = 50
+
+	// timestampDataLength is the length of the originate, receive and
+	// transmit timestamps of Timestamp messages.
+	timestampDataLength = 12
+
+	// maskDataLength is the length of the mask of Address Mask messages.
+	maskDataLength = 4
+)
+
+// ResponderConfig configures a Responder.
+type ResponderConfig struct {
+	// IsLocal reports whether an address is one of the host's. Only
+	// requests to local addresses are answered, so broadcast and multicast
+	// pings are ignored unless IsLocal accepts them.
+	IsLocal func(addr common.IPv4Address) bool
+
+	Rate  int // Replies per second (default DefaultResponseRate, negative for no limit)
+	Burst int // Replies sent at once after a quiet period (default DefaultResponseBurst)
+
+	// Timestamp enables replies to Timestamp Requests.
+	Timestamp bool
+
+	// AddressMask, if set, returns the mask of a local address, enabling
+	// replies to Address Mask Requests (RFC 950) for the addresses it
+	// reports a mask for.
+	AddressMask func(addr common.IPv4Address) (mask common.IPv4Address, ok bool)
+}
+
+// ResponderStats holds the counters of a Responder.
+type ResponderStats struct {
+	Requests    uint64 // Requests to local addresses
+	Replies     uint64 // Replies sent
+	RateLimited uint64 // Requests not answered because of the rate limit
+	Ignored     uint64 // Messages not answered: not requests, not for us, or disabled
+	Invalid     uint64 // Requests with a bad checksum or length
+}
+
+// Responder answers the ICMP queries sent to a host: Echo Requests, so that
+// ping works, and optionally Timestamp and Address Mask Requests. Replies
+// are rate limited with a token bucket, like Linux's icmp_ratelimit, so the
+// host cannot be used to amplify a flood.
+//
+// The Responder only builds replies; the caller sends them from the
+// request's destination address back to its source.
+type Responder struct {
+	config ResponderConfig
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time // Time tokens were last added
+	stats  ResponderStats
+
+	now func() time.Time // Replaced in tests
+}
+
+// NewResponder creates a responder.
+func NewResponder(config ResponderConfig) (*Responder, error) {
+	if config.IsLocal == nil {
+		return nil, fmt.Errorf("IsLocal is required")
+	}
+
+	// Apply defaults
+	if config.Rate == 0 {
+		config.Rate = DefaultResponseRate
+	}
+	if config.Burst == 0 {
+		config.Burst = DefaultResponseBurst
+	}
+	if config.Burst < 0 {
+		return nil, fmt.Errorf("invalid burst: %d", config.Burst)
+	}
+
+	r := &Responder{
+		config: config,
+		tokens: float64(config.Burst),
+		now:    time.Now,
+	}
+	r.last = r.now()
+	return r, nil
+}
+
+// Respond returns the reply to a message received from src for dst, or nil
+// if it should not be answered.
+func (r *Responder) Respond(req *Message, src, dst common.IPv4Address) *Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.answers(req.Type, dst) {
+		r.stats.Ignored++
+		return nil
+	}
+	r.stats.Requests++
+	if !req.VerifyChecksum() {
+		r.stats.Invalid++
+		return nil
+	}
+
+	var reply *Message
+	switch req.Type {
+	case TypeEchoRequest:
+		reply = NewEchoReply(req.ID, req.Sequence, req.Data)
+
+	case TypeTimestampRequest:
+		if len(req.Data) < timestampDataLength {
+			r.stats.Invalid++
+			return nil
+		}
+		now := msSinceMidnight(r.now())
+		data := make([]byte, timestampDataLength)
+		copy(data[0:4], req.Data[0:4]) // Originate timestamp
+		binary.BigEndian.PutUint32(data[4:8], now)
+		binary.BigEndian.PutUint32(data[8:12], now)
+		reply = &Message{Type: TypeTimestampReply, ID: req.ID, Sequence: req.Sequence, Data: data}
+
+	case TypeAddressMaskRequest:
+		mask, _ := r.config.AddressMask(dst)
+		data := make([]byte, maskDataLength)
+		copy(data, mask[:])
+		reply = &Message{Type: TypeAddressMaskReply, ID: req.ID, Sequence: req.Sequence, Data: data}
+	}
+
+	if !r.allow() {
+		r.stats.RateLimited++
+		return nil
+	}
+	r.stats.Replies++
+	return reply
+}
+
+// Stats returns the responder's counters.
+func (r *Responder) Stats() ResponderStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// answers reports whether the responder answers messages of type t sent to
+// dst. Must be called with r.mu held.
+func (r *Responder) answers(t Type, dst common.IPv4Address) bool {
+	switch t {
+	case TypeEchoRequest:
+	case TypeTimestampRequest:
+		if !r.config.Timestamp {
+			return false
+		}
+	case TypeAddressMaskRequest:
+		if r.config.AddressMask == nil {
+			return false
+		}
+		if _, ok := r.config.AddressMask(dst); !ok {
+			return false
+		}
+	default:
+		return false
+	}
+	return r.config.IsLocal(dst)
+}
+
+// allow takes a token for a reply, if there is one. Must be called with
+// r.mu held.
+func (r *Responder) allow() bool {
+	if r.config.Rate < 0 {
+		return true
+	}
+
+	now := r.now()
+	r.tokens += now.Sub(r.last).Seconds() * float64(r.config.Rate)
+	r.last = now
+	if burst := float64(r.config.Burst); r.tokens > burst {
+		r.tokens = burst
+	}
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// msSinceMidnight returns the milliseconds since midnight UT, the format of
+// ICMP timestamps (RFC 792).
+func msSinceMidnight(t time.Time) uint32 {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return uint32(t.Sub(midnight) / time.Millisecond)
+}
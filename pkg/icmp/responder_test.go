@@ -0,0 +1,168 @@
+package icmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+var (
+	localIP  = common.IPv4Address{10, 0, 0, 1}
+	remoteIP = common.IPv4Address{10, 0, 0, 2}
+)
+
+// newTestResponder returns a responder for localIP with a clock the test
+// sets.
+func newTestResponder(t *testing.T, config ResponderConfig) (*Responder, *time.Time) {
+	t.Helper()
+	config.IsLocal = func(addr common.IPv4Address) bool { return addr == localIP }
+	r, err := NewResponder(config)
+	if err != nil {
+		t.Fatalf("NewResponder() error = %v", err)
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	r.last = now
+	return r, &now
+}
+
+// request returns a checksummed request as it would be parsed.
+func request(typ Type, id, seq uint16, data []byte) *Message {
+	msg := &Message{Type: typ, ID: id, Sequence: seq, Data: data}
+	raw, _ := msg.Serialize()
+	parsed, _ := Parse(raw)
+	return parsed
+}
+
+func TestResponderEcho(t *testing.T) {
+	r, _ := newTestResponder(t, ResponderConfig{})
+
+	reply := r.Respond(request(TypeEchoRequest, 0x1234, 7, []byte("ping")), remoteIP, localIP)
+	if reply == nil {
+		t.Fatal("Respond() = nil, want an Echo Reply")
+	}
+	if reply.Type != TypeEchoReply || reply.ID != 0x1234 || reply.Sequence != 7 || string(reply.Data) != "ping" {
+		t.Errorf("Respond() = %v, want Echo Reply 0x1234/7 with the request's data", reply)
+	}
+
+	bad := request(TypeEchoRequest, 1, 1, []byte("ping"))
+	bad.Checksum++
+	ignored := []struct {
+		name string
+		msg  *Message
+		dst  common.IPv4Address
+	}{
+		{"other host", request(TypeEchoRequest, 1, 1, nil), remoteIP},
+		{"broadcast", request(TypeEchoRequest, 1, 1, nil), common.IPv4Address{255, 255, 255, 255}},
+		{"echo reply", request(TypeEchoReply, 1, 1, nil), localIP},
+		{"timestamp disabled", request(TypeTimestampRequest, 1, 1, make([]byte, 12)), localIP},
+		{"address mask disabled", request(TypeAddressMaskRequest, 1, 1, make([]byte, 4)), localIP},
+		{"bad checksum", bad, localIP},
+	}
+	for _, tt := range ignored {
+		if reply := r.Respond(tt.msg, remoteIP, tt.dst); reply != nil {
+			t.Errorf("%s: Respond() = %v, want nil", tt.name, reply)
+		}
+	}
+
+	want := ResponderStats{Requests: 2, Replies: 1, Ignored: 5, Invalid: 1}
+	if got := r.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestResponderRateLimit(t *testing.T) {
+	r, now := newTestResponder(t, ResponderConfig{Rate: 10, Burst: 3})
+	ping := request(TypeEchoRequest, 1, 1, nil)
+
+	answered := func(n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if r.Respond(ping, remoteIP, localIP) != nil {
+				count++
+			}
+		}
+		return count
+	}
+
+	// A burst, then one reply per 100ms
+	if got := answered(5); got != 3 {
+		t.Errorf("answered %d of a burst of 5, want 3", got)
+	}
+	*now = now.Add(250 * time.Millisecond)
+	if got := answered(5); got != 2 {
+		t.Errorf("answered %d after 250ms, want 2", got)
+	}
+	*now = now.Add(time.Hour)
+	if got := answered(5); got != 3 {
+		t.Errorf("answered %d after an hour, want the burst of 3", got)
+	}
+	if got := r.Stats().RateLimited; got != 7 {
+		t.Errorf("Stats().RateLimited = %d, want 7", got)
+	}
+
+	unlimited, _ := newTestResponder(t, ResponderConfig{Rate: -1})
+	for i := 0; i < 1000; i++ {
+		if unlimited.Respond(ping, remoteIP, localIP) == nil {
+			t.Fatalf("unlimited responder dropped request %d", i)
+		}
+	}
+}
+
+func TestResponderTimestamp(t *testing.T) {
+	r, _ := newTestResponder(t, ResponderConfig{Timestamp: true})
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[0:4], 12345)
+
+	reply := r.Respond(request(TypeTimestampRequest, 9, 3, data), remoteIP, localIP)
+	if reply == nil || reply.Type != TypeTimestampReply || reply.ID != 9 || reply.Sequence != 3 {
+		t.Fatalf("Respond() = %v, want a Timestamp Reply", reply)
+	}
+	want := uint32(12 * time.Hour / time.Millisecond) // The clock is at noon UT
+	if len(reply.Data) != 12 {
+		t.Fatalf("reply data is %d bytes, want 12", len(reply.Data))
+	}
+	if got := binary.BigEndian.Uint32(reply.Data[0:4]); got != 12345 {
+		t.Errorf("originate timestamp = %d, want 12345", got)
+	}
+	for _, off := range []int{4, 8} {
+		if got := binary.BigEndian.Uint32(reply.Data[off : off+4]); got != want {
+			t.Errorf("timestamp at %d = %d, want %d", off, got, want)
+		}
+	}
+
+	if reply := r.Respond(request(TypeTimestampRequest, 9, 3, data[:8]), remoteIP, localIP); reply != nil {
+		t.Errorf("Respond() to a short request = %v, want nil", reply)
+	}
+}
+
+func TestResponderAddressMask(t *testing.T) {
+	mask := common.IPv4Address{255, 255, 255, 0}
+	r, _ := newTestResponder(t, ResponderConfig{
+		AddressMask: func(addr common.IPv4Address) (common.IPv4Address, bool) { return mask, true },
+	})
+
+	reply := r.Respond(request(TypeAddressMaskRequest, 5, 1, make([]byte, 4)), remoteIP, localIP)
+	if reply == nil || reply.Type != TypeAddressMaskReply || !bytes.Equal(reply.Data, mask[:]) {
+		t.Fatalf("Respond() = %v, want an Address Mask Reply with %s", reply, mask)
+	}
+	raw, _ := reply.Serialize()
+	if parsed, _ := Parse(raw); !parsed.VerifyChecksum() {
+		t.Error("reply has a bad checksum")
+	}
+}
+
+func TestNewResponderErrors(t *testing.T) {
+	configs := []ResponderConfig{
+		{},
+		{IsLocal: func(common.IPv4Address) bool { return true }, Burst: -1},
+	}
+	for _, config := range configs {
+		if _, err := NewResponder(config); err == nil {
+			t.Errorf("NewResponder(%+v) error = nil, want an error", config)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package interop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	srcMAC = common.MACAddress{0x02, 0, 0, 0, 0, 1}
+	dstMAC = common.MACAddress{0x02, 0, 0, 0, 0, 2}
+	srcIP  = common.IPv4Address{10, 0, 0, 1}
+	dstIP  = common.IPv4Address{10, 0, 0, 2}
+)
+
+// tcpFrame builds a frame carrying a SYN with an MSS option.
+func tcpFrame(t *testing.T) *ethernet.Frame {
+	t.Helper()
+	seg := tcp.NewSegment(40000, 80, 1000, 0, tcp.FlagSYN, 65535, []byte("hi"))
+	seg.Options = []byte{2, 4, 0x05, 0xb4} // MSS 1460
+	checksum, err := seg.CalculateChecksum(srcIP, dstIP)
+	if err != nil {
+		t.Fatalf("CalculateChecksum() error = %v", err)
+	}
+	seg.Checksum = checksum
+	segment, err := seg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	packet, err := ip.NewPacket(srcIP, dstIP, common.ProtocolTCP, segment).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	return ethernet.NewFrame(dstMAC, srcMAC, common.EtherTypeIPv4, packet)
+}
+
+func TestToPacket(t *testing.T) {
+	pkt := ToPacket(tcpFrame(t))
+	if err := pkt.ErrorLayer(); err != nil {
+		t.Fatalf("gopacket failed to decode the frame: %v", err.Error())
+	}
+
+	eth, _ := pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	ipv4, _ := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	seg, _ := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if eth == nil || ipv4 == nil || seg == nil {
+		t.Fatalf("layers = %v, want Ethernet, IPv4 and TCP", pkt.Layers())
+	}
+	if !bytes.Equal(eth.SrcMAC, srcMAC[:]) || !ipv4.DstIP.Equal(net.IP(dstIP[:])) {
+		t.Errorf("addresses = %s, %s; want %s, %s", eth.SrcMAC, ipv4.DstIP, srcMAC, dstIP)
+	}
+	if seg.SrcPort != 40000 || seg.DstPort != 80 || !seg.SYN || seg.Seq != 1000 || string(seg.Payload) != "hi" {
+		t.Errorf("TCP layer = %+v", seg)
+	}
+	if len(seg.Options) != 1 || seg.Options[0].OptionType != layers.TCPOptionKindMSS {
+		t.Errorf("TCP options = %v, want MSS", seg.Options)
+	}
+
+	// gopacket agrees the checksum is right
+	seg.SetNetworkLayerForChecksum(ipv4)
+	got := seg.Checksum
+	if _, err := Serialize(seg, gopacket.Payload(seg.Payload)); err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if seg.Checksum != got {
+		t.Errorf("checksum = %#04x, gopacket computes %#04x", got, seg.Checksum)
+	}
+}
+
+func TestFromPacket(t *testing.T) {
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr(srcMAC[:]), DstMAC: net.HardwareAddr(dstMAC[:]), EthernetType: layers.EthernetTypeDot1Q}
+	vlan := &layers.Dot1Q{VLANIdentifier: 42, Priority: 3, Type: layers.EthernetTypeIPv4}
+	ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP(srcIP[:]), DstIP: net.IP(dstIP[:])}
+	datagram := &layers.UDP{SrcPort: 5000, DstPort: 53}
+	datagram.SetNetworkLayerForChecksum(ipv4)
+	data, err := Serialize(eth, vlan, ipv4, datagram, gopacket.Payload("query"))
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	frame, err := FromPacket(gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default))
+	if err != nil {
+		t.Fatalf("FromPacket() error = %v", err)
+	}
+	if frame.Source != srcMAC || len(frame.VLAN) != 1 || frame.VLAN[0].VID != 42 || frame.VLAN[0].Priority != 3 || frame.EtherType != common.EtherTypeIPv4 {
+		t.Fatalf("frame = %+v", frame)
+	}
+	pkt, err := ip.Parse(frame.Payload)
+	if err != nil {
+		t.Fatalf("ip.Parse() error = %v", err)
+	}
+	u, err := udp.Parse(pkt.Payload)
+	if err != nil {
+		t.Fatalf("udp.Parse() error = %v", err)
+	}
+	if !u.VerifyChecksum(pkt.Source, pkt.Destination) || string(u.Data) != "query" {
+		t.Errorf("datagram = %+v, want a valid one carrying the query", u)
+	}
+
+	// Packets decoded from another link layer have no frame
+	raw := gopacket.NewPacket(data[18:], layers.LayerTypeIPv4, gopacket.Default)
+	if _, err := FromPacket(raw); err == nil {
+		t.Error("FromPacket() of an IPv4 packet succeeded, want error")
+	}
+}
+
+func TestIPv4RoundTrip(t *testing.T) {
+	p := ip.NewPacket(srcIP, dstIP, common.ProtocolUDP, []byte("payload"))
+	p.TTL, p.DSCP, p.Identification = 17, 46, 0x1234
+	p.Flags = ip.FlagDontFragment
+	p.Options = []byte{1, 1, 1, 1} // NOPs
+
+	l, err := IPv4Layer(p)
+	if err != nil {
+		t.Fatalf("IPv4Layer() error = %v", err)
+	}
+	if l.TTL != 17 || l.TOS != 46<<2 || l.Id != 0x1234 || l.Flags != layers.IPv4DontFragment || l.IHL != 6 || string(l.Payload) != "payload" {
+		t.Errorf("IPv4Layer() = %+v", l)
+	}
+
+	back, err := PacketFromIPv4(l)
+	if err != nil {
+		t.Fatalf("PacketFromIPv4() error = %v", err)
+	}
+	if back.Source != srcIP || back.TTL != 17 || back.DSCP != 46 || back.Checksum != p.Checksum || !bytes.Equal(back.Options, p.Options) || string(back.Payload) != "payload" {
+		t.Errorf("PacketFromIPv4() = %+v, want %+v", back, p)
+	}
+}
+
+func TestPacketFromIPv4ComputesFields(t *testing.T) {
+	l := &layers.IPv4{Version: 4, TTL: 1, Protocol: layers.IPProtocolICMPv4, SrcIP: net.IP(srcIP[:]), DstIP: net.IP(dstIP[:])}
+	l.Payload = []byte{8, 0, 0, 0}
+	p, err := PacketFromIPv4(l)
+	if err != nil {
+		t.Fatalf("PacketFromIPv4() error = %v", err)
+	}
+	if p.IHL != 5 || p.TotalLength != 24 || !p.VerifyChecksum() {
+		t.Errorf("PacketFromIPv4() = %+v, want a valid header", p)
+	}
+}
+
+func TestTCPRoundTrip(t *testing.T) {
+	s := tcp.NewSegment(1234, 443, 0xdeadbeef, 42, tcp.FlagACK|tcp.FlagPSH, 512, []byte("data"))
+	s.Options = []byte{1, 1, 8, 10, 0, 0, 0, 1, 0, 0, 0, 2} // Timestamps
+	s.Checksum = 0xabcd
+
+	l, err := TCPLayer(s)
+	if err != nil {
+		t.Fatalf("TCPLayer() error = %v", err)
+	}
+	if l.Seq != 0xdeadbeef || l.Ack != 42 || !l.ACK || !l.PSH || l.SYN || l.Window != 512 || l.Checksum != 0xabcd || string(l.Payload) != "data" {
+		t.Errorf("TCPLayer() = %+v", l)
+	}
+
+	back, err := SegmentFromTCP(l)
+	if err != nil {
+		t.Fatalf("SegmentFromTCP() error = %v", err)
+	}
+	if back.SequenceNumber != s.SequenceNumber || back.Flags != s.Flags || back.Checksum != 0xabcd || back.DataOffset != 8 ||
+		!bytes.Equal(back.Options, s.Options) || string(back.Data) != "data" {
+		t.Errorf("SegmentFromTCP() = %+v, want %+v", back, s)
+	}
+}
+
+func TestUDPRoundTrip(t *testing.T) {
+	p := udp.NewPacket(68, 67, []byte("dhcp"))
+	p.Checksum = 0x1111
+
+	l, err := UDPLayer(p)
+	if err != nil {
+		t.Fatalf("UDPLayer() error = %v", err)
+	}
+	if l.SrcPort != 68 || l.DstPort != 67 || l.Length != 12 || l.Checksum != 0x1111 || string(l.Payload) != "dhcp" {
+		t.Errorf("UDPLayer() = %+v", l)
+	}
+
+	l.Payload = []byte("longer payload")
+	back, err := PacketFromUDP(l)
+	if err != nil {
+		t.Fatalf("PacketFromUDP() error = %v", err)
+	}
+	if back.Length != 22 || back.Checksum != 0x1111 || string(back.Data) != "longer payload" {
+		t.Errorf("PacketFromUDP() = %+v", back)
+	}
+}
+
+func TestTransmitAndCapture(t *testing.T) {
+	a, b := memory.NewPipe(memory.Config{})
+	defer a.Close()
+
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr(srcMAC[:]), DstMAC: net.HardwareAddr(dstMAC[:]), EthernetType: layers.EthernetTypeARP}
+	arp := &layers.ARP{
+		AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4,
+		Operation: layers.ARPRequest, SourceHwAddress: srcMAC[:], SourceProtAddress: srcIP[:],
+		DstHwAddress: make([]byte, 6), DstProtAddress: dstIP[:],
+	}
+	if err := Transmit(a, eth, arp); err != nil {
+		t.Fatalf("Transmit() error = %v", err)
+	}
+	if err := Transmit(a, arp); err == nil {
+		t.Error("Transmit() without an Ethernet layer succeeded, want error")
+	}
+
+	source := NewDataSource(b)
+	captured := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	source.now = func() time.Time { return captured }
+	data, ci, err := source.ReadPacketData()
+	if err != nil {
+		t.Fatalf("ReadPacketData() error = %v", err)
+	}
+	if ci.Timestamp != captured || ci.CaptureLength != len(data) || ci.Length != len(data) || ci.InterfaceIndex != b.Index() {
+		t.Errorf("CaptureInfo = %+v", ci)
+	}
+
+	pkt := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	got, _ := pkt.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if got == nil || got.Operation != layers.ARPRequest || !bytes.Equal(got.DstProtAddress, dstIP[:]) {
+		t.Errorf("captured layers = %v, want the ARP request", pkt.Layers())
+	}
+	if op := binary.BigEndian.Uint16(data[20:22]); op != layers.ARPRequest {
+		t.Errorf("ARP operation on the wire = %d, want %d", op, layers.ARPRequest)
+	}
+}
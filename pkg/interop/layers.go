@@ -0,0 +1,97 @@
+package interop
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// IPv4Layer returns an IPv4 packet as a gopacket layer, with the payload
+// as the layer's payload. The packet's IHL, length and checksum are
+// updated as by Serialize.
+func IPv4Layer(p *ip.Packet) (*layers.IPv4, error) {
+	data, err := p.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	l := &layers.IPv4{}
+	if err := l.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		return nil, fmt.Errorf("gopacket failed to decode IPv4 packet: %w", err)
+	}
+	return l, nil
+}
+
+// PacketFromIPv4 returns a gopacket IPv4 layer, with its payload, as an
+// IPv4 packet. Its length fields and checksum are computed.
+func PacketFromIPv4(l *layers.IPv4) (*ip.Packet, error) {
+	data, err := serializeLayer(l, l.Payload, true)
+	if err != nil {
+		return nil, err
+	}
+	return ip.Parse(data)
+}
+
+// TCPLayer returns a TCP segment as a gopacket layer, with the data as
+// the layer's payload. The checksum is copied, not computed.
+func TCPLayer(s *tcp.Segment) (*layers.TCP, error) {
+	data, err := s.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	l := &layers.TCP{}
+	if err := l.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		return nil, fmt.Errorf("gopacket failed to decode TCP segment: %w", err)
+	}
+	return l, nil
+}
+
+// SegmentFromTCP returns a gopacket TCP layer, with its payload, as a TCP
+// segment. The data offset is computed, and the checksum copied.
+func SegmentFromTCP(l *layers.TCP) (*tcp.Segment, error) {
+	data, err := serializeLayer(l, l.Payload, false)
+	if err != nil {
+		return nil, err
+	}
+	return tcp.Parse(data)
+}
+
+// UDPLayer returns a UDP datagram as a gopacket layer, with the data as
+// the layer's payload. The checksum is copied, not computed.
+func UDPLayer(p *udp.Packet) (*layers.UDP, error) {
+	data, err := p.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	l := &layers.UDP{}
+	if err := l.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		return nil, fmt.Errorf("gopacket failed to decode UDP datagram: %w", err)
+	}
+	return l, nil
+}
+
+// PacketFromUDP returns a gopacket UDP layer, with its payload, as a UDP
+// datagram. The length is computed, and the checksum copied.
+func PacketFromUDP(l *layers.UDP) (*udp.Packet, error) {
+	data, err := serializeLayer(l, l.Payload, false)
+	if err != nil {
+		return nil, err
+	}
+	return udp.Parse(data)
+}
+
+// serializeLayer encodes a layer followed by its payload. Transport layer
+// checksums cannot be computed without the network layer, so only the IPv4
+// header checksum is.
+func serializeLayer(l gopacket.SerializableLayer, payload []byte, checksum bool) ([]byte, error) {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: checksum}
+	if err := gopacket.SerializeLayers(buf, opts, l, gopacket.Payload(payload)); err != nil {
+		return nil, fmt.Errorf("failed to serialize %s layer: %w", l.LayerType(), err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,106 @@
+// Package interop converts between the stack's packet types and the
+// layers of github.com/google/gopacket, so that existing gopacket tooling
+// can analyze the stack's traffic and gopacket-built packets can be sent
+// on the stack's devices.
+//
+// Frames convert to and from whole gopacket packets, decoded from the
+// link layer up:
+//
+//	pkt := interop.ToPacket(frame)
+//	if tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP); ok { ... }
+//
+// and IPv4 packets, TCP segments and UDP datagrams to and from the single
+// gopacket layers. Conversions go through the wire format, so every field
+// gopacket decodes keeps its value, options included.
+package interop
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// ToPacket returns a frame as a gopacket packet decoded from its Ethernet
+// layer. The packet has a copy of the frame's bytes.
+func ToPacket(f *ethernet.Frame) gopacket.Packet {
+	return gopacket.NewPacket(f.Serialize(), layers.LayerTypeEthernet, gopacket.Default)
+}
+
+// FromPacket returns the frame of a gopacket packet whose first layer is
+// Ethernet. The frame's payload aliases the packet's data.
+func FromPacket(p gopacket.Packet) (*ethernet.Frame, error) {
+	if p.LinkLayer() == nil || p.LinkLayer().LayerType() != layers.LayerTypeEthernet {
+		return nil, fmt.Errorf("not an Ethernet packet")
+	}
+	return ethernet.Parse(p.Data())
+}
+
+// Serialize encodes gopacket layers as gopacket.SerializeLayers does,
+// fixing their lengths and computing their checksums.
+func Serialize(ls ...gopacket.SerializableLayer) ([]byte, error) {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Transmit sends the frame gopacket layers encode, starting with an
+// Ethernet layer, on a device. Lengths and checksums are computed as by
+// Serialize, so a transport layer must be given its network layer with
+// SetNetworkLayerForChecksum.
+func Transmit(dev ethernet.Device, ls ...gopacket.SerializableLayer) error {
+	if len(ls) == 0 || ls[0].LayerType() != layers.LayerTypeEthernet {
+		return fmt.Errorf("first layer is not Ethernet")
+	}
+	data, err := Serialize(ls...)
+	if err != nil {
+		return fmt.Errorf("failed to serialize layers: %w", err)
+	}
+	frame, err := ethernet.Parse(data)
+	if err != nil {
+		return err
+	}
+	return dev.WriteFrame(frame)
+}
+
+// DataSource reads the frames a device receives as a gopacket
+// PacketDataSource.
+type DataSource struct {
+	dev ethernet.Device
+	now func() time.Time
+}
+
+// NewDataSource creates a data source reading from a device.
+func NewDataSource(dev ethernet.Device) *DataSource {
+	return &DataSource{dev: dev, now: time.Now}
+}
+
+// NewPacketSource returns a gopacket packet source of the frames a device
+// receives, decoded from their Ethernet layer:
+//
+//	for pkt := range interop.NewPacketSource(dev).Packets() { ... }
+func NewPacketSource(dev ethernet.Device) *gopacket.PacketSource {
+	return gopacket.NewPacketSource(NewDataSource(dev), layers.LayerTypeEthernet)
+}
+
+// ReadPacketData reads the next frame, timestamped when it is read.
+func (s *DataSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	frame, err := s.dev.ReadFrame()
+	if err != nil {
+		return nil, gopacket.CaptureInfo{}, err
+	}
+	data := frame.Serialize()
+	frame.Release()
+	return data, gopacket.CaptureInfo{
+		Timestamp:      s.now(),
+		CaptureLength:  len(data),
+		Length:         len(data),
+		InterfaceIndex: s.dev.Index(),
+	}, nil
+}
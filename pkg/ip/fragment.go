@@ -2,19 +2,44 @@
 package ip
 
 import (
+	"container/list"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
 )
 
 const (
 	// MaxFragmentSize is the maximum size of a fragment payload (must be multiple of 8).
-	MaxFragmentSize = 1480 // Typical MTU (1500) - IP header (20)
+	MaxFragmentSize = 1480 // DefaultMTU (1500) - IP header (20); see PathMTU for other links
 
 	// FragmentTimeout is the maximum time to wait for all fragments.
 	FragmentTimeout = 60 * time.Second
+
+	// DefaultReassemblyMemory is the default memory budget for fragments
+	// awaiting reassembly, like Linux's ipfrag_high_thresh.
+	DefaultReassemblyMemory = 4 << 20
+
+	// fragmentOverhead is the memory charged for each fragment on top of
+	// its data, so that many tiny fragments cannot exceed the budget
+	// unnoticed.
+	fragmentOverhead = 64
+)
+
+var (
+	// ErrFragmentOverlap is returned for a fragment overlapping one
+	// already received for the same datagram. The whole datagram is
+	// discarded, as overlaps only come from attacks such as teardrop
+	// (RFC 1858).
+	ErrFragmentOverlap = errors.New("overlapping fragment")
+
+	// ErrInvalidFragment is returned for a fragment that cannot belong to
+	// a valid datagram.
+	ErrInvalidFragment = errors.New("invalid fragment")
 )
 
 // FragmentKey uniquely identifies a set of fragments.
@@ -32,65 +57,127 @@ type FragmentEntry struct {
 	ReceivedLength uint16            // How much data we've received so far
 	LastSeen       time.Time         // Last time we received a fragment
 	Complete       bool              // Whether we have all fragments
+
+	key    FragmentKey
+	first  *Packet       // Fragment at offset 0, once received
+	memory int           // Memory charged to the budget
+	timer  *time.Timer   // Reassembly timer, started by the first fragment
+	elem   *list.Element // Position in the fragmenter's age order
+}
+
+// ReassemblyConfig configures reassembly.
+type ReassemblyConfig struct {
+	Timeout   time.Duration // Time allowed from the first fragment (default FragmentTimeout)
+	MaxMemory int           // Memory for incomplete datagrams (default DefaultReassemblyMemory)
+
+	// OnTimeout, if set, is called when a datagram whose first fragment
+	// was received times out, with the Time Exceeded message to send from
+	// src to dst (RFC 792). It is called without locks held.
+	OnTimeout func(msg *icmp.Message, src, dst common.IPv4Address)
 }
 
 // Fragmenter handles IP fragmentation and reassembly.
+//
+// Incomplete datagrams are held until a timer started by their first
+// fragment expires, and within a memory budget: a fragment that would
+// exceed it evicts the oldest datagrams first.
 type Fragmenter struct {
-	mu         sync.RWMutex
-	fragments  map[FragmentKey]*FragmentEntry
-	nextID     uint16 // Next identification number for outgoing fragments
-	cleanupTicker *time.Ticker
-	done       chan struct{}
+	config ReassemblyConfig
+
+	mu        sync.RWMutex
+	fragments map[FragmentKey]*FragmentEntry
+	age       list.List // Incomplete datagrams, oldest first
+	memory    int       // Memory held by incomplete datagrams
+	nextID    uint16    // Next identification number for outgoing fragments
 }
 
-// NewFragmenter creates a new fragmenter.
+// NewFragmenter creates a new fragmenter with the default reassembly
+// configuration.
 func NewFragmenter() *Fragmenter {
-	f := &Fragmenter{
-		fragments: make(map[FragmentKey]*FragmentEntry),
-		nextID:    1,
-		done:      make(chan struct{}),
-	}
+	f, _ := NewFragmenterWithConfig(ReassemblyConfig{}) // The defaults are valid
+	return f
+}
 
-	// Start cleanup goroutine
-	f.cleanupTicker = time.NewTicker(10 * time.Second)
-	go f.cleanupLoop()
+// NewFragmenterWithConfig creates a new fragmenter.
+func NewFragmenterWithConfig(config ReassemblyConfig) (*Fragmenter, error) {
+	// Apply defaults
+	if config.Timeout == 0 {
+		config.Timeout = FragmentTimeout
+	}
+	if config.MaxMemory == 0 {
+		config.MaxMemory = DefaultReassemblyMemory
+	}
+	if config.Timeout < 0 {
+		return nil, fmt.Errorf("invalid reassembly timeout: %v", config.Timeout)
+	}
+	if config.MaxMemory < MaxPacketSize {
+		return nil, fmt.Errorf("reassembly memory %d cannot hold a maximum-size datagram", config.MaxMemory)
+	}
 
-	return f
+	return &Fragmenter{
+		config:    config,
+		fragments: make(map[FragmentKey]*FragmentEntry),
+		nextID:    1,
+	}, nil
 }
 
-// Close stops the fragmenter.
+// Close stops the fragmenter, discarding incomplete datagrams.
 func (f *Fragmenter) Close() {
-	close(f.done)
-	if f.cleanupTicker != nil {
-		f.cleanupTicker.Stop()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, entry := range f.fragments {
+		f.remove(entry)
 	}
 }
 
-// cleanupLoop periodically removes expired fragment entries.
-func (f *Fragmenter) cleanupLoop() {
-	for {
-		select {
-		case <-f.cleanupTicker.C:
-			f.cleanup()
-		case <-f.done:
-			return
-		}
-	}
+// Pending returns the number of incomplete datagrams and the memory they
+// hold.
+func (f *Fragmenter) Pending() (datagrams, memory int) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.fragments), f.memory
 }
 
-// cleanup removes expired fragment entries.
-func (f *Fragmenter) cleanup() {
+// expire discards an incomplete datagram when its reassembly timer fires,
+// reporting the timeout if its first fragment was received.
+func (f *Fragmenter) expire(entry *FragmentEntry) {
 	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	now := time.Now()
-	for key, entry := range f.fragments {
-		if now.Sub(entry.LastSeen) > FragmentTimeout {
-			delete(f.fragments, key)
+	if f.fragments[entry.key] != entry {
+		f.mu.Unlock()
+		return // Completed, evicted or discarded meanwhile
+	}
+	f.remove(entry)
+	counters.reassemblyTimeouts.Add(1)
+	logger.Debug("reassembly timed out", logging.F("src", entry.key.Source), logging.F("dst", entry.key.Destination),
+		logging.F("id", entry.key.Identification), logging.F("received", entry.ReceivedLength))
+	first, onTimeout := entry.first, f.config.OnTimeout
+	f.mu.Unlock()
+
+	if first != nil && onTimeout != nil {
+		if msg := timeExceeded(first); msg != nil {
+			onTimeout(msg, first.Destination, first.Source)
 		}
 	}
 }
 
+// timeExceeded returns the Fragment Reassembly Time Exceeded message
+// quoting the header and first 8 bytes of a datagram's first fragment.
+func timeExceeded(first *Packet) *icmp.Message {
+	quoted := quote(first)
+	if quoted == nil {
+		return nil
+	}
+	return icmp.NewTimeExceeded(icmp.CodeFragmentReassemblyTime, quoted)
+}
+
+// remove discards an incomplete datagram. Must be called with f.mu held.
+func (f *Fragmenter) remove(entry *FragmentEntry) {
+	entry.timer.Stop()
+	f.age.Remove(entry.elem)
+	f.memory -= entry.memory
+	delete(f.fragments, entry.key)
+}
+
 // Fragment fragments a packet into MTU-sized pieces.
 func (f *Fragmenter) Fragment(pkt *Packet, mtu int) ([]*Packet, error) {
 	// Calculate maximum payload size per fragment
@@ -163,11 +250,20 @@ func (f *Fragmenter) Fragment(pkt *Packet, mtu int) ([]*Packet, error) {
 		offset = end
 	}
 
+	counters.fragmentedPackets.Add(1)
+	counters.fragmentsCreated.Add(uint64(len(fragments)))
+	if logger.Enabled(logging.LevelDebug) {
+		logger.Debug("packet fragmented", logging.F("hdr", pkt), logging.F("mtu", mtu), logging.F("fragments", len(fragments)))
+	}
 	return fragments, nil
 }
 
 // Reassemble attempts to reassemble fragments into a complete packet.
 // Returns the reassembled packet if complete, nil otherwise.
+//
+// A fragment repeating one already received is ignored. One overlapping
+// another otherwise, or inconsistent with the datagram's length, discards
+// the datagram and returns ErrFragmentOverlap or ErrInvalidFragment.
 func (f *Fragmenter) Reassemble(pkt *Packet) (*Packet, error) {
 	// If not a fragment, return as-is
 	if !pkt.IsFragment() {
@@ -181,6 +277,17 @@ func (f *Fragmenter) Reassemble(pkt *Packet) (*Packet, error) {
 		Protocol:       pkt.Protocol,
 	}
 
+	counters.fragmentsReceived.Add(1)
+
+	// Calculate byte offset
+	offset := int(pkt.FragmentOffset) * 8
+	end := offset + len(pkt.Payload)
+	last := pkt.Flags&FlagMoreFragments == 0
+	if end > MaxPacketSize-MinHeaderLength || (!last && len(pkt.Payload)%8 != 0) || len(pkt.Payload) == 0 {
+		counters.reassemblyErrors.Add(1)
+		return nil, fmt.Errorf("%w: %d bytes at offset %d", ErrInvalidFragment, len(pkt.Payload), offset)
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
@@ -189,91 +296,128 @@ func (f *Fragmenter) Reassemble(pkt *Packet) (*Packet, error) {
 	if !exists {
 		entry = &FragmentEntry{
 			Fragments: make(map[uint16][]byte),
-			LastSeen:  time.Now(),
+			key:       key,
 		}
+		entry.timer = time.AfterFunc(f.config.Timeout, func() { f.expire(entry) })
+		entry.elem = f.age.PushBack(entry)
 		f.fragments[key] = entry
 	}
 
 	// Update last seen time
 	entry.LastSeen = time.Now()
 
-	// Calculate byte offset
-	byteOffset := uint16(pkt.FragmentOffset * 8)
+	if err := entry.check(offset, end, last); err != nil {
+		f.remove(entry)
+		if errors.Is(err, ErrFragmentOverlap) {
+			counters.reassemblyOverlaps.Add(1)
+		} else {
+			counters.reassemblyErrors.Add(1)
+		}
+		logger.Debug("reassembly discarded", logging.F("src", key.Source), logging.F("dst", key.Destination),
+			logging.F("id", key.Identification), logging.F("err", err))
+		return nil, err
+	}
+	if data, dup := entry.Fragments[uint16(offset)]; dup && len(data) == len(pkt.Payload) {
+		return nil, nil // A duplicate, such as a retransmission
+	}
+
+	// Make room in the memory budget, oldest datagrams first
+	charge := len(pkt.Payload) + fragmentOverhead
+	for f.memory+charge > f.config.MaxMemory && f.age.Len() > 0 {
+		oldest := f.age.Front().Value.(*FragmentEntry)
+		f.remove(oldest)
+		counters.reassemblyEvictions.Add(1)
+		if oldest == entry {
+			return nil, nil
+		}
+	}
 
 	// Store fragment
-	entry.Fragments[byteOffset] = pkt.Payload
+	entry.Fragments[uint16(offset)] = pkt.Payload
+	entry.ReceivedLength += uint16(len(pkt.Payload))
+	entry.memory += charge
+	f.memory += charge
+	if offset == 0 {
+		entry.first = pkt
+	}
 
 	// Check if this is the last fragment
-	if (pkt.Flags & FlagMoreFragments) == 0 {
+	if last {
 		// This is the last fragment, we now know the total length
-		entry.TotalLength = byteOffset + uint16(len(pkt.Payload))
+		entry.TotalLength = uint16(end)
 	}
 
-	// Calculate how much data we have
-	var receivedLength uint16
-	for _, data := range entry.Fragments {
-		receivedLength += uint16(len(data))
+	// Fragments do not overlap, so all the data is there once its length is
+	if entry.TotalLength == 0 || entry.ReceivedLength != entry.TotalLength {
+		// Still waiting for more fragments
+		return nil, nil
 	}
-	entry.ReceivedLength = receivedLength
-
-	// Check if we have all fragments
-	if entry.TotalLength > 0 && entry.ReceivedLength >= entry.TotalLength {
-		// Reassemble the packet
-		reassembled := &Packet{
-			Version:        pkt.Version,
-			IHL:            pkt.IHL,
-			DSCP:           pkt.DSCP,
-			ECN:            pkt.ECN,
-			Identification: pkt.Identification,
-			Flags:          0, // Clear fragment flags
-			FragmentOffset: 0,
-			TTL:            pkt.TTL,
-			Protocol:       pkt.Protocol,
-			Source:         pkt.Source,
-			Destination:    pkt.Destination,
-			Options:        pkt.Options,
-			Payload:        make([]byte, entry.TotalLength),
-		}
-
-		// Copy fragments into payload
-		for offset, data := range entry.Fragments {
-			copy(reassembled.Payload[offset:], data)
-		}
-
-		// Verify we have all the data (no holes)
-		if !f.verifyNoHoles(entry, entry.TotalLength) {
-			return nil, nil // Still waiting for more fragments
-		}
 
-		// Remove from fragments map
-		delete(f.fragments, key)
+	// The first fragment's header, with its options, is the datagram's
+	first := entry.first
+	reassembled := &Packet{
+		Version:        first.Version,
+		IHL:            first.IHL,
+		DSCP:           first.DSCP,
+		ECN:            first.ECN,
+		Identification: first.Identification,
+		Flags:          first.Flags &^ FlagMoreFragments, // Clear fragment flags
+		FragmentOffset: 0,
+		TTL:            first.TTL,
+		Protocol:       first.Protocol,
+		Source:         first.Source,
+		Destination:    first.Destination,
+		Options:        first.Options,
+		Payload:        make([]byte, entry.TotalLength),
+	}
 
-		return reassembled, nil
+	// Copy fragments into payload
+	for offset, data := range entry.Fragments {
+		copy(reassembled.Payload[offset:], data)
+	}
+	entry.Complete = true
+
+	// Remove from fragments map
+	f.remove(entry)
+	counters.reassembled.Add(1)
+	if logger.Enabled(logging.LevelDebug) {
+		logger.Debug("packet reassembled", logging.F("hdr", reassembled),
+			logging.F("len", len(reassembled.Payload)), logging.F("fragments", len(entry.Fragments)))
 	}
 
-	// Still waiting for more fragments
-	return nil, nil
+	return reassembled, nil
 }
 
-// verifyNoHoles checks if there are any gaps in the received fragments.
-func (f *Fragmenter) verifyNoHoles(entry *FragmentEntry, totalLength uint16) bool {
-	// Create a bitmap to track which bytes we have
-	received := make([]bool, totalLength)
-
-	for offset, data := range entry.Fragments {
-		for i := 0; i < len(data); i++ {
-			if int(offset)+i < len(received) {
-				received[int(offset)+i] = true
-			}
+// check verifies that a fragment from offset to end fits the fragments
+// already received: it overlaps none of them, except by repeating one
+// exactly, and agrees with the datagram's length if it is known.
+func (e *FragmentEntry) check(offset, end int, last bool) error {
+	for start, data := range e.Fragments {
+		if int(start) == offset && len(data) == end-offset {
+			continue // A duplicate
+		}
+		if offset < int(start)+len(data) && int(start) < end {
+			return fmt.Errorf("%w: bytes %d-%d overlap %d-%d", ErrFragmentOverlap, offset, end, start, int(start)+len(data))
 		}
 	}
 
-	// Check for holes
-	for i := 0; i < len(received); i++ {
-		if !received[i] {
-			return false
-		}
+	total := int(e.TotalLength)
+	switch {
+	case last && total != 0 && end != total:
+		return fmt.Errorf("%w: datagram ends at %d, not %d", ErrInvalidFragment, total, end)
+	case total != 0 && end > total:
+		return fmt.Errorf("%w: %d bytes past the end of the datagram", ErrInvalidFragment, end-total)
+	case last && end < e.highest():
+		return fmt.Errorf("%w: last fragment ends at %d before received data", ErrInvalidFragment, end)
 	}
+	return nil
+}
 
-	return true
+// highest returns the end of the received data furthest into the datagram.
+func (e *FragmentEntry) highest() int {
+	highest := 0
+	for start, data := range e.Fragments {
+		highest = max(highest, int(start)+len(data))
+	}
+	return highest
 }
@@ -2,10 +2,12 @@ package ip
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
 )
 
 func TestFragmenter_Fragment(t *testing.T) {
@@ -223,51 +225,174 @@ func TestFragmenter_Reassemble_NonFragment(t *testing.T) {
 	}
 }
 
-func TestFragmenter_Cleanup(t *testing.T) {
-	f := NewFragmenter()
+func TestFragmenter_Timeout(t *testing.T) {
+	type timeout struct {
+		msg      *icmp.Message
+		src, dst common.IPv4Address
+	}
+	timeouts := make(chan timeout, 2)
+	f, err := NewFragmenterWithConfig(ReassemblyConfig{
+		Timeout: 20 * time.Millisecond,
+		OnTimeout: func(msg *icmp.Message, src, dst common.IPv4Address) {
+			timeouts <- timeout{msg, src, dst}
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewFragmenterWithConfig() error = %v", err)
+	}
 	defer f.Close()
 
 	srcIP, _ := common.ParseIPv4("192.168.1.100")
 	dstIP, _ := common.ParseIPv4("192.168.1.1")
 
-	// Create a fragment
-	pkt := NewPacket(srcIP, dstIP, common.ProtocolICMP, []byte("test"))
-	pkt.Identification = 0x9999
-	pkt.Flags = FlagMoreFragments
-	pkt.FragmentOffset = 0
+	// The first fragment of one datagram, and a later one of another
+	first := NewPacket(srcIP, dstIP, common.ProtocolUDP, []byte("12345678"))
+	first.Identification = 0x9999
+	first.Flags = FlagMoreFragments
+	later := NewPacket(srcIP, dstIP, common.ProtocolUDP, []byte("test"))
+	later.Identification = 0x9998
+	later.FragmentOffset = 10
+
+	for _, pkt := range []*Packet{first, later} {
+		if _, err := f.Reassemble(pkt); err != nil {
+			t.Fatalf("Reassemble() error = %v", err)
+		}
+	}
+	if n, _ := f.Pending(); n != 2 {
+		t.Fatalf("Pending() = %d datagrams, want 2", n)
+	}
 
-	// Reassemble (will be incomplete)
-	_, err := f.Reassemble(pkt)
-	if err != nil {
-		t.Fatalf("Reassemble() error = %v", err)
+	// Only the datagram whose first fragment arrived is reported
+	select {
+	case got := <-timeouts:
+		if got.msg.Type != icmp.TypeTimeExceeded || got.msg.Code != icmp.CodeFragmentReassemblyTime {
+			t.Errorf("OnTimeout() message = %v, want Fragment Reassembly Time Exceeded", got.msg)
+		}
+		if got.src != dstIP || got.dst != srcIP {
+			t.Errorf("OnTimeout() from %s to %s, want %s to %s", got.src, got.dst, dstIP, srcIP)
+		}
+		orig, err := got.msg.Original()
+		if err != nil {
+			t.Fatalf("Original() error = %v", err)
+		}
+		if !bytes.Equal(orig.Payload, []byte("12345678")) {
+			t.Errorf("quoted payload = %q, want the first 8 bytes", orig.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnTimeout() not called")
 	}
 
-	// Check that entry was created
-	f.mu.RLock()
-	initialCount := len(f.fragments)
-	f.mu.RUnlock()
+	time.Sleep(20 * time.Millisecond)
+	if n, memory := f.Pending(); n != 0 || memory != 0 {
+		t.Errorf("Pending() = %d datagrams, %d bytes; want none", n, memory)
+	}
+	select {
+	case got := <-timeouts:
+		t.Errorf("OnTimeout() called again with %v", got.msg)
+	default:
+	}
+}
 
-	if initialCount == 0 {
-		t.Error("Fragment entry was not created")
+func TestFragmenter_Overlap(t *testing.T) {
+	srcIP, _ := common.ParseIPv4("192.168.1.100")
+	dstIP, _ := common.ParseIPv4("192.168.1.1")
+	fragment := func(offset uint16, size int, more bool) *Packet {
+		pkt := NewPacket(srcIP, dstIP, common.ProtocolUDP, make([]byte, size))
+		pkt.Identification = 0x4242
+		pkt.FragmentOffset = offset
+		if more {
+			pkt.Flags = FlagMoreFragments
+		}
+		return pkt
 	}
 
-	// Manually set last seen to past
-	f.mu.Lock()
-	for _, entry := range f.fragments {
-		entry.LastSeen = time.Now().Add(-2 * FragmentTimeout)
+	tests := []struct {
+		name      string
+		fragments []*Packet
+		wantErr   error
+	}{
+		{"teardrop", []*Packet{fragment(0, 32, true), fragment(1, 8, false)}, ErrFragmentOverlap},
+		{"overlapping start", []*Packet{fragment(2, 16, false), fragment(0, 24, true)}, ErrFragmentOverlap},
+		{"duplicate", []*Packet{fragment(0, 16, true), fragment(0, 16, true), fragment(2, 8, false)}, nil},
+		{"second end", []*Packet{fragment(2, 8, false), fragment(4, 8, false)}, ErrInvalidFragment},
+		{"past the end", []*Packet{fragment(2, 8, false), fragment(3, 8, true)}, ErrInvalidFragment},
+		{"end before data", []*Packet{fragment(4, 8, true), fragment(1, 8, false)}, ErrInvalidFragment},
+		{"unaligned", []*Packet{fragment(0, 12, true)}, ErrInvalidFragment},
+		{"ping of death", []*Packet{fragment(8189, 1480, false)}, ErrInvalidFragment},
 	}
-	f.mu.Unlock()
 
-	// Run cleanup
-	f.cleanup()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFragmenter()
+			defer f.Close()
+
+			var err error
+			var reassembled *Packet
+			for _, frag := range tt.fragments {
+				if reassembled, err = f.Reassemble(frag); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("Reassemble() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if reassembled == nil || len(reassembled.Payload) != 24 {
+					t.Errorf("Reassemble() = %v, want a 24 byte datagram", reassembled)
+				}
+				return
+			}
+
+			// The whole datagram is discarded
+			if n, memory := f.Pending(); n != 0 || memory != 0 {
+				t.Errorf("Pending() = %d datagrams, %d bytes; want none", n, memory)
+			}
+		})
+	}
+}
+
+func TestFragmenter_MemoryBudget(t *testing.T) {
+	f, err := NewFragmenterWithConfig(ReassemblyConfig{MaxMemory: 3 * MaxPacketSize})
+	if err != nil {
+		t.Fatalf("NewFragmenterWithConfig() error = %v", err)
+	}
+	defer f.Close()
 
-	// Check that entry was removed
+	srcIP, _ := common.ParseIPv4("192.168.1.100")
+	dstIP, _ := common.ParseIPv4("192.168.1.1")
+	start := func(id uint16) {
+		t.Helper()
+		pkt := NewPacket(srcIP, dstIP, common.ProtocolUDP, make([]byte, 32*1024))
+		pkt.Identification = id
+		pkt.Flags = FlagMoreFragments
+		if _, err := f.Reassemble(pkt); err != nil {
+			t.Fatalf("Reassemble() error = %v", err)
+		}
+	}
+
+	before := GetStats().ReassemblyEvictions
+	for id := uint16(1); id <= 8; id++ {
+		start(id)
+	}
+	n, memory := f.Pending()
+	if memory > 3*MaxPacketSize {
+		t.Errorf("Pending() memory = %d, want at most %d", memory, 3*MaxPacketSize)
+	}
+	if evicted := GetStats().ReassemblyEvictions - before; int(evicted) != 8-n {
+		t.Errorf("evicted %d datagrams, want %d", evicted, 8-n)
+	}
+
+	// The newest datagrams are kept
 	f.mu.RLock()
-	finalCount := len(f.fragments)
+	_, newest := f.fragments[FragmentKey{srcIP, dstIP, 8, common.ProtocolUDP}]
+	_, oldest := f.fragments[FragmentKey{srcIP, dstIP, 1, common.ProtocolUDP}]
 	f.mu.RUnlock()
+	if !newest || oldest {
+		t.Errorf("newest held = %v, oldest held = %v; want true, false", newest, oldest)
+	}
 
-	if finalCount != 0 {
-		t.Errorf("Cleanup did not remove expired entries: %d remaining", finalCount)
+	if _, err := NewFragmenterWithConfig(ReassemblyConfig{MaxMemory: 1000}); err == nil {
+		t.Error("NewFragmenterWithConfig() with a tiny budget error = nil, want an error")
 	}
 }
 
@@ -0,0 +1,33 @@
+package ip
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/internal/fuzzseed"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func FuzzParse(f *testing.F) {
+	fuzzseed.Add(f, fuzzseed.EtherPayloads(f, uint16(common.EtherTypeIPv4)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt, err := Parse(data)
+		if err != nil {
+			return
+		}
+		_ = pkt.String()
+
+		serialized, err := pkt.Serialize()
+		if err != nil {
+			return // Options too long to pad within the header
+		}
+		again, err := Parse(serialized)
+		if err != nil {
+			t.Fatalf("Parse() of serialized packet error = %v", err)
+		}
+		if !bytes.Equal(again.Payload, pkt.Payload) {
+			t.Errorf("reparsed payload = %x, want %x", again.Payload, pkt.Payload)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package ip
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+const (
+	// DefaultMTU is the MTU of interfaces whose MTU the routing table does
+	// not know, that of Ethernet.
+	DefaultMTU = 1500
+
+	// MinMTU is the smallest MTU, the smallest datagram every link must
+	// carry whole (RFC 791).
+	MinMTU = 68
+)
+
+// SetInterfaceMTU records the MTU of an interface, 9000 for one carrying
+// jumbo frames, which PathMTU returns for the routes out of it. An MTU of
+// 0 forgets it; one above 65535, such as the 65536 of Linux's loopback,
+// is taken as the largest datagram.
+func (rt *RoutingTable) SetInterfaceMTU(iface string, mtu int) error {
+	if mtu != 0 && mtu < MinMTU {
+		return fmt.Errorf("invalid MTU %d for %s", mtu, iface)
+	}
+	mtu = min(mtu, math.MaxUint16)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if mtu == 0 {
+		delete(rt.mtus, iface)
+		return nil
+	}
+	if rt.mtus == nil {
+		rt.mtus = make(map[string]int)
+	}
+	rt.mtus[iface] = mtu
+	return nil
+}
+
+// InterfaceMTU returns the MTU of an interface, DefaultMTU if it was not
+// set.
+func (rt *RoutingTable) InterfaceMTU(iface string) int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.interfaceMTU(iface)
+}
+
+// interfaceMTU is InterfaceMTU, called with rt.mu held.
+func (rt *RoutingTable) interfaceMTU(iface string) int {
+	if mtu, ok := rt.mtus[iface]; ok {
+		return mtu
+	}
+	return DefaultMTU
+}
+
+// PathMTU returns the largest datagram to send to dst out of device or,
+// if device is empty, wherever the routing table sends it: the MTU of
+// the route to dst if it has one, and otherwise that of the route's
+// interface. Without a route it is DefaultMTU.
+func (rt *RoutingTable) PathMTU(dst common.IPv4Address, device string) int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	route, _, err := rt.lookupFlow(FlowKey{Destination: dst}, device)
+	switch {
+	case err != nil:
+		return DefaultMTU
+	case route.MTU > 0:
+		return route.MTU
+	default:
+		return rt.interfaceMTU(route.Interface)
+	}
+}
+
+// FragmentPath fragments a packet to fit the path MTU to its destination
+// through the routing table, out of device if it is not empty.
+func (f *Fragmenter) FragmentPath(pkt *Packet, rt *RoutingTable, device string) ([]*Packet, error) {
+	return f.Fragment(pkt, rt.PathMTU(pkt.Destination, device))
+}
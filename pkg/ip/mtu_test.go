@@ -0,0 +1,73 @@
+package ip
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestRoutingTable_PathMTU(t *testing.T) {
+	rt := multihomedTable(t)
+	if err := rt.SetInterfaceMTU("eth1", 9000); err != nil {
+		t.Fatalf("SetInterfaceMTU() error = %v", err)
+	}
+	rt.AddRoute(&Route{Destination: common.IPv4Address{10, 9, 0, 0}, Netmask: common.IPv4Address{255, 255, 0, 0}, Interface: "eth1", MTU: 1400})
+
+	tests := []struct {
+		name   string
+		dst    common.IPv4Address
+		device string
+		want   int
+	}{
+		{"jumbo interface", common.IPv4Address{10, 1, 2, 3}, "", 9000},
+		{"route MTU", common.IPv4Address{10, 9, 0, 1}, "", 1400},
+		{"unset interface", common.IPv4Address{192, 168, 1, 50}, "", DefaultMTU},
+		{"default route", common.IPv4Address{8, 8, 8, 8}, "", DefaultMTU},
+		{"bound to device", common.IPv4Address{8, 8, 8, 8}, "eth1", 9000},
+		{"no route out of device", common.IPv4Address{8, 8, 8, 8}, "eth2", DefaultMTU},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rt.PathMTU(tt.dst, tt.device); got != tt.want {
+				t.Errorf("PathMTU(%s, %q) = %d, want %d", tt.dst, tt.device, got, tt.want)
+			}
+		})
+	}
+
+	// Forgetting the MTU, and MTUs out of range
+	rt.SetInterfaceMTU("eth1", 0)
+	if mtu := rt.InterfaceMTU("eth1"); mtu != DefaultMTU {
+		t.Errorf("InterfaceMTU() after clearing = %d, want %d", mtu, DefaultMTU)
+	}
+	if err := rt.SetInterfaceMTU("eth1", MinMTU-1); err == nil {
+		t.Error("SetInterfaceMTU() below MinMTU error = nil, want an error")
+	}
+	rt.SetInterfaceMTU("lo", 65536)
+	if mtu := rt.InterfaceMTU("lo"); mtu != 65535 {
+		t.Errorf("InterfaceMTU() = %d, want 65535", mtu)
+	}
+}
+
+func TestFragmenter_FragmentPath(t *testing.T) {
+	rt := multihomedTable(t)
+	rt.SetInterfaceMTU("eth1", 9000)
+	f := NewFragmenter()
+
+	pkt := NewPacket(common.IPv4Address{10, 0, 0, 5}, common.IPv4Address{10, 1, 2, 3}, common.ProtocolUDP, make([]byte, 8000))
+	fragments, err := f.FragmentPath(pkt, rt, "")
+	if err != nil {
+		t.Fatalf("FragmentPath() error = %v", err)
+	}
+	if len(fragments) != 1 {
+		t.Errorf("FragmentPath() over a jumbo link = %d fragments, want 1", len(fragments))
+	}
+
+	pkt = NewPacket(common.IPv4Address{192, 168, 1, 10}, common.IPv4Address{192, 168, 1, 50}, common.ProtocolUDP, make([]byte, 8000))
+	fragments, err = f.FragmentPath(pkt, rt, "")
+	if err != nil {
+		t.Fatalf("FragmentPath() error = %v", err)
+	}
+	if len(fragments) != 6 {
+		t.Errorf("FragmentPath() over Ethernet = %d fragments, want 6", len(fragments))
+	}
+}
@@ -0,0 +1,198 @@
+package ip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// NextHop is one path of a multipath route.
+type NextHop struct {
+	Gateway   common.IPv4Address // Next hop gateway (0.0.0.0 for direct)
+	Interface string             // Network interface name
+	Weight    int                // Share of flows relative to the other paths; 0 counts as 1
+}
+
+// NextHopGroup holds the paths of an equal-cost multipath (ECMP) route and
+// whether each is healthy.
+//
+// Flows are spread over the healthy paths by weighted rendezvous hashing on
+// their 5-tuple, so a flow sticks to one path, and a path going down or
+// coming back only moves the flows that were or will be on it.
+type NextHopGroup struct {
+	hops    []NextHop
+	paths   []*Route // Each path as a single-path route, as lookups return it
+	seeds   []uint32 // Per-path hash seeds, from the path's gateway and interface
+	down    []atomic.Bool
+	healthy atomic.Int32
+}
+
+// NewMultipathRoute creates a route to a network over several paths. Its
+// Gateway and Interface are those of the first path.
+func NewMultipathRoute(destination, netmask common.IPv4Address, metric int, hops ...NextHop) (*Route, error) {
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("multipath route has no next hops")
+	}
+
+	g := &NextHopGroup{
+		hops:  append([]NextHop(nil), hops...),
+		paths: make([]*Route, len(hops)),
+		seeds: make([]uint32, len(hops)),
+		down:  make([]atomic.Bool, len(hops)),
+	}
+	for i, hop := range hops {
+		if hop.Weight < 0 {
+			return nil, fmt.Errorf("invalid weight for next hop %s: %d", hop.Gateway, hop.Weight)
+		}
+		if hop.Weight == 0 {
+			g.hops[i].Weight = 1
+		}
+		g.paths[i] = &Route{
+			Destination: destination,
+			Netmask:     netmask,
+			Gateway:     hop.Gateway,
+			Interface:   hop.Interface,
+			Metric:      metric,
+		}
+		h := fnv.New32a()
+		h.Write(hop.Gateway[:])
+		h.Write([]byte(hop.Interface))
+		g.seeds[i] = h.Sum32()
+	}
+	g.healthy.Store(int32(len(hops)))
+
+	return &Route{
+		Destination: destination,
+		Netmask:     netmask,
+		Gateway:     hops[0].Gateway,
+		Interface:   hops[0].Interface,
+		Metric:      metric,
+		NextHops:    g,
+	}, nil
+}
+
+// NextHops returns the group's paths.
+func (g *NextHopGroup) NextHops() []NextHop {
+	return append([]NextHop(nil), g.hops...)
+}
+
+// SetHealthy marks path i up or down. Flows are not sent down paths that
+// are down.
+func (g *NextHopGroup) SetHealthy(i int, healthy bool) error {
+	if i < 0 || i >= len(g.hops) {
+		return fmt.Errorf("next hop %d out of range (%d next hops)", i, len(g.hops))
+	}
+	if g.down[i].Swap(!healthy) == !healthy {
+		return nil
+	}
+	if healthy {
+		g.healthy.Add(1)
+	} else {
+		g.healthy.Add(-1)
+	}
+	return nil
+}
+
+// Healthy reports whether path i is up.
+func (g *NextHopGroup) Healthy(i int) bool {
+	return i >= 0 && i < len(g.hops) && !g.down[i].Load()
+}
+
+// Select returns the index of the path for a flow hash, or false if every
+// path is down.
+func (g *NextHopGroup) Select(hash uint32) (int, bool) {
+	if g.healthy.Load() <= 0 {
+		return 0, false
+	}
+
+	// Weighted rendezvous hashing: the path with the highest
+	// -weight/ln(u) wins, where u is the flow's uniform hash for the path
+	best, bestScore := -1, 0.0
+	for i := range g.hops {
+		if g.down[i].Load() {
+			continue
+		}
+		u := (float64(mix32(hash^g.seeds[i])) + 0.5) / (1 << 32)
+		score := -float64(g.hops[i].Weight) / math.Log(u)
+		if best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best, best >= 0
+}
+
+// Path returns the route a flow with the given hash takes: the route itself
+// if it has a single path, or the single-path route of the path selected,
+// or nil if every path is down.
+func (r *Route) Path(hash uint32) *Route {
+	if r.NextHops == nil {
+		return r
+	}
+	i, ok := r.NextHops.Select(hash)
+	if !ok {
+		return nil
+	}
+	return r.NextHops.paths[i]
+}
+
+// setDSCP gives the group's paths the default DSCP of their route, which
+// Path returns in its place.
+func (g *NextHopGroup) setDSCP(dscp uint8) {
+	for _, path := range g.paths {
+		path.DSCP = dscp
+	}
+}
+
+// FlowKey is the 5-tuple multipath routes hash flows on.
+type FlowKey struct {
+	Source          common.IPv4Address
+	Destination     common.IPv4Address
+	Protocol        common.Protocol
+	SourcePort      uint16
+	DestinationPort uint16
+}
+
+// PacketFlowKey returns the flow key of a packet. The ports of TCP and UDP
+// packets are included unless the packet is a fragment, so every fragment
+// of a datagram takes the same path.
+func PacketFlowKey(p *Packet) FlowKey {
+	key := FlowKey{Source: p.Source, Destination: p.Destination, Protocol: p.Protocol}
+	fragment := p.FragmentOffset != 0 || p.Flags&FlagMoreFragments != 0
+	if (p.Protocol == common.ProtocolTCP || p.Protocol == common.ProtocolUDP) && !fragment && len(p.Payload) >= 4 {
+		key.SourcePort = binary.BigEndian.Uint16(p.Payload[0:2])
+		key.DestinationPort = binary.BigEndian.Uint16(p.Payload[2:4])
+	}
+	return key
+}
+
+// Hash returns the flow's hash.
+func (k FlowKey) Hash() uint32 {
+	var b [13]byte
+	copy(b[0:4], k.Source[:])
+	copy(b[4:8], k.Destination[:])
+	b[8] = byte(k.Protocol)
+	binary.BigEndian.PutUint16(b[9:11], k.SourcePort)
+	binary.BigEndian.PutUint16(b[11:13], k.DestinationPort)
+
+	// FNV-1a, inline so lookups do not allocate
+	h := uint32(2166136261)
+	for _, c := range b {
+		h = (h ^ uint32(c)) * 16777619
+	}
+	return h
+}
+
+// mix32 is the MurmurHash3 finalizer, which spreads every input bit over
+// the output.
+func mix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,208 @@
+package ip
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// flowKey returns the key of the TCP flow from 10.0.0.1 with a source port.
+func flowKey(port int) FlowKey {
+	return FlowKey{
+		Source:          common.IPv4Address{10, 0, 0, 1},
+		Destination:     common.IPv4Address{8, 8, 8, 8},
+		Protocol:        common.ProtocolTCP,
+		SourcePort:      uint16(port),
+		DestinationPort: 443,
+	}
+}
+
+// newTestMultipathTable returns a table with a default route over eth0,
+// eth1 and eth2 with the given weights.
+func newTestMultipathTable(t *testing.T, weights ...int) (*RoutingTable, *Route) {
+	t.Helper()
+	var hops []NextHop
+	for i, weight := range weights {
+		hops = append(hops, NextHop{
+			Gateway:   common.IPv4Address{192, 168, byte(i), 1},
+			Interface: "eth" + string(rune('0'+i)),
+			Weight:    weight,
+		})
+	}
+	route, err := NewMultipathRoute(common.IPv4Address{}, common.IPv4Address{}, 0, hops...)
+	if err != nil {
+		t.Fatalf("NewMultipathRoute() error = %v", err)
+	}
+	rt := NewRoutingTable()
+	rt.AddRoute(route)
+	return rt, route
+}
+
+// spread looks up n flows and returns how many took each interface, and
+// the interface of each flow.
+func spread(t *testing.T, rt *RoutingTable, n int) (map[string]int, []string) {
+	t.Helper()
+	counts := make(map[string]int)
+	paths := make([]string, n)
+	for i := 0; i < n; i++ {
+		route, nextHop, err := rt.LookupFlow(flowKey(i))
+		if err != nil {
+			t.Fatalf("LookupFlow() error = %v", err)
+		}
+		if nextHop != route.Gateway || route.NextHops != nil {
+			t.Fatalf("LookupFlow() = %+v, %s, want a single path and its gateway", route, nextHop)
+		}
+		counts[route.Interface]++
+		paths[i] = route.Interface
+	}
+	return counts, paths
+}
+
+func TestRoutingTable_LookupFlow(t *testing.T) {
+	const flows = 10000
+
+	tests := []struct {
+		name    string
+		weights []int
+		want    map[string]int // Expected share of flows in percent
+	}{
+		{"equal cost", []int{0, 0}, map[string]int{"eth0": 50, "eth1": 50}},
+		{"weighted", []int{1, 3}, map[string]int{"eth0": 25, "eth1": 75}},
+		{"three paths", []int{2, 1, 1}, map[string]int{"eth0": 50, "eth1": 25, "eth2": 25}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, _ := newTestMultipathTable(t, tt.weights...)
+			counts, _ := spread(t, rt, flows)
+			for iface, share := range tt.want {
+				got := counts[iface] * 100 / flows
+				if got < share-3 || got > share+3 {
+					t.Errorf("%s carried %d%% of flows, want %d%%", iface, got, share)
+				}
+			}
+		})
+	}
+}
+
+func TestRoutingTable_LookupFlowHealth(t *testing.T) {
+	const flows = 1000
+	rt, route := newTestMultipathTable(t, 1, 1, 1)
+	_, before := spread(t, rt, flows)
+
+	// Flows stick to their path, and a path going down only moves its own
+	_, again := spread(t, rt, flows)
+	if err := route.NextHops.SetHealthy(1, false); err != nil {
+		t.Fatalf("SetHealthy() error = %v", err)
+	}
+	counts, after := spread(t, rt, flows)
+	if counts["eth1"] != 0 {
+		t.Errorf("%d flows took eth1 while it was down", counts["eth1"])
+	}
+	for i := range before {
+		if again[i] != before[i] {
+			t.Fatalf("flow %d moved from %s to %s", i, before[i], again[i])
+		}
+		if before[i] != "eth1" && after[i] != before[i] {
+			t.Errorf("flow %d on %s moved to %s when eth1 went down", i, before[i], after[i])
+		}
+	}
+
+	// Coming back restores every flow's path
+	route.NextHops.SetHealthy(1, true)
+	if _, restored := spread(t, rt, flows); !equalPaths(restored, before) {
+		t.Error("flows did not return to their paths when eth1 came back")
+	}
+
+	// A route with every path down is passed over for a less specific one
+	for i := 0; i < 3; i++ {
+		route.NextHops.SetHealthy(i, false)
+	}
+	if _, _, err := rt.LookupFlow(flowKey(1)); err == nil {
+		t.Error("LookupFlow() with every path down error = nil, want an error")
+	}
+	specific, _ := NewMultipathRoute(common.IPv4Address{8, 8, 0, 0}, common.IPv4Address{255, 255, 0, 0}, 0, NextHop{Interface: "eth3"})
+	specific.NextHops.SetHealthy(0, false)
+	route.NextHops.SetHealthy(0, true)
+	rt.AddRoute(specific)
+	if got, _, err := rt.LookupFlow(flowKey(1)); err != nil || got.Interface != "eth0" {
+		t.Errorf("LookupFlow() = %v, %v, want the default route over eth0", got, err)
+	}
+
+	if err := route.NextHops.SetHealthy(3, true); err == nil {
+		t.Error("SetHealthy() of a missing next hop error = nil, want an error")
+	}
+}
+
+// equalPaths reports whether two flow assignments are the same.
+func equalPaths(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+func TestRoutingTable_LookupFlowDSCP(t *testing.T) {
+	route, err := NewMultipathRoute(common.IPv4Address{}, common.IPv4Address{}, 0,
+		NextHop{Gateway: common.IPv4Address{192, 168, 0, 1}, Interface: "eth0"},
+		NextHop{Gateway: common.IPv4Address{192, 168, 1, 1}, Interface: "eth1"})
+	if err != nil {
+		t.Fatalf("NewMultipathRoute() error = %v", err)
+	}
+	route.DSCP = 26
+	rt := NewRoutingTable()
+	rt.AddRoute(route)
+
+	// Every path carries the default DSCP of the route
+	for i := 0; i < 10; i++ {
+		path, _, err := rt.LookupFlow(flowKey(i))
+		if err != nil {
+			t.Fatalf("LookupFlow() error = %v", err)
+		}
+		if path.DSCP != 26 {
+			t.Errorf("path %s DSCP = %d, want 26", path.Interface, path.DSCP)
+		}
+	}
+}
+
+func TestNewMultipathRoute(t *testing.T) {
+	if _, err := NewMultipathRoute(common.IPv4Address{}, common.IPv4Address{}, 0); err == nil {
+		t.Error("NewMultipathRoute() without next hops error = nil, want an error")
+	}
+	if _, err := NewMultipathRoute(common.IPv4Address{}, common.IPv4Address{}, 0, NextHop{Interface: "eth0", Weight: -1}); err == nil {
+		t.Error("NewMultipathRoute() with a negative weight error = nil, want an error")
+	}
+
+	route, err := NewMultipathRoute(common.IPv4Address{10, 0, 0, 0}, common.IPv4Address{255, 0, 0, 0}, 5,
+		NextHop{Gateway: common.IPv4Address{192, 168, 0, 1}, Interface: "eth0"},
+		NextHop{Gateway: common.IPv4Address{192, 168, 1, 1}, Interface: "eth1", Weight: 2})
+	if err != nil {
+		t.Fatalf("NewMultipathRoute() error = %v", err)
+	}
+	if route.Gateway != (common.IPv4Address{192, 168, 0, 1}) || route.Interface != "eth0" || route.Metric != 5 {
+		t.Errorf("NewMultipathRoute() = %+v, want the first path's gateway and interface", route)
+	}
+	hops := route.NextHops.NextHops()
+	if len(hops) != 2 || hops[0].Weight != 1 || hops[1].Weight != 2 {
+		t.Errorf("NextHops() = %+v, want weights 1 and 2", hops)
+	}
+}
+
+func TestPacketFlowKey(t *testing.T) {
+	payload := []byte{0x30, 0x39, 0x01, 0xbb, 0, 0, 0, 0}
+	p := NewPacket(common.IPv4Address{10, 0, 0, 1}, common.IPv4Address{8, 8, 8, 8}, common.ProtocolTCP, payload)
+
+	want := flowKey(12345)
+	if got := PacketFlowKey(p); got != want {
+		t.Errorf("PacketFlowKey() = %+v, want %+v", got, want)
+	}
+
+	// Fragments hash on the addresses and protocol only
+	p.Flags = FlagMoreFragments
+	want.SourcePort, want.DestinationPort = 0, 0
+	if got := PacketFlowKey(p); got != want {
+		t.Errorf("PacketFlowKey() of a fragment = %+v, want %+v", got, want)
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"fmt"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
 )
 
+// logger is the "ip" logging subsystem.
+var logger = logging.New("ip")
+
 const (
 	// IPv4Version is the version number for IPv4.
 	IPv4Version = 4
@@ -59,108 +63,194 @@ type Packet struct {
 
 	// Payload
 	Payload []byte // Packet payload
+
+	// headroom is the buffer from NewPacketInPlace, which ends with Payload
+	headroom []byte
 }
 
 // Parse parses an IPv4 packet from raw bytes.
+// Options and Payload alias data; Clone copies them to keep the packet
+// once data is reused.
 func Parse(data []byte) (*Packet, error) {
-	if len(data) < MinHeaderLength {
-		return nil, fmt.Errorf("packet too short: %d bytes (minimum %d)", len(data), MinHeaderLength)
+	pkt := &Packet{}
+	if err := pkt.Decode(data); err != nil {
+		return nil, err
 	}
+	return pkt, nil
+}
 
-	pkt := &Packet{}
+// Decode parses an IPv4 packet from raw bytes into p, as Parse does.
+// Options and Payload alias data, so decoding into a reused Packet does not
+// allocate.
+func (p *Packet) Decode(data []byte) error {
+	if len(data) < MinHeaderLength {
+		return fmt.Errorf("packet too short: %d bytes (minimum %d)", len(data), MinHeaderLength)
+	}
 
 	// Parse version and IHL (first byte)
 	versionIHL := data[0]
-	pkt.Version = versionIHL >> 4
-	pkt.IHL = versionIHL & 0x0F
+	p.Version = versionIHL >> 4
+	p.IHL = versionIHL & 0x0F
 
-	if pkt.Version != IPv4Version {
-		return nil, fmt.Errorf("invalid IP version: %d (expected %d)", pkt.Version, IPv4Version)
+	if p.Version != IPv4Version {
+		return fmt.Errorf("invalid IP version: %d (expected %d)", p.Version, IPv4Version)
 	}
 
-	if pkt.IHL < 5 {
-		return nil, fmt.Errorf("invalid IHL: %d (minimum 5)", pkt.IHL)
+	if p.IHL < 5 {
+		return fmt.Errorf("invalid IHL: %d (minimum 5)", p.IHL)
 	}
 
-	headerLength := int(pkt.IHL) * 4
+	headerLength := int(p.IHL) * 4
 	if len(data) < headerLength {
-		return nil, fmt.Errorf("packet too short for header: %d bytes (expected %d)", len(data), headerLength)
+		return fmt.Errorf("packet too short for header: %d bytes (expected %d)", len(data), headerLength)
 	}
 
 	// Parse DSCP and ECN (second byte)
 	dscpECN := data[1]
-	pkt.DSCP = dscpECN >> 2
-	pkt.ECN = dscpECN & 0x03
+	p.DSCP = dscpECN >> 2
+	p.ECN = dscpECN & 0x03
 
 	// Parse total length
-	pkt.TotalLength = binary.BigEndian.Uint16(data[2:4])
-	if int(pkt.TotalLength) > len(data) {
-		return nil, fmt.Errorf("total length mismatch: header says %d, got %d bytes", pkt.TotalLength, len(data))
+	p.TotalLength = binary.BigEndian.Uint16(data[2:4])
+	if int(p.TotalLength) > len(data) {
+		return fmt.Errorf("total length mismatch: header says %d, got %d bytes", p.TotalLength, len(data))
+	}
+	if int(p.TotalLength) < headerLength {
+		return fmt.Errorf("total length %d shorter than header length %d", p.TotalLength, headerLength)
 	}
 
 	// Parse identification
-	pkt.Identification = binary.BigEndian.Uint16(data[4:6])
+	p.Identification = binary.BigEndian.Uint16(data[4:6])
 
 	// Parse flags and fragment offset
 	flagsFragOffset := binary.BigEndian.Uint16(data[6:8])
-	pkt.Flags = IPv4Flags(flagsFragOffset >> 13)
-	pkt.FragmentOffset = flagsFragOffset & 0x1FFF
+	p.Flags = IPv4Flags(flagsFragOffset >> 13)
+	p.FragmentOffset = flagsFragOffset & 0x1FFF
 
 	// Parse TTL
-	pkt.TTL = data[8]
+	p.TTL = data[8]
 
 	// Parse protocol
-	pkt.Protocol = common.Protocol(data[9])
+	p.Protocol = common.Protocol(data[9])
 
 	// Parse checksum
-	pkt.Checksum = binary.BigEndian.Uint16(data[10:12])
+	p.Checksum = binary.BigEndian.Uint16(data[10:12])
 
 	// Parse source and destination addresses
-	copy(pkt.Source[:], data[12:16])
-	copy(pkt.Destination[:], data[16:20])
-
-	// Parse options if present
-	if pkt.IHL > 5 {
-		optionsLength := headerLength - MinHeaderLength
-		pkt.Options = make([]byte, optionsLength)
-		copy(pkt.Options, data[20:headerLength])
+	copy(p.Source[:], data[12:16])
+	copy(p.Destination[:], data[16:20])
+
+	// Parse options if present (capacity capped so appending to them
+	// cannot overwrite the payload)
+	p.Options = nil
+	if p.IHL > 5 {
+		p.Options = data[MinHeaderLength:headerLength:headerLength]
 	}
 
 	// Extract payload
-	pkt.Payload = data[headerLength:pkt.TotalLength]
+	p.Payload = data[headerLength:p.TotalLength]
+	p.headroom = nil
 
-	return pkt, nil
+	if logger.Enabled(logging.LevelTrace) {
+		logger.Packet("decoded", p)
+	}
+	return nil
+}
+
+// Clone returns a copy of the packet with Options and Payload of its own,
+// in one allocation, to keep a packet decoded from a buffer that is reused,
+// such as a received frame's.
+func (p *Packet) Clone() *Packet {
+	clone := *p
+	clone.headroom = nil
+	if len(p.Options)+len(p.Payload) > 0 {
+		buf := make([]byte, len(p.Options)+len(p.Payload))
+		n := copy(buf, p.Options)
+		copy(buf[n:], p.Payload)
+		if p.Options != nil {
+			clone.Options = buf[:n:n]
+		}
+		clone.Payload = buf[n:]
+	}
+	return &clone
 }
 
 // Serialize converts the packet to bytes.
+// For a packet from NewPacketInPlace the header is written in front of the
+// payload and the result aliases the packet's buffer.
 func (p *Packet) Serialize() ([]byte, error) {
-	// Calculate header length
-	headerLength := MinHeaderLength
-	if len(p.Options) > 0 {
-		// Options must be padded to 4-byte boundary
-		optionsLength := len(p.Options)
-		if optionsLength%4 != 0 {
-			optionsLength = (optionsLength/4 + 1) * 4
+	if p.inPlace() {
+		n, err := p.SerializeTo(p.headroom)
+		if err != nil {
+			return nil, err
 		}
-		headerLength += optionsLength
+		return p.headroom[:n], nil
 	}
 
-	if headerLength > MaxHeaderLength {
-		return nil, fmt.Errorf("header too long: %d bytes (maximum %d)", headerLength, MaxHeaderLength)
+	if _, err := p.headerLength(); err != nil {
+		return nil, err
 	}
 
-	// Update IHL
-	p.IHL = uint8(headerLength / 4)
+	// Allocate buffer
+	buf := make([]byte, p.Size())
+	n, err := p.SerializeTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Size returns the length of the serialized packet: the header, with options
+// padded to a 4-byte boundary, plus the payload.
+func (p *Packet) Size() int {
+	return MinHeaderLength + (len(p.Options)+3)/4*4 + len(p.Payload)
+}
+
+// inPlace reports whether the payload still directly follows room for an
+// option-less header in the buffer from NewPacketInPlace.
+func (p *Packet) inPlace() bool {
+	if len(p.Options) > 0 || len(p.headroom) < MinHeaderLength {
+		return false
+	}
+	payload := p.headroom[MinHeaderLength:]
+	if len(p.Payload) != len(payload) {
+		return false
+	}
+	return len(payload) == 0 || &p.Payload[0] == &payload[0]
+}
+
+// headerLength returns the length of the serialized header, checking that
+// the header and the packet fit their length fields.
+func (p *Packet) headerLength() (int, error) {
+	// Options must be padded to 4-byte boundary
+	headerLength := MinHeaderLength + (len(p.Options)+3)/4*4
+	if headerLength > MaxHeaderLength {
+		return 0, fmt.Errorf("header too long: %d bytes (maximum %d)", headerLength, MaxHeaderLength)
+	}
 
-	// Calculate total length
 	totalLength := headerLength + len(p.Payload)
 	if totalLength > MaxPacketSize {
-		return nil, fmt.Errorf("packet too large: %d bytes (maximum %d)", totalLength, MaxPacketSize)
+		return 0, fmt.Errorf("packet too large: %d bytes (maximum %d)", totalLength, MaxPacketSize)
 	}
-	p.TotalLength = uint16(totalLength)
+	return headerLength, nil
+}
 
-	// Allocate buffer
-	buf := make([]byte, totalLength)
+// SerializeTo writes the packet into b, as Serialize does, and returns the
+// number of bytes written (Size). It updates IHL, TotalLength and Checksum.
+func (p *Packet) SerializeTo(b []byte) (int, error) {
+	headerLength, err := p.headerLength()
+	if err != nil {
+		return 0, err
+	}
+	totalLength := headerLength + len(p.Payload)
+	if len(b) < totalLength {
+		return 0, fmt.Errorf("buffer too small for packet: %d bytes (need %d)", len(b), totalLength)
+	}
+	buf := b[:totalLength]
+
+	// Update IHL and total length
+	p.IHL = uint8(headerLength / 4)
+	p.TotalLength = uint16(totalLength)
 
 	// Set version and IHL
 	buf[0] = (p.Version << 4) | p.IHL
@@ -192,30 +282,28 @@ func (p *Packet) Serialize() ([]byte, error) {
 	copy(buf[12:16], p.Source[:])
 	copy(buf[16:20], p.Destination[:])
 
-	// Copy options if present
-	if len(p.Options) > 0 {
-		copy(buf[20:], p.Options)
-		// Pad with zeros if necessary
-		for i := 20 + len(p.Options); i < headerLength; i++ {
-			buf[i] = 0
-		}
-	}
+	// Copy options if present, padding with zeros if necessary
+	n := copy(buf[MinHeaderLength:headerLength], p.Options)
+	clear(buf[MinHeaderLength+n : headerLength])
 
 	// Calculate and set checksum
 	p.Checksum = common.CalculateChecksum(buf[:headerLength])
 	binary.BigEndian.PutUint16(buf[10:12], p.Checksum)
 
-	// Copy payload
-	copy(buf[headerLength:], p.Payload)
+	// Copy payload, unless it was serialized in place after the header
+	if len(p.Payload) > 0 && &buf[headerLength] != &p.Payload[0] {
+		copy(buf[headerLength:], p.Payload)
+	}
 
-	return buf, nil
+	return totalLength, nil
 }
 
 // VerifyChecksum verifies the IP header checksum.
 func (p *Packet) VerifyChecksum() bool {
 	// Reconstruct the header for checksum verification
 	headerLength := int(p.IHL) * 4
-	buf := make([]byte, headerLength)
+	var header [MaxHeaderLength]byte
+	buf := header[:headerLength]
 
 	buf[0] = (p.Version << 4) | p.IHL
 	buf[1] = (p.DSCP << 2) | p.ECN
@@ -233,16 +321,46 @@ func (p *Packet) VerifyChecksum() bool {
 	}
 
 	// Checksum should be 0 if correct
-	return common.CalculateChecksum(buf) == 0
+	if common.CalculateChecksum(buf) != 0 {
+		counters.checksumErrors.Add(1)
+		logger.Debug("header checksum mismatch", logging.F("hdr", p))
+		return false
+	}
+	return true
 }
 
 // DecrementTTL decrements the TTL and returns true if the packet is still alive.
+// It is the forwarding step, so it also counts forwarded and expired packets.
 func (p *Packet) DecrementTTL() bool {
+	if p.TTL > 0 {
+		p.TTL--
+	}
 	if p.TTL == 0 {
+		counters.ttlExceeded.Add(1)
+		logger.Debug("TTL expired", logging.F("hdr", p))
 		return false
 	}
-	p.TTL--
-	return p.TTL > 0
+	counters.forwarded.Add(1)
+	return true
+}
+
+// TOS returns the TOS octet: the DSCP and ECN fields together.
+func (p *Packet) TOS() uint8 {
+	return p.DSCP<<2 | p.ECN&0x3
+}
+
+// SetTOS sets the DSCP and ECN fields from a TOS octet, such as the TOS
+// field of a TCP segment or UDP datagram.
+func (p *Packet) SetTOS(tos uint8) {
+	p.DSCP, p.ECN = tos>>2, tos&0x3
+}
+
+// ApplyRouteDSCP sets the DSCP of a packet that has none to the default
+// DSCP of the route it is sent over, if the route has one.
+func (p *Packet) ApplyRouteDSCP(route *Route) {
+	if route != nil && p.DSCP == 0 {
+		p.DSCP = route.DSCP
+	}
 }
 
 // IsFragment returns true if this packet is a fragment.
@@ -276,3 +394,30 @@ func NewPacket(src, dst common.IPv4Address, protocol common.Protocol, payload []
 		Payload:        payload,
 	}
 }
+
+// NewPacketInPlace creates a new IPv4 packet whose payload is
+// buf[MinHeaderLength:], such as a transport segment serialized after room
+// for the IP header. Serialize then writes the header into that room rather
+// than copying the payload, as long as no options are added and the payload
+// is not replaced.
+func NewPacketInPlace(src, dst common.IPv4Address, protocol common.Protocol, buf []byte) *Packet {
+	pkt := new(Packet)
+	pkt.ResetInPlace(src, dst, protocol, buf)
+	return pkt
+}
+
+// ResetInPlace sets p up as NewPacketInPlace sets up a new packet, so that
+// a Packet of the caller's can carry one packet after another without
+// allocating.
+func (p *Packet) ResetInPlace(src, dst common.IPv4Address, protocol common.Protocol, buf []byte) {
+	*p = Packet{
+		Version:     IPv4Version,
+		IHL:         5,
+		TTL:         DefaultTTL,
+		Protocol:    protocol,
+		Source:      src,
+		Destination: dst,
+		Payload:     buf[MinHeaderLength:],
+		headroom:    buf,
+	}
+}
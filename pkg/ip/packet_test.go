@@ -55,6 +55,18 @@ func TestParse(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "total length shorter than header",
+			data: []byte{
+				0x46, 0x00, 0x00, 0x14, // IHL = 6, total length 20
+				0x12, 0x34, 0x40, 0x00,
+				0x40, 0x06, 0x00, 0x00,
+				0xc0, 0xa8, 0x01, 0x64,
+				0xc0, 0xa8, 0x01, 0x01,
+				0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -273,6 +285,159 @@ func TestPacket_WithOptions(t *testing.T) {
 	}
 }
 
+func TestPacket_DecodeAndSerializeTo(t *testing.T) {
+	srcIP, _ := common.ParseIPv4("192.168.1.100")
+	dstIP, _ := common.ParseIPv4("192.168.1.1")
+
+	pkt := NewPacket(srcIP, dstIP, common.ProtocolUDP, []byte("payload"))
+	pkt.Options = []byte{0x01, 0x01, 0x01} // Padded to 4 bytes
+	want, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	// SerializeTo writes the same bytes, padding included, into a dirty buffer
+	buf := bytes.Repeat([]byte{0xFF}, 100)
+	n, err := pkt.SerializeTo(buf)
+	if err != nil {
+		t.Fatalf("SerializeTo() error = %v", err)
+	}
+	if n != pkt.Size() || !bytes.Equal(buf[:n], want) {
+		t.Errorf("SerializeTo() = %x, want %x", buf[:n], want)
+	}
+	if _, err := pkt.SerializeTo(buf[:n-1]); err == nil {
+		t.Error("SerializeTo() into a short buffer succeeded")
+	}
+
+	// Decode reuses the packet and clears options from the previous one
+	var decoded Packet
+	if err := decoded.Decode(want); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !decoded.VerifyChecksum() || !bytes.Equal(decoded.Payload, pkt.Payload) {
+		t.Errorf("Decode() = %v", &decoded)
+	}
+	plain, _ := NewPacket(srcIP, dstIP, common.ProtocolUDP, nil).Serialize()
+	if err := decoded.Decode(plain); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if decoded.Options != nil {
+		t.Errorf("Options = %v, want nil", decoded.Options)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		decoded.Decode(want)
+		decoded.VerifyChecksum()
+		decoded.SerializeTo(buf)
+	})
+	if allocs != 0 {
+		t.Errorf("Decode()/VerifyChecksum()/SerializeTo() allocs = %v, want 0", allocs)
+	}
+
+	// A clone keeps the decoded packet once the input is reused
+	clone := decoded.Clone()
+	saved := want[len(want)-1]
+	want[len(want)-1] ^= 0xFF
+	if !bytes.Equal(clone.Payload, pkt.Payload) || !bytes.HasPrefix(clone.Options, pkt.Options) || !clone.VerifyChecksum() {
+		t.Errorf("Clone() = %v, changed with the input", clone)
+	}
+	want[len(want)-1] = saved
+}
+
+func TestNewPacketInPlace(t *testing.T) {
+	srcIP, _ := common.ParseIPv4("192.168.1.100")
+	dstIP, _ := common.ParseIPv4("192.168.1.1")
+	payload := []byte("segment")
+
+	want, err := NewPacket(srcIP, dstIP, common.ProtocolTCP, payload).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	// The header is written in front of the payload, in the same buffer
+	buf := make([]byte, MinHeaderLength+len(payload))
+	copy(buf[MinHeaderLength:], payload)
+	pkt := NewPacketInPlace(srcIP, dstIP, common.ProtocolTCP, buf)
+	var data []byte
+	allocs := testing.AllocsPerRun(10, func() {
+		data, err = pkt.Serialize()
+	})
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("Serialize() = %x, want %x", data, want)
+	}
+	if &data[0] != &buf[0] {
+		t.Error("Serialize() did not write into the packet's buffer")
+	}
+	if allocs != 0 {
+		t.Errorf("Serialize() allocs = %v, want 0", allocs)
+	}
+
+	// With options the header no longer fits, so the packet is copied
+	pkt.Options = []byte{0x01, 0x01, 0x01, 0x01}
+	data, err = pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if &data[0] == &buf[0] || !bytes.Equal(data[MinHeaderLength+4:], payload) {
+		t.Errorf("Serialize() with options = %x", data)
+	}
+}
+
+func TestPacket_TOS(t *testing.T) {
+	pkt := NewPacket(common.IPv4Address{10, 0, 0, 1}, common.IPv4Address{10, 0, 0, 2}, common.ProtocolUDP, nil)
+	pkt.SetTOS(0xb9) // EF, ECT(1)
+	if pkt.DSCP != 46 || pkt.ECN != 1 || pkt.TOS() != 0xb9 {
+		t.Errorf("SetTOS(0xb9) gave DSCP %d, ECN %d, TOS %#x, want 46, 1, 0xb9", pkt.DSCP, pkt.ECN, pkt.TOS())
+	}
+
+	tests := []struct {
+		name  string
+		dscp  uint8 // Set by the socket
+		route *Route
+		want  uint8
+	}{
+		{"route default", 0, &Route{DSCP: 10}, 10},
+		{"socket wins", 46, &Route{DSCP: 10}, 46},
+		{"route without default", 0, &Route{}, 0},
+		{"no route", 0, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt.DSCP = tt.dscp
+			pkt.ApplyRouteDSCP(tt.route)
+			if pkt.DSCP != tt.want {
+				t.Errorf("DSCP = %d, want %d", pkt.DSCP, tt.want)
+			}
+		})
+	}
+}
+
+func TestReleasePacket(t *testing.T) {
+	srcIP, _ := common.ParseIPv4("192.168.1.100")
+	dstIP, _ := common.ParseIPv4("192.168.1.1")
+	data, err := NewPacket(srcIP, dstIP, common.ProtocolTCP, []byte("test payload")).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	pkt := AcquirePacket()
+	if err := pkt.Decode(data); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	kept := pkt.Clone()
+	ReleasePacket(pkt)
+
+	if pkt.Source != (common.IPv4Address{}) || pkt.Payload != nil {
+		t.Errorf("released packet = %+v, want zeroed", pkt)
+	}
+	if kept.Source != srcIP || string(kept.Payload) != "test payload" {
+		t.Errorf("clone = %+v, want the packet decoded", kept)
+	}
+}
+
 func BenchmarkParse(b *testing.B) {
 	data := []byte{
 		0x45, 0x00, 0x00, 0x28,
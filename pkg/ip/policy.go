@@ -0,0 +1,244 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+const (
+	// MainTable is the name of the table a policy router is created with,
+	// like Linux's main table.
+	MainTable = "main"
+
+	// DefaultRulePriority is the priority of the rule that looks routes up
+	// in the main table, like Linux's "from all lookup main" rule.
+	DefaultRulePriority = 32766
+)
+
+// Errors returned by PolicyRouter.
+var (
+	ErrTableExists   = errors.New("routing table already exists")
+	ErrTableNotFound = errors.New("routing table not found")
+	ErrTableInUse    = errors.New("routing table is used by a rule")
+	ErrRuleNotFound  = errors.New("routing rule not found")
+)
+
+// Table is a routing table a policy router can look routes up in.
+// RoutingTable, TrieRoutingTable, OptimizedRoutingTable and
+// CachedRoutingTable are all Tables.
+type Table interface {
+	Lookup(dst common.IPv4Address) (*Route, common.IPv4Address, error)
+}
+
+// Flow describes a packet being routed, for matching against rules.
+type Flow struct {
+	Source      common.IPv4Address
+	Destination common.IPv4Address
+	TOS         uint8  // The packet's TOS byte (DSCP and ECN)
+	InInterface string // The interface the packet arrived on, or "" if sent locally
+}
+
+// PacketFlow returns the flow of a packet that arrived on inInterface, or
+// is being sent if inInterface is "".
+func PacketFlow(p *Packet, inInterface string) Flow {
+	return Flow{
+		Source:      p.Source,
+		Destination: p.Destination,
+		TOS:         p.DSCP<<2 | p.ECN&ecnMask,
+		InInterface: inInterface,
+	}
+}
+
+// Rule selects a routing table for the packets it matches, like a Linux
+// "ip rule". A rule's zero-valued fields match every packet.
+type Rule struct {
+	Priority int // Rules are tried lowest priority first
+
+	// Source addresses the rule matches. A zero SourceMask matches all.
+	Source     common.IPv4Address
+	SourceMask common.IPv4Address
+
+	// DSCP the rule matches, in the upper six bits as in the TOS byte; the
+	// ECN bits are ignored. 0 matches all.
+	TOS uint8
+
+	// Interface the packet arrived on. "" matches all.
+	InInterface string
+
+	// Table is the name of the table to look routes up in.
+	Table string
+}
+
+// Matches reports whether the rule matches a flow.
+func (r Rule) Matches(flow Flow) bool {
+	for i := 0; i < 4; i++ {
+		if flow.Source[i]&r.SourceMask[i] != r.Source[i]&r.SourceMask[i] {
+			return false
+		}
+	}
+	if r.TOS != 0 && flow.TOS&^ecnMask != r.TOS&^ecnMask {
+		return false
+	}
+	return r.InInterface == "" || r.InInterface == flow.InInterface
+}
+
+// String returns a string representation of the rule, as "ip rule" prints.
+func (r Rule) String() string {
+	s := fmt.Sprintf("%d: from ", r.Priority)
+	if r.SourceMask == (common.IPv4Address{}) {
+		s += "all"
+	} else {
+		s += fmt.Sprintf("%s/%d", r.Source, countPrefix(r.SourceMask))
+	}
+	if r.TOS != 0 {
+		s += fmt.Sprintf(" tos 0x%02x", r.TOS)
+	}
+	if r.InInterface != "" {
+		s += " iif " + r.InInterface
+	}
+	return s + " lookup " + r.Table
+}
+
+// ecnMask is the ECN bits of the TOS byte.
+const ecnMask = 0x03
+
+// PolicyRouter looks routes up in one of several named routing tables,
+// chosen by rules, so that packets can be routed by their source address,
+// DSCP or ingress interface as well as their destination.
+//
+// A lookup tries the rules in priority order. The first rule that matches
+// and whose table has a route to the destination decides the route, so a
+// rule whose table has no route falls through to the next, as on Linux.
+type PolicyRouter struct {
+	mu     sync.RWMutex
+	tables map[string]Table
+	rules  []Rule // Sorted by priority
+}
+
+// NewPolicyRouter creates a policy router with one table, MainTable, and
+// one rule looking every route up in it at DefaultRulePriority, so it
+// routes as main alone would until tables and rules are added.
+func NewPolicyRouter(main Table) *PolicyRouter {
+	return &PolicyRouter{
+		tables: map[string]Table{MainTable: main},
+		rules:  []Rule{{Priority: DefaultRulePriority, Table: MainTable}},
+	}
+}
+
+// AddTable adds a named routing table.
+func (pr *PolicyRouter) AddTable(name string, table Table) error {
+	if table == nil {
+		return fmt.Errorf("table is nil")
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if _, exists := pr.tables[name]; exists {
+		return fmt.Errorf("%w: %s", ErrTableExists, name)
+	}
+	pr.tables[name] = table
+	return nil
+}
+
+// RemoveTable removes a named routing table. A table rules use cannot be
+// removed.
+func (pr *PolicyRouter) RemoveTable(name string) error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if _, exists := pr.tables[name]; !exists {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+	for _, rule := range pr.rules {
+		if rule.Table == name {
+			return fmt.Errorf("%w: %s (%s)", ErrTableInUse, name, rule)
+		}
+	}
+	delete(pr.tables, name)
+	return nil
+}
+
+// Table returns a named routing table.
+func (pr *PolicyRouter) Table(name string) (Table, bool) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	table, exists := pr.tables[name]
+	return table, exists
+}
+
+// AddRule adds a rule. Rules of equal priority are tried in the order they
+// were added.
+func (pr *PolicyRouter) AddRule(rule Rule) error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if _, exists := pr.tables[rule.Table]; !exists {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, rule.Table)
+	}
+	i := sort.Search(len(pr.rules), func(i int) bool { return pr.rules[i].Priority > rule.Priority })
+	pr.rules = append(pr.rules, Rule{})
+	copy(pr.rules[i+1:], pr.rules[i:])
+	pr.rules[i] = rule
+	return nil
+}
+
+// RemoveRule removes the first rule equal to rule.
+func (pr *PolicyRouter) RemoveRule(rule Rule) error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	for i, r := range pr.rules {
+		if r == rule {
+			pr.rules = append(pr.rules[:i], pr.rules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrRuleNotFound, rule)
+}
+
+// Rules returns the rules in the order they are tried.
+func (pr *PolicyRouter) Rules() []Rule {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	rules := make([]Rule, len(pr.rules))
+	copy(rules, pr.rules)
+	return rules
+}
+
+// Lookup finds the route for a flow, and returns it, its next hop and the
+// name of the table it was found in.
+func (pr *PolicyRouter) Lookup(flow Flow) (*Route, common.IPv4Address, string, error) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	for _, rule := range pr.rules {
+		if !rule.Matches(flow) {
+			continue
+		}
+		route, nextHop, err := pr.tables[rule.Table].Lookup(flow.Destination)
+		if errors.Is(err, ErrNoRoute) {
+			continue
+		}
+		if err != nil {
+			return nil, common.IPv4Address{}, "", fmt.Errorf("table %s: %w", rule.Table, err)
+		}
+		return route, nextHop, rule.Table, nil
+	}
+	return nil, common.IPv4Address{}, "", fmt.Errorf("%w: %s", ErrNoRoute, flow.Destination)
+}
+
+// countPrefix returns the prefix length of a netmask.
+func countPrefix(netmask common.IPv4Address) int {
+	count := 0
+	for _, b := range netmask {
+		count += bits.OnesCount8(b)
+	}
+	return count
+}
@@ -0,0 +1,142 @@
+package ip
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// newTestPolicyRouter returns a policy router sending traffic out of eth0
+// by default, and traffic from 10.1.0.0/16 out of eth1 by the "isp2"
+// table.
+func newTestPolicyRouter(t *testing.T) *PolicyRouter {
+	t.Helper()
+	main := NewRoutingTable()
+	main.SetDefaultGateway(common.IPv4Address{192, 168, 1, 1}, "eth0")
+	main.AddRoute(&Route{Destination: common.IPv4Address{10, 1, 0, 0}, Netmask: common.IPv4Address{255, 255, 0, 0}, Interface: "eth2"})
+
+	isp2 := NewTrieRoutingTable()
+	isp2.AddRoute(&Route{Destination: common.IPv4Address{0, 0, 0, 0}, Netmask: common.IPv4Address{0, 0, 0, 0}, Gateway: common.IPv4Address{172, 16, 0, 1}, Interface: "eth1"})
+
+	pr := NewPolicyRouter(main)
+	if err := pr.AddTable("isp2", isp2); err != nil {
+		t.Fatalf("AddTable() error = %v", err)
+	}
+	if err := pr.AddRule(Rule{Priority: 100, Source: common.IPv4Address{10, 1, 0, 0}, SourceMask: common.IPv4Address{255, 255, 0, 0}, Table: "isp2"}); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+	return pr
+}
+
+func TestPolicyRouter_Lookup(t *testing.T) {
+	pr := newTestPolicyRouter(t)
+
+	// Voice traffic (EF) from anywhere, and traffic arriving on the guest
+	// network, go out of eth1 too; the guest rule is tried first
+	if err := pr.AddTable("guest", NewRoutingTable()); err != nil {
+		t.Fatalf("AddTable() error = %v", err)
+	}
+	for _, rule := range []Rule{
+		{Priority: 200, TOS: 46 << 2, Table: "isp2"},
+		{Priority: 50, InInterface: "guest0", Table: "guest"},
+	} {
+		if err := pr.AddRule(rule); err != nil {
+			t.Fatalf("AddRule() error = %v", err)
+		}
+	}
+
+	remote := common.IPv4Address{8, 8, 8, 8}
+	tests := []struct {
+		name          string
+		flow          Flow
+		wantInterface string
+		wantTable     string
+	}{
+		{"default", Flow{Source: common.IPv4Address{192, 168, 1, 10}, Destination: remote}, "eth0", MainTable},
+		{"source", Flow{Source: common.IPv4Address{10, 1, 2, 3}, Destination: remote}, "eth1", "isp2"},
+		{"DSCP", Flow{Source: common.IPv4Address{192, 168, 1, 10}, Destination: remote, TOS: 46<<2 | 1}, "eth1", "isp2"},
+		{"other DSCP", Flow{Source: common.IPv4Address{192, 168, 1, 10}, Destination: remote, TOS: 10 << 2}, "eth0", MainTable},
+		{"empty table falls through", Flow{Source: common.IPv4Address{10, 1, 2, 3}, Destination: remote, InInterface: "guest0"}, "eth1", "isp2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, _, table, err := pr.Lookup(tt.flow)
+			if err != nil {
+				t.Fatalf("Lookup() error = %v", err)
+			}
+			if route.Interface != tt.wantInterface {
+				t.Errorf("Interface = %s, want %s", route.Interface, tt.wantInterface)
+			}
+			if table != tt.wantTable {
+				t.Errorf("table = %s, want %s", table, tt.wantTable)
+			}
+		})
+	}
+}
+
+func TestPolicyRouter_NoRoute(t *testing.T) {
+	pr := NewPolicyRouter(NewRoutingTable())
+	_, _, _, err := pr.Lookup(Flow{Destination: common.IPv4Address{8, 8, 8, 8}})
+	if !errors.Is(err, ErrNoRoute) {
+		t.Errorf("Lookup() error = %v, want %v", err, ErrNoRoute)
+	}
+}
+
+func TestPolicyRouter_Rules(t *testing.T) {
+	pr := newTestPolicyRouter(t)
+
+	if err := pr.AddRule(Rule{Priority: 100, InInterface: "eth3", Table: MainTable}); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+	if err := pr.AddRule(Rule{Priority: 10, Table: "missing"}); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("AddRule() error = %v, want %v", err, ErrTableNotFound)
+	}
+
+	want := []string{
+		"100: from 10.1.0.0/16 lookup isp2",
+		"100: from all iif eth3 lookup main",
+		"32766: from all lookup main",
+	}
+	rules := pr.Rules()
+	if len(rules) != len(want) {
+		t.Fatalf("Rules() = %v, want %d rules", rules, len(want))
+	}
+	for i := range want {
+		if got := rules[i].String(); got != want[i] {
+			t.Errorf("rule %d = %q, want %q", i, got, want[i])
+		}
+	}
+
+	// A table in use cannot be removed until its rules are
+	if err := pr.RemoveTable("isp2"); !errors.Is(err, ErrTableInUse) {
+		t.Errorf("RemoveTable() error = %v, want %v", err, ErrTableInUse)
+	}
+	if err := pr.RemoveRule(rules[0]); err != nil {
+		t.Fatalf("RemoveRule() error = %v", err)
+	}
+	if err := pr.RemoveRule(rules[0]); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("RemoveRule() twice error = %v, want %v", err, ErrRuleNotFound)
+	}
+	if err := pr.RemoveTable("isp2"); err != nil {
+		t.Errorf("RemoveTable() error = %v", err)
+	}
+	if _, exists := pr.Table("isp2"); exists {
+		t.Error("Table() found a removed table")
+	}
+	if err := pr.AddTable(MainTable, NewRoutingTable()); !errors.Is(err, ErrTableExists) {
+		t.Errorf("AddTable() error = %v, want %v", err, ErrTableExists)
+	}
+}
+
+func TestPacketFlow(t *testing.T) {
+	p := NewPacket(common.IPv4Address{10, 0, 0, 1}, common.IPv4Address{10, 0, 0, 2}, common.ProtocolUDP, nil)
+	p.DSCP = 46
+	p.ECN = 1
+
+	want := Flow{Source: p.Source, Destination: p.Destination, TOS: 0xb9, InInterface: "eth0"}
+	if got := PacketFlow(p, "eth0"); got != want {
+		t.Errorf("PacketFlow() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,26 @@
+package ip
+
+import "sync"
+
+// packetPool holds released packets, zeroed. The stack decodes the
+// packets it receives into packets from it, and wraps the segments it
+// sends in them, so that at high rates the headers are not left to the
+// garbage collector.
+var packetPool = sync.Pool{
+	New: func() any { return new(Packet) },
+}
+
+// AcquirePacket returns a zeroed packet from the stack's freelist, to
+// Decode into or set up with ResetInPlace, and to give back with
+// ReleasePacket once it is delivered.
+func AcquirePacket() *Packet {
+	return packetPool.Get().(*Packet)
+}
+
+// ReleasePacket returns a packet from AcquirePacket to the freelist. It is
+// zeroed, so that anything still holding it sees an empty packet rather
+// than the next one's: a packet to keep past delivery must be Cloned.
+func ReleasePacket(p *Packet) {
+	*p = Packet{}
+	packetPool.Put(p)
+}
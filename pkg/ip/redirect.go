@@ -0,0 +1,207 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+)
+
+// DefaultRedirectLifetime is how long an accepted redirect changes the
+// next hop, if not configured.
+const DefaultRedirectLifetime = 10 * time.Minute
+
+// Errors returned by HandleRedirect.
+var (
+	ErrRedirectsDisabled = errors.New("ICMP redirects not accepted")
+	ErrInvalidRedirect   = errors.New("invalid ICMP redirect")
+)
+
+// RedirectConfig is the policy a RoutingTable applies to the ICMP
+// Redirects a host receives. The zero value rejects them all: anyone on
+// the link who forges the gateway's address could otherwise divert the
+// host's traffic.
+type RedirectConfig struct {
+	// Accept enables redirects.
+	Accept bool
+
+	// Secure only accepts redirects to the gateway of a route in the
+	// table, like Linux's secure_redirects.
+	Secure bool
+
+	Lifetime time.Duration // 0 means DefaultRedirectLifetime
+}
+
+// redirect is an accepted redirect: the next hop to use for a destination
+// until it expires.
+type redirect struct {
+	gateway common.IPv4Address
+	expires time.Time
+}
+
+// SetRedirectConfig sets the policy for ICMP Redirects. Redirects already
+// accepted are kept.
+func (rt *RoutingTable) SetRedirectConfig(config RedirectConfig) {
+	if config.Lifetime == 0 {
+		config.Lifetime = DefaultRedirectLifetime
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.redirectConfig = config
+}
+
+// HandleRedirect applies an ICMP Redirect received from a gateway, if the
+// policy accepts redirects: lookups of the destination of the datagram it
+// quotes return the new gateway as the next hop, until the redirect
+// expires or the routes change. Network redirects are applied to the host
+// alone (RFC 1812 Section 5.2.7.2).
+//
+// The redirect must come from the current next hop towards the
+// destination, and name a gateway on a network directly connected by the
+// same interface (RFC 1122 Section 3.2.2.2).
+func (rt *RoutingTable) HandleRedirect(msg *icmp.Message, from common.IPv4Address) error {
+	err := rt.handleRedirect(msg, from)
+	if err != nil {
+		counters.redirectsRejected.Add(1)
+		return err
+	}
+	counters.redirectsAccepted.Add(1)
+	return nil
+}
+
+func (rt *RoutingTable) handleRedirect(msg *icmp.Message, from common.IPv4Address) error {
+	rt.mu.RLock()
+	config := rt.redirectConfig
+	rt.mu.RUnlock()
+	if !config.Accept {
+		return ErrRedirectsDisabled
+	}
+	if msg.Type != icmp.TypeRedirect || msg.Code > icmp.CodeRedirectTOSHost {
+		return fmt.Errorf("%w: %s message with code %d", ErrInvalidRedirect, msg.Type, msg.Code)
+	}
+	orig, err := msg.Original()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+	}
+	dst, gateway := orig.Destination, msg.Gateway()
+	if !rt.IsLocalAddress(orig.Source) {
+		return fmt.Errorf("%w: quotes a datagram from %s", ErrInvalidRedirect, orig.Source)
+	}
+
+	route, nextHop, err := rt.Lookup(dst)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+	}
+	if nextHop != from || nextHop == dst {
+		return fmt.Errorf("%w: from %s, not the gateway to %s", ErrInvalidRedirect, from, dst)
+	}
+	if gateway == (common.IPv4Address{}) || gateway == from || rt.IsLocalAddress(gateway) {
+		return fmt.Errorf("%w: gateway %s", ErrInvalidRedirect, gateway)
+	}
+	onLink, _, err := rt.Lookup(gateway)
+	if err != nil || onLink.Gateway != (common.IPv4Address{}) || onLink.Interface != route.Interface {
+		return fmt.Errorf("%w: gateway %s not on the network of %s", ErrInvalidRedirect, gateway, route.Interface)
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if config.Secure && !rt.isGateway(gateway) {
+		return fmt.Errorf("%w: gateway %s not in the routing table", ErrInvalidRedirect, gateway)
+	}
+	now := rt.now()
+	for d, r := range rt.redirects {
+		if !now.Before(r.expires) {
+			delete(rt.redirects, d)
+		}
+	}
+	if gateway == route.Gateway {
+		// Back to the route's own gateway
+		delete(rt.redirects, dst)
+		return nil
+	}
+	if rt.redirects == nil {
+		rt.redirects = make(map[common.IPv4Address]redirect)
+	}
+	rt.redirects[dst] = redirect{gateway: gateway, expires: now.Add(config.Lifetime)}
+	return nil
+}
+
+// isGateway reports whether an address is the gateway of a route. Must be
+// called with rt.mu held.
+func (rt *RoutingTable) isGateway(addr common.IPv4Address) bool {
+	for _, route := range rt.routes {
+		if route.Gateway == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// redirected returns the next hop a redirect sets for dst, if one does.
+// Must be called with rt.mu held.
+func (rt *RoutingTable) redirected(dst common.IPv4Address) (common.IPv4Address, bool) {
+	r, ok := rt.redirects[dst]
+	if !ok || !rt.now().Before(r.expires) {
+		return common.IPv4Address{}, false
+	}
+	return r.gateway, true
+}
+
+// Redirects returns the next hop of each destination with a redirect in
+// effect.
+func (rt *RoutingTable) Redirects() map[common.IPv4Address]common.IPv4Address {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	redirects := make(map[common.IPv4Address]common.IPv4Address, len(rt.redirects))
+	for dst := range rt.redirects {
+		if gateway, ok := rt.redirected(dst); ok {
+			redirects[dst] = gateway
+		}
+	}
+	return redirects
+}
+
+// FlushRedirects forgets the redirects accepted.
+func (rt *RoutingTable) FlushRedirects() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.redirects = nil
+}
+
+// RedirectFor returns the Host Redirect a router sends to the source of a
+// packet it forwards, or nil if it sends none: only packets leaving by the
+// interface they arrived on, for a next hop on the source's own network,
+// are redirected (RFC 1812 Section 5.2.7.2).
+func (rt *RoutingTable) RedirectFor(p *Packet, inInterface string) *icmp.Message {
+	route, nextHop, err := rt.Lookup(p.Destination)
+	if err != nil || route.Interface != inInterface || nextHop == p.Source {
+		return nil
+	}
+	src, _, err := rt.Lookup(p.Source)
+	if err != nil || src.Interface != inInterface || src.Gateway != (common.IPv4Address{}) ||
+		!rt.matches(nextHop, src.Destination, src.Netmask) {
+		return nil
+	}
+	quoted := quote(p)
+	if quoted == nil {
+		return nil
+	}
+	return icmp.NewRedirect(icmp.CodeRedirectHost, nextHop, quoted)
+}
+
+// quote returns the IPv4 header and first 8 bytes of payload of a packet,
+// as an ICMP error quotes them, or nil if it cannot be serialized.
+func quote(p *Packet) []byte {
+	raw, err := p.Serialize()
+	if err != nil {
+		return nil
+	}
+	quoted := int(p.IHL)*4 + 8
+	if quoted > len(raw) {
+		quoted = len(raw)
+	}
+	return raw[:quoted]
+}
@@ -0,0 +1,206 @@
+package ip
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+)
+
+var (
+	hostIP     = common.IPv4Address{10, 0, 0, 1}
+	routerIP   = common.IPv4Address{10, 0, 0, 254}
+	router2IP  = common.IPv4Address{10, 0, 0, 253}
+	remoteHost = common.IPv4Address{8, 8, 8, 8}
+)
+
+// newRedirectTable returns the table of a host on 10.0.0.0/24 with a
+// default route via routerIP, and a clock the test sets.
+func newRedirectTable(t *testing.T, config RedirectConfig) (*RoutingTable, *time.Time) {
+	t.Helper()
+	rt := NewRoutingTable()
+	rt.AddLocalInterface("eth0", hostIP)
+	if err := rt.AddRoute(&Route{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: common.IPv4Address{255, 255, 255, 0}, Interface: "eth0"}); err != nil {
+		t.Fatalf("AddRoute() error = %v", err)
+	}
+	if err := rt.SetDefaultGateway(routerIP, "eth0"); err != nil {
+		t.Fatalf("SetDefaultGateway() error = %v", err)
+	}
+	now := time.Unix(1000, 0)
+	rt.now = func() time.Time { return now }
+	rt.SetRedirectConfig(config)
+	return rt, &now
+}
+
+// redirectFor returns a redirect to gateway of a datagram from src to dst.
+func redirectFor(t *testing.T, code icmp.Code, gateway, src, dst common.IPv4Address) *icmp.Message {
+	t.Helper()
+	quoted := quote(NewPacket(src, dst, common.ProtocolUDP, make([]byte, 16)))
+	raw, err := icmp.NewRedirect(code, gateway, quoted).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	msg, err := icmp.Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return msg
+}
+
+func TestHandleRedirect(t *testing.T) {
+	rt, now := newRedirectTable(t, RedirectConfig{Accept: true, Lifetime: time.Minute})
+	before := GetStats().RedirectsAccepted
+
+	if err := rt.HandleRedirect(redirectFor(t, icmp.CodeRedirectNet, router2IP, hostIP, remoteHost), routerIP); err != nil {
+		t.Fatalf("HandleRedirect() error = %v", err)
+	}
+	if got := GetStats().RedirectsAccepted - before; got != 1 {
+		t.Errorf("RedirectsAccepted grew by %d, want 1", got)
+	}
+	route, nextHop, err := rt.Lookup(remoteHost)
+	if err != nil || nextHop != router2IP || route.Interface != "eth0" {
+		t.Errorf("Lookup() = %v, %s, %v; want next hop %s", route, nextHop, err, router2IP)
+	}
+	// The network redirect is only applied to the host
+	if _, nextHop, _ := rt.Lookup(common.IPv4Address{8, 8, 8, 9}); nextHop != routerIP {
+		t.Errorf("Lookup() of another host = %s, want %s", nextHop, routerIP)
+	}
+	if got := rt.Redirects(); len(got) != 1 || got[remoteHost] != router2IP {
+		t.Errorf("Redirects() = %v", got)
+	}
+
+	// The new gateway may redirect again, here back to the original
+	if err := rt.HandleRedirect(redirectFor(t, icmp.CodeRedirectHost, routerIP, hostIP, remoteHost), router2IP); err != nil {
+		t.Fatalf("HandleRedirect() error = %v", err)
+	}
+	if _, nextHop, _ := rt.Lookup(remoteHost); nextHop != routerIP || len(rt.Redirects()) != 0 {
+		t.Errorf("Lookup() after the redirect back = %s, want %s", nextHop, routerIP)
+	}
+
+	// Redirects expire
+	if err := rt.HandleRedirect(redirectFor(t, icmp.CodeRedirectHost, router2IP, hostIP, remoteHost), routerIP); err != nil {
+		t.Fatalf("HandleRedirect() error = %v", err)
+	}
+	*now = now.Add(time.Minute)
+	if _, nextHop, _ := rt.Lookup(remoteHost); nextHop != routerIP {
+		t.Errorf("Lookup() after the lifetime = %s, want %s", nextHop, routerIP)
+	}
+
+	// And are forgotten when the routes change
+	if err := rt.HandleRedirect(redirectFor(t, icmp.CodeRedirectHost, router2IP, hostIP, remoteHost), routerIP); err != nil {
+		t.Fatalf("HandleRedirect() error = %v", err)
+	}
+	if err := rt.AddRoute(&Route{Destination: common.IPv4Address{192, 168, 0, 0}, Netmask: common.IPv4Address{255, 255, 0, 0}, Gateway: routerIP, Interface: "eth0"}); err != nil {
+		t.Fatalf("AddRoute() error = %v", err)
+	}
+	if _, nextHop, _ := rt.Lookup(remoteHost); nextHop != routerIP {
+		t.Errorf("Lookup() after a route change = %s, want %s", nextHop, routerIP)
+	}
+}
+
+func TestHandleRedirectRejected(t *testing.T) {
+	rt, _ := newRedirectTable(t, RedirectConfig{Accept: true})
+	echo := icmp.NewEchoRequest(1, 1, nil)
+	tests := []struct {
+		name string
+		msg  *icmp.Message
+		from common.IPv4Address
+	}{
+		{"not a redirect", echo, routerIP},
+		{"not from the gateway", redirectFor(t, icmp.CodeRedirectHost, router2IP, hostIP, remoteHost), router2IP},
+		{"on-link destination", redirectFor(t, icmp.CodeRedirectHost, router2IP, hostIP, common.IPv4Address{10, 0, 0, 7}), common.IPv4Address{10, 0, 0, 7}},
+		{"datagram not ours", redirectFor(t, icmp.CodeRedirectHost, router2IP, common.IPv4Address{10, 0, 0, 9}, remoteHost), routerIP},
+		{"gateway off-link", redirectFor(t, icmp.CodeRedirectHost, common.IPv4Address{10, 1, 0, 1}, hostIP, remoteHost), routerIP},
+		{"gateway is local", redirectFor(t, icmp.CodeRedirectHost, hostIP, hostIP, remoteHost), routerIP},
+		{"gateway unspecified", redirectFor(t, icmp.CodeRedirectHost, common.IPv4Address{}, hostIP, remoteHost), routerIP},
+		{"bad code", redirectFor(t, 4, router2IP, hostIP, remoteHost), routerIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := rt.HandleRedirect(tt.msg, tt.from); !errors.Is(err, ErrInvalidRedirect) {
+				t.Errorf("HandleRedirect() error = %v, want ErrInvalidRedirect", err)
+			}
+		})
+	}
+	if len(rt.Redirects()) != 0 {
+		t.Errorf("Redirects() = %v, want none", rt.Redirects())
+	}
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	msg := func(t *testing.T) *icmp.Message {
+		return redirectFor(t, icmp.CodeRedirectHost, router2IP, hostIP, remoteHost)
+	}
+
+	// Redirects are rejected unless enabled
+	rt, _ := newRedirectTable(t, RedirectConfig{})
+	before := GetStats().RedirectsRejected
+	if err := rt.HandleRedirect(msg(t), routerIP); !errors.Is(err, ErrRedirectsDisabled) {
+		t.Errorf("HandleRedirect() error = %v, want ErrRedirectsDisabled", err)
+	}
+	if got := GetStats().RedirectsRejected - before; got != 1 {
+		t.Errorf("RedirectsRejected grew by %d, want 1", got)
+	}
+
+	// Secure redirects must name a known gateway
+	rt, _ = newRedirectTable(t, RedirectConfig{Accept: true, Secure: true})
+	if err := rt.HandleRedirect(msg(t), routerIP); !errors.Is(err, ErrInvalidRedirect) {
+		t.Errorf("HandleRedirect() to an unknown gateway error = %v, want ErrInvalidRedirect", err)
+	}
+	if err := rt.AddRoute(&Route{Destination: common.IPv4Address{172, 16, 0, 0}, Netmask: common.IPv4Address{255, 240, 0, 0}, Gateway: router2IP, Interface: "eth0"}); err != nil {
+		t.Fatalf("AddRoute() error = %v", err)
+	}
+	if err := rt.HandleRedirect(msg(t), routerIP); err != nil {
+		t.Errorf("HandleRedirect() to a known gateway error = %v", err)
+	}
+	if rt.FlushRedirects(); len(rt.Redirects()) != 0 {
+		t.Errorf("Redirects() after FlushRedirects() = %v", rt.Redirects())
+	}
+}
+
+func TestRedirectFor(t *testing.T) {
+	rt, _ := newRedirectTable(t, RedirectConfig{})
+	if err := rt.AddRoute(&Route{Destination: common.IPv4Address{172, 16, 0, 0}, Netmask: common.IPv4Address{255, 240, 0, 0}, Gateway: router2IP, Interface: "eth0"}); err != nil {
+		t.Fatalf("AddRoute() error = %v", err)
+	}
+	if err := rt.AddRoute(&Route{Destination: common.IPv4Address{192, 168, 1, 0}, Netmask: common.IPv4Address{255, 255, 255, 0}, Interface: "eth1"}); err != nil {
+		t.Fatalf("AddRoute() error = %v", err)
+	}
+	src := common.IPv4Address{10, 0, 0, 7}
+	dst := common.IPv4Address{172, 16, 1, 1}
+
+	msg := rt.RedirectFor(NewPacket(src, dst, common.ProtocolUDP, make([]byte, 32)), "eth0")
+	if msg == nil {
+		t.Fatal("RedirectFor() = nil, want a host redirect")
+	}
+	if msg.Code != icmp.CodeRedirectHost || msg.Gateway() != router2IP {
+		t.Errorf("RedirectFor() = %v to %s, want a host redirect to %s", msg, msg.Gateway(), router2IP)
+	}
+	if orig, err := msg.Original(); err != nil || orig.Source != src || orig.Destination != dst || len(orig.Payload) != 8 {
+		t.Errorf("Original() = %+v, %v", orig, err)
+	}
+
+	// Hosts on the link are sent to each other
+	onLink := common.IPv4Address{10, 0, 0, 8}
+	if msg := rt.RedirectFor(NewPacket(src, onLink, common.ProtocolUDP, nil), "eth0"); msg == nil || msg.Gateway() != onLink {
+		t.Errorf("RedirectFor() of an on-link destination = %v, want a redirect to %s", msg, onLink)
+	}
+
+	tests := []struct {
+		name     string
+		src, dst common.IPv4Address
+		in       string
+	}{
+		{"other interface", common.IPv4Address{192, 168, 1, 5}, dst, "eth1"},
+		{"source off-link", common.IPv4Address{192, 168, 1, 5}, dst, "eth0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg := rt.RedirectFor(NewPacket(tt.src, tt.dst, common.ProtocolUDP, nil), tt.in); msg != nil {
+				t.Errorf("RedirectFor() = %v, want nil", msg)
+			}
+		})
+	}
+}
@@ -2,13 +2,19 @@
 package ip
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
 )
 
+// ErrNoRoute is returned when a lookup finds no route to a destination.
+var ErrNoRoute = errors.New("no route to host")
+
 // Route represents a routing table entry.
 type Route struct {
 	Destination common.IPv4Address // Destination network
@@ -16,14 +22,38 @@ type Route struct {
 	Gateway     common.IPv4Address // Next hop gateway (0.0.0.0 for direct)
 	Interface   string             // Network interface name
 	Metric      int                // Route metric (lower is better)
+	DSCP        uint8              // Default DSCP of packets sent over the route whose socket sets none
+	Source      common.IPv4Address // Preferred source address, as Linux's "src" (0.0.0.0 to select one); single-path routes only
+	MTU         int                // Path MTU, as Linux's "mtu" (0 for the interface MTU); single-path routes only
+
+	// NextHops holds the paths of a multipath route made with
+	// NewMultipathRoute, and is nil for other routes.
+	NextHops *NextHopGroup
 }
 
 // RoutingTable manages IP routes.
+//
+// Routes are changed one at a time with AddRoute, ReplaceRoute and
+// RemoveRoute, or together with Apply; listeners added with Subscribe are
+// told of each change.
 type RoutingTable struct {
 	mu              sync.RWMutex
 	routes          []*Route
 	defaultGateway  *Route
 	localInterfaces map[string]common.IPv4Address // interface name -> IP address
+	addresses       []InterfaceAddress            // Addresses of the interfaces, in the order added
+	mtus            map[string]int                // interface name -> MTU, if not DefaultMTU
+
+	// ICMP Redirects accepted, by destination
+	redirectConfig RedirectConfig
+	redirects      map[common.IPv4Address]redirect
+	now            func() time.Time // Replaced in tests
+
+	// Route change listeners. updateMu orders changes, so listeners see
+	// them in the order they were made; it is taken before mu.
+	updateMu    sync.Mutex
+	subscribers []subscriber
+	nextSub     Subscription
 }
 
 // NewRoutingTable creates a new routing table.
@@ -31,6 +61,7 @@ func NewRoutingTable() *RoutingTable {
 	return &RoutingTable{
 		routes:          make([]*Route, 0),
 		localInterfaces: make(map[string]common.IPv4Address),
+		now:             time.Now,
 	}
 }
 
@@ -40,63 +71,63 @@ func (rt *RoutingTable) AddRoute(route *Route) error {
 		return fmt.Errorf("route is nil")
 	}
 
-	rt.mu.Lock()
-	defer rt.mu.Unlock()
-
-	// Check if this is a default route (0.0.0.0/0)
-	if route.Destination == (common.IPv4Address{0, 0, 0, 0}) &&
-		route.Netmask == (common.IPv4Address{0, 0, 0, 0}) {
-		rt.defaultGateway = route
-	}
-
-	rt.routes = append(rt.routes, route)
-	return nil
+	return rt.Apply([]RouteChange{{Op: RouteAdd, Route: route}})
 }
 
 // RemoveRoute removes a route from the routing table.
 func (rt *RoutingTable) RemoveRoute(destination, netmask common.IPv4Address) bool {
-	rt.mu.Lock()
-	defer rt.mu.Unlock()
-
-	for i, route := range rt.routes {
-		if route.Destination == destination && route.Netmask == netmask {
-			// Remove route
-			rt.routes = append(rt.routes[:i], rt.routes[i+1:]...)
-
-			// Clear default gateway if this was it
-			if rt.defaultGateway == route {
-				rt.defaultGateway = nil
-			}
-
-			return true
-		}
-	}
-
-	return false
+	err := rt.Apply([]RouteChange{{Op: RouteRemove, Route: &Route{Destination: destination, Netmask: netmask}}})
+	return err == nil
 }
 
 // Lookup finds the best route for a destination IP address.
-// Returns the route and next hop IP address.
+// Returns the route and next hop IP address. Multipath routes choose a path
+// by the destination alone; LookupFlow spreads flows over them.
 func (rt *RoutingTable) Lookup(dst common.IPv4Address) (*Route, common.IPv4Address, error) {
+	return rt.LookupFlow(FlowKey{Destination: dst})
+}
+
+// LookupFlow finds the best route for a flow. For a multipath route it
+// returns the single-path route of the path the flow hashes to; routes
+// whose paths are all down are passed over.
+func (rt *RoutingTable) LookupFlow(key FlowKey) (*Route, common.IPv4Address, error) {
 	rt.mu.RLock()
 	defer rt.mu.RUnlock()
+	return rt.lookupFlow(key, "")
+}
+
+// lookupFlow is LookupFlow among the routes out of device, or all routes
+// if device is empty. Called with rt.mu held.
+func (rt *RoutingTable) lookupFlow(key FlowKey, device string) (*Route, common.IPv4Address, error) {
+	dst := key.Destination
 
 	var bestRoute *Route
 	var bestPrefixLen int = -1
+	var hash uint32
+	hashed := false
 
 	// Find the most specific route (longest prefix match)
 	for _, route := range rt.routes {
 		if rt.matches(dst, route.Destination, route.Netmask) {
 			prefixLen := rt.countOnes(route.Netmask)
-			if prefixLen > bestPrefixLen {
-				bestRoute = route
+			if prefixLen <= bestPrefixLen {
+				continue
+			}
+			if route.NextHops != nil && !hashed {
+				hash, hashed = key.Hash(), true
+			}
+			if path := route.Path(hash); path != nil && (device == "" || path.Interface == device) {
+				bestRoute = path
 				bestPrefixLen = prefixLen
 			}
 		}
 	}
 
 	if bestRoute == nil {
-		return nil, common.IPv4Address{}, fmt.Errorf("no route to host: %s", dst)
+		if device != "" {
+			return nil, common.IPv4Address{}, fmt.Errorf("%w: %s dev %s", ErrNoRoute, dst, device)
+		}
+		return nil, common.IPv4Address{}, fmt.Errorf("%w: %s", ErrNoRoute, dst)
 	}
 
 	// Determine next hop
@@ -105,6 +136,9 @@ func (rt *RoutingTable) Lookup(dst common.IPv4Address) (*Route, common.IPv4Addre
 		// Use gateway
 		nextHop = bestRoute.Gateway
 	}
+	if gateway, ok := rt.redirected(dst); ok {
+		nextHop = gateway
+	}
 
 	return bestRoute, nextHop, nil
 }
@@ -158,6 +192,15 @@ func (rt *RoutingTable) AddLocalInterface(iface string, ip common.IPv4Address) {
 	rt.localInterfaces[iface] = ip
 }
 
+// RemoveLocalInterface unregisters a local network interface, with the
+// addresses added to it with AddAddress.
+func (rt *RoutingTable) RemoveLocalInterface(iface string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.localInterfaces, iface)
+	rt.addresses = slices.DeleteFunc(rt.addresses, func(a InterfaceAddress) bool { return a.Interface == iface })
+}
+
 // GetLocalInterface returns the IP address for a local interface.
 func (rt *RoutingTable) GetLocalInterface(iface string) (common.IPv4Address, bool) {
 	rt.mu.RLock()
@@ -171,11 +214,21 @@ func (rt *RoutingTable) IsLocalAddress(ip common.IPv4Address) bool {
 	rt.mu.RLock()
 	defer rt.mu.RUnlock()
 
+	return rt.isLocal(ip)
+}
+
+// isLocal is IsLocalAddress, called with rt.mu held.
+func (rt *RoutingTable) isLocal(ip common.IPv4Address) bool {
 	for _, localIP := range rt.localInterfaces {
 		if localIP == ip {
 			return true
 		}
 	}
+	for _, addr := range rt.addresses {
+		if addr.Address == ip {
+			return true
+		}
+	}
 	return false
 }
 
@@ -206,6 +259,16 @@ func (rt *RoutingTable) String() string {
 
 		s += fmt.Sprintf("%-15s %-15s %-15s %-10s %d\n",
 			route.Destination, route.Netmask, gateway, route.Interface, route.Metric)
+
+		if route.NextHops != nil {
+			for i, hop := range route.NextHops.NextHops() {
+				state := ""
+				if !route.NextHops.Healthy(i) {
+					state = " (down)"
+				}
+				s += fmt.Sprintf("  nexthop via %s dev %s weight %d%s\n", hop.Gateway, hop.Interface, hop.Weight, state)
+			}
+		}
 	}
 
 	return s
@@ -111,7 +111,7 @@ func (rt *OptimizedRoutingTable) Lookup(dst common.IPv4Address) (*Route, common.
 		}
 	}
 
-	return nil, common.IPv4Address{}, fmt.Errorf("no route to host: %s", dst)
+	return nil, common.IPv4Address{}, fmt.Errorf("%w: %s", ErrNoRoute, dst)
 }
 
 // rebuildSortedRoutes rebuilds the sorted route list (must hold write lock)
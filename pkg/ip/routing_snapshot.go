@@ -0,0 +1,270 @@
+package ip
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// SnapshotRoutingTable is a routing table whose lookups take no lock, for
+// the forwarding fast path.
+//
+// The routes are held in an immutable binary trie. A change copies the
+// nodes on the path to the network it changes, sharing the rest with the
+// old trie, and swaps the new root in with an atomic pointer; lookups
+// running meanwhile finish on the trie they started with. Changes are
+// serialized with each other but never wait for lookups.
+//
+// Unlike RoutingTable, the table holds one route per network: adding a
+// route to a network replaces the one it has.
+type SnapshotRoutingTable struct {
+	mu       sync.Mutex // Serializes changes
+	snapshot atomic.Pointer[RouteSnapshot]
+}
+
+// RouteSnapshot is the content of a SnapshotRoutingTable at one time. It is
+// never modified, so a forwarding loop can take one for a batch of packets
+// and look them all up in it.
+type RouteSnapshot struct {
+	root            *snapshotNode
+	routes          int
+	localInterfaces map[string]common.IPv4Address
+}
+
+// snapshotNode is a node of the trie, at the depth of its prefix length.
+type snapshotNode struct {
+	route    *Route           // Route to the node's network, if any
+	children [2]*snapshotNode // By the next bit of the address
+}
+
+// NewSnapshotRoutingTable creates an empty snapshot routing table.
+func NewSnapshotRoutingTable() *SnapshotRoutingTable {
+	rt := &SnapshotRoutingTable{}
+	rt.snapshot.Store(&RouteSnapshot{localInterfaces: map[string]common.IPv4Address{}})
+	return rt
+}
+
+// Snapshot returns the current content of the table.
+func (rt *SnapshotRoutingTable) Snapshot() *RouteSnapshot {
+	return rt.snapshot.Load()
+}
+
+// AddRoute adds a route, replacing any route to the same network.
+func (rt *SnapshotRoutingTable) AddRoute(route *Route) error {
+	return rt.Apply([]RouteChange{{Op: RouteAdd, Route: route}})
+}
+
+// RemoveRoute removes the route to a network. Returns false if there is
+// none.
+func (rt *SnapshotRoutingTable) RemoveRoute(destination, netmask common.IPv4Address) bool {
+	err := rt.Apply([]RouteChange{{Op: RouteRemove, Route: &Route{Destination: destination, Netmask: netmask}}})
+	return err == nil
+}
+
+// Apply makes a list of changes atomically: lookups see the table before
+// or after all of them, and if one fails none is made. RouteAdd and
+// RouteReplace both set the route to a network.
+func (rt *SnapshotRoutingTable) Apply(changes []RouteChange) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	old := rt.snapshot.Load()
+	root, routes := old.root, old.routes
+	for _, change := range changes {
+		route := change.Route
+		if route == nil {
+			return fmt.Errorf("route is nil")
+		}
+		bits, ok := common.NetmaskLength(route.Netmask)
+		if !ok {
+			return fmt.Errorf("invalid netmask %s", route.Netmask)
+		}
+		prefix := route.Destination.ToUint32() & route.Netmask.ToUint32()
+		exists := root.get(prefix, bits) != nil
+
+		switch change.Op {
+		case RouteAdd, RouteReplace:
+			if route.NextHops != nil {
+				route.NextHops.setDSCP(route.DSCP)
+			}
+			root = root.with(prefix, 0, bits, route)
+			if !exists {
+				routes++
+			}
+		case RouteRemove:
+			if !exists {
+				return fmt.Errorf("%w: %s/%s", ErrRouteNotFound, route.Destination, route.Netmask)
+			}
+			root = root.with(prefix, 0, bits, nil)
+			routes--
+		default:
+			return fmt.Errorf("invalid route operation: %s", change.Op)
+		}
+	}
+
+	rt.snapshot.Store(&RouteSnapshot{root: root, routes: routes, localInterfaces: old.localInterfaces})
+	return nil
+}
+
+// Lookup finds the best route for a destination in the current snapshot.
+func (rt *SnapshotRoutingTable) Lookup(dst common.IPv4Address) (*Route, common.IPv4Address, error) {
+	return rt.snapshot.Load().Lookup(dst)
+}
+
+// LookupFlow finds the best route for a flow in the current snapshot.
+func (rt *SnapshotRoutingTable) LookupFlow(key FlowKey) (*Route, common.IPv4Address, error) {
+	return rt.snapshot.Load().LookupFlow(key)
+}
+
+// GetRoutes returns all routes, shortest prefix first.
+func (rt *SnapshotRoutingTable) GetRoutes() []*Route {
+	return rt.snapshot.Load().Routes()
+}
+
+// AddLocalInterface registers a local network interface.
+func (rt *SnapshotRoutingTable) AddLocalInterface(name string, addr common.IPv4Address) {
+	rt.setLocalInterface(name, addr, true)
+}
+
+// RemoveLocalInterface unregisters a local network interface.
+func (rt *SnapshotRoutingTable) RemoveLocalInterface(name string) {
+	rt.setLocalInterface(name, common.IPv4Address{}, false)
+}
+
+// setLocalInterface publishes a snapshot with an interface added or
+// removed.
+func (rt *SnapshotRoutingTable) setLocalInterface(name string, addr common.IPv4Address, add bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	old := rt.snapshot.Load()
+	local := make(map[string]common.IPv4Address, len(old.localInterfaces)+1)
+	for n, a := range old.localInterfaces {
+		local[n] = a
+	}
+	if add {
+		local[name] = addr
+	} else {
+		delete(local, name)
+	}
+	rt.snapshot.Store(&RouteSnapshot{root: old.root, routes: old.routes, localInterfaces: local})
+}
+
+// IsLocalAddress checks if an IP address belongs to a local interface.
+func (rt *SnapshotRoutingTable) IsLocalAddress(ip common.IPv4Address) bool {
+	return rt.snapshot.Load().IsLocalAddress(ip)
+}
+
+// Len returns the number of routes in the snapshot.
+func (s *RouteSnapshot) Len() int {
+	return s.routes
+}
+
+// Lookup finds the best route for a destination IP address. Returns the
+// route and next hop IP address. Multipath routes choose a path by the
+// destination alone.
+func (s *RouteSnapshot) Lookup(dst common.IPv4Address) (*Route, common.IPv4Address, error) {
+	return s.LookupFlow(FlowKey{Destination: dst})
+}
+
+// LookupFlow finds the best route for a flow, like RoutingTable.LookupFlow:
+// a multipath route gives the route of the path the flow hashes to, and
+// one whose paths are all down is passed over for a shorter prefix.
+func (s *RouteSnapshot) LookupFlow(key FlowKey) (*Route, common.IPv4Address, error) {
+	dst := key.Destination.ToUint32()
+
+	// The routes on the destination's path, longest prefix last
+	var matches [33]*Route
+	n := 0
+	node := s.root
+	for depth := 0; node != nil; depth++ {
+		if node.route != nil {
+			matches[n] = node.route
+			n++
+		}
+		if depth == 32 {
+			break
+		}
+		node = node.children[dst>>(31-depth)&1]
+	}
+
+	var hash uint32
+	hashed := false
+	for i := n - 1; i >= 0; i-- {
+		route := matches[i]
+		if route.NextHops != nil && !hashed {
+			hash, hashed = key.Hash(), true
+		}
+		if path := route.Path(hash); path != nil {
+			nextHop := key.Destination
+			if path.Gateway != (common.IPv4Address{}) {
+				nextHop = path.Gateway
+			}
+			return path, nextHop, nil
+		}
+	}
+	return nil, common.IPv4Address{}, fmt.Errorf("%w: %s", ErrNoRoute, key.Destination)
+}
+
+// Routes returns all routes, shortest prefix first.
+func (s *RouteSnapshot) Routes() []*Route {
+	routes := make([]*Route, 0, s.routes)
+	level := []*snapshotNode{s.root}
+	for len(level) > 0 {
+		var next []*snapshotNode
+		for _, node := range level {
+			if node == nil {
+				continue
+			}
+			if node.route != nil {
+				routes = append(routes, node.route)
+			}
+			next = append(next, node.children[0], node.children[1])
+		}
+		level = next
+	}
+	return routes
+}
+
+// IsLocalAddress checks if an IP address belongs to a local interface.
+func (s *RouteSnapshot) IsLocalAddress(ip common.IPv4Address) bool {
+	for _, addr := range s.localInterfaces {
+		if addr == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the route to the network of prefix length bits, or nil.
+func (n *snapshotNode) get(prefix uint32, bits int) *Route {
+	for depth := 0; n != nil; depth++ {
+		if depth == bits {
+			return n.route
+		}
+		n = n.children[prefix>>(31-depth)&1]
+	}
+	return nil
+}
+
+// with returns a copy of the subtrie at n, at depth, with the route to the
+// network of prefix length bits set, or removed if route is nil. Only the
+// nodes on the path are copied, and those left empty are dropped.
+func (n *snapshotNode) with(prefix uint32, depth, bits int, route *Route) *snapshotNode {
+	var next snapshotNode
+	if n != nil {
+		next = *n
+	}
+	if depth == bits {
+		next.route = route
+	} else {
+		bit := prefix >> (31 - depth) & 1
+		next.children[bit] = next.children[bit].with(prefix, depth+1, bits, route)
+	}
+	if next.route == nil && next.children[0] == nil && next.children[1] == nil {
+		return nil
+	}
+	return &next
+}
@@ -0,0 +1,189 @@
+package ip
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestSnapshotRoutingTable_Lookup(t *testing.T) {
+	rt := NewSnapshotRoutingTable()
+	routes := []*Route{
+		{Destination: common.IPv4Address{}, Netmask: common.IPv4Address{}, Gateway: common.IPv4Address{192, 168, 1, 1}, Interface: "eth0"},
+		{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: common.IPv4Address{255, 0, 0, 0}, Gateway: common.IPv4Address{10, 0, 0, 1}, Interface: "eth1"},
+		{Destination: common.IPv4Address{10, 1, 0, 0}, Netmask: common.IPv4Address{255, 255, 0, 0}, Interface: "eth2"},
+		{Destination: common.IPv4Address{10, 1, 2, 3}, Netmask: common.IPv4Address{255, 255, 255, 255}, Interface: "eth3"},
+	}
+	for _, route := range routes {
+		if err := rt.AddRoute(route); err != nil {
+			t.Fatalf("AddRoute() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		dst     common.IPv4Address
+		iface   string
+		nextHop common.IPv4Address
+	}{
+		{common.IPv4Address{8, 8, 8, 8}, "eth0", common.IPv4Address{192, 168, 1, 1}},
+		{common.IPv4Address{10, 200, 0, 1}, "eth1", common.IPv4Address{10, 0, 0, 1}},
+		{common.IPv4Address{10, 1, 9, 9}, "eth2", common.IPv4Address{10, 1, 9, 9}},
+		{common.IPv4Address{10, 1, 2, 3}, "eth3", common.IPv4Address{10, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		route, nextHop, err := rt.Lookup(tt.dst)
+		if err != nil {
+			t.Fatalf("Lookup(%s) error = %v", tt.dst, err)
+		}
+		if route.Interface != tt.iface || nextHop != tt.nextHop {
+			t.Errorf("Lookup(%s) = %s via %s, want %s via %s", tt.dst, route.Interface, nextHop, tt.iface, tt.nextHop)
+		}
+
+		// The same answers as RoutingTable
+		ref := NewRoutingTable()
+		for _, route := range routes {
+			ref.AddRoute(route)
+		}
+		want, wantHop, _ := ref.Lookup(tt.dst)
+		if route != want || nextHop != wantHop {
+			t.Errorf("Lookup(%s) = %s via %s, RoutingTable gives %s via %s", tt.dst, route.Interface, nextHop, want.Interface, wantHop)
+		}
+	}
+
+	if !rt.RemoveRoute(common.IPv4Address{}, common.IPv4Address{}) {
+		t.Fatal("RemoveRoute() of the default route = false, want true")
+	}
+	if _, _, err := rt.Lookup(common.IPv4Address{8, 8, 8, 8}); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("Lookup() without a default route error = %v, want ErrNoRoute", err)
+	}
+	if rt.RemoveRoute(common.IPv4Address{}, common.IPv4Address{}) {
+		t.Error("RemoveRoute() of a missing route = true, want false")
+	}
+	if got := len(rt.GetRoutes()); got != 3 || rt.Snapshot().Len() != 3 {
+		t.Errorf("GetRoutes() = %d routes, Len() = %d, want 3", got, rt.Snapshot().Len())
+	}
+}
+
+func TestSnapshotRoutingTable_Snapshot(t *testing.T) {
+	rt := NewSnapshotRoutingTable()
+	net10 := &Route{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: common.IPv4Address{255, 0, 0, 0}, Interface: "eth0"}
+	rt.AddRoute(net10)
+	before := rt.Snapshot()
+
+	// Changes leave a snapshot taken before them as it was
+	replacement := &Route{Destination: common.IPv4Address{10, 9, 9, 9}, Netmask: common.IPv4Address{255, 0, 0, 0}, Interface: "eth1"}
+	rt.AddRoute(replacement)
+	rt.AddRoute(&Route{Destination: common.IPv4Address{10, 1, 0, 0}, Netmask: common.IPv4Address{255, 255, 0, 0}, Interface: "eth2"})
+
+	if route, _, _ := before.Lookup(common.IPv4Address{10, 1, 0, 1}); route != net10 {
+		t.Errorf("old snapshot Lookup() = %v, want the route it had", route)
+	}
+	if before.Len() != 1 || rt.Snapshot().Len() != 2 {
+		t.Errorf("Len() = %d before and %d after, want 1 and 2", before.Len(), rt.Snapshot().Len())
+	}
+	if route, _, _ := rt.Lookup(common.IPv4Address{10, 2, 0, 1}); route != replacement {
+		t.Errorf("Lookup() = %v, want the replacing route", route)
+	}
+}
+
+func TestSnapshotRoutingTable_Apply(t *testing.T) {
+	rt := NewSnapshotRoutingTable()
+	a := &Route{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: common.IPv4Address{255, 0, 0, 0}}
+	b := &Route{Destination: common.IPv4Address{172, 16, 0, 0}, Netmask: common.IPv4Address{255, 240, 0, 0}}
+	rt.AddRoute(a)
+	before := rt.Snapshot()
+
+	// A failing change undoes the batch
+	err := rt.Apply([]RouteChange{
+		{Op: RouteAdd, Route: b},
+		{Op: RouteRemove, Route: &Route{Destination: common.IPv4Address{192, 168, 0, 0}, Netmask: common.IPv4Address{255, 255, 0, 0}}},
+	})
+	if !errors.Is(err, ErrRouteNotFound) {
+		t.Fatalf("Apply() error = %v, want ErrRouteNotFound", err)
+	}
+	if rt.Snapshot() != before {
+		t.Error("failed Apply() published a snapshot")
+	}
+
+	if err := rt.Apply([]RouteChange{{Op: RouteAdd, Route: b}, {Op: RouteRemove, Route: a}}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if routes := rt.GetRoutes(); len(routes) != 1 || routes[0] != b {
+		t.Errorf("GetRoutes() = %v, want only %v", routes, b)
+	}
+
+	if err := rt.AddRoute(&Route{Netmask: common.IPv4Address{255, 0, 255, 0}}); err == nil {
+		t.Error("AddRoute() with a discontiguous netmask error = nil, want an error")
+	}
+	if err := rt.AddRoute(nil); err == nil {
+		t.Error("AddRoute(nil) error = nil, want an error")
+	}
+}
+
+func TestSnapshotRoutingTable_LookupFlow(t *testing.T) {
+	rt := NewSnapshotRoutingTable()
+	fallback := &Route{Destination: common.IPv4Address{}, Netmask: common.IPv4Address{}, Interface: "eth9"}
+	route, err := NewMultipathRoute(common.IPv4Address{8, 0, 0, 0}, common.IPv4Address{255, 0, 0, 0}, 0,
+		NextHop{Gateway: common.IPv4Address{10, 0, 0, 1}, Interface: "eth0"},
+		NextHop{Gateway: common.IPv4Address{10, 0, 0, 2}, Interface: "eth1"})
+	if err != nil {
+		t.Fatalf("NewMultipathRoute() error = %v", err)
+	}
+	rt.AddRoute(fallback)
+	rt.AddRoute(route)
+
+	key := FlowKey{Source: common.IPv4Address{1, 1, 1, 1}, Destination: common.IPv4Address{8, 8, 8, 8}, Protocol: common.ProtocolTCP, SourcePort: 1234, DestinationPort: 80}
+	path, nextHop, err := rt.LookupFlow(key)
+	if err != nil {
+		t.Fatalf("LookupFlow() error = %v", err)
+	}
+	if want := route.Path(key.Hash()); path != want || nextHop != want.Gateway {
+		t.Errorf("LookupFlow() = %s via %s, want %s via %s", path.Interface, nextHop, want.Interface, want.Gateway)
+	}
+
+	// With every path down, the default route takes over
+	route.NextHops.SetHealthy(0, false)
+	route.NextHops.SetHealthy(1, false)
+	if path, _, _ := rt.LookupFlow(key); path != fallback {
+		t.Errorf("LookupFlow() with all paths down = %v, want the default route", path)
+	}
+}
+
+func TestSnapshotRoutingTable_LocalInterfaces(t *testing.T) {
+	rt := NewSnapshotRoutingTable()
+	addr := common.IPv4Address{192, 168, 1, 10}
+	rt.AddLocalInterface("eth0", addr)
+	if !rt.IsLocalAddress(addr) {
+		t.Error("IsLocalAddress() = false, want true")
+	}
+	before := rt.Snapshot()
+	rt.RemoveLocalInterface("eth0")
+	if rt.IsLocalAddress(addr) || !before.IsLocalAddress(addr) {
+		t.Error("RemoveLocalInterface() changed an old snapshot or kept the address")
+	}
+}
+
+func TestSnapshotRoutingTable_Concurrent(t *testing.T) {
+	rt := NewSnapshotRoutingTable()
+	stable := &Route{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: common.IPv4Address{255, 0, 0, 0}, Interface: "eth0"}
+	rt.AddRoute(stable)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			other := &Route{Destination: common.IPv4Address{10, 1, byte(i), 0}, Netmask: common.IPv4Address{255, 255, 255, 0}}
+			rt.AddRoute(other)
+			rt.RemoveRoute(other.Destination, other.Netmask)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		if route, _, err := rt.Lookup(common.IPv4Address{10, 2, 0, 1}); err != nil || route != stable {
+			t.Fatalf("Lookup() during changes = %v, %v, want the stable route", route, err)
+		}
+	}
+	wg.Wait()
+}
@@ -1,6 +1,7 @@
 package ip
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
@@ -319,6 +320,186 @@ func TestRoutingTable_countOnes(t *testing.T) {
 	}
 }
 
+func TestRoutingTable_ReplaceRoute(t *testing.T) {
+	rt := NewRoutingTable()
+
+	def := common.IPv4Address{0, 0, 0, 0}
+	first := &Route{Destination: def, Netmask: def, Gateway: common.IPv4Address{10, 0, 0, 1}, Interface: "eth0"}
+	second := &Route{Destination: def, Netmask: def, Gateway: common.IPv4Address{10, 0, 0, 254}, Interface: "eth0"}
+
+	old, err := rt.ReplaceRoute(first)
+	if err != nil {
+		t.Fatalf("ReplaceRoute() error = %v", err)
+	}
+	if old != nil {
+		t.Errorf("ReplaceRoute() of a new route = %v, want nil", old)
+	}
+
+	old, err = rt.ReplaceRoute(second)
+	if err != nil {
+		t.Fatalf("ReplaceRoute() error = %v", err)
+	}
+	if old != first {
+		t.Errorf("ReplaceRoute() = %v, want %v", old, first)
+	}
+	if routes := rt.GetRoutes(); len(routes) != 1 {
+		t.Errorf("Expected 1 route, got %d", len(routes))
+	}
+	if got := rt.GetDefaultGateway(); got != second {
+		t.Errorf("GetDefaultGateway() = %v, want %v", got, second)
+	}
+
+	_, nextHop, err := rt.Lookup(common.IPv4Address{8, 8, 8, 8})
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if nextHop != second.Gateway {
+		t.Errorf("NextHop = %s, want %s", nextHop, second.Gateway)
+	}
+}
+
+func TestRoutingTable_Apply(t *testing.T) {
+	rt := NewRoutingTable()
+
+	mask := common.IPv4Address{255, 255, 255, 0}
+	lan := &Route{Destination: common.IPv4Address{192, 168, 1, 0}, Netmask: mask, Interface: "eth0"}
+	rt.AddRoute(lan)
+
+	// A change that fails leaves the table as it was
+	err := rt.Apply([]RouteChange{
+		{Op: RouteRemove, Route: lan},
+		{Op: RouteAdd, Route: &Route{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: mask, Interface: "eth1"}},
+		{Op: RouteRemove, Route: &Route{Destination: common.IPv4Address{172, 16, 0, 0}, Netmask: mask}},
+	})
+	if !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("Apply() error = %v, want %v", err, ErrRouteNotFound)
+	}
+	if routes := rt.GetRoutes(); len(routes) != 1 || routes[0] != lan {
+		t.Errorf("GetRoutes() after a failed Apply() = %v, want [%v]", routes, lan)
+	}
+
+	invalid := [][]RouteChange{
+		{{Op: RouteAdd}},
+		{{Op: RouteOp(99), Route: lan}},
+	}
+	for _, changes := range invalid {
+		if err := rt.Apply(changes); err == nil {
+			t.Errorf("Apply(%v) error = nil, want an error", changes)
+		}
+	}
+
+	// Moving the network to another interface in one Apply() leaves no
+	// moment when lookups find no route
+	moved := &Route{Destination: lan.Destination, Netmask: mask, Interface: "eth1"}
+	err = rt.Apply([]RouteChange{
+		{Op: RouteRemove, Route: lan},
+		{Op: RouteAdd, Route: moved},
+	})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	route, _, err := rt.Lookup(common.IPv4Address{192, 168, 1, 10})
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if route != moved {
+		t.Errorf("Lookup() = %v, want %v", route, moved)
+	}
+}
+
+func TestRoutingTable_Subscribe(t *testing.T) {
+	rt := NewRoutingTable()
+
+	var events []RouteEvent
+	sub := rt.Subscribe(func(event RouteEvent) { events = append(events, event) })
+
+	mask := common.IPv4Address{255, 255, 255, 0}
+	lan := &Route{Destination: common.IPv4Address{192, 168, 1, 0}, Netmask: mask, Interface: "eth0"}
+	moved := &Route{Destination: lan.Destination, Netmask: mask, Interface: "eth1"}
+
+	rt.AddRoute(lan)
+	rt.ReplaceRoute(moved)
+	rt.RemoveRoute(lan.Destination, mask)
+	rt.RemoveRoute(lan.Destination, mask) // Not a change
+
+	want := []RouteEvent{
+		{Op: RouteAdd, Route: lan},
+		{Op: RouteReplace, Route: moved, Old: lan},
+		{Op: RouteRemove, Route: moved},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+
+	if !rt.Unsubscribe(sub) {
+		t.Error("Unsubscribe() = false, want true")
+	}
+	if rt.Unsubscribe(sub) {
+		t.Error("Unsubscribe() twice = true, want false")
+	}
+	rt.AddRoute(lan)
+	if len(events) != len(want) {
+		t.Errorf("got %d events after Unsubscribe(), want %d", len(events), len(want))
+	}
+}
+
+func TestRoutingTable_SubscribeInvalidatesNextHops(t *testing.T) {
+	rt := NewRoutingTable()
+	def := common.IPv4Address{0, 0, 0, 0}
+	rt.SetDefaultGateway(common.IPv4Address{10, 0, 0, 1}, "eth0")
+
+	// A next-hop cache, as the layer resolving next hops would keep,
+	// dropped when the routes change
+	dst := common.IPv4Address{8, 8, 8, 8}
+	cache := make(map[common.IPv4Address]common.IPv4Address)
+	lookup := func() common.IPv4Address {
+		if nextHop, ok := cache[dst]; ok {
+			return nextHop
+		}
+		_, nextHop, err := rt.Lookup(dst)
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		cache[dst] = nextHop
+		return nextHop
+	}
+	rt.Subscribe(func(RouteEvent) {
+		for addr := range cache {
+			delete(cache, addr)
+		}
+	})
+
+	lookup()
+	gateway := common.IPv4Address{10, 0, 0, 254}
+	rt.ReplaceRoute(&Route{Destination: def, Netmask: def, Gateway: gateway, Interface: "eth0"})
+	if got := lookup(); got != gateway {
+		t.Errorf("next hop after ReplaceRoute() = %s, want %s", got, gateway)
+	}
+}
+
+func TestRouteOp_String(t *testing.T) {
+	tests := []struct {
+		op   RouteOp
+		want string
+	}{
+		{RouteAdd, "add"},
+		{RouteReplace, "replace"},
+		{RouteRemove, "remove"},
+		{RouteOp(7), "RouteOp(7)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.op.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
 func BenchmarkLookup(b *testing.B) {
 	rt := NewRoutingTable()
 
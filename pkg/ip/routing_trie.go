@@ -120,7 +120,7 @@ func (trt *TrieRoutingTable) Lookup(dst common.IPv4Address) (*Route, common.IPv4
 	}
 
 	if bestMatch == nil {
-		return nil, common.IPv4Address{}, fmt.Errorf("no route to host: %s", dst)
+		return nil, common.IPv4Address{}, fmt.Errorf("%w: %s", ErrNoRoute, dst)
 	}
 
 	return bestMatch.route, bestMatch.nextHop, nil
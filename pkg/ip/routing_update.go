@@ -0,0 +1,194 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// ErrRouteNotFound is returned when a change removes a route the table
+// does not have.
+var ErrRouteNotFound = errors.New("route not found")
+
+// RouteOp is a change to a routing table.
+type RouteOp int
+
+const (
+	// RouteAdd adds a route, alongside any others to the same network.
+	RouteAdd RouteOp = iota
+
+	// RouteReplace replaces the route to a network, or adds it if there
+	// is none, like "ip route replace".
+	RouteReplace
+
+	// RouteRemove removes the route to a network.
+	RouteRemove
+)
+
+// String returns the string representation of the operation.
+func (op RouteOp) String() string {
+	switch op {
+	case RouteAdd:
+		return "add"
+	case RouteReplace:
+		return "replace"
+	case RouteRemove:
+		return "remove"
+	default:
+		return fmt.Sprintf("RouteOp(%d)", int(op))
+	}
+}
+
+// RouteChange is one change in a call to Apply. A removal only needs the
+// route's Destination and Netmask.
+type RouteChange struct {
+	Op    RouteOp
+	Route *Route
+}
+
+// RouteEvent tells a listener of a change to a routing table.
+type RouteEvent struct {
+	Op    RouteOp
+	Route *Route // The route added or removed, or the new route
+	Old   *Route // The route replaced, if any
+}
+
+// Subscription identifies a route change listener.
+type Subscription uint64
+
+type subscriber struct {
+	id Subscription
+	fn func(RouteEvent)
+}
+
+// ReplaceRoute replaces the route to the route's network, or adds it if
+// there is none. It returns the route replaced, or nil.
+func (rt *RoutingTable) ReplaceRoute(route *Route) (*Route, error) {
+	var old *Route
+	err := rt.apply([]RouteChange{{Op: RouteReplace, Route: route}}, func(events []RouteEvent) {
+		old = events[0].Old
+	})
+	return old, err
+}
+
+// Apply makes a list of changes atomically: lookups see the table before
+// or after all of them, and if one fails, because it removes a route the
+// table does not have, none is made.
+func (rt *RoutingTable) Apply(changes []RouteChange) error {
+	return rt.apply(changes, nil)
+}
+
+// Subscribe adds a listener called with each change to the table, in the
+// order the changes are made. Listeners are called after the change, and
+// may look routes up but must not change the table.
+func (rt *RoutingTable) Subscribe(fn func(RouteEvent)) Subscription {
+	rt.updateMu.Lock()
+	defer rt.updateMu.Unlock()
+
+	rt.nextSub++
+	rt.subscribers = append(rt.subscribers, subscriber{id: rt.nextSub, fn: fn})
+	return rt.nextSub
+}
+
+// Unsubscribe removes a listener. Returns false if it is not subscribed.
+func (rt *RoutingTable) Unsubscribe(s Subscription) bool {
+	rt.updateMu.Lock()
+	defer rt.updateMu.Unlock()
+
+	for i, sub := range rt.subscribers {
+		if sub.id == s {
+			rt.subscribers = append(rt.subscribers[:i], rt.subscribers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// apply makes the changes on a copy of the routes and, if they all succeed,
+// installs it and tells the listeners and done, if not nil, of the events.
+func (rt *RoutingTable) apply(changes []RouteChange, done func([]RouteEvent)) error {
+	rt.updateMu.Lock()
+	defer rt.updateMu.Unlock()
+
+	rt.mu.RLock()
+	routes := append([]*Route(nil), rt.routes...)
+	defaultGateway := rt.defaultGateway
+	rt.mu.RUnlock()
+
+	events := make([]RouteEvent, 0, len(changes))
+	for _, change := range changes {
+		route := change.Route
+		if route == nil {
+			return fmt.Errorf("route is nil")
+		}
+		event := RouteEvent{Op: change.Op, Route: route}
+		i := findRoute(routes, route.Destination, route.Netmask)
+		if change.Op != RouteRemove && route.NextHops != nil {
+			route.NextHops.setDSCP(route.DSCP)
+		}
+
+		switch change.Op {
+		case RouteAdd:
+			routes = append(routes, route)
+		case RouteReplace:
+			if i < 0 {
+				routes = append(routes, route)
+				break
+			}
+			event.Old = routes[i]
+			routes[i] = route
+		case RouteRemove:
+			if i < 0 {
+				return fmt.Errorf("%w: %s/%s", ErrRouteNotFound, route.Destination, route.Netmask)
+			}
+			event.Route = routes[i]
+			routes = append(routes[:i], routes[i+1:]...)
+		default:
+			return fmt.Errorf("invalid route operation: %s", change.Op)
+		}
+
+		// The default gateway is the last default route added, if it is
+		// still there
+		switch {
+		case change.Op == RouteRemove && event.Route == defaultGateway:
+			defaultGateway = nil
+		case change.Op != RouteRemove && isDefaultRoute(route):
+			defaultGateway = route
+		}
+		events = append(events, event)
+	}
+
+	rt.mu.Lock()
+	rt.routes = routes
+	rt.defaultGateway = defaultGateway
+	rt.redirects = nil // Made for the old routes
+	rt.mu.Unlock()
+
+	// Still holding updateMu, so that listeners see changes in order
+	for _, event := range events {
+		for _, sub := range rt.subscribers {
+			sub.fn(event)
+		}
+	}
+	if done != nil {
+		done(events)
+	}
+	return nil
+}
+
+// findRoute returns the index of the first route to a network, or -1.
+func findRoute(routes []*Route, destination, netmask common.IPv4Address) int {
+	for i, route := range routes {
+		if route.Destination == destination && route.Netmask == netmask {
+			return i
+		}
+	}
+	return -1
+}
+
+// isDefaultRoute reports whether a route is a default route (0.0.0.0/0).
+func isDefaultRoute(route *Route) bool {
+	return route.Destination == (common.IPv4Address{0, 0, 0, 0}) &&
+		route.Netmask == (common.IPv4Address{0, 0, 0, 0})
+}
@@ -0,0 +1,249 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// ErrNoSourceAddress is returned when no local address can be the source
+// of packets to a destination.
+var ErrNoSourceAddress = errors.New("no source address")
+
+// Scope is how far an IPv4 address reaches, as Linux's address scopes:
+// a packet from an address goes no further than the address's scope.
+// Narrower scopes are lower.
+type Scope int
+
+const (
+	ScopeHost   Scope = iota // 127.0.0.0/8, within the host
+	ScopeLink                // 169.254.0.0/16, on the link
+	ScopeGlobal              // Everything else
+)
+
+// String returns the name Linux gives the scope.
+func (s Scope) String() string {
+	switch s {
+	case ScopeHost:
+		return "host"
+	case ScopeLink:
+		return "link"
+	default:
+		return "global"
+	}
+}
+
+// AddressScope returns the scope of an address.
+func AddressScope(addr common.IPv4Address) Scope {
+	switch {
+	case addr[0] == 127:
+		return ScopeHost
+	case addr[0] == 169 && addr[1] == 254:
+		return ScopeLink
+	default:
+		return ScopeGlobal
+	}
+}
+
+// InterfaceAddress is an address of a local interface, on the subnet of
+// its prefix.
+type InterfaceAddress struct {
+	Interface string
+	Address   common.IPv4Address
+	PrefixLen int
+}
+
+// Contains returns whether ip is on the address's subnet.
+func (a InterfaceAddress) Contains(ip common.IPv4Address) bool {
+	return commonPrefixLen(a.Address, ip) >= a.PrefixLen
+}
+
+// String returns the address in CIDR notation, with its interface.
+func (a InterfaceAddress) String() string {
+	return fmt.Sprintf("%s/%d dev %s", a.Address, a.PrefixLen, a.Interface)
+}
+
+// AddAddress adds an address to a local interface. An interface can have
+// several; the first is the one GetLocalInterface returns, and is
+// preferred as source address over those added later that are no better
+// a match (see SelectSource). Adding an address the interface has
+// changes its prefix.
+func (rt *RoutingTable) AddAddress(addr InterfaceAddress) error {
+	if addr.Interface == "" {
+		return fmt.Errorf("address %s has no interface", addr.Address)
+	}
+	if addr.PrefixLen < 0 || addr.PrefixLen > 32 {
+		return fmt.Errorf("invalid prefix length %d for %s", addr.PrefixLen, addr.Address)
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if i := rt.addressIndex(addr.Interface, addr.Address); i >= 0 {
+		rt.addresses[i] = addr
+		return nil
+	}
+	rt.addresses = append(rt.addresses, addr)
+	if _, ok := rt.localInterfaces[addr.Interface]; !ok {
+		rt.localInterfaces[addr.Interface] = addr.Address
+	}
+	return nil
+}
+
+// RemoveAddress removes an address from a local interface, and returns
+// whether the interface had it. If it was the interface's first address,
+// the next takes its place; the interface is unregistered with its last.
+func (rt *RoutingTable) RemoveAddress(iface string, addr common.IPv4Address) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	i := rt.addressIndex(iface, addr)
+	if i < 0 {
+		return false
+	}
+	rt.addresses = slices.Delete(rt.addresses, i, i+1)
+	if rt.localInterfaces[iface] == addr {
+		delete(rt.localInterfaces, iface)
+		for _, a := range rt.addresses {
+			if a.Interface == iface {
+				rt.localInterfaces[iface] = a.Address
+				break
+			}
+		}
+	}
+	return true
+}
+
+// Addresses returns the addresses of a local interface, in the order
+// added, or of every interface if iface is empty. An interface registered
+// with AddLocalInterface alone has its address as a /32.
+func (rt *RoutingTable) Addresses(iface string) []InterfaceAddress {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	var addrs []InterfaceAddress
+	for _, a := range rt.candidates() {
+		if iface == "" || a.Interface == iface {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// LookupDevice is Lookup among the routes out of an interface, for
+// sockets bound to it; with device empty it is Lookup.
+func (rt *RoutingTable) LookupDevice(dst common.IPv4Address, device string) (*Route, common.IPv4Address, error) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.lookupFlow(FlowKey{Destination: dst}, device)
+}
+
+// SelectSource returns the source address of packets to dst, sent out of
+// device or, if device is empty, wherever the routing table sends them.
+// It is, in order:
+//
+//   - dst itself if it is a local address;
+//   - the Source of the route to dst, if it is a local address;
+//   - an address of the route's interface, the best by the rules below;
+//   - failing one, the best address of any interface.
+//
+// Addresses are ranked, as in RFC 6724 Section 5 and as Linux does, by
+//
+//  1. scope: one that reaches dst, the narrowest that does;
+//  2. subnet: one on the subnet of the next hop;
+//  3. the longest prefix in common with dst;
+//  4. the order they were added.
+//
+// Loopback addresses are never the source of packets leaving the host,
+// and link-local ones only if no address reaches further.
+func (rt *RoutingTable) SelectSource(dst common.IPv4Address, device string) (common.IPv4Address, error) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	if rt.isLocal(dst) {
+		return dst, nil
+	}
+	route, nextHop, err := rt.lookupFlow(FlowKey{Destination: dst}, device)
+	if err != nil {
+		return common.IPv4Address{}, err
+	}
+	if route.Source != (common.IPv4Address{}) && rt.isLocal(route.Source) {
+		return route.Source, nil
+	}
+
+	candidates := rt.candidates()
+	if best, ok := bestSource(candidates, dst, nextHop, route.Interface); ok {
+		return best.Address, nil
+	}
+	if best, ok := bestSource(candidates, dst, nextHop, ""); ok {
+		return best.Address, nil
+	}
+	return common.IPv4Address{}, fmt.Errorf("%w: %s dev %s", ErrNoSourceAddress, dst, route.Interface)
+}
+
+// candidates returns the addresses of every interface: those added with
+// AddAddress, and those of interfaces registered with AddLocalInterface
+// and not added. Called with rt.mu held.
+func (rt *RoutingTable) candidates() []InterfaceAddress {
+	addrs := slices.Clone(rt.addresses)
+	for iface, ip := range rt.localInterfaces {
+		if rt.addressIndex(iface, ip) < 0 {
+			addrs = append(addrs, InterfaceAddress{Interface: iface, Address: ip, PrefixLen: 32})
+		}
+	}
+	return addrs
+}
+
+// addressIndex returns the index of an interface's address in
+// rt.addresses, or -1. Called with rt.mu held.
+func (rt *RoutingTable) addressIndex(iface string, addr common.IPv4Address) int {
+	return slices.IndexFunc(rt.addresses, func(a InterfaceAddress) bool {
+		return a.Interface == iface && a.Address == addr
+	})
+}
+
+// bestSource returns the best source address to dst among the addresses
+// of iface, or of all interfaces if iface is empty, ranked as SelectSource
+// says.
+func bestSource(addrs []InterfaceAddress, dst, nextHop common.IPv4Address, iface string) (InterfaceAddress, bool) {
+	scope := AddressScope(dst)
+	var best InterfaceAddress
+	found := false
+	for _, a := range addrs {
+		if iface != "" && a.Interface != iface {
+			continue
+		}
+		if s := AddressScope(a.Address); s == ScopeHost && scope != ScopeHost {
+			continue
+		}
+		if !found || betterSource(a, best, dst, nextHop, scope) {
+			best, found = a, true
+		}
+	}
+	return best, found
+}
+
+// betterSource returns whether a is a better source address to dst than
+// b, which comes before it.
+func betterSource(a, b InterfaceAddress, dst, nextHop common.IPv4Address, scope Scope) bool {
+	// Rule 2: prefer an address reaching dst, the narrowest of them
+	if sa, sb := AddressScope(a.Address), AddressScope(b.Address); sa != sb {
+		if sa < scope || sb < scope {
+			return sa > sb
+		}
+		return sa < sb
+	}
+
+	// Prefer an address on the next hop's subnet
+	if ca, cb := a.Contains(nextHop), b.Contains(nextHop); ca != cb {
+		return ca
+	}
+
+	// Rule 8: prefer the longest matching prefix
+	return commonPrefixLen(a.Address, dst) > commonPrefixLen(b.Address, dst)
+}
+
+// commonPrefixLen returns the number of leading bits a and b share.
+func commonPrefixLen(a, b common.IPv4Address) int {
+	return bits.LeadingZeros32(ipToUint32(a) ^ ipToUint32(b))
+}
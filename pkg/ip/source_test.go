@@ -0,0 +1,132 @@
+package ip
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// multihomedTable returns the routing table of a host on two networks,
+// eth0 with the default route, plus an unnumbered link and loopback.
+func multihomedTable(t *testing.T) *RoutingTable {
+	t.Helper()
+	rt := NewRoutingTable()
+	for _, addr := range []InterfaceAddress{
+		{"eth0", common.IPv4Address{192, 168, 1, 10}, 24},
+		{"eth0", common.IPv4Address{192, 168, 1, 20}, 24},
+		{"eth0", common.IPv4Address{169, 254, 3, 4}, 16},
+		{"eth1", common.IPv4Address{10, 0, 0, 5}, 8},
+		{"lo", common.IPv4Address{127, 0, 0, 1}, 8},
+	} {
+		if err := rt.AddAddress(addr); err != nil {
+			t.Fatalf("AddAddress() error = %v", err)
+		}
+	}
+	for _, route := range []*Route{
+		{Destination: common.IPv4Address{192, 168, 1, 0}, Netmask: common.IPv4Address{255, 255, 255, 0}, Interface: "eth0"},
+		{Destination: common.IPv4Address{169, 254, 0, 0}, Netmask: common.IPv4Address{255, 255, 0, 0}, Interface: "eth0"},
+		{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: common.IPv4Address{255, 0, 0, 0}, Interface: "eth1"},
+		{Destination: common.IPv4Address{127, 0, 0, 0}, Netmask: common.IPv4Address{255, 0, 0, 0}, Interface: "lo"},
+		{Destination: common.IPv4Address{198, 51, 100, 0}, Netmask: common.IPv4Address{255, 255, 255, 0}, Interface: "eth3"},
+		{Destination: common.IPv4Address{203, 0, 113, 0}, Netmask: common.IPv4Address{255, 255, 255, 0}, Gateway: common.IPv4Address{192, 168, 1, 1}, Interface: "eth0", Source: common.IPv4Address{10, 0, 0, 5}},
+		{Gateway: common.IPv4Address{192, 168, 1, 1}, Interface: "eth0"},
+		{Gateway: common.IPv4Address{10, 0, 0, 1}, Interface: "eth1", Metric: 10},
+	} {
+		if err := rt.AddRoute(route); err != nil {
+			t.Fatalf("AddRoute() error = %v", err)
+		}
+	}
+	return rt
+}
+
+func TestRoutingTable_SelectSource(t *testing.T) {
+	rt := multihomedTable(t)
+
+	tests := []struct {
+		name   string
+		dst    common.IPv4Address
+		device string
+		want   common.IPv4Address
+	}{
+		{"default route", common.IPv4Address{8, 8, 8, 8}, "", common.IPv4Address{192, 168, 1, 10}},
+		{"bound to device", common.IPv4Address{8, 8, 8, 8}, "eth1", common.IPv4Address{10, 0, 0, 5}},
+		{"other network", common.IPv4Address{10, 9, 9, 9}, "", common.IPv4Address{10, 0, 0, 5}},
+		{"longest prefix", common.IPv4Address{192, 168, 1, 21}, "", common.IPv4Address{192, 168, 1, 20}},
+		{"link scope", common.IPv4Address{169, 254, 9, 9}, "", common.IPv4Address{169, 254, 3, 4}},
+		{"host scope", common.IPv4Address{127, 0, 0, 5}, "", common.IPv4Address{127, 0, 0, 1}},
+		{"route source", common.IPv4Address{203, 0, 113, 7}, "", common.IPv4Address{10, 0, 0, 5}},
+		{"unnumbered interface", common.IPv4Address{198, 51, 100, 1}, "", common.IPv4Address{192, 168, 1, 10}},
+		{"local destination", common.IPv4Address{192, 168, 1, 20}, "", common.IPv4Address{192, 168, 1, 20}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rt.SelectSource(tt.dst, tt.device)
+			if err != nil {
+				t.Fatalf("SelectSource() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("SelectSource(%s, %q) = %s, want %s", tt.dst, tt.device, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := rt.SelectSource(common.IPv4Address{8, 8, 8, 8}, "eth9"); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("SelectSource() on a device without routes error = %v, want %v", err, ErrNoRoute)
+	}
+}
+
+func TestRoutingTable_SelectSourceScope(t *testing.T) {
+	rt := NewRoutingTable()
+	rt.SetDefaultGateway(common.IPv4Address{169, 254, 0, 1}, "eth0")
+	rt.AddAddress(InterfaceAddress{"lo", common.IPv4Address{127, 0, 0, 1}, 8})
+
+	// Loopback addresses never leave the host
+	if _, err := rt.SelectSource(common.IPv4Address{8, 8, 8, 8}, ""); !errors.Is(err, ErrNoSourceAddress) {
+		t.Errorf("SelectSource() with only loopback error = %v, want %v", err, ErrNoSourceAddress)
+	}
+
+	// A link-local address does, without a better one
+	linkLocal := common.IPv4Address{169, 254, 3, 4}
+	rt.AddAddress(InterfaceAddress{"eth0", linkLocal, 16})
+	if got, err := rt.SelectSource(common.IPv4Address{8, 8, 8, 8}, ""); err != nil || got != linkLocal {
+		t.Errorf("SelectSource() = %s, %v, want %s", got, err, linkLocal)
+	}
+}
+
+func TestRoutingTable_Addresses(t *testing.T) {
+	rt := NewRoutingTable()
+	first, second := common.IPv4Address{10, 0, 0, 1}, common.IPv4Address{10, 0, 0, 2}
+	rt.AddAddress(InterfaceAddress{"eth0", first, 24})
+	rt.AddAddress(InterfaceAddress{"eth0", second, 24})
+	rt.AddLocalInterface("eth1", common.IPv4Address{172, 16, 0, 1})
+
+	if got, _ := rt.GetLocalInterface("eth0"); got != first {
+		t.Errorf("GetLocalInterface() = %s, want the first address %s", got, first)
+	}
+	if !rt.IsLocalAddress(second) {
+		t.Errorf("IsLocalAddress(%s) = false, want true", second)
+	}
+	if addrs := rt.Addresses(""); len(addrs) != 3 || addrs[2] != (InterfaceAddress{"eth1", common.IPv4Address{172, 16, 0, 1}, 32}) {
+		t.Errorf("Addresses() = %v, want eth0's two and eth1's as a /32", addrs)
+	}
+	if err := rt.AddAddress(InterfaceAddress{"eth0", first, 33}); err == nil {
+		t.Error("AddAddress() with prefix length 33 error = nil, want an error")
+	}
+
+	// The next address takes the place of the first, and the interface
+	// goes with the last
+	if !rt.RemoveAddress("eth0", first) {
+		t.Fatal("RemoveAddress() = false, want true")
+	}
+	if got, _ := rt.GetLocalInterface("eth0"); got != second {
+		t.Errorf("GetLocalInterface() = %s after removing the first address, want %s", got, second)
+	}
+	rt.RemoveAddress("eth0", second)
+	if _, ok := rt.GetLocalInterface("eth0"); ok {
+		t.Error("GetLocalInterface() found eth0 after removing its addresses")
+	}
+	if rt.RemoveAddress("eth0", second) {
+		t.Error("RemoveAddress() of a removed address = true, want false")
+	}
+}
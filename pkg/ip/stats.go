@@ -0,0 +1,56 @@
+package ip
+
+import "sync/atomic"
+
+// Stats holds IPv4 counters for the whole stack.
+type Stats struct {
+	Forwarded           uint64 // Packets whose TTL was decremented for forwarding
+	TTLExceeded         uint64 // Packets discarded because the TTL reached zero
+	ChecksumErrors      uint64 // Packets that failed header checksum verification
+	FragmentedPackets   uint64 // Packets split into fragments
+	FragmentsCreated    uint64 // Fragments produced by fragmentation
+	FragmentsReceived   uint64 // Fragments passed to reassembly
+	Reassembled         uint64 // Packets reassembled from fragments
+	ReassemblyTimeouts  uint64 // Incomplete packets discarded on timeout
+	ReassemblyOverlaps  uint64 // Incomplete packets discarded for overlapping fragments
+	ReassemblyErrors    uint64 // Invalid fragments, and packets discarded for them
+	ReassemblyEvictions uint64 // Incomplete packets discarded to stay within the memory budget
+	RedirectsAccepted   uint64 // ICMP Redirects applied to the routing table
+	RedirectsRejected   uint64 // ICMP Redirects refused by the policy or its checks
+}
+
+// counters are the package-wide counters behind GetStats.
+var counters struct {
+	forwarded           atomic.Uint64
+	ttlExceeded         atomic.Uint64
+	checksumErrors      atomic.Uint64
+	fragmentedPackets   atomic.Uint64
+	fragmentsCreated    atomic.Uint64
+	fragmentsReceived   atomic.Uint64
+	reassembled         atomic.Uint64
+	reassemblyTimeouts  atomic.Uint64
+	reassemblyOverlaps  atomic.Uint64
+	reassemblyErrors    atomic.Uint64
+	reassemblyEvictions atomic.Uint64
+	redirectsAccepted   atomic.Uint64
+	redirectsRejected   atomic.Uint64
+}
+
+// GetStats returns a snapshot of the IPv4 counters.
+func GetStats() Stats {
+	return Stats{
+		Forwarded:           counters.forwarded.Load(),
+		TTLExceeded:         counters.ttlExceeded.Load(),
+		ChecksumErrors:      counters.checksumErrors.Load(),
+		FragmentedPackets:   counters.fragmentedPackets.Load(),
+		FragmentsCreated:    counters.fragmentsCreated.Load(),
+		FragmentsReceived:   counters.fragmentsReceived.Load(),
+		Reassembled:         counters.reassembled.Load(),
+		ReassemblyTimeouts:  counters.reassemblyTimeouts.Load(),
+		ReassemblyOverlaps:  counters.reassemblyOverlaps.Load(),
+		ReassemblyErrors:    counters.reassemblyErrors.Load(),
+		ReassemblyEvictions: counters.reassemblyEvictions.Load(),
+		RedirectsAccepted:   counters.redirectsAccepted.Load(),
+		RedirectsRejected:   counters.redirectsRejected.Load(),
+	}
+}
@@ -0,0 +1,241 @@
+package ipsec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+)
+
+// Action is what a policy does with the packets it matches.
+type Action int
+
+const (
+	// Protect sends packets to the prefix over the policy's SA, and drops
+	// the packets from it that did not arrive over an SA.
+	Protect Action = iota
+
+	// Bypass lets packets through unprotected, as for a host within a
+	// protected prefix that does not speak IPsec.
+	Bypass
+
+	// Discard drops packets to and from the prefix.
+	Discard
+)
+
+// String returns the action name.
+func (a Action) String() string {
+	switch a {
+	case Protect:
+		return "protect"
+	case Bypass:
+		return "bypass"
+	case Discard:
+		return "discard"
+	default:
+		return fmt.Sprintf("Action(%d)", int(a))
+	}
+}
+
+// Policy is an entry of the security policy database: what to do with the
+// traffic exchanged with a prefix. Outbound packets match by destination,
+// inbound by source; the most specific policy applies, and traffic
+// matching none passes unprotected.
+type Policy struct {
+	Destination common.IPv4Address
+	Netmask     common.IPv4Address
+	Action      Action
+	SA          *SA // Outbound SA of a Protect policy
+}
+
+// matches reports whether addr is in the policy's prefix.
+func (p *Policy) matches(addr common.IPv4Address) bool {
+	mask := p.Netmask.ToUint32()
+	return addr.ToUint32()&mask == p.Destination.ToUint32()&mask
+}
+
+// Stats are the counters of an Engine.
+type Stats struct {
+	Encapsulated uint64 // Packets protected on output
+	Decapsulated uint64 // Packets accepted on input
+	PolicyDrops  uint64 // Packets dropped by a Discard policy, or received unprotected for a Protect one
+	Errors       uint64 // ESP packets dropped: unknown SPI, replayed, or failing their check
+}
+
+// Engine applies IPsec policies to the packets passing its hooks.
+type Engine struct {
+	mu       sync.RWMutex
+	policies []Policy       // Most specific first
+	inbound  map[uint32]*SA // By SPI
+
+	encapsulated atomic.Uint64
+	decapsulated atomic.Uint64
+	policyDrops  atomic.Uint64
+	errors       atomic.Uint64
+}
+
+// New creates an engine with no policies or SAs.
+func New() *Engine {
+	return &Engine{inbound: make(map[uint32]*SA)}
+}
+
+// AddPolicy adds a policy, replacing any for the same prefix.
+func (e *Engine) AddPolicy(p Policy) error {
+	if host := ^p.Netmask.ToUint32(); host&(host+1) != 0 {
+		return fmt.Errorf("netmask %s is not contiguous", p.Netmask)
+	}
+	if p.Action == Protect && p.SA == nil {
+		return fmt.Errorf("protect policy for %s has no SA", p.Destination)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.removePolicy(p.Destination, p.Netmask)
+	e.policies = append(e.policies, p)
+	sort.SliceStable(e.policies, func(i, j int) bool {
+		return bits.OnesCount32(e.policies[i].Netmask.ToUint32()) > bits.OnesCount32(e.policies[j].Netmask.ToUint32())
+	})
+	return nil
+}
+
+// RemovePolicy removes the policy for a prefix, and reports whether there
+// was one.
+func (e *Engine) RemovePolicy(destination, netmask common.IPv4Address) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.removePolicy(destination, netmask)
+}
+
+func (e *Engine) removePolicy(destination, netmask common.IPv4Address) bool {
+	for i, p := range e.policies {
+		if p.Destination == destination && p.Netmask == netmask {
+			e.policies = append(e.policies[:i], e.policies[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Policies returns a copy of the policies, most specific first.
+func (e *Engine) Policies() []Policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]Policy(nil), e.policies...)
+}
+
+// AddInboundSA adds an SA packets are received on.
+func (e *Engine) AddInboundSA(sa *SA) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.inbound[sa.SPI()]; ok {
+		return fmt.Errorf("SPI %d already in use", sa.SPI())
+	}
+	e.inbound[sa.SPI()] = sa
+	return nil
+}
+
+// RemoveInboundSA removes the inbound SA with an SPI, and reports whether
+// there was one.
+func (e *Engine) RemoveInboundSA(spi uint32) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.inbound[spi]; !ok {
+		return false
+	}
+	delete(e.inbound, spi)
+	return true
+}
+
+// Stats returns the engine's counters.
+func (e *Engine) Stats() Stats {
+	return Stats{
+		Encapsulated: e.encapsulated.Load(),
+		Decapsulated: e.decapsulated.Load(),
+		PolicyDrops:  e.policyDrops.Load(),
+		Errors:       e.errors.Load(),
+	}
+}
+
+// Output is a hook protecting the IPv4 packets passing its point by the
+// policy for their destination. It belongs at ip-tx, before fragmentation.
+func (e *Engine) Output(pkt *hook.Packet) hook.Verdict {
+	if pkt.IP == nil || pkt.IP.Protocol == common.ProtocolESP {
+		return hook.Continue
+	}
+	policy := e.lookup(pkt.IP.Destination)
+	if policy == nil || policy.Action == Bypass {
+		return hook.Continue
+	}
+	if policy.Action == Discard || pkt.IP.IsFragment() {
+		e.policyDrops.Add(1)
+		return hook.Drop
+	}
+
+	if err := policy.SA.Encapsulate(pkt.IP); err != nil {
+		e.errors.Add(1)
+		return hook.Drop
+	}
+	e.encapsulated.Add(1)
+	return hook.Continue
+}
+
+// Input is a hook decapsulating the ESP packets passing its point, and
+// enforcing the policy for the source of the others. It belongs at ip-rx,
+// after reassembly and ahead of the hooks looking at transport headers;
+// ESP fragments are left alone.
+func (e *Engine) Input(pkt *hook.Packet) hook.Verdict {
+	if pkt.IP == nil || pkt.IP.IsFragment() {
+		return hook.Continue
+	}
+
+	if pkt.IP.Protocol != common.ProtocolESP {
+		if policy := e.lookup(pkt.IP.Source); policy != nil && policy.Action != Bypass {
+			e.policyDrops.Add(1)
+			return hook.Drop
+		}
+		return hook.Continue
+	}
+
+	if len(pkt.IP.Payload) < 4 {
+		e.errors.Add(1)
+		return hook.Drop
+	}
+	e.mu.RLock()
+	sa := e.inbound[binary.BigEndian.Uint32(pkt.IP.Payload[0:4])]
+	e.mu.RUnlock()
+	if sa == nil || sa.Source() != pkt.IP.Source || sa.Destination() != pkt.IP.Destination {
+		e.errors.Add(1)
+		return hook.Drop
+	}
+	if err := sa.Decapsulate(pkt.IP); err != nil {
+		e.errors.Add(1)
+		return hook.Drop
+	}
+
+	// Protected traffic from a prefix whose policy is to discard it is
+	// still discarded
+	if policy := e.lookup(pkt.IP.Source); policy != nil && policy.Action == Discard {
+		e.policyDrops.Add(1)
+		return hook.Drop
+	}
+	e.decapsulated.Add(1)
+	return hook.Continue
+}
+
+// lookup returns the most specific policy matching addr.
+func (e *Engine) lookup(addr common.IPv4Address) *Policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for i := range e.policies {
+		if e.policies[i].matches(addr) {
+			p := e.policies[i]
+			return &p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package ipsec
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+var (
+	mask32 = common.IPv4Address{255, 255, 255, 255}
+	mask24 = common.IPv4Address{255, 255, 255, 0}
+)
+
+// testHost is a host whose pipeline runs an engine.
+type testHost struct {
+	engine   *Engine
+	pipeline *hook.Pipeline
+}
+
+func newTestHost(t *testing.T) *testHost {
+	t.Helper()
+	h := &testHost{engine: New(), pipeline: hook.NewPipeline()}
+	if _, err := h.pipeline.Register(hook.IPTx, 0, "ipsec-out", h.engine.Output); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := h.pipeline.Register(hook.IPRx, -1000, "ipsec-in", h.engine.Input); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	return h
+}
+
+// hostPair returns hostA and hostB, protecting their traffic with each
+// other.
+func hostPair(t *testing.T) (a, b *testHost) {
+	a, b = newTestHost(t), newTestHost(t)
+	ab := SAConfig{SPI: 0x1000, Source: hostA, Destination: hostB, Key: keyAB}
+	ba := SAConfig{SPI: 0x2000, Source: hostB, Destination: hostA, Key: keyBA}
+
+	for _, side := range []struct {
+		host    *testHost
+		out, in SAConfig
+		peer    common.IPv4Address
+	}{{a, ab, ba, hostB}, {b, ba, ab, hostA}} {
+		if err := side.host.engine.AddPolicy(Policy{Destination: side.peer, Netmask: mask32, SA: mustNewSA(t, side.out)}); err != nil {
+			t.Fatalf("AddPolicy() error = %v", err)
+		}
+		if err := side.host.engine.AddInboundSA(mustNewSA(t, side.in)); err != nil {
+			t.Fatalf("AddInboundSA() error = %v", err)
+		}
+	}
+	return a, b
+}
+
+// transfer sends pkt from one host to the other, and returns the packet
+// the receiver delivers, or nil if it is dropped.
+func transfer(t *testing.T, from, to *testHost, pkt *ip.Packet) *ip.Packet {
+	t.Helper()
+	if v := from.pipeline.Run(hook.IPTx, &hook.Packet{IP: pkt}); v != hook.Continue {
+		return nil
+	}
+	data, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	received, err := ip.Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if v := to.pipeline.Run(hook.IPRx, &hook.Packet{IP: received}); v != hook.Continue {
+		return nil
+	}
+	return received
+}
+
+func TestEngine(t *testing.T) {
+	a, b := hostPair(t)
+
+	request := ip.NewPacket(hostA, hostB, common.ProtocolUDP, []byte("request"))
+	got := transfer(t, a, b, request)
+	if request.Protocol != common.ProtocolESP {
+		t.Errorf("sent protocol %s, want ESP", request.Protocol)
+	}
+	if got == nil || got.Protocol != common.ProtocolUDP || string(got.Payload) != "request" {
+		t.Fatalf("b delivered %v, want the UDP request", got)
+	}
+
+	reply := transfer(t, b, a, ip.NewPacket(hostB, hostA, common.ProtocolUDP, []byte("reply")))
+	if reply == nil || string(reply.Payload) != "reply" {
+		t.Fatalf("a delivered %v, want the reply", reply)
+	}
+
+	// Traffic with other hosts is not protected
+	other := common.IPv4Address{10, 0, 0, 3}
+	pkt := ip.NewPacket(hostA, other, common.ProtocolUDP, []byte("plain"))
+	if v := a.pipeline.Run(hook.IPTx, &hook.Packet{IP: pkt}); v != hook.Continue || pkt.Protocol != common.ProtocolUDP {
+		t.Errorf("Run() = %s with protocol %s, want CONTINUE with UDP", v, pkt.Protocol)
+	}
+
+	for _, h := range []*testHost{a, b} {
+		if stats := h.engine.Stats(); stats.Encapsulated != 1 || stats.Decapsulated != 1 || stats.PolicyDrops != 0 || stats.Errors != 0 {
+			t.Errorf("Stats() = %+v, want 1 encapsulated and 1 decapsulated", stats)
+		}
+	}
+}
+
+func TestEngineInputPolicy(t *testing.T) {
+	a, b := hostPair(t)
+
+	// Unprotected traffic from a protected host is dropped
+	spoofed := ip.NewPacket(hostA, hostB, common.ProtocolUDP, []byte("spoofed"))
+	if v := b.pipeline.Run(hook.IPRx, &hook.Packet{IP: spoofed}); v != hook.Drop {
+		t.Errorf("Run() unprotected = %s, want DROP", v)
+	}
+
+	// So is ESP for an unknown SPI, or from the wrong host
+	pkt := ip.NewPacket(hostA, hostB, common.ProtocolUDP, []byte("data"))
+	if v := a.pipeline.Run(hook.IPTx, &hook.Packet{IP: pkt}); v != hook.Continue {
+		t.Fatalf("Run() = %s, want CONTINUE", v)
+	}
+	wrongHost := *pkt
+	wrongHost.Source = common.IPv4Address{10, 0, 0, 9}
+	if v := b.pipeline.Run(hook.IPRx, &hook.Packet{IP: &wrongHost}); v != hook.Drop {
+		t.Errorf("Run() from the wrong host = %s, want DROP", v)
+	}
+	unknown := *pkt
+	unknown.Payload = append([]byte(nil), pkt.Payload...)
+	unknown.Payload[3]++
+	if v := b.pipeline.Run(hook.IPRx, &hook.Packet{IP: &unknown}); v != hook.Drop {
+		t.Errorf("Run() with an unknown SPI = %s, want DROP", v)
+	}
+
+	if stats := b.engine.Stats(); stats.PolicyDrops != 1 || stats.Errors != 2 {
+		t.Errorf("Stats() = %+v, want 1 policy drop and 2 errors", stats)
+	}
+}
+
+func TestEnginePolicies(t *testing.T) {
+	e := New()
+	sa := mustNewSA(t, SAConfig{SPI: 0x1000, Source: hostA, Destination: hostB, Key: keyAB})
+	policies := []Policy{
+		{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: mask24, SA: sa},
+		{Destination: common.IPv4Address{10, 0, 0, 5}, Netmask: mask32, Action: Bypass},
+		{Destination: common.IPv4Address{10, 0, 0, 6}, Netmask: mask32, Action: Discard},
+	}
+	for _, p := range policies {
+		if err := e.AddPolicy(p); err != nil {
+			t.Fatalf("AddPolicy() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		dst          common.IPv4Address
+		want         hook.Verdict
+		wantProtocol common.Protocol
+	}{
+		{common.IPv4Address{10, 0, 0, 2}, hook.Continue, common.ProtocolESP},
+		{common.IPv4Address{10, 0, 0, 5}, hook.Continue, common.ProtocolUDP},
+		{common.IPv4Address{10, 0, 0, 6}, hook.Drop, common.ProtocolUDP},
+		{common.IPv4Address{10, 0, 1, 2}, hook.Continue, common.ProtocolUDP},
+	}
+	for _, tt := range tests {
+		pkt := ip.NewPacket(hostA, tt.dst, common.ProtocolUDP, []byte("data"))
+		if v := e.Output(&hook.Packet{IP: pkt}); v != tt.want || pkt.Protocol != tt.wantProtocol {
+			t.Errorf("Output() to %s = %s with protocol %s, want %s with %s", tt.dst, v, pkt.Protocol, tt.want, tt.wantProtocol)
+		}
+	}
+
+	if got := e.Policies(); len(got) != 3 || got[2].Netmask != mask24 {
+		t.Errorf("Policies() = %+v, want the /24 last", got)
+	}
+	if !e.RemovePolicy(common.IPv4Address{10, 0, 0, 6}, mask32) || e.RemovePolicy(common.IPv4Address{10, 0, 0, 6}, mask32) {
+		t.Error("RemovePolicy() should succeed once")
+	}
+	if err := e.AddPolicy(Policy{Destination: hostB, Netmask: mask32}); err == nil {
+		t.Error("AddPolicy() protecting without an SA succeeded")
+	}
+	if err := e.AddPolicy(Policy{Netmask: common.IPv4Address{255, 0, 255, 0}, Action: Bypass}); err == nil {
+		t.Error("AddPolicy() with a non-contiguous netmask succeeded")
+	}
+	if err := e.AddInboundSA(sa); err != nil {
+		t.Fatalf("AddInboundSA() error = %v", err)
+	}
+	if err := e.AddInboundSA(sa); err == nil {
+		t.Error("AddInboundSA() with a duplicate SPI succeeded")
+	}
+}
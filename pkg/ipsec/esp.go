@@ -0,0 +1,314 @@
+// Package ipsec implements IPsec ESP (RFC 4303) in transport mode, with
+// manually keyed security associations.
+//
+// An SA protects the packets between two hosts with AES-GCM (RFC 4106).
+// An Engine holds the policies saying which destinations are protected and
+// by which SA, and the SAs packets are received on; its hooks apply them on
+// the stack's IP output and input paths:
+//
+//	p.Register(hook.IPTx, 0, "ipsec-out", engine.Output)
+//	p.Register(hook.IPRx, -1000, "ipsec-in", engine.Input)
+//
+// There is no key exchange: both ends are configured with the same SPIs
+// and keys, as with "ip xfrm state add".
+package ipsec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+// ESP packet format (RFC 4303 Section 2), following the IP header in
+// transport mode:
+// +---------------------------------------------+
+// | Security Parameters Index (4)               |
+// | Sequence Number (4)                         |
+// +---------------------------------------------+
+// | IV (8)                                      |
+// +---------------------------------------------+  ^
+// | Payload (variable)                          |  |
+// +----------------------+------------+---------+  | Encrypted
+// | Padding (0-3)        | Pad len (1)| Next (1)|  |
+// +----------------------+------------+---------+  v
+// | ICV (16)                                    |
+// +---------------------------------------------+
+//
+// With AES-GCM the nonce is a salt from the key material followed by the
+// IV, and the SPI and sequence number are authenticated as additional data.
+
+const (
+	// HeaderSize is the size of the ESP header and IV (16 bytes).
+	HeaderSize = 8 + ivSize
+
+	// ICVSize is the size of the integrity check value (16 bytes).
+	ICVSize = 16
+
+	// DefaultReplayWindow is the number of sequence numbers back from the
+	// highest received that are still accepted.
+	DefaultReplayWindow = 64
+
+	// ivSize is the size of the explicit IV.
+	ivSize = 8
+
+	// saltSize is the size of the salt at the end of the key material.
+	saltSize = 4
+
+	// trailerSize is the size of the pad length and next header fields.
+	trailerSize = 2
+)
+
+var (
+	// ErrReplay is returned for a packet whose sequence number was already
+	// received, or is too old to tell.
+	ErrReplay = errors.New("replayed packet")
+
+	// ErrAuthentication is returned for a packet that fails its integrity
+	// check.
+	ErrAuthentication = errors.New("integrity check failed")
+
+	// ErrSequenceOverflow is returned once an SA has used every sequence
+	// number. Without extended sequence numbers it cannot send again, and
+	// must be replaced.
+	ErrSequenceOverflow = errors.New("sequence number overflow")
+)
+
+// SAConfig configures a security association.
+type SAConfig struct {
+	SPI         uint32             // Security Parameters Index, identifying the SA to the receiver
+	Source      common.IPv4Address // Host sending on the SA
+	Destination common.IPv4Address // Host receiving on the SA
+
+	// Key is the AES key followed by a 4-byte salt: 20, 28 or 36 bytes
+	// for AES-128, AES-192 and AES-256.
+	Key []byte
+
+	// ReplayWindow is the size of the anti-replay window of an inbound SA,
+	// at most 64; 0 means DefaultReplayWindow, and -1 disables
+	// anti-replay.
+	ReplayWindow int
+}
+
+// SAStats are the counters of an SA.
+type SAStats struct {
+	Packets        uint64 // Packets protected or accepted
+	Bytes          uint64 // Payload bytes of the packets
+	ReplayErrors   uint64
+	AuthErrors     uint64
+	SequenceNumber uint32 // Last sent, or highest received
+}
+
+// SA is a security association: the keys and sequence numbers protecting
+// the packets one host sends another. An SA is used in one direction; two
+// hosts talking need one each way.
+type SA struct {
+	config SAConfig
+	aead   cipher.AEAD
+	salt   [saltSize]byte
+
+	mu     sync.Mutex
+	seq    uint32 // Last sequence number sent
+	replay replayWindow
+	stats  SAStats
+}
+
+// NewSA creates a security association.
+func NewSA(config SAConfig) (*SA, error) {
+	if config.SPI < 256 {
+		return nil, fmt.Errorf("reserved SPI: %d", config.SPI) // RFC 4303 Section 2.1
+	}
+	switch len(config.Key) {
+	case 16 + saltSize, 24 + saltSize, 32 + saltSize:
+	default:
+		return nil, fmt.Errorf("invalid key length: %d bytes (want 20, 28 or 36)", len(config.Key))
+	}
+	switch {
+	case config.ReplayWindow == 0:
+		config.ReplayWindow = DefaultReplayWindow
+	case config.ReplayWindow < -1 || config.ReplayWindow > 64:
+		return nil, fmt.Errorf("invalid replay window: %d (maximum 64)", config.ReplayWindow)
+	}
+
+	n := len(config.Key) - saltSize
+	block, err := aes.NewCipher(config.Key[:n])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	sa := &SA{aead: aead}
+	copy(sa.salt[:], config.Key[n:])
+	config.Key = nil // Only the cipher keeps it
+	sa.config = config
+	sa.replay.size = uint64(max(config.ReplayWindow, 0))
+	return sa, nil
+}
+
+// SPI returns the SA's Security Parameters Index.
+func (sa *SA) SPI() uint32 {
+	return sa.config.SPI
+}
+
+// Source returns the host sending on the SA.
+func (sa *SA) Source() common.IPv4Address {
+	return sa.config.Source
+}
+
+// Destination returns the host receiving on the SA.
+func (sa *SA) Destination() common.IPv4Address {
+	return sa.config.Destination
+}
+
+// Stats returns the SA's counters.
+func (sa *SA) Stats() SAStats {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	return sa.stats
+}
+
+// Encapsulate protects pkt in transport mode: its payload is replaced by
+// an ESP packet carrying it, and its protocol by ESP.
+func (sa *SA) Encapsulate(pkt *ip.Packet) error {
+	sa.mu.Lock()
+	if sa.seq == ^uint32(0) {
+		sa.mu.Unlock()
+		return ErrSequenceOverflow
+	}
+	sa.seq++
+	seq := sa.seq
+	sa.stats.Packets++
+	sa.stats.Bytes += uint64(len(pkt.Payload))
+	sa.stats.SequenceNumber = seq
+	sa.mu.Unlock()
+
+	// The padding aligns the trailer to 4 bytes, and counts up from 1
+	padLen := (4 - (len(pkt.Payload)+trailerSize)%4) % 4
+	plaintextLen := len(pkt.Payload) + padLen + trailerSize
+
+	data := make([]byte, HeaderSize, HeaderSize+plaintextLen+ICVSize)
+	binary.BigEndian.PutUint32(data[0:4], sa.config.SPI)
+	binary.BigEndian.PutUint32(data[4:8], seq)
+	binary.BigEndian.PutUint64(data[8:16], uint64(seq)) // A counter never repeats under one key
+
+	plaintext := make([]byte, plaintextLen)
+	n := copy(plaintext, pkt.Payload)
+	for i := 0; i < padLen; i++ {
+		plaintext[n+i] = byte(i + 1)
+	}
+	plaintext[plaintextLen-2] = byte(padLen)
+	plaintext[plaintextLen-1] = uint8(pkt.Protocol)
+
+	data = sa.aead.Seal(data, sa.nonce(data[8:16]), plaintext, data[0:8])
+	pkt.Payload = data
+	pkt.Protocol = common.ProtocolESP
+	pkt.TotalLength = uint16(int(pkt.IHL)*4 + len(data))
+	return nil
+}
+
+// Decapsulate verifies and decrypts the ESP payload of pkt, replacing it by
+// the payload it carries. The sequence number is checked against the
+// anti-replay window, which only authenticated packets move.
+func (sa *SA) Decapsulate(pkt *ip.Packet) error {
+	data := pkt.Payload
+	if len(data) < HeaderSize+trailerSize+ICVSize {
+		return fmt.Errorf("ESP packet too short: %d bytes", len(data))
+	}
+	if spi := binary.BigEndian.Uint32(data[0:4]); spi != sa.config.SPI {
+		return fmt.Errorf("SPI %d does not match SA %d", spi, sa.config.SPI)
+	}
+	seq := binary.BigEndian.Uint32(data[4:8])
+
+	sa.mu.Lock()
+	if !sa.replay.check(seq) {
+		sa.stats.ReplayErrors++
+		sa.mu.Unlock()
+		return ErrReplay
+	}
+	sa.mu.Unlock()
+
+	plaintext, err := sa.aead.Open(nil, sa.nonce(data[8:16]), data[HeaderSize:], data[0:8])
+	if err != nil {
+		sa.mu.Lock()
+		sa.stats.AuthErrors++
+		sa.mu.Unlock()
+		return ErrAuthentication
+	}
+	padLen := int(plaintext[len(plaintext)-2])
+	if padLen+trailerSize > len(plaintext) {
+		return fmt.Errorf("invalid pad length: %d", padLen)
+	}
+	payload := plaintext[:len(plaintext)-trailerSize-padLen]
+
+	sa.mu.Lock()
+	if !sa.replay.check(seq) { // Raced with another copy
+		sa.stats.ReplayErrors++
+		sa.mu.Unlock()
+		return ErrReplay
+	}
+	sa.replay.accept(seq)
+	sa.stats.Packets++
+	sa.stats.Bytes += uint64(len(payload))
+	sa.stats.SequenceNumber = sa.replay.highest
+	sa.mu.Unlock()
+
+	pkt.Protocol = common.Protocol(plaintext[len(plaintext)-1])
+	pkt.Payload = payload
+	pkt.TotalLength = uint16(int(pkt.IHL)*4 + len(payload))
+	return nil
+}
+
+// nonce returns the AES-GCM nonce of a packet: the salt followed by its IV.
+func (sa *SA) nonce(iv []byte) []byte {
+	nonce := make([]byte, saltSize+ivSize)
+	copy(nonce, sa.salt[:])
+	copy(nonce[saltSize:], iv)
+	return nonce
+}
+
+// replayWindow is the anti-replay window of an inbound SA (RFC 4303
+// Section 3.4.3).
+type replayWindow struct {
+	size    uint64 // 0 disables the check
+	highest uint32 // Highest sequence number accepted
+	bitmap  uint64 // Bit i set if highest-i was accepted
+}
+
+// check reports whether seq is new and within the window.
+func (w *replayWindow) check(seq uint32) bool {
+	switch {
+	case w.size == 0:
+		return true
+	case seq == 0:
+		return false // The first packet is numbered 1
+	case seq > w.highest:
+		return true
+	case uint64(w.highest-seq) >= w.size:
+		return false
+	default:
+		return w.bitmap&(1<<(w.highest-seq)) == 0
+	}
+}
+
+// accept records seq as received.
+func (w *replayWindow) accept(seq uint32) {
+	if seq > w.highest {
+		if shift := seq - w.highest; shift >= 64 {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= shift
+		}
+		w.bitmap |= 1
+		w.highest = seq
+		return
+	}
+	w.bitmap |= 1 << (w.highest - seq)
+}
@@ -0,0 +1,176 @@
+package ipsec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+var (
+	hostA = common.IPv4Address{10, 0, 0, 1}
+	hostB = common.IPv4Address{10, 0, 0, 2}
+	keyAB = bytes.Repeat([]byte{0x11}, 20)
+	keyBA = bytes.Repeat([]byte{0x22}, 36)
+)
+
+func mustNewSA(t *testing.T, config SAConfig) *SA {
+	t.Helper()
+	sa, err := NewSA(config)
+	if err != nil {
+		t.Fatalf("NewSA() error = %v", err)
+	}
+	return sa
+}
+
+// saPair returns the two ends of an SA from hostA to hostB.
+func saPair(t *testing.T, window int) (out, in *SA) {
+	config := SAConfig{SPI: 0x1000, Source: hostA, Destination: hostB, Key: keyAB, ReplayWindow: window}
+	return mustNewSA(t, config), mustNewSA(t, config)
+}
+
+func TestSAEncapsulate(t *testing.T) {
+	for _, size := range []int{0, 1, 2, 3, 4, 100} {
+		out, in := saPair(t, 0)
+		payload := bytes.Repeat([]byte{0xab}, size)
+		pkt := ip.NewPacket(hostA, hostB, common.ProtocolUDP, payload)
+
+		if err := out.Encapsulate(pkt); err != nil {
+			t.Fatalf("Encapsulate() error = %v", err)
+		}
+		if pkt.Protocol != common.ProtocolESP {
+			t.Errorf("Protocol = %s, want ESP", pkt.Protocol)
+		}
+		if n := len(pkt.Payload) - HeaderSize - ICVSize; n%4 != 0 || n < size+trailerSize {
+			t.Errorf("%d bytes encrypted for a %d byte payload, want it and the trailer aligned to 4", n, size)
+		}
+		if bytes.Contains(pkt.Payload, payload) && size > 4 {
+			t.Error("payload sent in the clear")
+		}
+
+		// Over the wire and back
+		data, err := pkt.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		received, err := ip.Parse(data)
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if err := in.Decapsulate(received); err != nil {
+			t.Fatalf("Decapsulate() error = %v", err)
+		}
+		if received.Protocol != common.ProtocolUDP || !bytes.Equal(received.Payload, payload) {
+			t.Errorf("decapsulated %s %x, want UDP %x", received.Protocol, received.Payload, payload)
+		}
+	}
+}
+
+func TestSADecapsulateInvalid(t *testing.T) {
+	out, in := saPair(t, 0)
+	other := mustNewSA(t, SAConfig{SPI: 0x1000, Source: hostA, Destination: hostB, Key: keyBA})
+
+	protect := func(sa *SA) *ip.Packet {
+		pkt := ip.NewPacket(hostA, hostB, common.ProtocolTCP, []byte("segment"))
+		if err := sa.Encapsulate(pkt); err != nil {
+			t.Fatalf("Encapsulate() error = %v", err)
+		}
+		return pkt
+	}
+
+	tampered := protect(out)
+	tampered.Payload[HeaderSize] ^= 1
+	if err := in.Decapsulate(tampered); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Decapsulate() tampered error = %v, want %v", err, ErrAuthentication)
+	}
+	if err := in.Decapsulate(protect(other)); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Decapsulate() with another key error = %v, want %v", err, ErrAuthentication)
+	}
+
+	// The forgeries did not move the window
+	pkt := protect(out)
+	replay := *pkt
+	replay.Payload = append([]byte(nil), pkt.Payload...)
+	if err := in.Decapsulate(pkt); err != nil {
+		t.Fatalf("Decapsulate() error = %v", err)
+	}
+	if err := in.Decapsulate(&replay); !errors.Is(err, ErrReplay) {
+		t.Errorf("Decapsulate() replay error = %v, want %v", err, ErrReplay)
+	}
+	if stats := in.Stats(); stats.Packets != 1 || stats.AuthErrors != 2 || stats.ReplayErrors != 1 {
+		t.Errorf("Stats() = %+v, want 1 packet, 2 authentication errors and 1 replay", stats)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	w := replayWindow{size: 32}
+	steps := []struct {
+		seq  uint32
+		want bool
+	}{
+		{0, false}, // Never sent
+		{1, true},
+		{1, false},
+		{3, true},
+		{2, true},
+		{40, true},
+		{9, true},
+		{8, false}, // Left the window
+		{9, false},
+		{1000, true},
+		{999, true},
+		{40, false},
+	}
+	for i, step := range steps {
+		got := w.check(step.seq)
+		if got != step.want {
+			t.Fatalf("step %d: check(%d) = %v, want %v", i, step.seq, got, step.want)
+		}
+		if got {
+			w.accept(step.seq)
+		}
+	}
+
+	disabled := replayWindow{}
+	disabled.accept(5)
+	if !disabled.check(5) {
+		t.Error("check() with anti-replay disabled = false, want true")
+	}
+}
+
+func TestSASequenceOverflow(t *testing.T) {
+	out, _ := saPair(t, 0)
+	out.seq = ^uint32(0) - 1
+	pkt := ip.NewPacket(hostA, hostB, common.ProtocolUDP, nil)
+	if err := out.Encapsulate(pkt); err != nil {
+		t.Fatalf("Encapsulate() of the last sequence number error = %v", err)
+	}
+	if err := out.Encapsulate(ip.NewPacket(hostA, hostB, common.ProtocolUDP, nil)); !errors.Is(err, ErrSequenceOverflow) {
+		t.Errorf("Encapsulate() error = %v, want %v", err, ErrSequenceOverflow)
+	}
+}
+
+func TestNewSA(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SAConfig
+		wantErr bool
+	}{
+		{"AES-128", SAConfig{SPI: 256, Key: make([]byte, 20)}, false},
+		{"AES-192", SAConfig{SPI: 256, Key: make([]byte, 28)}, false},
+		{"AES-256", SAConfig{SPI: 256, Key: make([]byte, 36)}, false},
+		{"no salt", SAConfig{SPI: 256, Key: make([]byte, 16)}, true},
+		{"reserved SPI", SAConfig{SPI: 255, Key: make([]byte, 20)}, true},
+		{"window too large", SAConfig{SPI: 256, Key: make([]byte, 20), ReplayWindow: 65}, true},
+		{"anti-replay disabled", SAConfig{SPI: 256, Key: make([]byte, 20), ReplayWindow: -1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSA(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("NewSA() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
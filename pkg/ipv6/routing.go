@@ -0,0 +1,173 @@
+package ipv6
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// ErrNoRoute is returned when a lookup finds no route to a destination.
+var ErrNoRoute = errors.New("no route to host")
+
+// Route represents an IPv6 routing table entry.
+type Route struct {
+	Destination  common.IPv6Address // Destination prefix
+	PrefixLength int                // Length of the prefix, 0 for the default route
+	Gateway      common.IPv6Address // Next hop router (:: for on-link)
+	Interface    string             // Network interface name
+	Metric       int                // Route metric (lower is better)
+}
+
+// Matches reports whether addr is within the route's destination prefix.
+func (r *Route) Matches(addr common.IPv6Address) bool {
+	return PrefixMatch(addr, r.Destination, r.PrefixLength)
+}
+
+// String returns the route as ip -6 route shows it.
+func (r *Route) String() string {
+	s := fmt.Sprintf("%s/%d", r.Destination, r.PrefixLength)
+	if r.PrefixLength == 0 {
+		s = "default"
+	}
+	if !r.Gateway.IsUnspecified() {
+		s += " via " + r.Gateway.String()
+	}
+	if r.Interface != "" {
+		s += " dev " + r.Interface
+	}
+	return s + fmt.Sprintf(" metric %d", r.Metric)
+}
+
+// PrefixMatch reports whether the first length bits of a and b are equal.
+func PrefixMatch(a, b common.IPv6Address, length int) bool {
+	for i := 0; i < 16 && length > 0; i, length = i+1, length-8 {
+		mask := byte(0xff)
+		if length < 8 {
+			mask <<= 8 - length
+		}
+		if a[i]&mask != b[i]&mask {
+			return false
+		}
+	}
+	return true
+}
+
+// Mask returns addr with the bits after the first length cleared.
+func Mask(addr common.IPv6Address, length int) common.IPv6Address {
+	var m common.IPv6Address
+	for i := 0; i < 16 && length > 0; i, length = i+1, length-8 {
+		mask := byte(0xff)
+		if length < 8 {
+			mask <<= 8 - length
+		}
+		m[i] = addr[i] & mask
+	}
+	return m
+}
+
+// RoutingTable manages IPv6 routes. A route is identified by its
+// destination prefix and gateway, so a prefix may be routed through
+// several routers; lookups prefer the longest prefix, then the lowest
+// metric.
+type RoutingTable struct {
+	mu     sync.RWMutex
+	routes []*Route
+}
+
+// NewRoutingTable creates a new IPv6 routing table.
+func NewRoutingTable() *RoutingTable {
+	return &RoutingTable{}
+}
+
+// AddRoute adds a route, replacing any with the same destination prefix
+// and gateway.
+func (rt *RoutingTable) AddRoute(route *Route) error {
+	if route == nil {
+		return fmt.Errorf("route is nil")
+	}
+	if route.PrefixLength < 0 || route.PrefixLength > 128 {
+		return fmt.Errorf("invalid prefix length: %d", route.PrefixLength)
+	}
+	r := *route
+	r.Destination = Mask(r.Destination, r.PrefixLength)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if i := rt.find(r.Destination, r.PrefixLength, r.Gateway); i >= 0 {
+		rt.routes[i] = &r
+		return nil
+	}
+	rt.routes = append(rt.routes, &r)
+	return nil
+}
+
+// RemoveRoute removes the route to a prefix through a gateway (:: for the
+// on-link route), reporting whether there was one.
+func (rt *RoutingTable) RemoveRoute(destination common.IPv6Address, prefixLength int, gateway common.IPv6Address) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	i := rt.find(Mask(destination, prefixLength), prefixLength, gateway)
+	if i < 0 {
+		return false
+	}
+	rt.routes = append(rt.routes[:i], rt.routes[i+1:]...)
+	return true
+}
+
+func (rt *RoutingTable) find(destination common.IPv6Address, prefixLength int, gateway common.IPv6Address) int {
+	for i, r := range rt.routes {
+		if r.Destination == destination && r.PrefixLength == prefixLength && r.Gateway == gateway {
+			return i
+		}
+	}
+	return -1
+}
+
+// Lookup finds the best route for a destination, returning it and the
+// next hop: the gateway, or the destination itself if it is on-link.
+func (rt *RoutingTable) Lookup(dst common.IPv6Address) (*Route, common.IPv6Address, error) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	var best *Route
+	for _, r := range rt.routes {
+		if !r.Matches(dst) {
+			continue
+		}
+		if best == nil || r.PrefixLength > best.PrefixLength ||
+			(r.PrefixLength == best.PrefixLength && r.Metric < best.Metric) {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil, common.IPv6Address{}, fmt.Errorf("%w: %s", ErrNoRoute, dst)
+	}
+
+	nextHop := dst
+	if !best.Gateway.IsUnspecified() {
+		nextHop = best.Gateway
+	}
+	r := *best
+	return &r, nextHop, nil
+}
+
+// GetRoutes returns copies of the routes, the most specific first.
+func (rt *RoutingTable) GetRoutes() []Route {
+	rt.mu.RLock()
+	routes := make([]Route, len(rt.routes))
+	for i, r := range rt.routes {
+		routes[i] = *r
+	}
+	rt.mu.RUnlock()
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].PrefixLength != routes[j].PrefixLength {
+			return routes[i].PrefixLength > routes[j].PrefixLength
+		}
+		return routes[i].Metric < routes[j].Metric
+	})
+	return routes
+}
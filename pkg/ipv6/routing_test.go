@@ -0,0 +1,120 @@
+package ipv6
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func mustParseIPv6(t *testing.T, s string) common.IPv6Address {
+	t.Helper()
+	addr, err := common.ParseIPv6(s)
+	if err != nil {
+		t.Fatalf("ParseIPv6(%q) error = %v", s, err)
+	}
+	return addr
+}
+
+func TestPrefixMatch(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		length int
+		want   bool
+	}{
+		{"2001:db8::1", "2001:db8::", 64, true},
+		{"2001:db8:0:1::1", "2001:db8::", 64, false},
+		{"2001:db8:0:1::1", "2001:db8::", 48, true},
+		{"2001:db8::1", "2001:db9::", 31, true},
+		{"2001:db8::1", "2001:db9::", 32, false},
+		{"fe80::1", "::", 0, true},
+		{"2001:db8::1", "2001:db8::1", 128, true},
+		{"2001:db8::1", "2001:db8::2", 128, false},
+	}
+
+	for _, tt := range tests {
+		got := PrefixMatch(mustParseIPv6(t, tt.a), mustParseIPv6(t, tt.b), tt.length)
+		if got != tt.want {
+			t.Errorf("PrefixMatch(%s, %s, %d) = %v, want %v", tt.a, tt.b, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestRoutingTableLookup(t *testing.T) {
+	rt := NewRoutingTable()
+	routes := []*Route{
+		{Destination: mustParseIPv6(t, "2001:db8::5"), PrefixLength: 64, Interface: "eth0"},
+		{Gateway: mustParseIPv6(t, "fe80::1"), Interface: "eth0", Metric: 10},
+		{Gateway: mustParseIPv6(t, "fe80::2"), Interface: "eth0", Metric: 5},
+	}
+	for _, r := range routes {
+		if err := rt.AddRoute(r); err != nil {
+			t.Fatalf("AddRoute() error = %v", err)
+		}
+	}
+
+	// The prefix is stored masked
+	if got := rt.GetRoutes()[0].Destination; got != mustParseIPv6(t, "2001:db8::") {
+		t.Errorf("Destination = %s, want 2001:db8::", got)
+	}
+
+	tests := []struct {
+		dst     string
+		wantHop string
+	}{
+		{"2001:db8::42", "2001:db8::42"}, // On-link
+		{"2001:db8:1::42", "fe80::2"},    // Default router with the lower metric
+	}
+	for _, tt := range tests {
+		_, hop, err := rt.Lookup(mustParseIPv6(t, tt.dst))
+		if err != nil {
+			t.Fatalf("Lookup(%s) error = %v", tt.dst, err)
+		}
+		if hop != mustParseIPv6(t, tt.wantHop) {
+			t.Errorf("Lookup(%s) next hop = %s, want %s", tt.dst, hop, tt.wantHop)
+		}
+	}
+
+	// Replacing a route changes it in place
+	if err := rt.AddRoute(&Route{Gateway: mustParseIPv6(t, "fe80::2"), Interface: "eth0", Metric: 20}); err != nil {
+		t.Fatalf("AddRoute() error = %v", err)
+	}
+	if n := len(rt.GetRoutes()); n != 3 {
+		t.Errorf("len(GetRoutes()) = %d, want 3", n)
+	}
+	if _, hop, _ := rt.Lookup(mustParseIPv6(t, "2001:db8:1::42")); hop != mustParseIPv6(t, "fe80::1") {
+		t.Errorf("next hop after replace = %s, want fe80::1", hop)
+	}
+
+	if !rt.RemoveRoute(common.IPv6Address{}, 0, mustParseIPv6(t, "fe80::1")) {
+		t.Error("RemoveRoute() = false, want true")
+	}
+	if rt.RemoveRoute(common.IPv6Address{}, 0, mustParseIPv6(t, "fe80::1")) {
+		t.Error("second RemoveRoute() = true, want false")
+	}
+	rt.RemoveRoute(common.IPv6Address{}, 0, mustParseIPv6(t, "fe80::2"))
+	if _, _, err := rt.Lookup(mustParseIPv6(t, "2001:db8:1::42")); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("Lookup() error = %v, want ErrNoRoute", err)
+	}
+}
+
+func TestRoutingTableAddRouteInvalid(t *testing.T) {
+	rt := NewRoutingTable()
+	if err := rt.AddRoute(nil); err == nil {
+		t.Error("AddRoute(nil) succeeded, want error")
+	}
+	if err := rt.AddRoute(&Route{PrefixLength: 129}); err == nil {
+		t.Error("AddRoute() with prefix length 129 succeeded, want error")
+	}
+}
+
+func TestRouteString(t *testing.T) {
+	r := &Route{Gateway: mustParseIPv6(t, "fe80::1"), Interface: "eth0", Metric: 1024}
+	if got, want := r.String(), "default via fe80::1 dev eth0 metric 1024"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	r = &Route{Destination: mustParseIPv6(t, "2001:db8::"), PrefixLength: 64, Interface: "eth0"}
+	if got, want := r.String(), "2001:db8::/64 dev eth0 metric 0"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,420 @@
+// Package memory implements an in-process link for connecting two stack
+// instances without raw sockets.
+//
+// A link is a pair of Endpoints joined by two unidirectional pipes. Each pipe
+// can impair traffic with latency, jitter, random loss, reordering and a
+// bandwidth limit, in the spirit of Linux netem. All randomness comes from a
+// seeded source, so a given Config and traffic pattern always produce the same
+// drop and reorder decisions, which makes retransmission and congestion-control
+// behaviour reproducible in tests.
+//
+// Endpoints implement ethernet.Device for frame-level use, and also expose
+// WritePacket/ReadPacket for carrying raw IP packets or TCP segments.
+package memory
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// DefaultQueueSize is the number of packets a pipe can hold in flight
+// when Config.QueueSize is zero.
+const DefaultQueueSize = 1024
+
+// ErrClosed is returned by operations on a closed endpoint.
+var ErrClosed = errors.New("link closed")
+
+// Config describes the impairments applied to one direction of a link.
+type Config struct {
+	// Latency is the fixed one-way propagation delay.
+	Latency time.Duration
+
+	// Jitter is the maximum random delay added to Latency. Jitter alone can
+	// reorder packets, just like on a real network.
+	Jitter time.Duration
+
+	// LossRate is the probability (0.0-1.0) that a packet is dropped.
+	LossRate float64
+
+	// ReorderRate is the probability (0.0-1.0) that a packet is held back
+	// by ReorderDelay so that later packets overtake it.
+	ReorderRate float64
+
+	// ReorderDelay is the extra delay applied to reordered packets.
+	// Defaults to Latency + Jitter, or 1ms if both are zero.
+	ReorderDelay time.Duration
+
+	// Bandwidth limits throughput in bytes per second (0 = unlimited).
+	// Packets are serialized one after another at this rate.
+	Bandwidth int64
+
+	// QueueSize bounds the packets queued in the pipe; excess packets are
+	// tail-dropped (0 = DefaultQueueSize).
+	QueueSize int
+
+	// Seed seeds the random source used for jitter, loss and reordering.
+	Seed int64
+}
+
+// Stats holds per-direction counters.
+type Stats struct {
+	Sent      uint64 // Packets accepted for transmission
+	Delivered uint64 // Packets handed to the receiver
+	Lost      uint64 // Packets dropped by LossRate
+	Reordered uint64 // Packets held back by ReorderRate
+	Overflows uint64 // Packets tail-dropped because the queue was full
+}
+
+// pending is a packet waiting for its delivery time.
+type pending struct {
+	data    []byte
+	deliver time.Time
+	seq     uint64 // Tie-breaker preserving send order for equal times
+}
+
+// pendingHeap orders packets by delivery time.
+type pendingHeap []*pending
+
+func (h pendingHeap) Len() int { return len(h) }
+func (h pendingHeap) Less(i, j int) bool {
+	if h[i].deliver.Equal(h[j].deliver) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].deliver.Before(h[j].deliver)
+}
+func (h pendingHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *pendingHeap) Push(x interface{}) { *h = append(*h, x.(*pending)) }
+func (h *pendingHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	p := old[n-1]
+	*h = old[:n-1]
+	return p
+}
+
+// pipe is one direction of a link.
+type pipe struct {
+	mu       sync.Mutex
+	config   Config
+	rng      *rand.Rand
+	queue    pendingHeap
+	seq      uint64
+	nextFree time.Time // When the (bandwidth-limited) wire becomes idle
+	stats    Stats
+
+	wake   chan struct{}
+	out    chan []byte
+	done   chan struct{}
+	closed bool
+}
+
+// newPipe creates a pipe and starts its scheduler.
+func newPipe(config Config) *pipe {
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	p := &pipe{
+		config: config,
+		rng:    rand.New(rand.NewSource(config.Seed)),
+		wake:   make(chan struct{}, 1),
+		out:    make(chan []byte, queueSize),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// setConfig replaces the impairments. Packets already in flight keep their
+// delivery times.
+func (p *pipe) setConfig(config Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	reseed := config.Seed != p.config.Seed
+	config.QueueSize = p.config.QueueSize
+	p.config = config
+	if reseed {
+		p.rng = rand.New(rand.NewSource(config.Seed))
+	}
+}
+
+// send schedules a packet for delivery.
+func (p *pipe) send(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+
+	p.stats.Sent++
+	cfg := p.config
+
+	// Random loss
+	if cfg.LossRate > 0 && p.rng.Float64() < cfg.LossRate {
+		p.stats.Lost++
+		return nil
+	}
+
+	// Tail drop when the pipe is full
+	if len(p.queue)+len(p.out) >= cap(p.out) {
+		p.stats.Overflows++
+		return nil
+	}
+
+	now := time.Now()
+
+	// Bandwidth: packets leave the sender back to back at the configured rate
+	depart := now
+	if cfg.Bandwidth > 0 {
+		if p.nextFree.After(depart) {
+			depart = p.nextFree
+		}
+		depart = depart.Add(time.Duration(int64(len(data)) * int64(time.Second) / cfg.Bandwidth))
+		p.nextFree = depart
+	}
+
+	// Propagation delay plus jitter
+	deliver := depart.Add(cfg.Latency)
+	if cfg.Jitter > 0 {
+		deliver = deliver.Add(time.Duration(p.rng.Int63n(int64(cfg.Jitter) + 1)))
+	}
+
+	// Reordering: hold this packet back so later ones overtake it
+	if cfg.ReorderRate > 0 && p.rng.Float64() < cfg.ReorderRate {
+		delay := cfg.ReorderDelay
+		if delay == 0 {
+			delay = cfg.Latency + cfg.Jitter
+		}
+		if delay == 0 {
+			delay = time.Millisecond
+		}
+		deliver = deliver.Add(delay)
+		p.stats.Reordered++
+	}
+
+	// Copy so the caller may reuse its buffer
+	buf := make([]byte, len(data))
+	copy(buf, data)
+
+	p.seq++
+	heap.Push(&p.queue, &pending{data: buf, deliver: deliver, seq: p.seq})
+
+	// Nudge the scheduler in case this packet is now the earliest
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// run delivers queued packets when their time comes.
+func (p *pipe) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		for len(p.queue) > 0 && !p.queue[0].deliver.After(now) {
+			pkt := heap.Pop(&p.queue).(*pending)
+			select {
+			case p.out <- pkt.data:
+				p.stats.Delivered++
+			default:
+				p.stats.Overflows++
+			}
+		}
+
+		wait := time.Hour
+		if len(p.queue) > 0 {
+			wait = p.queue[0].deliver.Sub(now)
+		}
+		p.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-p.done:
+			return
+		case <-p.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// receive blocks until a packet is delivered or the pipe is closed.
+func (p *pipe) receive() ([]byte, error) {
+	select {
+	case data := <-p.out:
+		return data, nil
+	case <-p.done:
+		return nil, ErrClosed
+	}
+}
+
+// close stops the pipe and discards packets in flight.
+func (p *pipe) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+}
+
+// getStats returns a snapshot of the counters.
+func (p *pipe) getStats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Endpoint is one side of an in-memory link.
+type Endpoint struct {
+	name       string
+	index      int
+	macAddress common.MACAddress
+
+	tx *pipe // Traffic sent by this endpoint
+	rx *pipe // Traffic received by this endpoint
+
+	closeOnce sync.Once
+}
+
+// Compile-time check that Endpoint implements ethernet.Device.
+var _ ethernet.Device = (*Endpoint)(nil)
+
+// NewLink creates a pair of connected endpoints. Config a applies to traffic
+// sent from the first endpoint to the second, and b to the reverse direction.
+func NewLink(a, b Config) (*Endpoint, *Endpoint) {
+	ab := newPipe(a)
+	ba := newPipe(b)
+
+	epA := &Endpoint{
+		name:       "mem0",
+		index:      1,
+		macAddress: common.MACAddress{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		tx:         ab,
+		rx:         ba,
+	}
+	epB := &Endpoint{
+		name:       "mem1",
+		index:      2,
+		macAddress: common.MACAddress{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+		tx:         ba,
+		rx:         ab,
+	}
+	return epA, epB
+}
+
+// NewPipe creates a pair of connected endpoints with the same impairments in
+// both directions. The reverse direction uses Seed+1 so the two directions do
+// not make identical random decisions.
+func NewPipe(config Config) (*Endpoint, *Endpoint) {
+	reverse := config
+	reverse.Seed = config.Seed + 1
+	return NewLink(config, reverse)
+}
+
+// Name returns the endpoint name.
+func (e *Endpoint) Name() string {
+	return e.name
+}
+
+// SetName sets the endpoint name.
+func (e *Endpoint) SetName(name string) {
+	e.name = name
+}
+
+// MACAddress returns the endpoint's MAC address.
+func (e *Endpoint) MACAddress() common.MACAddress {
+	return e.macAddress
+}
+
+// SetMACAddress sets the endpoint's MAC address.
+func (e *Endpoint) SetMACAddress(mac common.MACAddress) {
+	e.macAddress = mac
+}
+
+// Index returns the endpoint's interface index.
+func (e *Endpoint) Index() int {
+	return e.index
+}
+
+// SetConfig changes the impairments applied to traffic sent by this endpoint.
+func (e *Endpoint) SetConfig(config Config) {
+	e.tx.setConfig(config)
+}
+
+// Stats returns the counters for traffic sent by this endpoint.
+func (e *Endpoint) Stats() Stats {
+	return e.tx.getStats()
+}
+
+// WritePacket sends raw bytes to the peer endpoint.
+func (e *Endpoint) WritePacket(data []byte) error {
+	return e.tx.send(data)
+}
+
+// ReadPacket blocks until raw bytes arrive from the peer endpoint.
+func (e *Endpoint) ReadPacket() ([]byte, error) {
+	return e.rx.receive()
+}
+
+// ReadPacketTimeout is like ReadPacket but gives up after timeout.
+func (e *Endpoint) ReadPacketTimeout(timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case data := <-e.rx.out:
+		return data, nil
+	case <-e.rx.done:
+		return nil, ErrClosed
+	case <-timer.C:
+		return nil, fmt.Errorf("timeout waiting for packet")
+	}
+}
+
+// WriteFrame sends an Ethernet frame to the peer endpoint.
+func (e *Endpoint) WriteFrame(frame *ethernet.Frame) error {
+	return e.WritePacket(frame.Serialize())
+}
+
+// ReadFrame blocks until an Ethernet frame arrives from the peer endpoint.
+func (e *Endpoint) ReadFrame() (*ethernet.Frame, error) {
+	data, err := e.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	return ethernet.Parse(data)
+}
+
+// Close shuts down both directions of the link. Pending reads on either
+// endpoint return ErrClosed.
+func (e *Endpoint) Close() error {
+	e.closeOnce.Do(func() {
+		e.tx.close()
+		e.rx.close()
+	})
+	return nil
+}
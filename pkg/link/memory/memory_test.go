@@ -0,0 +1,267 @@
+package memory
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+func TestEndpointRoundTrip(t *testing.T) {
+	a, b := NewPipe(Config{})
+	defer a.Close()
+
+	if err := a.WritePacket([]byte("hello")); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	if err := b.WritePacket([]byte("world")); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+
+	got, err := b.ReadPacketTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("ReadPacketTimeout() error = %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("b received %q, want %q", got, "hello")
+	}
+
+	got, err = a.ReadPacketTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("ReadPacketTimeout() error = %v", err)
+	}
+	if string(got) != "world" {
+		t.Errorf("a received %q, want %q", got, "world")
+	}
+}
+
+func TestEndpointFrames(t *testing.T) {
+	a, b := NewPipe(Config{})
+	defer a.Close()
+
+	payload := []byte("frame payload")
+	frame := ethernet.NewFrame(b.MACAddress(), a.MACAddress(), common.EtherTypeIPv4, payload)
+	if err := a.WriteFrame(frame); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+
+	got, err := b.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame() error = %v", err)
+	}
+	if got.Source != a.MACAddress() || got.Destination != b.MACAddress() {
+		t.Errorf("ReadFrame() = %s, want %s -> %s", got, a.MACAddress(), b.MACAddress())
+	}
+	if !bytes.HasPrefix(got.Payload, payload) {
+		t.Errorf("Payload = %x, want prefix %x", got.Payload, payload)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	latency := 30 * time.Millisecond
+	a, b := NewPipe(Config{Latency: latency})
+	defer a.Close()
+
+	start := time.Now()
+	a.WritePacket([]byte{1})
+	if _, err := b.ReadPacketTimeout(time.Second); err != nil {
+		t.Fatalf("ReadPacketTimeout() error = %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("packet delivered after %v, want >= %v", elapsed, latency)
+	}
+}
+
+func TestLossIsDeterministic(t *testing.T) {
+	run := func() []int {
+		a, b := NewPipe(Config{LossRate: 0.3, Seed: 42})
+		defer a.Close()
+
+		for i := 0; i < 100; i++ {
+			a.WritePacket([]byte{byte(i)})
+		}
+
+		var received []int
+		for {
+			data, err := b.ReadPacketTimeout(50 * time.Millisecond)
+			if err != nil {
+				break
+			}
+			received = append(received, int(data[0]))
+		}
+
+		stats := a.Stats()
+		if stats.Sent != 100 {
+			t.Errorf("Sent = %d, want 100", stats.Sent)
+		}
+		if int(stats.Lost)+len(received) != 100 {
+			t.Errorf("Lost (%d) + received (%d) != 100", stats.Lost, len(received))
+		}
+		return received
+	}
+
+	first := run()
+	second := run()
+
+	if len(first) == 0 || len(first) == 100 {
+		t.Fatalf("received %d of 100 packets, want some loss", len(first))
+	}
+	if len(first) != len(second) {
+		t.Fatalf("runs received %d and %d packets, want identical", len(first), len(second))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("runs differ at %d: %d vs %d", i, first[i], second[i])
+		}
+	}
+}
+
+func TestReorder(t *testing.T) {
+	a, b := NewPipe(Config{ReorderRate: 0.5, ReorderDelay: 20 * time.Millisecond, Seed: 7})
+	defer a.Close()
+
+	for i := 0; i < 20; i++ {
+		a.WritePacket([]byte{byte(i)})
+	}
+
+	var received []byte
+	for len(received) < 20 {
+		data, err := b.ReadPacketTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("ReadPacketTimeout() error = %v (received %d)", err, len(received))
+		}
+		received = append(received, data[0])
+	}
+
+	inOrder := true
+	for i := 1; i < len(received); i++ {
+		if received[i] < received[i-1] {
+			inOrder = false
+		}
+	}
+	if inOrder {
+		t.Errorf("received %v in order, want reordering", received)
+	}
+	if a.Stats().Reordered == 0 {
+		t.Error("Reordered = 0, want > 0")
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	// 10 packets of 1000 bytes at 100 KB/s take at least 100ms to serialize
+	a, b := NewPipe(Config{Bandwidth: 100000})
+	defer a.Close()
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		a.WritePacket(make([]byte, 1000))
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := b.ReadPacketTimeout(time.Second); err != nil {
+			t.Fatalf("ReadPacketTimeout() error = %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("transfer took %v, want >= 100ms", elapsed)
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+	a, b := NewPipe(Config{QueueSize: 4, Latency: 10 * time.Millisecond})
+	defer a.Close()
+
+	for i := 0; i < 10; i++ {
+		a.WritePacket([]byte{byte(i)})
+	}
+
+	if overflows := a.Stats().Overflows; overflows != 6 {
+		t.Errorf("Overflows = %d, want 6", overflows)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := b.ReadPacketTimeout(time.Second); err != nil {
+			t.Fatalf("ReadPacketTimeout() error = %v", err)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	a, b := NewPipe(Config{})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.ReadFrame()
+		done <- err
+	}()
+
+	a.Close()
+
+	select {
+	case err := <-done:
+		if err != ErrClosed {
+			t.Errorf("ReadFrame() error = %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadFrame() did not return after Close")
+	}
+
+	if err := b.WritePacket([]byte{1}); err != ErrClosed {
+		t.Errorf("WritePacket() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestTCPHandshakeOverLink(t *testing.T) {
+	clientIP := common.IPv4Address{10, 0, 0, 1}
+	serverIP := common.IPv4Address{10, 0, 0, 2}
+
+	a, b := NewPipe(Config{Latency: 2 * time.Millisecond, Jitter: time.Millisecond, Seed: 1})
+	defer a.Close()
+
+	client := tcp.NewSocket(clientIP, 50000)
+	server := tcp.NewSocket(serverIP, 80)
+
+	// Carry serialized segments across the link (checksums are set by the connection)
+	sendVia := func(ep *Endpoint) func(*tcp.Segment, common.IPv4Address, common.IPv4Address) error {
+		return func(seg *tcp.Segment, src, dst common.IPv4Address) error {
+			data, err := seg.Serialize()
+			if err != nil {
+				return err
+			}
+			return ep.WritePacket(data)
+		}
+	}
+	client.SetSendFunc(sendVia(a))
+	server.SetSendFunc(sendVia(b))
+
+	// Deliver segments arriving at each endpoint to its socket
+	deliver := func(ep *Endpoint, sock *tcp.Socket, src, dst common.IPv4Address) {
+		for {
+			data, err := ep.ReadPacket()
+			if err != nil {
+				return
+			}
+			seg, err := tcp.Parse(data)
+			if err != nil {
+				continue
+			}
+			sock.HandleIncomingSegment(seg, src, dst)
+		}
+	}
+	go deliver(a, client, serverIP, clientIP)
+	go deliver(b, server, clientIP, serverIP)
+
+	if err := server.Listen(1); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	if err := client.Connect(serverIP, 80); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if client.GetState() != tcp.StateEstablished {
+		t.Errorf("client state = %v, want %v", client.GetState(), tcp.StateEstablished)
+	}
+}
@@ -0,0 +1,146 @@
+package linkstate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// DefaultQueueLimit is the number of frames a gate holds while its link is
+// down when no limit is given, the default txqueuelen of a Linux Ethernet
+// device.
+const DefaultQueueLimit = 1000
+
+// ErrLinkDown is returned for frames a gate drops while its link is down.
+var ErrLinkDown = errors.New("link down")
+
+// Gate is a Device whose transmit pauses while its link is down, as the
+// kernel stops a device's queue when it loses its carrier. Frames written
+// meanwhile are held, up to the gate's limit, and sent in order when the
+// link comes back up; past the limit they are dropped with ErrLinkDown,
+// which TCP reports to the writer while it retransmits the data as after
+// any loss.
+type Gate struct {
+	ethernet.Device
+	limit int
+
+	// mu is held for reading while frames are sent with the link up, and
+	// for writing while they are held and the link changes state
+	mu      sync.RWMutex
+	down    bool
+	held    []*ethernet.Frame
+	dropped uint64
+}
+
+// NewGate returns a gate transmitting over dev, holding at most limit
+// frames while the link is down, or DefaultQueueLimit if limit is not
+// positive. The link starts up.
+func NewGate(dev ethernet.Device, limit int) *Gate {
+	if limit <= 0 {
+		limit = DefaultQueueLimit
+	}
+	return &Gate{Device: dev, limit: limit}
+}
+
+// Up returns whether the gate's link is up.
+func (g *Gate) Up() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return !g.down
+}
+
+// Held returns the number of frames waiting for the link to come up.
+func (g *Gate) Held() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.held)
+}
+
+// Dropped returns the number of frames dropped because the link was down:
+// those past the limit, and those that failed to go out once it came up.
+func (g *Gate) Dropped() uint64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.dropped
+}
+
+// SetUp sets whether the gate's link is up. Frames held while it was down
+// are sent when it comes up, before any written after.
+func (g *Gate) SetUp(up bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.down = !up
+	if !up || len(g.held) == 0 {
+		return
+	}
+	sent, _ := ethernet.WriteFrames(g.Device, g.held)
+	g.dropped += uint64(len(g.held) - sent)
+	g.held = nil
+}
+
+// WriteFrame sends a frame, or holds it while the link is down.
+func (g *Gate) WriteFrame(frame *ethernet.Frame) error {
+	g.mu.RLock()
+	if !g.down {
+		defer g.mu.RUnlock()
+		return g.Device.WriteFrame(frame)
+	}
+	g.mu.RUnlock()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.down {
+		return g.Device.WriteFrame(frame)
+	}
+	return g.hold(frame)
+}
+
+// WriteFrames sends frames in one batch if the device can, or holds them
+// while the link is down, and returns how many were sent or held.
+func (g *Gate) WriteFrames(frames []*ethernet.Frame) (int, error) {
+	g.mu.RLock()
+	if !g.down {
+		defer g.mu.RUnlock()
+		return ethernet.WriteFrames(g.Device, frames)
+	}
+	g.mu.RUnlock()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.down {
+		return ethernet.WriteFrames(g.Device, frames)
+	}
+	for i, frame := range frames {
+		if err := g.hold(frame); err != nil {
+			return i, err
+		}
+	}
+	return len(frames), nil
+}
+
+// hold keeps a copy of a frame until the link comes up, as the caller may
+// reuse it. Called with g.mu held for writing.
+func (g *Gate) hold(frame *ethernet.Frame) error {
+	if len(g.held) >= g.limit {
+		g.dropped++
+		return fmt.Errorf("%s: %w", g.Name(), ErrLinkDown)
+	}
+	g.held = append(g.held, &ethernet.Frame{
+		Destination: frame.Destination,
+		Source:      frame.Source,
+		VLAN:        slices.Clone(frame.VLAN),
+		EtherType:   frame.EtherType,
+		Payload:     bytes.Clone(frame.Payload),
+	})
+	return nil
+}
+
+// Compile-time checks that Gate is a Device that writes in batches.
+var (
+	_ ethernet.Device      = (*Gate)(nil)
+	_ ethernet.BatchWriter = (*Gate)(nil)
+)
@@ -0,0 +1,67 @@
+package linkstate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// testDevice records the frames written to it.
+type testDevice struct {
+	name    string
+	written []*ethernet.Frame
+}
+
+func (d *testDevice) Name() string                        { return d.name }
+func (d *testDevice) MACAddress() common.MACAddress       { return common.MACAddress{2, 0, 0, 0, 0, 1} }
+func (d *testDevice) Index() int                          { return 1 }
+func (d *testDevice) ReadFrame() (*ethernet.Frame, error) { return nil, errors.New("not implemented") }
+func (d *testDevice) Close() error                        { return nil }
+
+func (d *testDevice) WriteFrame(frame *ethernet.Frame) error {
+	d.written = append(d.written, frame)
+	return nil
+}
+
+// testFrame returns a frame whose payload is its number.
+func testFrame(n byte) *ethernet.Frame {
+	return &ethernet.Frame{EtherType: common.EtherTypeIPv4, Payload: []byte{n}}
+}
+
+func TestGate(t *testing.T) {
+	dev := &testDevice{name: "eth0"}
+	g := NewGate(dev, 2)
+	if err := g.WriteFrame(testFrame(1)); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+
+	// Frames wait while the link is down, up to the limit, and a frame
+	// reused after WriteFrame returns does not change the one held
+	g.SetUp(false)
+	reused := testFrame(2)
+	if err := g.WriteFrame(reused); err != nil {
+		t.Fatalf("WriteFrame() with the link down error = %v", err)
+	}
+	reused.Payload[0] = 9
+	if n, err := g.WriteFrames([]*ethernet.Frame{testFrame(3), testFrame(4)}); n != 1 || !errors.Is(err, ErrLinkDown) {
+		t.Errorf("WriteFrames() past the limit = %d, %v, want 1, %v", n, err, ErrLinkDown)
+	}
+	if len(dev.written) != 1 || g.Held() != 2 || g.Dropped() != 1 {
+		t.Errorf("with the link down: %d frames sent, %d held, %d dropped, want 1, 2 and 1", len(dev.written), g.Held(), g.Dropped())
+	}
+
+	// They go out in order when it comes back up
+	g.SetUp(true)
+	if err := g.WriteFrame(testFrame(5)); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	var got []byte
+	for _, frame := range dev.written {
+		got = append(got, frame.Payload[0])
+	}
+	if string(got) != string([]byte{1, 2, 3, 5}) || g.Held() != 0 {
+		t.Errorf("sent frames %v with %d held, want [1 2 3 5] with none", got, g.Held())
+	}
+}
@@ -0,0 +1,218 @@
+// Package linkstate tracks whether network interfaces are up and which
+// addresses they have, so the stack stops using state an interface going
+// down or changing address left stale.
+//
+// A Monitor is told of changes with Handle, by the netlink watcher
+// (netlink.WatcherConfig.OnLinkEvent) or by whatever else knows the state
+// of a device, such as a TAP or in-memory link. It pauses the transmit
+// of the Gates over an interface while the interface is down, flushes the
+// neighbor tables learned on it, such as its arp.Cache, when it goes down
+// or loses an address, and passes each change on to the callbacks
+// registered with OnChange.
+package linkstate
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+var logger = logging.New("linkstate")
+
+// EventType is the kind of change an Event reports.
+type EventType int
+
+const (
+	LinkUp         EventType = iota // The interface is up and has a carrier
+	LinkDown                        // The interface is down or lost its carrier
+	AddressAdded                    // The interface was given an address
+	AddressRemoved                  // The interface lost an address
+)
+
+// String returns the event type name.
+func (t EventType) String() string {
+	switch t {
+	case LinkUp:
+		return "up"
+	case LinkDown:
+		return "down"
+	case AddressAdded:
+		return "address added"
+	case AddressRemoved:
+		return "address removed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a change of an interface's state or addresses. An address
+// changing is reported as the old one removed and the new one added.
+type Event struct {
+	Type         EventType
+	Interface    string
+	Address      common.IPv4Address // For AddressAdded and AddressRemoved
+	PrefixLength int                // Of Address
+}
+
+// Neighbors is a table of neighbors learned on one interface, such as an
+// arp.Cache, flushed when what it learned may no longer hold.
+type Neighbors interface {
+	Clear()
+}
+
+// Monitor tracks the state of interfaces and applies its changes.
+type Monitor struct {
+	// handleMu orders changes, so gates, neighbor tables and callbacks see
+	// them in the order they were handled; it is taken before mu.
+	handleMu sync.Mutex
+
+	mu       sync.Mutex
+	links    map[string]*link
+	handlers []func(Event)
+}
+
+// link is what a monitor knows of an interface.
+type link struct {
+	known     bool // Whether its state was reported
+	up        bool
+	addresses []ip.InterfaceAddress
+	gates     []*Gate
+	neighbors []Neighbors
+}
+
+// NewMonitor creates a monitor that knows no interfaces. Interfaces are
+// taken to be up until reported otherwise.
+func NewMonitor() *Monitor {
+	return &Monitor{links: make(map[string]*link)}
+}
+
+// OnChange registers a callback invoked with each change, once the
+// monitor has applied it. Callbacks must not call Handle.
+func (m *Monitor) OnChange(f func(Event)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, f)
+}
+
+// Gate returns a gate transmitting over dev, paused while the monitor
+// knows the interface of dev's name to be down. limit is as for NewGate.
+func (m *Monitor) Gate(dev ethernet.Device, limit int) *Gate {
+	g := NewGate(dev, limit)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.link(dev.Name())
+	if l.known && !l.up {
+		g.SetUp(false)
+	}
+	l.gates = append(l.gates, g)
+	return g
+}
+
+// AddNeighbors registers a neighbor table learned on an interface, to be
+// flushed when the interface goes down or loses an address.
+func (m *Monitor) AddNeighbors(iface string, n Neighbors) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.link(iface)
+	l.neighbors = append(l.neighbors, n)
+}
+
+// Up returns whether an interface is up, as last reported; interfaces
+// never reported are taken to be.
+func (m *Monitor) Up(iface string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.links[iface]
+	return !ok || !l.known || l.up
+}
+
+// Addresses returns the addresses of an interface, in the order added.
+func (m *Monitor) Addresses(iface string) []ip.InterfaceAddress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.links[iface]; ok {
+		return slices.Clone(l.addresses)
+	}
+	return nil
+}
+
+// Handle applies a change. A change to what the monitor already knows,
+// such as an interface that is up reported up again, is ignored.
+func (m *Monitor) Handle(ev Event) {
+	m.handleMu.Lock()
+	defer m.handleMu.Unlock()
+
+	m.mu.Lock()
+	l := m.link(ev.Interface)
+	if !l.apply(ev) {
+		m.mu.Unlock()
+		return
+	}
+	gates, neighbors := slices.Clone(l.gates), slices.Clone(l.neighbors)
+	handlers := slices.Clone(m.handlers)
+	m.mu.Unlock()
+
+	logger.Debug("interface changed", logging.F("interface", ev.Interface), logging.F("event", ev.Type))
+	switch ev.Type {
+	case LinkUp, LinkDown:
+		for _, g := range gates {
+			g.SetUp(ev.Type == LinkUp)
+		}
+	}
+	// Neighbors may have moved on while the link was down, and those
+	// learned on a subnet the interface left are unreachable
+	if ev.Type == LinkDown || ev.Type == AddressRemoved {
+		for _, n := range neighbors {
+			n.Clear()
+		}
+	}
+	for _, f := range handlers {
+		f(ev)
+	}
+}
+
+// link returns the state of an interface, adding it if new. Called with
+// m.mu held.
+func (m *Monitor) link(iface string) *link {
+	l, ok := m.links[iface]
+	if !ok {
+		l = &link{}
+		m.links[iface] = l
+	}
+	return l
+}
+
+// apply records a change, and returns whether it changed anything.
+func (l *link) apply(ev Event) bool {
+	i := slices.IndexFunc(l.addresses, func(a ip.InterfaceAddress) bool { return a.Address == ev.Address })
+	switch ev.Type {
+	case LinkUp, LinkDown:
+		up := ev.Type == LinkUp
+		if l.known && l.up == up {
+			return false
+		}
+		l.known, l.up = true, up
+	case AddressAdded:
+		addr := ip.InterfaceAddress{Interface: ev.Interface, Address: ev.Address, PrefixLen: ev.PrefixLength}
+		if i >= 0 {
+			if l.addresses[i] == addr {
+				return false
+			}
+			l.addresses[i] = addr
+			return true
+		}
+		l.addresses = append(l.addresses, addr)
+	case AddressRemoved:
+		if i < 0 {
+			return false
+		}
+		l.addresses = slices.Delete(l.addresses, i, i+1)
+	default:
+		return false
+	}
+	return true
+}
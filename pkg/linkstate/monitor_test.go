@@ -0,0 +1,66 @@
+package linkstate
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+func TestMonitor(t *testing.T) {
+	m := NewMonitor()
+	var events []Event
+	m.OnChange(func(ev Event) { events = append(events, ev) })
+
+	eth0 := m.Gate(&testDevice{name: "eth0"}, 0)
+	eth1 := m.Gate(&testDevice{name: "eth1"}, 0)
+	cache0, cache1 := arp.NewCache(time.Minute), arp.NewCache(time.Minute)
+	m.AddNeighbors("eth0", cache0)
+	m.AddNeighbors("eth1", cache1)
+	neighbor := common.IPv4Address{192, 168, 1, 1}
+	cache0.Add(neighbor, common.MACAddress{2, 0, 0, 0, 0, 2})
+	cache1.Add(neighbor, common.MACAddress{2, 0, 0, 0, 0, 3})
+
+	// Going down pauses the interface's gates and flushes its neighbors,
+	// and no other's
+	down := Event{Type: LinkDown, Interface: "eth0"}
+	m.Handle(down)
+	m.Handle(down)
+	if eth0.Up() || !eth1.Up() || m.Up("eth0") {
+		t.Errorf("gates up = %v, %v and Up() = %v with eth0 down, want false, true and false", eth0.Up(), eth1.Up(), m.Up("eth0"))
+	}
+	if cache0.Size() != 0 || cache1.Size() != 1 {
+		t.Errorf("ARP caches hold %d and %d entries with eth0 down, want 0 and 1", cache0.Size(), cache1.Size())
+	}
+	if late := m.Gate(&testDevice{name: "eth0"}, 0); late.Up() {
+		t.Error("gate added with eth0 down is up")
+	}
+
+	up := Event{Type: LinkUp, Interface: "eth0"}
+	m.Handle(up)
+	if !eth0.Up() {
+		t.Error("gate down with eth0 up again")
+	}
+
+	// Addresses come and go; losing one flushes the neighbors
+	addr := Event{Type: AddressAdded, Interface: "eth1", Address: common.IPv4Address{10, 0, 0, 5}, PrefixLength: 8}
+	m.Handle(addr)
+	m.Handle(addr)
+	want := []ip.InterfaceAddress{{Interface: "eth1", Address: addr.Address, PrefixLen: 8}}
+	if got := m.Addresses("eth1"); !slices.Equal(got, want) {
+		t.Errorf("Addresses() = %v, want %v", got, want)
+	}
+	removed := Event{Type: AddressRemoved, Interface: "eth1", Address: addr.Address, PrefixLength: 8}
+	m.Handle(removed)
+	if got := m.Addresses("eth1"); len(got) != 0 || cache1.Size() != 0 {
+		t.Errorf("Addresses() = %v with %d neighbors after the address was removed, want none", got, cache1.Size())
+	}
+
+	// Callbacks saw each change once
+	if wantEvents := []Event{down, up, addr, removed}; !slices.Equal(events, wantEvents) {
+		t.Errorf("OnChange() called with %v, want %v", events, wantEvents)
+	}
+}
@@ -0,0 +1,283 @@
+package lldp
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+const (
+	// DefaultInterval is the default advertisement interval (msgTxInterval).
+	DefaultInterval = 30 * time.Second
+
+	// DefaultHoldMultiplier is the default TTL multiplier (msgTxHold).
+	// Advertised TTL = interval * hold multiplier.
+	DefaultHoldMultiplier = 4
+)
+
+// Neighbor is an entry in the agent's neighbor table.
+type Neighbor struct {
+	ChassisID         string            // Formatted chassis identifier
+	PortID            string            // Formatted port identifier
+	PortDescription   string            // Port description, if advertised
+	SystemName        string            // System name, if advertised
+	SystemDescription string            // System description, if advertised
+	SourceMAC         common.MACAddress // Source address of the last LLDPDU
+	TTL               time.Duration     // Advertised time to live
+	LastSeen          time.Time         // When the last LLDPDU was received
+	Packet            *Packet           // Last LLDPDU received
+}
+
+// ExpiresAt returns when the neighbor information becomes stale.
+func (n *Neighbor) ExpiresAt() time.Time {
+	return n.LastSeen.Add(n.TTL)
+}
+
+// String returns a human-readable representation of the neighbor.
+func (n *Neighbor) String() string {
+	return fmt.Sprintf("Neighbor{Chassis=%s, Port=%s, System=%q, SeenAt=%s}",
+		n.ChassisID, n.PortID, n.SystemName, n.LastSeen.Format(time.RFC3339))
+}
+
+// Agent advertises the local system on an interface and tracks neighbors.
+type Agent struct {
+	iface      ethernet.Device
+	mu         sync.RWMutex
+	local      Packet
+	interval   time.Duration
+	hold       int
+	neighbors  map[string]*Neighbor
+	onNeighbor func(*Neighbor)
+}
+
+// NewAgent creates an LLDP agent for the given interface.
+// The chassis is identified by the interface's MAC address and the port by
+// its name.
+func NewAgent(iface ethernet.Device, systemName, systemDescription string) *Agent {
+	local := NewPacket(iface.MACAddress(), iface.Name(), 0)
+	local.PortDescription = iface.Name()
+	local.SystemName = systemName
+	local.SystemDescription = systemDescription
+
+	return &Agent{
+		iface:     iface,
+		local:     *local,
+		interval:  DefaultInterval,
+		hold:      DefaultHoldMultiplier,
+		neighbors: make(map[string]*Neighbor),
+	}
+}
+
+// SetInterval sets the advertisement interval.
+func (a *Agent) SetInterval(interval time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.interval = interval
+}
+
+// SetHoldMultiplier sets the multiplier used to compute the advertised TTL.
+func (a *Agent) SetHoldMultiplier(hold int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.hold = hold
+}
+
+// SetPortDescription sets the advertised port description.
+func (a *Agent) SetPortDescription(desc string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.local.PortDescription = desc
+}
+
+// OnNeighbor registers a callback invoked whenever a neighbor is added or refreshed.
+func (a *Agent) OnNeighbor(f func(*Neighbor)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onNeighbor = f
+}
+
+// ttl returns the TTL to advertise, in seconds.
+func (a *Agent) ttl() uint16 {
+	seconds := int(a.interval/time.Second) * a.hold
+	if seconds < 1 {
+		seconds = 1
+	}
+	if seconds > 65535 {
+		seconds = 65535
+	}
+	return uint16(seconds)
+}
+
+// Advertise sends a single LLDPDU describing the local system.
+func (a *Agent) Advertise() error {
+	a.mu.RLock()
+	pkt := a.local
+	pkt.TTL = a.ttl()
+	a.mu.RUnlock()
+
+	return a.send(&pkt)
+}
+
+// Shutdown sends an LLDPDU with TTL 0, telling neighbors to forget us.
+func (a *Agent) Shutdown() error {
+	a.mu.RLock()
+	pkt := a.local
+	a.mu.RUnlock()
+
+	pkt.TTL = 0
+	return a.send(&pkt)
+}
+
+// send transmits an LLDPDU to the LLDP multicast address.
+func (a *Agent) send(pkt *Packet) error {
+	payload, err := pkt.Serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize LLDPDU: %w", err)
+	}
+
+	frame := ethernet.NewFrame(MulticastMAC, a.iface.MACAddress(), common.EtherTypeLLDP, payload)
+	return a.iface.WriteFrame(frame)
+}
+
+// HandleFrame processes a received Ethernet frame. Non-LLDP frames are ignored.
+func (a *Agent) HandleFrame(frame *ethernet.Frame) error {
+	if frame.EtherType != common.EtherTypeLLDP {
+		return nil
+	}
+
+	// Ignore our own advertisements looped back to us
+	if frame.Source == a.iface.MACAddress() {
+		return nil
+	}
+
+	pkt, err := Parse(frame.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to parse LLDPDU: %w", err)
+	}
+
+	return a.HandlePacket(pkt, frame.Source)
+}
+
+// HandlePacket updates the neighbor table from an LLDPDU.
+func (a *Agent) HandlePacket(pkt *Packet, source common.MACAddress) error {
+	key := neighborKey(pkt)
+
+	a.mu.Lock()
+
+	// TTL 0 means the neighbor is shutting down
+	if pkt.TTL == 0 {
+		delete(a.neighbors, key)
+		a.mu.Unlock()
+		return nil
+	}
+
+	n := &Neighbor{
+		ChassisID:         pkt.ChassisIDString(),
+		PortID:            pkt.PortIDString(),
+		PortDescription:   pkt.PortDescription,
+		SystemName:        pkt.SystemName,
+		SystemDescription: pkt.SystemDescription,
+		SourceMAC:         source,
+		TTL:               time.Duration(pkt.TTL) * time.Second,
+		LastSeen:          time.Now(),
+		Packet:            pkt,
+	}
+	a.neighbors[key] = n
+	callback := a.onNeighbor
+	a.mu.Unlock()
+
+	if callback != nil {
+		callback(n)
+	}
+	return nil
+}
+
+// neighborKey identifies a neighbor by its chassis and port (MSAP identifier).
+func neighborKey(pkt *Packet) string {
+	return fmt.Sprintf("%d:%x/%d:%x", pkt.ChassisIDSubtype, pkt.ChassisID, pkt.PortIDSubtype, pkt.PortID)
+}
+
+// Neighbors returns the current neighbors, sorted by chassis and port.
+// Expired entries are removed.
+func (a *Agent) Neighbors() []*Neighbor {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	neighbors := make([]*Neighbor, 0, len(a.neighbors))
+	for key, n := range a.neighbors {
+		if now.After(n.ExpiresAt()) {
+			delete(a.neighbors, key)
+			continue
+		}
+		neighbors = append(neighbors, n)
+	}
+
+	sort.Slice(neighbors, func(i, j int) bool {
+		if neighbors[i].ChassisID != neighbors[j].ChassisID {
+			return neighbors[i].ChassisID < neighbors[j].ChassisID
+		}
+		return neighbors[i].PortID < neighbors[j].PortID
+	})
+
+	return neighbors
+}
+
+// Start starts advertising and processing incoming LLDP frames.
+// It returns a channel that can be closed to stop the agent; a shutdown
+// LLDPDU is sent when the agent stops.
+//
+// Start reads frames from the interface itself. If other protocols share the
+// interface, demultiplex frames externally and call HandleFrame instead.
+func (a *Agent) Start() (chan<- struct{}, error) {
+	if err := a.Advertise(); err != nil {
+		return nil, fmt.Errorf("failed to send initial LLDPDU: %w", err)
+	}
+
+	stop := make(chan struct{})
+
+	// Periodic advertisements
+	go func() {
+		a.mu.RLock()
+		interval := a.interval
+		a.mu.RUnlock()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				a.Shutdown()
+				return
+			case <-ticker.C:
+				a.Advertise()
+			}
+		}
+	}()
+
+	// Receive loop
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				frame, err := a.iface.ReadFrame()
+				if err != nil {
+					time.Sleep(10 * time.Millisecond)
+					continue
+				}
+
+				// Errors from malformed LLDPDUs are not fatal
+				a.HandleFrame(frame)
+			}
+		}
+	}()
+
+	return stop, nil
+}
@@ -0,0 +1,129 @@
+package lldp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+)
+
+func TestAgentDiscovery(t *testing.T) {
+	a, b := memory.NewPipe(memory.Config{})
+	defer a.Close()
+
+	agentA := NewAgent(a, "switch-a", "test switch A")
+	agentB := NewAgent(b, "switch-b", "test switch B")
+	agentA.SetInterval(50 * time.Millisecond)
+	agentB.SetInterval(50 * time.Millisecond)
+
+	stopA, err := agentA.Start()
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	stopB, err := agentB.Start()
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer close(stopA)
+	defer close(stopB)
+
+	// Wait for each side to learn the other
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(agentA.Neighbors()) == 1 && len(agentB.Neighbors()) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	neighbors := agentA.Neighbors()
+	if len(neighbors) != 1 {
+		t.Fatalf("agent A has %d neighbors, want 1", len(neighbors))
+	}
+	n := neighbors[0]
+	if n.SystemName != "switch-b" {
+		t.Errorf("SystemName = %q, want %q", n.SystemName, "switch-b")
+	}
+	if n.ChassisID != b.MACAddress().String() {
+		t.Errorf("ChassisID = %q, want %q", n.ChassisID, b.MACAddress().String())
+	}
+	if n.PortID != b.Name() {
+		t.Errorf("PortID = %q, want %q", n.PortID, b.Name())
+	}
+	if n.SourceMAC != b.MACAddress() {
+		t.Errorf("SourceMAC = %s, want %s", n.SourceMAC, b.MACAddress())
+	}
+
+	// TTL is interval * hold multiplier, rounded up to at least one second
+	if n.TTL != time.Second {
+		t.Errorf("TTL = %v, want 1s", n.TTL)
+	}
+
+	if len(agentB.Neighbors()) != 1 {
+		t.Errorf("agent B has %d neighbors, want 1", len(agentB.Neighbors()))
+	}
+}
+
+func TestAgentShutdownAndExpiry(t *testing.T) {
+	a, _ := memory.NewPipe(memory.Config{})
+	defer a.Close()
+
+	agent := NewAgent(a, "local", "")
+	remote := common.MACAddress{0x02, 0, 0, 0, 0, 0x99}
+
+	// Learn a neighbor with a short TTL
+	pkt := NewPacket(remote, "eth1", 1)
+	pkt.SystemName = "remote"
+	if err := agent.HandlePacket(pkt, remote); err != nil {
+		t.Fatalf("HandlePacket() error = %v", err)
+	}
+	if len(agent.Neighbors()) != 1 {
+		t.Fatalf("Neighbors() = %d entries, want 1", len(agent.Neighbors()))
+	}
+
+	// A shutdown LLDPDU removes it immediately
+	shutdown := NewPacket(remote, "eth1", 0)
+	if err := agent.HandlePacket(shutdown, remote); err != nil {
+		t.Fatalf("HandlePacket() error = %v", err)
+	}
+	if len(agent.Neighbors()) != 0 {
+		t.Errorf("Neighbors() = %d entries after shutdown, want 0", len(agent.Neighbors()))
+	}
+
+	// An expired entry is removed lazily
+	agent.HandlePacket(pkt, remote)
+	agent.mu.Lock()
+	for _, n := range agent.neighbors {
+		n.LastSeen = time.Now().Add(-2 * time.Second)
+	}
+	agent.mu.Unlock()
+	if len(agent.Neighbors()) != 0 {
+		t.Errorf("Neighbors() = %d entries after expiry, want 0", len(agent.Neighbors()))
+	}
+}
+
+func TestAgentIgnoresOtherFrames(t *testing.T) {
+	a, _ := memory.NewPipe(memory.Config{})
+	defer a.Close()
+
+	agent := NewAgent(a, "local", "")
+
+	// Non-LLDP frame
+	frame := ethernet.NewFrame(MulticastMAC, common.MACAddress{0x02, 0, 0, 0, 0, 1}, common.EtherTypeIPv4, []byte{1, 2, 3})
+	if err := agent.HandleFrame(frame); err != nil {
+		t.Errorf("HandleFrame() error = %v", err)
+	}
+
+	// Our own advertisement
+	payload, _ := NewPacket(a.MACAddress(), a.Name(), 120).Serialize()
+	own := ethernet.NewFrame(MulticastMAC, a.MACAddress(), common.EtherTypeLLDP, payload)
+	if err := agent.HandleFrame(own); err != nil {
+		t.Errorf("HandleFrame() error = %v", err)
+	}
+
+	if len(agent.Neighbors()) != 0 {
+		t.Errorf("Neighbors() = %d entries, want 0", len(agent.Neighbors()))
+	}
+}
@@ -0,0 +1,305 @@
+// Package lldp implements the Link Layer Discovery Protocol (IEEE 802.1AB).
+// LLDP lets directly connected devices advertise their identity and
+// capabilities, so each side can build a table of its link-layer neighbors.
+package lldp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// LLDPDU format (IEEE 802.1AB):
+// +------------+---------+-----+---------------+---------------+
+// | Chassis ID | Port ID | TTL | Optional TLVs | End of LLDPDU |
+// +------------+---------+-----+---------------+---------------+
+//
+// Each TLV has a 16-bit header:
+// +--------------+-----------------+----------------------+
+// | Type (7 bits)| Length (9 bits) | Value (0-511 bytes)  |
+// +--------------+-----------------+----------------------+
+
+const (
+	// MaxTLVLength is the maximum length of a TLV value (9-bit field).
+	MaxTLVLength = 511
+
+	// tlvHeaderSize is the size of a TLV header.
+	tlvHeaderSize = 2
+)
+
+// MulticastMAC is the "nearest bridge" destination address for LLDP frames.
+// Frames sent to it are never forwarded by 802.1D bridges.
+var MulticastMAC = common.MACAddress{0x01, 0x80, 0xC2, 0x00, 0x00, 0x0E}
+
+// TLVType identifies an LLDP TLV.
+type TLVType uint8
+
+// Standard TLV types.
+const (
+	TLVEnd                TLVType = 0
+	TLVChassisID          TLVType = 1
+	TLVPortID             TLVType = 2
+	TLVTTL                TLVType = 3
+	TLVPortDescription    TLVType = 4
+	TLVSystemName         TLVType = 5
+	TLVSystemDescription  TLVType = 6
+	TLVSystemCapabilities TLVType = 7
+	TLVManagementAddress  TLVType = 8
+	TLVOrganization       TLVType = 127
+)
+
+// String returns a human-readable name for the TLV type.
+func (t TLVType) String() string {
+	switch t {
+	case TLVEnd:
+		return "End"
+	case TLVChassisID:
+		return "ChassisID"
+	case TLVPortID:
+		return "PortID"
+	case TLVTTL:
+		return "TTL"
+	case TLVPortDescription:
+		return "PortDescription"
+	case TLVSystemName:
+		return "SystemName"
+	case TLVSystemDescription:
+		return "SystemDescription"
+	case TLVSystemCapabilities:
+		return "SystemCapabilities"
+	case TLVManagementAddress:
+		return "ManagementAddress"
+	case TLVOrganization:
+		return "Organization"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+// Chassis ID subtypes.
+const (
+	ChassisIDChassisComponent uint8 = 1
+	ChassisIDInterfaceAlias   uint8 = 2
+	ChassisIDPortComponent    uint8 = 3
+	ChassisIDMACAddress       uint8 = 4
+	ChassisIDNetworkAddress   uint8 = 5
+	ChassisIDInterfaceName    uint8 = 6
+	ChassisIDLocal            uint8 = 7
+)
+
+// Port ID subtypes.
+const (
+	PortIDInterfaceAlias uint8 = 1
+	PortIDPortComponent  uint8 = 2
+	PortIDMACAddress     uint8 = 3
+	PortIDNetworkAddress uint8 = 4
+	PortIDInterfaceName  uint8 = 5
+	PortIDAgentCircuitID uint8 = 6
+	PortIDLocal          uint8 = 7
+)
+
+// TLV is a raw type-length-value element.
+type TLV struct {
+	Type  TLVType
+	Value []byte
+}
+
+// Packet represents an LLDP data unit.
+type Packet struct {
+	ChassisIDSubtype  uint8  // How ChassisID is encoded
+	ChassisID         []byte // Chassis identifier
+	PortIDSubtype     uint8  // How PortID is encoded
+	PortID            []byte // Port identifier
+	TTL               uint16 // Seconds the receiver should keep this information (0 = shutdown)
+	PortDescription   string // Optional port description
+	SystemName        string // Optional system name
+	SystemDescription string // Optional system description
+	Extra             []TLV  // Other optional TLVs, preserved in order
+}
+
+// Parse parses an LLDPDU from an Ethernet payload.
+func Parse(data []byte) (*Packet, error) {
+	p := &Packet{}
+	offset := 0
+	index := 0
+
+	for {
+		tlv, n, err := parseTLV(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		offset += n
+
+		// The first three TLVs are mandatory and must appear in order
+		switch index {
+		case 0:
+			if tlv.Type != TLVChassisID {
+				return nil, fmt.Errorf("first TLV must be ChassisID, got %s", tlv.Type)
+			}
+		case 1:
+			if tlv.Type != TLVPortID {
+				return nil, fmt.Errorf("second TLV must be PortID, got %s", tlv.Type)
+			}
+		case 2:
+			if tlv.Type != TLVTTL {
+				return nil, fmt.Errorf("third TLV must be TTL, got %s", tlv.Type)
+			}
+		}
+		index++
+
+		switch tlv.Type {
+		case TLVEnd:
+			return p, nil
+		case TLVChassisID:
+			if len(tlv.Value) < 2 {
+				return nil, fmt.Errorf("ChassisID TLV too short: %d bytes", len(tlv.Value))
+			}
+			p.ChassisIDSubtype = tlv.Value[0]
+			p.ChassisID = tlv.Value[1:]
+		case TLVPortID:
+			if len(tlv.Value) < 2 {
+				return nil, fmt.Errorf("PortID TLV too short: %d bytes", len(tlv.Value))
+			}
+			p.PortIDSubtype = tlv.Value[0]
+			p.PortID = tlv.Value[1:]
+		case TLVTTL:
+			if len(tlv.Value) < 2 {
+				return nil, fmt.Errorf("TTL TLV too short: %d bytes", len(tlv.Value))
+			}
+			p.TTL = binary.BigEndian.Uint16(tlv.Value)
+		case TLVPortDescription:
+			p.PortDescription = string(tlv.Value)
+		case TLVSystemName:
+			p.SystemName = string(tlv.Value)
+		case TLVSystemDescription:
+			p.SystemDescription = string(tlv.Value)
+		default:
+			p.Extra = append(p.Extra, tlv)
+		}
+
+		// Tolerate a missing End TLV at the end of the frame
+		if offset >= len(data) && index >= 3 {
+			return p, nil
+		}
+	}
+}
+
+// parseTLV parses a single TLV, returning it and the number of bytes consumed.
+func parseTLV(data []byte) (TLV, int, error) {
+	if len(data) < tlvHeaderSize {
+		return TLV{}, 0, fmt.Errorf("LLDP TLV header truncated")
+	}
+
+	header := binary.BigEndian.Uint16(data[0:2])
+	tlvType := TLVType(header >> 9)
+	length := int(header & 0x01FF)
+
+	if len(data) < tlvHeaderSize+length {
+		return TLV{}, 0, fmt.Errorf("LLDP %s TLV truncated: need %d bytes, have %d",
+			tlvType, length, len(data)-tlvHeaderSize)
+	}
+
+	return TLV{Type: tlvType, Value: data[tlvHeaderSize : tlvHeaderSize+length]}, tlvHeaderSize + length, nil
+}
+
+// Serialize converts the LLDPDU to bytes (the Ethernet payload).
+func (p *Packet) Serialize() ([]byte, error) {
+	if len(p.ChassisID) == 0 {
+		return nil, fmt.Errorf("ChassisID is required")
+	}
+	if len(p.PortID) == 0 {
+		return nil, fmt.Errorf("PortID is required")
+	}
+
+	var buf []byte
+	var err error
+
+	ttl := make([]byte, 2)
+	binary.BigEndian.PutUint16(ttl, p.TTL)
+
+	// Mandatory TLVs
+	tlvs := []TLV{
+		{Type: TLVChassisID, Value: append([]byte{p.ChassisIDSubtype}, p.ChassisID...)},
+		{Type: TLVPortID, Value: append([]byte{p.PortIDSubtype}, p.PortID...)},
+		{Type: TLVTTL, Value: ttl},
+	}
+
+	// Optional TLVs
+	if p.PortDescription != "" {
+		tlvs = append(tlvs, TLV{Type: TLVPortDescription, Value: []byte(p.PortDescription)})
+	}
+	if p.SystemName != "" {
+		tlvs = append(tlvs, TLV{Type: TLVSystemName, Value: []byte(p.SystemName)})
+	}
+	if p.SystemDescription != "" {
+		tlvs = append(tlvs, TLV{Type: TLVSystemDescription, Value: []byte(p.SystemDescription)})
+	}
+	tlvs = append(tlvs, p.Extra...)
+	tlvs = append(tlvs, TLV{Type: TLVEnd})
+
+	for _, tlv := range tlvs {
+		if buf, err = appendTLV(buf, tlv); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+// appendTLV appends a TLV to buf.
+func appendTLV(buf []byte, tlv TLV) ([]byte, error) {
+	if tlv.Type > 127 {
+		return nil, fmt.Errorf("invalid TLV type: %d", tlv.Type)
+	}
+	if len(tlv.Value) > MaxTLVLength {
+		return nil, fmt.Errorf("%s TLV too long: %d bytes (maximum %d)", tlv.Type, len(tlv.Value), MaxTLVLength)
+	}
+
+	header := uint16(tlv.Type)<<9 | uint16(len(tlv.Value))
+	buf = binary.BigEndian.AppendUint16(buf, header)
+	return append(buf, tlv.Value...), nil
+}
+
+// ChassisIDString returns the chassis ID formatted according to its subtype.
+func (p *Packet) ChassisIDString() string {
+	return formatID(p.ChassisIDSubtype == ChassisIDMACAddress, p.ChassisIDSubtype == ChassisIDNetworkAddress, p.ChassisID)
+}
+
+// PortIDString returns the port ID formatted according to its subtype.
+func (p *Packet) PortIDString() string {
+	return formatID(p.PortIDSubtype == PortIDMACAddress, p.PortIDSubtype == PortIDNetworkAddress, p.PortID)
+}
+
+// formatID formats a chassis or port identifier.
+func formatID(isMAC, isNetwork bool, id []byte) string {
+	switch {
+	case isMAC && len(id) == 6:
+		return net.HardwareAddr(id).String()
+	case isNetwork && len(id) >= 1:
+		// First byte is the IANA address family (1 = IPv4, 2 = IPv6)
+		if (id[0] == 1 && len(id) == 5) || (id[0] == 2 && len(id) == 17) {
+			return net.IP(id[1:]).String()
+		}
+	}
+	return string(id)
+}
+
+// String returns a human-readable representation of the LLDPDU.
+func (p *Packet) String() string {
+	return fmt.Sprintf("LLDP{Chassis=%s, Port=%s, TTL=%d, System=%q}",
+		p.ChassisIDString(), p.PortIDString(), p.TTL, p.SystemName)
+}
+
+// NewPacket creates an LLDPDU identifying the chassis by MAC address and the
+// port by interface name.
+func NewPacket(chassisMAC common.MACAddress, portName string, ttl uint16) *Packet {
+	return &Packet{
+		ChassisIDSubtype: ChassisIDMACAddress,
+		ChassisID:        append([]byte(nil), chassisMAC[:]...),
+		PortIDSubtype:    PortIDInterfaceName,
+		PortID:           []byte(portName),
+		TTL:              ttl,
+	}
+}
@@ -0,0 +1,141 @@
+package lldp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	mac := common.MACAddress{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	pkt := NewPacket(mac, "eth0", 120)
+	pkt.PortDescription = "uplink"
+	pkt.SystemName = "router1"
+	pkt.SystemDescription = "Go network stack"
+	pkt.Extra = []TLV{{Type: TLVSystemCapabilities, Value: []byte{0x00, 0x14, 0x00, 0x10}}}
+
+	data, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if parsed.ChassisIDSubtype != ChassisIDMACAddress || !bytes.Equal(parsed.ChassisID, mac[:]) {
+		t.Errorf("ChassisID = %d/%x, want %d/%x", parsed.ChassisIDSubtype, parsed.ChassisID, ChassisIDMACAddress, mac[:])
+	}
+	if parsed.PortIDSubtype != PortIDInterfaceName || string(parsed.PortID) != "eth0" {
+		t.Errorf("PortID = %d/%q, want %d/%q", parsed.PortIDSubtype, parsed.PortID, PortIDInterfaceName, "eth0")
+	}
+	if parsed.TTL != 120 {
+		t.Errorf("TTL = %d, want 120", parsed.TTL)
+	}
+	if parsed.PortDescription != "uplink" {
+		t.Errorf("PortDescription = %q, want %q", parsed.PortDescription, "uplink")
+	}
+	if parsed.SystemName != "router1" {
+		t.Errorf("SystemName = %q, want %q", parsed.SystemName, "router1")
+	}
+	if parsed.SystemDescription != "Go network stack" {
+		t.Errorf("SystemDescription = %q, want %q", parsed.SystemDescription, "Go network stack")
+	}
+	if len(parsed.Extra) != 1 || parsed.Extra[0].Type != TLVSystemCapabilities {
+		t.Errorf("Extra = %v, want one SystemCapabilities TLV", parsed.Extra)
+	}
+	if parsed.ChassisIDString() != "00:11:22:33:44:55" {
+		t.Errorf("ChassisIDString() = %q, want %q", parsed.ChassisIDString(), "00:11:22:33:44:55")
+	}
+	if parsed.PortIDString() != "eth0" {
+		t.Errorf("PortIDString() = %q, want %q", parsed.PortIDString(), "eth0")
+	}
+}
+
+func TestParseKnownLLDPDU(t *testing.T) {
+	data := []byte{
+		// ChassisID: type 1, length 7, MAC subtype
+		0x02, 0x07, 0x04, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55,
+		// PortID: type 2, length 3, locally assigned "p1"
+		0x04, 0x03, 0x07, 'p', '1',
+		// TTL: type 3, length 2, 120 seconds
+		0x06, 0x02, 0x00, 0x78,
+		// End
+		0x00, 0x00,
+		// Ethernet padding
+		0x00, 0x00, 0x00, 0x00,
+	}
+
+	pkt, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if pkt.TTL != 120 {
+		t.Errorf("TTL = %d, want 120", pkt.TTL)
+	}
+	if pkt.PortIDString() != "p1" {
+		t.Errorf("PortIDString() = %q, want %q", pkt.PortIDString(), "p1")
+	}
+
+	// Serializing the parsed packet reproduces the original (without padding)
+	out, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if !bytes.Equal(out, data[:len(data)-4]) {
+		t.Errorf("Serialize() = %x, want %x", out, data[:len(data)-4])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"truncated header", []byte{0x02}},
+		{"truncated value", []byte{0x02, 0x07, 0x04, 0x00}},
+		{"wrong first TLV", []byte{0x04, 0x03, 0x07, 'p', '1'}},
+		{"missing TTL", []byte{
+			0x02, 0x02, 0x07, 'c',
+			0x04, 0x02, 0x07, 'p',
+			0x00, 0x00,
+		}},
+		{"short chassis ID", []byte{0x02, 0x01, 0x04}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Error("Parse() should return error")
+			}
+		})
+	}
+}
+
+func TestSerializeErrors(t *testing.T) {
+	if _, err := (&Packet{PortID: []byte("p")}).Serialize(); err == nil {
+		t.Error("Serialize() should require ChassisID")
+	}
+	if _, err := (&Packet{ChassisID: []byte("c")}).Serialize(); err == nil {
+		t.Error("Serialize() should require PortID")
+	}
+
+	pkt := NewPacket(common.MACAddress{}, "eth0", 120)
+	pkt.SystemDescription = string(bytes.Repeat([]byte{'x'}, MaxTLVLength+1))
+	if _, err := pkt.Serialize(); err == nil {
+		t.Error("Serialize() should reject oversized TLV")
+	}
+}
+
+func TestNetworkAddressID(t *testing.T) {
+	pkt := &Packet{
+		ChassisIDSubtype: ChassisIDNetworkAddress,
+		ChassisID:        []byte{1, 192, 168, 1, 1},
+	}
+	if pkt.ChassisIDString() != "192.168.1.1" {
+		t.Errorf("ChassisIDString() = %q, want %q", pkt.ChassisIDString(), "192.168.1.1")
+	}
+}
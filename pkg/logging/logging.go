@@ -0,0 +1,311 @@
+// Package logging provides leveled, structured logging for the stack.
+//
+// Each protocol package logs through its own Subsystem, so verbosity can be
+// set per package. Nothing is logged until a Logger is installed:
+//
+//	logging.SetLogger(logging.NewTextLogger(os.Stderr))
+//	logging.SetLevels("tcp=debug,arp=trace")
+//
+// At LevelTrace subsystems also log the decoded headers of every packet
+// they handle. The NETSTACK_LOG environment variable, if set, is applied
+// with SetLevels at startup and installs a text logger on stderr.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log record. The values match log/slog, with
+// LevelTrace below LevelDebug for packet tracing.
+type Level int
+
+const (
+	// LevelTrace logs every packet a subsystem handles.
+	LevelTrace Level = -8
+
+	// LevelDebug logs protocol events such as state changes.
+	LevelDebug Level = -4
+
+	// LevelInfo logs infrequent, noteworthy events.
+	LevelInfo Level = 0
+
+	// LevelWarn logs unexpected conditions the stack recovers from.
+	LevelWarn Level = 4
+
+	// LevelError logs failures.
+	LevelError Level = 8
+
+	// LevelOff disables a subsystem.
+	LevelOff Level = 1 << 10
+)
+
+// DefaultLevel is the level of subsystems not configured otherwise.
+const DefaultLevel = LevelWarn
+
+// levelNames maps levels to the names used by String and ParseLevel.
+var levelNames = map[Level]string{
+	LevelTrace: "TRACE",
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+	LevelOff:   "OFF",
+}
+
+// String returns the level's name.
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// ParseLevel parses a level name, ignoring case.
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Field is a key/value pair attached to a record. Values implementing
+// fmt.Stringer are formatted only when the record is written.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// F creates a field.
+func F(key string, value any) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Record is a single log entry.
+type Record struct {
+	Time      time.Time
+	Level     Level
+	Subsystem string
+	Message   string
+	Fields    []Field
+}
+
+// Logger writes log records. Log may be called from many goroutines, often
+// while a protocol lock is held, so it must not call back into the stack.
+type Logger interface {
+	Log(r Record)
+}
+
+// LoggerFunc adapts a function to the Logger interface.
+type LoggerFunc func(r Record)
+
+// Log calls f(r).
+func (f LoggerFunc) Log(r Record) {
+	f(r)
+}
+
+// Subsystem is a named source of log records with its own level.
+type Subsystem struct {
+	name  string
+	level atomic.Int64
+}
+
+// loggerBox lets the installed Logger be swapped atomically.
+type loggerBox struct {
+	logger Logger
+}
+
+var (
+	// output is the installed logger, or nil to discard records.
+	output atomic.Pointer[loggerBox]
+
+	// mu guards the registry below.
+	mu           sync.Mutex
+	subsystems   = make(map[string]*Subsystem)
+	levels       = make(map[string]Level) // Levels set for named subsystems
+	defaultLevel = DefaultLevel
+)
+
+func init() {
+	if spec := os.Getenv("NETSTACK_LOG"); spec != "" {
+		if err := SetLevels(spec); err != nil {
+			fmt.Fprintf(os.Stderr, "logging: NETSTACK_LOG: %v\n", err)
+			return
+		}
+		SetLogger(NewTextLogger(os.Stderr))
+	}
+}
+
+// SetLogger installs the logger that receives records from all subsystems.
+// A nil logger discards them.
+func SetLogger(l Logger) {
+	if l == nil {
+		output.Store(nil)
+		return
+	}
+	output.Store(&loggerBox{logger: l})
+}
+
+// New returns the subsystem with the given name, creating it if needed.
+func New(name string) *Subsystem {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if s, ok := subsystems[name]; ok {
+		return s
+	}
+	s := &Subsystem{name: name}
+	level, ok := levels[name]
+	if !ok {
+		level = defaultLevel
+	}
+	s.level.Store(int64(level))
+	subsystems[name] = s
+	return s
+}
+
+// SetLevel sets the level of a subsystem, including one not created yet.
+// The name "*" sets the level of every subsystem and the default for new ones.
+func SetLevel(name string, level Level) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if name == "*" {
+		defaultLevel = level
+		clear(levels)
+		for _, s := range subsystems {
+			s.level.Store(int64(level))
+		}
+		return
+	}
+
+	levels[name] = level
+	if s, ok := subsystems[name]; ok {
+		s.level.Store(int64(level))
+	}
+}
+
+// SetLevels applies a comma-separated list of levels, such as
+// "warn,tcp=debug,arp=trace". An entry without a name applies to every
+// subsystem, as SetLevel("*", level) does.
+func SetLevels(spec string) error {
+	type setting struct {
+		name  string
+		level Level
+	}
+
+	// Parse everything before applying anything
+	var settings []setting
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, value = "*", entry
+		}
+		level, err := ParseLevel(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		settings = append(settings, setting{strings.TrimSpace(name), level})
+	}
+
+	// Apply "all" entries first so named ones override them
+	sort.SliceStable(settings, func(i, j int) bool {
+		return settings[i].name == "*" && settings[j].name != "*"
+	})
+	for _, s := range settings {
+		SetLevel(s.name, s.level)
+	}
+	return nil
+}
+
+// Subsystems returns the names of the created subsystems, sorted.
+func Subsystems() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	names := make([]string, 0, len(subsystems))
+	for name := range subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name returns the subsystem's name.
+func (s *Subsystem) Name() string {
+	return s.name
+}
+
+// Level returns the subsystem's level.
+func (s *Subsystem) Level() Level {
+	return Level(s.level.Load())
+}
+
+// Enabled reports whether a record at the given level would be written.
+// Callers can check it before building expensive fields.
+func (s *Subsystem) Enabled(level Level) bool {
+	return level >= s.Level() && output.Load() != nil
+}
+
+// Log writes a record at the given level if it is enabled.
+func (s *Subsystem) Log(level Level, msg string, fields ...Field) {
+	if level < s.Level() {
+		return
+	}
+	box := output.Load()
+	if box == nil {
+		return
+	}
+	box.logger.Log(Record{
+		Time:      time.Now(),
+		Level:     level,
+		Subsystem: s.name,
+		Message:   msg,
+		Fields:    fields,
+	})
+}
+
+// Trace logs at LevelTrace.
+func (s *Subsystem) Trace(msg string, fields ...Field) {
+	s.Log(LevelTrace, msg, fields...)
+}
+
+// Debug logs at LevelDebug.
+func (s *Subsystem) Debug(msg string, fields ...Field) {
+	s.Log(LevelDebug, msg, fields...)
+}
+
+// Info logs at LevelInfo.
+func (s *Subsystem) Info(msg string, fields ...Field) {
+	s.Log(LevelInfo, msg, fields...)
+}
+
+// Warn logs at LevelWarn.
+func (s *Subsystem) Warn(msg string, fields ...Field) {
+	s.Log(LevelWarn, msg, fields...)
+}
+
+// Error logs at LevelError.
+func (s *Subsystem) Error(msg string, fields ...Field) {
+	s.Log(LevelError, msg, fields...)
+}
+
+// Packet logs the decoded headers of a packet at LevelTrace. Direction
+// describes what happened to it, such as "rx" or "tx".
+func (s *Subsystem) Packet(direction string, header fmt.Stringer, fields ...Field) {
+	if !s.Enabled(LevelTrace) {
+		return
+	}
+	s.Log(LevelTrace, "packet", append([]Field{F("dir", direction), F("hdr", header)}, fields...)...)
+}
@@ -0,0 +1,155 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a Logger that keeps the records it receives.
+type recorder struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func (r *recorder) Log(rec Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, rec)
+}
+
+// capture installs a recorder and restores the global state after the test.
+func capture(t *testing.T) *recorder {
+	t.Helper()
+	rec := &recorder{}
+	SetLogger(rec)
+	t.Cleanup(func() {
+		SetLogger(nil)
+		SetLevel("*", DefaultLevel)
+	})
+	return rec
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	rec := capture(t)
+
+	// A level set before the subsystem exists applies once it is created
+	SetLevel("test-early", LevelDebug)
+	early := New("test-early")
+	other := New("test-other")
+	if New("test-early") != early {
+		t.Error("New() created a second subsystem with the same name")
+	}
+
+	early.Debug("shown", F("n", 1))
+	other.Debug("hidden")
+	other.Warn("shown")
+	if len(rec.records) != 2 {
+		t.Fatalf("got %d records, want 2", len(rec.records))
+	}
+	if r := rec.records[0]; r.Subsystem != "test-early" || r.Level != LevelDebug || r.Message != "shown" || r.Fields[0] != F("n", 1) {
+		t.Errorf("record = %+v", r)
+	}
+
+	// Without a logger nothing is enabled
+	SetLogger(nil)
+	if early.Enabled(LevelError) {
+		t.Error("Enabled() = true without a logger")
+	}
+}
+
+func TestSetLevels(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]Level
+		wantErr bool
+	}{
+		{"tcp=debug", map[string]Level{"tcp": LevelDebug, "ip": DefaultLevel}, false},
+		{"error, arp=TRACE", map[string]Level{"tcp": LevelError, "arp": LevelTrace}, false},
+		{"tcp=info,off", map[string]Level{"tcp": LevelInfo, "ip": LevelOff}, false},
+		{"tcp=loud", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			capture(t)
+			err := SetLevels(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetLevels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for name, want := range tt.want {
+				if got := New(name).Level(); got != want {
+					t.Errorf("%s level = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPacketTrace(t *testing.T) {
+	rec := capture(t)
+	s := New("test-trace")
+	hdr := &stringer{}
+
+	s.Packet("rx", hdr)
+	if len(rec.records) != 0 || hdr.calls != 0 {
+		t.Fatalf("packet traced at level %v", s.Level())
+	}
+
+	SetLevel("test-trace", LevelTrace)
+	s.Packet("rx", hdr, F("iface", "eth0"))
+	if len(rec.records) != 1 {
+		t.Fatalf("got %d records, want 1", len(rec.records))
+	}
+	fields := rec.records[0].Fields
+	if len(fields) != 3 || fields[0] != F("dir", "rx") || fields[1].Key != "hdr" || fields[2] != F("iface", "eth0") {
+		t.Errorf("fields = %v", fields)
+	}
+}
+
+// stringer counts how often it is formatted.
+type stringer struct {
+	calls int
+}
+
+func (s *stringer) String() string {
+	s.calls++
+	return "HDR{a=1}"
+}
+
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewTextLogger(&buf)
+	l.Log(Record{
+		Time:      time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+		Level:     LevelDebug,
+		Subsystem: "tcp",
+		Message:   "state change",
+		Fields:    []Field{F("to", &stringer{}), F("n", 3), F("empty", "")},
+	})
+
+	want := `time=2024-01-02T15:04:05.000Z level=DEBUG subsystem=tcp msg="state change" to="HDR{a=1}" n=3 empty=""` + "\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.Level(LevelDebug)})
+	l := NewSlogLogger(slog.New(handler))
+
+	l.Log(Record{Time: time.Now(), Level: LevelTrace, Subsystem: "ip", Message: "filtered"})
+	l.Log(Record{Time: time.Now(), Level: LevelWarn, Subsystem: "ip", Message: "kept", Fields: []Field{F("hdr", &stringer{})}})
+
+	out := buf.String()
+	if strings.Contains(out, "filtered") {
+		t.Error("record below the handler's level was written")
+	}
+	if !strings.Contains(out, `level=WARN msg=kept subsystem=ip hdr="HDR{a=1}"`) {
+		t.Errorf("output = %q", out)
+	}
+}
@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// textLogger writes records as logfmt lines.
+type textLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTextLogger returns a logger that writes one logfmt line per record:
+//
+//	time=2024-01-02T15:04:05.000Z level=DEBUG subsystem=tcp msg="state change" from=SYN_SENT to=ESTABLISHED
+func NewTextLogger(w io.Writer) Logger {
+	return &textLogger{w: w}
+}
+
+// Log writes the record.
+func (l *textLogger) Log(r Record) {
+	var b strings.Builder
+	b.WriteString("time=")
+	b.WriteString(r.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	b.WriteString(" level=")
+	b.WriteString(r.Level.String())
+	b.WriteString(" subsystem=")
+	writeValue(&b, r.Subsystem)
+	b.WriteString(" msg=")
+	writeValue(&b, r.Message)
+	for _, f := range r.Fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		writeValue(&b, formatField(f.Value))
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+// formatField formats a field value for text output.
+func formatField(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// writeValue writes a logfmt value, quoting it if it is empty or contains
+// spaces, quotes or '='.
+func writeValue(b *strings.Builder, s string) {
+	if s == "" || strings.ContainsAny(s, " \"=\t\n") {
+		b.WriteString(strconv.Quote(s))
+		return
+	}
+	b.WriteString(s)
+}
+
+// slogLogger forwards records to a log/slog handler.
+type slogLogger struct {
+	handler slog.Handler
+}
+
+// NewSlogLogger returns a logger that forwards records to l's handler, with
+// the subsystem as an attribute. Levels map directly; LevelTrace is below
+// slog.LevelDebug, so the handler must be configured to accept it.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{handler: l.Handler()}
+}
+
+// Log forwards the record.
+func (l *slogLogger) Log(r Record) {
+	ctx := context.Background()
+	level := slog.Level(r.Level)
+	if !l.handler.Enabled(ctx, level) {
+		return
+	}
+
+	rec := slog.NewRecord(r.Time, level, r.Message, 0)
+	rec.AddAttrs(slog.String("subsystem", r.Subsystem))
+	for _, f := range r.Fields {
+		value := f.Value
+		if s, ok := value.(fmt.Stringer); ok {
+			value = s.String()
+		}
+		rec.AddAttrs(slog.Any(f.Key, value))
+	}
+	l.handler.Handle(ctx, rec)
+}
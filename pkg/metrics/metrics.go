@@ -0,0 +1,238 @@
+// Package metrics exports counters in the Prometheus text exposition format.
+//
+// A Registry gathers metric families from collectors each time it is
+// scraped, so the counters themselves stay in the packages that update
+// them. The registry returned by NewStackRegistry reports the ethernet, ip,
+// tcp, udp and arp counters, and Handler serves it over pkg/http:
+//
+//	server := &http.Server{Handler: metrics.Handler()}
+//	server.Serve(tcp.NewListener(socket))
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/http"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// ErrInvalidName is returned when a metric or label name is not valid.
+	ErrInvalidName = errors.New("metrics: invalid name")
+
+	// ErrDuplicateMetric is returned when two collectors report the same family.
+	ErrDuplicateMetric = errors.New("metrics: duplicate metric")
+)
+
+// Type is the type of a metric family.
+type Type string
+
+const (
+	// Counter is a value that only increases.
+	Counter Type = "counter"
+
+	// Gauge is a value that can go up and down.
+	Gauge Type = "gauge"
+)
+
+// Label is a name/value pair distinguishing samples of a family.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one value of a metric family.
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Family is a named set of samples with the same type and help text.
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// Collector returns the current metric families. It is called on every
+// scrape, possibly from several goroutines at once.
+type Collector func() []Family
+
+// Registry holds the collectors reported by a metrics endpoint.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a collector to the registry.
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Gather calls every collector and returns the families sorted by name.
+func (r *Registry) Gather() ([]Family, error) {
+	r.mu.RLock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.RUnlock()
+
+	var families []Family
+	seen := make(map[string]bool)
+	for _, collect := range collectors {
+		for _, f := range collect() {
+			if err := f.validate(); err != nil {
+				return nil, err
+			}
+			if seen[f.Name] {
+				return nil, fmt.Errorf("%w: %s", ErrDuplicateMetric, f.Name)
+			}
+			seen[f.Name] = true
+			families = append(families, f)
+		}
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+	return families, nil
+}
+
+// WriteText writes the gathered families in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	families, err := r.Gather()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler returns an HTTP handler that serves the registry to scrapers.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Render first, so a collector error becomes a 500 rather than a
+		// truncated body
+		var body strings.Builder
+		if err := r.WriteText(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+		io.WriteString(w, body.String())
+	})
+}
+
+// validate checks the family and label names.
+func (f *Family) validate() error {
+	if !validName(f.Name, true) {
+		return fmt.Errorf("%w: metric %q", ErrInvalidName, f.Name)
+	}
+	for _, s := range f.Samples {
+		for _, l := range s.Labels {
+			if !validName(l.Name, false) || strings.HasPrefix(l.Name, "__") {
+				return fmt.Errorf("%w: label %q of %s", ErrInvalidName, l.Name, f.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// write writes the family's HELP, TYPE and sample lines.
+func (f *Family) write(w *bufio.Writer) {
+	if f.Help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+	}
+	typ := f.Type
+	if typ == "" {
+		typ = "untyped"
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.Name, typ)
+
+	for _, s := range f.Samples {
+		w.WriteString(f.Name)
+		if len(s.Labels) > 0 {
+			w.WriteByte('{')
+			for i, l := range s.Labels {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				fmt.Fprintf(w, "%s=\"%s\"", l.Name, escapeLabel(l.Value))
+			}
+			w.WriteByte('}')
+		}
+		w.WriteByte(' ')
+		w.WriteString(formatValue(s.Value))
+		w.WriteByte('\n')
+	}
+}
+
+// validName reports whether s is a valid metric name, or a label name if
+// metric is false (label names may not contain colons).
+func validName(s string, metric bool) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c == ':' && metric:
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// escapeHelp escapes backslashes and newlines in help text.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes backslashes, quotes and newlines in a label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// formatValue formats a sample value, spelling infinities and NaN the way
+// the format expects.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
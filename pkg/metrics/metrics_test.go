@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/http"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	r.Register(func() []Family {
+		return []Family{
+			{Name: "requests_total", Help: "Requests by path.\nMultiline", Type: Counter, Samples: []Sample{
+				{Labels: []Label{{"path", `/a"b\c`}, {"code", "200"}}, Value: 3},
+				{Labels: []Label{{"path", "/"}, {"code", "404"}}, Value: 1},
+			}},
+		}
+	})
+	r.Register(func() []Family {
+		return []Family{
+			{Name: "queue_depth", Type: Gauge, Samples: []Sample{{Value: 2.5}}},
+			{Name: "limit", Samples: []Sample{{Value: math.Inf(1)}}},
+		}
+	})
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+
+	// Families are sorted by name; label values and help text are escaped
+	want := `# TYPE limit untyped
+limit +Inf
+# TYPE queue_depth gauge
+queue_depth 2.5
+# HELP requests_total Requests by path.\nMultiline
+# TYPE requests_total counter
+requests_total{path="/a\"b\\c",code="200"} 3
+requests_total{path="/",code="404"} 1
+`
+	if b.String() != want {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestGatherErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		families []Family
+		wantErr  error
+	}{
+		{"metric name", []Family{{Name: "1st"}}, ErrInvalidName},
+		{"metric name character", []Family{{Name: "rx-bytes"}}, ErrInvalidName},
+		{"label name", []Family{{Name: "m", Samples: []Sample{{Labels: []Label{{"a:b", "x"}}}}}}, ErrInvalidName},
+		{"reserved label", []Family{{Name: "m", Samples: []Sample{{Labels: []Label{{"__name__", "x"}}}}}}, ErrInvalidName},
+		{"duplicate", []Family{{Name: "m"}, {Name: "m"}}, ErrDuplicateMetric},
+		{"valid", []Family{{Name: "ns:m_1", Samples: []Sample{{Labels: []Label{{"_l", "x"}}}}}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.Register(func() []Family { return tt.families })
+			if _, err := r.Gather(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Gather() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// scrape sends one request to a handler over an in-memory connection.
+func scrape(t *testing.T, h http.Handler, method string) (*http.Response, string) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	go (&http.Server{Handler: h}).ServeConn(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, err := http.NewRequest(method, "http://stack/metrics", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if _, err := io.WriteString(client, method+" /metrics HTTP/1.1\r\nHost: stack\r\n\r\n"); err != nil {
+		t.Fatalf("write request error = %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(client), req)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	return resp, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	resp, body := scrape(t, Handler(), "GET")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := resp.Header.Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, ContentType)
+	}
+	for _, name := range []string{
+		"network_ethernet_frames_received_total",
+		"network_ip_forwarded_total",
+		"network_tcp_retransmissions_total",
+		"network_udp_datagrams_received_total",
+		"network_arp_requests_sent_total",
+	} {
+		if !strings.Contains(body, "# TYPE "+name+" counter\n") {
+			t.Errorf("body has no %s counter", name)
+		}
+	}
+
+	if resp, _ := scrape(t, Handler(), "POST"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	// A collector error fails the scrape instead of serving partial output
+	r := NewRegistry()
+	r.Register(func() []Family { return []Family{{Name: "bad name"}} })
+	if resp, _ := scrape(t, r.Handler(), "GET"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status with a bad collector = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+}
+
+// sampleValue returns the value of an unlabelled sample in the stack registry.
+func sampleValue(t *testing.T, name string) float64 {
+	t.Helper()
+	families, err := NewStackRegistry().Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, f := range families {
+		if f.Name == name {
+			return f.Samples[0].Value
+		}
+	}
+	t.Fatalf("no metric %s", name)
+	return 0
+}
+
+func TestStackCounters(t *testing.T) {
+	// The counters are shared by the whole process, so compare deltas
+	noPorts := sampleValue(t, "network_udp_no_ports_total")
+	demux := udp.NewDemultiplexer()
+	demux.Deliver(udp.NewPacket(1234, 9, []byte("x")), udp.Address{})
+	if got := sampleValue(t, "network_udp_no_ports_total"); got != noPorts+1 {
+		t.Errorf("udp no ports = %v, want %v", got, noPorts+1)
+	}
+
+	forwarded := sampleValue(t, "network_ip_forwarded_total")
+	expired := sampleValue(t, "network_ip_ttl_exceeded_total")
+	pkt := &ip.Packet{TTL: 2}
+	pkt.DecrementTTL()
+	pkt.DecrementTTL()
+	if got := sampleValue(t, "network_ip_forwarded_total"); got != forwarded+1 {
+		t.Errorf("ip forwarded = %v, want %v", got, forwarded+1)
+	}
+	if got := sampleValue(t, "network_ip_ttl_exceeded_total"); got != expired+1 {
+		t.Errorf("ip ttl exceeded = %v, want %v", got, expired+1)
+	}
+}
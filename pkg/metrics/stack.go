@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/http"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// namespace prefixes the names of the stack's metrics.
+const namespace = "network_"
+
+// Default is the registry of the stack's counters served by Handler.
+var Default = NewStackRegistry()
+
+// Handler returns an HTTP handler that serves the Default registry.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// NewStackRegistry creates a registry reporting the counters of every
+// protocol package.
+func NewStackRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(EthernetCollector)
+	r.Register(IPCollector)
+	r.Register(TCPCollector)
+	r.Register(UDPCollector)
+	r.Register(ARPCollector)
+	return r
+}
+
+// EthernetCollector reports the ethernet frame counters.
+func EthernetCollector() []Family {
+	s := ethernet.GetStats()
+	return []Family{
+		counter("ethernet_frames_received_total", "Frames read from interfaces.", s.FramesReceived),
+		counter("ethernet_frames_sent_total", "Frames sent on interfaces.", s.FramesSent),
+		counter("ethernet_received_bytes_total", "Bytes of frames read from interfaces.", s.BytesReceived),
+		counter("ethernet_sent_bytes_total", "Bytes of frames sent on interfaces.", s.BytesSent),
+		counter("ethernet_receive_drops_total", "Received frames dropped as malformed or for another VLAN.", s.RxDropped),
+		counter("ethernet_fcs_errors_total", "Received frames dropped for a bad FCS.", s.FCSErrors),
+		counter("ethernet_transmit_errors_total", "Frames that failed to send.", s.TxErrors),
+	}
+}
+
+// IPCollector reports the IPv4 counters.
+func IPCollector() []Family {
+	s := ip.GetStats()
+	return []Family{
+		counter("ip_forwarded_total", "Packets forwarded.", s.Forwarded),
+		counter("ip_ttl_exceeded_total", "Packets discarded because the TTL reached zero.", s.TTLExceeded),
+		counter("ip_checksum_errors_total", "Packets with a bad header checksum.", s.ChecksumErrors),
+		counter("ip_fragmented_total", "Packets split into fragments.", s.FragmentedPackets),
+		counter("ip_fragments_created_total", "Fragments produced by fragmentation.", s.FragmentsCreated),
+		counter("ip_fragments_received_total", "Fragments received for reassembly.", s.FragmentsReceived),
+		counter("ip_reassembled_total", "Packets reassembled from fragments.", s.Reassembled),
+		counter("ip_reassembly_timeouts_total", "Incomplete packets discarded on reassembly timeout.", s.ReassemblyTimeouts),
+		counter("ip_reassembly_overlaps_total", "Incomplete packets discarded for overlapping fragments.", s.ReassemblyOverlaps),
+		counter("ip_reassembly_errors_total", "Invalid fragments received.", s.ReassemblyErrors),
+		counter("ip_reassembly_evictions_total", "Incomplete packets evicted to stay within the reassembly memory budget.", s.ReassemblyEvictions),
+		counter("ip_redirects_accepted_total", "ICMP redirects applied to the routing table.", s.RedirectsAccepted),
+		counter("ip_redirects_rejected_total", "ICMP redirects refused.", s.RedirectsRejected),
+	}
+}
+
+// TCPCollector reports the TCP counters summed over all connections.
+func TCPCollector() []Family {
+	s := tcp.GetStackStats()
+	return []Family{
+		counter("tcp_active_opens_total", "Connections opened actively.", s.ActiveOpens),
+		counter("tcp_passive_opens_total", "Connections opened passively.", s.PassiveOpens),
+		counter("tcp_established_total", "Connections that reached ESTABLISHED.", s.Established),
+		counter("tcp_segments_sent_total", "Segments sent, including retransmissions.", s.SegmentsSent),
+		counter("tcp_segments_received_total", "Segments received.", s.SegmentsReceived),
+		counter("tcp_retransmissions_total", "Segments retransmitted.", s.Retransmissions),
+		counter("tcp_resets_sent_total", "Segments sent with RST.", s.ResetsSent),
+		counter("tcp_resets_received_total", "Segments received with RST.", s.ResetsReceived),
+		counter("tcp_checksum_errors_total", "Segments with a bad checksum.", s.ChecksumErrors),
+		counter("tcp_segments_rejected_total", "Segments outside the receive or send window.", s.SegmentsRejected),
+		counter("tcp_challenge_acks_total", "Challenge ACKs sent for suspicious segments.", s.ChallengeACKs),
+	}
+}
+
+// UDPCollector reports the UDP counters.
+func UDPCollector() []Family {
+	s := udp.GetStats()
+	return []Family{
+		counter("udp_datagrams_sent_total", "Datagrams sent.", s.DatagramsSent),
+		counter("udp_datagrams_received_total", "Datagrams delivered to sockets.", s.DatagramsReceived),
+		counter("udp_no_ports_total", "Datagrams for a port with no socket.", s.NoPorts),
+		counter("udp_receive_errors_total", "Datagrams dropped because a socket's queue was full.", s.ReceiveErrors),
+		counter("udp_checksum_errors_total", "Datagrams with a bad checksum.", s.ChecksumErrors),
+		counter("udp_icmp_errors_total", "ICMP errors reported to sockets.", s.ICMPErrors),
+	}
+}
+
+// ARPCollector reports the ARP counters.
+func ARPCollector() []Family {
+	s := arp.GetStats()
+	return []Family{
+		counter("arp_requests_sent_total", "Requests sent, including announcements.", s.RequestsSent),
+		counter("arp_requests_received_total", "Requests received.", s.RequestsReceived),
+		counter("arp_replies_sent_total", "Replies sent.", s.RepliesSent),
+		counter("arp_replies_received_total", "Replies received.", s.RepliesReceived),
+		counter("arp_cache_hits_total", "Resolutions answered from the cache.", s.CacheHits),
+		counter("arp_cache_misses_total", "Resolutions that needed a request.", s.CacheMisses),
+		counter("arp_timeouts_total", "Resolutions that got no reply.", s.Timeouts),
+		counter("arp_proxy_replies_total", "Replies sent for proxied networks.", s.ProxyReplies),
+		counter("arp_mac_changes_total", "Updates changing the MAC address of a cache entry.", s.MACChanges),
+	}
+}
+
+// counter builds a single-sample counter family in the stack namespace.
+func counter(name, help string, value uint64) Family {
+	return Family{
+		Name:    namespace + name,
+		Help:    help,
+		Type:    Counter,
+		Samples: []Sample{{Value: float64(value)}},
+	}
+}
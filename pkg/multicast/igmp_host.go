@@ -0,0 +1,806 @@
+package multicast
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+// IGMP host protocol defaults (RFC 2236 Section 8, RFC 3376 Section 8).
+const (
+	// DefaultIGMPRobustness is the number of times unsolicited reports are sent.
+	DefaultIGMPRobustness = 2
+
+	// DefaultIGMPv2UnsolicitedInterval is the spacing of repeated IGMPv2 join reports.
+	DefaultIGMPv2UnsolicitedInterval = 10 * time.Second
+
+	// DefaultIGMPv3UnsolicitedInterval is the spacing of repeated IGMPv3 state-change reports.
+	DefaultIGMPv3UnsolicitedInterval = 1 * time.Second
+
+	// DefaultIGMPQueryInterval is the querier's default general query interval.
+	DefaultIGMPQueryInterval = 125 * time.Second
+
+	// DefaultIGMPQueryResponseInterval is the default max response time of a query.
+	DefaultIGMPQueryResponseInterval = 10 * time.Second
+
+	// IGMPv1MaxRespTime is the response time used for IGMPv1 queries (10s in deciseconds).
+	IGMPv1MaxRespTime = 100
+)
+
+// routerAlertOption is the IPv4 Router Alert option (RFC 2113) carried by IGMP packets.
+var routerAlertOption = []byte{0x94, 0x04, 0x00, 0x00}
+
+// FilterMode is the source filter mode of a group membership (RFC 3376).
+type FilterMode uint8
+
+const (
+	// FilterModeInclude receives traffic only from the listed sources.
+	FilterModeInclude FilterMode = 1
+
+	// FilterModeExclude receives traffic from all but the listed sources.
+	// EXCLUDE with an empty source list is an ordinary (any-source) join.
+	FilterModeExclude FilterMode = 2
+)
+
+// String returns a human-readable representation of the filter mode.
+func (m FilterMode) String() string {
+	switch m {
+	case FilterModeInclude:
+		return "INCLUDE"
+	case FilterModeExclude:
+		return "EXCLUDE"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(m))
+	}
+}
+
+// IGMPSendFunc transmits an IGMP message to the given destination address.
+// The caller is responsible for the IP (TTL 1, Router Alert) and link-layer
+// encapsulation; see NewIGMPDeviceSender.
+type IGMPSendFunc func(dst common.IPv4Address, payload []byte) error
+
+// IGMPConfig configures an IGMP host.
+type IGMPConfig struct {
+	// Version is the highest IGMP version to use: 2 or 3 (default 3).
+	// A version 3 host falls back to version 2 while an IGMPv1/v2 querier is present.
+	Version int
+
+	// Robustness is the number of times unsolicited reports are sent (default 2).
+	Robustness int
+
+	// UnsolicitedReportInterval is the spacing of repeated unsolicited
+	// reports (default 10s for IGMPv2, 1s for IGMPv3).
+	UnsolicitedReportInterval time.Duration
+
+	// OlderVersionQuerierTimeout is how long IGMPv2 compatibility mode lasts
+	// after an IGMPv1/v2 query (default Robustness*125s + 10s).
+	OlderVersionQuerierTimeout time.Duration
+}
+
+// IGMPStats holds IGMP host counters.
+type IGMPStats struct {
+	ReportsSent       uint64 // Membership reports sent (v2 and v3)
+	LeavesSent        uint64 // IGMPv2 leave messages sent
+	QueriesReceived   uint64 // Membership queries received
+	ReportsSuppressed uint64 // IGMPv2 reports suppressed by another member's report
+}
+
+// IGMPMembership describes the state of one group on the host.
+type IGMPMembership struct {
+	Group   common.IPv4Address
+	Mode    FilterMode
+	Sources []common.IPv4Address
+}
+
+// igmpGroup is the per-group host state.
+type igmpGroup struct {
+	addr    common.IPv4Address
+	mode    FilterMode
+	sources []common.IPv4Address
+
+	// Pending query response (IGMPv2 "Delaying Member" state)
+	delayTimer    *time.Timer
+	delayDeadline time.Time
+	querySources  []common.IPv4Address // Sources of a pending group-and-source-specific query
+
+	// lastReporter is set when this host sent the most recent IGMPv2 report
+	lastReporter bool
+
+	// Unsolicited report retransmission. A group left in IGMPv3 stays,
+	// as INCLUDE {}, until its state-change report has been repeated.
+	retransmitTimer *time.Timer
+	retransmitsLeft int
+}
+
+// member reports whether the host is in the group, rather than waiting to
+// repeat the report of having left it.
+func (g *igmpGroup) member() bool {
+	return g.mode == FilterModeExclude || len(g.sources) > 0
+}
+
+// igmpOutgoing is a message queued for transmission once the host lock is released.
+type igmpOutgoing struct {
+	dst     common.IPv4Address
+	payload []byte
+	leave   bool
+}
+
+// IGMPHost implements the IGMPv2 (RFC 2236) and IGMPv3 (RFC 3376) host
+// protocol: it sends unsolicited reports on join, leave messages (or IGMPv3
+// state-change reports) on leave, and answers membership queries after a
+// random delay.
+type IGMPHost struct {
+	mu             sync.Mutex
+	config         IGMPConfig
+	send           IGMPSendFunc
+	groups         map[common.IPv4Address]*igmpGroup
+	v2QuerierUntil time.Time // IGMPv2 compatibility mode deadline
+	generalTimer   *time.Timer
+	generalAt      time.Time
+	rng            *rand.Rand
+	stats          IGMPStats
+	closed         bool
+}
+
+// NewIGMPHost creates an IGMP host that transmits through send.
+func NewIGMPHost(send IGMPSendFunc, config IGMPConfig) *IGMPHost {
+	if config.Version != 2 {
+		config.Version = 3
+	}
+	if config.Robustness <= 0 {
+		config.Robustness = DefaultIGMPRobustness
+	}
+	if config.OlderVersionQuerierTimeout <= 0 {
+		config.OlderVersionQuerierTimeout = time.Duration(config.Robustness)*DefaultIGMPQueryInterval +
+			DefaultIGMPQueryResponseInterval
+	}
+
+	return &IGMPHost{
+		config: config,
+		send:   send,
+		groups: make(map[common.IPv4Address]*igmpGroup),
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Version returns the IGMP version currently in use (2 or 3).
+func (h *IGMPHost) Version() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.version()
+}
+
+// version returns the compatibility mode. Caller must hold h.mu.
+func (h *IGMPHost) version() int {
+	if h.config.Version == 2 || time.Now().Before(h.v2QuerierUntil) {
+		return 2
+	}
+	return 3
+}
+
+// unsolicitedInterval returns the spacing of repeated reports. Caller must hold h.mu.
+func (h *IGMPHost) unsolicitedInterval() time.Duration {
+	if h.config.UnsolicitedReportInterval > 0 {
+		return h.config.UnsolicitedReportInterval
+	}
+	if h.version() == 2 {
+		return DefaultIGMPv2UnsolicitedInterval
+	}
+	return DefaultIGMPv3UnsolicitedInterval
+}
+
+// Join joins a group for all sources (EXCLUDE {}).
+func (h *IGMPHost) Join(group common.IPv4Address) error {
+	return h.SetFilter(group, FilterModeExclude, nil)
+}
+
+// JoinSources joins a group for the given sources only (INCLUDE {sources}).
+// This requires IGMPv3; in IGMPv2 compatibility mode it behaves like Join.
+func (h *IGMPHost) JoinSources(group common.IPv4Address, sources []common.IPv4Address) error {
+	return h.SetFilter(group, FilterModeInclude, sources)
+}
+
+// Leave leaves a group (INCLUDE {}).
+func (h *IGMPHost) Leave(group common.IPv4Address) error {
+	return h.SetFilter(group, FilterModeInclude, nil)
+}
+
+// SetFilter sets the source filter for a group and emits the reports
+// required by the resulting state change.
+func (h *IGMPHost) SetFilter(group common.IPv4Address, mode FilterMode, sources []common.IPv4Address) error {
+	if !IsMulticastIPv4(group) {
+		return fmt.Errorf("not a multicast address: %s", group)
+	}
+	if mode != FilterModeInclude && mode != FilterModeExclude {
+		return fmt.Errorf("invalid filter mode: %d", mode)
+	}
+	sources = normalizeSources(sources)
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return fmt.Errorf("IGMP host closed")
+	}
+
+	// A group we are not in is INCLUDE {}
+	oldMode := FilterModeInclude
+	var oldSources []common.IPv4Address
+	g, exists := h.groups[group]
+	if exists {
+		oldMode = g.mode
+		oldSources = g.sources
+	}
+
+	if oldMode == mode && sourcesEqual(oldSources, sources) {
+		h.mu.Unlock()
+		return nil
+	}
+
+	wasMember := oldMode == FilterModeExclude || len(oldSources) > 0
+	isMember := mode == FilterModeExclude || len(sources) > 0
+
+	if !exists {
+		g = &igmpGroup{addr: group}
+		h.groups[group] = g
+	}
+	g.mode = mode
+	g.sources = sources
+
+	var out []igmpOutgoing
+
+	// The all-hosts group is never reported (RFC 2236 Section 6)
+	if group != AllHostsMulticast {
+		if h.version() == 2 {
+			switch {
+			case !wasMember && isMember:
+				out = append(out, h.v2Report(g))
+				h.scheduleRetransmit(g)
+			case wasMember && !isMember:
+				if g.lastReporter {
+					out = append(out, igmpOutgoing{dst: AllRoutersMulticast, payload: mustSerialize(NewLeaveGroup(group)), leave: true})
+				}
+			}
+		} else {
+			records := stateChangeRecords(group, oldMode, oldSources, mode, sources)
+			if len(records) > 0 {
+				out = append(out, h.v3Report(records))
+				h.scheduleRetransmit(g)
+			}
+		}
+	}
+
+	// Leaving removes all state for the group, once an IGMPv3
+	// state-change report has been repeated Robustness times (RFC 3376
+	// Section 5.1)
+	if !isMember {
+		if h.version() == 2 || g.retransmitTimer == nil {
+			h.stopGroupTimers(g)
+			delete(h.groups, group)
+		} else if g.delayTimer != nil {
+			g.delayTimer.Stop()
+			g.delayTimer = nil
+		}
+	}
+	h.mu.Unlock()
+
+	return h.flush(out)
+}
+
+// HandleMessage processes an IGMP message received from src.
+func (h *IGMPHost) HandleMessage(src common.IPv4Address, data []byte) error {
+	if len(data) < IGMPHeaderLen {
+		return fmt.Errorf("IGMP message too short: %d bytes", len(data))
+	}
+	if common.CalculateChecksum(data) != 0 {
+		return fmt.Errorf("IGMP checksum verification failed")
+	}
+
+	switch data[0] {
+	case IGMPMembershipQuery:
+		return h.handleQuery(data)
+	case IGMPv1MembershipReport, IGMPv2MembershipReport:
+		msg, err := ParseIGMP(data)
+		if err != nil {
+			return err
+		}
+		h.handleReport(msg.GroupAddress)
+	}
+
+	// IGMPv3 reports and leaves from other hosts need no action
+	return nil
+}
+
+// handleQuery processes a membership query.
+func (h *IGMPHost) handleQuery(data []byte) error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.stats.QueriesReceived++
+
+	var group common.IPv4Address
+	var maxResp int // Deciseconds
+	var sources []common.IPv4Address
+
+	if len(data) >= IGMPv3QueryMinLen {
+		q, err := ParseIGMPv3Query(data)
+		if err != nil {
+			h.mu.Unlock()
+			return err
+		}
+		group, maxResp, sources = q.GroupAddress, q.MaxRespTime(), q.Sources
+	} else {
+		msg, err := ParseIGMP(data)
+		if err != nil {
+			h.mu.Unlock()
+			return err
+		}
+		group, maxResp = msg.GroupAddress, int(msg.MaxRespTime)
+		if maxResp == 0 {
+			maxResp = IGMPv1MaxRespTime
+		}
+
+		// An older querier is present: fall back to IGMPv2
+		h.v2QuerierUntil = time.Now().Add(h.config.OlderVersionQuerierTimeout)
+	}
+
+	if maxResp <= 0 {
+		maxResp = 1
+	}
+	maxDelay := time.Duration(maxResp) * 100 * time.Millisecond
+	general := group == common.IPv4Address{}
+
+	if h.version() == 2 {
+		// IGMPv2: per-group timers, sources are ignored
+		for _, g := range h.groups {
+			if (general || g.addr == group) && g.addr != AllHostsMulticast && g.member() {
+				h.startDelayTimer(g, maxDelay, nil)
+			}
+		}
+	} else if general {
+		// IGMPv3 general query: one interface-wide report
+		h.startGeneralTimer(maxDelay)
+	} else if g, ok := h.groups[group]; ok && g.addr != AllHostsMulticast && g.member() {
+		h.startDelayTimer(g, maxDelay, sources)
+	}
+
+	h.mu.Unlock()
+	return nil
+}
+
+// handleReport processes an IGMPv1/v2 report from another member.
+func (h *IGMPHost) handleReport(group common.IPv4Address) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Report suppression only applies in IGMPv2 mode
+	if h.version() != 2 {
+		return
+	}
+
+	g, ok := h.groups[group]
+	if !ok || g.delayTimer == nil {
+		return
+	}
+
+	g.delayTimer.Stop()
+	g.delayTimer = nil
+	g.lastReporter = false
+	h.stats.ReportsSuppressed++
+}
+
+// startDelayTimer schedules a response for a group query, keeping an
+// earlier pending response. Caller must hold h.mu.
+func (h *IGMPHost) startDelayTimer(g *igmpGroup, maxDelay time.Duration, sources []common.IPv4Address) {
+	delay := time.Duration(h.rng.Int63n(int64(maxDelay) + 1))
+	deadline := time.Now().Add(delay)
+
+	if g.delayTimer != nil {
+		// A group-and-source-specific query adds to the pending source list
+		// only if the pending response is also source-specific
+		if len(sources) == 0 {
+			g.querySources = nil
+		} else if len(g.querySources) > 0 {
+			g.querySources = normalizeSources(append(g.querySources, sources...))
+		}
+
+		if !deadline.Before(g.delayDeadline) {
+			return
+		}
+		g.delayTimer.Stop()
+	} else {
+		g.querySources = normalizeSources(sources)
+	}
+
+	addr := g.addr
+	g.delayDeadline = deadline
+	g.delayTimer = time.AfterFunc(delay, func() { h.groupTimerExpired(addr) })
+}
+
+// startGeneralTimer schedules a response to an IGMPv3 general query.
+// Caller must hold h.mu.
+func (h *IGMPHost) startGeneralTimer(maxDelay time.Duration) {
+	delay := time.Duration(h.rng.Int63n(int64(maxDelay) + 1))
+	deadline := time.Now().Add(delay)
+
+	if h.generalTimer != nil {
+		if !deadline.Before(h.generalAt) {
+			return
+		}
+		h.generalTimer.Stop()
+	}
+
+	h.generalAt = deadline
+	h.generalTimer = time.AfterFunc(delay, h.generalTimerExpired)
+}
+
+// groupTimerExpired sends the pending response for a group query.
+func (h *IGMPHost) groupTimerExpired(addr common.IPv4Address) {
+	h.mu.Lock()
+	g, ok := h.groups[addr]
+	if !ok || h.closed || g.delayTimer == nil {
+		h.mu.Unlock()
+		return
+	}
+	g.delayTimer = nil
+	querySources := g.querySources
+	g.querySources = nil
+
+	var out []igmpOutgoing
+	if h.version() == 2 {
+		out = append(out, h.v2Report(g))
+	} else if rec, ok := currentStateRecord(g, querySources); ok {
+		out = append(out, h.v3Report([]IGMPv3GroupRecord{rec}))
+	}
+	h.mu.Unlock()
+
+	h.flush(out)
+}
+
+// generalTimerExpired sends the current state of all groups (IGMPv3).
+func (h *IGMPHost) generalTimerExpired() {
+	h.mu.Lock()
+	if h.closed || h.generalTimer == nil {
+		h.mu.Unlock()
+		return
+	}
+	h.generalTimer = nil
+
+	var records []IGMPv3GroupRecord
+	for _, g := range h.sortedGroups() {
+		if g.addr == AllHostsMulticast || !g.member() {
+			continue
+		}
+		if rec, ok := currentStateRecord(g, nil); ok {
+			records = append(records, rec)
+		}
+	}
+
+	var out []igmpOutgoing
+	if len(records) > 0 && h.version() == 3 {
+		out = append(out, h.v3Report(records))
+	}
+	h.mu.Unlock()
+
+	h.flush(out)
+}
+
+// scheduleRetransmit arranges for the unsolicited report for g to be
+// repeated Robustness-1 more times. Caller must hold h.mu.
+func (h *IGMPHost) scheduleRetransmit(g *igmpGroup) {
+	if g.retransmitTimer != nil {
+		g.retransmitTimer.Stop()
+	}
+	g.retransmitsLeft = h.config.Robustness - 1
+	h.armRetransmit(g)
+}
+
+// armRetransmit starts the next retransmission timer. Caller must hold h.mu.
+func (h *IGMPHost) armRetransmit(g *igmpGroup) {
+	if g.retransmitsLeft <= 0 {
+		g.retransmitTimer = nil
+		return
+	}
+
+	interval := h.unsolicitedInterval()
+	delay := time.Duration(h.rng.Int63n(int64(interval) + 1))
+	addr := g.addr
+	g.retransmitTimer = time.AfterFunc(delay, func() { h.retransmitExpired(addr) })
+}
+
+// retransmitExpired repeats the unsolicited report for a group. The current
+// state is reported, so changes made in the meantime are folded in.
+func (h *IGMPHost) retransmitExpired(addr common.IPv4Address) {
+	h.mu.Lock()
+	g, ok := h.groups[addr]
+	if !ok || h.closed || g.retransmitTimer == nil {
+		h.mu.Unlock()
+		return
+	}
+	g.retransmitsLeft--
+
+	var out []igmpOutgoing
+	if h.version() == 2 {
+		// An IGMPv2 querier hears of the leave no further
+		if g.member() {
+			out = append(out, h.v2Report(g))
+		} else {
+			g.retransmitsLeft = 0
+		}
+	} else {
+		// Report as a filter mode change, which a router treats as authoritative
+		rec, _ := currentStateRecord(g, nil)
+		if rec.Type == RecordModeIsExclude {
+			rec.Type = RecordChangeToExclude
+		} else {
+			rec.Type = RecordChangeToInclude
+		}
+		out = append(out, h.v3Report([]IGMPv3GroupRecord{rec}))
+	}
+	h.armRetransmit(g)
+	if g.retransmitTimer == nil && !g.member() {
+		delete(h.groups, addr)
+	}
+	h.mu.Unlock()
+
+	h.flush(out)
+}
+
+// v2Report builds an IGMPv2 membership report. Caller must hold h.mu.
+func (h *IGMPHost) v2Report(g *igmpGroup) igmpOutgoing {
+	g.lastReporter = true
+	return igmpOutgoing{dst: g.addr, payload: mustSerialize(NewMembershipReport(g.addr))}
+}
+
+// v3Report builds an IGMPv3 membership report. Caller must hold h.mu.
+func (h *IGMPHost) v3Report(records []IGMPv3GroupRecord) igmpOutgoing {
+	payload, _ := (&IGMPv3Report{Records: records}).Serialize()
+	return igmpOutgoing{dst: AllIGMPv3RoutersMulticast, payload: payload}
+}
+
+// flush transmits queued messages. Must be called without h.mu held.
+func (h *IGMPHost) flush(out []igmpOutgoing) error {
+	var firstErr error
+	for _, msg := range out {
+		if err := h.send(msg.dst, msg.payload); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to send IGMP message: %w", err)
+			}
+			continue
+		}
+
+		h.mu.Lock()
+		if msg.leave {
+			h.stats.LeavesSent++
+		} else {
+			h.stats.ReportsSent++
+		}
+		h.mu.Unlock()
+	}
+	return firstErr
+}
+
+// stopGroupTimers stops all timers of a group. Caller must hold h.mu.
+func (h *IGMPHost) stopGroupTimers(g *igmpGroup) {
+	if g.delayTimer != nil {
+		g.delayTimer.Stop()
+		g.delayTimer = nil
+	}
+	if g.retransmitTimer != nil {
+		g.retransmitTimer.Stop()
+		g.retransmitTimer = nil
+	}
+}
+
+// sortedGroups returns the groups ordered by address. Caller must hold h.mu.
+func (h *IGMPHost) sortedGroups() []*igmpGroup {
+	groups := make([]*igmpGroup, 0, len(h.groups))
+	for _, g := range h.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return bytes.Compare(groups[i].addr[:], groups[j].addr[:]) < 0
+	})
+	return groups
+}
+
+// Groups returns the current group memberships.
+func (h *IGMPHost) Groups() []IGMPMembership {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	memberships := make([]IGMPMembership, 0, len(h.groups))
+	for _, g := range h.sortedGroups() {
+		if !g.member() {
+			continue
+		}
+		memberships = append(memberships, IGMPMembership{
+			Group:   g.addr,
+			Mode:    g.mode,
+			Sources: append([]common.IPv4Address(nil), g.sources...),
+		})
+	}
+	return memberships
+}
+
+// Stats returns a snapshot of the host counters.
+func (h *IGMPHost) Stats() IGMPStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
+
+// Close stops all timers. Memberships are not left; call Leave first to
+// notify routers.
+func (h *IGMPHost) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, g := range h.groups {
+		h.stopGroupTimers(g)
+	}
+	if h.generalTimer != nil {
+		h.generalTimer.Stop()
+		h.generalTimer = nil
+	}
+}
+
+// currentStateRecord builds the current state record for a group, restricted
+// to querySources for a group-and-source-specific query (RFC 3376 Section 5.2).
+// ok is false if there is nothing to report.
+func currentStateRecord(g *igmpGroup, querySources []common.IPv4Address) (IGMPv3GroupRecord, bool) {
+	if len(querySources) == 0 {
+		recType := RecordModeIsInclude
+		if g.mode == FilterModeExclude {
+			recType = RecordModeIsExclude
+		}
+		return IGMPv3GroupRecord{Type: recType, GroupAddress: g.addr, Sources: g.sources}, true
+	}
+
+	var sources []common.IPv4Address
+	if g.mode == FilterModeInclude {
+		sources = sourcesIntersect(g.sources, querySources)
+	} else {
+		sources = sourcesMinus(querySources, g.sources)
+	}
+	if len(sources) == 0 {
+		return IGMPv3GroupRecord{}, false
+	}
+	return IGMPv3GroupRecord{Type: RecordModeIsInclude, GroupAddress: g.addr, Sources: sources}, true
+}
+
+// stateChangeRecords computes the IGMPv3 state-change records for a filter
+// change (RFC 3376 Section 5.1).
+func stateChangeRecords(group common.IPv4Address, oldMode FilterMode, oldSources []common.IPv4Address,
+	newMode FilterMode, newSources []common.IPv4Address) []IGMPv3GroupRecord {
+	if oldMode != newMode {
+		recType := RecordChangeToInclude
+		if newMode == FilterModeExclude {
+			recType = RecordChangeToExclude
+		}
+		return []IGMPv3GroupRecord{{Type: recType, GroupAddress: group, Sources: newSources}}
+	}
+
+	// Same mode: report the sources that were allowed and blocked
+	var allow, block []common.IPv4Address
+	if newMode == FilterModeInclude {
+		allow = sourcesMinus(newSources, oldSources)
+		block = sourcesMinus(oldSources, newSources)
+	} else {
+		allow = sourcesMinus(oldSources, newSources)
+		block = sourcesMinus(newSources, oldSources)
+	}
+
+	var records []IGMPv3GroupRecord
+	if len(allow) > 0 {
+		records = append(records, IGMPv3GroupRecord{Type: RecordAllowNewSources, GroupAddress: group, Sources: allow})
+	}
+	if len(block) > 0 {
+		records = append(records, IGMPv3GroupRecord{Type: RecordBlockOldSources, GroupAddress: group, Sources: block})
+	}
+	return records
+}
+
+// normalizeSources returns a sorted, de-duplicated copy of sources.
+func normalizeSources(sources []common.IPv4Address) []common.IPv4Address {
+	if len(sources) == 0 {
+		return nil
+	}
+	out := append([]common.IPv4Address(nil), sources...)
+	sort.Slice(out, func(i, j int) bool { return bytes.Compare(out[i][:], out[j][:]) < 0 })
+
+	n := 1
+	for i := 1; i < len(out); i++ {
+		if out[i] != out[n-1] {
+			out[n] = out[i]
+			n++
+		}
+	}
+	return out[:n]
+}
+
+// sourcesEqual reports whether two normalized source lists are equal.
+func sourcesEqual(a, b []common.IPv4Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sourcesMinus returns the sources in a that are not in b.
+func sourcesMinus(a, b []common.IPv4Address) []common.IPv4Address {
+	var out []common.IPv4Address
+	for _, s := range a {
+		if !containsSource(b, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// sourcesIntersect returns the sources in both a and b.
+func sourcesIntersect(a, b []common.IPv4Address) []common.IPv4Address {
+	var out []common.IPv4Address
+	for _, s := range a {
+		if containsSource(b, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// containsSource reports whether list contains s.
+func containsSource(list []common.IPv4Address, s common.IPv4Address) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// mustSerialize serializes an IGMP message that cannot fail to encode.
+func mustSerialize(msg *IGMPMessage) []byte {
+	data, _ := msg.Serialize()
+	return data
+}
+
+// IPv4MulticastMAC returns the Ethernet address for an IPv4 multicast group
+// (01:00:5e followed by the low 23 bits of the group, RFC 1112).
+func IPv4MulticastMAC(group common.IPv4Address) common.MACAddress {
+	return common.IPv4MulticastMAC(group)
+}
+
+// NewIGMPDeviceSender returns an IGMPSendFunc that transmits IGMP messages
+// on dev from localIP, encapsulated in IPv4 (TTL 1, Router Alert option,
+// internetwork-control precedence) and Ethernet.
+func NewIGMPDeviceSender(dev ethernet.Device, localIP common.IPv4Address) IGMPSendFunc {
+	return func(dst common.IPv4Address, payload []byte) error {
+		pkt := ip.NewPacket(localIP, dst, common.ProtocolIGMP, payload)
+		pkt.TTL = 1
+		pkt.DSCP = 0x30 // TOS 0xC0
+		pkt.Options = routerAlertOption
+
+		data, err := pkt.Serialize()
+		if err != nil {
+			return fmt.Errorf("failed to serialize IP packet: %w", err)
+		}
+
+		frame := ethernet.NewFrame(IPv4MulticastMAC(dst), dev.MACAddress(), common.EtherTypeIPv4, data)
+		return dev.WriteFrame(frame)
+	}
+}
@@ -0,0 +1,428 @@
+package multicast
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+)
+
+// igmpRecorder captures messages sent by an IGMP host.
+type igmpRecorder struct {
+	mu   sync.Mutex
+	msgs []igmpOutgoing
+}
+
+func (r *igmpRecorder) send(dst common.IPv4Address, payload []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, igmpOutgoing{dst: dst, payload: payload})
+	return nil
+}
+
+func (r *igmpRecorder) all() []igmpOutgoing {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]igmpOutgoing(nil), r.msgs...)
+}
+
+func (r *igmpRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = nil
+}
+
+// waitFor polls until the recorder holds at least n messages.
+func (r *igmpRecorder) waitFor(t *testing.T, n int) []igmpOutgoing {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if msgs := r.all(); len(msgs) >= n {
+			return msgs
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("got %d IGMP messages, want %d", len(r.all()), n)
+	return nil
+}
+
+// parseV3 parses a recorded IGMPv3 report.
+func parseV3(t *testing.T, msg igmpOutgoing) *IGMPv3Report {
+	t.Helper()
+	if msg.dst != AllIGMPv3RoutersMulticast {
+		t.Errorf("report sent to %s, want %s", msg.dst, AllIGMPv3RoutersMulticast)
+	}
+	report, err := ParseIGMPv3Report(msg.payload)
+	if err != nil {
+		t.Fatalf("ParseIGMPv3Report() error = %v", err)
+	}
+	return report
+}
+
+var testGroup = common.IPv4Address{239, 1, 2, 3}
+
+func TestIGMPv3JoinLeave(t *testing.T) {
+	rec := &igmpRecorder{}
+	host := NewIGMPHost(rec.send, IGMPConfig{UnsolicitedReportInterval: 10 * time.Millisecond})
+	defer host.Close()
+
+	if err := host.Join(testGroup); err != nil {
+		t.Fatalf("Join() error = %v", err)
+	}
+
+	// Initial report plus one retransmission (robustness 2)
+	msgs := rec.waitFor(t, 2)
+	for _, msg := range msgs {
+		report := parseV3(t, msg)
+		if len(report.Records) != 1 || report.Records[0].Type != RecordChangeToExclude ||
+			report.Records[0].GroupAddress != testGroup {
+			t.Errorf("join report = %+v, want TO_EX(%s, {})", report.Records, testGroup)
+		}
+	}
+
+	rec.reset()
+	if err := host.Leave(testGroup); err != nil {
+		t.Fatalf("Leave() error = %v", err)
+	}
+	msgs = rec.waitFor(t, 1)
+	report := parseV3(t, msgs[0])
+	if len(report.Records) != 1 || report.Records[0].Type != RecordChangeToInclude || len(report.Records[0].Sources) != 0 {
+		t.Errorf("leave report = %+v, want TO_IN({})", report.Records)
+	}
+	if len(host.Groups()) != 0 {
+		t.Errorf("Groups() = %v, want none after leave", host.Groups())
+	}
+}
+
+func TestIGMPv3LeaveRetransmitted(t *testing.T) {
+	rec := &igmpRecorder{}
+	host := NewIGMPHost(rec.send, IGMPConfig{Robustness: 3, UnsolicitedReportInterval: 5 * time.Millisecond})
+	defer host.Close()
+
+	if err := host.Join(testGroup); err != nil {
+		t.Fatalf("Join() error = %v", err)
+	}
+	rec.waitFor(t, 3)
+	rec.reset()
+
+	// The leave is reported Robustness times, and the group is gone from
+	// Groups meanwhile
+	if err := host.Leave(testGroup); err != nil {
+		t.Fatalf("Leave() error = %v", err)
+	}
+	if len(host.Groups()) != 0 {
+		t.Errorf("Groups() = %v, want none after leave", host.Groups())
+	}
+	for _, msg := range rec.waitFor(t, 3) {
+		report := parseV3(t, msg)
+		if len(report.Records) != 1 || report.Records[0].Type != RecordChangeToInclude || len(report.Records[0].Sources) != 0 {
+			t.Errorf("leave report = %+v, want TO_IN({})", report.Records)
+		}
+	}
+
+	// Then the host forgets the group
+	time.Sleep(50 * time.Millisecond)
+	if n := len(rec.all()); n != 3 {
+		t.Errorf("sent %d leave reports, want 3", n)
+	}
+	host.mu.Lock()
+	n := len(host.groups)
+	host.mu.Unlock()
+	if n != 0 {
+		t.Errorf("host keeps %d groups after the leave was repeated, want none", n)
+	}
+}
+
+func TestIGMPv3SourceFilter(t *testing.T) {
+	rec := &igmpRecorder{}
+	host := NewIGMPHost(rec.send, IGMPConfig{Robustness: 1})
+	defer host.Close()
+
+	s1 := common.IPv4Address{10, 0, 0, 1}
+	s2 := common.IPv4Address{10, 0, 0, 2}
+	s3 := common.IPv4Address{10, 0, 0, 3}
+
+	// INCLUDE {} -> INCLUDE {s1, s2}: ALLOW(s1, s2)
+	host.JoinSources(testGroup, []common.IPv4Address{s2, s1})
+	report := parseV3(t, rec.waitFor(t, 1)[0])
+	if len(report.Records) != 1 || report.Records[0].Type != RecordAllowNewSources || len(report.Records[0].Sources) != 2 {
+		t.Fatalf("report = %+v, want ALLOW(s1, s2)", report.Records)
+	}
+
+	// INCLUDE {s1, s2} -> INCLUDE {s2, s3}: ALLOW(s3), BLOCK(s1)
+	rec.reset()
+	host.JoinSources(testGroup, []common.IPv4Address{s2, s3})
+	report = parseV3(t, rec.waitFor(t, 1)[0])
+	if len(report.Records) != 2 {
+		t.Fatalf("report = %+v, want ALLOW and BLOCK records", report.Records)
+	}
+	if report.Records[0].Type != RecordAllowNewSources || report.Records[0].Sources[0] != s3 {
+		t.Errorf("record 0 = %+v, want ALLOW(s3)", report.Records[0])
+	}
+	if report.Records[1].Type != RecordBlockOldSources || report.Records[1].Sources[0] != s1 {
+		t.Errorf("record 1 = %+v, want BLOCK(s1)", report.Records[1])
+	}
+
+	// Setting the same filter again sends nothing
+	rec.reset()
+	host.JoinSources(testGroup, []common.IPv4Address{s3, s2})
+	if len(rec.all()) != 0 {
+		t.Errorf("unchanged filter sent %d messages, want 0", len(rec.all()))
+	}
+
+	groups := host.Groups()
+	if len(groups) != 1 || groups[0].Mode != FilterModeInclude || len(groups[0].Sources) != 2 {
+		t.Errorf("Groups() = %+v, want INCLUDE {s2, s3}", groups)
+	}
+}
+
+func TestIGMPv3GeneralQuery(t *testing.T) {
+	rec := &igmpRecorder{}
+	host := NewIGMPHost(rec.send, IGMPConfig{Robustness: 1})
+	defer host.Close()
+
+	other := common.IPv4Address{239, 9, 9, 9}
+	host.Join(testGroup)
+	host.Join(other)
+	rec.waitFor(t, 2)
+	rec.reset()
+
+	// General query with a 100ms max response time
+	query, _ := (&IGMPv3Query{MaxRespCode: 1}).Serialize()
+	if err := host.HandleMessage(common.IPv4Address{10, 0, 0, 254}, query); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+
+	report := parseV3(t, rec.waitFor(t, 1)[0])
+	if len(report.Records) != 2 {
+		t.Fatalf("report = %+v, want records for both groups", report.Records)
+	}
+	for _, r := range report.Records {
+		if r.Type != RecordModeIsExclude {
+			t.Errorf("record type = %d, want MODE_IS_EXCLUDE", r.Type)
+		}
+	}
+	if host.Stats().QueriesReceived != 1 {
+		t.Errorf("QueriesReceived = %d, want 1", host.Stats().QueriesReceived)
+	}
+}
+
+func TestIGMPv3GroupAndSourceQuery(t *testing.T) {
+	rec := &igmpRecorder{}
+	host := NewIGMPHost(rec.send, IGMPConfig{Robustness: 1})
+	defer host.Close()
+
+	s1 := common.IPv4Address{10, 0, 0, 1}
+	s2 := common.IPv4Address{10, 0, 0, 2}
+	host.JoinSources(testGroup, []common.IPv4Address{s1})
+	rec.waitFor(t, 1)
+	rec.reset()
+
+	// Ask about s1 and s2; only s1 is included
+	query, _ := (&IGMPv3Query{MaxRespCode: 1, GroupAddress: testGroup, Sources: []common.IPv4Address{s1, s2}}).Serialize()
+	host.HandleMessage(common.IPv4Address{10, 0, 0, 254}, query)
+
+	report := parseV3(t, rec.waitFor(t, 1)[0])
+	if len(report.Records) != 1 || report.Records[0].Type != RecordModeIsInclude ||
+		len(report.Records[0].Sources) != 1 || report.Records[0].Sources[0] != s1 {
+		t.Errorf("report = %+v, want IS_IN(s1)", report.Records)
+	}
+}
+
+func TestIGMPv2Compatibility(t *testing.T) {
+	rec := &igmpRecorder{}
+	host := NewIGMPHost(rec.send, IGMPConfig{Robustness: 1})
+	defer host.Close()
+
+	host.Join(testGroup)
+	rec.waitFor(t, 1)
+	rec.reset()
+
+	// An IGMPv2 general query switches the host to v2 mode
+	query := NewMembershipQuery(common.IPv4Address{}, 1)
+	data, _ := query.Serialize()
+	if err := host.HandleMessage(common.IPv4Address{10, 0, 0, 254}, data); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+	if host.Version() != 2 {
+		t.Fatalf("Version() = %d, want 2", host.Version())
+	}
+
+	msgs := rec.waitFor(t, 1)
+	msg, err := ParseIGMP(msgs[0].payload)
+	if err != nil {
+		t.Fatalf("ParseIGMP() error = %v", err)
+	}
+	if msg.Type != IGMPv2MembershipReport || msg.GroupAddress != testGroup || msgs[0].dst != testGroup {
+		t.Errorf("response = %s to %s, want v2 report for %s", msg, msgs[0].dst, testGroup)
+	}
+
+	// We were the last reporter, so leave sends an IGMPv2 Leave
+	rec.reset()
+	host.Leave(testGroup)
+	msgs = rec.waitFor(t, 1)
+	msg, _ = ParseIGMP(msgs[0].payload)
+	if msg.Type != IGMPv2LeaveGroup || msgs[0].dst != AllRoutersMulticast {
+		t.Errorf("leave = %s to %s, want v2 leave to %s", msg, msgs[0].dst, AllRoutersMulticast)
+	}
+	if host.Stats().LeavesSent != 1 {
+		t.Errorf("LeavesSent = %d, want 1", host.Stats().LeavesSent)
+	}
+}
+
+func TestIGMPv2ReportSuppression(t *testing.T) {
+	rec := &igmpRecorder{}
+	host := NewIGMPHost(rec.send, IGMPConfig{Version: 2, Robustness: 1})
+	defer host.Close()
+
+	host.Join(testGroup)
+	rec.waitFor(t, 1)
+	rec.reset()
+
+	// Query with a long max response time, then another member reports first
+	query, _ := NewMembershipQuery(testGroup, 50).Serialize()
+	host.HandleMessage(common.IPv4Address{10, 0, 0, 254}, query)
+	report, _ := NewMembershipReport(testGroup).Serialize()
+	host.HandleMessage(common.IPv4Address{10, 0, 0, 7}, report)
+
+	// Another member reported last, so leaving is silent
+	host.Leave(testGroup)
+	time.Sleep(20 * time.Millisecond)
+
+	if n := len(rec.all()); n != 0 {
+		t.Errorf("sent %d messages after suppression, want 0", n)
+	}
+	if host.Stats().ReportsSuppressed != 1 {
+		t.Errorf("ReportsSuppressed = %d, want 1", host.Stats().ReportsSuppressed)
+	}
+}
+
+func TestIGMPAllHostsNotReported(t *testing.T) {
+	rec := &igmpRecorder{}
+	host := NewIGMPHost(rec.send, IGMPConfig{})
+	defer host.Close()
+
+	host.Join(AllHostsMulticast)
+	time.Sleep(10 * time.Millisecond)
+	if n := len(rec.all()); n != 0 {
+		t.Errorf("joining 224.0.0.1 sent %d messages, want 0", n)
+	}
+}
+
+func TestManagerIGMP(t *testing.T) {
+	rec := &igmpRecorder{}
+	m := NewManager()
+	host := m.EnableIGMP(rec.send, IGMPConfig{Robustness: 1})
+	defer host.Close()
+
+	if err := m.JoinGroup(NewIPv4Group(testGroup, 1)); err != nil {
+		t.Fatalf("JoinGroup() error = %v", err)
+	}
+	rec.waitFor(t, 1)
+
+	// Re-joining an existing group is silent
+	m.JoinGroup(NewIPv4Group(testGroup, 1))
+	if n := len(rec.all()); n != 1 {
+		t.Errorf("re-join sent %d messages total, want 1", n)
+	}
+
+	if err := m.LeaveGroup(testGroup); err != nil {
+		t.Fatalf("LeaveGroup() error = %v", err)
+	}
+	msgs := rec.waitFor(t, 2)
+	report := parseV3(t, msgs[1])
+	if report.Records[0].Type != RecordChangeToInclude {
+		t.Errorf("leave record type = %d, want CHANGE_TO_INCLUDE", report.Records[0].Type)
+	}
+
+	// Corrupted messages are rejected
+	bad, _ := NewMembershipQuery(common.IPv4Address{}, 10).Serialize()
+	bad[1] ^= 0xFF
+	if err := m.HandleIGMP(common.IPv4Address{10, 0, 0, 254}, bad); err == nil {
+		t.Error("HandleIGMP() should reject a bad checksum")
+	}
+}
+
+func TestIGMPv3Codec(t *testing.T) {
+	q := &IGMPv3Query{
+		MaxRespCode:  0x8F, // Floating point: (0x0F|0x10) << (0+3) = 248
+		GroupAddress: testGroup,
+		QRV:          2,
+		QQIC:         125,
+		Sources:      []common.IPv4Address{{10, 0, 0, 1}},
+	}
+	data, err := q.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if common.CalculateChecksum(data) != 0 {
+		t.Error("query checksum invalid")
+	}
+
+	parsed, err := ParseIGMPv3Query(data)
+	if err != nil {
+		t.Fatalf("ParseIGMPv3Query() error = %v", err)
+	}
+	if parsed.MaxRespTime() != 248 {
+		t.Errorf("MaxRespTime() = %d, want 248", parsed.MaxRespTime())
+	}
+	if parsed.GroupAddress != testGroup || parsed.QRV != 2 || parsed.QQIC != 125 || len(parsed.Sources) != 1 {
+		t.Errorf("parsed query = %+v, want %+v", parsed, q)
+	}
+
+	report := &IGMPv3Report{Records: []IGMPv3GroupRecord{
+		{Type: RecordModeIsInclude, GroupAddress: testGroup, Sources: []common.IPv4Address{{10, 0, 0, 1}, {10, 0, 0, 2}}},
+		{Type: RecordModeIsExclude, GroupAddress: common.IPv4Address{239, 0, 0, 1}},
+	}}
+	data, err = report.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	parsedReport, err := ParseIGMPv3Report(data)
+	if err != nil {
+		t.Fatalf("ParseIGMPv3Report() error = %v", err)
+	}
+	if len(parsedReport.Records) != 2 || len(parsedReport.Records[0].Sources) != 2 ||
+		parsedReport.Records[1].GroupAddress != (common.IPv4Address{239, 0, 0, 1}) {
+		t.Errorf("parsed report = %+v, want %+v", parsedReport, report)
+	}
+
+	if _, err := ParseIGMPv3Report(data[:IGMPHeaderLen+4]); err == nil {
+		t.Error("ParseIGMPv3Report() should reject truncated record")
+	}
+}
+
+func TestIGMPDeviceSender(t *testing.T) {
+	a, b := memory.NewPipe(memory.Config{})
+	defer a.Close()
+
+	localIP := common.IPv4Address{192, 168, 1, 10}
+	host := NewIGMPHost(NewIGMPDeviceSender(a, localIP), IGMPConfig{Version: 2, Robustness: 1})
+	defer host.Close()
+
+	if err := host.Join(testGroup); err != nil {
+		t.Fatalf("Join() error = %v", err)
+	}
+
+	frame, err := b.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame() error = %v", err)
+	}
+	if frame.Destination != IPv4MulticastMAC(testGroup) {
+		t.Errorf("Destination = %s, want %s", frame.Destination, IPv4MulticastMAC(testGroup))
+	}
+
+	pkt, err := ip.Parse(frame.Payload)
+	if err != nil {
+		t.Fatalf("ip.Parse() error = %v", err)
+	}
+	if pkt.TTL != 1 || pkt.Protocol != common.ProtocolIGMP || pkt.Source != localIP || pkt.Destination != testGroup {
+		t.Errorf("IP packet = %s, want IGMP %s -> %s with TTL 1", pkt, localIP, testGroup)
+	}
+	if len(pkt.Options) < 4 || pkt.Options[0] != 0x94 {
+		t.Errorf("Options = %x, want Router Alert", pkt.Options)
+	}
+}
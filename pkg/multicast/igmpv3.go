@@ -0,0 +1,208 @@
+package multicast
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// IGMPv3 query format (RFC 3376 Section 4.1):
+//  0                   1                   2                   3
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |  Type = 0x11  | Max Resp Code |           Checksum            |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                         Group Address                         |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// | Resv  |S| QRV |     QQIC      |     Number of Sources (N)     |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                       Source Address [1..N]                   |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// IGMPv3 report format (RFC 3376 Section 4.2):
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |  Type = 0x22  |    Reserved   |           Checksum            |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |           Reserved            |  Number of Group Records (M)  |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                      Group Record [1..M]                      |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+
+// IGMPv3QueryMinLen is the minimum length of an IGMPv3 query.
+const IGMPv3QueryMinLen = 12
+
+// IGMPv3 group record types
+const (
+	RecordModeIsInclude   uint8 = 1 // Current state: INCLUDE(sources)
+	RecordModeIsExclude   uint8 = 2 // Current state: EXCLUDE(sources)
+	RecordChangeToInclude uint8 = 3 // Filter mode changed to INCLUDE
+	RecordChangeToExclude uint8 = 4 // Filter mode changed to EXCLUDE
+	RecordAllowNewSources uint8 = 5 // Sources added to the list
+	RecordBlockOldSources uint8 = 6 // Sources removed from the list
+)
+
+// AllIGMPv3RoutersMulticast is the destination of IGMPv3 reports (224.0.0.22).
+var AllIGMPv3RoutersMulticast = common.IPv4Address{224, 0, 0, 22}
+
+// IGMPv3Query represents an IGMPv3 membership query.
+type IGMPv3Query struct {
+	MaxRespCode  uint8                // Max response code (see MaxRespTime)
+	GroupAddress common.IPv4Address   // Group address (0.0.0.0 for general query)
+	SuppressFlag bool                 // Suppress router-side processing
+	QRV          uint8                // Querier's robustness variable
+	QQIC         uint8                // Querier's query interval code
+	Sources      []common.IPv4Address // Source addresses (group-and-source-specific query)
+}
+
+// ParseIGMPv3Query parses an IGMPv3 query from bytes.
+func ParseIGMPv3Query(data []byte) (*IGMPv3Query, error) {
+	if len(data) < IGMPv3QueryMinLen {
+		return nil, fmt.Errorf("IGMPv3 query too short: %d bytes", len(data))
+	}
+	if data[0] != IGMPMembershipQuery {
+		return nil, fmt.Errorf("not an IGMP query: type 0x%02x", data[0])
+	}
+
+	q := &IGMPv3Query{
+		MaxRespCode:  data[1],
+		SuppressFlag: data[8]&0x08 != 0,
+		QRV:          data[8] & 0x07,
+		QQIC:         data[9],
+	}
+	copy(q.GroupAddress[:], data[4:8])
+
+	numSources := int(binary.BigEndian.Uint16(data[10:12]))
+	if len(data) < IGMPv3QueryMinLen+numSources*4 {
+		return nil, fmt.Errorf("IGMPv3 query truncated: %d sources in %d bytes", numSources, len(data))
+	}
+
+	q.Sources = make([]common.IPv4Address, numSources)
+	for i := range q.Sources {
+		copy(q.Sources[i][:], data[IGMPv3QueryMinLen+i*4:])
+	}
+
+	return q, nil
+}
+
+// Serialize serializes the IGMPv3 query to bytes.
+func (q *IGMPv3Query) Serialize() ([]byte, error) {
+	if len(q.Sources) > 0xFFFF {
+		return nil, fmt.Errorf("too many sources: %d", len(q.Sources))
+	}
+
+	buf := make([]byte, IGMPv3QueryMinLen+4*len(q.Sources))
+	buf[0] = IGMPMembershipQuery
+	buf[1] = q.MaxRespCode
+	copy(buf[4:8], q.GroupAddress[:])
+	buf[8] = q.QRV & 0x07
+	if q.SuppressFlag {
+		buf[8] |= 0x08
+	}
+	buf[9] = q.QQIC
+	binary.BigEndian.PutUint16(buf[10:12], uint16(len(q.Sources)))
+	for i, src := range q.Sources {
+		copy(buf[IGMPv3QueryMinLen+i*4:], src[:])
+	}
+
+	binary.BigEndian.PutUint16(buf[2:4], common.CalculateChecksum(buf))
+	return buf, nil
+}
+
+// MaxRespTime returns the maximum response time in deciseconds.
+// Codes of 128 and above use the floating-point encoding from RFC 3376.
+func (q *IGMPv3Query) MaxRespTime() int {
+	return decodeIGMPv3Code(q.MaxRespCode)
+}
+
+// decodeIGMPv3Code decodes an IGMPv3 Max Resp Code or QQIC value.
+func decodeIGMPv3Code(code uint8) int {
+	if code < 128 {
+		return int(code)
+	}
+	mant := int(code&0x0F) | 0x10
+	exp := int(code>>4) & 0x07
+	return mant << (exp + 3)
+}
+
+// IGMPv3GroupRecord is a single group record in an IGMPv3 report.
+type IGMPv3GroupRecord struct {
+	Type         uint8                // Record type (RecordModeIsInclude etc.)
+	GroupAddress common.IPv4Address   // Multicast group address
+	Sources      []common.IPv4Address // Source addresses
+}
+
+// IGMPv3Report represents an IGMPv3 membership report.
+type IGMPv3Report struct {
+	Records []IGMPv3GroupRecord
+}
+
+// ParseIGMPv3Report parses an IGMPv3 report from bytes.
+func ParseIGMPv3Report(data []byte) (*IGMPv3Report, error) {
+	if len(data) < IGMPHeaderLen {
+		return nil, fmt.Errorf("IGMPv3 report too short: %d bytes", len(data))
+	}
+	if data[0] != IGMPv3MembershipReport {
+		return nil, fmt.Errorf("not an IGMPv3 report: type 0x%02x", data[0])
+	}
+
+	numRecords := int(binary.BigEndian.Uint16(data[6:8]))
+	report := &IGMPv3Report{Records: make([]IGMPv3GroupRecord, 0, numRecords)}
+
+	offset := IGMPHeaderLen
+	for i := 0; i < numRecords; i++ {
+		if len(data) < offset+8 {
+			return nil, fmt.Errorf("IGMPv3 group record %d truncated", i)
+		}
+
+		rec := IGMPv3GroupRecord{Type: data[offset]}
+		auxLen := int(data[offset+1]) * 4
+		numSources := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		copy(rec.GroupAddress[:], data[offset+4:offset+8])
+		offset += 8
+
+		if len(data) < offset+numSources*4+auxLen {
+			return nil, fmt.Errorf("IGMPv3 group record %d truncated", i)
+		}
+		rec.Sources = make([]common.IPv4Address, numSources)
+		for j := range rec.Sources {
+			copy(rec.Sources[j][:], data[offset+j*4:])
+		}
+		offset += numSources*4 + auxLen
+
+		report.Records = append(report.Records, rec)
+	}
+
+	return report, nil
+}
+
+// Serialize serializes the IGMPv3 report to bytes.
+func (r *IGMPv3Report) Serialize() ([]byte, error) {
+	if len(r.Records) > 0xFFFF {
+		return nil, fmt.Errorf("too many group records: %d", len(r.Records))
+	}
+
+	length := IGMPHeaderLen
+	for _, rec := range r.Records {
+		length += 8 + 4*len(rec.Sources)
+	}
+
+	buf := make([]byte, length)
+	buf[0] = IGMPv3MembershipReport
+	binary.BigEndian.PutUint16(buf[6:8], uint16(len(r.Records)))
+
+	offset := IGMPHeaderLen
+	for _, rec := range r.Records {
+		buf[offset] = rec.Type
+		binary.BigEndian.PutUint16(buf[offset+2:offset+4], uint16(len(rec.Sources)))
+		copy(buf[offset+4:offset+8], rec.GroupAddress[:])
+		offset += 8
+		for _, src := range rec.Sources {
+			copy(buf[offset:offset+4], src[:])
+			offset += 4
+		}
+	}
+
+	binary.BigEndian.PutUint16(buf[2:4], common.CalculateChecksum(buf))
+	return buf, nil
+}
@@ -4,6 +4,7 @@ package multicast
 import (
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
 	"golang.org/x/net/ipv4"
@@ -142,8 +143,12 @@ func (g *Group) String() string {
 }
 
 // Manager manages multicast groups.
+// If IGMP is enabled, joining and leaving IPv4 groups emits the corresponding
+// IGMP traffic and the manager answers membership queries.
 type Manager struct {
+	mu     sync.RWMutex
 	groups map[string]*Group
+	igmp   *IGMPHost
 }
 
 // NewManager creates a new multicast manager.
@@ -153,6 +158,64 @@ func NewManager() *Manager {
 	}
 }
 
+// EnableIGMP attaches an IGMP host to the manager. IPv4 groups that are
+// already joined are reported immediately.
+func (m *Manager) EnableIGMP(send IGMPSendFunc, config IGMPConfig) *IGMPHost {
+	host := NewIGMPHost(send, config)
+
+	m.mu.Lock()
+	m.igmp = host
+	var existing []common.IPv4Address
+	for _, g := range m.groups {
+		if addr, ok := g.Address.(common.IPv4Address); ok {
+			existing = append(existing, addr)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, addr := range existing {
+		host.Join(addr)
+	}
+	return host
+}
+
+// IGMP returns the manager's IGMP host, or nil if IGMP is not enabled.
+func (m *Manager) IGMP() *IGMPHost {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.igmp
+}
+
+// HandleIGMP processes an IGMP message received from src.
+func (m *Manager) HandleIGMP(src common.IPv4Address, data []byte) error {
+	host := m.IGMP()
+	if host == nil {
+		return fmt.Errorf("IGMP not enabled")
+	}
+	return host.HandleMessage(src, data)
+}
+
+// JoinSourceGroup joins an IPv4 group with an IGMPv3 source filter.
+func (m *Manager) JoinSourceGroup(group *Group, mode FilterMode, sources []common.IPv4Address) error {
+	addr, ok := group.Address.(common.IPv4Address)
+	if !ok {
+		return fmt.Errorf("source filtering requires an IPv4 group")
+	}
+	if !IsMulticastIPv4(addr) {
+		return fmt.Errorf("not a multicast address: %s", addr)
+	}
+
+	m.mu.Lock()
+	m.groups[addr.String()] = group
+	host := m.igmp
+	m.mu.Unlock()
+
+	if host != nil {
+		return host.SetFilter(addr, mode, sources)
+	}
+	return nil
+}
+
 // JoinGroup joins a multicast group.
 func (m *Manager) JoinGroup(group *Group) error {
 	var key string
@@ -171,7 +234,16 @@ func (m *Manager) JoinGroup(group *Group) error {
 		return fmt.Errorf("invalid address type")
 	}
 
+	m.mu.Lock()
+	_, joined := m.groups[key]
 	m.groups[key] = group
+	host := m.igmp
+	m.mu.Unlock()
+
+	// Announce new IPv4 memberships
+	if addr, ok := group.Address.(common.IPv4Address); ok && host != nil && !joined {
+		return host.Join(addr)
+	}
 	return nil
 }
 
@@ -187,7 +259,16 @@ func (m *Manager) LeaveGroup(addr interface{}) error {
 		return fmt.Errorf("invalid address type")
 	}
 
+	m.mu.Lock()
+	_, joined := m.groups[key]
 	delete(m.groups, key)
+	host := m.igmp
+	m.mu.Unlock()
+
+	// Announce the departure from IPv4 groups
+	if a, ok := addr.(common.IPv4Address); ok && host != nil && joined {
+		return host.Leave(a)
+	}
 	return nil
 }
 
@@ -203,7 +284,9 @@ func (m *Manager) GetGroup(addr interface{}) (*Group, error) {
 		return nil, fmt.Errorf("invalid address type")
 	}
 
+	m.mu.RLock()
 	group, ok := m.groups[key]
+	m.mu.RUnlock()
 	if !ok {
 		return nil, fmt.Errorf("group not found: %s", key)
 	}
@@ -213,6 +296,9 @@ func (m *Manager) GetGroup(addr interface{}) (*Group, error) {
 
 // ListGroups returns all multicast groups.
 func (m *Manager) ListGroups() []*Group {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	groups := make([]*Group, 0, len(m.groups))
 	for _, g := range m.groups {
 		groups = append(groups, g)
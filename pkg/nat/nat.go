@@ -0,0 +1,589 @@
+// Package nat implements source NAT with port address translation (RFC 3022)
+// for the IPv4 forwarding path. Mapping, filtering and timeout behavior follow
+// the NAT requirements for UDP (RFC 4787), TCP (RFC 5382) and ICMP (RFC 5508).
+package nat
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+const (
+	// DefaultUDPTimeout is the idle timeout for UDP mappings (RFC 4787 REQ-5).
+	DefaultUDPTimeout = 5 * time.Minute
+
+	// DefaultTCPEstablishedTimeout is the idle timeout for established TCP
+	// connections (RFC 5382 REQ-5).
+	DefaultTCPEstablishedTimeout = 2*time.Hour + 4*time.Minute
+
+	// DefaultTCPTransitoryTimeout is the idle timeout for TCP connections that
+	// are opening or closing (RFC 5382 REQ-5).
+	DefaultTCPTransitoryTimeout = 4 * time.Minute
+
+	// DefaultICMPTimeout is the idle timeout for ICMP query mappings (RFC 5508 REQ-1).
+	DefaultICMPTimeout = 60 * time.Second
+
+	// DefaultPortRangeStart is the first external port allocated by default.
+	DefaultPortRangeStart = 1024
+
+	// DefaultPortRangeEnd is the last external port allocated by default.
+	DefaultPortRangeEnd = 65535
+)
+
+var (
+	// ErrNoMapping is returned for inbound packets that match no mapping.
+	ErrNoMapping = errors.New("no NAT mapping for packet")
+
+	// ErrFiltered is returned for inbound packets from a remote endpoint the
+	// internal host has not contacted.
+	ErrFiltered = errors.New("packet filtered by NAT")
+
+	// ErrPortsExhausted is returned when no external port is free.
+	ErrPortsExhausted = errors.New("NAT external ports exhausted")
+
+	// ErrFragment is returned for IP fragments, which must be reassembled
+	// before translation.
+	ErrFragment = errors.New("NAT cannot translate IP fragments")
+
+	// ErrUnsupportedProtocol is returned for packets other than TCP, UDP,
+	// ICMP echo and ICMP errors.
+	ErrUnsupportedProtocol = errors.New("protocol not supported by NAT")
+)
+
+// Config holds NAT configuration.
+type Config struct {
+	PublicIP       common.IPv4Address // Address internal hosts are translated to
+	PortRangeStart uint16             // First external port (or ICMP identifier)
+	PortRangeEnd   uint16             // Last external port (or ICMP identifier)
+
+	UDPTimeout            time.Duration // Idle timeout for UDP flows
+	TCPEstablishedTimeout time.Duration // Idle timeout for established TCP flows
+	TCPTransitoryTimeout  time.Duration // Idle timeout for opening/closing TCP flows
+	ICMPTimeout           time.Duration // Idle timeout for ICMP echo flows
+
+	// EndpointIndependentFiltering accepts inbound packets from any remote
+	// endpoint once a mapping exists. By default only remote endpoints the
+	// internal host has sent to may reach it (address and port dependent filtering).
+	EndpointIndependentFiltering bool
+}
+
+// Endpoint is an IPv4 address and port. For ICMP the port is the echo identifier.
+type Endpoint struct {
+	IP   common.IPv4Address
+	Port uint16
+}
+
+// String returns the endpoint as "ip:port".
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%s:%d", e.IP, e.Port)
+}
+
+// State is the tracked state of a translated connection.
+type State uint8
+
+const (
+	StateNew         State = iota // Packets seen in one direction only
+	StateEstablished              // Packets seen in both directions
+	StateClosing                  // TCP FIN seen in one direction
+	StateClosed                   // TCP FIN seen in both directions, or RST
+)
+
+// String returns a human-readable name for the state.
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "NEW"
+	case StateEstablished:
+		return "ESTABLISHED"
+	case StateClosing:
+		return "CLOSING"
+	case StateClosed:
+		return "CLOSED"
+	default:
+		return fmt.Sprintf("State(%d)", s)
+	}
+}
+
+// Connection describes a connection tracked by the NAT.
+type Connection struct {
+	Protocol common.Protocol
+	Internal Endpoint // Endpoint of the internal host
+	External Endpoint // Translated endpoint (public IP, allocated port)
+	Remote   Endpoint // Endpoint of the remote host
+	State    State
+	LastSeen time.Time
+}
+
+// String returns a human-readable representation of the connection.
+func (c Connection) String() string {
+	return fmt.Sprintf("%s %s -> %s -> %s %s", c.Protocol, c.Internal, c.External, c.Remote, c.State)
+}
+
+// Stats holds NAT statistics.
+type Stats struct {
+	Outbound    uint64 // Packets translated from inside to outside
+	Inbound     uint64 // Packets translated from outside to inside
+	Hairpinned  uint64 // Packets translated back to another internal host
+	Dropped     uint64 // Packets that could not be translated
+	Mappings    int    // Active external port allocations
+	Connections int    // Active tracked connections
+}
+
+// flowKey identifies a flow from one side of the NAT.
+type flowKey struct {
+	proto  common.Protocol
+	local  Endpoint // Internal endpoint (outbound table) or external endpoint (inbound table)
+	remote Endpoint
+}
+
+// bindingKey identifies an internal endpoint.
+type bindingKey struct {
+	proto    common.Protocol
+	internal Endpoint
+}
+
+// portKey identifies an allocated external port.
+type portKey struct {
+	proto common.Protocol
+	port  uint16
+}
+
+// binding maps an internal endpoint to an external port. Every flow from the
+// same internal endpoint shares the binding (endpoint-independent mapping).
+type binding struct {
+	internal Endpoint
+	port     uint16
+	flows    int
+}
+
+// flow is a tracked connection.
+type flow struct {
+	conn    Connection
+	binding *binding
+	seenOut bool
+	seenIn  bool
+	finOut  bool
+	finIn   bool
+}
+
+// NAT performs source NAT for packets forwarded between an internal network
+// and the outside through a single public address.
+type NAT struct {
+	mu       sync.Mutex
+	config   Config
+	outbound map[flowKey]*flow // Keyed by internal endpoint
+	inbound  map[flowKey]*flow // Keyed by external endpoint
+	bindings map[bindingKey]*binding
+	ports    map[portKey]*binding
+	nextPort map[common.Protocol]uint16
+	stats    Stats
+	now      func() time.Time
+}
+
+// New creates a NAT. Zero-valued configuration fields take their defaults.
+func New(config Config) (*NAT, error) {
+	if config.PublicIP == (common.IPv4Address{}) {
+		return nil, fmt.Errorf("public IP is required")
+	}
+
+	// Apply defaults
+	if config.PortRangeStart == 0 {
+		config.PortRangeStart = DefaultPortRangeStart
+	}
+	if config.PortRangeEnd == 0 {
+		config.PortRangeEnd = DefaultPortRangeEnd
+	}
+	if config.PortRangeStart > config.PortRangeEnd {
+		return nil, fmt.Errorf("invalid port range: %d-%d", config.PortRangeStart, config.PortRangeEnd)
+	}
+	if config.UDPTimeout == 0 {
+		config.UDPTimeout = DefaultUDPTimeout
+	}
+	if config.TCPEstablishedTimeout == 0 {
+		config.TCPEstablishedTimeout = DefaultTCPEstablishedTimeout
+	}
+	if config.TCPTransitoryTimeout == 0 {
+		config.TCPTransitoryTimeout = DefaultTCPTransitoryTimeout
+	}
+	if config.ICMPTimeout == 0 {
+		config.ICMPTimeout = DefaultICMPTimeout
+	}
+
+	return &NAT{
+		config:   config,
+		outbound: make(map[flowKey]*flow),
+		inbound:  make(map[flowKey]*flow),
+		bindings: make(map[bindingKey]*binding),
+		ports:    make(map[portKey]*binding),
+		nextPort: make(map[common.Protocol]uint16),
+		now:      time.Now,
+	}, nil
+}
+
+// PublicIP returns the address internal hosts are translated to.
+func (n *NAT) PublicIP() common.IPv4Address {
+	return n.config.PublicIP
+}
+
+// Outbound translates a packet received from the internal network.
+// The source is rewritten to the public address and an external port,
+// creating a mapping if needed. If the packet is addressed to a mapping on
+// the public address (hairpinning), the destination is also rewritten to
+// the internal host and hairpin is true: the packet should be routed back
+// into the internal network instead of out.
+//
+// Packets addressed to the router itself should be delivered locally before
+// calling Outbound. The packet payload is modified in place.
+func (n *NAT) Outbound(pkt *ip.Packet) (hairpin bool, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	hairpin, err = n.translateOutbound(pkt)
+	if err != nil {
+		n.stats.Dropped++
+		return false, err
+	}
+
+	if hairpin {
+		n.stats.Hairpinned++
+	} else {
+		n.stats.Outbound++
+	}
+	return hairpin, nil
+}
+
+// Inbound translates a packet received from the outside. The destination
+// is rewritten to the internal host of the matching mapping. ErrNoMapping
+// and ErrFiltered indicate the packet should be dropped (or delivered
+// locally, if it is for the router itself).
+//
+// The packet payload is modified in place.
+func (n *NAT) Inbound(pkt *ip.Packet) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.translateInbound(pkt); err != nil {
+		n.stats.Dropped++
+		return err
+	}
+
+	n.stats.Inbound++
+	return nil
+}
+
+// translateOutbound implements Outbound. Must be called with n.mu held.
+func (n *NAT) translateOutbound(pkt *ip.Packet) (bool, error) {
+	if pkt.IsFragment() {
+		return false, ErrFragment
+	}
+	if pkt.Protocol == common.ProtocolICMP && isICMPError(pkt.Payload) {
+		return false, n.translateICMPError(pkt, true)
+	}
+
+	internal, remote, err := parseEndpoints(pkt, true)
+	if err != nil {
+		return false, err
+	}
+
+	// Hairpinning: the destination is another internal host's mapping
+	var target *binding
+	if remote.IP == n.config.PublicIP {
+		if pkt.Protocol == common.ProtocolICMP {
+			return false, ErrNoMapping
+		}
+		target = n.ports[portKey{pkt.Protocol, remote.Port}]
+		if target == nil {
+			return false, ErrNoMapping
+		}
+	}
+
+	// Find or create the flow
+	now := n.now()
+	key := flowKey{pkt.Protocol, internal, remote}
+	f := n.outbound[key]
+	if f != nil && n.expired(f, now) {
+		n.removeFlow(f)
+		f = nil
+	}
+	if f == nil {
+		f, err = n.createFlow(pkt.Protocol, internal, remote, now)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	n.track(f, pkt, true, now)
+	rewriteSource(pkt, f.conn.External)
+
+	if target != nil {
+		rewriteDestination(pkt, target.internal)
+		return true, nil
+	}
+	return false, nil
+}
+
+// translateInbound implements Inbound. Must be called with n.mu held.
+func (n *NAT) translateInbound(pkt *ip.Packet) error {
+	if pkt.IsFragment() {
+		return ErrFragment
+	}
+	if pkt.Destination != n.config.PublicIP {
+		return ErrNoMapping
+	}
+	if pkt.Protocol == common.ProtocolICMP && isICMPError(pkt.Payload) {
+		return n.translateICMPError(pkt, false)
+	}
+
+	external, remote, err := parseEndpoints(pkt, false)
+	if err != nil {
+		return err
+	}
+
+	now := n.now()
+	f := n.inbound[flowKey{pkt.Protocol, external, remote}]
+	if f != nil && n.expired(f, now) {
+		n.removeFlow(f)
+		f = nil
+	}
+
+	if f == nil {
+		// No flow for this remote; check for a mapping on the port
+		b := n.ports[portKey{pkt.Protocol, external.Port}]
+		if b == nil {
+			return ErrNoMapping
+		}
+		if !n.config.EndpointIndependentFiltering {
+			return ErrFiltered
+		}
+		if f, err = n.createFlow(pkt.Protocol, b.internal, remote, now); err != nil {
+			return err
+		}
+	}
+
+	n.track(f, pkt, false, now)
+	rewriteDestination(pkt, f.conn.Internal)
+	return nil
+}
+
+// createFlow creates a flow, allocating or reusing the binding for the
+// internal endpoint. Must be called with n.mu held.
+func (n *NAT) createFlow(proto common.Protocol, internal, remote Endpoint, now time.Time) (*flow, error) {
+	bkey := bindingKey{proto, internal}
+	b := n.bindings[bkey]
+	if b == nil {
+		port, err := n.allocatePort(proto, internal.Port)
+		if err != nil {
+			return nil, err
+		}
+		b = &binding{internal: internal, port: port}
+		n.bindings[bkey] = b
+		n.ports[portKey{proto, port}] = b
+	}
+
+	f := &flow{
+		conn: Connection{
+			Protocol: proto,
+			Internal: internal,
+			External: Endpoint{IP: n.config.PublicIP, Port: b.port},
+			Remote:   remote,
+			State:    StateNew,
+			LastSeen: now,
+		},
+		binding: b,
+	}
+	b.flows++
+
+	n.outbound[flowKey{proto, f.conn.Internal, remote}] = f
+	n.inbound[flowKey{proto, f.conn.External, remote}] = f
+	return f, nil
+}
+
+// allocatePort finds a free external port for a protocol. The internal port
+// is preserved when possible (RFC 4787 REQ-3). Must be called with n.mu held.
+func (n *NAT) allocatePort(proto common.Protocol, preferred uint16) (uint16, error) {
+	start, end := n.config.PortRangeStart, n.config.PortRangeEnd
+	if preferred >= start && preferred <= end && n.ports[portKey{proto, preferred}] == nil {
+		return preferred, nil
+	}
+
+	// Scan the range from where the last allocation left off
+	size := int(end) - int(start) + 1
+	port := n.nextPort[proto]
+	if port < start || port > end {
+		port = start
+	}
+	for i := 0; i < size; i++ {
+		candidate := port
+		if port == end {
+			port = start
+		} else {
+			port++
+		}
+		if n.ports[portKey{proto, candidate}] == nil {
+			n.nextPort[proto] = port
+			return candidate, nil
+		}
+	}
+
+	return 0, ErrPortsExhausted
+}
+
+// track updates a flow's state and timestamp for a translated packet.
+// Must be called with n.mu held.
+func (n *NAT) track(f *flow, pkt *ip.Packet, outbound bool, now time.Time) {
+	f.conn.LastSeen = now
+
+	if pkt.Protocol == common.ProtocolTCP {
+		flags := pkt.Payload[13]
+
+		// A new SYN on a closed connection reuses the flow
+		if f.conn.State == StateClosed && flags&tcp.FlagSYN != 0 && flags&tcp.FlagACK == 0 {
+			*f = flow{conn: f.conn, binding: f.binding}
+			f.conn.State = StateNew
+		}
+
+		if flags&tcp.FlagRST != 0 {
+			f.conn.State = StateClosed
+			return
+		}
+		if flags&tcp.FlagFIN != 0 {
+			if outbound {
+				f.finOut = true
+			} else {
+				f.finIn = true
+			}
+		}
+	}
+
+	if outbound {
+		f.seenOut = true
+	} else {
+		f.seenIn = true
+	}
+
+	switch {
+	case f.finOut && f.finIn:
+		f.conn.State = StateClosed
+	case f.finOut || f.finIn:
+		f.conn.State = StateClosing
+	case f.seenOut && f.seenIn:
+		f.conn.State = StateEstablished
+	}
+}
+
+// timeout returns the idle timeout for a flow in its current state.
+func (n *NAT) timeout(f *flow) time.Duration {
+	switch f.conn.Protocol {
+	case common.ProtocolTCP:
+		if f.conn.State == StateEstablished {
+			return n.config.TCPEstablishedTimeout
+		}
+		return n.config.TCPTransitoryTimeout
+	case common.ProtocolUDP:
+		return n.config.UDPTimeout
+	default:
+		return n.config.ICMPTimeout
+	}
+}
+
+// expired reports whether a flow has been idle longer than its timeout.
+func (n *NAT) expired(f *flow, now time.Time) bool {
+	return now.Sub(f.conn.LastSeen) > n.timeout(f)
+}
+
+// removeFlow deletes a flow, releasing its binding when it was the last
+// flow using it. Must be called with n.mu held.
+func (n *NAT) removeFlow(f *flow) {
+	proto := f.conn.Protocol
+	delete(n.outbound, flowKey{proto, f.conn.Internal, f.conn.Remote})
+	delete(n.inbound, flowKey{proto, f.conn.External, f.conn.Remote})
+
+	f.binding.flows--
+	if f.binding.flows == 0 {
+		delete(n.bindings, bindingKey{proto, f.binding.internal})
+		delete(n.ports, portKey{proto, f.binding.port})
+	}
+}
+
+// Expire removes flows that have been idle longer than their timeout.
+// Returns the number of flows removed.
+func (n *NAT) Expire() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	removed := 0
+	for _, f := range n.outbound {
+		if n.expired(f, now) {
+			n.removeFlow(f)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// StartExpiry starts a goroutine that periodically removes expired flows.
+// Returns a channel that can be closed to stop the routine.
+func (n *NAT) StartExpiry(interval time.Duration) chan<- struct{} {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				n.Expire()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// Connections returns a snapshot of tracked connections, ordered by
+// protocol, external port and remote endpoint.
+func (n *NAT) Connections() []Connection {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	conns := make([]Connection, 0, len(n.outbound))
+	for _, f := range n.outbound {
+		conns = append(conns, f.conn)
+	}
+
+	sort.Slice(conns, func(i, j int) bool {
+		a, b := conns[i], conns[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.External.Port != b.External.Port {
+			return a.External.Port < b.External.Port
+		}
+		return a.Remote.String() < b.Remote.String()
+	})
+
+	return conns
+}
+
+// Stats returns a snapshot of NAT statistics.
+func (n *NAT) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	stats := n.stats
+	stats.Mappings = len(n.ports)
+	stats.Connections = len(n.outbound)
+	return stats
+}
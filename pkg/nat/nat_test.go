@@ -0,0 +1,411 @@
+package nat
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	publicIP = common.IPv4Address{203, 0, 113, 1}
+	hostA    = common.IPv4Address{192, 168, 1, 10}
+	hostB    = common.IPv4Address{192, 168, 1, 11}
+	remoteIP = common.IPv4Address{198, 51, 100, 7}
+)
+
+func newTestNAT(t *testing.T, config Config) *NAT {
+	t.Helper()
+	config.PublicIP = publicIP
+	n, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return n
+}
+
+// udpPacket builds an IPv4 UDP packet with a valid checksum.
+func udpPacket(t *testing.T, src, dst Endpoint) *ip.Packet {
+	t.Helper()
+	u := udp.NewPacket(src.Port, dst.Port, []byte("payload"))
+	checksum, err := u.CalculateChecksum(src.IP, dst.IP)
+	if err != nil {
+		t.Fatalf("CalculateChecksum() error = %v", err)
+	}
+	u.Checksum = checksum
+	data, _ := u.Serialize()
+	return ip.NewPacket(src.IP, dst.IP, common.ProtocolUDP, data)
+}
+
+// tcpPacket builds an IPv4 TCP packet with a valid checksum.
+func tcpPacket(t *testing.T, src, dst Endpoint, flags uint8) *ip.Packet {
+	t.Helper()
+	s := tcp.NewSegment(src.Port, dst.Port, 1000, 2000, flags, 65535, nil)
+	checksum, err := s.CalculateChecksum(src.IP, dst.IP)
+	if err != nil {
+		t.Fatalf("CalculateChecksum() error = %v", err)
+	}
+	s.Checksum = checksum
+	data, _ := s.Serialize()
+	return ip.NewPacket(src.IP, dst.IP, common.ProtocolTCP, data)
+}
+
+// icmpPacket builds an IPv4 ICMP packet.
+func icmpPacket(t *testing.T, src, dst common.IPv4Address, msg *icmp.Message) *ip.Packet {
+	t.Helper()
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	return ip.NewPacket(src, dst, common.ProtocolICMP, data)
+}
+
+// checkUDP verifies the endpoints and checksum of a translated UDP packet.
+func checkUDP(t *testing.T, pkt *ip.Packet, src, dst Endpoint) {
+	t.Helper()
+	u, err := udp.Parse(pkt.Payload)
+	if err != nil {
+		t.Fatalf("udp.Parse() error = %v", err)
+	}
+	got := [2]Endpoint{{pkt.Source, u.SourcePort}, {pkt.Destination, u.DestinationPort}}
+	if got != [2]Endpoint{src, dst} {
+		t.Errorf("packet %s -> %s, want %s -> %s", got[0], got[1], src, dst)
+	}
+	if !u.VerifyChecksum(pkt.Source, pkt.Destination) {
+		t.Error("UDP checksum invalid after translation")
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Error("New() should require a public IP")
+	}
+	if _, err := New(Config{PublicIP: publicIP, PortRangeStart: 2000, PortRangeEnd: 1000}); err == nil {
+		t.Error("New() should reject an inverted port range")
+	}
+}
+
+func TestUDPTranslation(t *testing.T) {
+	n := newTestNAT(t, Config{})
+
+	internal := Endpoint{hostA, 40000}
+	remote := Endpoint{remoteIP, 53}
+
+	pkt := udpPacket(t, internal, remote)
+	hairpin, err := n.Outbound(pkt)
+	if err != nil {
+		t.Fatalf("Outbound() error = %v", err)
+	}
+	if hairpin {
+		t.Error("Outbound() hairpin = true, want false")
+	}
+
+	// The internal port is preserved
+	external := Endpoint{publicIP, 40000}
+	checkUDP(t, pkt, external, remote)
+
+	// The reply is translated back
+	reply := udpPacket(t, remote, external)
+	if err := n.Inbound(reply); err != nil {
+		t.Fatalf("Inbound() error = %v", err)
+	}
+	checkUDP(t, reply, remote, internal)
+
+	// Another remote is filtered; an unmapped port has no mapping
+	other := udpPacket(t, Endpoint{remoteIP, 54}, external)
+	if err := n.Inbound(other); !errors.Is(err, ErrFiltered) {
+		t.Errorf("Inbound() from new remote error = %v, want %v", err, ErrFiltered)
+	}
+	unmapped := udpPacket(t, remote, Endpoint{publicIP, 40001})
+	if err := n.Inbound(unmapped); !errors.Is(err, ErrNoMapping) {
+		t.Errorf("Inbound() to unmapped port error = %v, want %v", err, ErrNoMapping)
+	}
+
+	conns := n.Connections()
+	if len(conns) != 1 || conns[0].State != StateEstablished {
+		t.Errorf("Connections() = %v, want one ESTABLISHED flow", conns)
+	}
+
+	stats := n.Stats()
+	if stats.Outbound != 1 || stats.Inbound != 1 || stats.Dropped != 2 || stats.Mappings != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestEndpointIndependentFiltering(t *testing.T) {
+	n := newTestNAT(t, Config{EndpointIndependentFiltering: true})
+
+	n.Outbound(udpPacket(t, Endpoint{hostA, 5000}, Endpoint{remoteIP, 3478}))
+
+	// Any remote may now reach the mapping
+	pkt := udpPacket(t, Endpoint{common.IPv4Address{192, 0, 2, 9}, 9999}, Endpoint{publicIP, 5000})
+	if err := n.Inbound(pkt); err != nil {
+		t.Fatalf("Inbound() error = %v", err)
+	}
+	checkUDP(t, pkt, Endpoint{common.IPv4Address{192, 0, 2, 9}, 9999}, Endpoint{hostA, 5000})
+
+	if got := len(n.Connections()); got != 2 {
+		t.Errorf("Connections() = %d entries, want 2", got)
+	}
+}
+
+func TestEndpointIndependentMapping(t *testing.T) {
+	n := newTestNAT(t, Config{})
+
+	// The same internal endpoint keeps its external port for every remote
+	p1 := udpPacket(t, Endpoint{hostA, 6000}, Endpoint{remoteIP, 1})
+	p2 := udpPacket(t, Endpoint{hostA, 6000}, Endpoint{remoteIP, 2})
+	n.Outbound(p1)
+	n.Outbound(p2)
+	u1, _ := udp.Parse(p1.Payload)
+	u2, _ := udp.Parse(p2.Payload)
+	if u1.SourcePort != u2.SourcePort {
+		t.Errorf("external ports %d and %d differ, want equal", u1.SourcePort, u2.SourcePort)
+	}
+
+	// A second host using the same port gets a different one
+	p3 := udpPacket(t, Endpoint{hostB, 6000}, Endpoint{remoteIP, 1})
+	n.Outbound(p3)
+	u3, _ := udp.Parse(p3.Payload)
+	if u3.SourcePort == u1.SourcePort {
+		t.Errorf("hosts share external port %d, want distinct", u3.SourcePort)
+	}
+
+	if stats := n.Stats(); stats.Mappings != 2 || stats.Connections != 3 {
+		t.Errorf("Stats() = %+v, want 2 mappings and 3 connections", stats)
+	}
+}
+
+func TestTCPStateAndExpiry(t *testing.T) {
+	n := newTestNAT(t, Config{})
+	now := time.Now()
+	n.now = func() time.Time { return now }
+
+	internal := Endpoint{hostA, 33000}
+	remote := Endpoint{remoteIP, 443}
+	external := Endpoint{publicIP, 33000}
+
+	steps := []struct {
+		outbound bool
+		flags    uint8
+		want     State
+	}{
+		{true, tcp.FlagSYN, StateNew},
+		{false, tcp.FlagSYN | tcp.FlagACK, StateEstablished},
+		{true, tcp.FlagACK, StateEstablished},
+		{true, tcp.FlagFIN | tcp.FlagACK, StateClosing},
+		{false, tcp.FlagFIN | tcp.FlagACK, StateClosed},
+	}
+
+	for i, step := range steps {
+		if step.outbound {
+			pkt := tcpPacket(t, internal, remote, step.flags)
+			if _, err := n.Outbound(pkt); err != nil {
+				t.Fatalf("step %d: Outbound() error = %v", i, err)
+			}
+			s, _ := tcp.Parse(pkt.Payload)
+			if !s.VerifyChecksum(pkt.Source, pkt.Destination) {
+				t.Errorf("step %d: TCP checksum invalid after translation", i)
+			}
+		} else {
+			pkt := tcpPacket(t, remote, external, step.flags)
+			if err := n.Inbound(pkt); err != nil {
+				t.Fatalf("step %d: Inbound() error = %v", i, err)
+			}
+			s, _ := tcp.Parse(pkt.Payload)
+			if pkt.Destination != hostA || !s.VerifyChecksum(pkt.Source, pkt.Destination) {
+				t.Errorf("step %d: inbound packet not translated correctly", i)
+			}
+		}
+
+		if state := n.Connections()[0].State; state != step.want {
+			t.Errorf("step %d: State = %s, want %s", i, state, step.want)
+		}
+	}
+
+	// Closed connections use the transitory timeout
+	now = now.Add(DefaultTCPTransitoryTimeout - time.Second)
+	if removed := n.Expire(); removed != 0 {
+		t.Errorf("Expire() = %d before timeout, want 0", removed)
+	}
+	now = now.Add(2 * time.Second)
+	if removed := n.Expire(); removed != 1 {
+		t.Errorf("Expire() = %d after timeout, want 1", removed)
+	}
+	if stats := n.Stats(); stats.Mappings != 0 || stats.Connections != 0 {
+		t.Errorf("Stats() = %+v, want no mappings after expiry", stats)
+	}
+}
+
+func TestEstablishedTimeout(t *testing.T) {
+	n := newTestNAT(t, Config{TCPEstablishedTimeout: time.Hour})
+	now := time.Now()
+	n.now = func() time.Time { return now }
+
+	internal := Endpoint{hostA, 33000}
+	remote := Endpoint{remoteIP, 22}
+	n.Outbound(tcpPacket(t, internal, remote, tcp.FlagSYN))
+	n.Inbound(tcpPacket(t, remote, Endpoint{publicIP, 33000}, tcp.FlagSYN|tcp.FlagACK))
+
+	// Established connections survive the transitory timeout
+	now = now.Add(30 * time.Minute)
+	if removed := n.Expire(); removed != 0 {
+		t.Errorf("Expire() = %d, want 0", removed)
+	}
+
+	// An expired flow no longer accepts inbound packets
+	now = now.Add(time.Hour)
+	err := n.Inbound(tcpPacket(t, remote, Endpoint{publicIP, 33000}, tcp.FlagACK))
+	if !errors.Is(err, ErrNoMapping) {
+		t.Errorf("Inbound() after expiry error = %v, want %v", err, ErrNoMapping)
+	}
+}
+
+func TestICMPEcho(t *testing.T) {
+	n := newTestNAT(t, Config{})
+
+	req := icmpPacket(t, hostA, remoteIP, icmp.NewEchoRequest(7, 1, []byte("ping")))
+	if _, err := n.Outbound(req); err != nil {
+		t.Fatalf("Outbound() error = %v", err)
+	}
+	msg, err := icmp.Parse(req.Payload)
+	if err != nil {
+		t.Fatalf("icmp.Parse() error = %v", err)
+	}
+	if req.Source != publicIP || !msg.VerifyChecksum() {
+		t.Errorf("echo request from %s (checksum ok: %v), want %s", req.Source, msg.VerifyChecksum(), publicIP)
+	}
+
+	reply := icmpPacket(t, remoteIP, publicIP, icmp.NewEchoReply(msg.ID, 1, []byte("ping")))
+	if err := n.Inbound(reply); err != nil {
+		t.Fatalf("Inbound() error = %v", err)
+	}
+	msg, _ = icmp.Parse(reply.Payload)
+	if reply.Destination != hostA || msg.ID != 7 || !msg.VerifyChecksum() {
+		t.Errorf("echo reply to %s id %d, want %s id 7", reply.Destination, msg.ID, hostA)
+	}
+
+	// Unsolicited echo requests are for the router, not translated
+	ping := icmpPacket(t, remoteIP, publicIP, icmp.NewEchoRequest(9, 1, nil))
+	if err := n.Inbound(ping); !errors.Is(err, ErrUnsupportedProtocol) {
+		t.Errorf("Inbound() echo request error = %v, want %v", err, ErrUnsupportedProtocol)
+	}
+}
+
+func TestHairpinning(t *testing.T) {
+	n := newTestNAT(t, Config{})
+
+	// Host A creates a mapping
+	n.Outbound(udpPacket(t, Endpoint{hostA, 7000}, Endpoint{remoteIP, 3478}))
+
+	// Host B sends to A's public mapping
+	pkt := udpPacket(t, Endpoint{hostB, 8000}, Endpoint{publicIP, 7000})
+	hairpin, err := n.Outbound(pkt)
+	if err != nil {
+		t.Fatalf("Outbound() error = %v", err)
+	}
+	if !hairpin {
+		t.Fatal("Outbound() hairpin = false, want true")
+	}
+
+	// A sees B by its external address (RFC 4787 REQ-9)
+	checkUDP(t, pkt, Endpoint{publicIP, 8000}, Endpoint{hostA, 7000})
+
+	// A's reply hairpins back to B
+	back := udpPacket(t, Endpoint{hostA, 7000}, Endpoint{publicIP, 8000})
+	if hairpin, err := n.Outbound(back); err != nil || !hairpin {
+		t.Fatalf("Outbound() = %v, %v, want hairpin", hairpin, err)
+	}
+	checkUDP(t, back, Endpoint{publicIP, 7000}, Endpoint{hostB, 8000})
+
+	// A public port without a mapping cannot be hairpinned
+	if _, err := n.Outbound(udpPacket(t, Endpoint{hostB, 8000}, Endpoint{publicIP, 9})); !errors.Is(err, ErrNoMapping) {
+		t.Errorf("Outbound() error = %v, want %v", err, ErrNoMapping)
+	}
+
+	if stats := n.Stats(); stats.Hairpinned != 2 {
+		t.Errorf("Hairpinned = %d, want 2", stats.Hairpinned)
+	}
+}
+
+func TestICMPErrorTranslation(t *testing.T) {
+	n := newTestNAT(t, Config{})
+
+	internal := Endpoint{hostA, 40000}
+	remote := Endpoint{remoteIP, 9}
+	orig := udpPacket(t, internal, remote)
+	n.Outbound(orig)
+
+	// The remote replies with port unreachable quoting the translated packet
+	quoted, _ := orig.Serialize()
+	unreach := icmpPacket(t, remoteIP, publicIP, icmp.NewDestinationUnreachable(icmp.CodePortUnreachable, quoted[:ip.MinHeaderLength+8]))
+	if err := n.Inbound(unreach); err != nil {
+		t.Fatalf("Inbound() error = %v", err)
+	}
+	if unreach.Destination != hostA {
+		t.Errorf("Destination = %s, want %s", unreach.Destination, hostA)
+	}
+
+	msg, err := icmp.Parse(unreach.Payload)
+	if err != nil {
+		t.Fatalf("icmp.Parse() error = %v", err)
+	}
+	if !msg.VerifyChecksum() {
+		t.Error("ICMP checksum invalid after translation")
+	}
+
+	// The quoted header now shows the internal source
+	inner := unreach.Payload[icmp.MinHeaderLength:]
+	var innerSrc common.IPv4Address
+	copy(innerSrc[:], inner[12:16])
+	if innerSrc != hostA {
+		t.Errorf("quoted source = %s, want %s", innerSrc, hostA)
+	}
+	if !common.VerifyChecksum(inner[:ip.MinHeaderLength]) {
+		t.Error("quoted IP header checksum invalid")
+	}
+	if port := uint16(inner[20])<<8 | uint16(inner[21]); port != internal.Port {
+		t.Errorf("quoted source port = %d, want %d", port, internal.Port)
+	}
+
+	// Errors do not refresh the flow
+	if stats := n.Stats(); stats.Inbound != 1 {
+		t.Errorf("Inbound = %d, want 1", stats.Inbound)
+	}
+}
+
+func TestPortExhaustion(t *testing.T) {
+	n := newTestNAT(t, Config{PortRangeStart: 2000, PortRangeEnd: 2001})
+
+	for i := 0; i < 2; i++ {
+		src := Endpoint{common.IPv4Address{192, 168, 1, byte(20 + i)}, 5000}
+		if _, err := n.Outbound(udpPacket(t, src, Endpoint{remoteIP, 53})); err != nil {
+			t.Fatalf("Outbound() error = %v", err)
+		}
+	}
+
+	src := Endpoint{common.IPv4Address{192, 168, 1, 30}, 5000}
+	if _, err := n.Outbound(udpPacket(t, src, Endpoint{remoteIP, 53})); !errors.Is(err, ErrPortsExhausted) {
+		t.Errorf("Outbound() error = %v, want %v", err, ErrPortsExhausted)
+	}
+
+	// TCP has its own port space
+	if _, err := n.Outbound(tcpPacket(t, src, Endpoint{remoteIP, 80}, tcp.FlagSYN)); err != nil {
+		t.Errorf("Outbound() TCP error = %v", err)
+	}
+}
+
+func TestFragmentRejected(t *testing.T) {
+	n := newTestNAT(t, Config{})
+
+	pkt := udpPacket(t, Endpoint{hostA, 1}, Endpoint{remoteIP, 2})
+	pkt.Flags = ip.FlagMoreFragments
+	if _, err := n.Outbound(pkt); !errors.Is(err, ErrFragment) {
+		t.Errorf("Outbound() error = %v, want %v", err, ErrFragment)
+	}
+}
@@ -0,0 +1,225 @@
+package nat
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+// Minimum transport header lengths needed for translation
+const (
+	tcpMinHeaderLen   = 20
+	udpHeaderLen      = 8
+	icmpEchoHeaderLen = icmp.MinHeaderLength
+)
+
+// parseEndpoints extracts the internal-side and remote endpoints of a packet.
+// For outbound packets the internal side is the source; for inbound packets
+// it is the destination. ICMP echo requests are translated outbound and
+// echo replies inbound, with the identifier acting as the internal-side port.
+func parseEndpoints(pkt *ip.Packet, outbound bool) (local, remote Endpoint, err error) {
+	th := pkt.Payload
+	src := Endpoint{IP: pkt.Source}
+	dst := Endpoint{IP: pkt.Destination}
+
+	switch pkt.Protocol {
+	case common.ProtocolTCP, common.ProtocolUDP:
+		minLen := udpHeaderLen
+		if pkt.Protocol == common.ProtocolTCP {
+			minLen = tcpMinHeaderLen
+		}
+		if len(th) < minLen {
+			return local, remote, fmt.Errorf("%s header too short: %d bytes", pkt.Protocol, len(th))
+		}
+		src.Port = binary.BigEndian.Uint16(th[0:2])
+		dst.Port = binary.BigEndian.Uint16(th[2:4])
+
+	case common.ProtocolICMP:
+		if len(th) < icmpEchoHeaderLen {
+			return local, remote, fmt.Errorf("ICMP header too short: %d bytes", len(th))
+		}
+		want := icmp.TypeEchoReply
+		if outbound {
+			want = icmp.TypeEchoRequest
+		}
+		if icmp.Type(th[0]) != want {
+			return local, remote, ErrUnsupportedProtocol
+		}
+		id := binary.BigEndian.Uint16(th[4:6])
+		if outbound {
+			src.Port = id
+		} else {
+			dst.Port = id
+		}
+
+	default:
+		return local, remote, ErrUnsupportedProtocol
+	}
+
+	if outbound {
+		return src, dst, nil
+	}
+	return dst, src, nil
+}
+
+// isICMPError reports whether an ICMP message is an error carrying the
+// header of the packet that caused it.
+func isICMPError(msg []byte) bool {
+	if len(msg) == 0 {
+		return false
+	}
+	switch icmp.Type(msg[0]) {
+	case icmp.TypeDestinationUnreachable, icmp.TypeSourceQuench, icmp.TypeRedirect,
+		icmp.TypeTimeExceeded, icmp.TypeParameterProblem:
+		return true
+	default:
+		return false
+	}
+}
+
+// portOffset returns the offset of the port field in a transport header.
+// ICMP echo messages carry a single identifier used in both directions.
+func portOffset(proto common.Protocol, source bool) int {
+	if proto == common.ProtocolICMP {
+		return 4
+	}
+	if source {
+		return 0
+	}
+	return 2
+}
+
+// checksumOffset returns the offset of the checksum in a transport header.
+func checksumOffset(proto common.Protocol) int {
+	switch proto {
+	case common.ProtocolTCP:
+		return 16
+	case common.ProtocolUDP:
+		return 6
+	default:
+		return 2
+	}
+}
+
+// rewriteSource translates the source address and port of a packet.
+func rewriteSource(pkt *ip.Packet, to Endpoint) {
+	rewriteTransport(pkt.Protocol, pkt.Payload, portOffset(pkt.Protocol, true), pkt.Source, to.IP, to.Port)
+	pkt.Source = to.IP
+}
+
+// rewriteDestination translates the destination address and port of a packet.
+func rewriteDestination(pkt *ip.Packet, to Endpoint) {
+	rewriteTransport(pkt.Protocol, pkt.Payload, portOffset(pkt.Protocol, false), pkt.Destination, to.IP, to.Port)
+	pkt.Destination = to.IP
+}
+
+// rewriteTransport replaces a port in a transport header and incrementally
+// updates its checksum (RFC 1624) for the new port and, for TCP and UDP,
+// the changed pseudo-header address. Headers truncated before the checksum
+// field (as quoted in ICMP errors) only have the port rewritten.
+func rewriteTransport(proto common.Protocol, th []byte, portOff int, oldIP, newIP common.IPv4Address, newPort uint16) {
+	var oldPort, port [2]byte
+	copy(oldPort[:], th[portOff:portOff+2])
+	binary.BigEndian.PutUint16(port[:], newPort)
+	copy(th[portOff:portOff+2], port[:])
+
+	csumOff := checksumOffset(proto)
+	if len(th) < csumOff+2 {
+		return
+	}
+
+	checksum := binary.BigEndian.Uint16(th[csumOff : csumOff+2])
+	if proto == common.ProtocolUDP && checksum == 0 {
+		// Checksum disabled by the sender
+		return
+	}
+
+	if proto != common.ProtocolICMP {
+		checksum = common.UpdateChecksum(checksum, oldIP[:], newIP[:])
+	}
+	checksum = common.UpdateChecksum(checksum, oldPort[:], port[:])
+
+	// Zero means "no checksum" for UDP; send the equivalent all-ones value
+	if proto == common.ProtocolUDP && checksum == 0 {
+		checksum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(th[csumOff:csumOff+2], checksum)
+}
+
+// rewriteHeaderAddress replaces an address in a raw IPv4 header, keeping the
+// header checksum valid.
+func rewriteHeaderAddress(hdr []byte, off int, newIP common.IPv4Address) {
+	checksum := binary.BigEndian.Uint16(hdr[10:12])
+	checksum = common.UpdateChecksum(checksum, hdr[off:off+4], newIP[:])
+	copy(hdr[off:off+4], newIP[:])
+	binary.BigEndian.PutUint16(hdr[10:12], checksum)
+}
+
+// translateICMPError translates an ICMP error and the packet header it
+// quotes (RFC 5508 REQ-3). Outbound errors are sent by an internal host
+// about a packet it received; inbound errors are sent by a remote host
+// about a packet the NAT translated. Errors do not refresh the flow.
+// Must be called with n.mu held.
+func (n *NAT) translateICMPError(pkt *ip.Packet, outbound bool) error {
+	msg := pkt.Payload
+	if len(msg) < icmp.MinHeaderLength+ip.MinHeaderLength {
+		return fmt.Errorf("ICMP error too short: %d bytes", len(msg))
+	}
+
+	// Parse the quoted IP header and the first 8 bytes of its payload
+	inner := msg[icmp.MinHeaderLength:]
+	headerLen := int(inner[0]&0x0F) * 4
+	if inner[0]>>4 != ip.IPv4Version || headerLen < ip.MinHeaderLength || len(inner) < headerLen+8 {
+		return fmt.Errorf("ICMP error quotes invalid IP header")
+	}
+	proto := common.Protocol(inner[9])
+	th := inner[headerLen:]
+
+	var src, dst Endpoint
+	copy(src.IP[:], inner[12:16])
+	copy(dst.IP[:], inner[16:20])
+
+	switch proto {
+	case common.ProtocolTCP, common.ProtocolUDP:
+		src.Port = binary.BigEndian.Uint16(th[0:2])
+		dst.Port = binary.BigEndian.Uint16(th[2:4])
+	case common.ProtocolICMP:
+		// The quoted packet is an echo request (inbound errors) or reply (outbound errors)
+		id := binary.BigEndian.Uint16(th[4:6])
+		if outbound {
+			dst.Port = id
+		} else {
+			src.Port = id
+		}
+	default:
+		return ErrUnsupportedProtocol
+	}
+
+	if outbound {
+		// Quoted packet: remote -> internal host
+		f := n.outbound[flowKey{proto, dst, src}]
+		if f == nil {
+			return ErrNoMapping
+		}
+		rewriteTransport(proto, th, portOffset(proto, false), dst.IP, f.conn.External.IP, f.conn.External.Port)
+		rewriteHeaderAddress(inner, 16, f.conn.External.IP)
+		pkt.Source = f.conn.External.IP
+	} else {
+		// Quoted packet: public address -> remote
+		f := n.inbound[flowKey{proto, src, dst}]
+		if f == nil {
+			return ErrNoMapping
+		}
+		rewriteTransport(proto, th, portOffset(proto, true), src.IP, f.conn.Internal.IP, f.conn.Internal.Port)
+		rewriteHeaderAddress(inner, 12, f.conn.Internal.IP)
+		pkt.Destination = f.conn.Internal.IP
+	}
+
+	// The quoted bytes changed, so recompute the ICMP checksum
+	msg[2], msg[3] = 0, 0
+	binary.BigEndian.PutUint16(msg[2:4], common.CalculateChecksum(msg))
+	return nil
+}
@@ -0,0 +1,562 @@
+package ndp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ipv6"
+	"github.com/therealutkarshpriyadarshi/network/pkg/multicast"
+)
+
+const (
+	// DefaultSolicitations is how many Router Solicitations are sent
+	// while no router advertises itself (MAX_RTR_SOLICITATIONS).
+	DefaultSolicitations = 3
+
+	// DefaultSolicitationInterval is the interval between Router
+	// Solicitations (RTR_SOLICITATION_INTERVAL).
+	DefaultSolicitationInterval = 4 * time.Second
+
+	// DefaultRouteMetric is the metric of the routes installed, that of
+	// the kernel's autoconfigured routes.
+	DefaultRouteMetric = 1024
+
+	// MinMTU is the smallest MTU of an IPv6 link (RFC 8200 Section 5).
+	// Advertised MTUs below it are ignored.
+	MinMTU = 1280
+
+	// tickInterval is the resolution of the host's timers.
+	tickInterval = time.Second
+
+	// minValidLifetime is as short as an advertisement may make the valid
+	// lifetime of an address, so that a forged one cannot take the
+	// address away (RFC 4862 Section 5.5.3 e).
+	minValidLifetime = 2 * time.Hour
+)
+
+// Config configures a host.
+type Config struct {
+	// StableSecret, if set, has addresses formed with the stable,
+	// per-prefix interface identifiers of RFC 7217 derived from it, and
+	// NetworkID is part of their derivation. Otherwise addresses have the
+	// EUI-64 identifier of the device's MAC address.
+	StableSecret []byte
+	NetworkID    []byte
+
+	// Routes, if set, gets the on-link and default routes learned,
+	// removed again as they expire.
+	Routes      *ipv6.RoutingTable
+	RouteMetric int // 0 = DefaultRouteMetric
+
+	Solicitations        int           // 0 = DefaultSolicitations, negative for none
+	SolicitationInterval time.Duration // 0 = DefaultSolicitationInterval
+}
+
+// Address is an address the host configured.
+type Address struct {
+	Address        common.IPv6Address
+	PrefixLength   int
+	PreferredUntil time.Time // Zero if preferred forever
+	ValidUntil     time.Time // Zero if valid forever
+
+	// Deprecated is set for addresses past their preferred lifetime,
+	// which new connections should not use.
+	Deprecated bool
+}
+
+// Prefix is an on-link prefix: its addresses are reached directly rather
+// than through a router.
+type Prefix struct {
+	Prefix     common.IPv6Address
+	Length     int
+	ValidUntil time.Time // Zero if valid forever
+}
+
+// Router is a default router.
+type Router struct {
+	Address     common.IPv6Address // Link-local address
+	LinkAddress common.MACAddress  // Zero if not advertised
+	Expires     time.Time
+}
+
+// Host autoconfigures the IPv6 addresses and routes of a device from the
+// Router Advertisements received on it. It solicits advertisements at
+// Start, and applies each one received, so addresses and routes are
+// refreshed by the periodic advertisements of the routers and expire
+// when those stop.
+//
+// The host does not read the device: frames received on it are passed to
+// HandleFrame, which ignores those that are not Router Advertisements.
+// Duplicate address detection is not performed.
+type Host struct {
+	dev    ethernet.Device
+	config Config
+	now    func() time.Time
+
+	mu          sync.Mutex
+	linkLocal   common.IPv6Address
+	addresses   []*Address // The link-local address first
+	prefixes    []*Prefix
+	routers     []*Router
+	mtu         int
+	hopLimit    uint8
+	advertised  bool // A router has answered
+	solicited   int
+	nextSolicit time.Time
+	onChange    func()
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New creates a host autoconfiguring dev.
+func New(dev ethernet.Device, config Config) (*Host, error) {
+	if config.RouteMetric == 0 {
+		config.RouteMetric = DefaultRouteMetric
+	}
+	if config.Solicitations == 0 {
+		config.Solicitations = DefaultSolicitations
+	}
+	if config.SolicitationInterval == 0 {
+		config.SolicitationInterval = DefaultSolicitationInterval
+	}
+	if config.SolicitationInterval < 0 {
+		return nil, fmt.Errorf("invalid solicitation interval: %v", config.SolicitationInterval)
+	}
+
+	return &Host{
+		dev:    dev,
+		config: config,
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+// OnChange registers a callback invoked after the host's addresses,
+// prefixes or routers change.
+func (h *Host) OnChange(f func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onChange = f
+}
+
+// Start configures the link-local address, solicits Router
+// Advertisements, and runs the host's timers until Close.
+func (h *Host) Start() error {
+	if err := h.update(h.up); err != nil {
+		return err
+	}
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.update(h.tick)
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the host's timers and removes the routes it installed. The
+// device is left open.
+func (h *Host) Close() error {
+	h.stopOnce.Do(func() { close(h.stop) })
+	h.wg.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeRoute(&ipv6.Route{Destination: linkLocalPrefix, PrefixLength: InterfaceIDLength})
+	for _, p := range h.prefixes {
+		h.removeRoute(prefixRoute(p))
+	}
+	for _, r := range h.routers {
+		h.removeRoute(defaultRoute(r))
+	}
+	return nil
+}
+
+// up configures the link-local address and sends the first Router
+// Solicitation.
+func (h *Host) up(now time.Time) (bool, error) {
+	h.linkLocal = LinkLocalAddress(h.interfaceID(linkLocalPrefix))
+	h.addresses = append(h.addresses[:0], &Address{Address: h.linkLocal, PrefixLength: InterfaceIDLength})
+	h.addRoute(&ipv6.Route{Destination: linkLocalPrefix, PrefixLength: InterfaceIDLength})
+	h.solicited = 0
+	return true, h.solicit(now)
+}
+
+// LinkLocalAddress returns the host's link-local address, or :: before
+// Start.
+func (h *Host) LinkLocalAddress() common.IPv6Address {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.linkLocal
+}
+
+// Addresses returns the host's addresses, the link-local one first.
+func (h *Host) Addresses() []Address {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	addresses := make([]Address, len(h.addresses))
+	for i, a := range h.addresses {
+		addresses[i] = *a
+		addresses[i].Deprecated = expired(a.PreferredUntil, now)
+	}
+	return addresses
+}
+
+// Prefixes returns the on-link prefixes advertised.
+func (h *Host) Prefixes() []Prefix {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prefixes := make([]Prefix, len(h.prefixes))
+	for i, p := range h.prefixes {
+		prefixes[i] = *p
+	}
+	return prefixes
+}
+
+// DefaultRouters returns the default routers, in the order they were
+// first heard from.
+func (h *Host) DefaultRouters() []Router {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	routers := make([]Router, len(h.routers))
+	for i, r := range h.routers {
+		routers[i] = *r
+	}
+	return routers
+}
+
+// MTU returns the MTU advertised for the link, or 0 if none was.
+func (h *Host) MTU() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.mtu
+}
+
+// HopLimit returns the hop limit advertised for the link, or 0 if none
+// was.
+func (h *Host) HopLimit() uint8 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.hopLimit
+}
+
+// HandleFrame processes a received Ethernet frame. Frames other than
+// Router Advertisements are ignored, as are advertisements that do not
+// come from a router on the link (RFC 4861 Section 6.1.2).
+func (h *Host) HandleFrame(frame *ethernet.Frame) error {
+	if frame.EtherType != common.EtherTypeIPv6 {
+		return nil
+	}
+	pkt, err := ipv6.Parse(frame.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to parse IPv6 packet: %w", err)
+	}
+	if pkt.NextHeader != common.ProtocolICMPv6 || len(pkt.Payload) == 0 || pkt.Payload[0] != TypeRouterAdvertisement {
+		return nil
+	}
+	if pkt.HopLimit != HopLimit || !pkt.Source.IsLinkLocal() {
+		return nil
+	}
+	if !VerifyChecksum(pkt.Source, pkt.Destination, pkt.Payload) {
+		return fmt.Errorf("invalid Router Advertisement checksum from %s", pkt.Source)
+	}
+	ra, err := ParseRouterAdvertisement(pkt.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to parse Router Advertisement: %w", err)
+	}
+
+	return h.update(func(now time.Time) (bool, error) {
+		if h.linkLocal.IsUnspecified() {
+			return false, nil // Not started
+		}
+		if pkt.Destination != multicast.AllNodesMulticast && !h.hasAddress(pkt.Destination) {
+			return false, nil
+		}
+		return h.handleRA(pkt.Source, ra, now), nil
+	})
+}
+
+// handleRA applies a Router Advertisement from src (RFC 4861 Section
+// 6.3.4 and RFC 4862 Section 5.5.3), reporting whether the addresses,
+// prefixes or routers changed.
+func (h *Host) handleRA(src common.IPv6Address, ra *RouterAdvertisement, now time.Time) bool {
+	h.advertised = true
+	if ra.CurHopLimit != 0 {
+		h.hopLimit = ra.CurHopLimit
+	}
+	if ra.MTU >= MinMTU {
+		h.mtu = int(ra.MTU)
+	}
+
+	changed := h.updateRouter(src, ra, now)
+	for _, p := range ra.Prefixes {
+		if p.Prefix.IsLinkLocal() || p.Prefix.IsMulticast() {
+			continue
+		}
+		p.Prefix = ipv6.Mask(p.Prefix, p.Length)
+		if p.OnLink && h.updatePrefix(p, now) {
+			changed = true
+		}
+		if p.Autonomous && h.updateAddress(p, now) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// updateRouter adds, refreshes or removes the default router src.
+func (h *Host) updateRouter(src common.IPv6Address, ra *RouterAdvertisement, now time.Time) bool {
+	i := h.findRouter(src)
+	if ra.RouterLifetime == 0 {
+		if i < 0 {
+			return false
+		}
+		h.removeRoute(defaultRoute(h.routers[i]))
+		h.routers = append(h.routers[:i], h.routers[i+1:]...)
+		return true
+	}
+
+	if i >= 0 {
+		r := h.routers[i]
+		r.Expires = now.Add(ra.RouterLifetime)
+		if ra.SourceLinkAddress != (common.MACAddress{}) {
+			r.LinkAddress = ra.SourceLinkAddress
+		}
+		return false
+	}
+	r := &Router{Address: src, LinkAddress: ra.SourceLinkAddress, Expires: now.Add(ra.RouterLifetime)}
+	h.routers = append(h.routers, r)
+	h.addRoute(defaultRoute(r))
+	return true
+}
+
+// updatePrefix adds, refreshes or removes an on-link prefix.
+func (h *Host) updatePrefix(p PrefixInformation, now time.Time) bool {
+	i := -1
+	for j, q := range h.prefixes {
+		if q.Prefix == p.Prefix && q.Length == p.Length {
+			i = j
+			break
+		}
+	}
+	if p.ValidLifetime == 0 {
+		if i < 0 {
+			return false
+		}
+		h.removeRoute(prefixRoute(h.prefixes[i]))
+		h.prefixes = append(h.prefixes[:i], h.prefixes[i+1:]...)
+		return true
+	}
+
+	if i >= 0 {
+		h.prefixes[i].ValidUntil = until(now, p.ValidLifetime)
+		return false
+	}
+	q := &Prefix{Prefix: p.Prefix, Length: p.Length, ValidUntil: until(now, p.ValidLifetime)}
+	h.prefixes = append(h.prefixes, q)
+	h.addRoute(prefixRoute(q))
+	return true
+}
+
+// updateAddress forms an address in an autonomous prefix, or refreshes
+// the lifetimes of the one formed.
+func (h *Host) updateAddress(p PrefixInformation, now time.Time) bool {
+	if p.PreferredLifetime > p.ValidLifetime || p.Length != 128-InterfaceIDLength {
+		return false
+	}
+
+	var a *Address
+	for _, b := range h.addresses[1:] {
+		if b.PrefixLength == p.Length && ipv6.PrefixMatch(b.Address, p.Prefix, p.Length) {
+			a = b
+			break
+		}
+	}
+	if a == nil {
+		if p.ValidLifetime == 0 {
+			return false
+		}
+		h.addresses = append(h.addresses, &Address{
+			Address:        h.interfaceID(p.Prefix).Address(p.Prefix),
+			PrefixLength:   p.Length,
+			PreferredUntil: until(now, p.PreferredLifetime),
+			ValidUntil:     until(now, p.ValidLifetime),
+		})
+		return true
+	}
+
+	wasDeprecated := expired(a.PreferredUntil, now)
+	a.PreferredUntil = until(now, p.PreferredLifetime)
+
+	// Only a lifetime of over two hours, or one extending the address,
+	// is taken as is; a shorter one cuts the address to two hours
+	remaining := Infinity
+	if !a.ValidUntil.IsZero() {
+		remaining = a.ValidUntil.Sub(now)
+	}
+	switch {
+	case p.ValidLifetime > minValidLifetime || p.ValidLifetime > remaining:
+		a.ValidUntil = until(now, p.ValidLifetime)
+	case remaining > minValidLifetime:
+		a.ValidUntil = now.Add(minValidLifetime)
+	}
+	return wasDeprecated != expired(a.PreferredUntil, now)
+}
+
+// tick expires what the advertisements no longer refresh, and sends the
+// next Router Solicitation.
+func (h *Host) tick(now time.Time) (bool, error) {
+	changed := false
+	routers := h.routers[:0]
+	for _, r := range h.routers {
+		if expired(r.Expires, now) {
+			h.removeRoute(defaultRoute(r))
+			changed = true
+			continue
+		}
+		routers = append(routers, r)
+	}
+	h.routers = routers
+
+	prefixes := h.prefixes[:0]
+	for _, p := range h.prefixes {
+		if expired(p.ValidUntil, now) {
+			h.removeRoute(prefixRoute(p))
+			changed = true
+			continue
+		}
+		prefixes = append(prefixes, p)
+	}
+	h.prefixes = prefixes
+
+	addresses := h.addresses[:0]
+	for _, a := range h.addresses {
+		if expired(a.ValidUntil, now) {
+			changed = true
+			continue
+		}
+		addresses = append(addresses, a)
+	}
+	h.addresses = addresses
+
+	if !h.advertised && h.solicited < h.config.Solicitations && !now.Before(h.nextSolicit) {
+		return changed, h.solicit(now)
+	}
+	return changed, nil
+}
+
+// solicit sends a Router Solicitation to the routers of the link.
+func (h *Host) solicit(now time.Time) error {
+	if h.config.Solicitations < 0 {
+		return nil
+	}
+	h.solicited++
+	h.nextSolicit = now.Add(h.config.SolicitationInterval)
+
+	dst := multicast.AllRoutersMulticast6
+	rs := &RouterSolicitation{SourceLinkAddress: h.dev.MACAddress()}
+	pkt := ipv6.NewPacket(h.linkLocal, dst, common.ProtocolICMPv6, rs.Serialize(h.linkLocal, dst))
+	pkt.HopLimit = HopLimit
+	data, err := pkt.Serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize Router Solicitation: %w", err)
+	}
+	frame := ethernet.NewFrame(multicastMAC(dst), h.dev.MACAddress(), common.EtherTypeIPv6, data)
+	if err := h.dev.WriteFrame(frame); err != nil {
+		return fmt.Errorf("failed to send Router Solicitation: %w", err)
+	}
+	return nil
+}
+
+// interfaceID returns the interface identifier of the host's address in a
+// prefix.
+func (h *Host) interfaceID(prefix common.IPv6Address) InterfaceID {
+	if h.config.StableSecret != nil {
+		return StableInterfaceID(prefix, h.dev.Name(), h.config.NetworkID, 0, h.config.StableSecret)
+	}
+	return EUI64(h.dev.MACAddress())
+}
+
+func (h *Host) hasAddress(addr common.IPv6Address) bool {
+	for _, a := range h.addresses {
+		if a.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Host) findRouter(addr common.IPv6Address) int {
+	for i, r := range h.routers {
+		if r.Address == addr {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *Host) addRoute(r *ipv6.Route) {
+	if h.config.Routes == nil {
+		return
+	}
+	r.Interface = h.dev.Name()
+	r.Metric = h.config.RouteMetric
+	h.config.Routes.AddRoute(r)
+}
+
+func (h *Host) removeRoute(r *ipv6.Route) {
+	if h.config.Routes != nil {
+		h.config.Routes.RemoveRoute(r.Destination, r.PrefixLength, r.Gateway)
+	}
+}
+
+func prefixRoute(p *Prefix) *ipv6.Route {
+	return &ipv6.Route{Destination: p.Prefix, PrefixLength: p.Length}
+}
+
+func defaultRoute(r *Router) *ipv6.Route {
+	return &ipv6.Route{Gateway: r.Address}
+}
+
+// update runs f with the lock held, then calls the change callback if f
+// reports a change.
+func (h *Host) update(f func(now time.Time) (bool, error)) error {
+	h.mu.Lock()
+	changed, err := f(h.now())
+	callback := h.onChange
+	h.mu.Unlock()
+
+	if changed && callback != nil {
+		callback()
+	}
+	return err
+}
+
+// until returns when a lifetime starting now ends, or the zero time for
+// an infinite one.
+func until(now time.Time, lifetime time.Duration) time.Time {
+	if lifetime == Infinity {
+		return time.Time{}
+	}
+	return now.Add(lifetime)
+}
+
+// expired reports whether a time returned by until has passed.
+func expired(t, now time.Time) bool {
+	return !t.IsZero() && !now.Before(t)
+}
@@ -0,0 +1,382 @@
+package ndp
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ipv6"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+)
+
+var (
+	routerMAC = common.MACAddress{0x02, 0, 0, 0, 0, 0xaa}
+	prefix1   = common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 0, 0x01}
+)
+
+// clock is a fake time source.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// testHost returns a host brought up without its timers, which tests run
+// with tick, the far end of its link, and its clock. The first Router
+// Solicitation has been read.
+func testHost(t *testing.T, config Config) (*Host, *memory.Endpoint, *clock) {
+	t.Helper()
+	a, b := memory.NewPipe(memory.Config{})
+	t.Cleanup(func() { a.Close() })
+	h, err := New(a, config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c := &clock{now: time.Unix(1000, 0)}
+	h.now = c.Now
+	if err := h.update(h.up); err != nil {
+		t.Fatalf("up() error = %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	readSolicitation(t, b)
+	return h, b, c
+}
+
+// readSolicitation reads the next frame sent, which must be a valid Router
+// Solicitation.
+func readSolicitation(t *testing.T, b *memory.Endpoint) (common.IPv6Address, *RouterSolicitation) {
+	t.Helper()
+	data, err := b.ReadPacketTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("ReadPacketTimeout() error = %v", err)
+	}
+	frame, err := ethernet.Parse(data)
+	if err != nil {
+		t.Fatalf("ethernet.Parse() error = %v", err)
+	}
+	if want := (common.MACAddress{0x33, 0x33, 0, 0, 0, 0x02}); frame.Destination != want {
+		t.Errorf("frame destination = %s, want %s", frame.Destination, want)
+	}
+	pkt, err := ipv6.Parse(frame.Payload)
+	if err != nil {
+		t.Fatalf("ipv6.Parse() error = %v", err)
+	}
+	if pkt.HopLimit != HopLimit {
+		t.Errorf("HopLimit = %d, want %d", pkt.HopLimit, HopLimit)
+	}
+	if !VerifyChecksum(pkt.Source, pkt.Destination, pkt.Payload) {
+		t.Error("VerifyChecksum() = false, want true")
+	}
+	rs, err := ParseRouterSolicitation(pkt.Payload)
+	if err != nil {
+		t.Fatalf("ParseRouterSolicitation() error = %v", err)
+	}
+	return pkt.Source, rs
+}
+
+// advertise passes a Router Advertisement from routerLL to h.
+func advertise(t *testing.T, h *Host, ra *RouterAdvertisement) {
+	t.Helper()
+	if err := h.HandleFrame(raFrame(routerLL, allNodes, HopLimit, ra)); err != nil {
+		t.Fatalf("HandleFrame() error = %v", err)
+	}
+}
+
+func raFrame(src, dst common.IPv6Address, hopLimit uint8, ra *RouterAdvertisement) *ethernet.Frame {
+	pkt := ipv6.NewPacket(src, dst, common.ProtocolICMPv6, ra.Serialize(src, dst))
+	pkt.HopLimit = hopLimit
+	data, _ := pkt.Serialize()
+	return ethernet.NewFrame(multicastMAC(dst), routerMAC, common.EtherTypeIPv6, data)
+}
+
+func prefixRA(lifetime, valid, preferred time.Duration) *RouterAdvertisement {
+	return &RouterAdvertisement{
+		RouterLifetime: lifetime,
+		Prefixes: []PrefixInformation{{
+			Prefix:            prefix1,
+			Length:            64,
+			OnLink:            true,
+			Autonomous:        true,
+			ValidLifetime:     valid,
+			PreferredLifetime: preferred,
+		}},
+	}
+}
+
+func TestHostSolicitations(t *testing.T) {
+	a, b := memory.NewPipe(memory.Config{})
+	defer a.Close()
+	h, err := New(a, Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c := &clock{now: time.Unix(1000, 0)}
+	h.now = c.Now
+	if err := h.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer h.Close()
+
+	src, rs := readSolicitation(t, b)
+	if want := LinkLocalAddress(EUI64(a.MACAddress())); src != want || h.LinkLocalAddress() != want {
+		t.Errorf("source = %s, LinkLocalAddress() = %s, want %s", src, h.LinkLocalAddress(), want)
+	}
+	if rs.SourceLinkAddress != a.MACAddress() {
+		t.Errorf("SourceLinkAddress = %s, want %s", rs.SourceLinkAddress, a.MACAddress())
+	}
+
+	for i := 1; i < DefaultSolicitations; i++ {
+		c.Advance(DefaultSolicitationInterval)
+		h.update(h.tick)
+		readSolicitation(t, b)
+	}
+	c.Advance(DefaultSolicitationInterval)
+	h.update(h.tick)
+	if _, err := b.ReadPacketTimeout(50 * time.Millisecond); err == nil {
+		t.Errorf("solicitation sent after %d", DefaultSolicitations)
+	}
+}
+
+func TestHostStopsSolicitingWhenAdvertised(t *testing.T) {
+	h, b, c := testHost(t, Config{})
+	advertise(t, h, &RouterAdvertisement{RouterLifetime: time.Minute})
+
+	c.Advance(DefaultSolicitationInterval)
+	h.update(h.tick)
+	if _, err := b.ReadPacketTimeout(50 * time.Millisecond); err == nil {
+		t.Error("solicitation sent after an advertisement")
+	}
+}
+
+func TestHostAutoconfiguration(t *testing.T) {
+	routes := ipv6.NewRoutingTable()
+	h, _, c := testHost(t, Config{Routes: routes})
+	changes := 0
+	h.OnChange(func() { changes++ })
+
+	ra := prefixRA(30*time.Minute, time.Hour, 20*time.Minute)
+	ra.CurHopLimit = 64
+	ra.MTU = 1400
+	ra.SourceLinkAddress = routerMAC
+	advertise(t, h, ra)
+	if changes != 1 {
+		t.Errorf("OnChange called %d times, want 1", changes)
+	}
+
+	want := EUI64(h.dev.MACAddress()).Address(prefix1)
+	addrs := h.Addresses()
+	if len(addrs) != 2 || addrs[0].Address != h.LinkLocalAddress() || addrs[1].Address != want {
+		t.Fatalf("Addresses() = %+v, want the link-local address and %s", addrs, want)
+	}
+	if got := addrs[1]; got.PrefixLength != 64 || got.Deprecated ||
+		!got.PreferredUntil.Equal(c.Now().Add(20*time.Minute)) || !got.ValidUntil.Equal(c.Now().Add(time.Hour)) {
+		t.Errorf("address = %+v, want /64 preferred 20m and valid 1h", got)
+	}
+	if p := h.Prefixes(); len(p) != 1 || p[0].Prefix != prefix1 || p[0].Length != 64 {
+		t.Errorf("Prefixes() = %+v, want %s/64", p, prefix1)
+	}
+	if r := h.DefaultRouters(); len(r) != 1 || r[0].Address != routerLL || r[0].LinkAddress != routerMAC {
+		t.Errorf("DefaultRouters() = %+v, want %s at %s", r, routerLL, routerMAC)
+	}
+	if h.MTU() != 1400 || h.HopLimit() != 64 {
+		t.Errorf("MTU() = %d, HopLimit() = %d, want 1400 and 64", h.MTU(), h.HopLimit())
+	}
+
+	lookup := func(dst common.IPv6Address) (common.IPv6Address, error) {
+		_, hop, err := routes.Lookup(dst)
+		return hop, err
+	}
+	onLink := prefix1
+	onLink[15] = 0x42
+	offLink := common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 0xff, 15: 0x01}
+	if hop, err := lookup(onLink); err != nil || hop != onLink {
+		t.Errorf("Lookup(%s) = %s, %v, want on-link", onLink, hop, err)
+	}
+	if hop, err := lookup(offLink); err != nil || hop != routerLL {
+		t.Errorf("Lookup(%s) = %s, %v, want via %s", offLink, hop, err, routerLL)
+	}
+
+	// The router goes quiet: its lifetime runs out first, then the
+	// address is deprecated, then everything expires
+	c.Advance(30 * time.Minute)
+	h.update(h.tick)
+	if r := h.DefaultRouters(); len(r) != 0 {
+		t.Errorf("DefaultRouters() after router lifetime = %+v, want none", r)
+	}
+	if _, err := lookup(offLink); err == nil {
+		t.Errorf("Lookup(%s) after router lifetime succeeded, want no route", offLink)
+	}
+	if a := h.Addresses(); len(a) != 2 || !a[1].Deprecated {
+		t.Errorf("Addresses() after preferred lifetime = %+v, want the address deprecated", a)
+	}
+
+	c.Advance(30 * time.Minute)
+	h.update(h.tick)
+	if a := h.Addresses(); len(a) != 1 {
+		t.Errorf("Addresses() after valid lifetime = %+v, want the link-local address only", a)
+	}
+	if p := h.Prefixes(); len(p) != 0 {
+		t.Errorf("Prefixes() after valid lifetime = %+v, want none", p)
+	}
+	if _, err := lookup(onLink); err == nil {
+		t.Errorf("Lookup(%s) after valid lifetime succeeded, want no route", onLink)
+	}
+	if changes != 3 {
+		t.Errorf("OnChange called %d times, want 3", changes)
+	}
+
+	// Close removes the link-local route too
+	h.Close()
+	if r := routes.GetRoutes(); len(r) != 0 {
+		t.Errorf("GetRoutes() after Close = %v, want none", r)
+	}
+}
+
+func TestHostRefresh(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining time.Duration // Valid lifetime left when refreshed
+		received  time.Duration
+		want      time.Duration
+	}{
+		{"longer", time.Hour, 3 * time.Hour, 3 * time.Hour},
+		{"over two hours", 10 * time.Hour, 3 * time.Hour, 3 * time.Hour},
+		{"extending", 30 * time.Minute, time.Hour, time.Hour},
+		{"shortening within two hours", time.Hour, 10 * time.Minute, time.Hour},
+		{"shortening below two hours", 10 * time.Hour, 10 * time.Minute, 2 * time.Hour},
+		{"zero", 10 * time.Hour, 0, 2 * time.Hour},
+		{"infinite", time.Hour, Infinity, Infinity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _, c := testHost(t, Config{})
+			advertise(t, h, prefixRA(time.Minute, tt.remaining+time.Minute, 0))
+			c.Advance(time.Minute)
+			advertise(t, h, prefixRA(time.Minute, tt.received, 0))
+
+			a := h.Addresses()
+			if len(a) != 2 {
+				t.Fatalf("Addresses() = %+v, want 2", a)
+			}
+			if tt.want == Infinity {
+				if !a[1].ValidUntil.IsZero() {
+					t.Errorf("ValidUntil = %v, want forever", a[1].ValidUntil)
+				}
+				return
+			}
+			if got := a[1].ValidUntil.Sub(c.Now()); got != tt.want {
+				t.Errorf("valid lifetime = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostWithdrawals(t *testing.T) {
+	routes := ipv6.NewRoutingTable()
+	h, _, _ := testHost(t, Config{Routes: routes})
+	advertise(t, h, prefixRA(time.Hour, time.Hour, time.Hour))
+
+	// A router lifetime of 0 withdraws the router; a valid lifetime of 0
+	// withdraws the on-link prefix, but not the address (RFC 4862 Section
+	// 5.5.3 e)
+	advertise(t, h, prefixRA(0, 0, 0))
+	if r := h.DefaultRouters(); len(r) != 0 {
+		t.Errorf("DefaultRouters() = %+v, want none", r)
+	}
+	if p := h.Prefixes(); len(p) != 0 {
+		t.Errorf("Prefixes() = %+v, want none", p)
+	}
+	if a := h.Addresses(); len(a) != 2 || !a[1].Deprecated {
+		t.Errorf("Addresses() = %+v, want the address deprecated", a)
+	}
+	if r := routes.GetRoutes(); len(r) != 1 || r[0].PrefixLength != 64 || !r[0].Destination.IsLinkLocal() {
+		t.Errorf("GetRoutes() = %v, want the link-local route only", r)
+	}
+}
+
+func TestHostStableAddresses(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	h, _, _ := testHost(t, Config{StableSecret: secret})
+	advertise(t, h, prefixRA(time.Hour, time.Hour, time.Hour))
+
+	name := h.dev.Name()
+	want := []common.IPv6Address{
+		LinkLocalAddress(StableInterfaceID(linkLocalPrefix, name, nil, 0, secret)),
+		StableInterfaceID(prefix1, name, nil, 0, secret).Address(prefix1),
+	}
+	a := h.Addresses()
+	if len(a) != 2 || a[0].Address != want[0] || a[1].Address != want[1] {
+		t.Fatalf("Addresses() = %+v, want %v", a, want)
+	}
+	if eui := EUI64(h.dev.MACAddress()); a[1].Address == eui.Address(prefix1) {
+		t.Errorf("address %s is the EUI-64 one", a[1].Address)
+	}
+}
+
+func TestHostIgnoresAdvertisements(t *testing.T) {
+	h, _, _ := testHost(t, Config{})
+	ra := prefixRA(time.Hour, time.Hour, time.Hour)
+
+	global := common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 0x01}
+	bad := raFrame(routerLL, allNodes, HopLimit, ra)
+	bad.Payload[len(bad.Payload)-1] ^= 0xff
+	other := raFrame(routerLL, common.IPv6Address{0xff, 0x02, 15: 0x99}, HopLimit, ra)
+
+	tests := []struct {
+		name    string
+		frame   *ethernet.Frame
+		wantErr string
+	}{
+		{"forwarded", raFrame(routerLL, allNodes, 64, ra), ""},
+		{"global source", raFrame(global, allNodes, HopLimit, ra), ""},
+		{"other destination", other, ""},
+		{"bad checksum", bad, "checksum"},
+		{"not IPv6", ethernet.NewFrame(h.dev.MACAddress(), routerMAC, common.EtherTypeIPv4, []byte{0x45}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.HandleFrame(tt.frame)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("HandleFrame() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("HandleFrame() error = %v, want %q", err, tt.wantErr)
+			}
+			if a := h.Addresses(); len(a) != 1 {
+				t.Errorf("Addresses() = %+v, want the link-local address only", a)
+			}
+		})
+	}
+}
+
+func TestHostIgnoresBadPrefixes(t *testing.T) {
+	h, _, _ := testHost(t, Config{})
+	ra := prefixRA(time.Hour, time.Hour, time.Hour)
+	ra.Prefixes = []PrefixInformation{
+		{Prefix: common.IPv6Address{0xfe, 0x80}, Length: 64, OnLink: true, Autonomous: true, ValidLifetime: time.Hour},
+		{Prefix: prefix1, Length: 48, Autonomous: true, ValidLifetime: time.Hour},
+		{Prefix: prefix1, Length: 64, Autonomous: true, ValidLifetime: time.Hour, PreferredLifetime: 2 * time.Hour},
+	}
+	advertise(t, h, ra)
+	if a := h.Addresses(); len(a) != 1 {
+		t.Errorf("Addresses() = %+v, want the link-local address only", a)
+	}
+	if p := h.Prefixes(); len(p) != 0 {
+		t.Errorf("Prefixes() = %+v, want none", p)
+	}
+}
@@ -0,0 +1,95 @@
+package ndp
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// InterfaceIDLength is the length in bits of the interface identifiers
+// SLAAC forms addresses with; only prefixes of 128 - InterfaceIDLength
+// bits can be used.
+const InterfaceIDLength = 64
+
+// InterfaceID is the low 64 bits of an address, identifying an interface
+// on its link.
+type InterfaceID [8]byte
+
+// EUI64 returns the modified EUI-64 interface identifier of a MAC address
+// (RFC 4291 Appendix A): 0xfffe in the middle, and the universal/local bit
+// inverted. It exposes the MAC address to every host the address reaches;
+// StableInterfaceID does not.
+func EUI64(mac common.MACAddress) InterfaceID {
+	return InterfaceID{mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]}
+}
+
+// StableInterfaceID returns the semantically opaque interface identifier
+// of RFC 7217 for a prefix: stable as long as the host stays on the same
+// prefix and interface, but different on every prefix, so it cannot be
+// used to follow the host between networks.
+//
+// networkID optionally identifies the network, such as the SSID of a
+// wireless one. dadCounter starts at 0, and is incremented to form another
+// address when the one formed is found to be a duplicate. The secret
+// must be kept across restarts for the identifier to remain stable.
+func StableInterfaceID(prefix common.IPv6Address, ifname string, networkID []byte, dadCounter uint8, secret []byte) InterfaceID {
+	for {
+		h := sha256.New()
+		h.Write(prefix[:8])
+		h.Write([]byte(ifname))
+		h.Write(networkID)
+		h.Write([]byte{dadCounter})
+		h.Write(secret)
+
+		var id InterfaceID
+		copy(id[:], h.Sum(nil))
+		if !id.Reserved() {
+			return id
+		}
+		dadCounter++ // RFC 7217 Section 5
+	}
+}
+
+// Reserved reports whether the identifier is one hosts must not use (RFC
+// 5453): zero, which is the Subnet-Router anycast address, the reserved
+// 0200:5eff:fe00:0000 to 0200:5eff:fe00:5212 range, or the subnet anycast
+// fdff:ffff:ffff:ff80 to fdff:ffff:ffff:ffff range.
+func (id InterfaceID) Reserved() bool {
+	v := binary.BigEndian.Uint64(id[:])
+	return v == 0 ||
+		(v >= 0x02005efffe000000 && v <= 0x02005efffe005212) ||
+		(v >= 0xfdffffffffffff80 && v <= 0xfdffffffffffffff)
+}
+
+// Address returns the address of the identifier in a prefix of
+// 128 - InterfaceIDLength bits.
+func (id InterfaceID) Address(prefix common.IPv6Address) common.IPv6Address {
+	addr := prefix
+	copy(addr[8:], id[:])
+	return addr
+}
+
+// String returns the identifier in the notation of the low half of an
+// address.
+func (id InterfaceID) String() string {
+	return fmt.Sprintf("%x:%x:%x:%x",
+		binary.BigEndian.Uint16(id[0:2]), binary.BigEndian.Uint16(id[2:4]),
+		binary.BigEndian.Uint16(id[4:6]), binary.BigEndian.Uint16(id[6:8]))
+}
+
+// linkLocalPrefix is fe80::/64.
+var linkLocalPrefix = common.IPv6Address{0xfe, 0x80}
+
+// LinkLocalAddress returns the link-local address of an interface
+// identifier.
+func LinkLocalAddress(id InterfaceID) common.IPv6Address {
+	return id.Address(linkLocalPrefix)
+}
+
+// multicastMAC returns the Ethernet multicast address of an IPv6
+// multicast address (RFC 2464 Section 7).
+func multicastMAC(addr common.IPv6Address) common.MACAddress {
+	return common.MACAddress{0x33, 0x33, addr[12], addr[13], addr[14], addr[15]}
+}
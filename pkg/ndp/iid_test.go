@@ -0,0 +1,81 @@
+package ndp
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestEUI64(t *testing.T) {
+	tests := []struct {
+		mac  common.MACAddress
+		want string
+	}{
+		{common.MACAddress{0x00, 0x1b, 0x21, 0x3a, 0x4b, 0x5c}, "21b:21ff:fe3a:4b5c"},
+		{common.MACAddress{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}, "0:ff:fe00:1"},
+	}
+	for _, tt := range tests {
+		if got := EUI64(tt.mac).String(); got != tt.want {
+			t.Errorf("EUI64(%s) = %s, want %s", tt.mac, got, tt.want)
+		}
+	}
+
+	ll := LinkLocalAddress(EUI64(common.MACAddress{0x00, 0x1b, 0x21, 0x3a, 0x4b, 0x5c}))
+	if got, want := ll.String(), "fe80::21b:21ff:fe3a:4b5c"; got != want {
+		t.Errorf("LinkLocalAddress() = %s, want %s", got, want)
+	}
+}
+
+func TestStableInterfaceID(t *testing.T) {
+	prefix1 := common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 0, 0x01}
+	prefix2 := common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 0, 0x02}
+	secret := []byte("secret")
+
+	id := StableInterfaceID(prefix1, "eth0", nil, 0, secret)
+	if again := StableInterfaceID(prefix1, "eth0", nil, 0, secret); again != id {
+		t.Errorf("StableInterfaceID() = %s, then %s, want stable", id, again)
+	}
+
+	// The low bits of the prefix are not part of it
+	sub := prefix1
+	sub[15] = 0x01
+	if got := StableInterfaceID(sub, "eth0", nil, 0, secret); got != id {
+		t.Errorf("StableInterfaceID() with interface ID bits set = %s, want %s", got, id)
+	}
+
+	others := []struct {
+		name string
+		id   InterfaceID
+	}{
+		{"prefix", StableInterfaceID(prefix2, "eth0", nil, 0, secret)},
+		{"interface", StableInterfaceID(prefix1, "eth1", nil, 0, secret)},
+		{"network", StableInterfaceID(prefix1, "eth0", []byte("ssid"), 0, secret)},
+		{"DAD counter", StableInterfaceID(prefix1, "eth0", nil, 1, secret)},
+		{"secret", StableInterfaceID(prefix1, "eth0", nil, 0, []byte("other"))},
+	}
+	for _, o := range others {
+		if o.id == id {
+			t.Errorf("StableInterfaceID() with another %s = %s, want a different one", o.name, o.id)
+		}
+	}
+}
+
+func TestInterfaceIDReserved(t *testing.T) {
+	tests := []struct {
+		id   InterfaceID
+		want bool
+	}{
+		{InterfaceID{}, true},
+		{InterfaceID{0x02, 0x00, 0x5e, 0xff, 0xfe, 0x00, 0x00, 0x00}, true},
+		{InterfaceID{0x02, 0x00, 0x5e, 0xff, 0xfe, 0x00, 0x52, 0x12}, true},
+		{InterfaceID{0x02, 0x00, 0x5e, 0xff, 0xfe, 0x00, 0x52, 0x13}, false},
+		{InterfaceID{0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x80}, true},
+		{InterfaceID{0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, false},
+		{InterfaceID{0, 0, 0, 0, 0, 0, 0, 1}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.id.Reserved(); got != tt.want {
+			t.Errorf("%s.Reserved() = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
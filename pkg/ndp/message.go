@@ -0,0 +1,313 @@
+// Package ndp implements the router discovery part of IPv6 Neighbor
+// Discovery (RFC 4861) and stateless address autoconfiguration (RFC 4862),
+// so a host configures its addresses and default routes from the Router
+// Advertisements of its link.
+package ndp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// Router Solicitation format (RFC 4861 Section 4.1):
+// +----------+----------+---------------+
+// | Type (8) | Code (8) | Checksum (16) |
+// +----------+----------+---------------+
+// | Reserved (32)                       |
+// +-------------------------------------+
+// | Options...                          |
+// +-------------------------------------+
+//
+// Router Advertisement format (RFC 4861 Section 4.2):
+// +------------------+--------------+---------------------+
+// | Type (8)         | Code (8)     | Checksum (16)       |
+// +------------------+--------------+---------------------+
+// | Cur Hop Limit (8)| M|O|Rsvd (8) | Router Lifetime (16)|
+// +------------------+--------------+---------------------+
+// | Reachable Time (32)                                   |
+// | Retrans Timer (32)                                    |
+// +-------------------------------------------------------+
+// | Options...                                            |
+// +-------------------------------------------------------+
+
+// ICMPv6 message types of router discovery.
+const (
+	TypeRouterSolicitation  = 133
+	TypeRouterAdvertisement = 134
+)
+
+const (
+	// HopLimit is the hop limit of every Neighbor Discovery message. A
+	// message received with another has been forwarded by a router, and
+	// so comes from off the link.
+	HopLimit = 255
+
+	// RouterSolicitationSize is the size of a Router Solicitation without
+	// options (8 bytes).
+	RouterSolicitationSize = 8
+
+	// RouterAdvertisementSize is the size of a Router Advertisement
+	// without options (16 bytes).
+	RouterAdvertisementSize = 16
+
+	// Infinity is the lifetime of lifetime fields of all ones.
+	Infinity time.Duration = math.MaxInt64
+)
+
+// Option types (RFC 4861 Section 4.6).
+const (
+	optionSourceLinkAddress = 1
+	optionPrefixInformation = 3
+	optionMTU               = 5
+)
+
+// Prefix Information flags.
+const (
+	flagOnLink     = 0x80
+	flagAutonomous = 0x40
+)
+
+// Router Advertisement flags.
+const (
+	flagManaged = 0x80
+	flagOther   = 0x40
+)
+
+// prefixInformationSize is the size of a Prefix Information option.
+const prefixInformationSize = 32
+
+// PrefixInformation is a Prefix Information option: a prefix of the link,
+// and whether hosts may consider it on-link and form addresses in it.
+type PrefixInformation struct {
+	Prefix            common.IPv6Address
+	Length            int
+	OnLink            bool // L flag
+	Autonomous        bool // A flag
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+}
+
+// RouterSolicitation asks the routers of a link to advertise themselves.
+type RouterSolicitation struct {
+	// SourceLinkAddress is the sender's MAC address, omitted if zero. It
+	// must be omitted when the source address is unspecified.
+	SourceLinkAddress common.MACAddress
+}
+
+// RouterAdvertisement is a router's announcement of itself and of the
+// parameters of its link.
+type RouterAdvertisement struct {
+	CurHopLimit    uint8 // 0 if unspecified
+	Managed        bool  // Addresses are available from DHCPv6
+	Other          bool  // Other configuration is available from DHCPv6
+	RouterLifetime time.Duration
+	ReachableTime  time.Duration // 0 if unspecified
+	RetransTimer   time.Duration // 0 if unspecified
+
+	SourceLinkAddress common.MACAddress // Zero if omitted
+	MTU               uint32            // 0 if omitted
+	Prefixes          []PrefixInformation
+}
+
+// ParseRouterSolicitation parses a Router Solicitation from an ICMPv6
+// message. The checksum is not verified; see VerifyChecksum.
+func ParseRouterSolicitation(data []byte) (*RouterSolicitation, error) {
+	if err := checkHeader(data, TypeRouterSolicitation, RouterSolicitationSize); err != nil {
+		return nil, err
+	}
+	m := &RouterSolicitation{}
+	err := parseOptions(data[RouterSolicitationSize:], func(t uint8, body []byte) error {
+		if t == optionSourceLinkAddress && len(body) >= 6 {
+			copy(m.SourceLinkAddress[:], body)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Serialize converts the solicitation to an ICMPv6 message sent from src to
+// dst.
+func (m *RouterSolicitation) Serialize(src, dst common.IPv6Address) []byte {
+	data := make([]byte, RouterSolicitationSize)
+	data[0] = TypeRouterSolicitation
+	if m.SourceLinkAddress != (common.MACAddress{}) {
+		data = appendLinkAddress(data, optionSourceLinkAddress, m.SourceLinkAddress)
+	}
+	binary.BigEndian.PutUint16(data[2:4], checksum(src, dst, data))
+	return data
+}
+
+// ParseRouterAdvertisement parses a Router Advertisement from an ICMPv6
+// message. Unknown options are skipped. The checksum is not verified; see
+// VerifyChecksum.
+func ParseRouterAdvertisement(data []byte) (*RouterAdvertisement, error) {
+	if err := checkHeader(data, TypeRouterAdvertisement, RouterAdvertisementSize); err != nil {
+		return nil, err
+	}
+	m := &RouterAdvertisement{
+		CurHopLimit:    data[4],
+		Managed:        data[5]&flagManaged != 0,
+		Other:          data[5]&flagOther != 0,
+		RouterLifetime: time.Duration(binary.BigEndian.Uint16(data[6:8])) * time.Second,
+		ReachableTime:  time.Duration(binary.BigEndian.Uint32(data[8:12])) * time.Millisecond,
+		RetransTimer:   time.Duration(binary.BigEndian.Uint32(data[12:16])) * time.Millisecond,
+	}
+	err := parseOptions(data[RouterAdvertisementSize:], func(t uint8, body []byte) error {
+		switch t {
+		case optionSourceLinkAddress:
+			if len(body) >= 6 {
+				copy(m.SourceLinkAddress[:], body)
+			}
+		case optionMTU:
+			if len(body) != 6 {
+				return fmt.Errorf("invalid MTU option length: %d bytes", len(body)+2)
+			}
+			m.MTU = binary.BigEndian.Uint32(body[2:6])
+		case optionPrefixInformation:
+			if len(body) != prefixInformationSize-2 {
+				return fmt.Errorf("invalid prefix information option length: %d bytes", len(body)+2)
+			}
+			p := PrefixInformation{
+				Length:            int(body[0]),
+				OnLink:            body[1]&flagOnLink != 0,
+				Autonomous:        body[1]&flagAutonomous != 0,
+				ValidLifetime:     parseLifetime(body[2:6]),
+				PreferredLifetime: parseLifetime(body[6:10]),
+			}
+			if p.Length > 128 {
+				return fmt.Errorf("invalid prefix length: %d", p.Length)
+			}
+			copy(p.Prefix[:], body[14:30])
+			m.Prefixes = append(m.Prefixes, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Serialize converts the advertisement to an ICMPv6 message sent from src
+// to dst.
+func (m *RouterAdvertisement) Serialize(src, dst common.IPv6Address) []byte {
+	data := make([]byte, RouterAdvertisementSize)
+	data[0] = TypeRouterAdvertisement
+	data[4] = m.CurHopLimit
+	if m.Managed {
+		data[5] |= flagManaged
+	}
+	if m.Other {
+		data[5] |= flagOther
+	}
+	binary.BigEndian.PutUint16(data[6:8], uint16(min(m.RouterLifetime/time.Second, math.MaxUint16)))
+	binary.BigEndian.PutUint32(data[8:12], uint32(m.ReachableTime/time.Millisecond))
+	binary.BigEndian.PutUint32(data[12:16], uint32(m.RetransTimer/time.Millisecond))
+
+	if m.SourceLinkAddress != (common.MACAddress{}) {
+		data = appendLinkAddress(data, optionSourceLinkAddress, m.SourceLinkAddress)
+	}
+	if m.MTU != 0 {
+		data = append(data, optionMTU, 1, 0, 0)
+		data = binary.BigEndian.AppendUint32(data, m.MTU)
+	}
+	for _, p := range m.Prefixes {
+		var flags uint8
+		if p.OnLink {
+			flags |= flagOnLink
+		}
+		if p.Autonomous {
+			flags |= flagAutonomous
+		}
+		data = append(data, optionPrefixInformation, prefixInformationSize/8, uint8(p.Length), flags)
+		data = appendLifetime(data, p.ValidLifetime)
+		data = appendLifetime(data, p.PreferredLifetime)
+		data = append(data, 0, 0, 0, 0) // Reserved
+		data = append(data, p.Prefix[:]...)
+	}
+	binary.BigEndian.PutUint16(data[2:4], checksum(src, dst, data))
+	return data
+}
+
+// VerifyChecksum reports whether the checksum of an ICMPv6 message sent
+// from src to dst is correct.
+func VerifyChecksum(src, dst common.IPv6Address, data []byte) bool {
+	return checksum(src, dst, data) == 0
+}
+
+// checksum computes the ICMPv6 checksum over the IPv6 pseudo-header and
+// the message, including its current Checksum field (RFC 4443 Section
+// 2.3).
+func checksum(src, dst common.IPv6Address, data []byte) uint16 {
+	var c common.Checksummer
+	c.AddPseudoHeaderIPv6(common.PseudoHeaderIPv6{
+		SourceAddr:      src,
+		DestinationAddr: dst,
+		Length:          uint32(len(data)),
+		NextHeader:      common.ProtocolICMPv6,
+	})
+	c.Add(data)
+	return c.Sum()
+}
+
+func checkHeader(data []byte, t uint8, size int) error {
+	if len(data) < size {
+		return fmt.Errorf("message too short: %d bytes (minimum %d)", len(data), size)
+	}
+	if data[0] != t {
+		return fmt.Errorf("unexpected ICMPv6 type: %d (expected %d)", data[0], t)
+	}
+	if data[1] != 0 {
+		return fmt.Errorf("invalid code: %d", data[1])
+	}
+	return nil
+}
+
+// parseOptions calls f with the type and body of each option, the body
+// being the option without its type and length.
+func parseOptions(data []byte, f func(t uint8, body []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return fmt.Errorf("truncated option")
+		}
+		length := int(data[1]) * 8
+		if length == 0 {
+			return fmt.Errorf("option %d has zero length", data[0])
+		}
+		if length > len(data) {
+			return fmt.Errorf("option %d truncated: %d bytes, have %d", data[0], length, len(data))
+		}
+		if err := f(data[0], data[2:length]); err != nil {
+			return err
+		}
+		data = data[length:]
+	}
+	return nil
+}
+
+func appendLinkAddress(data []byte, t uint8, mac common.MACAddress) []byte {
+	data = append(data, t, 1)
+	return append(data, mac[:]...)
+}
+
+func parseLifetime(b []byte) time.Duration {
+	v := binary.BigEndian.Uint32(b)
+	if v == math.MaxUint32 {
+		return Infinity
+	}
+	return time.Duration(v) * time.Second
+}
+
+func appendLifetime(data []byte, d time.Duration) []byte {
+	if d == Infinity || d/time.Second >= math.MaxUint32 {
+		return binary.BigEndian.AppendUint32(data, math.MaxUint32)
+	}
+	return binary.BigEndian.AppendUint32(data, uint32(d/time.Second))
+}
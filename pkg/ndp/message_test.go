@@ -0,0 +1,130 @@
+package ndp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+var (
+	routerLL = common.IPv6Address{0xfe, 0x80, 15: 0x01}
+	allNodes = common.IPv6Address{0xff, 0x02, 15: 0x01}
+)
+
+func TestRouterAdvertisementRoundTrip(t *testing.T) {
+	ra := &RouterAdvertisement{
+		CurHopLimit:       64,
+		Other:             true,
+		RouterLifetime:    1800 * time.Second,
+		ReachableTime:     30 * time.Second,
+		RetransTimer:      time.Second,
+		SourceLinkAddress: common.MACAddress{0x02, 0, 0, 0, 0, 0x01},
+		MTU:               1500,
+		Prefixes: []PrefixInformation{
+			{
+				Prefix:            common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 0, 0x01},
+				Length:            64,
+				OnLink:            true,
+				Autonomous:        true,
+				ValidLifetime:     24 * time.Hour,
+				PreferredLifetime: 4 * time.Hour,
+			},
+			{
+				Prefix:            common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 0, 0x02},
+				Length:            48,
+				OnLink:            true,
+				ValidLifetime:     Infinity,
+				PreferredLifetime: Infinity,
+			},
+		},
+	}
+
+	data := ra.Serialize(routerLL, allNodes)
+	if want := RouterAdvertisementSize + 8 + 8 + 2*prefixInformationSize; len(data) != want {
+		t.Fatalf("len(Serialize()) = %d, want %d", len(data), want)
+	}
+	if !VerifyChecksum(routerLL, allNodes, data) {
+		t.Error("VerifyChecksum() = false, want true")
+	}
+	if VerifyChecksum(routerLL, common.IPv6Address{0xff, 0x02, 15: 0x02}, data) {
+		t.Error("VerifyChecksum() with another destination = true, want false")
+	}
+
+	got, err := ParseRouterAdvertisement(data)
+	if err != nil {
+		t.Fatalf("ParseRouterAdvertisement() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, ra) {
+		t.Errorf("ParseRouterAdvertisement() = %+v, want %+v", got, ra)
+	}
+}
+
+func TestRouterSolicitationRoundTrip(t *testing.T) {
+	src := common.IPv6Address{0xfe, 0x80, 15: 0x02}
+	dst := common.IPv6Address{0xff, 0x02, 15: 0x02}
+
+	tests := []struct {
+		name string
+		rs   RouterSolicitation
+		size int
+	}{
+		{"with link address", RouterSolicitation{SourceLinkAddress: common.MACAddress{0x02, 0, 0, 0, 0, 0x02}}, 16},
+		{"without link address", RouterSolicitation{}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.rs.Serialize(src, dst)
+			if len(data) != tt.size {
+				t.Fatalf("len(Serialize()) = %d, want %d", len(data), tt.size)
+			}
+			if !VerifyChecksum(src, dst, data) {
+				t.Error("VerifyChecksum() = false, want true")
+			}
+			got, err := ParseRouterSolicitation(data)
+			if err != nil {
+				t.Fatalf("ParseRouterSolicitation() error = %v", err)
+			}
+			if *got != tt.rs {
+				t.Errorf("ParseRouterSolicitation() = %+v, want %+v", got, tt.rs)
+			}
+		})
+	}
+}
+
+func TestParseRouterAdvertisementErrors(t *testing.T) {
+	valid := (&RouterAdvertisement{RouterLifetime: time.Minute}).Serialize(routerLL, allNodes)
+	withOptions := func(options ...byte) []byte {
+		return append(append([]byte(nil), valid...), options...)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"too short", valid[:10], "too short"},
+		{"solicitation", (&RouterSolicitation{SourceLinkAddress: routerMAC}).Serialize(routerLL, allNodes), "unexpected ICMPv6 type"},
+		{"nonzero code", append([]byte{TypeRouterAdvertisement, 1}, valid[2:]...), "invalid code"},
+		{"zero length option", withOptions(optionSourceLinkAddress, 0, 0, 0, 0, 0, 0, 0), "zero length"},
+		{"truncated option", withOptions(optionSourceLinkAddress, 2, 0, 0, 0, 0, 0, 0), "truncated"},
+		{"short prefix option", withOptions(optionPrefixInformation, 1, 64, 0, 0, 0, 0, 0), "prefix information option length"},
+		{"short MTU option", withOptions(optionMTU, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0), "MTU option length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRouterAdvertisement(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseRouterAdvertisement() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Unknown options are skipped
+	data := withOptions(42, 1, 0, 0, 0, 0, 0, 0)
+	if _, err := ParseRouterAdvertisement(data); err != nil {
+		t.Errorf("ParseRouterAdvertisement() with unknown option error = %v", err)
+	}
+}
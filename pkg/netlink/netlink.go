@@ -0,0 +1,444 @@
+// Package netlink reads the Linux kernel's network configuration over
+// rtnetlink, so the custom stack can mirror the host's.
+//
+// Read dumps the kernel's interfaces, IPv4 addresses and main table routes,
+// and Import loads them into an ip.RoutingTable. Watch does the same and then
+// keeps the table in sync as the kernel announces changes (RTM_NEWROUTE,
+// RTM_DELROUTE and the address and link messages).
+//
+// Dumps need no privileges.
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"syscall"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+// rtaxMTU is the RTA_METRICS attribute holding a route's MTU.
+const rtaxMTU = 2
+
+// Link is a kernel network interface.
+type Link struct {
+	Index        int
+	Name         string
+	MTU          int
+	HardwareAddr common.MACAddress
+	Up           bool // Administratively up and running (IFF_UP and IFF_RUNNING)
+}
+
+// Address is an IPv4 address assigned to a kernel network interface.
+type Address struct {
+	Index        int    // Interface index
+	Interface    string // Interface name
+	Address      common.IPv4Address
+	PrefixLength int
+}
+
+// Netmask returns the netmask of the address's network.
+func (a *Address) Netmask() common.IPv4Address {
+	return common.NetmaskFromLength(a.PrefixLength)
+}
+
+// Snapshot is the host's network configuration at one moment.
+type Snapshot struct {
+	Links     []Link
+	Addresses []Address
+	Routes    []*ip.Route // IPv4 unicast routes of the main table
+}
+
+// Read dumps the kernel's interfaces, IPv4 addresses and main table IPv4
+// unicast routes.
+func Read() (*Snapshot, error) {
+	s := &Snapshot{}
+	links := make(map[int]string)
+
+	msgs, err := dump(syscall.RTM_GETLINK, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump links: %w", err)
+	}
+	for i := range msgs {
+		link, ok, err := parseLink(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.Links = append(s.Links, *link)
+			links[link.Index] = link.Name
+		}
+	}
+
+	msgs, err = dump(syscall.RTM_GETADDR, syscall.AF_INET)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump addresses: %w", err)
+	}
+	for i := range msgs {
+		addr, ok, err := parseAddress(&msgs[i], links)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.Addresses = append(s.Addresses, *addr)
+		}
+	}
+
+	msgs, err = dump(syscall.RTM_GETROUTE, syscall.AF_INET)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump routes: %w", err)
+	}
+	for i := range msgs {
+		route, ok, err := parseRoute(&msgs[i], links)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.Routes = append(s.Routes, route)
+		}
+	}
+
+	return s, nil
+}
+
+// Import reads the kernel's configuration and loads it into a routing
+// table: the addresses of the interfaces that are up become local
+// interfaces, the table gets the MTUs of all, and their routes replace the table's routes to the same
+// networks, in one change. The table's other routes are kept.
+func Import(rt *ip.RoutingTable) (*Snapshot, error) {
+	s, err := Read()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Import(rt); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Import loads the snapshot into a routing table, as the Import function
+// does. Of the kernel's routes to a network, the table gets the one with
+// the lowest metric.
+func (s *Snapshot) Import(rt *ip.RoutingTable) error {
+	up := make(map[string]bool)
+	for _, link := range s.Links {
+		up[link.Name] = link.Up
+		rt.SetInterfaceMTU(link.Name, link.MTU)
+	}
+
+	for _, addr := range s.Addresses {
+		if up[addr.Interface] {
+			rt.AddLocalInterface(addr.Interface, addr.Address)
+		}
+	}
+
+	var keys []prefix
+	networks := make(map[prefix][]*ip.Route)
+	for _, route := range s.Routes {
+		key := routePrefix(route)
+		if _, exists := networks[key]; !exists {
+			keys = append(keys, key)
+		}
+		networks[key] = append(networks[key], route)
+	}
+
+	var changes []ip.RouteChange
+	for _, key := range keys {
+		if best := bestRoute(networks[key], up); best != nil {
+			changes = append(changes, ip.RouteChange{Op: ip.RouteReplace, Route: best})
+		}
+	}
+	if err := rt.Apply(changes); err != nil {
+		return fmt.Errorf("failed to import routes: %w", err)
+	}
+	return nil
+}
+
+// prefix identifies the network a route leads to.
+type prefix struct {
+	destination common.IPv4Address
+	netmask     common.IPv4Address
+}
+
+// routePrefix returns the network a route leads to.
+func routePrefix(route *ip.Route) prefix {
+	return prefix{route.Destination, route.Netmask}
+}
+
+// bestRoute returns the route with the lowest metric whose interface is
+// up, as the kernel would use, or nil.
+func bestRoute(routes []*ip.Route, up map[string]bool) *ip.Route {
+	var best *ip.Route
+	for _, route := range routes {
+		if routeUp(route, up) && (best == nil || route.Metric < best.Metric) {
+			best = route
+		}
+	}
+	return best
+}
+
+// routeUp reports whether a route's interface is up, or for a multipath
+// route whether any of its paths' interfaces is. The paths of a multipath
+// route over interfaces that are down are marked down.
+func routeUp(route *ip.Route, up map[string]bool) bool {
+	if route.NextHops == nil {
+		return up[route.Interface]
+	}
+	usable := false
+	for i, hop := range route.NextHops.NextHops() {
+		route.NextHops.SetHealthy(i, up[hop.Interface])
+		usable = usable || up[hop.Interface]
+	}
+	return usable
+}
+
+// dump requests a dump of a kind of object and returns its messages.
+func dump(proto, family int) ([]syscall.NetlinkMessage, error) {
+	raw, err := syscall.NetlinkRIB(proto, family)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse netlink messages: %w", err)
+	}
+	return msgs, nil
+}
+
+// parseLink parses an RTM_NEWLINK or RTM_DELLINK message. It returns false
+// for other messages.
+func parseLink(msg *syscall.NetlinkMessage) (*Link, bool, error) {
+	if msg.Header.Type != syscall.RTM_NEWLINK && msg.Header.Type != syscall.RTM_DELLINK {
+		return nil, false, nil
+	}
+	if len(msg.Data) < syscall.SizeofIfInfomsg {
+		return nil, false, fmt.Errorf("link message too short: %d bytes", len(msg.Data))
+	}
+	attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse link attributes: %w", err)
+	}
+
+	// struct ifinfomsg: family, pad, type, index, flags, change
+	flags := binary.NativeEndian.Uint32(msg.Data[8:12])
+	link := &Link{
+		Index: int(int32(binary.NativeEndian.Uint32(msg.Data[4:8]))),
+		Up:    flags&syscall.IFF_UP != 0 && flags&syscall.IFF_RUNNING != 0,
+	}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.IFLA_IFNAME:
+			link.Name = cString(attr.Value)
+		case syscall.IFLA_MTU:
+			if len(attr.Value) >= 4 {
+				link.MTU = int(binary.NativeEndian.Uint32(attr.Value))
+			}
+		case syscall.IFLA_ADDRESS:
+			if len(attr.Value) == len(link.HardwareAddr) {
+				copy(link.HardwareAddr[:], attr.Value)
+			}
+		}
+	}
+	return link, true, nil
+}
+
+// parseAddress parses an RTM_NEWADDR or RTM_DELADDR message for an IPv4
+// address, naming its interface from links. It returns false for other
+// messages.
+func parseAddress(msg *syscall.NetlinkMessage, links map[int]string) (*Address, bool, error) {
+	if msg.Header.Type != syscall.RTM_NEWADDR && msg.Header.Type != syscall.RTM_DELADDR {
+		return nil, false, nil
+	}
+	if len(msg.Data) < syscall.SizeofIfAddrmsg {
+		return nil, false, fmt.Errorf("address message too short: %d bytes", len(msg.Data))
+	}
+	if msg.Data[0] != syscall.AF_INET {
+		return nil, false, nil
+	}
+	attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse address attributes: %w", err)
+	}
+
+	// struct ifaddrmsg: family, prefixlen, flags, scope, index
+	addr := &Address{
+		Index:        int(binary.NativeEndian.Uint32(msg.Data[4:8])),
+		PrefixLength: int(msg.Data[1]),
+	}
+	addr.Interface = links[addr.Index]
+
+	// IFA_LOCAL is the interface's address; IFA_ADDRESS is the peer's on
+	// point-to-point links and the same as IFA_LOCAL otherwise
+	found := false
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.IFA_LOCAL:
+			found = copyIPv4(&addr.Address, attr.Value)
+		case syscall.IFA_ADDRESS:
+			if !found {
+				found = copyIPv4(&addr.Address, attr.Value)
+			}
+		}
+	}
+	if !found {
+		return nil, false, fmt.Errorf("address message without an address")
+	}
+	return addr, true, nil
+}
+
+// parseRoute parses an RTM_NEWROUTE or RTM_DELROUTE message for an IPv4
+// unicast route of the main table, naming its interface from links. It
+// returns false for other messages and routes.
+func parseRoute(msg *syscall.NetlinkMessage, links map[int]string) (*ip.Route, bool, error) {
+	if msg.Header.Type != syscall.RTM_NEWROUTE && msg.Header.Type != syscall.RTM_DELROUTE {
+		return nil, false, nil
+	}
+	if len(msg.Data) < syscall.SizeofRtMsg {
+		return nil, false, fmt.Errorf("route message too short: %d bytes", len(msg.Data))
+	}
+
+	// struct rtmsg: family, dst_len, src_len, tos, table, protocol, scope,
+	// type, flags
+	family, dstLen, table, typ := msg.Data[0], int(msg.Data[1]), uint32(msg.Data[4]), msg.Data[7]
+	flags := binary.NativeEndian.Uint32(msg.Data[8:12])
+	if family != syscall.AF_INET || typ != syscall.RTN_UNICAST || flags&syscall.RTM_F_CLONED != 0 {
+		return nil, false, nil
+	}
+	if dstLen > 32 {
+		return nil, false, fmt.Errorf("invalid route prefix length: %d", dstLen)
+	}
+	attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse route attributes: %w", err)
+	}
+
+	route := &ip.Route{Netmask: common.NetmaskFromLength(dstLen)}
+	oif := 0
+	var hops []ip.NextHop
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.RTA_TABLE:
+			if len(attr.Value) >= 4 {
+				table = binary.NativeEndian.Uint32(attr.Value)
+			}
+		case syscall.RTA_DST:
+			copyIPv4(&route.Destination, attr.Value)
+		case syscall.RTA_GATEWAY:
+			copyIPv4(&route.Gateway, attr.Value)
+		case syscall.RTA_OIF:
+			if len(attr.Value) >= 4 {
+				oif = int(binary.NativeEndian.Uint32(attr.Value))
+			}
+		case syscall.RTA_PRIORITY:
+			if len(attr.Value) >= 4 {
+				route.Metric = int(binary.NativeEndian.Uint32(attr.Value))
+			}
+		case syscall.RTA_MULTIPATH:
+			hops = parseNextHops(attr.Value, links)
+		case syscall.RTA_METRICS:
+			route.MTU = parseMTU(attr.Value)
+		}
+	}
+	if table != syscall.RT_TABLE_MAIN {
+		return nil, false, nil
+	}
+	route.Interface = links[oif]
+
+	if len(hops) > 0 {
+		multipath, err := ip.NewMultipathRoute(route.Destination, route.Netmask, route.Metric, hops...)
+		if err != nil {
+			return nil, false, err
+		}
+		route = multipath
+	}
+	return route, true, nil
+}
+
+// parseNextHops parses the next hops of an RTA_MULTIPATH attribute, naming
+// their interfaces from links.
+func parseNextHops(b []byte, links map[int]string) []ip.NextHop {
+	var hops []ip.NextHop
+
+	// struct rtnexthop: len, flags, hops, ifindex, then attributes
+	for len(b) >= syscall.SizeofRtNexthop {
+		length := int(binary.NativeEndian.Uint16(b[0:2]))
+		if length < syscall.SizeofRtNexthop || length > len(b) {
+			break
+		}
+		hop := ip.NextHop{
+			Interface: links[int(binary.NativeEndian.Uint32(b[4:8]))],
+			Weight:    int(b[3]) + 1, // rtnh_hops is the weight less one
+		}
+
+		attrs := b[syscall.SizeofRtNexthop:length]
+		for len(attrs) >= syscall.SizeofRtAttr {
+			attrLen := int(binary.NativeEndian.Uint16(attrs[0:2]))
+			if attrLen < syscall.SizeofRtAttr || attrLen > len(attrs) {
+				break
+			}
+			if binary.NativeEndian.Uint16(attrs[2:4]) == syscall.RTA_GATEWAY {
+				copyIPv4(&hop.Gateway, attrs[syscall.SizeofRtAttr:attrLen])
+			}
+			attrs = attrs[min(rtaAlign(attrLen), len(attrs)):]
+		}
+
+		hops = append(hops, hop)
+		b = b[min(rtaAlign(length), len(b)):]
+	}
+	return hops
+}
+
+// parseMTU returns the MTU among the metrics of an RTA_METRICS attribute,
+// 0 if it has none.
+func parseMTU(b []byte) int {
+	for len(b) >= syscall.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(b[0:2]))
+		if length < syscall.SizeofRtAttr || length > len(b) {
+			break
+		}
+		if binary.NativeEndian.Uint16(b[2:4]) == rtaxMTU && length >= syscall.SizeofRtAttr+4 {
+			return int(binary.NativeEndian.Uint32(b[syscall.SizeofRtAttr:]))
+		}
+		b = b[min(rtaAlign(length), len(b)):]
+	}
+	return 0
+}
+
+// sameRoute reports whether two routes are the same, comparing the paths
+// of multipath routes.
+func sameRoute(a, b *ip.Route) bool {
+	if a.NextHops == nil || b.NextHops == nil {
+		return *a == *b
+	}
+	a2, b2 := *a, *b
+	a2.NextHops, b2.NextHops = nil, nil
+	return a2 == b2 && slices.Equal(a.NextHops.NextHops(), b.NextHops.NextHops())
+}
+
+// rtaAlign rounds an attribute length up to the 4-byte alignment of
+// netlink attributes.
+func rtaAlign(length int) int {
+	return (length + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+}
+
+// copyIPv4 copies a 4-byte address attribute. It reports whether the value
+// was an IPv4 address.
+func copyIPv4(dst *common.IPv4Address, value []byte) bool {
+	if len(value) != len(dst) {
+		return false
+	}
+	copy(dst[:], value)
+	return true
+}
+
+// cString returns a NUL-terminated string attribute.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
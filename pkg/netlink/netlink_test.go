@@ -0,0 +1,298 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+var (
+	testLinks = map[int]string{1: "lo", 2: "eth0", 3: "eth1"}
+	anyAddr   = common.IPv4Address{0, 0, 0, 0}
+)
+
+// attr encodes a route attribute, padded to its alignment.
+func attr(typ uint16, value []byte) []byte {
+	b := make([]byte, rtaAlign(syscall.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(b[0:2], uint16(syscall.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(b[2:4], typ)
+	copy(b[syscall.SizeofRtAttr:], value)
+	return b
+}
+
+// u32 encodes a 32-bit attribute value.
+func u32(v int) []byte {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, uint32(v))
+	return b
+}
+
+// message returns a netlink message with a fixed header and attributes.
+func message(typ, flags uint16, header []byte, attrs ...[]byte) *syscall.NetlinkMessage {
+	data := append([]byte(nil), header...)
+	for _, a := range attrs {
+		data = append(data, a...)
+	}
+	return &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Len: uint32(syscall.SizeofNlMsghdr + len(data)), Type: typ, Flags: flags},
+		Data:   data,
+	}
+}
+
+// linkMessage returns an RTM_NEWLINK or RTM_DELLINK message.
+func linkMessage(typ uint16, index int, name string, up bool) *syscall.NetlinkMessage {
+	header := make([]byte, syscall.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(header[4:8], uint32(index))
+	if up {
+		binary.NativeEndian.PutUint32(header[8:12], syscall.IFF_UP|syscall.IFF_RUNNING)
+	}
+	return message(typ, 0, header,
+		attr(syscall.IFLA_IFNAME, append([]byte(name), 0)),
+		attr(syscall.IFLA_MTU, u32(1500)),
+		attr(syscall.IFLA_ADDRESS, []byte{0x02, 0, 0, 0, 0, byte(index)}))
+}
+
+// addressMessage returns an RTM_NEWADDR or RTM_DELADDR message.
+func addressMessage(typ uint16, index int, addr common.IPv4Address, prefixLength int) *syscall.NetlinkMessage {
+	header := make([]byte, syscall.SizeofIfAddrmsg)
+	header[0] = syscall.AF_INET
+	header[1] = byte(prefixLength)
+	binary.NativeEndian.PutUint32(header[4:8], uint32(index))
+	return message(typ, 0, header, attr(syscall.IFA_ADDRESS, addr[:]), attr(syscall.IFA_LOCAL, addr[:]))
+}
+
+// routeMessage returns an RTM_NEWROUTE or RTM_DELROUTE message for a main
+// table unicast route.
+func routeMessage(typ, flags uint16, dst common.IPv4Address, prefixLength int, gateway common.IPv4Address, oif, metric int) *syscall.NetlinkMessage {
+	header := make([]byte, syscall.SizeofRtMsg)
+	header[0] = syscall.AF_INET
+	header[1] = byte(prefixLength)
+	header[4] = syscall.RT_TABLE_MAIN
+	header[7] = syscall.RTN_UNICAST
+	attrs := [][]byte{attr(syscall.RTA_TABLE, u32(syscall.RT_TABLE_MAIN)), attr(syscall.RTA_OIF, u32(oif)), attr(syscall.RTA_PRIORITY, u32(metric))}
+	if prefixLength > 0 {
+		attrs = append(attrs, attr(syscall.RTA_DST, dst[:]))
+	}
+	if gateway != anyAddr {
+		attrs = append(attrs, attr(syscall.RTA_GATEWAY, gateway[:]))
+	}
+	return message(typ, flags, header, attrs...)
+}
+
+// nextHop encodes a struct rtnexthop with a gateway.
+func nextHop(index, weight int, gateway common.IPv4Address) []byte {
+	hop := make([]byte, syscall.SizeofRtNexthop)
+	hop = append(hop, attr(syscall.RTA_GATEWAY, gateway[:])...)
+	binary.NativeEndian.PutUint16(hop[0:2], uint16(len(hop)))
+	hop[3] = byte(weight - 1)
+	binary.NativeEndian.PutUint32(hop[4:8], uint32(index))
+	return hop
+}
+
+// multipathMessage returns an RTM_NEWROUTE message for a multipath route.
+func multipathMessage(dst common.IPv4Address, prefixLength int, hops ...[]byte) *syscall.NetlinkMessage {
+	msg := routeMessage(syscall.RTM_NEWROUTE, 0, dst, prefixLength, anyAddr, 0, 0)
+	var value []byte
+	for _, hop := range hops {
+		value = append(value, hop...)
+	}
+	msg.Data = append(msg.Data, attr(syscall.RTA_MULTIPATH, value)...)
+	return msg
+}
+
+func TestParseLink(t *testing.T) {
+	link, ok, err := parseLink(linkMessage(syscall.RTM_NEWLINK, 2, "eth0", true))
+	if err != nil || !ok {
+		t.Fatalf("parseLink() = %v, %v, want a link", ok, err)
+	}
+	want := Link{Index: 2, Name: "eth0", MTU: 1500, HardwareAddr: common.MACAddress{0x02, 0, 0, 0, 0, 2}, Up: true}
+	if *link != want {
+		t.Errorf("parseLink() = %+v, want %+v", *link, want)
+	}
+
+	if link, _, _ := parseLink(linkMessage(syscall.RTM_NEWLINK, 2, "eth0", false)); link.Up {
+		t.Error("parseLink() of a down link: Up = true, want false")
+	}
+	if _, _, err := parseLink(message(syscall.RTM_NEWLINK, 0, make([]byte, 4))); err == nil {
+		t.Error("parseLink() of a short message error = nil, want an error")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	addr, ok, err := parseAddress(addressMessage(syscall.RTM_NEWADDR, 2, common.IPv4Address{192, 168, 1, 10}, 24), testLinks)
+	if err != nil || !ok {
+		t.Fatalf("parseAddress() = %v, %v, want an address", ok, err)
+	}
+	want := Address{Index: 2, Interface: "eth0", Address: common.IPv4Address{192, 168, 1, 10}, PrefixLength: 24}
+	if *addr != want {
+		t.Errorf("parseAddress() = %+v, want %+v", *addr, want)
+	}
+	if got, want := addr.Netmask(), (common.IPv4Address{255, 255, 255, 0}); got != want {
+		t.Errorf("Netmask() = %s, want %s", got, want)
+	}
+
+	ipv6 := addressMessage(syscall.RTM_NEWADDR, 2, common.IPv4Address{}, 64)
+	ipv6.Data[0] = syscall.AF_INET6
+	if _, ok, err := parseAddress(ipv6, testLinks); ok || err != nil {
+		t.Errorf("parseAddress() of an IPv6 address = %v, %v, want false, nil", ok, err)
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	gateway := common.IPv4Address{192, 168, 1, 1}
+	network := common.IPv4Address{10, 1, 0, 0}
+
+	local := routeMessage(syscall.RTM_NEWROUTE, 0, common.IPv4Address{127, 0, 0, 1}, 32, anyAddr, 1, 0)
+	local.Data[4] = syscall.RT_TABLE_LOCAL
+	copy(local.Data[syscall.SizeofRtMsg+syscall.SizeofRtAttr:], u32(syscall.RT_TABLE_LOCAL))
+	broadcast := routeMessage(syscall.RTM_NEWROUTE, 0, network, 16, anyAddr, 2, 0)
+	broadcast.Data[7] = syscall.RTN_BROADCAST
+	jumbo := routeMessage(syscall.RTM_NEWROUTE, 0, network, 16, gateway, 2, 0)
+	jumbo.Data = append(jumbo.Data, attr(syscall.RTA_METRICS, attr(rtaxMTU, u32(9000)))...)
+
+	// A multipath route over eth1 and, with three times the weight, eth0
+	other := common.IPv4Address{192, 168, 2, 1}
+	multipath := multipathMessage(network, 16, nextHop(3, 1, gateway), nextHop(2, 3, other))
+	wantMultipath, err := ip.NewMultipathRoute(network, common.IPv4Address{255, 255, 0, 0}, 0,
+		ip.NextHop{Gateway: gateway, Interface: "eth1", Weight: 1},
+		ip.NextHop{Gateway: other, Interface: "eth0", Weight: 3})
+	if err != nil {
+		t.Fatalf("NewMultipathRoute() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		msg    *syscall.NetlinkMessage
+		want   *ip.Route
+		wantOK bool
+	}{
+		{"default route", routeMessage(syscall.RTM_NEWROUTE, 0, anyAddr, 0, gateway, 2, 100), &ip.Route{Destination: anyAddr, Netmask: anyAddr, Gateway: gateway, Interface: "eth0", Metric: 100}, true},
+		{"connected route", routeMessage(syscall.RTM_DELROUTE, 0, common.IPv4Address{192, 168, 1, 0}, 24, anyAddr, 2, 0), &ip.Route{Destination: common.IPv4Address{192, 168, 1, 0}, Netmask: common.IPv4Address{255, 255, 255, 0}, Interface: "eth0"}, true},
+		{"multipath route", multipath, wantMultipath, true},
+		{"route with an MTU", jumbo, &ip.Route{Destination: network, Netmask: common.IPv4Address{255, 255, 0, 0}, Gateway: gateway, Interface: "eth0", MTU: 9000}, true},
+		{"local table", local, nil, false},
+		{"broadcast route", broadcast, nil, false},
+		{"link message", linkMessage(syscall.RTM_NEWLINK, 2, "eth0", true), nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, ok, err := parseRoute(tt.msg, testLinks)
+			if err != nil {
+				t.Fatalf("parseRoute() error = %v", err)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("parseRoute() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && !sameRoute(route, tt.want) {
+				t.Errorf("parseRoute() = %+v, want %+v", *route, *tt.want)
+			}
+		})
+	}
+
+	bad := routeMessage(syscall.RTM_NEWROUTE, 0, anyAddr, 0, anyAddr, 2, 0)
+	bad.Data[1] = 33
+	if _, _, err := parseRoute(bad, testLinks); err == nil {
+		t.Error("parseRoute() with a /33 prefix error = nil, want an error")
+	}
+}
+
+func TestSnapshotImport(t *testing.T) {
+	lan := common.IPv4Address{192, 168, 1, 0}
+	mask := common.IPv4Address{255, 255, 255, 0}
+	wired := &ip.Route{Destination: anyAddr, Netmask: anyAddr, Gateway: common.IPv4Address{192, 168, 1, 1}, Interface: "eth0", Metric: 100}
+	wireless := &ip.Route{Destination: anyAddr, Netmask: anyAddr, Gateway: common.IPv4Address{192, 168, 2, 1}, Interface: "wlan0", Metric: 600}
+	down := &ip.Route{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: mask, Interface: "eth1"}
+
+	s := &Snapshot{
+		Links: []Link{{Index: 2, Name: "eth0", MTU: 9000, Up: true}, {Index: 3, Name: "eth1"}, {Index: 4, Name: "wlan0", MTU: 1500, Up: true}},
+		Addresses: []Address{
+			{Index: 2, Interface: "eth0", Address: common.IPv4Address{192, 168, 1, 10}, PrefixLength: 24},
+			{Index: 3, Interface: "eth1", Address: common.IPv4Address{10, 0, 0, 10}, PrefixLength: 24},
+		},
+		Routes: []*ip.Route{
+			wireless,
+			{Destination: lan, Netmask: mask, Interface: "eth0"},
+			wired,
+			down,
+		},
+	}
+
+	rt := ip.NewRoutingTable()
+	mine := &ip.Route{Destination: common.IPv4Address{172, 16, 0, 0}, Netmask: mask, Interface: "tap0"}
+	rt.AddRoute(mine)
+	if err := s.Import(rt); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	if got := rt.GetDefaultGateway(); got != wired {
+		t.Errorf("GetDefaultGateway() = %+v, want the lowest metric route %+v", got, wired)
+	}
+	if routes := rt.GetRoutes(); len(routes) != 3 {
+		t.Errorf("Expected 3 routes, got %d", len(routes))
+	}
+	for _, route := range rt.GetRoutes() {
+		if route == down {
+			t.Error("imported a route of a link that is down")
+		}
+	}
+	if _, _, err := rt.Lookup(common.IPv4Address{172, 16, 0, 1}); err != nil {
+		t.Errorf("Lookup() of the table's own route error = %v", err)
+	}
+	if !rt.IsLocalAddress(common.IPv4Address{192, 168, 1, 10}) {
+		t.Error("IsLocalAddress() = false for an address of an up link, want true")
+	}
+	if rt.IsLocalAddress(common.IPv4Address{10, 0, 0, 10}) {
+		t.Error("IsLocalAddress() = true for an address of a down link, want false")
+	}
+	if mtu := rt.InterfaceMTU("eth0"); mtu != 9000 {
+		t.Errorf("InterfaceMTU() = %d, want the link's 9000", mtu)
+	}
+}
+
+func TestRead(t *testing.T) {
+	s, err := Read()
+	if err != nil {
+		t.Skipf("netlink not available: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, link := range s.Links {
+		if link.Name == "" || link.Index <= 0 {
+			t.Errorf("link %+v has no name or index", link)
+		}
+		names[link.Name] = true
+	}
+	for _, addr := range s.Addresses {
+		if !names[addr.Interface] {
+			t.Errorf("address %+v is on an unknown link", addr)
+		}
+	}
+	for _, route := range s.Routes {
+		if route.Interface != "" && !names[route.Interface] {
+			t.Errorf("route %+v is on an unknown link", *route)
+		}
+	}
+}
+
+func TestAddressNetmask(t *testing.T) {
+	tests := []struct {
+		length int
+		want   common.IPv4Address
+	}{
+		{0, common.IPv4Address{0, 0, 0, 0}},
+		{8, common.IPv4Address{255, 0, 0, 0}},
+		{25, common.IPv4Address{255, 255, 255, 128}},
+		{32, common.IPv4Address{255, 255, 255, 255}},
+	}
+
+	for _, tt := range tests {
+		a := &Address{PrefixLength: tt.length}
+		if got := a.Netmask(); got != tt.want {
+			t.Errorf("Netmask() of a /%d = %s, want %s", tt.length, got, tt.want)
+		}
+	}
+}
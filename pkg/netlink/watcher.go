@@ -0,0 +1,448 @@
+package netlink
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/linkstate"
+)
+
+// watchGroups are the multicast groups a watcher joins: link changes and
+// IPv4 address and route changes.
+const watchGroups = 1<<(syscall.RTNLGRP_LINK-1) |
+	1<<(syscall.RTNLGRP_IPV4_IFADDR-1) |
+	1<<(syscall.RTNLGRP_IPV4_ROUTE-1)
+
+// receiveBufferSize is large enough for any rtnetlink notification.
+const receiveBufferSize = 1 << 16
+
+// WatcherConfig holds configuration for a Watcher.
+type WatcherConfig struct {
+	// OnError is called, from the watcher's goroutine, with errors reading
+	// or applying the kernel's notifications. The watcher carries on.
+	OnError func(err error)
+
+	// OnLinkEvent is called with the interfaces going up or down and
+	// gaining or losing addresses, as linkstate.Monitor.Handle takes them:
+	// from the watcher's goroutine, and from Watch with the interfaces and
+	// addresses the kernel already has. After notifications were lost it is
+	// called with what changed meanwhile.
+	OnLinkEvent func(linkstate.Event)
+}
+
+// Watcher keeps a routing table in sync with the kernel's configuration.
+//
+// Of the kernel's routes to a network the table holds the one with the
+// lowest metric whose interface is up, so when an interface goes down its
+// routes are withdrawn, and the next best route to each network, if any,
+// takes over. The table's other routes are left alone.
+type Watcher struct {
+	rt     *ip.RoutingTable
+	config WatcherConfig
+	file   *os.File
+	done   chan struct{}
+
+	mu        sync.Mutex
+	links     map[int]Link
+	addresses map[int]Address        // The address each link was last given
+	kernel    map[prefix][]*ip.Route // The kernel's routes to each network
+	installed map[prefix]*ip.Route   // The route the table was given for each network
+	events    []linkstate.Event      // Link events to report once mu is released
+}
+
+// Watch imports the kernel's configuration into a routing table, as Import
+// does, and keeps the table in sync until the watcher is closed.
+func Watch(rt *ip.RoutingTable) (*Watcher, error) {
+	return WatchWithConfig(rt, WatcherConfig{})
+}
+
+// WatchWithConfig is Watch with custom configuration.
+func WatchWithConfig(rt *ip.RoutingTable, config WatcherConfig) (*Watcher, error) {
+	// Join the groups before the dump, so no change is missed between them
+	file, err := subscribe()
+	if err != nil {
+		return nil, err
+	}
+
+	w := newWatcher(rt, config)
+	if err := w.resync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w.file = file
+	go w.run()
+	return w, nil
+}
+
+// newWatcher creates a watcher that has not started.
+func newWatcher(rt *ip.RoutingTable, config WatcherConfig) *Watcher {
+	return &Watcher{
+		rt:        rt,
+		config:    config,
+		done:      make(chan struct{}),
+		links:     make(map[int]Link),
+		addresses: make(map[int]Address),
+		kernel:    make(map[prefix][]*ip.Route),
+		installed: make(map[prefix]*ip.Route),
+	}
+}
+
+// Links returns the kernel's interfaces, as last announced.
+func (w *Watcher) Links() []Link {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	links := make([]Link, 0, len(w.links))
+	for _, link := range w.links {
+		links = append(links, link)
+	}
+	return links
+}
+
+// Close stops the watcher. The routing table keeps its routes.
+func (w *Watcher) Close() error {
+	err := w.file.Close()
+	<-w.done
+	return err
+}
+
+// subscribe opens a netlink socket that receives the watched notifications.
+func subscribe() (*os.File, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create netlink socket: %w", err)
+	}
+
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: watchGroups}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to join netlink groups: %w", err)
+	}
+
+	// Non-blocking mode lets the Go runtime poller drive reads, so Close
+	// unblocks a pending read
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to set non-blocking mode: %w", err)
+	}
+	return os.NewFile(uintptr(fd), "netlink"), nil
+}
+
+// run reads and applies notifications until the watcher is closed.
+func (w *Watcher) run() {
+	defer close(w.done)
+
+	buf := make([]byte, receiveBufferSize)
+	for {
+		n, err := w.file.Read(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if errors.Is(err, syscall.ENOBUFS) {
+			// The socket overflowed and notifications were lost, so
+			// start again from a fresh dump
+			if err := w.resync(); err != nil {
+				w.report(err)
+			}
+			continue
+		}
+		if err != nil {
+			w.report(fmt.Errorf("failed to read netlink notification: %w", err))
+			return
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			w.report(fmt.Errorf("failed to parse netlink notification: %w", err))
+			continue
+		}
+		for i := range msgs {
+			if err := w.handle(&msgs[i]); err != nil {
+				w.report(err)
+			}
+		}
+	}
+}
+
+// report passes an error to the OnError callback, if any.
+func (w *Watcher) report(err error) {
+	if w.config.OnError != nil {
+		w.config.OnError(err)
+	}
+}
+
+// notify reports link events to the OnLinkEvent callback, if any.
+func (w *Watcher) notify(events []linkstate.Event) {
+	if w.config.OnLinkEvent == nil {
+		return
+	}
+	for _, ev := range events {
+		w.config.OnLinkEvent(ev)
+	}
+}
+
+// takeEvents returns the link events to report, and forgets them. Called
+// with w.mu held.
+func (w *Watcher) takeEvents() []linkstate.Event {
+	events := w.events
+	w.events = nil
+	return events
+}
+
+// linkEvent records a link going up or down, to be reported.
+func (w *Watcher) linkEvent(link Link) {
+	typ := linkstate.LinkDown
+	if link.Up {
+		typ = linkstate.LinkUp
+	}
+	w.events = append(w.events, linkstate.Event{Type: typ, Interface: link.Name})
+}
+
+// addressEvent records an address added or removed, to be reported.
+func (w *Watcher) addressEvent(typ linkstate.EventType, addr Address) {
+	w.events = append(w.events, linkstate.Event{Type: typ, Interface: addr.Interface, Address: addr.Address, PrefixLength: addr.PrefixLength})
+}
+
+// resync replaces the watcher's view of the kernel with a fresh dump and
+// brings the routing table in line with it.
+func (w *Watcher) resync() error {
+	s, err := Read()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	err = w.load(s)
+	events := w.takeEvents()
+	w.mu.Unlock()
+	w.notify(events)
+	return err
+}
+
+// load replaces the watcher's view of the kernel with a snapshot, and
+// records the link events between the two. Called with w.mu held.
+func (w *Watcher) load(s *Snapshot) error {
+	oldLinks, oldAddresses := w.links, w.addresses
+	w.links = make(map[int]Link)
+	w.addresses = make(map[int]Address)
+	for _, link := range s.Links {
+		w.links[link.Index] = link
+		w.rt.SetInterfaceMTU(link.Name, link.MTU)
+	}
+	for _, addr := range s.Addresses {
+		w.updateAddress(syscall.RTM_NEWADDR, &addr)
+	}
+
+	// Report what changed since the last view, which the address updates
+	// do not know
+	w.events = nil
+	for _, index := range slices.Sorted(maps.Keys(oldLinks)) {
+		if old := oldLinks[index]; old.Up {
+			if _, exists := w.links[index]; !exists {
+				old.Up = false
+				w.linkEvent(old)
+			}
+		}
+	}
+	for _, index := range slices.Sorted(maps.Keys(w.links)) {
+		link := w.links[index]
+		if old, exists := oldLinks[index]; !exists || old.Up != link.Up {
+			w.linkEvent(link)
+		}
+	}
+	for _, index := range slices.Sorted(maps.Keys(oldAddresses)) {
+		if old := oldAddresses[index]; w.addresses[index] != old {
+			w.addressEvent(linkstate.AddressRemoved, old)
+		}
+	}
+	for _, index := range slices.Sorted(maps.Keys(w.addresses)) {
+		if addr := w.addresses[index]; oldAddresses[index] != addr {
+			w.addressEvent(linkstate.AddressAdded, addr)
+		}
+	}
+
+	w.kernel = make(map[prefix][]*ip.Route)
+	for _, route := range s.Routes {
+		key := routePrefix(route)
+		w.kernel[key] = append(w.kernel[key], route)
+	}
+	return w.syncAll()
+}
+
+// handle applies one notification.
+func (w *Watcher) handle(msg *syscall.NetlinkMessage) error {
+	w.mu.Lock()
+	err := w.apply(msg)
+	events := w.takeEvents()
+	w.mu.Unlock()
+	w.notify(events)
+	return err
+}
+
+// apply applies one notification. Called with w.mu held.
+func (w *Watcher) apply(msg *syscall.NetlinkMessage) error {
+	switch msg.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		link, _, err := parseLink(msg)
+		if err != nil {
+			return err
+		}
+		return w.updateLink(msg.Header.Type, link)
+
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		addr, ok, err := parseAddress(msg, w.linkNames())
+		if err != nil || !ok {
+			return err
+		}
+		w.updateAddress(msg.Header.Type, addr)
+		return nil
+
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		route, ok, err := parseRoute(msg, w.linkNames())
+		if err != nil || !ok {
+			return err
+		}
+		w.updateRoute(msg, route)
+		return w.sync(routePrefix(route))
+	}
+	return nil
+}
+
+// updateLink records a link change. A link going up or down changes which
+// routes the table should have, and its address comes and goes with it.
+func (w *Watcher) updateLink(typ uint16, link *Link) error {
+	old, exists := w.links[link.Index]
+	if typ == syscall.RTM_DELLINK {
+		if addr, ok := w.addresses[link.Index]; ok {
+			w.addressEvent(linkstate.AddressRemoved, addr)
+		}
+		delete(w.links, link.Index)
+		delete(w.addresses, link.Index)
+		link.Up = false
+		w.rt.SetInterfaceMTU(link.Name, 0)
+	} else {
+		w.links[link.Index] = *link
+		w.rt.SetInterfaceMTU(link.Name, link.MTU)
+	}
+	if !exists && typ != syscall.RTM_DELLINK || exists && old.Up != link.Up {
+		w.linkEvent(*link)
+	}
+	if exists && old.Up == link.Up && old.Name == link.Name {
+		return nil
+	}
+
+	if exists && old.Up {
+		w.rt.RemoveLocalInterface(old.Name)
+	}
+	if addr, ok := w.addresses[link.Index]; ok && link.Up {
+		w.rt.AddLocalInterface(link.Name, addr.Address)
+	}
+	return w.syncAll()
+}
+
+// updateAddress records an address change. Addresses of links that are down
+// are not local.
+func (w *Watcher) updateAddress(typ uint16, addr *Address) {
+	current, exists := w.addresses[addr.Index]
+	if typ == syscall.RTM_DELADDR {
+		if exists && current.Address == addr.Address {
+			delete(w.addresses, addr.Index)
+			w.rt.RemoveLocalInterface(addr.Interface)
+			w.addressEvent(linkstate.AddressRemoved, current)
+		}
+		return
+	}
+	if exists && current != *addr {
+		w.addressEvent(linkstate.AddressRemoved, current)
+	}
+	if !exists || current != *addr {
+		w.addressEvent(linkstate.AddressAdded, *addr)
+	}
+	w.addresses[addr.Index] = *addr
+	if link, exists := w.links[addr.Index]; exists && link.Up {
+		w.rt.AddLocalInterface(addr.Interface, addr.Address)
+	}
+}
+
+// updateRoute records a route change in the watcher's view of the
+// kernel's routes.
+func (w *Watcher) updateRoute(msg *syscall.NetlinkMessage, route *ip.Route) {
+	key := routePrefix(route)
+	routes := w.kernel[key]
+
+	// A deletion removes the matching route, and an addition any it
+	// repeats or, for "ip route replace", has the same metric as
+	add := msg.Header.Type == syscall.RTM_NEWROUTE
+	replace := add && msg.Header.Flags&syscall.NLM_F_REPLACE != 0
+	kept := routes[:0:0]
+	for _, r := range routes {
+		if !sameRoute(r, route) && !(replace && r.Metric == route.Metric) {
+			kept = append(kept, r)
+		}
+	}
+	if add {
+		kept = append(kept, route)
+	}
+
+	if len(kept) == 0 {
+		delete(w.kernel, key)
+	} else {
+		w.kernel[key] = kept
+	}
+}
+
+// syncAll brings the table's routes to every network in line with the
+// kernel's.
+func (w *Watcher) syncAll() error {
+	var errs []error
+	for key := range w.installed {
+		if _, exists := w.kernel[key]; !exists {
+			errs = append(errs, w.sync(key))
+		}
+	}
+	for key := range w.kernel {
+		errs = append(errs, w.sync(key))
+	}
+	return errors.Join(errs...)
+}
+
+// sync brings the table's route to a network in line with the kernel's.
+func (w *Watcher) sync(key prefix) error {
+	up := make(map[string]bool)
+	for _, link := range w.links {
+		up[link.Name] = link.Up
+	}
+	best := bestRoute(w.kernel[key], up)
+	old := w.installed[key]
+
+	switch {
+	case best == old, best != nil && old != nil && sameRoute(best, old):
+		return nil
+	case best == nil:
+		delete(w.installed, key)
+		w.rt.RemoveRoute(key.destination, key.netmask)
+		return nil
+	}
+
+	w.installed[key] = best
+	if _, err := w.rt.ReplaceRoute(best); err != nil {
+		return fmt.Errorf("failed to install route to %s/%s: %w", key.destination, key.netmask, err)
+	}
+	return nil
+}
+
+// linkNames returns the names of the kernel's interfaces by index.
+func (w *Watcher) linkNames() map[int]string {
+	names := make(map[int]string, len(w.links))
+	for index, link := range w.links {
+		names[index] = link.Name
+	}
+	return names
+}
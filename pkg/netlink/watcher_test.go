@@ -0,0 +1,246 @@
+package netlink
+
+import (
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/linkstate"
+)
+
+// handleAll has a watcher handle notifications in order.
+func handleAll(t *testing.T, w *Watcher, msgs ...*syscall.NetlinkMessage) {
+	t.Helper()
+	for _, msg := range msgs {
+		if err := w.handle(msg); err != nil {
+			t.Fatalf("handle() error = %v", err)
+		}
+	}
+}
+
+// defaultGateway returns the gateway of the table's default route, or the
+// zero address.
+func defaultGateway(rt *ip.RoutingTable) common.IPv4Address {
+	if route := rt.GetDefaultGateway(); route != nil {
+		return route.Gateway
+	}
+	return common.IPv4Address{}
+}
+
+func TestWatcherRoutes(t *testing.T) {
+	rt := ip.NewRoutingTable()
+	w := newWatcher(rt, WatcherConfig{})
+	var events []ip.RouteEvent
+	rt.Subscribe(func(event ip.RouteEvent) { events = append(events, event) })
+
+	wired := common.IPv4Address{192, 168, 1, 1}
+	wireless := common.IPv4Address{192, 168, 2, 1}
+	handleAll(t, w,
+		linkMessage(syscall.RTM_NEWLINK, 2, "eth0", true),
+		linkMessage(syscall.RTM_NEWLINK, 4, "wlan0", true),
+		routeMessage(syscall.RTM_NEWROUTE, 0, anyAddr, 0, wireless, 4, 600),
+		routeMessage(syscall.RTM_NEWROUTE, 0, anyAddr, 0, wired, 2, 100),
+	)
+	if got := defaultGateway(rt); got != wired {
+		t.Errorf("default gateway = %s, want the lower metric %s", got, wired)
+	}
+
+	// The wired link losing its carrier hands over to the wireless route,
+	// and getting it back hands back
+	handleAll(t, w, linkMessage(syscall.RTM_NEWLINK, 2, "eth0", false))
+	if got := defaultGateway(rt); got != wireless {
+		t.Errorf("default gateway with eth0 down = %s, want %s", got, wireless)
+	}
+	handleAll(t, w, linkMessage(syscall.RTM_NEWLINK, 2, "eth0", true))
+	if got := defaultGateway(rt); got != wired {
+		t.Errorf("default gateway with eth0 up = %s, want %s", got, wired)
+	}
+
+	// "ip route replace" of the wired route changes its gateway
+	replaced := common.IPv4Address{192, 168, 1, 254}
+	handleAll(t, w, routeMessage(syscall.RTM_NEWROUTE, syscall.NLM_F_REPLACE, anyAddr, 0, replaced, 2, 100))
+	if got := defaultGateway(rt); got != replaced {
+		t.Errorf("default gateway after replace = %s, want %s", got, replaced)
+	}
+
+	handleAll(t, w,
+		routeMessage(syscall.RTM_DELROUTE, 0, anyAddr, 0, replaced, 2, 100),
+		routeMessage(syscall.RTM_DELROUTE, 0, anyAddr, 0, wireless, 4, 600),
+	)
+	if route := rt.GetDefaultGateway(); route != nil {
+		t.Errorf("GetDefaultGateway() = %+v after the kernel's routes were deleted, want nil", route)
+	}
+	if routes := rt.GetRoutes(); len(routes) != 0 {
+		t.Errorf("Expected 0 routes, got %d", len(routes))
+	}
+
+	// Listeners saw every change to the table, and no others
+	wantOps := []ip.RouteOp{
+		ip.RouteReplace, ip.RouteReplace, // wlan0, then eth0
+		ip.RouteReplace, ip.RouteReplace, // eth0 down, then up
+		ip.RouteReplace,                 // ip route replace
+		ip.RouteReplace, ip.RouteRemove, // eth0 deleted, then wlan0
+	}
+	if len(events) != len(wantOps) {
+		t.Fatalf("got %d route events, want %d", len(events), len(wantOps))
+	}
+	for i, op := range wantOps {
+		if events[i].Op != op {
+			t.Errorf("event %d = %s, want %s", i, events[i].Op, op)
+		}
+	}
+}
+
+func TestWatcherMultipath(t *testing.T) {
+	rt := ip.NewRoutingTable()
+	w := newWatcher(rt, WatcherConfig{})
+
+	handleAll(t, w,
+		linkMessage(syscall.RTM_NEWLINK, 2, "eth0", true),
+		linkMessage(syscall.RTM_NEWLINK, 3, "eth1", true),
+		multipathMessage(anyAddr, 0, nextHop(2, 1, common.IPv4Address{192, 168, 1, 1}), nextHop(3, 1, common.IPv4Address{192, 168, 2, 1})),
+	)
+	route := rt.GetDefaultGateway()
+	if route == nil || route.NextHops == nil {
+		t.Fatalf("GetDefaultGateway() = %+v, want a multipath route", route)
+	}
+
+	// Paths over a link that is down are not used, but the route stays as
+	// long as one path is up
+	handleAll(t, w, linkMessage(syscall.RTM_NEWLINK, 2, "eth0", false))
+	if route.NextHops.Healthy(0) || !route.NextHops.Healthy(1) {
+		t.Errorf("Healthy() = %v, %v with eth0 down, want false, true", route.NextHops.Healthy(0), route.NextHops.Healthy(1))
+	}
+	for port := uint16(0); port < 100; port++ {
+		path, _, err := rt.LookupFlow(ip.FlowKey{Destination: common.IPv4Address{8, 8, 8, 8}, Protocol: common.ProtocolTCP, SourcePort: port})
+		if err != nil {
+			t.Fatalf("LookupFlow() error = %v", err)
+		}
+		if path.Interface != "eth1" {
+			t.Fatalf("LookupFlow() = %s with eth0 down, want eth1", path.Interface)
+		}
+	}
+
+	handleAll(t, w, linkMessage(syscall.RTM_NEWLINK, 3, "eth1", false))
+	if route := rt.GetDefaultGateway(); route != nil {
+		t.Errorf("GetDefaultGateway() = %+v with every path down, want nil", route)
+	}
+}
+
+func TestWatcherAddresses(t *testing.T) {
+	rt := ip.NewRoutingTable()
+	w := newWatcher(rt, WatcherConfig{})
+	addr := common.IPv4Address{192, 168, 1, 10}
+
+	handleAll(t, w,
+		linkMessage(syscall.RTM_NEWLINK, 2, "eth0", true),
+		addressMessage(syscall.RTM_NEWADDR, 2, addr, 24),
+	)
+	if !rt.IsLocalAddress(addr) {
+		t.Error("IsLocalAddress() = false after RTM_NEWADDR, want true")
+	}
+
+	handleAll(t, w, linkMessage(syscall.RTM_NEWLINK, 2, "eth0", false))
+	if rt.IsLocalAddress(addr) {
+		t.Error("IsLocalAddress() = true with the link down, want false")
+	}
+	handleAll(t, w, linkMessage(syscall.RTM_NEWLINK, 2, "eth0", true))
+	if !rt.IsLocalAddress(addr) {
+		t.Error("IsLocalAddress() = false with the link up again, want true")
+	}
+
+	// Removing another address leaves the interface's
+	handleAll(t, w, addressMessage(syscall.RTM_DELADDR, 2, common.IPv4Address{192, 168, 1, 11}, 24))
+	if !rt.IsLocalAddress(addr) {
+		t.Error("IsLocalAddress() = false after removing another address, want true")
+	}
+	handleAll(t, w, addressMessage(syscall.RTM_DELADDR, 2, addr, 24))
+	if rt.IsLocalAddress(addr) {
+		t.Error("IsLocalAddress() = true after RTM_DELADDR, want false")
+	}
+
+	if links := w.Links(); len(links) != 1 || links[0].Name != "eth0" || !links[0].Up {
+		t.Errorf("Links() = %+v, want eth0 up", links)
+	}
+	handleAll(t, w, linkMessage(syscall.RTM_DELLINK, 2, "eth0", false))
+	if links := w.Links(); len(links) != 0 {
+		t.Errorf("Links() after RTM_DELLINK = %+v, want none", links)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	rt := ip.NewRoutingTable()
+	w, err := Watch(rt)
+	if err != nil {
+		t.Skipf("netlink not available: %v", err)
+	}
+
+	s, err := Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	imported := ip.NewRoutingTable()
+	if err := s.Import(imported); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if got, want := len(rt.GetRoutes()), len(imported.GetRoutes()); got != want {
+		t.Errorf("Watch() imported %d routes, want %d", got, want)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestWatcherLinkEvents(t *testing.T) {
+	var events []linkstate.Event
+	w := newWatcher(ip.NewRoutingTable(), WatcherConfig{
+		OnLinkEvent: func(ev linkstate.Event) { events = append(events, ev) },
+	})
+	addr := common.IPv4Address{192, 168, 1, 10}
+	renumbered := common.IPv4Address{192, 168, 1, 20}
+
+	handleAll(t, w,
+		linkMessage(syscall.RTM_NEWLINK, 2, "eth0", true),
+		addressMessage(syscall.RTM_NEWADDR, 2, addr, 24),
+		addressMessage(syscall.RTM_NEWADDR, 2, addr, 24), // Repeated
+		linkMessage(syscall.RTM_NEWLINK, 2, "eth0", false),
+		linkMessage(syscall.RTM_NEWLINK, 2, "eth0", false), // Repeated
+		linkMessage(syscall.RTM_NEWLINK, 2, "eth0", true),
+		addressMessage(syscall.RTM_NEWADDR, 2, renumbered, 24),
+		linkMessage(syscall.RTM_DELLINK, 2, "eth0", false),
+	)
+	want := []linkstate.Event{
+		{Type: linkstate.LinkUp, Interface: "eth0"},
+		{Type: linkstate.AddressAdded, Interface: "eth0", Address: addr, PrefixLength: 24},
+		{Type: linkstate.LinkDown, Interface: "eth0"},
+		{Type: linkstate.LinkUp, Interface: "eth0"},
+		{Type: linkstate.AddressRemoved, Interface: "eth0", Address: addr, PrefixLength: 24},
+		{Type: linkstate.AddressAdded, Interface: "eth0", Address: renumbered, PrefixLength: 24},
+		{Type: linkstate.AddressRemoved, Interface: "eth0", Address: renumbered, PrefixLength: 24},
+		{Type: linkstate.LinkDown, Interface: "eth0"},
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("link events = %+v, want %+v", events, want)
+	}
+
+	// A fresh dump reports what changed since the last one
+	events = nil
+	w.mu.Lock()
+	w.load(&Snapshot{
+		Links:     []Link{{Index: 3, Name: "eth1", Up: true}},
+		Addresses: []Address{{Index: 3, Interface: "eth1", Address: addr, PrefixLength: 16}},
+	})
+	w.load(&Snapshot{Links: []Link{{Index: 3, Name: "eth1"}}})
+	got := w.takeEvents()
+	w.mu.Unlock()
+	want = []linkstate.Event{
+		{Type: linkstate.LinkDown, Interface: "eth1"},
+		{Type: linkstate.AddressRemoved, Interface: "eth1", Address: addr, PrefixLength: 16},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("link events after the second dump = %+v, want %+v", got, want)
+	}
+}
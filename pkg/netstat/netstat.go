@@ -0,0 +1,156 @@
+// Package netstat reports the sockets of the stack, like netstat(8).
+//
+// Collect takes a snapshot of the TCP connections and listeners and the UDP
+// bindings in this process. Handler serves it over pkg/http, as a table for
+// people or as JSON for cmd/netstat, which prints the sockets of a remote
+// application that mounts it:
+//
+//	server := &http.Server{Handler: netstat.Handler()}
+//	server.Serve(tcp.NewListener(socket))
+package netstat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/http"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// Entry is one socket in a snapshot. Addresses are "ip:port", or
+// "[ip]:port" for IPv6, with "*" for an unspecified port.
+type Entry struct {
+	Proto  string `json:"proto"` // "tcp" or "udp", or "tcp6" or "udp6" for IPv6
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	State  string `json:"state,omitempty"` // TCP state; empty for UDP
+	SendQ  int    `json:"send_q"`          // Bytes not yet acknowledged (TCP), or the backlog of a listener
+	RecvQ  int    `json:"recv_q"`          // Bytes (TCP) or datagrams (UDP) not yet read, or connections waiting for Accept
+}
+
+// Listening reports whether the entry is a listener or an unconnected UDP
+// socket.
+func (e *Entry) Listening() bool {
+	return strings.HasPrefix(e.Proto, "udp") || e.State == tcp.StateListen.String()
+}
+
+// Snapshot is the set of sockets at one point in time.
+type Snapshot struct {
+	Time    time.Time `json:"time"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Collect returns the current TCP connections and listeners followed by
+// the UDP bindings.
+func Collect() *Snapshot {
+	snap := &Snapshot{Time: time.Now()}
+	for _, c := range tcp.Connections() {
+		entry := Entry{
+			Proto:  "tcp",
+			Local:  endpoint(c.LocalAddr, c.LocalPort),
+			Remote: endpoint(c.RemoteAddr, c.RemotePort),
+			State:  c.State.String(),
+			SendQ:  c.SendQueue,
+			RecvQ:  c.RecvQueue,
+		}
+		if !c.LocalAddr6.IsUnspecified() || !c.RemoteAddr6.IsUnspecified() {
+			entry.Proto = "tcp6"
+			entry.Local = endpoint6(c.LocalAddr6, c.LocalPort)
+			entry.Remote = endpoint6(c.RemoteAddr6, c.RemotePort)
+		}
+		if c.State == tcp.StateListen {
+			entry.Remote = endpoint(common.IPv4Address{}, 0)
+			if entry.Proto == "tcp6" {
+				entry.Remote = endpoint6(common.IPv6Address{}, 0)
+			}
+		}
+		snap.Entries = append(snap.Entries, entry)
+	}
+	for _, b := range udp.Bindings() {
+		entry := Entry{
+			Proto:  "udp",
+			Local:  endpoint(b.LocalAddr.IP, b.LocalAddr.Port),
+			Remote: endpoint(common.IPv4Address{}, 0),
+			RecvQ:  b.RecvQueue,
+		}
+		if b.LocalAddr.IsIPv6() {
+			entry.Proto = "udp6"
+			entry.Local = endpoint6(b.LocalAddr.IPv6, b.LocalAddr.Port)
+			entry.Remote = endpoint6(common.IPv6Address{}, 0)
+		}
+		snap.Entries = append(snap.Entries, entry)
+	}
+	return snap
+}
+
+// Filter returns the entries for which keep returns true.
+func (s *Snapshot) Filter(keep func(*Entry) bool) *Snapshot {
+	filtered := &Snapshot{Time: s.Time}
+	for i := range s.Entries {
+		if keep(&s.Entries[i]) {
+			filtered.Entries = append(filtered.Entries, s.Entries[i])
+		}
+	}
+	return filtered
+}
+
+// WriteTable writes the entries as a netstat-style table.
+func (s *Snapshot) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Proto\tRecv-Q\tSend-Q\tLocal Address\tForeign Address\tState")
+	for _, e := range s.Entries {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\n", e.Proto, e.RecvQ, e.SendQ, e.Local, e.Remote, e.State)
+	}
+	return tw.Flush()
+}
+
+// Handler returns an HTTP handler that serves a fresh snapshot on each
+// request: as a table, or as JSON with "?format=json".
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		snap := Collect()
+		var body strings.Builder
+		switch format := r.Query().Get("format"); format {
+		case "", "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			snap.WriteTable(&body)
+		case "json":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(&body).Encode(snap)
+		default:
+			http.Error(w, "unknown format "+strconv.Quote(format), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+		io.WriteString(w, body.String())
+	})
+}
+
+// endpoint formats an address and port, writing "*" for port 0.
+func endpoint(addr common.IPv4Address, port uint16) string {
+	if port == 0 {
+		return addr.String() + ":*"
+	}
+	return addr.String() + ":" + strconv.Itoa(int(port))
+}
+
+// endpoint6 formats an IPv6 address and port, as "[addr]:port".
+func endpoint6(addr common.IPv6Address, port uint16) string {
+	if port == 0 {
+		return "[" + addr.String() + "]:*"
+	}
+	return "[" + addr.String() + "]:" + strconv.Itoa(int(port))
+}
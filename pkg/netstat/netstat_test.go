@@ -0,0 +1,147 @@
+package netstat
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/http"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var testIP = common.IPv4Address{192, 0, 2, 1}
+
+// openSockets opens a TCP listener and a UDP socket for the test.
+func openSockets(t *testing.T) {
+	t.Helper()
+	listener := tcp.NewSocket(testIP, 8080)
+	if err := listener.Listen(8); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	sock := udp.NewSocket()
+	if err := sock.Bind(udp.Address{IP: testIP, Port: 53}); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	t.Cleanup(func() { sock.Close() })
+	sock.Receive([]byte("query"), udp.Address{IP: common.IPv4Address{192, 0, 2, 9}, Port: 4000})
+}
+
+func TestCollect(t *testing.T) {
+	openSockets(t)
+	snap := Collect().Filter(func(e *Entry) bool {
+		return strings.HasPrefix(e.Local, "192.0.2.1:")
+	})
+
+	want := []Entry{
+		{Proto: "tcp", Local: "192.0.2.1:8080", Remote: "0.0.0.0:*", State: "LISTEN", SendQ: 8},
+		{Proto: "udp", Local: "192.0.2.1:53", Remote: "0.0.0.0:*", RecvQ: 1},
+	}
+	if len(snap.Entries) != len(want) {
+		t.Fatalf("entries = %+v, want %+v", snap.Entries, want)
+	}
+	for i := range want {
+		if snap.Entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, snap.Entries[i], want[i])
+		}
+		if !snap.Entries[i].Listening() {
+			t.Errorf("entry %d Listening() = false", i)
+		}
+	}
+
+	var b strings.Builder
+	if err := snap.WriteTable(&b); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "Proto  Recv-Q  Send-Q  Local Address") ||
+		strings.Join(strings.Fields(lines[1]), " ") != "tcp 0 8 192.0.2.1:8080 0.0.0.0:* LISTEN" {
+		t.Errorf("table =\n%s", b.String())
+	}
+}
+
+func TestCollectIPv6(t *testing.T) {
+	addr := common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 1}
+	listener := tcp.NewSocketIPv6(addr, 8080)
+	if err := listener.Listen(8); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	sock := udp.NewSocket()
+	if err := sock.Bind(udp.Address{IPv6: addr, Port: 53}); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	defer sock.Close()
+
+	snap := Collect().Filter(func(e *Entry) bool {
+		return strings.HasPrefix(e.Local, "[2001:db8::1]:")
+	})
+	want := []Entry{
+		{Proto: "tcp6", Local: "[2001:db8::1]:8080", Remote: "[::]:*", State: "LISTEN", SendQ: 8},
+		{Proto: "udp6", Local: "[2001:db8::1]:53", Remote: "[::]:*"},
+	}
+	if len(snap.Entries) != len(want) {
+		t.Fatalf("entries = %+v, want %+v", snap.Entries, want)
+	}
+	for i := range want {
+		if snap.Entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, snap.Entries[i], want[i])
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	openSockets(t)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantType   string
+	}{
+		{"", http.StatusOK, "text/plain; charset=utf-8"},
+		{"?format=json", http.StatusOK, "application/json"},
+		{"?format=xml", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go (&http.Server{Handler: Handler()}).ServeConn(server)
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+
+			req, _ := http.NewRequest("GET", "http://stack/netstat"+tt.query, nil)
+			io.WriteString(client, "GET /netstat"+tt.query+" HTTP/1.1\r\nHost: stack\r\n\r\n")
+			resp, err := http.ReadResponse(bufio.NewReader(client), req)
+			if err != nil {
+				t.Fatalf("ReadResponse() error = %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantType == "" {
+				return
+			}
+			if got := resp.Header.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if tt.wantType == "application/json" {
+				var snap Snapshot
+				if err := json.Unmarshal(body, &snap); err != nil {
+					t.Fatalf("Unmarshal() error = %v", err)
+				}
+				if len(snap.Filter(func(e *Entry) bool { return e.Local == "192.0.2.1:53" }).Entries) != 1 {
+					t.Errorf("snapshot has no UDP binding: %s", body)
+				}
+			}
+		})
+	}
+}
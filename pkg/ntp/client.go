@@ -0,0 +1,187 @@
+package ntp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSamples is the number of queries a measurement makes.
+	DefaultSamples = 4
+
+	// DefaultTimeout is how long a query waits for the server's reply.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultSampleInterval is the pause between a measurement's queries.
+	DefaultSampleInterval = 100 * time.Millisecond
+)
+
+// Errors returned by Client.
+var (
+	ErrNoResponse      = errors.New("no response from NTP server")
+	ErrKissOfDeath     = errors.New("NTP server sent kiss-o'-death")
+	ErrNotSynchronized = errors.New("NTP server clock is not synchronized")
+)
+
+// Config configures a Client.
+type Config struct {
+	Samples        int           // Queries per measurement; 0 means DefaultSamples
+	Timeout        time.Duration // Per query; 0 means DefaultTimeout
+	SampleInterval time.Duration // Between queries; 0 means DefaultSampleInterval
+}
+
+// Sample is the result of one query.
+type Sample struct {
+	Offset      time.Duration // How far the server's clock is ahead of ours
+	Delay       time.Duration // Round-trip delay, less the server's processing time
+	Stratum     uint8
+	Leap        LeapIndicator
+	ReferenceID [4]byte
+}
+
+// Result is the result of a measurement: the sample with the least delay,
+// and all the samples.
+type Result struct {
+	Sample
+	Samples []Sample
+}
+
+// Client is an SNTP client. It queries servers through a UDP connection,
+// usually a udp.PacketConn over the stack.
+type Client struct {
+	conn   net.PacketConn
+	config Config
+
+	mu  sync.Mutex       // One query at a time on conn
+	now func() time.Time // Clock, replaced in tests
+}
+
+// NewClient creates a client with the default configuration.
+func NewClient(conn net.PacketConn) *Client {
+	return NewClientWithConfig(conn, Config{})
+}
+
+// NewClientWithConfig creates a client with a custom configuration.
+func NewClientWithConfig(conn net.PacketConn, config Config) *Client {
+	if config.Samples <= 0 {
+		config.Samples = DefaultSamples
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.SampleInterval <= 0 {
+		config.SampleInterval = DefaultSampleInterval
+	}
+	return &Client{conn: conn, config: config, now: time.Now}
+}
+
+// GetTime returns the current time by a server's clock.
+func (c *Client) GetTime(server net.Addr) (time.Time, error) {
+	result, err := c.Measure(server)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return c.now().Add(result.Offset), nil
+}
+
+// Measure queries a server Samples times and returns the sample with the
+// least delay. Queries that go unanswered are skipped; a kiss-o'-death
+// ends the measurement, as RFC 4330 requires.
+func (c *Client) Measure(server net.Addr) (*Result, error) {
+	result := &Result{}
+	var lastErr error
+	for i := 0; i < c.config.Samples; i++ {
+		if i > 0 {
+			time.Sleep(c.config.SampleInterval)
+		}
+
+		sample, err := c.Query(server)
+		if errors.Is(err, ErrKissOfDeath) {
+			return nil, err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(result.Samples) == 0 || sample.Delay < result.Delay {
+			result.Sample = *sample
+		}
+		result.Samples = append(result.Samples, *sample)
+	}
+
+	if len(result.Samples) == 0 {
+		return nil, lastErr
+	}
+	return result, nil
+}
+
+// Query sends one request to a server and returns the clock offset and
+// delay its reply shows. Replies that do not answer the request, because
+// they do not echo its transmit timestamp, are ignored.
+func (c *Client) Query(server net.Addr) (*Sample, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t1 := c.now()
+	request := &Packet{
+		Version:      Version,
+		Mode:         ModeClient,
+		TransmitTime: NewTimestamp(t1),
+	}
+
+	if err := c.conn.SetReadDeadline(t1.Add(c.config.Timeout)); err != nil {
+		return nil, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	if _, err := c.conn.WriteTo(request.Serialize(), server); err != nil {
+		return nil, fmt.Errorf("failed to send request to %s: %w", server, err)
+	}
+
+	buf := make([]byte, 512)
+	for {
+		n, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return nil, fmt.Errorf("%w: %s", ErrNoResponse, server)
+			}
+			return nil, fmt.Errorf("failed to receive reply: %w", err)
+		}
+		t4 := c.now()
+
+		reply, err := Parse(buf[:n])
+		if err != nil || reply.Mode != ModeServer || reply.OriginTime != request.TransmitTime {
+			continue
+		}
+		return newSample(reply, t1, t4)
+	}
+}
+
+// newSample checks a server's reply to a request sent at t1 and received
+// at t4, and computes the clock offset and delay (RFC 4330 Section 5).
+func newSample(reply *Packet, t1, t4 time.Time) (*Sample, error) {
+	if code := reply.KissCode(); code != "" {
+		return nil, fmt.Errorf("%w: %q", ErrKissOfDeath, code)
+	}
+	if reply.Leap == LeapNotInSync || reply.TransmitTime.IsZero() {
+		return nil, ErrNotSynchronized
+	}
+
+	t2 := reply.ReceiveTime.Time()
+	t3 := reply.TransmitTime.Time()
+	delay := t4.Sub(t1) - t3.Sub(t2)
+	if delay < 0 {
+		delay = 0
+	}
+	return &Sample{
+		Offset:      (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		Delay:       delay,
+		Stratum:     reply.Stratum,
+		Leap:        reply.Leap,
+		ReferenceID: reply.ReferenceID,
+	}, nil
+}
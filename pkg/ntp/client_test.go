@@ -0,0 +1,201 @@
+package ntp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	clientAddr = udp.Address{IP: common.IPv4Address{192, 168, 1, 10}, Port: 40000}
+	serverAddr = udp.Address{IP: common.IPv4Address{192, 168, 1, 1}, Port: Port}
+)
+
+// newTestClient returns a client connected over UDP sockets of the stack to
+// a server that answers each request with respond, or not at all if it
+// returns nil.
+func newTestClient(t *testing.T, respond func(req *Packet, received time.Time) *Packet) *Client {
+	t.Helper()
+
+	clientSock, serverSock := udp.NewSocket(), udp.NewSocket()
+	if err := clientSock.Bind(clientAddr); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	if err := serverSock.Bind(serverAddr); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+
+	// Each conn delivers what it sends straight to the other's socket
+	clientConn := udp.NewPacketConn(clientSock, func(pkt *udp.Packet, to udp.Address) error {
+		return serverSock.Receive(pkt.Data, clientAddr)
+	})
+	serverConn := udp.NewPacketConn(serverSock, func(pkt *udp.Packet, to udp.Address) error {
+		return clientSock.Receive(pkt.Data, serverAddr)
+	})
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := serverConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := Parse(buf[:n])
+			if err != nil {
+				continue
+			}
+			if reply := respond(req, time.Now()); reply != nil {
+				serverConn.WriteTo(reply.Serialize(), from)
+			}
+		}
+	}()
+
+	return NewClientWithConfig(clientConn, Config{
+		Samples:        3,
+		Timeout:        200 * time.Millisecond,
+		SampleInterval: time.Millisecond,
+	})
+}
+
+// serverReply returns a server's reply to req by a clock skew ahead of
+// ours, sent hold after it was received.
+func serverReply(req *Packet, received time.Time, skew, hold time.Duration) *Packet {
+	return &Packet{
+		Version:      Version,
+		Mode:         ModeServer,
+		Stratum:      1,
+		ReferenceID:  [4]byte{'G', 'P', 'S', 0},
+		OriginTime:   req.TransmitTime,
+		ReceiveTime:  NewTimestamp(received.Add(skew)),
+		TransmitTime: NewTimestamp(received.Add(skew + hold)),
+	}
+}
+
+func TestClientGetTime(t *testing.T) {
+	const skew = 90 * time.Second
+	c := newTestClient(t, func(req *Packet, received time.Time) *Packet {
+		return serverReply(req, received, skew, 0)
+	})
+
+	got, err := c.GetTime(serverAddr)
+	if err != nil {
+		t.Fatalf("GetTime() error = %v", err)
+	}
+	if d := got.Sub(time.Now().Add(skew)); d < -50*time.Millisecond || d > 50*time.Millisecond {
+		t.Errorf("GetTime() = %s, want within 50ms of %s", got, time.Now().Add(skew))
+	}
+}
+
+func TestClientMeasure(t *testing.T) {
+	const skew = -3 * time.Second
+	const hold = 20 * time.Millisecond
+	queries := 0
+	c := newTestClient(t, func(req *Packet, received time.Time) *Packet {
+		queries++
+		if queries == 2 {
+			return nil // Lost
+		}
+		return serverReply(req, received, skew, hold)
+	})
+
+	result, err := c.Measure(serverAddr)
+	if err != nil {
+		t.Fatalf("Measure() error = %v", err)
+	}
+	if len(result.Samples) != 2 {
+		t.Errorf("len(Samples) = %d, want 2 (one query lost)", len(result.Samples))
+	}
+	for _, s := range result.Samples {
+		if s.Delay < result.Delay {
+			t.Errorf("result delay %s is not the least (sample delay %s)", result.Delay, s.Delay)
+		}
+	}
+
+	// The server's hold time is not part of the delay, and the offset is
+	// half of it off at most
+	if result.Delay > 10*time.Millisecond {
+		t.Errorf("Delay = %s, want under 10ms", result.Delay)
+	}
+	if d := result.Offset - skew; d < -hold || d > hold {
+		t.Errorf("Offset = %s, want about %s", result.Offset, skew)
+	}
+	if result.Stratum != 1 || result.ReferenceID != [4]byte{'G', 'P', 'S', 0} {
+		t.Errorf("Stratum = %d, ReferenceID = %q, want 1, GPS", result.Stratum, result.ReferenceID[:])
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(req *Packet, received time.Time) *Packet
+		want    error
+	}{
+		{
+			name:    "no response",
+			respond: func(req *Packet, received time.Time) *Packet { return nil },
+			want:    ErrNoResponse,
+		},
+		{
+			name: "wrong origin",
+			respond: func(req *Packet, received time.Time) *Packet {
+				reply := serverReply(req, received, 0, 0)
+				reply.OriginTime++
+				return reply
+			},
+			want: ErrNoResponse,
+		},
+		{
+			name: "kiss-o'-death",
+			respond: func(req *Packet, received time.Time) *Packet {
+				reply := serverReply(req, received, 0, 0)
+				reply.Stratum = 0
+				reply.ReferenceID = [4]byte{'R', 'A', 'T', 'E'}
+				return reply
+			},
+			want: ErrKissOfDeath,
+		},
+		{
+			name: "not synchronized",
+			respond: func(req *Packet, received time.Time) *Packet {
+				reply := serverReply(req, received, 0, 0)
+				reply.Leap = LeapNotInSync
+				return reply
+			},
+			want: ErrNotSynchronized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, tt.respond)
+			if _, err := c.Query(serverAddr); !errors.Is(err, tt.want) {
+				t.Errorf("Query() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientMeasureStopsOnKissOfDeath(t *testing.T) {
+	queries := 0
+	c := newTestClient(t, func(req *Packet, received time.Time) *Packet {
+		queries++
+		reply := serverReply(req, received, 0, 0)
+		reply.Stratum = 0
+		reply.ReferenceID = [4]byte{'D', 'E', 'N', 'Y'}
+		return reply
+	})
+
+	if _, err := c.Measure(serverAddr); !errors.Is(err, ErrKissOfDeath) {
+		t.Fatalf("Measure() error = %v, want %v", err, ErrKissOfDeath)
+	}
+	if queries != 1 {
+		t.Errorf("server got %d queries, want 1", queries)
+	}
+}
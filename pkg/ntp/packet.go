@@ -0,0 +1,204 @@
+// Package ntp implements a Simple Network Time Protocol (SNTPv4, RFC 4330)
+// client over the stack's UDP sockets.
+//
+// A Client queries a server several times and estimates the local clock's
+// offset from the sample with the least round-trip delay, which is the one
+// least disturbed by queuing:
+//
+//	client := ntp.NewClient(conn)
+//	now, err := client.GetTime(&net.UDPAddr{IP: server, Port: ntp.Port})
+package ntp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// NTP packet format (RFC 4330 Section 4):
+// +---------------------------+-------------+----------+---------------+
+// | LI (2), VN (3), Mode (3)  | Stratum (8) | Poll (8) | Precision (8) |
+// +---------------------------+-------------+----------+---------------+
+// | Root Delay (32)                                                    |
+// | Root Dispersion (32)                                               |
+// | Reference Identifier (32)                                          |
+// | Reference Timestamp (64)                                           |
+// | Originate Timestamp (64)                                           |
+// | Receive Timestamp (64)                                             |
+// | Transmit Timestamp (64)                                            |
+// +--------------------------------------------------------------------+
+
+const (
+	// Port is the UDP port NTP servers listen on.
+	Port = 123
+
+	// Version is the protocol version the client sends.
+	Version = 4
+
+	// PacketLength is the length of an NTP packet without extension
+	// fields or authenticator.
+	PacketLength = 48
+)
+
+// Mode is the association mode of an NTP packet.
+type Mode uint8
+
+// NTP modes.
+const (
+	ModeClient    Mode = 3
+	ModeServer    Mode = 4
+	ModeBroadcast Mode = 5
+)
+
+// String returns a human-readable name for the mode.
+func (m Mode) String() string {
+	switch m {
+	case ModeClient:
+		return "Client"
+	case ModeServer:
+		return "Server"
+	case ModeBroadcast:
+		return "Broadcast"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(m))
+	}
+}
+
+// LeapIndicator warns of a leap second at the end of the current day.
+type LeapIndicator uint8
+
+// Leap indicators.
+const (
+	LeapNone         LeapIndicator = 0
+	LeapAddSecond    LeapIndicator = 1
+	LeapDeleteSecond LeapIndicator = 2
+	LeapNotInSync    LeapIndicator = 3 // The server's clock is not synchronized
+)
+
+// Timestamp is an NTP timestamp: seconds since 1900 in the upper 32 bits
+// and the fraction of a second in the lower 32.
+type Timestamp uint64
+
+// ntpEpochOffset is the number of seconds from the NTP epoch (1900) to the
+// Unix epoch (1970).
+const ntpEpochOffset = 2208988800
+
+// NewTimestamp returns the NTP timestamp of a time.
+func NewTimestamp(t time.Time) Timestamp {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return Timestamp(seconds<<32 | fraction)
+}
+
+// Time returns the time of the timestamp. Timestamps with the most
+// significant bit clear are taken to be in era 1, after 2036-02-07, as RFC
+// 4330 Section 3 suggests.
+func (ts Timestamp) Time() time.Time {
+	seconds := int64(ts >> 32)
+	if seconds&0x80000000 == 0 {
+		seconds += 1 << 32
+	}
+	nanos := int64(uint64(ts&0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds-ntpEpochOffset, nanos)
+}
+
+// IsZero reports whether the timestamp is zero, which means unknown.
+func (ts Timestamp) IsZero() bool {
+	return ts == 0
+}
+
+// Packet is an NTP packet.
+type Packet struct {
+	Leap           LeapIndicator
+	Version        uint8
+	Mode           Mode
+	Stratum        uint8 // 0 is a kiss-o'-death message, 1 a primary server
+	Poll           int8  // Log2 of the poll interval in seconds
+	Precision      int8  // Log2 of the clock's precision in seconds
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	ReferenceID    [4]byte // Kiss code when Stratum is 0
+
+	ReferenceTime Timestamp // When the server's clock was last set
+	OriginTime    Timestamp // Client's transmit time, echoed by the server
+	ReceiveTime   Timestamp // When the server received the request
+	TransmitTime  Timestamp // When the packet was sent
+}
+
+// Parse parses an NTP packet. Extension fields and authenticators after the
+// header are ignored.
+func Parse(data []byte) (*Packet, error) {
+	if len(data) < PacketLength {
+		return nil, fmt.Errorf("packet too short: %d bytes", len(data))
+	}
+
+	p := &Packet{
+		Leap:           LeapIndicator(data[0] >> 6),
+		Version:        data[0] >> 3 & 0x07,
+		Mode:           Mode(data[0] & 0x07),
+		Stratum:        data[1],
+		Poll:           int8(data[2]),
+		Precision:      int8(data[3]),
+		RootDelay:      shortToDuration(binary.BigEndian.Uint32(data[4:8])),
+		RootDispersion: shortToDuration(binary.BigEndian.Uint32(data[8:12])),
+		ReferenceTime:  Timestamp(binary.BigEndian.Uint64(data[16:24])),
+		OriginTime:     Timestamp(binary.BigEndian.Uint64(data[24:32])),
+		ReceiveTime:    Timestamp(binary.BigEndian.Uint64(data[32:40])),
+		TransmitTime:   Timestamp(binary.BigEndian.Uint64(data[40:48])),
+	}
+	copy(p.ReferenceID[:], data[12:16])
+	if p.Version == 0 {
+		return nil, fmt.Errorf("invalid version 0")
+	}
+	return p, nil
+}
+
+// Serialize converts the packet to bytes.
+func (p *Packet) Serialize() []byte {
+	data := make([]byte, PacketLength)
+	data[0] = byte(p.Leap)<<6 | (p.Version&0x07)<<3 | byte(p.Mode)&0x07
+	data[1] = p.Stratum
+	data[2] = byte(p.Poll)
+	data[3] = byte(p.Precision)
+	binary.BigEndian.PutUint32(data[4:8], durationToShort(p.RootDelay))
+	binary.BigEndian.PutUint32(data[8:12], durationToShort(p.RootDispersion))
+	copy(data[12:16], p.ReferenceID[:])
+	binary.BigEndian.PutUint64(data[16:24], uint64(p.ReferenceTime))
+	binary.BigEndian.PutUint64(data[24:32], uint64(p.OriginTime))
+	binary.BigEndian.PutUint64(data[32:40], uint64(p.ReceiveTime))
+	binary.BigEndian.PutUint64(data[40:48], uint64(p.TransmitTime))
+	return data
+}
+
+// KissCode returns the kiss code of a kiss-o'-death message, such as
+// "RATE" or "DENY", or "" if the packet is not one.
+func (p *Packet) KissCode() string {
+	if p.Stratum != 0 {
+		return ""
+	}
+	return string(p.ReferenceID[:])
+}
+
+// String returns a human-readable representation of the packet.
+func (p *Packet) String() string {
+	return fmt.Sprintf("NTPv%d{%s, Stratum=%d, Transmit=%s}",
+		p.Version, p.Mode, p.Stratum, p.TransmitTime.Time().UTC().Format(time.RFC3339Nano))
+}
+
+// shortToDuration converts an NTP short format value (seconds in 16.16
+// fixed point) to a duration.
+func shortToDuration(v uint32) time.Duration {
+	return time.Duration(uint64(v) * uint64(time.Second) >> 16)
+}
+
+// durationToShort converts a duration to NTP short format, saturating at
+// its limits.
+func durationToShort(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	if d >= 1<<16*time.Second {
+		return 0xffffffff
+	}
+	return uint32(uint64(d) << 16 / uint64(time.Second))
+}
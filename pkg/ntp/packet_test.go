@@ -0,0 +1,136 @@
+package ntp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 250000000, time.UTC)
+	pkt := &Packet{
+		Leap:           LeapAddSecond,
+		Version:        Version,
+		Mode:           ModeServer,
+		Stratum:        2,
+		Poll:           6,
+		Precision:      -20,
+		RootDelay:      15625 * time.Microsecond,
+		RootDispersion: 500 * time.Millisecond,
+		ReferenceID:    [4]byte{192, 168, 1, 1},
+		ReferenceTime:  NewTimestamp(now.Add(-time.Minute)),
+		OriginTime:     NewTimestamp(now.Add(-time.Second)),
+		ReceiveTime:    NewTimestamp(now),
+		TransmitTime:   NewTimestamp(now.Add(time.Millisecond)),
+	}
+
+	data := pkt.Serialize()
+	if len(data) != PacketLength {
+		t.Fatalf("len(Serialize()) = %d, want %d", len(data), PacketLength)
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if *parsed != *pkt {
+		t.Errorf("Parse() = %+v, want %+v", parsed, pkt)
+	}
+}
+
+func TestParseKnownPacket(t *testing.T) {
+	// A client request: LI 0, version 4, mode 3, transmit timestamp
+	// 2024-01-01T00:00:00.5Z (0xe93c7f00 seconds and half)
+	data := make([]byte, PacketLength)
+	data[0] = 0x23
+	copy(data[40:], []byte{0xe9, 0x3c, 0x7f, 0x00, 0x80, 0x00, 0x00, 0x00})
+
+	pkt, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if pkt.Leap != LeapNone || pkt.Version != 4 || pkt.Mode != ModeClient {
+		t.Errorf("header = LI %d, version %d, mode %s, want LI 0, version 4, mode Client", pkt.Leap, pkt.Version, pkt.Mode)
+	}
+	want := time.Date(2024, 1, 1, 0, 0, 0, 500000000, time.UTC)
+	if got := pkt.TransmitTime.Time(); !got.Equal(want) {
+		t.Errorf("TransmitTime = %s, want %s", got, want)
+	}
+	if !bytes.Equal(pkt.Serialize(), data) {
+		t.Errorf("Serialize() = %x, want %x", pkt.Serialize(), data)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", make([]byte, PacketLength-1)},
+		{"version zero", make([]byte, PacketLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Errorf("Parse() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	tests := []struct {
+		name string
+		time time.Time
+	}{
+		{"unix epoch", time.Unix(0, 0)},
+		{"now", time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)},
+		{"last second of era 0", time.Date(2036, 2, 7, 6, 28, 15, 0, time.UTC)},
+		{"era 1", time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewTimestamp(tt.time).Time()
+			if d := got.Sub(tt.time); d < -time.Nanosecond || d > time.Nanosecond {
+				t.Errorf("NewTimestamp(%s).Time() = %s, want %s", tt.time, got, tt.time)
+			}
+		})
+	}
+
+	if got := NewTimestamp(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)); got != 0 {
+		t.Errorf("NewTimestamp(1900) = %#x, want 0", uint64(got))
+	}
+}
+
+func TestKissCode(t *testing.T) {
+	kod := &Packet{Stratum: 0, ReferenceID: [4]byte{'R', 'A', 'T', 'E'}}
+	if got := kod.KissCode(); got != "RATE" {
+		t.Errorf("KissCode() = %q, want %q", got, "RATE")
+	}
+	normal := &Packet{Stratum: 1, ReferenceID: [4]byte{'G', 'P', 'S', 0}}
+	if got := normal.KissCode(); got != "" {
+		t.Errorf("KissCode() = %q for stratum 1, want \"\"", got)
+	}
+}
+
+func TestShortFormat(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want uint32
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{time.Second, 0x00010000},
+		{500 * time.Millisecond, 0x00008000},
+		{1 << 20 * time.Second, 0xffffffff},
+	}
+
+	for _, tt := range tests {
+		if got := durationToShort(tt.d); got != tt.want {
+			t.Errorf("durationToShort(%s) = %#x, want %#x", tt.d, got, tt.want)
+		}
+	}
+}
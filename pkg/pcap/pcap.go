@@ -0,0 +1,198 @@
+// Package pcap reads and writes packet captures in the classic libpcap file
+// format, as written by tcpdump and read by Wireshark.
+//
+// Both byte orders and both microsecond and nanosecond timestamp resolution
+// are read; files are written in the host's byte order with microsecond or
+// nanosecond timestamps.
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// File magic numbers, as read in the writer's byte order
+const (
+	magicMicroseconds = 0xa1b2c3d4
+	magicNanoseconds  = 0xa1b23c4d
+)
+
+const (
+	versionMajor = 2
+	versionMinor = 4
+
+	fileHeaderLength   = 24
+	recordHeaderLength = 16
+
+	// DefaultSnapLen is the largest packet a Writer records in full.
+	DefaultSnapLen = 262144
+)
+
+// LinkType is the link-layer header type of the packets in a file.
+type LinkType uint32
+
+// Link types (https://www.tcpdump.org/linktypes.html)
+const (
+	LinkTypeEthernet LinkType = 1
+	LinkTypeRaw      LinkType = 101 // IPv4 or IPv6 packets without a link-layer header
+)
+
+// ErrBadMagic is returned for a file that is not a pcap file.
+var ErrBadMagic = errors.New("not a pcap file")
+
+// Packet is a captured packet.
+type Packet struct {
+	Timestamp time.Time
+	Data      []byte // The captured bytes
+	Length    int    // Length of the packet on the wire, at least len(Data)
+}
+
+// Reader reads packets from a pcap file.
+type Reader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	snapLen  uint32
+	linkType LinkType
+	header   [recordHeaderLength]byte
+}
+
+// NewReader reads the file header from r and returns a reader of its
+// packets.
+func NewReader(r io.Reader) (*Reader, error) {
+	var header [fileHeaderLength]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+
+	rd := &Reader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(header[0:4]) == magicMicroseconds:
+		rd.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header[0:4]) == magicMicroseconds:
+		rd.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header[0:4]) == magicNanoseconds:
+		rd.order, rd.nanos = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header[0:4]) == magicNanoseconds:
+		rd.order, rd.nanos = binary.BigEndian, true
+	default:
+		return nil, ErrBadMagic
+	}
+
+	if major := rd.order.Uint16(header[4:6]); major != versionMajor {
+		return nil, fmt.Errorf("unsupported pcap version %d.%d", major, rd.order.Uint16(header[6:8]))
+	}
+	rd.snapLen = rd.order.Uint32(header[16:20])
+	rd.linkType = LinkType(rd.order.Uint32(header[20:24]) & 0x0fffffff) // The top bits hold FCS information
+	return rd, nil
+}
+
+// LinkType returns the link-layer header type of the file's packets.
+func (r *Reader) LinkType() LinkType {
+	return r.linkType
+}
+
+// SnapLen returns the largest number of bytes captured of a packet.
+func (r *Reader) SnapLen() int {
+	return int(r.snapLen)
+}
+
+// ReadPacket reads the next packet. It returns io.EOF at the end of the
+// file, and io.ErrUnexpectedEOF if the file ends inside a packet.
+func (r *Reader) ReadPacket() (*Packet, error) {
+	if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated record header: %w", err)
+		}
+		return nil, err
+	}
+
+	sec := r.order.Uint32(r.header[0:4])
+	frac := r.order.Uint32(r.header[4:8])
+	captured := r.order.Uint32(r.header[8:12])
+	length := r.order.Uint32(r.header[12:16])
+
+	// Allow some slack over the snap length for writers that ignore it
+	if captured > r.snapLen && captured > DefaultSnapLen {
+		return nil, fmt.Errorf("packet of %d bytes exceeds snap length %d", captured, r.snapLen)
+	}
+	if !r.nanos {
+		frac *= 1000
+	}
+
+	data := make([]byte, captured)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("truncated packet: %w", err)
+	}
+	return &Packet{
+		Timestamp: time.Unix(int64(sec), int64(frac)),
+		Data:      data,
+		Length:    int(max(length, captured)),
+	}, nil
+}
+
+// Writer writes packets to a pcap file.
+type Writer struct {
+	w       io.Writer
+	nanos   bool
+	snapLen int
+	header  [recordHeaderLength]byte
+}
+
+// NewWriter writes a file header for packets of the given link type to w,
+// and returns a writer of microsecond-resolution records.
+func NewWriter(w io.Writer, linkType LinkType) (*Writer, error) {
+	return newWriter(w, linkType, false)
+}
+
+// NewNanosecondWriter is like NewWriter, but records timestamps to the
+// nanosecond.
+func NewNanosecondWriter(w io.Writer, linkType LinkType) (*Writer, error) {
+	return newWriter(w, linkType, true)
+}
+
+func newWriter(w io.Writer, linkType LinkType, nanos bool) (*Writer, error) {
+	var header [fileHeaderLength]byte
+	magic := uint32(magicMicroseconds)
+	if nanos {
+		magic = magicNanoseconds
+	}
+	binary.NativeEndian.PutUint32(header[0:4], magic)
+	binary.NativeEndian.PutUint16(header[4:6], versionMajor)
+	binary.NativeEndian.PutUint16(header[6:8], versionMinor)
+	binary.NativeEndian.PutUint32(header[16:20], DefaultSnapLen)
+	binary.NativeEndian.PutUint32(header[20:24], uint32(linkType))
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, fmt.Errorf("failed to write file header: %w", err)
+	}
+	return &Writer{w: w, nanos: nanos, snapLen: DefaultSnapLen}, nil
+}
+
+// WritePacket writes a packet captured at ts. Packets longer than the snap
+// length are truncated.
+func (w *Writer) WritePacket(ts time.Time, data []byte) error {
+	length := len(data)
+	if len(data) > w.snapLen {
+		data = data[:w.snapLen]
+	}
+
+	frac := uint32(ts.Nanosecond())
+	if !w.nanos {
+		frac /= 1000
+	}
+	binary.NativeEndian.PutUint32(w.header[0:4], uint32(ts.Unix()))
+	binary.NativeEndian.PutUint32(w.header[4:8], frac)
+	binary.NativeEndian.PutUint32(w.header[8:12], uint32(len(data)))
+	binary.NativeEndian.PutUint32(w.header[12:16], uint32(length))
+	if _, err := w.w.Write(w.header[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(data)
+	return err
+}
@@ -0,0 +1,111 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	tests := []struct {
+		name    string
+		newFunc func(io.Writer, LinkType) (*Writer, error)
+		want    time.Time
+	}{
+		{"microseconds", NewWriter, ts.Truncate(time.Microsecond)},
+		{"nanoseconds", NewNanosecondWriter, ts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := tt.newFunc(&buf, LinkTypeEthernet)
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			packets := [][]byte{[]byte("first packet"), {}, bytes.Repeat([]byte{0xAB}, 1500)}
+			for i, data := range packets {
+				if err := w.WritePacket(ts.Add(time.Duration(i)*time.Second), data); err != nil {
+					t.Fatalf("WritePacket() error = %v", err)
+				}
+			}
+
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			if r.LinkType() != LinkTypeEthernet {
+				t.Errorf("LinkType() = %d, want %d", r.LinkType(), LinkTypeEthernet)
+			}
+			for i, data := range packets {
+				pkt, err := r.ReadPacket()
+				if err != nil {
+					t.Fatalf("ReadPacket() error = %v", err)
+				}
+				if !bytes.Equal(pkt.Data, data) || pkt.Length != len(data) {
+					t.Errorf("packet %d = %d bytes (length %d), want %d", i, len(pkt.Data), pkt.Length, len(data))
+				}
+				if want := tt.want.Add(time.Duration(i) * time.Second); !pkt.Timestamp.Equal(want) {
+					t.Errorf("packet %d timestamp = %v, want %v", i, pkt.Timestamp, want)
+				}
+			}
+			if _, err := r.ReadPacket(); err != io.EOF {
+				t.Errorf("ReadPacket() error = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestReadBigEndian(t *testing.T) {
+	// A big-endian file with one truncated 4-byte record of a 100-byte packet
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, []uint32{magicMicroseconds, 2<<16 | 4, 0, 0, 4, uint32(LinkTypeRaw)})
+	binary.Write(&buf, binary.BigEndian, []uint32{1700000000, 500000, 4, 100})
+	buf.Write([]byte{0x45, 0x00, 0x00, 0x64})
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	if r.LinkType() != LinkTypeRaw || r.SnapLen() != 4 {
+		t.Errorf("LinkType(), SnapLen() = %d, %d, want %d, 4", r.LinkType(), r.SnapLen(), LinkTypeRaw)
+	}
+	pkt, err := r.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket() error = %v", err)
+	}
+	if want := time.Unix(1700000000, 500000000); !pkt.Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v", pkt.Timestamp, want)
+	}
+	if len(pkt.Data) != 4 || pkt.Length != 100 {
+		t.Errorf("packet = %d bytes of %d, want 4 of 100", len(pkt.Data), pkt.Length)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	var valid bytes.Buffer
+	w, _ := NewWriter(&valid, LinkTypeEthernet)
+	w.WritePacket(time.Now(), []byte("some packet data"))
+	file := valid.Bytes()
+
+	if _, err := NewReader(bytes.NewReader(make([]byte, fileHeaderLength))); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("NewReader() error = %v, want %v", err, ErrBadMagic)
+	}
+	if _, err := NewReader(bytes.NewReader(file[:10])); err == nil {
+		t.Errorf("NewReader() of a short header error = nil, want error")
+	}
+
+	for _, n := range []int{fileHeaderLength + 8, len(file) - 1} {
+		r, err := NewReader(bytes.NewReader(file[:n]))
+		if err != nil {
+			t.Fatalf("NewReader() error = %v", err)
+		}
+		if _, err := r.ReadPacket(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("ReadPacket() of %d bytes error = %v, want %v", n, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
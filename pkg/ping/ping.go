@@ -0,0 +1,437 @@
+// Package ping sends ICMP Echo Requests to one or more hosts and matches
+// their replies. A Pinger owns no socket: it hands each request to a send
+// function and is given the packets received, so it runs over the stack,
+// a raw socket, or a test harness alike.
+package ping
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+const (
+	// DefaultInterval is the pause between the requests to a target.
+	DefaultInterval = time.Second
+
+	// DefaultTimeout is how long a reply is waited for.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultSize is the payload size of requests, that of ping(8).
+	DefaultSize = 56
+
+	// DefaultResultBuffer is how many results wait to be read before the
+	// Pinger waits for the reader.
+	DefaultResultBuffer = 64
+)
+
+// Errors reported by Pinger.
+var (
+	ErrTimeout = errors.New("ping: no reply")
+	ErrRunning = errors.New("ping: pinger already run")
+)
+
+// Config configures a Pinger.
+type Config struct {
+	// Send transmits an Echo Request to dst, from the host's address the
+	// replies come back to. It is required.
+	Send func(msg *icmp.Message, dst common.IPv4Address) error
+
+	// ID is the identifier of the requests; 0 means a random one.
+	ID uint16
+
+	// Count is how many requests each target is sent; 0 means until the
+	// context of Run is done.
+	Count int
+
+	Interval time.Duration // 0 means DefaultInterval
+	Timeout  time.Duration // 0 means DefaultTimeout
+	Size     int           // Payload bytes; 0 means DefaultSize
+
+	// Pattern is repeated to fill the payload, like the -p option of
+	// ping(8); nil means the bytes 0, 1, 2 and so on.
+	Pattern []byte
+
+	ResultBuffer int // 0 means DefaultResultBuffer
+}
+
+// Result is the outcome of an Echo Request.
+type Result struct {
+	Target   common.IPv4Address
+	Sequence uint16
+
+	// From is the host the reply or error came from: the target, or a
+	// router reporting an error.
+	From common.IPv4Address
+
+	RTT       time.Duration // Zero unless replied to
+	TTL       uint8         // Of the reply
+	Size      int           // ICMP bytes of the reply
+	Duplicate bool          // Another reply to a request already answered
+
+	// Err is ErrTimeout if no reply came in time, the error an ICMP error
+	// message reports, or the error Send returned.
+	Err error
+}
+
+// Stats summarizes the results for a target.
+type Stats struct {
+	Transmitted int
+	Received    int // Replies, duplicates excluded
+	Duplicates  int
+	Errors      int // ICMP errors reported
+
+	MinRTT, AvgRTT, MaxRTT time.Duration
+	StdDevRTT              time.Duration // The mdev of ping(8)
+}
+
+// Loss returns the fraction of the requests sent that got no reply.
+func (s Stats) Loss() float64 {
+	if s.Transmitted == 0 {
+		return 0
+	}
+	return float64(s.Transmitted-s.Received) / float64(s.Transmitted)
+}
+
+// Pinger pings targets concurrently. It runs once: Run sends the
+// requests and closes the channel of results when done.
+type Pinger struct {
+	config  Config
+	payload []byte
+	now     func() time.Time // Replaced in tests
+
+	results   chan Result
+	closeMu   sync.RWMutex // Held for sending results, and to close them
+	closed    bool
+	resultCtx context.Context
+
+	mu      sync.Mutex
+	started bool
+	targets map[common.IPv4Address]*target
+}
+
+// target is the state of the pings to one host.
+type target struct {
+	addr    common.IPv4Address
+	nextSeq uint16
+	pending map[uint16]*request
+	replied chan struct{} // Signaled on each reply
+
+	stats         Stats
+	sumRTT, sumSq float64 // In seconds, for the average and deviation
+}
+
+// request is an Echo Request sent.
+type request struct {
+	sent     time.Time
+	answered bool
+}
+
+// New creates a Pinger.
+func New(config Config) (*Pinger, error) {
+	if config.Send == nil {
+		return nil, fmt.Errorf("ping: Send is required")
+	}
+	if config.Count < 0 || config.Size < 0 || config.Interval < 0 || config.Timeout < 0 || config.ResultBuffer < 0 {
+		return nil, fmt.Errorf("ping: invalid config %+v", config)
+	}
+
+	// Apply defaults
+	for config.ID == 0 {
+		var b [2]byte
+		rand.Read(b[:])
+		config.ID = binary.BigEndian.Uint16(b[:])
+	}
+	if config.Interval == 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Size == 0 {
+		config.Size = DefaultSize
+	}
+	if config.ResultBuffer == 0 {
+		config.ResultBuffer = DefaultResultBuffer
+	}
+
+	payload := make([]byte, config.Size)
+	for i := range payload {
+		if len(config.Pattern) > 0 {
+			payload[i] = config.Pattern[i%len(config.Pattern)]
+		} else {
+			payload[i] = byte(i)
+		}
+	}
+
+	return &Pinger{
+		config:  config,
+		payload: payload,
+		now:     time.Now,
+		results: make(chan Result, config.ResultBuffer),
+		targets: make(map[common.IPv4Address]*target),
+	}, nil
+}
+
+// ID returns the identifier of the requests.
+func (p *Pinger) ID() uint16 {
+	return p.config.ID
+}
+
+// Results returns the channel of results, in the order they are known.
+// It must be read while Run runs, and is closed when Run returns.
+func (p *Pinger) Results() <-chan Result {
+	return p.results
+}
+
+// Run pings the targets, each at the configured interval, until every
+// request is answered or timed out or until ctx is done.
+func (p *Pinger) Run(ctx context.Context, targets ...common.IPv4Address) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("ping: no targets")
+	}
+	p.mu.Lock()
+	if p.started {
+		p.mu.Unlock()
+		return ErrRunning
+	}
+	p.started = true
+	p.resultCtx = ctx
+	var run []*target
+	for _, addr := range targets {
+		if _, ok := p.targets[addr]; !ok {
+			t := &target{addr: addr, nextSeq: 1, pending: make(map[uint16]*request), replied: make(chan struct{}, 1)}
+			p.targets[addr] = t
+			run = append(run, t)
+		}
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, t := range run {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.ping(ctx, t)
+		}()
+	}
+	wg.Wait()
+
+	p.closeMu.Lock()
+	p.closed = true
+	close(p.results)
+	p.closeMu.Unlock()
+
+	if p.config.Count == 0 {
+		return nil // Stopped by ctx, as intended
+	}
+	return ctx.Err()
+}
+
+// ping sends the requests to a target, then waits for the last replies.
+func (p *Pinger) ping(ctx context.Context, t *target) {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for sent := 0; p.config.Count == 0 || sent < p.config.Count; sent++ {
+		if sent > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+		p.expire(t)
+		p.send(t)
+	}
+
+	deadline := time.NewTimer(p.config.Timeout)
+	defer deadline.Stop()
+	for p.waiting(t) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.replied:
+		case <-deadline.C:
+			p.expire(t)
+			return
+		}
+	}
+}
+
+// send sends the next request to a target.
+func (p *Pinger) send(t *target) {
+	p.mu.Lock()
+	seq := t.nextSeq
+	t.nextSeq++
+	req := &request{sent: p.now()}
+	// Pending before it is sent, as the reply may come back at once
+	t.pending[seq] = req
+	t.stats.Transmitted++
+	p.mu.Unlock()
+
+	msg := icmp.NewEchoRequest(p.config.ID, seq, p.payload)
+	if err := p.config.Send(msg, t.addr); err != nil {
+		p.mu.Lock()
+		delete(t.pending, seq)
+		t.stats.Transmitted--
+		p.mu.Unlock()
+		p.emit(Result{Target: t.addr, Sequence: seq, Err: err})
+	}
+}
+
+// waiting reports whether requests to a target wait for a reply.
+func (p *Pinger) waiting(t *target) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, req := range t.pending {
+		if !req.answered {
+			return true
+		}
+	}
+	return false
+}
+
+// expire reports the requests to a target that timed out, and forgets
+// those answered long enough ago that no duplicate is expected.
+func (p *Pinger) expire(t *target) {
+	var timedOut []uint16
+	p.mu.Lock()
+	now := p.now()
+	for seq, req := range t.pending {
+		if now.Sub(req.sent) < p.config.Timeout {
+			continue
+		}
+		if !req.answered {
+			timedOut = append(timedOut, seq)
+		}
+		delete(t.pending, seq)
+	}
+	p.mu.Unlock()
+
+	for _, seq := range timedOut {
+		p.emit(Result{Target: t.addr, Sequence: seq, Err: ErrTimeout})
+	}
+}
+
+// emit delivers a result, unless Run is over or its context done.
+func (p *Pinger) emit(r Result) {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.results <- r:
+	case <-p.resultCtx.Done():
+	}
+}
+
+// HandlePacket processes a packet received, and reports whether it was
+// the reply to one of the Pinger's requests or an ICMP error about one.
+func (p *Pinger) HandlePacket(pkt *ip.Packet) bool {
+	if pkt.Protocol != common.ProtocolICMP {
+		return false
+	}
+	msg, err := icmp.Parse(pkt.Payload)
+	if err != nil || !msg.VerifyChecksum() {
+		return false
+	}
+	switch {
+	case msg.IsEchoReply():
+		if msg.ID != p.config.ID {
+			return false
+		}
+		return p.reply(pkt.Source, Result{Target: pkt.Source, Sequence: msg.Sequence, From: pkt.Source, TTL: pkt.TTL, Size: len(pkt.Payload)})
+	case msg.IsError():
+		orig, err := msg.Original()
+		if err != nil || orig.Protocol != common.ProtocolICMP || len(orig.Payload) < icmp.MinHeaderLength {
+			return false
+		}
+		echo := orig.Payload
+		if icmp.Type(echo[0]) != icmp.TypeEchoRequest || binary.BigEndian.Uint16(echo[4:6]) != p.config.ID {
+			return false
+		}
+		seq := binary.BigEndian.Uint16(echo[6:8])
+		return p.reply(orig.Destination, Result{Target: orig.Destination, Sequence: seq, From: pkt.Source, Err: msg.Err()})
+	default:
+		return false
+	}
+}
+
+// reply matches a reply or error to a pending request, and reports the
+// result.
+func (p *Pinger) reply(addr common.IPv4Address, r Result) bool {
+	p.mu.Lock()
+	t, ok := p.targets[addr]
+	if !ok {
+		p.mu.Unlock()
+		return false
+	}
+	req, ok := t.pending[r.Sequence]
+	if !ok {
+		p.mu.Unlock()
+		return false // Timed out, or never sent
+	}
+
+	switch {
+	case r.Err != nil:
+		delete(t.pending, r.Sequence)
+		t.stats.Errors++
+	case req.answered:
+		r.Duplicate = true
+		r.RTT = p.now().Sub(req.sent)
+		t.stats.Duplicates++
+	default:
+		req.answered = true
+		r.RTT = p.now().Sub(req.sent)
+		t.record(r.RTT)
+	}
+	p.mu.Unlock()
+
+	select {
+	case t.replied <- struct{}{}:
+	default:
+	}
+	p.emit(r)
+	return true
+}
+
+// record counts a reply with its round-trip time.
+func (t *target) record(rtt time.Duration) {
+	s := &t.stats
+	if s.Received == 0 || rtt < s.MinRTT {
+		s.MinRTT = rtt
+	}
+	if rtt > s.MaxRTT {
+		s.MaxRTT = rtt
+	}
+	s.Received++
+	t.sumRTT += rtt.Seconds()
+	t.sumSq += rtt.Seconds() * rtt.Seconds()
+
+	avg := t.sumRTT / float64(s.Received)
+	s.AvgRTT = time.Duration(avg * float64(time.Second))
+	s.StdDevRTT = time.Duration(math.Sqrt(max(t.sumSq/float64(s.Received)-avg*avg, 0)) * float64(time.Second))
+}
+
+// Stats returns the statistics of a target, or false if it was not
+// pinged.
+func (p *Pinger) Stats(addr common.IPv4Address) (Stats, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.targets[addr]
+	if !ok {
+		return Stats{}, false
+	}
+	return t.stats, true
+}
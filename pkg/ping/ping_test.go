@@ -0,0 +1,291 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+var (
+	localIP  = common.IPv4Address{10, 0, 0, 1}
+	hostA    = common.IPv4Address{10, 0, 0, 2}
+	hostB    = common.IPv4Address{10, 0, 0, 3}
+	routerIP = common.IPv4Address{10, 0, 0, 254}
+)
+
+// network answers the requests of a Pinger as the hosts in it do: each
+// request is passed to the host's function, which returns the packets
+// sent back.
+type network struct {
+	t      *testing.T
+	pinger *Pinger
+	hosts  map[common.IPv4Address]func(req *icmp.Message) []*ip.Packet
+
+	mu   sync.Mutex
+	sent []*icmp.Message
+}
+
+func newNetwork(t *testing.T, config Config) *network {
+	t.Helper()
+	n := &network{t: t, hosts: make(map[common.IPv4Address]func(*icmp.Message) []*ip.Packet)}
+	config.Send = n.send
+	p, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	n.pinger = p
+	return n
+}
+
+func (n *network) send(msg *icmp.Message, dst common.IPv4Address) error {
+	n.mu.Lock()
+	n.sent = append(n.sent, msg)
+	host := n.hosts[dst]
+	n.mu.Unlock()
+	if host == nil {
+		return nil
+	}
+	for _, pkt := range host(msg) {
+		n.pinger.HandlePacket(pkt)
+	}
+	return nil
+}
+
+// run pings the targets and returns the results.
+func (n *network) run(targets ...common.IPv4Address) []Result {
+	n.t.Helper()
+	var results []Result
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for r := range n.pinger.Results() {
+			results = append(results, r)
+		}
+	}()
+	if err := n.pinger.Run(context.Background(), targets...); err != nil {
+		n.t.Fatalf("Run() error = %v", err)
+	}
+	<-done
+	return results
+}
+
+// packet returns an ICMP message as received from src.
+func packet(t *testing.T, msg *icmp.Message, src common.IPv4Address) *ip.Packet {
+	t.Helper()
+	raw, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	return ip.NewPacket(src, localIP, common.ProtocolICMP, raw)
+}
+
+// echo returns a host that answers every request after delay.
+func echo(t *testing.T, addr common.IPv4Address, delay time.Duration) func(*icmp.Message) []*ip.Packet {
+	return func(req *icmp.Message) []*ip.Packet {
+		time.Sleep(delay)
+		return []*ip.Packet{packet(t, icmp.NewEchoReply(req.ID, req.Sequence, req.Data), addr)}
+	}
+}
+
+func TestPing(t *testing.T) {
+	n := newNetwork(t, Config{Count: 3, Interval: time.Millisecond, Size: 16})
+	n.hosts[hostA] = echo(t, hostA, 0)
+	n.hosts[hostB] = echo(t, hostB, 5*time.Millisecond)
+
+	results := n.run(hostA, hostB)
+	if len(results) != 6 {
+		t.Fatalf("got %d results, want 6", len(results))
+	}
+	seqs := map[common.IPv4Address][]uint16{}
+	for _, r := range results {
+		if r.Err != nil || r.Duplicate || r.From != r.Target || r.TTL != ip.DefaultTTL || r.Size != icmp.MinHeaderLength+16 {
+			t.Errorf("result = %+v, want a reply", r)
+		}
+		// The round trip is timed from the send
+		if r.Target == hostB && r.RTT < 5*time.Millisecond {
+			t.Errorf("RTT to %s = %v, want at least 5ms", hostB, r.RTT)
+		}
+		seqs[r.Target] = append(seqs[r.Target], r.Sequence)
+	}
+	for _, host := range []common.IPv4Address{hostA, hostB} {
+		if got := seqs[host]; len(got) != 3 || got[0] != 1 || got[2] != 3 {
+			t.Errorf("sequence numbers to %s = %v, want 1, 2, 3", host, got)
+		}
+		s, ok := n.pinger.Stats(host)
+		if !ok || s.Transmitted != 3 || s.Received != 3 || s.Loss() != 0 {
+			t.Errorf("Stats(%s) = %+v, %v", host, s, ok)
+		}
+		if s.MinRTT > s.AvgRTT || s.AvgRTT > s.MaxRTT {
+			t.Errorf("Stats(%s) RTTs min %v, avg %v, max %v", host, s.MinRTT, s.AvgRTT, s.MaxRTT)
+		}
+	}
+	if _, ok := n.pinger.Stats(routerIP); ok {
+		t.Error("Stats() of a host not pinged succeeded")
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	n := newNetwork(t, Config{Count: 2, Interval: time.Millisecond, Timeout: 20 * time.Millisecond})
+	answered := false
+	n.hosts[hostA] = func(req *icmp.Message) []*ip.Packet {
+		// Only the first request is answered
+		if answered {
+			return nil
+		}
+		answered = true
+		return echo(t, hostA, 0)(req)
+	}
+
+	start := time.Now()
+	results := n.run(hostA)
+	if len(results) != 2 || results[0].Err != nil || !errors.Is(results[1].Err, ErrTimeout) || results[1].Sequence != 2 {
+		t.Fatalf("results = %+v, want a reply then a timeout", results)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Run() returned after %v, before the timeout", elapsed)
+	}
+	if s, _ := n.pinger.Stats(hostA); s.Transmitted != 2 || s.Received != 1 || s.Loss() != 0.5 {
+		t.Errorf("Stats() = %+v, want half lost", s)
+	}
+}
+
+func TestPingErrors(t *testing.T) {
+	n := newNetwork(t, Config{Count: 1})
+	n.hosts[hostA] = func(req *icmp.Message) []*ip.Packet {
+		// The router quotes the request it could not deliver
+		raw, _ := req.Serialize()
+		quoted, _ := ip.NewPacket(localIP, hostA, common.ProtocolICMP, raw).Serialize()
+		return []*ip.Packet{packet(t, icmp.NewDestinationUnreachable(icmp.CodeHostUnreachable, quoted[:ip.MinHeaderLength+8]), routerIP)}
+	}
+
+	results := n.run(hostA)
+	if len(results) != 1 || !errors.Is(results[0].Err, icmp.ErrHostUnreachable) || results[0].From != routerIP || results[0].Target != hostA {
+		t.Fatalf("results = %+v, want host unreachable from %s", results, routerIP)
+	}
+	if s, _ := n.pinger.Stats(hostA); s.Errors != 1 || s.Received != 0 {
+		t.Errorf("Stats() = %+v, want an error", s)
+	}
+}
+
+func TestPingDuplicates(t *testing.T) {
+	n := newNetwork(t, Config{Count: 1, Timeout: 20 * time.Millisecond})
+	n.hosts[hostA] = func(req *icmp.Message) []*ip.Packet {
+		reply := echo(t, hostA, 0)(req)
+		return append(reply, reply...)
+	}
+
+	results := n.run(hostA)
+	if len(results) != 2 || results[0].Duplicate || !results[1].Duplicate {
+		t.Fatalf("results = %+v, want a reply and a duplicate", results)
+	}
+	if s, _ := n.pinger.Stats(hostA); s.Received != 1 || s.Duplicates != 1 {
+		t.Errorf("Stats() = %+v, want 1 received and 1 duplicate", s)
+	}
+}
+
+func TestPingPayload(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   []byte
+	}{
+		{"default", Config{Size: 4}, []byte{0, 1, 2, 3}},
+		{"pattern", Config{Size: 5, Pattern: []byte{0xde, 0xad}}, []byte{0xde, 0xad, 0xde, 0xad, 0xde}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Count = 1
+			tt.config.Timeout = time.Millisecond
+			n := newNetwork(t, tt.config)
+			n.run(hostA)
+			if len(n.sent) != 1 || !bytes.Equal(n.sent[0].Data, tt.want) || n.sent[0].ID != n.pinger.ID() {
+				t.Errorf("sent %+v, want payload % x", n.sent, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlePacketUnmatched(t *testing.T) {
+	n := newNetwork(t, Config{ID: 7, Interval: time.Hour})
+	n.hosts[hostA] = func(req *icmp.Message) []*ip.Packet { return nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- n.pinger.Run(ctx, hostA) }()
+	for {
+		n.mu.Lock()
+		sent := len(n.sent)
+		n.mu.Unlock()
+		if sent > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	bad := packet(t, icmp.NewEchoReply(7, 1, nil), hostA)
+	bad.Payload[2]++ // Checksum
+	tests := []struct {
+		name string
+		pkt  *ip.Packet
+	}{
+		{"other identifier", packet(t, icmp.NewEchoReply(8, 1, nil), hostA)},
+		{"not sent", packet(t, icmp.NewEchoReply(7, 2, nil), hostA)},
+		{"other host", packet(t, icmp.NewEchoReply(7, 1, nil), hostB)},
+		{"echo request", packet(t, icmp.NewEchoRequest(7, 1, nil), hostA)},
+		{"bad checksum", bad},
+		{"not ICMP", ip.NewPacket(hostA, localIP, common.ProtocolUDP, make([]byte, 8))},
+	}
+	for _, tt := range tests {
+		if n.pinger.HandlePacket(tt.pkt) {
+			t.Errorf("HandlePacket() of %s = true, want false", tt.name)
+		}
+	}
+	if !n.pinger.HandlePacket(packet(t, icmp.NewEchoReply(7, 1, nil), hostA)) {
+		t.Error("HandlePacket() of the reply = false, want true")
+	}
+	if r := <-n.pinger.Results(); r.Sequence != 1 || r.Err != nil {
+		t.Errorf("result = %+v, want the reply", r)
+	}
+
+	// Until cancelled without a count
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+	if _, ok := <-n.pinger.Results(); ok {
+		t.Error("Results() not closed after Run() returned")
+	}
+	if err := n.pinger.Run(context.Background(), hostA); !errors.Is(err, ErrRunning) {
+		t.Errorf("second Run() error = %v, want ErrRunning", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	send := func(*icmp.Message, common.IPv4Address) error { return nil }
+	tests := []Config{
+		{},
+		{Send: send, Count: -1},
+		{Send: send, Size: -1},
+	}
+	for _, config := range tests {
+		if _, err := New(config); err == nil {
+			t.Errorf("New(%+v) succeeded, want error", config)
+		}
+	}
+	p, err := New(Config{Send: send})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if p.ID() == 0 || len(p.payload) != DefaultSize {
+		t.Errorf("New() ID %d, payload %d bytes", p.ID(), len(p.payload))
+	}
+	if err := p.Run(context.Background()); err == nil {
+		t.Error("Run() without targets succeeded, want error")
+	}
+}
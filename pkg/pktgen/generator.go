@@ -0,0 +1,233 @@
+package pktgen
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/pcap"
+)
+
+const (
+	// DefaultBatchSize is how many frames are sent per batch.
+	DefaultBatchSize = ethernet.MaxBatchSize
+
+	// maxSleep bounds each wait, so Stop takes effect promptly.
+	maxSleep = 100 * time.Millisecond
+)
+
+// Config configures a Generator.
+type Config struct {
+	// Rate is the target packet rate, in packets per second. 0 sends as
+	// fast as the device allows.
+	Rate float64
+
+	// Count is the number of packets to send; 0 means no limit.
+	Count int
+
+	// Duration ends the run after this long; 0 means no limit.
+	Duration time.Duration
+
+	// BatchSize is the most frames sent at once. 0 means DefaultBatchSize.
+	BatchSize int
+}
+
+// Report describes what a run sent.
+type Report struct {
+	Packets uint64        // Frames sent
+	Bytes   uint64        // Bytes of the frames sent, without FCS
+	Errors  uint64        // Frames that failed to send
+	Elapsed time.Duration // Time from the first batch to the end of the run
+}
+
+// PPS returns the achieved packet rate, in packets per second.
+func (r Report) PPS() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Packets) / r.Elapsed.Seconds()
+}
+
+// BPS returns the achieved bit rate, in bits per second.
+func (r Report) BPS() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) * 8 / r.Elapsed.Seconds()
+}
+
+// String returns a one-line summary of the report.
+func (r Report) String() string {
+	return fmt.Sprintf("%d packets, %d bytes, %d errors in %v: %.0f pps, %.2f Mbit/s",
+		r.Packets, r.Bytes, r.Errors, r.Elapsed.Round(time.Millisecond), r.PPS(), r.BPS()/1e6)
+}
+
+// Generator sends traffic on a device.
+type Generator struct {
+	dev    ethernet.Device
+	config Config
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// Clock, replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// New creates a generator sending on dev.
+func New(dev ethernet.Device, config Config) (*Generator, error) {
+	if dev == nil {
+		return nil, fmt.Errorf("device is nil")
+	}
+	if config.Rate < 0 || config.Count < 0 || config.Duration < 0 || config.BatchSize < 0 {
+		return nil, fmt.Errorf("rate, count, duration and batch size must not be negative")
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	return &Generator{
+		dev:    dev,
+		config: config,
+		stop:   make(chan struct{}),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}, nil
+}
+
+// Stop ends the current run, and any later one, after its batch in flight.
+func (g *Generator) Stop() {
+	g.stopOnce.Do(func() { close(g.stop) })
+}
+
+// stopped reports whether Stop was called.
+func (g *Generator) stopped() bool {
+	select {
+	case <-g.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run sends frames round-robin at the configured rate until Count packets
+// are sent, Duration passes or Stop is called. With neither Count nor
+// Duration, only Stop ends it.
+func (g *Generator) Run(frames []*ethernet.Frame) (Report, error) {
+	if len(frames) == 0 {
+		return Report{}, fmt.Errorf("no frames to send")
+	}
+
+	var interval time.Duration
+	if g.config.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / g.config.Rate)
+	}
+	return g.send(func(sent int) (*ethernet.Frame, time.Duration, bool) {
+		if g.config.Count > 0 && sent >= g.config.Count {
+			return nil, 0, false
+		}
+		return frames[sent%len(frames)], time.Duration(sent) * interval, true
+	})
+}
+
+// Replay sends captured Ethernet frames with the gaps between their
+// timestamps divided by speed: 1 keeps the original timing, 2 replays twice
+// as fast, and 0 sends as fast as possible. The capture is sent loops
+// times; 0 means once. Count, Duration and Stop end a replay early; Rate
+// is not used.
+func (g *Generator) Replay(packets []*pcap.Packet, speed float64, loops int) (Report, error) {
+	if len(packets) == 0 {
+		return Report{}, fmt.Errorf("no packets to replay")
+	}
+	if speed < 0 || loops < 0 {
+		return Report{}, fmt.Errorf("speed and loops must not be negative")
+	}
+	if loops == 0 {
+		loops = 1
+	}
+
+	frames := make([]*ethernet.Frame, len(packets))
+	for i, pkt := range packets {
+		frame, err := ethernet.Parse(pkt.Data)
+		if err != nil {
+			return Report{}, fmt.Errorf("packet %d: %w", i+1, err)
+		}
+		frames[i] = frame
+	}
+
+	// Each loop starts one original inter-packet gap after the last
+	first := packets[0].Timestamp
+	span := packets[len(packets)-1].Timestamp.Sub(first)
+	if len(packets) > 1 {
+		span += span / time.Duration(len(packets)-1)
+	}
+
+	return g.send(func(sent int) (*ethernet.Frame, time.Duration, bool) {
+		if sent >= loops*len(packets) || (g.config.Count > 0 && sent >= g.config.Count) {
+			return nil, 0, false
+		}
+		loop, i := sent/len(packets), sent%len(packets)
+		if speed == 0 {
+			return frames[i], 0, true
+		}
+		offset := time.Duration(loop)*span + packets[i].Timestamp.Sub(first)
+		return frames[i], time.Duration(float64(offset) / speed), true
+	})
+}
+
+// send sends the frames next returns, each once its offset from the start
+// of the run has passed, in batches of the frames that are due. A frame
+// that fails to send is counted as an error and skipped; the run ends with
+// an error once BatchSize frames in a row have failed.
+func (g *Generator) send(next func(sent int) (frame *ethernet.Frame, offset time.Duration, ok bool)) (Report, error) {
+	var report Report
+	batch := make([]*ethernet.Frame, 0, g.config.BatchSize)
+	start := g.now()
+	sent, failures := 0, 0
+
+	// pending is the next frame, not yet in a batch
+	pending, offset, ok := next(sent)
+	for ok && !g.stopped() {
+		elapsed := g.now().Sub(start)
+		if g.config.Duration > 0 && elapsed >= g.config.Duration {
+			break
+		}
+		if wait := offset - elapsed; wait > 0 {
+			g.sleep(min(wait, maxSleep))
+			continue
+		}
+
+		batch = batch[:0]
+		for ok && len(batch) < cap(batch) && offset <= elapsed {
+			batch = append(batch, pending)
+			sent++
+			pending, offset, ok = next(sent)
+		}
+
+		n, err := ethernet.WriteFrames(g.dev, batch)
+		for _, frame := range batch[:n] {
+			report.Packets++
+			report.Bytes += uint64(frame.Size())
+		}
+		if n > 0 {
+			failures = 0
+		}
+		if err != nil {
+			report.Errors++
+			if failures++; failures >= g.config.BatchSize {
+				report.Elapsed = g.now().Sub(start)
+				return report, fmt.Errorf("failed to send: %w", err)
+			}
+
+			// The frames after the one that failed are sent again
+			if rest := len(batch) - n - 1; rest > 0 {
+				sent -= rest
+				pending, offset, ok = next(sent)
+			}
+		}
+	}
+
+	report.Elapsed = g.now().Sub(start)
+	return report, nil
+}
@@ -0,0 +1,392 @@
+package pktgen
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/pcap"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// fakeDevice records the frames sent to it, and when, on a fake clock.
+type fakeDevice struct {
+	clock   *fakeClock
+	frames  []*ethernet.Frame
+	times   []time.Duration
+	batches int
+	fail    func(n int) bool // Whether sending the nth frame fails
+}
+
+func (d *fakeDevice) Name() string                  { return "fake0" }
+func (d *fakeDevice) MACAddress() common.MACAddress { return common.MACAddress{} }
+func (d *fakeDevice) Index() int                    { return 1 }
+func (d *fakeDevice) ReadFrame() (*ethernet.Frame, error) {
+	return nil, errors.New("not supported")
+}
+func (d *fakeDevice) Close() error { return nil }
+
+func (d *fakeDevice) WriteFrame(frame *ethernet.Frame) error {
+	n, err := d.WriteFrames([]*ethernet.Frame{frame})
+	if n == 0 && err == nil {
+		err = errors.New("not sent")
+	}
+	return err
+}
+
+func (d *fakeDevice) WriteFrames(frames []*ethernet.Frame) (int, error) {
+	d.batches++
+	for i, frame := range frames {
+		attempt := len(d.frames)
+		if d.fail != nil && d.fail(attempt) {
+			return i, errors.New("device busy")
+		}
+		d.frames = append(d.frames, frame)
+		d.times = append(d.times, d.clock.elapsed)
+	}
+	return len(frames), nil
+}
+
+// fakeClock advances only when slept on.
+type fakeClock struct {
+	start   time.Time
+	elapsed time.Duration
+}
+
+func (c *fakeClock) now() time.Time              { return c.start.Add(c.elapsed) }
+func (c *fakeClock) sleep(d time.Duration)       { c.elapsed += d }
+func newFakeClock() *fakeClock                   { return &fakeClock{start: time.Unix(1700000000, 0)} }
+func (d *fakeDevice) sentAt(i int) time.Duration { return d.times[i] }
+
+func testGenerator(t *testing.T, config Config) (*Generator, *fakeDevice) {
+	t.Helper()
+	clock := newFakeClock()
+	dev := &fakeDevice{clock: clock}
+	g, err := New(dev, config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	g.now, g.sleep = clock.now, clock.sleep
+	return g, dev
+}
+
+var testFlow = FlowConfig{
+	SourceMAC:       common.MACAddress{0x02, 0, 0, 0, 0, 1},
+	DestinationMAC:  common.MACAddress{0x02, 0, 0, 0, 0, 2},
+	Source:          common.IPv4Address{10, 0, 0, 1},
+	Destination:     common.IPv4Address{10, 0, 0, 2},
+	SourcePort:      40000,
+	DestinationPort: 9,
+	Flows:           4,
+	PayloadSize:     100,
+}
+
+func TestBuildFrames(t *testing.T) {
+	for _, proto := range []Protocol{ProtocolUDP, ProtocolTCP, ProtocolICMP} {
+		t.Run(proto.String(), func(t *testing.T) {
+			config := testFlow
+			config.Protocol = proto
+			frames, err := BuildFrames(config)
+			if err != nil {
+				t.Fatalf("BuildFrames() error = %v", err)
+			}
+			if len(frames) != config.Flows {
+				t.Fatalf("BuildFrames() = %d frames, want %d", len(frames), config.Flows)
+			}
+
+			for i, frame := range frames {
+				parsed, err := ethernet.Parse(frame.Serialize())
+				if err != nil {
+					t.Fatalf("ethernet.Parse() error = %v", err)
+				}
+				pkt, err := ip.Parse(parsed.Payload)
+				if err != nil {
+					t.Fatalf("ip.Parse() error = %v", err)
+				}
+				if !pkt.VerifyChecksum() || pkt.Source != config.Source || pkt.Destination != config.Destination {
+					t.Fatalf("frame %d: IP packet %s is invalid", i, pkt)
+				}
+
+				port := config.SourcePort + uint16(i)
+				switch proto {
+				case ProtocolUDP:
+					datagram, err := udp.Parse(pkt.Payload)
+					if err != nil || !datagram.VerifyChecksum(pkt.Source, pkt.Destination) {
+						t.Fatalf("frame %d: invalid UDP datagram (%v)", i, err)
+					}
+					if datagram.SourcePort != port || len(datagram.Data) != config.PayloadSize {
+						t.Errorf("frame %d: %s, want source port %d", i, datagram, port)
+					}
+				case ProtocolTCP:
+					seg, err := tcp.Parse(pkt.Payload)
+					if err != nil || !seg.VerifyChecksum(pkt.Source, pkt.Destination) {
+						t.Fatalf("frame %d: invalid TCP segment (%v)", i, err)
+					}
+					if seg.SourcePort != port || len(seg.Data) != config.PayloadSize {
+						t.Errorf("frame %d: %s, want source port %d", i, seg, port)
+					}
+				case ProtocolICMP:
+					msg, err := icmp.Parse(pkt.Payload)
+					if err != nil || !msg.VerifyChecksum() || !msg.IsEchoRequest() {
+						t.Fatalf("frame %d: invalid echo request (%v)", i, err)
+					}
+					if msg.ID != port {
+						t.Errorf("frame %d: ID = %d, want %d", i, msg.ID, port)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBuildFramesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*FlowConfig)
+	}{
+		{"negative payload", func(c *FlowConfig) { c.PayloadSize = -1 }},
+		{"payload too large", func(c *FlowConfig) { c.PayloadSize = 65535 }},
+		{"too many flows", func(c *FlowConfig) { c.Flows = 65537 }},
+		{"unknown protocol", func(c *FlowConfig) { c.Protocol = 7 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testFlow
+			tt.modify(&config)
+			if _, err := BuildFrames(config); err == nil {
+				t.Errorf("BuildFrames() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestRunRate(t *testing.T) {
+	g, dev := testGenerator(t, Config{Rate: 1000, Count: 250, BatchSize: 8})
+	frames, err := BuildFrames(testFlow)
+	if err != nil {
+		t.Fatalf("BuildFrames() error = %v", err)
+	}
+
+	report, err := g.Run(frames)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Packets != 250 || len(dev.frames) != 250 {
+		t.Fatalf("Run() sent %d packets (device got %d), want 250", report.Packets, len(dev.frames))
+	}
+	for i, frame := range dev.frames {
+		if frame != frames[i%len(frames)] {
+			t.Fatalf("frame %d is not flow %d", i, i%len(frames))
+		}
+		if want := time.Duration(i) * time.Millisecond; dev.sentAt(i) != want {
+			t.Fatalf("frame %d sent at %v, want %v", i, dev.sentAt(i), want)
+		}
+	}
+	if want := uint64(250 * frames[0].Size()); report.Bytes != want {
+		t.Errorf("Bytes = %d, want %d", report.Bytes, want)
+	}
+	if pps := report.PPS(); pps < 990 || pps > 1010 {
+		t.Errorf("PPS() = %.0f, want about 1000", pps)
+	}
+}
+
+func TestRunBatchesAtFullSpeed(t *testing.T) {
+	g, dev := testGenerator(t, Config{Count: 100, BatchSize: 16})
+	frames, _ := BuildFrames(testFlow)
+
+	report, err := g.Run(frames)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Packets != 100 {
+		t.Errorf("Packets = %d, want 100", report.Packets)
+	}
+	if dev.batches != 7 {
+		t.Errorf("sent in %d batches, want 7 of at most 16", dev.batches)
+	}
+}
+
+func TestRunDuration(t *testing.T) {
+	g, _ := testGenerator(t, Config{Rate: 100, Duration: time.Second})
+	frames, _ := BuildFrames(testFlow)
+
+	report, err := g.Run(frames)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Packets != 100 || report.Elapsed != time.Second {
+		t.Errorf("Run() = %d packets in %v, want 100 in 1s", report.Packets, report.Elapsed)
+	}
+}
+
+func TestRunSendErrors(t *testing.T) {
+	g, dev := testGenerator(t, Config{Count: 10, BatchSize: 4})
+	failed := false
+	dev.fail = func(n int) bool {
+		if n == 2 && !failed {
+			failed = true
+			return true
+		}
+		return false
+	}
+	frames, _ := BuildFrames(testFlow)
+
+	report, err := g.Run(frames)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Packets != 9 || report.Errors != 1 {
+		t.Errorf("Run() = %d packets, %d errors, want 9 and 1", report.Packets, report.Errors)
+	}
+
+	// A device that never sends ends the run
+	g, dev = testGenerator(t, Config{BatchSize: 4})
+	dev.fail = func(int) bool { return true }
+	if _, err := g.Run(frames); err == nil {
+		t.Errorf("Run() error = nil, want error")
+	}
+}
+
+func TestStop(t *testing.T) {
+	g, _ := testGenerator(t, Config{Rate: 10})
+	frames, _ := BuildFrames(testFlow)
+	g.sleep = func(time.Duration) { g.Stop() }
+
+	report, err := g.Run(frames)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Packets != 1 {
+		t.Errorf("Packets = %d, want 1", report.Packets)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	frames, _ := BuildFrames(testFlow)
+	start := time.Unix(1600000000, 0)
+	offsets := []time.Duration{0, 10 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond}
+	packets := make([]*pcap.Packet, len(offsets))
+	for i, offset := range offsets {
+		packets[i] = &pcap.Packet{Timestamp: start.Add(offset), Data: frames[i].Serialize()}
+	}
+
+	tests := []struct {
+		name  string
+		speed float64
+		loops int
+		want  []time.Duration
+	}{
+		{"original timing", 1, 0, offsets},
+		{"twice as fast", 2, 1, []time.Duration{0, 5 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond}},
+		{"top speed", 0, 1, []time.Duration{0, 0, 0, 0}},
+		{"looped", 1, 2, append(append([]time.Duration(nil), offsets...),
+			80*time.Millisecond, 90*time.Millisecond, 120*time.Millisecond, 140*time.Millisecond)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, dev := testGenerator(t, Config{})
+			report, err := g.Replay(packets, tt.speed, tt.loops)
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			if int(report.Packets) != len(tt.want) {
+				t.Fatalf("Replay() sent %d packets, want %d", report.Packets, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if dev.sentAt(i) != want {
+					t.Errorf("packet %d sent at %v, want %v", i, dev.sentAt(i), want)
+				}
+				if dev.frames[i].Destination != testFlow.DestinationMAC {
+					t.Errorf("packet %d sent to %s, want %s", i, dev.frames[i].Destination, testFlow.DestinationMAC)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	g, _ := testGenerator(t, Config{})
+	if _, err := g.Replay(nil, 1, 1); err == nil {
+		t.Errorf("Replay() of no packets error = nil, want error")
+	}
+	if _, err := g.Replay([]*pcap.Packet{{Data: []byte{1, 2, 3}}}, 1, 1); err == nil {
+		t.Errorf("Replay() of a runt frame error = nil, want error")
+	}
+	if _, err := g.Replay([]*pcap.Packet{{Data: make([]byte, 60)}}, -1, 1); err == nil {
+		t.Errorf("Replay() with a negative speed error = nil, want error")
+	}
+}
+
+func TestParseProtocol(t *testing.T) {
+	for _, p := range []Protocol{ProtocolUDP, ProtocolTCP, ProtocolICMP} {
+		if got, err := ParseProtocol(p.String()); err != nil || got != p {
+			t.Errorf("ParseProtocol(%q) = %v, %v, want %v", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParseProtocol("sctp"); err == nil {
+		t.Errorf("ParseProtocol(\"sctp\") error = nil, want error")
+	}
+}
+
+// BenchmarkGenerator sends 64-byte UDP frames as fast as possible into a
+// veth pair, from a raw socket and from an AF_XDP socket, and reports the
+// packet rate. It needs root.
+func BenchmarkGenerator(b *testing.B) {
+	if os.Geteuid() != 0 {
+		b.Skip("sending on a veth pair requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		b.Skip("creating a veth pair requires the ip command")
+	}
+	name := fmt.Sprintf("pgb%d", os.Getpid()%100000)
+	if out, err := exec.Command("ip", "link", "add", name, "type", "veth", "peer", "name", name+"p").CombinedOutput(); err != nil {
+		b.Fatalf("ip link add: %v: %s", err, out)
+	}
+	defer exec.Command("ip", "link", "del", name).Run()
+	for _, ifname := range []string{name, name + "p"} {
+		exec.Command("ip", "link", "set", ifname, "up").Run()
+	}
+
+	open := map[string]func() (ethernet.Device, error){
+		"AF_PACKET": func() (ethernet.Device, error) { return ethernet.OpenInterface(name) },
+		"AF_XDP": func() (ethernet.Device, error) {
+			return ethernet.OpenXDPInterface(name, ethernet.XDPConfig{})
+		},
+	}
+	for _, backend := range []string{"AF_PACKET", "AF_XDP"} {
+		b.Run(backend, func(b *testing.B) {
+			dev, err := open[backend]()
+			if err != nil {
+				b.Skipf("failed to open %s: %v", backend, err)
+			}
+			defer dev.Close()
+
+			config := testFlow
+			config.SourceMAC = dev.MACAddress()
+			frames, err := BuildFrames(config)
+			if err != nil {
+				b.Fatalf("BuildFrames() error = %v", err)
+			}
+			g, err := New(dev, Config{Count: b.N})
+			if err != nil {
+				b.Fatalf("New() error = %v", err)
+			}
+			b.ResetTimer()
+			report, err := g.Run(frames)
+			if err != nil {
+				b.Fatalf("Run() error = %v", err)
+			}
+			b.ReportMetric(report.PPS()/1e6, "Mpps")
+		})
+	}
+}
@@ -0,0 +1,219 @@
+// Package ports manages the local ports of a transport protocol: which
+// address and port each socket is bound to, and which ephemeral port a
+// socket binding to port 0 gets.
+//
+// Each protocol has its own port space, so TCP and UDP each use a Manager
+// of their own, applying the same rules:
+//
+//   - Bindings overlap when they have the same port and the same address,
+//     or one of them is bound to the zero (wildcard) address. A binding
+//     overlapping another fails with ErrInUse, unless both set ReuseAddr,
+//     as with SO_REUSEADDR.
+//   - Binding to port 0 allocates the next ephemeral port, in turn, that
+//     no binding overlaps and that is not reserved.
+//   - Reserved ports are never allocated, but may be bound explicitly,
+//     like Linux's ip_local_reserved_ports.
+//
+// A socket holds a Binding while it uses its port:
+//
+//	m, err := ports.New(ports.Config{})
+//	b, err := m.Bind(addr, 0, ports.Options{})
+//	defer b.Release()
+//	port := b.Port
+package ports
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+const (
+	// EphemeralStart is the default start of the ephemeral port range
+	// (RFC 6335).
+	EphemeralStart = 49152
+
+	// EphemeralEnd is the default end of the ephemeral port range.
+	EphemeralEnd = 65535
+)
+
+var (
+	// ErrInUse is returned when a binding overlaps an existing one.
+	ErrInUse = errors.New("address already in use")
+
+	// ErrExhausted is returned when no ephemeral port is free.
+	ErrExhausted = errors.New("no ephemeral ports available")
+)
+
+// wildcard is the zero address, which binds every address.
+var wildcard common.IPv4Address
+
+// Config configures a Manager.
+type Config struct {
+	EphemeralStart uint16 // Start of the ephemeral range (default EphemeralStart)
+	EphemeralEnd   uint16 // End of the ephemeral range, inclusive (default EphemeralEnd)
+}
+
+// Options are the options of a binding.
+type Options struct {
+	// ReuseAddr lets the binding overlap others that also set it.
+	ReuseAddr bool
+
+	// Avoid, if set, is asked about each candidate when allocating an
+	// ephemeral port, and passes over those for which it returns true.
+	Avoid func(port uint16) bool
+}
+
+// Binding is a local address and port held by a socket.
+type Binding struct {
+	Addr common.IPv4Address
+	Port uint16
+
+	reuseAddr bool
+	manager   *Manager
+}
+
+// Manager tracks the bindings of one protocol.
+type Manager struct {
+	config Config
+
+	mu       sync.Mutex
+	bindings map[uint16][]*Binding // Port -> bindings on it
+	reserved map[uint16]bool
+	next     uint16 // Next ephemeral port to try
+}
+
+// New creates a port manager.
+func New(config Config) (*Manager, error) {
+	// Apply defaults
+	if config.EphemeralStart == 0 {
+		config.EphemeralStart = EphemeralStart
+	}
+	if config.EphemeralEnd == 0 {
+		config.EphemeralEnd = EphemeralEnd
+	}
+	if config.EphemeralEnd < config.EphemeralStart {
+		return nil, fmt.Errorf("invalid ephemeral range %d-%d", config.EphemeralStart, config.EphemeralEnd)
+	}
+
+	return &Manager{
+		config:   config,
+		bindings: make(map[uint16][]*Binding),
+		reserved: make(map[uint16]bool),
+		next:     config.EphemeralStart,
+	}, nil
+}
+
+// EphemeralRange returns the first and last ephemeral ports.
+func (m *Manager) EphemeralRange() (start, end uint16) {
+	return m.config.EphemeralStart, m.config.EphemeralEnd
+}
+
+// Bind binds addr and port, allocating an ephemeral port if port is 0.
+func (m *Manager) Bind(addr common.IPv4Address, port uint16, opts Options) (*Binding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := &Binding{Addr: addr, Port: port, reuseAddr: opts.ReuseAddr, manager: m}
+	if port == 0 {
+		port, err := m.allocate(b, opts.Avoid)
+		if err != nil {
+			return nil, err
+		}
+		b.Port = port
+	} else if m.conflicts(b, port) {
+		return nil, fmt.Errorf("%s:%d: %w", addr, port, ErrInUse)
+	}
+
+	m.bindings[b.Port] = append(m.bindings[b.Port], b)
+	return b, nil
+}
+
+// InUse reports whether a binding without ReuseAddr to addr and port would
+// fail.
+func (m *Manager) InUse(addr common.IPv4Address, port uint16) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conflicts(&Binding{Addr: addr}, port)
+}
+
+// Reserve excludes ports from ephemeral allocation.
+func (m *Manager) Reserve(ports ...uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, port := range ports {
+		m.reserved[port] = true
+	}
+}
+
+// Unreserve returns reserved ports to ephemeral allocation.
+func (m *Manager) Unreserve(ports ...uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, port := range ports {
+		delete(m.reserved, port)
+	}
+}
+
+// Release gives up the binding. Releasing a nil binding, or one already
+// released, has no effect.
+func (b *Binding) Release() {
+	if b == nil || b.manager == nil {
+		return
+	}
+	m := b.manager
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bindings := m.bindings[b.Port]
+	for i, other := range bindings {
+		if other == b {
+			bindings = append(bindings[:i], bindings[i+1:]...)
+			break
+		}
+	}
+	if len(bindings) == 0 {
+		delete(m.bindings, b.Port)
+	} else {
+		m.bindings[b.Port] = bindings
+	}
+	b.manager = nil
+}
+
+// allocate returns the next ephemeral port b may bind. Must be called with
+// m.mu held.
+func (m *Manager) allocate(b *Binding, avoid func(uint16) bool) (uint16, error) {
+	start := m.next
+	for {
+		port := m.next
+		if m.next == m.config.EphemeralEnd {
+			m.next = m.config.EphemeralStart
+		} else {
+			m.next++
+		}
+
+		if !m.reserved[port] && !m.conflicts(b, port) && (avoid == nil || !avoid(port)) {
+			return port, nil
+		}
+
+		// Check if we've wrapped around
+		if m.next == start {
+			return 0, ErrExhausted
+		}
+	}
+}
+
+// conflicts reports whether binding b to port would overlap a binding it
+// may not share the port with. Must be called with m.mu held.
+func (m *Manager) conflicts(b *Binding, port uint16) bool {
+	for _, other := range m.bindings[port] {
+		overlaps := other.Addr == b.Addr || other.Addr == wildcard || b.Addr == wildcard
+		if overlaps && !(other.reuseAddr && b.reuseAddr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package ports
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+var (
+	addrA        = common.IPv4Address{10, 0, 0, 1}
+	addrB        = common.IPv4Address{10, 0, 0, 2}
+	wildcardAddr = common.IPv4Address{}
+)
+
+func TestBindRules(t *testing.T) {
+	type bind struct {
+		addr  common.IPv4Address
+		reuse bool
+	}
+	tests := []struct {
+		name    string
+		first   bind
+		second  bind
+		wantErr bool
+	}{
+		{"same address", bind{addrA, false}, bind{addrA, false}, true},
+		{"other address", bind{addrA, false}, bind{addrB, false}, false},
+		{"wildcard then specific", bind{wildcardAddr, false}, bind{addrA, false}, true},
+		{"specific then wildcard", bind{addrA, false}, bind{wildcardAddr, false}, true},
+		{"both reuse", bind{addrA, true}, bind{addrA, true}, false},
+		{"both reuse with wildcard", bind{wildcardAddr, true}, bind{addrA, true}, false},
+		{"only second reuses", bind{addrA, false}, bind{addrA, true}, true},
+		{"only first reuses", bind{addrA, true}, bind{wildcardAddr, false}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(Config{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			first, err := m.Bind(tt.first.addr, 80, Options{ReuseAddr: tt.first.reuse})
+			if err != nil {
+				t.Fatalf("Bind() error = %v", err)
+			}
+
+			_, err = m.Bind(tt.second.addr, 80, Options{ReuseAddr: tt.second.reuse})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("second Bind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInUse) {
+				t.Errorf("second Bind() error = %v, want %v", err, ErrInUse)
+			}
+
+			// Once the first binding is released the second always succeeds
+			first.Release()
+			if tt.wantErr {
+				if _, err := m.Bind(tt.second.addr, 80, Options{ReuseAddr: tt.second.reuse}); err != nil {
+					t.Errorf("Bind() after Release() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestEphemeral(t *testing.T) {
+	m, err := New(Config{EphemeralStart: 5000, EphemeralEnd: 5004})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := m.Bind(addrA, 5001, Options{}); err != nil { // In use
+		t.Fatalf("Bind() error = %v", err)
+	}
+	m.Reserve(5002)
+
+	var got []uint16
+	var bindings []*Binding
+	for {
+		b, err := m.Bind(addrA, 0, Options{Avoid: func(port uint16) bool { return port == 5003 }})
+		if errors.Is(err, ErrExhausted) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Bind() error = %v", err)
+		}
+		got = append(got, b.Port)
+		bindings = append(bindings, b)
+	}
+	want := []uint16{5000, 5004}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("allocated %v, want %v", got, want)
+	}
+
+	// Released and unreserved ports are allocated again, in turn
+	bindings[0].Release()
+	bindings[0].Release() // No effect
+	m.Unreserve(5002)
+	for _, want := range []uint16{5000, 5002} {
+		b, err := m.Bind(addrA, 0, Options{})
+		if err != nil || b.Port != want {
+			t.Errorf("Bind() = %v, %v; want port %d", b, err, want)
+		}
+	}
+
+	// Ports on another address are free, except where a wildcard binds
+	if m.InUse(addrB, 5000) {
+		t.Error("InUse() on another address = true")
+	}
+	if !m.InUse(wildcardAddr, 5000) {
+		t.Error("InUse() on the wildcard address = false")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(Config{EphemeralStart: 6000, EphemeralEnd: 5000}); err == nil {
+		t.Error("New() with an inverted range error = nil, want an error")
+	}
+}
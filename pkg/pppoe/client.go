@@ -0,0 +1,915 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+const (
+	// DefaultTimeout is how long a discovery or negotiation step waits
+	// for an answer before retrying.
+	DefaultTimeout = 3 * time.Second
+
+	// DefaultRetries is how many times a step is tried.
+	DefaultRetries = 5
+
+	// DefaultEchoInterval is the interval between LCP echo requests on an
+	// open session.
+	DefaultEchoInterval = 10 * time.Second
+
+	// DefaultEchoFailures is how many echo requests in a row go
+	// unanswered before the session is considered down.
+	DefaultEchoFailures = 3
+
+	// hostUniqSize is the size of the Host-Uniq tag identifying our
+	// discovery packets.
+	hostUniqSize = 8
+
+	// packetQueueSize is how many received IP packets are queued for
+	// ReadPacket; more are dropped.
+	packetQueueSize = 256
+)
+
+var (
+	// ErrNoOffer is returned by Dial when no access concentrator answers.
+	ErrNoOffer = errors.New("no access concentrator offered a session")
+
+	// ErrClosed is returned by a closed session.
+	ErrClosed = errors.New("session closed")
+
+	// errTimeout is returned by await when no matching packet arrives.
+	errTimeout = errors.New("timed out")
+)
+
+// Config configures a PPPoE session.
+type Config struct {
+	ServiceName string // Service requested; empty for any
+	ACName      string // Access concentrator to accept offers from; empty for any
+
+	// Username and Password authenticate with PAP when the access
+	// concentrator asks to. Without them, a link requiring authentication
+	// is refused.
+	Username string
+	Password string
+
+	Timeout time.Duration // Per attempt (0 = DefaultTimeout)
+	Retries int           // Attempts per step (0 = DefaultRetries)
+
+	// EchoInterval is the interval between LCP echo requests checking the
+	// link; 0 means DefaultEchoInterval, and a negative value disables
+	// them. EchoFailures unanswered in a row (0 = DefaultEchoFailures)
+	// bring the session down.
+	EchoInterval time.Duration
+	EchoFailures int
+}
+
+// phase is the stage of the PPP link (RFC 1661 Section 3.2).
+type phase int
+
+const (
+	phaseEstablish phase = iota // LCP negotiation
+	phaseAuthenticate
+	phaseNetwork // IPCP negotiation
+	phaseOpen
+)
+
+// negotiation is the state of one side of LCP or IPCP option negotiation.
+type negotiation struct {
+	protocol  Protocol
+	options   []Option // Of our current Configure-Request
+	id        uint8    // Of our current Configure-Request
+	ackedByUs bool     // We acked the peer's request
+	ackedByIt bool     // The peer acked ours
+}
+
+// opened reports whether both sides acked the other's options.
+func (n *negotiation) opened() bool {
+	return n.ackedByUs && n.ackedByIt
+}
+
+// option returns our requested option of a type.
+func (n *negotiation) option(t uint8) (Option, bool) {
+	for _, o := range n.options {
+		if o.Type == t {
+			return o, true
+		}
+	}
+	return Option{}, false
+}
+
+// set replaces or adds our requested option of a type.
+func (n *negotiation) set(o Option) {
+	for i := range n.options {
+		if n.options[i].Type == o.Type {
+			n.options[i] = o
+			return
+		}
+	}
+	n.options = append(n.options, o)
+}
+
+// remove drops our requested option of a type.
+func (n *negotiation) remove(t uint8) {
+	for i := range n.options {
+		if n.options[i].Type == t {
+			n.options = append(n.options[:i], n.options[i+1:]...)
+			return
+		}
+	}
+}
+
+// Session is an open PPPoE session, carrying IPv4 packets to and from the
+// access concentrator.
+type Session struct {
+	dev      ethernet.Device
+	config   Config
+	hostUniq []byte
+
+	// Set by discovery
+	acMAC  common.MACAddress
+	acName string
+	id     uint16
+
+	// Set by negotiation before Dial returns
+	local common.IPv4Address
+	peer  common.IPv4Address
+	dns   []common.IPv4Address
+	mtu   int
+
+	frames  chan *ethernet.Frame // From the reader
+	packets chan []byte          // IPv4 packets for ReadPacket
+	up      chan struct{}        // Closed once the session is open
+	done    chan struct{}        // Closed when the session ends
+	wg      sync.WaitGroup
+
+	stopOnce  sync.Once
+	closeOnce sync.Once
+	mu        sync.Mutex
+	err       error // Why the session ended
+	readErr   error // Why the reader stopped, before frames is closed
+
+	// State of the run goroutine
+	phase        phase
+	lcp          negotiation
+	ipcp         negotiation
+	magic        uint32
+	peerMRU      int
+	authenticate bool // The peer asked for PAP
+	papID        uint8
+	nextID       uint8
+	attempts     int
+	echoes       int // Unanswered echo requests
+}
+
+// Dial starts a PPPoE session on dev: it discovers an access concentrator,
+// then negotiates the PPP link and an IPv4 address, and returns once IP
+// packets can flow or ctx is done. The session takes over dev and closes it
+// on Close.
+func Dial(ctx context.Context, dev ethernet.Device, config Config) (*Session, error) {
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Retries == 0 {
+		config.Retries = DefaultRetries
+	}
+	if config.EchoInterval == 0 {
+		config.EchoInterval = DefaultEchoInterval
+	}
+	if config.EchoFailures == 0 {
+		config.EchoFailures = DefaultEchoFailures
+	}
+	if config.Timeout < 0 || config.Retries < 0 || config.EchoFailures < 0 {
+		return nil, fmt.Errorf("invalid timeout, retries or echo failures")
+	}
+
+	s := &Session{
+		dev:      dev,
+		config:   config,
+		hostUniq: make([]byte, hostUniqSize),
+		frames:   make(chan *ethernet.Frame, 16),
+		packets:  make(chan []byte, packetQueueSize),
+		up:       make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	var magic [4]byte
+	if _, err := rand.Read(s.hostUniq); err != nil {
+		return nil, fmt.Errorf("failed to generate Host-Uniq: %w", err)
+	}
+	if _, err := rand.Read(magic[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate magic number: %w", err)
+	}
+	s.magic = binary.BigEndian.Uint32(magic[:])
+
+	s.wg.Add(1)
+	go s.read()
+
+	if err := s.discover(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	select {
+	case <-s.up:
+		return s, nil
+	case <-s.done:
+		err := s.Err()
+		s.Close()
+		return nil, fmt.Errorf("PPP negotiation failed: %w", err)
+	case <-ctx.Done():
+		s.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Name returns the name of the session's interface.
+func (s *Session) Name() string {
+	return "pppoe-" + s.dev.Name()
+}
+
+// MTU returns the largest IP packet the session carries.
+func (s *Session) MTU() int {
+	return s.mtu
+}
+
+// LocalAddress returns the IPv4 address assigned to us.
+func (s *Session) LocalAddress() common.IPv4Address {
+	return s.local
+}
+
+// PeerAddress returns the access concentrator's IPv4 address.
+func (s *Session) PeerAddress() common.IPv4Address {
+	return s.peer
+}
+
+// DNS returns the DNS servers the access concentrator assigned, if any.
+func (s *Session) DNS() []common.IPv4Address {
+	return append([]common.IPv4Address(nil), s.dns...)
+}
+
+// SessionID returns the PPPoE session ID.
+func (s *Session) SessionID() uint16 {
+	return s.id
+}
+
+// ACName returns the name of the access concentrator.
+func (s *Session) ACName() string {
+	return s.acName
+}
+
+// ACAddress returns the MAC address of the access concentrator.
+func (s *Session) ACAddress() common.MACAddress {
+	return s.acMAC
+}
+
+// Err returns why the session ended, or nil while it is up.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// ReadPacket blocks until an IPv4 packet is received, or the session ends.
+func (s *Session) ReadPacket() ([]byte, error) {
+	select {
+	case packet := <-s.packets:
+		return packet, nil
+	case <-s.done:
+		return nil, s.Err()
+	}
+}
+
+// WritePacket sends an IPv4 packet to the access concentrator.
+func (s *Session) WritePacket(packet []byte) error {
+	select {
+	case <-s.done:
+		return s.Err()
+	default:
+	}
+	if len(packet) > s.mtu {
+		return fmt.Errorf("packet of %d bytes exceeds MTU %d", len(packet), s.mtu)
+	}
+	return s.sendPPP(ProtocolIPv4, packet)
+}
+
+// Close ends the session with a PADT, and closes the device.
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		if s.id != 0 {
+			s.sendDiscovery(s.acMAC, &Packet{Code: CodePADT, SessionID: s.id})
+		}
+		s.stop(ErrClosed)
+		err = s.dev.Close()
+	})
+	s.wg.Wait()
+	return err
+}
+
+// stop ends the session for err, unless it already ended.
+func (s *Session) stop(err error) {
+	s.stopOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		close(s.done)
+	})
+}
+
+// read passes the PPPoE frames received on the device to the session.
+func (s *Session) read() {
+	defer s.wg.Done()
+	defer close(s.frames)
+	for {
+		frame, err := s.dev.ReadFrame()
+		if err != nil {
+			s.readErr = err
+			return
+		}
+		if frame.EtherType != common.EtherTypePPPoEDiscovery && frame.EtherType != common.EtherTypePPPoESession {
+			continue
+		}
+		select {
+		case s.frames <- frame:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// receive returns the next frame from the reader.
+func (s *Session) receive(frame *ethernet.Frame, ok bool) (*ethernet.Frame, error) {
+	if !ok {
+		return nil, fmt.Errorf("device failed: %w", s.readErr)
+	}
+	return frame, nil
+}
+
+// await returns the next discovery packet for which match is true,
+// discarding others, or errTimeout once the step's timeout passes.
+func (s *Session) await(ctx context.Context, match func(*ethernet.Frame, *Packet) bool) (*ethernet.Frame, *Packet, error) {
+	timer := time.NewTimer(s.config.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case f, ok := <-s.frames:
+			frame, err := s.receive(f, ok)
+			if err != nil {
+				return nil, nil, err
+			}
+			if frame.EtherType != common.EtherTypePPPoEDiscovery {
+				continue
+			}
+			pkt, err := Parse(frame.Payload)
+			if err != nil {
+				continue
+			}
+			if hostUniq, _ := pkt.Tag(TagHostUniq); !bytes.Equal(hostUniq, s.hostUniq) {
+				continue
+			}
+			if match(frame, pkt) {
+				return frame, pkt, nil
+			}
+		case <-timer.C:
+			return nil, nil, errTimeout
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// discover runs the discovery stage: it broadcasts a PADI, takes the
+// first acceptable PADO, and requests a session from its sender.
+func (s *Session) discover(ctx context.Context) error {
+	service := Tag{Type: TagServiceName, Value: []byte(s.config.ServiceName)}
+	hostUniq := Tag{Type: TagHostUniq, Value: s.hostUniq}
+
+	padi := &Packet{Code: CodePADI, Tags: []Tag{service, hostUniq}}
+	var offer *Packet
+	for attempt := 0; offer == nil; attempt++ {
+		if attempt == s.config.Retries {
+			return ErrNoOffer
+		}
+		if err := s.sendDiscovery(common.BroadcastMAC, padi); err != nil {
+			return err
+		}
+		frame, pkt, err := s.await(ctx, func(frame *ethernet.Frame, pkt *Packet) bool {
+			if pkt.Code != CodePADO || pkt.Error() != nil {
+				return false
+			}
+			name, _ := pkt.Tag(TagACName)
+			return s.config.ACName == "" || string(name) == s.config.ACName
+		})
+		if err == errTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		offer = pkt
+		s.acMAC = frame.Source
+	}
+	name, _ := offer.Tag(TagACName)
+	s.acName = string(name)
+
+	// The request echoes the cookie and relay ID of the offer
+	padr := &Packet{Code: CodePADR, Tags: []Tag{service, hostUniq}}
+	for _, t := range []TagType{TagACCookie, TagRelaySessionID} {
+		if value, ok := offer.Tag(t); ok {
+			padr.Tags = append(padr.Tags, Tag{Type: t, Value: value})
+		}
+	}
+	for attempt := 0; attempt < s.config.Retries; attempt++ {
+		if err := s.sendDiscovery(s.acMAC, padr); err != nil {
+			return err
+		}
+		_, pads, err := s.await(ctx, func(frame *ethernet.Frame, pkt *Packet) bool {
+			return pkt.Code == CodePADS && frame.Source == s.acMAC
+		})
+		if err == errTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := pads.Error(); err != nil {
+			return fmt.Errorf("session refused: %w", err)
+		}
+		if pads.SessionID == 0 {
+			return fmt.Errorf("session refused")
+		}
+		s.id = pads.SessionID
+		return nil
+	}
+	return fmt.Errorf("no session confirmation from %s", s.acMAC)
+}
+
+// run negotiates the PPP link, then keeps it up until the session ends.
+func (s *Session) run() {
+	defer s.wg.Done()
+
+	s.lcp = negotiation{protocol: ProtocolLCP, options: []Option{
+		uint16Option(lcpMRU, MTU),
+		uint32Option(lcpMagicNumber, s.magic),
+	}}
+	s.ipcp = negotiation{protocol: ProtocolIPCP, options: []Option{
+		{Type: ipcpIPAddress, Value: make([]byte, 4)},
+		{Type: ipcpPrimaryDNS, Value: make([]byte, 4)},
+		{Type: ipcpSecondaryDNS, Value: make([]byte, 4)},
+	}}
+	s.peerMRU = MTU
+	if err := s.sendConfigureRequest(&s.lcp); err != nil {
+		s.stop(err)
+		return
+	}
+
+	timer := time.NewTimer(s.config.Timeout)
+	defer timer.Stop()
+	var echo <-chan time.Time
+	for {
+		select {
+		case f, ok := <-s.frames:
+			frame, err := s.receive(f, ok)
+			if err == nil {
+				err = s.handleFrame(frame)
+			}
+			if err == nil {
+				err = s.advance()
+			}
+			if err != nil {
+				s.stop(err)
+				return
+			}
+			if s.phase == phaseOpen && echo == nil && s.config.EchoInterval > 0 {
+				ticker := time.NewTicker(s.config.EchoInterval)
+				defer ticker.Stop()
+				echo = ticker.C
+			}
+
+		case <-timer.C:
+			if s.phase == phaseOpen {
+				continue
+			}
+			if err := s.retry(); err != nil {
+				s.stop(err)
+				return
+			}
+			timer.Reset(s.config.Timeout)
+
+		case <-echo:
+			if s.echoes >= s.config.EchoFailures {
+				s.stop(fmt.Errorf("no reply to %d LCP echo requests", s.echoes))
+				return
+			}
+			s.echoes++
+			s.nextID++
+			s.sendControl(ProtocolLCP, &ControlPacket{Code: EchoRequest, ID: s.nextID, Data: s.magicData()})
+
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// advance moves to the next phase once the current one is complete.
+func (s *Session) advance() error {
+	if s.phase == phaseEstablish && s.lcp.opened() {
+		s.attempts = 0
+		if s.authenticate {
+			s.phase = phaseAuthenticate
+			return s.sendAuthenticate()
+		}
+		s.phase = phaseNetwork
+		return s.sendConfigureRequest(&s.ipcp)
+	}
+	if s.phase == phaseNetwork && s.ipcp.opened() {
+		o, _ := s.ipcp.option(ipcpIPAddress)
+		s.local = common.IPv4Address(o.Value)
+		if s.local == (common.IPv4Address{}) {
+			return fmt.Errorf("no IPv4 address assigned")
+		}
+		for _, t := range []uint8{ipcpPrimaryDNS, ipcpSecondaryDNS} {
+			if o, ok := s.ipcp.option(t); ok && common.IPv4Address(o.Value) != (common.IPv4Address{}) {
+				s.dns = append(s.dns, common.IPv4Address(o.Value))
+			}
+		}
+		s.mtu = min(s.peerMRU, MTU)
+		s.phase = phaseOpen
+		close(s.up)
+	}
+	return nil
+}
+
+// retry resends the request of the current phase, or fails the session
+// once it has been tried Retries times.
+func (s *Session) retry() error {
+	s.attempts++
+	switch s.phase {
+	case phaseEstablish:
+		if s.attempts >= s.config.Retries {
+			return fmt.Errorf("LCP negotiation timed out")
+		}
+		if !s.lcp.ackedByIt {
+			return s.sendConfigureRequest(&s.lcp)
+		}
+	case phaseAuthenticate:
+		if s.attempts >= s.config.Retries {
+			return fmt.Errorf("PAP authentication timed out")
+		}
+		return s.sendAuthenticate()
+	case phaseNetwork:
+		if s.attempts >= s.config.Retries {
+			return fmt.Errorf("IPCP negotiation timed out")
+		}
+		if !s.ipcp.ackedByIt {
+			return s.sendConfigureRequest(&s.ipcp)
+		}
+	}
+	return nil
+}
+
+// handleFrame processes a frame received during the session.
+func (s *Session) handleFrame(frame *ethernet.Frame) error {
+	if frame.Source != s.acMAC {
+		return nil
+	}
+	pkt, err := Parse(frame.Payload)
+	if err != nil || pkt.SessionID != s.id {
+		return nil
+	}
+	if frame.EtherType == common.EtherTypePPPoEDiscovery {
+		if pkt.Code == CodePADT {
+			return fmt.Errorf("terminated by access concentrator")
+		}
+		return nil
+	}
+	if pkt.Code != CodeSession || len(pkt.Payload) < 2 {
+		return nil
+	}
+
+	protocol := Protocol(binary.BigEndian.Uint16(pkt.Payload))
+	info := pkt.Payload[2:]
+	if protocol == ProtocolIPv4 {
+		if s.phase == phaseOpen {
+			select {
+			case s.packets <- append([]byte(nil), info...):
+			default: // Queue full
+			}
+		}
+		return nil
+	}
+
+	c, err := ParseControl(info)
+	switch {
+	case protocol == ProtocolLCP:
+		if err != nil {
+			return nil
+		}
+		return s.handleLCP(c)
+	case s.phase == phaseEstablish:
+		return nil // Other protocols wait for the link (RFC 1661 Section 3.4)
+	case protocol == ProtocolPAP:
+		if err != nil {
+			return nil
+		}
+		return s.handlePAP(c)
+	case protocol == ProtocolIPCP:
+		if err != nil {
+			return nil
+		}
+		return s.handleIPCP(c)
+	default:
+		s.nextID++
+		return s.sendControl(ProtocolLCP, &ControlPacket{
+			Code: ProtocolReject,
+			ID:   s.nextID,
+			Data: pkt.Payload,
+		})
+	}
+}
+
+// handleLCP processes an LCP packet.
+func (s *Session) handleLCP(c *ControlPacket) error {
+	switch c.Code {
+	case ConfigureRequest:
+		options, err := ParseOptions(c.Data)
+		if err != nil {
+			return nil
+		}
+		if s.phase != phaseEstablish {
+			return fmt.Errorf("peer restarted LCP negotiation")
+		}
+		reply := s.reviewLCP(options)
+		reply.ID = c.ID
+		s.lcp.ackedByUs = reply.Code == ConfigureAck
+		return s.sendControl(ProtocolLCP, reply)
+
+	case ConfigureAck, ConfigureNak, ConfigureReject:
+		if s.phase != phaseEstablish || c.ID != s.lcp.id {
+			return nil
+		}
+		options, err := ParseOptions(c.Data)
+		if err != nil {
+			return nil
+		}
+		switch c.Code {
+		case ConfigureAck:
+			s.lcp.ackedByIt = true
+			return nil
+		case ConfigureNak:
+			for _, o := range options {
+				switch {
+				case o.Type == lcpMRU && len(o.Value) == 2 && int(binary.BigEndian.Uint16(o.Value)) <= MTU:
+					s.lcp.set(o)
+				case o.Type == lcpMagicNumber:
+					var magic [4]byte
+					rand.Read(magic[:])
+					s.magic = binary.BigEndian.Uint32(magic[:])
+					s.lcp.set(uint32Option(lcpMagicNumber, s.magic))
+				}
+			}
+		case ConfigureReject:
+			for _, o := range options {
+				s.lcp.remove(o.Type)
+				if o.Type == lcpMagicNumber {
+					s.magic = 0
+				}
+			}
+		}
+		return s.sendConfigureRequest(&s.lcp)
+
+	case TerminateRequest:
+		s.sendControl(ProtocolLCP, &ControlPacket{Code: TerminateAck, ID: c.ID})
+		return fmt.Errorf("terminated by peer")
+
+	case EchoRequest:
+		if s.phase == phaseEstablish {
+			return nil
+		}
+		return s.sendControl(ProtocolLCP, &ControlPacket{Code: EchoReply, ID: c.ID, Data: s.magicData()})
+
+	case EchoReply:
+		s.echoes = 0
+		return nil
+
+	case ProtocolReject:
+		if len(c.Data) >= 2 && Protocol(binary.BigEndian.Uint16(c.Data)) == ProtocolIPCP {
+			return fmt.Errorf("peer rejected IPCP")
+		}
+		return nil
+
+	case TerminateAck, CodeReject, DiscardRequest:
+		return nil
+
+	default:
+		s.nextID++
+		return s.sendControl(ProtocolLCP, &ControlPacket{Code: CodeReject, ID: s.nextID, Data: c.Serialize()})
+	}
+}
+
+// reviewLCP returns our answer to the peer's LCP Configure-Request.
+func (s *Session) reviewLCP(options []Option) *ControlPacket {
+	var naks, rejects []Option
+	mru, authenticate := 1500, false // RFC 1661 defaults
+	for _, o := range options {
+		switch o.Type {
+		case lcpMRU:
+			if len(o.Value) != 2 {
+				rejects = append(rejects, o)
+				continue
+			}
+			mru = int(binary.BigEndian.Uint16(o.Value))
+		case lcpAuthProtocol:
+			switch {
+			case len(o.Value) < 2 || s.config.Username == "":
+				rejects = append(rejects, o)
+			case Protocol(binary.BigEndian.Uint16(o.Value)) != ProtocolPAP:
+				naks = append(naks, uint16Option(lcpAuthProtocol, uint16(ProtocolPAP)))
+			default:
+				authenticate = true
+			}
+		case lcpMagicNumber:
+			if len(o.Value) != 4 {
+				rejects = append(rejects, o)
+				continue
+			}
+			if magic := binary.BigEndian.Uint32(o.Value); magic != 0 && magic == s.magic {
+				// A looped-back link sees its own number (RFC 1661
+				// Section 6.4)
+				naks = append(naks, uint32Option(lcpMagicNumber, ^magic))
+			}
+		default:
+			rejects = append(rejects, o)
+		}
+	}
+	switch {
+	case len(rejects) > 0:
+		return &ControlPacket{Code: ConfigureReject, Data: serializeOptions(rejects)}
+	case len(naks) > 0:
+		return &ControlPacket{Code: ConfigureNak, Data: serializeOptions(naks)}
+	}
+	s.peerMRU, s.authenticate = mru, authenticate
+	return &ControlPacket{Code: ConfigureAck, Data: serializeOptions(options)}
+}
+
+// handlePAP processes a PAP packet.
+func (s *Session) handlePAP(c *ControlPacket) error {
+	if s.phase != phaseAuthenticate || c.ID != s.papID {
+		return nil
+	}
+	switch c.Code {
+	case PAPAuthenticateAck:
+		s.phase = phaseNetwork
+		s.attempts = 0
+		return s.sendConfigureRequest(&s.ipcp)
+	case PAPAuthenticateNak:
+		if len(c.Data) > 0 && int(c.Data[0]) < len(c.Data) {
+			return fmt.Errorf("authentication failed: %q", c.Data[1:1+int(c.Data[0])])
+		}
+		return fmt.Errorf("authentication failed")
+	}
+	return nil
+}
+
+// handleIPCP processes an IPCP packet.
+func (s *Session) handleIPCP(c *ControlPacket) error {
+	switch c.Code {
+	case ConfigureRequest:
+		options, err := ParseOptions(c.Data)
+		if err != nil {
+			return nil
+		}
+		if s.phase == phaseOpen {
+			return fmt.Errorf("peer restarted IPCP negotiation")
+		}
+		reply := s.reviewIPCP(options)
+		reply.ID = c.ID
+		s.ipcp.ackedByUs = reply.Code == ConfigureAck
+		return s.sendControl(ProtocolIPCP, reply)
+
+	case ConfigureAck, ConfigureNak, ConfigureReject:
+		if s.phase != phaseNetwork || c.ID != s.ipcp.id {
+			return nil
+		}
+		options, err := ParseOptions(c.Data)
+		if err != nil {
+			return nil
+		}
+		switch c.Code {
+		case ConfigureAck:
+			s.ipcp.ackedByIt = true
+			return nil
+		case ConfigureNak:
+			// Naks carry the values the peer assigns us
+			for _, o := range options {
+				switch o.Type {
+				case ipcpIPAddress, ipcpPrimaryDNS, ipcpSecondaryDNS:
+					if len(o.Value) == 4 {
+						s.ipcp.set(Option{Type: o.Type, Value: append([]byte(nil), o.Value...)})
+					}
+				}
+			}
+		case ConfigureReject:
+			for _, o := range options {
+				if o.Type == ipcpIPAddress {
+					return fmt.Errorf("peer rejected IP address negotiation")
+				}
+				s.ipcp.remove(o.Type)
+			}
+		}
+		return s.sendConfigureRequest(&s.ipcp)
+
+	case TerminateRequest:
+		s.sendControl(ProtocolIPCP, &ControlPacket{Code: TerminateAck, ID: c.ID})
+		return fmt.Errorf("IPCP terminated by peer")
+
+	case TerminateAck, CodeReject:
+		return nil
+
+	default:
+		s.nextID++
+		return s.sendControl(ProtocolIPCP, &ControlPacket{Code: CodeReject, ID: s.nextID, Data: c.Serialize()})
+	}
+}
+
+// reviewIPCP returns our answer to the peer's IPCP Configure-Request. Only
+// its own address is accepted.
+func (s *Session) reviewIPCP(options []Option) *ControlPacket {
+	var rejects []Option
+	var peer common.IPv4Address
+	for _, o := range options {
+		if o.Type == ipcpIPAddress && len(o.Value) == 4 {
+			peer = common.IPv4Address(o.Value)
+			continue
+		}
+		rejects = append(rejects, o)
+	}
+	if len(rejects) > 0 {
+		return &ControlPacket{Code: ConfigureReject, Data: serializeOptions(rejects)}
+	}
+	s.peer = peer
+	return &ControlPacket{Code: ConfigureAck, Data: serializeOptions(options)}
+}
+
+// sendConfigureRequest sends our options of a negotiation with a new ID.
+func (s *Session) sendConfigureRequest(n *negotiation) error {
+	s.nextID++
+	n.id = s.nextID
+	n.ackedByIt = false
+	return s.sendControl(n.protocol, &ControlPacket{Code: ConfigureRequest, ID: n.id, Data: serializeOptions(n.options)})
+}
+
+// sendAuthenticate sends a PAP Authenticate-Request.
+func (s *Session) sendAuthenticate() error {
+	s.nextID++
+	s.papID = s.nextID
+	data := append([]byte{uint8(len(s.config.Username))}, s.config.Username...)
+	data = append(data, uint8(len(s.config.Password)))
+	data = append(data, s.config.Password...)
+	return s.sendControl(ProtocolPAP, &ControlPacket{Code: PAPAuthenticateRequest, ID: s.papID, Data: data})
+}
+
+// magicData returns the data of an echo packet: our magic number.
+func (s *Session) magicData() []byte {
+	return binary.BigEndian.AppendUint32(nil, s.magic)
+}
+
+// sendControl sends a control packet.
+func (s *Session) sendControl(protocol Protocol, c *ControlPacket) error {
+	return s.sendPPP(protocol, c.Serialize())
+}
+
+// sendPPP sends a PPP frame in the session.
+func (s *Session) sendPPP(protocol Protocol, info []byte) error {
+	payload, err := (&Packet{Code: CodeSession, SessionID: s.id, Payload: pppFrame(protocol, info)}).Serialize()
+	if err != nil {
+		return err
+	}
+	if err := s.dev.WriteFrame(ethernet.NewFrame(s.acMAC, s.dev.MACAddress(), common.EtherTypePPPoESession, payload)); err != nil {
+		return fmt.Errorf("failed to send %s frame: %w", protocol, err)
+	}
+	return nil
+}
+
+// sendDiscovery sends a discovery packet.
+func (s *Session) sendDiscovery(dst common.MACAddress, pkt *Packet) error {
+	payload, err := pkt.Serialize()
+	if err != nil {
+		return err
+	}
+	if err := s.dev.WriteFrame(ethernet.NewFrame(dst, s.dev.MACAddress(), common.EtherTypePPPoEDiscovery, payload)); err != nil {
+		return fmt.Errorf("failed to send %s: %w", pkt.Code, err)
+	}
+	return nil
+}
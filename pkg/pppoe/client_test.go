@@ -0,0 +1,355 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+)
+
+var (
+	testAddress = common.IPv4Address{100, 64, 0, 99}
+	testPeer    = common.IPv4Address{100, 64, 0, 1}
+	testDNS1    = common.IPv4Address{192, 0, 2, 53}
+	testDNS2    = common.IPv4Address{192, 0, 2, 54}
+)
+
+// fakeAC is an access concentrator serving one session: it answers
+// discovery, requests PAP if it has credentials, and assigns testAddress
+// and the test DNS servers with IPCP Naks.
+type fakeAC struct {
+	dev       *memory.Endpoint
+	sessionID uint16
+	username  string
+	password  string
+	noEcho    bool // Leave echo requests unanswered
+
+	client  common.MACAddress
+	packets chan []byte // IPv4 packets received
+	done    chan struct{}
+}
+
+func newFakeAC(dev *memory.Endpoint) *fakeAC {
+	return &fakeAC{
+		dev:       dev,
+		sessionID: 0x2a,
+		username:  "user",
+		password:  "secret",
+		packets:   make(chan []byte, 16),
+		done:      make(chan struct{}),
+	}
+}
+
+func (ac *fakeAC) run() {
+	defer close(ac.done)
+	for {
+		frame, err := ac.dev.ReadFrame()
+		if err != nil {
+			return
+		}
+		pkt, err := Parse(frame.Payload)
+		if err != nil {
+			continue
+		}
+		if frame.EtherType == common.EtherTypePPPoEDiscovery {
+			ac.discovery(frame.Source, pkt)
+			continue
+		}
+		if pkt.SessionID != ac.sessionID || len(pkt.Payload) < 2 {
+			continue
+		}
+		protocol := Protocol(binary.BigEndian.Uint16(pkt.Payload))
+		if protocol == ProtocolIPv4 {
+			ac.packets <- append([]byte(nil), pkt.Payload[2:]...)
+			continue
+		}
+		c, err := ParseControl(pkt.Payload[2:])
+		if err != nil {
+			continue
+		}
+		ac.control(protocol, c)
+	}
+}
+
+func (ac *fakeAC) discovery(src common.MACAddress, pkt *Packet) {
+	hostUniq, _ := pkt.Tag(TagHostUniq)
+	switch pkt.Code {
+	case CodePADI:
+		ac.sendDiscovery(src, &Packet{Code: CodePADO, Tags: []Tag{
+			{Type: TagServiceName},
+			{Type: TagACName, Value: []byte("fake-bras")},
+			{Type: TagACCookie, Value: []byte("cookie")},
+			{Type: TagHostUniq, Value: hostUniq},
+		}})
+	case CodePADR:
+		if cookie, _ := pkt.Tag(TagACCookie); string(cookie) != "cookie" {
+			return
+		}
+		ac.client = src
+		ac.sendDiscovery(src, &Packet{Code: CodePADS, SessionID: ac.sessionID, Tags: []Tag{
+			{Type: TagServiceName},
+			{Type: TagHostUniq, Value: hostUniq},
+		}})
+		options := []Option{uint16Option(lcpMRU, 1480), uint32Option(lcpMagicNumber, 0x11223344)}
+		if ac.username != "" {
+			options = append(options, uint16Option(lcpAuthProtocol, uint16(ProtocolPAP)))
+		}
+		ac.send(ProtocolLCP, &ControlPacket{Code: ConfigureRequest, ID: 1, Data: serializeOptions(options)})
+	}
+}
+
+func (ac *fakeAC) control(protocol Protocol, c *ControlPacket) {
+	switch {
+	case protocol == ProtocolLCP && c.Code == ConfigureRequest:
+		ac.send(ProtocolLCP, &ControlPacket{Code: ConfigureAck, ID: c.ID, Data: c.Data})
+	case protocol == ProtocolLCP && c.Code == ConfigureAck && ac.username == "":
+		ac.sendIPCPRequest()
+	case protocol == ProtocolLCP && c.Code == EchoRequest && !ac.noEcho:
+		ac.send(ProtocolLCP, &ControlPacket{Code: EchoReply, ID: c.ID, Data: []byte{0x11, 0x22, 0x33, 0x44}})
+
+	case protocol == ProtocolPAP && c.Code == PAPAuthenticateRequest:
+		want := append([]byte{uint8(len(ac.username))}, ac.username...)
+		want = append(want, uint8(len(ac.password)))
+		want = append(want, ac.password...)
+		if !bytes.Equal(c.Data, want) {
+			ac.send(ProtocolPAP, &ControlPacket{Code: PAPAuthenticateNak, ID: c.ID, Data: append([]byte{3}, "bad"...)})
+			return
+		}
+		ac.send(ProtocolPAP, &ControlPacket{Code: PAPAuthenticateAck, ID: c.ID, Data: []byte{0}})
+		ac.sendIPCPRequest()
+
+	case protocol == ProtocolIPCP && c.Code == ConfigureRequest:
+		options, _ := ParseOptions(c.Data)
+		for _, o := range options {
+			if o.Type == ipcpIPAddress && common.IPv4Address(o.Value) == (common.IPv4Address{}) {
+				ac.send(ProtocolIPCP, &ControlPacket{Code: ConfigureNak, ID: c.ID, Data: serializeOptions([]Option{
+					{Type: ipcpIPAddress, Value: testAddress[:]},
+					{Type: ipcpPrimaryDNS, Value: testDNS1[:]},
+					{Type: ipcpSecondaryDNS, Value: testDNS2[:]},
+				})})
+				return
+			}
+		}
+		ac.send(ProtocolIPCP, &ControlPacket{Code: ConfigureAck, ID: c.ID, Data: c.Data})
+	}
+}
+
+func (ac *fakeAC) sendIPCPRequest() {
+	ac.send(ProtocolIPCP, &ControlPacket{Code: ConfigureRequest, ID: 1, Data: serializeOptions([]Option{
+		{Type: ipcpIPAddress, Value: testPeer[:]},
+	})})
+}
+
+func (ac *fakeAC) send(protocol Protocol, c *ControlPacket) {
+	ac.sendPPP(protocol, c.Serialize())
+}
+
+func (ac *fakeAC) sendPPP(protocol Protocol, info []byte) {
+	payload, _ := (&Packet{Code: CodeSession, SessionID: ac.sessionID, Payload: pppFrame(protocol, info)}).Serialize()
+	ac.dev.WriteFrame(ethernet.NewFrame(ac.client, ac.dev.MACAddress(), common.EtherTypePPPoESession, payload))
+}
+
+func (ac *fakeAC) sendDiscovery(dst common.MACAddress, pkt *Packet) {
+	payload, _ := pkt.Serialize()
+	ac.dev.WriteFrame(ethernet.NewFrame(dst, ac.dev.MACAddress(), common.EtherTypePPPoEDiscovery, payload))
+}
+
+// recorder is a device remembering the last frame it sent.
+type recorder struct {
+	*memory.Endpoint
+
+	mu   sync.Mutex
+	last *ethernet.Frame
+}
+
+func (r *recorder) WriteFrame(frame *ethernet.Frame) error {
+	r.mu.Lock()
+	r.last = frame
+	r.mu.Unlock()
+	return r.Endpoint.WriteFrame(frame)
+}
+
+// dial starts a session with ac.
+func dial(t *testing.T, ac *fakeAC, client ethernet.Device, config Config) (*Session, error) {
+	t.Helper()
+	go ac.run()
+	t.Cleanup(func() {
+		ac.dev.Close()
+		<-ac.done
+	})
+	if config.Timeout == 0 {
+		config.Timeout = 200 * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return Dial(ctx, client, config)
+}
+
+func TestDial(t *testing.T) {
+	client, server := memory.NewPipe(memory.Config{})
+	ac := newFakeAC(server)
+	s, err := dial(t, ac, client, Config{Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer s.Close()
+
+	if s.SessionID() != ac.sessionID {
+		t.Errorf("SessionID() = %d, want %d", s.SessionID(), ac.sessionID)
+	}
+	if s.ACName() != "fake-bras" {
+		t.Errorf("ACName() = %q, want fake-bras", s.ACName())
+	}
+	if s.ACAddress() != server.MACAddress() {
+		t.Errorf("ACAddress() = %s, want %s", s.ACAddress(), server.MACAddress())
+	}
+	if s.LocalAddress() != testAddress {
+		t.Errorf("LocalAddress() = %s, want %s", s.LocalAddress(), testAddress)
+	}
+	if s.PeerAddress() != testPeer {
+		t.Errorf("PeerAddress() = %s, want %s", s.PeerAddress(), testPeer)
+	}
+	if dns := s.DNS(); len(dns) != 2 || dns[0] != testDNS1 || dns[1] != testDNS2 {
+		t.Errorf("DNS() = %v, want [%s %s]", dns, testDNS1, testDNS2)
+	}
+	if s.MTU() != 1480 {
+		t.Errorf("MTU() = %d, want the peer's MRU 1480", s.MTU())
+	}
+	if s.Name() != "pppoe-mem0" {
+		t.Errorf("Name() = %q, want pppoe-mem0", s.Name())
+	}
+
+	packet := []byte{0x45, 0, 0, 20, 1, 2, 3, 4}
+	if err := s.WritePacket(packet); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	select {
+	case got := <-ac.packets:
+		if !bytes.Equal(got, packet) {
+			t.Errorf("access concentrator received % x, want % x", got, packet)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("access concentrator received no packet")
+	}
+	if err := s.WritePacket(make([]byte, 1481)); err == nil {
+		t.Error("WritePacket() larger than the MTU succeeded")
+	}
+
+	ac.sendPPP(ProtocolIPv4, packet)
+	got, err := s.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket() error = %v", err)
+	}
+	if !bytes.Equal(got, packet) {
+		t.Errorf("ReadPacket() = % x, want % x", got, packet)
+	}
+
+	// A PADT from the access concentrator ends the session
+	ac.sendDiscovery(client.MACAddress(), &Packet{Code: CodePADT, SessionID: ac.sessionID})
+	if _, err := s.ReadPacket(); err == nil || !strings.Contains(err.Error(), "terminated") {
+		t.Errorf("ReadPacket() after PADT error = %v, want terminated", err)
+	}
+	if err := s.WritePacket(packet); err == nil {
+		t.Error("WritePacket() after PADT succeeded")
+	}
+}
+
+func TestDialWithoutAuthentication(t *testing.T) {
+	client, server := memory.NewPipe(memory.Config{})
+	ac := newFakeAC(server)
+	ac.username = ""
+	s, err := dial(t, ac, client, Config{})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer s.Close()
+	if s.LocalAddress() != testAddress {
+		t.Errorf("LocalAddress() = %s, want %s", s.LocalAddress(), testAddress)
+	}
+}
+
+func TestDialAuthenticationFailure(t *testing.T) {
+	client, server := memory.NewPipe(memory.Config{})
+	ac := newFakeAC(server)
+	_, err := dial(t, ac, client, Config{Username: "user", Password: "wrong"})
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("Dial() error = %v, want authentication failure", err)
+	}
+}
+
+func TestDialNoOffer(t *testing.T) {
+	client, server := memory.NewPipe(memory.Config{})
+	defer server.Close()
+	_, err := Dial(context.Background(), client, Config{Timeout: 10 * time.Millisecond, Retries: 2})
+	if !errors.Is(err, ErrNoOffer) {
+		t.Errorf("Dial() error = %v, want %v", err, ErrNoOffer)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := memory.NewPipe(memory.Config{})
+	ac := newFakeAC(server)
+	dev := &recorder{Endpoint: client}
+	s, err := dial(t, ac, dev, Config{Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	dev.mu.Lock()
+	last := dev.last
+	dev.mu.Unlock()
+	pkt, err := Parse(last.Payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if last.EtherType != common.EtherTypePPPoEDiscovery || pkt.Code != CodePADT || pkt.SessionID != ac.sessionID {
+		t.Errorf("last frame = %s %s session %d, want PADT for session %d", last.EtherType, pkt.Code, pkt.SessionID, ac.sessionID)
+	}
+	if _, err := s.ReadPacket(); !errors.Is(err, ErrClosed) {
+		t.Errorf("ReadPacket() after Close error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestSessionEcho(t *testing.T) {
+	tests := []struct {
+		name   string
+		noEcho bool
+		wantUp bool
+	}{
+		{"answered", false, true},
+		{"unanswered", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := memory.NewPipe(memory.Config{})
+			ac := newFakeAC(server)
+			ac.noEcho = tt.noEcho
+			s, err := dial(t, ac, client, Config{
+				Username:     "user",
+				Password:     "secret",
+				EchoInterval: 10 * time.Millisecond,
+				EchoFailures: 2,
+			})
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer s.Close()
+
+			time.Sleep(100 * time.Millisecond)
+			if err := s.Err(); (err == nil) != tt.wantUp {
+				t.Errorf("Err() = %v, want up = %v", err, tt.wantUp)
+			}
+		})
+	}
+}
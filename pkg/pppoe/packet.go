@@ -0,0 +1,206 @@
+// Package pppoe implements a PPP over Ethernet client (RFC 2516), as used
+// on DSL access networks.
+//
+// Dial finds an access concentrator with the PPPoE discovery stage
+// (PADI/PADO/PADR/PADS), then negotiates the PPP link with LCP (RFC
+// 1661), authenticates with PAP (RFC 1334) if the concentrator asks to,
+// and obtains an IPv4 address and DNS servers with IPCP (RFC 1332, RFC
+// 1877). The resulting Session reads and writes IPv4 packets, like a TUN
+// device.
+package pppoe
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// PPPoE packet format (RFC 2516 Section 4), the payload of frames of
+// EtherType 0x8863 (discovery) and 0x8864 (session):
+// +-------+-------+---------------+-------------------------------+
+// | Ver(4)|Type(4)| Code (8)      | Session ID (16)               |
+// +-------+-------+---------------+-------------------------------+
+// | Length (16)                   | Payload (Length bytes)        |
+// +-------------------------------+-------------------------------+
+//
+// Discovery payloads are tags: type (16), length (16) and value. Session
+// payloads are PPP frames.
+
+const (
+	// HeaderSize is the size of the PPPoE header (6 bytes).
+	HeaderSize = 6
+
+	// MTU is the largest IP packet a session carries over an Ethernet
+	// link: its MTU less the PPPoE and PPP headers (RFC 2516 Section 7).
+	MTU = ethernet.MaxPayloadSize - HeaderSize - 2
+
+	// maxPayload is the largest PPPoE payload.
+	maxPayload = ethernet.MaxPayloadSize - HeaderSize
+
+	// versionType is the version and type of every PPPoE packet.
+	versionType = 0x11
+
+	// tagHeaderSize is the size of a tag's type and length.
+	tagHeaderSize = 4
+)
+
+// Code is a PPPoE packet code.
+type Code uint8
+
+// PPPoE packet codes.
+const (
+	CodeSession Code = 0x00 // Session data
+	CodePADO    Code = 0x07 // Active Discovery Offer
+	CodePADI    Code = 0x09 // Active Discovery Initiation
+	CodePADR    Code = 0x19 // Active Discovery Request
+	CodePADS    Code = 0x65 // Active Discovery Session-confirmation
+	CodePADT    Code = 0xa7 // Active Discovery Terminate
+)
+
+// String returns the name of the code.
+func (c Code) String() string {
+	switch c {
+	case CodeSession:
+		return "Session"
+	case CodePADO:
+		return "PADO"
+	case CodePADI:
+		return "PADI"
+	case CodePADR:
+		return "PADR"
+	case CodePADS:
+		return "PADS"
+	case CodePADT:
+		return "PADT"
+	default:
+		return fmt.Sprintf("Unknown(0x%02x)", uint8(c))
+	}
+}
+
+// TagType is the type of a discovery tag.
+type TagType uint16
+
+// Discovery tag types.
+const (
+	TagEndOfList        TagType = 0x0000
+	TagServiceName      TagType = 0x0101
+	TagACName           TagType = 0x0102
+	TagHostUniq         TagType = 0x0103
+	TagACCookie         TagType = 0x0104
+	TagRelaySessionID   TagType = 0x0110
+	TagServiceNameError TagType = 0x0201
+	TagACSystemError    TagType = 0x0202
+	TagGenericError     TagType = 0x0203
+)
+
+// Tag is a discovery tag.
+type Tag struct {
+	Type  TagType
+	Value []byte
+}
+
+// Packet is a PPPoE packet.
+type Packet struct {
+	Code      Code
+	SessionID uint16
+	Tags      []Tag  // Of discovery packets
+	Payload   []byte // Of session packets
+}
+
+// Tag returns the value of the first tag of a type.
+func (p *Packet) Tag(t TagType) ([]byte, bool) {
+	for _, tag := range p.Tags {
+		if tag.Type == t {
+			return tag.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Error returns the error a discovery packet reports in its error tags, or
+// nil if it has none.
+func (p *Packet) Error() error {
+	for _, tag := range p.Tags {
+		switch tag.Type {
+		case TagServiceNameError:
+			return fmt.Errorf("service name error: %q", tag.Value)
+		case TagACSystemError:
+			return fmt.Errorf("access concentrator error: %q", tag.Value)
+		case TagGenericError:
+			return fmt.Errorf("error: %q", tag.Value)
+		}
+	}
+	return nil
+}
+
+// Parse parses a PPPoE packet. Trailing bytes beyond its length, as
+// Ethernet padding, are ignored.
+func Parse(data []byte) (*Packet, error) {
+	if len(data) < HeaderSize {
+		return nil, fmt.Errorf("packet too short: %d bytes", len(data))
+	}
+	if data[0] != versionType {
+		return nil, fmt.Errorf("unsupported version and type: 0x%02x", data[0])
+	}
+	length := int(binary.BigEndian.Uint16(data[4:6]))
+	if HeaderSize+length > len(data) {
+		return nil, fmt.Errorf("length %d exceeds packet of %d bytes", length, len(data))
+	}
+
+	p := &Packet{
+		Code:      Code(data[1]),
+		SessionID: binary.BigEndian.Uint16(data[2:4]),
+	}
+	payload := data[HeaderSize : HeaderSize+length]
+	if p.Code == CodeSession {
+		p.Payload = payload
+		return p, nil
+	}
+
+	for len(payload) > 0 {
+		if len(payload) < tagHeaderSize {
+			return nil, fmt.Errorf("truncated tag header")
+		}
+		t := TagType(binary.BigEndian.Uint16(payload[0:2]))
+		n := int(binary.BigEndian.Uint16(payload[2:4]))
+		if tagHeaderSize+n > len(payload) {
+			return nil, fmt.Errorf("tag 0x%04x length %d exceeds packet", uint16(t), n)
+		}
+		if t == TagEndOfList {
+			break
+		}
+		p.Tags = append(p.Tags, Tag{Type: t, Value: payload[tagHeaderSize : tagHeaderSize+n]})
+		payload = payload[tagHeaderSize+n:]
+	}
+	return p, nil
+}
+
+// Serialize converts the packet to bytes.
+func (p *Packet) Serialize() ([]byte, error) {
+	length := len(p.Payload)
+	if p.Code != CodeSession {
+		length = 0
+		for _, tag := range p.Tags {
+			length += tagHeaderSize + len(tag.Value)
+		}
+	}
+	if length > maxPayload {
+		return nil, fmt.Errorf("payload too long: %d bytes (maximum %d)", length, maxPayload)
+	}
+
+	data := make([]byte, HeaderSize, HeaderSize+length)
+	data[0] = versionType
+	data[1] = uint8(p.Code)
+	binary.BigEndian.PutUint16(data[2:4], p.SessionID)
+	binary.BigEndian.PutUint16(data[4:6], uint16(length))
+	if p.Code == CodeSession {
+		return append(data, p.Payload...), nil
+	}
+	for _, tag := range p.Tags {
+		data = binary.BigEndian.AppendUint16(data, uint16(tag.Type))
+		data = binary.BigEndian.AppendUint16(data, uint16(len(tag.Value)))
+		data = append(data, tag.Value...)
+	}
+	return data, nil
+}
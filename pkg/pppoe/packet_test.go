@@ -0,0 +1,91 @@
+package pppoe
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPacketDiscoveryRoundTrip(t *testing.T) {
+	pkt := &Packet{Code: CodePADO, Tags: []Tag{
+		{Type: TagServiceName, Value: nil},
+		{Type: TagACName, Value: []byte("bras1")},
+		{Type: TagHostUniq, Value: []byte{1, 2, 3, 4}},
+	}}
+	data, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	want := []byte{
+		0x11, 0x07, 0x00, 0x00, 0x00, 0x15,
+		0x01, 0x01, 0x00, 0x00,
+		0x01, 0x02, 0x00, 0x05, 'b', 'r', 'a', 's', '1',
+		0x01, 0x03, 0x00, 0x04, 1, 2, 3, 4,
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("Serialize() = % x, want % x", data, want)
+	}
+
+	// Ethernet padding after the packet is ignored
+	got, err := Parse(append(data, 0, 0, 0, 0))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Code != CodePADO || len(got.Tags) != 3 {
+		t.Fatalf("Parse() = %+v, want PADO with 3 tags", got)
+	}
+	if name, ok := got.Tag(TagACName); !ok || string(name) != "bras1" {
+		t.Errorf("Tag(ACName) = %q, %v, want \"bras1\"", name, ok)
+	}
+	if _, ok := got.Tag(TagACCookie); ok {
+		t.Error("Tag(ACCookie) found, want none")
+	}
+	if err := got.Error(); err != nil {
+		t.Errorf("Error() = %v, want nil", err)
+	}
+}
+
+func TestPacketSessionRoundTrip(t *testing.T) {
+	pkt := &Packet{Code: CodeSession, SessionID: 0x1234, Payload: []byte{0x00, 0x21, 0x45}}
+	data, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Code != CodeSession || got.SessionID != 0x1234 || !bytes.Equal(got.Payload, pkt.Payload) {
+		t.Errorf("Parse() = %+v, want %+v", got, pkt)
+	}
+
+	if _, err := (&Packet{Code: CodeSession, Payload: make([]byte, maxPayload+1)}).Serialize(); err == nil {
+		t.Error("Serialize() with oversized payload succeeded")
+	}
+}
+
+func TestPacketError(t *testing.T) {
+	pkt := &Packet{Code: CodePADS, Tags: []Tag{{Type: TagServiceNameError, Value: []byte("no such service")}}}
+	if err := pkt.Error(); err == nil {
+		t.Error("Error() = nil, want service name error")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte{0x11, 0x09, 0, 0}},
+		{"bad version", []byte{0x21, 0x09, 0, 0, 0, 0}},
+		{"length past end", []byte{0x11, 0x09, 0, 0, 0, 8, 1, 1, 0, 0}},
+		{"truncated tag header", []byte{0x11, 0x09, 0, 0, 0, 2, 1, 1}},
+		{"tag past end", []byte{0x11, 0x09, 0, 0, 0, 4, 1, 1, 0, 9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Error("Parse() succeeded, want error")
+			}
+		})
+	}
+}
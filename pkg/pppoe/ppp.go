@@ -0,0 +1,169 @@
+package pppoe
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// PPP frame format in a PPPoE session (RFC 2516 Section 7), with no
+// address and control fields:
+// +----------------+--------------------------+
+// | Protocol (16)  | Information              |
+// +----------------+--------------------------+
+//
+// Control protocol packet format (RFC 1661 Section 5), for LCP, IPCP and
+// PAP:
+// +----------+--------+-------------+--------------+
+// | Code (8) | ID (8) | Length (16) | Data         |
+// +----------+--------+-------------+--------------+
+//
+// The data of Configure packets are options: type (8), length (8,
+// including the type and length) and value.
+
+// Protocol is a PPP protocol number.
+type Protocol uint16
+
+// PPP protocols.
+const (
+	ProtocolIPv4 Protocol = 0x0021
+	ProtocolIPCP Protocol = 0x8021
+	ProtocolLCP  Protocol = 0xc021
+	ProtocolPAP  Protocol = 0xc023
+	ProtocolCHAP Protocol = 0xc223
+)
+
+// String returns the protocol name.
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolIPv4:
+		return "IPv4"
+	case ProtocolIPCP:
+		return "IPCP"
+	case ProtocolLCP:
+		return "LCP"
+	case ProtocolPAP:
+		return "PAP"
+	case ProtocolCHAP:
+		return "CHAP"
+	default:
+		return fmt.Sprintf("Unknown(0x%04x)", uint16(p))
+	}
+}
+
+// ControlCode is the code of a control protocol packet.
+type ControlCode uint8
+
+// LCP codes; IPCP uses the first seven, PAP its own.
+const (
+	ConfigureRequest ControlCode = 1
+	ConfigureAck     ControlCode = 2
+	ConfigureNak     ControlCode = 3
+	ConfigureReject  ControlCode = 4
+	TerminateRequest ControlCode = 5
+	TerminateAck     ControlCode = 6
+	CodeReject       ControlCode = 7
+	ProtocolReject   ControlCode = 8
+	EchoRequest      ControlCode = 9
+	EchoReply        ControlCode = 10
+	DiscardRequest   ControlCode = 11
+
+	PAPAuthenticateRequest ControlCode = 1
+	PAPAuthenticateAck     ControlCode = 2
+	PAPAuthenticateNak     ControlCode = 3
+)
+
+// LCP option types.
+const (
+	lcpMRU          = 1
+	lcpAuthProtocol = 3
+	lcpMagicNumber  = 5
+)
+
+// IPCP option types.
+const (
+	ipcpIPAddress    = 3
+	ipcpPrimaryDNS   = 129
+	ipcpSecondaryDNS = 131
+)
+
+// controlHeaderSize is the size of a control packet header.
+const controlHeaderSize = 4
+
+// ControlPacket is an LCP, IPCP or PAP packet.
+type ControlPacket struct {
+	Code ControlCode
+	ID   uint8
+	Data []byte
+}
+
+// ParseControl parses a control protocol packet. Bytes beyond its length
+// are ignored.
+func ParseControl(data []byte) (*ControlPacket, error) {
+	if len(data) < controlHeaderSize {
+		return nil, fmt.Errorf("control packet too short: %d bytes", len(data))
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < controlHeaderSize || length > len(data) {
+		return nil, fmt.Errorf("invalid control packet length: %d", length)
+	}
+	return &ControlPacket{Code: ControlCode(data[0]), ID: data[1], Data: data[controlHeaderSize:length]}, nil
+}
+
+// Serialize converts the packet to bytes.
+func (c *ControlPacket) Serialize() []byte {
+	data := make([]byte, controlHeaderSize, controlHeaderSize+len(c.Data))
+	data[0] = uint8(c.Code)
+	data[1] = c.ID
+	binary.BigEndian.PutUint16(data[2:4], uint16(controlHeaderSize+len(c.Data)))
+	return append(data, c.Data...)
+}
+
+// Option is a configuration option of LCP or IPCP.
+type Option struct {
+	Type  uint8
+	Value []byte
+}
+
+// ParseOptions parses the options of a Configure packet.
+func ParseOptions(data []byte) ([]Option, error) {
+	var options []Option
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("truncated option")
+		}
+		n := int(data[1])
+		if n < 2 || n > len(data) {
+			return nil, fmt.Errorf("option %d has invalid length %d", data[0], n)
+		}
+		options = append(options, Option{Type: data[0], Value: data[2:n]})
+		data = data[n:]
+	}
+	return options, nil
+}
+
+// serializeOptions converts options to the data of a Configure packet.
+func serializeOptions(options []Option) []byte {
+	var data []byte
+	for _, o := range options {
+		data = append(data, o.Type, uint8(2+len(o.Value)))
+		data = append(data, o.Value...)
+	}
+	return data
+}
+
+// pppFrame returns the PPP frame carrying info for protocol.
+func pppFrame(protocol Protocol, info []byte) []byte {
+	frame := make([]byte, 2, 2+len(info))
+	binary.BigEndian.PutUint16(frame, uint16(protocol))
+	return append(frame, info...)
+}
+
+// uint16Option returns an option with a 16-bit value.
+func uint16Option(t uint8, v uint16) Option {
+	return Option{Type: t, Value: binary.BigEndian.AppendUint16(nil, v)}
+}
+
+// uint32Option returns an option with a 32-bit value.
+func uint32Option(t uint8, v uint32) Option {
+	return Option{Type: t, Value: binary.BigEndian.AppendUint32(nil, v)}
+}
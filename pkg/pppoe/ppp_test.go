@@ -0,0 +1,78 @@
+package pppoe
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestControlPacketRoundTrip(t *testing.T) {
+	c := &ControlPacket{Code: ConfigureRequest, ID: 7, Data: serializeOptions([]Option{
+		uint16Option(lcpMRU, 1492),
+		uint32Option(lcpMagicNumber, 0xdeadbeef),
+	})}
+	data := c.Serialize()
+	want := []byte{0x01, 0x07, 0x00, 0x0e, 0x01, 0x04, 0x05, 0xd4, 0x05, 0x06, 0xde, 0xad, 0xbe, 0xef}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("Serialize() = % x, want % x", data, want)
+	}
+
+	got, err := ParseControl(append(data, 0xff))
+	if err != nil {
+		t.Fatalf("ParseControl() error = %v", err)
+	}
+	if got.Code != ConfigureRequest || got.ID != 7 || !bytes.Equal(got.Data, c.Data) {
+		t.Fatalf("ParseControl() = %+v, want %+v", got, c)
+	}
+	options, err := ParseOptions(got.Data)
+	if err != nil {
+		t.Fatalf("ParseOptions() error = %v", err)
+	}
+	if len(options) != 2 || options[0].Type != lcpMRU || !bytes.Equal(options[1].Value, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Errorf("ParseOptions() = %v", options)
+	}
+}
+
+func TestParseControlInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte{1, 1, 0}},
+		{"length below header", []byte{1, 1, 0, 3}},
+		{"length past end", []byte{1, 1, 0, 8, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseControl(tt.data); err == nil {
+				t.Error("ParseControl() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestParseOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated", []byte{1}},
+		{"length below header", []byte{1, 1}},
+		{"length past end", []byte{1, 4, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseOptions(tt.data); err == nil {
+				t.Error("ParseOptions() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestProtocolString(t *testing.T) {
+	if got := ProtocolLCP.String(); got != "LCP" {
+		t.Errorf("String() = %q, want LCP", got)
+	}
+	if got := Protocol(0x1234).String(); got != "Unknown(0x1234)" {
+		t.Errorf("String() = %q, want Unknown(0x1234)", got)
+	}
+}
@@ -0,0 +1,73 @@
+package qdisc
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// Action is an active queue management decision for a frame.
+type Action uint8
+
+const (
+	Pass Action = iota // Queue or send the frame
+	Drop               // Drop the frame
+	Mark               // Mark the frame Congestion Experienced, or drop it if it is not ECN-capable
+)
+
+// String returns the action name.
+func (a Action) String() string {
+	switch a {
+	case Pass:
+		return "pass"
+	case Drop:
+		return "drop"
+	case Mark:
+		return "mark"
+	default:
+		return "unknown"
+	}
+}
+
+// AQM is an active queue management algorithm for one band, signalling
+// congestion by dropping or marking frames before the band fills. A Shaper
+// consults it as frames join and leave the band; an algorithm acts on
+// whichever suits it, and passes frames at the other.
+type AQM interface {
+	// Enqueue decides for a frame joining a band of backlog frames.
+	Enqueue(backlog int, now time.Time) Action
+
+	// Dequeue decides for a frame about to be sent after waiting sojourn
+	// in the band, leaving backlog bytes behind it.
+	Dequeue(sojourn time.Duration, backlog int, now time.Time) Action
+}
+
+// ECN codepoints of the IPv4 header.
+const (
+	ecnNotECT = 0 // Not ECN-capable transport
+	ecnCE     = 3 // Congestion Experienced
+)
+
+// mark sets the ECN field of a frame's IPv4 packet to Congestion
+// Experienced. Returns false if the packet is not ECN-capable, or the frame
+// does not carry IPv4.
+func mark(frame *ethernet.Frame) bool {
+	if frame.EtherType != common.EtherTypeIPv4 || len(frame.Payload) < 12 {
+		return false
+	}
+	header := frame.Payload
+	switch header[1] & 0x03 {
+	case ecnNotECT:
+		return false
+	case ecnCE:
+		return true
+	}
+
+	old := [2]byte{header[0], header[1]}
+	header[1] |= ecnCE
+	checksum := common.UpdateChecksum(binary.BigEndian.Uint16(header[10:12]), old[:], header[0:2])
+	binary.BigEndian.PutUint16(header[10:12], checksum)
+	return true
+}
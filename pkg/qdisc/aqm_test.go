@@ -0,0 +1,210 @@
+package qdisc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+// ecnFrame returns a frame carrying a valid IPv4 packet with an ECN field.
+func ecnFrame(ecn uint8) *ethernet.Frame {
+	pkt := ip.NewPacket(common.IPv4Address{10, 0, 0, 1}, common.IPv4Address{10, 0, 0, 2}, common.ProtocolUDP, make([]byte, 100))
+	pkt.ECN = ecn
+	data, _ := pkt.Serialize()
+	return ethernet.NewFrame(common.BroadcastMAC, common.MACAddress{0x02, 0, 0, 0, 0, 1}, common.EtherTypeIPv4, data)
+}
+
+// fixedAQM returns the same actions for every frame.
+type fixedAQM struct {
+	enqueue Action
+	dequeue Action
+}
+
+func (a fixedAQM) Enqueue(int, time.Time) Action                { return a.enqueue }
+func (a fixedAQM) Dequeue(time.Duration, int, time.Time) Action { return a.dequeue }
+
+func TestMark(t *testing.T) {
+	tests := []struct {
+		name   string
+		frame  *ethernet.Frame
+		want   bool
+		wantCE bool
+	}{
+		{"ECT(0)", ecnFrame(2), true, true},
+		{"ECT(1)", ecnFrame(1), true, true},
+		{"already CE", ecnFrame(3), true, true},
+		{"not ECT", ecnFrame(0), false, false},
+		{"ARP", ethernet.NewFrame(common.BroadcastMAC, common.MACAddress{}, common.EtherTypeARP, make([]byte, 28)), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mark(tt.frame); got != tt.want {
+				t.Fatalf("mark() = %v, want %v", got, tt.want)
+			}
+			if !tt.wantCE {
+				return
+			}
+			pkt, err := ip.Parse(tt.frame.Payload)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if pkt.ECN != ecnCE {
+				t.Errorf("ECN = %d, want %d", pkt.ECN, ecnCE)
+			}
+			if !common.VerifyChecksum(tt.frame.Payload[:ip.MinHeaderLength]) {
+				t.Error("header checksum invalid after marking")
+			}
+		})
+	}
+}
+
+func TestCoDel(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    CoDelConfig
+		sojourn   time.Duration
+		backlog   int
+		wantDrops []time.Duration // When frames, one every 10ms, are dropped
+		want      Action
+	}{
+		{"standing queue", CoDelConfig{}, 20 * time.Millisecond, 10000, []time.Duration{100, 200, 280, 330, 380}, Drop},
+		{"ECN", CoDelConfig{ECN: true}, 20 * time.Millisecond, 10000, []time.Duration{100, 200, 280, 330, 380}, Mark},
+		{"below target", CoDelConfig{}, 4 * time.Millisecond, 10000, nil, Drop},
+		{"one frame queued", CoDelConfig{}, 20 * time.Millisecond, 1000, nil, Drop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoDel(tt.config)()
+			start := time.Unix(0, 0)
+			var drops []time.Duration
+			for ms := time.Duration(0); ms < 400; ms += 10 {
+				switch action := c.Dequeue(tt.sojourn, tt.backlog, start.Add(ms*time.Millisecond)); action {
+				case tt.want:
+					drops = append(drops, ms)
+				case Pass:
+				default:
+					t.Fatalf("Dequeue() = %v, want %v or %v", action, Pass, tt.want)
+				}
+			}
+			if len(drops) != len(tt.wantDrops) {
+				t.Fatalf("dropped at %v ms, want %v ms", drops, tt.wantDrops)
+			}
+			for i := range drops {
+				if drops[i] != tt.wantDrops[i] {
+					t.Fatalf("dropped at %v ms, want %v ms", drops, tt.wantDrops)
+				}
+			}
+
+			// The delay falling below the target ends the dropping state
+			if tt.wantDrops != nil {
+				if action := c.Dequeue(time.Millisecond, tt.backlog, start.Add(400*time.Millisecond)); action != Pass {
+					t.Errorf("Dequeue() below target = %v, want %v", action, Pass)
+				}
+				if action := c.Dequeue(tt.sojourn, tt.backlog, start.Add(410*time.Millisecond)); action != Pass {
+					t.Errorf("Dequeue() right after = %v, want %v", action, Pass)
+				}
+			}
+		})
+	}
+}
+
+func TestRED(t *testing.T) {
+	tests := []struct {
+		name             string
+		config           REDConfig
+		backlog          int
+		wantMin, wantMax int // Drops of 1000 frames
+		want             Action
+	}{
+		{"below min threshold", REDConfig{MinThreshold: 10, MaxThreshold: 30, Weight: 1}, 5, 0, 0, Drop},
+		{"between thresholds", REDConfig{MinThreshold: 10, MaxThreshold: 30, Weight: 1}, 20, 70, 130, Drop}, // About one in 1/(2*0.05)
+		{"above max threshold", REDConfig{MinThreshold: 10, MaxThreshold: 30, Weight: 1}, 30, 1000, 1000, Drop},
+		{"ECN", REDConfig{MinThreshold: 10, MaxThreshold: 30, Weight: 1, ECN: true}, 30, 1000, 1000, Mark},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRED(tt.config)()
+			drops := 0
+			for i := 0; i < 1000; i++ {
+				switch action := r.Enqueue(tt.backlog, time.Time{}); action {
+				case tt.want:
+					drops++
+				case Pass:
+				default:
+					t.Fatalf("Enqueue() = %v, want %v or %v", action, Pass, tt.want)
+				}
+			}
+			if drops < tt.wantMin || drops > tt.wantMax {
+				t.Errorf("dropped %d of 1000, want %d to %d", drops, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestShaperAQM(t *testing.T) {
+	tests := []struct {
+		name       string
+		aqm        fixedAQM
+		ecn        uint8
+		wantSent   uint64
+		wantEarly  uint64
+		wantMarked uint64
+	}{
+		{"pass", fixedAQM{Pass, Pass}, 2, 3, 0, 0},
+		{"drop on enqueue", fixedAQM{Drop, Pass}, 2, 0, 3, 0},
+		{"drop on dequeue", fixedAQM{Pass, Drop}, 2, 0, 3, 0},
+		{"mark", fixedAQM{Pass, Mark}, 2, 3, 0, 3},
+		{"mark not ECN-capable", fixedAQM{Mark, Pass}, 0, 0, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, peer := newTestShaper(t, Config{NewAQM: func() AQM { return tt.aqm }})
+			for i := 0; i < 3; i++ {
+				s.WriteFrame(ecnFrame(tt.ecn))
+			}
+
+			for i := uint64(0); i < tt.wantSent; i++ {
+				data, err := peer.ReadPacketTimeout(time.Second)
+				if err != nil {
+					t.Fatalf("ReadPacketTimeout() error = %v", err)
+				}
+				if ecn := data[ethernet.HeaderSize+1] & 0x03; tt.wantMarked > 0 && ecn != ecnCE {
+					t.Errorf("ECN = %d, want CE", ecn)
+				}
+			}
+			deadline := time.Now().Add(time.Second)
+			for s.Stats().Early != tt.wantEarly && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			stats := s.Stats()
+			if stats.Sent != tt.wantSent || stats.Early != tt.wantEarly || stats.Marked != tt.wantMarked {
+				t.Errorf("Stats() = %+v, want %d sent, %d early drops, %d marked", stats, tt.wantSent, tt.wantEarly, tt.wantMarked)
+			}
+		})
+	}
+}
+
+func TestShaperCoDel(t *testing.T) {
+	s, peer := newTestShaper(t, Config{
+		Rate:   200000,
+		NewAQM: NewCoDel(CoDelConfig{Target: 5 * time.Millisecond, Interval: 20 * time.Millisecond}),
+	})
+
+	// 100000 bytes take half a second to drain, building a standing queue
+	for i := 0; i < 100; i++ {
+		s.WriteFrame(ipFrame(0, 1000))
+	}
+	received := 0
+	for {
+		if _, err := peer.ReadPacketTimeout(200 * time.Millisecond); err != nil {
+			break
+		}
+		received++
+	}
+	if stats := s.Stats(); stats.Early == 0 || received+int(stats.Early) != 100 {
+		t.Errorf("received %d, Stats() = %+v, want the rest dropped early", received, stats)
+	}
+}
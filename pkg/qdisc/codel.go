@@ -0,0 +1,110 @@
+package qdisc
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// DefaultCoDelTarget is the queueing delay CoDel tolerates.
+	DefaultCoDelTarget = 5 * time.Millisecond
+
+	// DefaultCoDelInterval is how long the delay must stay above the
+	// target before CoDel drops: about a worst-case round-trip time.
+	DefaultCoDelInterval = 100 * time.Millisecond
+)
+
+// CoDelConfig configures CoDel.
+type CoDelConfig struct {
+	Target   time.Duration // Default DefaultCoDelTarget
+	Interval time.Duration // Default DefaultCoDelInterval
+	ECN      bool          // Mark rather than drop ECN-capable frames
+}
+
+// CoDel is the Controlled Delay algorithm (RFC 8289). It drops frames
+// leaving a band once they have waited longer than the target for a whole
+// interval, more often the longer that lasts, until the delay falls below
+// the target again.
+type CoDel struct {
+	config CoDelConfig
+
+	firstAbove time.Time // When the delay will have been above target for an interval; zero while below
+	dropNext   time.Time // When to drop next while dropping
+	count      uint32    // Drops since entering the dropping state
+	lastCount  uint32    // count when the dropping state was last left
+	dropping   bool
+}
+
+// NewCoDel returns a function creating CoDel for each band, for
+// Config.NewAQM.
+func NewCoDel(config CoDelConfig) func() AQM {
+	if config.Target <= 0 {
+		config.Target = DefaultCoDelTarget
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultCoDelInterval
+	}
+	return func() AQM { return &CoDel{config: config} }
+}
+
+// Enqueue passes every frame: CoDel acts as frames leave the band.
+func (c *CoDel) Enqueue(backlog int, now time.Time) Action {
+	return Pass
+}
+
+// Dequeue decides for a frame by how long it waited.
+func (c *CoDel) Dequeue(sojourn time.Duration, backlog int, now time.Time) Action {
+	okToDrop := false
+	switch {
+	case sojourn < c.config.Target || backlog <= maxFrameSize:
+		// Below target, or too little queued to matter
+		c.firstAbove = time.Time{}
+	case c.firstAbove.IsZero():
+		c.firstAbove = now.Add(c.config.Interval)
+	case !now.Before(c.firstAbove):
+		okToDrop = true
+	}
+
+	if c.dropping {
+		if !okToDrop {
+			c.dropping = false
+			return Pass
+		}
+		if now.Before(c.dropNext) {
+			return Pass
+		}
+		c.count++
+		c.dropNext = c.controlLaw(c.dropNext)
+		return c.signal()
+	}
+
+	if !okToDrop {
+		return Pass
+	}
+	c.dropping = true
+
+	// Resume near the previous drop rate if the last dropping state ended
+	// recently
+	delta := c.count - c.lastCount
+	c.count = 1
+	if delta > 1 && now.Sub(c.dropNext) < 16*c.config.Interval {
+		c.count = delta
+	}
+	c.lastCount = c.count
+	c.dropNext = c.controlLaw(now)
+	return c.signal()
+}
+
+// controlLaw returns when to drop next after t: an interval divided by the
+// square root of the drops so far.
+func (c *CoDel) controlLaw(t time.Time) time.Time {
+	return t.Add(time.Duration(float64(c.config.Interval) / math.Sqrt(float64(c.count))))
+}
+
+// signal returns the action signalling congestion.
+func (c *CoDel) signal() Action {
+	if c.config.ECN {
+		return Mark
+	}
+	return Drop
+}
@@ -0,0 +1,422 @@
+// Package qdisc implements an egress scheduler for a device, in the spirit
+// of the Linux prio and tbf queueing disciplines.
+//
+// A Shaper wraps an ethernet.Device. Frames written to it are queued in
+// priority bands chosen by the DSCP of their IPv4 packet, and a scheduler
+// sends them on the device from the highest band first, at the rate a
+// token bucket allows. The rate and burst can be changed at runtime, so a
+// test can constrain the link under a running connection. Each band can
+// also run an active queue management algorithm, CoDel or RED, that drops
+// or ECN-marks frames before the band fills:
+//
+//	a, b := memory.NewPipe(memory.Config{})
+//	shaped, _ := qdisc.New(a, qdisc.Config{Rate: 125000}) // 1 Mbit/s
+//	...
+//	shaped.SetRate(12500, 0) // Drop to 100 kbit/s
+//
+//	qdisc.New(dev, qdisc.Config{Rate: 125000, NewAQM: qdisc.NewCoDel(qdisc.CoDelConfig{ECN: true})})
+package qdisc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+const (
+	// DefaultBands is the number of priority bands.
+	DefaultBands = 3
+
+	// DefaultQueueLen is the number of frames a band holds.
+	DefaultQueueLen = 1000
+
+	// maxFrameSize is the size of the largest untagged frame.
+	maxFrameSize = 1518
+
+	// minBurst is the smallest burst, one maximum-size frame: a smaller
+	// bucket would never fill enough to send it.
+	minBurst = maxFrameSize
+)
+
+// ErrClosed is returned by WriteFrame on a closed shaper.
+var ErrClosed = errors.New("shaper closed")
+
+// Config configures a Shaper.
+type Config struct {
+	// Rate limits the frames sent in bytes per second (0 = unlimited).
+	Rate int64
+
+	// Burst is the size of the token bucket in bytes: how much may be sent
+	// back to back at line rate after an idle period. Defaults to 10ms at
+	// Rate, and is at least one maximum-size frame.
+	Burst int
+
+	// Bands is the number of priority bands, band 0 first
+	// (0 = DefaultBands).
+	Bands int
+
+	// QueueLen bounds the frames queued in each band; excess frames are
+	// tail-dropped (0 = DefaultQueueLen).
+	QueueLen int
+
+	// Classify returns the band of a frame, clamped to the bands. Defaults
+	// to BandOf.
+	Classify func(frame *ethernet.Frame) int
+
+	// NewAQM creates the active queue management of each band, such as
+	// NewCoDel or NewRED (nil = tail drop only).
+	NewAQM func() AQM
+}
+
+// Stats holds the counters of a Shaper.
+type Stats struct {
+	Sent    uint64   // Frames sent on the device
+	Bytes   uint64   // Bytes sent on the device
+	Dropped uint64   // Frames tail-dropped because their band was full
+	Early   uint64   // Frames dropped by the AQM
+	Marked  uint64   // Frames ECN-marked by the AQM
+	Errors  uint64   // Frames the device failed to send
+	Backlog []int    // Frames queued in each band
+	Bands   []uint64 // Frames sent from each band
+}
+
+// BandOf returns the band of a frame in three bands by the DSCP of its IPv4
+// packet, as pfifo_fast does by TOS: network control and expedited
+// forwarding (CS5 and above) first, lower-effort (CS1) last, everything
+// else, including non-IPv4 frames, in between.
+func BandOf(frame *ethernet.Frame) int {
+	if frame.EtherType != common.EtherTypeIPv4 || len(frame.Payload) < 2 {
+		return 1
+	}
+	switch dscp := frame.Payload[1] >> 2; {
+	case dscp >= 40: // CS5, EF, CS6, CS7
+		return 0
+	case dscp>>3 == 1: // CS1, AF1x
+		return 2
+	default:
+		return 1
+	}
+}
+
+// Shaper is an ethernet.Device sending the frames written to it on another
+// device through priority bands and a token bucket. Frames are received
+// from the device unchanged.
+type Shaper struct {
+	dev      ethernet.Device
+	classify func(*ethernet.Frame) int
+	queueLen int
+	now      func() time.Time
+
+	mu     sync.Mutex
+	rate   int64
+	burst  int64
+	tokens int64     // Bytes that may be sent now
+	filled time.Time // When tokens was last refilled
+	bands  []band
+	stats  Stats
+	closed bool
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// band is a priority band.
+type band struct {
+	frames []queued
+	bytes  int // Queued
+	aqm    AQM // nil for tail drop only
+}
+
+// queued is a frame in a band.
+type queued struct {
+	frame *ethernet.Frame
+	at    time.Time // When it joined the band
+}
+
+// Compile-time check that Shaper implements ethernet.Device.
+var _ ethernet.Device = (*Shaper)(nil)
+
+// New creates a shaper on a device and starts its scheduler.
+func New(dev ethernet.Device, config Config) (*Shaper, error) {
+	if config.Bands == 0 {
+		config.Bands = DefaultBands
+	}
+	if config.QueueLen == 0 {
+		config.QueueLen = DefaultQueueLen
+	}
+	if config.Classify == nil {
+		config.Classify = BandOf
+	}
+	if config.Bands < 0 || config.QueueLen < 0 {
+		return nil, fmt.Errorf("invalid shaper queues: %d bands of %d frames", config.Bands, config.QueueLen)
+	}
+	if config.Rate < 0 || config.Burst < 0 {
+		return nil, fmt.Errorf("invalid shaper rate: %d bytes/s, burst %d", config.Rate, config.Burst)
+	}
+
+	s := &Shaper{
+		dev:      dev,
+		classify: config.Classify,
+		queueLen: config.QueueLen,
+		now:      time.Now,
+		bands:    make([]band, config.Bands),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	s.stats.Bands = make([]uint64, config.Bands)
+	if config.NewAQM != nil {
+		for i := range s.bands {
+			s.bands[i].aqm = config.NewAQM()
+		}
+	}
+	s.setRate(config.Rate, config.Burst)
+	s.tokens = s.burst
+	s.filled = s.now()
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// SetRate changes the rate in bytes per second (0 = unlimited) and the
+// burst in bytes (0 = the default for the rate). Frames already queued are
+// sent at the new rate.
+func (s *Shaper) SetRate(rate int64, burst int) error {
+	if rate < 0 || burst < 0 {
+		return fmt.Errorf("invalid shaper rate: %d bytes/s, burst %d", rate, burst)
+	}
+
+	s.mu.Lock()
+	s.refill(s.now())
+	s.setRate(rate, burst)
+	s.tokens = min(s.tokens, s.burst)
+	s.mu.Unlock()
+
+	s.kick()
+	return nil
+}
+
+// Rate returns the rate in bytes per second and the burst in bytes.
+func (s *Shaper) Rate() (int64, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate, int(s.burst)
+}
+
+// setRate sets the rate and burst, applying the burst defaults. Called
+// with s.mu held.
+func (s *Shaper) setRate(rate int64, burst int) {
+	if burst == 0 {
+		burst = int(rate / 100)
+	}
+	s.rate = rate
+	s.burst = int64(max(burst, minBurst))
+}
+
+// Name returns the device name.
+func (s *Shaper) Name() string {
+	return s.dev.Name()
+}
+
+// MACAddress returns the device's MAC address.
+func (s *Shaper) MACAddress() common.MACAddress {
+	return s.dev.MACAddress()
+}
+
+// Index returns the device's interface index.
+func (s *Shaper) Index() int {
+	return s.dev.Index()
+}
+
+// ReadFrame blocks until a frame is received on the device.
+func (s *Shaper) ReadFrame() (*ethernet.Frame, error) {
+	return s.dev.ReadFrame()
+}
+
+// WriteFrame queues a frame in its band. A frame whose band is full, or
+// that the band's AQM drops, is dropped and counted, as a device drops
+// frames its transmit queue has no room for.
+func (s *Shaper) WriteFrame(frame *ethernet.Frame) error {
+	i := min(max(s.classify(frame), 0), len(s.bands)-1)
+
+	// Copy so the caller may reuse the frame
+	copied := *frame
+	copied.VLAN = append([]ethernet.VLANTag(nil), frame.VLAN...)
+	copied.Payload = append([]byte(nil), frame.Payload...)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	b := &s.bands[i]
+	if len(b.frames) >= s.queueLen {
+		s.stats.Dropped++
+		s.mu.Unlock()
+		return nil
+	}
+	now := s.now()
+	if b.aqm != nil && !s.apply(b.aqm.Enqueue(len(b.frames), now), &copied) {
+		s.mu.Unlock()
+		return nil
+	}
+	b.frames = append(b.frames, queued{&copied, now})
+	b.bytes += copied.Size()
+	s.mu.Unlock()
+
+	s.kick()
+	return nil
+}
+
+// Stats returns a snapshot of the counters.
+func (s *Shaper) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Bands = append([]uint64(nil), s.stats.Bands...)
+	stats.Backlog = make([]int, len(s.bands))
+	for i, b := range s.bands {
+		stats.Backlog[i] = len(b.frames)
+	}
+	return stats
+}
+
+// Close stops the scheduler, discarding queued frames, and closes the
+// device.
+func (s *Shaper) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return s.dev.Close()
+}
+
+// kick wakes the scheduler.
+func (s *Shaper) kick() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends queued frames as the token bucket allows.
+func (s *Shaper) run() {
+	defer s.wg.Done()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		frame, band, wait := s.next()
+		if frame != nil {
+			err := s.dev.WriteFrame(frame)
+			s.mu.Lock()
+			if err != nil {
+				s.stats.Errors++
+			} else {
+				s.stats.Sent++
+				s.stats.Bytes += uint64(frame.Size())
+				s.stats.Bands[band]++
+			}
+			s.mu.Unlock()
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// next dequeues the frame to send now, from the highest band with frames,
+// and returns its band. If there is none, it returns how long to wait for
+// the tokens the next frame needs.
+func (s *Shaper) next() (*ethernet.Frame, int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.bands {
+		b := &s.bands[i]
+		for len(b.frames) > 0 {
+			now := s.now()
+			size := int64(b.frames[0].frame.Size())
+			if s.rate > 0 {
+				s.refill(now)
+				need := min(size, s.burst)
+				if s.tokens < need {
+					return nil, 0, time.Duration((need - s.tokens) * int64(time.Second) / s.rate)
+				}
+			}
+
+			q := b.frames[0]
+			b.frames[0] = queued{}
+			b.frames = b.frames[1:]
+			b.bytes -= int(size)
+			if b.aqm != nil && !s.apply(b.aqm.Dequeue(now.Sub(q.at), b.bytes, now), q.frame) {
+				continue
+			}
+			if s.rate > 0 {
+				s.tokens -= size
+			}
+			return q.frame, i, 0
+		}
+	}
+	return nil, 0, time.Hour
+}
+
+// apply carries out an AQM action on a frame, marking it or counting its
+// drop. Returns whether the frame goes on. Called with s.mu held.
+func (s *Shaper) apply(action Action, frame *ethernet.Frame) bool {
+	switch action {
+	case Mark:
+		if mark(frame) {
+			s.stats.Marked++
+			return true
+		}
+		s.stats.Early++
+		return false
+	case Drop:
+		s.stats.Early++
+		return false
+	default:
+		return true
+	}
+}
+
+// refill adds the tokens earned since the last refill. Called with s.mu
+// held.
+func (s *Shaper) refill(now time.Time) {
+	elapsed := now.Sub(s.filled)
+	if s.rate == 0 || elapsed >= time.Duration((s.burst-s.tokens)*int64(time.Second)/s.rate) {
+		s.tokens = s.burst
+		s.filled = now
+		return
+	}
+
+	// Only whole bytes are earned; the remainder carries over
+	earned := int64(elapsed) * s.rate / int64(time.Second)
+	s.tokens = min(s.tokens+earned, s.burst)
+	s.filled = s.filled.Add(time.Duration(earned * int64(time.Second) / s.rate))
+}
@@ -0,0 +1,197 @@
+package qdisc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+)
+
+// ipFrame returns a frame of size bytes carrying an IPv4 packet with dscp.
+func ipFrame(dscp uint8, size int) *ethernet.Frame {
+	payload := make([]byte, size-ethernet.HeaderSize)
+	payload[0] = 0x45
+	payload[1] = dscp << 2
+	return ethernet.NewFrame(common.BroadcastMAC, common.MACAddress{0x02, 0, 0, 0, 0, 1}, common.EtherTypeIPv4, payload)
+}
+
+// newTestShaper returns a shaper on one end of an in-memory link, and the
+// other end.
+func newTestShaper(t *testing.T, config Config) (*Shaper, *memory.Endpoint) {
+	t.Helper()
+	a, b := memory.NewPipe(memory.Config{})
+	s, err := New(a, config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, b
+}
+
+// receive reads n frames from the peer, returning their DSCPs.
+func receive(t *testing.T, peer *memory.Endpoint, n int) []uint8 {
+	t.Helper()
+	var dscps []uint8
+	for i := 0; i < n; i++ {
+		data, err := peer.ReadPacketTimeout(2 * time.Second)
+		if err != nil {
+			t.Fatalf("frame %d: ReadPacketTimeout() error = %v", i, err)
+		}
+		dscps = append(dscps, data[ethernet.HeaderSize+1]>>2)
+	}
+	return dscps
+}
+
+func TestBandOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame *ethernet.Frame
+		want  int
+	}{
+		{"best effort", ipFrame(0, 100), 1},
+		{"expedited forwarding", ipFrame(46, 100), 0},
+		{"network control", ipFrame(48, 100), 0},
+		{"AF41", ipFrame(34, 100), 1},
+		{"lower effort", ipFrame(8, 100), 2},
+		{"AF11", ipFrame(10, 100), 2},
+		{"ARP", ethernet.NewFrame(common.BroadcastMAC, common.MACAddress{}, common.EtherTypeARP, make([]byte, 28)), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BandOf(tt.frame); got != tt.want {
+				t.Errorf("BandOf() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShaperRate(t *testing.T) {
+	s, peer := newTestShaper(t, Config{Rate: 200000})
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		if err := s.WriteFrame(ipFrame(0, 1000)); err != nil {
+			t.Fatalf("WriteFrame() error = %v", err)
+		}
+	}
+	receive(t, peer, 30)
+
+	// 28000 bytes beyond the 2000 byte burst at 200000 bytes/s
+	if elapsed := time.Since(start); elapsed < 120*time.Millisecond || elapsed > time.Second {
+		t.Errorf("sent in %v, want about 140ms", elapsed)
+	}
+	stats := s.Stats()
+	if stats.Sent != 30 || stats.Bytes != 30000 || stats.Bands[1] != 30 {
+		t.Errorf("Stats() = %+v, want 30 frames, 30000 bytes in band 1", stats)
+	}
+}
+
+func TestShaperPriority(t *testing.T) {
+	s, peer := newTestShaper(t, Config{Rate: 50000})
+
+	for i := 0; i < 5; i++ {
+		s.WriteFrame(ipFrame(0, 1000))
+	}
+	s.WriteFrame(ipFrame(46, 1000))
+
+	// Only the best-effort frames sent before it arrived go first
+	dscps := receive(t, peer, 6)
+	for i, dscp := range dscps {
+		if dscp == 46 {
+			if i > 2 {
+				t.Errorf("EF frame received at %d of %v, want ahead of the backlog", i, dscps)
+			}
+			return
+		}
+	}
+	t.Errorf("received %v, no EF frame", dscps)
+}
+
+func TestShaperDrop(t *testing.T) {
+	s, _ := newTestShaper(t, Config{Rate: 1000, QueueLen: 2})
+
+	// The first frame goes out in the burst
+	s.WriteFrame(ipFrame(0, 1000))
+	for deadline := time.Now().Add(time.Second); s.Stats().Sent == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("first frame not sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := s.WriteFrame(ipFrame(0, 1000)); err != nil {
+			t.Fatalf("WriteFrame() error = %v", err)
+		}
+	}
+	stats := s.Stats()
+	if stats.Dropped != 3 || stats.Backlog[1] != 2 {
+		t.Errorf("Stats() = %+v, want 3 drops and 2 frames queued", stats)
+	}
+}
+
+func TestShaperSetRate(t *testing.T) {
+	s, peer := newTestShaper(t, Config{Rate: 1000})
+	if rate, burst := s.Rate(); rate != 1000 || burst != minBurst {
+		t.Errorf("Rate() = %d, %d, want 1000, %d", rate, burst, minBurst)
+	}
+
+	for i := 0; i < 5; i++ {
+		s.WriteFrame(ipFrame(0, 1000))
+	}
+	receive(t, peer, 1) // The burst
+
+	// Lifting the limit sends the backlog at once
+	if err := s.SetRate(0, 0); err != nil {
+		t.Fatalf("SetRate() error = %v", err)
+	}
+	start := time.Now()
+	receive(t, peer, 4)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("backlog sent in %v after lifting the rate", elapsed)
+	}
+
+	if err := s.SetRate(-1, 0); err == nil {
+		t.Error("SetRate(-1) error = nil, want an error")
+	}
+}
+
+func TestShaperClose(t *testing.T) {
+	s, _ := newTestShaper(t, Config{})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := s.WriteFrame(ipFrame(0, 100)); !errors.Is(err, ErrClosed) {
+		t.Errorf("WriteFrame() error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"defaults", Config{}, false},
+		{"negative rate", Config{Rate: -1}, true},
+		{"negative burst", Config{Burst: -1}, true},
+		{"negative bands", Config{Bands: -1}, true},
+		{"negative queue", Config{QueueLen: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := memory.NewPipe(memory.Config{})
+			defer a.Close()
+			s, err := New(a, tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s != nil {
+				s.Close()
+			}
+		})
+	}
+}
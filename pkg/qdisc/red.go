@@ -0,0 +1,98 @@
+package qdisc
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// DefaultREDMaxP is the drop probability RED reaches at the maximum
+	// threshold.
+	DefaultREDMaxP = 0.1
+
+	// DefaultREDWeight is the weight of each sample in RED's average queue
+	// length.
+	DefaultREDWeight = 0.002
+)
+
+// REDConfig configures RED. The thresholds are in frames.
+type REDConfig struct {
+	MinThreshold int     // Average length below which no frame is dropped
+	MaxThreshold int     // Average length above which every frame is dropped
+	MaxP         float64 // Default DefaultREDMaxP
+	Weight       float64 // Default DefaultREDWeight
+	ECN          bool    // Mark rather than drop ECN-capable frames
+	Seed         int64   // Of the random drop decisions
+}
+
+// RED is Random Early Detection (Floyd and Jacobson, 1993). It drops
+// frames joining a band with a probability growing with the band's
+// average length between the thresholds, spreading the drops out so that
+// flows back off before the band fills.
+type RED struct {
+	config REDConfig
+	rng    *rand.Rand
+
+	avg   float64 // Average queue length
+	count int     // Frames passed since the last drop, -1 below the minimum threshold
+}
+
+// NewRED returns a function creating RED for each band, for Config.NewAQM.
+// Each band draws from its own source seeded with config.Seed, so the same
+// traffic always sees the same drops.
+func NewRED(config REDConfig) func() AQM {
+	if config.MaxP <= 0 {
+		config.MaxP = DefaultREDMaxP
+	}
+	if config.Weight <= 0 {
+		config.Weight = DefaultREDWeight
+	}
+	if config.MaxThreshold <= config.MinThreshold {
+		config.MaxThreshold = config.MinThreshold + 1
+	}
+	return func() AQM {
+		return &RED{config: config, rng: rand.New(rand.NewSource(config.Seed)), count: -1}
+	}
+}
+
+// Enqueue decides for a frame by the band's average length.
+func (r *RED) Enqueue(backlog int, now time.Time) Action {
+	r.avg += r.config.Weight * (float64(backlog) - r.avg)
+
+	minTh, maxTh := float64(r.config.MinThreshold), float64(r.config.MaxThreshold)
+	switch {
+	case r.avg < minTh:
+		r.count = -1
+		return Pass
+	case r.avg >= maxTh:
+		r.count = 0
+		return r.signal()
+	}
+
+	// Uniform rather than geometric spacing of drops: the probability
+	// grows with the frames passed since the last one
+	r.count++
+	pb := r.config.MaxP * (r.avg - minTh) / (maxTh - minTh)
+	pa := 1.0
+	if p := 1 - float64(r.count)*pb; p > 0 {
+		pa = pb / p
+	}
+	if r.rng.Float64() < pa {
+		r.count = 0
+		return r.signal()
+	}
+	return Pass
+}
+
+// Dequeue passes every frame: RED acts as frames join the band.
+func (r *RED) Dequeue(sojourn time.Duration, backlog int, now time.Time) Action {
+	return Pass
+}
+
+// signal returns the action signalling congestion.
+func (r *RED) signal() Action {
+	if r.config.ECN {
+		return Mark
+	}
+	return Drop
+}
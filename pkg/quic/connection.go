@@ -2,7 +2,12 @@
 package quic
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -20,6 +25,71 @@ const (
 	StateClosed      ConnectionState = 4
 )
 
+const (
+	// DefaultConnIDLength is the length of the connection IDs this stack
+	// issues.
+	DefaultConnIDLength = 8
+
+	// DefaultHandshakeTimeout bounds Dial.
+	DefaultHandshakeTimeout = 10 * time.Second
+
+	// maxDatagramSize is the largest datagram sent. Every QUIC path carries
+	// 1200 bytes, so no path MTU discovery is needed at this size.
+	maxDatagramSize = MinInitialDatagramSize
+
+	// amplificationFactor limits how much a server sends to an address
+	// before validating it (RFC 9000 Section 8)
+	amplificationFactor = 3
+
+	// maxIssuedConnIDs caps the connection IDs issued to the peer
+	maxIssuedConnIDs = 8
+
+	// cryptoFrameOverhead bounds the type, offset and length of a CRYPTO
+	// frame in a packet of maxDatagramSize
+	cryptoFrameOverhead = 1 + 8 + 2
+)
+
+// Config configures QUIC connections.
+type Config struct {
+	// TLSConfig configures the TLS 1.3 handshake. Servers need
+	// Certificates, and both sides must set NextProtos: QUIC requires
+	// ALPN.
+	TLSConfig *tls.Config
+
+	// ConnIDLength is the length of the connection IDs this endpoint
+	// issues (DefaultConnIDLength if zero).
+	ConnIDLength int
+
+	// TransportParameters are advertised to the peer
+	// (DefaultTransportParameters if nil). Connection ID parameters are
+	// filled in per connection.
+	TransportParameters *TransportParameters
+
+	// HandshakeTimeout bounds Dial (DefaultHandshakeTimeout if zero).
+	HandshakeTimeout time.Duration
+}
+
+// withDefaults returns a copy of the config with defaults applied.
+func (c *Config) withDefaults() (*Config, error) {
+	if c == nil || c.TLSConfig == nil {
+		return nil, fmt.Errorf("quic: config needs a TLSConfig")
+	}
+	cfg := *c
+	if cfg.ConnIDLength == 0 {
+		cfg.ConnIDLength = DefaultConnIDLength
+	}
+	if cfg.ConnIDLength < 0 || cfg.ConnIDLength > MaxConnIDLength {
+		return nil, fmt.Errorf("quic: invalid connection ID length %d", cfg.ConnIDLength)
+	}
+	if cfg.TransportParameters == nil {
+		cfg.TransportParameters = DefaultTransportParameters()
+	}
+	if cfg.HandshakeTimeout == 0 {
+		cfg.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	return &cfg, nil
+}
+
 // Connection represents a QUIC connection.
 type Connection struct {
 	mu sync.RWMutex
@@ -31,116 +101,1205 @@ type Connection struct {
 	// Network
 	conn       net.PacketConn
 	remoteAddr net.Addr
+	endpoint   *endpoint
 
 	// State
-	state          ConnectionState
-	version        uint32
-	maxStreamData  uint64
-	maxData        uint64
+	state   ConnectionState
+	version uint32
+
+	// Streams, indexed by streamDir where there is one counter per type
+	streams         map[uint64]*Stream
+	streamCond      *sync.Cond // Signals stream state changes under mu
+	sendQueue       []*Stream  // Streams with data to send, in turn
+	acceptQueue     [2][]*Stream
+	opened          [2]uint64 // Streams we have opened
+	peerOpened      [2]uint64 // Streams the peer has opened
+	peerMaxStreams  [2]uint64 // Streams the peer allows us
+	localMaxStreams [2]uint64 // Streams we allow the peer
+
+	// Connection flow control
+	maxData      uint64 // Peer's limit on data we send
+	dataSent     uint64
+	recvMaxData  uint64 // Our limit on data the peer sends
+	recvHighest  uint64 // Sum of the highest offsets received per stream
+	recvConsumed uint64 // Data read or discarded by the application
+
+	// Handshake
+	isClient           bool
+	config             *Config
+	tls                *tls.QUICConn
+	origDestConnID     []byte
+	localParams        *TransportParameters
+	peerParams         *TransportParameters
+	handshakeComplete  bool
+	handshakeConfirmed bool
+	gotServerConnID    bool // Client: adopted the server's Source Connection ID
+
+	// Packet number spaces, connection IDs and loss recovery
+	spaces   [numSpaces]*packetSpace
+	connIDs  *connIDManager
+	rtt      rttStats
+	ptoCount int
+	lastSent time.Time
 
-	// Streams
-	streams map[uint64]*Stream
+	// Anti-amplification limit (server only)
+	addressValidated bool
+	bytesReceived    uint64
+	bytesSent        uint64
 
-	// Packet handling
-	packetNumber uint64
-	largestAcked uint64
+	// Closing
+	closeErr      error
+	closeFrame    *ConnectionCloseFrame
+	closeDeadline time.Time
+	draining      bool
+
+	// Event loop
+	incoming      chan datagram
+	wake          chan struct{}
+	handshakeDone chan struct{}
+	done          chan struct{}
 
 	// Timing
-	created time.Time
+	created  time.Time
 	lastSeen time.Time
 }
 
-// Stream represents a QUIC stream.
-type Stream struct {
-	ID       uint64
-	sendBuf  []byte
-	recvBuf  []byte
-	offset   uint64
-	finished bool
+// datagram is a received UDP payload with its source.
+type datagram struct {
+	data []byte
+	from net.Addr
 }
 
 // NewConnection creates a new QUIC connection.
 func NewConnection(conn net.PacketConn, remoteAddr net.Addr) (*Connection, error) {
 	// Generate random connection ID
-	localConnID := make([]byte, 8)
-	if _, err := rand.Read(localConnID); err != nil {
-		return nil, fmt.Errorf("failed to generate connection ID: %w", err)
-	}
-
-	return &Connection{
-		LocalConnID:  localConnID,
-		conn:         conn,
-		remoteAddr:   remoteAddr,
-		state:        StateIdle,
-		version:      Version1,
-		maxStreamData: 1024 * 1024,  // 1MB
-		maxData:      10 * 1024 * 1024, // 10MB
-		streams:      make(map[uint64]*Stream),
-		packetNumber: 0,
-		created:      time.Now(),
-		lastSeen:     time.Now(),
-	}, nil
-}
-
-// SendPacket sends a QUIC packet.
-func (c *Connection) SendPacket(pkt *Packet) error {
+	localConnID, err := newConnID(DefaultConnIDLength)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Connection{
+		LocalConnID:   localConnID,
+		conn:          conn,
+		remoteAddr:    remoteAddr,
+		state:         StateIdle,
+		version:       Version1,
+		streams:       make(map[uint64]*Stream),
+		rtt:           newRTTStats(),
+		incoming:      make(chan datagram, 64),
+		wake:          make(chan struct{}, 1),
+		handshakeDone: make(chan struct{}),
+		done:          make(chan struct{}),
+		created:       time.Now(),
+		lastSeen:      time.Now(),
+	}
+	c.streamCond = sync.NewCond(&c.mu)
+	for id := range c.spaces {
+		c.spaces[id] = newPacketSpace(spaceID(id))
+	}
+	return c, nil
+}
+
+// Dial opens a connection to remote over pc and blocks until the handshake
+// completes. The connection reads from pc until it is closed, so pc must
+// not be shared with other readers.
+func Dial(pc net.PacketConn, remote net.Addr, config *Config) (*Connection, error) {
+	cfg, err := config.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	ep := newEndpoint(pc, cfg, false)
+	c, err := newClientConnection(ep, remote)
+	if err != nil {
+		ep.close()
+		return nil, err
+	}
+	go ep.readLoop()
+	go c.run()
+
+	if err := c.waitHandshake(cfg.HandshakeTimeout); err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", remote, err)
+	}
+	return c, nil
+}
+
+// newClientConnection creates a client connection and starts its
+// handshake.
+func newClientConnection(ep *endpoint, remote net.Addr) (*Connection, error) {
+	c, err := NewConnection(ep.conn, remote)
+	if err != nil {
+		return nil, err
+	}
+	if c.LocalConnID, err = newConnID(ep.config.ConnIDLength); err != nil {
+		return nil, err
+	}
+
+	// The first Destination Connection ID is random and at least 8 bytes
+	// (RFC 9000 Section 7.2); Initial keys derive from it
+	dcid, err := newConnID(max(8, ep.config.ConnIDLength))
+	if err != nil {
+		return nil, err
+	}
+
+	c.isClient = true
+	c.origDestConnID = dcid
+	if err := c.init(ep, dcid); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newServerConnection creates a server connection for a client's first
+// Initial packet.
+func newServerConnection(ep *endpoint, remote net.Addr, origDCID, clientSCID []byte) (*Connection, error) {
+	c, err := NewConnection(ep.conn, remote)
+	if err != nil {
+		return nil, err
+	}
+	if c.LocalConnID, err = newConnID(ep.config.ConnIDLength); err != nil {
+		return nil, err
+	}
+
+	c.origDestConnID = bytes.Clone(origDCID)
+	if err := c.init(ep, clientSCID); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// init sets up connection IDs, Initial keys and the TLS handshake.
+func (c *Connection) init(ep *endpoint, remoteConnID []byte) error {
+	c.endpoint = ep
+	c.config = ep.config
+	c.RemoteConnID = bytes.Clone(remoteConnID)
+
+	// Transport parameters carry the connection IDs so that the peer can
+	// authenticate them (RFC 9000 Section 7.3)
+	params := *c.config.TransportParameters
+	params.InitialSourceConnID = c.LocalConnID
+	params.OriginalDestConnID = nil
+	params.StatelessResetToken = nil
+	if !c.isClient {
+		params.OriginalDestConnID = c.origDestConnID
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err != nil {
+			return fmt.Errorf("failed to generate reset token: %w", err)
+		}
+		params.StatelessResetToken = token
+	}
+	c.localParams = &params
+	c.recvMaxData = params.InitialMaxData
+	c.localMaxStreams = [2]uint64{params.InitialMaxStreamsBidi, params.InitialMaxStreamsUni}
+
+	c.connIDs = newConnIDManager(c.config.ConnIDLength, c.LocalConnID, params.ActiveConnIDLimit)
+	c.connIDs.setRemote(c.RemoteConnID)
+	c.connIDs.onIssue = func(id []byte) { ep.register(id, c) }
+	c.connIDs.onRetire = func(id []byte) { ep.unregister(id) }
+	ep.register(c.LocalConnID, c)
+	if !c.isClient {
+		// Retransmitted client Initials still carry the original ID
+		ep.register(c.origDestConnID, c)
+	}
+
+	// Initial keys
+	clientKeys, serverKeys, err := InitialKeys(c.origDestConnID)
+	if err != nil {
+		return err
+	}
+	sealKeys, openKeys := clientKeys, serverKeys
+	if !c.isClient {
+		sealKeys, openKeys = serverKeys, clientKeys
+	}
+	initial := c.spaces[spaceInitial]
+	if initial.seal, err = NewProtector(sealKeys); err != nil {
+		return err
+	}
+	if initial.open, err = NewProtector(openKeys); err != nil {
+		return err
+	}
+
+	// TLS 1.3 drives the handshake; QUIC carries its messages in CRYPTO
+	// frames
+	tlsConfig := c.config.TLSConfig.Clone()
+	tlsConfig.MinVersion = tls.VersionTLS13
+	qc := &tls.QUICConfig{TLSConfig: tlsConfig}
+	if c.isClient {
+		c.tls = tls.QUICClient(qc)
+	} else {
+		c.tls = tls.QUICServer(qc)
+	}
+	c.tls.SetTransportParameters(params.Marshal())
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	data, err := pkt.Serialize()
-	if err != nil {
-		return fmt.Errorf("failed to serialize packet: %w", err)
+	c.state = StateHandshaking
+	if err := c.tls.Start(context.Background()); err != nil {
+		return fmt.Errorf("failed to start TLS handshake: %w", err)
+	}
+	return c.processTLSEvents()
+}
+
+// waitHandshake blocks until the handshake completes, the connection
+// closes or the timeout passes.
+func (c *Connection) waitHandshake(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.handshakeDone:
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-timer.C:
+		c.mu.Lock()
+		c.terminate(ErrHandshakeTimeout)
+		c.mu.Unlock()
+		c.signal()
+		return ErrHandshakeTimeout
 	}
+}
 
-	_, err = c.conn.WriteTo(data, c.remoteAddr)
+// run is the connection's event loop: it processes datagrams, fires
+// timers and sends whatever is due, until the connection is closed.
+func (c *Connection) run() {
+	defer c.endpoint.remove(c)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		c.onTimers(now)
+		c.flush(now)
+		deadline := c.nextDeadline()
+		closed := c.state == StateClosed
+		c.mu.Unlock()
+
+		if closed {
+			return
+		}
+
+		wait := time.Hour
+		if !deadline.IsZero() {
+			wait = max(time.Until(deadline), 0)
+		}
+		timer.Reset(wait)
+
+		select {
+		case d := <-c.incoming:
+			c.mu.Lock()
+			c.handleDatagram(d, time.Now())
+			c.mu.Unlock()
+		case <-timer.C:
+		case <-c.wake:
+		}
+	}
+}
+
+// deliver queues a datagram for the event loop, dropping it if the queue
+// is full.
+func (c *Connection) deliver(d datagram) {
+	select {
+	case c.incoming <- d:
+	default:
+	}
+}
+
+// signal wakes the event loop to send queued frames.
+func (c *Connection) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// processTLSEvents applies what the TLS handshake produced: keys,
+// handshake data to send and the peer's transport parameters.
+func (c *Connection) processTLSEvents() error {
+	for {
+		e := c.tls.NextEvent()
+		switch e.Kind {
+		case tls.QUICNoEvent:
+			return nil
+
+		case tls.QUICSetReadSecret, tls.QUICSetWriteSecret:
+			id, ok := spaceForLevel(e.Level)
+			if !ok {
+				continue
+			}
+			keys, err := DeriveSuiteKeys(e.Suite, e.Data)
+			if err != nil {
+				return &TransportError{Code: ErrCodeInternal, Reason: err.Error()}
+			}
+			p, err := NewProtector(keys)
+			if err != nil {
+				return &TransportError{Code: ErrCodeInternal, Reason: err.Error()}
+			}
+			if e.Kind == tls.QUICSetReadSecret {
+				c.spaces[id].open = p
+			} else {
+				c.spaces[id].seal = p
+			}
+
+		case tls.QUICWriteData:
+			if id, ok := spaceForLevel(e.Level); ok {
+				c.spaces[id].writeCrypto(e.Data)
+			}
+
+		case tls.QUICTransportParameters:
+			if err := c.setPeerParams(e.Data); err != nil {
+				return err
+			}
+
+		case tls.QUICTransportParametersRequired:
+			c.tls.SetTransportParameters(c.localParams.Marshal())
+
+		case tls.QUICHandshakeDone:
+			if err := c.onHandshakeComplete(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// spaceForLevel maps a TLS encryption level to its packet number space.
+// 0-RTT is not supported.
+func spaceForLevel(level tls.QUICEncryptionLevel) (spaceID, bool) {
+	switch level {
+	case tls.QUICEncryptionLevelInitial:
+		return spaceInitial, true
+	case tls.QUICEncryptionLevelHandshake:
+		return spaceHandshake, true
+	case tls.QUICEncryptionLevelApplication:
+		return spaceApp, true
+	default:
+		return 0, false
+	}
+}
+
+// levelForSpace maps a packet number space to its TLS encryption level.
+func levelForSpace(id spaceID) tls.QUICEncryptionLevel {
+	switch id {
+	case spaceInitial:
+		return tls.QUICEncryptionLevelInitial
+	case spaceHandshake:
+		return tls.QUICEncryptionLevelHandshake
+	default:
+		return tls.QUICEncryptionLevelApplication
+	}
+}
+
+// setPeerParams validates and applies the peer's transport parameters,
+// including the connection IDs that authenticate the handshake.
+func (c *Connection) setPeerParams(data []byte) error {
+	p, err := ParseTransportParameters(data)
 	if err != nil {
-		return fmt.Errorf("failed to send packet: %w", err)
+		return &TransportError{Code: ErrCodeTransportParameter, Reason: err.Error()}
 	}
 
-	c.packetNumber++
+	if c.isClient {
+		if !p.hasOriginalDestConnID || !bytes.Equal(p.OriginalDestConnID, c.origDestConnID) {
+			return protocolError(ErrCodeTransportParameter, "original_destination_connection_id mismatch")
+		}
+	} else if p.hasOriginalDestConnID || p.StatelessResetToken != nil {
+		return protocolError(ErrCodeTransportParameter, "client sent a server-only parameter")
+	}
+	if !p.hasInitialSourceConnID || !bytes.Equal(p.InitialSourceConnID, c.RemoteConnID) {
+		return protocolError(ErrCodeTransportParameter, "initial_source_connection_id mismatch")
+	}
+
+	c.peerParams = p
+	c.maxData = p.InitialMaxData
+	c.peerMaxStreams = [2]uint64{p.InitialMaxStreamsBidi, p.InitialMaxStreamsUni}
+	return nil
+}
+
+// onHandshakeComplete is called when TLS finishes. The server confirms the
+// handshake at once and tells the client with HANDSHAKE_DONE.
+func (c *Connection) onHandshakeComplete() error {
+	c.handshakeComplete = true
+	c.state = StateEstablished
+
+	if !c.isClient {
+		c.spaces[spaceApp].pending = append(c.spaces[spaceApp].pending, &HandshakeDoneFrame{})
+		c.confirmHandshake()
+	}
+
+	// Give the peer spare connection IDs
+	if err := c.connIDs.issue(min(c.peerParams.ActiveConnIDLimit, maxIssuedConnIDs)); err != nil {
+		return &TransportError{Code: ErrCodeInternal, Reason: err.Error()}
+	}
+	c.queueConnIDFrames()
+
+	close(c.handshakeDone)
+	if !c.isClient {
+		c.endpoint.accept(c)
+	}
 	return nil
 }
 
-// ReceivePacket receives and processes a QUIC packet.
-func (c *Connection) ReceivePacket() (*Packet, error) {
-	buf := make([]byte, 65535)
-	n, addr, err := c.conn.ReadFrom(buf)
+// confirmHandshake discards the Handshake keys (RFC 9001 Section 4.9.2).
+func (c *Connection) confirmHandshake() {
+	if c.handshakeConfirmed {
+		return
+	}
+	c.handshakeConfirmed = true
+	c.discardSpace(spaceHandshake)
+}
+
+// discardSpace drops the keys of a packet number space.
+func (c *Connection) discardSpace(id spaceID) {
+	if c.spaces[id].discarded {
+		return
+	}
+	c.spaces[id].discard()
+	c.ptoCount = 0
+}
+
+// queueConnIDFrames moves connection ID frames into the 1-RTT space.
+func (c *Connection) queueConnIDFrames() {
+	app := c.spaces[spaceApp]
+	app.pending = append(app.pending, c.connIDs.takePending()...)
+}
+
+// handleDatagram processes a received datagram, which may hold several
+// coalesced packets.
+func (c *Connection) handleDatagram(d datagram, now time.Time) {
+	if c.state == StateClosed || c.draining {
+		return
+	}
+	c.bytesReceived += uint64(len(d.data))
+
+	// In the closing state, every packet is answered with the close
+	if c.state == StateClosing {
+		c.sendClose(now)
+		return
+	}
+
+	data := d.data
+	for len(data) > 0 && c.state < StateClosing {
+		n := c.handlePacket(data, now)
+		if n <= 0 {
+			break
+		}
+		data = data[n:]
+	}
+}
+
+// handlePacket opens and processes the first packet in data and returns
+// its length, or 0 if the rest of the datagram cannot be parsed.
+func (c *Connection) handlePacket(data []byte, now time.Time) int {
+	id := spaceApp
+	if data[0]&0x80 != 0 {
+		if len(data) < 5 {
+			return 0
+		}
+		if binary.BigEndian.Uint32(data[1:5]) == 0 {
+			c.handleVersionNegotiation(data)
+			return 0
+		}
+		switch PacketType((data[0] >> 4) & 0x03) {
+		case PacketTypeInitial:
+			id = spaceInitial
+		case PacketTypeHandshake:
+			id = spaceHandshake
+		default:
+			// 0-RTT and Retry are not supported
+			return packetEnd(data)
+		}
+	}
+
+	s := c.spaces[id]
+	if !s.canReceive() {
+		return packetEnd(data)
+	}
+	pkt, n, err := OpenPacket(data, c.config.ConnIDLength, s.open, s.largestRecv)
 	if err != nil {
-		return nil, fmt.Errorf("failed to receive packet: %w", err)
+		// Undecryptable packets are dropped (RFC 9000 Section 12.2)
+		return packetEnd(data)
+	}
+	if pkt.HeaderForm == 1 && pkt.Version != c.version {
+		return n
 	}
 
-	c.mu.Lock()
-	c.remoteAddr = addr
-	c.lastSeen = time.Now()
-	c.mu.Unlock()
+	c.lastSeen = now
+	if err := c.processPacket(s, pkt, now); err != nil {
+		c.closeLocked(err, now)
+	}
+	return n
+}
+
+// packetEnd returns the length of a long header packet, or the rest of the
+// datagram for a short header packet, so that it can be skipped.
+func packetEnd(data []byte) int {
+	if data[0]&0x80 == 0 {
+		return len(data)
+	}
+	_, pnOffset, end, err := parseLongHeader(data)
+	if err != nil || pnOffset < 0 {
+		return 0
+	}
+	return end
+}
+
+// handleVersionNegotiation ends a client connection when the server
+// supports none of our versions. A Version Negotiation packet listing our
+// own version is ignored (RFC 9000 Section 6.2).
+func (c *Connection) handleVersionNegotiation(data []byte) {
+	pkt, _, _, err := parseLongHeader(data)
+	if err != nil || !c.isClient || c.gotServerConnID {
+		return
+	}
+	if !bytes.Equal(pkt.DestConnID, c.LocalConnID) {
+		return
+	}
+	for v := pkt.Payload; len(v) >= 4; v = v[4:] {
+		if binary.BigEndian.Uint32(v) == c.version {
+			return
+		}
+	}
+	c.terminate(fmt.Errorf("quic: server does not support version 0x%08x", c.version))
+}
+
+// processPacket handles the frames of a decrypted packet.
+func (c *Connection) processPacket(s *packetSpace, pkt *Packet, now time.Time) error {
+	frames, err := ParseFrames(pkt.Payload)
+	if err != nil {
+		return protocolError(ErrCodeFrameEncoding, "%v", err)
+	}
+
+	ackEliciting := false
+	for _, f := range frames {
+		if isAckEliciting(f) {
+			ackEliciting = true
+		}
+	}
+	if !s.onPacketReceived(pkt.PacketNumber, ackEliciting, now, c.localParams.MaxAckDelay) {
+		return nil // Duplicate
+	}
+
+	// The client switches to the connection ID the server chose
+	if c.isClient && s.id == spaceInitial && !c.gotServerConnID {
+		c.gotServerConnID = true
+		c.RemoteConnID = bytes.Clone(pkt.SrcConnID)
+		c.connIDs.setRemote(c.RemoteConnID)
+	}
+
+	// A Handshake packet proves the client owns its address, and the
+	// server no longer needs Initial keys (RFC 9001 Section 4.9.1)
+	if !c.isClient && s.id == spaceHandshake {
+		c.addressValidated = true
+		c.discardSpace(spaceInitial)
+	}
+
+	for _, f := range frames {
+		if err := c.handleFrame(s, pkt, f, now); err != nil {
+			return err
+		}
+		if c.state >= StateClosing || s.discarded {
+			return nil
+		}
+	}
+	return nil
+}
+
+// isAckEliciting reports whether a frame requires acknowledgement.
+func isAckEliciting(f Frame) bool {
+	switch f.(type) {
+	case *AckFrame, *PaddingFrame, *ConnectionCloseFrame:
+		return false
+	default:
+		return true
+	}
+}
+
+// allowedBeforeOneRTT reports whether a frame may appear in Initial and
+// Handshake packets (RFC 9000 Section 12.4).
+func allowedBeforeOneRTT(f Frame) bool {
+	switch f := f.(type) {
+	case *CryptoFrame, *AckFrame, *PingFrame, *PaddingFrame:
+		return true
+	case *ConnectionCloseFrame:
+		return !f.Application
+	default:
+		return false
+	}
+}
+
+// handleFrame processes one frame.
+func (c *Connection) handleFrame(s *packetSpace, pkt *Packet, frame Frame, now time.Time) error {
+	if s.id != spaceApp && !allowedBeforeOneRTT(frame) {
+		return protocolError(ErrCodeProtocolViolation, "%s frame in %s packet", frameTypeName(uint64(frame.Type())), s.id)
+	}
+
+	switch f := frame.(type) {
+	case *AckFrame:
+		return c.handleAck(s, f, now)
+
+	case *CryptoFrame:
+		data, err := s.receiveCrypto(f)
+		if err != nil {
+			return err
+		}
+		if len(data) > 0 {
+			if err := c.tls.HandleData(levelForSpace(s.id), data); err != nil {
+				return cryptoError(err)
+			}
+		}
+		return c.processTLSEvents()
+
+	case *PingFrame:
+		// Only elicits an ACK
+
+	case *StreamFrame:
+		return c.handleStream(f)
+
+	case *ResetStreamFrame:
+		return c.handleResetStream(f)
 
-	pkt, err := Parse(buf[:n])
+	case *StopSendingFrame:
+		return c.handleStopSending(f)
+
+	case *MaxStreamDataFrame:
+		return c.handleMaxStreamData(f)
+
+	case *MaxStreamsFrame:
+		c.handleMaxStreams(f)
+
+	case *MaxDataFrame:
+		if f.MaximumData > c.maxData {
+			c.maxData = f.MaximumData
+			c.streamCond.Broadcast()
+		}
+
+	case *DataBlockedFrame, *StreamDataBlockedFrame, *StreamsBlockedFrame:
+		// Informational: limits are raised as data is read
+
+	case *ConnectionCloseFrame:
+		c.enterDraining(f, now)
+
+	case *HandshakeDoneFrame:
+		if !c.isClient {
+			return protocolError(ErrCodeProtocolViolation, "HANDSHAKE_DONE sent by client")
+		}
+		c.confirmHandshake()
+
+	case *NewConnectionIDFrame:
+		if len(c.RemoteConnID) == 0 {
+			return protocolError(ErrCodeProtocolViolation, "NEW_CONNECTION_ID with zero-length connection ID")
+		}
+		if err := c.connIDs.handleNewConnectionID(f); err != nil {
+			return err
+		}
+		c.RemoteConnID = c.connIDs.activeRemoteID()
+		c.queueConnIDFrames()
+
+	case *RetireConnectionIDFrame:
+		if err := c.connIDs.handleRetireConnectionID(f, pkt.DestConnID, c.issueLimit()); err != nil {
+			return err
+		}
+		c.queueConnIDFrames()
+	}
+	return nil
+}
+
+// cryptoError converts a TLS error to a CRYPTO_ERROR carrying its alert.
+func cryptoError(err error) error {
+	var alert tls.AlertError
+	if errors.As(err, &alert) {
+		return &TransportError{Code: ErrCodeCryptoError + uint64(alert), Reason: err.Error()}
+	}
+	return &TransportError{Code: ErrCodeCryptoError + 80, Reason: err.Error()} // internal_error
+}
+
+// issueLimit returns how many local connection IDs the peer may hold.
+func (c *Connection) issueLimit() uint64 {
+	if c.peerParams == nil {
+		return DefaultActiveConnIDLimit
+	}
+	return min(c.peerParams.ActiveConnIDLimit, maxIssuedConnIDs)
+}
+
+// handleAck processes an ACK frame: it takes an RTT sample, then detects
+// lost packets (RFC 9002 Sections 5 and 6).
+func (c *Connection) handleAck(s *packetSpace, f *AckFrame, now time.Time) error {
+	if f.LargestAcknowledged >= s.nextPN {
+		return protocolError(ErrCodeProtocolViolation, "ACK of unsent packet %d", f.LargestAcknowledged)
+	}
+
+	acked := s.onAckReceived(f)
+	if len(acked) == 0 {
+		return nil
+	}
+
+	// Only the largest acknowledged packet gives an RTT sample
+	for _, p := range acked {
+		if p.pn != f.LargestAcknowledged {
+			continue
+		}
+		var ackDelay time.Duration
+		if s.id == spaceApp {
+			ackDelay = time.Duration(f.AckDelay<<c.peerAckDelayExponent()) * time.Microsecond
+		}
+		c.rtt.update(now.Sub(p.time), ackDelay, c.peerMaxAckDelay(), c.handshakeConfirmed)
+	}
+
+	// A client in the Initial space keeps backing off until it knows the
+	// server validated its address
+	if !c.isClient || s.id != spaceInitial {
+		c.ptoCount = 0
+	}
+
+	s.requeue(s.detectLostPackets(&c.rtt, now))
+	return nil
+}
+
+func (c *Connection) peerAckDelayExponent() uint64 {
+	if c.peerParams == nil {
+		return DefaultAckDelayExponent
+	}
+	return c.peerParams.AckDelayExponent
+}
+
+func (c *Connection) peerMaxAckDelay() time.Duration {
+	if c.peerParams == nil {
+		return DefaultMaxAckDelay
+	}
+	return c.peerParams.MaxAckDelay
+}
+
+// flush sends packets for every space with something due, lowest
+// encryption level first. Each packet is sent in its own datagram.
+func (c *Connection) flush(now time.Time) {
+	if c.state != StateHandshaking && c.state != StateEstablished {
+		return
+	}
+	for _, s := range c.spaces {
+		for s.hasData(now) || s.id == spaceApp && c.handshakeComplete && c.streamsReady() {
+			if !c.sendPacket(s, now) {
+				break
+			}
+		}
+	}
+}
+
+// amplificationAllowance returns how many more bytes the server may send
+// before the client's address is validated.
+func (c *Connection) amplificationAllowance() uint64 {
+	if c.isClient || c.addressValidated {
+		return ^uint64(0)
+	}
+	limit := amplificationFactor * c.bytesReceived
+	if c.bytesSent >= limit {
+		return 0
+	}
+	return limit - c.bytesSent
+}
+
+// newPacket returns a packet of the space's type with the next packet
+// number and the current connection IDs.
+func (c *Connection) newPacket(s *packetSpace) *Packet {
+	var pkt *Packet
+	switch s.id {
+	case spaceInitial:
+		pkt = NewInitialPacket(c.RemoteConnID, c.LocalConnID, nil, nil)
+	case spaceHandshake:
+		pkt = NewHandshakePacket(c.RemoteConnID, c.LocalConnID, nil)
+	default:
+		pkt = New1RTTPacket(c.RemoteConnID, nil)
+	}
+	pkt.PacketNumber = s.nextPN
+	pkt.PacketNumLen = uint8(PacketNumberLength(s.nextPN, s.largestAcked))
+	return pkt
+}
+
+// headerLength returns the size of a packet's header.
+func headerLength(pkt *Packet) int {
+	if pkt.HeaderForm == 0 {
+		return 1 + len(pkt.DestConnID) + int(pkt.PacketNumLen)
+	}
+	return pkt.longHeaderLength(2)
+}
+
+// sendPacket builds and sends one packet of the space: an ACK if one is
+// owed, then queued frames, then CRYPTO data, and a PING if a probe is
+// owed and nothing else elicits an ACK. It reports whether a packet was
+// sent.
+func (c *Connection) sendPacket(s *packetSpace, now time.Time) bool {
+	// Before address validation a full-size datagram must fit the limit
+	if c.amplificationAllowance() < maxDatagramSize {
+		return false
+	}
+
+	pkt := c.newPacket(s)
+	budget := maxDatagramSize - headerLength(pkt) - s.seal.Overhead()
+
+	var payload []byte
+	var frames []Frame
+	ackEliciting := false
+
+	if !s.ackDeadline.IsZero() {
+		var delay uint64
+		if s.id == spaceApp {
+			delay = uint64(now.Sub(s.largestRecvTime).Microseconds()) >> c.localParams.AckDelayExponent
+		}
+		if b, err := s.received.ackFrame(delay).Serialize(); err == nil {
+			payload = append(payload, b...)
+		}
+		s.onAckSent()
+	}
+
+	for len(s.pending) > 0 {
+		b, err := s.pending[0].Serialize()
+		if err != nil || c.abandoned(s.pending[0]) {
+			s.pending = s.pending[1:]
+			continue
+		}
+		if len(payload)+len(b) > budget {
+			break
+		}
+		payload = append(payload, b...)
+		frames = append(frames, s.pending[0])
+		ackEliciting = true
+		s.pending = s.pending[1:]
+	}
+
+	if f := s.takeCrypto(budget - len(payload) - cryptoFrameOverhead); f != nil {
+		if b, err := f.Serialize(); err == nil {
+			payload = append(payload, b...)
+			frames = append(frames, f)
+			ackEliciting = true
+		}
+	}
+
+	// Stream data fills the rest of 1-RTT packets
+	if s.id == spaceApp && c.handshakeComplete {
+		n := len(frames)
+		payload, frames = c.appendStreamFrames(payload, frames, budget)
+		ackEliciting = ackEliciting || len(frames) > n
+	}
+
+	if s.probes > 0 {
+		s.probes--
+		if !ackEliciting {
+			payload = append(payload, byte(FrameTypePing))
+			ackEliciting = true
+		}
+	}
+
+	if len(payload) == 0 {
+		return false
+	}
+
+	pkt.Payload = payload
+	data, err := pkt.SerializeProtected(s.seal)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse packet: %w", err)
+		return false
+	}
+	// A failed write is treated like a lost packet
+	_, _ = c.conn.WriteTo(data, c.remoteAddr)
+
+	s.nextPN++
+	c.bytesSent += uint64(len(data))
+	c.lastSent = now
+	if ackEliciting {
+		s.sent[pkt.PacketNumber] = &sentPacket{
+			pn:           pkt.PacketNumber,
+			time:         now,
+			size:         len(data),
+			ackEliciting: true,
+			frames:       frames,
+		}
+		s.lastAckElicitingSent = now
 	}
 
-	return pkt, nil
+	// The client drops Initial keys once it sends a Handshake packet
+	if c.isClient && s.id == spaceHandshake {
+		c.discardSpace(spaceInitial)
+	}
+	return true
 }
 
-// OpenStream opens a new stream.
-func (c *Connection) OpenStream() (*Stream, error) {
+// lossDetectionTimer returns when the loss detection timer fires, the
+// space it fires for and whether it is a loss time rather than a probe
+// timeout (RFC 9002 Section 6.2).
+func (c *Connection) lossDetectionTimer() (time.Time, spaceID, bool) {
+	var when time.Time
+	var id spaceID
+	for _, s := range c.spaces {
+		if !s.lossTime.IsZero() && (when.IsZero() || s.lossTime.Before(when)) {
+			when, id = s.lossTime, s.id
+		}
+	}
+	if !when.IsZero() {
+		return when, id, true
+	}
+
+	// A server blocked by the amplification limit waits for the client
+	if c.amplificationAllowance() < maxDatagramSize {
+		return time.Time{}, 0, false
+	}
+
+	backoff := time.Duration(1) << min(c.ptoCount, maxPTOBackoff)
+	inFlight := false
+	for _, s := range c.spaces {
+		if !s.canSend() || !s.ackElicitingInFlight() {
+			continue
+		}
+		// 1-RTT probes wait for handshake confirmation
+		if s.id == spaceApp && !c.handshakeConfirmed {
+			continue
+		}
+		inFlight = true
+
+		var maxAckDelay time.Duration
+		if s.id == spaceApp {
+			maxAckDelay = c.peerMaxAckDelay()
+		}
+		t := s.lastAckElicitingSent.Add(c.rtt.pto(maxAckDelay) * backoff)
+		if when.IsZero() || t.Before(when) {
+			when, id = t, s.id
+		}
+	}
+
+	// Until the handshake is confirmed the client keeps a probe armed, so
+	// that lost server packets cannot deadlock the handshake
+	if !inFlight && c.isClient && !c.handshakeConfirmed {
+		id = spaceInitial
+		if c.spaces[spaceHandshake].canSend() {
+			id = spaceHandshake
+		}
+		if !c.spaces[id].canSend() {
+			return time.Time{}, 0, false
+		}
+		base := c.lastSent
+		if base.IsZero() {
+			base = c.created
+		}
+		return base.Add(c.rtt.pto(0) * backoff), id, false
+	}
+	return when, id, false
+}
+
+// onLossTimeout declares packets lost when their loss time passes, or
+// sends probes when the probe timeout fires.
+func (c *Connection) onLossTimeout(now time.Time) {
+	when, id, isLossTime := c.lossDetectionTimer()
+	if when.IsZero() || now.Before(when) {
+		return
+	}
+
+	s := c.spaces[id]
+	if isLossTime {
+		s.requeue(s.detectLostPackets(&c.rtt, now))
+		return
+	}
+
+	// Probe with the unacknowledged data, moving it to the new packets so
+	// that it is not retransmitted twice
+	c.ptoCount++
+	for _, p := range s.sent {
+		s.pending = append(s.pending, p.frames...)
+		p.frames = nil
+	}
+	s.probes = 1
+}
+
+// onTimers fires whatever timers have expired.
+func (c *Connection) onTimers(now time.Time) {
+	switch {
+	case c.state == StateClosed:
+		return
+	case c.state == StateClosing:
+		if !now.Before(c.closeDeadline) {
+			c.terminate(c.closeErr)
+		}
+		return
+	}
+
+	if !now.Before(c.idleDeadline()) {
+		c.terminate(ErrIdleTimeout)
+		return
+	}
+	c.onLossTimeout(now)
+}
+
+// idleTimeout returns the negotiated idle timeout: the smaller of the two
+// endpoints' values, but at least three probe timeouts (RFC 9000 Section
+// 10.1).
+func (c *Connection) idleTimeout() time.Duration {
+	timeout := c.localParams.MaxIdleTimeout
+	if c.peerParams != nil && c.peerParams.MaxIdleTimeout > 0 {
+		if timeout == 0 || c.peerParams.MaxIdleTimeout < timeout {
+			timeout = c.peerParams.MaxIdleTimeout
+		}
+	}
+	if timeout == 0 {
+		return 0
+	}
+	return max(timeout, 3*c.rtt.pto(c.peerMaxAckDelay()))
+}
+
+// idleDeadline returns when the connection times out, or a far future
+// time without an idle timeout.
+func (c *Connection) idleDeadline() time.Time {
+	timeout := c.idleTimeout()
+	if timeout == 0 {
+		return c.lastSeen.Add(24 * time.Hour)
+	}
+	return c.lastSeen.Add(timeout)
+}
+
+// nextDeadline returns when the event loop must next wake up.
+func (c *Connection) nextDeadline() time.Time {
+	if c.state == StateClosing {
+		return c.closeDeadline
+	}
+
+	deadline := c.idleDeadline()
+	if t, _, _ := c.lossDetectionTimer(); !t.IsZero() && t.Before(deadline) {
+		deadline = t
+	}
+	for _, s := range c.spaces {
+		if s.canSend() && !s.ackDeadline.IsZero() && s.ackDeadline.Before(deadline) {
+			deadline = s.ackDeadline
+		}
+	}
+	return deadline
+}
+
+// closeLocked closes the connection with a local error: it sends
+// CONNECTION_CLOSE and waits in the closing state for three probe timeouts
+// (RFC 9000 Section 10.2).
+func (c *Connection) closeLocked(err error, now time.Time) {
+	if c.state >= StateClosing {
+		return
+	}
+
+	frame := &ConnectionCloseFrame{ErrorCode: ErrCodeInternal}
+	var te *TransportError
+	var ae *ApplicationError
+	switch {
+	case errors.As(err, &te):
+		frame.ErrorCode, frame.FrameType, frame.ReasonPhrase = te.Code, te.FrameType, te.Reason
+	case errors.As(err, &ae):
+		frame.ErrorCode, frame.ReasonPhrase, frame.Application = ae.Code, ae.Reason, true
+	}
+	c.closeErr = err
+	c.closeFrame = frame
+
+	c.sendClose(now)
+	c.enterClosing(now)
+}
+
+// sendClose sends CONNECTION_CLOSE in every space with keys. Before the
+// handshake completes an application close is sent as a transport
+// APPLICATION_ERROR without a reason, which the peer may not be able to
+// authenticate (RFC 9000 Section 10.2.3).
+func (c *Connection) sendClose(now time.Time) {
+	for _, s := range c.spaces {
+		if !s.canSend() {
+			continue
+		}
+		frame := c.closeFrame
+		if frame.Application && s.id != spaceApp {
+			frame = &ConnectionCloseFrame{ErrorCode: ErrCodeApplication}
+		}
+		if s.id == spaceApp && !c.handshakeComplete {
+			continue
+		}
+
+		b, err := frame.Serialize()
+		if err != nil {
+			continue
+		}
+		pkt := c.newPacket(s)
+		pkt.Payload = b
+		data, err := pkt.SerializeProtected(s.seal)
+		if err != nil {
+			continue
+		}
+		_, _ = c.conn.WriteTo(data, c.remoteAddr)
+		s.nextPN++
+		c.bytesSent += uint64(len(data))
+	}
+}
+
+// enterDraining handles the peer's CONNECTION_CLOSE: nothing more is sent.
+func (c *Connection) enterDraining(f *ConnectionCloseFrame, now time.Time) {
+	if f.Application {
+		c.closeErr = &ApplicationError{Code: f.ErrorCode, Reason: f.ReasonPhrase, Remote: true}
+	} else {
+		c.closeErr = &TransportError{Code: f.ErrorCode, FrameType: f.FrameType, Reason: f.ReasonPhrase, Remote: true}
+	}
+	c.draining = true
+	c.enterClosing(now)
+}
+
+// enterClosing starts the closing or draining period.
+func (c *Connection) enterClosing(now time.Time) {
+	c.state = StateClosing
+	c.closeDeadline = now.Add(3 * c.rtt.pto(c.peerMaxAckDelay()))
+	close(c.done)
+	c.streamCond.Broadcast()
+}
+
+// terminate ends the connection at once, without sending anything.
+func (c *Connection) terminate(err error) {
+	if c.state == StateClosed {
+		return
+	}
+	if c.state != StateClosing {
+		c.closeErr = err
+		close(c.done)
+	}
+	c.state = StateClosed
+	c.streamCond.Broadcast()
+	if c.tls != nil {
+		c.tls.Close()
+	}
+}
+
+// SendPacket protects pkt with the keys of its packet number space,
+// assigns it the next packet number and sends it in its own datagram.
+// Frames sent this way are not retransmitted.
+func (c *Connection) SendPacket(pkt *Packet) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.state != StateEstablished {
-		return nil, fmt.Errorf("connection not established")
+	var s *packetSpace
+	switch pkt.Type {
+	case PacketTypeInitial:
+		s = c.spaces[spaceInitial]
+	case PacketTypeHandshake:
+		s = c.spaces[spaceHandshake]
+	case PacketType1RTT:
+		s = c.spaces[spaceApp]
+	default:
+		return fmt.Errorf("cannot send %s packets", pkt.Type)
+	}
+	if !s.canSend() {
+		return fmt.Errorf("no keys for %s packets", pkt.Type)
 	}
 
-	streamID := uint64(len(c.streams) * 4) // Client-initiated bidirectional stream
-	stream := &Stream{
-		ID:      streamID,
-		sendBuf: make([]byte, 0),
-		recvBuf: make([]byte, 0),
-		offset:  0,
+	pkt.PacketNumber = s.nextPN
+	pkt.PacketNumLen = uint8(PacketNumberLength(s.nextPN, s.largestAcked))
+	data, err := pkt.SerializeProtected(s.seal)
+	if err != nil {
+		return fmt.Errorf("failed to serialize packet: %w", err)
 	}
 
-	c.streams[streamID] = stream
-	return stream, nil
+	_, err = c.conn.WriteTo(data, c.remoteAddr)
+	if err != nil {
+		return fmt.Errorf("failed to send packet: %w", err)
+	}
+
+	s.nextPN++
+	c.bytesSent += uint64(len(data))
+	return nil
 }
 
 // GetStream gets a stream by ID.
@@ -156,67 +1315,98 @@ func (c *Connection) GetStream(streamID uint64) (*Stream, error) {
 	return stream, nil
 }
 
-// SendStreamData sends data on a stream.
+// SendStreamData queues data on a stream without blocking, closing it
+// for writing if fin is set. It is sent in 1-RTT packets as flow control
+// allows and retransmitted until acknowledged.
 func (c *Connection) SendStreamData(streamID uint64, data []byte, fin bool) error {
-	stream, err := c.GetStream(streamID)
-	if err != nil {
-		return err
-	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	// Create STREAM frame
-	frame := &StreamFrame{
-		StreamID: streamID,
-		Offset:   stream.offset,
-		Data:     data,
-		Fin:      fin,
+	if c.state >= StateClosing {
+		return c.closedError()
 	}
-
-	frameData, err := frame.Serialize()
-	if err != nil {
-		return fmt.Errorf("failed to serialize frame: %w", err)
+	if c.state != StateEstablished {
+		return fmt.Errorf("connection not established")
 	}
-
-	// Create packet with frame
-	pkt := New1RTTPacket(c.RemoteConnID, frameData)
-
-	err = c.SendPacket(pkt)
-	if err != nil {
-		return err
+	stream, ok := c.streams[streamID]
+	if !ok || !stream.canSend {
+		return fmt.Errorf("stream not found: %d", streamID)
 	}
-
-	stream.offset += uint64(len(data))
-	if fin {
-		stream.finished = true
+	if err := stream.writeError(); err != nil {
+		return err
 	}
 
+	stream.sendBuf = append(stream.sendBuf, data...)
+	stream.finished = fin
+	c.queueStream(stream)
+	c.signal()
 	return nil
 }
 
-// Close closes the connection.
+// Close closes the connection with an application error code. The peer
+// is told with CONNECTION_CLOSE; Close does not wait for the closing
+// period to end.
 func (c *Connection) Close(errorCode uint64, reason string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.state == StateClosed {
+	if c.state >= StateClosing {
 		return nil
 	}
-
-	// Send CONNECTION_CLOSE frame
-	frame := &ConnectionCloseFrame{
-		ErrorCode:    errorCode,
-		ReasonPhrase: reason,
+	if c.tls == nil {
+		// Never started
+		c.state = StateClosed
+		close(c.done)
+		return nil
 	}
 
-	frameData, err := frame.Serialize()
-	if err != nil {
-		return fmt.Errorf("failed to serialize close frame: %w", err)
+	c.closeLocked(&ApplicationError{Code: errorCode, Reason: reason}, time.Now())
+	c.signal()
+	return nil
+}
+
+// Done returns a channel that is closed when the connection starts
+// closing, by either endpoint or by timeout.
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection closed, or nil while it is open.
+func (c *Connection) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closeErr
+}
+
+// NegotiatedProtocol returns the application protocol chosen with ALPN.
+func (c *Connection) NegotiatedProtocol() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.tls == nil || !c.handshakeComplete {
+		return ""
 	}
+	return c.tls.ConnectionState().NegotiatedProtocol
+}
 
-	pkt := New1RTTPacket(c.RemoteConnID, frameData)
-	_ = c.SendPacket(pkt) // Best effort
+// HandshakeConfirmed reports whether the handshake is confirmed: the
+// server confirms on completion, the client on receiving HANDSHAKE_DONE.
+func (c *Connection) HandshakeConfirmed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.handshakeConfirmed
+}
 
-	c.state = StateClosed
-	return nil
+// RTT returns the smoothed round-trip time estimate.
+func (c *Connection) RTT() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rtt.smoothed
+}
+
+// RemoteAddr returns the peer's address.
+func (c *Connection) RemoteAddr() net.Addr {
+	return c.remoteAddr
 }
 
 // GetState returns the current connection state.
@@ -238,22 +1428,8 @@ func (c *Connection) String() string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	stateStr := "Unknown"
-	switch c.state {
-	case StateIdle:
-		stateStr = "Idle"
-	case StateHandshaking:
-		stateStr = "Handshaking"
-	case StateEstablished:
-		stateStr = "Established"
-	case StateClosing:
-		stateStr = "Closing"
-	case StateClosed:
-		stateStr = "Closed"
-	}
-
 	return fmt.Sprintf("QUIC{State=%s, LocalConnID=%x, RemoteConnID=%x, Streams=%d}",
-		stateStr, c.LocalConnID, c.RemoteConnID, len(c.streams))
+		c.state, c.LocalConnID, c.RemoteConnID, len(c.streams))
 }
 
 // String returns a string representation of the state.
@@ -0,0 +1,329 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// testTLSConfigs returns server and client TLS configs sharing a
+// self-signed certificate for "localhost".
+func testTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{"hq-interop"},
+	}
+	client = &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+		NextProtos: []string{"hq-interop"},
+	}
+	return server, client
+}
+
+// testWire connects two UDP sockets of this stack. drop, if set, decides
+// whether to lose each datagram, numbered from 0 in each direction.
+type testWire struct {
+	client, server *udp.PacketConn
+
+	mu   sync.Mutex
+	drop func(toServer bool, n int) bool
+	sent [2]int
+}
+
+func newTestWire(t *testing.T) *testWire {
+	t.Helper()
+
+	w := &testWire{}
+	clientAddr := udp.Address{IP: common.IPv4Address{10, 0, 0, 1}, Port: 50000}
+	serverAddr := udp.Address{IP: common.IPv4Address{10, 0, 0, 2}, Port: 4433}
+
+	clientSocket, serverSocket := udp.NewSocket(), udp.NewSocket()
+	if err := clientSocket.Bind(clientAddr); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	if err := serverSocket.Bind(serverAddr); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+
+	w.client = udp.NewPacketConn(clientSocket, func(pkt *udp.Packet, to udp.Address) error {
+		if w.lose(true) {
+			return nil
+		}
+		serverSocket.Receive(pkt.Data, clientAddr)
+		return nil
+	})
+	w.server = udp.NewPacketConn(serverSocket, func(pkt *udp.Packet, to udp.Address) error {
+		if w.lose(false) {
+			return nil
+		}
+		clientSocket.Receive(pkt.Data, serverAddr)
+		return nil
+	})
+	t.Cleanup(func() {
+		w.client.Close()
+		w.server.Close()
+	})
+	return w
+}
+
+func (w *testWire) lose(toServer bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dir := 0
+	if toServer {
+		dir = 1
+	}
+	n := w.sent[dir]
+	w.sent[dir]++
+	return w.drop != nil && w.drop(toServer, n)
+}
+
+// handshake dials from the client end of the wire and accepts on the
+// server end.
+func handshake(t *testing.T, w *testWire) (client, server *Connection) {
+	t.Helper()
+	return handshakeWithParams(t, w, nil, nil)
+}
+
+// handshakeWithParams is handshake with the given transport parameters;
+// nil selects the defaults.
+func handshakeWithParams(t *testing.T, w *testWire, serverParams, clientParams *TransportParameters) (client, server *Connection) {
+	t.Helper()
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+	l, err := Listen(w.server, &Config{TLSConfig: serverTLS, TransportParameters: serverParams})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	accepted := make(chan *Connection, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			t.Errorf("Accept() error = %v", err)
+		}
+		accepted <- c
+	}()
+
+	client, err = Dial(w.client, l.Addr(), &Config{TLSConfig: clientTLS, TransportParameters: clientParams, HandshakeTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close(0, "") })
+
+	select {
+	case server = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept() did not return")
+	}
+	if server == nil {
+		t.FailNow()
+	}
+	return client, server
+}
+
+// eventually polls cond until it holds or a deadline passes.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	w := newTestWire(t)
+	client, server := handshake(t, w)
+
+	if client.GetState() != StateEstablished || server.GetState() != StateEstablished {
+		t.Errorf("states = %v/%v, want Established", client.GetState(), server.GetState())
+	}
+	if got := client.NegotiatedProtocol(); got != "hq-interop" {
+		t.Errorf("NegotiatedProtocol() = %q, want hq-interop", got)
+	}
+	if !server.HandshakeConfirmed() {
+		t.Error("server handshake not confirmed")
+	}
+	eventually(t, "HANDSHAKE_DONE", client.HandshakeConfirmed)
+
+	// Both sides use the IDs the other chose during the handshake
+	if string(client.RemoteConnID) != string(server.LocalConnID) {
+		t.Errorf("client RemoteConnID = %x, want %x", client.RemoteConnID, server.LocalConnID)
+	}
+	if string(server.RemoteConnID) != string(client.LocalConnID) {
+		t.Errorf("server RemoteConnID = %x, want %x", server.RemoteConnID, client.LocalConnID)
+	}
+
+	// Spare connection IDs arrive in NEW_CONNECTION_ID frames
+	eventually(t, "NEW_CONNECTION_ID", func() bool {
+		client.mu.RLock()
+		defer client.mu.RUnlock()
+		return len(client.connIDs.remote) == int(DefaultTransportParameters().ActiveConnIDLimit)
+	})
+
+	if client.RTT() <= 0 || client.RTT() >= initialRTT {
+		t.Errorf("RTT() = %v, want a measured sample", client.RTT())
+	}
+}
+
+func TestHandshakeWithLoss(t *testing.T) {
+	w := newTestWire(t)
+
+	// Lose the client's first Initial and the server's first flight, so
+	// both sides must recover by probe timeout
+	w.drop = func(toServer bool, n int) bool { return n == 0 }
+
+	client, server := handshake(t, w)
+	if client.GetState() != StateEstablished || server.GetState() != StateEstablished {
+		t.Errorf("states = %v/%v, want Established", client.GetState(), server.GetState())
+	}
+	eventually(t, "HANDSHAKE_DONE", client.HandshakeConfirmed)
+}
+
+func TestStreamData(t *testing.T) {
+	w := newTestWire(t)
+	client, server := handshake(t, w)
+
+	// Lose every third datagram from the client; retransmission fills the
+	// gaps
+	var n atomic.Int32
+	w.mu.Lock()
+	w.drop = func(toServer bool, _ int) bool { return toServer && n.Add(1)%3 == 0 }
+	w.mu.Unlock()
+
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	data := make([]byte, 5000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := client.SendStreamData(stream.ID, data, true); err != nil {
+		t.Fatalf("SendStreamData() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	accepted, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	if accepted.ID != stream.ID {
+		t.Errorf("accepted stream %d, want %d", accepted.ID, stream.ID)
+	}
+	accepted.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(accepted)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("server received corrupted stream data")
+	}
+}
+
+func TestClose(t *testing.T) {
+	w := newTestWire(t)
+	client, server := handshake(t, w)
+
+	if err := client.Close(0x42, "bye"); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	select {
+	case <-server.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not see CONNECTION_CLOSE")
+	}
+
+	var appErr *ApplicationError
+	if !errors.As(server.Err(), &appErr) || appErr.Code != 0x42 || appErr.Reason != "bye" || !appErr.Remote {
+		t.Errorf("server Err() = %v, want remote application error 0x42", server.Err())
+	}
+	if err := client.SendStreamData(0, []byte("x"), false); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("SendStreamData() after Close error = %v, want ErrConnectionClosed", err)
+	}
+	eventually(t, "closed state", func() bool { return server.GetState() == StateClosed })
+}
+
+func TestDialUntrustedCertificate(t *testing.T) {
+	w := newTestWire(t)
+	serverTLS, clientTLS := testTLSConfigs(t)
+	clientTLS.RootCAs = x509.NewCertPool()
+
+	l, err := Listen(w.server, &Config{TLSConfig: serverTLS})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	_, err = Dial(w.client, l.Addr(), &Config{TLSConfig: clientTLS, HandshakeTimeout: 5 * time.Second})
+	var te *TransportError
+	if !errors.As(err, &te) || te.Code < ErrCodeCryptoError || te.Code > ErrCodeCryptoError+0xff {
+		t.Errorf("Dial() error = %v, want a CRYPTO_ERROR", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	w := newTestWire(t)
+	_, clientTLS := testTLSConfigs(t)
+
+	// Nobody listens on the server end
+	_, err := Dial(w.client, w.server.LocalAddr(), &Config{TLSConfig: clientTLS, HandshakeTimeout: 200 * time.Millisecond})
+	if !errors.Is(err, ErrHandshakeTimeout) {
+		t.Errorf("Dial() error = %v, want ErrHandshakeTimeout", err)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	if _, err := Dial(nil, nil, &Config{}); err == nil {
+		t.Error("Dial() without TLSConfig succeeded")
+	}
+	if _, err := Listen(nil, &Config{TLSConfig: &tls.Config{}}); err == nil {
+		t.Error("Listen() without certificate succeeded")
+	}
+}
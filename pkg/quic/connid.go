@@ -0,0 +1,173 @@
+package quic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+)
+
+// connIDManager tracks the connection IDs of both endpoints (RFC 9000
+// Section 5.1). Local IDs are issued to the peer with NEW_CONNECTION_ID
+// frames and routed to the connection by the endpoint; remote IDs are
+// issued by the peer, and the active one is used as the Destination
+// Connection ID of outgoing packets.
+type connIDManager struct {
+	length int
+
+	// Local connection IDs by sequence number
+	local        map[uint64][]byte
+	nextLocalSeq uint64
+
+	// Remote connection IDs by sequence number, and the one in use
+	remote        map[uint64][]byte
+	activeRemote  uint64
+	retirePriorTo uint64
+	remoteLimit   uint64 // Our active_connection_id_limit
+
+	// Frames awaiting transmission
+	pending []Frame
+
+	// Callbacks to route local IDs to the connection
+	onIssue  func(id []byte)
+	onRetire func(id []byte)
+}
+
+// newConnIDManager creates a manager with the connection ID chosen for the
+// handshake as local sequence number 0.
+func newConnIDManager(length int, initial []byte, remoteLimit uint64) *connIDManager {
+	return &connIDManager{
+		length:       length,
+		local:        map[uint64][]byte{0: initial},
+		nextLocalSeq: 1,
+		remote:       make(map[uint64][]byte),
+		remoteLimit:  remoteLimit,
+	}
+}
+
+// newConnID generates a random connection ID.
+func newConnID(length int) ([]byte, error) {
+	id := make([]byte, length)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate connection ID: %w", err)
+	}
+	return id, nil
+}
+
+// setRemote records the peer's handshake connection ID as sequence number
+// 0. The client calls it again when the server picks its own ID.
+func (m *connIDManager) setRemote(id []byte) {
+	m.remote[0] = bytes.Clone(id)
+	m.activeRemote = 0
+}
+
+// activeRemoteID returns the Destination Connection ID to send with.
+func (m *connIDManager) activeRemoteID() []byte {
+	return m.remote[m.activeRemote]
+}
+
+// issue generates local connection IDs until the peer holds limit of them
+// (its active_connection_id_limit) and queues NEW_CONNECTION_ID frames.
+func (m *connIDManager) issue(limit uint64) error {
+	// Zero-length connection IDs cannot be changed
+	if m.length == 0 {
+		return nil
+	}
+
+	for uint64(len(m.local)) < limit {
+		id, err := newConnID(m.length)
+		if err != nil {
+			return err
+		}
+		f := &NewConnectionIDFrame{SequenceNumber: m.nextLocalSeq, ConnectionID: id}
+		if _, err := rand.Read(f.StatelessResetToken[:]); err != nil {
+			return fmt.Errorf("failed to generate reset token: %w", err)
+		}
+
+		m.local[m.nextLocalSeq] = id
+		m.nextLocalSeq++
+		if m.onIssue != nil {
+			m.onIssue(id)
+		}
+		m.pending = append(m.pending, f)
+	}
+	return nil
+}
+
+// handleNewConnectionID stores a connection ID issued by the peer and
+// retires those it asks us to stop using.
+func (m *connIDManager) handleNewConnectionID(f *NewConnectionIDFrame) error {
+	if existing, ok := m.remote[f.SequenceNumber]; ok {
+		if !bytes.Equal(existing, f.ConnectionID) {
+			return protocolError(ErrCodeProtocolViolation, "connection ID %d reused", f.SequenceNumber)
+		}
+		return nil
+	}
+
+	// A sequence number that was already retired is retired again at once
+	if f.SequenceNumber < m.retirePriorTo {
+		m.pending = append(m.pending, &RetireConnectionIDFrame{SequenceNumber: f.SequenceNumber})
+		return nil
+	}
+	m.remote[f.SequenceNumber] = bytes.Clone(f.ConnectionID)
+
+	if f.RetirePriorTo > m.retirePriorTo {
+		m.retirePriorTo = f.RetirePriorTo
+		for seq := range m.remote {
+			if seq < m.retirePriorTo {
+				delete(m.remote, seq)
+				m.pending = append(m.pending, &RetireConnectionIDFrame{SequenceNumber: seq})
+			}
+		}
+
+		// Move to the lowest connection ID still active
+		if _, ok := m.remote[m.activeRemote]; !ok {
+			m.activeRemote = f.SequenceNumber
+			for seq := range m.remote {
+				m.activeRemote = min(m.activeRemote, seq)
+			}
+		}
+	}
+
+	if uint64(len(m.remote)) > m.remoteLimit {
+		return protocolError(ErrCodeConnectionIDLimit, "peer exceeded %d connection IDs", m.remoteLimit)
+	}
+	return nil
+}
+
+// handleRetireConnectionID retires a local connection ID and issues a
+// replacement. dcid is the Destination Connection ID of the packet that
+// carried the frame, which may not be the one retired.
+func (m *connIDManager) handleRetireConnectionID(f *RetireConnectionIDFrame, dcid []byte, limit uint64) error {
+	if f.SequenceNumber >= m.nextLocalSeq {
+		return protocolError(ErrCodeProtocolViolation, "retired unissued connection ID %d", f.SequenceNumber)
+	}
+	id, ok := m.local[f.SequenceNumber]
+	if !ok {
+		return nil
+	}
+	if bytes.Equal(id, dcid) {
+		return protocolError(ErrCodeProtocolViolation, "retired connection ID %d in its own packet", f.SequenceNumber)
+	}
+
+	delete(m.local, f.SequenceNumber)
+	if m.onRetire != nil {
+		m.onRetire(id)
+	}
+	return m.issue(limit)
+}
+
+// localIDs returns all local connection IDs in use.
+func (m *connIDManager) localIDs() [][]byte {
+	ids := make([][]byte, 0, len(m.local))
+	for _, id := range m.local {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// takePending returns and clears the queued frames.
+func (m *connIDManager) takePending() []Frame {
+	frames := m.pending
+	m.pending = nil
+	return frames
+}
@@ -0,0 +1,81 @@
+package quic
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConnIDManagerIssue(t *testing.T) {
+	var issued, retired [][]byte
+	m := newConnIDManager(8, []byte("initial!"), 4)
+	m.onIssue = func(id []byte) { issued = append(issued, id) }
+	m.onRetire = func(id []byte) { retired = append(retired, id) }
+
+	if err := m.issue(3); err != nil {
+		t.Fatalf("issue() error = %v", err)
+	}
+	frames := m.takePending()
+	if len(frames) != 2 || len(issued) != 2 {
+		t.Fatalf("issued %d IDs in %d frames, want 2", len(issued), len(frames))
+	}
+	first := frames[0].(*NewConnectionIDFrame)
+	if first.SequenceNumber != 1 || len(first.ConnectionID) != 8 {
+		t.Errorf("first frame = %v", first)
+	}
+
+	// Retiring an ID issues a replacement
+	err := m.handleRetireConnectionID(&RetireConnectionIDFrame{SequenceNumber: 1}, []byte("initial!"), 3)
+	if err != nil {
+		t.Fatalf("handleRetireConnectionID() error = %v", err)
+	}
+	if len(retired) != 1 || !bytes.Equal(retired[0], first.ConnectionID) {
+		t.Errorf("retired = %x, want %x", retired, first.ConnectionID)
+	}
+	if got := m.takePending(); len(got) != 1 || got[0].(*NewConnectionIDFrame).SequenceNumber != 3 {
+		t.Errorf("replacement frames = %v, want sequence 3", got)
+	}
+
+	// Retiring an unissued ID or the one the packet was sent to is an error
+	if err := m.handleRetireConnectionID(&RetireConnectionIDFrame{SequenceNumber: 9}, nil, 3); err == nil {
+		t.Error("retiring an unissued ID succeeded")
+	}
+	if err := m.handleRetireConnectionID(&RetireConnectionIDFrame{SequenceNumber: 0}, []byte("initial!"), 3); err == nil {
+		t.Error("retiring the packet's own ID succeeded")
+	}
+}
+
+func TestConnIDManagerNewConnectionID(t *testing.T) {
+	m := newConnIDManager(8, []byte("localid!"), 3)
+	m.setRemote([]byte("remote-0"))
+
+	if err := m.handleNewConnectionID(&NewConnectionIDFrame{SequenceNumber: 1, ConnectionID: []byte("remote-1")}); err != nil {
+		t.Fatalf("handleNewConnectionID() error = %v", err)
+	}
+	if !bytes.Equal(m.activeRemoteID(), []byte("remote-0")) {
+		t.Errorf("active = %q, want remote-0", m.activeRemoteID())
+	}
+
+	// Retire Prior To moves off the retired IDs
+	err := m.handleNewConnectionID(&NewConnectionIDFrame{SequenceNumber: 2, RetirePriorTo: 1, ConnectionID: []byte("remote-2")})
+	if err != nil {
+		t.Fatalf("handleNewConnectionID() error = %v", err)
+	}
+	if !bytes.Equal(m.activeRemoteID(), []byte("remote-1")) {
+		t.Errorf("active = %q, want remote-1", m.activeRemoteID())
+	}
+	if got := m.takePending(); len(got) != 1 || got[0].(*RetireConnectionIDFrame).SequenceNumber != 0 {
+		t.Errorf("pending = %v, want RETIRE_CONNECTION_ID 0", got)
+	}
+
+	// A reused sequence number with a different ID is a violation
+	if err := m.handleNewConnectionID(&NewConnectionIDFrame{SequenceNumber: 2, ConnectionID: []byte("other-id")}); err == nil {
+		t.Error("reused sequence number accepted")
+	}
+
+	// Exceeding our active_connection_id_limit is an error
+	m.handleNewConnectionID(&NewConnectionIDFrame{SequenceNumber: 3, RetirePriorTo: 1, ConnectionID: []byte("remote-3")})
+	err = m.handleNewConnectionID(&NewConnectionIDFrame{SequenceNumber: 4, RetirePriorTo: 1, ConnectionID: []byte("remote-4")})
+	if te, ok := err.(*TransportError); !ok || te.Code != ErrCodeConnectionIDLimit {
+		t.Errorf("error = %v, want CONNECTION_ID_LIMIT_ERROR", err)
+	}
+}
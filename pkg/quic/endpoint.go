@@ -0,0 +1,263 @@
+package quic
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// acceptQueueLength bounds connections waiting for Accept.
+const acceptQueueLength = 16
+
+// endpoint reads datagrams from a PacketConn and routes them to
+// connections by Destination Connection ID. A server endpoint creates a
+// connection for each new client Initial packet.
+type endpoint struct {
+	conn   net.PacketConn
+	config *Config
+	server bool
+
+	mu    sync.Mutex
+	conns map[string]*Connection // By local connection ID
+
+	accepted  chan *Connection
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newEndpoint(pc net.PacketConn, config *Config, server bool) *endpoint {
+	return &endpoint{
+		conn:     pc,
+		config:   config,
+		server:   server,
+		conns:    make(map[string]*Connection),
+		accepted: make(chan *Connection, acceptQueueLength),
+		closed:   make(chan struct{}),
+	}
+}
+
+// readLoop reads datagrams until the endpoint or the PacketConn is closed.
+func (e *endpoint) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := e.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-e.closed:
+				// Give the PacketConn back without the deadline that
+				// stopped the loop
+				_ = e.conn.SetReadDeadline(time.Time{})
+				return
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			e.close()
+			return
+		}
+		e.handleDatagram(append([]byte(nil), buf[:n]...), addr)
+	}
+}
+
+// handleDatagram routes a datagram to its connection.
+func (e *endpoint) handleDatagram(data []byte, from net.Addr) {
+	dcid, ok := e.destConnID(data)
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	c := e.conns[string(dcid)]
+	e.mu.Unlock()
+
+	if c == nil {
+		if !e.server {
+			return
+		}
+		if c = e.newConnection(data, dcid, from); c == nil {
+			return
+		}
+	}
+	c.deliver(datagram{data: data, from: from})
+}
+
+// destConnID extracts the Destination Connection ID of the first packet.
+// Short headers carry no length, so the endpoint's own length is used.
+func (e *endpoint) destConnID(data []byte) ([]byte, bool) {
+	if len(data) < 1 {
+		return nil, false
+	}
+	if data[0]&0x80 == 0 {
+		if len(data) < 1+e.config.ConnIDLength {
+			return nil, false
+		}
+		return data[1 : 1+e.config.ConnIDLength], true
+	}
+	if len(data) < 6 || int(data[5]) > MaxConnIDLength || len(data) < 6+int(data[5]) {
+		return nil, false
+	}
+	return data[6 : 6+int(data[5])], true
+}
+
+// newConnection creates a server connection for a client's first Initial
+// packet. Other unknown packets are dropped, and unsupported versions are
+// answered with Version Negotiation.
+func (e *endpoint) newConnection(data, dcid []byte, from net.Addr) *Connection {
+	// Clients pad their first datagrams, so smaller ones are not answered
+	if data[0]&0x80 == 0 || len(data) < MinInitialDatagramSize {
+		return nil
+	}
+	pkt, _, _, err := parseLongHeader(data)
+	if err != nil {
+		return nil
+	}
+	if pkt.Version != Version1 {
+		if pkt.Version != 0 {
+			e.sendVersionNegotiation(pkt, from)
+		}
+		return nil
+	}
+	if pkt.Type != PacketTypeInitial || len(dcid) < 8 {
+		return nil
+	}
+
+	select {
+	case <-e.closed:
+		return nil
+	default:
+	}
+
+	c, err := newServerConnection(e, from, dcid, pkt.SrcConnID)
+	if err != nil {
+		return nil
+	}
+	go c.run()
+	return c
+}
+
+// sendVersionNegotiation lists the supported versions, echoing the
+// client's connection IDs swapped (RFC 9000 Section 17.2.1).
+func (e *endpoint) sendVersionNegotiation(pkt *Packet, to net.Addr) {
+	b := []byte{0x80 | 0x40}
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = append(b, byte(len(pkt.SrcConnID)))
+	b = append(b, pkt.SrcConnID...)
+	b = append(b, byte(len(pkt.DestConnID)))
+	b = append(b, pkt.DestConnID...)
+	b = binary.BigEndian.AppendUint32(b, Version1)
+	_, _ = e.conn.WriteTo(b, to)
+}
+
+// register routes packets for a local connection ID to c.
+func (e *endpoint) register(id []byte, c *Connection) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.conns[string(id)] = c
+}
+
+// unregister stops routing a retired connection ID.
+func (e *endpoint) unregister(id []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.conns, string(id))
+}
+
+// remove unregisters all IDs of a closed connection. A client endpoint
+// stops with its connection.
+func (e *endpoint) remove(c *Connection) {
+	e.mu.Lock()
+	for id, conn := range e.conns {
+		if conn == c {
+			delete(e.conns, id)
+		}
+	}
+	e.mu.Unlock()
+
+	if !e.server {
+		e.close()
+	}
+}
+
+// accept queues a connection that completed its handshake for Accept,
+// refusing it if the queue is full.
+func (e *endpoint) accept(c *Connection) {
+	select {
+	case e.accepted <- c:
+	default:
+		c.closeLocked(protocolError(ErrCodeConnectionRefused, "accept queue full"), time.Now())
+	}
+}
+
+// close stops the read loop, unblocking it with a past deadline. The
+// PacketConn itself belongs to the caller.
+func (e *endpoint) close() {
+	e.closeOnce.Do(func() {
+		close(e.closed)
+		_ = e.conn.SetReadDeadline(time.Now())
+	})
+}
+
+// connections returns the open connections.
+func (e *endpoint) connections() []*Connection {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	seen := make(map[*Connection]bool)
+	var conns []*Connection
+	for _, c := range e.conns {
+		if !seen[c] {
+			seen[c] = true
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// Listener accepts QUIC connections on a PacketConn.
+type Listener struct {
+	ep *endpoint
+}
+
+// Listen starts accepting connections on pc. The config's TLSConfig must
+// hold the server certificate.
+func Listen(pc net.PacketConn, config *Config) (*Listener, error) {
+	cfg, err := config.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.TLSConfig.Certificates) == 0 && cfg.TLSConfig.GetCertificate == nil {
+		return nil, errors.New("quic: server TLSConfig has no certificate")
+	}
+
+	ep := newEndpoint(pc, cfg, true)
+	go ep.readLoop()
+	return &Listener{ep: ep}, nil
+}
+
+// Accept returns the next connection whose handshake has completed.
+func (l *Listener) Accept() (*Connection, error) {
+	select {
+	case c := <-l.ep.accepted:
+		return c, nil
+	case <-l.ep.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close stops accepting and closes all connections of the listener.
+func (l *Listener) Close() error {
+	for _, c := range l.ep.connections() {
+		c.Close(ErrCodeNoError, "server closing")
+	}
+	l.ep.close()
+	return nil
+}
+
+// Addr returns the local address of the PacketConn.
+func (l *Listener) Addr() net.Addr {
+	return l.ep.conn.LocalAddr()
+}
@@ -0,0 +1,86 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Transport error codes carried in CONNECTION_CLOSE frames (RFC 9000
+// Section 20.1).
+const (
+	ErrCodeNoError              uint64 = 0x00
+	ErrCodeInternal             uint64 = 0x01
+	ErrCodeConnectionRefused    uint64 = 0x02
+	ErrCodeFlowControl          uint64 = 0x03
+	ErrCodeStreamLimit          uint64 = 0x04
+	ErrCodeStreamState          uint64 = 0x05
+	ErrCodeFinalSize            uint64 = 0x06
+	ErrCodeFrameEncoding        uint64 = 0x07
+	ErrCodeTransportParameter   uint64 = 0x08
+	ErrCodeConnectionIDLimit    uint64 = 0x09
+	ErrCodeProtocolViolation    uint64 = 0x0a
+	ErrCodeInvalidToken         uint64 = 0x0b
+	ErrCodeApplication          uint64 = 0x0c
+	ErrCodeCryptoBufferExceeded uint64 = 0x0d
+	ErrCodeKeyUpdate            uint64 = 0x0e
+	ErrCodeAEADLimitReached     uint64 = 0x0f
+	ErrCodeNoViablePath         uint64 = 0x10
+	ErrCodeCryptoError          uint64 = 0x0100 // 0x0100-0x01ff carry a TLS alert
+)
+
+var (
+	// ErrIdleTimeout is returned once a connection has been silently
+	// closed by its idle timeout.
+	ErrIdleTimeout = errors.New("quic: idle timeout")
+
+	// ErrHandshakeTimeout is returned when the handshake does not finish in
+	// time.
+	ErrHandshakeTimeout = errors.New("quic: handshake timeout")
+
+	// ErrConnectionClosed is returned for operations on a closed
+	// connection.
+	ErrConnectionClosed = errors.New("quic: connection closed")
+
+	// ErrListenerClosed is returned by Accept after the listener is closed.
+	ErrListenerClosed = errors.New("quic: listener closed")
+)
+
+// TransportError is a connection error signalled with a transport error
+// code, by this endpoint or by the peer.
+type TransportError struct {
+	Code      uint64
+	FrameType uint64 // Type of the frame that triggered the error, if known
+	Reason    string
+	Remote    bool // Received from the peer
+}
+
+func (e *TransportError) Error() string {
+	side := "local"
+	if e.Remote {
+		side = "remote"
+	}
+	if e.Reason == "" {
+		return fmt.Sprintf("quic: %s transport error 0x%x", side, e.Code)
+	}
+	return fmt.Sprintf("quic: %s transport error 0x%x: %s", side, e.Code, e.Reason)
+}
+
+// ApplicationError is a connection closed by the application protocol.
+type ApplicationError struct {
+	Code   uint64
+	Reason string
+	Remote bool // Received from the peer
+}
+
+func (e *ApplicationError) Error() string {
+	side := "local"
+	if e.Remote {
+		side = "remote"
+	}
+	return fmt.Sprintf("quic: %s application error 0x%x: %s", side, e.Code, e.Reason)
+}
+
+// protocolError returns a local transport error.
+func protocolError(code uint64, format string, args ...any) *TransportError {
+	return &TransportError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
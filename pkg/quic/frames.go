@@ -2,7 +2,6 @@
 package quic
 
 import (
-	"encoding/binary"
 	"fmt"
 )
 
@@ -13,6 +12,7 @@ const (
 	FrameTypePadding         FrameType = 0x00
 	FrameTypePing            FrameType = 0x01
 	FrameTypeAck             FrameType = 0x02
+	FrameTypeAckECN          FrameType = 0x03
 	FrameTypeResetStream     FrameType = 0x04
 	FrameTypeStopSending     FrameType = 0x05
 	FrameTypeCrypto          FrameType = 0x06
@@ -21,14 +21,17 @@ const (
 	FrameTypeMaxData         FrameType = 0x10
 	FrameTypeMaxStreamData   FrameType = 0x11
 	FrameTypeMaxStreams      FrameType = 0x12
+	FrameTypeMaxStreamsUni   FrameType = 0x13
 	FrameTypeDataBlocked     FrameType = 0x14
 	FrameTypeStreamDataBlocked FrameType = 0x15
 	FrameTypeStreamsBlocked  FrameType = 0x16
+	FrameTypeStreamsBlockedUni FrameType = 0x17
 	FrameTypeNewConnectionID FrameType = 0x18
 	FrameTypeRetireConnectionID FrameType = 0x19
 	FrameTypePathChallenge   FrameType = 0x1a
 	FrameTypePathResponse    FrameType = 0x1b
 	FrameTypeConnectionClose FrameType = 0x1c
+	FrameTypeConnectionCloseApp FrameType = 0x1d
 	FrameTypeHandshakeDone   FrameType = 0x1e
 )
 
@@ -71,11 +74,16 @@ func (f *PingFrame) String() string {
 	return "PING"
 }
 
-// AckFrame represents an ACK frame.
+// AckFrame represents an ACK frame. FirstAckRange counts the packets
+// acknowledged below LargestAcknowledged; each further AckRange skips Gap+1
+// unacknowledged packets and acknowledges Length+1 more (RFC 9000 Section
+// 19.3.1).
 type AckFrame struct {
 	LargestAcknowledged uint64
 	AckDelay            uint64
+	FirstAckRange       uint64
 	AckRanges           []AckRange
+	ECN                 *ECNCounts // ECN counts (ACK_ECN frames only)
 }
 
 type AckRange struct {
@@ -83,26 +91,74 @@ type AckRange struct {
 	Length uint64
 }
 
+// ECNCounts are the ECN counts of an ACK_ECN frame.
+type ECNCounts struct {
+	ECT0, ECT1, CE uint64
+}
+
 func (f *AckFrame) Type() FrameType {
+	if f.ECN != nil {
+		return FrameTypeAckECN
+	}
 	return FrameTypeAck
 }
 
 func (f *AckFrame) Serialize() ([]byte, error) {
-	// Simplified serialization
-	buf := make([]byte, 1+8+8+1) // Type + Largest + Delay + RangeCount
-	buf[0] = byte(FrameTypeAck)
-	binary.BigEndian.PutUint64(buf[1:9], f.LargestAcknowledged)
-	binary.BigEndian.PutUint64(buf[9:17], f.AckDelay)
-	buf[17] = byte(len(f.AckRanges))
-	return buf, nil
+	if f.FirstAckRange > f.LargestAcknowledged {
+		return nil, fmt.Errorf("first ACK range %d exceeds largest acknowledged %d",
+			f.FirstAckRange, f.LargestAcknowledged)
+	}
+
+	w := frameWriter{buf: []byte{byte(f.Type())}}
+	w.varint(f.LargestAcknowledged)
+	w.varint(f.AckDelay)
+	w.varint(uint64(len(f.AckRanges)))
+	w.varint(f.FirstAckRange)
+	for _, r := range f.AckRanges {
+		w.varint(r.Gap)
+		w.varint(r.Length)
+	}
+	if f.ECN != nil {
+		w.varint(f.ECN.ECT0)
+		w.varint(f.ECN.ECT1)
+		w.varint(f.ECN.CE)
+	}
+	return w.bytes()
+}
+
+// Acknowledges reports whether the frame acknowledges packet number pn.
+func (f *AckFrame) Acknowledges(pn uint64) bool {
+	largest := f.LargestAcknowledged
+	smallest := largest - f.FirstAckRange
+	if pn <= largest && pn >= smallest {
+		return true
+	}
+
+	for _, r := range f.AckRanges {
+		// The next range ends Gap+2 below the previous range's smallest
+		if smallest < r.Gap+2 {
+			return false
+		}
+		largest = smallest - r.Gap - 2
+		if largest < r.Length {
+			return false
+		}
+		smallest = largest - r.Length
+		if pn <= largest && pn >= smallest {
+			return true
+		}
+	}
+	return false
 }
 
 func (f *AckFrame) String() string {
 	return fmt.Sprintf("ACK{Largest=%d, Delay=%d, Ranges=%d}",
-		f.LargestAcknowledged, f.AckDelay, len(f.AckRanges))
+		f.LargestAcknowledged, f.AckDelay, len(f.AckRanges)+1)
 }
 
-// StreamFrame represents a STREAM frame.
+// StreamFrame represents a STREAM frame. Length is set by ParseFrame;
+// Serialize always encodes the length of Data, so frames can be followed
+// by others in the same packet.
 type StreamFrame struct {
 	StreamID uint64
 	Offset   uint64
@@ -111,46 +167,35 @@ type StreamFrame struct {
 	Data     []byte
 }
 
+// STREAM frame type bits
+const (
+	streamFlagFin    = 0x01
+	streamFlagLength = 0x02
+	streamFlagOffset = 0x04
+)
+
 func (f *StreamFrame) Type() FrameType {
 	return FrameTypeStream
 }
 
 func (f *StreamFrame) Serialize() ([]byte, error) {
 	// Calculate type byte with flags
-	typeByte := byte(FrameTypeStream)
+	typeByte := byte(FrameTypeStream) | streamFlagLength
 	if f.Fin {
-		typeByte |= 0x01
-	}
-	if f.Length > 0 {
-		typeByte |= 0x02
+		typeByte |= streamFlagFin
 	}
 	if f.Offset > 0 {
-		typeByte |= 0x04
+		typeByte |= streamFlagOffset
 	}
 
-	// Simplified serialization
-	buf := make([]byte, 1+8+8+8+len(f.Data))
-	offset := 0
-
-	buf[offset] = typeByte
-	offset++
-
-	binary.BigEndian.PutUint64(buf[offset:], f.StreamID)
-	offset += 8
-
+	w := frameWriter{buf: []byte{typeByte}}
+	w.varint(f.StreamID)
 	if f.Offset > 0 {
-		binary.BigEndian.PutUint64(buf[offset:], f.Offset)
-		offset += 8
+		w.varint(f.Offset)
 	}
-
-	if f.Length > 0 {
-		binary.BigEndian.PutUint64(buf[offset:], f.Length)
-		offset += 8
-	}
-
-	copy(buf[offset:], f.Data)
-
-	return buf[:offset+len(f.Data)], nil
+	w.varint(uint64(len(f.Data)))
+	w.buf = append(w.buf, f.Data...)
+	return w.bytes()
 }
 
 func (f *StreamFrame) String() string {
@@ -169,38 +214,42 @@ func (f *CryptoFrame) Type() FrameType {
 }
 
 func (f *CryptoFrame) Serialize() ([]byte, error) {
-	buf := make([]byte, 1+8+8+len(f.Data))
-	buf[0] = byte(FrameTypeCrypto)
-	binary.BigEndian.PutUint64(buf[1:9], f.Offset)
-	binary.BigEndian.PutUint64(buf[9:17], uint64(len(f.Data)))
-	copy(buf[17:], f.Data)
-	return buf, nil
+	w := frameWriter{buf: []byte{byte(FrameTypeCrypto)}}
+	w.varint(f.Offset)
+	w.varint(uint64(len(f.Data)))
+	w.buf = append(w.buf, f.Data...)
+	return w.bytes()
 }
 
 func (f *CryptoFrame) String() string {
 	return fmt.Sprintf("CRYPTO{Offset=%d, Len=%d}", f.Offset, len(f.Data))
 }
 
-// ConnectionCloseFrame represents a CONNECTION_CLOSE frame.
+// ConnectionCloseFrame represents a CONNECTION_CLOSE frame. Application
+// closes (type 0x1d) carry no frame type.
 type ConnectionCloseFrame struct {
 	ErrorCode    uint64
 	FrameType    uint64
 	ReasonPhrase string
+	Application  bool
 }
 
 func (f *ConnectionCloseFrame) Type() FrameType {
+	if f.Application {
+		return FrameTypeConnectionCloseApp
+	}
 	return FrameTypeConnectionClose
 }
 
 func (f *ConnectionCloseFrame) Serialize() ([]byte, error) {
-	reasonBytes := []byte(f.ReasonPhrase)
-	buf := make([]byte, 1+8+8+8+len(reasonBytes))
-	buf[0] = byte(FrameTypeConnectionClose)
-	binary.BigEndian.PutUint64(buf[1:9], f.ErrorCode)
-	binary.BigEndian.PutUint64(buf[9:17], f.FrameType)
-	binary.BigEndian.PutUint64(buf[17:25], uint64(len(reasonBytes)))
-	copy(buf[25:], reasonBytes)
-	return buf, nil
+	w := frameWriter{buf: []byte{byte(f.Type())}}
+	w.varint(f.ErrorCode)
+	if !f.Application {
+		w.varint(f.FrameType)
+	}
+	w.varint(uint64(len(f.ReasonPhrase)))
+	w.buf = append(w.buf, f.ReasonPhrase...)
+	return w.bytes()
 }
 
 func (f *ConnectionCloseFrame) String() string {
@@ -217,29 +266,320 @@ func (f *MaxDataFrame) Type() FrameType {
 }
 
 func (f *MaxDataFrame) Serialize() ([]byte, error) {
-	buf := make([]byte, 9)
-	buf[0] = byte(FrameTypeMaxData)
-	binary.BigEndian.PutUint64(buf[1:9], f.MaximumData)
-	return buf, nil
+	w := frameWriter{buf: []byte{byte(FrameTypeMaxData)}}
+	w.varint(f.MaximumData)
+	return w.bytes()
 }
 
 func (f *MaxDataFrame) String() string {
 	return fmt.Sprintf("MAX_DATA{Max=%d}", f.MaximumData)
 }
 
-// ParseFrame parses a frame from bytes (simplified).
+// MaxStreamsLimit is the largest stream count a MAX_STREAMS or
+// STREAMS_BLOCKED frame may carry (2^60).
+const MaxStreamsLimit = 1 << 60
+
+// ResetStreamFrame represents a RESET_STREAM frame, abandoning the sending
+// part of a stream.
+type ResetStreamFrame struct {
+	StreamID  uint64
+	ErrorCode uint64
+	FinalSize uint64
+}
+
+func (f *ResetStreamFrame) Type() FrameType {
+	return FrameTypeResetStream
+}
+
+func (f *ResetStreamFrame) Serialize() ([]byte, error) {
+	w := frameWriter{buf: []byte{byte(FrameTypeResetStream)}}
+	w.varint(f.StreamID)
+	w.varint(f.ErrorCode)
+	w.varint(f.FinalSize)
+	return w.bytes()
+}
+
+func (f *ResetStreamFrame) String() string {
+	return fmt.Sprintf("RESET_STREAM{ID=%d, Code=%d, FinalSize=%d}", f.StreamID, f.ErrorCode, f.FinalSize)
+}
+
+// StopSendingFrame represents a STOP_SENDING frame, asking the peer to
+// reset a stream.
+type StopSendingFrame struct {
+	StreamID  uint64
+	ErrorCode uint64
+}
+
+func (f *StopSendingFrame) Type() FrameType {
+	return FrameTypeStopSending
+}
+
+func (f *StopSendingFrame) Serialize() ([]byte, error) {
+	w := frameWriter{buf: []byte{byte(FrameTypeStopSending)}}
+	w.varint(f.StreamID)
+	w.varint(f.ErrorCode)
+	return w.bytes()
+}
+
+func (f *StopSendingFrame) String() string {
+	return fmt.Sprintf("STOP_SENDING{ID=%d, Code=%d}", f.StreamID, f.ErrorCode)
+}
+
+// MaxStreamDataFrame represents a MAX_STREAM_DATA frame.
+type MaxStreamDataFrame struct {
+	StreamID          uint64
+	MaximumStreamData uint64
+}
+
+func (f *MaxStreamDataFrame) Type() FrameType {
+	return FrameTypeMaxStreamData
+}
+
+func (f *MaxStreamDataFrame) Serialize() ([]byte, error) {
+	w := frameWriter{buf: []byte{byte(FrameTypeMaxStreamData)}}
+	w.varint(f.StreamID)
+	w.varint(f.MaximumStreamData)
+	return w.bytes()
+}
+
+func (f *MaxStreamDataFrame) String() string {
+	return fmt.Sprintf("MAX_STREAM_DATA{ID=%d, Max=%d}", f.StreamID, f.MaximumStreamData)
+}
+
+// MaxStreamsFrame represents a MAX_STREAMS frame for bidirectional or
+// unidirectional streams.
+type MaxStreamsFrame struct {
+	Unidirectional bool
+	MaximumStreams uint64
+}
+
+func (f *MaxStreamsFrame) Type() FrameType {
+	if f.Unidirectional {
+		return FrameTypeMaxStreamsUni
+	}
+	return FrameTypeMaxStreams
+}
+
+func (f *MaxStreamsFrame) Serialize() ([]byte, error) {
+	if f.MaximumStreams > MaxStreamsLimit {
+		return nil, fmt.Errorf("stream count %d exceeds 2^60", f.MaximumStreams)
+	}
+	w := frameWriter{buf: []byte{byte(f.Type())}}
+	w.varint(f.MaximumStreams)
+	return w.bytes()
+}
+
+func (f *MaxStreamsFrame) String() string {
+	return fmt.Sprintf("MAX_STREAMS{Uni=%v, Max=%d}", f.Unidirectional, f.MaximumStreams)
+}
+
+// DataBlockedFrame represents a DATA_BLOCKED frame.
+type DataBlockedFrame struct {
+	MaximumData uint64
+}
+
+func (f *DataBlockedFrame) Type() FrameType {
+	return FrameTypeDataBlocked
+}
+
+func (f *DataBlockedFrame) Serialize() ([]byte, error) {
+	w := frameWriter{buf: []byte{byte(FrameTypeDataBlocked)}}
+	w.varint(f.MaximumData)
+	return w.bytes()
+}
+
+func (f *DataBlockedFrame) String() string {
+	return fmt.Sprintf("DATA_BLOCKED{Max=%d}", f.MaximumData)
+}
+
+// StreamDataBlockedFrame represents a STREAM_DATA_BLOCKED frame.
+type StreamDataBlockedFrame struct {
+	StreamID          uint64
+	MaximumStreamData uint64
+}
+
+func (f *StreamDataBlockedFrame) Type() FrameType {
+	return FrameTypeStreamDataBlocked
+}
+
+func (f *StreamDataBlockedFrame) Serialize() ([]byte, error) {
+	w := frameWriter{buf: []byte{byte(FrameTypeStreamDataBlocked)}}
+	w.varint(f.StreamID)
+	w.varint(f.MaximumStreamData)
+	return w.bytes()
+}
+
+func (f *StreamDataBlockedFrame) String() string {
+	return fmt.Sprintf("STREAM_DATA_BLOCKED{ID=%d, Max=%d}", f.StreamID, f.MaximumStreamData)
+}
+
+// StreamsBlockedFrame represents a STREAMS_BLOCKED frame.
+type StreamsBlockedFrame struct {
+	Unidirectional bool
+	MaximumStreams uint64
+}
+
+func (f *StreamsBlockedFrame) Type() FrameType {
+	if f.Unidirectional {
+		return FrameTypeStreamsBlockedUni
+	}
+	return FrameTypeStreamsBlocked
+}
+
+func (f *StreamsBlockedFrame) Serialize() ([]byte, error) {
+	if f.MaximumStreams > MaxStreamsLimit {
+		return nil, fmt.Errorf("stream count %d exceeds 2^60", f.MaximumStreams)
+	}
+	w := frameWriter{buf: []byte{byte(f.Type())}}
+	w.varint(f.MaximumStreams)
+	return w.bytes()
+}
+
+func (f *StreamsBlockedFrame) String() string {
+	return fmt.Sprintf("STREAMS_BLOCKED{Uni=%v, Max=%d}", f.Unidirectional, f.MaximumStreams)
+}
+
+// HandshakeDoneFrame represents a HANDSHAKE_DONE frame.
+type HandshakeDoneFrame struct{}
+
+func (f *HandshakeDoneFrame) Type() FrameType {
+	return FrameTypeHandshakeDone
+}
+
+func (f *HandshakeDoneFrame) Serialize() ([]byte, error) {
+	return []byte{byte(FrameTypeHandshakeDone)}, nil
+}
+
+func (f *HandshakeDoneFrame) String() string {
+	return "HANDSHAKE_DONE"
+}
+
+// NewConnectionIDFrame represents a NEW_CONNECTION_ID frame, issuing an
+// additional connection ID the peer may use to address this endpoint.
+type NewConnectionIDFrame struct {
+	SequenceNumber      uint64
+	RetirePriorTo       uint64
+	ConnectionID        []byte
+	StatelessResetToken [16]byte
+}
+
+func (f *NewConnectionIDFrame) Type() FrameType {
+	return FrameTypeNewConnectionID
+}
+
+func (f *NewConnectionIDFrame) Serialize() ([]byte, error) {
+	if len(f.ConnectionID) < 1 || len(f.ConnectionID) > MaxConnIDLength {
+		return nil, fmt.Errorf("invalid connection ID length: %d", len(f.ConnectionID))
+	}
+	if f.RetirePriorTo > f.SequenceNumber {
+		return nil, fmt.Errorf("retire prior to %d exceeds sequence number %d", f.RetirePriorTo, f.SequenceNumber)
+	}
+
+	w := frameWriter{buf: []byte{byte(FrameTypeNewConnectionID)}}
+	w.varint(f.SequenceNumber)
+	w.varint(f.RetirePriorTo)
+	w.buf = append(w.buf, byte(len(f.ConnectionID)))
+	w.buf = append(w.buf, f.ConnectionID...)
+	w.buf = append(w.buf, f.StatelessResetToken[:]...)
+	return w.bytes()
+}
+
+func (f *NewConnectionIDFrame) String() string {
+	return fmt.Sprintf("NEW_CONNECTION_ID{Seq=%d, RetirePriorTo=%d, ID=%x}",
+		f.SequenceNumber, f.RetirePriorTo, f.ConnectionID)
+}
+
+// RetireConnectionIDFrame represents a RETIRE_CONNECTION_ID frame.
+type RetireConnectionIDFrame struct {
+	SequenceNumber uint64
+}
+
+func (f *RetireConnectionIDFrame) Type() FrameType {
+	return FrameTypeRetireConnectionID
+}
+
+func (f *RetireConnectionIDFrame) Serialize() ([]byte, error) {
+	w := frameWriter{buf: []byte{byte(FrameTypeRetireConnectionID)}}
+	w.varint(f.SequenceNumber)
+	return w.bytes()
+}
+
+func (f *RetireConnectionIDFrame) String() string {
+	return fmt.Sprintf("RETIRE_CONNECTION_ID{Seq=%d}", f.SequenceNumber)
+}
+
+// frameWriter appends variable-length integers, keeping the first error.
+type frameWriter struct {
+	buf []byte
+	err error
+}
+
+func (w *frameWriter) varint(v uint64) {
+	if w.err == nil {
+		w.buf, w.err = AppendVarint(w.buf, v)
+	}
+}
+
+func (w *frameWriter) bytes() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf, nil
+}
+
+// frameReader reads the fields of a frame, keeping the first error.
+type frameReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *frameReader) varint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n, err := ReadVarint(r.data[r.offset:])
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	r.offset += n
+	return v
+}
+
+// bytes reads n bytes; the result aliases the frame data.
+func (r *frameReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.data)-r.offset) {
+		r.err = fmt.Errorf("frame truncated")
+		return nil
+	}
+	b := r.data[r.offset : r.offset+int(n)]
+	r.offset += int(n)
+	return b
+}
+
+// ParseFrame parses the frame at the start of data and returns it with the
+// number of bytes consumed. Byte slices in the frame alias data.
 func ParseFrame(data []byte) (Frame, int, error) {
 	if len(data) < 1 {
 		return nil, 0, fmt.Errorf("frame data too short")
 	}
 
-	frameType := FrameType(data[0])
+	r := &frameReader{data: data}
+	typ := r.varint()
+	if r.err != nil {
+		return nil, 0, fmt.Errorf("invalid frame type: %w", r.err)
+	}
 
-	switch frameType {
-	case FrameTypePing:
-		return &PingFrame{}, 1, nil
+	var frame Frame
+	switch {
+	case typ == uint64(FrameTypePing):
+		frame = &PingFrame{}
 
-	case FrameTypePadding:
+	case typ == uint64(FrameTypePadding):
 		// Count consecutive padding bytes
 		count := 0
 		for i := 0; i < len(data) && data[i] == 0; i++ {
@@ -247,8 +587,170 @@ func ParseFrame(data []byte) (Frame, int, error) {
 		}
 		return &PaddingFrame{Length: count}, count, nil
 
+	case typ == uint64(FrameTypeAck) || typ == uint64(FrameTypeAckECN):
+		f := &AckFrame{
+			LargestAcknowledged: r.varint(),
+			AckDelay:            r.varint(),
+		}
+		rangeCount := r.varint()
+		f.FirstAckRange = r.varint()
+		for i := uint64(0); i < rangeCount && r.err == nil; i++ {
+			f.AckRanges = append(f.AckRanges, AckRange{Gap: r.varint(), Length: r.varint()})
+		}
+		if typ == uint64(FrameTypeAckECN) {
+			f.ECN = &ECNCounts{ECT0: r.varint(), ECT1: r.varint(), CE: r.varint()}
+		}
+		if r.err == nil && f.FirstAckRange > f.LargestAcknowledged {
+			return nil, 0, fmt.Errorf("invalid ACK frame: first range exceeds largest acknowledged")
+		}
+		frame = f
+
+	case typ >= uint64(FrameTypeStream) && typ <= uint64(FrameTypeStream)|0x07:
+		f := &StreamFrame{StreamID: r.varint(), Fin: typ&streamFlagFin != 0}
+		if typ&streamFlagOffset != 0 {
+			f.Offset = r.varint()
+		}
+		if typ&streamFlagLength != 0 {
+			f.Length = r.varint()
+		} else if r.err == nil {
+			// Without a length the frame extends to the end of the packet
+			f.Length = uint64(len(data) - r.offset)
+		}
+		f.Data = r.bytes(f.Length)
+		if r.err == nil && f.Offset+f.Length > MaxVarint {
+			return nil, 0, fmt.Errorf("invalid STREAM frame: offset exceeds 2^62-1")
+		}
+		frame = f
+
+	case typ == uint64(FrameTypeCrypto):
+		f := &CryptoFrame{Offset: r.varint()}
+		f.Data = r.bytes(r.varint())
+		frame = f
+
+	case typ == uint64(FrameTypeConnectionClose) || typ == uint64(FrameTypeConnectionCloseApp):
+		f := &ConnectionCloseFrame{
+			ErrorCode:   r.varint(),
+			Application: typ == uint64(FrameTypeConnectionCloseApp),
+		}
+		if !f.Application {
+			f.FrameType = r.varint()
+		}
+		f.ReasonPhrase = string(r.bytes(r.varint()))
+		frame = f
+
+	case typ == uint64(FrameTypeMaxData):
+		frame = &MaxDataFrame{MaximumData: r.varint()}
+
+	case typ == uint64(FrameTypeHandshakeDone):
+		frame = &HandshakeDoneFrame{}
+
+	case typ == uint64(FrameTypeResetStream):
+		frame = &ResetStreamFrame{StreamID: r.varint(), ErrorCode: r.varint(), FinalSize: r.varint()}
+
+	case typ == uint64(FrameTypeStopSending):
+		frame = &StopSendingFrame{StreamID: r.varint(), ErrorCode: r.varint()}
+
+	case typ == uint64(FrameTypeMaxStreamData):
+		frame = &MaxStreamDataFrame{StreamID: r.varint(), MaximumStreamData: r.varint()}
+
+	case typ == uint64(FrameTypeMaxStreams) || typ == uint64(FrameTypeMaxStreamsUni):
+		f := &MaxStreamsFrame{Unidirectional: typ == uint64(FrameTypeMaxStreamsUni), MaximumStreams: r.varint()}
+		if r.err == nil && f.MaximumStreams > MaxStreamsLimit {
+			return nil, 0, fmt.Errorf("invalid MAX_STREAMS frame: count exceeds 2^60")
+		}
+		frame = f
+
+	case typ == uint64(FrameTypeDataBlocked):
+		frame = &DataBlockedFrame{MaximumData: r.varint()}
+
+	case typ == uint64(FrameTypeStreamDataBlocked):
+		frame = &StreamDataBlockedFrame{StreamID: r.varint(), MaximumStreamData: r.varint()}
+
+	case typ == uint64(FrameTypeStreamsBlocked) || typ == uint64(FrameTypeStreamsBlockedUni):
+		f := &StreamsBlockedFrame{Unidirectional: typ == uint64(FrameTypeStreamsBlockedUni), MaximumStreams: r.varint()}
+		if r.err == nil && f.MaximumStreams > MaxStreamsLimit {
+			return nil, 0, fmt.Errorf("invalid STREAMS_BLOCKED frame: count exceeds 2^60")
+		}
+		frame = f
+
+	case typ == uint64(FrameTypeNewConnectionID):
+		f := &NewConnectionIDFrame{SequenceNumber: r.varint(), RetirePriorTo: r.varint()}
+		idLen := r.bytes(1)
+		if r.err == nil && (idLen[0] < 1 || idLen[0] > MaxConnIDLength) {
+			return nil, 0, fmt.Errorf("invalid NEW_CONNECTION_ID frame: connection ID length %d", idLen[0])
+		}
+		if r.err == nil {
+			f.ConnectionID = r.bytes(uint64(idLen[0]))
+			copy(f.StatelessResetToken[:], r.bytes(16))
+		}
+		if r.err == nil && f.RetirePriorTo > f.SequenceNumber {
+			return nil, 0, fmt.Errorf("invalid NEW_CONNECTION_ID frame: retire prior to exceeds sequence number")
+		}
+		frame = f
+
+	case typ == uint64(FrameTypeRetireConnectionID):
+		frame = &RetireConnectionIDFrame{SequenceNumber: r.varint()}
+
+	default:
+		return nil, 0, fmt.Errorf("unsupported frame type: 0x%02x", typ)
+	}
+
+	if r.err != nil {
+		return nil, 0, fmt.Errorf("invalid %s frame: %w", frameTypeName(typ), r.err)
+	}
+	return frame, r.offset, nil
+}
+
+// ParseFrames parses all frames in a packet payload, skipping padding.
+func ParseFrames(payload []byte) ([]Frame, error) {
+	var frames []Frame
+	for len(payload) > 0 {
+		frame, n, err := ParseFrame(payload)
+		if err != nil {
+			return frames, err
+		}
+		if _, ok := frame.(*PaddingFrame); !ok {
+			frames = append(frames, frame)
+		}
+		payload = payload[n:]
+	}
+	return frames, nil
+}
+
+// frameTypeName returns a short name for error messages.
+func frameTypeName(typ uint64) string {
+	switch {
+	case typ == uint64(FrameTypeAck) || typ == uint64(FrameTypeAckECN):
+		return "ACK"
+	case typ >= uint64(FrameTypeStream) && typ <= uint64(FrameTypeStream)|0x07:
+		return "STREAM"
+	case typ == uint64(FrameTypeCrypto):
+		return "CRYPTO"
+	case typ == uint64(FrameTypeConnectionClose) || typ == uint64(FrameTypeConnectionCloseApp):
+		return "CONNECTION_CLOSE"
+	case typ == uint64(FrameTypeMaxData):
+		return "MAX_DATA"
+	case typ == uint64(FrameTypeResetStream):
+		return "RESET_STREAM"
+	case typ == uint64(FrameTypeStopSending):
+		return "STOP_SENDING"
+	case typ == uint64(FrameTypeMaxStreamData):
+		return "MAX_STREAM_DATA"
+	case typ == uint64(FrameTypeMaxStreams) || typ == uint64(FrameTypeMaxStreamsUni):
+		return "MAX_STREAMS"
+	case typ == uint64(FrameTypeDataBlocked):
+		return "DATA_BLOCKED"
+	case typ == uint64(FrameTypeStreamDataBlocked):
+		return "STREAM_DATA_BLOCKED"
+	case typ == uint64(FrameTypeStreamsBlocked) || typ == uint64(FrameTypeStreamsBlockedUni):
+		return "STREAMS_BLOCKED"
+	case typ == uint64(FrameTypeHandshakeDone):
+		return "HANDSHAKE_DONE"
+	case typ == uint64(FrameTypeNewConnectionID):
+		return "NEW_CONNECTION_ID"
+	case typ == uint64(FrameTypeRetireConnectionID):
+		return "RETIRE_CONNECTION_ID"
 	default:
-		// For other frames, return a simple representation
-		return nil, 0, fmt.Errorf("unsupported frame type: 0x%02x", frameType)
+		return fmt.Sprintf("0x%02x", typ)
 	}
 }
@@ -0,0 +1,111 @@
+package quic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Frame
+	}{
+		{"ping", &PingFrame{}},
+		{"ack", &AckFrame{LargestAcknowledged: 100, AckDelay: 25, FirstAckRange: 3,
+			AckRanges: []AckRange{{Gap: 1, Length: 10}}}},
+		{"ack ecn", &AckFrame{LargestAcknowledged: 7, ECN: &ECNCounts{ECT0: 1, ECT1: 2, CE: 3}}},
+		{"stream", &StreamFrame{StreamID: 4, Length: 5, Data: []byte("hello")}},
+		{"stream with offset and fin", &StreamFrame{StreamID: 1 << 40, Offset: 70000,
+			Length: 3, Fin: true, Data: []byte("end")}},
+		{"crypto", &CryptoFrame{Offset: 1200, Data: []byte("handshake")}},
+		{"connection close", &ConnectionCloseFrame{ErrorCode: 0x0a, FrameType: 0x08,
+			ReasonPhrase: "protocol violation"}},
+		{"application close", &ConnectionCloseFrame{ErrorCode: 1, ReasonPhrase: "bye", Application: true}},
+		{"max data", &MaxDataFrame{MaximumData: 10 << 20}},
+		{"handshake done", &HandshakeDoneFrame{}},
+		{"new connection id", &NewConnectionIDFrame{SequenceNumber: 2, RetirePriorTo: 1,
+			ConnectionID: []byte{1, 2, 3, 4}, StatelessResetToken: [16]byte{0xff}}},
+		{"retire connection id", &RetireConnectionIDFrame{SequenceNumber: 1}},
+		{"reset stream", &ResetStreamFrame{StreamID: 4, ErrorCode: 7, FinalSize: 1 << 20}},
+		{"stop sending", &StopSendingFrame{StreamID: 8, ErrorCode: 0x100}},
+		{"max stream data", &MaxStreamDataFrame{StreamID: 1, MaximumStreamData: 1 << 30}},
+		{"max streams bidi", &MaxStreamsFrame{MaximumStreams: 100}},
+		{"max streams uni", &MaxStreamsFrame{Unidirectional: true, MaximumStreams: 3}},
+		{"data blocked", &DataBlockedFrame{MaximumData: 4096}},
+		{"stream data blocked", &StreamDataBlockedFrame{StreamID: 2, MaximumStreamData: 512}},
+		{"streams blocked uni", &StreamsBlockedFrame{Unidirectional: true, MaximumStreams: 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.frame.Serialize()
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+
+			// A trailing frame must not be consumed
+			got, n, err := ParseFrame(append(data, byte(FrameTypePing)))
+			if err != nil {
+				t.Fatalf("ParseFrame() error = %v", err)
+			}
+			if n != len(data) {
+				t.Errorf("ParseFrame() consumed %d bytes, want %d", n, len(data))
+			}
+			if !reflect.DeepEqual(got, tt.frame) {
+				t.Errorf("ParseFrame() = %#v, want %#v", got, tt.frame)
+			}
+		})
+	}
+}
+
+func TestParseFrameErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown type", []byte{0x30}},
+		{"truncated ack", []byte{byte(FrameTypeAck), 10, 0}},
+		{"ack range below zero", []byte{byte(FrameTypeAck), 2, 0, 0, 5}},
+		{"stream data past end", []byte{0x0a, 4, 10, 'a'}},
+		{"truncated crypto", []byte{byte(FrameTypeCrypto), 0x40}},
+		{"max streams above 2^60", []byte{byte(FrameTypeMaxStreams), 0xd0, 0, 0, 0, 0, 0, 0, 1}},
+		{"truncated reset stream", []byte{byte(FrameTypeResetStream), 4, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if f, _, err := ParseFrame(tt.data); err == nil {
+				t.Errorf("ParseFrame() = %v, want error", f)
+			}
+		})
+	}
+}
+
+func TestStreamFrameWithoutLength(t *testing.T) {
+	// Type 0x08: no offset, no length; the data runs to the end of the packet
+	f, n, err := ParseFrame([]byte{0x08, 4, 'a', 'b', 'c'})
+	if err != nil {
+		t.Fatalf("ParseFrame() error = %v", err)
+	}
+	stream := f.(*StreamFrame)
+	if n != 5 || string(stream.Data) != "abc" || stream.Length != 3 {
+		t.Errorf("ParseFrame() = %+v, %d", stream, n)
+	}
+}
+
+func TestAckFrameAcknowledges(t *testing.T) {
+	// Acknowledges 100-97, 94-84 and 81-81
+	ack := &AckFrame{
+		LargestAcknowledged: 100,
+		FirstAckRange:       3,
+		AckRanges:           []AckRange{{Gap: 1, Length: 10}, {Gap: 1, Length: 0}},
+	}
+
+	for pn := uint64(75); pn <= 101; pn++ {
+		want := (pn >= 97 && pn <= 100) || (pn >= 84 && pn <= 94) || pn == 81
+		if got := ack.Acknowledges(pn); got != want {
+			t.Errorf("Acknowledges(%d) = %v, want %v", pn, got, want)
+		}
+	}
+}
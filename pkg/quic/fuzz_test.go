@@ -0,0 +1,119 @@
+package quic
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/internal/fuzzseed"
+)
+
+// packetSeeds returns the QUIC packets of the seed capture.
+func packetSeeds(tb testing.TB) [][]byte {
+	return fuzzseed.UDPPayloads(tb, 443)
+}
+
+func FuzzParse(f *testing.F) {
+	fuzzseed.Add(f, packetSeeds(f))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt, err := Parse(data)
+		if err != nil || pkt == nil {
+			return
+		}
+		_ = pkt.String()
+		ParseFrames(pkt.Payload)
+	})
+}
+
+func FuzzOpenPacket(f *testing.F) {
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	client, _, err := InitialKeys(dcid)
+	if err != nil {
+		f.Fatalf("InitialKeys() error = %v", err)
+	}
+	pr, err := NewProtector(client)
+	if err != nil {
+		f.Fatalf("NewProtector() error = %v", err)
+	}
+
+	for _, seed := range packetSeeds(f) {
+		pkt, err := Parse(seed)
+		if err != nil {
+			f.Fatalf("Parse() error = %v", err)
+		}
+		protected, err := pkt.SerializeProtected(pr)
+		if err != nil {
+			f.Fatalf("SerializeProtected() error = %v", err)
+		}
+		f.Add(protected)
+	}
+	f.Add([]byte{0x40, 1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		largest := int64(-1)
+		for len(data) > 0 {
+			pkt, n, err := OpenPacket(data, len(dcid), pr, largest)
+			if err != nil {
+				return
+			}
+			if n <= 0 || n > len(data) {
+				t.Fatalf("OpenPacket() consumed %d of %d bytes", n, len(data))
+			}
+			ParseFrames(pkt.Payload)
+			largest = int64(pkt.PacketNumber)
+			data = data[n:]
+		}
+	})
+}
+
+func FuzzParseFrames(f *testing.F) {
+	for _, seed := range packetSeeds(f) {
+		pkt, err := Parse(seed)
+		if err != nil {
+			f.Fatalf("Parse() error = %v", err)
+		}
+		f.Add(pkt.Payload)
+	}
+	for _, frame := range []Frame{
+		&AckFrame{LargestAcknowledged: 10, AckDelay: 3, FirstAckRange: 2, AckRanges: []AckRange{{Gap: 1, Length: 2}}},
+		&StreamFrame{StreamID: 4, Offset: 100, Data: []byte("data"), Fin: true},
+		&ConnectionCloseFrame{ErrorCode: 1, ReasonPhrase: "bye"},
+		&MaxDataFrame{MaximumData: 1 << 20},
+	} {
+		data, err := frame.Serialize()
+		if err != nil {
+			f.Fatalf("Serialize() error = %v", err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frames, err := ParseFrames(data)
+		if err != nil {
+			return
+		}
+		for _, frame := range frames {
+			_ = frame.String()
+			if _, err := frame.Serialize(); err != nil {
+				t.Errorf("%v Serialize() error = %v", frame.Type(), err)
+			}
+		}
+	})
+}
+
+func FuzzParseTransportParameters(f *testing.F) {
+	f.Add(DefaultTransportParameters().Marshal())
+	params := DefaultTransportParameters()
+	params.OriginalDestConnID = []byte{1, 2, 3, 4}
+	params.StatelessResetToken = make([]byte, 16)
+	f.Add(params.Marshal())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		params, err := ParseTransportParameters(data)
+		if err != nil {
+			return
+		}
+		if _, err := ParseTransportParameters(params.Marshal()); err != nil {
+			t.Errorf("ParseTransportParameters() of marshaled parameters error = %v", err)
+		}
+	})
+}
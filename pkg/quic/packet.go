@@ -28,6 +28,9 @@ const (
 	Version1 uint32 = 0x00000001 // QUIC version 1 (RFC 9000)
 )
 
+// MaxConnIDLength is the longest connection ID in QUIC version 1.
+const MaxConnIDLength = 20
+
 // Packet represents a QUIC packet.
 type Packet struct {
 	// Header
@@ -38,8 +41,10 @@ type Packet struct {
 	DestConnID     []byte      // Destination Connection ID
 	SrcConnID      []byte      // Source Connection ID (long header only)
 	Token          []byte      // Token (Initial packets only)
-	PacketNumber   uint64      // Packet number
-	PacketNumLen   uint8       // Packet number length
+	PacketNumber   uint64      // Full packet number
+	PacketNumLen   uint8       // Encoded packet number length, 1-4 (0 picks the shortest)
+	SpinBit        uint8       // Latency spin bit (short header only)
+	KeyPhase       uint8       // Key phase bit (short header only)
 
 	// Payload
 	Payload        []byte      // Encrypted payload
@@ -70,7 +75,18 @@ type ShortHeader struct {
 	Payload      []byte
 }
 
-// Parse parses a QUIC packet from raw bytes.
+// MinInitialDatagramSize is the smallest UDP payload that may carry an
+// ack-eliciting Initial packet (RFC 9000 Section 14.1). Protected Initial
+// packets are padded to it.
+const MinInitialDatagramSize = 1200
+
+// Parse parses an unprotected QUIC packet from raw bytes, as produced by
+// Serialize. The packet number length is read from the first byte, which
+// header protection would hide; use OpenPacket for packets from the wire.
+//
+// Short headers do not carry the length of the Destination Connection ID,
+// so for 1-RTT packets only the first byte is parsed and Payload holds the
+// rest.
 func Parse(data []byte) (*Packet, error) {
 	if len(data) < 1 {
 		return nil, fmt.Errorf("packet too short")
@@ -83,23 +99,91 @@ func Parse(data []byte) (*Packet, error) {
 	pkt.HeaderForm = (firstByte >> 7) & 0x01
 	pkt.FixedBit = (firstByte >> 6) & 0x01
 
+	if pkt.HeaderForm == 1 {
+		// Long header
+		pkt, pnOffset, end, err := parseLongHeader(data)
+		if err != nil || pnOffset < 0 {
+			return pkt, err
+		}
+		pkt.PacketNumLen = (data[0] & 0x03) + 1
+		if pnOffset+int(pkt.PacketNumLen) > end {
+			return nil, fmt.Errorf("packet truncated")
+		}
+		pkt.PacketNumber = readPacketNumber(data[pnOffset:], int(pkt.PacketNumLen))
+		pkt.Payload = data[pnOffset+int(pkt.PacketNumLen) : end]
+		return pkt, nil
+	}
+
 	if pkt.FixedBit != 1 {
 		return nil, fmt.Errorf("invalid fixed bit")
 	}
 
-	if pkt.HeaderForm == 1 {
-		// Long header
-		return parseLongHeader(data)
+	// Short header
+	pkt.Type = PacketType1RTT
+	pkt.PacketNumLen = (firstByte & 0x03) + 1
+	pkt.Payload = data[1:]
+	return pkt, nil
+}
+
+// OpenPacket removes packet protection from the first packet in data and
+// parses it. connIDLen is the length of the connection IDs this endpoint
+// issues, needed to parse short headers; largestPN is the largest packet
+// number received so far in the packet's number space, or -1.
+//
+// It returns the packet and the number of bytes it occupied, so that the
+// packets coalesced in a datagram can be opened in turn.
+func OpenPacket(data []byte, connIDLen int, p *Protector, largestPN int64) (*Packet, int, error) {
+	var pkt *Packet
+	var pnOffset, end int
+
+	if len(data) < 1 {
+		return nil, 0, fmt.Errorf("packet too short")
+	}
+	if data[0]&0x80 != 0 {
+		var err error
+		if pkt, pnOffset, end, err = parseLongHeader(data); err != nil {
+			return nil, 0, err
+		}
+		if pnOffset < 0 {
+			return nil, 0, fmt.Errorf("%s packets are not protected", pkt.Type)
+		}
 	} else {
-		// Short header
-		return parseShortHeader(data)
+		if 1+connIDLen > len(data) {
+			return nil, 0, fmt.Errorf("packet truncated")
+		}
+		pkt = &Packet{
+			FixedBit:   (data[0] >> 6) & 0x01,
+			Type:       PacketType1RTT,
+			DestConnID: append([]byte(nil), data[1:1+connIDLen]...),
+		}
+		pnOffset, end = 1+connIDLen, len(data)
+	}
+	if pkt.FixedBit != 1 {
+		return nil, 0, fmt.Errorf("invalid fixed bit")
+	}
+
+	header, payload, pn, err := p.Open(data[:end], pnOffset, largestPN)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pkt.PacketNumber = pn
+	pkt.PacketNumLen = (header[0] & 0x03) + 1
+	pkt.Payload = payload
+	if pkt.HeaderForm == 0 {
+		pkt.SpinBit = (header[0] >> 5) & 0x01
+		pkt.KeyPhase = (header[0] >> 2) & 0x01
 	}
+	return pkt, end, nil
 }
 
-// parseLongHeader parses a long header packet.
-func parseLongHeader(data []byte) (*Packet, error) {
+// parseLongHeader parses the fields of a long header. It returns the
+// packet, the offset of the packet number and the end of the packet within
+// data. Version Negotiation and Retry packets have no packet number; for
+// them the offset is -1 and Payload holds the rest of the datagram.
+func parseLongHeader(data []byte) (*Packet, int, int, error) {
 	if len(data) < 5 {
-		return nil, fmt.Errorf("long header too short")
+		return nil, 0, 0, fmt.Errorf("long header too short")
 	}
 
 	pkt := &Packet{}
@@ -115,139 +199,207 @@ func parseLongHeader(data []byte) (*Packet, error) {
 	pkt.Version = binary.BigEndian.Uint32(data[offset : offset+4])
 	offset += 4
 
-	// Destination Connection ID Length
-	if offset >= len(data) {
-		return nil, fmt.Errorf("packet truncated")
+	// Connection IDs, each preceded by a length byte
+	var err error
+	if pkt.DestConnID, offset, err = readConnID(data, offset); err != nil {
+		return nil, 0, 0, err
 	}
-	destConnIDLen := int(data[offset])
-	offset++
-
-	// Destination Connection ID
-	if offset+destConnIDLen > len(data) {
-		return nil, fmt.Errorf("packet truncated")
+	if pkt.SrcConnID, offset, err = readConnID(data, offset); err != nil {
+		return nil, 0, 0, err
 	}
-	pkt.DestConnID = make([]byte, destConnIDLen)
-	copy(pkt.DestConnID, data[offset:offset+destConnIDLen])
-	offset += destConnIDLen
 
-	// Source Connection ID Length
-	if offset >= len(data) {
-		return nil, fmt.Errorf("packet truncated")
+	// Version Negotiation lists versions; Retry carries a token and the
+	// integrity tag
+	if pkt.Version == 0 {
+		pkt.Type = PacketTypeVersionNeg
+		pkt.Payload = data[offset:]
+		return pkt, -1, len(data), nil
+	}
+	if pkt.FixedBit != 1 {
+		return nil, 0, 0, fmt.Errorf("invalid fixed bit")
+	}
+	if pkt.Type == PacketTypeRetry {
+		pkt.Payload = data[offset:]
+		return pkt, -1, len(data), nil
 	}
-	srcConnIDLen := int(data[offset])
-	offset++
 
-	// Source Connection ID
-	if offset+srcConnIDLen > len(data) {
-		return nil, fmt.Errorf("packet truncated")
+	// Token (Initial packets only)
+	if pkt.Type == PacketTypeInitial {
+		tokenLen, n, err := ReadVarint(data[offset:])
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("invalid token length: %w", err)
+		}
+		offset += n
+		if tokenLen > uint64(len(data)-offset) {
+			return nil, 0, 0, fmt.Errorf("packet truncated")
+		}
+		pkt.Token = append([]byte(nil), data[offset:offset+int(tokenLen)]...)
+		offset += int(tokenLen)
 	}
-	pkt.SrcConnID = make([]byte, srcConnIDLen)
-	copy(pkt.SrcConnID, data[offset:offset+srcConnIDLen])
-	offset += srcConnIDLen
 
-	// Payload (simplified - actual QUIC has more fields)
-	pkt.Payload = data[offset:]
+	// Length of the packet number and payload
+	length, n, err := ReadVarint(data[offset:])
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("invalid length: %w", err)
+	}
+	offset += n
+	if length > uint64(len(data)-offset) {
+		return nil, 0, 0, fmt.Errorf("packet truncated")
+	}
 
-	return pkt, nil
+	return pkt, offset, offset + int(length), nil
 }
 
-// parseShortHeader parses a short header packet.
-func parseShortHeader(data []byte) (*Packet, error) {
-	if len(data) < 1 {
-		return nil, fmt.Errorf("short header too short")
+// readConnID reads a length-prefixed connection ID at offset.
+func readConnID(data []byte, offset int) ([]byte, int, error) {
+	if offset >= len(data) {
+		return nil, 0, fmt.Errorf("packet truncated")
+	}
+	n := int(data[offset])
+	offset++
+	if n > MaxConnIDLength {
+		return nil, 0, fmt.Errorf("connection ID too long: %d bytes", n)
+	}
+	if offset+n > len(data) {
+		return nil, 0, fmt.Errorf("packet truncated")
 	}
+	return append([]byte(nil), data[offset:offset+n]...), offset + n, nil
+}
 
-	pkt := &Packet{}
-	firstByte := data[0]
+func readPacketNumber(data []byte, n int) uint64 {
+	var pn uint64
+	for i := 0; i < n; i++ {
+		pn = pn<<8 | uint64(data[i])
+	}
+	return pn
+}
 
-	pkt.HeaderForm = 0
-	pkt.FixedBit = (firstByte >> 6) & 0x01
-	pkt.Type = PacketType1RTT
+// Serialize converts the packet to bytes without packet protection.
+func (p *Packet) Serialize() ([]byte, error) {
+	buf, _, err := p.appendHeader(nil, len(p.Payload), 0)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, p.Payload...), nil
+}
 
-	// For short header, connection ID length is negotiated during handshake
-	// For simplicity, assume a fixed length here
-	offset := 1
+// SerializeProtected converts the packet to bytes and applies packet
+// protection. Initial packets are padded to MinInitialDatagramSize, and
+// payloads too short to sample for header protection are padded with
+// PADDING frames.
+func (p *Packet) SerializeProtected(pr *Protector) ([]byte, error) {
+	if p.Type == PacketTypeRetry || p.Type == PacketTypeVersionNeg {
+		return nil, fmt.Errorf("%s packets are not protected", p.Type)
+	}
 
-	// Simplified - would need connection state to know actual conn ID length
-	// Payload starts after connection ID and packet number
-	pkt.Payload = data[offset:]
+	pnLen := p.packetNumberLength()
+	payload := p.Payload
+	minPayload := sampleOffset - pnLen
+	if p.Type == PacketTypeInitial {
+		// Pad in the sealed packet's terms: the header with a 2-byte Length
+		// field, the payload and the AEAD tag
+		headerLen := p.longHeaderLength(2)
+		minPayload = max(minPayload, MinInitialDatagramSize-headerLen-pr.Overhead())
+	}
+	if len(payload) < minPayload {
+		payload = append(append([]byte(nil), payload...), make([]byte, minPayload-len(payload))...)
+	}
 
-	return pkt, nil
+	header, pnOffset, err := p.appendHeader(nil, len(payload)+pr.Overhead(), pnLen)
+	if err != nil {
+		return nil, err
+	}
+	return pr.Seal(header, payload, pnOffset, pnLen, p.PacketNumber)
 }
 
-// Serialize converts the packet to bytes.
-func (p *Packet) Serialize() ([]byte, error) {
-	if p.HeaderForm == 1 {
-		return p.serializeLongHeader()
+// packetNumberLength returns PacketNumLen, or the length needed to encode
+// PacketNumber when nothing has been acknowledged.
+func (p *Packet) packetNumberLength() int {
+	if p.PacketNumLen >= 1 && p.PacketNumLen <= 4 {
+		return int(p.PacketNumLen)
 	}
-	return p.serializeShortHeader()
+	return PacketNumberLength(p.PacketNumber, -1)
 }
 
-// serializeLongHeader serializes a long header packet.
-func (p *Packet) serializeLongHeader() ([]byte, error) {
-	// Calculate size
-	size := 1 + 4 + 1 + len(p.DestConnID) + 1 + len(p.SrcConnID) + len(p.Payload)
+// longHeaderLength returns the size of the long header, including the
+// packet number, with a Length field of lengthLen bytes.
+func (p *Packet) longHeaderLength(lengthLen int) int {
+	n := 1 + 4 + 1 + len(p.DestConnID) + 1 + len(p.SrcConnID) + lengthLen + p.packetNumberLength()
 	if p.Type == PacketTypeInitial {
-		size += 1 + len(p.Token) // Token length + token
+		n += VarintLen(uint64(len(p.Token))) + len(p.Token)
 	}
+	return n
+}
 
-	buf := make([]byte, size)
-	offset := 0
+// appendHeader appends the unprotected header for a payload of payloadLen
+// bytes (after the packet number) and returns the offset of the packet
+// number. pnLen of zero picks the packet's own length.
+func (p *Packet) appendHeader(buf []byte, payloadLen, pnLen int) ([]byte, int, error) {
+	if len(p.DestConnID) > MaxConnIDLength || len(p.SrcConnID) > MaxConnIDLength {
+		return nil, 0, fmt.Errorf("connection ID too long")
+	}
+	if p.PacketNumber > MaxPacketNumber {
+		return nil, 0, fmt.Errorf("packet number out of range: %d", p.PacketNumber)
+	}
+	if pnLen == 0 {
+		pnLen = p.packetNumberLength()
+	}
 
-	// First byte
-	firstByte := (p.HeaderForm << 7) | (p.FixedBit << 6) | (uint8(p.Type) << 4)
-	buf[offset] = firstByte
-	offset++
+	if p.HeaderForm == 0 {
+		// First byte: form, fixed bit, spin bit, reserved bits, key phase
+		// and packet number length
+		firstByte := (p.FixedBit << 6) | (p.SpinBit&0x01)<<5 | (p.KeyPhase&0x01)<<2 | uint8(pnLen-1)
+		buf = append(buf, firstByte)
+		buf = append(buf, p.DestConnID...)
+		pnOffset := len(buf)
+		return appendPacketNumber(buf, p.PacketNumber, pnLen), pnOffset, nil
+	}
+
+	// First byte: form, fixed bit, type and packet number length
+	firstByte := (p.HeaderForm << 7) | (p.FixedBit << 6) | (uint8(p.Type&0x03) << 4)
+	if p.Type != PacketTypeRetry {
+		firstByte |= uint8(pnLen - 1)
+	}
+	buf = append(buf, firstByte)
 
 	// Version
-	binary.BigEndian.PutUint32(buf[offset:], p.Version)
-	offset += 4
+	buf = binary.BigEndian.AppendUint32(buf, p.Version)
 
-	// Destination Connection ID
-	buf[offset] = uint8(len(p.DestConnID))
-	offset++
-	copy(buf[offset:], p.DestConnID)
-	offset += len(p.DestConnID)
+	// Destination and Source Connection IDs
+	buf = append(buf, uint8(len(p.DestConnID)))
+	buf = append(buf, p.DestConnID...)
+	buf = append(buf, uint8(len(p.SrcConnID)))
+	buf = append(buf, p.SrcConnID...)
 
-	// Source Connection ID
-	buf[offset] = uint8(len(p.SrcConnID))
-	offset++
-	copy(buf[offset:], p.SrcConnID)
-	offset += len(p.SrcConnID)
+	// Retry packets carry the token and integrity tag as their payload
+	if p.Type == PacketTypeRetry {
+		return buf, -1, nil
+	}
 
 	// Token (Initial packets only)
 	if p.Type == PacketTypeInitial {
-		buf[offset] = uint8(len(p.Token))
-		offset++
-		copy(buf[offset:], p.Token)
-		offset += len(p.Token)
+		buf = appendVarintLen(buf, uint64(len(p.Token)), VarintLen(uint64(len(p.Token))))
+		buf = append(buf, p.Token...)
 	}
 
-	// Payload
-	copy(buf[offset:], p.Payload)
+	// Length covers the packet number and payload. A 2-byte encoding keeps
+	// the header size independent of small payload changes such as padding.
+	length := uint64(pnLen + payloadLen)
+	if length > MaxVarint {
+		return nil, 0, fmt.Errorf("payload too long: %d bytes", payloadLen)
+	}
+	buf = appendVarintLen(buf, length, max(2, VarintLen(length)))
 
-	return buf, nil
+	pnOffset := len(buf)
+	return appendPacketNumber(buf, p.PacketNumber, pnLen), pnOffset, nil
 }
 
-// serializeShortHeader serializes a short header packet.
-func (p *Packet) serializeShortHeader() ([]byte, error) {
-	size := 1 + len(p.DestConnID) + len(p.Payload)
-	buf := make([]byte, size)
-	offset := 0
-
-	// First byte
-	firstByte := (p.HeaderForm << 7) | (p.FixedBit << 6)
-	buf[offset] = firstByte
-	offset++
-
-	// Destination Connection ID
-	copy(buf[offset:], p.DestConnID)
-	offset += len(p.DestConnID)
-
-	// Payload
-	copy(buf[offset:], p.Payload)
-
-	return buf, nil
+// appendPacketNumber appends the low n bytes of pn.
+func appendPacketNumber(buf []byte, pn uint64, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, byte(pn>>(8*i)))
+	}
+	return buf
 }
 
 // NewInitialPacket creates a new Initial packet.
@@ -290,20 +442,26 @@ func New1RTTPacket(destConnID, payload []byte) *Packet {
 
 // String returns a human-readable representation of the packet.
 func (p *Packet) String() string {
-	typeStr := "Unknown"
-	switch p.Type {
+	return fmt.Sprintf("QUIC{Type=%s, Version=0x%08x, DestConnID=%x, PN=%d, PayloadLen=%d}",
+		p.Type, p.Version, p.DestConnID, p.PacketNumber, len(p.Payload))
+}
+
+// String returns the name of the packet type.
+func (t PacketType) String() string {
+	switch t {
 	case PacketTypeInitial:
-		typeStr = "Initial"
+		return "Initial"
 	case PacketType0RTT:
-		typeStr = "0-RTT"
+		return "0-RTT"
 	case PacketTypeHandshake:
-		typeStr = "Handshake"
+		return "Handshake"
 	case PacketTypeRetry:
-		typeStr = "Retry"
+		return "Retry"
 	case PacketType1RTT:
-		typeStr = "1-RTT"
+		return "1-RTT"
+	case PacketTypeVersionNeg:
+		return "VersionNegotiation"
+	default:
+		return "Unknown"
 	}
-
-	return fmt.Sprintf("QUIC{Type=%s, Version=0x%08x, DestConnID=%x, PayloadLen=%d}",
-		typeStr, p.Version, p.DestConnID, len(p.Payload))
 }
@@ -0,0 +1,31 @@
+package quic
+
+import "testing"
+
+func TestPacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		pkt  *Packet
+	}{
+		{"initial", NewInitialPacket([]byte{1, 2, 3, 4}, []byte{5, 6}, []byte("tok"), []byte("data"))},
+		{"handshake", NewHandshakePacket([]byte{1}, nil, make([]byte, 300))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.pkt.PacketNumber = 300
+			data, err := tt.pkt.Serialize()
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			got, err := Parse(data)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got.Type != tt.pkt.Type || got.PacketNumber != 300 || got.PacketNumLen != 2 ||
+				string(got.Payload) != string(tt.pkt.Payload) || string(got.Token) != string(tt.pkt.Token) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.pkt)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package quic
+
+import "math/bits"
+
+// MaxPacketNumber is the largest packet number (2^62-1).
+const MaxPacketNumber = 1<<62 - 1
+
+// PacketNumberLength returns the number of bytes (1-4) needed to encode
+// fullPN so that a peer that has seen largestAcked can recover it (RFC 9000
+// Appendix A.2). largestAcked is -1 if nothing has been acknowledged yet.
+//
+// The encoding must cover twice the number of packets in flight, so that
+// the peer can tell which of two candidates is meant.
+func PacketNumberLength(fullPN uint64, largestAcked int64) int {
+	var unacked uint64
+	if largestAcked < 0 {
+		unacked = fullPN + 1
+	} else {
+		unacked = fullPN - uint64(largestAcked)
+	}
+
+	if unacked == 0 {
+		unacked = 1
+	}
+
+	// ceil(log2(unacked)) + 1 bits
+	minBits := bits.Len64(unacked-1) + 1
+	n := (minBits + 7) / 8
+	if n > 4 {
+		n = 4
+	}
+	return n
+}
+
+// EncodePacketNumber truncates fullPN for a header and returns it with its
+// encoded length.
+func EncodePacketNumber(fullPN uint64, largestAcked int64) (uint32, int) {
+	n := PacketNumberLength(fullPN, largestAcked)
+	return uint32(fullPN & (1<<(8*n) - 1)), n
+}
+
+// DecodePacketNumber recovers the full packet number from a truncated one
+// of pnLen bytes, given the largest packet number received so far (-1 if
+// none), following RFC 9000 Appendix A.3.
+func DecodePacketNumber(largestPN int64, truncated uint64, pnLen int) uint64 {
+	expected := largestPN + 1
+	win := int64(1) << (8 * pnLen)
+	hwin := win / 2
+	mask := win - 1
+
+	// The candidate closest to the expected packet number wins
+	candidate := (expected &^ mask) | int64(truncated)
+	switch {
+	case candidate <= expected-hwin && candidate < MaxPacketNumber+1-win:
+		candidate += win
+	case candidate > expected+hwin && candidate >= win:
+		candidate -= win
+	}
+	return uint64(candidate)
+}
@@ -0,0 +1,259 @@
+package quic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// Packet protection (RFC 9001 Section 5) with the AES-GCM cipher suites:
+// AEAD_AES_128_GCM protects Initial packets, and TLS 1.3 may negotiate it or
+// AEAD_AES_256_GCM for the Handshake and 1-RTT keys.
+
+const (
+	// sampleLength is the size of the ciphertext sample used for header
+	// protection.
+	sampleLength = 16
+
+	// sampleOffset is where the sample starts, counted from the packet
+	// number field: it assumes a 4-byte packet number.
+	sampleOffset = 4
+
+	// aeadIVLength is the AES-GCM nonce size.
+	aeadIVLength = 12
+)
+
+// TLS 1.3 cipher suites (RFC 8446 Appendix B.4).
+const (
+	suiteAES128GCMSHA256 uint16 = 0x1301
+	suiteAES256GCMSHA384 uint16 = 0x1302
+)
+
+// initialSaltV1 is the salt for deriving Initial secrets in QUIC version 1
+// (RFC 9001 Section 5.2).
+var initialSaltV1 = []byte{
+	0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+}
+
+var (
+	// ErrPacketTooShort is returned when a packet is too short to take the
+	// header protection sample.
+	ErrPacketTooShort = errors.New("quic: packet too short for header protection")
+
+	// ErrDecryptionFailed is returned when a packet fails authentication.
+	ErrDecryptionFailed = errors.New("quic: packet decryption failed")
+
+	// ErrUnsupportedCipherSuite is returned for TLS cipher suites other
+	// than AES-GCM.
+	ErrUnsupportedCipherSuite = errors.New("quic: unsupported cipher suite")
+)
+
+// Keys are the packet protection keys for one direction of a connection.
+type Keys struct {
+	Key []byte // AEAD key
+	IV  []byte // AEAD nonce base, combined with the packet number
+	HP  []byte // Header protection key
+}
+
+// InitialKeys derives the Initial packet keys of both endpoints from the
+// Destination Connection ID of the client's first Initial packet.
+func InitialKeys(destConnID []byte) (client, server *Keys, err error) {
+	initialSecret, err := hkdf.Extract(sha256.New, destConnID, initialSaltV1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to extract initial secret: %w", err)
+	}
+
+	clientSecret, err := expandLabel(sha256.New, initialSecret, "client in", sha256.Size)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverSecret, err := expandLabel(sha256.New, initialSecret, "server in", sha256.Size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if client, err = DeriveKeys(clientSecret); err != nil {
+		return nil, nil, err
+	}
+	if server, err = DeriveKeys(serverSecret); err != nil {
+		return nil, nil, err
+	}
+	return client, server, nil
+}
+
+// DeriveKeys derives AES-128-GCM packet protection keys from a traffic
+// secret.
+func DeriveKeys(secret []byte) (*Keys, error) {
+	return DeriveSuiteKeys(suiteAES128GCMSHA256, secret)
+}
+
+// DeriveSuiteKeys derives packet protection keys from a traffic secret
+// negotiated with a TLS 1.3 cipher suite. Only the AES-GCM suites are
+// supported.
+func DeriveSuiteKeys(suite uint16, secret []byte) (*Keys, error) {
+	var h func() hash.Hash
+	var keyLength int
+	switch suite {
+	case suiteAES128GCMSHA256:
+		h, keyLength = sha256.New, 16
+	case suiteAES256GCMSHA384:
+		h, keyLength = sha512.New384, 32
+	default:
+		return nil, fmt.Errorf("%w: 0x%04x", ErrUnsupportedCipherSuite, suite)
+	}
+
+	key, err := expandLabel(h, secret, "quic key", keyLength)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := expandLabel(h, secret, "quic iv", aeadIVLength)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := expandLabel(h, secret, "quic hp", keyLength)
+	if err != nil {
+		return nil, err
+	}
+	return &Keys{Key: key, IV: iv, HP: hp}, nil
+}
+
+// expandLabel is HKDF-Expand-Label from TLS 1.3 (RFC 8446 Section 7.1)
+// with an empty context.
+func expandLabel(h func() hash.Hash, secret []byte, label string, length int) ([]byte, error) {
+	fullLabel := "tls13 " + label
+	info := make([]byte, 0, 4+len(fullLabel))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(fullLabel)))
+	info = append(info, fullLabel...)
+	info = append(info, 0) // Empty context
+
+	out, err := hkdf.Expand(h, secret, string(info), length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand %q: %w", label, err)
+	}
+	return out, nil
+}
+
+// Protector applies and removes packet protection for one direction.
+type Protector struct {
+	aead cipher.AEAD
+	hp   cipher.Block
+	iv   []byte
+}
+
+// NewProtector creates a Protector from packet protection keys.
+func NewProtector(keys *Keys) (*Protector, error) {
+	block, err := aes.NewCipher(keys.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid packet key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AEAD: %w", err)
+	}
+	hp, err := aes.NewCipher(keys.HP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header protection key: %w", err)
+	}
+	if len(keys.IV) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid IV length: %d", len(keys.IV))
+	}
+
+	return &Protector{aead: aead, hp: hp, iv: keys.IV}, nil
+}
+
+// Overhead returns the number of bytes the AEAD adds to a payload.
+func (p *Protector) Overhead() int {
+	return p.aead.Overhead()
+}
+
+// Seal protects a packet. header is the unprotected header ending with the
+// pnLen-byte packet number at pnOffset; payload is the plaintext. The
+// payload must be at least 4-pnLen bytes so there is enough ciphertext to
+// sample.
+func (p *Protector) Seal(header, payload []byte, pnOffset, pnLen int, pn uint64) ([]byte, error) {
+	if pnOffset+sampleOffset+sampleLength > len(header)+len(payload)+p.Overhead() {
+		return nil, ErrPacketTooShort
+	}
+
+	packet := make([]byte, len(header), len(header)+len(payload)+p.Overhead())
+	copy(packet, header)
+	packet = p.aead.Seal(packet, p.nonce(pn), payload, header)
+
+	p.applyHeaderProtection(packet, pnOffset, pnLen)
+	return packet, nil
+}
+
+// Open removes the protection from a packet whose packet number field
+// starts at pnOffset. largestPN is the largest packet number received in
+// the packet number space so far, or -1. It returns the unprotected header,
+// the plaintext payload and the full packet number.
+//
+// For long header packets, packet must end where the Length field says.
+func (p *Protector) Open(packet []byte, pnOffset int, largestPN int64) ([]byte, []byte, uint64, error) {
+	if pnOffset+sampleOffset+sampleLength > len(packet) {
+		return nil, nil, 0, ErrPacketTooShort
+	}
+
+	// Unmask a copy of the header to learn the packet number length
+	header := append([]byte(nil), packet[:pnOffset+sampleOffset]...)
+	mask := p.headerMask(packet[pnOffset+sampleOffset:])
+	if header[0]&0x80 != 0 {
+		header[0] ^= mask[0] & 0x0f
+	} else {
+		header[0] ^= mask[0] & 0x1f
+	}
+	pnLen := int(header[0]&0x03) + 1
+
+	var truncated uint64
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		truncated = truncated<<8 | uint64(header[pnOffset+i])
+	}
+	header = header[:pnOffset+pnLen]
+	pn := DecodePacketNumber(largestPN, truncated, pnLen)
+
+	payload, err := p.aead.Open(nil, p.nonce(pn), packet[pnOffset+pnLen:], header)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%w: packet %d", ErrDecryptionFailed, pn)
+	}
+	return header, payload, pn, nil
+}
+
+// nonce combines the IV with the packet number (RFC 9001 Section 5.3).
+func (p *Protector) nonce(pn uint64) []byte {
+	nonce := append([]byte(nil), p.iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	return nonce
+}
+
+// headerMask computes the header protection mask from a ciphertext sample
+// (RFC 9001 Section 5.4.3).
+func (p *Protector) headerMask(sample []byte) [aes.BlockSize]byte {
+	var mask [aes.BlockSize]byte
+	p.hp.Encrypt(mask[:], sample[:sampleLength])
+	return mask
+}
+
+// applyHeaderProtection masks the reserved and packet number length bits
+// of the first byte and the packet number itself.
+func (p *Protector) applyHeaderProtection(packet []byte, pnOffset, pnLen int) {
+	mask := p.headerMask(packet[pnOffset+sampleOffset:])
+	if packet[0]&0x80 != 0 {
+		packet[0] ^= mask[0] & 0x0f
+	} else {
+		packet[0] ^= mask[0] & 0x1f
+	}
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+}
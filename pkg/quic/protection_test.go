@@ -0,0 +1,187 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+// rfc9001ConnID is the client's Destination Connection ID in the RFC 9001
+// Appendix A examples.
+const rfc9001ConnID = "8394c8f03e515708"
+
+func TestInitialKeys(t *testing.T) {
+	client, server, err := InitialKeys(mustHex(t, rfc9001ConnID))
+	if err != nil {
+		t.Fatalf("InitialKeys() error = %v", err)
+	}
+
+	// RFC 9001 Appendix A.1
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"client key", client.Key, "1f369613dd76d5467730efcbe3b1a22d"},
+		{"client iv", client.IV, "fa044b2f42a3fd3b46fb255c"},
+		{"client hp", client.HP, "9f50449e04a0e810283a1e9933adedd2"},
+		{"server key", server.Key, "cf3a5331653c364c88f0f379b6067e37"},
+		{"server iv", server.IV, "0ac1493ca1905853b0bba03e"},
+		{"server hp", server.HP, "c206b8d9b9f0f37644430b490eeaa314"},
+	}
+	for _, tt := range tests {
+		if hex.EncodeToString(tt.got) != tt.want {
+			t.Errorf("%s = %x, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// Header protection mask of the client Initial (RFC 9001 Appendix A.2)
+	p, err := NewProtector(client)
+	if err != nil {
+		t.Fatalf("NewProtector() error = %v", err)
+	}
+	mask := p.headerMask(mustHex(t, "d1b1c98dd7689fb8ec11d242b123dc9b"))
+	if got := hex.EncodeToString(mask[:5]); got != "437b9aec36" {
+		t.Errorf("header mask = %s, want 437b9aec36", got)
+	}
+}
+
+func TestSealServerInitial(t *testing.T) {
+	// RFC 9001 Appendix A.3
+	header := mustHex(t, "c1000000010008f067a5502a4262b50040750001")
+	payload := mustHex(t, "02000000000600405a020000560303eefce7f7b37ba1d1632e96677825ddf73988cf"+
+		"c79825df566dc5430b9a045a1200130100002e00330024001d00209d3c940d89690b84d0"+
+		"8a60993c144eca684d1081287c834d5311bcf32bb9da1a002b00020304")
+	want := mustHex(t, "cf000000010008f067a5502a4262b5004075c0d95a482cd0991cd25b0aac406a5816b6394100"+
+		"f37a1c69797554780bb38cc5a99f5ede4cf73c3ec2493a1839b3dbcba3f6ea46c5b7684df354"+
+		"8e7ddeb9c3bf9c73cc3f3bded74b562bfb19fb84022f8ef4cdd93795d77d06edbb7aaf2f5889"+
+		"1850abbdca3d20398c276456cbc42158407dd074ee")
+
+	_, server, err := InitialKeys(mustHex(t, rfc9001ConnID))
+	if err != nil {
+		t.Fatalf("InitialKeys() error = %v", err)
+	}
+	p, err := NewProtector(server)
+	if err != nil {
+		t.Fatalf("NewProtector() error = %v", err)
+	}
+
+	sealed, err := p.Seal(header, payload, 18, 2, 1)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if !bytes.Equal(sealed, want) {
+		t.Errorf("Seal() =\n%x\nwant\n%x", sealed, want)
+	}
+
+	// The client opens it knowing nothing of the server's packet numbers
+	pkt, n, err := OpenPacket(sealed, 0, p, -1)
+	if err != nil {
+		t.Fatalf("OpenPacket() error = %v", err)
+	}
+	if n != len(sealed) || pkt.PacketNumber != 1 || !bytes.Equal(pkt.Payload, payload) {
+		t.Errorf("OpenPacket() = PN %d, %d bytes, payload %x", pkt.PacketNumber, n, pkt.Payload)
+	}
+	if hex.EncodeToString(pkt.SrcConnID) != "f067a5502a4262b5" {
+		t.Errorf("SrcConnID = %x, want f067a5502a4262b5", pkt.SrcConnID)
+	}
+}
+
+func TestProtectedInitialRoundTrip(t *testing.T) {
+	destConnID := mustHex(t, rfc9001ConnID)
+	client, _, err := InitialKeys(destConnID)
+	if err != nil {
+		t.Fatalf("InitialKeys() error = %v", err)
+	}
+	p, err := NewProtector(client)
+	if err != nil {
+		t.Fatalf("NewProtector() error = %v", err)
+	}
+
+	crypto := &CryptoFrame{Data: []byte("client hello")}
+	payload, _ := crypto.Serialize()
+	pkt := NewInitialPacket(destConnID, []byte{1, 2, 3, 4}, []byte("token"), payload)
+	pkt.PacketNumber = 2
+
+	sealed, err := pkt.SerializeProtected(p)
+	if err != nil {
+		t.Fatalf("SerializeProtected() error = %v", err)
+	}
+	if len(sealed) != MinInitialDatagramSize {
+		t.Errorf("protected Initial length = %d, want %d", len(sealed), MinInitialDatagramSize)
+	}
+
+	// A Handshake packet coalesced behind it must be left alone
+	datagram := append(sealed, 0xe0)
+	opened, n, err := OpenPacket(datagram, 0, p, -1)
+	if err != nil {
+		t.Fatalf("OpenPacket() error = %v", err)
+	}
+	if n != len(sealed) {
+		t.Errorf("OpenPacket() consumed %d bytes, want %d", n, len(sealed))
+	}
+	if opened.PacketNumber != 2 || string(opened.Token) != "token" {
+		t.Errorf("opened PN = %d, token = %q", opened.PacketNumber, opened.Token)
+	}
+
+	frames, err := ParseFrames(opened.Payload)
+	if err != nil {
+		t.Fatalf("ParseFrames() error = %v", err)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("frames = %v, want one CRYPTO frame", frames)
+	}
+	if f, ok := frames[0].(*CryptoFrame); !ok || string(f.Data) != "client hello" {
+		t.Errorf("frame = %v, want CRYPTO with client hello", frames[0])
+	}
+
+	// Any change to the packet fails authentication
+	sealed[len(sealed)-1] ^= 0xff
+	if _, _, err := OpenPacket(sealed, 0, p, -1); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("OpenPacket(tampered) error = %v, want ErrDecryptionFailed", err)
+	}
+}
+
+func TestProtectedShortHeaderRoundTrip(t *testing.T) {
+	keys, err := DeriveKeys(bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatalf("DeriveKeys() error = %v", err)
+	}
+	p, err := NewProtector(keys)
+	if err != nil {
+		t.Fatalf("NewProtector() error = %v", err)
+	}
+
+	// A one-byte payload is padded so the header protection sample fits
+	pkt := New1RTTPacket([]byte{9, 8, 7, 6, 5, 4, 3, 2}, []byte{byte(FrameTypePing)})
+	pkt.PacketNumber = 0x1234
+	pkt.PacketNumLen = 2
+	pkt.KeyPhase = 1
+
+	sealed, err := pkt.SerializeProtected(p)
+	if err != nil {
+		t.Fatalf("SerializeProtected() error = %v", err)
+	}
+
+	opened, _, err := OpenPacket(sealed, 8, p, 0x1200)
+	if err != nil {
+		t.Fatalf("OpenPacket() error = %v", err)
+	}
+	if opened.PacketNumber != 0x1234 || opened.KeyPhase != 1 {
+		t.Errorf("opened PN = %#x, key phase = %d", opened.PacketNumber, opened.KeyPhase)
+	}
+	frames, err := ParseFrames(opened.Payload)
+	if err != nil || len(frames) != 1 || frames[0].Type() != FrameTypePing {
+		t.Errorf("frames = %v, %v, want PING", frames, err)
+	}
+}
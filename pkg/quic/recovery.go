@@ -0,0 +1,140 @@
+package quic
+
+import (
+	"time"
+)
+
+// Loss detection constants (RFC 9002 Section 6 and Appendix A.2).
+const (
+	// packetThreshold is the reordering threshold in packets
+	packetThreshold = 3
+
+	// timeThresholdNum/timeThresholdDen is the reordering threshold as a
+	// fraction of the RTT (9/8)
+	timeThresholdNum = 9
+	timeThresholdDen = 8
+
+	// granularity is the timer granularity
+	granularity = time.Millisecond
+
+	// initialRTT is assumed before the first RTT sample
+	initialRTT = 333 * time.Millisecond
+
+	// maxPTOBackoff caps the exponential PTO backoff
+	maxPTOBackoff = 10
+)
+
+// rttStats estimates the round-trip time (RFC 9002 Section 5).
+type rttStats struct {
+	latest    time.Duration
+	smoothed  time.Duration
+	rttvar    time.Duration
+	min       time.Duration
+	hasSample bool
+}
+
+func newRTTStats() rttStats {
+	return rttStats{
+		smoothed: initialRTT,
+		rttvar:   initialRTT / 2,
+	}
+}
+
+// update adds an RTT sample. ackDelay is the peer's reported delay, which
+// is only subtracted once the handshake is confirmed and is capped at
+// maxAckDelay.
+func (r *rttStats) update(latest, ackDelay, maxAckDelay time.Duration, handshakeConfirmed bool) {
+	r.latest = latest
+	if !r.hasSample {
+		r.hasSample = true
+		r.min = latest
+		r.smoothed = latest
+		r.rttvar = latest / 2
+		return
+	}
+
+	r.min = min(r.min, latest)
+	if handshakeConfirmed {
+		ackDelay = min(ackDelay, maxAckDelay)
+	}
+
+	// Only subtract the ack delay if the sample stays above min_rtt
+	adjusted := latest
+	if latest >= r.min+ackDelay {
+		adjusted = latest - ackDelay
+	}
+
+	diff := r.smoothed - adjusted
+	if diff < 0 {
+		diff = -diff
+	}
+	r.rttvar = (3*r.rttvar + diff) / 4
+	r.smoothed = (7*r.smoothed + adjusted) / 8
+}
+
+// pto returns the probe timeout before backoff, including maxAckDelay for
+// the application data space.
+func (r *rttStats) pto(maxAckDelay time.Duration) time.Duration {
+	return r.smoothed + max(4*r.rttvar, granularity) + maxAckDelay
+}
+
+// lossDelay returns how long after a later packet was acknowledged an
+// unacknowledged packet is declared lost.
+func (r *rttStats) lossDelay() time.Duration {
+	d := max(r.latest, r.smoothed) * timeThresholdNum / timeThresholdDen
+	return max(d, granularity)
+}
+
+// detectLostPackets removes the packets of the space that are lost given
+// its largest acknowledged packet (RFC 9002 Section 6.1) and returns them.
+// It sets lossTime for the earliest packet that will be lost if it stays
+// unacknowledged.
+func (s *packetSpace) detectLostPackets(rtt *rttStats, now time.Time) []*sentPacket {
+	s.lossTime = time.Time{}
+	if s.largestAcked < 0 {
+		return nil
+	}
+
+	lossDelay := rtt.lossDelay()
+	lostSendTime := now.Add(-lossDelay)
+
+	var lost []*sentPacket
+	for pn, p := range s.sent {
+		if int64(pn) > s.largestAcked {
+			continue
+		}
+		if !p.time.After(lostSendTime) || s.largestAcked >= int64(pn)+packetThreshold {
+			delete(s.sent, pn)
+			lost = append(lost, p)
+			continue
+		}
+		if t := p.time.Add(lossDelay); s.lossTime.IsZero() || t.Before(s.lossTime) {
+			s.lossTime = t
+		}
+	}
+	return lost
+}
+
+// onAckReceived removes the packets an ACK frame covers and returns them.
+func (s *packetSpace) onAckReceived(f *AckFrame) []*sentPacket {
+	var acked []*sentPacket
+	for pn, p := range s.sent {
+		if f.Acknowledges(pn) {
+			delete(s.sent, pn)
+			acked = append(acked, p)
+		}
+	}
+	if int64(f.LargestAcknowledged) > s.largestAcked {
+		s.largestAcked = int64(f.LargestAcknowledged)
+	}
+	return acked
+}
+
+// requeue schedules the frames of lost packets for retransmission.
+// PADDING, PING and ACK frames are not retransmitted; a fresh ACK is built
+// for each packet.
+func (s *packetSpace) requeue(packets []*sentPacket) {
+	for _, p := range packets {
+		s.pending = append(s.pending, p.frames...)
+	}
+}
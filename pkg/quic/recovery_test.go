@@ -0,0 +1,81 @@
+package quic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTStats(t *testing.T) {
+	r := newRTTStats()
+	if r.smoothed != initialRTT {
+		t.Errorf("initial smoothed = %v, want %v", r.smoothed, initialRTT)
+	}
+
+	// The first sample sets the estimate directly
+	r.update(100*time.Millisecond, 0, DefaultMaxAckDelay, true)
+	if r.smoothed != 100*time.Millisecond || r.rttvar != 50*time.Millisecond || r.min != 100*time.Millisecond {
+		t.Errorf("after first sample = %+v", r)
+	}
+
+	// Later samples are smoothed, with the ack delay subtracted
+	r.update(130*time.Millisecond, 10*time.Millisecond, DefaultMaxAckDelay, true)
+	if want := (7*100*time.Millisecond + 120*time.Millisecond) / 8; r.smoothed != want {
+		t.Errorf("smoothed = %v, want %v", r.smoothed, want)
+	}
+	if want := (3*50*time.Millisecond + 20*time.Millisecond) / 4; r.rttvar != want {
+		t.Errorf("rttvar = %v, want %v", r.rttvar, want)
+	}
+
+	// The ack delay is capped at max_ack_delay once confirmed
+	r2 := newRTTStats()
+	r2.update(10*time.Millisecond, 0, DefaultMaxAckDelay, true)
+	r2.update(200*time.Millisecond, time.Second, DefaultMaxAckDelay, true)
+	if want := (7*10*time.Millisecond + 175*time.Millisecond) / 8; r2.smoothed != want {
+		t.Errorf("smoothed with capped delay = %v, want %v", r2.smoothed, want)
+	}
+
+	if got, want := r.pto(0), r.smoothed+4*r.rttvar; got != want {
+		t.Errorf("pto() = %v, want %v", got, want)
+	}
+}
+
+func TestDetectLostPackets(t *testing.T) {
+	now := time.Now()
+	rtt := newRTTStats()
+	rtt.update(100*time.Millisecond, 0, 0, true)
+
+	s := newPacketSpace(spaceApp)
+	for pn := uint64(0); pn < 6; pn++ {
+		s.sent[pn] = &sentPacket{pn: pn, time: now, ackEliciting: true, frames: []Frame{&PingFrame{}}}
+	}
+	s.nextPN = 6
+
+	// Acknowledging 4 makes 0 and 1 lost by packet threshold; 2 and 3 wait
+	// for the time threshold
+	acked := s.onAckReceived(&AckFrame{LargestAcknowledged: 4})
+	if len(acked) != 1 || acked[0].pn != 4 {
+		t.Fatalf("acked = %v, want packet 4", acked)
+	}
+	lost := s.detectLostPackets(&rtt, now)
+	if len(lost) != 2 {
+		t.Errorf("lost %d packets, want 2", len(lost))
+	}
+	if want := now.Add(rtt.lossDelay()); !s.lossTime.Equal(want) {
+		t.Errorf("lossTime = %v, want %v", s.lossTime, want)
+	}
+
+	// Once the loss time passes, 2 and 3 are lost; 5 is above the largest
+	// acknowledged and stays in flight
+	lost = s.detectLostPackets(&rtt, now.Add(rtt.lossDelay()))
+	if len(lost) != 2 {
+		t.Errorf("lost %d packets after loss time, want 2", len(lost))
+	}
+	if _, ok := s.sent[5]; !ok || len(s.sent) != 1 {
+		t.Errorf("in flight = %d packets, want only 5", len(s.sent))
+	}
+
+	s.requeue(lost)
+	if len(s.pending) != 2 {
+		t.Errorf("requeued %d frames, want 2", len(s.pending))
+	}
+}
@@ -0,0 +1,307 @@
+package quic
+
+import (
+	"time"
+)
+
+// spaceID identifies a packet number space (RFC 9000 Section 12.3).
+type spaceID int
+
+const (
+	spaceInitial spaceID = iota
+	spaceHandshake
+	spaceApp
+	numSpaces
+)
+
+func (s spaceID) String() string {
+	switch s {
+	case spaceInitial:
+		return "Initial"
+	case spaceHandshake:
+		return "Handshake"
+	case spaceApp:
+		return "1-RTT"
+	default:
+		return "Unknown"
+	}
+}
+
+const (
+	// maxAckRanges bounds the ranges kept for ACK frames; older ranges are
+	// forgotten, so very old reordered packets may be acknowledged again
+	maxAckRanges = 32
+
+	// maxCryptoBuffer bounds out-of-order CRYPTO data held per space
+	maxCryptoBuffer = 64 * 1024
+
+	// ackElicitingThreshold is the number of ack-eliciting 1-RTT packets
+	// received before an ACK is sent without waiting for max_ack_delay
+	ackElicitingThreshold = 2
+)
+
+// pnRange is an inclusive range of packet numbers.
+type pnRange struct {
+	lo, hi uint64
+}
+
+// pnRanges is a set of packet numbers as disjoint ranges, highest first.
+type pnRanges []pnRange
+
+// add inserts pn and reports whether it was new.
+func (r *pnRanges) add(pn uint64) bool {
+	s := *r
+	i := 0
+	for i < len(s) && s[i].lo > pn {
+		i++
+	}
+	if i < len(s) && s[i].lo <= pn && pn <= s[i].hi {
+		return false
+	}
+
+	switch {
+	case i < len(s) && s[i].hi+1 == pn:
+		// Extends range i upwards, possibly joining the range above it
+		s[i].hi = pn
+		if i > 0 && s[i-1].lo == pn+1 {
+			s[i-1].lo = s[i].lo
+			s = append(s[:i], s[i+1:]...)
+		}
+	case i > 0 && s[i-1].lo == pn+1:
+		// Extends range i-1 downwards
+		s[i-1].lo = pn
+	default:
+		s = append(s, pnRange{})
+		copy(s[i+1:], s[i:])
+		s[i] = pnRange{lo: pn, hi: pn}
+	}
+
+	if len(s) > maxAckRanges {
+		s = s[:maxAckRanges]
+	}
+	*r = s
+	return true
+}
+
+// contains reports whether pn is in the set.
+func (r pnRanges) contains(pn uint64) bool {
+	for _, rg := range r {
+		if rg.lo <= pn && pn <= rg.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// ackFrame builds an ACK frame covering the set.
+func (r pnRanges) ackFrame(ackDelay uint64) *AckFrame {
+	if len(r) == 0 {
+		return nil
+	}
+	f := &AckFrame{
+		LargestAcknowledged: r[0].hi,
+		AckDelay:            ackDelay,
+		FirstAckRange:       r[0].hi - r[0].lo,
+	}
+	for i := 1; i < len(r); i++ {
+		f.AckRanges = append(f.AckRanges, AckRange{
+			Gap:    r[i-1].lo - r[i].hi - 2,
+			Length: r[i].hi - r[i].lo,
+		})
+	}
+	return f
+}
+
+// sentPacket is a packet awaiting acknowledgement.
+type sentPacket struct {
+	pn           uint64
+	time         time.Time
+	size         int
+	ackEliciting bool
+	frames       []Frame // Retransmittable frames
+}
+
+// packetSpace holds the state of one packet number space: keys, sent
+// packets for loss detection, received packets for ACK generation and the
+// CRYPTO stream at its encryption level.
+type packetSpace struct {
+	id spaceID
+
+	// Packet protection, set when TLS provides the secrets
+	seal, open *Protector
+	discarded  bool
+
+	// Sending
+	nextPN               uint64
+	largestAcked         int64
+	sent                 map[uint64]*sentPacket
+	lossTime             time.Time
+	lastAckElicitingSent time.Time
+	pending              []Frame // Frames queued for (re)transmission
+	probes               int     // Probe packets owed after a PTO
+
+	// Receiving
+	received            pnRanges
+	largestRecv         int64
+	largestRecvTime     time.Time
+	ackElicitingUnacked int
+	ackDeadline         time.Time // Zero when no ACK is owed
+
+	// CRYPTO stream
+	cryptoSend       []byte // Data not yet sent
+	cryptoSendOffset uint64 // Stream offset of cryptoSend[0]
+	cryptoRecvOffset uint64
+	cryptoRecv       map[uint64][]byte // Out-of-order data by offset
+	cryptoBuffered   int
+}
+
+func newPacketSpace(id spaceID) *packetSpace {
+	return &packetSpace{
+		id:           id,
+		largestAcked: -1,
+		largestRecv:  -1,
+		sent:         make(map[uint64]*sentPacket),
+		cryptoRecv:   make(map[uint64][]byte),
+	}
+}
+
+// canSend reports whether packets can be sent in the space.
+func (s *packetSpace) canSend() bool {
+	return s.seal != nil && !s.discarded
+}
+
+// canReceive reports whether packets in the space can be opened.
+func (s *packetSpace) canReceive() bool {
+	return s.open != nil && !s.discarded
+}
+
+// discard drops the keys and all state of the space (RFC 9001 Section
+// 4.9): nothing is retransmitted or acknowledged in it any more.
+func (s *packetSpace) discard() {
+	s.discarded = true
+	s.seal, s.open = nil, nil
+	s.sent = make(map[uint64]*sentPacket)
+	s.lossTime = time.Time{}
+	s.pending = nil
+	s.probes = 0
+	s.ackDeadline = time.Time{}
+	s.cryptoSend = nil
+	s.cryptoRecv = make(map[uint64][]byte)
+}
+
+// onPacketReceived records a received packet number and schedules an ACK.
+// It reports whether the packet is new. Initial and Handshake packets are
+// acknowledged at once; 1-RTT packets after ackElicitingThreshold packets
+// or maxAckDelay.
+func (s *packetSpace) onPacketReceived(pn uint64, ackEliciting bool, now time.Time, maxAckDelay time.Duration) bool {
+	if !s.received.add(pn) {
+		return false
+	}
+	if int64(pn) > s.largestRecv {
+		s.largestRecv = int64(pn)
+		s.largestRecvTime = now
+	}
+	if !ackEliciting {
+		return true
+	}
+
+	s.ackElicitingUnacked++
+	if s.id != spaceApp || s.ackElicitingUnacked >= ackElicitingThreshold {
+		s.ackDeadline = now
+	} else if s.ackDeadline.IsZero() {
+		s.ackDeadline = now.Add(maxAckDelay)
+	}
+	return true
+}
+
+// ackDue reports whether an ACK should be sent now.
+func (s *packetSpace) ackDue(now time.Time) bool {
+	return !s.ackDeadline.IsZero() && !now.Before(s.ackDeadline)
+}
+
+// onAckSent clears the pending ACK.
+func (s *packetSpace) onAckSent() {
+	s.ackElicitingUnacked = 0
+	s.ackDeadline = time.Time{}
+}
+
+// ackElicitingInFlight reports whether any ack-eliciting packet is
+// unacknowledged.
+func (s *packetSpace) ackElicitingInFlight() bool {
+	for _, p := range s.sent {
+		if p.ackEliciting {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCrypto queues handshake data from TLS.
+func (s *packetSpace) writeCrypto(data []byte) {
+	s.cryptoSend = append(s.cryptoSend, data...)
+}
+
+// takeCrypto returns a CRYPTO frame with up to n bytes of queued data.
+func (s *packetSpace) takeCrypto(n int) *CryptoFrame {
+	if len(s.cryptoSend) == 0 || n <= 0 {
+		return nil
+	}
+	n = min(n, len(s.cryptoSend))
+	f := &CryptoFrame{Offset: s.cryptoSendOffset, Data: s.cryptoSend[:n:n]}
+	s.cryptoSend = s.cryptoSend[n:]
+	s.cryptoSendOffset += uint64(n)
+	return f
+}
+
+// receiveCrypto reassembles CRYPTO data and returns whatever became
+// contiguous, in order.
+func (s *packetSpace) receiveCrypto(f *CryptoFrame) ([]byte, error) {
+	offset, data := f.Offset, f.Data
+	end := offset + uint64(len(data))
+	if end <= s.cryptoRecvOffset {
+		return nil, nil // Duplicate
+	}
+	if offset < s.cryptoRecvOffset {
+		data = data[s.cryptoRecvOffset-offset:]
+		offset = s.cryptoRecvOffset
+	}
+
+	if offset > s.cryptoRecvOffset {
+		if _, ok := s.cryptoRecv[offset]; !ok {
+			if s.cryptoBuffered+len(data) > maxCryptoBuffer {
+				return nil, protocolError(ErrCodeCryptoBufferExceeded, "%s CRYPTO buffer exceeded", s.id)
+			}
+			s.cryptoRecv[offset] = data
+			s.cryptoBuffered += len(data)
+		}
+		return nil, nil
+	}
+
+	// In order: deliver it and any buffered data it connects to
+	out := append([]byte(nil), data...)
+	s.cryptoRecvOffset = end
+	for {
+		found := false
+		for o, d := range s.cryptoRecv {
+			oend := o + uint64(len(d))
+			if o > s.cryptoRecvOffset {
+				continue
+			}
+			delete(s.cryptoRecv, o)
+			s.cryptoBuffered -= len(d)
+			if oend > s.cryptoRecvOffset {
+				out = append(out, d[s.cryptoRecvOffset-o:]...)
+				s.cryptoRecvOffset = oend
+			}
+			found = true
+		}
+		if !found {
+			return out, nil
+		}
+	}
+}
+
+// hasData reports whether the space has anything to send now.
+func (s *packetSpace) hasData(now time.Time) bool {
+	return s.canSend() && (len(s.cryptoSend) > 0 || len(s.pending) > 0 || s.probes > 0 || s.ackDue(now))
+}
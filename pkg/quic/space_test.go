@@ -0,0 +1,131 @@
+package quic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPNRangesAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		pns  []uint64
+		want pnRanges
+	}{
+		{"in order", []uint64{0, 1, 2}, pnRanges{{0, 2}}},
+		{"gap", []uint64{0, 1, 5}, pnRanges{{5, 5}, {0, 1}}},
+		{"fill gap", []uint64{0, 2, 1}, pnRanges{{0, 2}}},
+		{"extend down", []uint64{5, 4}, pnRanges{{4, 5}}},
+		{"reordered", []uint64{9, 3, 7, 8, 1}, pnRanges{{7, 9}, {3, 3}, {1, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r pnRanges
+			for _, pn := range tt.pns {
+				if !r.add(pn) {
+					t.Errorf("add(%d) = false for a new packet", pn)
+				}
+			}
+			if !reflect.DeepEqual(r, tt.want) {
+				t.Errorf("ranges = %v, want %v", r, tt.want)
+			}
+			if r.add(tt.pns[0]) {
+				t.Errorf("add(%d) = true for a duplicate", tt.pns[0])
+			}
+		})
+	}
+}
+
+func TestPNRangesAckFrame(t *testing.T) {
+	var r pnRanges
+	for _, pn := range []uint64{0, 1, 2, 5, 6, 9} {
+		r.add(pn)
+	}
+
+	f := r.ackFrame(0)
+	if f.LargestAcknowledged != 9 || f.FirstAckRange != 0 {
+		t.Fatalf("ACK = %+v, want largest 9 with first range 0", f)
+	}
+
+	// The frame acknowledges exactly the received packets
+	for pn := uint64(0); pn <= 10; pn++ {
+		if got, want := f.Acknowledges(pn), r.contains(pn); got != want {
+			t.Errorf("Acknowledges(%d) = %v, want %v", pn, got, want)
+		}
+	}
+}
+
+func TestPacketSpaceAckScheduling(t *testing.T) {
+	now := time.Now()
+
+	// Handshake packets are acknowledged at once
+	hs := newPacketSpace(spaceHandshake)
+	hs.onPacketReceived(0, true, now, DefaultMaxAckDelay)
+	if !hs.ackDue(now) {
+		t.Error("Handshake ACK not due immediately")
+	}
+
+	// 1-RTT packets wait for a second packet or max_ack_delay
+	app := newPacketSpace(spaceApp)
+	app.onPacketReceived(0, true, now, DefaultMaxAckDelay)
+	if app.ackDue(now) {
+		t.Error("1-RTT ACK due after one packet")
+	}
+	if !app.ackDue(now.Add(DefaultMaxAckDelay)) {
+		t.Error("1-RTT ACK not due after max_ack_delay")
+	}
+	app.onPacketReceived(1, true, now, DefaultMaxAckDelay)
+	if !app.ackDue(now) {
+		t.Error("1-RTT ACK not due after two packets")
+	}
+
+	// Packets that are not ack-eliciting do not schedule an ACK
+	app.onAckSent()
+	app.onPacketReceived(2, false, now, DefaultMaxAckDelay)
+	if !app.ackDeadline.IsZero() {
+		t.Error("ACK scheduled for a packet that is not ack-eliciting")
+	}
+	if app.onPacketReceived(2, true, now, DefaultMaxAckDelay) {
+		t.Error("duplicate packet reported as new")
+	}
+}
+
+func TestPacketSpaceCrypto(t *testing.T) {
+	s := newPacketSpace(spaceInitial)
+	s.writeCrypto([]byte("hello, world"))
+
+	first := s.takeCrypto(5)
+	second := s.takeCrypto(100)
+	if string(first.Data) != "hello" || first.Offset != 0 {
+		t.Errorf("first = %q at %d", first.Data, first.Offset)
+	}
+	if string(second.Data) != ", world" || second.Offset != 5 {
+		t.Errorf("second = %q at %d", second.Data, second.Offset)
+	}
+	if s.takeCrypto(100) != nil {
+		t.Error("takeCrypto() returned data after draining")
+	}
+
+	// Out-of-order and overlapping delivery is reassembled
+	r := newPacketSpace(spaceInitial)
+	if got, _ := r.receiveCrypto(second); got != nil {
+		t.Errorf("out-of-order data delivered early: %q", got)
+	}
+	got, err := r.receiveCrypto(&CryptoFrame{Offset: 0, Data: []byte("hello, ")})
+	if err != nil {
+		t.Fatalf("receiveCrypto() error = %v", err)
+	}
+	if string(got) != "hello, world" {
+		t.Errorf("reassembled = %q, want %q", got, "hello, world")
+	}
+	if got, _ := r.receiveCrypto(first); got != nil {
+		t.Errorf("duplicate data delivered: %q", got)
+	}
+
+	// Buffering is bounded
+	big := &CryptoFrame{Offset: 1 << 20, Data: make([]byte, maxCryptoBuffer+1)}
+	if _, err := r.receiveCrypto(big); err == nil {
+		t.Error("receiveCrypto() buffered past the limit")
+	}
+}
@@ -0,0 +1,747 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// maxStreamSendBuffer bounds data written to a stream but not yet
+	// sent; Write blocks beyond it
+	maxStreamSendBuffer = 256 * 1024
+
+	// streamFrameOverhead bounds the type, stream ID, offset and length of
+	// a STREAM frame in a packet of maxDatagramSize
+	streamFrameOverhead = 1 + 8 + 8 + 2
+)
+
+// Stream ID bits (RFC 9000 Section 2.1).
+const (
+	streamServerInitiated = 0x01
+	streamUnidirectional  = 0x02
+)
+
+// ErrStreamLimit is returned by OpenStream when the peer allows no more
+// streams of the type.
+var ErrStreamLimit = errors.New("quic: stream limit reached")
+
+// StreamError reports a stream part ended with an application error code:
+// the sending part by RESET_STREAM, the receiving part by STOP_SENDING.
+type StreamError struct {
+	StreamID uint64
+	Code     uint64
+	Remote   bool // Ended by the peer
+}
+
+func (e *StreamError) Error() string {
+	side := "local"
+	if e.Remote {
+		side = "remote"
+	}
+	return fmt.Sprintf("quic: stream %d canceled by %s error 0x%x", e.StreamID, side, e.Code)
+}
+
+// streamDir indexes the per-direction stream counters.
+type streamDir int
+
+const (
+	dirBidi streamDir = iota
+	dirUni
+)
+
+func streamDirOf(id uint64) streamDir {
+	if id&streamUnidirectional != 0 {
+		return dirUni
+	}
+	return dirBidi
+}
+
+// Stream represents a QUIC stream. It is an ordered byte stream like a TCP
+// connection; a unidirectional stream only sends or only receives.
+//
+// Streams are safe for one reader and one writer at a time.
+type Stream struct {
+	ID   uint64
+	conn *Connection
+
+	// Which parts the stream has
+	canSend, canRecv bool
+
+	// Send side
+	sendBuf  []byte // Written but not yet sent
+	offset   uint64 // Stream offset of sendBuf[0]
+	sendMax  uint64 // Peer's flow control limit
+	finished bool   // Closed for writing: FIN follows sendBuf
+	finSent  bool
+	sendErr  *StreamError // Set once the sending part is reset
+	queued   bool         // In the connection's send queue
+
+	// Receive side
+	recvBuf      []byte            // In-order data not yet read
+	readOffset   uint64            // Stream offset of recvBuf[0]
+	recvOffset   uint64            // End of the contiguous data received
+	recvPending  map[uint64][]byte // Out-of-order data by offset
+	recvHighest  uint64            // Highest offset received
+	recvMax      uint64            // Limit advertised to the peer
+	recvWindow   uint64
+	finalSize    uint64
+	hasFinalSize bool
+	recvErr      *StreamError // Set once reset by the peer or canceled
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// done is set when both parts have ended and the stream is forgotten
+	done bool
+}
+
+// isLocalStream reports whether this endpoint opened the stream.
+func (c *Connection) isLocalStream(id uint64) bool {
+	return (id&streamServerInitiated == 0) == c.isClient
+}
+
+// newStream creates a stream with the flow control limits of its type
+// (RFC 9000 Section 18.2).
+func (c *Connection) newStream(id uint64) *Stream {
+	s := &Stream{ID: id, conn: c, recvPending: make(map[uint64][]byte)}
+	local, peer := c.localParams, c.peerParams
+
+	switch {
+	case streamDirOf(id) == dirUni && c.isLocalStream(id):
+		s.canSend = true
+		s.sendMax = peer.InitialMaxStreamDataUni
+	case streamDirOf(id) == dirUni:
+		s.canRecv = true
+		s.recvWindow = local.InitialMaxStreamDataUni
+	case c.isLocalStream(id):
+		s.canSend, s.canRecv = true, true
+		s.sendMax = peer.InitialMaxStreamDataBidiRemote
+		s.recvWindow = local.InitialMaxStreamDataBidiLocal
+	default:
+		s.canSend, s.canRecv = true, true
+		s.sendMax = peer.InitialMaxStreamDataBidiLocal
+		s.recvWindow = local.InitialMaxStreamDataBidiRemote
+	}
+	s.recvMax = s.recvWindow
+
+	c.streams[id] = s
+	return s
+}
+
+// OpenStream opens a bidirectional stream. It fails with ErrStreamLimit
+// if the peer allows no more streams.
+func (c *Connection) OpenStream() (*Stream, error) {
+	return c.openStream(dirBidi)
+}
+
+// OpenUniStream opens a unidirectional stream for sending.
+func (c *Connection) OpenUniStream() (*Stream, error) {
+	return c.openStream(dirUni)
+}
+
+func (c *Connection) openStream(dir streamDir) (*Stream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state >= StateClosing {
+		return nil, c.closedError()
+	}
+	if c.state != StateEstablished {
+		return nil, fmt.Errorf("connection not established")
+	}
+	if c.opened[dir] >= c.peerMaxStreams[dir] {
+		c.spaces[spaceApp].pending = append(c.spaces[spaceApp].pending,
+			&StreamsBlockedFrame{Unidirectional: dir == dirUni, MaximumStreams: c.peerMaxStreams[dir]})
+		c.signal()
+		return nil, ErrStreamLimit
+	}
+
+	// Stream IDs count up by 4 within each type
+	id := c.opened[dir] << 2
+	if dir == dirUni {
+		id |= streamUnidirectional
+	}
+	if !c.isClient {
+		id |= streamServerInitiated
+	}
+	c.opened[dir]++
+	return c.newStream(id), nil
+}
+
+// AcceptStream waits for the peer to open a bidirectional stream.
+func (c *Connection) AcceptStream(ctx context.Context) (*Stream, error) {
+	return c.acceptStream(ctx, dirBidi)
+}
+
+// AcceptUniStream waits for the peer to open a unidirectional stream.
+func (c *Connection) AcceptUniStream(ctx context.Context) (*Stream, error) {
+	return c.acceptStream(ctx, dirUni)
+}
+
+func (c *Connection) acceptStream(ctx context.Context, dir streamDir) (*Stream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, c.broadcast)
+	defer stop()
+
+	for {
+		if q := c.acceptQueue[dir]; len(q) > 0 {
+			c.acceptQueue[dir] = q[1:]
+			return q[0], nil
+		}
+		if c.state >= StateClosing {
+			return nil, c.closedError()
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c.streamCond.Wait()
+	}
+}
+
+// broadcast wakes goroutines blocked on streams.
+func (c *Connection) broadcast() {
+	c.mu.Lock()
+	c.streamCond.Broadcast()
+	c.mu.Unlock()
+}
+
+// closedError returns the error for stream operations on a closed
+// connection, wrapping why it closed.
+func (c *Connection) closedError() error {
+	if c.closeErr == nil {
+		return ErrConnectionClosed
+	}
+	return fmt.Errorf("%w: %w", ErrConnectionClosed, c.closeErr)
+}
+
+// streamForFrame returns the stream a received frame refers to. sendSide
+// is set for frames about our sending part (MAX_STREAM_DATA,
+// STOP_SENDING). Peer streams are opened implicitly, with all lower
+// numbered streams of the type; nil is returned for closed streams.
+func (c *Connection) streamForFrame(id uint64, sendSide bool) (*Stream, error) {
+	dir := streamDirOf(id)
+	local := c.isLocalStream(id)
+	if dir == dirUni && local != sendSide {
+		return nil, protocolError(ErrCodeStreamState, "frame for the wrong part of unidirectional stream %d", id)
+	}
+
+	if s, ok := c.streams[id]; ok {
+		return s, nil
+	}
+
+	index := id >> 2
+	if local {
+		if index >= c.opened[dir] {
+			return nil, protocolError(ErrCodeStreamState, "frame for unopened stream %d", id)
+		}
+		return nil, nil
+	}
+	if index < c.peerOpened[dir] {
+		return nil, nil
+	}
+	if index >= c.localMaxStreams[dir] {
+		return nil, protocolError(ErrCodeStreamLimit, "stream %d exceeds limit %d", id, c.localMaxStreams[dir])
+	}
+
+	for i := c.peerOpened[dir]; i <= index; i++ {
+		s := c.newStream(i<<2 | id&0x03)
+		c.acceptQueue[dir] = append(c.acceptQueue[dir], s)
+	}
+	c.peerOpened[dir] = index + 1
+	c.streamCond.Broadcast()
+	return c.streams[id], nil
+}
+
+// handleStream processes a STREAM frame.
+func (c *Connection) handleStream(f *StreamFrame) error {
+	s, err := c.streamForFrame(f.StreamID, false)
+	if err != nil || s == nil {
+		return err
+	}
+
+	end := f.Offset + uint64(len(f.Data))
+	if err := s.checkFinalSize(end, f.Fin); err != nil {
+		return err
+	}
+	if end > s.recvMax {
+		return protocolError(ErrCodeFlowControl, "stream %d exceeded limit %d", s.ID, s.recvMax)
+	}
+	if err := c.accountReceived(s, end); err != nil {
+		return err
+	}
+
+	if s.recvErr != nil {
+		// Canceled: the data only counts against flow control
+		c.discardReceived(s)
+	} else {
+		s.receive(f.Offset, f.Data)
+	}
+	c.maybeCompleteStream(s)
+	c.streamCond.Broadcast()
+	return nil
+}
+
+// handleResetStream processes RESET_STREAM: unread data is discarded and
+// reads fail with the peer's error code.
+func (c *Connection) handleResetStream(f *ResetStreamFrame) error {
+	s, err := c.streamForFrame(f.StreamID, false)
+	if err != nil || s == nil {
+		return err
+	}
+	if err := s.checkFinalSize(f.FinalSize, true); err != nil {
+		return err
+	}
+	if err := c.accountReceived(s, f.FinalSize); err != nil {
+		return err
+	}
+
+	if s.recvErr == nil {
+		s.recvErr = &StreamError{StreamID: s.ID, Code: f.ErrorCode, Remote: true}
+	}
+	c.discardReceived(s)
+	c.maybeCompleteStream(s)
+	c.streamCond.Broadcast()
+	return nil
+}
+
+// handleStopSending processes STOP_SENDING by resetting the stream with
+// the peer's error code (RFC 9000 Section 3.5).
+func (c *Connection) handleStopSending(f *StopSendingFrame) error {
+	s, err := c.streamForFrame(f.StreamID, true)
+	if err != nil || s == nil {
+		return err
+	}
+	if s.sendErr == nil {
+		c.resetSend(s, f.ErrorCode, true)
+	}
+	return nil
+}
+
+// handleMaxStreamData raises a stream's send limit.
+func (c *Connection) handleMaxStreamData(f *MaxStreamDataFrame) error {
+	s, err := c.streamForFrame(f.StreamID, true)
+	if err != nil || s == nil {
+		return err
+	}
+	if f.MaximumStreamData > s.sendMax {
+		s.sendMax = f.MaximumStreamData
+		c.streamCond.Broadcast()
+	}
+	return nil
+}
+
+// handleMaxStreams raises how many streams we may open.
+func (c *Connection) handleMaxStreams(f *MaxStreamsFrame) {
+	dir := dirBidi
+	if f.Unidirectional {
+		dir = dirUni
+	}
+	if f.MaximumStreams > c.peerMaxStreams[dir] {
+		c.peerMaxStreams[dir] = f.MaximumStreams
+		c.streamCond.Broadcast()
+	}
+}
+
+// checkFinalSize enforces that a stream's final size never changes and
+// covers all data received (RFC 9000 Section 4.5).
+func (s *Stream) checkFinalSize(end uint64, fin bool) error {
+	if s.hasFinalSize && (end > s.finalSize || fin && end != s.finalSize) {
+		return protocolError(ErrCodeFinalSize, "stream %d final size changed", s.ID)
+	}
+	if fin && end < s.recvHighest {
+		return protocolError(ErrCodeFinalSize, "stream %d final size below data received", s.ID)
+	}
+	if fin {
+		s.finalSize, s.hasFinalSize = end, true
+	}
+	return nil
+}
+
+// accountReceived charges newly received stream data against the
+// connection's flow control limit.
+func (c *Connection) accountReceived(s *Stream, end uint64) error {
+	if end <= s.recvHighest {
+		return nil
+	}
+	c.recvHighest += end - s.recvHighest
+	s.recvHighest = end
+	if c.recvHighest > c.recvMaxData {
+		return protocolError(ErrCodeFlowControl, "connection exceeded limit %d", c.recvMaxData)
+	}
+	return nil
+}
+
+// discardReceived drops unread data of a canceled or reset stream,
+// returning its flow control credit.
+func (c *Connection) discardReceived(s *Stream) {
+	n := s.recvHighest - s.readOffset
+	s.readOffset, s.recvOffset = s.recvHighest, s.recvHighest
+	s.recvBuf = nil
+	clear(s.recvPending)
+	c.onConsumed(n)
+}
+
+// receive adds data at offset, appending whatever becomes contiguous to
+// recvBuf.
+func (s *Stream) receive(offset uint64, data []byte) {
+	if len(data) > 0 && offset+uint64(len(data)) > s.recvOffset {
+		// Keep the longer of two segments at the same offset
+		if prev, ok := s.recvPending[offset]; !ok || len(prev) < len(data) {
+			s.recvPending[offset] = bytes.Clone(data)
+		}
+	}
+
+	for progress := true; progress; {
+		progress = false
+		for o, d := range s.recvPending {
+			if o > s.recvOffset {
+				continue
+			}
+			delete(s.recvPending, o)
+			if end := o + uint64(len(d)); end > s.recvOffset {
+				s.recvBuf = append(s.recvBuf, d[s.recvOffset-o:]...)
+				s.recvOffset = end
+			}
+			progress = true
+		}
+	}
+}
+
+// onConsumed returns connection flow control credit for n bytes the
+// application has read, sending MAX_DATA once half the window is used.
+func (c *Connection) onConsumed(n uint64) {
+	c.recvConsumed += n
+	window := c.localParams.InitialMaxData
+	if c.recvMaxData-c.recvConsumed < window/2 {
+		c.recvMaxData = c.recvConsumed + window
+		c.spaces[spaceApp].pending = append(c.spaces[spaceApp].pending, &MaxDataFrame{MaximumData: c.recvMaxData})
+		c.signal()
+	}
+}
+
+// resetSend abandons the sending part with RESET_STREAM.
+func (c *Connection) resetSend(s *Stream, code uint64, remote bool) {
+	s.sendErr = &StreamError{StreamID: s.ID, Code: code, Remote: remote}
+	s.sendBuf = nil
+	c.spaces[spaceApp].pending = append(c.spaces[spaceApp].pending,
+		&ResetStreamFrame{StreamID: s.ID, ErrorCode: code, FinalSize: s.offset})
+	c.maybeCompleteStream(s)
+	c.streamCond.Broadcast()
+	c.signal()
+}
+
+// maybeCompleteStream forgets a stream once both parts have ended. Closing
+// a peer stream lets the peer open another, announced with MAX_STREAMS.
+func (c *Connection) maybeCompleteStream(s *Stream) {
+	if s.done {
+		return
+	}
+	sendDone := !s.canSend || s.sendErr != nil || s.finSent && len(s.sendBuf) == 0
+	recvDone := !s.canRecv || s.hasFinalSize && s.readOffset == s.finalSize
+	if !sendDone || !recvDone {
+		return
+	}
+
+	s.done = true
+	delete(c.streams, s.ID)
+	if !c.isLocalStream(s.ID) {
+		dir := streamDirOf(s.ID)
+		c.localMaxStreams[dir]++
+		c.spaces[spaceApp].pending = append(c.spaces[spaceApp].pending,
+			&MaxStreamsFrame{Unidirectional: dir == dirUni, MaximumStreams: c.localMaxStreams[dir]})
+		c.signal()
+	}
+}
+
+// queueStream schedules a stream with data or a FIN to send.
+func (c *Connection) queueStream(s *Stream) {
+	if !s.queued {
+		s.queued = true
+		c.sendQueue = append(c.sendQueue, s)
+	}
+}
+
+// streamsReady reports whether a queued stream can send now.
+func (c *Connection) streamsReady() bool {
+	for _, s := range c.sendQueue {
+		if s.sendErr != nil {
+			continue
+		}
+		if len(s.sendBuf) == 0 && s.finished && !s.finSent {
+			return true
+		}
+		if len(s.sendBuf) > 0 && s.sendMax > s.offset && c.maxData > c.dataSent {
+			return true
+		}
+	}
+	return false
+}
+
+// appendStreamFrames fills the rest of a packet with STREAM frames, taking
+// the queued streams in turn.
+func (c *Connection) appendStreamFrames(payload []byte, frames []Frame, budget int) ([]byte, []Frame) {
+	for n := len(c.sendQueue); n > 0; n-- {
+		room := budget - len(payload) - streamFrameOverhead
+		if room <= 0 {
+			break
+		}
+
+		s := c.sendQueue[0]
+		c.sendQueue = c.sendQueue[1:]
+		if f := c.nextStreamFrame(s, room); f != nil {
+			if b, err := f.Serialize(); err == nil {
+				payload = append(payload, b...)
+				frames = append(frames, f)
+			}
+			c.maybeCompleteStream(s)
+		}
+
+		// Streams with more to send go to the back of the queue
+		if s.sendErr == nil && (len(s.sendBuf) > 0 || s.finished && !s.finSent) {
+			c.sendQueue = append(c.sendQueue, s)
+		} else {
+			s.queued = false
+		}
+	}
+	return payload, frames
+}
+
+// nextStreamFrame takes up to room bytes of a stream's data, within the
+// stream and connection flow control limits.
+func (c *Connection) nextStreamFrame(s *Stream, room int) *StreamFrame {
+	if s.sendErr != nil {
+		return nil
+	}
+	n := uint64(min(len(s.sendBuf), room))
+	n = min(n, s.sendMax-min(s.sendMax, s.offset), c.maxData-min(c.maxData, c.dataSent))
+	fin := s.finished && !s.finSent && n == uint64(len(s.sendBuf))
+	if n == 0 && !fin {
+		return nil
+	}
+
+	f := &StreamFrame{
+		StreamID: s.ID,
+		Offset:   s.offset,
+		Data:     bytes.Clone(s.sendBuf[:n]),
+		Fin:      fin,
+	}
+	s.sendBuf = s.sendBuf[n:]
+	s.offset += n
+	c.dataSent += n
+	if fin {
+		s.finSent = true
+	}
+	if n > 0 {
+		// Writers may be waiting for buffer space
+		c.streamCond.Broadcast()
+	}
+	return f
+}
+
+// abandoned reports whether a frame queued for retransmission carries data
+// of a stream we have since reset.
+func (c *Connection) abandoned(f Frame) bool {
+	sf, ok := f.(*StreamFrame)
+	if !ok {
+		return false
+	}
+	s, ok := c.streams[sf.StreamID]
+	return ok && s.sendErr != nil
+}
+
+// wait blocks on the connection's stream condition until woken or the
+// deadline passes. It must be called with c.mu held.
+func (s *Stream) wait(deadline time.Time) error {
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.AfterFunc(d, s.conn.broadcast)
+		defer t.Stop()
+	}
+	s.conn.streamCond.Wait()
+	return nil
+}
+
+// Read reads data in order, blocking until some arrives. It returns
+// io.EOF after the peer's FIN, and a *StreamError if the peer reset the
+// stream.
+func (s *Stream) Read(p []byte) (int, error) {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !s.canRecv {
+		return 0, fmt.Errorf("quic: stream %d is send-only", s.ID)
+	}
+	for {
+		if s.recvErr != nil {
+			return 0, s.recvErr
+		}
+		if len(s.recvBuf) > 0 {
+			n := copy(p, s.recvBuf)
+			s.recvBuf = s.recvBuf[n:]
+			s.readOffset += uint64(n)
+			c.onStreamRead(s, uint64(n))
+			return n, nil
+		}
+		if s.hasFinalSize && s.readOffset == s.finalSize {
+			return 0, io.EOF
+		}
+		if c.state >= StateClosing {
+			return 0, c.closedError()
+		}
+		if err := s.wait(s.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// onStreamRead returns flow control credit for data read from a stream,
+// sending MAX_STREAM_DATA once half the stream window is used.
+func (c *Connection) onStreamRead(s *Stream, n uint64) {
+	if !s.hasFinalSize && s.recvMax-s.readOffset < s.recvWindow/2 {
+		s.recvMax = s.readOffset + s.recvWindow
+		c.spaces[spaceApp].pending = append(c.spaces[spaceApp].pending,
+			&MaxStreamDataFrame{StreamID: s.ID, MaximumStreamData: s.recvMax})
+		c.signal()
+	}
+	c.onConsumed(n)
+	c.maybeCompleteStream(s)
+}
+
+// Write queues data to send, blocking while the stream's send buffer is
+// full. Data is sent as flow control allows and retransmitted until
+// acknowledged.
+func (s *Stream) Write(p []byte) (int, error) {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !s.canSend {
+		return 0, fmt.Errorf("quic: stream %d is receive-only", s.ID)
+	}
+	written := 0
+	for len(p) > 0 {
+		if err := s.writeError(); err != nil {
+			return written, err
+		}
+		room := maxStreamSendBuffer - len(s.sendBuf)
+		if room <= 0 {
+			if err := s.wait(s.writeDeadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n := min(room, len(p))
+		s.sendBuf = append(s.sendBuf, p[:n]...)
+		p = p[n:]
+		written += n
+		c.queueStream(s)
+		c.signal()
+	}
+	return written, nil
+}
+
+// writeError returns why the stream cannot be written, if it cannot.
+func (s *Stream) writeError() error {
+	switch {
+	case s.sendErr != nil:
+		return s.sendErr
+	case s.finished:
+		return fmt.Errorf("quic: write on closed stream %d", s.ID)
+	case s.conn.state >= StateClosing:
+		return s.conn.closedError()
+	}
+	return nil
+}
+
+// Close ends the sending part with a FIN once buffered data is sent. The
+// receiving part stays open until the peer finishes or CancelRead.
+func (s *Stream) Close() error {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !s.canSend || s.finished || s.sendErr != nil {
+		return nil
+	}
+	s.finished = true
+	c.queueStream(s)
+	c.signal()
+	return nil
+}
+
+// CancelWrite abandons the sending part: unsent data is dropped and the
+// peer is sent RESET_STREAM with code.
+func (s *Stream) CancelWrite(code uint64) {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s.canSend && s.sendErr == nil && c.state < StateClosing {
+		c.resetSend(s, code, false)
+	}
+}
+
+// CancelRead discards received data and asks the peer to stop sending
+// with STOP_SENDING carrying code.
+func (s *Stream) CancelRead(code uint64) {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !s.canRecv || s.recvErr != nil || s.hasFinalSize && s.readOffset == s.finalSize || c.state >= StateClosing {
+		return
+	}
+	s.recvErr = &StreamError{StreamID: s.ID, Code: code}
+	c.discardReceived(s)
+	c.spaces[spaceApp].pending = append(c.spaces[spaceApp].pending, &StopSendingFrame{StreamID: s.ID, ErrorCode: code})
+	c.maybeCompleteStream(s)
+	c.streamCond.Broadcast()
+	c.signal()
+}
+
+// SetDeadline sets the read and write deadlines.
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for Read, including calls already
+// blocked. A zero time disables it.
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	s.readDeadline = t
+	s.conn.streamCond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write while it waits for buffer
+// space.
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	s.writeDeadline = t
+	s.conn.streamCond.Broadcast()
+	return nil
+}
+
+// String returns a string representation of the stream.
+func (s *Stream) String() string {
+	kind := "bidi"
+	if streamDirOf(s.ID) == dirUni {
+		kind = "uni"
+	}
+	return fmt.Sprintf("QUICStream{ID=%d, %s}", s.ID, kind)
+}
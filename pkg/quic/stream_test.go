@@ -0,0 +1,338 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// acceptStream accepts a stream within a test deadline.
+func acceptStream(t *testing.T, c *Connection, uni bool) *Stream {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	accept := c.AcceptStream
+	if uni {
+		accept = c.AcceptUniStream
+	}
+	s, err := accept(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	s.SetDeadline(time.Now().Add(5 * time.Second))
+	return s
+}
+
+func TestStreamEcho(t *testing.T) {
+	w := newTestWire(t)
+	client, server := handshake(t, w)
+
+	s, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	s.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	s.Close()
+
+	// The server echoes the request back on the same stream
+	peer := acceptStream(t, server, false)
+	got, err := io.ReadAll(peer)
+	if err != nil {
+		t.Fatalf("server ReadAll() error = %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("server read %q, want %q", got, "hello")
+	}
+	peer.Write(bytes.ToUpper(got))
+	peer.Close()
+
+	reply, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("client ReadAll() error = %v", err)
+	}
+	if string(reply) != "HELLO" {
+		t.Errorf("client read %q, want %q", reply, "HELLO")
+	}
+
+	// Both parts have ended, so both sides forget the stream
+	eventually(t, "stream cleanup", func() bool {
+		client.mu.RLock()
+		defer client.mu.RUnlock()
+		return len(client.streams) == 0
+	})
+	if _, err := s.Write([]byte("x")); err == nil {
+		t.Error("Write() after Close succeeded")
+	}
+}
+
+func TestUniStream(t *testing.T) {
+	w := newTestWire(t)
+	client, server := handshake(t, w)
+
+	s, err := server.OpenUniStream()
+	if err != nil {
+		t.Fatalf("OpenUniStream() error = %v", err)
+	}
+	if s.ID&0x03 != streamServerInitiated|streamUnidirectional {
+		t.Errorf("stream ID = %d, want a server-initiated unidirectional ID", s.ID)
+	}
+	s.Write([]byte("push"))
+	s.Close()
+
+	if _, err := s.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() on a send-only stream succeeded")
+	}
+
+	peer := acceptStream(t, client, true)
+	if peer.ID != s.ID {
+		t.Errorf("accepted stream %d, want %d", peer.ID, s.ID)
+	}
+	got, err := io.ReadAll(peer)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(got) != "push" {
+		t.Errorf("read %q, want %q", got, "push")
+	}
+	if _, err := peer.Write([]byte("x")); err == nil {
+		t.Error("Write() on a receive-only stream succeeded")
+	}
+}
+
+func TestStreamMultiplexing(t *testing.T) {
+	w := newTestWire(t)
+	client, server := handshake(t, w)
+
+	const streams = 8
+	payload := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("stream %d;", i)), 2000)
+	}
+
+	// All streams write at once and are interleaved on the wire
+	var wg sync.WaitGroup
+	ids := make(map[uint64]int)
+	for i := 0; i < streams; i++ {
+		s, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("OpenStream() error = %v", err)
+		}
+		ids[s.ID] = i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Write(payload(i))
+			s.Close()
+		}()
+	}
+
+	for n := 0; n < streams; n++ {
+		peer := acceptStream(t, server, false)
+		got, err := io.ReadAll(peer)
+		if err != nil {
+			t.Fatalf("ReadAll(stream %d) error = %v", peer.ID, err)
+		}
+		if !bytes.Equal(got, payload(ids[peer.ID])) {
+			t.Errorf("stream %d data corrupted", peer.ID)
+		}
+	}
+	wg.Wait()
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	w := newTestWire(t)
+	params := DefaultTransportParameters()
+	params.InitialMaxData = 4000
+	params.InitialMaxStreamDataBidiRemote = 1000
+	client, server := handshakeWithParams(t, w, params, nil)
+
+	s, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 5000)
+	go func() {
+		s.Write(data)
+		s.Close()
+	}()
+
+	// Nothing beyond the stream window is sent until the server reads
+	peer := acceptStream(t, server, false)
+	eventually(t, "window filled", func() bool {
+		client.mu.RLock()
+		defer client.mu.RUnlock()
+		return s.offset == 1000
+	})
+	time.Sleep(20 * time.Millisecond)
+	server.mu.RLock()
+	highest := peer.recvHighest
+	server.mu.RUnlock()
+	if highest != 1000 {
+		t.Errorf("received %d bytes before reading, want the 1000 byte window", highest)
+	}
+
+	// Reading opens both the stream and connection windows
+	got, err := io.ReadAll(peer)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("flow controlled data corrupted")
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	w := newTestWire(t)
+	client, server := handshake(t, w)
+
+	// CancelWrite resets the stream; the peer's reads fail with the code
+	s, _ := client.OpenStream()
+	s.Write([]byte("partial"))
+	peer := acceptStream(t, server, false)
+	s.CancelWrite(7)
+
+	_, err := io.ReadAll(peer)
+	var se *StreamError
+	if !errors.As(err, &se) || se.Code != 7 || !se.Remote || se.StreamID != s.ID {
+		t.Errorf("Read() after reset error = %v, want remote stream error 7", err)
+	}
+	if _, err := s.Write([]byte("x")); !errors.As(err, &se) || se.Remote {
+		t.Errorf("Write() after CancelWrite error = %v, want local stream error", err)
+	}
+
+	// CancelRead sends STOP_SENDING; the peer's writes fail with the code
+	s2, _ := client.OpenStream()
+	s2.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	s2.Write([]byte("first"))
+	peer2 := acceptStream(t, server, false)
+	peer2.CancelRead(9)
+
+	eventually(t, "STOP_SENDING", func() bool {
+		_, err := s2.Write([]byte("more"))
+		return errors.As(err, &se) && se.Code == 9 && se.Remote
+	})
+	if _, err := peer2.Read(make([]byte, 1)); !errors.As(err, &se) || se.Remote {
+		t.Errorf("Read() after CancelRead error = %v, want local stream error", err)
+	}
+}
+
+func TestStreamLimit(t *testing.T) {
+	w := newTestWire(t)
+	params := DefaultTransportParameters()
+	params.InitialMaxStreamsBidi = 1
+	client, server := handshakeWithParams(t, w, params, nil)
+
+	s, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	if _, err := client.OpenStream(); !errors.Is(err, ErrStreamLimit) {
+		t.Errorf("OpenStream() past the limit error = %v, want ErrStreamLimit", err)
+	}
+
+	// Finishing the stream lets the server allow another with MAX_STREAMS
+	s.Write([]byte("done"))
+	s.Close()
+	peer := acceptStream(t, server, false)
+	io.ReadAll(peer)
+	peer.Close()
+
+	eventually(t, "MAX_STREAMS", func() bool {
+		_, err := client.OpenStream()
+		return err == nil
+	})
+}
+
+func TestStreamDeadline(t *testing.T) {
+	w := newTestWire(t)
+	client, _ := handshake(t, w)
+
+	s, _ := client.OpenStream()
+	s.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := s.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() error = %v, want deadline exceeded", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.AcceptStream(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AcceptStream() error = %v, want context deadline", err)
+	}
+
+	// Closing the connection unblocks readers
+	s.SetReadDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	client.Close(0, "")
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrConnectionClosed) {
+			t.Errorf("Read() after Close error = %v, want ErrConnectionClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read() not unblocked by Close")
+	}
+}
+
+func TestStreamFinalSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		frames  []*StreamFrame
+		wantErr bool
+	}{
+		{"in order", []*StreamFrame{{Offset: 0, Data: []byte("ab")}, {Offset: 2, Data: []byte("c"), Fin: true}}, false},
+		{"duplicate fin", []*StreamFrame{{Offset: 0, Data: []byte("abc"), Fin: true}, {Offset: 0, Data: []byte("abc"), Fin: true}}, false},
+		{"data past final size", []*StreamFrame{{Offset: 0, Data: []byte("ab"), Fin: true}, {Offset: 2, Data: []byte("c")}}, true},
+		{"final size changed", []*StreamFrame{{Offset: 0, Data: []byte("ab"), Fin: true}, {Offset: 0, Data: []byte("a"), Fin: true}}, true},
+		{"final size below data", []*StreamFrame{{Offset: 0, Data: []byte("abc")}, {Offset: 0, Data: []byte("a"), Fin: true}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Stream{}
+			var err error
+			for _, f := range tt.frames {
+				end := f.Offset + uint64(len(f.Data))
+				if err = s.checkFinalSize(end, f.Fin); err != nil {
+					break
+				}
+				s.recvHighest = max(s.recvHighest, end)
+			}
+			var te *TransportError
+			if tt.wantErr != (err != nil) || err != nil && (!errors.As(err, &te) || te.Code != ErrCodeFinalSize) {
+				t.Errorf("checkFinalSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamReassembly(t *testing.T) {
+	s := &Stream{recvPending: make(map[uint64][]byte)}
+
+	// Out-of-order, overlapping and duplicate segments; at offset 4 the
+	// shorter duplicate must not replace the longer one
+	s.receive(4, []byte("efgh"))
+	s.receive(4, []byte("ef"))
+	s.receive(2, []byte("cdef"))
+	if len(s.recvBuf) != 0 {
+		t.Errorf("delivered %q before offset 0 arrived", s.recvBuf)
+	}
+	s.receive(0, []byte("abc"))
+	s.receive(1, []byte("b"))
+	if string(s.recvBuf) != "abcdefgh" || s.recvOffset != 8 {
+		t.Errorf("recvBuf = %q at %d, want %q", s.recvBuf, s.recvOffset, "abcdefgh")
+	}
+}
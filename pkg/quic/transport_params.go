@@ -0,0 +1,223 @@
+package quic
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// Transport parameter IDs (RFC 9000 Section 18.2).
+const (
+	paramOriginalDestConnID      = 0x00
+	paramMaxIdleTimeout          = 0x01
+	paramStatelessResetToken     = 0x02
+	paramMaxUDPPayloadSize       = 0x03
+	paramInitialMaxData          = 0x04
+	paramInitialMaxStreamDataBL  = 0x05
+	paramInitialMaxStreamDataBR  = 0x06
+	paramInitialMaxStreamDataUni = 0x07
+	paramInitialMaxStreamsBidi   = 0x08
+	paramInitialMaxStreamsUni    = 0x09
+	paramAckDelayExponent        = 0x0a
+	paramMaxAckDelay             = 0x0b
+	paramActiveConnIDLimit       = 0x0e
+	paramInitialSourceConnID     = 0x0f
+	paramRetrySourceConnID       = 0x10
+)
+
+// Default transport parameter values used when a parameter is absent.
+const (
+	DefaultAckDelayExponent  = 3
+	DefaultMaxAckDelay       = 25 * time.Millisecond
+	DefaultActiveConnIDLimit = 2
+	DefaultMaxUDPPayloadSize = 65527
+)
+
+// TransportParameters are exchanged in the TLS handshake to configure the
+// connection (RFC 9000 Section 18).
+type TransportParameters struct {
+	OriginalDestConnID  []byte // Server only
+	InitialSourceConnID []byte
+	RetrySourceConnID   []byte // Server only, after Retry
+
+	MaxIdleTimeout      time.Duration
+	StatelessResetToken []byte // Server only
+	MaxUDPPayloadSize   uint64
+
+	InitialMaxData                 uint64
+	InitialMaxStreamDataBidiLocal  uint64
+	InitialMaxStreamDataBidiRemote uint64
+	InitialMaxStreamDataUni        uint64
+	InitialMaxStreamsBidi          uint64
+	InitialMaxStreamsUni           uint64
+
+	AckDelayExponent  uint64
+	MaxAckDelay       time.Duration
+	ActiveConnIDLimit uint64
+
+	// hasOriginalDestConnID and hasInitialSourceConnID record presence,
+	// since an empty connection ID is valid
+	hasOriginalDestConnID  bool
+	hasInitialSourceConnID bool
+}
+
+// DefaultTransportParameters returns the parameters this stack advertises.
+func DefaultTransportParameters() *TransportParameters {
+	return &TransportParameters{
+		MaxIdleTimeout:                 30 * time.Second,
+		MaxUDPPayloadSize:              1452,
+		InitialMaxData:                 10 * 1024 * 1024,
+		InitialMaxStreamDataBidiLocal:  1024 * 1024,
+		InitialMaxStreamDataBidiRemote: 1024 * 1024,
+		InitialMaxStreamDataUni:        1024 * 1024,
+		InitialMaxStreamsBidi:          100,
+		InitialMaxStreamsUni:           100,
+		AckDelayExponent:               DefaultAckDelayExponent,
+		MaxAckDelay:                    DefaultMaxAckDelay,
+		ActiveConnIDLimit:              4,
+	}
+}
+
+// Marshal encodes the parameters. Connection ID parameters are written
+// when set; the server's original_destination_connection_id is always
+// written when OriginalDestConnID is non-nil.
+func (p *TransportParameters) Marshal() []byte {
+	var b []byte
+	appendBytes := func(id uint64, v []byte) {
+		b = appendVarintLen(b, id, VarintLen(id))
+		b = appendVarintLen(b, uint64(len(v)), VarintLen(uint64(len(v))))
+		b = append(b, v...)
+	}
+	appendInt := func(id, v uint64) {
+		var enc []byte
+		enc, _ = AppendVarint(enc, v)
+		appendBytes(id, enc)
+	}
+
+	if p.OriginalDestConnID != nil {
+		appendBytes(paramOriginalDestConnID, p.OriginalDestConnID)
+	}
+	if p.MaxIdleTimeout > 0 {
+		appendInt(paramMaxIdleTimeout, uint64(p.MaxIdleTimeout/time.Millisecond))
+	}
+	if len(p.StatelessResetToken) == 16 {
+		appendBytes(paramStatelessResetToken, p.StatelessResetToken)
+	}
+	if p.MaxUDPPayloadSize > 0 {
+		appendInt(paramMaxUDPPayloadSize, p.MaxUDPPayloadSize)
+	}
+	appendInt(paramInitialMaxData, p.InitialMaxData)
+	appendInt(paramInitialMaxStreamDataBL, p.InitialMaxStreamDataBidiLocal)
+	appendInt(paramInitialMaxStreamDataBR, p.InitialMaxStreamDataBidiRemote)
+	appendInt(paramInitialMaxStreamDataUni, p.InitialMaxStreamDataUni)
+	appendInt(paramInitialMaxStreamsBidi, p.InitialMaxStreamsBidi)
+	appendInt(paramInitialMaxStreamsUni, p.InitialMaxStreamsUni)
+	if p.AckDelayExponent != DefaultAckDelayExponent {
+		appendInt(paramAckDelayExponent, p.AckDelayExponent)
+	}
+	if p.MaxAckDelay != DefaultMaxAckDelay && p.MaxAckDelay > 0 {
+		appendInt(paramMaxAckDelay, uint64(p.MaxAckDelay/time.Millisecond))
+	}
+	if p.ActiveConnIDLimit > DefaultActiveConnIDLimit {
+		appendInt(paramActiveConnIDLimit, p.ActiveConnIDLimit)
+	}
+	if p.InitialSourceConnID != nil {
+		appendBytes(paramInitialSourceConnID, p.InitialSourceConnID)
+	}
+	if p.RetrySourceConnID != nil {
+		appendBytes(paramRetrySourceConnID, p.RetrySourceConnID)
+	}
+	return b
+}
+
+// ParseTransportParameters decodes parameters received from the peer.
+// Unknown parameters are ignored, as RFC 9000 requires.
+func ParseTransportParameters(data []byte) (*TransportParameters, error) {
+	p := &TransportParameters{
+		MaxUDPPayloadSize: DefaultMaxUDPPayloadSize,
+		AckDelayExponent:  DefaultAckDelayExponent,
+		MaxAckDelay:       DefaultMaxAckDelay,
+		ActiveConnIDLimit: DefaultActiveConnIDLimit,
+	}
+	seen := make(map[uint64]bool)
+
+	r := &frameReader{data: data}
+	for r.offset < len(data) && r.err == nil {
+		id := r.varint()
+		value := r.bytes(r.varint())
+		if r.err != nil {
+			break
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate transport parameter 0x%x", id)
+		}
+		seen[id] = true
+
+		var v uint64
+		switch id {
+		case paramMaxIdleTimeout, paramMaxUDPPayloadSize, paramInitialMaxData,
+			paramInitialMaxStreamDataBL, paramInitialMaxStreamDataBR, paramInitialMaxStreamDataUni,
+			paramInitialMaxStreamsBidi, paramInitialMaxStreamsUni, paramAckDelayExponent,
+			paramMaxAckDelay, paramActiveConnIDLimit:
+			var n int
+			var err error
+			if v, n, err = ReadVarint(value); err != nil || n != len(value) {
+				return nil, fmt.Errorf("malformed transport parameter 0x%x", id)
+			}
+		}
+
+		switch id {
+		case paramOriginalDestConnID:
+			p.OriginalDestConnID = bytes.Clone(value)
+			p.hasOriginalDestConnID = true
+		case paramInitialSourceConnID:
+			p.InitialSourceConnID = bytes.Clone(value)
+			p.hasInitialSourceConnID = true
+		case paramRetrySourceConnID:
+			p.RetrySourceConnID = bytes.Clone(value)
+		case paramStatelessResetToken:
+			if len(value) != 16 {
+				return nil, fmt.Errorf("invalid stateless reset token length %d", len(value))
+			}
+			p.StatelessResetToken = bytes.Clone(value)
+		case paramMaxIdleTimeout:
+			p.MaxIdleTimeout = time.Duration(v) * time.Millisecond
+		case paramMaxUDPPayloadSize:
+			if v < 1200 {
+				return nil, fmt.Errorf("max_udp_payload_size %d below 1200", v)
+			}
+			p.MaxUDPPayloadSize = v
+		case paramInitialMaxData:
+			p.InitialMaxData = v
+		case paramInitialMaxStreamDataBL:
+			p.InitialMaxStreamDataBidiLocal = v
+		case paramInitialMaxStreamDataBR:
+			p.InitialMaxStreamDataBidiRemote = v
+		case paramInitialMaxStreamDataUni:
+			p.InitialMaxStreamDataUni = v
+		case paramInitialMaxStreamsBidi:
+			p.InitialMaxStreamsBidi = v
+		case paramInitialMaxStreamsUni:
+			p.InitialMaxStreamsUni = v
+		case paramAckDelayExponent:
+			if v > 20 {
+				return nil, fmt.Errorf("ack_delay_exponent %d above 20", v)
+			}
+			p.AckDelayExponent = v
+		case paramMaxAckDelay:
+			if v >= 1<<14 {
+				return nil, fmt.Errorf("max_ack_delay %d too large", v)
+			}
+			p.MaxAckDelay = time.Duration(v) * time.Millisecond
+		case paramActiveConnIDLimit:
+			if v < 2 {
+				return nil, fmt.Errorf("active_connection_id_limit %d below 2", v)
+			}
+			p.ActiveConnIDLimit = v
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("malformed transport parameters: %w", r.err)
+	}
+	return p, nil
+}
@@ -0,0 +1,90 @@
+package quic
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTransportParametersRoundTrip(t *testing.T) {
+	p := DefaultTransportParameters()
+	p.OriginalDestConnID = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	p.InitialSourceConnID = []byte{}
+	p.StatelessResetToken = bytes.Repeat([]byte{0xab}, 16)
+	p.MaxAckDelay = 40 * time.Millisecond
+	p.AckDelayExponent = 5
+
+	got, err := ParseTransportParameters(p.Marshal())
+	if err != nil {
+		t.Fatalf("ParseTransportParameters() error = %v", err)
+	}
+
+	if !got.hasOriginalDestConnID || !bytes.Equal(got.OriginalDestConnID, p.OriginalDestConnID) {
+		t.Errorf("OriginalDestConnID = %x, want %x", got.OriginalDestConnID, p.OriginalDestConnID)
+	}
+	// An empty connection ID is still present
+	if !got.hasInitialSourceConnID || len(got.InitialSourceConnID) != 0 {
+		t.Errorf("InitialSourceConnID = %x (present %v), want empty", got.InitialSourceConnID, got.hasInitialSourceConnID)
+	}
+	if !bytes.Equal(got.StatelessResetToken, p.StatelessResetToken) {
+		t.Errorf("StatelessResetToken = %x, want %x", got.StatelessResetToken, p.StatelessResetToken)
+	}
+	if got.MaxIdleTimeout != p.MaxIdleTimeout {
+		t.Errorf("MaxIdleTimeout = %v, want %v", got.MaxIdleTimeout, p.MaxIdleTimeout)
+	}
+	if got.InitialMaxData != p.InitialMaxData || got.InitialMaxStreamsBidi != p.InitialMaxStreamsBidi {
+		t.Errorf("limits = %d/%d, want %d/%d", got.InitialMaxData, got.InitialMaxStreamsBidi, p.InitialMaxData, p.InitialMaxStreamsBidi)
+	}
+	if got.MaxAckDelay != p.MaxAckDelay || got.AckDelayExponent != p.AckDelayExponent {
+		t.Errorf("ack delay = %v/%d, want %v/%d", got.MaxAckDelay, got.AckDelayExponent, p.MaxAckDelay, p.AckDelayExponent)
+	}
+	if got.ActiveConnIDLimit != p.ActiveConnIDLimit {
+		t.Errorf("ActiveConnIDLimit = %d, want %d", got.ActiveConnIDLimit, p.ActiveConnIDLimit)
+	}
+}
+
+func TestTransportParametersDefaults(t *testing.T) {
+	got, err := ParseTransportParameters(nil)
+	if err != nil {
+		t.Fatalf("ParseTransportParameters() error = %v", err)
+	}
+	if got.MaxUDPPayloadSize != DefaultMaxUDPPayloadSize || got.AckDelayExponent != DefaultAckDelayExponent ||
+		got.MaxAckDelay != DefaultMaxAckDelay || got.ActiveConnIDLimit != DefaultActiveConnIDLimit {
+		t.Errorf("defaults = %+v", got)
+	}
+}
+
+func TestParseTransportParametersErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated value", []byte{0x04, 0x04, 0x80}},
+		{"duplicate", []byte{0x04, 0x01, 0x01, 0x04, 0x01, 0x02}},
+		{"bad varint length", []byte{0x04, 0x02, 0x01, 0x00}},
+		{"short reset token", []byte{0x02, 0x02, 0x00, 0x00}},
+		{"max_udp_payload_size below 1200", []byte{0x03, 0x02, 0x44, 0xaf}},
+		{"ack_delay_exponent above 20", []byte{0x0a, 0x01, 21}},
+		{"active_connection_id_limit below 2", []byte{0x0e, 0x01, 0x01}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTransportParameters(tt.data); err == nil {
+				t.Error("ParseTransportParameters() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestParseTransportParametersIgnoresUnknown(t *testing.T) {
+	// A reserved parameter (31*N+27) followed by initial_max_data
+	data := []byte{0x40, 0x1b, 0x02, 0xff, 0xff, 0x04, 0x01, 0x07}
+	got, err := ParseTransportParameters(data)
+	if err != nil {
+		t.Fatalf("ParseTransportParameters() error = %v", err)
+	}
+	if got.InitialMaxData != 7 {
+		t.Errorf("InitialMaxData = %d, want 7", got.InitialMaxData)
+	}
+}
@@ -0,0 +1,79 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxVarint is the largest value a variable-length integer can hold (2^62-1).
+const MaxVarint = 1<<62 - 1
+
+var (
+	// ErrVarintTruncated is returned when a variable-length integer extends
+	// past the end of the data.
+	ErrVarintTruncated = errors.New("quic: truncated variable-length integer")
+
+	// ErrVarintRange is returned when encoding a value above MaxVarint.
+	ErrVarintRange = errors.New("quic: value exceeds variable-length integer range")
+)
+
+// VarintLen returns the number of bytes needed to encode v (RFC 9000
+// Section 16): 1, 2, 4 or 8.
+func VarintLen(v uint64) int {
+	switch {
+	case v <= 63:
+		return 1
+	case v <= 16383:
+		return 2
+	case v <= 1073741823:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// AppendVarint appends the shortest encoding of v to b. Values above
+// MaxVarint are an error.
+func AppendVarint(b []byte, v uint64) ([]byte, error) {
+	if v > MaxVarint {
+		return b, fmt.Errorf("%w: %d", ErrVarintRange, v)
+	}
+	return appendVarintLen(b, v, VarintLen(v)), nil
+}
+
+// appendVarintLen appends v encoded in exactly n bytes, which must be at
+// least VarintLen(v). Fixed widths let a length field be written before the
+// value it covers is known.
+func appendVarintLen(b []byte, v uint64, n int) []byte {
+	switch n {
+	case 1:
+		return append(b, byte(v))
+	case 2:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case 4:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// ReadVarint decodes a variable-length integer from the start of data and
+// returns it with the number of bytes consumed.
+func ReadVarint(data []byte) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, ErrVarintTruncated
+	}
+
+	// The two most significant bits give the length
+	n := 1 << (data[0] >> 6)
+	if len(data) < n {
+		return 0, 0, ErrVarintTruncated
+	}
+
+	v := uint64(data[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(data[i])
+	}
+	return v, n, nil
+}
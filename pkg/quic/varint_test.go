@@ -0,0 +1,100 @@
+package quic
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVarint(t *testing.T) {
+	// Examples from RFC 9000 Appendix A.1
+	tests := []struct {
+		encoded  []byte
+		value    uint64
+		shortest bool
+	}{
+		{[]byte{0xc2, 0x19, 0x7c, 0x5e, 0xff, 0x14, 0xe8, 0x8c}, 151288809941952652, true},
+		{[]byte{0x9d, 0x7f, 0x3e, 0x7d}, 494878333, true},
+		{[]byte{0x7b, 0xbd}, 15293, true},
+		{[]byte{0x25}, 37, true},
+		{[]byte{0x40, 0x25}, 37, false},
+	}
+
+	for _, tt := range tests {
+		v, n, err := ReadVarint(tt.encoded)
+		if err != nil {
+			t.Fatalf("ReadVarint(%x) error = %v", tt.encoded, err)
+		}
+		if v != tt.value || n != len(tt.encoded) {
+			t.Errorf("ReadVarint(%x) = %d, %d, want %d, %d", tt.encoded, v, n, tt.value, len(tt.encoded))
+		}
+
+		if !tt.shortest {
+			continue
+		}
+		encoded, err := AppendVarint(nil, tt.value)
+		if err != nil {
+			t.Fatalf("AppendVarint(%d) error = %v", tt.value, err)
+		}
+		if !bytes.Equal(encoded, tt.encoded) {
+			t.Errorf("AppendVarint(%d) = %x, want %x", tt.value, encoded, tt.encoded)
+		}
+	}
+}
+
+func TestVarintBoundaries(t *testing.T) {
+	for _, v := range []uint64{0, 63, 64, 16383, 16384, 1073741823, 1073741824, MaxVarint} {
+		encoded, err := AppendVarint(nil, v)
+		if err != nil {
+			t.Fatalf("AppendVarint(%d) error = %v", v, err)
+		}
+		if len(encoded) != VarintLen(v) {
+			t.Errorf("AppendVarint(%d) length = %d, want %d", v, len(encoded), VarintLen(v))
+		}
+		got, _, err := ReadVarint(encoded)
+		if err != nil || got != v {
+			t.Errorf("ReadVarint(AppendVarint(%d)) = %d, %v", v, got, err)
+		}
+	}
+
+	if _, err := AppendVarint(nil, MaxVarint+1); !errors.Is(err, ErrVarintRange) {
+		t.Errorf("AppendVarint(2^62) error = %v, want ErrVarintRange", err)
+	}
+	if _, _, err := ReadVarint([]byte{0x9d, 0x7f}); !errors.Is(err, ErrVarintTruncated) {
+		t.Errorf("ReadVarint(truncated) error = %v, want ErrVarintTruncated", err)
+	}
+}
+
+func TestPacketNumber(t *testing.T) {
+	// Examples from RFC 9000 Appendix A.2 and A.3
+	if n := PacketNumberLength(0xac5c02, 0xabe8b3); n != 2 {
+		t.Errorf("PacketNumberLength(0xac5c02, 0xabe8b3) = %d, want 2", n)
+	}
+	if n := PacketNumberLength(0xace8fe, 0xabe8b3); n != 3 {
+		t.Errorf("PacketNumberLength(0xace8fe, 0xabe8b3) = %d, want 3", n)
+	}
+	if got := DecodePacketNumber(0xa82f30ea, 0x9b32, 2); got != 0xa82f9b32 {
+		t.Errorf("DecodePacketNumber() = %#x, want 0xa82f9b32", got)
+	}
+
+	tests := []struct {
+		name    string
+		largest int64
+		fullPN  uint64
+	}{
+		{"first packet", -1, 0},
+		{"small gap", 10, 11},
+		{"wrap of one byte", 250, 260},
+		{"large gap", 1000, 70000},
+		{"far ahead", 0x12345678, 0x12345678 + 1<<20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			truncated, n := EncodePacketNumber(tt.fullPN, tt.largest)
+			if got := DecodePacketNumber(tt.largest, uint64(truncated), n); got != tt.fullPN {
+				t.Errorf("round trip = %d, want %d (encoded %#x in %d bytes)", got, tt.fullPN, truncated, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,578 @@
+package rip
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+const (
+	// DefaultUpdateInterval is how often the whole table is advertised.
+	DefaultUpdateInterval = 30 * time.Second
+
+	// DefaultTimeout is how long a learned route lives without being
+	// refreshed before it is withdrawn.
+	DefaultTimeout = 180 * time.Second
+
+	// DefaultGarbageCollection is how long a withdrawn route is advertised
+	// as unreachable before it is forgotten.
+	DefaultGarbageCollection = 120 * time.Second
+
+	// tickInterval is how often timers are checked and triggered updates
+	// sent, which rate-limits triggered updates.
+	tickInterval = time.Second
+)
+
+// Interface is a network the daemon runs RIP on.
+type Interface struct {
+	Name    string             // Interface name, as routes in the table use it
+	Address common.IPv4Address // The interface's address
+	Netmask common.IPv4Address // The interface's network mask
+
+	// Conn is a socket bound to Port on the interface, which has joined
+	// MulticastGroup. The daemon closes it when it is closed.
+	Conn net.PacketConn
+
+	// Metric is the cost added to routes learned on the interface. 0
+	// counts as 1.
+	Metric int
+}
+
+// Config configures a Daemon.
+type Config struct {
+	Table             *ip.RoutingTable // Table learned routes are installed in
+	Interfaces        []Interface
+	UpdateInterval    time.Duration // 0 means DefaultUpdateInterval
+	Timeout           time.Duration // 0 means DefaultTimeout
+	GarbageCollection time.Duration // 0 means DefaultGarbageCollection
+}
+
+// Route is an entry in the daemon's RIP table.
+type Route struct {
+	Destination common.IPv4Address
+	Netmask     common.IPv4Address
+	NextHop     common.IPv4Address // 0.0.0.0 for connected networks
+	Interface   string
+	Metric      int    // Infinity while the route is being garbage collected
+	RouteTag    uint16 // Tag the route was advertised with
+	Connected   bool   // Whether the network is one of the daemon's interfaces'
+	Updated     time.Time
+
+	from    common.IPv4Address // Router the route was learned from
+	changed bool               // Whether the next triggered update carries it
+	gcAt    time.Time          // When a withdrawn route is forgotten
+}
+
+// String returns a human-readable representation of the route.
+func (r *Route) String() string {
+	if r.Connected {
+		return fmt.Sprintf("%s/%d dev %s metric %d (connected)",
+			r.Destination, prefixLength(r.Netmask), r.Interface, r.Metric)
+	}
+	return fmt.Sprintf("%s/%d via %s dev %s metric %d",
+		r.Destination, prefixLength(r.Netmask), r.NextHop, r.Interface, r.Metric)
+}
+
+// prefix identifies a network in the RIP table.
+type prefix struct {
+	destination common.IPv4Address
+	netmask     common.IPv4Address
+}
+
+// Daemon is a RIPv2 router (RFC 2453). It advertises its connected networks
+// and the routes it learns to its neighbors every UpdateInterval, and sends
+// triggered updates when routes change. Routes are advertised back on the
+// interface they were learned on as unreachable (split horizon with
+// poisoned reverse).
+//
+// The best route to each network is installed in the routing table, and
+// withdrawn when it times out.
+type Daemon struct {
+	config     Config
+	interfaces []Interface
+
+	mu         sync.Mutex
+	routes     map[prefix]*Route
+	nextUpdate time.Time
+	triggered  bool
+	closed     bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	now func() time.Time // Clock, replaced in tests
+}
+
+// New creates a RIP daemon. Its interfaces' networks are added to its
+// table as connected routes; they are expected to be in the routing table
+// already.
+func New(config Config) (*Daemon, error) {
+	if config.Table == nil {
+		return nil, fmt.Errorf("routing table is nil")
+	}
+	if len(config.Interfaces) == 0 {
+		return nil, fmt.Errorf("no interfaces")
+	}
+	if config.UpdateInterval == 0 {
+		config.UpdateInterval = DefaultUpdateInterval
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.GarbageCollection == 0 {
+		config.GarbageCollection = DefaultGarbageCollection
+	}
+
+	d := &Daemon{
+		config:     config,
+		interfaces: make([]Interface, len(config.Interfaces)),
+		routes:     make(map[prefix]*Route),
+		done:       make(chan struct{}),
+		now:        time.Now,
+	}
+	for i, iface := range config.Interfaces {
+		if iface.Conn == nil {
+			return nil, fmt.Errorf("interface %s has no connection", iface.Name)
+		}
+		if iface.Metric < 0 || iface.Metric >= Infinity {
+			return nil, fmt.Errorf("invalid metric for interface %s: %d", iface.Name, iface.Metric)
+		}
+		if iface.Metric == 0 {
+			iface.Metric = 1
+		}
+		d.interfaces[i] = iface
+
+		key := prefix{mask(iface.Address, iface.Netmask), iface.Netmask}
+		d.routes[key] = &Route{
+			Destination: key.destination,
+			Netmask:     key.netmask,
+			Interface:   iface.Name,
+			Metric:      iface.Metric,
+			Connected:   true,
+		}
+	}
+	return d, nil
+}
+
+// Start asks the neighbors for their tables and starts advertising routes
+// and processing received messages.
+func (d *Daemon) Start() {
+	d.mu.Lock()
+	d.nextUpdate = d.now()
+	d.mu.Unlock()
+
+	request := &Packet{
+		Command: CommandRequest,
+		Version: Version,
+		Entries: []Entry{{Metric: Infinity}},
+	}
+	for i := range d.interfaces {
+		d.send(&d.interfaces[i], request, multicastAddr())
+
+		d.wg.Add(1)
+		go d.receive(&d.interfaces[i])
+	}
+
+	d.wg.Add(1)
+	go d.run()
+}
+
+// Close stops the daemon, closes its interfaces' connections and withdraws
+// the routes it installed from the routing table.
+func (d *Daemon) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	close(d.done)
+	var firstErr error
+	for _, iface := range d.interfaces {
+		if err := iface.Conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	d.wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, route := range d.routes {
+		if !route.Connected && route.Metric < Infinity {
+			d.config.Table.RemoveRoute(key.destination, key.netmask)
+		}
+	}
+	return firstErr
+}
+
+// Routes returns the daemon's RIP table, ordered by destination.
+func (d *Daemon) Routes() []Route {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	routes := make([]Route, 0, len(d.routes))
+	for _, route := range d.routes {
+		routes = append(routes, *route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		a, b := routes[i], routes[j]
+		if a.Destination != b.Destination {
+			return a.Destination.ToUint32() < b.Destination.ToUint32()
+		}
+		return a.Netmask.ToUint32() < b.Netmask.ToUint32()
+	})
+	return routes
+}
+
+// run checks timers and sends updates until the daemon is closed.
+func (d *Daemon) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.tick()
+		}
+	}
+}
+
+// receive handles the messages received on an interface until its
+// connection is closed.
+func (d *Daemon) receive(iface *Interface) {
+	defer d.wg.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := iface.Conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-d.done:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		source, port, ok := sourceAddress(addr)
+		if !ok {
+			continue
+		}
+		d.handle(iface, buf[:n], source, port)
+	}
+}
+
+// handle processes a message received on an interface. Malformed messages
+// and messages from outside the interface's network are ignored.
+func (d *Daemon) handle(iface *Interface, data []byte, source common.IPv4Address, port uint16) {
+	pkt, err := Parse(data)
+	if err != nil || pkt.Version < Version {
+		return
+	}
+	if source == iface.Address {
+		return // Our own multicast looped back
+	}
+
+	switch pkt.Command {
+	case CommandRequest:
+		d.handleRequest(iface, pkt, source, port)
+	case CommandResponse:
+		// Responses must come from a RIP process on a neighbor
+		if port != Port || mask(source, iface.Netmask) != mask(iface.Address, iface.Netmask) {
+			return
+		}
+		d.handleResponse(iface, pkt, source)
+	}
+}
+
+// handleRequest answers a request (RFC 2453 Section 3.9.1). A request for
+// the whole table is answered as an update would be; a request for
+// specific networks, usually from a diagnostic tool, is answered with the
+// routes as they are.
+func (d *Daemon) handleRequest(iface *Interface, pkt *Packet, source common.IPv4Address, port uint16) {
+	to := &net.UDPAddr{IP: net.IP(source[:]), Port: int(port)}
+
+	if pkt.IsTableRequest() {
+		d.mu.Lock()
+		entries := d.entries(iface, false)
+		d.mu.Unlock()
+		d.sendEntries(iface, entries, to)
+		return
+	}
+
+	response := &Packet{Command: CommandResponse, Version: Version, Entries: pkt.Entries}
+	d.mu.Lock()
+	for i := range response.Entries {
+		e := &response.Entries[i]
+		e.Metric = Infinity
+		if route, ok := d.routes[prefix{e.Address, e.Netmask}]; ok {
+			e.Metric = uint32(route.Metric)
+			e.RouteTag = route.RouteTag
+		}
+	}
+	d.mu.Unlock()
+	d.send(iface, response, to)
+}
+
+// handleResponse processes the routes in a response (RFC 2453 Section
+// 3.9.2).
+func (d *Daemon) handleResponse(iface *Interface, pkt *Packet, source common.IPv4Address) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return
+	}
+	now := d.now()
+	for _, e := range pkt.Entries {
+		if !validEntry(e) {
+			continue
+		}
+		metric := min(int(e.Metric)+iface.Metric, Infinity)
+
+		// A next hop on the interface's network is used as advertised;
+		// otherwise packets go to the sender
+		nextHop := source
+		if e.NextHop != (common.IPv4Address{}) && e.NextHop != iface.Address &&
+			mask(e.NextHop, iface.Netmask) == mask(iface.Address, iface.Netmask) {
+			nextHop = e.NextHop
+		}
+
+		key := prefix{e.Address, e.Netmask}
+		route, exists := d.routes[key]
+		switch {
+		case !exists:
+			if metric == Infinity {
+				continue
+			}
+			route = &Route{Destination: e.Address, Netmask: e.Netmask}
+			d.routes[key] = route
+			d.update(route, iface, source, nextHop, metric, e.RouteTag, now)
+
+		case route.Connected:
+			// Our own networks are not learned
+
+		case route.from == source && route.Metric < Infinity:
+			if metric == Infinity {
+				d.withdraw(key, route, now)
+				continue
+			}
+			if metric != route.Metric || nextHop != route.NextHop || e.RouteTag != route.RouteTag {
+				d.update(route, iface, source, nextHop, metric, e.RouteTag, now)
+				continue
+			}
+			route.Updated = now
+
+		case metric < route.Metric:
+			d.update(route, iface, source, nextHop, metric, e.RouteTag, now)
+		}
+	}
+}
+
+// update points a route at a new next hop or metric, installs it and
+// schedules a triggered update.
+func (d *Daemon) update(route *Route, iface *Interface, source, nextHop common.IPv4Address, metric int, tag uint16, now time.Time) {
+	route.NextHop = nextHop
+	route.Interface = iface.Name
+	route.Metric = metric
+	route.RouteTag = tag
+	route.Updated = now
+	route.from = source
+	route.changed = true
+	route.gcAt = time.Time{}
+	d.triggered = true
+
+	d.config.Table.ReplaceRoute(&ip.Route{
+		Destination: route.Destination,
+		Netmask:     route.Netmask,
+		Gateway:     nextHop,
+		Interface:   iface.Name,
+		Metric:      metric,
+	})
+}
+
+// withdraw removes a route from the routing table and starts garbage
+// collecting it: it is advertised as unreachable until it is forgotten.
+func (d *Daemon) withdraw(key prefix, route *Route, now time.Time) {
+	route.Metric = Infinity
+	route.changed = true
+	route.gcAt = now.Add(d.config.GarbageCollection)
+	d.triggered = true
+
+	d.config.Table.RemoveRoute(key.destination, key.netmask)
+}
+
+// tick times out and garbage collects routes, and sends the periodic
+// update if it is due or a triggered update if routes have changed.
+func (d *Daemon) tick() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+
+	now := d.now()
+	for key, route := range d.routes {
+		if route.Connected {
+			continue
+		}
+		if route.Metric < Infinity && now.Sub(route.Updated) >= d.config.Timeout {
+			d.withdraw(key, route, now)
+		} else if route.Metric == Infinity && !now.Before(route.gcAt) {
+			delete(d.routes, key)
+		}
+	}
+
+	periodic := !now.Before(d.nextUpdate)
+	triggered := d.triggered
+	if periodic {
+		d.nextUpdate = now.Add(d.config.UpdateInterval)
+	}
+	if !periodic && !triggered {
+		d.mu.Unlock()
+		return
+	}
+
+	// A periodic update carries every route, so it stands in for a
+	// triggered one
+	updates := make([][]Entry, len(d.interfaces))
+	for i := range d.interfaces {
+		updates[i] = d.entries(&d.interfaces[i], !periodic)
+	}
+	for _, route := range d.routes {
+		route.changed = false
+	}
+	d.triggered = false
+	d.mu.Unlock()
+
+	for i := range d.interfaces {
+		d.sendEntries(&d.interfaces[i], updates[i], multicastAddr())
+	}
+}
+
+// entries returns the route entries to advertise on an interface: every
+// route, or only the changed ones for a triggered update. Routes learned
+// on the interface are advertised back as unreachable. d.mu must be held.
+func (d *Daemon) entries(iface *Interface, changedOnly bool) []Entry {
+	keys := make([]prefix, 0, len(d.routes))
+	for key, route := range d.routes {
+		if !changedOnly || route.changed {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].destination != keys[j].destination {
+			return keys[i].destination.ToUint32() < keys[j].destination.ToUint32()
+		}
+		return keys[i].netmask.ToUint32() < keys[j].netmask.ToUint32()
+	})
+
+	entries := make([]Entry, 0, len(keys))
+	for _, key := range keys {
+		route := d.routes[key]
+		metric := route.Metric
+		if !route.Connected && route.Interface == iface.Name {
+			metric = Infinity // Poisoned reverse
+		}
+		entries = append(entries, Entry{
+			AddressFamily: AddressFamilyIP,
+			RouteTag:      route.RouteTag,
+			Address:       key.destination,
+			Netmask:       key.netmask,
+			Metric:        uint32(metric),
+		})
+	}
+	return entries
+}
+
+// sendEntries sends route entries in as many responses as they need.
+func (d *Daemon) sendEntries(iface *Interface, entries []Entry, to net.Addr) {
+	for len(entries) > 0 {
+		n := min(len(entries), MaxEntries)
+		d.send(iface, &Packet{Command: CommandResponse, Version: Version, Entries: entries[:n]}, to)
+		entries = entries[n:]
+	}
+}
+
+// send sends a message on an interface. Errors are dropped: RIP recovers
+// from lost messages with the next update.
+func (d *Daemon) send(iface *Interface, pkt *Packet, to net.Addr) {
+	data, err := pkt.Serialize()
+	if err != nil {
+		return
+	}
+	iface.Conn.WriteTo(data, to)
+}
+
+// validEntry reports whether a route entry of a response is one to learn:
+// an IPv4 unicast network with a contiguous netmask and a metric from 1 to
+// Infinity.
+func validEntry(e Entry) bool {
+	if e.AddressFamily != AddressFamilyIP || e.Metric < 1 || e.Metric > Infinity {
+		return false
+	}
+	if e.Address[0] == 0 && e.Address != (common.IPv4Address{}) {
+		return false // 0.0.0.0/8 other than the default route
+	}
+	if e.Address[0] == 127 || e.Address[0] >= 224 {
+		return false // Loopback, multicast and reserved
+	}
+	if host := ^e.Netmask.ToUint32(); host&(host+1) != 0 {
+		return false // Not contiguous
+	}
+	return mask(e.Address, e.Netmask) == e.Address
+}
+
+// sourceAddress returns the address and port a message came from.
+func sourceAddress(addr net.Addr) (common.IPv4Address, uint16, bool) {
+	switch a := addr.(type) {
+	case udp.Address:
+		return a.IP, a.Port, true
+	case *udp.Address:
+		return a.IP, a.Port, true
+	case *net.UDPAddr:
+		ip4 := a.IP.To4()
+		if ip4 == nil {
+			return common.IPv4Address{}, 0, false
+		}
+		var source common.IPv4Address
+		copy(source[:], ip4)
+		return source, uint16(a.Port), true
+	default:
+		return common.IPv4Address{}, 0, false
+	}
+}
+
+// multicastAddr returns the address updates are sent to.
+func multicastAddr() *net.UDPAddr {
+	g := MulticastGroup
+	return &net.UDPAddr{IP: net.IPv4(g[0], g[1], g[2], g[3]), Port: Port}
+}
+
+// mask returns the network of an address.
+func mask(addr, netmask common.IPv4Address) common.IPv4Address {
+	for i := range addr {
+		addr[i] &= netmask[i]
+	}
+	return addr
+}
+
+// prefixLength returns the prefix length of a netmask.
+func prefixLength(netmask common.IPv4Address) int {
+	ones, _ := net.IPMask(netmask[:]).Size()
+	return ones
+}
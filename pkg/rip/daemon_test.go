@@ -0,0 +1,504 @@
+package rip
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// fakeConn is a PacketConn that records the messages written to it.
+type fakeConn struct {
+	mu     sync.Mutex
+	writes []sent
+	closed chan struct{}
+	once   sync.Once
+}
+
+// sent is a message written to a fakeConn.
+type sent struct {
+	pkt *Packet
+	to  net.Addr
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{closed: make(chan struct{})}
+}
+
+func (c *fakeConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	<-c.closed
+	return 0, nil, net.ErrClosed
+}
+
+func (c *fakeConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	pkt, err := Parse(p)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, sent{pkt, addr})
+	return len(p), nil
+}
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakeConn) LocalAddr() net.Addr                { return udp.Address{Port: Port} }
+func (c *fakeConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fakeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// take returns and forgets the messages written so far.
+func (c *fakeConn) take() []sent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writes := c.writes
+	c.writes = nil
+	return writes
+}
+
+var (
+	eth0Address = common.IPv4Address{192, 168, 1, 1}
+	eth1Address = common.IPv4Address{10, 0, 0, 1}
+	neighbor    = common.IPv4Address{192, 168, 1, 2}
+	other       = common.IPv4Address{192, 168, 1, 3}
+	mask24      = common.IPv4Address{255, 255, 255, 0}
+	mask16      = common.IPv4Address{255, 255, 0, 0}
+	remoteNet   = common.IPv4Address{172, 16, 0, 0}
+)
+
+// testDaemon is a daemon on two interfaces, eth0 (192.168.1.1/24) and eth1
+// (10.0.0.1/24), with a clock the test sets.
+type testDaemon struct {
+	*Daemon
+	table *ip.RoutingTable
+	eth0  *fakeConn
+	eth1  *fakeConn
+	clock time.Time
+}
+
+func newTestDaemon(t *testing.T) *testDaemon {
+	t.Helper()
+
+	td := &testDaemon{
+		table: ip.NewRoutingTable(),
+		eth0:  newFakeConn(),
+		eth1:  newFakeConn(),
+		clock: time.Unix(1000, 0),
+	}
+	d, err := New(Config{
+		Table: td.table,
+		Interfaces: []Interface{
+			{Name: "eth0", Address: eth0Address, Netmask: mask24, Conn: td.eth0},
+			{Name: "eth1", Address: eth1Address, Netmask: mask24, Conn: td.eth1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	d.now = func() time.Time { return td.clock }
+	td.Daemon = d
+	return td
+}
+
+// respond delivers a response from a neighbor on eth0.
+func (td *testDaemon) respond(from common.IPv4Address, entries ...Entry) {
+	pkt := &Packet{Command: CommandResponse, Version: Version, Entries: entries}
+	data, _ := pkt.Serialize()
+	td.handle(&td.interfaces[0], data, from, Port)
+}
+
+// route returns the daemon's route to a network, or nil.
+func (td *testDaemon) route(destination, netmask common.IPv4Address) *Route {
+	for _, r := range td.Routes() {
+		if r.Destination == destination && r.Netmask == netmask {
+			return &r
+		}
+	}
+	return nil
+}
+
+func entry(destination, netmask common.IPv4Address, metric uint32) Entry {
+	return Entry{AddressFamily: AddressFamilyIP, Address: destination, Netmask: netmask, Metric: metric}
+}
+
+// metrics returns the metric advertised for each network in a set of
+// messages.
+func metrics(writes []sent) map[common.IPv4Address]uint32 {
+	m := make(map[common.IPv4Address]uint32)
+	for _, w := range writes {
+		for _, e := range w.pkt.Entries {
+			m[e.Address] = e.Metric
+		}
+	}
+	return m
+}
+
+func TestNew(t *testing.T) {
+	conn := newFakeConn()
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"no table", Config{Interfaces: []Interface{{Name: "eth0", Conn: conn}}}},
+		{"no interfaces", Config{Table: ip.NewRoutingTable()}},
+		{"no connection", Config{Table: ip.NewRoutingTable(), Interfaces: []Interface{{Name: "eth0"}}}},
+		{"metric infinity", Config{Table: ip.NewRoutingTable(), Interfaces: []Interface{{Name: "eth0", Conn: conn, Metric: Infinity}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); err == nil {
+				t.Errorf("New() error = nil, want error")
+			}
+		})
+	}
+
+	td := newTestDaemon(t)
+	routes := td.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("len(Routes()) = %d, want 2 connected routes", len(routes))
+	}
+	for _, r := range routes {
+		if !r.Connected || r.Metric != 1 {
+			t.Errorf("route %s: Connected = %v, Metric = %d, want connected with metric 1", &r, r.Connected, r.Metric)
+		}
+	}
+}
+
+func TestDaemonLearnsRoutes(t *testing.T) {
+	td := newTestDaemon(t)
+
+	td.respond(neighbor, entry(remoteNet, mask16, 2))
+
+	r := td.route(remoteNet, mask16)
+	if r == nil {
+		t.Fatalf("route to %s not learned", remoteNet)
+	}
+	if r.NextHop != neighbor || r.Interface != "eth0" || r.Metric != 3 {
+		t.Errorf("route = %s, want via %s dev eth0 metric 3", r, neighbor)
+	}
+
+	route, nextHop, err := td.table.Lookup(common.IPv4Address{172, 16, 5, 5})
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if nextHop != neighbor || route.Interface != "eth0" || route.Metric != 3 {
+		t.Errorf("Lookup() = %+v via %s, want eth0 via %s metric 3", route, nextHop, neighbor)
+	}
+
+	// A worse route from another router is ignored
+	td.respond(other, entry(remoteNet, mask16, 5))
+	if r := td.route(remoteNet, mask16); r.NextHop != neighbor {
+		t.Errorf("NextHop = %s after worse route, want %s", r.NextHop, neighbor)
+	}
+
+	// A better one replaces it
+	td.respond(other, entry(remoteNet, mask16, 1))
+	if r := td.route(remoteNet, mask16); r.NextHop != other || r.Metric != 2 {
+		t.Errorf("route = %s after better route, want via %s metric 2", r, other)
+	}
+	if _, nextHop, _ := td.table.Lookup(common.IPv4Address{172, 16, 5, 5}); nextHop != other {
+		t.Errorf("Lookup() next hop = %s, want %s", nextHop, other)
+	}
+
+	// The router the route is from may make it worse
+	td.respond(other, entry(remoteNet, mask16, 4))
+	if r := td.route(remoteNet, mask16); r.NextHop != other || r.Metric != 5 {
+		t.Errorf("route = %s after worse metric from its router, want via %s metric 5", r, other)
+	}
+
+	// An advertised next hop on the interface's network is used
+	td.respond(neighbor, Entry{
+		AddressFamily: AddressFamilyIP,
+		Address:       common.IPv4Address{10, 9, 0, 0},
+		Netmask:       mask16,
+		NextHop:       other,
+		Metric:        1,
+	})
+	if r := td.route(common.IPv4Address{10, 9, 0, 0}, mask16); r == nil || r.NextHop != other {
+		t.Errorf("route = %v, want via advertised next hop %s", r, other)
+	}
+}
+
+func TestDaemonIgnoresInvalid(t *testing.T) {
+	td := newTestDaemon(t)
+
+	valid := entry(remoteNet, mask16, 1)
+	data, _ := (&Packet{Command: CommandResponse, Version: Version, Entries: []Entry{valid}}).Serialize()
+	v1, _ := (&Packet{Command: CommandResponse, Version: 1, Entries: []Entry{valid}}).Serialize()
+
+	td.handle(&td.interfaces[0], data, neighbor, 5000)                        // Not from a RIP process
+	td.handle(&td.interfaces[0], data, common.IPv4Address{10, 0, 0, 2}, Port) // Not on eth0's network
+	td.handle(&td.interfaces[0], data, eth0Address, Port)                     // Our own
+	td.handle(&td.interfaces[0], v1, neighbor, Port)                          // RIPv1
+	td.handle(&td.interfaces[0], []byte{0x02}, neighbor, Port)                // Malformed
+
+	td.respond(neighbor,
+		Entry{AddressFamily: 0xffff, Address: remoteNet, Netmask: mask16, Metric: 1}, // Authentication
+		entry(remoteNet, mask16, 0),
+		entry(remoteNet, mask16, 17),
+		entry(common.IPv4Address{127, 0, 0, 0}, common.IPv4Address{255, 0, 0, 0}, 1),
+		entry(common.IPv4Address{224, 0, 0, 0}, common.IPv4Address{240, 0, 0, 0}, 1),
+		entry(common.IPv4Address{172, 16, 1, 0}, mask16, 1),                             // Host bits set
+		entry(common.IPv4Address{172, 16, 0, 0}, common.IPv4Address{255, 0, 255, 0}, 1), // Not contiguous
+		entry(remoteNet, mask16, Infinity),                                              // Unreachable and unknown
+	)
+
+	if routes := td.Routes(); len(routes) != 2 {
+		t.Errorf("Routes() = %v, want only the connected routes", routes)
+	}
+	if routes := td.table.GetRoutes(); len(routes) != 0 {
+		t.Errorf("table routes = %v, want none", routes)
+	}
+
+	// Connected networks are not learned
+	td.respond(neighbor, entry(common.IPv4Address{10, 0, 0, 0}, mask24, 1))
+	if r := td.route(common.IPv4Address{10, 0, 0, 0}, mask24); !r.Connected {
+		t.Errorf("route = %s, want connected", r)
+	}
+}
+
+func TestDaemonSplitHorizon(t *testing.T) {
+	td := newTestDaemon(t)
+	td.respond(neighbor, entry(remoteNet, mask16, 2))
+
+	td.tick()
+
+	eth0 := td.eth0.take()
+	eth1 := td.eth1.take()
+	if len(eth0) != 1 || len(eth1) != 1 {
+		t.Fatalf("sent %d messages on eth0 and %d on eth1, want 1 each", len(eth0), len(eth1))
+	}
+	to, ok := eth0[0].to.(*net.UDPAddr)
+	if !ok || !to.IP.Equal(net.IPv4(224, 0, 0, 9)) || to.Port != Port {
+		t.Errorf("update sent to %v, want 224.0.0.9:520", eth0[0].to)
+	}
+	if eth0[0].pkt.Command != CommandResponse {
+		t.Errorf("Command = %s, want Response", eth0[0].pkt.Command)
+	}
+
+	tests := []struct {
+		name    string
+		writes  []sent
+		network common.IPv4Address
+		want    uint32
+	}{
+		{"eth0 poisoned reverse", eth0, remoteNet, Infinity},
+		{"eth1 learned", eth1, remoteNet, 3},
+		{"eth0 connected", eth0, common.IPv4Address{192, 168, 1, 0}, 1},
+		{"eth0 other connected", eth0, common.IPv4Address{10, 0, 0, 0}, 1},
+		{"eth1 connected", eth1, common.IPv4Address{192, 168, 1, 0}, 1},
+	}
+	for _, tt := range tests {
+		got, ok := metrics(tt.writes)[tt.network]
+		if !ok || got != tt.want {
+			t.Errorf("%s: metric for %s = %d (advertised %v), want %d", tt.name, tt.network, got, ok, tt.want)
+		}
+	}
+}
+
+func TestDaemonUpdates(t *testing.T) {
+	td := newTestDaemon(t)
+
+	// The first tick sends the periodic update, then nothing until a route
+	// changes or the interval passes
+	td.tick()
+	if n := len(td.eth1.take()); n != 1 {
+		t.Fatalf("first tick sent %d messages on eth1, want 1", n)
+	}
+	td.clock = td.clock.Add(time.Second)
+	td.tick()
+	if n := len(td.eth1.take()); n != 0 {
+		t.Fatalf("idle tick sent %d messages on eth1, want 0", n)
+	}
+
+	// A triggered update carries only the changed route
+	td.respond(neighbor, entry(remoteNet, mask16, 1))
+	td.clock = td.clock.Add(time.Second)
+	td.tick()
+	writes := td.eth1.take()
+	if len(writes) != 1 || len(writes[0].pkt.Entries) != 1 || writes[0].pkt.Entries[0].Address != remoteNet {
+		t.Fatalf("triggered update = %v, want one message with the learned route", writes)
+	}
+	td.eth0.take()
+
+	// The periodic update carries everything
+	td.clock = td.clock.Add(DefaultUpdateInterval)
+	td.tick()
+	if got := len(metrics(td.eth1.take())); got != 3 {
+		t.Errorf("periodic update advertised %d networks, want 3", got)
+	}
+
+	// Large tables are split into messages of at most MaxEntries
+	for i := 0; i < 30; i++ {
+		td.respond(neighbor, entry(common.IPv4Address{172, 17, byte(i), 0}, mask24, 1))
+	}
+	td.clock = td.clock.Add(DefaultUpdateInterval)
+	td.tick()
+	writes = td.eth1.take()
+	if len(writes) != 2 || len(writes[0].pkt.Entries) != MaxEntries || len(writes[1].pkt.Entries) != 8 {
+		t.Errorf("periodic update sent %d messages, want 2 with 25 and 8 entries", len(writes))
+	}
+}
+
+func TestDaemonTimeout(t *testing.T) {
+	td := newTestDaemon(t)
+	td.respond(neighbor, entry(remoteNet, mask16, 1))
+	td.tick()
+	td.eth1.take()
+
+	// Refreshing the route restarts its timeout
+	td.clock = td.clock.Add(DefaultTimeout - time.Second)
+	td.respond(neighbor, entry(remoteNet, mask16, 1))
+	td.clock = td.clock.Add(2 * time.Second)
+	td.tick()
+	if r := td.route(remoteNet, mask16); r.Metric != 2 {
+		t.Fatalf("Metric = %d after refresh, want 2", r.Metric)
+	}
+
+	td.clock = td.clock.Add(DefaultTimeout)
+	td.eth1.take()
+	td.tick()
+
+	// The route is withdrawn from the table and advertised as unreachable
+	if r := td.route(remoteNet, mask16); r == nil || r.Metric != Infinity {
+		t.Fatalf("route = %v after timeout, want metric %d", r, Infinity)
+	}
+	if _, _, err := td.table.Lookup(common.IPv4Address{172, 16, 5, 5}); err == nil {
+		t.Errorf("Lookup() error = nil after timeout, want error")
+	}
+	if got := metrics(td.eth1.take())[remoteNet]; got != Infinity {
+		t.Errorf("advertised metric = %d after timeout, want %d", got, Infinity)
+	}
+
+	// A route from any router brings it back during garbage collection
+	td.respond(other, entry(remoteNet, mask16, 3))
+	if r := td.route(remoteNet, mask16); r.Metric != 4 || r.NextHop != other {
+		t.Errorf("route = %s during garbage collection, want via %s metric 4", r, other)
+	}
+	td.respond(other, entry(remoteNet, mask16, Infinity))
+
+	// Once garbage collected it is forgotten
+	td.clock = td.clock.Add(DefaultGarbageCollection)
+	td.tick()
+	if r := td.route(remoteNet, mask16); r != nil {
+		t.Errorf("route = %s after garbage collection, want none", r)
+	}
+}
+
+func TestDaemonWithdrawnRoute(t *testing.T) {
+	td := newTestDaemon(t)
+	td.respond(neighbor, entry(remoteNet, mask16, 1))
+
+	// Unreachable from another router changes nothing
+	td.respond(other, entry(remoteNet, mask16, Infinity))
+	if r := td.route(remoteNet, mask16); r.Metric != 2 {
+		t.Fatalf("Metric = %d, want 2", r.Metric)
+	}
+
+	td.respond(neighbor, entry(remoteNet, mask16, Infinity))
+	if r := td.route(remoteNet, mask16); r.Metric != Infinity {
+		t.Errorf("Metric = %d after withdrawal, want %d", r.Metric, Infinity)
+	}
+	if routes := td.table.GetRoutes(); len(routes) != 0 {
+		t.Errorf("table routes = %v after withdrawal, want none", routes)
+	}
+}
+
+func TestDaemonRequests(t *testing.T) {
+	td := newTestDaemon(t)
+	td.respond(neighbor, entry(remoteNet, mask16, 1))
+	requester := common.IPv4Address{192, 168, 1, 7}
+
+	// A whole-table request is answered like an update, to the requester
+	request, _ := (&Packet{Command: CommandRequest, Version: Version, Entries: []Entry{{Metric: Infinity}}}).Serialize()
+	td.handle(&td.interfaces[0], request, requester, Port)
+	writes := td.eth0.take()
+	if len(writes) != 1 {
+		t.Fatalf("sent %d responses, want 1", len(writes))
+	}
+	to, ok := writes[0].to.(*net.UDPAddr)
+	if !ok || !to.IP.Equal(net.IPv4(192, 168, 1, 7)) || to.Port != Port {
+		t.Errorf("response sent to %v, want 192.168.1.7:520", writes[0].to)
+	}
+	if got := metrics(writes)[remoteNet]; got != Infinity {
+		t.Errorf("metric for %s = %d, want %d (split horizon)", remoteNet, got, Infinity)
+	}
+
+	// A request for specific networks is answered as they are, to the
+	// requester's port
+	request, _ = (&Packet{Command: CommandRequest, Version: Version, Entries: []Entry{
+		entry(remoteNet, mask16, 0),
+		entry(common.IPv4Address{10, 99, 0, 0}, mask16, 0),
+	}}).Serialize()
+	td.handle(&td.interfaces[0], request, requester, 40000)
+	writes = td.eth0.take()
+	if len(writes) != 1 {
+		t.Fatalf("sent %d responses, want 1", len(writes))
+	}
+	if to := writes[0].to.(*net.UDPAddr); to.Port != 40000 {
+		t.Errorf("response sent to port %d, want 40000", to.Port)
+	}
+	got := metrics(writes)
+	if got[remoteNet] != 2 || got[common.IPv4Address{10, 99, 0, 0}] != Infinity {
+		t.Errorf("metrics = %v, want %s at 2 and 10.99.0.0 at %d", got, remoteNet, Infinity)
+	}
+}
+
+func TestDaemonStartAndClose(t *testing.T) {
+	td := newTestDaemon(t)
+	td.respond(neighbor, entry(remoteNet, mask16, 1))
+
+	td.Start()
+
+	// Start asks the neighbors for their tables
+	writes := td.eth0.take()
+	if len(writes) == 0 || !writes[0].pkt.IsTableRequest() {
+		t.Fatalf("first message = %v, want a whole-table request", writes)
+	}
+
+	if err := td.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if routes := td.table.GetRoutes(); len(routes) != 0 {
+		t.Errorf("table routes = %v after Close(), want none", routes)
+	}
+	if err := td.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+}
+
+func TestSourceAddress(t *testing.T) {
+	want := common.IPv4Address{192, 168, 1, 2}
+	tests := []struct {
+		name string
+		addr net.Addr
+		ok   bool
+	}{
+		{"udp.Address", udp.Address{IP: want, Port: Port}, true},
+		{"*udp.Address", &udp.Address{IP: want, Port: Port}, true},
+		{"*net.UDPAddr", &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: Port}, true},
+		{"IPv6", &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: Port}, false},
+		{"other", &net.TCPAddr{IP: net.IPv4(192, 168, 1, 2), Port: Port}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, port, ok := sourceAddress(tt.addr)
+			if ok != tt.ok {
+				t.Fatalf("sourceAddress() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && (source != want || port != Port) {
+				t.Errorf("sourceAddress() = %s:%d, want %s:%d", source, port, want, Port)
+			}
+		})
+	}
+}
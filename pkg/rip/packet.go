@@ -0,0 +1,160 @@
+// Package rip implements the Routing Information Protocol version 2 (RFC
+// 2453) over the stack's UDP sockets.
+//
+// A Daemon exchanges routes with neighboring routers on each of its
+// interfaces and installs the best routes it learns into an
+// ip.RoutingTable, so the stack's forwarding follows them.
+package rip
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// RIPv2 message format (RFC 2453 Section 4):
+// +-------------+-------------+-------------------------+
+// | Command (1) | Version (1) | Must be zero (2)        |
+// +-------------+-------------+-------------------------+
+// | Route entries (20 bytes each), 1 to 25              |
+// +-----------------------------------------------------+
+//
+// Route entry:
+// +---------------------------+-------------------------+
+// | Address family (2)        | Route tag (2)           |
+// +---------------------------+-------------------------+
+// | IP address (4)                                      |
+// | Subnet mask (4)                                     |
+// | Next hop (4)                                        |
+// | Metric (4)                                          |
+// +-----------------------------------------------------+
+
+const (
+	// Port is the UDP port RIP routers send from and listen on.
+	Port = 520
+
+	// Version is the protocol version this package speaks.
+	Version = 2
+
+	// Infinity is the metric of an unreachable network.
+	Infinity = 16
+
+	// MaxEntries is the most route entries one message carries.
+	MaxEntries = 25
+
+	// AddressFamilyIP is the address family of IPv4 route entries.
+	AddressFamilyIP = 2
+
+	// headerLength is the length of the message header.
+	headerLength = 4
+
+	// entryLength is the length of a route entry.
+	entryLength = 20
+)
+
+// MulticastGroup is the group RIPv2 routers send updates to.
+var MulticastGroup = common.IPv4Address{224, 0, 0, 9}
+
+// Command is a RIP message type.
+type Command uint8
+
+// RIP commands.
+const (
+	CommandRequest  Command = 1
+	CommandResponse Command = 2
+)
+
+// String returns a human-readable name for the command.
+func (c Command) String() string {
+	switch c {
+	case CommandRequest:
+		return "Request"
+	case CommandResponse:
+		return "Response"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(c))
+	}
+}
+
+// Entry is a route entry of a RIP message.
+type Entry struct {
+	AddressFamily uint16
+	RouteTag      uint16
+	Address       common.IPv4Address
+	Netmask       common.IPv4Address
+	NextHop       common.IPv4Address // 0.0.0.0 means the sender
+	Metric        uint32
+}
+
+// Packet is a RIP message.
+type Packet struct {
+	Command Command
+	Version uint8
+	Entries []Entry
+}
+
+// IsTableRequest reports whether the packet requests the sender's whole
+// routing table: a request with a single entry of address family 0 and
+// metric Infinity.
+func (p *Packet) IsTableRequest() bool {
+	return p.Command == CommandRequest && len(p.Entries) == 1 &&
+		p.Entries[0].AddressFamily == 0 && p.Entries[0].Metric == Infinity
+}
+
+// Parse parses a RIP message.
+func Parse(data []byte) (*Packet, error) {
+	if len(data) < headerLength {
+		return nil, fmt.Errorf("message too short: %d bytes", len(data))
+	}
+	if (len(data)-headerLength)%entryLength != 0 {
+		return nil, fmt.Errorf("message length %d is not a whole number of entries", len(data))
+	}
+
+	p := &Packet{Command: Command(data[0]), Version: data[1]}
+	if p.Command != CommandRequest && p.Command != CommandResponse {
+		return nil, fmt.Errorf("unknown command %d", data[0])
+	}
+	if p.Version == 0 {
+		return nil, fmt.Errorf("invalid version 0")
+	}
+
+	for b := data[headerLength:]; len(b) > 0; b = b[entryLength:] {
+		e := Entry{
+			AddressFamily: binary.BigEndian.Uint16(b[0:2]),
+			RouteTag:      binary.BigEndian.Uint16(b[2:4]),
+			Metric:        binary.BigEndian.Uint32(b[16:20]),
+		}
+		copy(e.Address[:], b[4:8])
+		copy(e.Netmask[:], b[8:12])
+		copy(e.NextHop[:], b[12:16])
+		p.Entries = append(p.Entries, e)
+	}
+	return p, nil
+}
+
+// Serialize converts the message to bytes.
+func (p *Packet) Serialize() ([]byte, error) {
+	if len(p.Entries) > MaxEntries {
+		return nil, fmt.Errorf("too many entries: %d (maximum %d)", len(p.Entries), MaxEntries)
+	}
+
+	data := make([]byte, headerLength+len(p.Entries)*entryLength)
+	data[0] = byte(p.Command)
+	data[1] = p.Version
+	for i, e := range p.Entries {
+		b := data[headerLength+i*entryLength:]
+		binary.BigEndian.PutUint16(b[0:2], e.AddressFamily)
+		binary.BigEndian.PutUint16(b[2:4], e.RouteTag)
+		copy(b[4:8], e.Address[:])
+		copy(b[8:12], e.Netmask[:])
+		copy(b[12:16], e.NextHop[:])
+		binary.BigEndian.PutUint32(b[16:20], e.Metric)
+	}
+	return data, nil
+}
+
+// String returns a human-readable representation of the message.
+func (p *Packet) String() string {
+	return fmt.Sprintf("RIPv%d{%s, %d entries}", p.Version, p.Command, len(p.Entries))
+}
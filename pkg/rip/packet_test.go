@@ -0,0 +1,124 @@
+package rip
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	pkt := &Packet{
+		Command: CommandResponse,
+		Version: Version,
+		Entries: []Entry{
+			{
+				AddressFamily: AddressFamilyIP,
+				RouteTag:      7,
+				Address:       common.IPv4Address{10, 1, 0, 0},
+				Netmask:       common.IPv4Address{255, 255, 0, 0},
+				NextHop:       common.IPv4Address{192, 168, 1, 2},
+				Metric:        3,
+			},
+			{
+				AddressFamily: AddressFamilyIP,
+				Address:       common.IPv4Address{172, 16, 0, 0},
+				Netmask:       common.IPv4Address{255, 240, 0, 0},
+				Metric:        Infinity,
+			},
+		},
+	}
+
+	data, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if len(data) != headerLength+2*entryLength {
+		t.Fatalf("len(data) = %d, want %d", len(data), headerLength+2*entryLength)
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !reflect.DeepEqual(parsed, pkt) {
+		t.Errorf("Parse() = %+v, want %+v", parsed, pkt)
+	}
+}
+
+func TestParseKnownMessage(t *testing.T) {
+	// A whole-table request: one entry, address family 0, metric 16
+	data := []byte{
+		0x01, 0x02, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x10,
+	}
+
+	pkt, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if pkt.Command != CommandRequest || pkt.Version != 2 {
+		t.Errorf("header = %s v%d, want Request v2", pkt.Command, pkt.Version)
+	}
+	if !pkt.IsTableRequest() {
+		t.Errorf("IsTableRequest() = false, want true")
+	}
+
+	serialized, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if !bytes.Equal(serialized, data) {
+		t.Errorf("Serialize() = %x, want %x", serialized, data)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", []byte{0x02, 0x02}},
+		{"partial entry", append([]byte{0x02, 0x02, 0x00, 0x00}, make([]byte, 10)...)},
+		{"unknown command", []byte{0x09, 0x02, 0x00, 0x00}},
+		{"version zero", []byte{0x02, 0x00, 0x00, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Errorf("Parse() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestSerializeTooManyEntries(t *testing.T) {
+	pkt := &Packet{Command: CommandResponse, Version: Version, Entries: make([]Entry, MaxEntries+1)}
+	if _, err := pkt.Serialize(); err == nil {
+		t.Errorf("Serialize() error = nil, want error")
+	}
+}
+
+func TestCommandString(t *testing.T) {
+	tests := []struct {
+		cmd  Command
+		want string
+	}{
+		{CommandRequest, "Request"},
+		{CommandResponse, "Response"},
+		{Command(9), "Unknown(9)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.cmd.String(); got != tt.want {
+			t.Errorf("Command(%d).String() = %q, want %q", uint8(tt.cmd), got, tt.want)
+		}
+	}
+}
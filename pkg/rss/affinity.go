@@ -0,0 +1,224 @@
+package rss
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// PinThread locks the calling goroutine to its OS thread and restricts the
+// thread to one CPU, for a goroutine reading or writing a device at a high
+// rate. The lock is kept for the life of the goroutine.
+func PinThread(cpu int) error {
+	if cpu < 0 {
+		return fmt.Errorf("invalid CPU %d", cpu)
+	}
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Set(cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to pin thread to CPU %d: %w", cpu, err)
+	}
+	return nil
+}
+
+// CPU describes an online logical CPU.
+type CPU struct {
+	ID      int // Logical CPU number
+	Core    int // Physical core, within the package
+	Package int // Physical package (socket)
+	Node    int // NUMA node
+}
+
+// Topology lists the online CPUs of the machine, by ID.
+type Topology struct {
+	CPUs []CPU
+}
+
+// ReadTopology reads the CPU topology from sysfs.
+func ReadTopology() (*Topology, error) {
+	return readTopology(os.DirFS("/sys"))
+}
+
+// readTopology reads the CPU topology from a sysfs tree.
+func readTopology(sysfs fs.FS) (*Topology, error) {
+	online, err := readCPUList(sysfs, "devices/system/cpu/online")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read online CPUs: %w", err)
+	}
+
+	// Without NUMA support there is no node directory: all CPUs are on
+	// node 0
+	nodeOf := map[int]int{}
+	if nodes, err := readCPUList(sysfs, "devices/system/node/online"); err == nil {
+		for _, node := range nodes {
+			cpus, err := readCPUList(sysfs, fmt.Sprintf("devices/system/node/node%d/cpulist", node))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CPUs of node %d: %w", node, err)
+			}
+			for _, cpu := range cpus {
+				nodeOf[cpu] = node
+			}
+		}
+	}
+
+	t := &Topology{}
+	for _, id := range online {
+		dir := fmt.Sprintf("devices/system/cpu/cpu%d/topology/", id)
+		core, err := readInt(sysfs, dir+"core_id")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read core of CPU %d: %w", id, err)
+		}
+		pkg, err := readInt(sysfs, dir+"physical_package_id")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read package of CPU %d: %w", id, err)
+		}
+		t.CPUs = append(t.CPUs, CPU{ID: id, Core: core, Package: pkg, Node: nodeOf[id]})
+	}
+	return t, nil
+}
+
+// Nodes returns the NUMA nodes with online CPUs, in order.
+func (t *Topology) Nodes() []int {
+	var nodes []int
+	for _, cpu := range t.CPUs {
+		if !slices.Contains(nodes, cpu.Node) {
+			nodes = append(nodes, cpu.Node)
+		}
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// InterfaceNode returns the NUMA node of a network interface's device, or
+// -1 if it has none, such as for a virtual interface or on a machine
+// without NUMA.
+func InterfaceNode(ifname string) int {
+	return interfaceNode(os.DirFS("/sys"), ifname)
+}
+
+func interfaceNode(sysfs fs.FS, ifname string) int {
+	node, err := readInt(sysfs, "class/net/"+ifname+"/device/numa_node")
+	if err != nil {
+		return -1
+	}
+	return node
+}
+
+// Layout assigns CPUs to the goroutines of a receive path: the readers of
+// the devices and the Dispatcher's workers (Config.CPUs).
+type Layout struct {
+	Readers []int
+	Workers []int
+}
+
+// Suggest lays out readers and workers on the CPUs of a NUMA node, or of
+// the whole machine if node is negative or has no CPUs. Readers come
+// first; workers get the remaining CPUs, one per CPU, or all of them if
+// workers is 0. CPUs are used one thread per physical core first, as
+// hyperthreads of one core compete for its execution units, and are
+// shared round-robin when there are more goroutines than CPUs.
+func (t *Topology) Suggest(node, readers, workers int) Layout {
+	var cpus []CPU
+	for _, cpu := range t.CPUs {
+		if cpu.Node == node {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		cpus = t.CPUs
+	}
+	if len(cpus) == 0 {
+		return Layout{}
+	}
+
+	// The first thread of each core, then the rest
+	type core struct{ pkg, id int }
+	seen := map[core]bool{}
+	var first, rest []int
+	for _, cpu := range cpus {
+		c := core{cpu.Package, cpu.Core}
+		if seen[c] {
+			rest = append(rest, cpu.ID)
+		} else {
+			seen[c] = true
+			first = append(first, cpu.ID)
+		}
+	}
+	order := append(first, rest...)
+
+	if workers == 0 {
+		workers = max(len(order)-readers, 1)
+	}
+	var layout Layout
+	for i := 0; i < readers+workers; i++ {
+		cpu := order[i%len(order)]
+		if i < readers {
+			layout.Readers = append(layout.Readers, cpu)
+		} else {
+			layout.Workers = append(layout.Workers, cpu)
+		}
+	}
+	return layout
+}
+
+// SuggestLayout reads the topology and suggests a layout on the NUMA node
+// of a network interface, as Topology.Suggest does.
+func SuggestLayout(ifname string, readers, workers int) (Layout, error) {
+	t, err := ReadTopology()
+	if err != nil {
+		return Layout{}, err
+	}
+	return t.Suggest(InterfaceNode(ifname), readers, workers), nil
+}
+
+// readInt reads a file holding a decimal integer.
+func readInt(sysfs fs.FS, name string) (int, error) {
+	data, err := fs.ReadFile(sysfs, name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// readCPUList reads a file holding a CPU list.
+func readCPUList(sysfs fs.FS, name string) ([]int, error) {
+	data, err := fs.ReadFile(sysfs, name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCPUList(strings.TrimSpace(string(data)))
+}
+
+// ParseCPUList parses a list of CPUs in the kernel's format, ranges and
+// single CPUs separated by commas: "0-3,8,10-11".
+func ParseCPUList(s string) ([]int, error) {
+	var cpus []int
+	if s == "" {
+		return cpus, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(field, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid CPU list %q", s)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU list %q", s)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,158 @@
+package rss
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+	}{
+		{"0", []int{0}},
+		{"0-3,8,10-11", []int{0, 1, 2, 3, 8, 10, 11}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseCPUList(tt.in)
+		if err != nil {
+			t.Fatalf("ParseCPUList(%q) error = %v", tt.in, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseCPUList(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"a", "3-1", "1,", "-1"} {
+		if _, err := ParseCPUList(bad); err == nil {
+			t.Errorf("ParseCPUList(%q) error = nil, want an error", bad)
+		}
+	}
+}
+
+// testSysfs is a machine with two NUMA nodes of two cores with two threads
+// each: CPUs 0-3 on node 0 and 4-7 on node 1, CPU n+2 the sibling of CPU n.
+func testSysfs() fstest.MapFS {
+	fsys := fstest.MapFS{
+		"devices/system/cpu/online":         {Data: []byte("0-7\n")},
+		"devices/system/node/online":        {Data: []byte("0-1\n")},
+		"devices/system/node/node0/cpulist": {Data: []byte("0-3\n")},
+		"devices/system/node/node1/cpulist": {Data: []byte("4-7\n")},
+		"class/net/eth1/device/numa_node":   {Data: []byte("1\n")},
+		"class/net/virt0/device/numa_node":  {Data: []byte("-1\n")},
+	}
+	for cpu := 0; cpu < 8; cpu++ {
+		dir := fmt.Sprintf("devices/system/cpu/cpu%d/topology/", cpu)
+		fsys[dir+"core_id"] = &fstest.MapFile{Data: []byte(fmt.Sprintf("%d\n", cpu%2))}
+		fsys[dir+"physical_package_id"] = &fstest.MapFile{Data: []byte(fmt.Sprintf("%d\n", cpu/4))}
+	}
+	return fsys
+}
+
+func TestReadTopology(t *testing.T) {
+	topo, err := readTopology(testSysfs())
+	if err != nil {
+		t.Fatalf("readTopology() error = %v", err)
+	}
+	if len(topo.CPUs) != 8 {
+		t.Fatalf("readTopology() found %d CPUs, want 8", len(topo.CPUs))
+	}
+	if got, want := topo.CPUs[6], (CPU{ID: 6, Core: 0, Package: 1, Node: 1}); got != want {
+		t.Errorf("CPU 6 = %+v, want %+v", got, want)
+	}
+	if got := topo.Nodes(); !reflect.DeepEqual(got, []int{0, 1}) {
+		t.Errorf("Nodes() = %v, want [0 1]", got)
+	}
+
+	// Without NUMA everything is on node 0
+	fsys := testSysfs()
+	delete(fsys, "devices/system/node/online")
+	if topo, err = readTopology(fsys); err != nil {
+		t.Fatalf("readTopology() error = %v", err)
+	}
+	if got := topo.Nodes(); !reflect.DeepEqual(got, []int{0}) {
+		t.Errorf("Nodes() without NUMA = %v, want [0]", got)
+	}
+
+	for ifname, want := range map[string]int{"eth1": 1, "virt0": -1, "missing": -1} {
+		if got := interfaceNode(testSysfs(), ifname); got != want {
+			t.Errorf("interfaceNode(%q) = %d, want %d", ifname, got, want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	topo, err := readTopology(testSysfs())
+	if err != nil {
+		t.Fatalf("readTopology() error = %v", err)
+	}
+	tests := []struct {
+		name                   string
+		node, readers, workers int
+		want                   Layout
+	}{
+		{
+			// Cores first: 4 and 5, then their siblings 6 and 7
+			name: "one reader, all CPUs", node: 1, readers: 1,
+			want: Layout{Readers: []int{4}, Workers: []int{5, 6, 7}},
+		},
+		{
+			name: "more workers than CPUs", node: 0, readers: 2, workers: 4,
+			want: Layout{Readers: []int{0, 1}, Workers: []int{2, 3, 0, 1}},
+		},
+		{
+			name: "no node", node: -1, readers: 1, workers: 2,
+			want: Layout{Readers: []int{0}, Workers: []int{1, 4}},
+		},
+	}
+	for _, tt := range tests {
+		if got := topo.Suggest(tt.node, tt.readers, tt.workers); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Suggest() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPinnedWorkers(t *testing.T) {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		t.Fatalf("SchedGetaffinity() error = %v", err)
+	}
+	cpu := 0
+	for !set.IsSet(cpu) {
+		cpu++
+	}
+
+	affinity := make(chan unix.CPUSet, 1)
+	d, err := New(Config{CPUs: []int{cpu}}, func(string, *ethernet.Frame) error {
+		var set unix.CPUSet
+		unix.SchedGetaffinity(0, &set)
+		affinity <- set
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if d.Workers() != 1 {
+		t.Errorf("Workers() = %d, want 1 per CPU", d.Workers())
+	}
+	d.Dispatch("test0", ipv4Frame(common.IPv4Address{10, 0, 0, 1}, common.IPv4Address{10, 0, 0, 2}, common.ProtocolUDP, 1, 2, 0))
+	if got := <-affinity; got.Count() != 1 || !got.IsSet(cpu) {
+		t.Errorf("worker runs on %d CPUs, want only CPU %d", got.Count(), cpu)
+	}
+	if stats := d.Stats(); stats[0].CPU != cpu {
+		t.Errorf("Stats()[0].CPU = %d, want %d", stats[0].CPU, cpu)
+	}
+	d.Close()
+
+	// A CPU that is not online fails New
+	if _, err := New(Config{CPUs: []int{1023}}, func(string, *ethernet.Frame) error { return nil }); err == nil {
+		t.Error("New() with an offline CPU error = nil, want an error")
+	}
+}
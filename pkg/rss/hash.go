@@ -0,0 +1,85 @@
+package rss
+
+import (
+	"encoding/binary"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// SymmetricKey is the default Toeplitz key. The 16-bit pattern repeats, so
+// swapping the source and destination of a flow does not change its hash
+// and both directions of a connection reach the same worker.
+var SymmetricKey = []byte{
+	0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a,
+	0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a,
+	0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a,
+	0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a,
+	0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a,
+}
+
+// MinKeyLength is the shortest key that covers the largest hash input, the
+// 12-byte IPv4 4-tuple.
+const MinKeyLength = maxInputLength + 4
+
+// maxInputLength is the length of the IPv4 4-tuple: two addresses and two
+// ports.
+const maxInputLength = 12
+
+// Hash returns the flow hash of a frame with the given Toeplitz key, as a
+// NIC computes it for receive-side scaling:
+//
+//   - TCP and UDP over IPv4: source and destination address and port
+//   - Other IPv4, and IPv4 fragments: source and destination address, so
+//     every fragment of a datagram hashes alike
+//   - Other frames: source and destination MAC address
+//
+// The protocol is not hashed, matching NIC RSS; flows that differ only in
+// protocol share a worker. The key must be at least MinKeyLength bytes.
+func Hash(key []byte, frame *ethernet.Frame) uint32 {
+	var input [maxInputLength]byte
+	return toeplitz(key, flowTuple(input[:0], frame))
+}
+
+// flowTuple appends the hash input for a frame to b.
+func flowTuple(b []byte, frame *ethernet.Frame) []byte {
+	if frame.EtherType != common.EtherTypeIPv4 || !hasIPv4Header(frame.Payload) {
+		b = append(b, frame.Source[:]...)
+		return append(b, frame.Destination[:]...)
+	}
+
+	// Addresses, read from the header without a full parse
+	pkt := frame.Payload
+	b = append(b, pkt[12:20]...)
+
+	// Ports, unless they are not in this packet
+	headerLength := int(pkt[0]&0x0F) * 4
+	protocol := common.Protocol(pkt[9])
+	fragment := binary.BigEndian.Uint16(pkt[6:8])&0x3FFF != 0 // MF or offset
+	if (protocol == common.ProtocolTCP || protocol == common.ProtocolUDP) && !fragment && len(pkt) >= headerLength+4 {
+		b = append(b, pkt[headerLength:headerLength+4]...)
+	}
+	return b
+}
+
+// hasIPv4Header reports whether data starts with a plausible IPv4 header.
+func hasIPv4Header(data []byte) bool {
+	return len(data) >= 20 && data[0]>>4 == 4 && data[0]&0x0F >= 5
+}
+
+// toeplitz computes the Toeplitz hash of data: the XOR of the 32-bit
+// windows of the key starting at each set bit of the input.
+func toeplitz(key, data []byte) uint32 {
+	var hash uint32
+	window := binary.BigEndian.Uint32(key)
+	for i, b := range data {
+		next := key[i+4]
+		for bit := 7; bit >= 0; bit-- {
+			if b&(1<<bit) != 0 {
+				hash ^= window
+			}
+			window = window<<1 | uint32(next>>bit)&1
+		}
+	}
+	return hash
+}
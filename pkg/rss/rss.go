@@ -0,0 +1,333 @@
+// Package rss spreads received frames across worker goroutines, in the
+// manner of receive-side scaling on a multi-queue NIC.
+//
+// A Dispatcher hashes each frame's flow and queues it to one of N workers,
+// so frames of one connection are handled in order by a single worker while
+// different connections are processed in parallel:
+//
+//	rx, err := rss.New(rss.Config{Workers: 4}, pipeline.ReceiveFrame)
+//	for {
+//		frame, err := iface.ReadFrame()
+//		...
+//		rx.Dispatch(iface.Name(), frame)
+//	}
+//
+// When a worker's queue is full the configured DropPolicy decides between
+// dropping the new frame, dropping the oldest queued one, or blocking the
+// reader until there is room.
+//
+// At high packet rates workers can be pinned to CPUs (Config.CPUs), and
+// readers with PinThread; SuggestLayout picks CPUs on the NUMA node of the
+// NIC:
+//
+//	layout, err := rss.SuggestLayout("eth0", 1, 0)
+//	rx, err := rss.New(rss.Config{CPUs: layout.Workers}, pipeline.ReceiveFrame)
+//	go func() {
+//		rss.PinThread(layout.Readers[0])
+//		...
+//	}()
+package rss
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+// logger is the "rss" logging subsystem.
+var logger = logging.New("rss")
+
+const (
+	// DefaultQueueDepth is the number of frames queued per worker.
+	DefaultQueueDepth = 256
+)
+
+var (
+	// ErrQueueFull is returned by Dispatch when a frame is dropped because
+	// its worker's queue is full.
+	ErrQueueFull = errors.New("rss: worker queue full")
+
+	// ErrClosed is returned by Dispatch after Close.
+	ErrClosed = errors.New("rss: dispatcher closed")
+)
+
+// DropPolicy decides what happens to a frame whose worker queue is full.
+type DropPolicy uint8
+
+const (
+	// DropNewest drops the arriving frame (tail drop).
+	DropNewest DropPolicy = iota
+
+	// DropOldest drops the frame that has been queued longest, to make room
+	// for the arriving one. Fresh frames are often worth more than stale
+	// ones, for example for real-time traffic.
+	DropOldest
+
+	// Block waits for room in the queue, pushing back on the reader.
+	Block
+)
+
+// String returns the name of the policy.
+func (p DropPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Block:
+		return "block"
+	default:
+		return fmt.Sprintf("DropPolicy(%d)", p)
+	}
+}
+
+// Handler processes a frame received on a device. It runs on a worker
+// goroutine and takes ownership of the frame. hook.Pipeline.ReceiveFrame
+// is a Handler.
+type Handler func(device string, frame *ethernet.Frame) error
+
+// Config configures a Dispatcher.
+type Config struct {
+	Workers    int        // Worker goroutines (default len(CPUs), or GOMAXPROCS)
+	QueueDepth int        // Frames queued per worker (default DefaultQueueDepth)
+	Policy     DropPolicy // What to do when a worker's queue is full
+	Key        []byte     // Toeplitz hash key (default SymmetricKey)
+
+	// CPUs are the CPUs workers are pinned to, worker i to CPUs[i] and
+	// round-robin if there are more workers. nil leaves them unpinned.
+	CPUs []int
+}
+
+// WorkerStats holds the counters of one worker.
+type WorkerStats struct {
+	Queued    uint64 // Frames accepted into the queue
+	Processed uint64 // Frames passed to the handler
+	Dropped   uint64 // Frames dropped because the queue was full
+	Errors    uint64 // Frames for which the handler returned an error
+	Depth     int    // Frames currently queued
+	CPU       int    // CPU the worker is pinned to, or -1
+}
+
+// item is a queued frame.
+type item struct {
+	device string
+	frame  *ethernet.Frame
+}
+
+// worker is one queue and the goroutine draining it.
+type worker struct {
+	queue     chan item
+	cpu       int
+	queued    atomic.Uint64
+	processed atomic.Uint64
+	dropped   atomic.Uint64
+	errors    atomic.Uint64
+}
+
+// Dispatcher distributes frames to workers by flow hash.
+type Dispatcher struct {
+	config  Config
+	handler Handler
+	workers []*worker
+
+	// mu guards closed and the queues against closing while Dispatch sends
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// New creates a dispatcher and starts its workers.
+func New(config Config, handler Handler) (*Dispatcher, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("handler is required")
+	}
+
+	// Apply defaults
+	if config.Workers == 0 {
+		config.Workers = len(config.CPUs)
+	}
+	if config.Workers == 0 {
+		config.Workers = runtime.GOMAXPROCS(0)
+	}
+	if config.Workers < 0 {
+		return nil, fmt.Errorf("invalid worker count: %d", config.Workers)
+	}
+	if config.QueueDepth == 0 {
+		config.QueueDepth = DefaultQueueDepth
+	}
+	if config.QueueDepth < 0 {
+		return nil, fmt.Errorf("invalid queue depth: %d", config.QueueDepth)
+	}
+	if config.Policy > Block {
+		return nil, fmt.Errorf("invalid drop policy: %v", config.Policy)
+	}
+	if config.Key == nil {
+		config.Key = SymmetricKey
+	}
+	if len(config.Key) < MinKeyLength {
+		return nil, fmt.Errorf("hash key too short: %d bytes (minimum %d)", len(config.Key), MinKeyLength)
+	}
+
+	for _, cpu := range config.CPUs {
+		if cpu < 0 {
+			return nil, fmt.Errorf("invalid CPU: %d", cpu)
+		}
+	}
+
+	d := &Dispatcher{
+		config:  config,
+		handler: handler,
+		workers: make([]*worker, config.Workers),
+	}
+	started := make(chan error, config.Workers)
+	for i := range d.workers {
+		w := &worker{queue: make(chan item, config.QueueDepth), cpu: -1}
+		if len(config.CPUs) > 0 {
+			w.cpu = config.CPUs[i%len(config.CPUs)]
+		}
+		d.workers[i] = w
+		d.wg.Add(1)
+		go d.run(w, started)
+	}
+
+	// Fail if a worker could not be pinned
+	var err error
+	for range d.workers {
+		if perr := <-started; perr != nil && err == nil {
+			err = perr
+		}
+	}
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Workers returns the number of workers.
+func (d *Dispatcher) Workers() int {
+	return len(d.workers)
+}
+
+// Worker returns the index of the worker that handles a frame's flow.
+func (d *Dispatcher) Worker(frame *ethernet.Frame) int {
+	return int(Hash(d.config.Key, frame) % uint32(len(d.workers)))
+}
+
+// Dispatch queues a frame to the worker for its flow. If the frame is
+// dropped it is released and ErrQueueFull is returned; with DropOldest the
+// arriving frame is always queued and the frame it displaced is counted as
+// dropped instead. Dispatch may be called from several goroutines, but
+// frames of one flow keep their order only when dispatched from one.
+func (d *Dispatcher) Dispatch(device string, frame *ethernet.Frame) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		frame.Release()
+		return ErrClosed
+	}
+
+	index := d.Worker(frame)
+	w := d.workers[index]
+	it := item{device: device, frame: frame}
+
+	switch d.config.Policy {
+	case Block:
+		w.queue <- it
+		w.queued.Add(1)
+	case DropOldest:
+		for !w.enqueue(it) {
+			// Make room by dropping the head of the queue
+			select {
+			case old := <-w.queue:
+				w.drop(index, old)
+			default:
+			}
+		}
+	default:
+		if !w.enqueue(it) {
+			w.drop(index, it)
+			return ErrQueueFull
+		}
+	}
+	return nil
+}
+
+// enqueue queues an item without blocking and reports whether it fit.
+func (w *worker) enqueue(it item) bool {
+	select {
+	case w.queue <- it:
+		w.queued.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+// drop discards an item that did not fit in the queue.
+func (w *worker) drop(index int, it item) {
+	w.dropped.Add(1)
+	if logger.Enabled(logging.LevelDebug) {
+		logger.Debug("queue full, frame dropped", logging.F("worker", index), logging.F("device", it.device))
+	}
+	it.frame.Release()
+}
+
+// run pins the worker to its CPU, if it has one, reports whether that
+// worked on started, and passes queued frames to the handler until the
+// queue is closed.
+func (d *Dispatcher) run(w *worker, started chan<- error) {
+	defer d.wg.Done()
+	if w.cpu >= 0 {
+		if err := PinThread(w.cpu); err != nil {
+			started <- err
+			return
+		}
+	}
+	started <- nil
+	for it := range w.queue {
+		w.processed.Add(1)
+		if err := d.handler(it.device, it.frame); err != nil {
+			w.errors.Add(1)
+		}
+	}
+}
+
+// Stats returns the counters of each worker.
+func (d *Dispatcher) Stats() []WorkerStats {
+	stats := make([]WorkerStats, len(d.workers))
+	for i, w := range d.workers {
+		stats[i] = WorkerStats{
+			Queued:    w.queued.Load(),
+			Processed: w.processed.Load(),
+			Dropped:   w.dropped.Load(),
+			Errors:    w.errors.Load(),
+			Depth:     len(w.queue),
+			CPU:       w.cpu,
+		}
+	}
+	return stats
+}
+
+// Close stops accepting frames, waits for the workers to process the frames
+// already queued, and stops them.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	for _, w := range d.workers {
+		close(w.queue)
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
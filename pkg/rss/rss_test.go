@@ -0,0 +1,254 @@
+package rss
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+// microsoftKey is the key of the RSS verification suite.
+var microsoftKey = []byte{
+	0x6d, 0x5a, 0x56, 0xda, 0x25, 0x5b, 0x0e, 0xc2,
+	0x41, 0x67, 0x25, 0x3d, 0x43, 0xa3, 0x8f, 0xb0,
+	0xd0, 0xca, 0x2b, 0xcb, 0xae, 0x7b, 0x30, 0xb4,
+	0x77, 0xcb, 0x2d, 0xa3, 0x80, 0x30, 0xf2, 0x0c,
+	0x6a, 0x42, 0xb7, 0x3b, 0xbe, 0xac, 0x01, 0xfa,
+}
+
+// ipv4Frame builds a frame carrying an IPv4 packet whose payload starts
+// with the given ports. seq is stored after the ports to tell frames apart.
+// The frame is backed by a pooled buffer, as if read from an interface.
+func ipv4Frame(src, dst common.IPv4Address, protocol common.Protocol, srcPort, dstPort uint16, seq uint32) *ethernet.Frame {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint16(payload[0:2], srcPort)
+	binary.BigEndian.PutUint16(payload[2:4], dstPort)
+	binary.BigEndian.PutUint32(payload[4:8], seq)
+	pkt, _ := ip.NewPacket(src, dst, protocol, payload).Serialize()
+	frame := ethernet.NewFrame(common.BroadcastMAC, common.MACAddress{2, 0, 0, 0, 0, 1}, common.EtherTypeIPv4, pkt)
+
+	buf := common.NewPooledBuffer(ethernet.MaxFrameSize)
+	n, _ := frame.SerializeTo(buf.Bytes())
+	buf.SetLen(n)
+	frame, _ = ethernet.ParseBuffer(buf)
+	return frame
+}
+
+// seqOf returns the seq stored in a frame by ipv4Frame.
+func seqOf(frame *ethernet.Frame) uint32 {
+	return binary.BigEndian.Uint32(frame.Payload[24:28])
+}
+
+func TestHash(t *testing.T) {
+	src := common.IPv4Address{66, 9, 149, 187}
+	dst := common.IPv4Address{161, 142, 100, 80}
+
+	tests := []struct {
+		name     string
+		protocol common.Protocol
+		want     uint32
+	}{
+		{"tcp", common.ProtocolTCP, 0x51ccc178},   // 4-tuple
+		{"icmp", common.ProtocolICMP, 0x323e8fc2}, // Addresses only
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := ipv4Frame(src, dst, tt.protocol, 2794, 1766, 0)
+			if got := Hash(microsoftKey, frame); got != tt.want {
+				t.Errorf("Hash() = 0x%08x, want 0x%08x", got, tt.want)
+			}
+		})
+	}
+
+	// Fragments hash on addresses only
+	frame := ipv4Frame(src, dst, common.ProtocolUDP, 2794, 1766, 0)
+	frame.Payload[6] |= 0x20 // More fragments
+	if got := Hash(microsoftKey, frame); got != 0x323e8fc2 {
+		t.Errorf("Hash() of fragment = 0x%08x, want 0x323e8fc2", got)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		Hash(SymmetricKey, frame)
+	})
+	if allocs != 0 {
+		t.Errorf("Hash() allocs = %v, want 0", allocs)
+	}
+}
+
+func TestHashSymmetric(t *testing.T) {
+	a := common.IPv4Address{10, 0, 0, 1}
+	b := common.IPv4Address{192, 168, 7, 20}
+
+	forward := ipv4Frame(a, b, common.ProtocolTCP, 50000, 443, 0)
+	reverse := ipv4Frame(b, a, common.ProtocolTCP, 443, 50000, 0)
+	if h1, h2 := Hash(SymmetricKey, forward), Hash(SymmetricKey, reverse); h1 != h2 {
+		t.Errorf("Hash() = 0x%08x forward, 0x%08x reverse", h1, h2)
+	}
+
+	arpForward := ethernet.NewFrame(common.MACAddress{2, 0, 0, 0, 0, 1}, common.MACAddress{2, 0, 0, 0, 0, 2}, common.EtherTypeARP, nil)
+	arpReverse := ethernet.NewFrame(common.MACAddress{2, 0, 0, 0, 0, 2}, common.MACAddress{2, 0, 0, 0, 0, 1}, common.EtherTypeARP, nil)
+	if h1, h2 := Hash(SymmetricKey, arpForward), Hash(SymmetricKey, arpReverse); h1 != h2 {
+		t.Errorf("Hash() of ARP = 0x%08x forward, 0x%08x reverse", h1, h2)
+	}
+}
+
+func TestDispatchOrdering(t *testing.T) {
+	const flows, perFlow = 16, 50
+
+	var mu sync.Mutex
+	got := make(map[uint16][]uint32)
+	workerOf := make(map[uint16]int)
+	inUse := common.PooledBuffersInUse()
+	d, err := New(Config{Workers: 4, Policy: Block}, func(device string, frame *ethernet.Frame) error {
+		defer frame.Release()
+		port := binary.BigEndian.Uint16(frame.Payload[20:22])
+		seq := seqOf(frame)
+		mu.Lock()
+		defer mu.Unlock()
+		got[port] = append(got[port], seq)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	src := common.IPv4Address{10, 0, 0, 1}
+	dst := common.IPv4Address{10, 0, 0, 2}
+	for seq := uint32(0); seq < perFlow; seq++ {
+		for port := uint16(0); port < flows; port++ {
+			frame := ipv4Frame(src, dst, common.ProtocolTCP, 40000+port, 80, seq)
+			workerOf[40000+port] = d.Worker(frame)
+			if err := d.Dispatch("eth0", frame); err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+		}
+	}
+	d.Close()
+
+	// Every flow arrived complete and in order
+	used := make(map[int]bool)
+	for port := uint16(40000); port < 40000+flows; port++ {
+		seqs := got[port]
+		if len(seqs) != perFlow {
+			t.Fatalf("flow %d: got %d frames, want %d", port, len(seqs), perFlow)
+		}
+		for i, seq := range seqs {
+			if seq != uint32(i) {
+				t.Fatalf("flow %d: frame %d has seq %d", port, i, seq)
+			}
+		}
+		used[workerOf[port]] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("flows used %d workers, want them spread", len(used))
+	}
+
+	var processed uint64
+	for _, s := range d.Stats() {
+		processed += s.Processed
+	}
+	if processed != flows*perFlow {
+		t.Errorf("Processed = %d, want %d", processed, flows*perFlow)
+	}
+	if got := common.PooledBuffersInUse(); got != inUse {
+		t.Errorf("PooledBuffersInUse() = %d, want %d", got, inUse)
+	}
+
+	if err := d.Dispatch("eth0", ipv4Frame(src, dst, common.ProtocolTCP, 1, 2, 0)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Dispatch() after Close() error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestDropPolicies(t *testing.T) {
+	tests := []struct {
+		policy     DropPolicy
+		wantErr    error
+		wantSeqs   []uint32 // Frames processed after the first, which blocks the worker
+		wantQueued uint64
+	}{
+		{DropNewest, ErrQueueFull, []uint32{1, 2}, 3},
+		{DropOldest, nil, []uint32{3, 4}, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			started := make(chan struct{})
+			unblock := make(chan struct{})
+			var seqs []uint32
+			inUse := common.PooledBuffersInUse()
+			d, err := New(Config{Workers: 1, QueueDepth: 2, Policy: tt.policy}, func(device string, frame *ethernet.Frame) error {
+				defer frame.Release()
+				seq := seqOf(frame)
+				if seq == 0 {
+					close(started)
+					<-unblock
+					return nil
+				}
+				seqs = append(seqs, seq)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			src := common.IPv4Address{10, 0, 0, 1}
+			dst := common.IPv4Address{10, 0, 0, 2}
+			d.Dispatch("eth0", ipv4Frame(src, dst, common.ProtocolUDP, 1, 2, 0))
+			<-started
+
+			// Two frames fill the queue; the next two overflow it
+			var lastErr error
+			for seq := uint32(1); seq <= 4; seq++ {
+				if err := d.Dispatch("eth0", ipv4Frame(src, dst, common.ProtocolUDP, 1, 2, seq)); err != nil {
+					lastErr = err
+				}
+			}
+			if !errors.Is(lastErr, tt.wantErr) {
+				t.Errorf("Dispatch() error = %v, want %v", lastErr, tt.wantErr)
+			}
+
+			close(unblock)
+			d.Close()
+			if fmt.Sprint(seqs) != fmt.Sprint(tt.wantSeqs) {
+				t.Errorf("processed %v, want %v", seqs, tt.wantSeqs)
+			}
+			if stats := d.Stats()[0]; stats.Dropped != 2 || stats.Queued != tt.wantQueued {
+				t.Errorf("Stats() = %+v, want 2 dropped and %d queued", stats, tt.wantQueued)
+			}
+
+			// Dropped frames were released as well as processed ones
+			if got := common.PooledBuffersInUse(); got != inUse {
+				t.Errorf("PooledBuffersInUse() = %d, want %d", got, inUse)
+			}
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	handler := func(string, *ethernet.Frame) error { return nil }
+	tests := []struct {
+		name    string
+		config  Config
+		handler Handler
+	}{
+		{"no handler", Config{}, nil},
+		{"negative workers", Config{Workers: -1}, handler},
+		{"negative depth", Config{QueueDepth: -1}, handler},
+		{"bad policy", Config{Policy: Block + 1}, handler},
+		{"short key", Config{Key: make([]byte, MinKeyLength-1)}, handler},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config, tt.handler); err == nil {
+				t.Error("New() error = nil, want an error")
+			}
+		})
+	}
+}
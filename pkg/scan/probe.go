@@ -0,0 +1,155 @@
+package scan
+
+import (
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+// probe is a probe of a target.
+type probe struct {
+	kind   Probe
+	target common.IPv4Address
+	port   uint16 // Of a TCP probe
+}
+
+// reply is a reply to a probe.
+type reply struct {
+	probe Probe
+	from  common.IPv4Address
+	mac   common.MACAddress
+	port  uint16 // Of a TCP reply
+	open  bool   // A SYN-ACK
+}
+
+// probes returns the probes of a sweep of the targets. All ARP probes go
+// first, so that the other probes can be sent to the MAC addresses they
+// find.
+func (s *Scanner) probes(targets []common.IPv4Address) []probe {
+	var probes []probe
+	for _, kind := range []Probe{ProbeARP, ProbeICMP} {
+		if s.config.Probes&kind == 0 {
+			continue
+		}
+		for _, t := range targets {
+			probes = append(probes, probe{kind: kind, target: t})
+		}
+	}
+	if s.config.Probes&ProbeTCP != 0 {
+		for _, t := range targets {
+			for _, port := range s.config.Ports {
+				probes = append(probes, probe{kind: ProbeTCP, target: t, port: port})
+			}
+		}
+	}
+	return probes
+}
+
+// frame builds the frame of a probe. IP probes go to mac, or the gateway if
+// it is zero.
+func (s *Scanner) frame(p probe, mac common.MACAddress) (*ethernet.Frame, error) {
+	src := s.dev.MACAddress()
+	if p.kind == ProbeARP {
+		req := arp.NewRequest(src, s.config.Source, p.target)
+		return ethernet.NewFrame(common.BroadcastMAC, src, common.EtherTypeARP, req.Serialize()), nil
+	}
+	if mac == (common.MACAddress{}) {
+		mac = s.config.Gateway
+	}
+
+	var payload []byte
+	var proto common.Protocol
+	var err error
+	switch p.kind {
+	case ProbeICMP:
+		proto = common.ProtocolICMP
+		payload, err = icmp.NewEchoRequest(s.echoID, uint16(p.target.ToUint32()), nil).Serialize()
+	case ProbeTCP:
+		proto = common.ProtocolTCP
+		payload, err = s.segment(p.target, p.port, s.tcpSeq, tcp.FlagSYN)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := ip.NewPacket(s.config.Source, p.target, proto, payload).Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return ethernet.NewFrame(mac, src, common.EtherTypeIPv4, data), nil
+}
+
+// segment serializes a TCP segment from the probes' port to dst.
+func (s *Scanner) segment(dst common.IPv4Address, port uint16, seq uint32, flags uint8) ([]byte, error) {
+	seg := tcp.NewSegment(s.tcpPort, port, seq, 0, flags, 1024, nil)
+	checksum, err := seg.CalculateChecksum(s.config.Source, dst)
+	if err != nil {
+		return nil, err
+	}
+	seg.Checksum = checksum
+	return seg.Serialize()
+}
+
+// parseReply returns the reply to a probe a frame carries, if it does.
+// A SYN-ACK is answered with a reset, so the target does not keep the
+// half-open connection.
+func (s *Scanner) parseReply(frame *ethernet.Frame) (reply, bool) {
+	switch frame.EtherType {
+	case common.EtherTypeARP:
+		pkt, err := arp.Parse(frame.Payload)
+		if err != nil || !pkt.IsReply() || pkt.TargetIP != s.config.Source {
+			return reply{}, false
+		}
+		return reply{probe: ProbeARP, from: pkt.SenderIP, mac: pkt.SenderMAC}, true
+	case common.EtherTypeIPv4:
+	default:
+		return reply{}, false
+	}
+
+	pkt, err := ip.Parse(frame.Payload)
+	if err != nil || pkt.Destination != s.config.Source {
+		return reply{}, false
+	}
+	r := reply{from: pkt.Source, mac: frame.Source}
+	switch pkt.Protocol {
+	case common.ProtocolICMP:
+		msg, err := icmp.Parse(pkt.Payload)
+		if err != nil || !msg.IsEchoReply() || msg.ID != s.echoID {
+			return reply{}, false
+		}
+		r.probe = ProbeICMP
+	case common.ProtocolTCP:
+		var seg tcp.Segment
+		if err := seg.Decode(pkt.Payload); err != nil || seg.DestinationPort != s.tcpPort || !seg.HasFlag(tcp.FlagACK) ||
+			seg.AckNumber != s.tcpSeq+1 || !seg.VerifyChecksum(pkt.Source, pkt.Destination) {
+			return reply{}, false
+		}
+		switch {
+		case seg.HasFlag(tcp.FlagRST):
+		case seg.HasFlag(tcp.FlagSYN):
+			r.open = true
+			s.reset(frame.Source, pkt.Source, seg.SourcePort)
+		default:
+			return reply{}, false
+		}
+		r.probe, r.port = ProbeTCP, seg.SourcePort
+	default:
+		return reply{}, false
+	}
+	return r, true
+}
+
+// reset sends a reset for the connection a TCP probe opened.
+func (s *Scanner) reset(mac common.MACAddress, dst common.IPv4Address, port uint16) {
+	seg, err := s.segment(dst, port, s.tcpSeq+1, tcp.FlagRST)
+	if err != nil {
+		return
+	}
+	data, err := ip.NewPacket(s.config.Source, dst, common.ProtocolTCP, seg).Serialize()
+	if err != nil {
+		return
+	}
+	s.dev.WriteFrame(ethernet.NewFrame(mac, s.dev.MACAddress(), common.EtherTypeIPv4, data))
+}
@@ -0,0 +1,413 @@
+// Package scan discovers the hosts on a network by sweeping it with probes
+// sent on a raw interface: ARP requests, which every IPv4 host on the link
+// answers, and optionally ICMP Echo Requests and TCP SYNs, which also reach
+// hosts behind a router. Probes are paced at a fixed rate, and the hosts
+// found are reported with the vendor of their MAC address.
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+const (
+	// DefaultRate is the probe rate, in probes per second, if not
+	// configured.
+	DefaultRate = 200
+
+	// DefaultTimeout is how long replies are waited for after each sweep,
+	// if not configured.
+	DefaultTimeout = time.Second
+
+	// MaxHosts is the most addresses Hosts returns, a /16.
+	MaxHosts = 1 << 16
+)
+
+// DefaultPorts are the ports TCP probes are sent to, if not configured.
+var DefaultPorts = []uint16{22, 80, 443}
+
+// ErrNoTargets is returned by Scan when given no addresses.
+var ErrNoTargets = errors.New("scan: no targets")
+
+// Probe is a set of probe types.
+type Probe uint8
+
+// Probe types.
+const (
+	ProbeARP  Probe = 1 << iota // ARP request
+	ProbeICMP                   // ICMP Echo Request
+	ProbeTCP                    // TCP SYN to each port
+)
+
+var probeNames = []struct {
+	probe Probe
+	name  string
+}{
+	{ProbeARP, "arp"},
+	{ProbeICMP, "icmp"},
+	{ProbeTCP, "tcp"},
+}
+
+// String returns the names of the probes, comma separated.
+func (p Probe) String() string {
+	var names []string
+	for _, n := range probeNames {
+		if p&n.probe != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseProbes parses a comma separated list of probe names ("arp", "icmp"
+// and "tcp").
+func ParseProbes(s string) (Probe, error) {
+	var p Probe
+	for _, field := range strings.Split(s, ",") {
+		found := false
+		for _, n := range probeNames {
+			if strings.EqualFold(strings.TrimSpace(field), n.name) {
+				p |= n.probe
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown probe %q", field)
+		}
+	}
+	return p, nil
+}
+
+// Config configures a Scanner.
+type Config struct {
+	// Source is the address probes are sent from. It is required.
+	Source common.IPv4Address
+
+	// Probes are the probes sent to each target; 0 means ProbeARP.
+	Probes Probe
+
+	Rate    float64       // Probes per second; 0 means DefaultRate
+	Timeout time.Duration // 0 means DefaultTimeout
+
+	// Retries is how many more sweeps are made of the targets that have
+	// not answered.
+	Retries int
+
+	// Ports are the ports of TCP probes; nil means DefaultPorts.
+	Ports []uint16
+
+	// Gateway is the MAC address ICMP and TCP probes are sent to when the
+	// target's own is not known from an ARP reply, as for hosts behind a
+	// router. Zero means broadcast.
+	Gateway common.MACAddress
+}
+
+// Host is a host that answered a probe.
+type Host struct {
+	IP  common.IPv4Address
+	MAC common.MACAddress // Source of the first reply
+
+	// Vendor is the organization the MAC address is assigned to, if known.
+	Vendor string
+
+	Probes Probe    // Probes answered
+	Ports  []uint16 // Ports that answered a TCP probe with a SYN-ACK, sorted
+
+	// RTT is the time from the last probe sent to the host to its first
+	// reply.
+	RTT time.Duration
+}
+
+// String returns the host's addresses and vendor.
+func (h Host) String() string {
+	return fmt.Sprintf("%-15s %s", h.IP, h.MAC.StringWithVendor())
+}
+
+// Stats counts the probes and replies of a scan.
+type Stats struct {
+	Sent       int // Probes sent
+	SendErrors int // Probes that failed to send
+	Replies    int // Replies received, duplicates included
+}
+
+// Scanner sweeps networks for hosts. Once it has scanned, it reads all the
+// frames of the device until the device is closed, so nothing else may
+// read the device.
+type Scanner struct {
+	dev    ethernet.Device
+	config Config
+	now    func() time.Time // Replaced in tests
+
+	// Identifiers of the probes, so replies to other hosts' are ignored
+	echoID  uint16
+	tcpPort uint16
+	tcpSeq  uint32
+
+	scanning sync.Mutex // Held by a Scan
+	mu       sync.Mutex
+	reading  bool  // The device is read
+	current  *scan // The scan replies go to, or nil
+}
+
+// New creates a Scanner sending probes on dev.
+func New(dev ethernet.Device, config Config) (*Scanner, error) {
+	if config.Source == (common.IPv4Address{}) {
+		return nil, fmt.Errorf("scan: no source address")
+	}
+	if config.Rate < 0 || config.Timeout < 0 || config.Retries < 0 {
+		return nil, fmt.Errorf("scan: invalid config")
+	}
+	if config.Probes == 0 {
+		config.Probes = ProbeARP
+	}
+	if config.Rate == 0 {
+		config.Rate = DefaultRate
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Ports == nil {
+		config.Ports = DefaultPorts
+	}
+	if config.Gateway == (common.MACAddress{}) {
+		config.Gateway = common.BroadcastMAC
+	}
+	return &Scanner{
+		dev:     dev,
+		config:  config,
+		now:     time.Now,
+		echoID:  uint16(rand.Intn(0xffff) + 1),
+		tcpPort: uint16(49152 + rand.Intn(16384)),
+		tcpSeq:  rand.Uint32(),
+	}, nil
+}
+
+// Hosts returns the addresses of the hosts of an IPv4 network, without its
+// network and broadcast addresses unless it is a /31 or /32 (RFC 3021).
+// Networks larger than MaxHosts addresses are refused.
+func Hosts(network common.Prefix) ([]common.IPv4Address, error) {
+	if !network.Is4() {
+		return nil, fmt.Errorf("network %s is not IPv4", network)
+	}
+	size := network.Size()
+	if size > MaxHosts {
+		return nil, fmt.Errorf("network %s has more than %d addresses", network, MaxHosts)
+	}
+	hosts := make([]common.IPv4Address, 0, size)
+	network.Iterate(func(addr common.IPv4Address) bool {
+		hosts = append(hosts, addr)
+		return true
+	})
+	if size > 2 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+// scan is the state of a Scan.
+type scan struct {
+	mu      sync.Mutex
+	targets map[common.IPv4Address]*Host // nil until the target answers
+	sent    map[common.IPv4Address]time.Time
+	stats   Stats
+}
+
+// Scan probes the targets and returns the hosts that answered, sorted by
+// address. Probes are paced at the configured rate; after each sweep
+// replies are waited for the configured timeout, and then the targets
+// still silent are swept again, up to the configured retries. If ctx is
+// done first, the hosts found so far are returned with ctx's error.
+func (s *Scanner) Scan(ctx context.Context, targets []common.IPv4Address) ([]Host, Stats, error) {
+	if len(targets) == 0 {
+		return nil, Stats{}, ErrNoTargets
+	}
+	s.scanning.Lock()
+	defer s.scanning.Unlock()
+
+	sc := &scan{
+		targets: make(map[common.IPv4Address]*Host, len(targets)),
+		sent:    make(map[common.IPv4Address]time.Time, len(targets)),
+	}
+	for _, t := range targets {
+		sc.targets[t] = nil
+	}
+	s.mu.Lock()
+	s.current = sc
+	if !s.reading {
+		s.reading = true
+		go s.receive()
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.current = nil
+		s.mu.Unlock()
+	}()
+
+	var err error
+	interval := time.Duration(float64(time.Second) / s.config.Rate)
+	next := s.now()
+	for sweep := 0; sweep <= s.config.Retries && err == nil; sweep++ {
+		silent := sc.silent(targets)
+		if len(silent) == 0 {
+			break
+		}
+		for _, p := range s.probes(silent) {
+			if err = wait(ctx, next.Sub(s.now())); err != nil {
+				break
+			}
+			next = next.Add(interval)
+			sc.send(s, p)
+		}
+		if err == nil {
+			err = wait(ctx, s.config.Timeout)
+			next = s.now()
+		}
+	}
+	return sc.hosts(), sc.getStats(), err
+}
+
+// wait waits for d, or until ctx is done.
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// receive handles the frames read from the device, until it fails.
+// Frames read between scans are dropped.
+func (s *Scanner) receive() {
+	for {
+		frame, err := s.dev.ReadFrame()
+		if err != nil {
+			s.mu.Lock()
+			s.reading = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Lock()
+		sc := s.current
+		s.mu.Unlock()
+		if sc != nil {
+			if r, ok := s.parseReply(frame); ok {
+				sc.reply(s, r)
+			}
+		}
+		frame.Release()
+	}
+}
+
+// silent returns the targets that have not answered, in order.
+func (sc *scan) silent(targets []common.IPv4Address) []common.IPv4Address {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	var silent []common.IPv4Address
+	for _, t := range targets {
+		if sc.targets[t] == nil {
+			silent = append(silent, t)
+		}
+	}
+	return silent
+}
+
+// send transmits a probe.
+func (sc *scan) send(s *Scanner, p probe) {
+	frame, err := s.frame(p, sc.mac(p.target))
+	if err == nil {
+		err = s.dev.WriteFrame(frame)
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if err != nil {
+		sc.stats.SendErrors++
+		return
+	}
+	sc.stats.Sent++
+	sc.sent[p.target] = s.now()
+}
+
+// mac returns the MAC address a probe to target is sent to: the target's
+// own if it answered an ARP probe, or the gateway's.
+func (sc *scan) mac(target common.IPv4Address) common.MACAddress {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if h := sc.targets[target]; h != nil && h.Probes&ProbeARP != 0 {
+		return h.MAC
+	}
+	return common.MACAddress{}
+}
+
+// reply records a reply to a probe.
+func (sc *scan) reply(s *Scanner, r reply) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	h, ok := sc.targets[r.from]
+	if !ok {
+		return
+	}
+	sc.stats.Replies++
+	if h == nil {
+		h = &Host{IP: r.from, RTT: s.now().Sub(sc.sent[r.from])}
+		sc.targets[r.from] = h
+	}
+	// An ARP reply comes from the host itself, rather than a router an IP
+	// reply may have come through
+	if h.MAC == (common.MACAddress{}) || r.probe == ProbeARP {
+		h.MAC, h.Vendor = r.mac, vendor(r.mac)
+	}
+	h.Probes |= r.probe
+	if r.open {
+		i := sort.Search(len(h.Ports), func(i int) bool { return h.Ports[i] >= r.port })
+		if i == len(h.Ports) || h.Ports[i] != r.port {
+			h.Ports = append(h.Ports[:i], append([]uint16{r.port}, h.Ports[i:]...)...)
+		}
+	}
+}
+
+// vendor returns the vendor of a MAC address, or "" if it is unknown.
+func vendor(mac common.MACAddress) string {
+	v, _ := common.LookupVendor(mac)
+	return v
+}
+
+// hosts returns the hosts that answered, sorted by address.
+func (sc *scan) hosts() []Host {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	var hosts []Host
+	for _, h := range sc.targets {
+		if h != nil {
+			host := *h
+			host.Ports = append([]uint16(nil), h.Ports...)
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].IP.ToUint32() < hosts[j].IP.ToUint32() })
+	return hosts
+}
+
+func (sc *scan) getStats() Stats {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.stats
+}
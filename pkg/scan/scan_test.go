@@ -0,0 +1,338 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+var localIP = common.IPv4Address{192, 168, 1, 1}
+
+// host is a simulated host on the far end of the link.
+type host struct {
+	mac      common.MACAddress
+	noARP    bool // Behind a router: answers IP probes only
+	noICMP   bool
+	open     []uint16
+	answered int // Times to stay silent before answering
+}
+
+// network answers the probes it receives for its hosts.
+type network struct {
+	dev   *memory.Endpoint
+	hosts map[common.IPv4Address]*host
+
+	mu     sync.Mutex
+	resets []uint16 // Ports reset by the scanner
+}
+
+func newScanner(t *testing.T, config Config, hosts map[common.IPv4Address]*host) (*Scanner, *network) {
+	t.Helper()
+	a, b := memory.NewPipe(memory.Config{})
+	t.Cleanup(func() { a.Close() })
+	if config.Source == (common.IPv4Address{}) {
+		config.Source = localIP
+	}
+	if config.Rate == 0 {
+		config.Rate = 10000
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 20 * time.Millisecond
+	}
+	s, err := New(a, config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	n := &network{dev: b, hosts: hosts}
+	go n.run()
+	return s, n
+}
+
+func (n *network) run() {
+	for {
+		frame, err := n.dev.ReadFrame()
+		if err != nil {
+			return
+		}
+		n.handle(frame)
+	}
+}
+
+func (n *network) handle(frame *ethernet.Frame) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if frame.EtherType == common.EtherTypeARP {
+		req, err := arp.Parse(frame.Payload)
+		if err != nil {
+			return
+		}
+		h := n.hosts[req.TargetIP]
+		if h == nil || h.noARP || !n.answer(h) {
+			return
+		}
+		rep := arp.NewReply(h.mac, req.TargetIP, req.SenderMAC, req.SenderIP)
+		n.dev.WriteFrame(ethernet.NewFrame(frame.Source, h.mac, common.EtherTypeARP, rep.Serialize()))
+		return
+	}
+
+	pkt, err := ip.Parse(frame.Payload)
+	if err != nil {
+		return
+	}
+	h := n.hosts[pkt.Destination]
+	if h == nil {
+		return
+	}
+	var out []byte
+	switch pkt.Protocol {
+	case common.ProtocolICMP:
+		req, err := icmp.Parse(pkt.Payload)
+		if err != nil || h.noICMP || !n.answer(h) {
+			return
+		}
+		out, _ = icmp.NewEchoReply(req.ID, req.Sequence, req.Data).Serialize()
+	case common.ProtocolTCP:
+		seg, _ := tcp.Parse(pkt.Payload)
+		if seg.HasFlag(tcp.FlagRST) {
+			n.resets = append(n.resets, seg.DestinationPort)
+			return
+		}
+		if !n.answer(h) {
+			return
+		}
+		flags := tcp.FlagRST | tcp.FlagACK
+		for _, port := range h.open {
+			if port == seg.DestinationPort {
+				flags = tcp.FlagSYN | tcp.FlagACK
+			}
+		}
+		rep := tcp.NewSegment(seg.DestinationPort, seg.SourcePort, 1000, seg.SequenceNumber+1, flags, 1024, nil)
+		rep.Checksum, _ = rep.CalculateChecksum(pkt.Destination, pkt.Source)
+		out, _ = rep.Serialize()
+	default:
+		return
+	}
+	data, _ := ip.NewPacket(pkt.Destination, pkt.Source, pkt.Protocol, out).Serialize()
+	n.dev.WriteFrame(ethernet.NewFrame(frame.Source, h.mac, common.EtherTypeIPv4, data))
+}
+
+// answer reports whether a host answers a probe. Must be called with n.mu
+// held.
+func (n *network) answer(h *host) bool {
+	if h.answered > 0 {
+		h.answered--
+		return false
+	}
+	return true
+}
+
+func addrs(last ...byte) []common.IPv4Address {
+	var a []common.IPv4Address
+	for _, b := range last {
+		a = append(a, common.IPv4Address{192, 168, 1, b})
+	}
+	return a
+}
+
+var (
+	vmwareMAC = common.MACAddress{0x00, 0x50, 0x56, 0x01, 0x02, 0x03}
+	localMAC  = common.MACAddress{0x02, 0x00, 0x00, 0x00, 0x00, 0x09}
+)
+
+func TestScanARP(t *testing.T) {
+	s, _ := newScanner(t, Config{}, map[common.IPv4Address]*host{
+		addrs(2)[0]: {mac: vmwareMAC},
+		addrs(5)[0]: {mac: localMAC},
+	})
+
+	hosts, stats, err := s.Scan(context.Background(), addrs(2, 3, 4, 5))
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	want := []Host{
+		{IP: addrs(2)[0], MAC: vmwareMAC, Vendor: "VMware", Probes: ProbeARP},
+		{IP: addrs(5)[0], MAC: localMAC, Probes: ProbeARP},
+	}
+	for i := range hosts {
+		if hosts[i].RTT <= 0 {
+			t.Errorf("host %s RTT = %v, want positive", hosts[i].IP, hosts[i].RTT)
+		}
+		hosts[i].RTT = 0
+	}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("Scan() = %+v, want %+v", hosts, want)
+	}
+	if stats.Sent != 4 || stats.Replies != 2 || stats.SendErrors != 0 {
+		t.Errorf("Scan() stats = %+v", stats)
+	}
+}
+
+func TestScanProbes(t *testing.T) {
+	s, n := newScanner(t, Config{Probes: ProbeARP | ProbeICMP | ProbeTCP, Ports: []uint16{22, 80}},
+		map[common.IPv4Address]*host{
+			addrs(2)[0]: {mac: vmwareMAC, open: []uint16{80}},
+			addrs(3)[0]: {mac: localMAC, noARP: true, noICMP: true, open: []uint16{22, 80}},
+			addrs(4)[0]: {mac: localMAC, noARP: true},
+		})
+
+	hosts, _, err := s.Scan(context.Background(), addrs(2, 3, 4, 6))
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if len(hosts) != 3 {
+		t.Fatalf("Scan() = %+v, want 3 hosts", hosts)
+	}
+	tests := []struct {
+		probes Probe
+		ports  []uint16
+	}{
+		{ProbeARP | ProbeICMP | ProbeTCP, []uint16{80}},
+		{ProbeTCP, []uint16{22, 80}},
+		{ProbeICMP | ProbeTCP, nil},
+	}
+	for i, tt := range tests {
+		if h := hosts[i]; h.Probes != tt.probes || !reflect.DeepEqual(h.Ports, tt.ports) {
+			t.Errorf("host %s answered %s on ports %v, want %s on %v", h.IP, h.Probes, h.Ports, tt.probes, tt.ports)
+		}
+	}
+
+	// Open connections are reset
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.resets) != 3 {
+		t.Errorf("%d connections reset, want 3", len(n.resets))
+	}
+}
+
+func TestScanRetries(t *testing.T) {
+	tests := []struct {
+		retries int
+		found   int
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 1},
+	}
+	for _, tt := range tests {
+		hosts := map[common.IPv4Address]*host{addrs(2)[0]: {mac: localMAC, answered: 2}}
+		s, _ := newScanner(t, Config{Retries: tt.retries}, hosts)
+		found, stats, err := s.Scan(context.Background(), addrs(2))
+		if err != nil {
+			t.Fatalf("Scan() error = %v", err)
+		}
+		if len(found) != tt.found || stats.Sent != tt.retries+1 {
+			t.Errorf("Retries %d: found %d hosts with %d probes, want %d with %d", tt.retries, len(found), stats.Sent, tt.found, tt.retries+1)
+		}
+	}
+}
+
+func TestScanRate(t *testing.T) {
+	s, _ := newScanner(t, Config{Rate: 500, Timeout: time.Millisecond}, nil)
+	start := time.Now()
+	if _, stats, err := s.Scan(context.Background(), addrs(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)); err != nil || stats.Sent != 11 {
+		t.Fatalf("Scan() = %+v, %v", stats, err)
+	}
+	// 11 probes at 2ms intervals
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Scan() took %v, want at least 20ms at 500 probes/s", elapsed)
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	s, _ := newScanner(t, Config{Rate: 10}, map[common.IPv4Address]*host{addrs(1)[0]: {mac: localMAC}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	network, _ := common.ParsePrefix("192.168.1.0/24")
+	targets, _ := Hosts(network)
+	hosts, stats, err := s.Scan(ctx, targets)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Scan() error = %v, want DeadlineExceeded", err)
+	}
+	if len(hosts) != 1 || stats.Sent >= len(targets) {
+		t.Errorf("Scan() = %d hosts after %d probes", len(hosts), stats.Sent)
+	}
+
+	// The scanner can scan again
+	if _, _, err := s.Scan(context.Background(), nil); !errors.Is(err, ErrNoTargets) {
+		t.Errorf("Scan() error = %v, want ErrNoTargets", err)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	tests := []struct {
+		network     string
+		first, last common.IPv4Address
+		n           int
+	}{
+		{"10.0.0.77/24", common.IPv4Address{10, 0, 0, 1}, common.IPv4Address{10, 0, 0, 254}, 254},
+		{"10.0.0.4/30", common.IPv4Address{10, 0, 0, 5}, common.IPv4Address{10, 0, 0, 6}, 2},
+		{"10.0.0.4/31", common.IPv4Address{10, 0, 0, 4}, common.IPv4Address{10, 0, 0, 5}, 2},
+		{"10.0.0.4/32", common.IPv4Address{10, 0, 0, 4}, common.IPv4Address{10, 0, 0, 4}, 1},
+		{"10.1.2.3/16", common.IPv4Address{10, 1, 0, 1}, common.IPv4Address{10, 1, 255, 254}, MaxHosts - 2},
+	}
+	for _, tt := range tests {
+		network, err := common.ParsePrefix(tt.network)
+		if err != nil {
+			t.Fatalf("ParsePrefix(%q) error = %v", tt.network, err)
+		}
+		hosts, err := Hosts(network)
+		if err != nil {
+			t.Fatalf("Hosts(%s) error = %v", network, err)
+		}
+		if len(hosts) != tt.n || hosts[0] != tt.first || hosts[len(hosts)-1] != tt.last {
+			t.Errorf("Hosts(%s) = %d hosts %s to %s, want %d %s to %s", network,
+				len(hosts), hosts[0], hosts[len(hosts)-1], tt.n, tt.first, tt.last)
+		}
+	}
+
+	for _, s := range []string{"10.0.0.0/15", "2001:db8::/120"} {
+		network, _ := common.ParsePrefix(s)
+		if _, err := Hosts(network); err == nil {
+			t.Errorf("Hosts(%s) succeeded, want error", network)
+		}
+	}
+}
+
+func TestParseProbes(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Probe
+	}{
+		{"arp", ProbeARP},
+		{"ICMP,tcp", ProbeICMP | ProbeTCP},
+		{"arp, icmp, tcp", ProbeARP | ProbeICMP | ProbeTCP},
+	}
+	for _, tt := range tests {
+		got, err := ParseProbes(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseProbes(%q) = %s, %v, want %s", tt.s, got, err, tt.want)
+		}
+		if back, _ := ParseProbes(got.String()); back != got {
+			t.Errorf("ParseProbes(%q) = %s", got.String(), back)
+		}
+	}
+	if _, err := ParseProbes("udp"); err == nil {
+		t.Error("ParseProbes(\"udp\") succeeded, want error")
+	}
+}
+
+func TestNew(t *testing.T) {
+	a, _ := memory.NewPipe(memory.Config{})
+	defer a.Close()
+	for _, config := range []Config{{}, {Source: localIP, Rate: -1}, {Source: localIP, Retries: -1}} {
+		if _, err := New(a, config); err == nil {
+			t.Errorf("New(%+v) succeeded, want error", config)
+		}
+	}
+}
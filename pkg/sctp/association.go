@@ -0,0 +1,682 @@
+package sctp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+var (
+	// ErrEndpointClosed is returned for operations on a closed endpoint.
+	ErrEndpointClosed = errors.New("endpoint closed")
+
+	// ErrAborted is returned once an association was aborted, by either
+	// side.
+	ErrAborted = errors.New("association aborted")
+
+	// ErrTimeout is returned once an association failed because the peer
+	// stopped acknowledging what it sent.
+	ErrTimeout = errors.New("association timed out")
+
+	// ErrSendBufferFull is returned by Send when the message does not fit
+	// in the send buffer.
+	ErrSendBufferFull = errors.New("send buffer full")
+)
+
+// State is the state of an association (RFC 9260 Section 4).
+type State uint8
+
+const (
+	StateClosed State = iota
+	StateCookieWait
+	StateCookieEchoed
+	StateEstablished
+	StateShutdownPending
+	StateShutdownSent
+	StateShutdownReceived
+	StateShutdownAckSent
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "CLOSED"
+	case StateCookieWait:
+		return "COOKIE-WAIT"
+	case StateCookieEchoed:
+		return "COOKIE-ECHOED"
+	case StateEstablished:
+		return "ESTABLISHED"
+	case StateShutdownPending:
+		return "SHUTDOWN-PENDING"
+	case StateShutdownSent:
+		return "SHUTDOWN-SENT"
+	case StateShutdownReceived:
+		return "SHUTDOWN-RECEIVED"
+	case StateShutdownAckSent:
+		return "SHUTDOWN-ACK-SENT"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(s))
+	}
+}
+
+// Message is a user message sent or received on a stream.
+type Message struct {
+	Stream    uint16
+	PPID      uint32 // Payload protocol identifier, opaque to SCTP
+	Unordered bool   // Delivered as soon as it arrives, ahead of earlier messages
+	Data      []byte
+}
+
+// Association is an SCTP association with a peer endpoint.
+type Association struct {
+	ep         *Endpoint
+	remote     common.IPv4Address
+	remotePort uint16
+
+	mu      sync.Mutex
+	state   State
+	err     error   // Why the association closed
+	out     []Chunk // Chunks to send once mu is released
+	myTag   uint32
+	peerTag uint32
+
+	// Streams
+	outStreams uint16
+	inStreams  uint16
+	ssn        []uint16 // Next stream sequence number per outbound stream
+
+	// Sending
+	nextTSN     uint32
+	cumAck      uint32 // Highest TSN the peer acknowledged cumulatively
+	sendQueue   []*dataChunk
+	outstanding []*sentChunk // Sent and not acknowledged cumulatively, by TSN
+	sendQueued  int          // Bytes of data queued or outstanding
+	flightSize  int          // Bytes of data in flight
+	peerRwnd    uint32       // Peer's receive window less the data in flight
+
+	// Congestion control (RFC 9260 Section 7.2)
+	cwnd              int
+	ssthresh          int
+	partialBytesAcked int
+	fastRecovery      bool
+	recoveryExit      uint32 // TSN whose acknowledgment ends fast recovery
+
+	// Receiving
+	rcvCumTSN   uint32              // Highest TSN received in sequence
+	rcvAbove    map[uint32]struct{} // TSNs received after rcvCumTSN
+	rcvDups     []uint32            // Duplicate TSNs to report in the next SACK
+	frags       map[uint32]*dataChunk
+	nextSSN     []uint16 // Next stream sequence number to deliver per inbound stream
+	reorder     []map[uint16]Message
+	inbox       []Message
+	rcvQueued   int    // Bytes of data received and not read
+	advertised  uint32 // Receive window in the last SACK
+	sackNeeded  bool   // A SACK goes in the next packet
+	dataPackets int    // Packets with DATA since the last SACK
+	peerDone    bool   // The peer shut down: no more data comes
+
+	// Round-trip time and timers (RFC 9260 Section 6.3)
+	srtt, rttvar, rto time.Duration
+	t1, t2, t3        time.Time // Init, shutdown and retransmission timers; zero when stopped
+	sackAt            time.Time // When a delayed SACK is due
+	heartbeatAt       time.Time
+	heartbeatNonce    uint64 // Nonce of the unacknowledged HEARTBEAT, if any
+	initRetries       int
+	errorCount        int
+	initChunk         Chunk // INIT or COOKIE-ECHO retransmitted by T1
+
+	readable    chan struct{} // Signaled when a message arrives or the association closes
+	established chan struct{}
+	done        chan struct{}
+}
+
+// sentChunk is a DATA chunk sent and not acknowledged cumulatively.
+type sentChunk struct {
+	*dataChunk
+	sent              time.Time
+	transmissions     int
+	acked             bool // Acknowledged by a gap block
+	retransmit        bool // Marked for retransmission, and not in flight
+	missing           int  // SACKs that reported it missing
+	fastRetransmitted bool
+}
+
+// newAssociation creates an association with remote and port, in the
+// CLOSED state.
+func newAssociation(e *Endpoint, remote common.IPv4Address, port uint16, tag, tsn uint32) *Association {
+	mtu := e.config.MTU - ipv4HeaderLength
+	return &Association{
+		ep:          e,
+		remote:      remote,
+		remotePort:  port,
+		myTag:       tag,
+		nextTSN:     tsn,
+		cumAck:      tsn - 1,
+		cwnd:        min(4*mtu, max(2*mtu, 4380)),
+		ssthresh:    int(e.config.ReceiveWindow),
+		rto:         e.config.RTOInitial,
+		rcvAbove:    make(map[uint32]struct{}),
+		frags:       make(map[uint32]*dataChunk),
+		advertised:  e.config.ReceiveWindow,
+		readable:    make(chan struct{}, 1),
+		established: make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// RemoteAddr returns the peer's address and port.
+func (a *Association) RemoteAddr() (common.IPv4Address, uint16) {
+	return a.remote, a.remotePort
+}
+
+// State returns the state of the association.
+func (a *Association) State() State {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state
+}
+
+// Streams returns the number of outbound and inbound streams negotiated.
+func (a *Association) Streams() (outbound, inbound uint16) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.outStreams, a.inStreams
+}
+
+// Done returns a channel closed once the association is closed.
+func (a *Association) Done() <-chan struct{} {
+	return a.done
+}
+
+// Err returns why the association closed: nil after a graceful shutdown,
+// or while it is open.
+func (a *Association) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// closeErr returns the error of operations on the closed association.
+func (a *Association) closeErr() error {
+	if err := a.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// Send sends a message on a stream. Messages larger than a packet are
+// fragmented. Send does not wait for the message to be acknowledged, nor
+// for space in the send buffer: it fails with ErrSendBufferFull if the
+// message does not fit.
+func (a *Association) Send(m Message) error {
+	a.mu.Lock()
+	defer a.unlock()
+
+	if a.state != StateEstablished {
+		if a.state == StateClosed && a.err != nil {
+			return a.err
+		}
+		return fmt.Errorf("cannot send in state %s", a.state)
+	}
+	if m.Stream >= a.outStreams {
+		return fmt.Errorf("stream %d, association has %d outbound streams", m.Stream, a.outStreams)
+	}
+	if len(m.Data) == 0 {
+		return fmt.Errorf("empty message")
+	}
+	if a.sendQueued+len(m.Data) > a.ep.config.SendBuffer {
+		return fmt.Errorf("%w: %d bytes queued, limit %d", ErrSendBufferFull, a.sendQueued, a.ep.config.SendBuffer)
+	}
+
+	var ssn uint16
+	if !m.Unordered {
+		ssn = a.ssn[m.Stream]
+		a.ssn[m.Stream]++
+	}
+	maxData := a.maxPacket() - HeaderLength - dataChunkOverhead
+	for off := 0; off < len(m.Data); off += maxData {
+		end := min(off+maxData, len(m.Data))
+		a.sendQueue = append(a.sendQueue, &dataChunk{
+			stream:    m.Stream,
+			ssn:       ssn,
+			ppid:      m.PPID,
+			unordered: m.Unordered,
+			begin:     off == 0,
+			end:       end == len(m.Data),
+			data:      append([]byte(nil), m.Data[off:end]...),
+		})
+	}
+	a.sendQueued += len(m.Data)
+	a.flush()
+	return nil
+}
+
+// Recv returns the next message received, waiting until there is one or
+// ctx is done. It returns io.EOF once the peer has shut the association
+// down and every message was read.
+func (a *Association) Recv(ctx context.Context) (Message, error) {
+	for {
+		a.mu.Lock()
+		if len(a.inbox) > 0 {
+			m := a.inbox[0]
+			a.inbox = a.inbox[1:]
+			a.rcvQueued -= len(m.Data)
+			// Tell the peer once the window has opened by a packet
+			if a.state != StateClosed && a.rwnd() >= a.advertised+uint32(a.maxPacket()) {
+				a.sackNeeded = true
+				a.flush()
+			}
+			a.unlock()
+			return m, nil
+		}
+		if a.peerDone || a.state == StateClosed {
+			a.mu.Unlock()
+			return Message{}, a.closeErr()
+		}
+		a.mu.Unlock()
+
+		select {
+		case <-a.readable:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// Close shuts the association down gracefully: once the data sent is
+// acknowledged, the peer is told no more follows and the association
+// closes. Close does not wait for that; Done does. An association not yet
+// established is aborted.
+func (a *Association) Close() error {
+	a.mu.Lock()
+	defer a.unlock()
+
+	switch a.state {
+	case StateCookieWait, StateCookieEchoed:
+		a.emit(abortChunk("", false))
+		a.close(ErrAborted)
+	case StateEstablished:
+		a.state = StateShutdownPending
+		a.checkShutdown()
+	}
+	return nil
+}
+
+// Abort aborts the association at once, discarding the data not yet
+// acknowledged. The peer is told reason.
+func (a *Association) Abort(reason string) {
+	a.mu.Lock()
+	defer a.unlock()
+
+	if a.state == StateClosed {
+		return
+	}
+	if a.state != StateCookieWait {
+		a.emit(abortChunk(reason, false))
+	}
+	a.close(ErrAborted)
+}
+
+// unlock releases a.mu and sends the chunks queued with it held, bundled
+// into as few packets as fit.
+func (a *Association) unlock() {
+	out, tag := a.out, a.peerTag
+	a.out = nil
+	a.mu.Unlock()
+
+	maxPacket := a.maxPacket()
+	for len(out) > 0 {
+		pkt := &Packet{
+			SourcePort:      a.ep.config.Port,
+			DestinationPort: a.remotePort,
+			VerificationTag: tag,
+		}
+		size := HeaderLength
+		for len(out) > 0 && (len(pkt.Chunks) == 0 || size+padded(chunkHeaderLength+len(out[0].Value)) <= maxPacket) {
+			size += padded(chunkHeaderLength + len(out[0].Value))
+			pkt.Chunks = append(pkt.Chunks, out[0])
+			out = out[1:]
+		}
+		a.ep.transmit(pkt, a.remote)
+	}
+}
+
+// emit queues a chunk to send once a.mu is released.
+func (a *Association) emit(c Chunk) {
+	a.out = append(a.out, c)
+}
+
+// maxPacket returns the largest SCTP packet sent.
+func (a *Association) maxPacket() int {
+	return a.ep.config.MTU - ipv4HeaderLength
+}
+
+// sendInit sends the INIT of an association being connected.
+func (a *Association) sendInit() {
+	init := &initChunk{
+		initiateTag: a.myTag,
+		arwnd:       a.ep.config.ReceiveWindow,
+		outStreams:  a.ep.config.OutboundStreams,
+		inStreams:   a.ep.config.MaxInboundStreams,
+		initialTSN:  a.nextTSN,
+	}
+	a.state = StateCookieWait
+	a.initChunk = init.chunk(ChunkInit)
+	a.emit(a.initChunk)
+	a.t1 = a.ep.now().Add(a.rto)
+}
+
+// setPeer sets the state of an association from the peer's INIT or
+// INIT-ACK.
+func (a *Association) setPeer(peerTag, peerTSN, peerRwnd uint32, outStreams, inStreams uint16) {
+	a.peerTag = peerTag
+	a.rcvCumTSN = peerTSN - 1
+	a.peerRwnd = peerRwnd
+	a.outStreams, a.inStreams = outStreams, inStreams
+	a.ssn = make([]uint16, outStreams)
+	a.nextSSN = make([]uint16, inStreams)
+	a.reorder = make([]map[uint16]Message, inStreams)
+}
+
+// setEstablished moves the association to ESTABLISHED once the handshake
+// is done.
+func (a *Association) setEstablished() {
+	a.state = StateEstablished
+	a.t1 = time.Time{}
+	a.heartbeatAt = a.ep.now().Add(a.rto + a.ep.config.HeartbeatInterval)
+	close(a.established)
+}
+
+// close closes the association with err, nil for a graceful shutdown.
+func (a *Association) close(err error) {
+	if a.state == StateClosed && a.err != nil {
+		return
+	}
+	a.state = StateClosed
+	a.err = err
+	if errors.Is(err, ErrAborted) {
+		counters.aborted.Add(1)
+	}
+	a.t1, a.t2, a.t3, a.sackAt, a.heartbeatAt = time.Time{}, time.Time{}, time.Time{}, time.Time{}, time.Time{}
+	a.sendQueue, a.outstanding = nil, nil
+	select {
+	case <-a.done:
+	default:
+		close(a.done)
+	}
+	a.signal()
+	a.ep.remove(a)
+}
+
+// signal wakes a Recv waiting for a message.
+func (a *Association) signal() {
+	select {
+	case a.readable <- struct{}{}:
+	default:
+	}
+}
+
+// handle processes a packet from the peer.
+func (a *Association) handle(pkt *Packet) error {
+	a.mu.Lock()
+	defer a.unlock()
+
+	if err := a.verifyTag(pkt); err != nil {
+		counters.badTags.Add(1)
+		return err
+	}
+	hadData := false
+	immediate := false
+	for _, c := range pkt.Chunks {
+		if c.Type != ChunkData {
+			counters.controlChunksReceived.Add(1)
+		}
+		var err error
+		switch c.Type {
+		case ChunkData:
+			hadData = true
+			immediate = a.handleData(c) || immediate
+		case ChunkInitAck:
+			err = a.handleInitAck(c)
+		case ChunkCookieEcho:
+			// A retransmission: the COOKIE-ACK was lost
+			if a.state != StateCookieWait && a.state != StateClosed {
+				a.emit(Chunk{Type: ChunkCookieAck})
+			}
+		case ChunkCookieAck:
+			if a.state == StateCookieEchoed {
+				a.setEstablished()
+				counters.activeEstablishes.Add(1)
+			}
+		case ChunkSACK:
+			var s *sackChunk
+			if s, err = parseSACK(c); err == nil {
+				a.handleSACK(s)
+			}
+		case ChunkHeartbeat:
+			a.emit(Chunk{Type: ChunkHeartbeatAck, Value: c.Value})
+		case ChunkHeartbeatAck:
+			a.handleHeartbeatAck(c)
+		case ChunkAbort:
+			reason := abortReason(c)
+			if reason != "" {
+				a.close(fmt.Errorf("%w by peer: %s", ErrAborted, reason))
+			} else {
+				a.close(fmt.Errorf("%w by peer", ErrAborted))
+			}
+			return nil
+		case ChunkShutdown:
+			var cum uint32
+			if cum, err = parseShutdown(c); err == nil {
+				a.handleShutdown(cum)
+			}
+		case ChunkShutdownAck:
+			if a.state == StateShutdownSent || a.state == StateShutdownAckSent {
+				a.emit(Chunk{Type: ChunkShutdownComplete})
+				a.peerDone = true
+				counters.shutdowns.Add(1)
+				a.close(nil)
+			}
+		case ChunkShutdownComplete:
+			if a.state == StateShutdownAckSent {
+				counters.shutdowns.Add(1)
+				a.close(nil)
+			}
+			return nil
+		case ChunkInit, ChunkError:
+			// Restarts and collisions (RFC 9260 Section 5.2) are not
+			// handled; errors are only reported by peers here
+		default:
+			if uint8(c.Type)&chunkSkip == 0 {
+				return fmt.Errorf("unknown chunk type %d", c.Type)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if a.state == StateClosed {
+			return nil
+		}
+	}
+	if hadData {
+		a.dataPackets++
+		if immediate || a.dataPackets >= 2 {
+			a.sackNeeded = true
+		} else if a.sackAt.IsZero() {
+			a.sackAt = a.ep.now().Add(delayedSACK)
+		}
+	}
+	a.flush()
+	return nil
+}
+
+// verifyTag checks the verification tag of a packet (RFC 9260 Section
+// 8.5).
+func (a *Association) verifyTag(pkt *Packet) error {
+	first := pkt.Chunks[0]
+	switch {
+	case first.Type == ChunkInit:
+		if pkt.VerificationTag == 0 {
+			return nil
+		}
+	case (first.Type == ChunkAbort || first.Type == ChunkShutdownComplete) && first.Flags&flagReflected != 0:
+		if pkt.VerificationTag == a.peerTag && a.peerTag != 0 {
+			return nil
+		}
+	case pkt.VerificationTag == a.myTag:
+		return nil
+	}
+	return fmt.Errorf("%s with verification tag %d", first.Type, pkt.VerificationTag)
+}
+
+// handleInitAck processes the INIT-ACK answering the INIT, and echoes its
+// cookie.
+func (a *Association) handleInitAck(c Chunk) error {
+	if a.state != StateCookieWait {
+		return nil
+	}
+	init, err := parseInit(c)
+	if err != nil {
+		return err
+	}
+	outStreams := min(a.ep.config.OutboundStreams, init.inStreams)
+	inStreams := min(a.ep.config.MaxInboundStreams, init.outStreams)
+	a.setPeer(init.initiateTag, init.initialTSN, init.arwnd, outStreams, inStreams)
+	a.state = StateCookieEchoed
+
+	a.initChunk = Chunk{Type: ChunkCookieEcho, Value: init.cookie}
+	a.initRetries = 0
+	a.emit(a.initChunk)
+	a.t1 = a.ep.now().Add(a.rto)
+	return nil
+}
+
+// handleShutdown processes a SHUTDOWN acknowledging cum.
+func (a *Association) handleShutdown(cum uint32) {
+	switch a.state {
+	case StateEstablished, StateShutdownPending, StateShutdownSent, StateShutdownReceived:
+	default:
+		return
+	}
+	if !seqBefore(cum, a.cumAck) {
+		a.handleSACK(&sackChunk{cumTSN: cum, arwnd: a.peerRwnd + uint32(a.flightSize)})
+	}
+	a.peerDone = true
+	a.signal()
+	switch a.state {
+	case StateShutdownSent:
+		// Both sides shut down at once
+		a.state = StateShutdownAckSent
+		a.emit(Chunk{Type: ChunkShutdownAck})
+		a.t2 = a.ep.now().Add(a.rto)
+	case StateEstablished, StateShutdownPending:
+		a.state = StateShutdownReceived
+		a.checkShutdown()
+	}
+}
+
+// checkShutdown sends SHUTDOWN or SHUTDOWN-ACK once the data sent is all
+// acknowledged.
+func (a *Association) checkShutdown() {
+	if len(a.sendQueue) > 0 || len(a.outstanding) > 0 {
+		return
+	}
+	switch a.state {
+	case StateShutdownPending:
+		a.state = StateShutdownSent
+		a.emit(shutdownChunk(a.rcvCumTSN))
+	case StateShutdownReceived:
+		a.state = StateShutdownAckSent
+		a.emit(Chunk{Type: ChunkShutdownAck})
+	default:
+		return
+	}
+	a.t3 = time.Time{}
+	a.t2 = a.ep.now().Add(a.rto)
+}
+
+// tick runs the timers that are due.
+func (a *Association) tick() {
+	a.mu.Lock()
+	defer a.unlock()
+
+	now := a.ep.now()
+	due := func(t time.Time) bool { return !t.IsZero() && !now.Before(t) }
+
+	if due(a.t1) {
+		if a.initRetries++; a.initRetries > a.ep.config.MaxInitRetransmissions {
+			a.close(ErrTimeout)
+			return
+		}
+		a.backoff()
+		a.emit(a.initChunk)
+		a.t1 = now.Add(a.rto)
+	}
+	if due(a.t2) {
+		if !a.failed() {
+			if a.state == StateShutdownSent {
+				a.emit(shutdownChunk(a.rcvCumTSN))
+			} else {
+				a.emit(Chunk{Type: ChunkShutdownAck})
+			}
+			a.t2 = now.Add(a.rto)
+		}
+	}
+	if due(a.t3) {
+		a.retransmitTimeout()
+	}
+	if due(a.heartbeatAt) {
+		a.heartbeat()
+	}
+	if due(a.sackAt) {
+		a.sackNeeded = true
+	}
+	if a.state != StateClosed {
+		a.flush()
+	}
+}
+
+// failed counts an unanswered retransmission or heartbeat, and closes the
+// association once there were too many in a row.
+func (a *Association) failed() bool {
+	a.backoff()
+	if a.errorCount++; a.errorCount > a.ep.config.MaxRetransmissions {
+		a.close(ErrTimeout)
+		return true
+	}
+	return false
+}
+
+// backoff doubles the retransmission timeout.
+func (a *Association) backoff() {
+	a.rto = min(2*a.rto, a.ep.config.RTOMax)
+}
+
+// updateRTO updates the retransmission timeout with a round-trip time
+// measurement.
+func (a *Association) updateRTO(r time.Duration) {
+	if a.srtt == 0 {
+		a.srtt, a.rttvar = r, r/2
+	} else {
+		diff := a.srtt - r
+		if diff < 0 {
+			diff = -diff
+		}
+		a.rttvar = (3*a.rttvar + diff) / 4
+		a.srtt = (7*a.srtt + r) / 8
+	}
+	a.rto = min(max(a.srtt+4*a.rttvar, a.ep.config.RTOMin), a.ep.config.RTOMax)
+}
+
+// seqBefore reports whether TSN a comes before b, in serial number
+// arithmetic.
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
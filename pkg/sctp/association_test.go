@@ -0,0 +1,720 @@
+package sctp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+var (
+	clientIP = common.IPv4Address{10, 0, 0, 1}
+	serverIP = common.IPv4Address{10, 0, 0, 2}
+)
+
+const (
+	clientPort = 5000
+	serverPort = 5001
+)
+
+// wire connects test endpoints: packets sent are queued, and delivered by
+// pump. drop, if set, loses the packets it returns true for.
+type wire struct {
+	t    *testing.T
+	mu   sync.Mutex
+	now  time.Time
+	eps  map[common.IPv4Address]*Endpoint
+	sent []*Packet // Every packet sent, dropped ones too
+	q    []wirePacket
+	drop func(*Packet) bool
+}
+
+type wirePacket struct {
+	data     []byte
+	src, dst common.IPv4Address
+}
+
+func newWire(t *testing.T) *wire {
+	return &wire{t: t, now: time.Unix(1000, 0), eps: make(map[common.IPv4Address]*Endpoint)}
+}
+
+// endpoint creates an endpoint on the wire.
+func (w *wire) endpoint(addr common.IPv4Address, port uint16, config Config) *Endpoint {
+	w.t.Helper()
+	config.LocalAddr, config.Port = addr, port
+	config.Send = func(data []byte, src, dst common.IPv4Address) error {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		pkt, err := Parse(data)
+		if err != nil {
+			w.t.Errorf("sent an invalid packet: %v", err)
+			return nil
+		}
+		w.sent = append(w.sent, pkt)
+		if w.drop == nil || !w.drop(pkt) {
+			w.q = append(w.q, wirePacket{data, src, dst})
+		}
+		return nil
+	}
+	e, err := NewEndpoint(config)
+	if err != nil {
+		w.t.Fatalf("NewEndpoint() error = %v", err)
+	}
+	e.now = func() time.Time {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.now
+	}
+	w.eps[addr] = e
+	return e
+}
+
+// pump delivers the packets queued, and those sent in answer, until
+// there are none.
+func (w *wire) pump() {
+	for range 10000 {
+		w.mu.Lock()
+		if len(w.q) == 0 {
+			w.mu.Unlock()
+			return
+		}
+		p := w.q[0]
+		w.q = w.q[1:]
+		w.mu.Unlock()
+		w.eps[p.dst].HandlePacket(p.data, p.src, p.dst)
+	}
+	w.t.Fatal("packets still flowing after 10000")
+}
+
+// advance moves the clock on by d, runs the endpoints' timers and pumps.
+func (w *wire) advance(d time.Duration) {
+	w.mu.Lock()
+	w.now = w.now.Add(d)
+	w.mu.Unlock()
+	for _, e := range w.eps {
+		e.tick()
+	}
+	w.pump()
+}
+
+// sentChunks returns the chunks of a type sent since the packet at index
+// from of w.sent.
+func (w *wire) sentChunks(from int, t ChunkType) []Chunk {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var chunks []Chunk
+	for _, pkt := range w.sent[from:] {
+		for _, c := range pkt.Chunks {
+			if c.Type == t {
+				chunks = append(chunks, c)
+			}
+		}
+	}
+	return chunks
+}
+
+// mark returns the index of the next packet to be sent.
+func (w *wire) mark() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.sent)
+}
+
+// connected returns a wire with a client and a server endpoint, and an
+// association set up between them.
+func connected(t *testing.T, client, server Config) (*wire, *Association, *Association) {
+	t.Helper()
+	w := newWire(t)
+	ce := w.endpoint(clientIP, clientPort, client)
+	se := w.endpoint(serverIP, serverPort, server)
+	if err := se.Listen(4); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	a, err := ce.dial(serverIP, serverPort)
+	if err != nil {
+		t.Fatalf("dial() error = %v", err)
+	}
+	w.pump()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b, err := se.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	if got := a.State(); got != StateEstablished {
+		t.Fatalf("client state = %s, want ESTABLISHED", got)
+	}
+	return w, a, b
+}
+
+// recvAll returns the messages a has received, without waiting.
+func recvAll(t *testing.T, a *Association) []Message {
+	t.Helper()
+	var msgs []Message
+	for {
+		a.mu.Lock()
+		n := len(a.inbox)
+		a.mu.Unlock()
+		if n == 0 {
+			return msgs
+		}
+		m, err := a.Recv(context.Background())
+		if err != nil {
+			t.Fatalf("Recv() error = %v", err)
+		}
+		msgs = append(msgs, m)
+	}
+}
+
+// dropData returns a drop function losing the packets with DATA chunks
+// pick returns true for, the first time they are sent.
+func dropData(pick func(d *dataChunk) bool) func(*Packet) bool {
+	dropped := make(map[uint32]bool)
+	return func(pkt *Packet) bool {
+		for _, c := range pkt.Chunks {
+			if c.Type != ChunkData {
+				continue
+			}
+			d, _ := parseData(c)
+			if pick(d) && !dropped[d.tsn] {
+				dropped[d.tsn] = true
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	before := GetStats()
+	w, a, b := connected(t, Config{OutboundStreams: 4, MaxInboundStreams: 3}, Config{})
+
+	if out, in := a.Streams(); out != 4 || in != 3 {
+		t.Errorf("client streams = %d, %d; want 4, 3", out, in)
+	}
+	if out, in := b.Streams(); out != 3 || in != 4 {
+		t.Errorf("server streams = %d, %d; want 3, 4", out, in)
+	}
+	if addr, port := b.RemoteAddr(); addr != clientIP || port != clientPort {
+		t.Errorf("RemoteAddr() = %s:%d, want %s:%d", addr, port, clientIP, clientPort)
+	}
+
+	var types []ChunkType
+	for _, pkt := range w.sent {
+		types = append(types, pkt.Chunks[0].Type)
+	}
+	want := []ChunkType{ChunkInit, ChunkInitAck, ChunkCookieEcho, ChunkCookieAck}
+	if len(types) != len(want) {
+		t.Fatalf("handshake = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("handshake = %v, want %v", types, want)
+		}
+	}
+	if w.sent[0].VerificationTag != 0 || w.sent[1].VerificationTag != a.myTag || w.sent[2].VerificationTag != b.myTag {
+		t.Errorf("verification tags = %d, %d, %d; want 0, %d, %d", w.sent[0].VerificationTag, w.sent[1].VerificationTag, w.sent[2].VerificationTag, a.myTag, b.myTag)
+	}
+
+	stats := GetStats()
+	if stats.ActiveEstablishes-before.ActiveEstablishes != 1 || stats.PassiveEstablishes-before.PassiveEstablishes != 1 {
+		t.Errorf("establishes = %d active, %d passive; want 1 each",
+			stats.ActiveEstablishes-before.ActiveEstablishes, stats.PassiveEstablishes-before.PassiveEstablishes)
+	}
+}
+
+func TestHandshakeRetransmission(t *testing.T) {
+	w := newWire(t)
+	ce := w.endpoint(clientIP, clientPort, Config{})
+	se := w.endpoint(serverIP, serverPort, Config{})
+	se.Listen(1)
+
+	// The first INIT and the first COOKIE-ACK are lost
+	lost := map[ChunkType]bool{ChunkInit: true, ChunkCookieAck: true}
+	w.drop = func(pkt *Packet) bool {
+		t := pkt.Chunks[0].Type
+		drop := lost[t]
+		lost[t] = false
+		return drop
+	}
+	a, err := ce.dial(serverIP, serverPort)
+	if err != nil {
+		t.Fatalf("dial() error = %v", err)
+	}
+	w.pump()
+	if got := a.State(); got != StateCookieWait {
+		t.Fatalf("state after a lost INIT = %s, want COOKIE-WAIT", got)
+	}
+	w.advance(DefaultRTOInitial)
+	if got := a.State(); got != StateCookieEchoed {
+		t.Fatalf("state after a lost COOKIE-ACK = %s, want COOKIE-ECHOED", got)
+	}
+	// The server answers the COOKIE-ECHO again for the same association
+	w.advance(2 * DefaultRTOInitial)
+	if got := a.State(); got != StateEstablished {
+		t.Fatalf("state = %s, want ESTABLISHED", got)
+	}
+	if n := len(se.associations()); n != 1 {
+		t.Errorf("server has %d associations, want 1", n)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	w := newWire(t)
+	ce := w.endpoint(clientIP, clientPort, Config{MaxInitRetransmissions: 2})
+	w.drop = func(*Packet) bool { return true }
+	a, err := ce.dial(serverIP, serverPort)
+	if err != nil {
+		t.Fatalf("dial() error = %v", err)
+	}
+	for range 3 {
+		w.advance(time.Minute)
+	}
+	if got := len(w.sentChunks(0, ChunkInit)); got != 3 {
+		t.Errorf("sent %d INITs, want 3", got)
+	}
+	if err := a.Err(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Err() = %v, want ErrTimeout", err)
+	}
+	if _, err := ce.dial(serverIP, serverPort); err != nil {
+		t.Errorf("dial() after a timeout error = %v", err)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	w, a, b := connected(t, Config{}, Config{})
+	msgs := []Message{
+		{Stream: 0, PPID: 1, Data: []byte("a1")},
+		{Stream: 1, PPID: 2, Data: []byte("b1")},
+		{Stream: 0, PPID: 1, Data: []byte("a2")},
+		{Stream: 1, PPID: 2, Data: []byte("b2")},
+	}
+	for _, m := range msgs {
+		if err := a.Send(m); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	w.pump()
+	got := recvAll(t, b)
+	if len(got) != len(msgs) {
+		t.Fatalf("received %d messages, want %d", len(got), len(msgs))
+	}
+	for i := range msgs {
+		if got[i].Stream != msgs[i].Stream || got[i].PPID != msgs[i].PPID || !bytes.Equal(got[i].Data, msgs[i].Data) {
+			t.Errorf("message %d = %+v, want %+v", i, got[i], msgs[i])
+		}
+	}
+
+	if err := a.Send(Message{Stream: DefaultStreams, Data: []byte("x")}); err == nil {
+		t.Error("Send() on a stream beyond those negotiated succeeded, want error")
+	}
+	if err := a.Send(Message{}); err == nil {
+		t.Error("Send() of an empty message succeeded, want error")
+	}
+}
+
+func TestStreamHeadOfLine(t *testing.T) {
+	w, a, b := connected(t, Config{}, Config{})
+
+	// A lost message on stream 0 holds back the later ones on stream 0
+	// only. Two SACKs report it missing, too few for a fast retransmit.
+	w.drop = dropData(func(d *dataChunk) bool { return d.stream == 0 && d.ssn == 0 })
+	for _, m := range []Message{
+		{Stream: 0, Data: []byte("a1")},
+		{Stream: 0, Data: []byte("a2")},
+		{Stream: 1, Data: []byte("b1")},
+	} {
+		if err := a.Send(m); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	w.pump()
+	var got []string
+	for _, m := range recvAll(t, b) {
+		got = append(got, string(m.Data))
+	}
+	if strings.Join(got, " ") != "b1" {
+		t.Fatalf("received %q before the retransmission, want [b1]", got)
+	}
+
+	w.advance(DefaultRTOInitial)
+	got = nil
+	for _, m := range recvAll(t, b) {
+		got = append(got, string(m.Data))
+	}
+	if strings.Join(got, " ") != "a1 a2" {
+		t.Errorf("received %q after the retransmission, want [a1 a2]", got)
+	}
+}
+
+func TestFragmentation(t *testing.T) {
+	w, a, b := connected(t, Config{MTU: 600}, Config{})
+	big := bytes.Repeat([]byte("0123456789"), 300)
+	for _, unordered := range []bool{false, true} {
+		start := w.mark()
+		if err := a.Send(Message{Stream: 3, PPID: 9, Unordered: unordered, Data: big}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		w.pump()
+		if n := len(w.sentChunks(start, ChunkData)); n < 6 {
+			t.Errorf("a %d-byte message went in %d DATA chunks with a 600-byte MTU", len(big), n)
+		}
+		got := recvAll(t, b)
+		if len(got) != 1 || !bytes.Equal(got[0].Data, big) || got[0].Unordered != unordered || got[0].PPID != 9 {
+			t.Fatalf("received %d messages, want the %d-byte message", len(got), len(big))
+		}
+	}
+	for _, pkt := range w.sent {
+		if size := pkt.Size(); size > 600-ipv4HeaderLength {
+			t.Errorf("sent a packet of %d bytes with a 600-byte MTU", size)
+		}
+	}
+}
+
+func TestFastRetransmit(t *testing.T) {
+	w, a, b := connected(t, Config{}, Config{})
+	before := GetStats()
+	first := true
+	w.drop = func(pkt *Packet) bool {
+		if pkt.Chunks[0].Type == ChunkData && first {
+			first = false
+			return true
+		}
+		return false
+	}
+	for i := range 5 {
+		if err := a.Send(Message{Data: []byte{byte('a' + i)}}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		w.pump()
+	}
+	// Three SACKs reported the first chunk missing: it went again without
+	// waiting for T3-rtx
+	if got := GetStats().FastRetransmits - before.FastRetransmits; got != 1 {
+		t.Errorf("fast retransmits = %d, want 1", got)
+	}
+	var got []byte
+	for _, m := range recvAll(t, b) {
+		got = append(got, m.Data...)
+	}
+	if string(got) != "abcde" {
+		t.Errorf("received %q, want %q", got, "abcde")
+	}
+	// The last chunk arrived in order: its SACK is delayed
+	if len(a.outstanding) != 1 {
+		t.Errorf("%d chunks outstanding before the delayed SACK, want 1", len(a.outstanding))
+	}
+	w.advance(delayedSACK)
+	if len(a.outstanding) != 0 || a.flightSize != 0 {
+		t.Errorf("%d chunks and %d bytes outstanding, want none", len(a.outstanding), a.flightSize)
+	}
+	if !a.t3.IsZero() {
+		t.Error("T3-rtx running with nothing outstanding")
+	}
+}
+
+func TestRetransmitTimeout(t *testing.T) {
+	w, a, b := connected(t, Config{MaxRetransmissions: 3}, Config{})
+	w.drop = func(pkt *Packet) bool { return pkt.Chunks[0].Type == ChunkData }
+	if err := a.Send(Message{Data: []byte("lost")}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	w.pump()
+
+	w.advance(DefaultRTOInitial)
+	if n := len(w.sentChunks(0, ChunkData)); n != 2 {
+		t.Fatalf("sent %d DATA chunks after T3-rtx, want 2", n)
+	}
+	if a.cwnd != a.maxPacket() || a.rto != 2*DefaultRTOInitial {
+		t.Errorf("after T3-rtx cwnd = %d, RTO = %v; want %d, %v", a.cwnd, a.rto, a.maxPacket(), 2*DefaultRTOInitial)
+	}
+
+	// The retransmission arrives
+	w.drop = nil
+	w.advance(a.rto)
+	w.advance(delayedSACK)
+	if got := recvAll(t, b); len(got) != 1 || string(got[0].Data) != "lost" {
+		t.Fatalf("received %v, want the message", got)
+	}
+	if a.errorCount != 0 {
+		t.Errorf("error count = %d after an acknowledgment, want 0", a.errorCount)
+	}
+
+	// Without acknowledgments the association times out
+	w.drop = func(pkt *Packet) bool { return true }
+	a.Send(Message{Data: []byte("void")})
+	w.pump()
+	for range 4 {
+		w.advance(DefaultRTOMax)
+	}
+	if err := a.Err(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Err() = %v, want ErrTimeout", err)
+	}
+	if _, err := a.Recv(context.Background()); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Recv() error = %v, want ErrTimeout", err)
+	}
+}
+
+func TestReceiveWindow(t *testing.T) {
+	w, a, b := connected(t, Config{}, Config{ReceiveWindow: 4000})
+	for range 8 {
+		if err := a.Send(Message{Data: make([]byte, 1000)}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	w.pump()
+	if got := b.rcvQueued; got > 4000 {
+		t.Errorf("receiver holds %d bytes with a 4000-byte window", got)
+	}
+	if len(a.sendQueue) == 0 {
+		t.Fatal("sender sent everything into a full window")
+	}
+
+	// Reading opens the window, and the rest follows. Probes sent into
+	// the zero window are dropped.
+	var total int
+	for range 20 {
+		for _, m := range recvAll(t, b) {
+			total += len(m.Data)
+		}
+		w.advance(DefaultRTOInitial)
+		if got := b.rcvQueued; got > 4000 {
+			t.Fatalf("receiver holds %d bytes with a 4000-byte window", got)
+		}
+	}
+	if total != 8000 {
+		t.Errorf("received %d bytes, want 8000", total)
+	}
+	if len(a.sendQueue) != 0 || len(a.outstanding) != 0 {
+		t.Errorf("%d chunks queued and %d outstanding, want none", len(a.sendQueue), len(a.outstanding))
+	}
+}
+
+func TestSendBuffer(t *testing.T) {
+	w, a, _ := connected(t, Config{SendBuffer: 1500}, Config{})
+	w.drop = func(*Packet) bool { return true }
+	if err := a.Send(Message{Data: make([]byte, 1000)}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := a.Send(Message{Data: make([]byte, 1000)}); !errors.Is(err, ErrSendBufferFull) {
+		t.Errorf("Send() beyond the send buffer error = %v, want ErrSendBufferFull", err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	w, a, _ := connected(t, Config{HeartbeatInterval: 5 * time.Second, MaxRetransmissions: 2}, Config{})
+	start := w.mark()
+	w.advance(5*time.Second + DefaultRTOInitial)
+	if n := len(w.sentChunks(start, ChunkHeartbeat)); n != 1 {
+		t.Fatalf("sent %d HEARTBEATs while idle, want 1", n)
+	}
+	if n := len(w.sentChunks(start, ChunkHeartbeatAck)); n != 1 {
+		t.Fatalf("peer sent %d HEARTBEAT-ACKs, want 1", n)
+	}
+	if a.heartbeatNonce != 0 || a.srtt == 0 {
+		t.Errorf("heartbeat nonce = %d, SRTT = %v after the acknowledgment", a.heartbeatNonce, a.srtt)
+	}
+
+	// A peer that stops answering is given up on
+	w.drop = func(*Packet) bool { return true }
+	for range 4 {
+		w.advance(time.Minute)
+	}
+	if err := a.Err(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Err() = %v, want ErrTimeout", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	w, a, b := connected(t, Config{}, Config{})
+	w.drop = dropData(func(*dataChunk) bool { return true })
+	if err := a.Send(Message{Data: []byte("last words")}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	w.pump()
+
+	// The shutdown waits for the data to be acknowledged
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	w.pump()
+	if got := a.State(); got != StateShutdownPending {
+		t.Fatalf("state with data outstanding = %s, want SHUTDOWN-PENDING", got)
+	}
+	if err := a.Send(Message{Data: []byte("more")}); err == nil {
+		t.Error("Send() after Close() succeeded, want error")
+	}
+
+	w.advance(DefaultRTOInitial)
+	w.advance(delayedSACK)
+	for _, assoc := range []*Association{a, b} {
+		select {
+		case <-assoc.Done():
+		default:
+			t.Fatalf("association in state %s after the shutdown", assoc.State())
+		}
+		if err := assoc.Err(); err != nil {
+			t.Errorf("Err() = %v after a graceful shutdown", err)
+		}
+	}
+	m, err := b.Recv(context.Background())
+	if err != nil || string(m.Data) != "last words" {
+		t.Errorf("Recv() = %q, %v; want the message", m.Data, err)
+	}
+	if _, err := b.Recv(context.Background()); err != io.EOF {
+		t.Errorf("Recv() after the message error = %v, want io.EOF", err)
+	}
+	for _, chunk := range []ChunkType{ChunkShutdown, ChunkShutdownAck, ChunkShutdownComplete} {
+		if n := len(w.sentChunks(0, chunk)); n != 1 {
+			t.Errorf("sent %d %s chunks, want 1", n, chunk)
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	w, a, b := connected(t, Config{}, Config{})
+	a.Abort("going away")
+	w.pump()
+	if err := a.Err(); !errors.Is(err, ErrAborted) {
+		t.Errorf("Err() = %v, want ErrAborted", err)
+	}
+	if err := b.Err(); !errors.Is(err, ErrAborted) || !strings.Contains(err.Error(), "going away") {
+		t.Errorf("peer Err() = %v, want ErrAborted with the reason", err)
+	}
+	if _, err := b.Recv(context.Background()); !errors.Is(err, ErrAborted) {
+		t.Errorf("Recv() error = %v, want ErrAborted", err)
+	}
+}
+
+func TestVerificationTag(t *testing.T) {
+	w, a, b := connected(t, Config{}, Config{})
+	before := GetStats()
+
+	// An ABORT with a guessed tag is ignored
+	forged := &Packet{SourcePort: clientPort, DestinationPort: serverPort, VerificationTag: b.myTag + 1, Chunks: []Chunk{abortChunk("", false)}}
+	data, _ := forged.Serialize()
+	if err := w.eps[serverIP].HandlePacket(data, clientIP, serverIP); err == nil {
+		t.Error("HandlePacket() with a wrong tag succeeded, want error")
+	}
+	if got := b.State(); got != StateEstablished {
+		t.Errorf("state after a forged ABORT = %s, want ESTABLISHED", got)
+	}
+	if got := GetStats().BadTags - before.BadTags; got != 1 {
+		t.Errorf("BadTags = %d, want 1", got)
+	}
+
+	// A reflected one carries the peer's tag
+	forged.VerificationTag = a.myTag
+	forged.Chunks[0].Flags = flagReflected
+	data, _ = forged.Serialize()
+	if err := w.eps[serverIP].HandlePacket(data, clientIP, serverIP); err != nil {
+		t.Errorf("HandlePacket() error = %v", err)
+	}
+	if got := b.State(); got != StateClosed {
+		t.Errorf("state after a reflected ABORT = %s, want CLOSED", got)
+	}
+}
+
+func TestOutOfTheBlue(t *testing.T) {
+	w := newWire(t)
+	ce := w.endpoint(clientIP, clientPort, Config{})
+	w.endpoint(serverIP, serverPort, Config{})
+	before := GetStats()
+
+	// The server is not listening: the INIT is aborted
+	a, err := ce.dial(serverIP, serverPort)
+	if err != nil {
+		t.Fatalf("dial() error = %v", err)
+	}
+	w.pump()
+	if err := a.Err(); !errors.Is(err, ErrAborted) {
+		t.Errorf("Err() = %v, want ErrAborted", err)
+	}
+	aborts := w.sentChunks(0, ChunkAbort)
+	if len(aborts) != 1 || aborts[0].Flags&flagReflected != 0 {
+		t.Errorf("sent %v, want an ABORT without the T bit", aborts)
+	}
+
+	// Other packets for no association are aborted with the T bit, and
+	// SHUTDOWN-ACKs completed
+	tests := []struct {
+		chunk ChunkType
+		want  ChunkType
+	}{
+		{ChunkData, ChunkAbort},
+		{ChunkShutdownAck, ChunkShutdownComplete},
+		{ChunkAbort, 0},
+	}
+	for _, tt := range tests {
+		start := w.mark()
+		pkt := &Packet{SourcePort: clientPort, DestinationPort: serverPort, VerificationTag: 42, Chunks: []Chunk{{Type: tt.chunk}}}
+		data, _ := pkt.Serialize()
+		w.eps[serverIP].HandlePacket(data, clientIP, serverIP)
+		if tt.want == 0 {
+			if w.mark() != start {
+				t.Errorf("answered an out of the blue %s", tt.chunk)
+			}
+			continue
+		}
+		if w.mark() != start+1 {
+			t.Fatalf("sent %d packets for an out of the blue %s, want 1", w.mark()-start, tt.chunk)
+		}
+		reply := w.sent[start]
+		if reply.Chunks[0].Type != tt.want || reply.Chunks[0].Flags&flagReflected == 0 || reply.VerificationTag != 42 {
+			t.Errorf("answer to an out of the blue %s = %s with flags %#x and tag %d, want %s with the T bit and tag 42",
+				tt.chunk, reply.Chunks[0].Type, reply.Chunks[0].Flags, reply.VerificationTag, tt.want)
+		}
+	}
+	if got := GetStats().OutOfBlues - before.OutOfBlues; got != 4 {
+		t.Errorf("OutOfBlues = %d, want 4", got)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	w := newWire(t)
+	ce := w.endpoint(clientIP, clientPort, Config{})
+	se := w.endpoint(serverIP, serverPort, Config{})
+	se.Listen(1)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				w.pump()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, err := ce.Connect(ctx, serverIP, serverPort)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	b, err := se.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	if err := a.Send(Message{Data: []byte("ping")}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if m, err := b.Recv(ctx); err != nil || string(m.Data) != "ping" {
+		t.Errorf("Recv() = %q, %v; want %q", m.Data, err, "ping")
+	}
+	if _, err := ce.Connect(ctx, serverIP, serverPort); err == nil {
+		t.Error("Connect() to a peer already associated succeeded, want error")
+	}
+}
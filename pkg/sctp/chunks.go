@@ -0,0 +1,286 @@
+package sctp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DATA chunk flags
+const (
+	flagEnd       = 0x01 // Last fragment of a message
+	flagBegin     = 0x02 // First fragment of a message
+	flagUnordered = 0x04 // Delivered regardless of its stream sequence number
+)
+
+// flagReflected is the T bit of ABORT and SHUTDOWN-COMPLETE: the
+// verification tag is the sender's own rather than the receiver's.
+const flagReflected = 0x01
+
+// Parameter types (RFC 9260 Section 3.3.2)
+const (
+	paramHeartbeatInfo      = 1
+	paramIPv4Address        = 5
+	paramIPv6Address        = 6
+	paramStateCookie        = 7
+	paramCookiePreservative = 9
+	paramHostName           = 11
+	paramSupportedAddrTypes = 12
+
+	// paramSkip is set in the types of parameters a receiver that does
+	// not know them skips; it stops at other unknown parameters.
+	paramSkip = 0x8000
+)
+
+// chunkSkip is set in the types of chunks a receiver that does not know
+// them skips; it discards the rest of the packet at other unknown chunks.
+const chunkSkip = 0x80
+
+// causeUserInitiatedAbort is the error cause of an ABORT sent by Abort
+const causeUserInitiatedAbort = 12
+
+const (
+	// dataHeaderLength is the length of the fields of a DATA chunk before
+	// its user data
+	dataHeaderLength = 12
+
+	// dataChunkOverhead is the length of a DATA chunk without user data
+	dataChunkOverhead = chunkHeaderLength + dataHeaderLength
+
+	// initLength is the length of the fixed fields of INIT and INIT-ACK
+	initLength = 16
+
+	// sackLength is the length of the fixed fields of a SACK
+	sackLength = 12
+)
+
+// dataChunk is a DATA chunk: a message or a fragment of one.
+type dataChunk struct {
+	tsn       uint32
+	stream    uint16
+	ssn       uint16
+	ppid      uint32
+	unordered bool
+	begin     bool
+	end       bool
+	data      []byte
+}
+
+func (d *dataChunk) chunk() Chunk {
+	v := make([]byte, dataHeaderLength, dataHeaderLength+len(d.data))
+	binary.BigEndian.PutUint32(v[0:4], d.tsn)
+	binary.BigEndian.PutUint16(v[4:6], d.stream)
+	binary.BigEndian.PutUint16(v[6:8], d.ssn)
+	binary.BigEndian.PutUint32(v[8:12], d.ppid)
+	var flags uint8
+	if d.unordered {
+		flags |= flagUnordered
+	}
+	if d.begin {
+		flags |= flagBegin
+	}
+	if d.end {
+		flags |= flagEnd
+	}
+	return Chunk{Type: ChunkData, Flags: flags, Value: append(v, d.data...)}
+}
+
+func parseData(c Chunk) (*dataChunk, error) {
+	if len(c.Value) <= dataHeaderLength {
+		return nil, fmt.Errorf("DATA chunk without user data")
+	}
+	return &dataChunk{
+		tsn:       binary.BigEndian.Uint32(c.Value[0:4]),
+		stream:    binary.BigEndian.Uint16(c.Value[4:6]),
+		ssn:       binary.BigEndian.Uint16(c.Value[6:8]),
+		ppid:      binary.BigEndian.Uint32(c.Value[8:12]),
+		unordered: c.Flags&flagUnordered != 0,
+		begin:     c.Flags&flagBegin != 0,
+		end:       c.Flags&flagEnd != 0,
+		data:      append([]byte(nil), c.Value[dataHeaderLength:]...),
+	}, nil
+}
+
+// initChunk is an INIT or INIT-ACK chunk.
+type initChunk struct {
+	initiateTag uint32
+	arwnd       uint32
+	outStreams  uint16
+	inStreams   uint16
+	initialTSN  uint32
+	cookie      []byte // State cookie of an INIT-ACK
+}
+
+func (i *initChunk) chunk(t ChunkType) Chunk {
+	v := make([]byte, initLength)
+	binary.BigEndian.PutUint32(v[0:4], i.initiateTag)
+	binary.BigEndian.PutUint32(v[4:8], i.arwnd)
+	binary.BigEndian.PutUint16(v[8:10], i.outStreams)
+	binary.BigEndian.PutUint16(v[10:12], i.inStreams)
+	binary.BigEndian.PutUint32(v[12:16], i.initialTSN)
+	if i.cookie != nil {
+		v = appendParam(v, paramStateCookie, i.cookie)
+	} else {
+		// Only IPv4 addresses are supported
+		v = appendParam(v, paramSupportedAddrTypes, []byte{0, paramIPv4Address})
+	}
+	return Chunk{Type: t, Value: v}
+}
+
+func parseInit(c Chunk) (*initChunk, error) {
+	if len(c.Value) < initLength {
+		return nil, fmt.Errorf("%s chunk too short: %d bytes", c.Type, len(c.Value))
+	}
+	i := &initChunk{
+		initiateTag: binary.BigEndian.Uint32(c.Value[0:4]),
+		arwnd:       binary.BigEndian.Uint32(c.Value[4:8]),
+		outStreams:  binary.BigEndian.Uint16(c.Value[8:10]),
+		inStreams:   binary.BigEndian.Uint16(c.Value[10:12]),
+		initialTSN:  binary.BigEndian.Uint32(c.Value[12:16]),
+	}
+	if i.initiateTag == 0 || i.outStreams == 0 || i.inStreams == 0 {
+		return nil, fmt.Errorf("invalid %s: tag %d, %d outbound and %d inbound streams", c.Type, i.initiateTag, i.outStreams, i.inStreams)
+	}
+
+	err := params(c.Value[initLength:], func(t uint16, v []byte) bool {
+		switch t {
+		case paramStateCookie:
+			i.cookie = append([]byte(nil), v...)
+		case paramIPv4Address, paramIPv6Address, paramCookiePreservative, paramHostName, paramSupportedAddrTypes:
+			// Single-homed: the peer's address is the packet's source
+		default:
+			return t&paramSkip != 0
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Type, err)
+	}
+	if c.Type == ChunkInitAck && i.cookie == nil {
+		return nil, fmt.Errorf("INIT-ACK without a state cookie")
+	}
+	return i, nil
+}
+
+// gapBlock is a range of TSNs received after the cumulative TSN ack,
+// as offsets from it.
+type gapBlock struct {
+	start, end uint16
+}
+
+// sackChunk is a SACK chunk.
+type sackChunk struct {
+	cumTSN uint32
+	arwnd  uint32
+	gaps   []gapBlock
+	dups   []uint32
+}
+
+func (s *sackChunk) chunk() Chunk {
+	v := make([]byte, sackLength, sackLength+4*len(s.gaps)+4*len(s.dups))
+	binary.BigEndian.PutUint32(v[0:4], s.cumTSN)
+	binary.BigEndian.PutUint32(v[4:8], s.arwnd)
+	binary.BigEndian.PutUint16(v[8:10], uint16(len(s.gaps)))
+	binary.BigEndian.PutUint16(v[10:12], uint16(len(s.dups)))
+	for _, g := range s.gaps {
+		v = binary.BigEndian.AppendUint16(v, g.start)
+		v = binary.BigEndian.AppendUint16(v, g.end)
+	}
+	for _, tsn := range s.dups {
+		v = binary.BigEndian.AppendUint32(v, tsn)
+	}
+	return Chunk{Type: ChunkSACK, Value: v}
+}
+
+func parseSACK(c Chunk) (*sackChunk, error) {
+	if len(c.Value) < sackLength {
+		return nil, fmt.Errorf("SACK chunk too short: %d bytes", len(c.Value))
+	}
+	s := &sackChunk{
+		cumTSN: binary.BigEndian.Uint32(c.Value[0:4]),
+		arwnd:  binary.BigEndian.Uint32(c.Value[4:8]),
+	}
+	gaps, dups := int(binary.BigEndian.Uint16(c.Value[8:10])), int(binary.BigEndian.Uint16(c.Value[10:12]))
+	if len(c.Value) < sackLength+4*gaps+4*dups {
+		return nil, fmt.Errorf("SACK chunk with %d gap blocks and %d duplicates too short: %d bytes", gaps, dups, len(c.Value))
+	}
+	v := c.Value[sackLength:]
+	for range gaps {
+		g := gapBlock{binary.BigEndian.Uint16(v[0:2]), binary.BigEndian.Uint16(v[2:4])}
+		if g.start == 0 || g.end < g.start {
+			return nil, fmt.Errorf("invalid SACK gap block %d-%d", g.start, g.end)
+		}
+		s.gaps = append(s.gaps, g)
+		v = v[4:]
+	}
+	for range dups {
+		s.dups = append(s.dups, binary.BigEndian.Uint32(v[0:4]))
+		v = v[4:]
+	}
+	return s, nil
+}
+
+// shutdownChunk returns a SHUTDOWN chunk acknowledging cumTSN.
+func shutdownChunk(cumTSN uint32) Chunk {
+	return Chunk{Type: ChunkShutdown, Value: binary.BigEndian.AppendUint32(nil, cumTSN)}
+}
+
+func parseShutdown(c Chunk) (uint32, error) {
+	if len(c.Value) < 4 {
+		return 0, fmt.Errorf("SHUTDOWN chunk too short: %d bytes", len(c.Value))
+	}
+	return binary.BigEndian.Uint32(c.Value), nil
+}
+
+// abortChunk returns an ABORT chunk, with a User-Initiated Abort cause
+// carrying reason unless it is empty.
+func abortChunk(reason string, reflected bool) Chunk {
+	c := Chunk{Type: ChunkAbort}
+	if reflected {
+		c.Flags = flagReflected
+	}
+	if reason != "" {
+		c.Value = appendParam(nil, causeUserInitiatedAbort, []byte(reason))
+	}
+	return c
+}
+
+// abortReason returns the reason of a User-Initiated Abort cause in an
+// ABORT chunk, if it has one.
+func abortReason(c Chunk) string {
+	var reason string
+	params(c.Value, func(t uint16, v []byte) bool {
+		if t == causeUserInitiatedAbort {
+			reason = string(v)
+		}
+		return true
+	})
+	return reason
+}
+
+// appendParam appends a parameter or error cause, padded, to b.
+func appendParam(b []byte, t uint16, v []byte) []byte {
+	length := 4 + len(v)
+	b = binary.BigEndian.AppendUint16(b, t)
+	b = binary.BigEndian.AppendUint16(b, uint16(length))
+	b = append(b, v...)
+	return append(b, make([]byte, padded(length)-length)...)
+}
+
+// params calls f with the type and value of each parameter in b until it
+// returns false.
+func params(b []byte, f func(t uint16, v []byte) bool) error {
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return fmt.Errorf("truncated parameter: %d bytes", len(b))
+		}
+		t, length := binary.BigEndian.Uint16(b[0:2]), int(binary.BigEndian.Uint16(b[2:4]))
+		if length < 4 || length > len(b) {
+			return fmt.Errorf("invalid length %d of parameter %d", length, t)
+		}
+		if !f(t, b[4:length]) {
+			return nil
+		}
+		b = b[min(padded(length), len(b)):]
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package sctp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// cookieLength is the length of a state cookie: its fields and the
+// HMAC-SHA-256 over them
+const cookieLength = 38 + sha256.Size
+
+// cookie is the state an endpoint hands to a peer in the INIT-ACK instead
+// of keeping it, so that a flood of INITs holds no resources (RFC 9260
+// Section 5.1.3). The peer echoes it in COOKIE-ECHO, and the association
+// is created from it then.
+type cookie struct {
+	created    time.Time
+	localTag   uint32
+	peerTag    uint32
+	localTSN   uint32 // Our initial TSN
+	peerTSN    uint32 // The peer's initial TSN
+	peerRwnd   uint32
+	outStreams uint16
+	inStreams  uint16
+	peer       common.IPv4Address
+	peerPort   uint16
+}
+
+// seal serializes the cookie with a MAC keyed with secret.
+func (c *cookie) seal(secret []byte) []byte {
+	b := make([]byte, 0, cookieLength)
+	b = binary.BigEndian.AppendUint64(b, uint64(c.created.UnixNano()))
+	b = binary.BigEndian.AppendUint32(b, c.localTag)
+	b = binary.BigEndian.AppendUint32(b, c.peerTag)
+	b = binary.BigEndian.AppendUint32(b, c.localTSN)
+	b = binary.BigEndian.AppendUint32(b, c.peerTSN)
+	b = binary.BigEndian.AppendUint32(b, c.peerRwnd)
+	b = binary.BigEndian.AppendUint16(b, c.outStreams)
+	b = binary.BigEndian.AppendUint16(b, c.inStreams)
+	b = append(b, c.peer[:]...)
+	b = binary.BigEndian.AppendUint16(b, c.peerPort)
+	return append(b, cookieMAC(secret, b)...)
+}
+
+// openCookie verifies a state cookie from peer and decodes it. It fails
+// if the cookie was not sealed with secret, or was sealed before
+// now - lifetime.
+func openCookie(b, secret []byte, peer common.IPv4Address, peerPort uint16, now time.Time, lifetime time.Duration) (*cookie, error) {
+	if len(b) != cookieLength {
+		return nil, fmt.Errorf("state cookie of %d bytes, want %d", len(b), cookieLength)
+	}
+	fields, mac := b[:cookieLength-sha256.Size], b[cookieLength-sha256.Size:]
+	if !hmac.Equal(mac, cookieMAC(secret, fields)) {
+		return nil, fmt.Errorf("state cookie MAC mismatch")
+	}
+
+	c := &cookie{
+		created:    time.Unix(0, int64(binary.BigEndian.Uint64(b[0:8]))),
+		localTag:   binary.BigEndian.Uint32(b[8:12]),
+		peerTag:    binary.BigEndian.Uint32(b[12:16]),
+		localTSN:   binary.BigEndian.Uint32(b[16:20]),
+		peerTSN:    binary.BigEndian.Uint32(b[20:24]),
+		peerRwnd:   binary.BigEndian.Uint32(b[24:28]),
+		outStreams: binary.BigEndian.Uint16(b[28:30]),
+		inStreams:  binary.BigEndian.Uint16(b[30:32]),
+		peerPort:   binary.BigEndian.Uint16(b[36:38]),
+	}
+	copy(c.peer[:], b[32:36])
+	if c.peer != peer || c.peerPort != peerPort {
+		return nil, fmt.Errorf("state cookie for %s:%d echoed by %s:%d", c.peer, c.peerPort, peer, peerPort)
+	}
+	if age := now.Sub(c.created); age > lifetime {
+		return nil, fmt.Errorf("stale state cookie: %v old, lifetime %v", age, lifetime)
+	}
+	return c, nil
+}
+
+// cookieMAC returns the HMAC-SHA-256 of the fields of a cookie.
+func cookieMAC(secret, fields []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(fields)
+	return h.Sum(nil)
+}
@@ -0,0 +1,493 @@
+package sctp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+const (
+	// DefaultStreams is the default number of outbound streams requested
+	// and of inbound streams allowed.
+	DefaultStreams = 10
+
+	// DefaultReceiveWindow is the default receive window of an
+	// association, in bytes.
+	DefaultReceiveWindow = 256 * 1024
+
+	// DefaultSendBuffer is the default limit on the data an association
+	// holds unacknowledged, in bytes.
+	DefaultSendBuffer = 256 * 1024
+
+	// DefaultMTU is the default size of the IP packets carrying SCTP
+	// packets.
+	DefaultMTU = 1500
+
+	// Defaults of the protocol parameters of RFC 9260 Section 16
+	DefaultRTOInitial             = time.Second
+	DefaultRTOMin                 = time.Second
+	DefaultRTOMax                 = 60 * time.Second
+	DefaultHeartbeatInterval      = 30 * time.Second
+	DefaultMaxRetransmissions     = 10
+	DefaultMaxInitRetransmissions = 8
+	DefaultCookieLifetime         = 60 * time.Second
+
+	// ipv4HeaderLength is the length of the IP header of the packets the
+	// MTU is for
+	ipv4HeaderLength = 20
+
+	// tickInterval is how often the timers of associations are checked
+	tickInterval = 10 * time.Millisecond
+)
+
+// Config configures an Endpoint.
+type Config struct {
+	LocalAddr common.IPv4Address // Address the endpoint is bound to
+	Port      uint16
+
+	// Send transmits a serialized SCTP packet from src to dst over IP
+	// (protocol 132). It is not called with locks held, and may call
+	// HandlePacket.
+	Send func(packet []byte, src, dst common.IPv4Address) error
+
+	OutboundStreams   uint16 // Streams requested of peers (DefaultStreams if zero)
+	MaxInboundStreams uint16 // Streams peers may open (DefaultStreams if zero)
+
+	ReceiveWindow uint32 // DefaultReceiveWindow if zero
+	SendBuffer    int    // DefaultSendBuffer if zero
+	MTU           int    // DefaultMTU if zero
+
+	RTOInitial             time.Duration // DefaultRTOInitial if zero
+	RTOMin                 time.Duration // DefaultRTOMin if zero
+	RTOMax                 time.Duration // DefaultRTOMax if zero
+	HeartbeatInterval      time.Duration // DefaultHeartbeatInterval if zero
+	MaxRetransmissions     int           // DefaultMaxRetransmissions if zero
+	MaxInitRetransmissions int           // DefaultMaxInitRetransmissions if zero
+	CookieLifetime         time.Duration // DefaultCookieLifetime if zero
+}
+
+// assocKey identifies an association by the peer's address and port.
+type assocKey struct {
+	addr common.IPv4Address
+	port uint16
+}
+
+// Endpoint is an SCTP endpoint bound to an address and port. It connects
+// associations to peers, and accepts associations from peers once Listen
+// is called. Received packets are passed to HandlePacket; Start runs the
+// timers of its associations.
+type Endpoint struct {
+	config Config
+	secret []byte // Key of the MACs of state cookies
+
+	mu          sync.Mutex
+	assocs      map[assocKey]*Association
+	acceptQueue chan *Association
+	closed      bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	now func() time.Time // Clock, replaced in tests
+}
+
+// NewEndpoint creates an SCTP endpoint.
+func NewEndpoint(config Config) (*Endpoint, error) {
+	if config.Send == nil {
+		return nil, fmt.Errorf("no send function")
+	}
+	if config.Port == 0 {
+		return nil, fmt.Errorf("port is 0")
+	}
+	if config.OutboundStreams == 0 {
+		config.OutboundStreams = DefaultStreams
+	}
+	if config.MaxInboundStreams == 0 {
+		config.MaxInboundStreams = DefaultStreams
+	}
+	if config.ReceiveWindow == 0 {
+		config.ReceiveWindow = DefaultReceiveWindow
+	}
+	if config.SendBuffer == 0 {
+		config.SendBuffer = DefaultSendBuffer
+	}
+	if config.MTU == 0 {
+		config.MTU = DefaultMTU
+	}
+	if config.MTU < 576 || config.MTU > 65535 {
+		return nil, fmt.Errorf("invalid MTU %d", config.MTU)
+	}
+	if config.RTOInitial == 0 {
+		config.RTOInitial = DefaultRTOInitial
+	}
+	if config.RTOMin == 0 {
+		config.RTOMin = DefaultRTOMin
+	}
+	if config.RTOMax == 0 {
+		config.RTOMax = DefaultRTOMax
+	}
+	if config.RTOMin > config.RTOMax {
+		return nil, fmt.Errorf("RTO minimum %v above maximum %v", config.RTOMin, config.RTOMax)
+	}
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if config.MaxRetransmissions == 0 {
+		config.MaxRetransmissions = DefaultMaxRetransmissions
+	}
+	if config.MaxInitRetransmissions == 0 {
+		config.MaxInitRetransmissions = DefaultMaxInitRetransmissions
+	}
+	if config.CookieLifetime == 0 {
+		config.CookieLifetime = DefaultCookieLifetime
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating cookie secret: %w", err)
+	}
+	return &Endpoint{
+		config: config,
+		secret: secret,
+		assocs: make(map[assocKey]*Association),
+		done:   make(chan struct{}),
+		now:    time.Now,
+	}, nil
+}
+
+// Start runs the timers of the endpoint's associations until Close.
+func (e *Endpoint) Start() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.done:
+				return
+			case <-ticker.C:
+				e.tick()
+			}
+		}
+	}()
+}
+
+// tick runs the timers of every association that are due.
+func (e *Endpoint) tick() {
+	for _, a := range e.associations() {
+		a.tick()
+	}
+}
+
+// associations returns the endpoint's associations.
+func (e *Endpoint) associations() []*Association {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	assocs := make([]*Association, 0, len(e.assocs))
+	for _, a := range e.assocs {
+		assocs = append(assocs, a)
+	}
+	return assocs
+}
+
+// Close aborts the endpoint's associations and stops its timers.
+func (e *Endpoint) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	if e.acceptQueue != nil {
+		close(e.acceptQueue)
+	}
+	e.mu.Unlock()
+
+	for _, a := range e.associations() {
+		a.Abort("endpoint closed")
+	}
+	close(e.done)
+	e.wg.Wait()
+	return nil
+}
+
+// Listen makes the endpoint accept associations from peers, holding up
+// to backlog of them until Accept.
+func (e *Endpoint) Listen(backlog int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrEndpointClosed
+	}
+	if e.acceptQueue != nil {
+		return fmt.Errorf("endpoint already listening")
+	}
+	if backlog <= 0 {
+		return fmt.Errorf("invalid backlog %d", backlog)
+	}
+	e.acceptQueue = make(chan *Association, backlog)
+	return nil
+}
+
+// Accept returns the next association established by a peer, waiting
+// until there is one or ctx is done.
+func (e *Endpoint) Accept(ctx context.Context) (*Association, error) {
+	e.mu.Lock()
+	queue := e.acceptQueue
+	e.mu.Unlock()
+	if queue == nil {
+		return nil, fmt.Errorf("endpoint not listening")
+	}
+
+	select {
+	case a, ok := <-queue:
+		if !ok {
+			return nil, ErrEndpointClosed
+		}
+		return a, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Connect sets up an association with the endpoint at remote and port,
+// waiting until it is established, fails or ctx is done. An association
+// not established when ctx is done is aborted.
+func (e *Endpoint) Connect(ctx context.Context, remote common.IPv4Address, port uint16) (*Association, error) {
+	a, err := e.dial(remote, port)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-a.established:
+		return a, nil
+	case <-a.done:
+		return nil, a.closeErr()
+	case <-ctx.Done():
+		a.Abort("")
+		return nil, ctx.Err()
+	}
+}
+
+// dial creates an association with remote and port and sends its INIT.
+func (e *Endpoint) dial(remote common.IPv4Address, port uint16) (*Association, error) {
+	if port == 0 {
+		return nil, fmt.Errorf("port is 0")
+	}
+	key := assocKey{remote, port}
+
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil, ErrEndpointClosed
+	}
+	if _, ok := e.assocs[key]; ok {
+		e.mu.Unlock()
+		return nil, fmt.Errorf("association with %s:%d already exists", remote, port)
+	}
+	a := newAssociation(e, remote, port, randomTag(), randomUint32())
+	e.assocs[key] = a
+	e.mu.Unlock()
+
+	a.mu.Lock()
+	a.sendInit()
+	a.unlock()
+	return a, nil
+}
+
+// remove forgets a closed association.
+func (e *Endpoint) remove(a *Association) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := assocKey{a.remote, a.remotePort}
+	if e.assocs[key] == a {
+		delete(e.assocs, key)
+	}
+}
+
+// HandlePacket processes an SCTP packet received from src for dst, the
+// payload of an IP packet. Packets for other ports, with a bad checksum
+// or for no association are dropped, and answered as RFC 9260 Section
+// 8.4 says for packets out of the blue.
+func (e *Endpoint) HandlePacket(data []byte, src, dst common.IPv4Address) error {
+	pkt, err := Parse(data)
+	if err != nil {
+		if errors.Is(err, ErrChecksum) {
+			counters.checksumErrors.Add(1)
+		}
+		return err
+	}
+	if pkt.DestinationPort != e.config.Port {
+		return fmt.Errorf("packet for port %d, endpoint on port %d", pkt.DestinationPort, e.config.Port)
+	}
+	if e.config.LocalAddr != (common.IPv4Address{}) && dst != e.config.LocalAddr {
+		return fmt.Errorf("packet for %s, endpoint on %s", dst, e.config.LocalAddr)
+	}
+	if len(pkt.Chunks) == 0 {
+		return fmt.Errorf("packet without chunks")
+	}
+	counters.packetsReceived.Add(1)
+
+	e.mu.Lock()
+	a := e.assocs[assocKey{src, pkt.SourcePort}]
+	e.mu.Unlock()
+	if a != nil {
+		return a.handle(pkt)
+	}
+	return e.handleOOTB(pkt, src)
+}
+
+// handleOOTB processes a packet for no association: an INIT or
+// COOKIE-ECHO setting one up, or a packet out of the blue.
+func (e *Endpoint) handleOOTB(pkt *Packet, src common.IPv4Address) error {
+	first := pkt.Chunks[0]
+	switch first.Type {
+	case ChunkInit:
+		return e.handleInit(pkt, src)
+	case ChunkCookieEcho:
+		return e.handleCookieEcho(pkt, src)
+	}
+
+	counters.outOfBlues.Add(1)
+	for _, c := range pkt.Chunks {
+		switch c.Type {
+		case ChunkAbort, ChunkShutdownComplete:
+			return fmt.Errorf("%s for no association", c.Type)
+		case ChunkShutdownAck:
+			e.reply(pkt, src, pkt.VerificationTag, Chunk{Type: ChunkShutdownComplete, Flags: flagReflected})
+			return fmt.Errorf("%s for no association", c.Type)
+		}
+	}
+	e.reply(pkt, src, pkt.VerificationTag, abortChunk("", true))
+	return fmt.Errorf("%s for no association", first.Type)
+}
+
+// handleInit answers an INIT with an INIT-ACK carrying the state of the
+// association in a cookie, or aborts it if the endpoint is not listening.
+func (e *Endpoint) handleInit(pkt *Packet, src common.IPv4Address) error {
+	if pkt.VerificationTag != 0 || len(pkt.Chunks) != 1 {
+		return fmt.Errorf("INIT with verification tag %d and %d chunks", pkt.VerificationTag, len(pkt.Chunks))
+	}
+	init, err := parseInit(pkt.Chunks[0])
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	listening := e.acceptQueue != nil && !e.closed
+	e.mu.Unlock()
+	if !listening {
+		counters.outOfBlues.Add(1)
+		e.reply(pkt, src, init.initiateTag, abortChunk("", false))
+		return fmt.Errorf("INIT from %s:%d, not listening", src, pkt.SourcePort)
+	}
+
+	c := &cookie{
+		created:    e.now(),
+		localTag:   randomTag(),
+		peerTag:    init.initiateTag,
+		localTSN:   randomUint32(),
+		peerTSN:    init.initialTSN,
+		peerRwnd:   init.arwnd,
+		outStreams: min(e.config.OutboundStreams, init.inStreams),
+		inStreams:  min(e.config.MaxInboundStreams, init.outStreams),
+		peer:       src,
+		peerPort:   pkt.SourcePort,
+	}
+	ack := &initChunk{
+		initiateTag: c.localTag,
+		arwnd:       e.config.ReceiveWindow,
+		outStreams:  c.outStreams,
+		inStreams:   c.inStreams,
+		initialTSN:  c.localTSN,
+		cookie:      c.seal(e.secret),
+	}
+	e.reply(pkt, src, init.initiateTag, ack.chunk(ChunkInitAck))
+	return nil
+}
+
+// handleCookieEcho establishes the association of a valid state cookie,
+// and processes the chunks bundled after it.
+func (e *Endpoint) handleCookieEcho(pkt *Packet, src common.IPv4Address) error {
+	c, err := openCookie(pkt.Chunks[0].Value, e.secret, src, pkt.SourcePort, e.now(), e.config.CookieLifetime)
+	if err != nil {
+		return err
+	}
+	if pkt.VerificationTag != c.localTag {
+		return fmt.Errorf("COOKIE-ECHO with verification tag %d, cookie for %d", pkt.VerificationTag, c.localTag)
+	}
+
+	key := assocKey{src, pkt.SourcePort}
+	e.mu.Lock()
+	if e.acceptQueue == nil || e.closed {
+		e.mu.Unlock()
+		return fmt.Errorf("COOKIE-ECHO from %s:%d, not listening", src, pkt.SourcePort)
+	}
+	if a := e.assocs[key]; a != nil {
+		// Set up by a COOKIE-ECHO that passed this one
+		e.mu.Unlock()
+		return a.handle(pkt)
+	}
+	a := newAssociation(e, src, pkt.SourcePort, c.localTag, c.localTSN)
+	a.setPeer(c.peerTag, c.peerTSN, c.peerRwnd, c.outStreams, c.inStreams)
+	a.setEstablished()
+	select {
+	case e.acceptQueue <- a:
+	default:
+		e.mu.Unlock()
+		e.reply(pkt, src, c.peerTag, abortChunk("accept queue full", false))
+		return fmt.Errorf("accept queue full")
+	}
+	e.assocs[key] = a
+	e.mu.Unlock()
+	counters.passiveEstablishes.Add(1)
+
+	return a.handle(pkt)
+}
+
+// reply sends a packet with a single chunk to the sender of pkt.
+func (e *Endpoint) reply(pkt *Packet, src common.IPv4Address, tag uint32, c Chunk) {
+	e.transmit(&Packet{
+		SourcePort:      e.config.Port,
+		DestinationPort: pkt.SourcePort,
+		VerificationTag: tag,
+		Chunks:          []Chunk{c},
+	}, src)
+}
+
+// transmit serializes and sends a packet to dst.
+func (e *Endpoint) transmit(pkt *Packet, dst common.IPv4Address) {
+	b, err := pkt.Serialize()
+	if err != nil {
+		return
+	}
+	counters.packetsSent.Add(1)
+	for _, c := range pkt.Chunks {
+		if c.Type != ChunkData {
+			counters.controlChunksSent.Add(1)
+		}
+	}
+	e.config.Send(b, e.config.LocalAddr, dst)
+}
+
+// randomTag returns a random verification tag, which is never 0.
+func randomTag() uint32 {
+	for {
+		if t := randomUint32(); t != 0 {
+			return t
+		}
+	}
+}
+
+// randomUint32 returns a random 32-bit number.
+func randomUint32() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
@@ -0,0 +1,185 @@
+package sctp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	pkt := &Packet{
+		SourcePort:      5000,
+		DestinationPort: 5001,
+		VerificationTag: 0x01020304,
+		Chunks: []Chunk{
+			{Type: ChunkData, Flags: flagBegin | flagEnd, Value: []byte("hello")},
+			{Type: ChunkCookieAck},
+		},
+	}
+	data, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if len(data) != pkt.Size() || len(data)%4 != 0 {
+		t.Errorf("serialized %d bytes, Size() = %d", len(data), pkt.Size())
+	}
+
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.SourcePort != 5000 || got.DestinationPort != 5001 || got.VerificationTag != 0x01020304 || got.Checksum != pkt.Checksum {
+		t.Errorf("Parse() header = %+v, want %+v", got, pkt)
+	}
+	if len(got.Chunks) != 2 || !bytes.Equal(got.Chunks[0].Value, []byte("hello")) || got.Chunks[0].Flags != flagBegin|flagEnd || got.Chunks[1].Type != ChunkCookieAck {
+		t.Errorf("Parse() chunks = %+v, want %+v", got.Chunks, pkt.Chunks)
+	}
+
+	// The padding of the last chunk may be left out
+	pkt.Chunks = pkt.Chunks[:1]
+	data, _ = pkt.Serialize()
+	if got, err := Parse(withChecksum(data[:len(data)-3])); err != nil || string(got.Chunks[0].Value) != "hello" {
+		t.Errorf("Parse() without padding = %v, %v", got, err)
+	}
+}
+
+// withChecksum fills in the checksum of a serialized packet.
+func withChecksum(data []byte) []byte {
+	binary.LittleEndian.PutUint32(data[8:12], checksum(data))
+	return data
+}
+
+func TestChecksum(t *testing.T) {
+	// RFC 3720 Appendix B.4: 32 bytes of zeros
+	if got := checksum(make([]byte, 32)); got != 0x8a9136aa {
+		t.Errorf("checksum() = 0x%08x, want 0x8a9136aa", got)
+	}
+
+	pkt := &Packet{SourcePort: 5000, DestinationPort: 5001, Chunks: []Chunk{{Type: ChunkInit, Value: make([]byte, initLength)}}}
+	data, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	// The checksum is sent least significant byte first
+	if got := binary.LittleEndian.Uint32(data[8:12]); got != checksum(data) {
+		t.Errorf("checksum field = 0x%08x, want 0x%08x", got, checksum(data))
+	}
+	for i := range data {
+		corrupt := append([]byte(nil), data...)
+		corrupt[i] ^= 0x10
+		if _, err := Parse(corrupt); !errors.Is(err, ErrChecksum) {
+			t.Fatalf("Parse() with byte %d corrupted error = %v, want ErrChecksum", i, err)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	valid := func(chunks ...byte) []byte {
+		return withChecksum(append(make([]byte, HeaderLength), chunks...))
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short header", make([]byte, 8)},
+		{"truncated chunk header", valid(0x00, 0x03)},
+		{"chunk length too small", valid(0x0b, 0x00, 0x00, 0x02)},
+		{"chunk length too large", valid(0x0b, 0x00, 0x00, 0x08)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Error("Parse() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestChunks(t *testing.T) {
+	d := &dataChunk{tsn: 7, stream: 2, ssn: 3, ppid: 51, unordered: true, begin: true, data: []byte("abc")}
+	got, err := parseData(d.chunk())
+	if err != nil {
+		t.Fatalf("parseData() error = %v", err)
+	}
+	if got.tsn != 7 || got.stream != 2 || got.ssn != 3 || got.ppid != 51 || !got.unordered || !got.begin || got.end || string(got.data) != "abc" {
+		t.Errorf("parseData() = %+v, want %+v", got, d)
+	}
+
+	s := &sackChunk{cumTSN: 10, arwnd: 1000, gaps: []gapBlock{{2, 3}, {5, 5}}, dups: []uint32{9}}
+	gotSACK, err := parseSACK(s.chunk())
+	if err != nil {
+		t.Fatalf("parseSACK() error = %v", err)
+	}
+	if gotSACK.cumTSN != 10 || gotSACK.arwnd != 1000 || len(gotSACK.gaps) != 2 || gotSACK.gaps[1] != (gapBlock{5, 5}) || len(gotSACK.dups) != 1 {
+		t.Errorf("parseSACK() = %+v, want %+v", gotSACK, s)
+	}
+
+	init := &initChunk{initiateTag: 1, arwnd: 2, outStreams: 3, inStreams: 4, initialTSN: 5}
+	c := init.chunk(ChunkInit)
+	// Parameters unknown here, one to skip and one to stop at
+	c.Value = appendParam(c.Value, 0x8008, []byte{1, 2})
+	c.Value = appendParam(c.Value, 0x0042, []byte{1})
+	c.Value = appendParam(c.Value, paramStateCookie, []byte("ignored"))
+	gotInit, err := parseInit(c)
+	if err != nil {
+		t.Fatalf("parseInit() error = %v", err)
+	}
+	if gotInit.initiateTag != 1 || gotInit.arwnd != 2 || gotInit.outStreams != 3 || gotInit.inStreams != 4 || gotInit.initialTSN != 5 || gotInit.cookie != nil {
+		t.Errorf("parseInit() = %+v, want %+v", gotInit, init)
+	}
+	if _, err := parseInit(init.chunk(ChunkInitAck)); err == nil {
+		t.Error("parseInit() of an INIT-ACK without a cookie succeeded, want error")
+	}
+	zeroTag := *init
+	zeroTag.initiateTag = 0
+	if _, err := parseInit(zeroTag.chunk(ChunkInit)); err == nil {
+		t.Error("parseInit() with initiate tag 0 succeeded, want error")
+	}
+
+	if got := abortReason(abortChunk("bye", false)); got != "bye" {
+		t.Errorf("abortReason() = %q, want %q", got, "bye")
+	}
+}
+
+func TestCookie(t *testing.T) {
+	secret := []byte("secret")
+	peer := common.IPv4Address{10, 0, 0, 1}
+	now := time.Unix(1000, 0)
+	c := &cookie{created: now, localTag: 1, peerTag: 2, localTSN: 3, peerTSN: 4, peerRwnd: 5, outStreams: 6, inStreams: 7, peer: peer, peerPort: 5000}
+	sealed := c.seal(secret)
+
+	got, err := openCookie(sealed, secret, peer, 5000, now.Add(time.Second), time.Minute)
+	if err != nil {
+		t.Fatalf("openCookie() error = %v", err)
+	}
+	if !got.created.Equal(c.created) || got.localTag != 1 || got.peerTSN != 4 || got.inStreams != 7 {
+		t.Errorf("openCookie() = %+v, want %+v", got, c)
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[9] ^= 1
+	tests := []struct {
+		name   string
+		cookie []byte
+		secret []byte
+		port   uint16
+		at     time.Time
+	}{
+		{"tampered", tampered, secret, 5000, now},
+		{"other secret", sealed, []byte("other"), 5000, now},
+		{"other peer", sealed, secret, 5002, now},
+		{"stale", sealed, secret, 5000, now.Add(2 * time.Minute)},
+		{"truncated", sealed[:20], secret, 5000, now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := openCookie(tt.cookie, tt.secret, peer, tt.port, tt.at, time.Minute); err == nil {
+				t.Error("openCookie() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package sctp
+
+import (
+	"slices"
+	"time"
+)
+
+// delayedSACK is how long the SACK of a packet with DATA waits for a
+// second one (RFC 9260 Section 6.2)
+const delayedSACK = 200 * time.Millisecond
+
+// handleData processes a DATA chunk, and reports whether it calls for an
+// immediate SACK: it was a duplicate, arrived out of order, filled a gap
+// or did not fit in the receive window. Chunks that do not fit, such as
+// the probes of a sender facing a zero window, are dropped unless nothing
+// is held. Called with a.mu held.
+func (a *Association) handleData(c Chunk) bool {
+	if a.peerDone {
+		return false
+	}
+	d, err := parseData(c)
+	if err != nil {
+		return false
+	}
+	if _, dup := a.rcvAbove[d.tsn]; dup || !seqBefore(a.rcvCumTSN, d.tsn) {
+		a.rcvDups = append(a.rcvDups, d.tsn)
+		return true
+	}
+	if a.rcvQueued > 0 && uint32(a.rcvQueued+len(d.data)) > a.ep.config.ReceiveWindow {
+		return true
+	}
+
+	gaps := len(a.rcvAbove) > 0
+	next := d.tsn == a.rcvCumTSN+1
+	if next {
+		a.rcvCumTSN++
+		for {
+			if _, ok := a.rcvAbove[a.rcvCumTSN+1]; !ok {
+				break
+			}
+			delete(a.rcvAbove, a.rcvCumTSN+1)
+			a.rcvCumTSN++
+		}
+	} else {
+		a.rcvAbove[d.tsn] = struct{}{}
+	}
+
+	// Chunks on streams the peer may not use are acknowledged and dropped
+	if d.stream < a.inStreams {
+		a.rcvQueued += len(d.data)
+		a.reassemble(d)
+	}
+	if d.unordered {
+		counters.unorderedChunksReceived.Add(1)
+	} else {
+		counters.orderedChunksReceived.Add(1)
+	}
+	return gaps || !next
+}
+
+// reassemble adds a message or fragment to those received, completing
+// the message once every fragment has arrived. The fragments of a
+// message have consecutive TSNs.
+func (a *Association) reassemble(d *dataChunk) {
+	if d.begin && d.end {
+		a.complete(d, d.data)
+		return
+	}
+	a.frags[d.tsn] = d
+
+	first := d
+	for !first.begin {
+		p, ok := a.frags[first.tsn-1]
+		if !ok || p.stream != d.stream || p.ssn != d.ssn || p.end {
+			return
+		}
+		first = p
+	}
+	last := d
+	for !last.end {
+		n, ok := a.frags[last.tsn+1]
+		if !ok || n.stream != d.stream || n.ssn != d.ssn || n.begin {
+			return
+		}
+		last = n
+	}
+
+	var data []byte
+	for tsn := first.tsn; ; tsn++ {
+		data = append(data, a.frags[tsn].data...)
+		delete(a.frags, tsn)
+		if tsn == last.tsn {
+			break
+		}
+	}
+	a.complete(first, data)
+}
+
+// complete delivers a reassembled message: unordered ones at once, the
+// others in stream sequence number order.
+func (a *Association) complete(d *dataChunk, data []byte) {
+	m := Message{Stream: d.stream, PPID: d.ppid, Unordered: d.unordered, Data: data}
+	if d.unordered {
+		a.inbox = append(a.inbox, m)
+		a.signal()
+		return
+	}
+
+	s := d.stream
+	if d.ssn != a.nextSSN[s] {
+		if a.reorder[s] == nil {
+			a.reorder[s] = make(map[uint16]Message)
+		}
+		a.reorder[s][d.ssn] = m
+		return
+	}
+	a.inbox = append(a.inbox, m)
+	a.nextSSN[s]++
+	for {
+		m, ok := a.reorder[s][a.nextSSN[s]]
+		if !ok {
+			break
+		}
+		delete(a.reorder[s], a.nextSSN[s])
+		a.inbox = append(a.inbox, m)
+		a.nextSSN[s]++
+	}
+	a.signal()
+}
+
+// rwnd returns the receive window to advertise.
+func (a *Association) rwnd() uint32 {
+	return a.ep.config.ReceiveWindow - min(a.ep.config.ReceiveWindow, uint32(a.rcvQueued))
+}
+
+// sack returns a SACK of the DATA received, and resets the delayed SACK.
+func (a *Association) sack() Chunk {
+	s := &sackChunk{cumTSN: a.rcvCumTSN, arwnd: a.rwnd(), dups: a.rcvDups}
+
+	above := make([]uint32, 0, len(a.rcvAbove))
+	for tsn := range a.rcvAbove {
+		above = append(above, tsn-a.rcvCumTSN)
+	}
+	slices.Sort(above)
+	// Gap blocks beyond 16 bits of offset wait for the cumulative TSN
+	for _, off := range above {
+		if off > 0xffff {
+			break
+		}
+		if n := len(s.gaps); n > 0 && uint32(s.gaps[n-1].end)+1 == off {
+			s.gaps[n-1].end++
+			continue
+		}
+		s.gaps = append(s.gaps, gapBlock{uint16(off), uint16(off)})
+	}
+
+	a.rcvDups = nil
+	a.sackNeeded = false
+	a.sackAt = time.Time{}
+	a.dataPackets = 0
+	a.advertised = s.arwnd
+	return s.chunk()
+}
@@ -0,0 +1,284 @@
+package sctp
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// fastRetransmitThreshold is how many SACKs report a DATA chunk missing
+// before it is retransmitted without waiting for T3-rtx
+const fastRetransmitThreshold = 3
+
+// flush sends a pending SACK, the DATA chunks marked for retransmission
+// and the queued ones, as far as the congestion window and the peer's
+// receive window allow. Called with a.mu held.
+func (a *Association) flush() {
+	if a.sackNeeded {
+		a.emit(a.sack())
+	}
+	if a.state < StateEstablished {
+		return
+	}
+
+	now := a.ep.now()
+	sent := false
+	for _, o := range a.outstanding {
+		if !o.retransmit {
+			continue
+		}
+		if a.flightSize > 0 && a.flightSize+len(o.data) > a.cwnd {
+			break
+		}
+		a.transmitData(o, now)
+		counters.retransmittedChunks.Add(1)
+		sent = true
+	}
+
+	// Rule A of RFC 9260 Section 6.1: one chunk may be in flight whatever
+	// the window
+	for len(a.sendQueue) > 0 && !a.retransmitPending() {
+		d := a.sendQueue[0]
+		if a.flightSize > 0 && (a.flightSize+len(d.data) > a.cwnd || uint32(len(d.data)) > a.peerRwnd) {
+			break
+		}
+		a.sendQueue = a.sendQueue[1:]
+		d.tsn = a.nextTSN
+		a.nextTSN++
+		o := &sentChunk{dataChunk: d}
+		a.outstanding = append(a.outstanding, o)
+		a.transmitData(o, now)
+		if d.unordered {
+			counters.unorderedChunksSent.Add(1)
+		} else {
+			counters.orderedChunksSent.Add(1)
+		}
+		sent = true
+	}
+
+	if sent {
+		if a.t3.IsZero() {
+			a.t3 = now.Add(a.rto)
+		}
+		if a.heartbeatNonce == 0 && !a.heartbeatAt.IsZero() {
+			a.heartbeatAt = now.Add(a.rto + a.ep.config.HeartbeatInterval)
+		}
+	}
+}
+
+// retransmitPending reports whether chunks marked for retransmission wait
+// for the congestion window, ahead of new data.
+func (a *Association) retransmitPending() bool {
+	for _, o := range a.outstanding {
+		if o.retransmit {
+			return true
+		}
+	}
+	return false
+}
+
+// transmitData sends an outstanding DATA chunk, and counts it in flight.
+func (a *Association) transmitData(o *sentChunk, now time.Time) {
+	o.retransmit = false
+	o.missing = 0
+	o.sent = now
+	o.transmissions++
+	a.flightSize += len(o.data)
+	a.peerRwnd -= min(a.peerRwnd, uint32(len(o.data)))
+	a.emit(o.chunk())
+}
+
+// handleSACK processes a SACK: it frees the chunks acknowledged, grows the
+// congestion window, and retransmits chunks reported missing often enough
+// (RFC 9260 Sections 6.2.1 and 7.2).
+func (a *Association) handleSACK(s *sackChunk) {
+	if seqBefore(s.cumTSN, a.cumAck) {
+		return // Out of date
+	}
+	if !seqBefore(s.cumTSN, a.nextTSN) {
+		return // Acknowledges data never sent
+	}
+	now := a.ep.now()
+	advanced := s.cumTSN != a.cumAck
+	newlyAcked := 0
+	var rtt time.Duration
+	ack := func(o *sentChunk) {
+		if o.acked {
+			return
+		}
+		if !o.retransmit {
+			a.flightSize -= len(o.data)
+		}
+		newlyAcked += len(o.data)
+		// Karn's algorithm: no samples from retransmitted chunks
+		if o.transmissions == 1 && rtt == 0 {
+			rtt = max(now.Sub(o.sent), time.Nanosecond)
+		}
+		o.acked, o.retransmit = true, false
+	}
+
+	acked := 0
+	for _, o := range a.outstanding {
+		if seqBefore(s.cumTSN, o.tsn) {
+			break
+		}
+		ack(o)
+		a.sendQueued -= len(o.data)
+		acked++
+	}
+	a.outstanding = a.outstanding[acked:]
+	a.cumAck = s.cumTSN
+
+	highest := s.cumTSN
+	for _, g := range s.gaps {
+		start, end := s.cumTSN+uint32(g.start), s.cumTSN+uint32(g.end)
+		for _, o := range a.outstanding {
+			if !seqBefore(o.tsn, start) && !seqBefore(end, o.tsn) {
+				ack(o)
+			}
+		}
+		if seqBefore(highest, end) {
+			highest = end
+		}
+	}
+	if rtt > 0 {
+		a.updateRTO(rtt)
+	}
+	if newlyAcked > 0 {
+		a.errorCount = 0
+	}
+
+	if a.fastRecovery && !seqBefore(a.cumAck, a.recoveryExit) {
+		a.fastRecovery = false
+	}
+	if advanced && !a.fastRecovery {
+		a.growWindow(newlyAcked)
+	}
+
+	// Chunks before the highest acknowledged are missing
+	var lost []*sentChunk
+	for _, o := range a.outstanding {
+		if !seqBefore(o.tsn, highest) {
+			break
+		}
+		if o.acked || o.retransmit || o.fastRetransmitted {
+			continue
+		}
+		if o.missing++; o.missing >= fastRetransmitThreshold {
+			lost = append(lost, o)
+		}
+	}
+	a.peerRwnd = s.arwnd - min(s.arwnd, uint32(a.flightSize))
+	if len(lost) > 0 {
+		a.fastRetransmit(lost, now)
+	}
+
+	switch {
+	case len(a.outstanding) == 0:
+		a.t3 = time.Time{}
+	case advanced:
+		a.t3 = now.Add(a.rto)
+	}
+	a.checkShutdown()
+}
+
+// growWindow grows the congestion window after newlyAcked bytes were
+// acknowledged: by up to a packet per SACK in slow start, and by a packet
+// per window in congestion avoidance.
+func (a *Association) growWindow(newlyAcked int) {
+	mtu := a.maxPacket()
+	if a.cwnd <= a.ssthresh {
+		a.cwnd += min(newlyAcked, mtu)
+		return
+	}
+	a.partialBytesAcked += newlyAcked
+	if a.partialBytesAcked >= a.cwnd {
+		a.partialBytesAcked -= a.cwnd
+		a.cwnd += mtu
+	}
+}
+
+// fastRetransmit retransmits the chunks reported missing, as many as fit
+// in a packet regardless of the congestion window, and enters fast
+// recovery unless already in it.
+func (a *Association) fastRetransmit(lost []*sentChunk, now time.Time) {
+	if !a.fastRecovery {
+		a.ssthresh = max(a.cwnd/2, 4*a.maxPacket())
+		a.cwnd = a.ssthresh
+		a.partialBytesAcked = 0
+		a.fastRecovery = true
+		a.recoveryExit = a.nextTSN - 1
+	}
+	room := a.maxPacket() - HeaderLength
+	for _, o := range lost {
+		if size := padded(dataChunkOverhead + len(o.data)); size <= room {
+			room -= size
+			a.flightSize -= len(o.data)
+			o.fastRetransmitted = true
+			a.transmitData(o, now)
+			counters.retransmittedChunks.Add(1)
+			continue
+		}
+		// Sent as the window allows
+		o.retransmit = true
+		a.flightSize -= len(o.data)
+	}
+	a.t3 = now.Add(a.rto)
+	counters.fastRetransmits.Add(1)
+}
+
+// retransmitTimeout handles the expiry of T3-rtx: every chunk in flight
+// is marked for retransmission, and the congestion window drops to a
+// packet (RFC 9260 Section 6.3.3).
+func (a *Association) retransmitTimeout() {
+	a.t3 = time.Time{}
+	if a.failed() {
+		return
+	}
+	a.ssthresh = max(a.cwnd/2, 4*a.maxPacket())
+	a.cwnd = a.maxPacket()
+	a.partialBytesAcked = 0
+	a.fastRecovery = false
+	for _, o := range a.outstanding {
+		if !o.acked && !o.retransmit {
+			o.retransmit = true
+			a.flightSize -= len(o.data)
+		}
+	}
+	counters.t3Timeouts.Add(1)
+	a.t3 = a.ep.now().Add(a.rto)
+}
+
+// heartbeat sends a HEARTBEAT to the idle peer, first counting the last
+// one if it went unanswered.
+func (a *Association) heartbeat() {
+	if a.heartbeatNonce != 0 && a.failed() {
+		return
+	}
+	now := a.ep.now()
+	for a.heartbeatNonce == 0 {
+		a.heartbeatNonce = uint64(randomUint32())<<32 | uint64(randomUint32())
+	}
+	info := binary.BigEndian.AppendUint64(nil, a.heartbeatNonce)
+	info = binary.BigEndian.AppendUint64(info, uint64(now.UnixNano()))
+	a.emit(Chunk{Type: ChunkHeartbeat, Value: appendParam(nil, paramHeartbeatInfo, info)})
+	a.heartbeatAt = now.Add(a.rto + a.ep.config.HeartbeatInterval)
+}
+
+// handleHeartbeatAck measures the round-trip time from the answer to a
+// HEARTBEAT.
+func (a *Association) handleHeartbeatAck(c Chunk) {
+	var info []byte
+	params(c.Value, func(t uint16, v []byte) bool {
+		if t == paramHeartbeatInfo {
+			info = v
+		}
+		return info == nil
+	})
+	if len(info) != 16 || a.heartbeatNonce == 0 || binary.BigEndian.Uint64(info) != a.heartbeatNonce {
+		return
+	}
+	a.heartbeatNonce = 0
+	a.errorCount = 0
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(info[8:])))
+	a.updateRTO(max(a.ep.now().Sub(sent), time.Nanosecond))
+}
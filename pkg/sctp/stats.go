@@ -0,0 +1,72 @@
+package sctp
+
+import "sync/atomic"
+
+// Stats holds SCTP counters for the whole stack, a subset of the SCTP MIB
+// (RFC 3873).
+type Stats struct {
+	ActiveEstablishes       uint64 // Associations connected by this stack
+	PassiveEstablishes      uint64 // Associations accepted from peers
+	Aborted                 uint64 // Associations aborted, by either side
+	Shutdowns               uint64 // Associations shut down gracefully
+	OutOfBlues              uint64 // Packets for no association
+	ChecksumErrors          uint64 // Packets with a bad checksum
+	BadTags                 uint64 // Packets for an association with a wrong verification tag
+	PacketsSent             uint64
+	PacketsReceived         uint64
+	ControlChunksSent       uint64
+	ControlChunksReceived   uint64
+	OrderedChunksSent       uint64 // DATA chunks sent, first transmissions only
+	UnorderedChunksSent     uint64
+	OrderedChunksReceived   uint64 // DATA chunks received, duplicates excluded
+	UnorderedChunksReceived uint64
+	RetransmittedChunks     uint64
+	FastRetransmits         uint64 // Times chunks reported missing were retransmitted
+	T3Timeouts              uint64 // Expiries of the retransmission timer
+}
+
+// counters are the package-wide counters behind GetStats.
+var counters struct {
+	activeEstablishes       atomic.Uint64
+	passiveEstablishes      atomic.Uint64
+	aborted                 atomic.Uint64
+	shutdowns               atomic.Uint64
+	outOfBlues              atomic.Uint64
+	checksumErrors          atomic.Uint64
+	badTags                 atomic.Uint64
+	packetsSent             atomic.Uint64
+	packetsReceived         atomic.Uint64
+	controlChunksSent       atomic.Uint64
+	controlChunksReceived   atomic.Uint64
+	orderedChunksSent       atomic.Uint64
+	unorderedChunksSent     atomic.Uint64
+	orderedChunksReceived   atomic.Uint64
+	unorderedChunksReceived atomic.Uint64
+	retransmittedChunks     atomic.Uint64
+	fastRetransmits         atomic.Uint64
+	t3Timeouts              atomic.Uint64
+}
+
+// GetStats returns a snapshot of the SCTP counters.
+func GetStats() Stats {
+	return Stats{
+		ActiveEstablishes:       counters.activeEstablishes.Load(),
+		PassiveEstablishes:      counters.passiveEstablishes.Load(),
+		Aborted:                 counters.aborted.Load(),
+		Shutdowns:               counters.shutdowns.Load(),
+		OutOfBlues:              counters.outOfBlues.Load(),
+		ChecksumErrors:          counters.checksumErrors.Load(),
+		BadTags:                 counters.badTags.Load(),
+		PacketsSent:             counters.packetsSent.Load(),
+		PacketsReceived:         counters.packetsReceived.Load(),
+		ControlChunksSent:       counters.controlChunksSent.Load(),
+		ControlChunksReceived:   counters.controlChunksReceived.Load(),
+		OrderedChunksSent:       counters.orderedChunksSent.Load(),
+		UnorderedChunksSent:     counters.unorderedChunksSent.Load(),
+		OrderedChunksReceived:   counters.orderedChunksReceived.Load(),
+		UnorderedChunksReceived: counters.unorderedChunksReceived.Load(),
+		RetransmittedChunks:     counters.retransmittedChunks.Load(),
+		FastRetransmits:         counters.fastRetransmits.Load(),
+		T3Timeouts:              counters.t3Timeouts.Load(),
+	}
+}
@@ -0,0 +1,343 @@
+package snmp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/metrics"
+)
+
+const (
+	// DefaultCommunity is the community an agent answers if none is
+	// configured.
+	DefaultCommunity = "public"
+
+	// DefaultInterface is the name the agent reports for its interface if
+	// none is configured.
+	DefaultInterface = "eth0"
+
+	// DefaultMTU is the ifMtu the agent reports if none is configured.
+	DefaultMTU = 1500
+
+	// DefaultMaxMessageSize is the largest response an agent sends if no
+	// limit is configured: one that fits an Ethernet frame unfragmented.
+	DefaultMaxMessageSize = 1472
+)
+
+// metricPrefix is the namespace of the stack's metrics.
+const metricPrefix = "network_"
+
+// Config configures an Agent.
+type Config struct {
+	// Community is the community string requests must carry; others are
+	// dropped. "" means DefaultCommunity.
+	Community string
+
+	// Registry holds the counters the agent reports; nil means
+	// metrics.Default. Objects whose metrics it does not report are
+	// absent from the MIB.
+	Registry *metrics.Registry
+
+	// The values of sysDescr, sysName, sysContact and sysLocation
+	Description string
+	Name        string
+	Contact     string
+	Location    string
+
+	// Interface and MTU describe the interface of the interfaces group,
+	// whose counters are those of all of the stack's devices.
+	Interface string
+	MTU       int
+
+	// MaxMessageSize is the largest response the agent sends; 0 means
+	// DefaultMaxMessageSize.
+	MaxMessageSize int
+}
+
+// Stats holds an agent's counters.
+type Stats struct {
+	Packets      uint64 // Messages received
+	BadVersions  uint64 // Messages dropped for a version other than SNMPv2c
+	BadCommunity uint64 // Messages dropped for the wrong community
+	ParseErrors  uint64 // Messages that could not be parsed
+	Responses    uint64 // Responses sent
+}
+
+// Agent is a read-only SNMPv2c agent serving the stack's MIB-II counters.
+type Agent struct {
+	config  Config
+	mib     mib
+	started time.Time
+	now     func() time.Time
+
+	mu     sync.Mutex
+	conns  map[io.Closer]struct{}
+	closed bool
+
+	packets      atomic.Uint64
+	badVersions  atomic.Uint64
+	badCommunity atomic.Uint64
+	parseErrors  atomic.Uint64
+	responses    atomic.Uint64
+}
+
+// NewAgent creates an agent. Its sysUpTime starts counting from now.
+func NewAgent(config Config) (*Agent, error) {
+	if config.Community == "" {
+		config.Community = DefaultCommunity
+	}
+	if config.Registry == nil {
+		config.Registry = metrics.Default
+	}
+	if config.Interface == "" {
+		config.Interface = DefaultInterface
+	}
+	if config.MTU == 0 {
+		config.MTU = DefaultMTU
+	}
+	if config.MaxMessageSize == 0 {
+		config.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if config.MaxMessageSize < MinMessageSize {
+		return nil, fmt.Errorf("maximum message size %d is below %d", config.MaxMessageSize, MinMessageSize)
+	}
+	if config.MTU < 0 {
+		return nil, fmt.Errorf("invalid MTU %d", config.MTU)
+	}
+
+	a := &Agent{
+		config: config,
+		now:    time.Now,
+		conns:  make(map[io.Closer]struct{}),
+	}
+	a.mib = newMIB(&a.config)
+	a.started = a.now()
+	return a, nil
+}
+
+// Stats returns the agent's counters.
+func (a *Agent) Stats() Stats {
+	return Stats{
+		Packets:      a.packets.Load(),
+		BadVersions:  a.badVersions.Load(),
+		BadCommunity: a.badCommunity.Load(),
+		ParseErrors:  a.parseErrors.Load(),
+		Responses:    a.responses.Load(),
+	}
+}
+
+// Serve answers the requests received on conn, usually bound to Port,
+// until conn or the agent is closed. It returns nil once the agent is
+// closed.
+func (a *Agent) Serve(conn net.PacketConn) error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return fmt.Errorf("agent closed")
+	}
+	a.conns[conn] = struct{}{}
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.conns, conn)
+		a.mu.Unlock()
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			a.mu.Lock()
+			closed := a.closed
+			a.mu.Unlock()
+			if closed {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if reply := a.handle(buf[:n]); reply != nil {
+			if _, err := conn.WriteTo(reply, from); err == nil {
+				a.responses.Add(1)
+			}
+		}
+	}
+}
+
+// Close closes the connections Serve was given.
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	for conn := range a.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// handle returns the response to a message, or nil if it gets none.
+func (a *Agent) handle(data []byte) []byte {
+	a.packets.Add(1)
+	m, err := Parse(data)
+	if err != nil {
+		a.parseErrors.Add(1)
+		return nil
+	}
+	if m.Version != Version2c {
+		a.badVersions.Add(1)
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(m.Community), []byte(a.config.Community)) != 1 {
+		a.badCommunity.Add(1)
+		return nil
+	}
+
+	switch m.PDU.Type {
+	case GetRequest, GetNextRequest, GetBulkRequest, SetRequest:
+	default:
+		return nil
+	}
+	reply := &Message{
+		Version:   m.Version,
+		Community: m.Community,
+		PDU:       PDU{Type: Response, RequestID: m.PDU.RequestID},
+	}
+
+	s, err := a.snapshot()
+	if err != nil {
+		reply.PDU.ErrorStatus = GenErr
+		reply.PDU.VarBinds = m.PDU.VarBinds
+		if len(m.PDU.VarBinds) > 0 {
+			reply.PDU.ErrorIndex = 1
+		}
+		return a.encode(reply, false)
+	}
+
+	switch m.PDU.Type {
+	case GetRequest:
+		for _, vb := range m.PDU.VarBinds {
+			reply.PDU.VarBinds = append(reply.PDU.VarBinds, VarBind{Name: vb.Name, Value: a.mib.get(vb.Name, s)})
+		}
+	case GetNextRequest:
+		for _, vb := range m.PDU.VarBinds {
+			reply.PDU.VarBinds = append(reply.PDU.VarBinds, a.mib.next(vb.Name, s))
+		}
+	case GetBulkRequest:
+		return a.encode(a.bulk(reply, &m.PDU, s), true)
+	case SetRequest:
+		// Nothing is writable (RFC 3416 Section 4.2.5)
+		reply.PDU.VarBinds = m.PDU.VarBinds
+		if len(m.PDU.VarBinds) > 0 {
+			reply.PDU.ErrorStatus, reply.PDU.ErrorIndex = NoCreation, 1
+			if v := a.mib.get(m.PDU.VarBinds[0].Name, s); v.Syntax != SyntaxNoSuchObject && v.Syntax != SyntaxNoSuchInstance {
+				reply.PDU.ErrorStatus = NotWritable
+			}
+		}
+	}
+	return a.encode(reply, false)
+}
+
+func (a *Agent) snapshot() (*snapshot, error) {
+	families, err := a.config.Registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	s := &snapshot{
+		metrics: make(map[string]float64, len(families)),
+		uptime:  a.now().Sub(a.started),
+		agent:   a,
+	}
+	for _, f := range families {
+		// Only unlabeled families map to scalar objects
+		if len(f.Samples) == 1 && len(f.Samples[0].Labels) == 0 {
+			s.metrics[f.Name] = f.Samples[0].Value
+		}
+	}
+	return s, nil
+}
+
+// bulk fills the response to a GetBulkRequest (RFC 3416 Section 4.2.3):
+// the successors of the first NonRepeaters variables, then those of the
+// others, repeated up to MaxRepetitions times or until the MIB view ends.
+func (a *Agent) bulk(reply *Message, req *PDU, s *snapshot) *Message {
+	vbs := req.VarBinds
+	nonRepeaters := min(max(req.NonRepeaters, 0), len(vbs))
+	for _, vb := range vbs[:nonRepeaters] {
+		reply.PDU.VarBinds = append(reply.PDU.VarBinds, a.mib.next(vb.Name, s))
+	}
+
+	repeaters := make([]OID, 0, len(vbs)-nonRepeaters)
+	for _, vb := range vbs[nonRepeaters:] {
+		repeaters = append(repeaters, vb.Name)
+	}
+	// A response holds at most a varbind per two bytes, which bounds the
+	// repetitions however many are asked for
+	limit := a.config.MaxMessageSize / 2
+	for r := 0; r < req.MaxRepetitions && len(repeaters) > 0 && len(reply.PDU.VarBinds) < limit; r++ {
+		ended := true
+		for i, name := range repeaters {
+			vb := a.mib.next(name, s)
+			if vb.Value.Syntax != SyntaxEndOfMIBView {
+				ended = false
+			}
+			reply.PDU.VarBinds = append(reply.PDU.VarBinds, vb)
+			repeaters[i] = vb.Name
+		}
+		if ended {
+			break
+		}
+	}
+	return reply
+}
+
+// encode serializes a response within the maximum message size. A GetBulk
+// response is cut short to fit; other responses that do not fit are
+// replaced by a tooBig error.
+func (a *Agent) encode(reply *Message, truncate bool) []byte {
+	var encoded [][]byte
+	for _, vb := range reply.PDU.VarBinds {
+		b, err := appendVarBind(nil, vb)
+		if err != nil {
+			return nil
+		}
+		encoded = append(encoded, b)
+	}
+
+	limit := a.config.MaxMessageSize
+	n, size := 0, len(reply.wrap(nil))
+	for n < len(encoded) && size+len(encoded[n]) <= limit {
+		size += len(encoded[n])
+		n++
+	}
+	for ; n >= 0; n-- {
+		// Only a GetBulk response loses variable bindings, and never all
+		if n < len(encoded) && (!truncate || n == 0) {
+			break
+		}
+		var vbs []byte
+		for _, b := range encoded[:n] {
+			vbs = append(vbs, b...)
+		}
+		// The lengths of the enclosing sequences may take more octets than
+		// estimated
+		if out := reply.wrap(vbs); len(out) <= limit {
+			return out
+		}
+	}
+
+	tooBig := &Message{
+		Version:   reply.Version,
+		Community: reply.Community,
+		PDU:       PDU{Type: Response, RequestID: reply.PDU.RequestID, ErrorStatus: TooBig},
+	}
+	return tooBig.wrap(nil)
+}
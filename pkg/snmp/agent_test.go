@@ -0,0 +1,353 @@
+package snmp
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/metrics"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testRegistry reports fixed UDP, TCP and ethernet counters, and no IP
+// ones.
+func testRegistry() *metrics.Registry {
+	r := metrics.NewRegistry()
+	r.Register(func() []metrics.Family {
+		values := map[string]float64{
+			"udp_datagrams_received_total":   10,
+			"udp_datagrams_sent_total":       20,
+			"udp_no_ports_total":             3,
+			"udp_receive_errors_total":       1,
+			"udp_checksum_errors_total":      2,
+			"tcp_active_opens_total":         4,
+			"tcp_passive_opens_total":        5,
+			"tcp_segments_received_total":    100,
+			"tcp_segments_sent_total":        90,
+			"tcp_retransmissions_total":      7,
+			"tcp_checksum_errors_total":      0,
+			"tcp_resets_sent_total":          1,
+			"ethernet_received_bytes_total":  1<<32 + 42,
+			"ethernet_sent_bytes_total":      5000,
+			"ethernet_receive_drops_total":   0,
+			"ethernet_fcs_errors_total":      0,
+			"ethernet_transmit_errors_total": 0,
+		}
+		var families []metrics.Family
+		for name, v := range values {
+			families = append(families, metrics.Family{
+				Name:    "network_" + name,
+				Type:    metrics.Counter,
+				Samples: []metrics.Sample{{Value: v}},
+			})
+		}
+		return families
+	})
+	return r
+}
+
+func testAgent(t *testing.T, config Config) (*Agent, *clock) {
+	t.Helper()
+	if config.Registry == nil {
+		config.Registry = testRegistry()
+	}
+	a, err := NewAgent(config)
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+	c := &clock{now: time.Unix(1700000000, 0)}
+	a.now, a.started = c.Now, c.Now()
+	return a, c
+}
+
+// request sends a PDU to the agent and returns the response's.
+func request(t *testing.T, a *Agent, pdu PDU) *PDU {
+	t.Helper()
+	m := &Message{Version: Version2c, Community: DefaultCommunity, PDU: pdu}
+	data, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	reply := a.handle(data)
+	if reply == nil {
+		t.Fatal("handle() returned no response")
+	}
+	if len(reply) > a.config.MaxMessageSize {
+		t.Errorf("response of %d bytes exceeds %d", len(reply), a.config.MaxMessageSize)
+	}
+	r, err := Parse(reply)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if r.PDU.Type != Response || r.PDU.RequestID != pdu.RequestID || r.Community != DefaultCommunity {
+		t.Errorf("reply = %s %d in %q, want a Response to %d", r.PDU.Type, r.PDU.RequestID, r.Community, pdu.RequestID)
+	}
+	return &r.PDU
+}
+
+func names(oids ...string) []VarBind {
+	var vbs []VarBind
+	for _, s := range oids {
+		vbs = append(vbs, VarBind{Name: MustParseOID(s), Value: Value{Syntax: SyntaxNull}})
+	}
+	return vbs
+}
+
+func TestAgentGet(t *testing.T) {
+	a, c := testAgent(t, Config{Description: "test stack", Name: "router1"})
+	c.Advance(5 * time.Second)
+
+	tests := []struct {
+		oid  string
+		want Value
+	}{
+		{"1.3.6.1.2.1.1.1.0", OctetString("test stack")},
+		{"1.3.6.1.2.1.1.3.0", TimeTicks(500)},
+		{"1.3.6.1.2.1.1.5.0", OctetString("router1")},
+		{"1.3.6.1.2.1.2.1.0", Integer(1)},
+		{"1.3.6.1.2.1.2.2.1.2.1", OctetString("eth0")},
+		{"1.3.6.1.2.1.2.2.1.4.1", Integer(1500)},
+		{"1.3.6.1.2.1.2.2.1.10.1", Counter32(42)},           // Wrapped
+		{"1.3.6.1.2.1.31.1.1.1.6.1", Counter64(1<<32 + 42)}, // ifHCInOctets
+		{"1.3.6.1.2.1.6.11.0", Counter32(83)},               // tcpOutSegs without retransmissions
+		{"1.3.6.1.2.1.7.1.0", Counter32(10)},
+		{"1.3.6.1.2.1.7.3.0", Counter32(3)}, // udpInErrors sums two counters
+		{"1.3.6.1.2.1.1.3.1", Value{Syntax: SyntaxNoSuchInstance}},
+		{"1.3.6.1.2.1.2.2.1.10.2", Value{Syntax: SyntaxNoSuchInstance}},
+		{"1.3.6.1.2.1.4.6.0", Value{Syntax: SyntaxNoSuchObject}}, // Not in the registry
+		{"1.3.6.1.4.1.9.9", Value{Syntax: SyntaxNoSuchObject}},
+	}
+	for _, tt := range tests {
+		pdu := request(t, a, PDU{Type: GetRequest, RequestID: 1, VarBinds: names(tt.oid)})
+		if pdu.ErrorStatus != NoError || len(pdu.VarBinds) != 1 {
+			t.Fatalf("GET %s = %+v, want one variable binding", tt.oid, pdu)
+		}
+		vb := pdu.VarBinds[0]
+		if vb.Name.String() != tt.oid || vb.Value.String() != tt.want.String() {
+			t.Errorf("GET %s = %s: %s, want %s", tt.oid, vb.Name, vb.Value, tt.want)
+		}
+	}
+}
+
+func TestAgentWalk(t *testing.T) {
+	a, _ := testAgent(t, Config{})
+	oid := MustParseOID("1.3.6.1.2.1")
+	var walked []OID
+	for i := 0; ; i++ {
+		if i > 100 {
+			t.Fatal("walk did not end")
+		}
+		pdu := request(t, a, PDU{Type: GetNextRequest, RequestID: int32(i), VarBinds: []VarBind{{Name: oid, Value: Value{Syntax: SyntaxNull}}}})
+		vb := pdu.VarBinds[0]
+		if vb.Value.Syntax == SyntaxEndOfMIBView {
+			if vb.Name.Compare(oid) != 0 {
+				t.Errorf("endOfMibView for %s, want %s", vb.Name, oid)
+			}
+			break
+		}
+		if vb.Name.Compare(oid) <= 0 {
+			t.Fatalf("GETNEXT %s = %s, not after it", oid, vb.Name)
+		}
+		if vb.Name.HasPrefix(ipGroup) {
+			t.Errorf("GETNEXT %s = %s, an object missing from the registry", oid, vb.Name)
+		}
+		walked = append(walked, vb.Name)
+		oid = vb.Name
+	}
+	if want := len(a.mib) - 7; len(walked) != want {
+		t.Errorf("walked %d objects, want %d (all but the ip group)", len(walked), want)
+	}
+}
+
+func TestAgentGetBulk(t *testing.T) {
+	a, _ := testAgent(t, Config{})
+	pdu := request(t, a, PDU{
+		Type:           GetBulkRequest,
+		RequestID:      9,
+		NonRepeaters:   1,
+		MaxRepetitions: 3,
+		VarBinds:       names("1.3.6.1.2.1.1.3", "1.3.6.1.2.1.7.1", "1.3.6.1.2.1.7.9.0"),
+	})
+	want := []string{
+		"1.3.6.1.2.1.1.3.0",
+		"1.3.6.1.2.1.7.1.0", "1.3.6.1.2.1.11.1.0",
+		"1.3.6.1.2.1.7.2.0", "1.3.6.1.2.1.11.3.0",
+		"1.3.6.1.2.1.7.3.0", "1.3.6.1.2.1.11.4.0",
+	}
+	var got []string
+	for _, vb := range pdu.VarBinds {
+		got = append(got, vb.Name.String())
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("GETBULK = %v, want %v", got, want)
+	}
+
+	// Repetitions stop at the end of the MIB view
+	pdu = request(t, a, PDU{Type: GetBulkRequest, MaxRepetitions: 10, VarBinds: names("1.3.6.1.2.1.31.1.1.1.6.1")})
+	if len(pdu.VarBinds) != 2 || pdu.VarBinds[1].Value.Syntax != SyntaxEndOfMIBView {
+		t.Errorf("GETBULK at the end = %v, want ifHCOutOctets then endOfMibView", pdu.VarBinds)
+	}
+}
+
+func TestAgentGetBulkTruncated(t *testing.T) {
+	a, _ := testAgent(t, Config{MaxMessageSize: MinMessageSize})
+	pdu := request(t, a, PDU{Type: GetBulkRequest, MaxRepetitions: 1 << 30, VarBinds: names("1.3.6.1.2.1.2", "1.3.6.1.2.1.2")})
+	if pdu.ErrorStatus != NoError || len(pdu.VarBinds) == 0 || len(pdu.VarBinds) >= 2*len(a.mib) {
+		t.Errorf("GETBULK = %s with %d variable bindings, want a partial response", pdu.ErrorStatus, len(pdu.VarBinds))
+	}
+}
+
+func TestAgentTooBig(t *testing.T) {
+	a, _ := testAgent(t, Config{Description: strings.Repeat("x", 500), MaxMessageSize: MinMessageSize})
+	pdu := request(t, a, PDU{Type: GetRequest, RequestID: 3, VarBinds: names("1.3.6.1.2.1.1.1.0", "1.3.6.1.2.1.1.3.0")})
+	if pdu.ErrorStatus != TooBig || len(pdu.VarBinds) != 0 {
+		t.Errorf("GET = %s with %d variable bindings, want tooBig without any", pdu.ErrorStatus, len(pdu.VarBinds))
+	}
+}
+
+func TestAgentSet(t *testing.T) {
+	a, _ := testAgent(t, Config{})
+	tests := []struct {
+		oid  string
+		want ErrorStatus
+	}{
+		{"1.3.6.1.2.1.1.5.0", NotWritable},
+		{"1.3.6.1.2.1.1.5.1", NoCreation},
+	}
+	for _, tt := range tests {
+		vbs := []VarBind{{Name: MustParseOID(tt.oid), Value: OctetString("new")}}
+		pdu := request(t, a, PDU{Type: SetRequest, RequestID: 4, VarBinds: vbs})
+		if pdu.ErrorStatus != tt.want || pdu.ErrorIndex != 1 || len(pdu.VarBinds) != 1 {
+			t.Errorf("SET %s = %s at %d, want %s at 1", tt.oid, pdu.ErrorStatus, pdu.ErrorIndex, tt.want)
+		}
+	}
+}
+
+func TestAgentDrops(t *testing.T) {
+	a, _ := testAgent(t, Config{Community: "secret"})
+	serialize := func(version int, community string, pduType PDUType) []byte {
+		m := &Message{Version: version, Community: community, PDU: PDU{Type: pduType, VarBinds: names("1.3.6.1.2.1.1.3.0")}}
+		data, err := m.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"SNMPv1", serialize(0, "secret", GetRequest)},
+		{"wrong community", serialize(Version2c, "public", GetRequest)},
+		{"malformed", getSysDescr[:10]},
+		{"response", serialize(Version2c, "secret", Response)},
+		{"trap", serialize(Version2c, "secret", TrapV2)},
+	}
+	for _, tt := range tests {
+		if reply := a.handle(tt.data); reply != nil {
+			t.Errorf("%s: handle() answered", tt.name)
+		}
+	}
+	want := Stats{Packets: 5, BadVersions: 1, BadCommunity: 1, ParseErrors: 1}
+	if got := a.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	// The counters are in the snmp group
+	m := &Message{Version: Version2c, Community: "secret", PDU: PDU{Type: GetRequest, VarBinds: names("1.3.6.1.2.1.11.1.0", "1.3.6.1.2.1.11.4.0")}}
+	data, _ := m.Serialize()
+	r, err := Parse(a.handle(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if r.PDU.VarBinds[0].Value.Uint != 6 || r.PDU.VarBinds[1].Value.Uint != 1 {
+		t.Errorf("snmpInPkts, snmpInBadCommunityNames = %v, want 6, 1", r.PDU.VarBinds)
+	}
+}
+
+func TestAgentServe(t *testing.T) {
+	agentIP := common.IPv4Address{192, 168, 1, 1}
+	managerIP := common.IPv4Address{192, 168, 1, 10}
+	hosts := map[common.IPv4Address]*udp.Demultiplexer{
+		agentIP:   udp.NewDemultiplexer(),
+		managerIP: udp.NewDemultiplexer(),
+	}
+	listen := func(host common.IPv4Address, port uint16) net.PacketConn {
+		conn, err := udp.Listen(hosts[host], host, port, func(datagram *udp.Packet, to udp.Address) error {
+			return hosts[to.IP].Deliver(datagram, udp.Address{IP: host, Port: datagram.SourcePort})
+		})
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+		return conn
+	}
+
+	a, _ := testAgent(t, Config{})
+	done := make(chan error, 1)
+	server := listen(agentIP, Port)
+	go func() { done <- a.Serve(server) }()
+
+	conn := listen(managerIP, 0)
+	defer conn.Close()
+	if _, err := conn.WriteTo(getSysDescr, udp.Address{IP: agentIP, Port: Port}); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	m, err := Parse(buf[:n])
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if m.PDU.Type != Response || m.PDU.RequestID != 1 || m.PDU.VarBinds[0].Value.Syntax != SyntaxOctetString {
+		t.Errorf("response = %+v, want sysDescr.0", m.PDU)
+	}
+
+	a.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve() error = %v", err)
+	}
+	if got := a.Stats().Responses; got != 1 {
+		t.Errorf("Stats().Responses = %d, want 1", got)
+	}
+}
+
+func TestNewAgent(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"message size too small", Config{MaxMessageSize: 100}},
+		{"negative MTU", Config{MTU: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAgent(tt.config); err == nil {
+				t.Error("NewAgent() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package snmp
+
+import (
+	"fmt"
+)
+
+// BER tags of the universal types SNMP uses (X.690).
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+)
+
+// appendTLV appends an element with its tag and definite length.
+func appendTLV(b []byte, tag byte, content []byte) []byte {
+	b = append(b, tag)
+	b = appendLength(b, len(content))
+	return append(b, content...)
+}
+
+func appendLength(b []byte, n int) []byte {
+	switch {
+	case n < 0x80:
+		return append(b, byte(n))
+	case n <= 0xff:
+		return append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		return append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		return append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// appendInteger appends a signed integer in the fewest octets.
+func appendInteger(b []byte, tag byte, v int64) []byte {
+	n := 1
+	for n < 8 && (v>>(8*n-1) != 0 && v>>(8*n-1) != -1) {
+		n++
+	}
+	b = append(b, tag, byte(n))
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+// appendUnsigned appends an unsigned integer in the fewest octets, with a
+// leading zero if its high bit is set.
+func appendUnsigned(b []byte, tag byte, v uint64) []byte {
+	n := 1
+	for n < 8 && v>>(8*n) != 0 {
+		n++
+	}
+	if v>>(8*n-1)&1 != 0 {
+		b = append(b, tag, byte(n+1), 0)
+	} else {
+		b = append(b, tag, byte(n))
+	}
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+func appendOID(b []byte, oid OID) ([]byte, error) {
+	if err := oid.validate(); err != nil {
+		return nil, err
+	}
+	content := appendSubidentifier(nil, oid[0]*40+oid[1])
+	for _, id := range oid[2:] {
+		content = appendSubidentifier(content, id)
+	}
+	return appendTLV(b, tagOID, content), nil
+}
+
+// appendSubidentifier appends a sub-identifier in base 128, the high bit
+// marking all octets but the last.
+func appendSubidentifier(b []byte, id uint32) []byte {
+	n := 1
+	for n < 5 && id>>(7*n) != 0 {
+		n++
+	}
+	for i := n - 1; i > 0; i-- {
+		b = append(b, byte(id>>(7*i))|0x80)
+	}
+	return append(b, byte(id&0x7f))
+}
+
+// decoder reads the elements of a BER encoding in turn.
+type decoder struct {
+	data []byte
+}
+
+// next reads the next element. Only single-octet tags and definite lengths,
+// which SNMP requires, are supported.
+func (d *decoder) next() (byte, []byte, error) {
+	if len(d.data) < 2 {
+		return 0, nil, fmt.Errorf("element truncated")
+	}
+	tag := d.data[0]
+	if tag&0x1f == 0x1f {
+		return 0, nil, fmt.Errorf("unsupported multi-octet tag")
+	}
+	length := int(d.data[1])
+	off := 2
+	if length&0x80 != 0 {
+		n := length &^ 0x80
+		if n == 0 || n > 3 {
+			return 0, nil, fmt.Errorf("unsupported length encoding 0x%02x", d.data[1])
+		}
+		if len(d.data) < off+n {
+			return 0, nil, fmt.Errorf("length truncated")
+		}
+		length = 0
+		for _, c := range d.data[off : off+n] {
+			length = length<<8 | int(c)
+		}
+		off += n
+	}
+	if len(d.data)-off < length {
+		return 0, nil, fmt.Errorf("element of %d bytes truncated to %d", length, len(d.data)-off)
+	}
+	content := d.data[off : off+length]
+	d.data = d.data[off+length:]
+	return tag, content, nil
+}
+
+// expect reads the next element, which must have the tag.
+func (d *decoder) expect(tag byte) ([]byte, error) {
+	t, content, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	if t != tag {
+		return nil, fmt.Errorf("tag 0x%02x, want 0x%02x", t, tag)
+	}
+	return content, nil
+}
+
+// integer reads the next element, an INTEGER.
+func (d *decoder) integer() (int64, error) {
+	content, err := d.expect(tagInteger)
+	if err != nil {
+		return 0, err
+	}
+	return parseInteger(content)
+}
+
+func parseInteger(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, fmt.Errorf("invalid integer length %d", len(content))
+	}
+	v := int64(int8(content[0]))
+	for _, c := range content[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+func parseUnsigned(content []byte, bits int) (uint64, error) {
+	if len(content) == 0 || len(content) > bits/8+1 || content[0]&0x80 != 0 {
+		return 0, fmt.Errorf("invalid unsigned integer")
+	}
+	var v uint64
+	for _, c := range content {
+		if v>>(bits-8) != 0 {
+			return 0, fmt.Errorf("unsigned integer overflows %d bits", bits)
+		}
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func parseOID(content []byte) (OID, error) {
+	if len(content) == 0 {
+		return nil, fmt.Errorf("empty object identifier")
+	}
+	var oid OID
+	var id uint64
+	for i, c := range content {
+		if id == 0 && c == 0x80 {
+			return nil, fmt.Errorf("object identifier sub-identifier not minimal")
+		}
+		id = id<<7 | uint64(c&0x7f)
+		if id > 1<<32-1 {
+			return nil, fmt.Errorf("object identifier sub-identifier overflows 32 bits")
+		}
+		if c&0x80 != 0 {
+			if i == len(content)-1 {
+				return nil, fmt.Errorf("object identifier truncated")
+			}
+			continue
+		}
+		if oid == nil {
+			// The first sub-identifier holds the first two arcs
+			switch {
+			case id < 40:
+				oid = OID{0, uint32(id)}
+			case id < 80:
+				oid = OID{1, uint32(id - 40)}
+			default:
+				oid = OID{2, uint32(id - 80)}
+			}
+		} else {
+			oid = append(oid, uint32(id))
+		}
+		id = 0
+	}
+	return oid, nil
+}
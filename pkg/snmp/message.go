@@ -0,0 +1,419 @@
+// Package snmp implements a minimal SNMPv2c agent (RFC 3416) over the
+// stack's UDP sockets.
+//
+// The agent answers GET, GETNEXT and GETBULK requests for the system,
+// interfaces, ip, tcp, udp and snmp groups of MIB-II, read from a
+// metrics.Registry on every request, so the counters network-monitoring
+// systems poll are the ones the metrics endpoint exports:
+//
+//	agent, _ := snmp.NewAgent(snmp.Config{Community: "monitoring"})
+//	conn, _ := udp.Listen(demux, addr, snmp.Port, send)
+//	agent.Serve(conn)
+//
+// The agent is read-only: SET requests are answered with an error.
+package snmp
+
+import (
+	"fmt"
+	"net"
+)
+
+// SNMPv2c message format (RFC 1901, RFC 3416 Section 3), in BER:
+//
+//	Message ::= SEQUENCE {
+//	    version   INTEGER,          -- 1 for SNMPv2c
+//	    community OCTET STRING,
+//	    data      PDU
+//	}
+//	PDU ::= [type] IMPLICIT SEQUENCE {
+//	    request-id   INTEGER,
+//	    error-status INTEGER,       -- non-repeaters in GetBulkRequest
+//	    error-index  INTEGER,       -- max-repetitions in GetBulkRequest
+//	    variable-bindings SEQUENCE OF SEQUENCE {
+//	        name  OBJECT IDENTIFIER,
+//	        value ObjectSyntax
+//	    }
+//	}
+
+const (
+	// Port is the UDP port agents listen on.
+	Port = 161
+
+	// Version2c is the version field of SNMPv2c messages.
+	Version2c = 1
+
+	// MinMessageSize is the message size every SNMP entity accepts (RFC
+	// 3417 Section 3.2).
+	MinMessageSize = 484
+)
+
+// PDUType is the context tag of a PDU.
+type PDUType byte
+
+// PDU types (RFC 3416 Section 3).
+const (
+	GetRequest     PDUType = 0xa0
+	GetNextRequest PDUType = 0xa1
+	Response       PDUType = 0xa2
+	SetRequest     PDUType = 0xa3
+	GetBulkRequest PDUType = 0xa5
+	InformRequest  PDUType = 0xa6
+	TrapV2         PDUType = 0xa7
+	Report         PDUType = 0xa8
+)
+
+func (t PDUType) String() string {
+	switch t {
+	case GetRequest:
+		return "GetRequest"
+	case GetNextRequest:
+		return "GetNextRequest"
+	case Response:
+		return "Response"
+	case SetRequest:
+		return "SetRequest"
+	case GetBulkRequest:
+		return "GetBulkRequest"
+	case InformRequest:
+		return "InformRequest"
+	case TrapV2:
+		return "SNMPv2-Trap"
+	case Report:
+		return "Report"
+	}
+	return fmt.Sprintf("PDUType(0x%02x)", byte(t))
+}
+
+// ErrorStatus is the error-status of a Response.
+type ErrorStatus int
+
+// Error statuses (RFC 3416 Section 3).
+const (
+	NoError     ErrorStatus = 0
+	TooBig      ErrorStatus = 1
+	GenErr      ErrorStatus = 5
+	NoAccess    ErrorStatus = 6
+	NoCreation  ErrorStatus = 11
+	NotWritable ErrorStatus = 17
+)
+
+func (s ErrorStatus) String() string {
+	switch s {
+	case NoError:
+		return "noError"
+	case TooBig:
+		return "tooBig"
+	case GenErr:
+		return "genErr"
+	case NoAccess:
+		return "noAccess"
+	case NoCreation:
+		return "noCreation"
+	case NotWritable:
+		return "notWritable"
+	}
+	return fmt.Sprintf("ErrorStatus(%d)", int(s))
+}
+
+// Syntax is the BER tag of a value.
+type Syntax byte
+
+// Value syntaxes (RFC 2578 Section 7.1, RFC 3416 Section 3).
+const (
+	SyntaxInteger     Syntax = tagInteger
+	SyntaxOctetString Syntax = tagOctetString
+	SyntaxNull        Syntax = tagNull
+	SyntaxOID         Syntax = tagOID
+	SyntaxIPAddress   Syntax = 0x40
+	SyntaxCounter32   Syntax = 0x41
+	SyntaxGauge32     Syntax = 0x42
+	SyntaxTimeTicks   Syntax = 0x43
+	SyntaxOpaque      Syntax = 0x44
+	SyntaxCounter64   Syntax = 0x46
+
+	// Exceptions in place of the value of a response's variable binding
+	SyntaxNoSuchObject   Syntax = 0x80
+	SyntaxNoSuchInstance Syntax = 0x81
+	SyntaxEndOfMIBView   Syntax = 0x82
+)
+
+// Value is the value of a variable binding. Which field holds it depends
+// on the syntax.
+type Value struct {
+	Syntax Syntax
+	Int    int64  // Integer
+	Uint   uint64 // Counter32, Gauge32, TimeTicks and Counter64
+	Bytes  []byte // OctetString, IPAddress and Opaque
+	OID    OID    // OID
+}
+
+// Integer returns an INTEGER value.
+func Integer(v int32) Value { return Value{Syntax: SyntaxInteger, Int: int64(v)} }
+
+// OctetString returns an OCTET STRING value.
+func OctetString(s string) Value { return Value{Syntax: SyntaxOctetString, Bytes: []byte(s)} }
+
+// Counter32 returns a Counter32 value, the counter modulo 2^32.
+func Counter32(v uint64) Value { return Value{Syntax: SyntaxCounter32, Uint: uint64(uint32(v))} }
+
+// Counter64 returns a Counter64 value.
+func Counter64(v uint64) Value { return Value{Syntax: SyntaxCounter64, Uint: v} }
+
+// Gauge32 returns a Gauge32 value.
+func Gauge32(v uint32) Value { return Value{Syntax: SyntaxGauge32, Uint: uint64(v)} }
+
+// TimeTicks returns a TimeTicks value, in hundredths of a second.
+func TimeTicks(v uint32) Value { return Value{Syntax: SyntaxTimeTicks, Uint: uint64(v)} }
+
+// String returns the value for display.
+func (v Value) String() string {
+	switch v.Syntax {
+	case SyntaxInteger:
+		return fmt.Sprintf("INTEGER: %d", v.Int)
+	case SyntaxOctetString:
+		return fmt.Sprintf("STRING: %q", v.Bytes)
+	case SyntaxNull:
+		return "NULL"
+	case SyntaxOID:
+		return "OID: " + v.OID.String()
+	case SyntaxIPAddress:
+		if len(v.Bytes) == 4 {
+			return "IpAddress: " + net.IP(v.Bytes).String()
+		}
+	case SyntaxCounter32:
+		return fmt.Sprintf("Counter32: %d", v.Uint)
+	case SyntaxGauge32:
+		return fmt.Sprintf("Gauge32: %d", v.Uint)
+	case SyntaxTimeTicks:
+		return fmt.Sprintf("Timeticks: %d", v.Uint)
+	case SyntaxCounter64:
+		return fmt.Sprintf("Counter64: %d", v.Uint)
+	case SyntaxNoSuchObject:
+		return "noSuchObject"
+	case SyntaxNoSuchInstance:
+		return "noSuchInstance"
+	case SyntaxEndOfMIBView:
+		return "endOfMibView"
+	}
+	return fmt.Sprintf("Syntax(0x%02x): %x", byte(v.Syntax), v.Bytes)
+}
+
+func (v Value) append(b []byte) ([]byte, error) {
+	switch v.Syntax {
+	case SyntaxInteger:
+		return appendInteger(b, byte(v.Syntax), v.Int), nil
+	case SyntaxOctetString, SyntaxIPAddress, SyntaxOpaque:
+		return appendTLV(b, byte(v.Syntax), v.Bytes), nil
+	case SyntaxNull, SyntaxNoSuchObject, SyntaxNoSuchInstance, SyntaxEndOfMIBView:
+		return append(b, byte(v.Syntax), 0), nil
+	case SyntaxOID:
+		return appendOID(b, v.OID)
+	case SyntaxCounter32, SyntaxGauge32, SyntaxTimeTicks:
+		if v.Uint > 1<<32-1 {
+			return nil, fmt.Errorf("%s out of range", v)
+		}
+		return appendUnsigned(b, byte(v.Syntax), v.Uint), nil
+	case SyntaxCounter64:
+		return appendUnsigned(b, byte(v.Syntax), v.Uint), nil
+	}
+	return nil, fmt.Errorf("unsupported syntax 0x%02x", byte(v.Syntax))
+}
+
+func parseValue(tag byte, content []byte) (Value, error) {
+	v := Value{Syntax: Syntax(tag)}
+	var err error
+	switch v.Syntax {
+	case SyntaxInteger:
+		v.Int, err = parseInteger(content)
+		if err == nil && (v.Int < -1<<31 || v.Int > 1<<31-1) {
+			err = fmt.Errorf("integer out of range")
+		}
+	case SyntaxOctetString, SyntaxOpaque:
+		v.Bytes = append([]byte(nil), content...)
+	case SyntaxIPAddress:
+		if len(content) != 4 {
+			err = fmt.Errorf("IpAddress of %d bytes", len(content))
+		}
+		v.Bytes = append([]byte(nil), content...)
+	case SyntaxNull, SyntaxNoSuchObject, SyntaxNoSuchInstance, SyntaxEndOfMIBView:
+		if len(content) != 0 {
+			err = fmt.Errorf("%s with content", v)
+		}
+	case SyntaxOID:
+		v.OID, err = parseOID(content)
+	case SyntaxCounter32, SyntaxGauge32, SyntaxTimeTicks:
+		v.Uint, err = parseUnsigned(content, 32)
+	case SyntaxCounter64:
+		v.Uint, err = parseUnsigned(content, 64)
+	default:
+		err = fmt.Errorf("unsupported syntax 0x%02x", tag)
+	}
+	return v, err
+}
+
+// VarBind is a variable binding: an object instance and its value.
+type VarBind struct {
+	Name  OID
+	Value Value
+}
+
+// PDU is the protocol data unit of a message.
+type PDU struct {
+	Type        PDUType
+	RequestID   int32
+	ErrorStatus ErrorStatus
+	ErrorIndex  int // 1-based index of the variable binding in error
+
+	// NonRepeaters and MaxRepetitions take the place of ErrorStatus and
+	// ErrorIndex in a GetBulkRequest.
+	NonRepeaters   int
+	MaxRepetitions int
+
+	VarBinds []VarBind
+}
+
+// Message is an SNMPv2c message.
+type Message struct {
+	Version   int
+	Community string
+	PDU       PDU
+}
+
+// Parse parses a message.
+func Parse(data []byte) (*Message, error) {
+	outer := decoder{data}
+	content, err := outer.expect(tagSequence)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	if len(outer.data) != 0 {
+		return nil, fmt.Errorf("%d bytes after message", len(outer.data))
+	}
+
+	d := decoder{content}
+	version, err := d.integer()
+	if err != nil {
+		return nil, fmt.Errorf("invalid version: %w", err)
+	}
+	community, err := d.expect(tagOctetString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid community: %w", err)
+	}
+	tag, pduContent, err := d.next()
+	if err != nil {
+		return nil, fmt.Errorf("invalid PDU: %w", err)
+	}
+	m := &Message{Version: int(version), Community: string(community)}
+	m.PDU.Type = PDUType(tag)
+	if err := m.PDU.parse(pduContent); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", m.PDU.Type, err)
+	}
+	return m, nil
+}
+
+func (p *PDU) parse(content []byte) error {
+	if p.Type&0xe0 != 0xa0 {
+		return fmt.Errorf("not a PDU")
+	}
+	d := decoder{content}
+	id, err := d.integer()
+	if err != nil {
+		return fmt.Errorf("request-id: %w", err)
+	}
+	status, err := d.integer()
+	if err != nil {
+		return fmt.Errorf("error-status: %w", err)
+	}
+	index, err := d.integer()
+	if err != nil {
+		return fmt.Errorf("error-index: %w", err)
+	}
+	if id < -1<<31 || id > 1<<31-1 || status < 0 || status > 1<<31-1 || index < 0 || index > 1<<31-1 {
+		return fmt.Errorf("field out of range")
+	}
+	p.RequestID = int32(id)
+	if p.Type == GetBulkRequest {
+		p.NonRepeaters, p.MaxRepetitions = int(status), int(index)
+	} else {
+		p.ErrorStatus, p.ErrorIndex = ErrorStatus(status), int(index)
+	}
+
+	list, err := d.expect(tagSequence)
+	if err != nil {
+		return fmt.Errorf("variable-bindings: %w", err)
+	}
+	vbs := decoder{list}
+	for len(vbs.data) > 0 {
+		vb, err := vbs.expect(tagSequence)
+		if err != nil {
+			return fmt.Errorf("variable binding: %w", err)
+		}
+		fields := decoder{vb}
+		name, err := fields.expect(tagOID)
+		if err != nil {
+			return fmt.Errorf("variable binding name: %w", err)
+		}
+		oid, err := parseOID(name)
+		if err != nil {
+			return err
+		}
+		tag, value, err := fields.next()
+		if err != nil {
+			return fmt.Errorf("variable binding value: %w", err)
+		}
+		v, err := parseValue(tag, value)
+		if err != nil {
+			return fmt.Errorf("variable binding %s: %w", oid, err)
+		}
+		p.VarBinds = append(p.VarBinds, VarBind{Name: oid, Value: v})
+	}
+	return nil
+}
+
+// Serialize encodes the message.
+func (m *Message) Serialize() ([]byte, error) {
+	vbs, err := appendVarBinds(nil, m.PDU.VarBinds)
+	if err != nil {
+		return nil, err
+	}
+	return m.wrap(vbs), nil
+}
+
+// wrap encodes the message around its encoded variable bindings.
+func (m *Message) wrap(vbs []byte) []byte {
+	status, index := int64(m.PDU.ErrorStatus), int64(m.PDU.ErrorIndex)
+	if m.PDU.Type == GetBulkRequest {
+		status, index = int64(m.PDU.NonRepeaters), int64(m.PDU.MaxRepetitions)
+	}
+	pdu := appendInteger(nil, tagInteger, int64(m.PDU.RequestID))
+	pdu = appendInteger(pdu, tagInteger, status)
+	pdu = appendInteger(pdu, tagInteger, index)
+	pdu = appendTLV(pdu, tagSequence, vbs)
+
+	msg := appendInteger(nil, tagInteger, int64(m.Version))
+	msg = appendTLV(msg, tagOctetString, []byte(m.Community))
+	msg = appendTLV(msg, byte(m.PDU.Type), pdu)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+func appendVarBinds(b []byte, vbs []VarBind) ([]byte, error) {
+	for _, vb := range vbs {
+		encoded, err := appendVarBind(nil, vb)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, encoded...)
+	}
+	return b, nil
+}
+
+func appendVarBind(b []byte, vb VarBind) ([]byte, error) {
+	content, err := appendOID(nil, vb.Name)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = vb.Value.append(content); err != nil {
+		return nil, fmt.Errorf("variable binding %s: %w", vb.Name, err)
+	}
+	return appendTLV(b, tagSequence, content), nil
+}
@@ -0,0 +1,222 @@
+package snmp
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// getSysDescr is the GetRequest for sysDescr.0 in the community public
+// with request-id 1, as snmpget sends it.
+var getSysDescr = []byte{
+	0x30, 0x26, 0x02, 0x01, 0x01, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c',
+	0xa0, 0x19, 0x02, 0x01, 0x01, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00,
+	0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x05, 0x00,
+}
+
+func TestParseKnownMessage(t *testing.T) {
+	m, err := Parse(getSysDescr)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := &Message{
+		Version:   Version2c,
+		Community: "public",
+		PDU: PDU{
+			Type:      GetRequest,
+			RequestID: 1,
+			VarBinds:  []VarBind{{Name: MustParseOID("1.3.6.1.2.1.1.1.0"), Value: Value{Syntax: SyntaxNull}}},
+		},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Parse() = %+v, want %+v", m, want)
+	}
+
+	data, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if !bytes.Equal(data, getSysDescr) {
+		t.Errorf("Serialize() = %x, want %x", data, getSysDescr)
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	values := []Value{
+		Integer(0),
+		Integer(-1),
+		Integer(128),
+		Integer(-129),
+		Integer(1<<31 - 1),
+		Integer(-1 << 31),
+		OctetString("eth0"),
+		{Syntax: SyntaxNull},
+		{Syntax: SyntaxOID, OID: MustParseOID("1.3.6.1.4.1.2021.400000")},
+		{Syntax: SyntaxOID, OID: MustParseOID("2.999.3")},
+		{Syntax: SyntaxIPAddress, Bytes: []byte{192, 0, 2, 1}},
+		Counter32(0),
+		Counter32(1<<32 - 1),
+		Counter32(1<<32 + 5), // Wraps to 5
+		Gauge32(1 << 31),
+		TimeTicks(360000),
+		Counter64(1<<64 - 1),
+		{Syntax: SyntaxOpaque, Bytes: []byte{1, 2, 3}},
+		{Syntax: SyntaxNoSuchObject},
+		{Syntax: SyntaxNoSuchInstance},
+		{Syntax: SyntaxEndOfMIBView},
+	}
+	m := &Message{Version: Version2c, Community: "c", PDU: PDU{Type: Response, RequestID: -12345, ErrorStatus: TooBig, ErrorIndex: 3}}
+	for i, v := range values {
+		m.PDU.VarBinds = append(m.PDU.VarBinds, VarBind{Name: OID{1, 3, 6, 1, 99, uint32(i)}, Value: v})
+	}
+
+	data, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Parse() = %+v, want %+v", got, m)
+	}
+	if v := got.PDU.VarBinds[13].Value; v.Uint != 5 {
+		t.Errorf("Counter32(1<<32 + 5) = %v, want 5", v)
+	}
+}
+
+func TestGetBulkRoundTrip(t *testing.T) {
+	m := &Message{Version: Version2c, Community: "public", PDU: PDU{
+		Type:           GetBulkRequest,
+		RequestID:      7,
+		NonRepeaters:   1,
+		MaxRepetitions: 10,
+		VarBinds:       []VarBind{{Name: MustParseOID("1.3.6.1.2.1.1.3"), Value: Value{Syntax: SyntaxNull}}},
+	}}
+	data, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Parse() = %+v, want %+v", got, m)
+	}
+}
+
+func TestLongLengths(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	m := &Message{Version: Version2c, Community: "public", PDU: PDU{
+		Type:     Response,
+		VarBinds: []VarBind{{Name: MustParseOID("1.3.6.1.2.1.1.1.0"), Value: OctetString(long)}},
+	}}
+	data, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if data[1] != 0x82 {
+		t.Errorf("message length encoded as 0x%02x, want the two-octet long form", data[1])
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if string(got.PDU.VarBinds[0].Value.Bytes) != long {
+		t.Error("long string changed in a round trip")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	replace := func(i int, b ...byte) []byte {
+		data := append([]byte(nil), getSysDescr...)
+		copy(data[i:], b)
+		return data
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"empty", nil, "truncated"},
+		{"truncated", getSysDescr[:20], "truncated"},
+		{"trailing bytes", append(append([]byte(nil), getSysDescr...), 0), "after message"},
+		{"not a sequence", replace(0, 0x31), "invalid message"},
+		{"indefinite length", replace(1, 0x80), "unsupported length"},
+		{"community not a string", replace(5, 0x02), "invalid community"},
+		{"unknown PDU", replace(13, 0x30), "not a PDU"},
+		{"empty integer", replace(15, 0x02, 0x00), "invalid"},
+		{"value with content", replace(38, 0x05, 0x01), "truncated"},
+		{"unknown syntax", replace(38, 0x47), "unsupported syntax"},
+		{"non-minimal sub-identifier", replace(30, 0x80), "not minimal"},
+		{"truncated sub-identifier", replace(37, 0x81), "object identifier truncated"},
+		{"multi-octet tag", replace(38, 0x5f), "multi-octet tag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSerializeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		vb   VarBind
+	}{
+		{"short name", VarBind{Name: OID{1}, Value: Value{Syntax: SyntaxNull}}},
+		{"invalid first arc", VarBind{Name: OID{3, 1}, Value: Value{Syntax: SyntaxNull}}},
+		{"invalid second arc", VarBind{Name: OID{1, 40}, Value: Value{Syntax: SyntaxNull}}},
+		{"gauge out of range", VarBind{Name: OID{1, 3}, Value: Value{Syntax: SyntaxGauge32, Uint: 1 << 32}}},
+		{"unknown syntax", VarBind{Name: OID{1, 3}, Value: Value{Syntax: 0x47}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Message{Version: Version2c, PDU: PDU{Type: Response, VarBinds: []VarBind{tt.vb}}}
+			if _, err := m.Serialize(); err == nil {
+				t.Error("Serialize() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestOID(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    OID
+		wantErr bool
+	}{
+		{"1.3.6.1.2.1.1.3.0", OID{1, 3, 6, 1, 2, 1, 1, 3, 0}, false},
+		{".1.3.6", OID{1, 3, 6}, false},
+		{"1", nil, true},
+		{"1.3.x", nil, true},
+		{"1.3.4294967296", nil, true},
+		{"", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOID(tt.s)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseOID(%q) = %v, %v; want %v, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+		if err == nil && got.String() != strings.TrimPrefix(tt.s, ".") {
+			t.Errorf("String() = %q, want %q", got.String(), strings.TrimPrefix(tt.s, "."))
+		}
+	}
+
+	ordered := []string{"1.3.6", "1.3.6.1", "1.3.6.1.2", "1.3.6.2", "1.3.7", "1.3.10"}
+	for i := 1; i < len(ordered); i++ {
+		a, b := MustParseOID(ordered[i-1]), MustParseOID(ordered[i])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 || a.Compare(a) != 0 {
+			t.Errorf("Compare() does not order %s before %s", a, b)
+		}
+	}
+	if !MustParseOID("1.3.6.1.2").HasPrefix(MustParseOID("1.3.6")) || MustParseOID("1.3.7").HasPrefix(MustParseOID("1.3.6")) {
+		t.Error("HasPrefix() is wrong")
+	}
+}
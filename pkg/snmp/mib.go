@@ -0,0 +1,187 @@
+package snmp
+
+import (
+	"sort"
+	"time"
+)
+
+// Object identifiers of the MIB-II groups (RFC 1213) the agent serves.
+var (
+	mib2       = OID{1, 3, 6, 1, 2, 1}
+	system     = mib2.Append(1)
+	interfaces = mib2.Append(2)
+	ipGroup    = mib2.Append(4)
+	tcpGroup   = mib2.Append(6)
+	udpGroup   = mib2.Append(7)
+	snmpGroup  = mib2.Append(11)
+	ifXEntry   = mib2.Append(31, 1, 1, 1) // RFC 2863
+)
+
+// ifIndex is the index of the interface the agent reports, which counts the
+// frames of all of the stack's devices.
+const ifIndex = 1
+
+const (
+	ethernetCsmacd = 6 // ifType (IANAifType-MIB)
+	statusUp       = 1 // ifAdminStatus and ifOperStatus
+)
+
+// snapshot is what the values of a request are read from, so that they
+// are consistent across its variable bindings.
+type snapshot struct {
+	metrics map[string]float64
+	uptime  time.Duration
+	agent   *Agent
+}
+
+// object is an instance of the MIB. value reports false if the object is
+// not available, such as when its metric is not in the registry.
+type object struct {
+	name  string // Its descriptor, without the instance
+	oid   OID
+	value func(s *snapshot) (Value, bool)
+}
+
+// mib is a set of objects, sorted by identifier.
+type mib []object
+
+// newMIB returns the objects the agent serves.
+func newMIB(config *Config) mib {
+	ifName := OctetString(config.Interface)
+	m := mib{
+		{"sysDescr", system.Append(1, 0), constant(OctetString(config.Description))},
+		{"sysUpTime", system.Append(3, 0), func(s *snapshot) (Value, bool) {
+			return TimeTicks(uint32(s.uptime / (10 * time.Millisecond))), true
+		}},
+		{"sysContact", system.Append(4, 0), constant(OctetString(config.Contact))},
+		{"sysName", system.Append(5, 0), constant(OctetString(config.Name))},
+		{"sysLocation", system.Append(6, 0), constant(OctetString(config.Location))},
+
+		{"ifNumber", interfaces.Append(1, 0), constant(Integer(1))},
+		{"ifIndex", ifEntry(1), constant(Integer(ifIndex))},
+		{"ifDescr", ifEntry(2), constant(ifName)},
+		{"ifType", ifEntry(3), constant(Integer(ethernetCsmacd))},
+		{"ifMtu", ifEntry(4), constant(Integer(int32(config.MTU)))},
+		{"ifAdminStatus", ifEntry(7), constant(Integer(statusUp))},
+		{"ifOperStatus", ifEntry(8), constant(Integer(statusUp))},
+		{"ifInOctets", ifEntry(10), counter32("ethernet_received_bytes_total")},
+		{"ifInDiscards", ifEntry(13), counter32("ethernet_receive_drops_total")},
+		{"ifInErrors", ifEntry(14), counter32("ethernet_fcs_errors_total")},
+		{"ifOutOctets", ifEntry(16), counter32("ethernet_sent_bytes_total")},
+		{"ifOutErrors", ifEntry(20), counter32("ethernet_transmit_errors_total")},
+		{"ifName", ifXEntry.Append(1, ifIndex), constant(ifName)},
+		{"ifHCInOctets", ifXEntry.Append(6, ifIndex), counter64("ethernet_received_bytes_total")},
+		{"ifHCOutOctets", ifXEntry.Append(10, ifIndex), counter64("ethernet_sent_bytes_total")},
+
+		{"ipInHdrErrors", ipGroup.Append(4, 0), counter32("ip_checksum_errors_total", "ip_ttl_exceeded_total")},
+		{"ipForwDatagrams", ipGroup.Append(6, 0), counter32("ip_forwarded_total")},
+		{"ipReasmReqds", ipGroup.Append(14, 0), counter32("ip_fragments_received_total")},
+		{"ipReasmOKs", ipGroup.Append(15, 0), counter32("ip_reassembled_total")},
+		{"ipReasmFails", ipGroup.Append(16, 0), counter32("ip_reassembly_timeouts_total", "ip_reassembly_overlaps_total",
+			"ip_reassembly_errors_total", "ip_reassembly_evictions_total")},
+		{"ipFragOKs", ipGroup.Append(17, 0), counter32("ip_fragmented_total")},
+		{"ipFragCreates", ipGroup.Append(19, 0), counter32("ip_fragments_created_total")},
+
+		{"tcpActiveOpens", tcpGroup.Append(5, 0), counter32("tcp_active_opens_total")},
+		{"tcpPassiveOpens", tcpGroup.Append(6, 0), counter32("tcp_passive_opens_total")},
+		{"tcpInSegs", tcpGroup.Append(10, 0), counter32("tcp_segments_received_total")},
+		{"tcpOutSegs", tcpGroup.Append(11, 0), tcpOutSegs(Counter32)},
+		{"tcpRetransSegs", tcpGroup.Append(12, 0), counter32("tcp_retransmissions_total")},
+		{"tcpInErrs", tcpGroup.Append(14, 0), counter32("tcp_checksum_errors_total")},
+		{"tcpOutRsts", tcpGroup.Append(15, 0), counter32("tcp_resets_sent_total")},
+		{"tcpHCInSegs", tcpGroup.Append(17, 0), counter64("tcp_segments_received_total")},
+		{"tcpHCOutSegs", tcpGroup.Append(18, 0), tcpOutSegs(Counter64)},
+
+		{"udpInDatagrams", udpGroup.Append(1, 0), counter32("udp_datagrams_received_total")},
+		{"udpNoPorts", udpGroup.Append(2, 0), counter32("udp_no_ports_total")},
+		{"udpInErrors", udpGroup.Append(3, 0), counter32("udp_receive_errors_total", "udp_checksum_errors_total")},
+		{"udpOutDatagrams", udpGroup.Append(4, 0), counter32("udp_datagrams_sent_total")},
+		{"udpHCInDatagrams", udpGroup.Append(8, 0), counter64("udp_datagrams_received_total")},
+		{"udpHCOutDatagrams", udpGroup.Append(9, 0), counter64("udp_datagrams_sent_total")},
+
+		{"snmpInPkts", snmpGroup.Append(1, 0), agentCounter(func(a *Agent) uint64 { return a.packets.Load() })},
+		{"snmpInBadVersions", snmpGroup.Append(3, 0), agentCounter(func(a *Agent) uint64 { return a.badVersions.Load() })},
+		{"snmpInBadCommunityNames", snmpGroup.Append(4, 0), agentCounter(func(a *Agent) uint64 { return a.badCommunity.Load() })},
+		{"snmpInASNParseErrs", snmpGroup.Append(6, 0), agentCounter(func(a *Agent) uint64 { return a.parseErrors.Load() })},
+	}
+	sort.Slice(m, func(i, j int) bool { return m[i].oid.Compare(m[j].oid) < 0 })
+	return m
+}
+
+func ifEntry(column uint32) OID {
+	return interfaces.Append(2, 1, column, ifIndex)
+}
+
+func constant(v Value) func(*snapshot) (Value, bool) {
+	return func(*snapshot) (Value, bool) { return v, true }
+}
+
+// counter32 and counter64 return the sum of the stack's metrics, without
+// their namespace prefix.
+func counter32(names ...string) func(*snapshot) (Value, bool) {
+	return sum(Counter32, names)
+}
+
+func counter64(names ...string) func(*snapshot) (Value, bool) {
+	return sum(Counter64, names)
+}
+
+func sum(syntax func(uint64) Value, names []string) func(*snapshot) (Value, bool) {
+	return func(s *snapshot) (Value, bool) {
+		var total uint64
+		for _, name := range names {
+			v, ok := s.metrics[metricPrefix+name]
+			if !ok {
+				return Value{}, false
+			}
+			total += uint64(v)
+		}
+		return syntax(total), true
+	}
+}
+
+// tcpOutSegs excludes retransmissions from the segments sent (RFC 4022).
+func tcpOutSegs(syntax func(uint64) Value) func(*snapshot) (Value, bool) {
+	return func(s *snapshot) (Value, bool) {
+		sent, ok := s.metrics[metricPrefix+"tcp_segments_sent_total"]
+		retransmitted, ok2 := s.metrics[metricPrefix+"tcp_retransmissions_total"]
+		if !ok || !ok2 {
+			return Value{}, false
+		}
+		return syntax(uint64(sent) - min(uint64(retransmitted), uint64(sent))), true
+	}
+}
+
+func agentCounter(f func(*Agent) uint64) func(*snapshot) (Value, bool) {
+	return func(s *snapshot) (Value, bool) { return Counter32(f(s.agent)), true }
+}
+
+// get returns the value of an object instance, or the exception for it.
+func (m mib) get(oid OID, s *snapshot) Value {
+	i := sort.Search(len(m), func(i int) bool { return m[i].oid.Compare(oid) >= 0 })
+	if i < len(m) && m[i].oid.Compare(oid) == 0 {
+		if v, ok := m[i].value(s); ok {
+			return v
+		}
+		return Value{Syntax: SyntaxNoSuchObject}
+	}
+	// An unknown instance of a known object, such as sysUpTime.1
+	for _, o := range m {
+		if len(oid) >= len(o.oid) && oid.HasPrefix(o.oid[:len(o.oid)-1]) {
+			return Value{Syntax: SyntaxNoSuchInstance}
+		}
+	}
+	return Value{Syntax: SyntaxNoSuchObject}
+}
+
+// next returns the first available object instance after oid, or the
+// endOfMibView exception with oid.
+func (m mib) next(oid OID, s *snapshot) VarBind {
+	i := sort.Search(len(m), func(i int) bool { return m[i].oid.Compare(oid) > 0 })
+	for ; i < len(m); i++ {
+		if v, ok := m[i].value(s); ok {
+			return VarBind{Name: m[i].oid, Value: v}
+		}
+	}
+	return VarBind{Name: oid, Value: Value{Syntax: SyntaxEndOfMIBView}}
+}
@@ -0,0 +1,88 @@
+package snmp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OID is an object identifier, such as 1.3.6.1.2.1.1.3.0 for sysUpTime.0.
+type OID []uint32
+
+// ParseOID parses an object identifier in dotted notation, with or without
+// a leading dot.
+func ParseOID(s string) (OID, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	oid := make(OID, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object identifier %q", s)
+		}
+		oid[i] = uint32(v)
+	}
+	if err := oid.validate(); err != nil {
+		return nil, err
+	}
+	return oid, nil
+}
+
+// MustParseOID is like ParseOID but panics on an invalid identifier.
+func MustParseOID(s string) OID {
+	oid, err := ParseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return oid
+}
+
+// validate checks that the identifier can be encoded: it has at least two
+// arcs, the first 0, 1 or 2, and the second below 40 unless the first is 2.
+func (o OID) validate() error {
+	if len(o) < 2 || o[0] > 2 || (o[0] < 2 && o[1] >= 40) || (o[0] == 2 && o[1] > 1<<32-1-80) {
+		return fmt.Errorf("invalid object identifier %s", o)
+	}
+	return nil
+}
+
+// String returns the identifier in dotted notation.
+func (o OID) String() string {
+	var b strings.Builder
+	for i, id := range o {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(strconv.FormatUint(uint64(id), 10))
+	}
+	return b.String()
+}
+
+// Compare orders identifiers lexicographically by arc, the order GETNEXT
+// walks the MIB in. It returns -1, 0 or 1.
+func (o OID) Compare(other OID) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		switch {
+		case o[i] < other[i]:
+			return -1
+		case o[i] > other[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(o) < len(other):
+		return -1
+	case len(o) > len(other):
+		return 1
+	}
+	return 0
+}
+
+// HasPrefix reports whether the identifier is prefix or below it.
+func (o OID) HasPrefix(prefix OID) bool {
+	return len(o) >= len(prefix) && o[:len(prefix)].Compare(prefix) == 0
+}
+
+// Append returns a new identifier with the arcs added.
+func (o OID) Append(arcs ...uint32) OID {
+	return append(append(OID(nil), o...), arcs...)
+}
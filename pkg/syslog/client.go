@@ -0,0 +1,400 @@
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+const (
+	// Port is the port collectors receive messages on.
+	Port = 514
+
+	// DefaultMaxMessageSize is the largest datagram a client sends if no
+	// limit is configured, the size RFC 5426 Section 3.2 recommends
+	// collectors accept.
+	DefaultMaxMessageSize = 2048
+
+	// MinMaxMessageSize is the size every collector accepts over UDP.
+	MinMaxMessageSize = 480
+
+	// DefaultQueueSize is how many log records wait to be sent before
+	// further ones are dropped, if not configured.
+	DefaultQueueSize = 1024
+
+	// DefaultTimeout bounds each TCP write, if not configured.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultRedialInterval is how long a client waits after a failed
+	// connection before dialing again, if not configured.
+	DefaultRedialInterval = time.Second
+)
+
+// ErrClosed is returned when sending with a closed client.
+var ErrClosed = errors.New("syslog: client closed")
+
+// Config configures a Client. Either Conn and Addr or Dial must be set.
+type Config struct {
+	// Conn and Addr send each message as a datagram to the collector at
+	// Addr, for instance from a connection udp.Listen opened on an
+	// ephemeral port.
+	Conn net.PacketConn
+	Addr net.Addr
+
+	// Dial opens a TCP connection to the collector, such as a tcp.NetConn
+	// over the stack. It is called again after the connection fails.
+	Dial func() (net.Conn, error)
+
+	// Facility of the messages logged; FacilityKernel, the zero value,
+	// means FacilityUser, as only the kernel sends kernel messages.
+	Facility Facility
+
+	// Header fields of the messages; "" means the host name, the program
+	// name and the process ID.
+	Hostname string
+	AppName  string
+	ProcID   string
+
+	// StructuredDataID, if set, is the SD-ID of the element log record
+	// fields are sent as, such as "fields@32473". Otherwise the fields are
+	// appended to the message in logfmt.
+	StructuredDataID string
+
+	// MaxMessageSize is the size datagrams are truncated to; 0 means
+	// DefaultMaxMessageSize. TCP messages are not truncated.
+	MaxMessageSize int
+
+	QueueSize      int           // 0 means DefaultQueueSize
+	Timeout        time.Duration // 0 means DefaultTimeout
+	RedialInterval time.Duration // 0 means DefaultRedialInterval
+}
+
+// Stats holds a client's counters.
+type Stats struct {
+	Sent      uint64 // Messages sent
+	Dropped   uint64 // Log records dropped because the queue was full
+	Truncated uint64 // Datagrams truncated to the maximum message size
+	Errors    uint64 // Messages that failed to send
+}
+
+// Client sends syslog messages to a collector. Log records are queued and
+// sent from a goroutine of the client, so Log never calls into the stack
+// while the caller may hold a protocol lock.
+//
+// Records the stack logs about sending the client's own messages are
+// forwarded too, so the subsystems carrying them should not log at
+// LevelTrace.
+type Client struct {
+	config Config
+	now    func() time.Time
+
+	queueMu sync.Mutex // Guards queue against sends after Close
+	queue   chan *Message
+	closed  bool
+	done    chan struct{}
+
+	connMu     sync.Mutex // Serializes sends
+	conn       net.Conn   // TCP connection, or nil
+	dialFailed time.Time  // When dialing last failed
+
+	sent      atomic.Uint64
+	dropped   atomic.Uint64
+	truncated atomic.Uint64
+	errors    atomic.Uint64
+}
+
+// NewClient creates a client and starts sending the records it is given.
+func NewClient(config Config) (*Client, error) {
+	if (config.Conn == nil) == (config.Dial == nil) {
+		return nil, fmt.Errorf("exactly one of a UDP connection and a dial function is needed")
+	}
+	if config.Conn != nil && config.Addr == nil {
+		return nil, fmt.Errorf("collector address is nil")
+	}
+	if config.Facility == FacilityKernel {
+		config.Facility = FacilityUser
+	}
+	if config.Facility < FacilityKernel || config.Facility > FacilityLocal7 {
+		return nil, fmt.Errorf("invalid facility %d", config.Facility)
+	}
+	if config.StructuredDataID != "" && !validSDName(config.StructuredDataID) {
+		return nil, fmt.Errorf("invalid structured data ID %q", config.StructuredDataID)
+	}
+	if config.Hostname == "" {
+		config.Hostname, _ = os.Hostname()
+	}
+	if config.AppName == "" {
+		config.AppName = filepath.Base(os.Args[0])
+	}
+	if config.ProcID == "" {
+		config.ProcID = strconv.Itoa(os.Getpid())
+	}
+	if config.MaxMessageSize == 0 {
+		config.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if config.MaxMessageSize < MinMaxMessageSize {
+		return nil, fmt.Errorf("maximum message size %d is below %d", config.MaxMessageSize, MinMaxMessageSize)
+	}
+	if config.QueueSize == 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.RedialInterval == 0 {
+		config.RedialInterval = DefaultRedialInterval
+	}
+
+	c := &Client{
+		config: config,
+		now:    time.Now,
+		queue:  make(chan *Message, config.QueueSize),
+		done:   make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+// Stats returns the client's counters.
+func (c *Client) Stats() Stats {
+	return Stats{
+		Sent:      c.sent.Load(),
+		Dropped:   c.dropped.Load(),
+		Truncated: c.truncated.Load(),
+		Errors:    c.errors.Load(),
+	}
+}
+
+// Log queues a log record to be sent, or drops it if the queue is full.
+// Its level maps to the severity, and its subsystem is the MSGID.
+func (c *Client) Log(r logging.Record) {
+	m := c.message(r)
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.queue <- m:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// Send sends a message and waits for it to be written. The zero Facility
+// and empty Hostname, AppName and ProcID fields are set to the client's,
+// and a zero Timestamp to the current time.
+func (c *Client) Send(m *Message) error {
+	c.queueMu.Lock()
+	closed := c.closed
+	c.queueMu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	msg := *m
+	if msg.Facility == FacilityKernel {
+		msg.Facility = c.config.Facility
+	}
+	if msg.Hostname == "" {
+		msg.Hostname = c.config.Hostname
+	}
+	if msg.AppName == "" {
+		msg.AppName = c.config.AppName
+	}
+	if msg.ProcID == "" {
+		msg.ProcID = c.config.ProcID
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = c.now()
+	}
+	return c.send(&msg)
+}
+
+// Close sends the queued records, then closes the connection.
+func (c *Client) Close() error {
+	c.queueMu.Lock()
+	if c.closed {
+		c.queueMu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.queue)
+	c.queueMu.Unlock()
+	<-c.done
+
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	if c.config.Conn != nil {
+		return c.config.Conn.Close()
+	}
+	return nil
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+	for m := range c.queue {
+		c.send(m)
+	}
+}
+
+// message converts a log record, formatting its fields now as their
+// values may change once Log returns.
+func (c *Client) message(r logging.Record) *Message {
+	m := &Message{
+		Facility:  c.config.Facility,
+		Severity:  severity(r.Level),
+		Timestamp: r.Time,
+		Hostname:  c.config.Hostname,
+		AppName:   c.config.AppName,
+		ProcID:    c.config.ProcID,
+		MsgID:     r.Subsystem,
+		Msg:       r.Message,
+	}
+	if len(r.Fields) == 0 {
+		return m
+	}
+
+	if c.config.StructuredDataID != "" {
+		e := Element{ID: c.config.StructuredDataID}
+		for _, f := range r.Fields {
+			e.Params = append(e.Params, Param{Name: sdName(f.Key), Value: fmt.Sprint(f.Value)})
+		}
+		m.StructuredData = []Element{e}
+		return m
+	}
+	var b strings.Builder
+	b.WriteString(r.Message)
+	for _, f := range r.Fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		if s := fmt.Sprint(f.Value); s == "" || strings.ContainsAny(s, " \"=\t\n") {
+			b.WriteString(strconv.Quote(s))
+		} else {
+			b.WriteString(s)
+		}
+	}
+	m.Msg = b.String()
+	return m
+}
+
+// severity maps a log level to the severity of its messages.
+func severity(level logging.Level) Severity {
+	switch {
+	case level < logging.LevelInfo:
+		return SeverityDebug
+	case level < logging.LevelWarn:
+		return SeverityInformational
+	case level < logging.LevelError:
+		return SeverityWarning
+	case level == logging.LevelError:
+		return SeverityError
+	}
+	return SeverityCritical
+}
+
+// sdName makes a field key a valid parameter name.
+func sdName(key string) string {
+	if key == "" {
+		return "_"
+	}
+	if len(key) > maxSDNameLength {
+		key = key[:maxSDNameLength]
+	}
+	b := []byte(key)
+	for i, ch := range b {
+		if ch < 33 || ch > 126 || strings.IndexByte("= ]\"", ch) >= 0 {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// send writes a message to the collector.
+func (c *Client) send(m *Message) error {
+	data, err := m.Format()
+	if err != nil {
+		c.errors.Add(1)
+		return err
+	}
+
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.config.Conn != nil {
+		err = c.sendUDP(data)
+	} else {
+		err = c.sendTCP(data)
+	}
+	if err != nil {
+		c.errors.Add(1)
+		return err
+	}
+	c.sent.Add(1)
+	return nil
+}
+
+// sendUDP sends a message as a datagram, truncated to the maximum size
+// (RFC 5426 Section 3.2).
+func (c *Client) sendUDP(data []byte) error {
+	if len(data) > c.config.MaxMessageSize {
+		data = data[:c.config.MaxMessageSize]
+		c.truncated.Add(1)
+	}
+	_, err := c.config.Conn.WriteTo(data, c.config.Addr)
+	return err
+}
+
+// sendTCP sends a message prefixed with its length (RFC 6587 Section
+// 3.4.1). A connection that fails is closed, and the message is sent once
+// more on a new one.
+func (c *Client) sendTCP(data []byte) error {
+	frame := strconv.AppendInt(nil, int64(len(data)), 10)
+	frame = append(append(frame, ' '), data...)
+	for {
+		fresh := c.conn == nil
+		if fresh {
+			if err := c.dial(); err != nil {
+				return err
+			}
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(c.config.Timeout))
+		_, err := c.conn.Write(frame)
+		if err == nil {
+			return nil
+		}
+		c.conn.Close()
+		c.conn = nil
+		if fresh {
+			return err
+		}
+	}
+}
+
+func (c *Client) dial() error {
+	now := c.now()
+	if !c.dialFailed.IsZero() && now.Sub(c.dialFailed) < c.config.RedialInterval {
+		return fmt.Errorf("not redialing within %v of a failure", c.config.RedialInterval)
+	}
+	conn, err := c.config.Dial()
+	if err != nil {
+		c.dialFailed = now
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	c.dialFailed = time.Time{}
+	c.conn = conn
+	return nil
+}
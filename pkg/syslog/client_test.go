@@ -0,0 +1,337 @@
+package syslog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	clientIP    = common.IPv4Address{192, 168, 1, 10}
+	collectorIP = common.IPv4Address{192, 168, 1, 1}
+)
+
+// udpCollector returns a client connection and the collector's address on
+// a network of two hosts, and the connection the collector receives on.
+func udpCollector(t *testing.T) (net.PacketConn, net.Addr, net.PacketConn) {
+	t.Helper()
+	hosts := map[common.IPv4Address]*udp.Demultiplexer{
+		clientIP:    udp.NewDemultiplexer(),
+		collectorIP: udp.NewDemultiplexer(),
+	}
+	listen := func(host common.IPv4Address, port uint16) net.PacketConn {
+		conn, err := udp.Listen(hosts[host], host, port, func(datagram *udp.Packet, to udp.Address) error {
+			return hosts[to.IP].Deliver(datagram, udp.Address{IP: host, Port: datagram.SourcePort})
+		})
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+		return conn
+	}
+	collector := listen(collectorIP, Port)
+	t.Cleanup(func() { collector.Close() })
+	return listen(clientIP, 0), collector.LocalAddr(), collector
+}
+
+func receive(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 65535)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	return string(buf[:n])
+}
+
+func testClient(t *testing.T, config Config) *Client {
+	t.Helper()
+	config.Hostname, config.AppName, config.ProcID = "host1", "app", "42"
+	c, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+var recordTime = time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+func TestClientLogUDP(t *testing.T) {
+	conn, addr, collector := udpCollector(t)
+	c := testClient(t, Config{Conn: conn, Addr: addr, Facility: FacilityLocal3})
+
+	c.Log(logging.Record{
+		Time:      recordTime,
+		Level:     logging.LevelWarn,
+		Subsystem: "tcp",
+		Message:   "retransmission timeout",
+		Fields:    []logging.Field{logging.F("rto", time.Second), logging.F("peer", "10.0.0.2:80"), logging.F("err", errors.New("no ack"))},
+	})
+	want := `<156>1 2024-01-02T15:04:05.000000Z host1 app 42 tcp - retransmission timeout rto=1s peer=10.0.0.2:80 err="no ack"`
+	if got := receive(t, collector); got != want {
+		t.Errorf("collector got %q, want %q", got, want)
+	}
+}
+
+func TestClientStructuredData(t *testing.T) {
+	conn, addr, collector := udpCollector(t)
+	c := testClient(t, Config{Conn: conn, Addr: addr, StructuredDataID: "fields@32473"})
+
+	c.Log(logging.Record{
+		Time:      recordTime,
+		Level:     logging.LevelDebug,
+		Subsystem: "arp",
+		Message:   "resolved",
+		Fields:    []logging.Field{logging.F("ip", "10.0.0.1"), logging.F("bad key", `"x"`)},
+	})
+	want := `<15>1 2024-01-02T15:04:05.000000Z host1 app 42 arp [fields@32473 ip="10.0.0.1" bad_key="\"x\""] resolved`
+	if got := receive(t, collector); got != want {
+		t.Errorf("collector got %q, want %q", got, want)
+	}
+}
+
+func TestClientTruncatesDatagrams(t *testing.T) {
+	conn, addr, collector := udpCollector(t)
+	c := testClient(t, Config{Conn: conn, Addr: addr, MaxMessageSize: MinMaxMessageSize})
+
+	if err := c.Send(&Message{Severity: SeverityNotice, Msg: strings.Repeat("x", 1000)}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := receive(t, collector); len(got) != MinMaxMessageSize {
+		t.Errorf("datagram of %d bytes, want %d", len(got), MinMaxMessageSize)
+	}
+	if got := c.Stats(); got.Sent != 1 || got.Truncated != 1 {
+		t.Errorf("Stats() = %+v, want 1 sent and truncated", got)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		level logging.Level
+		want  Severity
+	}{
+		{logging.LevelTrace, SeverityDebug},
+		{logging.LevelDebug, SeverityDebug},
+		{logging.LevelInfo, SeverityInformational},
+		{logging.LevelWarn, SeverityWarning},
+		{logging.LevelError, SeverityError},
+		{logging.LevelError + 4, SeverityCritical},
+	}
+	for _, tt := range tests {
+		if got := severity(tt.level); got != tt.want {
+			t.Errorf("severity(%v) = %d, want %d", tt.level, got, tt.want)
+		}
+	}
+}
+
+// blockingConn is a PacketConn whose writes wait for release.
+type blockingConn struct {
+	net.PacketConn
+	release chan struct{}
+	mu      sync.Mutex
+	writes  []string
+}
+
+func (c *blockingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	<-c.release
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, string(p))
+	return len(p), nil
+}
+
+func (c *blockingConn) Close() error { return nil }
+
+func TestClientQueue(t *testing.T) {
+	conn := &blockingConn{release: make(chan struct{})}
+	c := testClient(t, Config{Conn: conn, Addr: udp.Address{IP: collectorIP, Port: Port}, QueueSize: 2})
+
+	// One record is being sent and two are queued when the others arrive
+	for i := 0; i < 5; i++ {
+		c.Log(logging.Record{Time: recordTime, Message: strconv.Itoa(i)})
+		if i == 0 {
+			for len(c.queue) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	close(conn.release)
+	c.Close()
+
+	if got := c.Stats(); got.Sent != 3 || got.Dropped != 2 {
+		t.Errorf("Stats() = %+v, want 3 sent and 2 dropped", got)
+	}
+	for i, w := range conn.writes {
+		if !strings.HasSuffix(w, " "+strconv.Itoa(i)) {
+			t.Errorf("write %d = %q, want record %d", i, w, i)
+		}
+	}
+
+	// Records after Close are dropped silently, and messages fail
+	c.Log(logging.Record{Message: "late"})
+	if err := c.Send(&Message{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Send() after Close() error = %v, want %v", err, ErrClosed)
+	}
+}
+
+// tcpCollector accepts the connections a client dials, reading the
+// octet-counted messages they carry.
+type tcpCollector struct {
+	mu       sync.Mutex
+	fail     error // Returned by dial if set
+	dials    int
+	conns    []net.Conn
+	messages chan string
+}
+
+func newTCPCollector() *tcpCollector {
+	return &tcpCollector{messages: make(chan string, 16)}
+}
+
+func (tc *tcpCollector) dial() (net.Conn, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.dials++
+	if tc.fail != nil {
+		return nil, tc.fail
+	}
+	client, server := net.Pipe()
+	tc.conns = append(tc.conns, server)
+	go func() {
+		r := bufio.NewReader(server)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+			if err != nil {
+				return
+			}
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				return
+			}
+			tc.messages <- string(msg)
+		}
+	}()
+	return client, nil
+}
+
+func (tc *tcpCollector) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case m := <-tc.messages:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("collector received no message")
+		return ""
+	}
+}
+
+func TestClientTCP(t *testing.T) {
+	tc := newTCPCollector()
+	c := testClient(t, Config{Dial: tc.dial})
+
+	long := strings.Repeat("x", 3000)
+	for _, msg := range []string{"first", long} {
+		if err := c.Send(&Message{Severity: SeverityInformational, Timestamp: recordTime, Msg: msg}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		want := "<14>1 2024-01-02T15:04:05.000000Z host1 app 42 - - " + msg
+		if got := tc.next(t); got != want {
+			t.Errorf("collector got %.80q, want %.80q (not truncated)", got, want)
+		}
+	}
+
+	// The message is sent again on a new connection when the old one fails
+	tc.mu.Lock()
+	tc.conns[0].Close()
+	tc.mu.Unlock()
+	if err := c.Send(&Message{Msg: "after reconnect"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := tc.next(t); !strings.HasSuffix(got, "after reconnect") {
+		t.Errorf("collector got %q, want the message after reconnect", got)
+	}
+	if tc.dials != 2 {
+		t.Errorf("dialed %d times, want 2", tc.dials)
+	}
+}
+
+func TestClientRedialInterval(t *testing.T) {
+	tc := newTCPCollector()
+	tc.fail = errors.New("connection refused")
+	c := testClient(t, Config{Dial: tc.dial, RedialInterval: time.Minute})
+	now := recordTime
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if err := c.Send(&Message{Msg: "lost"}); err == nil {
+			t.Fatal("Send() succeeded without a collector")
+		}
+	}
+	if tc.dials != 1 {
+		t.Errorf("dialed %d times within the redial interval, want 1", tc.dials)
+	}
+
+	tc.mu.Lock()
+	tc.fail = nil
+	tc.mu.Unlock()
+	now = now.Add(time.Minute)
+	if err := c.Send(&Message{Msg: "delivered"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	tc.next(t)
+	if got := c.Stats(); got.Errors != 3 || got.Sent != 1 {
+		t.Errorf("Stats() = %+v, want 3 errors and 1 sent", got)
+	}
+}
+
+func TestClientAsLogger(t *testing.T) {
+	conn, addr, collector := udpCollector(t)
+	c := testClient(t, Config{Conn: conn, Addr: addr})
+	logging.SetLogger(c)
+	t.Cleanup(func() { logging.SetLogger(nil) })
+
+	logging.New("syslog-test").Error("link down", logging.F("dev", "eth0"))
+	got := receive(t, collector)
+	if !strings.HasPrefix(got, "<11>1 ") || !strings.HasSuffix(got, " host1 app 42 syslog-test - link down dev=eth0") {
+		t.Errorf("collector got %q, want the record as an error", got)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	conn := &blockingConn{}
+	addr := udp.Address{IP: collectorIP, Port: Port}
+	dial := func() (net.Conn, error) { return nil, errors.New("unused") }
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"no transport", Config{}},
+		{"both transports", Config{Conn: conn, Addr: addr, Dial: dial}},
+		{"no address", Config{Conn: conn}},
+		{"invalid facility", Config{Dial: dial, Facility: 24}},
+		{"invalid structured data ID", Config{Dial: dial, StructuredDataID: "a b"}},
+		{"message size too small", Config{Conn: conn, Addr: addr, MaxMessageSize: 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClient(tt.config); err == nil {
+				t.Error("NewClient() succeeded, want error")
+			}
+		})
+	}
+}
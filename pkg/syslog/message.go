@@ -0,0 +1,239 @@
+// Package syslog sends RFC 5424 syslog messages to a collector over the
+// stack's UDP sockets (RFC 5426), or over TCP with octet-counting framing
+// (RFC 6587).
+//
+// A Client is also a logging.Logger, so the stack's own log records can be
+// forwarded to a central collector:
+//
+//	conn, _ := udp.Listen(demux, addr, 0, send)
+//	client, _ := syslog.NewClient(syslog.Config{Conn: conn, Addr: collector})
+//	logging.SetLogger(client)
+package syslog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Facility is the subsystem a message comes from (RFC 5424 Section 6.2.1).
+type Facility int
+
+// Facilities.
+const (
+	FacilityKernel Facility = iota
+	FacilityUser
+	FacilityMail
+	FacilityDaemon
+	FacilityAuth
+	FacilitySyslog
+	FacilityLPR
+	FacilityNews
+	FacilityUUCP
+	FacilityCron
+	FacilityAuthPriv
+	FacilityFTP
+	FacilityNTP
+	FacilityAudit
+	FacilityAlert
+	FacilityClock
+	FacilityLocal0
+	FacilityLocal1
+	FacilityLocal2
+	FacilityLocal3
+	FacilityLocal4
+	FacilityLocal5
+	FacilityLocal6
+	FacilityLocal7
+)
+
+// Severity is the importance of a message, 0 being the most severe.
+type Severity int
+
+// Severities.
+const (
+	SeverityEmergency Severity = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInformational
+	SeverityDebug
+)
+
+// Version is the syslog protocol version of RFC 5424.
+const Version = 1
+
+// Maximum lengths of the header fields (RFC 5424 Section 6).
+const (
+	maxHostnameLength = 255
+	maxAppNameLength  = 48
+	maxProcIDLength   = 128
+	maxMsgIDLength    = 32
+	maxSDNameLength   = 32
+)
+
+// timestampFormat is the format of TIMESTAMP, with the microsecond
+// precision RFC 5424 allows at most.
+const timestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// Param is a parameter of a structured data element.
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Element is a structured data element, such as
+// [exampleSDID@32473 iut="3" eventSource="Application"].
+type Element struct {
+	ID     string
+	Params []Param
+}
+
+// Message is a syslog message. Empty header fields and a zero Timestamp
+// are sent as the nil value "-".
+type Message struct {
+	Facility       Facility
+	Severity       Severity
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData []Element
+	Msg            string
+}
+
+// Priority returns the PRI value of the message.
+func (m *Message) Priority() int {
+	return int(m.Facility)*8 + int(m.Severity)
+}
+
+// Validate checks the facility and severity, and the structured data,
+// whose identifiers cannot be made valid by Format.
+func (m *Message) Validate() error {
+	if m.Facility < FacilityKernel || m.Facility > FacilityLocal7 {
+		return fmt.Errorf("invalid facility %d", m.Facility)
+	}
+	if m.Severity < SeverityEmergency || m.Severity > SeverityDebug {
+		return fmt.Errorf("invalid severity %d", m.Severity)
+	}
+	for _, e := range m.StructuredData {
+		if !validSDName(e.ID) {
+			return fmt.Errorf("invalid structured data ID %q", e.ID)
+		}
+		for _, p := range e.Params {
+			if !validSDName(p.Name) {
+				return fmt.Errorf("invalid parameter name %q in %s", p.Name, e.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// Format returns the message in the RFC 5424 format:
+//
+//	<165>1 2003-10-11T22:14:15.003000Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3"] An application event
+//
+// Header fields are truncated to their maximum length, and characters
+// they cannot hold are replaced with '_'. The message is sent as is,
+// without the optional UTF-8 byte order mark.
+func (m *Message) Format() ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, 128+len(m.Msg))
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(m.Priority()), 10)
+	b = append(b, '>')
+	b = strconv.AppendInt(b, Version, 10)
+	b = append(b, ' ')
+	if m.Timestamp.IsZero() {
+		b = append(b, '-')
+	} else {
+		b = m.Timestamp.AppendFormat(b, timestampFormat)
+	}
+	for _, f := range []struct {
+		value string
+		limit int
+	}{
+		{m.Hostname, maxHostnameLength},
+		{m.AppName, maxAppNameLength},
+		{m.ProcID, maxProcIDLength},
+		{m.MsgID, maxMsgIDLength},
+	} {
+		b = append(b, ' ')
+		b = appendHeaderField(b, f.value, f.limit)
+	}
+
+	b = append(b, ' ')
+	if len(m.StructuredData) == 0 {
+		b = append(b, '-')
+	}
+	for _, e := range m.StructuredData {
+		b = append(b, '[')
+		b = append(b, e.ID...)
+		for _, p := range e.Params {
+			b = append(b, ' ')
+			b = append(b, p.Name...)
+			b = append(b, '=', '"')
+			b = appendParamValue(b, p.Value)
+			b = append(b, '"')
+		}
+		b = append(b, ']')
+	}
+
+	if m.Msg != "" {
+		b = append(b, ' ')
+		b = append(b, m.Msg...)
+	}
+	return b, nil
+}
+
+// appendHeaderField appends a header field of printable US-ASCII
+// characters, or "-" if it is empty.
+func appendHeaderField(b []byte, s string, limit int) []byte {
+	if s == "" {
+		return append(b, '-')
+	}
+	if len(s) > limit {
+		s = s[:limit]
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= 33 && c <= 126 {
+			b = append(b, c)
+		} else {
+			b = append(b, '_')
+		}
+	}
+	return b
+}
+
+// appendParamValue appends a parameter value, escaping the characters
+// that would end it (RFC 5424 Section 6.3.3).
+func appendParamValue(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '"' || c == '\\' || c == ']' {
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return b
+}
+
+// validSDName reports whether s is a valid SD-NAME: 1 to 32 printable
+// US-ASCII characters other than '=', ' ', ']' and '"'.
+func validSDName(s string) bool {
+	if s == "" || len(s) > maxSDNameLength {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 33 || c > 126 || strings.IndexByte("= ]\"", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package syslog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	ts := time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC)
+	tests := []struct {
+		name string
+		m    Message
+		want string
+	}{
+		{
+			// RFC 5424 Section 6.5, example 1, without the BOM
+			name: "no structured data",
+			m: Message{Facility: FacilityAuth, Severity: SeverityCritical, Timestamp: ts,
+				Hostname: "mymachine.example.com", AppName: "su", MsgID: "ID47", Msg: "'su root' failed for lonvick on /dev/pts/8"},
+			want: "<34>1 2003-10-11T22:14:15.003000Z mymachine.example.com su - ID47 - 'su root' failed for lonvick on /dev/pts/8",
+		},
+		{
+			// Example 4
+			name: "structured data",
+			m: Message{Facility: FacilityLocal4, Severity: SeverityNotice, Timestamp: ts,
+				Hostname: "mymachine.example.com", AppName: "evntslog", MsgID: "ID47",
+				StructuredData: []Element{
+					{ID: "exampleSDID@32473", Params: []Param{{"iut", "3"}, {"eventSource", "Application"}, {"eventID", "1011"}}},
+					{ID: "examplePriority@32473", Params: []Param{{"class", "high"}}},
+				}},
+			want: `<165>1 2003-10-11T22:14:15.003000Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high"]`,
+		},
+		{
+			name: "nil values",
+			m:    Message{Facility: FacilityUser, Severity: SeverityEmergency},
+			want: "<8>1 - - - - - -",
+		},
+		{
+			name: "time zone",
+			m:    Message{Facility: FacilityDaemon, Severity: SeverityDebug, Timestamp: ts.In(time.FixedZone("", -7*3600)), Msg: "x"},
+			want: "<31>1 2003-10-11T15:14:15.003000-07:00 - - - - - x",
+		},
+		{
+			name: "escaped parameter",
+			m: Message{Facility: FacilityLocal0, Severity: SeverityInformational,
+				StructuredData: []Element{{ID: "meta", Params: []Param{{"v", `a"b\c]d`}}}}},
+			want: `<134>1 - - - - - [meta v="a\"b\\c\]d"]`,
+		},
+		{
+			name: "sanitized header",
+			m:    Message{Facility: FacilityUser, Severity: SeverityNotice, Hostname: "my host", MsgID: strings.Repeat("m", 40)},
+			want: "<13>1 - my_host - - " + strings.Repeat("m", 32) + " -",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.Format()
+			if err != nil {
+				t.Fatalf("Format() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Format() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Message
+	}{
+		{"facility", Message{Facility: 24}},
+		{"severity", Message{Severity: -1}},
+		{"empty SD-ID", Message{StructuredData: []Element{{}}}},
+		{"SD-ID with space", Message{StructuredData: []Element{{ID: "a b"}}}},
+		{"long SD-ID", Message{StructuredData: []Element{{ID: strings.Repeat("x", 33)}}}},
+		{"parameter name", Message{StructuredData: []Element{{ID: "meta", Params: []Param{{Name: "a=b"}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.m.Validate(); err == nil {
+				t.Error("Validate() succeeded, want error")
+			}
+			if _, err := tt.m.Format(); err == nil {
+				t.Error("Format() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,507 @@
+package tcp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// MaxAOKeyLength is the longest master key SetAOKeys accepts.
+const MaxAOKeyLength = 80
+
+// aoMACLength is the length of the MACs of both algorithms, truncated to
+// 96 bits (RFC 5926 Section 3.2).
+const aoMACLength = 12
+
+// aoOptionLength is the length of the TCP-AO option: kind, length, KeyID,
+// RNextKeyID and the MAC.
+const aoOptionLength = 4 + aoMACLength
+
+// AOAlgorithm is the MAC algorithm of a TCP-AO key, with the key
+// derivation function that goes with it (RFC 5926).
+type AOAlgorithm uint8
+
+const (
+	AOHMACSHA1 AOAlgorithm = iota // HMAC-SHA-1-96, keys derived with KDF_HMAC_SHA1
+	AOAESCMAC                     // AES-128-CMAC-96, keys derived with KDF_AES_128_CMAC
+)
+
+func (a AOAlgorithm) String() string {
+	switch a {
+	case AOHMACSHA1:
+		return "HMAC-SHA-1-96"
+	case AOAESCMAC:
+		return "AES-128-CMAC-96"
+	}
+	return fmt.Sprintf("AOAlgorithm(%d)", uint8(a))
+}
+
+// AOKey is a master key tuple of the TCP Authentication Option (RFC 5925
+// Section 3.1). Each side names a key with the ID its segments carry: a
+// segment sent with the key carries SendID, and a segment received with
+// it carries RecvID, which is the peer's SendID for the key.
+type AOKey struct {
+	SendID    uint8
+	RecvID    uint8
+	Key       []byte // Master key, 1 to MaxAOKeyLength bytes
+	Algorithm AOAlgorithm
+
+	// Leave the options other than TCP-AO out of the MAC, for paths that
+	// change them
+	ExcludeOptions bool
+}
+
+// aoKey is a key of a connection, with the traffic keys derived from it
+// for the connection (RFC 5925 Section 5.2): for sending and receiving,
+// SYNs and other segments.
+type aoKey struct {
+	AOKey
+	traffic [4]aoTrafficKey
+}
+
+// aoTrafficKey is a traffic key and the context it was derived for.
+type aoTrafficKey struct {
+	context []byte
+	key     []byte
+}
+
+// aoConn is the TCP-AO state of a connection: its key chain, the key it
+// sends with, the RecvID it asks the peer to send with, and the sequence
+// number extensions of both directions.
+type aoConn struct {
+	keys    []*aoKey
+	current *aoKey
+	rnext   uint8
+	sndSNE  sne
+	rcvSNE  sne
+}
+
+// sne tracks a sequence number extension (RFC 5925 Section 6.2): the
+// number of times the sequence numbers of a direction wrapped.
+type sne struct {
+	high    uint32 // Wraps before sequence number seq
+	seq     uint32 // Highest sequence number seen
+	started bool
+}
+
+// of returns the SNE of sequence number seq, and the state that follows
+// seeing it. A sequence number from just before the last wrap has the SNE
+// from then.
+func (s sne) of(seq uint32) (uint32, sne) {
+	if !s.started {
+		return s.high, sne{high: s.high, seq: seq, started: true}
+	}
+	switch {
+	case seq < s.seq && s.seq-seq > 1<<31:
+		return s.high + 1, sne{high: s.high + 1, seq: seq, started: true}
+	case seq > s.seq && seq-s.seq > 1<<31:
+		return s.high - 1, s
+	case seqAfter(seq, s.seq):
+		s.seq = seq
+	}
+	return s.high, s
+}
+
+// SetAOKeys sets the TCP-AO key chain (RFC 5925) for segments exchanged
+// with peer, replacing the MD5 signature option as routing protocols use
+// it. A connection with keys authenticates every segment it sends with
+// its current key, the first of keys for a new connection, and drops
+// every segment it receives that lacks a valid MAC of a key in the chain.
+// A listening socket takes chains for each peer it accepts connections
+// from. A peer has TCP-AO keys or an MD5 key, not both.
+//
+// Keys can be added to the chain of a connected socket's peer to roll over
+// to them without breaking the connection: SetAORNextKey asks the peer to
+// send with the new key, and SetAOCurrentKey sends with it. A connection
+// also switches its current key to the one the peer asks for. Keys cannot
+// be added to a connection that started without any, nor all removed from
+// one; empty keys only remove the chain for new connections. Connections
+// with keys do not use GSO.
+func (s *Socket) SetAOKeys(peer common.IPv4Address, keys ...AOKey) error {
+	return s.setAOKeys(ipKey(peer), keys)
+}
+
+// SetAOKeysIPv6 is SetAOKeys for a peer reached over IPv6.
+func (s *Socket) SetAOKeysIPv6(peer common.IPv6Address, keys ...AOKey) error {
+	return s.setAOKeys(peer, keys)
+}
+
+func (s *Socket) setAOKeys(peer common.IPv6Address, keys []AOKey) error {
+	if err := validateAOKeys(keys); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.md5Keys[peer] != nil {
+		return fmt.Errorf("peer %s has an MD5 key", hostPort(peer, 0))
+	}
+	if s.conn != nil && s.remoteAddr == peer {
+		s.conn.mu.Lock()
+		err := s.conn.setAOKeys(keys)
+		s.conn.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(keys) == 0 {
+		delete(s.aoKeys, peer)
+		return nil
+	}
+	if s.aoKeys == nil {
+		s.aoKeys = make(map[common.IPv6Address][]AOKey)
+	}
+	s.aoKeys[peer] = copyAOKeys(keys)
+	return nil
+}
+
+// SetAOCurrentKey makes the connection send with the key of its chain
+// with SendID id.
+func (s *Socket) SetAOCurrentKey(id uint8) error {
+	return s.withAO(func(a *aoConn) error {
+		k := a.sendKey(id)
+		if k == nil {
+			return fmt.Errorf("no TCP-AO key with SendID %d", id)
+		}
+		a.current = k
+		return nil
+	})
+}
+
+// SetAORNextKey asks the peer to send with the key of the chain with
+// RecvID id, by sending id as the RNextKeyID of the following segments
+// (RFC 5925 Section 7.5.2). The peer switches its current key when it
+// sees it.
+func (s *Socket) SetAORNextKey(id uint8) error {
+	return s.withAO(func(a *aoConn) error {
+		if a.recvKey(id) == nil {
+			return fmt.Errorf("no TCP-AO key with RecvID %d", id)
+		}
+		a.rnext = id
+		return nil
+	})
+}
+
+// withAO calls f with the TCP-AO state of the socket's connection, under
+// the connection's lock.
+func (s *Socket) withAO(f func(*aoConn) error) error {
+	s.mu.RLock()
+	conn := s.conn
+	s.mu.RUnlock()
+
+	if conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.ao == nil {
+		return fmt.Errorf("connection does not use TCP-AO")
+	}
+	return f(conn.ao)
+}
+
+// validateAOKeys checks a key chain: valid keys, and no ID used twice in
+// a direction.
+func validateAOKeys(keys []AOKey) error {
+	var sendIDs, recvIDs [256]bool
+	for _, k := range keys {
+		if len(k.Key) == 0 || len(k.Key) > MaxAOKeyLength {
+			return fmt.Errorf("invalid TCP-AO key length %d (1 to %d bytes)", len(k.Key), MaxAOKeyLength)
+		}
+		if k.Algorithm > AOAESCMAC {
+			return fmt.Errorf("unknown TCP-AO algorithm %s", k.Algorithm)
+		}
+		if sendIDs[k.SendID] || recvIDs[k.RecvID] {
+			return fmt.Errorf("duplicate TCP-AO key ID %d/%d", k.SendID, k.RecvID)
+		}
+		sendIDs[k.SendID], recvIDs[k.RecvID] = true, true
+	}
+	return nil
+}
+
+func copyAOKeys(keys []AOKey) []AOKey {
+	copied := make([]AOKey, len(keys))
+	for i, k := range keys {
+		k.Key = append([]byte(nil), k.Key...)
+		copied[i] = k
+	}
+	return copied
+}
+
+// newAOConn returns the TCP-AO state of a new connection with keys, or nil
+// if there are none.
+func newAOConn(keys []AOKey) *aoConn {
+	if len(keys) == 0 {
+		return nil
+	}
+	a := &aoConn{}
+	a.setKeys(keys)
+	return a
+}
+
+// setKeys replaces the key chain, keeping the current key and RNextKeyID
+// if their keys remain.
+func (a *aoConn) setKeys(keys []AOKey) {
+	old, rnext := a.current, a.rnext
+	a.keys = make([]*aoKey, len(keys))
+	for i, k := range copyAOKeys(keys) {
+		a.keys[i] = &aoKey{AOKey: k}
+	}
+	a.current = a.keys[0]
+	if old != nil {
+		if k := a.sendKey(old.SendID); k != nil {
+			a.current = k
+		}
+	}
+	if old == nil || a.recvKey(rnext) == nil {
+		rnext = a.current.RecvID
+	}
+	a.rnext = rnext
+}
+
+// sendKey returns the key with SendID id, or nil.
+func (a *aoConn) sendKey(id uint8) *aoKey {
+	for _, k := range a.keys {
+		if k.SendID == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// recvKey returns the key with RecvID id, or nil.
+func (a *aoConn) recvKey(id uint8) *aoKey {
+	for _, k := range a.keys {
+		if k.RecvID == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// setAOKeys changes the key chain of a connection that uses TCP-AO. Called
+// with c.mu held.
+func (c *Connection) setAOKeys(keys []AOKey) error {
+	switch {
+	case c.ao == nil && len(keys) > 0:
+		return fmt.Errorf("connection did not start with TCP-AO")
+	case c.ao != nil && len(keys) == 0:
+		return fmt.Errorf("cannot remove the TCP-AO keys of a connection using them")
+	case c.ao != nil:
+		c.ao.setKeys(keys)
+	}
+	return nil
+}
+
+// setAO sets the TCP-AO state of a new connection. Called before the
+// connection is shared.
+func (c *Connection) setAO(a *aoConn) {
+	c.ao = a
+	if a != nil {
+		c.gso = false
+	}
+}
+
+// signAO adds a TCP-AO option to a segment the connection sends, if it
+// uses TCP-AO.
+func (c *Connection) signAO(seg *Segment) error {
+	if c.ao == nil {
+		return nil
+	}
+	if err := c.ao.sign(seg, c.localKey(), c.remoteKey(), c.iss, c.irs); err != nil {
+		return err
+	}
+	seg.Checksum = 0
+	checksum, err := c.checksum(seg)
+	if err != nil {
+		return err
+	}
+	seg.Checksum = checksum
+	return nil
+}
+
+// verifyAO checks the TCP-AO option of a segment the connection received:
+// a valid MAC if it uses TCP-AO, and no option if not.
+func (c *Connection) verifyAO(seg *Segment) bool {
+	return verifyAO(c.ao, seg, c.remoteKey(), c.localKey(), c.iss, c.irs)
+}
+
+// verifyAO checks the TCP-AO option of seg, received from remote by local,
+// for a connection with TCP-AO state a, or none.
+func verifyAO(a *aoConn, seg *Segment, remote, local common.IPv6Address, iss, irs uint32) bool {
+	if a == nil {
+		_, ok := findOption(seg.Options, OptionKindAO)
+		return !ok
+	}
+	return a.verify(seg, remote, local, iss, irs)
+}
+
+// sign adds a TCP-AO option with the MAC of the current key to seg, sent
+// from local to remote, replacing any it has. iss and irs are the
+// connection's initial sequence numbers.
+func (a *aoConn) sign(seg *Segment, local, remote common.IPv6Address, iss, irs uint32) error {
+	opts := stripOption(seg.Options, OptionKindAO)
+	if MinHeaderLength+len(opts)+aoOptionLength > MaxHeaderLength {
+		return fmt.Errorf("no room for the TCP-AO option: %d bytes of options", len(opts))
+	}
+	at := len(opts)
+	opts = append(opts, OptionKindAO, aoOptionLength, a.current.SendID, a.rnext)
+	seg.Options = append(opts, make([]byte, aoMACLength)...)
+
+	// A SYN has SNE zero, and the peer's ISN is not known yet
+	var ext uint32
+	syn := seg.Flags&(FlagSYN|FlagACK) == FlagSYN
+	if syn {
+		irs = 0
+	} else {
+		ext, a.sndSNE = a.sndSNE.of(seg.SequenceNumber)
+	}
+	k := a.current
+	traffic := k.trafficKey(true, syn, aoContext(local, remote, seg.SourcePort, seg.DestinationPort, iss, irs))
+	mac := k.mac(traffic, aoMessage(seg, local, remote, ext, at, k.ExcludeOptions))
+	copy(seg.Options[at+4:], mac)
+	return nil
+}
+
+// verify checks the MAC of seg, received from remote by local, switching
+// the current key to the one the peer asks for if it is valid.
+func (a *aoConn) verify(seg *Segment, remote, local common.IPv6Address, iss, irs uint32) bool {
+	at, ok := findOption(seg.Options, OptionKindAO)
+	if !ok || seg.Options[at+1] != aoOptionLength {
+		return false
+	}
+	if _, ok := findOption(seg.Options, OptionKindMD5); ok {
+		return false
+	}
+	keyID, rnext := seg.Options[at+2], seg.Options[at+3]
+	k := a.recvKey(keyID)
+	if k == nil {
+		return false
+	}
+
+	// The ISN of a SYN or SYN-ACK is its sequence number, which the
+	// connection may not have taken yet
+	if seg.HasFlag(FlagSYN) {
+		irs = seg.SequenceNumber
+	}
+	var ext uint32
+	next := a.rcvSNE
+	syn := seg.Flags&(FlagSYN|FlagACK) == FlagSYN
+	if syn {
+		iss = 0
+	} else {
+		ext, next = a.rcvSNE.of(seg.SequenceNumber)
+	}
+	traffic := k.trafficKey(false, syn, aoContext(remote, local, seg.SourcePort, seg.DestinationPort, irs, iss))
+	mac := k.mac(traffic, aoMessage(seg, remote, local, ext, at, k.ExcludeOptions))
+	if subtle.ConstantTimeCompare(mac, seg.Options[at+4:at+aoOptionLength]) != 1 {
+		return false
+	}
+
+	a.rcvSNE = next
+	if rnext != a.current.SendID {
+		if k := a.sendKey(rnext); k != nil {
+			a.current = k
+		}
+	}
+	return true
+}
+
+// aoContext returns the context traffic keys are derived from (RFC 5925
+// Section 5.2): the segment's addresses, ports and ISNs, in the order of
+// the direction of the segments they authenticate.
+func aoContext(src, dst common.IPv6Address, srcPort, dstPort uint16, srcISN, dstISN uint32) []byte {
+	b := make([]byte, 0, 44)
+	if dst4, ok := dst.To4(); ok {
+		src4, _ := ipv4Of(src)
+		b = append(append(b, src4[:]...), dst4[:]...)
+	} else {
+		b = append(append(b, src[:]...), dst[:]...)
+	}
+	b = binary.BigEndian.AppendUint16(b, srcPort)
+	b = binary.BigEndian.AppendUint16(b, dstPort)
+	b = binary.BigEndian.AppendUint32(b, srcISN)
+	return binary.BigEndian.AppendUint32(b, dstISN)
+}
+
+// trafficKey returns the traffic key for sent or received SYNs or other
+// segments with context, deriving it again if the context changed.
+func (k *aoKey) trafficKey(send, syn bool, context []byte) []byte {
+	i := 0
+	if !send {
+		i++
+	}
+	if !syn {
+		i += 2
+	}
+	t := &k.traffic[i]
+	if t.key == nil || !bytes.Equal(t.context, context) {
+		t.context, t.key = context, k.derive(context)
+	}
+	return t.key
+}
+
+// derive computes a traffic key from the master key with the KDF of the
+// algorithm (RFC 5926 Section 3.1.1): the PRF over i = 1, the label
+// "TCP-AO", the context and the output length in bits.
+func (k *AOKey) derive(context []byte) []byte {
+	input := append([]byte{1}, "TCP-AO"...)
+	input = append(input, context...)
+	if k.Algorithm == AOAESCMAC {
+		input = binary.BigEndian.AppendUint16(input, 128)
+		key := k.Key
+		if len(key) != 16 {
+			// Keys of other lengths are first reduced to 128 bits
+			reduced := cmac(make([]byte, 16), key)
+			key = reduced[:]
+		}
+		out := cmac(key, input)
+		return out[:]
+	}
+	input = binary.BigEndian.AppendUint16(input, 160)
+	h := hmac.New(sha1.New, k.Key)
+	h.Write(input)
+	return h.Sum(nil)
+}
+
+// mac computes the MAC of msg with a traffic key, truncated to 96 bits.
+func (k *AOKey) mac(traffic, msg []byte) []byte {
+	if k.Algorithm == AOAESCMAC {
+		out := cmac(traffic, msg)
+		return out[:aoMACLength]
+	}
+	h := hmac.New(sha1.New, traffic)
+	h.Write(msg)
+	return h.Sum(nil)[:aoMACLength]
+}
+
+// aoMessage returns what the MAC of seg, sent from src to dst, covers (RFC
+// 5925 Section 5.1): the SNE, the pseudo-header, the header with a zero
+// checksum and the options with a zero MAC, and the data. The TCP-AO
+// option is at offset at of the options; with excludeOptions it is the
+// only option covered.
+func aoMessage(seg *Segment, src, dst common.IPv6Address, ext uint32, at int, excludeOptions bool) []byte {
+	headerLength := MinHeaderLength + (len(seg.Options)+3)/4*4
+	b := make([]byte, 0, 4+40+headerLength+len(seg.Data))
+	b = binary.BigEndian.AppendUint32(b, ext)
+	b = appendPseudoHeader(b, src, dst, headerLength+len(seg.Data))
+	b = appendHeader(b, seg, headerLength)
+
+	opts := len(b)
+	if excludeOptions {
+		b = append(b, seg.Options[at:at+4]...)
+		b = append(b, make([]byte, aoMACLength)...)
+	} else {
+		b = append(b, seg.Options...)
+		b = append(b, make([]byte, headerLength-MinHeaderLength-len(seg.Options))...)
+		clear(b[opts+at+4 : opts+at+aoOptionLength])
+	}
+	return append(b, seg.Data...)
+}
@@ -0,0 +1,332 @@
+package tcp
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// aoPeers returns a client and server test peer with TCP-AO key chains,
+// before the handshake. Keys are given from the client's side; the server
+// gets them with SendID and RecvID swapped.
+func aoPeers(keys ...AOKey) (*testPeer, *testPeer) {
+	client, server := newTestPeer(true, nil), newTestPeer(false, nil)
+	client.conn.setAO(newAOConn(keys))
+	server.conn.setAO(newAOConn(peerKeys(keys)))
+	return client, server
+}
+
+// peerKeys returns the peer's view of keys.
+func peerKeys(keys []AOKey) []AOKey {
+	swapped := make([]AOKey, len(keys))
+	for i, k := range keys {
+		k.SendID, k.RecvID = k.RecvID, k.SendID
+		swapped[i] = k
+	}
+	return swapped
+}
+
+// aoKeyID returns the KeyID and RNextKeyID of a segment's TCP-AO option.
+func aoKeyID(t *testing.T, seg *Segment) (uint8, uint8) {
+	t.Helper()
+	i, ok := findOption(seg.Options, OptionKindAO)
+	if !ok {
+		t.Fatalf("segment %v has no TCP-AO option", seg)
+	}
+	return seg.Options[i+2], seg.Options[i+3]
+}
+
+func TestCMAC(t *testing.T) {
+	// RFC 4493 Section 4
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	tests := []struct {
+		length int
+		want   string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+	for _, tt := range tests {
+		if got := cmac(key, msg[:tt.length]); hex.EncodeToString(got[:]) != tt.want {
+			t.Errorf("cmac(%d bytes) = %x, want %s", tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestSNE(t *testing.T) {
+	var s sne
+	steps := []struct {
+		seq  uint32
+		want uint32
+	}{
+		{0xfffff000, 0},
+		{0xfffff800, 0},
+		{0x00000100, 1}, // The sequence numbers wrap
+		{0xfffffc00, 0}, // A retransmission from before the wrap
+		{0x00000200, 1},
+		{0x70000000, 1},
+		{0xe0000000, 1},
+		{0x00000400, 2}, // Wrapped again
+	}
+	for _, step := range steps {
+		var got uint32
+		got, s = s.of(step.seq)
+		if got != step.want {
+			t.Errorf("SNE of %#x = %d, want %d", step.seq, got, step.want)
+		}
+	}
+}
+
+func TestAOConnection(t *testing.T) {
+	tests := []struct {
+		name string
+		key  AOKey
+	}{
+		{"HMAC-SHA-1", AOKey{SendID: 1, RecvID: 2, Key: []byte("secret")}},
+		{"AES-CMAC", AOKey{SendID: 1, RecvID: 2, Key: []byte("0123456789abcdef"), Algorithm: AOAESCMAC}},
+		{"AES-CMAC, other key length", AOKey{SendID: 1, RecvID: 2, Key: []byte("secret"), Algorithm: AOAESCMAC}},
+		{"excluding options", AOKey{SendID: 1, RecvID: 2, Key: []byte("secret"), ExcludeOptions: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := aoPeers(tt.key)
+			if client.conn.gso {
+				t.Error("GSO enabled with TCP-AO")
+			}
+			var sent []*Segment
+			run := func() {
+				for len(client.out)+len(server.out) > 0 {
+					sent = appendClones(sent, client.out)
+					deliver(t, client, server)
+					sent = appendClones(sent, server.out)
+					deliver(t, server, client)
+				}
+			}
+			if err := client.conn.ActiveOpen(); err != nil {
+				t.Fatalf("ActiveOpen() error = %v", err)
+			}
+			run()
+			if err := client.conn.Send([]byte("hello")); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			if err := server.conn.Send([]byte("world")); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			run()
+			if string(server.data) != "hello" || string(client.data) != "world" {
+				t.Fatalf("received %q and %q, want %q and %q", server.data, client.data, "hello", "world")
+			}
+			for _, seg := range sent {
+				aoKeyID(t, seg)
+			}
+
+			// A forged RST without TCP-AO is dropped
+			injected := NewSegment(50000, 80, server.conn.rcvNxt, 0, FlagRST, 0, nil)
+			injected.Checksum, _ = injected.CalculateChecksum(testClientIP, testServerIP)
+			if err := server.conn.HandleSegment(injected); err == nil {
+				t.Error("HandleSegment() of an unauthenticated RST succeeded, want error")
+			}
+			if got := server.conn.GetState(); got != StateEstablished {
+				t.Errorf("state after an unauthenticated RST = %s, want ESTABLISHED", got)
+			}
+			if got := server.conn.Stats().AOFailures; got != 1 {
+				t.Errorf("AOFailures = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestAOVerify(t *testing.T) {
+	client, server := aoPeers(AOKey{SendID: 1, RecvID: 2, Key: []byte("secret")})
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	signed := func(f func(seg *Segment)) *Segment {
+		seg := NewSegment(50000, 80, client.conn.sndNxt, client.conn.rcvNxt, FlagACK|FlagPSH, 65535, []byte("data"))
+		client.conn.signAO(seg)
+		f(seg)
+		return seg
+	}
+	tests := []struct {
+		name string
+		seg  *Segment
+	}{
+		{"unsigned", NewSegment(50000, 80, client.conn.sndNxt, client.conn.rcvNxt, FlagACK, 65535, []byte("data"))},
+		{"changed data", signed(func(seg *Segment) { seg.Data = []byte("date") })},
+		{"changed options", signed(func(seg *Segment) { seg.Options = append(BuildMSSOption(1460), seg.Options...) })},
+		{"unknown KeyID", signed(func(seg *Segment) {
+			i, _ := findOption(seg.Options, OptionKindAO)
+			seg.Options[i+2] = 7
+		})},
+		{"MD5 option too", signed(func(seg *Segment) {
+			signMD5(seg, ipKey(testClientIP), ipKey(testServerIP), []byte("secret"))
+		})},
+		{"other SNE", signed(func(seg *Segment) {
+			client.conn.ao.sndSNE.high++
+			client.conn.signAO(seg)
+			client.conn.ao.sndSNE.high--
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if server.conn.verifyAO(tt.seg) {
+				t.Error("verifyAO() = true, want false")
+			}
+		})
+	}
+
+	if !server.conn.verifyAO(signed(func(*Segment) {})) {
+		t.Error("verifyAO() = false for a signed segment")
+	}
+	plain := newTestPeer(false, nil)
+	if plain.conn.verifyAO(signed(func(*Segment) {})) {
+		t.Error("verifyAO() = true without TCP-AO for a signed segment")
+	}
+}
+
+func TestAORollover(t *testing.T) {
+	old := AOKey{SendID: 1, RecvID: 2, Key: []byte("old secret")}
+	next := AOKey{SendID: 3, RecvID: 4, Key: []byte("new secret"), Algorithm: AOAESCMAC}
+	client, server := aoPeers(old)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+	s := NewSocket(testClientIP, 50000)
+	s.conn = client.conn
+
+	// Both sides add the new key; the client asks the server to use it
+	if err := client.conn.setAOKeys([]AOKey{old, next}); err != nil {
+		t.Fatalf("setAOKeys() error = %v", err)
+	}
+	if err := server.conn.setAOKeys(peerKeys([]AOKey{old, next})); err != nil {
+		t.Fatalf("setAOKeys() error = %v", err)
+	}
+	if err := s.SetAORNextKey(9); err == nil {
+		t.Error("SetAORNextKey() of an unknown key succeeded, want error")
+	}
+	if err := s.SetAORNextKey(next.RecvID); err != nil {
+		t.Fatalf("SetAORNextKey() error = %v", err)
+	}
+	client.conn.Send([]byte("switch"))
+	if keyID, rnext := aoKeyID(t, client.out[0]); keyID != old.SendID || rnext != next.RecvID {
+		t.Fatalf("KeyID, RNextKeyID = %d, %d; want %d, %d", keyID, rnext, old.SendID, next.RecvID)
+	}
+	deliver(t, client, server)
+	if keyID, _ := aoKeyID(t, server.out[0]); keyID != next.RecvID {
+		t.Errorf("server answered with KeyID %d, want %d", keyID, next.RecvID)
+	}
+	exchange(t, client, server)
+
+	// The client switches too, and the old key goes
+	if err := s.SetAOCurrentKey(next.SendID); err != nil {
+		t.Fatalf("SetAOCurrentKey() error = %v", err)
+	}
+	if err := client.conn.setAOKeys([]AOKey{next}); err != nil {
+		t.Fatalf("setAOKeys() error = %v", err)
+	}
+	if err := server.conn.setAOKeys(peerKeys([]AOKey{next})); err != nil {
+		t.Fatalf("setAOKeys() error = %v", err)
+	}
+	client.conn.Send([]byte(" done"))
+	exchange(t, client, server)
+	if string(server.data) != "switch done" {
+		t.Errorf("server received %q, want %q", server.data, "switch done")
+	}
+	if err := client.conn.setAOKeys(nil); err == nil {
+		t.Error("removing all keys of a connection succeeded, want error")
+	}
+}
+
+func TestAOListener(t *testing.T) {
+	var sent []*Segment
+	listener := NewSocket(testServerIP, 179)
+	listener.SetSendFunc(func(seg *Segment, src, dst common.IPv4Address) error {
+		sent = append(sent, seg)
+		return nil
+	})
+	key := AOKey{SendID: 2, RecvID: 1, Key: []byte("secret")}
+	for _, keys := range [][]AOKey{
+		{{Key: nil}},
+		{{Key: []byte("k"), Algorithm: AOAESCMAC + 1}},
+		{key, {SendID: 2, RecvID: 3, Key: []byte("k")}},
+	} {
+		if err := listener.SetAOKeys(testClientIP, keys...); err == nil {
+			t.Errorf("SetAOKeys(%v) succeeded, want error", keys)
+		}
+	}
+	if err := listener.SetAOKeys(testClientIP, key); err != nil {
+		t.Fatalf("SetAOKeys() error = %v", err)
+	}
+	if err := listener.SetMD5Key(testClientIP, []byte("secret")); err == nil {
+		t.Error("SetMD5Key() for a peer with TCP-AO keys succeeded, want error")
+	}
+	if err := listener.Listen(1); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	syn := NewSegment(50000, 179, 1000, 0, FlagSYN, 65535, nil)
+	syn.Checksum, _ = syn.CalculateChecksum(testClientIP, testServerIP)
+	listener.HandleIncomingSegment(syn, testClientIP, testServerIP)
+	if len(sent) != 0 {
+		t.Fatalf("sent %v to an unauthenticated SYN, want nothing", sent)
+	}
+
+	client := NewConnection(testClientIP, 50000, testServerIP, 179)
+	client.setAO(newAOConn(peerKeys([]AOKey{key})))
+	client.iss = 1000
+	syn = NewSegment(50000, 179, 1000, 0, FlagSYN, 65535, nil)
+	if err := client.signAO(syn); err != nil {
+		t.Fatalf("signAO() error = %v", err)
+	}
+	if err := listener.HandleIncomingSegment(syn, testClientIP, testServerIP); err != nil {
+		t.Fatalf("HandleIncomingSegment() error = %v", err)
+	}
+	if len(sent) != 1 || sent[0].Flags != FlagSYN|FlagACK {
+		t.Fatalf("sent %v, want a SYN-ACK", sent)
+	}
+	if !client.verifyAO(sent[0]) {
+		t.Error("SYN-ACK not authenticated with the key")
+	}
+}
+
+func TestAOExport(t *testing.T) {
+	client, server := aoPeers(AOKey{SendID: 1, RecvID: 2, Key: []byte("secret")}, AOKey{SendID: 3, RecvID: 4, Key: []byte("next"), ExcludeOptions: true})
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+	client.conn.ao.rnext = 4
+
+	state, err := client.conn.exportState(nil)
+	if err != nil {
+		t.Fatalf("exportState() error = %v", err)
+	}
+	s, st, err := importSocket(state)
+	if err != nil {
+		t.Fatalf("importSocket() error = %v", err)
+	}
+	s.conn.state.SetState(st)
+	a := s.conn.ao
+	if a == nil || len(a.keys) != 2 || a.current.SendID != 1 || a.rnext != 4 || a.sndSNE != client.conn.ao.sndSNE || a.rcvSNE != client.conn.ao.rcvSNE {
+		t.Fatalf("imported TCP-AO state = %+v, want that of the exported connection", a)
+	}
+
+	// The restored connection goes on authenticating
+	s.conn.onSegmentReady = client.conn.onSegmentReady
+	s.conn.onDataReady = client.conn.onDataReady
+	client.conn = s.conn
+	if err := client.conn.Send([]byte("moved")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+	if string(server.data) != "moved" {
+		t.Errorf("server received %q, want %q", server.data, "moved")
+	}
+}
@@ -0,0 +1,181 @@
+package tcp
+
+import "slices"
+
+// BacklogPolicy is what a listener does with connection attempts while
+// its accept queue is full.
+type BacklogPolicy int
+
+const (
+	// BacklogDrop drops SYNs, which the peer sends again, and holds
+	// connections completing the handshake until Accept makes room for
+	// them, as Linux does by default.
+	BacklogDrop BacklogPolicy = iota
+
+	// BacklogReset answers SYNs, and connections completing the
+	// handshake, with an RST, so that the peer fails at once rather than
+	// waiting, like Linux's tcp_abort_on_overflow.
+	BacklogReset
+)
+
+// AcceptQueueStats describes a listening socket's accept queue.
+type AcceptQueueStats struct {
+	Queued    int    // Connections waiting for Accept
+	Backlog   int    // Connections the queue holds
+	Pending   int    // Connections in the handshake, Held among them
+	Held      int    // Connections established and waiting for room in the queue (BacklogDrop)
+	Overflows uint64 // SYNs dropped or reset, and connections held or reset, for a full queue
+}
+
+// SetBacklogPolicy sets what the socket does with connection attempts
+// while it listens with a full accept queue, BacklogDrop by default.
+func (s *Socket) SetBacklogPolicy(policy BacklogPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backlogPolicy = policy
+}
+
+// OnAcceptQueueFull registers a callback invoked when a connection fills
+// the socket's accept queue, with the queue's state then. It is invoked
+// again only once Accept has made room.
+func (s *Socket) OnAcceptQueueFull(f func(AcceptQueueStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onQueueFull = f
+}
+
+// AcceptQueueStats returns the state of the socket's accept queue.
+func (s *Socket) AcceptQueueStats() AcceptQueueStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.acceptQueueStats()
+}
+
+func (s *Socket) acceptQueueStats() AcceptQueueStats {
+	s.pendingConnsMu.Lock()
+	pending := len(s.pendingConns)
+	s.pendingConnsMu.Unlock()
+	return AcceptQueueStats{
+		Queued:    len(s.acceptQueue),
+		Backlog:   cap(s.acceptQueue),
+		Pending:   pending,
+		Held:      len(s.held),
+		Overflows: s.overflows,
+	}
+}
+
+// acceptQueueFull returns whether the accept queue has no room. Called
+// with s.mu held, which every sender to the queue holds.
+func (s *Socket) acceptQueueFull() bool {
+	return len(s.acceptQueue) == cap(s.acceptQueue)
+}
+
+// overflow counts a connection attempt the full accept queue turned away
+// or held back.
+func (s *Socket) overflow() {
+	s.overflows++
+	stackCounters.listenOverflows.Add(1)
+}
+
+// queueConn moves a pending connection that completed the handshake to
+// the accept queue, and returns whether there was room. Called with s.mu
+// held.
+func (s *Socket) queueConn(key string, conn *Connection) (bool, error) {
+	if s.acceptQueueFull() {
+		return false, nil
+	}
+	s.pendingConnsMu.Lock()
+	delete(s.pendingConns, key)
+	s.pendingConnsMu.Unlock()
+	s.unhold(key)
+
+	// The demultiplexer delivers its segments from now on
+	if s.demux != nil {
+		conn.mu.Lock()
+		err := s.demux.addConn(conn, nil) // The listener holds the port
+		conn.mu.Unlock()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	s.acceptQueue <- conn
+	if s.acceptQueueFull() && !s.queueFull {
+		s.queueFull = true
+		s.queueFullNotice = s.onQueueFull != nil
+	}
+	return true, nil
+}
+
+// establishedOverflow handles a connection that completed the handshake
+// with the accept queue full, holding or resetting it as the backlog
+// policy says. Called with s.mu held.
+func (s *Socket) establishedOverflow(key string, conn *Connection) error {
+	s.overflow()
+	if s.backlogPolicy == BacklogReset {
+		s.pendingConnsMu.Lock()
+		delete(s.pendingConns, key)
+		s.pendingConnsMu.Unlock()
+		return conn.reset()
+	}
+	s.held = append(s.held, key)
+	return nil
+}
+
+// unhold forgets a held connection.
+func (s *Socket) unhold(key string) {
+	s.held = slices.DeleteFunc(s.held, func(k string) bool { return k == key })
+}
+
+// admitHeld moves held connections to the accept queue as it has room,
+// oldest first, once Accept took one from it. Those that closed meanwhile
+// are forgotten.
+func (s *Socket) admitHeld() {
+	s.mu.Lock()
+	if !s.acceptQueueFull() {
+		s.queueFull = false
+	}
+	for len(s.held) > 0 && s.isListening && !s.acceptQueueFull() {
+		key := s.held[0]
+		s.pendingConnsMu.Lock()
+		conn := s.pendingConns[key]
+		s.pendingConnsMu.Unlock()
+		if conn == nil || conn.GetState() == StateClosed {
+			s.unhold(key)
+			continue
+		}
+		if _, err := s.queueConn(key, conn); err != nil {
+			s.unhold(key)
+		}
+	}
+	notify := s.takeQueueFullNotice()
+	s.mu.Unlock()
+	notify()
+}
+
+// resetHeld resets the held connections when the listener closes, as
+// Accept will not return them. Called with s.mu held.
+func (s *Socket) resetHeld() {
+	for _, key := range s.held {
+		s.pendingConnsMu.Lock()
+		conn := s.pendingConns[key]
+		delete(s.pendingConns, key)
+		s.pendingConnsMu.Unlock()
+		if conn != nil {
+			conn.reset()
+		}
+	}
+	s.held = nil
+}
+
+// takeQueueFullNotice returns a function invoking the OnAcceptQueueFull
+// callback if the queue filled since the last call, and doing nothing
+// otherwise, to be called once s.mu is released. Called with s.mu held.
+func (s *Socket) takeQueueFullNotice() func() {
+	if !s.queueFullNotice {
+		return func() {}
+	}
+	s.queueFullNotice = false
+	f, stats := s.onQueueFull, s.acceptQueueStats()
+	return func() { f(stats) }
+}
@@ -0,0 +1,129 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// backlogListener returns a socket listening on port 80 with a backlog of
+// one, and the segments it sends.
+func backlogListener(t *testing.T, policy BacklogPolicy) (*Socket, *[]*Segment) {
+	t.Helper()
+	var sent []*Segment
+	listener := NewSocket(testServerIP, 80)
+	listener.SetSendFunc(func(seg *Segment, src, dst common.IPv4Address) error {
+		sent = append(sent, seg)
+		return nil
+	})
+	listener.SetBacklogPolicy(policy)
+	if err := listener.Listen(1); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	return listener, &sent
+}
+
+// backlogSYN has a client on port send the listener its SYN, and returns
+// the client, with the listener's answer, if any, delivered to it.
+func backlogSYN(t *testing.T, listener *Socket, sent *[]*Segment, port uint16) *testPeer {
+	t.Helper()
+	client := newTestPeer(true, nil)
+	client.conn = NewConnection(testClientIP, port, testServerIP, 80)
+	client.conn.onSegmentReady = func(seg *Segment) error {
+		client.out = append(client.out, seg)
+		return nil
+	}
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	backlogSend(t, listener, client)
+	for _, seg := range *sent {
+		if err := client.conn.HandleSegment(seg); err != nil {
+			t.Fatalf("HandleSegment() error = %v", err)
+		}
+	}
+	*sent = nil
+	return client
+}
+
+// backlogSend passes the listener the segments the client sent.
+func backlogSend(t *testing.T, listener *Socket, client *testPeer) {
+	t.Helper()
+	for _, seg := range client.out {
+		if err := listener.HandleIncomingSegment(seg, testClientIP, testServerIP); err != nil {
+			t.Fatalf("HandleIncomingSegment() error = %v", err)
+		}
+	}
+	client.out = nil
+}
+
+func TestBacklogDrop(t *testing.T) {
+	listener, sent := backlogListener(t, BacklogDrop)
+	defer listener.Close()
+	var full []AcceptQueueStats
+	listener.OnAcceptQueueFull(func(stats AcceptQueueStats) { full = append(full, stats) })
+
+	// Two handshakes start with room in the queue; the first fills it
+	a := backlogSYN(t, listener, sent, 50000)
+	b := backlogSYN(t, listener, sent, 50001)
+	backlogSend(t, listener, a)
+	if len(full) != 1 || full[0].Queued != 1 || full[0].Backlog != 1 {
+		t.Fatalf("OnAcceptQueueFull() called with %+v, want once with the queue full", full)
+	}
+
+	// The second is held, and a third SYN dropped
+	backlogSend(t, listener, b)
+	c := backlogSYN(t, listener, sent, 50002)
+	if c.conn.GetState() != StateSynSent {
+		t.Errorf("third client in %v, want its SYN unanswered", c.conn.GetState())
+	}
+	want := AcceptQueueStats{Queued: 1, Backlog: 1, Pending: 1, Held: 1, Overflows: 2}
+	if stats := listener.AcceptQueueStats(); stats != want {
+		t.Errorf("AcceptQueueStats() = %+v, want %+v", stats, want)
+	}
+
+	// Accepting the first makes room for the held one
+	for _, client := range []*testPeer{a, b} {
+		accepted, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("Accept() error = %v", err)
+		}
+		if port := accepted.GetRemotePort(); port != client.conn.LocalPort {
+			t.Errorf("accepted port %d, want %d", port, client.conn.LocalPort)
+		}
+	}
+	if len(full) != 2 {
+		t.Errorf("OnAcceptQueueFull() called %d times, want again once the held connection filled the queue", len(full))
+	}
+	if stats := listener.AcceptQueueStats(); stats.Queued != 0 || stats.Held != 0 || stats.Pending != 0 {
+		t.Errorf("AcceptQueueStats() = %+v, want nothing queued, held or pending", stats)
+	}
+}
+
+func TestBacklogReset(t *testing.T) {
+	listener, sent := backlogListener(t, BacklogReset)
+	defer listener.Close()
+	overflows := GetStackStats().ListenOverflows
+
+	a := backlogSYN(t, listener, sent, 50000)
+	b := backlogSYN(t, listener, sent, 50001)
+	backlogSend(t, listener, a)
+
+	// The handshake completing with the queue full is reset, as is a SYN
+	backlogSend(t, listener, b)
+	if len(*sent) != 1 || !(*sent)[0].HasFlag(FlagRST) {
+		t.Fatalf("sent %v to a connection completing with the queue full, want an RST", *sent)
+	}
+	*sent = nil
+	c := backlogSYN(t, listener, sent, 50002)
+	if c.conn.GetState() != StateClosed {
+		t.Errorf("client in %v after a SYN with the queue full, want reset", c.conn.GetState())
+	}
+
+	if stats := listener.AcceptQueueStats(); stats.Overflows != 2 || stats.Pending != 0 || stats.Held != 0 {
+		t.Errorf("AcceptQueueStats() = %+v, want 2 overflows and nothing pending", stats)
+	}
+	if got := GetStackStats().ListenOverflows - overflows; got != 2 {
+		t.Errorf("ListenOverflows grew by %d, want 2", got)
+	}
+}
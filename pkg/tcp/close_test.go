@@ -0,0 +1,156 @@
+package tcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCloseDrainsSendBuffer(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server, s := readingPeer(MinBufferSize)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	// Half the data waits on the closed window, and the FIN waits on it
+	data := make([]byte, 2*MinBufferSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := client.conn.Send(data); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := client.conn.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	exchange(t, client, server)
+	if got := client.conn.GetState(); got != StateFinWait1 {
+		t.Fatalf("state = %v with data unsent, want %v", got, StateFinWait1)
+	}
+	if client.conn.finSent {
+		t.Fatal("FIN sent ahead of the send buffer")
+	}
+
+	// Reading opens the window; the rest of the data goes, then the FIN
+	got := make([]byte, 0, len(data))
+	buf := make([]byte, len(data))
+	for client.conn.GetState() == StateFinWait1 {
+		n, err := s.RecvTimeout(buf, time.Second)
+		if err != nil {
+			t.Fatalf("RecvTimeout() error = %v after %d bytes", err, len(got))
+		}
+		got = append(got, buf[:n]...)
+		exchange(t, client, server)
+	}
+	if got := client.conn.GetState(); got != StateFinWait2 {
+		t.Errorf("client state = %v, want %v", got, StateFinWait2)
+	}
+	if got := server.conn.GetState(); got != StateCloseWait {
+		t.Errorf("server state = %v, want %v", got, StateCloseWait)
+	}
+
+	// The data ends at the FIN
+	for {
+		n, err := s.RecvTimeout(buf, time.Second)
+		if err != nil {
+			break
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("server read %d bytes, want the %d sent", len(got), len(data))
+	}
+}
+
+func TestHalfClose(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server, s := readingPeer(DefaultReceiveBufferSize)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	if err := client.conn.Send([]byte("request")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := client.conn.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	// The server reads to the end of the request
+	buf := make([]byte, 100)
+	n, err := s.Recv(buf)
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	if got := string(buf[:n]); got != "request" {
+		t.Errorf("Recv() = %q, want %q", got, "request")
+	}
+	if _, err := s.Recv(buf); err == nil {
+		t.Error("Recv() after the FIN succeeded, want an error")
+	}
+
+	// and answers on its open half
+	if _, err := s.Send([]byte("response")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+	if got := string(client.data); got != "response" {
+		t.Errorf("client received %q in %v, want %q", got, client.conn.GetState(), "response")
+	}
+
+	if err := s.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite() error = %v", err)
+	}
+	exchange(t, client, server)
+	if got := server.conn.GetState(); got != StateClosed {
+		t.Errorf("server state = %v, want %v", got, StateClosed)
+	}
+	if got := client.conn.GetState(); got != StateClosed {
+		t.Errorf("client state = %v after TIME_WAIT, want %v", got, StateClosed)
+	}
+}
+
+func TestCloseRead(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server, s := readingPeer(4096)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	if err := client.conn.Send(make([]byte, 4096)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+	if server.conn.rcvWnd != 0 {
+		t.Fatalf("window = %d with the buffer full, want 0", server.conn.rcvWnd)
+	}
+
+	// The unread data is dropped and the window reopens
+	if err := s.CloseRead(); err != nil {
+		t.Fatalf("CloseRead() error = %v", err)
+	}
+	if _, err := s.Recv(make([]byte, 100)); err == nil {
+		t.Error("Recv() after CloseRead succeeded, want an error")
+	}
+	exchange(t, client, server)
+	if client.conn.sndWnd != 4096 {
+		t.Errorf("client send window = %d after CloseRead, want 4096", client.conn.sndWnd)
+	}
+
+	// Later data is acknowledged and dropped
+	if err := client.conn.Send([]byte("ignored")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+	if client.conn.sndUna != client.conn.sndNxt {
+		t.Error("data sent after CloseRead not acknowledged")
+	}
+	if got := server.conn.recvQueued.Load(); got != 0 {
+		t.Errorf("%d bytes queued after CloseRead, want 0", got)
+	}
+}
@@ -0,0 +1,51 @@
+package tcp
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+)
+
+// cmac computes AES-CMAC (RFC 4493) of msg with a 16-byte key.
+func cmac(key, msg []byte) [aes.BlockSize]byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err) // Keys are always 16 bytes
+	}
+
+	// Subkeys K1 and K2: L = AES(K, 0), doubled in GF(2^128)
+	var k1, k2 [aes.BlockSize]byte
+	block.Encrypt(k1[:], k1[:])
+	k1 = cmacDouble(k1)
+	k2 = cmacDouble(k1)
+
+	var x, last [aes.BlockSize]byte
+	n := len(msg)
+	full := n > 0 && n%aes.BlockSize == 0
+	for ; len(msg) > aes.BlockSize; msg = msg[aes.BlockSize:] {
+		subtle.XORBytes(x[:], x[:], msg[:aes.BlockSize])
+		block.Encrypt(x[:], x[:])
+	}
+	copy(last[:], msg)
+	if full {
+		subtle.XORBytes(last[:], last[:], k1[:])
+	} else {
+		last[len(msg)] = 0x80
+		subtle.XORBytes(last[:], last[:], k2[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	block.Encrypt(x[:], x[:])
+	return x
+}
+
+// cmacDouble multiplies b by x in GF(2^128), as RFC 4493 derives subkeys.
+func cmacDouble(b [aes.BlockSize]byte) [aes.BlockSize]byte {
+	var d [aes.BlockSize]byte
+	for i := 0; i < aes.BlockSize-1; i++ {
+		d[i] = b[i]<<1 | b[i+1]>>7
+	}
+	d[aes.BlockSize-1] = b[aes.BlockSize-1] << 1
+	if b[0]&0x80 != 0 {
+		d[aes.BlockSize-1] ^= 0x87
+	}
+	return d
+}
@@ -6,9 +6,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ports"
 )
 
 // Connection represents a TCP connection.
@@ -19,6 +22,11 @@ type Connection struct {
 	RemoteAddr common.IPv4Address
 	RemotePort uint16
 
+	// Addresses of a connection over IPv6, which leaves LocalAddr and
+	// RemoteAddr zero
+	LocalAddr6  common.IPv6Address
+	RemoteAddr6 common.IPv6Address
+
 	// State machine
 	state *StateMachine
 	mu    sync.RWMutex
@@ -33,9 +41,18 @@ type Connection struct {
 	rcvWnd uint16 // Receive window
 	irs    uint32 // Initial receive sequence number
 
-	// Buffers
-	sendBuffer    *SendBuffer
-	receiveBuffer *ReceiveBuffer
+	// Buffers. Received data is held by the socket until it is read;
+	// recvQueued counts it for the receive window.
+	sendBuffer *SendBuffer
+	writable   chan struct{} // Signalled when Send may find room in sendBuffer
+
+	// Signalled on every state change
+	stateChanged chan struct{}
+
+	// Close was called and the FIN waits for sendBuffer to drain, or the
+	// FIN was sent
+	finQueued bool
+	finSent   bool
 
 	// Retransmission
 	retransmitQueue *RetransmitQueue
@@ -48,18 +65,96 @@ type Connection struct {
 	ssthresh  uint32 // Slow start threshold
 	dupAckCnt int    // Duplicate ACK count
 
+	// Loss detection (see rack.go), with SACK if both ends permitted it
+	// in their SYNs
+	sackOK bool
+	rack   rackState
+
+	// Spurious retransmission timeout detection (see frto.go)
+	frto frtoState
+
 	// Options
 	mss         uint16 // Maximum segment size
 	windowScale uint8  // Window scale factor
-
-	// Timers
-	timeWaitTimer *time.Timer
-	retransmitTimer *time.Timer
+	gso         bool   // Send GSO super-segments (see Socket.SetGSO)
+	opts        socketOptions
+
+	// Last timestamp received, for TIME_WAIT reuse
+	tsRecent    uint32
+	hasTSRecent bool
+
+	// Urgent data (RFC 6093): the sequence number after the last urgent
+	// byte to send, and after the last one to receive, while pending
+	sndUp     uint32
+	sndUrgent bool
+	rcvUp     uint32
+	rcvUrgent bool
+
+	// Timers, run by a shared timer wheel
+	timers          *TimerWheel
+	retransmitTimer *Timer
+	keepAliveTimer  *Timer
+	keepAliveProbes int // Unanswered keepalive probes
+	persistTimer    *Timer
+	persistBackoff  time.Duration
+	pacingTimer     *Timer
+	rackTimer       *Timer // RACK reordering timer
+	tlpTimer        *Timer // Tail loss probe timer
+
+	// Time the pacing rate lets the next segment out (see pacing.go), and
+	// the clock it is measured with, replaced in tests
+	pacingNext time.Time
+	now        func() time.Time
+
+	// Table the connection hands its 4-tuple to on entering TIME_WAIT
+	timeWait *TimeWaitTable
+
+	// Demultiplexer delivering its segments, if any, and the binding of
+	// the local port it connected from
+	demux   *Demultiplexer
+	binding *ports.Binding
+
+	// Key of the MD5 signature option (RFC 2385), nil if unsigned
+	md5Key []byte
+
+	// TCP Authentication Option state (RFC 5925), nil if not used
+	ao *aoConn
+
+	// TCP Fast Open (RFC 7413), nil if disabled
+	tfo     *TFOConnection
+	synData []byte // Data sent in our SYN
 
 	// Callbacks
 	onSegmentReady func(*Segment) error // Called when a segment is ready to send
 	onDataReady    func([]byte)         // Called when data is ready to deliver to app
 	onClose        func()               // Called when connection is closed
+	onFinReceived  func()               // Called when the peer's FIN ends its data
+	onUrgent       func(byte, bool)     // Called with the urgent byte, and whether it stays inline, before the data after it
+
+	// Data received before onDataReady was set
+	earlyData []byte
+
+	// End of the data a Flush pushed, while it is not all sent
+	sndPush     uint32
+	pushPending bool
+
+	// Last error ICMP reported for the connection, or the one that
+	// aborted it
+	err     error
+	aborted bool
+
+	// Challenge ACKs sent since challengeStart (RFC 5961 Section 7)
+	challengeACKs  int
+	challengeStart time.Time
+
+	// Opened by a listener
+	passive bool
+
+	// Bytes queued for Socket.Recv
+	recvQueued atomic.Int64
+
+	// Statistics
+	stats connCounters
 }
 
 // NewConnection creates a new TCP connection.
@@ -70,10 +165,11 @@ func NewConnection(localAddr common.IPv4Address, localPort uint16, remoteAddr co
 		RemoteAddr:      remoteAddr,
 		RemotePort:      remotePort,
 		state:           NewStateMachine(),
-		rcvWnd:          65535, // Default receive window
+		rcvWnd:          DefaultReceiveBufferSize, // Default receive window
 		sndWnd:          65535, // Default send window (will be updated)
 		sendBuffer:      NewSendBuffer(),
-		receiveBuffer:   NewReceiveBuffer(65535),
+		writable:        make(chan struct{}, 1),
+		stateChanged:    make(chan struct{}, 1),
 		retransmitQueue: NewRetransmitQueue(),
 		rto:             time.Second,     // Initial RTO = 1 second
 		srtt:            0,
@@ -82,7 +178,13 @@ func NewConnection(localAddr common.IPv4Address, localPort uint16, remoteAddr co
 		ssthresh:         65535,          // Initial ssthresh = max window
 		mss:             DefaultMSS,
 		windowScale:     0,
+		opts:            defaultSocketOptions,
+		timers:          defaultTimerWheel,
+		now:             time.Now,
+		timeWait:        timeWaitTable,
 	}
+	conn.stats.created = time.Now()
+	conn.recordCwnd()
 
 	return conn
 }
@@ -98,7 +200,9 @@ func (c *Connection) GetState() State {
 func (c *Connection) SetState(state State) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	from := c.state.GetState()
 	c.state.SetState(state)
+	c.track(from, state)
 }
 
 // ActiveOpen initiates an active open (client-side connection).
@@ -117,30 +221,37 @@ func (c *Connection) ActiveOpen() error {
 
 	// Create SYN segment
 	seg := NewSegment(c.LocalPort, c.RemotePort, c.iss, 0, FlagSYN, c.rcvWnd, nil)
-	seg.Options = BuildMSSOption(c.mss)
+	seg.Options = append(BuildMSSOption(c.mss), BuildSACKPermittedOption()...)
+
+	// With Fast Open the SYN may carry data
+	if c.tfo != nil {
+		c.addFastOpen(seg)
+	}
 
 	// Calculate checksum
-	checksum, err := seg.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
+	checksum, err := c.checksum(seg)
 	if err != nil {
 		return fmt.Errorf("failed to calculate checksum: %w", err)
 	}
 	seg.Checksum = checksum
 
 	// Transition state
-	if err := c.state.Transition(EventActiveOpen); err != nil {
+	if err := c.transition(EventActiveOpen); err != nil {
 		return err
 	}
+	stackCounters.activeOpens.Add(1)
 
 	// Send SYN segment
 	if c.onSegmentReady != nil {
-		if err := c.onSegmentReady(seg); err != nil {
+		if err := c.transmit(seg); err != nil {
 			return fmt.Errorf("failed to send SYN: %w", err)
 		}
 	}
 
 	// Add to retransmit queue
 	c.retransmitQueue.Add(c.iss, seg, time.Now())
-	c.sndNxt = c.iss + 1
+	c.armRetransmitTimer(false)
+	c.sndNxt = c.iss + 1 + uint32(len(seg.Data))
 
 	return nil
 }
@@ -155,7 +266,7 @@ func (c *Connection) PassiveOpen() error {
 	}
 
 	// Transition to LISTEN state
-	return c.state.Transition(EventPassiveOpen)
+	return c.transition(EventPassiveOpen)
 }
 
 // HandleSegment processes an incoming TCP segment.
@@ -165,10 +276,57 @@ func (c *Connection) HandleSegment(seg *Segment) error {
 
 	state := c.state.GetState()
 
-	// Verify checksum
-	if !seg.VerifyChecksum(c.RemoteAddr, c.LocalAddr) {
+	// Verify checksum, unless a Coalescer already did
+	if !seg.checksumVerified && !c.verifyChecksum(seg) {
+		c.stats.checksumErrors++
+		stackCounters.checksumErrors.Add(1)
+		logger.Debug("checksum verification failed", c.logID(), logging.F("seg", seg))
 		return fmt.Errorf("checksum verification failed")
 	}
+	if !c.verifyMD5(seg) {
+		c.stats.md5Failures++
+		stackCounters.md5Failures.Add(1)
+		logger.Debug("MD5 signature verification failed", c.logID(), logging.F("seg", seg))
+		return fmt.Errorf("MD5 signature verification failed")
+	}
+	if !c.verifyAO(seg) {
+		c.stats.aoFailures++
+		stackCounters.aoFailures.Add(1)
+		logger.Debug("TCP-AO verification failed", c.logID(), logging.F("seg", seg))
+		return fmt.Errorf("TCP-AO verification failed")
+	}
+	c.stats.segmentsReceived++
+	c.stats.bytesReceived += uint64(len(seg.Data))
+	stackCounters.segmentsReceived.Add(1)
+	if seg.HasFlag(FlagRST) {
+		stackCounters.resetsReceived.Add(1)
+	}
+	if logger.Enabled(logging.LevelTrace) {
+		logger.Packet("rx", seg, c.logID(), logging.F("state", state))
+	}
+
+	if seg.HasFlag(FlagRST) {
+		c.handleReset(seg)
+		return nil
+	}
+
+	// Segments outside the windows are dropped, keepalive probes among
+	// them: the ACK validateSegment sends answers the probe
+	if state.IsConnectionEstablished() {
+		if seg = c.validateSegment(seg); seg == nil {
+			return nil
+		}
+	}
+	if len(seg.Options) > 0 {
+		if tsVal, _, err := seg.GetTimestamp(); err == nil {
+			c.tsRecent, c.hasTSRecent = tsVal, true
+		}
+	}
+
+	// Any segment shows the peer is still there
+	if c.opts.keepAlive {
+		c.armKeepAlive()
+	}
 
 	// State-specific processing
 	switch state {
@@ -190,8 +348,6 @@ func (c *Connection) HandleSegment(seg *Segment) error {
 		return c.handleSegmentClosing(seg)
 	case StateLastAck:
 		return c.handleSegmentLastAck(seg)
-	case StateTimeWait:
-		return c.handleSegmentTimeWait(seg)
 	default:
 		return fmt.Errorf("invalid state: %s", state)
 	}
@@ -201,6 +357,7 @@ func (c *Connection) HandleSegment(seg *Segment) error {
 func (c *Connection) handleSegmentListen(seg *Segment) error {
 	if seg.HasFlag(FlagSYN) && !seg.HasFlag(FlagACK) {
 		// Received SYN, transition to SYN_RECEIVED
+		c.passive = true
 		c.irs = seg.SequenceNumber
 		c.rcvNxt = seg.SequenceNumber + 1
 
@@ -209,32 +366,46 @@ func (c *Connection) handleSegmentListen(seg *Segment) error {
 		c.sndUna = c.iss
 		c.sndNxt = c.iss
 
-		// Extract MSS from options
-		if mss, err := seg.GetMSS(); err == nil {
-			c.mss = mss
+		// Send no larger segments than the peer takes, and announce our own MSS
+		mss := c.mss
+		if peer, err := seg.GetMSS(); err == nil && peer < c.mss {
+			c.mss = peer
+		}
+		c.sackOK = seg.HasSACKPermitted()
+
+		// Fast Open may accept data in the SYN or hand out a cookie
+		var tfoOption []byte
+		if c.tfo != nil && seg.HasTFO() {
+			tfoOption = c.acceptFastOpen(seg)
 		}
 
 		// Send SYN+ACK
 		reply := NewSegment(c.LocalPort, c.RemotePort, c.iss, c.rcvNxt, FlagSYN|FlagACK, c.rcvWnd, nil)
-		reply.Options = BuildMSSOption(c.mss)
+		reply.Options = BuildMSSOption(mss)
+		if c.sackOK {
+			reply.Options = append(reply.Options, BuildSACKPermittedOption()...)
+		}
+		reply.Options = append(reply.Options, tfoOption...)
 
-		checksum, err := reply.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
+		checksum, err := c.checksum(reply)
 		if err != nil {
 			return err
 		}
 		reply.Checksum = checksum
 
-		if err := c.state.Transition(EventReceiveSyn); err != nil {
+		if err := c.transition(EventReceiveSyn); err != nil {
 			return err
 		}
+		stackCounters.passiveOpens.Add(1)
 
 		if c.onSegmentReady != nil {
-			if err := c.onSegmentReady(reply); err != nil {
+			if err := c.transmit(reply); err != nil {
 				return err
 			}
 		}
 
 		c.retransmitQueue.Add(c.iss, reply, time.Now())
+		c.armRetransmitTimer(false)
 		c.sndNxt = c.iss + 1
 
 		return nil
@@ -246,14 +417,23 @@ func (c *Connection) handleSegmentListen(seg *Segment) error {
 // handleSegmentSynSent handles segments in SYN_SENT state.
 func (c *Connection) handleSegmentSynSent(seg *Segment) error {
 	if seg.HasFlag(FlagSYN) && seg.HasFlag(FlagACK) {
-		// Received SYN+ACK
-		if seg.AckNumber != c.sndNxt {
+		// Received SYN+ACK. It acknowledges our SYN, and any Fast Open
+		// data the server accepted.
+		if seg.AckNumber-(c.iss+1) > c.sndNxt-(c.iss+1) {
 			return fmt.Errorf("invalid ACK number: got %d, expected %d", seg.AckNumber, c.sndNxt)
 		}
 
 		c.irs = seg.SequenceNumber
 		c.rcvNxt = seg.SequenceNumber + 1
 		c.sndUna = seg.AckNumber
+		c.sampleRTT(seg.AckNumber)
+
+		// SYN data the server did not accept is sent again
+		var unsent []byte
+		if c.tfo != nil {
+			unsent = c.fastOpenSynAck(seg)
+		}
+		c.sndNxt = seg.AckNumber
 
 		// Extract MSS from options
 		if mss, err := seg.GetMSS(); err == nil {
@@ -261,39 +441,107 @@ func (c *Connection) handleSegmentSynSent(seg *Segment) error {
 				c.mss = mss
 			}
 		}
+		c.sackOK = seg.HasSACKPermitted()
 
 		// Update send window
 		c.sndWnd = seg.WindowSize
 
 		// Remove SYN from retransmit queue
 		c.retransmitQueue.Remove(c.iss)
+		c.armRetransmitTimer(true)
 
 		// Send ACK
 		ack := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagACK, c.rcvWnd, nil)
-		checksum, err := ack.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
+		checksum, err := c.checksum(ack)
 		if err != nil {
 			return err
 		}
 		ack.Checksum = checksum
 
-		if err := c.state.Transition(EventReceiveSynAck); err != nil {
+		if err := c.transition(EventReceiveSynAck); err != nil {
 			return err
 		}
+		stackCounters.established.Add(1)
 
 		if c.onSegmentReady != nil {
-			if err := c.onSegmentReady(ack); err != nil {
+			if err := c.transmit(ack); err != nil {
 				return err
 			}
 		}
 
+		if len(unsent) > 0 {
+			c.sendBuffer.Write(unsent)
+			return c.sendData()
+		}
+
 		return nil
 	}
 
+	if seg.HasFlag(FlagSYN) {
+		return c.simultaneousOpen(seg)
+	}
+
 	return fmt.Errorf("expected SYN+ACK in SYN_SENT state")
 }
 
-// handleSegmentSynReceived handles segments in SYN_RECEIVED state.
+// simultaneousOpen handles the peer's SYN crossing ours (RFC 793 Section
+// 3.4, Figure 8): the connection moves to SYN_RECEIVED and sends a SYN-ACK
+// repeating our SYN, which it retransmits in place of the SYN. Data in
+// either SYN is sent again once the connection is established.
+func (c *Connection) simultaneousOpen(seg *Segment) error {
+	c.irs = seg.SequenceNumber
+	c.rcvNxt = seg.SequenceNumber + 1
+	c.sndWnd = seg.WindowSize
+	mss := c.mss
+	if peer, err := seg.GetMSS(); err == nil && peer < c.mss {
+		c.mss = peer
+	}
+	c.sackOK = seg.HasSACKPermitted()
+
+	if c.tfo != nil {
+		c.sendBuffer.Write(append(c.synData, c.tfo.GetQueuedData()...))
+		c.synData = nil
+	}
+	c.sndNxt = c.iss + 1
+
+	synAck := NewSegment(c.LocalPort, c.RemotePort, c.iss, c.rcvNxt, FlagSYN|FlagACK, c.rcvWnd, nil)
+	synAck.Options = append(BuildMSSOption(mss), BuildSACKPermittedOption()...)
+	checksum, err := c.checksum(synAck)
+	if err != nil {
+		return err
+	}
+	synAck.Checksum = checksum
+
+	if err := c.transition(EventReceiveSyn); err != nil {
+		return err
+	}
+	if c.onSegmentReady != nil {
+		if err := c.transmit(synAck); err != nil {
+			return err
+		}
+	}
+
+	c.retransmitQueue.Remove(c.iss)
+	c.retransmitQueue.Add(c.iss, synAck, time.Now())
+	c.armRetransmitTimer(true)
+	return nil
+}
+
+// handleSegmentSynReceived handles segments in SYN_RECEIVED state. The
+// ACK of our SYN establishes the connection; it may carry data and the
+// peer's FIN, and after a simultaneous open it is the peer's SYN-ACK. A
+// retransmission of the peer's SYN means our SYN-ACK was lost.
 func (c *Connection) handleSegmentSynReceived(seg *Segment) error {
+	if seg.HasFlag(FlagSYN) && !seg.HasFlag(FlagACK) {
+		if seg.SequenceNumber != c.irs {
+			return fmt.Errorf("unexpected SYN in SYN_RECEIVED state")
+		}
+		if head := c.retransmitQueue.GetFirst(); head != nil && c.onSegmentReady != nil {
+			c.transmit(head)
+		}
+		return nil
+	}
+
 	if seg.HasFlag(FlagACK) {
 		if seg.AckNumber != c.sndNxt {
 			return fmt.Errorf("invalid ACK number: got %d, expected %d", seg.AckNumber, c.sndNxt)
@@ -301,12 +549,28 @@ func (c *Connection) handleSegmentSynReceived(seg *Segment) error {
 
 		c.sndUna = seg.AckNumber
 		c.sndWnd = seg.WindowSize
+		c.sampleRTT(seg.AckNumber)
 
 		// Remove SYN from retransmit queue
 		c.retransmitQueue.Remove(c.iss)
+		c.armRetransmitTimer(true)
 
 		// Transition to ESTABLISHED
-		return c.state.Transition(EventReceiveAck)
+		if err := c.transition(EventReceiveAck); err != nil {
+			return err
+		}
+		stackCounters.established.Add(1)
+
+		if len(seg.Data) > 0 {
+			c.processData(seg)
+		}
+		if c.acceptFin(seg) {
+			return c.transition(EventReceiveFin)
+		}
+		if c.sendBuffer.Len() > 0 {
+			return c.sendData()
+		}
+		return nil
 	}
 
 	return fmt.Errorf("expected ACK in SYN_RECEIVED state")
@@ -325,81 +589,58 @@ func (c *Connection) handleSegmentEstablished(seg *Segment) error {
 	}
 
 	// Process FIN
-	if seg.HasFlag(FlagFIN) {
-		c.rcvNxt = seg.SequenceNumber + uint32(len(seg.Data)) + 1
-
-		// Send ACK for FIN
-		ack := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagACK, c.rcvWnd, nil)
-		checksum, _ := ack.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
-		ack.Checksum = checksum
-
-		if c.onSegmentReady != nil {
-			c.onSegmentReady(ack)
-		}
-
-		return c.state.Transition(EventReceiveFin)
+	if c.acceptFin(seg) {
+		return c.transition(EventReceiveFin)
 	}
 
 	return nil
 }
 
-// handleSegmentFinWait1 handles segments in FIN_WAIT_1 state.
+// handleSegmentFinWait1 handles segments in FIN_WAIT_1 state. Data still
+// in the send buffer goes out ahead of our FIN, and the peer may go on
+// sending until its own FIN.
 func (c *Connection) handleSegmentFinWait1(seg *Segment) error {
 	// Process ACK
 	if seg.HasFlag(FlagACK) {
 		c.processAck(seg)
-
-		// Check if FIN was ACKed
-		if seg.AckNumber > c.sndUna {
-			c.retransmitQueue.Remove(c.sndNxt - 1)
-			c.sndUna = seg.AckNumber
-		}
 	}
 
-	// Process FIN
-	if seg.HasFlag(FlagFIN) {
-		c.rcvNxt = seg.SequenceNumber + uint32(len(seg.Data)) + 1
-
-		// Send ACK for FIN
-		ack := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagACK, c.rcvWnd, nil)
-		checksum, _ := ack.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
-		ack.Checksum = checksum
-
-		if c.onSegmentReady != nil {
-			c.onSegmentReady(ack)
-		}
+	// Process data
+	if len(seg.Data) > 0 {
+		c.processData(seg)
+	}
 
-		if seg.HasFlag(FlagACK) {
-			return c.state.Transition(EventReceiveFinAck)
+	// Process FIN, moving on as far as the ACK of our FIN allows
+	finAcked := c.finAcked()
+	if c.acceptFin(seg) {
+		if finAcked {
+			return c.enterTimeWait(EventReceiveFinAck)
 		}
-		return c.state.Transition(EventReceiveFin)
+		return c.transition(EventReceiveFin)
 	}
-
-	if seg.HasFlag(FlagACK) && !seg.HasFlag(FlagFIN) {
-		return c.state.Transition(EventReceiveAck)
+	if finAcked {
+		return c.transition(EventReceiveAck)
 	}
 
 	return nil
 }
 
-// handleSegmentFinWait2 handles segments in FIN_WAIT_2 state.
+// handleSegmentFinWait2 handles segments in FIN_WAIT_2 state, receiving
+// data until the peer's FIN.
 func (c *Connection) handleSegmentFinWait2(seg *Segment) error {
-	if seg.HasFlag(FlagFIN) {
-		c.rcvNxt = seg.SequenceNumber + uint32(len(seg.Data)) + 1
-
-		// Send ACK for FIN
-		ack := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagACK, c.rcvWnd, nil)
-		checksum, _ := ack.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
-		ack.Checksum = checksum
-
-		if c.onSegmentReady != nil {
-			c.onSegmentReady(ack)
-		}
+	// Nothing is left to acknowledge, but the window may change
+	if seg.HasFlag(FlagACK) {
+		c.processAck(seg)
+	}
 
-		// Start TIME_WAIT timer (2 * MSL)
-		c.startTimeWaitTimer()
+	// Process data
+	if len(seg.Data) > 0 {
+		c.processData(seg)
+	}
 
-		return c.state.Transition(EventReceiveFin)
+	// Process FIN
+	if c.acceptFin(seg) {
+		return c.enterTimeWait(EventReceiveFin)
 	}
 
 	return nil
@@ -418,11 +659,9 @@ func (c *Connection) handleSegmentCloseWait(seg *Segment) error {
 func (c *Connection) handleSegmentClosing(seg *Segment) error {
 	if seg.HasFlag(FlagACK) {
 		c.processAck(seg)
-
-		// Start TIME_WAIT timer
-		c.startTimeWaitTimer()
-
-		return c.state.Transition(EventReceiveAck)
+		if c.finAcked() {
+			return c.enterTimeWait(EventReceiveAck)
+		}
 	}
 	return nil
 }
@@ -431,8 +670,11 @@ func (c *Connection) handleSegmentClosing(seg *Segment) error {
 func (c *Connection) handleSegmentLastAck(seg *Segment) error {
 	if seg.HasFlag(FlagACK) {
 		c.processAck(seg)
+		if !c.finAcked() {
+			return nil
+		}
 
-		if err := c.state.Transition(EventReceiveAck); err != nil {
+		if err := c.transition(EventReceiveAck); err != nil {
 			return err
 		}
 
@@ -446,49 +688,69 @@ func (c *Connection) handleSegmentLastAck(seg *Segment) error {
 	return nil
 }
 
-// handleSegmentTimeWait handles segments in TIME_WAIT state.
-func (c *Connection) handleSegmentTimeWait(seg *Segment) error {
-	// If we receive a FIN, re-ACK it and restart timer
-	if seg.HasFlag(FlagFIN) {
-		ack := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagACK, c.rcvWnd, nil)
-		checksum, _ := ack.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
-		ack.Checksum = checksum
-
-		if c.onSegmentReady != nil {
-			c.onSegmentReady(ack)
-		}
-
-		c.startTimeWaitTimer()
-	}
-
-	return nil
-}
-
 // processAck processes an ACK segment.
 func (c *Connection) processAck(seg *Segment) {
 	// Update send window
+	window := c.sndWnd
 	c.sndWnd = seg.WindowSize
 
+	// Segments the ACK selectively acknowledges
+	now := time.Now()
+	delivered, dsack := c.rackSACK(seg)
+
 	// Check if this ACKs new data
 	if seg.AckNumber > c.sndUna {
 		// New ACK received
 		bytesAcked := seg.AckNumber - c.sndUna
+		delivered = append(c.retransmitQueue.Acked(seg.AckNumber), delivered...)
 		c.sndUna = seg.AckNumber
+		c.sampleRTT(seg.AckNumber)
 
-		// Remove ACKed segments from retransmit queue
+		// Remove ACKed segments from retransmit queue, and restart the
+		// retransmission timer for what is still outstanding
 		c.retransmitQueue.RemoveBefore(seg.AckNumber)
+		c.armRetransmitTimer(true)
 
-		// Update congestion window
-		c.updateCongestionWindow(bytesAcked)
+		// Update congestion window, which holds during loss recovery
+		if !c.rack.inRecovery {
+			c.updateCongestionWindow(bytesAcked)
+		}
 
 		// Reset duplicate ACK counter
 		c.dupAckCnt = 0
-	} else if seg.AckNumber == c.sndUna && len(seg.Data) == 0 {
-		// Duplicate ACK
+		c.frtoAck(delivered, true)
+		c.rackAck(delivered, dsack, now)
+
+		// Send data the ACK made room for
+		if c.sendBuffer.Len() > 0 && c.canFlush() {
+			c.sendData()
+		}
+		c.armLossProbe()
+		c.wakeWriters()
+		c.retransmitQueue.Recycle()
+		return
+	}
+
+	frto := c.frto.step != frtoIdle
+	if frto && seg.AckNumber == c.sndUna && len(seg.Data) == 0 {
+		c.frtoAck(delivered, false)
+	}
+	if len(delivered) > 0 || dsack != nil || frto {
+		c.rackAck(delivered, dsack, now)
+	}
+	if seg.AckNumber == c.sndUna && seg.WindowSize != window {
+		// Window update, such as the answer to a window probe
+		if seg.WindowSize > window && c.sendBuffer.Len() > 0 && c.canFlush() {
+			c.sendData()
+		}
+	} else if seg.AckNumber == c.sndUna && len(seg.Data) == 0 && c.retransmitQueue.Len() > 0 {
+		// Duplicate ACK (RFC 5681 Section 2)
 		c.dupAckCnt++
+		c.stats.dupAcks++
 
-		// Fast retransmit on 3 duplicate ACKs
-		if c.dupAckCnt == 3 {
+		// Fast retransmit on 3 duplicate ACKs, unless the peer sends SACK
+		// blocks for RACK to find losses with
+		if c.dupAckCnt == 3 && !c.rack.sackSeen {
 			c.fastRetransmit()
 		}
 	}
@@ -497,22 +759,28 @@ func (c *Connection) processAck(seg *Segment) {
 // processData processes data in a segment.
 func (c *Connection) processData(seg *Segment) {
 	if seg.SequenceNumber == c.rcvNxt {
-		// In-order data
-		c.receiveBuffer.Write(seg.Data)
-		c.rcvNxt += uint32(len(seg.Data))
+		// In-order data, as much as the window has room for. The rest is
+		// left for the peer to send again once the window opens.
+		data := seg.Data
+		if len(data) > int(c.rcvWnd) {
+			data = data[:c.rcvWnd]
+		}
+		c.rcvNxt += uint32(len(data))
 
-		// Deliver data to application
-		if c.onDataReady != nil {
-			c.onDataReady(seg.Data)
+		// Deliver data to application. Nothing is held here waiting for
+		// PSH; a Coalescer in front of the connection delivers at a PSH.
+		if len(data) > 0 {
+			c.deliverUrgent(seg, data)
+			c.shrinkReceiveWindow()
 		}
 
 		// Send ACK
 		ack := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagACK, c.rcvWnd, nil)
-		checksum, _ := ack.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
+		checksum, _ := c.checksum(ack)
 		ack.Checksum = checksum
 
 		if c.onSegmentReady != nil {
-			c.onSegmentReady(ack)
+			c.transmit(ack)
 		}
 	} else {
 		// Out-of-order data - store in receive buffer
@@ -520,13 +788,67 @@ func (c *Connection) processData(seg *Segment) {
 	}
 }
 
+// acceptFin takes the peer's FIN if it follows the segment's data in
+// sequence, which it does not if the data was out of order or did not fit
+// the window, and acknowledges it. It returns whether the FIN was taken.
+func (c *Connection) acceptFin(seg *Segment) bool {
+	if !seg.HasFlag(FlagFIN) || seg.SequenceNumber+uint32(len(seg.Data)) != c.rcvNxt {
+		return false
+	}
+	c.rcvNxt++
+
+	// Send ACK for FIN
+	ack := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagACK, c.rcvWnd, nil)
+	checksum, _ := c.checksum(ack)
+	ack.Checksum = checksum
+
+	if c.onSegmentReady != nil {
+		c.transmit(ack)
+	}
+
+	if c.onFinReceived != nil {
+		c.onFinReceived()
+	}
+	return true
+}
+
+// deliver passes in-order data to the application, holding it until the
+// data callback is set.
+func (c *Connection) deliver(data []byte) {
+	if c.onDataReady == nil {
+		c.earlyData = append(c.earlyData, data...)
+		return
+	}
+	c.onDataReady(data)
+}
+
+// setDataReady sets the data callback, first passing it any data that
+// arrived before it was set. A server connection can receive data (Fast
+// Open SYN data, or data sent right after the handshake) before it is
+// accepted.
+func (c *Connection) setDataReady(f func([]byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onDataReady = f
+	if len(c.earlyData) > 0 {
+		data := c.earlyData
+		c.earlyData = nil
+		f(data)
+	}
+}
+
 // Send sends data over the connection.
 func (c *Connection) Send(data []byte) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if !c.state.GetState().CanSendData() {
-		return fmt.Errorf("cannot send data in state %s", c.state.GetState())
+		return c.sendStateError()
+	}
+
+	if queued := c.sendQueued(); queued+len(data) > c.opts.sendBuffer {
+		return fmt.Errorf("%w: %d bytes queued, limit %d", ErrSendBufferFull, queued, c.opts.sendBuffer)
 	}
 
 	// Add data to send buffer
@@ -536,8 +858,42 @@ func (c *Connection) Send(data []byte) error {
 	return c.sendData()
 }
 
-// sendData sends data from the send buffer.
+// write queues as much of data as the send buffer has room for and sends
+// what it can, returning how much was queued. It fails with
+// ErrSendBufferFull if there was no room at all.
+func (c *Connection) write(data []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.state.GetState().CanSendData() {
+		return 0, c.sendStateError()
+	}
+
+	n := min(len(data), c.opts.sendBuffer-c.sendQueued())
+	if n <= 0 && len(data) > 0 {
+		return 0, ErrSendBufferFull
+	}
+	c.sendBuffer.Write(data[:n])
+	return n, c.sendData()
+}
+
+// sendQueued returns the bytes in the send buffer: data not yet sent, and
+// data sent and not yet acknowledged.
+func (c *Connection) sendQueued() int {
+	return c.sendBuffer.Len() + int(c.sndNxt-c.sndUna)
+}
+
+// sendData sends data from the send buffer, holding back a small segment
+// if Nagle's algorithm is enabled.
 func (c *Connection) sendData() error {
+	return c.sendBuffered(c.opts.noDelay)
+}
+
+// sendBuffered sends data from the send buffer as the windows allow. Unless
+// push is set, a segment smaller than the MSS waits while data is
+// unacknowledged (RFC 1122 Section 4.2.3.4).
+func (c *Connection) sendBuffered(push bool) error {
+	sent := false
 	for {
 		// Check if we can send more data (window check)
 		availableWindow := int(c.sndWnd) - int(c.sndNxt-c.sndUna)
@@ -550,38 +906,109 @@ func (c *Connection) sendData() error {
 			break
 		}
 
+		size := c.sendSize(availableWindow)
+		if !push && !c.finQueued && !c.flushing() && c.sendBuffer.Len() < int(c.mss) && c.sndNxt != c.sndUna {
+			break
+		}
+
+		// Hold the segment back if it comes too soon for the pacing rate
+		if c.sendBuffer.Len() > 0 && c.paced() {
+			break
+		}
+
 		// Read from send buffer
-		data := c.sendBuffer.Read(int(c.mss))
+		data := c.sendBuffer.Read(size)
 		if len(data) == 0 {
 			break
 		}
 
-		// Create segment
-		seg := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagACK|FlagPSH, c.rcvWnd, data)
-		checksum, err := seg.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
-		if err != nil {
-			return err
+		// Create segment, with PSH if it ends the data written so far. A
+		// super-segment is checksummed only once it is split into wire
+		// segments.
+		flags := FlagACK
+		if c.sendBuffer.Len() == 0 {
+			flags |= FlagPSH
+		}
+		seg := newPooledSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, flags, c.rcvWnd, data)
+		c.markUrgent(seg)
+		if len(data) > int(c.mss) {
+			seg.GSOSize = c.mss
+		} else {
+			checksum, err := c.checksum(seg)
+			if err != nil {
+				return err
+			}
+			seg.Checksum = checksum
 		}
-		seg.Checksum = checksum
 
-		// Send segment
+		// Send segment. A segment the layers below drop is lost like one
+		// dropped on the wire: it stays queued, and the retransmission
+		// timer sends it again. One that cannot be sent at all, for want
+		// of a route or with the link down, stays queued too, for when
+		// it can be, but the caller is told.
+		var sendErr error
 		if c.onSegmentReady != nil {
-			if err := c.onSegmentReady(seg); err != nil {
-				return err
+			if err := c.transmit(seg); err != nil && sendLost(err) {
+				logger.Debug("segment dropped", c.logID(), logging.F("seq", seg.SequenceNumber), logging.F("err", err))
+			} else if err != nil {
+				sendErr = fmt.Errorf("failed to send segment %d: %w", seg.SequenceNumber, err)
 			}
 		}
 
-		// Add to retransmit queue
-		c.retransmitQueue.Add(c.sndNxt, seg, time.Now())
+		// Add to retransmit queue, which recycles the segment once it is
+		// acknowledged
+		c.retransmitQueue.addPooled(c.sndNxt, seg, time.Now())
+		c.armRetransmitTimer(false)
 
 		// Update sequence number
 		c.sndNxt += uint32(len(data))
+		c.pace(len(data))
+		sent = true
+		if sendErr != nil {
+			c.armLossProbe()
+			return sendErr
+		}
+	}
+
+	if sent {
+		c.armLossProbe()
 	}
 
+	if c.finQueued && c.sendBuffer.Len() == 0 {
+		return c.sendFin()
+	}
+	c.armPersistTimer()
 	return nil
 }
 
-// Close closes the connection.
+// canFlush returns whether data in the send buffer may be sent: the state
+// allows sending, or the data was queued before Close and goes out ahead
+// of the FIN.
+func (c *Connection) canFlush() bool {
+	return c.state.GetState().CanSendData() || c.finQueued
+}
+
+// sendSize returns how much data sendData puts in the next segment: one
+// MSS, or with GSO as many whole MSS-sized segments as the send and
+// congestion windows allow, up to GSOMaxSize and the pacing burst. Either
+// way it is no more than the send window has room for. Urgent data, which
+// cannot be segmented, goes in segments of one MSS.
+func (c *Connection) sendSize(availableWindow int) int {
+	mss := min(int(c.mss), availableWindow)
+	if !c.gso || c.sndUrgent {
+		return mss
+	}
+
+	size := min(availableWindow, int(c.cwnd)-int(c.sndNxt-c.sndUna), GSOMaxSize, c.pacingBurst())
+	if size <= mss {
+		return mss
+	}
+	return size - size%int(c.mss)
+}
+
+// Close closes the sending side of the connection (RFC 793 CLOSE call).
+// The FIN follows the data still in the send buffer, once the windows let
+// it out, and the connection goes on receiving until the peer's FIN.
 func (c *Connection) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -590,27 +1017,77 @@ func (c *Connection) Close() error {
 	if state == StateClosed {
 		return fmt.Errorf("connection already closed")
 	}
+	if c.finQueued || c.finSent {
+		return fmt.Errorf("connection already closing")
+	}
 
-	// Send FIN
+	// Transition state. A connection not yet synchronized just closes.
+	if err := c.transition(EventClose); err != nil {
+		return err
+	}
+	c.wakeWriters()
+	if c.state.GetState() == StateClosed {
+		return nil
+	}
+
+	c.finQueued = true
+	return c.sendBuffered(true)
+}
+
+// sendFin sends the FIN Close queued, once the send buffer is empty.
+func (c *Connection) sendFin() error {
 	fin := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagFIN|FlagACK, c.rcvWnd, nil)
-	checksum, err := fin.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
+	checksum, err := c.checksum(fin)
 	if err != nil {
 		return err
 	}
 	fin.Checksum = checksum
+	c.finQueued, c.finSent = false, true
 
+	// A FIN the layers below fail to send is retransmitted like data
 	if c.onSegmentReady != nil {
-		if err := c.onSegmentReady(fin); err != nil {
-			return err
+		if err := c.transmit(fin); err != nil {
+			logger.Debug("failed to send FIN", c.logID(), logging.F("seq", fin.SequenceNumber), logging.F("err", err))
 		}
 	}
 
 	// Add FIN to retransmit queue
 	c.retransmitQueue.Add(c.sndNxt, fin, time.Now())
+	c.armRetransmitTimer(false)
+	c.stopPersistTimer()
 	c.sndNxt++
+	return nil
+}
 
-	// Transition state
-	return c.state.Transition(EventClose)
+// finAcked returns whether the peer acknowledged our FIN.
+func (c *Connection) finAcked() bool {
+	return c.finSent && c.sndUna == c.sndNxt
+}
+
+// reset aborts the connection with a RST, discarding queued data (RFC 793
+// ABORT call). A RST is only sent in states where the peer knows of the
+// connection and is still waiting on us.
+func (c *Connection) reset() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state.GetState() {
+	case StateClosed:
+		return fmt.Errorf("connection already closed")
+	case StateSynReceived, StateEstablished, StateFinWait1, StateFinWait2, StateCloseWait:
+		rst := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, 0, FlagRST, 0, nil)
+		checksum, err := c.checksum(rst)
+		if err != nil {
+			return err
+		}
+		rst.Checksum = checksum
+		if c.onSegmentReady != nil {
+			c.transmit(rst)
+		}
+	}
+
+	c.abort()
+	return nil
 }
 
 // generateISN generates a random initial sequence number.
@@ -620,22 +1097,143 @@ func (c *Connection) generateISN() uint32 {
 	return binary.BigEndian.Uint32(isn[:])
 }
 
-// startTimeWaitTimer starts the TIME_WAIT timer (2 * MSL).
-func (c *Connection) startTimeWaitTimer() {
-	if c.timeWaitTimer != nil {
-		c.timeWaitTimer.Stop()
+// enterTimeWait moves the connection to TIME_WAIT on event, hands its
+// 4-tuple to the TIME_WAIT table, and closes it.
+func (c *Connection) enterTimeWait(event Event) error {
+	if err := c.transition(event); err != nil {
+		return err
 	}
 
-	c.timeWaitTimer = time.AfterFunc(2*time.Minute, func() {
-		c.mu.Lock()
-		defer c.mu.Unlock()
+	c.timeWait.add(c)
+	c.retransmitQueue.Clear()
+	c.armRetransmitTimer(false)
+	c.stopKeepAlive()
+	c.stopPersistTimer()
+	c.stopPacingTimer()
+	c.stopLossTimers()
 
-		c.state.Transition(EventTimeout)
+	if err := c.transition(EventTimeout); err != nil {
+		return err
+	}
+	if c.onClose != nil {
+		c.onClose()
+	}
+	return nil
+}
 
-		if c.onClose != nil {
-			c.onClose()
+// armRetransmitTimer starts the retransmission timer for the outstanding
+// segments, if it is not running or restart is set, and stops it when
+// nothing is outstanding (RFC 6298 Section 5).
+func (c *Connection) armRetransmitTimer(restart bool) {
+	if c.retransmitQueue.Len() == 0 {
+		if c.retransmitTimer != nil {
+			c.retransmitTimer.Stop()
+		}
+		return
+	}
+
+	if c.retransmitTimer == nil {
+		c.retransmitTimer = c.timers.AfterFunc(c.rto, c.retransmitTimeout)
+	} else if restart || !c.retransmitTimer.Pending() {
+		c.retransmitTimer.Reset(c.rto)
+	}
+}
+
+// retransmitTimeout retransmits the first outstanding segment when the
+// retransmission timer expires, collapses the congestion window to one
+// segment (RFC 5681 Section 3.1) and backs off the timer (RFC 6298
+// Section 5.5).
+func (c *Connection) retransmitTimeout() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	head := c.retransmitQueue.GetFirst()
+	if state := c.state.GetState(); head == nil || state == StateClosed || state == StateTimeWait {
+		return
+	}
+
+	c.frtoTimeout(c.retransmitQueue.Entries()[0])
+	seg := c.retransmit(head)
+	c.retransmitQueue.UpdateSentTime(head.SequenceNumber, time.Now())
+	c.stats.retransmissions++
+	stackCounters.retransmissions.Add(1)
+
+	flight := c.sndNxt - c.sndUna
+	c.ssthresh = flight / 2
+	if c.ssthresh < uint32(c.mss)*2 {
+		c.ssthresh = uint32(c.mss) * 2
+	}
+	c.cwnd = uint32(c.mss)
+	c.dupAckCnt = 0
+	c.recordCwnd()
+	c.rackRTO()
+
+	c.rto *= 2
+	if c.rto > maxRTO {
+		c.rto = maxRTO
+	}
+	c.retransmitTimer.Reset(c.rto)
+	logger.Debug("retransmission timeout", c.logID(), logging.F("seq", seg.SequenceNumber), logging.F("rto", c.rto))
+}
+
+// retransmit sends an outstanding segment again and returns what was sent.
+// Acknowledged data is left out, and a segment larger than the MSS, which
+// shrinks when the path MTU does, is sent in segments of the current MSS.
+func (c *Connection) retransmit(head *Segment) *Segment {
+	seg := c.trimAcked(head)
+	if len(seg.Data) > int(c.mss) && (seg.GSOSize == 0 || seg.GSOSize > c.mss) && !seg.HasFlag(FlagSYN) {
+		resegmented := *seg
+		resegmented.GSOSize = c.mss
+		resegmented.Checksum = 0
+		seg = &resegmented
+	}
+	if c.onSegmentReady == nil {
+		return seg
+	}
+	if seg.GSOSize == 0 || c.gso {
+		c.transmit(seg)
+		return seg
+	}
+
+	// Without GSO the segments are split here
+	wire, err := seg.splitGSO(c.checksum)
+	if err != nil {
+		return seg
+	}
+	for _, w := range wire {
+		c.transmit(w)
+	}
+	return seg
+}
+
+// trimAcked returns a segment without its acknowledged data, for
+// retransmitting a super-segment that was partly acknowledged. Other
+// segments are returned unchanged.
+func (c *Connection) trimAcked(seg *Segment) *Segment {
+	acked := int(c.sndUna - seg.SequenceNumber)
+	if !seqBefore(seg.SequenceNumber, c.sndUna) || acked >= len(seg.Data) || seg.HasFlag(FlagSYN) {
+		return seg
+	}
+
+	trimmed := *seg
+	trimmed.SequenceNumber = c.sndUna
+	trimmed.Data = seg.Data[acked:]
+	trimmed.Checksum = 0
+	if trimmed.HasFlag(FlagURG) {
+		if int(seg.UrgentPointer) > acked {
+			trimmed.UrgentPointer -= uint16(acked)
+		} else {
+			trimmed.Flags &^= FlagURG
+			trimmed.UrgentPointer = 0
 		}
-	})
+	}
+	if len(trimmed.Data) <= int(trimmed.GSOSize) {
+		trimmed.GSOSize = 0
+	}
+	if trimmed.GSOSize == 0 {
+		trimmed.Checksum, _ = c.checksum(&trimmed)
+	}
+	return &trimmed
 }
 
 // updateCongestionWindow updates the congestion window.
@@ -647,15 +1245,18 @@ func (c *Connection) updateCongestionWindow(bytesAcked uint32) {
 		// Congestion avoidance: linear growth
 		c.cwnd += uint32(c.mss) * bytesAcked / c.cwnd
 	}
+	c.recordCwnd()
 }
 
 // fastRetransmit performs fast retransmit.
 func (c *Connection) fastRetransmit() {
 	// Retransmit the first unacknowledged segment
-	if seg := c.retransmitQueue.GetFirst(); seg != nil {
-		if c.onSegmentReady != nil {
-			c.onSegmentReady(seg)
-		}
+	if head := c.retransmitQueue.GetFirst(); head != nil {
+		seg := c.retransmit(head)
+		c.retransmitQueue.UpdateSentTime(head.SequenceNumber, time.Now())
+		c.stats.retransmissions++
+		stackCounters.retransmissions.Add(1)
+		logger.Debug("fast retransmit", c.logID(), logging.F("seq", seg.SequenceNumber), logging.F("cwnd", c.cwnd))
 	}
 
 	// Fast recovery: set ssthresh and cwnd
@@ -664,4 +1265,5 @@ func (c *Connection) fastRetransmit() {
 		c.ssthresh = uint32(c.mss) * 2
 	}
 	c.cwnd = c.ssthresh
+	c.recordCwnd()
 }
@@ -0,0 +1,100 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+var (
+	testClientIP = common.IPv4Address{10, 0, 0, 1}
+	testServerIP = common.IPv4Address{10, 0, 0, 2}
+)
+
+// testPeer is a connection whose segments are queued for the test to
+// deliver.
+type testPeer struct {
+	conn *Connection
+	out  []*Segment
+	data []byte
+}
+
+func newTestPeer(client bool, tfo *TFOState) *testPeer {
+	p := &testPeer{}
+	if client {
+		p.conn = NewConnection(testClientIP, 50000, testServerIP, 80)
+	} else {
+		p.conn = NewConnection(testServerIP, 80, testClientIP, 50000)
+		p.conn.state.SetState(StateListen)
+	}
+	p.conn.onSegmentReady = func(seg *Segment) error {
+		p.out = append(p.out, seg)
+		return nil
+	}
+	p.conn.onDataReady = func(data []byte) { p.data = append(p.data, data...) }
+	p.conn.timeWait, _ = NewTimeWaitTable(TimeWaitConfig{}) // Keep TIME_WAIT out of the shared table
+	if tfo != nil {
+		p.conn.tfo = NewTFOConnection(tfo)
+	}
+	return p
+}
+
+// appendClones appends clones of segs to sent, to keep segments past the
+// ACK that recycles them.
+func appendClones(sent, segs []*Segment) []*Segment {
+	for _, seg := range segs {
+		sent = append(sent, seg.Clone())
+	}
+	return sent
+}
+
+// deliver passes the segments a has sent to b, through the wire format.
+func deliver(t *testing.T, a, b *testPeer) {
+	t.Helper()
+	segs := a.out
+	a.out = nil
+	for _, seg := range segs {
+		raw, err := seg.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		parsed, err := Parse(raw)
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if err := b.conn.HandleSegment(parsed); err != nil {
+			t.Fatalf("HandleSegment(%v) error = %v", parsed, err)
+		}
+	}
+}
+
+// exchange delivers segments both ways until neither side has more.
+func exchange(t *testing.T, client, server *testPeer) {
+	t.Helper()
+	for len(client.out)+len(server.out) > 0 {
+		deliver(t, client, server)
+		deliver(t, server, client)
+	}
+}
+
+func TestHandshakeMSS(t *testing.T) {
+	// The server on a jumbo link announces its MSS, and sends segments no
+	// larger than the client's
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	server.conn.mss = 8960
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	deliver(t, client, server)
+	if len(server.out) != 1 {
+		t.Fatalf("server sent %d segments after the SYN, want a SYN-ACK", len(server.out))
+	}
+	if mss, err := server.out[0].GetMSS(); err != nil || mss != 8960 {
+		t.Errorf("SYN-ACK announces MSS %d (error %v), want 8960", mss, err)
+	}
+	deliver(t, server, client)
+	if client.conn.mss != DefaultMSS || server.conn.mss != DefaultMSS {
+		t.Errorf("MSS = %d, %d, want %d at both ends", client.conn.mss, server.conn.mss, DefaultMSS)
+	}
+}
@@ -0,0 +1,88 @@
+package tcp
+
+import (
+	"sync"
+	"time"
+)
+
+// deadline is a read or write deadline of a Socket, as net.Pipe keeps
+// them. wait returns a channel closed once the deadline passes; moving the
+// deadline keeps the channel unless it was already closed, so an
+// operation blocked on it sees the new deadline.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline to t; the zero time clears it.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // The timer fired; wait for it to close cancel
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel closed when the deadline passes.
+func (d *deadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetDeadline sets the read and write deadlines, like net.Conn's. Recv,
+// Send and Accept, and their Context variants, fail with an error wrapping
+// os.ErrDeadlineExceeded once a deadline passes, including calls already
+// blocked when it is set. A zero value clears the deadlines.
+func (s *Socket) SetDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	s.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Recv and Accept.
+func (s *Socket) SetReadDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Send.
+func (s *Socket) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.set(t)
+	return nil
+}
@@ -0,0 +1,146 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestRecvDeadline(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server, s := readingPeer(DefaultReceiveBufferSize)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	buf := make([]byte, 100)
+	if err := s.SetReadDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline() error = %v", err)
+	}
+	if _, err := s.Recv(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Recv() past the deadline error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+
+	// Setting a deadline wakes a Recv already waiting
+	s.SetReadDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Recv(buf)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Recv() error = %v, want %v", err, os.ErrDeadlineExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Recv() still blocked after the deadline")
+	}
+
+	// Clearing the deadline lets data be read again
+	s.SetReadDeadline(time.Time{})
+	if err := client.conn.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+	n, err := s.Recv(buf)
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	if got := string(buf[:n]); got != "hello" {
+		t.Errorf("Recv() = %q, want %q", got, "hello")
+	}
+}
+
+func TestRecvContext(t *testing.T) {
+	_, s := readingPeer(DefaultReceiveBufferSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := s.RecvContext(ctx, make([]byte, 100)); !errors.Is(err, context.Canceled) {
+		t.Errorf("RecvContext() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestConnectContext(t *testing.T) {
+	// The SYN goes nowhere, so the connection never opens
+	d := NewDemultiplexer()
+	d.SetSendFunc(func(*Segment, common.IPv4Address, common.IPv4Address) error { return nil })
+
+	sock := NewSocket(testClientIP, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := d.ConnectContext(ctx, sock, testServerIP, 80)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ConnectContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := sock.conn.GetState(); got != StateClosed {
+		t.Errorf("state = %v after the context expired, want %v", got, StateClosed)
+	}
+}
+
+func TestAcceptContext(t *testing.T) {
+	d := NewDemultiplexer()
+	listener := NewSocket(common.IPv4Address{}, 8082)
+	if err := d.Listen(listener, 1); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := listener.AcceptContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AcceptContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	listener.SetDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := listener.Accept(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Accept() error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestSendDeadline(t *testing.T) {
+	client := NewDemultiplexer()
+	server := NewDemultiplexer()
+	defer linkDemuxes(t, client, server)()
+
+	listener := NewSocket(common.IPv4Address{}, 8083)
+	listener.SetReuseAddr(true)
+	if err := listener.SetSockOpt(common.OptReceiveBuffer, MinBufferSize); err != nil {
+		t.Fatalf("SetSockOpt(OptReceiveBuffer) error = %v", err)
+	}
+	if err := server.Listen(listener, 1); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	sock := NewSocket(testClientIP, 0)
+	if err := sock.SetSockOpt(common.OptSendBuffer, MinBufferSize); err != nil {
+		t.Fatalf("SetSockOpt(OptSendBuffer) error = %v", err)
+	}
+	if err := client.Connect(sock, testServerIP, 8083); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer sock.Close()
+
+	// Nobody reads, so both buffers fill and Send waits for the deadline
+	sock.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	data := make([]byte, 4*MinBufferSize)
+	n, err := sock.Send(data)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Send() error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if n == 0 || n >= len(data) {
+		t.Errorf("Send() = %d bytes, want some but not all of %d", n, len(data))
+	}
+}
@@ -0,0 +1,394 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ports"
+)
+
+const (
+	// EphemeralPortStart is the start of the default ephemeral port range.
+	EphemeralPortStart = ports.EphemeralStart
+
+	// EphemeralPortEnd is the end of the default ephemeral port range.
+	EphemeralPortEnd = ports.EphemeralEnd
+)
+
+// DemuxStats holds the counters of a Demultiplexer.
+type DemuxStats struct {
+	Connections uint64 // Segments delivered to a connection
+	Listeners   uint64 // Segments delivered to a listening socket
+	TimeWait    uint64 // Segments answered for a 4-tuple in TIME_WAIT
+	NoSocket    uint64 // Segments for which no socket was found
+	ResetsSent  uint64 // RSTs sent in reply to those
+}
+
+// Demultiplexer routes incoming segments to TCP connections and listening
+// sockets.
+//
+// Listening sockets and connecting sockets are added with Listen and
+// Connect. A connection is looked up by its 4-tuple from the time it sends
+// its SYN, or, for connections a listener accepts, from the time it is
+// established, until it closes; segments for connections still in the
+// handshake go to their listener. A listener bound to the zero address
+// receives the connections for its port on every address without one of
+// its own, over IPv4 and IPv6. Segments for no connection or listener are
+// answered with an RST.
+//
+// Local ports are bound with a ports.Manager, by IPv4 address; a socket
+// bound to an IPv6 address binds its port on every address. A socket binding a port in
+// use fails unless it and the sockets using the port set SetReuseAddr, and
+// so does a listener on a port with connections in TIME_WAIT.
+type Demultiplexer struct {
+	ports *ports.Manager
+
+	mu        sync.RWMutex
+	listeners map[endpoint]*Socket
+	conns     map[fourTuple]*Connection
+
+	// For sending RSTs
+	sendFunc     func(*Segment, common.IPv4Address, common.IPv4Address) error
+	sendFuncIPv6 func(*Segment, common.IPv6Address, common.IPv6Address) error
+
+	sourceSelector SourceSelector
+	pathMTUSource  PathMTUSource
+
+	stats struct {
+		connections atomic.Uint64
+		listeners   atomic.Uint64
+		timeWait    atomic.Uint64
+		noSocket    atomic.Uint64
+		resetsSent  atomic.Uint64
+	}
+}
+
+// endpoint is a local address, in the form it is looked up by, and port.
+type endpoint struct {
+	addr common.IPv6Address
+	port uint16
+}
+
+// NewDemultiplexer creates a new TCP demultiplexer with a port manager of
+// its own.
+func NewDemultiplexer() *Demultiplexer {
+	m, _ := ports.New(ports.Config{}) // The defaults are valid
+	return NewDemultiplexerWithPorts(m)
+}
+
+// NewDemultiplexerWithPorts creates a new TCP demultiplexer binding local
+// ports with m.
+func NewDemultiplexerWithPorts(m *ports.Manager) *Demultiplexer {
+	return &Demultiplexer{
+		ports:     m,
+		listeners: make(map[endpoint]*Socket),
+		conns:     make(map[fourTuple]*Connection),
+	}
+}
+
+// Ports returns the demultiplexer's port manager.
+func (d *Demultiplexer) Ports() *ports.Manager {
+	return d.ports
+}
+
+// SetSendFunc sets the function to call when sending segments. It sends the
+// demultiplexer's RSTs, and those of sockets added without a send function
+// of their own.
+func (d *Demultiplexer) SetSendFunc(f func(*Segment, common.IPv4Address, common.IPv4Address) error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sendFunc = f
+}
+
+// SetSendFuncIPv6 sets the function to call when sending segments over
+// IPv6, as SetSendFunc does over IPv4.
+func (d *Demultiplexer) SetSendFuncIPv6(f func(*Segment, common.IPv6Address, common.IPv6Address) error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sendFuncIPv6 = f
+}
+
+// SourceSelector picks the local address of connections to an IPv4
+// destination, among the routes out of device if it is not empty, as
+// ip.RoutingTable.SelectSource does.
+type SourceSelector interface {
+	SelectSource(dst common.IPv4Address, device string) (common.IPv4Address, error)
+}
+
+// SetSourceSelector sets what picks the local address of IPv4 sockets
+// connecting from an unspecified address, so that a multi-homed host
+// connects from the address of the interface it reaches the peer on or,
+// with OptBindToDevice, of the interface the socket is bound to. Without
+// one, such sockets connect from the unspecified address and the sender
+// fills in the source.
+func (d *Demultiplexer) SetSourceSelector(sel SourceSelector) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sourceSelector = sel
+}
+
+// bindAddr returns the address a socket bound to addr binds its port on.
+func bindAddr(addr common.IPv6Address) common.IPv4Address {
+	addr4, _ := ipv4Of(addr) // The zero address for IPv6
+	return addr4
+}
+
+// Listen puts the socket in listening mode and adds it. The socket must be
+// bound to a port.
+func (d *Demultiplexer) Listen(s *Socket, backlog int) error {
+	s.mu.Lock()
+	key := endpoint{s.localAddr, s.localPort}
+	reuseAddr := s.reuseAddr
+	s.mu.Unlock()
+	if key.port == 0 {
+		return fmt.Errorf("socket not bound to a port")
+	}
+	if !reuseAddr && timeWaitTable.usesPort(key.addr, key.port) {
+		return fmt.Errorf("%s: %w", hostPort(key.addr, key.port), ports.ErrInUse)
+	}
+
+	binding, err := d.ports.Bind(bindAddr(key.addr), key.port, ports.Options{ReuseAddr: reuseAddr})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if _, exists := d.listeners[key]; exists {
+		d.mu.Unlock()
+		binding.Release()
+		return fmt.Errorf("%s: %w", hostPort(key.addr, key.port), ports.ErrInUse)
+	}
+	d.listeners[key] = s
+	sendFunc, sendFuncIPv6 := d.sendFunc, d.sendFuncIPv6
+	d.mu.Unlock()
+
+	s.mu.Lock()
+	s.demux = d
+	s.binding = binding
+	if s.sendFunc == nil {
+		s.sendFunc = sendFunc
+	}
+	if s.sendFuncIPv6 == nil {
+		s.sendFuncIPv6 = sendFuncIPv6
+	}
+	s.mu.Unlock()
+
+	if err := s.Listen(backlog); err != nil {
+		s.mu.Lock()
+		d.removeListener(s, key)
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Connect connects the socket to a remote address and port, like
+// Socket.Connect. A socket bound to port 0 is given an ephemeral port not
+// in TIME_WAIT with the remote end.
+func (d *Demultiplexer) Connect(s *Socket, remoteAddr common.IPv4Address, remotePort uint16) error {
+	return d.ConnectWithData(s, remoteAddr, remotePort, nil)
+}
+
+// ConnectWithData connects the socket to a remote address and port and sends
+// data, like Socket.ConnectWithData. A socket bound to port 0 is given an
+// ephemeral port.
+func (d *Demultiplexer) ConnectWithData(s *Socket, remoteAddr common.IPv4Address, remotePort uint16, data []byte) error {
+	if err := d.bindConnect(s, ipKey(remoteAddr), remotePort); err != nil {
+		return err
+	}
+	return s.ConnectWithData(remoteAddr, remotePort, data)
+}
+
+// ConnectContext connects the socket like Connect, waiting until ctx is
+// done rather than DefaultConnectTimeout.
+func (d *Demultiplexer) ConnectContext(ctx context.Context, s *Socket, remoteAddr common.IPv4Address, remotePort uint16) error {
+	return d.ConnectWithDataContext(ctx, s, remoteAddr, remotePort, nil)
+}
+
+// ConnectWithDataContext connects the socket and sends data like
+// ConnectWithData, waiting until ctx is done rather than
+// DefaultConnectTimeout.
+func (d *Demultiplexer) ConnectWithDataContext(ctx context.Context, s *Socket, remoteAddr common.IPv4Address, remotePort uint16, data []byte) error {
+	if err := d.bindConnect(s, ipKey(remoteAddr), remotePort); err != nil {
+		return err
+	}
+	return s.ConnectWithDataContext(ctx, remoteAddr, remotePort, data)
+}
+
+// ConnectIPv6 connects the socket to a remote IPv6 address and port, like
+// Socket.ConnectIPv6.
+func (d *Demultiplexer) ConnectIPv6(s *Socket, remoteAddr common.IPv6Address, remotePort uint16) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultConnectTimeout)
+	defer cancel()
+	return d.ConnectIPv6Context(ctx, s, remoteAddr, remotePort)
+}
+
+// ConnectIPv6Context connects the socket like ConnectIPv6, waiting until
+// ctx is done rather than DefaultConnectTimeout.
+func (d *Demultiplexer) ConnectIPv6Context(ctx context.Context, s *Socket, remoteAddr common.IPv6Address, remotePort uint16) error {
+	if err := d.bindConnect(s, remoteAddr, remotePort); err != nil {
+		return err
+	}
+	return s.ConnectIPv6Context(ctx, remoteAddr, remotePort)
+}
+
+// bindConnect binds a socket about to connect to the demultiplexer.
+func (d *Demultiplexer) bindConnect(s *Socket, remoteAddr common.IPv6Address, remotePort uint16) error {
+	s.mu.Lock()
+	if s.conn != nil || s.isListening {
+		s.mu.Unlock()
+		return fmt.Errorf("socket already connected")
+	}
+
+	localAddr := s.localAddr
+	d.mu.RLock()
+	sel := d.sourceSelector
+	d.mu.RUnlock()
+	if dst, ok := remoteAddr.To4(); ok && sel != nil && localAddr.IsUnspecified() {
+		src, err := sel.SelectSource(dst, s.opts.device)
+		if err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+		localAddr = ipKey(src)
+	}
+	binding, err := d.ports.Bind(bindAddr(localAddr), s.localPort, ports.Options{
+		ReuseAddr: s.reuseAddr,
+		Avoid: func(port uint16) bool {
+			return timeWaitTable.contains(fourTuple{localAddr, port, remoteAddr, remotePort})
+		},
+	})
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	d.mu.RLock()
+	sendFunc, sendFuncIPv6 := d.sendFunc, d.sendFuncIPv6
+	d.mu.RUnlock()
+
+	s.localAddr = localAddr
+	s.localPort = binding.Port
+	s.demux = d
+	s.binding = binding
+	if s.sendFunc == nil {
+		s.sendFunc = sendFunc
+	}
+	if s.sendFuncIPv6 == nil {
+		s.sendFuncIPv6 = sendFuncIPv6
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Deliver delivers an incoming segment, received from srcIP for dstIP, to
+// its connection or listening socket, or answers it with an RST.
+func (d *Demultiplexer) Deliver(seg *Segment, srcIP, dstIP common.IPv4Address) error {
+	return d.deliver(seg, ipKey(srcIP), ipKey(dstIP))
+}
+
+// DeliverIPv6 delivers an incoming segment received over IPv6, like
+// Deliver.
+func (d *Demultiplexer) DeliverIPv6(seg *Segment, srcIP, dstIP common.IPv6Address) error {
+	return d.deliver(seg, srcIP, dstIP)
+}
+
+// deliver is Deliver with the addresses in the form they are looked up by.
+func (d *Demultiplexer) deliver(seg *Segment, srcIP, dstIP common.IPv6Address) error {
+	if handled, err := timeWaitTable.handleSegment(seg, srcIP, dstIP); handled {
+		d.stats.timeWait.Add(1)
+		return err
+	}
+
+	d.mu.RLock()
+	conn := d.conns[fourTuple{dstIP, seg.DestinationPort, srcIP, seg.SourcePort}]
+	listener := d.listeners[endpoint{dstIP, seg.DestinationPort}]
+	if listener == nil {
+		listener = d.listeners[endpoint{common.IPv6Address{}, seg.DestinationPort}]
+	}
+	sendFunc, sendFuncIPv6 := d.sendFunc, d.sendFuncIPv6
+	d.mu.RUnlock()
+
+	switch {
+	case conn != nil:
+		d.stats.connections.Add(1)
+		return conn.HandleSegment(seg)
+	case listener != nil:
+		d.stats.listeners.Add(1)
+		return listener.handleSegment(seg, srcIP, dstIP)
+	}
+
+	// Never answer an RST with an RST (RFC 793 Section 3.4)
+	d.stats.noSocket.Add(1)
+	if seg.HasFlag(FlagRST) {
+		return nil
+	}
+	if (isIPv4(srcIP) && sendFunc == nil) || (!isIPv4(srcIP) && sendFuncIPv6 == nil) {
+		return nil
+	}
+	rst, err := newReset(seg, dstIP, srcIP)
+	if err != nil {
+		return err
+	}
+	d.stats.resetsSent.Add(1)
+	stackCounters.resetsSent.Add(1)
+	return sendFor(rst, dstIP, srcIP, sendFunc, sendFuncIPv6)
+}
+
+// Stats returns the demultiplexer's counters.
+func (d *Demultiplexer) Stats() DemuxStats {
+	return DemuxStats{
+		Connections: d.stats.connections.Load(),
+		Listeners:   d.stats.listeners.Load(),
+		TimeWait:    d.stats.timeWait.Load(),
+		NoSocket:    d.stats.noSocket.Load(),
+		ResetsSent:  d.stats.resetsSent.Load(),
+	}
+}
+
+// addConn adds a connection that has not yet sent its SYN or has just been
+// established, and hands it the binding of its local port, if it has one of
+// its own. Called with c.mu held; the connection is removed by track when it
+// closes.
+func (d *Demultiplexer) addConn(c *Connection, binding *ports.Binding) error {
+	key := c.tuple()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.conns[key]; exists {
+		return fmt.Errorf("connection %s -> %s already exists", hostPort(key.localAddr, key.localPort), hostPort(key.remoteAddr, key.remotePort))
+	}
+	d.conns[key] = c
+	c.demux = d
+	c.binding = binding
+	return nil
+}
+
+// removeConn removes a connection. Called with c.mu held.
+func (d *Demultiplexer) removeConn(c *Connection) {
+	key := c.tuple()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conns[key] != c {
+		return
+	}
+	delete(d.conns, key)
+	c.binding.Release()
+	c.binding = nil
+}
+
+// removeListener removes a listening socket. Called with s.mu held.
+func (d *Demultiplexer) removeListener(s *Socket, key endpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.listeners[key] != s {
+		return
+	}
+	delete(d.listeners, key)
+	s.binding.Release()
+	s.binding = nil
+}
@@ -0,0 +1,377 @@
+package tcp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ports"
+)
+
+// linkDemuxes carries the segments each demultiplexer sends, serialized,
+// to the other, until the returned function is called.
+func linkDemuxes(t *testing.T, a, b *Demultiplexer) func() {
+	t.Helper()
+	done := make(chan struct{})
+	type packet struct {
+		raw  []byte
+		ipv6 bool
+	}
+	carry := func(from, to *Demultiplexer) {
+		ch := make(chan packet, 64)
+		send := func(seg *Segment, ipv6 bool) error {
+			raw, err := seg.Serialize()
+			if err != nil {
+				return err
+			}
+			ch <- packet{raw, ipv6}
+			return nil
+		}
+		from.SetSendFunc(func(seg *Segment, src, dst common.IPv4Address) error {
+			return send(seg, false)
+		})
+		from.SetSendFuncIPv6(func(seg *Segment, src, dst common.IPv6Address) error {
+			return send(seg, true)
+		})
+		go func() {
+			for {
+				select {
+				case p := <-ch:
+					seg, err := Parse(p.raw)
+					if err != nil {
+						t.Errorf("Parse() error = %v", err)
+						continue
+					}
+					// The demultiplexers are on the test hosts' addresses
+					if p.ipv6 {
+						src, dst := testClientIP6, testServerIP6
+						if from == b {
+							src, dst = dst, src
+						}
+						to.DeliverIPv6(seg, src, dst)
+						continue
+					}
+					src, dst := testClientIP, testServerIP
+					if from == b {
+						src, dst = dst, src
+					}
+					to.Deliver(seg, src, dst)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	carry(a, b)
+	carry(b, a)
+	return func() { close(done) }
+}
+
+func TestDemultiplexer(t *testing.T) {
+	client := NewDemultiplexer()
+	server := NewDemultiplexer()
+	defer linkDemuxes(t, client, server)()
+
+	listener := NewSocket(common.IPv4Address{}, 80) // Any address
+	listener.SetReuseAddr(true)                     // Despite TIME_WAIT from earlier runs
+	if err := server.Listen(listener, 4); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	// Two connections from ephemeral ports to the one listener
+	var socks [2]*Socket
+	for i := range socks {
+		socks[i] = NewSocket(testClientIP, 0)
+		if err := client.Connect(socks[i], testServerIP, 80); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		if port := socks[i].GetLocalPort(); port < EphemeralPortStart {
+			t.Errorf("local port = %d, want an ephemeral port", port)
+		}
+	}
+	if socks[0].GetLocalPort() == socks[1].GetLocalPort() {
+		t.Fatalf("both connections got port %d", socks[0].GetLocalPort())
+	}
+
+	for i, sock := range socks {
+		accepted, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("Accept() error = %v", err)
+		}
+		if accepted.GetRemotePort() != socks[i].GetLocalPort() {
+			t.Errorf("accepted port %d, want %d", accepted.GetRemotePort(), socks[i].GetLocalPort())
+		}
+
+		// Data flows both ways once the listener has handed the connection on
+		msg := []byte{'a' + byte(i)}
+		if _, err := sock.Send(msg); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		buf := make([]byte, 16)
+		n, err := accepted.RecvTimeout(buf, time.Second)
+		if err != nil || string(buf[:n]) != string(msg) {
+			t.Errorf("server Recv() = %q, %v; want %q", buf[:n], err, msg)
+		}
+		if _, err := accepted.Send([]byte("ok")); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		n, err = sock.RecvTimeout(buf, time.Second)
+		if err != nil || string(buf[:n]) != "ok" {
+			t.Errorf("client Recv() = %q, %v; want %q", buf[:n], err, "ok")
+		}
+
+		// Closed connections leave the demultiplexers
+		sock.Close()
+		accepted.Close()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		client.mu.RLock()
+		clientConns := len(client.conns)
+		client.mu.RUnlock()
+		server.mu.RLock()
+		serverConns := len(server.conns)
+		server.mu.RUnlock()
+		if clientConns == 0 && serverConns == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d client and %d server connections left after close", clientConns, serverConns)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if stats := server.Stats(); stats.Connections == 0 || stats.Listeners == 0 || stats.NoSocket != 0 {
+		t.Errorf("server Stats() = %+v", stats)
+	}
+}
+
+func TestDemultiplexerReset(t *testing.T) {
+	d := NewDemultiplexer()
+	var sent []*Segment
+	d.SetSendFunc(func(seg *Segment, src, dst common.IPv4Address) error {
+		sent = append(sent, seg)
+		return nil
+	})
+
+	listener := NewSocket(testServerIP, 7)
+	if err := d.Listen(listener, 1); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	if err := d.Listen(NewSocket(testServerIP, 7), 1); err == nil {
+		t.Error("second Listen() on the port error = nil, want an error")
+	}
+
+	tests := []struct {
+		name     string
+		seg      *Segment
+		wantSent bool
+		wantSeq  uint32
+		wantAck  uint32
+	}{
+		{"SYN to closed port", NewSegment(50000, 81, 1000, 0, FlagSYN, 65535, nil), true, 0, 1001},
+		{"ACK for no connection", NewSegment(50000, 81, 1000, 7000, FlagACK, 65535, []byte("data")), true, 7000, 0},
+		{"RST", NewSegment(50000, 81, 1000, 0, FlagRST, 0, nil), false, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent = nil
+			if err := d.Deliver(tt.seg, testClientIP, testServerIP); err != nil {
+				t.Fatalf("Deliver() error = %v", err)
+			}
+			if !tt.wantSent {
+				if len(sent) != 0 {
+					t.Errorf("sent %v, want nothing", sent)
+				}
+				return
+			}
+			if len(sent) != 1 || !sent[0].HasFlag(FlagRST) {
+				t.Fatalf("sent %v, want an RST", sent)
+			}
+			rst := sent[0]
+			if rst.SequenceNumber != tt.wantSeq || rst.AckNumber != tt.wantAck || rst.DestinationPort != 50000 {
+				t.Errorf("RST = %v, want seq %d ack %d to port 50000", rst, tt.wantSeq, tt.wantAck)
+			}
+			if !rst.VerifyChecksum(testServerIP, testClientIP) {
+				t.Error("RST has a bad checksum")
+			}
+		})
+	}
+
+	if stats := d.Stats(); stats.NoSocket != 3 || stats.ResetsSent != 2 {
+		t.Errorf("Stats() = %+v, want 3 without a socket and 2 resets", stats)
+	}
+
+	// A closed listener's port is free again
+	listener.Close()
+	if err := d.Listen(NewSocket(testServerIP, 7), 1); err != nil {
+		t.Errorf("Listen() after Close() error = %v", err)
+	}
+}
+
+// addTimeWait puts a 4-tuple in the shared TIME_WAIT table for the test.
+func addTimeWait(t *testing.T, localAddr common.IPv4Address, localPort uint16, remoteAddr common.IPv4Address, remotePort uint16) {
+	t.Helper()
+	timeWaitTable.add(NewConnection(localAddr, localPort, remoteAddr, remotePort))
+	t.Cleanup(func() {
+		timeWaitTable.mu.Lock()
+		defer timeWaitTable.mu.Unlock()
+		if e, ok := timeWaitTable.entries[fourTuple{ipKey(localAddr), localPort, ipKey(remoteAddr), remotePort}]; ok {
+			timeWaitTable.remove(e)
+		}
+	})
+}
+
+func TestDemultiplexerPorts(t *testing.T) {
+	client := NewDemultiplexer()
+	server := NewDemultiplexer()
+	defer linkDemuxes(t, client, server)()
+
+	listener := NewSocket(testServerIP, 9)
+	if err := server.Listen(listener, 4); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	// A wildcard listener overlaps the one on the server's address, unless
+	// both reuse the address
+	wildcard := NewSocket(common.IPv4Address{}, 9)
+	if err := server.Listen(wildcard, 4); !errors.Is(err, ports.ErrInUse) {
+		t.Errorf("Listen() on the wildcard address error = %v, want %v", err, ports.ErrInUse)
+	}
+
+	// The ephemeral port passes over a 4-tuple still in TIME_WAIT
+	start, _ := client.Ports().EphemeralRange()
+	addTimeWait(t, testClientIP, start, testServerIP, 9)
+	sock := NewSocket(testClientIP, 0)
+	if err := client.Connect(sock, testServerIP, 9); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer sock.Close()
+	if got := sock.GetLocalPort(); got != start+1 {
+		t.Errorf("local port = %d, want %d", got, start+1)
+	}
+	if !client.Ports().InUse(testClientIP, start+1) {
+		t.Error("connection's port is not bound")
+	}
+
+	// A listener needs SetReuseAddr to take a port in TIME_WAIT
+	addTimeWait(t, testServerIP, 8080, testClientIP, 50000)
+	restarted := NewSocket(testServerIP, 8080)
+	if err := server.Listen(restarted, 4); !errors.Is(err, ports.ErrInUse) {
+		t.Errorf("Listen() on a port in TIME_WAIT error = %v, want %v", err, ports.ErrInUse)
+	}
+	restarted.SetReuseAddr(true)
+	if err := server.Listen(restarted, 4); err != nil {
+		t.Errorf("Listen() with SetReuseAddr error = %v", err)
+	}
+	restarted.Close()
+	if server.Ports().InUse(testServerIP, 8080) {
+		t.Error("closed listener's port is still bound")
+	}
+}
+
+func TestDemultiplexerSourceSelection(t *testing.T) {
+	client := NewDemultiplexer()
+	server := NewDemultiplexer()
+	defer linkDemuxes(t, client, server)()
+
+	// The client is on the server's network through eth1, and has its
+	// default route out of eth0
+	rt := ip.NewRoutingTable()
+	rt.AddAddress(ip.InterfaceAddress{Interface: "eth0", Address: common.IPv4Address{192, 168, 1, 10}, PrefixLen: 24})
+	rt.AddAddress(ip.InterfaceAddress{Interface: "eth1", Address: testClientIP, PrefixLen: 24})
+	rt.SetDefaultGateway(common.IPv4Address{192, 168, 1, 1}, "eth0")
+	rt.AddRoute(&ip.Route{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: common.IPv4Address{255, 255, 255, 0}, Interface: "eth1"})
+	client.SetSourceSelector(rt)
+
+	listener := NewSocket(testServerIP, 7007)
+	if err := server.Listen(listener, 4); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	for _, device := range []string{"", "eth1"} {
+		sock := NewSocket(common.IPv4Address{}, 0)
+		if err := sock.SetSockOpt(common.OptBindToDevice, device); err != nil {
+			t.Fatalf("SetSockOpt() error = %v", err)
+		}
+		if err := client.Connect(sock, testServerIP, 7007); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		if got := sock.GetLocalAddr(); got != testClientIP {
+			t.Errorf("device %q: local address = %s, want %s", device, got, testClientIP)
+		}
+		if !client.Ports().InUse(testClientIP, sock.GetLocalPort()) {
+			t.Errorf("device %q: port not bound on the selected address", device)
+		}
+		sock.Close()
+	}
+
+	// Out of an interface with no route to the server, there is nothing to
+	// connect from
+	sock := NewSocket(common.IPv4Address{}, 0)
+	sock.SetSockOpt(common.OptBindToDevice, "eth2")
+	if err := client.Connect(sock, testServerIP, 7007); !errors.Is(err, ip.ErrNoRoute) {
+		t.Errorf("Connect() bound to eth2 error = %v, want %v", err, ip.ErrNoRoute)
+	}
+}
+
+func TestDemultiplexerPathMTU(t *testing.T) {
+	jumbo := ip.NewRoutingTable()
+	jumbo.AddRoute(&ip.Route{Destination: common.IPv4Address{10, 0, 0, 0}, Netmask: common.IPv4Address{255, 255, 255, 0}, Interface: "eth1"})
+	jumbo.SetInterfaceMTU("eth1", 9000)
+
+	// Each end sends segments no larger than either end's MSS
+	tests := []struct {
+		name           string
+		client, server PathMTUSource
+		port           uint16 // Each its own, clear of the others' TIME_WAIT
+		want           uint16
+	}{
+		{"jumbo frames end to end", jumbo, jumbo, 7008, 8960},
+		{"jumbo client", jumbo, nil, 7009, DefaultMSS},
+		{"jumbo server", nil, jumbo, 7010, DefaultMSS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewDemultiplexer()
+			server := NewDemultiplexer()
+			defer linkDemuxes(t, client, server)()
+			if tt.client != nil {
+				client.SetPathMTUSource(tt.client)
+			}
+			if tt.server != nil {
+				server.SetPathMTUSource(tt.server)
+			}
+
+			listener := NewSocket(testServerIP, tt.port)
+			if err := server.Listen(listener, 4); err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+			defer listener.Close()
+			sock := NewSocket(testClientIP, 0)
+			if err := client.Connect(sock, testServerIP, tt.port); err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+			defer sock.Close()
+			accepted, err := listener.Accept()
+			if err != nil {
+				t.Fatalf("Accept() error = %v", err)
+			}
+			defer accepted.Close()
+
+			if mss := sock.Stats().MSS; mss != tt.want {
+				t.Errorf("client MSS = %d, want %d", mss, tt.want)
+			}
+			if mss := accepted.Stats().MSS; mss != tt.want {
+				t.Errorf("server MSS = %d, want %d", mss, tt.want)
+			}
+		})
+	}
+}
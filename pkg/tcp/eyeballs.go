@@ -0,0 +1,263 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+const (
+	// DefaultResolutionDelay is how long a Dialer waits for IPv6 addresses
+	// once it has IPv4 ones (RFC 8305 Section 3).
+	DefaultResolutionDelay = 50 * time.Millisecond
+
+	// DefaultAttemptDelay is how long a Dialer waits for a connection
+	// attempt before starting the next one alongside it (RFC 8305 Section
+	// 5).
+	DefaultAttemptDelay = 250 * time.Millisecond
+)
+
+// Dialer opens connections to host names over IPv6 and IPv4 with Happy
+// Eyeballs (RFC 8305): it looks up both families at once, and tries the
+// addresses in turn, alternating families and starting with IPv6, each
+// AttemptDelay after the last or as soon as it fails. The first connection
+// to open wins; the attempts still in the handshake are abandoned and
+// those that opened as well are closed.
+type Dialer struct {
+	// Demux connects the sockets (required).
+	Demux *Demultiplexer
+
+	// Local addresses of the connections over each family; zero for the
+	// wildcard
+	LocalAddr  common.IPv4Address
+	LocalAddr6 common.IPv6Address
+
+	// LookupIPv4 and LookupIPv6 return the A and AAAA addresses of a host
+	// name. Either may be nil to dial over the other family only; IP
+	// literals are dialed without them.
+	LookupIPv4 func(ctx context.Context, host string) ([]common.IPv4Address, error)
+	LookupIPv6 func(ctx context.Context, host string) ([]common.IPv6Address, error)
+
+	// Delays of RFC 8305, DefaultResolutionDelay and DefaultAttemptDelay
+	// if zero
+	ResolutionDelay time.Duration
+	AttemptDelay    time.Duration
+}
+
+// lookupResult is the answer of one family's lookup.
+type lookupResult struct {
+	ipv6  bool
+	addrs []common.IPv6Address // In the form they are looked up by
+	err   error
+}
+
+// attemptResult is the outcome of one connection attempt.
+type attemptResult struct {
+	socket *Socket
+	err    error
+}
+
+// Dial connects to host and port, giving up after DefaultConnectTimeout.
+func (d *Dialer) Dial(host string, port uint16) (*Socket, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultConnectTimeout)
+	defer cancel()
+	return d.DialContext(ctx, host, port)
+}
+
+// DialContext connects to host and port, giving up when ctx is done. If
+// every attempt fails it returns the error of the first.
+func (d *Dialer) DialContext(ctx context.Context, host string, port uint16) (*Socket, error) {
+	// Cancelling ctx abandons the lookups and attempts still running
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+
+	lookups := make(chan lookupResult, 2)
+	pending, err := d.lookup(ctx, host, lookups)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		v6, v4     []common.IPv6Address // Addresses not yet tried
+		preferV6   = true
+		ready      bool // Attempts may start
+		running    int
+		attempted  int
+		firstErr   error
+		lookupErr  error
+		resolution <-chan time.Time
+		nextTimer  <-chan time.Time
+	)
+	results := make(chan attemptResult)
+
+	startNext := func() {
+		addr, ok := nextAddr(&v6, &v4, &preferV6)
+		if !ok {
+			nextTimer = nil
+			return
+		}
+		running++
+		attempted++
+		go d.attempt(ctx, addr, port, results, done)
+		nextTimer = time.After(d.attemptDelay())
+	}
+
+	for {
+		select {
+		case r := <-lookups:
+			pending--
+			switch {
+			case r.err != nil:
+				if lookupErr == nil {
+					lookupErr = r.err
+				}
+			case r.ipv6:
+				v6 = append(v6, r.addrs...)
+			default:
+				v4 = append(v4, r.addrs...)
+			}
+			if !ready {
+				switch {
+				case r.ipv6 || pending == 0:
+					ready = true
+				case len(r.addrs) > 0 && resolution == nil:
+					// Give the AAAA answer a moment to arrive
+					resolution = time.After(d.resolutionDelay())
+				}
+			}
+			// Unless an attempt started less than AttemptDelay ago
+			if ready && nextTimer == nil {
+				startNext()
+			}
+
+		case <-resolution:
+			resolution = nil
+			if !ready {
+				ready = true
+				startNext()
+			}
+
+		case <-nextTimer:
+			startNext()
+
+		case r := <-results:
+			running--
+			if r.err == nil {
+				return r.socket, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			startNext()
+
+		case <-ctx.Done():
+			if firstErr != nil {
+				return nil, fmt.Errorf("failed to connect to %s: %w (%w)", host, ctx.Err(), firstErr)
+			}
+			return nil, fmt.Errorf("failed to connect to %s: %w", host, ctx.Err())
+		}
+
+		if pending > 0 || running > 0 || len(v6)+len(v4) > 0 {
+			continue
+		}
+		switch {
+		case firstErr != nil:
+			return nil, fmt.Errorf("failed to connect to %s: %w", host, firstErr)
+		case attempted == 0 && lookupErr != nil:
+			return nil, fmt.Errorf("failed to resolve %q: %w", host, lookupErr)
+		default:
+			return nil, fmt.Errorf("no addresses for %q", host)
+		}
+	}
+}
+
+// lookup starts the lookups of host, sending their answers to results, and
+// returns how many it started. An IP literal is answered at once.
+func (d *Dialer) lookup(ctx context.Context, host string, results chan<- lookupResult) (int, error) {
+	if addr, err := common.ParseIPv4(host); err == nil {
+		results <- lookupResult{addrs: []common.IPv6Address{ipKey(addr)}}
+		return 1, nil
+	}
+	if addr, err := common.ParseIPv6(host); err == nil {
+		results <- lookupResult{ipv6: !isIPv4(addr), addrs: []common.IPv6Address{addr}}
+		return 1, nil
+	}
+	if d.LookupIPv4 == nil && d.LookupIPv6 == nil {
+		return 0, fmt.Errorf("cannot resolve %q: no resolver", host)
+	}
+
+	pending := 0
+	if d.LookupIPv6 != nil {
+		pending++
+		go func() {
+			addrs, err := d.LookupIPv6(ctx, host)
+			results <- lookupResult{ipv6: true, addrs: addrs, err: err}
+		}()
+	}
+	if d.LookupIPv4 != nil {
+		pending++
+		go func() {
+			addrs, err := d.LookupIPv4(ctx, host)
+			keys := make([]common.IPv6Address, len(addrs))
+			for i, addr := range addrs {
+				keys[i] = ipKey(addr)
+			}
+			results <- lookupResult{addrs: keys, err: err}
+		}()
+	}
+	return pending, nil
+}
+
+// nextAddr takes the next address to try, from the family preferred if it
+// has any left, and prefers the other family for the one after.
+func nextAddr(v6, v4 *[]common.IPv6Address, preferV6 *bool) (common.IPv6Address, bool) {
+	from := v4
+	if (*preferV6 && len(*v6) > 0) || len(*v4) == 0 {
+		from = v6
+	}
+	if len(*from) == 0 {
+		return common.IPv6Address{}, false
+	}
+	addr := (*from)[0]
+	*from = (*from)[1:]
+	*preferV6 = from == v4
+	return addr, true
+}
+
+// attempt connects a new socket to addr and port and sends the outcome to
+// results, or, once the dial is over, closes the socket.
+func (d *Dialer) attempt(ctx context.Context, addr common.IPv6Address, port uint16, results chan<- attemptResult, done <-chan struct{}) {
+	local := d.LocalAddr6
+	if isIPv4(addr) {
+		local = ipKey(d.LocalAddr)
+	}
+	s := newSocket(local, 0)
+	err := d.Demux.ConnectIPv6Context(ctx, s, addr, port)
+	select {
+	case results <- attemptResult{s, err}:
+	case <-done:
+		if err == nil {
+			s.Close()
+		}
+	}
+}
+
+// resolutionDelay returns the Resolution Delay.
+func (d *Dialer) resolutionDelay() time.Duration {
+	if d.ResolutionDelay > 0 {
+		return d.ResolutionDelay
+	}
+	return DefaultResolutionDelay
+}
+
+// attemptDelay returns the Connection Attempt Delay.
+func (d *Dialer) attemptDelay() time.Duration {
+	if d.AttemptDelay > 0 {
+		return d.AttemptDelay
+	}
+	return DefaultAttemptDelay
+}
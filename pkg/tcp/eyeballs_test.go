@@ -0,0 +1,140 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// lookups returns resolvers answering with the test server's addresses,
+// after a delay for IPv6.
+func lookups(delay6 time.Duration) (func(context.Context, string) ([]common.IPv4Address, error), func(context.Context, string) ([]common.IPv6Address, error)) {
+	lookup4 := func(ctx context.Context, host string) ([]common.IPv4Address, error) {
+		return []common.IPv4Address{testServerIP}, nil
+	}
+	lookup6 := func(ctx context.Context, host string) ([]common.IPv6Address, error) {
+		select {
+		case <-time.After(delay6):
+			return []common.IPv6Address{testServerIP6}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return lookup4, lookup6
+}
+
+func TestDialer(t *testing.T) {
+	tests := []struct {
+		name      string
+		delay6    time.Duration // Of the AAAA answer
+		drop6     bool          // IPv6 is broken
+		wantIPv6  bool
+		wantConns int // Left on the client once the loser is gone
+	}{
+		{"prefers IPv6", 0, false, true, 1},
+		{"IPv6 broken", 0, true, false, 1},
+		{"AAAA answer late", time.Second, false, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewDemultiplexer()
+			server := NewDemultiplexer()
+			defer linkDemuxes(t, client, server)()
+			if tt.drop6 {
+				client.SetSendFuncIPv6(func(*Segment, common.IPv6Address, common.IPv6Address) error { return nil })
+			}
+
+			listener := NewSocket(common.IPv4Address{}, 9443)
+			listener.SetReuseAddr(true)
+			if err := server.Listen(listener, 4); err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+			defer listener.Close()
+
+			lookup4, lookup6 := lookups(tt.delay6)
+			d := &Dialer{
+				Demux:           client,
+				LocalAddr:       testClientIP,
+				LocalAddr6:      testClientIP6,
+				LookupIPv4:      lookup4,
+				LookupIPv6:      lookup6,
+				ResolutionDelay: 10 * time.Millisecond,
+				AttemptDelay:    20 * time.Millisecond,
+			}
+			sock, err := d.Dial("server.example", 9443)
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer sock.Close()
+
+			if got := !isIPv4(sock.GetRemoteAddr6()); got != tt.wantIPv6 {
+				t.Errorf("connected to %s, want IPv6 = %v", hostPort(sock.GetRemoteAddr6(), 9443), tt.wantIPv6)
+			}
+			if sock.GetState() != StateEstablished {
+				t.Errorf("state = %v, want %v", sock.GetState(), StateEstablished)
+			}
+
+			// The abandoned attempt leaves the demultiplexer
+			deadline := time.Now().Add(time.Second)
+			for {
+				client.mu.RLock()
+				conns := len(client.conns)
+				client.mu.RUnlock()
+				if conns == tt.wantConns {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("%d client connections, want %d", conns, tt.wantConns)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestDialerErrors(t *testing.T) {
+	client := NewDemultiplexer()
+	server := NewDemultiplexer()
+	defer linkDemuxes(t, client, server)()
+
+	lookup4, lookup6 := lookups(0)
+	failing := func(ctx context.Context, host string) ([]common.IPv6Address, error) {
+		return nil, errors.New("no such host")
+	}
+
+	tests := []struct {
+		name    string
+		lookup4 func(context.Context, string) ([]common.IPv4Address, error)
+		lookup6 func(context.Context, string) ([]common.IPv6Address, error)
+		host    string
+		wantErr error
+	}{
+		{"refused on both families", lookup4, lookup6, "server.example", ErrConnectionRefused},
+		{"IPv4 literal", nil, nil, testServerIP.String(), ErrConnectionRefused},
+		{"IPv6 literal", nil, nil, testServerIP6.String(), ErrConnectionRefused},
+		{"no resolver", nil, nil, "server.example", nil},
+		{"lookup fails", nil, failing, "server.example", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Dialer{
+				Demux:      client,
+				LocalAddr:  testClientIP,
+				LocalAddr6: testClientIP6,
+				LookupIPv4: tt.lookup4,
+				LookupIPv6: tt.lookup6,
+			}
+			_, err := d.Dial(tt.host, 9) // Nothing listens on it
+			if err == nil {
+				t.Fatal("Dial() error = nil, want an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Dial() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
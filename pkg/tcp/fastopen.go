@@ -7,6 +7,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	"net"
+	"sync"
 )
 
 const (
@@ -26,6 +27,7 @@ type TFOState struct {
 	key cipher.Block // AES key for generating cookies
 
 	// Client-side state
+	mu          sync.Mutex
 	cookieCache map[string]TFOCookie // IP -> Cookie mapping
 }
 
@@ -89,11 +91,15 @@ func subtle_constantTimeCompare(a, b []byte) bool {
 
 // CacheCookie caches a TFO cookie for the given server IP.
 func (tfo *TFOState) CacheCookie(serverIP string, cookie TFOCookie) {
+	tfo.mu.Lock()
+	defer tfo.mu.Unlock()
 	tfo.cookieCache[serverIP] = cookie
 }
 
 // GetCachedCookie retrieves a cached TFO cookie for the given server IP.
 func (tfo *TFOState) GetCachedCookie(serverIP string) (TFOCookie, bool) {
+	tfo.mu.Lock()
+	defer tfo.mu.Unlock()
 	cookie, ok := tfo.cookieCache[serverIP]
 	return cookie, ok
 }
@@ -185,3 +191,69 @@ func (c *TFOConnection) GetQueuedData() []byte {
 func (c *TFOConnection) HasCookie() bool {
 	return c.cookie != nil
 }
+
+// addFastOpen adds the TFO option to a SYN. With a cookie cached for the
+// server, queued data (up to one MSS) is sent in the SYN; otherwise the
+// option requests a cookie for the next connection.
+func (c *Connection) addFastOpen(syn *Segment) {
+	if cookie, ok := c.tfo.state.GetCachedCookie(c.remoteIP().String()); ok {
+		c.tfo.SetCookie(cookie)
+	}
+	if !c.tfo.HasCookie() {
+		syn.Options = append(syn.Options, BuildTFOOption(nil)...)
+		return
+	}
+
+	syn.Options = append(syn.Options, BuildTFOOption(c.tfo.cookie[:])...)
+	data := c.tfo.GetQueuedData()
+	if len(data) > int(c.mss) {
+		// The rest follows once the connection is established
+		c.tfo.QueueData(data[c.mss:])
+		data = data[:c.mss]
+	}
+	c.synData = data
+	syn.Data = data
+}
+
+// fastOpenSynAck caches the cookie in a SYN-ACK and returns the data still
+// to send: SYN data the server did not acknowledge, then data that did not
+// fit the SYN.
+func (c *Connection) fastOpenSynAck(synAck *Segment) []byte {
+	if cookie, err := synAck.GetTFOCookie(); err == nil && len(cookie) == TFOCookieLen {
+		var fresh TFOCookie
+		copy(fresh[:], cookie)
+		c.tfo.state.CacheCookie(c.remoteIP().String(), fresh)
+	}
+
+	// The server acknowledges either all of the SYN data or none of it
+	acked := synAck.AckNumber - (c.iss + 1)
+	unsent := c.synData[acked:]
+	c.synData = nil
+	return append(unsent, c.tfo.GetQueuedData()...)
+}
+
+// acceptFastOpen processes the TFO option of a received SYN. Data in a SYN
+// with a valid cookie is accepted at once; a cookie request or an invalid
+// cookie gets a fresh cookie, returned as an option for the SYN-ACK, and
+// any SYN data is left for the client to send again.
+func (c *Connection) acceptFastOpen(syn *Segment) []byte {
+	clientIP := c.remoteIP()
+	if cookie, err := syn.GetTFOCookie(); err == nil && len(cookie) == TFOCookieLen {
+		var got TFOCookie
+		copy(got[:], cookie)
+		if c.tfo.state.ValidateCookie(clientIP, got) {
+			if len(syn.Data) > 0 {
+				c.rcvNxt += uint32(len(syn.Data))
+				c.deliver(syn.Data)
+				c.shrinkReceiveWindow()
+			}
+			return nil
+		}
+	}
+
+	fresh, err := c.tfo.state.GenerateCookie(clientIP)
+	if err != nil {
+		return nil
+	}
+	return BuildTFOOption(fresh[:])
+}
@@ -0,0 +1,122 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+)
+
+func newTFOState(t *testing.T) *TFOState {
+	t.Helper()
+	tfo, err := NewTFOState()
+	if err != nil {
+		t.Fatalf("NewTFOState() error = %v", err)
+	}
+	return tfo
+}
+
+func TestFastOpen(t *testing.T) {
+	clientTFO, serverTFO := newTFOState(t), newTFOState(t)
+
+	// Without a cookie the SYN requests one and the data waits for the
+	// handshake
+	client := newTestPeer(true, clientTFO)
+	server := newTestPeer(false, serverTFO)
+	client.conn.tfo.QueueData([]byte("hello"))
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	syn := client.out[0]
+	if cookie, err := syn.GetTFOCookie(); err != nil || len(cookie) != 0 || len(syn.Data) != 0 {
+		t.Errorf("first SYN cookie = %x, data = %q, want a cookie request without data", cookie, syn.Data)
+	}
+
+	exchange(t, client, server)
+	if client.conn.GetState() != StateEstablished || server.conn.GetState() != StateEstablished {
+		t.Fatalf("states = %v/%v, want ESTABLISHED", client.conn.GetState(), server.conn.GetState())
+	}
+	if string(server.data) != "hello" {
+		t.Errorf("server received %q, want %q", server.data, "hello")
+	}
+	want, _ := serverTFO.GenerateCookie(net.IP(testClientIP[:]))
+	if got, ok := clientTFO.GetCachedCookie(testServerIP.String()); !ok || got != want {
+		t.Errorf("cached cookie = %x, want %x", got, want)
+	}
+
+	// With the cookie the data rides on the SYN, and is delivered before
+	// the server's application attaches
+	client = newTestPeer(true, clientTFO)
+	server = newTestPeer(false, serverTFO)
+	server.conn.onDataReady = nil
+	client.conn.tfo.QueueData([]byte("again"))
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	if got := string(client.out[0].Data); got != "again" {
+		t.Errorf("SYN data = %q, want %q", got, "again")
+	}
+
+	deliver(t, client, server)
+	if got := server.out[0].AckNumber; got != client.conn.iss+1+5 {
+		t.Errorf("SYN-ACK acknowledges %d, want SYN and data (%d)", got, client.conn.iss+6)
+	}
+	deliver(t, server, client)
+	if len(client.out) != 1 || len(client.out[0].Data) != 0 {
+		t.Errorf("client sent %d segments after SYN-ACK, want only the ACK", len(client.out))
+	}
+	exchange(t, client, server)
+
+	server.conn.setDataReady(func(data []byte) { server.data = append(server.data, data...) })
+	if string(server.data) != "again" {
+		t.Errorf("server received %q, want %q", server.data, "again")
+	}
+}
+
+func TestFastOpenRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverTFO  bool
+		wantCookie bool
+	}{
+		{"invalid cookie", true, true},
+		{"server without fast open", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientTFO := newTFOState(t)
+			clientTFO.CacheCookie(testServerIP.String(), TFOCookie{1, 2, 3})
+
+			var serverTFO *TFOState
+			if tt.serverTFO {
+				serverTFO = newTFOState(t)
+			}
+			client := newTestPeer(true, clientTFO)
+			server := newTestPeer(false, serverTFO)
+			client.conn.tfo.QueueData([]byte("payload"))
+			if err := client.conn.ActiveOpen(); err != nil {
+				t.Fatalf("ActiveOpen() error = %v", err)
+			}
+
+			// The server ignores the SYN data; the client sends it again
+			// once established
+			deliver(t, client, server)
+			if got := server.out[0].AckNumber; got != client.conn.iss+1 {
+				t.Errorf("SYN-ACK acknowledges %d, want only the SYN (%d)", got, client.conn.iss+1)
+			}
+			if _, err := server.out[0].GetTFOCookie(); (err == nil) != tt.wantCookie {
+				t.Errorf("SYN-ACK has cookie = %v, want %v", err == nil, tt.wantCookie)
+			}
+			exchange(t, client, server)
+
+			if string(server.data) != "payload" {
+				t.Errorf("server received %q, want %q", server.data, "payload")
+			}
+			if tt.wantCookie {
+				want, _ := serverTFO.GenerateCookie(net.IP(testClientIP[:]))
+				if got, _ := clientTFO.GetCachedCookie(testServerIP.String()); got != want {
+					t.Errorf("cached cookie = %x, want the fresh cookie %x", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package tcp
+
+import (
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+// Spurious retransmission timeout detection with F-RTO (RFC 5682). A delay
+// spike longer than the RTO has the connection send again data that was
+// not lost, and collapse its congestion window for nothing. F-RTO tells
+// the two apart after the timeout resends the first segment: on the ACK
+// of that segment the connection sends new data rather than the rest of
+// the flight, and if the next ACK then acknowledges segments from before
+// the timeout that were never sent twice, the originals got through and
+// the timeout was spurious. The congestion window and ssthresh go back
+// to what they were before it (the response of RFC 4015). Otherwise
+// recovery goes on as after any timeout, RACK resending the rest of the
+// flight.
+//
+// With SACK (Section 3 of the RFC), SACKed segments count as
+// acknowledged, and duplicate ACKs before the first new one are waited
+// through: the SACK blocks show what they are for.
+const (
+	frtoIdle      = iota // No timeout being checked
+	frtoFirstAck         // Waiting for the ACK of the resent segment (step 2)
+	frtoSecondAck        // New data sent, waiting for the ACK after (step 3)
+)
+
+// frtoState is the F-RTO state of a connection.
+type frtoState struct {
+	step    int
+	recover uint32 // sndNxt at the timeout
+	resent  uint32 // End of the segment the timeout sent again
+
+	// The windows before the timeout, restored if it was spurious
+	cwnd, ssthresh uint32
+}
+
+// frtoTimeout starts F-RTO on a retransmission timeout, before the
+// timeout resends head and cuts the congestion window. Only the first
+// timeout of a segment, outside loss recovery, is checked; another
+// timeout while one is starts over, keeping the windows from before the
+// first.
+func (c *Connection) frtoTimeout(head *RetransmitEntry) {
+	switch {
+	case c.frto.step != frtoIdle:
+	case head.RetryCount == 0 && !c.rack.inRecovery:
+		c.frto.recover = c.sndNxt
+		c.frto.cwnd, c.frto.ssthresh = c.cwnd, c.ssthresh
+	default:
+		return
+	}
+	c.frto.step = frtoFirstAck
+	c.frto.resent = head.SeqNum + segmentLength(head.Segment)
+}
+
+// frtoAck runs F-RTO for an ACK arriving after a retransmission timeout,
+// once the ACK has moved sndUna: whether it moved it, and the segments it
+// delivered.
+func (c *Connection) frtoAck(delivered []*RetransmitEntry, advanced bool) {
+	switch c.frto.step {
+	case frtoFirstAck:
+		if !advanced {
+			if !c.sackOK {
+				c.frtoConventional()
+			}
+			return
+		}
+		// An ACK short of the resent segment shows it was lost too, and
+		// one of everything leaves nothing to tell by
+		if seqBefore(c.sndUna, c.frto.resent) || !seqBefore(c.sndUna, c.frto.recover) || !c.frtoSendNew() {
+			c.frtoConventional()
+			return
+		}
+		c.frto.step = frtoSecondAck
+
+	case frtoSecondAck:
+		// Only the originals show the timeout was spurious, the new data
+		// arriving either way; any new ACK covers some
+		if advanced {
+			c.frtoSpurious()
+			return
+		}
+		for _, e := range delivered {
+			if e.RetryCount == 0 && seqBefore(e.SeqNum, c.frto.recover) {
+				c.frtoSpurious()
+				return
+			}
+		}
+		c.frtoConventional()
+	}
+}
+
+// frtoSendNew sends up to two new segments at once, whatever room the
+// congestion window the timeout cut and the pacing rate leave, and
+// returns whether it sent any.
+func (c *Connection) frtoSendNew() bool {
+	if c.sendBuffer.Len() == 0 || !c.canFlush() {
+		return false
+	}
+	cwnd, sent := c.cwnd, c.sndNxt
+	c.cwnd = c.sndNxt - c.sndUna + 2*uint32(c.mss)
+	c.pacingNext = time.Time{}
+	c.sendBuffered(true)
+	c.cwnd = cwnd
+	return c.sndNxt != sent
+}
+
+// frtoSpurious undoes the response to a spurious timeout.
+func (c *Connection) frtoSpurious() {
+	c.frto.step = frtoIdle
+	c.rack.afterRTO = false
+	if c.cwnd < c.frto.cwnd {
+		c.cwnd = c.frto.cwnd
+	}
+	c.ssthresh = c.frto.ssthresh
+	c.recordCwnd()
+	c.stats.spuriousRTOs++
+	logger.Debug("spurious retransmission timeout", c.logID(), logging.F("cwnd", c.cwnd))
+}
+
+// frtoConventional gives up on F-RTO: the segments outstanding at the
+// timeout after the resent one, which RACK leaves alone while F-RTO
+// checks it, are lost.
+func (c *Connection) frtoConventional() {
+	c.frto.step = frtoIdle
+	if c.rack.sackSeen {
+		c.rackMarkLost(c.frto.resent, c.frto.recover)
+	}
+}
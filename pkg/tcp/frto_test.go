@@ -0,0 +1,131 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+)
+
+// frtoPeers returns an established client and server, the client with
+// four segments outstanding and two more buffered, and the congestion
+// window before its retransmission timer expires.
+func frtoPeers(t *testing.T, sack bool) (*testPeer, *testPeer, []*Segment, uint32) {
+	t.Helper()
+	client, server, _ := sackPeers(t)
+	client.conn.sackOK = sack
+	client.conn.cwnd = 4 * DefaultMSS
+	if err := client.conn.Send(make([]byte, 6*DefaultMSS)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	segs := client.out
+	if len(segs) != 4 {
+		t.Fatalf("sent %d segments, want 4", len(segs))
+	}
+	client.out = nil
+	cwnd := client.conn.cwnd
+
+	// The timeout comes long after the segments were sent, so that an
+	// ACK of the segment it resends is not taken for one of the original
+	backdate(client, 100*time.Millisecond)
+	client.conn.retransmitTimeout()
+	if len(client.out) != 1 || client.out[0].SequenceNumber != segs[0].SequenceNumber || client.conn.cwnd != DefaultMSS {
+		t.Fatalf("timeout sent %v with cwnd %d, want the first segment with cwnd %d", client.out, client.conn.cwnd, DefaultMSS)
+	}
+	client.out = nil
+	return client, server, segs, cwnd
+}
+
+// frtoNewData passes the client the server's ACK of segs[0], after which
+// it sends two new segments, and returns them.
+func frtoNewData(t *testing.T, client, server *testPeer, segs []*Segment) []*Segment {
+	t.Helper()
+	client.out = segs[:1]
+	deliver(t, client, server)
+	deliver(t, server, client)
+	sent := client.out
+	client.out = nil
+	if len(sent) != 2 || sent[0].SequenceNumber != segs[3].SequenceNumber+DefaultMSS {
+		t.Fatalf("sent %v after the ACK of the first segment, want 2 new segments", sent)
+	}
+	return sent
+}
+
+func TestFRTOSpurious(t *testing.T) {
+	for _, sack := range []bool{false, true} {
+		client, server, segs, cwnd := frtoPeers(t, sack)
+		ssthresh := client.conn.frto.ssthresh
+		if sack {
+			// Duplicate ACKs ahead of the ACK of the resent segment are
+			// waited through
+			ackWithSACK(t, client, server, segs[0].SequenceNumber, segs[1])
+			if len(client.out) != 0 || client.conn.frto.step != frtoFirstAck {
+				t.Fatalf("SACK: sent %v in F-RTO step %d after a duplicate ACK, want nothing in step %d", client.out, client.conn.frto.step, frtoFirstAck)
+			}
+		}
+		frtoNewData(t, client, server, segs)
+
+		// The timeout was a delay: the original second segment arrives
+		client.out = segs[1:2]
+		deliver(t, client, server)
+		deliver(t, server, client)
+		if client.conn.cwnd < cwnd || client.conn.ssthresh != ssthresh {
+			t.Errorf("SACK %v: cwnd = %d, ssthresh = %d after a spurious timeout, want at least %d and %d", sack, client.conn.cwnd, client.conn.ssthresh, cwnd, ssthresh)
+		}
+		if stats := client.conn.Stats(); stats.SpuriousRTOs != 1 {
+			t.Errorf("SACK %v: Stats().SpuriousRTOs = %d, want 1", sack, stats.SpuriousRTOs)
+		}
+		if client.conn.frto.step != frtoIdle {
+			t.Errorf("SACK %v: F-RTO step %d, want it done", sack, client.conn.frto.step)
+		}
+	}
+}
+
+func TestFRTOLoss(t *testing.T) {
+	for _, sack := range []bool{false, true} {
+		client, server, segs, _ := frtoPeers(t, sack)
+		sent := frtoNewData(t, client, server, segs)
+		cwnd := client.conn.cwnd
+
+		// The flight was lost: the peer reports only the new data, or
+		// nothing without SACK
+		if sack {
+			ackWithSACK(t, client, server, segs[1].SequenceNumber, sent[0])
+		} else {
+			ackWithSACK(t, client, server, segs[1].SequenceNumber)
+		}
+		if client.conn.cwnd != cwnd || client.conn.frto.step != frtoIdle {
+			t.Errorf("SACK %v: cwnd = %d in F-RTO step %d, want %d and done", sack, client.conn.cwnd, client.conn.frto.step, cwnd)
+		}
+		if stats := client.conn.Stats(); stats.SpuriousRTOs != 0 {
+			t.Errorf("SACK %v: Stats().SpuriousRTOs = %d, want 0", sack, stats.SpuriousRTOs)
+		}
+
+		// With SACK, RACK resends the rest of the flight as the window
+		// allows
+		want := 0
+		if sack {
+			want = 1
+		}
+		if len(client.out) != want || want > 0 && client.out[0].SequenceNumber != segs[1].SequenceNumber {
+			t.Errorf("SACK %v: retransmitted %v, want %d segments from the second", sack, client.out, want)
+		}
+	}
+}
+
+func TestFRTORepeatedTimeout(t *testing.T) {
+	// A second timeout of the same segment keeps the windows from before
+	// the first
+	client, _, _, cwnd := frtoPeers(t, false)
+	client.conn.retransmitTimeout()
+	if client.conn.frto.step != frtoFirstAck || client.conn.frto.cwnd != cwnd {
+		t.Errorf("F-RTO step %d with cwnd %d saved after a second timeout, want step %d with %d", client.conn.frto.step, client.conn.frto.cwnd, frtoFirstAck, cwnd)
+	}
+
+	// Timeouts in loss recovery are not checked
+	client, _, _ = sackPeers(t)
+	sendSegments(t, client, 2)
+	client.conn.rack.inRecovery = true
+	client.conn.retransmitTimeout()
+	if client.conn.frto.step != frtoIdle {
+		t.Errorf("F-RTO step %d after a timeout in recovery, want none", client.conn.frto.step)
+	}
+}
@@ -0,0 +1,41 @@
+package tcp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/internal/fuzzseed"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func FuzzParse(f *testing.F) {
+	fuzzseed.Add(f, fuzzseed.IPv4Payloads(f, uint8(common.ProtocolTCP)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		seg, err := Parse(data)
+		if err != nil {
+			return
+		}
+		_ = seg.String()
+
+		// The option getters must cope with any option bytes
+		seg.GetMSS()
+		seg.GetWindowScale()
+		seg.GetTimestamp()
+		seg.GetSACKBlocks()
+		seg.HasSACKPermitted()
+		seg.GetTFOCookie()
+
+		serialized, err := seg.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		again, err := Parse(serialized)
+		if err != nil {
+			t.Fatalf("Parse() of serialized segment error = %v", err)
+		}
+		if !bytes.Equal(again.Options, seg.Options) || !bytes.Equal(again.Data, seg.Data) {
+			t.Errorf("reparsed segment = %v, want %v", again, seg)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package tcp
+
+import (
+	"bytes"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// DefaultCoalesceFlows is the number of flows a Coalescer holds segments
+// for at once.
+const DefaultCoalesceFlows = 8
+
+// Coalescer merges consecutive in-order data segments of a flow into one
+// larger segment, as generic receive offload does, so that a bulk transfer
+// is handled by Connection.HandleSegment once per batch instead of once per
+// wire segment.
+//
+// Received segments are passed to Add and held while later segments of
+// their flow extend them; Flush delivers everything held. A receive loop
+// adds the segments of a batch of frames and flushes at the end of the
+// batch, so coalescing adds no latency beyond the batch:
+//
+//	for {
+//		for _, frame := range readBatch() {
+//			gro.Add(parseSegment(frame))
+//		}
+//		gro.Flush()
+//	}
+//
+// Only pure ACK segments carrying data, with optional PSH, are merged, and
+// only when they agree in ACK number and options; a PSH ends the merge.
+// Checksums are verified as segments are merged, so a merged segment is
+// not verified again. Anything else is delivered in order with the held
+// segments of its flow.
+type Coalescer struct {
+	deliver  func(seg *Segment, srcIP, dstIP common.IPv4Address) error
+	maxSize  int
+	maxFlows int
+
+	// Held flows, oldest first
+	flows []*groFlow
+
+	stats CoalescerStats
+}
+
+// CoalescerStats holds a Coalescer's counters.
+type CoalescerStats struct {
+	Segments  uint64 // Segments added
+	Delivered uint64 // Segments delivered, after merging
+	Merged    uint64 // Segments merged into a held segment
+}
+
+// groFlow is a segment held for a flow.
+type groFlow struct {
+	srcIP, dstIP common.IPv4Address
+	seg          *Segment
+	owned        bool // seg.Data has been copied into a buffer of our own
+}
+
+// NewCoalescer creates a Coalescer that passes segments to deliver,
+// typically Socket.HandleIncomingSegment. Merged segments carry at most
+// maxSize bytes of data, or GSOMaxSize if maxSize is zero.
+func NewCoalescer(maxSize int, deliver func(seg *Segment, srcIP, dstIP common.IPv4Address) error) *Coalescer {
+	if maxSize <= 0 || maxSize > GSOMaxSize {
+		maxSize = GSOMaxSize
+	}
+	return &Coalescer{
+		deliver:  deliver,
+		maxSize:  maxSize,
+		maxFlows: DefaultCoalesceFlows,
+	}
+}
+
+// Add adds a received segment, delivering it or holding it for merging.
+// A held segment's data must stay valid until it is delivered, so segments
+// decoded in place from a reused buffer, as the hook pipeline's are, must
+// be Cloned first; Parse copies the data.
+func (c *Coalescer) Add(seg *Segment, srcIP, dstIP common.IPv4Address) error {
+	c.stats.Segments++
+
+	i := c.find(seg, srcIP, dstIP)
+	if i >= 0 {
+		flow := c.flows[i]
+		held := flow.seg
+		if c.canMerge(held, seg) && seg.VerifyChecksum(srcIP, dstIP) {
+			if !flow.owned {
+				// Copy on the first merge, into a buffer with room for the
+				// largest merge, so the appends never write into whatever
+				// follows the held segment's data
+				held.Data = append(make([]byte, 0, c.maxSize), held.Data...)
+				flow.owned = true
+			}
+			held.Data = append(held.Data, seg.Data...)
+			held.Flags |= seg.Flags
+			held.WindowSize = seg.WindowSize
+			c.stats.Merged++
+			if seg.HasFlag(FlagPSH) {
+				return c.flush(i)
+			}
+			return nil
+		}
+
+		// Deliver what is held to keep the flow in order
+		if err := c.flush(i); err != nil {
+			return err
+		}
+	}
+
+	if !canHold(seg) || !seg.VerifyChecksum(srcIP, dstIP) {
+		c.stats.Delivered++
+		return c.deliver(seg, srcIP, dstIP)
+	}
+
+	// Make room by delivering the oldest flow
+	if len(c.flows) >= c.maxFlows {
+		if err := c.flush(0); err != nil {
+			return err
+		}
+	}
+	seg.checksumVerified = true
+	c.flows = append(c.flows, &groFlow{srcIP: srcIP, dstIP: dstIP, seg: seg})
+	return nil
+}
+
+// Flush delivers every held segment, oldest flow first, and returns the
+// first error.
+func (c *Coalescer) Flush() error {
+	var first error
+	for len(c.flows) > 0 {
+		if err := c.flush(0); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Stats returns the coalescer's counters.
+func (c *Coalescer) Stats() CoalescerStats {
+	return c.stats
+}
+
+// flush delivers the segment held for flow i and stops holding it.
+func (c *Coalescer) flush(i int) error {
+	flow := c.flows[i]
+	c.flows = append(c.flows[:i], c.flows[i+1:]...)
+	c.stats.Delivered++
+	return c.deliver(flow.seg, flow.srcIP, flow.dstIP)
+}
+
+// find returns the index of the flow a segment belongs to, or -1.
+func (c *Coalescer) find(seg *Segment, srcIP, dstIP common.IPv4Address) int {
+	for i, flow := range c.flows {
+		if flow.seg.SourcePort == seg.SourcePort && flow.seg.DestinationPort == seg.DestinationPort &&
+			flow.srcIP == srcIP && flow.dstIP == dstIP {
+			return i
+		}
+	}
+	return -1
+}
+
+// canHold reports whether a segment may start a merge: data with ACK and
+// no other flag. A PSH segment would end the merge at once.
+func canHold(seg *Segment) bool {
+	return len(seg.Data) > 0 && seg.Flags == FlagACK
+}
+
+// canMerge reports whether seg continues the held segment exactly.
+func (c *Coalescer) canMerge(held, seg *Segment) bool {
+	return len(seg.Data) > 0 &&
+		seg.Flags&^FlagPSH == FlagACK &&
+		seg.SequenceNumber == held.SequenceNumber+uint32(len(held.Data)) &&
+		seg.AckNumber == held.AckNumber &&
+		len(held.Data)+len(seg.Data) <= c.maxSize &&
+		bytes.Equal(seg.Options, held.Options)
+}
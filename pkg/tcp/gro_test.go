@@ -0,0 +1,156 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// groSegment returns a checksummed data segment from the test client.
+func groSegment(srcPort uint16, seq uint32, flags uint8, n int) *Segment {
+	seg := NewSegment(srcPort, 80, seq, 1, flags, 65535, make([]byte, n))
+	seg.Checksum, _ = seg.CalculateChecksum(testClientIP, testServerIP)
+	return seg
+}
+
+func TestCoalescer(t *testing.T) {
+	type span struct {
+		port uint16
+		seq  uint32
+		len  int
+	}
+	badChecksum := groSegment(50000, 1200, FlagACK, 100)
+	badChecksum.Checksum++
+
+	tests := []struct {
+		name    string
+		maxSize int
+		segs    []*Segment
+		want    []span // Delivered segments, in order
+	}{
+		{
+			name: "in order",
+			segs: []*Segment{
+				groSegment(50000, 1000, FlagACK, 100),
+				groSegment(50000, 1100, FlagACK, 100),
+				groSegment(50000, 1200, FlagACK, 100),
+			},
+			want: []span{{50000, 1000, 300}},
+		},
+		{
+			name: "PSH ends merge",
+			segs: []*Segment{
+				groSegment(50000, 1000, FlagACK, 100),
+				groSegment(50000, 1100, FlagACK|FlagPSH, 100),
+				groSegment(50000, 1200, FlagACK, 100),
+			},
+			want: []span{{50000, 1000, 200}, {50000, 1200, 100}},
+		},
+		{
+			name: "gap",
+			segs: []*Segment{
+				groSegment(50000, 1000, FlagACK, 100),
+				groSegment(50000, 1200, FlagACK, 100),
+			},
+			want: []span{{50000, 1000, 100}, {50000, 1200, 100}},
+		},
+		{
+			name: "interleaved flows",
+			segs: []*Segment{
+				groSegment(50000, 1000, FlagACK, 100),
+				groSegment(50001, 5000, FlagACK, 100),
+				groSegment(50000, 1100, FlagACK, 100),
+				groSegment(50001, 5100, FlagACK, 100),
+			},
+			want: []span{{50000, 1000, 200}, {50001, 5000, 200}},
+		},
+		{
+			name: "FIN flushes",
+			segs: []*Segment{
+				groSegment(50000, 1000, FlagACK, 100),
+				groSegment(50000, 1100, FlagACK|FlagFIN, 100),
+			},
+			want: []span{{50000, 1000, 100}, {50000, 1100, 100}},
+		},
+		{
+			name: "bad checksum",
+			segs: []*Segment{
+				groSegment(50000, 1000, FlagACK, 100),
+				groSegment(50000, 1100, FlagACK, 100),
+				badChecksum,
+			},
+			want: []span{{50000, 1000, 200}, {50000, 1200, 100}},
+		},
+		{
+			name:    "max size",
+			maxSize: 250,
+			segs: []*Segment{
+				groSegment(50000, 1000, FlagACK, 100),
+				groSegment(50000, 1100, FlagACK, 100),
+				groSegment(50000, 1200, FlagACK, 100),
+			},
+			want: []span{{50000, 1000, 200}, {50000, 1200, 100}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []span
+			var delivered []*Segment
+			gro := NewCoalescer(tt.maxSize, func(seg *Segment, srcIP, dstIP common.IPv4Address) error {
+				got = append(got, span{seg.SourcePort, seg.SequenceNumber, len(seg.Data)})
+				delivered = append(delivered, seg)
+				return nil
+			})
+			for _, seg := range tt.segs {
+				if err := gro.Add(seg, testClientIP, testServerIP); err != nil {
+					t.Fatalf("Add() error = %v", err)
+				}
+			}
+			if err := gro.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("delivered %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("delivered %v, want %v", got, tt.want)
+					break
+				}
+			}
+
+			// Only the segment with the bad checksum is left to fail
+			// verification
+			for _, seg := range delivered {
+				if !seg.checksumVerified && !seg.VerifyChecksum(testClientIP, testServerIP) && seg != badChecksum {
+					t.Errorf("delivered seg %d with a bad checksum", seg.SequenceNumber)
+				}
+			}
+
+			stats := gro.Stats()
+			if stats.Segments != uint64(len(tt.segs)) || stats.Delivered != uint64(len(tt.want)) {
+				t.Errorf("Stats() = %+v, want %d segments and %d delivered", stats, len(tt.segs), len(tt.want))
+			}
+		})
+	}
+}
+
+func BenchmarkCoalescer(b *testing.B) {
+	segs := make([]*Segment, 44)
+	for i := range segs {
+		segs[i] = groSegment(50000, uint32(i*DefaultMSS), FlagACK, DefaultMSS)
+	}
+	gro := NewCoalescer(0, func(seg *Segment, srcIP, dstIP common.IPv4Address) error { return nil })
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(segs) * DefaultMSS))
+	for i := 0; i < b.N; i++ {
+		for _, seg := range segs {
+			seg.Data = seg.Data[:DefaultMSS:DefaultMSS]
+			gro.Add(seg, testClientIP, testServerIP)
+		}
+		gro.Flush()
+	}
+}
@@ -0,0 +1,81 @@
+package tcp
+
+import (
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// GSOMaxSize is the most data a GSO super-segment carries: the largest
+// amount that, with a full TCP header, still fits in one IPv4 packet.
+const GSOMaxSize = 65535 - 20 - MaxHeaderLength
+
+// SplitGSO splits a super-segment into wire segments of at most GSOSize
+// bytes of data each, with their checksums set, as a NIC does for TCP
+// segmentation offload. A segment with GSOSize zero, or with no more data
+// than GSOSize, is returned as the only wire segment; if GSOSize was set,
+// it is cleared and the checksum computed.
+//
+// Sequence numbers advance across the wire segments. FIN and PSH are set
+// only on the last and CWR only on the first; the other header fields and
+// the options are copied to every segment. The wire segments' Data alias
+// the super-segment's.
+func (s *Segment) SplitGSO(srcIP, dstIP common.IPv4Address) ([]*Segment, error) {
+	return s.splitGSO(func(seg *Segment) (uint16, error) {
+		return seg.CalculateChecksum(srcIP, dstIP)
+	})
+}
+
+// SplitGSOIPv6 is SplitGSO for a super-segment sent over IPv6.
+func (s *Segment) SplitGSOIPv6(srcIP, dstIP common.IPv6Address) ([]*Segment, error) {
+	return s.splitGSO(func(seg *Segment) (uint16, error) {
+		return seg.CalculateChecksumIPv6(srcIP, dstIP)
+	})
+}
+
+// splitGSO splits a super-segment, computing the wire segments' checksums
+// with checksum.
+func (s *Segment) splitGSO(checksum func(*Segment) (uint16, error)) ([]*Segment, error) {
+	size := int(s.GSOSize)
+	if size == 0 || len(s.Data) <= size {
+		if s.GSOSize != 0 {
+			s.GSOSize = 0
+			s.Checksum = 0
+			sum, err := checksum(s)
+			if err != nil {
+				return nil, err
+			}
+			s.Checksum = sum
+		}
+		return []*Segment{s}, nil
+	}
+	if s.HasFlag(FlagSYN) || s.HasFlag(FlagURG) {
+		return nil, fmt.Errorf("cannot segment SYN or URG segment")
+	}
+
+	count := (len(s.Data) + size - 1) / size
+	segs := make([]*Segment, count)
+	wire := make([]Segment, count) // One allocation for all the headers
+	for i := range wire {
+		seg := &wire[i]
+		*seg = *s
+		seg.GSOSize = 0
+		seg.Checksum = 0
+		seg.SequenceNumber = s.SequenceNumber + uint32(i*size)
+		seg.Data = s.Data[i*size : min((i+1)*size, len(s.Data))]
+		if i > 0 {
+			seg.Flags &^= FlagCWR
+		}
+		if i < count-1 {
+			seg.Flags &^= FlagFIN | FlagPSH
+		}
+
+		sum, err := checksum(seg)
+		if err != nil {
+			return nil, err
+		}
+		seg.Checksum = sum
+		segs[i] = seg
+	}
+	return segs, nil
+}
@@ -0,0 +1,213 @@
+package tcp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestSplitGSO(t *testing.T) {
+	tests := []struct {
+		name      string
+		dataLen   int
+		gsoSize   uint16
+		flags     uint8
+		wantSizes []int
+	}{
+		{"not GSO", 3000, 0, FlagACK | FlagPSH, []int{3000}},
+		{"fits", 1000, 1460, FlagACK | FlagPSH, []int{1000}},
+		{"exact", 2920, 1460, FlagACK | FlagPSH, []int{1460, 1460}},
+		{"tail", 3000, 1460, FlagACK | FlagPSH | FlagFIN | FlagCWR, []int{1460, 1460, 80}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.dataLen)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			seg := NewSegment(50000, 80, 1000, 1, tt.flags, 65535, data)
+			seg.Options = BuildMSSOption(1460)
+			seg.GSOSize = tt.gsoSize
+
+			segs, err := seg.SplitGSO(testClientIP, testServerIP)
+			if err != nil {
+				t.Fatalf("SplitGSO() error = %v", err)
+			}
+			if len(segs) != len(tt.wantSizes) {
+				t.Fatalf("SplitGSO() returned %d segments, want %d", len(segs), len(tt.wantSizes))
+			}
+
+			var joined []byte
+			seq := uint32(1000)
+			for i, wire := range segs {
+				last := i == len(segs)-1
+				if len(wire.Data) != tt.wantSizes[i] {
+					t.Errorf("segment %d: %d bytes, want %d", i, len(wire.Data), tt.wantSizes[i])
+				}
+				if wire.SequenceNumber != seq {
+					t.Errorf("segment %d: seq = %d, want %d", i, wire.SequenceNumber, seq)
+				}
+				if wire.GSOSize != 0 {
+					t.Errorf("segment %d: GSOSize = %d, want 0", i, wire.GSOSize)
+				}
+				if tt.gsoSize != 0 && !wire.VerifyChecksum(testClientIP, testServerIP) {
+					t.Errorf("segment %d: bad checksum", i)
+				}
+				if wire.HasFlag(FlagFIN) != (last && tt.flags&FlagFIN != 0) || wire.HasFlag(FlagPSH) != last {
+					t.Errorf("segment %d: flags = 0x%02x", i, wire.Flags)
+				}
+				if wire.HasFlag(FlagCWR) != (i == 0 && tt.flags&FlagCWR != 0) {
+					t.Errorf("segment %d: flags = 0x%02x, want CWR only on the first", i, wire.Flags)
+				}
+				if !bytes.Equal(wire.Options, seg.Options) {
+					t.Errorf("segment %d: options = %x, want %x", i, wire.Options, seg.Options)
+				}
+				joined = append(joined, wire.Data...)
+				seq += uint32(len(wire.Data))
+			}
+			if !bytes.Equal(joined, data) {
+				t.Error("segments do not carry the super-segment's data")
+			}
+		})
+	}
+
+	syn := NewSegment(50000, 80, 1000, 0, FlagSYN, 65535, make([]byte, 3000))
+	syn.GSOSize = 1460
+	if _, err := syn.SplitGSO(testClientIP, testServerIP); err == nil {
+		t.Error("SplitGSO() of SYN error = nil, want an error")
+	}
+}
+
+// deliverSplit passes the segments a has sent to b like deliver, splitting
+// super-segments first and passing the wire segments through gro, if not
+// nil.
+func deliverSplit(t *testing.T, a, b *testPeer, gro *Coalescer) {
+	t.Helper()
+	segs := a.out
+	a.out = nil
+	for _, seg := range segs {
+		wire, err := seg.SplitGSO(a.conn.LocalAddr, a.conn.RemoteAddr)
+		if err != nil {
+			t.Fatalf("SplitGSO() error = %v", err)
+		}
+		for _, w := range wire {
+			raw, err := w.Serialize()
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			parsed, err := Parse(raw)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if gro != nil {
+				err = gro.Add(parsed, a.conn.LocalAddr, a.conn.RemoteAddr)
+			} else {
+				err = b.conn.HandleSegment(parsed)
+			}
+			if err != nil {
+				t.Fatalf("HandleSegment(%v) error = %v", parsed, err)
+			}
+		}
+	}
+	if gro != nil {
+		if err := gro.Flush(); err != nil {
+			t.Fatalf("Flush() error = %v", err)
+		}
+	}
+}
+
+func TestConnectionGSO(t *testing.T) {
+	for _, coalesce := range []bool{false, true} {
+		name := "GSO"
+		if coalesce {
+			name = "GSO+GRO"
+		}
+		t.Run(name, func(t *testing.T) {
+			client := newTestPeer(true, nil)
+			server := newTestPeer(false, nil)
+			client.conn.gso = true
+			if err := client.conn.ActiveOpen(); err != nil {
+				t.Fatalf("ActiveOpen() error = %v", err)
+			}
+			exchange(t, client, server)
+
+			var gro *Coalescer
+			if coalesce {
+				gro = NewCoalescer(0, func(seg *Segment, srcIP, dstIP common.IPv4Address) error {
+					return server.conn.HandleSegment(seg)
+				})
+			}
+
+			data := make([]byte, 20000)
+			for i := range data {
+				data[i] = byte(i * 7)
+			}
+			if err := client.conn.Send(data); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			// The initial window of 2 MSS goes out as one super-segment
+			if len(client.out) != 1 {
+				t.Fatalf("sent %d segments, want 1", len(client.out))
+			}
+			if seg := client.out[0]; seg.GSOSize != DefaultMSS || len(seg.Data) != 2*DefaultMSS {
+				t.Errorf("sent %d bytes with GSOSize %d, want %d with %d", len(seg.Data), seg.GSOSize, 2*DefaultMSS, DefaultMSS)
+			}
+
+			for rounds := 0; len(client.out)+len(server.out) > 0; rounds++ {
+				if rounds > 100 {
+					t.Fatal("transfer did not finish")
+				}
+				deliverSplit(t, client, server, gro)
+				deliver(t, server, client)
+			}
+
+			if !bytes.Equal(server.data, data) {
+				t.Errorf("server received %d bytes, want the %d sent", len(server.data), len(data))
+			}
+			if n := client.conn.retransmitQueue.Len(); n != 0 {
+				t.Errorf("retransmit queue holds %d segments, want 0", n)
+			}
+			if coalesce && gro.Stats().Merged == 0 {
+				t.Error("no segments were coalesced")
+			}
+		})
+	}
+}
+
+func TestTrimAcked(t *testing.T) {
+	conn := NewConnection(testClientIP, 50000, testServerIP, 80)
+	seg := NewSegment(50000, 80, 1000, 1, FlagACK|FlagPSH, 65535, make([]byte, 3*DefaultMSS))
+	seg.GSOSize = DefaultMSS
+
+	tests := []struct {
+		name        string
+		sndUna      uint32
+		wantSeq     uint32
+		wantLen     int
+		wantGSOSize uint16
+	}{
+		{"unacknowledged", 1000, 1000, 3 * DefaultMSS, DefaultMSS},
+		{"one acknowledged", 1000 + DefaultMSS, 1000 + DefaultMSS, 2 * DefaultMSS, DefaultMSS},
+		{"one left", 1000 + 2*DefaultMSS + 100, 1000 + 2*DefaultMSS + 100, DefaultMSS - 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn.sndUna = tt.sndUna
+			got := conn.trimAcked(seg)
+			if got.SequenceNumber != tt.wantSeq || len(got.Data) != tt.wantLen || got.GSOSize != tt.wantGSOSize {
+				t.Errorf("trimAcked() = seq %d, %d bytes, GSOSize %d; want seq %d, %d bytes, GSOSize %d",
+					got.SequenceNumber, len(got.Data), got.GSOSize, tt.wantSeq, tt.wantLen, tt.wantGSOSize)
+			}
+			if got.GSOSize == 0 && !got.VerifyChecksum(testClientIP, testServerIP) {
+				t.Error("trimAcked() segment has a bad checksum")
+			}
+		})
+	}
+	if seg.SequenceNumber != 1000 || len(seg.Data) != 3*DefaultMSS {
+		t.Error("trimAcked() modified the queued segment")
+	}
+}
@@ -0,0 +1,201 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+const (
+	// MinPathMTU is the smallest path MTU a Fragmentation Needed message
+	// can lower a connection's to, like Linux's min_pmtu, so that forged
+	// messages cannot make it send tiny segments.
+	MinPathMTU = 552
+
+	// ipv4HeaderLength is the length of an IPv4 header without options.
+	ipv4HeaderLength = 20
+)
+
+// DeliverICMPError delivers an ICMP error about a segment sent by one of the
+// demultiplexer's connections to that connection. It reports whether the
+// error was for a connection of the demultiplexer's.
+func (d *Demultiplexer) DeliverICMPError(msg *icmp.Message) bool {
+	orig, seq, err := parseICMPError(msg)
+	if err != nil {
+		return false
+	}
+	local, remote := orig.Ports()
+
+	d.mu.RLock()
+	conn := d.conns[fourTuple{ipKey(orig.Source), local, ipKey(orig.Destination), remote}]
+	listener := d.listeners[endpoint{ipKey(orig.Source), local}]
+	if listener == nil {
+		listener = d.listeners[endpoint{common.IPv6Address{}, local}]
+	}
+	d.mu.RUnlock()
+
+	switch {
+	case conn != nil:
+		conn.handleICMPError(msg, seq)
+		return true
+	case listener != nil:
+		return listener.handlePendingICMPError(msg, orig.Destination, remote, seq)
+	}
+	return false
+}
+
+// HandleICMPError handles an ICMP error about a segment the socket sent.
+// This should be called by the network stack when an ICMP error quoting a
+// TCP segment is received. It reports whether the error was for the
+// socket's connection, or one its listener is accepting.
+func (s *Socket) HandleICMPError(msg *icmp.Message) bool {
+	orig, seq, err := parseICMPError(msg)
+	if err != nil {
+		return false
+	}
+	local, remote := orig.Ports()
+
+	s.mu.RLock()
+	conn, listening := s.conn, s.isListening
+	matches := local == s.localPort && (s.localAddr.IsUnspecified() || s.localAddr == ipKey(orig.Source))
+	s.mu.RUnlock()
+	if !matches {
+		return false
+	}
+
+	if listening {
+		return s.handlePendingICMPError(msg, orig.Destination, remote, seq)
+	}
+	if conn == nil || conn.RemoteAddr != orig.Destination || conn.RemotePort != remote {
+		return false
+	}
+	conn.handleICMPError(msg, seq)
+	return true
+}
+
+// Err returns the last error ICMP reported for the connection, or the one
+// that aborted it, or nil.
+func (c *Connection) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.err
+}
+
+// handlePendingICMPError hands an ICMP error to the connection the listener
+// is accepting from remoteAddr and remotePort, dropping the connection if
+// the error aborts it.
+func (s *Socket) handlePendingICMPError(msg *icmp.Message, remoteAddr common.IPv4Address, remotePort uint16, seq uint32) bool {
+	connKey := hostPort(ipKey(remoteAddr), remotePort)
+
+	s.pendingConnsMu.Lock()
+	conn, exists := s.pendingConns[connKey]
+	s.pendingConnsMu.Unlock()
+	if !exists {
+		return false
+	}
+
+	conn.handleICMPError(msg, seq)
+	if conn.GetState() == StateClosed {
+		s.pendingConnsMu.Lock()
+		delete(s.pendingConns, connKey)
+		s.pendingConnsMu.Unlock()
+	}
+	return true
+}
+
+// parseICMPError returns the original datagram of an ICMP error quoting a
+// TCP segment, and the segment's sequence number.
+func parseICMPError(msg *icmp.Message) (*icmp.Original, uint32, error) {
+	if msg.Err() == nil {
+		return nil, 0, fmt.Errorf("%s message reports no error", msg.Type)
+	}
+	orig, err := msg.Original()
+	if err != nil {
+		return nil, 0, err
+	}
+	if orig.Protocol != common.ProtocolTCP {
+		return nil, 0, fmt.Errorf("original datagram is not TCP")
+	}
+	return orig, binary.BigEndian.Uint32(orig.Payload[4:8]), nil
+}
+
+// handleICMPError handles an ICMP error about the segment with sequence
+// number seq. Errors quoting a segment that is not outstanding are ignored,
+// so that an attacker must guess the sequence space (RFC 5927 Section
+// 4.1).
+//
+// Fragmentation Needed lowers the MSS to the path MTU (RFC 1191) and
+// resends the segment. Hard errors abort a connection in the handshake;
+// other errors, and hard errors once the connection is established, are
+// recorded for Err and reported if the connection times out (RFC 1122
+// Section 4.2.3.9).
+func (c *Connection) handleICMPError(msg *icmp.Message, seq uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if seqBefore(seq, c.sndUna) || !seqBefore(seq, c.sndNxt) {
+		logger.Debug("ignoring ICMP error for a segment not in flight", c.logID(), logging.F("seq", seq))
+		return
+	}
+
+	if msg.Type == icmp.TypeDestinationUnreachable && msg.Code == icmp.CodeFragmentationNeeded {
+		c.updatePathMTU(msg.NextHopMTU())
+		return
+	}
+
+	c.err = msg.Err()
+	logger.Debug("ICMP error", c.logID(), logging.F("err", c.err), logging.F("seq", seq))
+	if !msg.IsHardError() {
+		return
+	}
+	if state := c.state.GetState(); state == StateSynSent || state == StateSynReceived {
+		c.abort()
+	}
+}
+
+// updatePathMTU lowers the MSS to fit the path MTU and resends the first
+// outstanding segment, which was dropped for being too large.
+func (c *Connection) updatePathMTU(mtu uint16) {
+	if mtu == 0 {
+		return // Without the next-hop MTU the path MTU is not known
+	}
+	if mtu < MinPathMTU {
+		mtu = MinPathMTU
+	}
+	mss := mtu - ipv4HeaderLength - MinHeaderLength
+	if mss >= c.mss {
+		return
+	}
+	logger.Debug("path MTU lowered", c.logID(), logging.F("mtu", mtu), logging.F("mss", mss))
+	c.mss = mss
+
+	if head := c.retransmitQueue.GetFirst(); head != nil {
+		c.retransmit(head)
+		c.stats.retransmissions++
+		stackCounters.retransmissions.Add(1)
+	}
+}
+
+// abort closes the connection at once, discarding the data it has not
+// sent. Reads and writes fail with c.err from then on, if it is set.
+func (c *Connection) abort() {
+	c.aborted = true
+	c.retransmitQueue.Clear()
+	c.armRetransmitTimer(false)
+	c.stopKeepAlive()
+	c.stopPersistTimer()
+	c.stopPacingTimer()
+	c.stopLossTimers()
+	c.sendBuffer.Clear()
+	c.wakeWriters()
+
+	from := c.state.GetState()
+	c.state.SetState(StateClosed)
+	c.track(from, StateClosed)
+	if c.onClose != nil {
+		c.onClose()
+	}
+}
@@ -0,0 +1,184 @@
+package tcp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+// icmpError returns an ICMP error quoting the IP header and first 8 bytes of
+// a segment sent from src to dst, as a router would.
+func icmpError(t *testing.T, typ icmp.Type, code icmp.Code, mtu uint16, seg *Segment, src, dst common.IPv4Address) *icmp.Message {
+	t.Helper()
+	raw, err := seg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	pkt, err := ip.NewPacket(src, dst, common.ProtocolTCP, raw).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	return &icmp.Message{Type: typ, Code: code, Sequence: mtu, Data: pkt[:ip.MinHeaderLength+8]}
+}
+
+func TestICMPErrorHandshake(t *testing.T) {
+	tests := []struct {
+		name      string
+		typ       icmp.Type
+		code      icmp.Code
+		stale     bool
+		wantState State
+		wantErr   error
+	}{
+		{"port unreachable", icmp.TypeDestinationUnreachable, icmp.CodePortUnreachable, false, StateClosed, icmp.ErrPortUnreachable},
+		{"prohibited", icmp.TypeDestinationUnreachable, icmp.CodeCommunicationProhibited, false, StateClosed, icmp.ErrProhibited},
+		{"host unreachable", icmp.TypeDestinationUnreachable, icmp.CodeHostUnreachable, false, StateSynSent, icmp.ErrHostUnreachable},
+		{"TTL exceeded", icmp.TypeTimeExceeded, icmp.CodeTTLExceeded, false, StateSynSent, icmp.ErrTimeExceeded},
+		{"stale sequence number", icmp.TypeDestinationUnreachable, icmp.CodePortUnreachable, true, StateSynSent, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDemultiplexer()
+			client := newTestPeer(true, nil)
+			closed := false
+			client.conn.onClose = func() { closed = true }
+			client.conn.mu.Lock()
+			d.addConn(client.conn, nil)
+			client.conn.mu.Unlock()
+			if err := client.conn.ActiveOpen(); err != nil {
+				t.Fatalf("ActiveOpen() error = %v", err)
+			}
+
+			syn := client.out[0]
+			if tt.stale {
+				quoted := *syn
+				quoted.SequenceNumber -= 1000
+				syn = &quoted
+			}
+			msg := icmpError(t, tt.typ, tt.code, 0, syn, testClientIP, testServerIP)
+			if !d.DeliverICMPError(msg) {
+				t.Fatal("DeliverICMPError() = false, want true")
+			}
+
+			if got := client.conn.GetState(); got != tt.wantState {
+				t.Errorf("state = %s, want %s", got, tt.wantState)
+			}
+			if err := client.conn.Err(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Err() = %v, want %v", err, tt.wantErr)
+			}
+			if closed != (tt.wantState == StateClosed) {
+				t.Errorf("onClose called = %v", closed)
+			}
+		})
+	}
+}
+
+func TestICMPErrorEstablished(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	client.conn.ActiveOpen()
+	exchange(t, client, server)
+
+	// Hard errors no longer abort the connection, and errors for other
+	// connections are not delivered
+	client.conn.Send([]byte("hello"))
+	msg := icmpError(t, icmp.TypeDestinationUnreachable, icmp.CodePortUnreachable, 0, client.out[0], testClientIP, testServerIP)
+	s := NewSocket(testClientIP, 50001)
+	if s.HandleICMPError(msg) {
+		t.Error("HandleICMPError() for another port = true, want false")
+	}
+	client.conn.handleICMPError(msg, client.out[0].SequenceNumber)
+	if got := client.conn.GetState(); got != StateEstablished {
+		t.Errorf("state = %s, want %s", got, StateEstablished)
+	}
+	if err := client.conn.Err(); !errors.Is(err, icmp.ErrPortUnreachable) {
+		t.Errorf("Err() = %v, want %v", err, icmp.ErrPortUnreachable)
+	}
+}
+
+func TestPathMTU(t *testing.T) {
+	tests := []struct {
+		name    string
+		mtu     uint16
+		wantMSS uint16
+	}{
+		{"lower MTU", 1000, 960},
+		{"below minimum", 100, MinPathMTU - 40},
+		{"no MTU", 0, DefaultMSS},
+		{"higher MTU", 9000, DefaultMSS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestPeer(true, nil)
+			server := newTestPeer(false, nil)
+			client.conn.ActiveOpen()
+			exchange(t, client, server)
+
+			data := make([]byte, DefaultMSS)
+			client.conn.Send(data)
+			sent := client.out[0]
+			client.out = nil
+			msg := icmpError(t, icmp.TypeDestinationUnreachable, icmp.CodeFragmentationNeeded, tt.mtu, sent, testClientIP, testServerIP)
+			client.conn.handleICMPError(msg, sent.SequenceNumber)
+
+			if got := client.conn.Stats().MSS; got != tt.wantMSS {
+				t.Fatalf("MSS = %d, want %d", got, tt.wantMSS)
+			}
+			if tt.wantMSS == DefaultMSS {
+				if len(client.out) != 0 {
+					t.Errorf("resent %d segments, want none", len(client.out))
+				}
+				return
+			}
+
+			// The segment is resent in segments that fit the path
+			total := 0
+			for _, seg := range client.out {
+				if len(seg.Data) > int(tt.wantMSS) || seg.GSOSize != 0 {
+					t.Errorf("resent %d bytes (GSOSize %d), want at most %d", len(seg.Data), seg.GSOSize, tt.wantMSS)
+				}
+				if !seg.VerifyChecksum(testClientIP, testServerIP) {
+					t.Error("resent segment has a bad checksum")
+				}
+				total += len(seg.Data)
+			}
+			if total != len(data) {
+				t.Errorf("resent %d bytes, want %d", total, len(data))
+			}
+			deliver(t, client, server)
+			if len(server.data) != len(data) {
+				t.Errorf("server received %d bytes, want %d", len(server.data), len(data))
+			}
+		})
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	d := NewDemultiplexer()
+	syns := make(chan *Segment, 1)
+	d.SetSendFunc(func(seg *Segment, src, dst common.IPv4Address) error {
+		syns <- seg
+		return nil
+	})
+
+	s := NewSocket(testClientIP, 0)
+	done := make(chan error, 1)
+	go func() { done <- d.Connect(s, testServerIP, 9) }()
+
+	syn := <-syns
+	msg := icmpError(t, icmp.TypeDestinationUnreachable, icmp.CodePortUnreachable, 0, syn, testClientIP, testServerIP)
+	if !d.DeliverICMPError(msg) {
+		t.Fatal("DeliverICMPError() = false, want true")
+	}
+	if err := <-done; !errors.Is(err, icmp.ErrPortUnreachable) {
+		t.Errorf("Connect() error = %v, want %v", err, icmp.ErrPortUnreachable)
+	}
+	if d.DeliverICMPError(msg) {
+		t.Error("DeliverICMPError() after the connection closed = true, want false")
+	}
+}
@@ -0,0 +1,149 @@
+package tcp
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// Connections and sockets of both families are looked up in the same
+// tables, by the IPv6 form of their addresses: an IPv4 address is
+// IPv4-mapped (RFC 4291 Section 2.5.5.2), and the zero IPv4 address, the
+// wildcard, is the unspecified address. A socket bound to the wildcard is
+// so dual-stack: a listener accepts connections over IPv4 and IPv6. A
+// connection is over IPv4 if its remote address is IPv4-mapped, and over
+// IPv6 otherwise.
+
+// ipKey returns the form an IPv4 address is looked up by.
+func ipKey(addr common.IPv4Address) common.IPv6Address {
+	if addr == (common.IPv4Address{}) {
+		return common.IPv6Address{}
+	}
+	return addr.To6()
+}
+
+// ipv4Of returns the IPv4 address of an address in the form it is looked
+// up by, and whether it is one. The unspecified address is the zero IPv4
+// address.
+func ipv4Of(key common.IPv6Address) (common.IPv4Address, bool) {
+	if key.IsUnspecified() {
+		return common.IPv4Address{}, true
+	}
+	return key.To4()
+}
+
+// isIPv4 returns whether a remote address, in the form it is looked up by,
+// is reached over IPv4.
+func isIPv4(remote common.IPv6Address) bool {
+	_, ok := remote.To4()
+	return ok
+}
+
+// hostPort formats an endpoint as "192.0.2.1:80" or "[2001:db8::1]:80".
+func hostPort(addr common.IPv6Address, port uint16) string {
+	if v4, ok := ipv4Of(addr); ok {
+		return fmt.Sprintf("%s:%d", v4, port)
+	}
+	return fmt.Sprintf("[%s]:%d", addr, port)
+}
+
+// checksumFor returns the checksum of seg sent from local to remote, with
+// the pseudo-header of the family of remote.
+func checksumFor(seg *Segment, local, remote common.IPv6Address) (uint16, error) {
+	if remote4, ok := remote.To4(); ok {
+		local4, _ := ipv4Of(local)
+		return seg.CalculateChecksum(local4, remote4)
+	}
+	return seg.CalculateChecksumIPv6(local, remote)
+}
+
+// sendFor sends a segment from local to remote with the send function of
+// the family of remote, if it is set.
+func sendFor(seg *Segment, local, remote common.IPv6Address,
+	sendFunc func(*Segment, common.IPv4Address, common.IPv4Address) error,
+	sendFuncIPv6 func(*Segment, common.IPv6Address, common.IPv6Address) error) error {
+	if remote4, ok := remote.To4(); ok {
+		if sendFunc == nil {
+			return nil
+		}
+		local4, _ := ipv4Of(local)
+		return sendFunc(seg, local4, remote4)
+	}
+	if sendFuncIPv6 == nil {
+		return nil
+	}
+	return sendFuncIPv6(seg, local, remote)
+}
+
+// newConnectionFor creates a connection between two addresses in the form
+// they are looked up by, over the family of the remote address.
+func newConnectionFor(local common.IPv6Address, localPort uint16, remote common.IPv6Address, remotePort uint16) *Connection {
+	if remote4, ok := remote.To4(); ok {
+		local4, _ := ipv4Of(local)
+		return NewConnection(local4, localPort, remote4, remotePort)
+	}
+	return NewConnectionIPv6(local, localPort, remote, remotePort)
+}
+
+// NewConnectionIPv6 creates a new TCP connection over IPv6. LocalAddr and
+// RemoteAddr are left zero.
+func NewConnectionIPv6(localAddr common.IPv6Address, localPort uint16, remoteAddr common.IPv6Address, remotePort uint16) *Connection {
+	conn := NewConnection(common.IPv4Address{}, localPort, common.IPv4Address{}, remotePort)
+	conn.LocalAddr6 = localAddr
+	conn.RemoteAddr6 = remoteAddr
+	conn.mss = DefaultMSSIPv6
+	return conn
+}
+
+// IsIPv6 returns true if the connection is over IPv6, between LocalAddr6
+// and RemoteAddr6.
+func (c *Connection) IsIPv6() bool {
+	return !c.RemoteAddr6.IsUnspecified()
+}
+
+// localKey returns the local address in the form it is looked up by.
+func (c *Connection) localKey() common.IPv6Address {
+	if c.IsIPv6() {
+		return c.LocalAddr6
+	}
+	return ipKey(c.LocalAddr)
+}
+
+// remoteKey returns the remote address in the form it is looked up by.
+func (c *Connection) remoteKey() common.IPv6Address {
+	if c.IsIPv6() {
+		return c.RemoteAddr6
+	}
+	return ipKey(c.RemoteAddr)
+}
+
+// tuple returns the connection's 4-tuple.
+func (c *Connection) tuple() fourTuple {
+	return fourTuple{c.localKey(), c.LocalPort, c.remoteKey(), c.RemotePort}
+}
+
+// remoteIP returns the remote address as a net.IP, of 4 bytes for IPv4.
+func (c *Connection) remoteIP() net.IP {
+	if c.IsIPv6() {
+		return net.IP(c.RemoteAddr6[:])
+	}
+	return net.IP(c.RemoteAddr[:])
+}
+
+// checksum returns the checksum of a segment the connection sends.
+func (c *Connection) checksum(seg *Segment) (uint16, error) {
+	if c.IsIPv6() {
+		return seg.CalculateChecksumIPv6(c.LocalAddr6, c.RemoteAddr6)
+	}
+	return seg.CalculateChecksum(c.LocalAddr, c.RemoteAddr)
+}
+
+// verifyChecksum verifies the checksum of a segment the connection
+// received.
+func (c *Connection) verifyChecksum(seg *Segment) bool {
+	if c.IsIPv6() {
+		return seg.VerifyChecksumIPv6(c.RemoteAddr6, c.LocalAddr6)
+	}
+	return seg.VerifyChecksum(c.RemoteAddr, c.LocalAddr)
+}
@@ -0,0 +1,162 @@
+package tcp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+var (
+	testClientIP6 = common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 1}
+	testServerIP6 = common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 2}
+)
+
+func TestSegmentChecksumIPv6(t *testing.T) {
+	seg := NewSegment(12345, 80, 1000, 2000, FlagACK, 65535, []byte("Test data"))
+
+	checksum, err := seg.CalculateChecksumIPv6(testClientIP6, testServerIP6)
+	if err != nil {
+		t.Fatalf("CalculateChecksumIPv6() error = %v", err)
+	}
+
+	// The sum over the RFC 8200 pseudo-header and the segment
+	raw, err := seg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	raw[16], raw[17] = 0, 0
+	var c common.Checksummer
+	c.AddPseudoHeaderIPv6(common.PseudoHeaderIPv6{
+		SourceAddr:      testClientIP6,
+		DestinationAddr: testServerIP6,
+		Length:          uint32(len(raw)),
+		NextHeader:      common.ProtocolTCP,
+	})
+	c.Add(raw)
+	if want := c.Sum(); checksum != want {
+		t.Errorf("CalculateChecksumIPv6() = %#04x, want %#04x", checksum, want)
+	}
+
+	seg.Checksum = checksum
+	if !seg.VerifyChecksumIPv6(testClientIP6, testServerIP6) {
+		t.Error("VerifyChecksumIPv6() = false, want true")
+	}
+	if seg.VerifyChecksum(testClientIP, testServerIP) {
+		t.Error("VerifyChecksum() = true with the IPv4 pseudo-header, want false")
+	}
+}
+
+func TestDemultiplexerDualStack(t *testing.T) {
+	client := NewDemultiplexer()
+	server := NewDemultiplexer()
+	defer linkDemuxes(t, client, server)()
+
+	// A listener on the wildcard address accepts both families
+	listener := NewSocket(common.IPv4Address{}, 8080)
+	listener.SetReuseAddr(true)
+	if err := server.Listen(listener, 4); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	sock6 := NewSocketIPv6(testClientIP6, 0)
+	if err := client.ConnectIPv6(sock6, testServerIP6, 8080); err != nil {
+		t.Fatalf("ConnectIPv6() error = %v", err)
+	}
+	defer sock6.Close()
+	sock4 := NewSocket(testClientIP, 0)
+	if err := client.Connect(sock4, testServerIP, 8080); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer sock4.Close()
+
+	tests := []struct {
+		name       string
+		sock       *Socket
+		wantRemote string
+	}{
+		{"IPv6", sock6, "[2001:db8::1]:"},
+		{"IPv4", sock4, "10.0.0.1:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accepted, err := listener.Accept()
+			if err != nil {
+				t.Fatalf("Accept() error = %v", err)
+			}
+			defer accepted.Close()
+
+			if got := NewNetConn(accepted).RemoteAddr().String(); !strings.HasPrefix(got, tt.wantRemote) {
+				t.Errorf("RemoteAddr() = %s, want %s<port>", got, tt.wantRemote)
+			}
+			if accepted.GetRemotePort() != tt.sock.GetLocalPort() {
+				t.Errorf("accepted port %d, want %d", accepted.GetRemotePort(), tt.sock.GetLocalPort())
+			}
+
+			if _, err := tt.sock.Send([]byte("ping")); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			buf := make([]byte, 16)
+			n, err := accepted.RecvTimeout(buf, time.Second)
+			if err != nil || string(buf[:n]) != "ping" {
+				t.Errorf("Recv() = %q, %v; want %q", buf[:n], err, "ping")
+			}
+		})
+	}
+
+	var found bool
+	for _, info := range Connections() {
+		if info.RemoteAddr6 == testClientIP6 && info.LocalPort == 8080 {
+			found = true
+			if info.LocalAddr6 != testServerIP6 || info.LocalAddr != (common.IPv4Address{}) {
+				t.Errorf("ConnInfo = %+v, want the IPv6 addresses only", info)
+			}
+		}
+	}
+	if !found {
+		t.Error("Connections() does not list the IPv6 connection")
+	}
+}
+
+func TestConnectAddressFamily(t *testing.T) {
+	sock := NewSocketIPv6(testClientIP6, 50000)
+	if err := sock.Connect(testServerIP, 80); err == nil || !strings.Contains(err.Error(), "address family mismatch") {
+		t.Errorf("Connect() error = %v, want an address family mismatch", err)
+	}
+	if sock.GetState() != StateClosed {
+		t.Errorf("state = %v, want %v", sock.GetState(), StateClosed)
+	}
+}
+
+func TestTimeWaitIPv6(t *testing.T) {
+	table := newTestTimeWait(t, TimeWaitConfig{})
+	var out []*Segment
+	conn := NewConnectionIPv6(testServerIP6, 80, testClientIP6, 50000)
+	conn.sndNxt, conn.rcvNxt = 5000, 1000
+	conn.onSegmentReady = func(seg *Segment) error {
+		out = append(out, seg)
+		return nil
+	}
+	table.add(conn)
+
+	if !table.ContainsIPv6(testServerIP6, 80, testClientIP6, 50000) {
+		t.Error("ContainsIPv6() = false, want true")
+	}
+	if table.Contains(testServerIP, 80, testClientIP, 50000) {
+		t.Error("Contains() = true for the IPv4 4-tuple, want false")
+	}
+
+	// A retransmitted FIN is acknowledged with an IPv6 checksum
+	fin := NewSegment(50000, 80, 999, 5000, FlagFIN|FlagACK, 65535, nil)
+	if handled, err := table.HandleSegmentIPv6(fin, testClientIP6, testServerIP6); !handled || err != nil {
+		t.Fatalf("HandleSegmentIPv6(FIN) = %v, %v; want true, nil", handled, err)
+	}
+	if len(out) != 1 {
+		t.Fatalf("sent %d segments, want 1", len(out))
+	}
+	if !out[0].VerifyChecksumIPv6(testServerIP6, testClientIP6) {
+		t.Error("ACK checksum does not verify over IPv6")
+	}
+}
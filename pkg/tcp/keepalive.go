@@ -0,0 +1,70 @@
+package tcp
+
+import (
+	"errors"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+// ErrConnectionTimedOut is the error of a connection aborted because its
+// peer stopped answering keepalive probes.
+var ErrConnectionTimedOut = errors.New("connection timed out")
+
+// armKeepAlive restarts the keepalive timer for a connection that has
+// just heard from its peer, or stops it if keepalives are off.
+func (c *Connection) armKeepAlive() {
+	c.keepAliveProbes = 0
+	if !c.opts.keepAlive {
+		if c.keepAliveTimer != nil {
+			c.keepAliveTimer.Stop()
+		}
+		return
+	}
+
+	if c.keepAliveTimer == nil {
+		c.keepAliveTimer = c.timers.AfterFunc(c.opts.keepAliveIdle, c.keepAliveTimeout)
+	} else {
+		c.keepAliveTimer.Reset(c.opts.keepAliveIdle)
+	}
+}
+
+// stopKeepAlive stops the keepalive timer of a connection that is
+// closing.
+func (c *Connection) stopKeepAlive() {
+	if c.keepAliveTimer != nil {
+		c.keepAliveTimer.Stop()
+	}
+}
+
+// keepAliveTimeout sends a keepalive probe when the connection has been
+// idle for the keepalive time or a probe went unanswered, and aborts the
+// connection once the probes run out (RFC 1122 Section 4.2.3.6). A probe
+// is an ACK one byte before sndUna, which the peer can only answer with an
+// ACK of its own.
+func (c *Connection) keepAliveTimeout() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if state := c.state.GetState(); !c.opts.keepAlive || (state != StateEstablished && state != StateCloseWait) {
+		return
+	}
+	if c.retransmitQueue.Len() > 0 {
+		// The retransmission timer finds out whether the peer is there
+		c.keepAliveTimer.Reset(c.opts.keepAliveIdle)
+		return
+	}
+	if c.keepAliveProbes >= c.opts.keepAliveCount {
+		logger.Debug("keepalive timeout", c.logID(), logging.F("probes", c.keepAliveProbes))
+		c.err = ErrConnectionTimedOut
+		c.abort()
+		return
+	}
+
+	probe := NewSegment(c.LocalPort, c.RemotePort, c.sndUna-1, c.rcvNxt, FlagACK, c.rcvWnd, nil)
+	probe.Checksum, _ = c.checksum(probe)
+	if c.onSegmentReady != nil {
+		c.transmit(probe)
+	}
+	c.keepAliveProbes++
+	c.keepAliveTimer.Reset(c.opts.keepAliveInterval)
+}
@@ -0,0 +1,225 @@
+package tcp
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// MaxMD5KeyLength is the longest key SetMD5Key accepts, as with Linux's
+// TCP_MD5SIG.
+const MaxMD5KeyLength = 80
+
+// md5OptionLength is the length of the MD5 signature option: kind, length
+// and the 16-byte digest.
+const md5OptionLength = 2 + md5.Size
+
+// SetMD5Key sets the key of the TCP MD5 signature option (RFC 2385) for
+// segments exchanged with peer, as BGP sessions use it. A connection with
+// a key signs every segment it sends and drops every segment it receives
+// that is unsigned or signed with another key; one without a key drops
+// signed segments. A listening socket takes keys for each peer it accepts
+// connections from. An empty key removes the peer's key.
+//
+// Setting the key of a connected socket's peer changes the key of its
+// connection, which goes on only if the peer changes its key too.
+// Connections with a key do not use GSO, as each wire segment is signed.
+func (s *Socket) SetMD5Key(peer common.IPv4Address, key []byte) error {
+	return s.setMD5Key(ipKey(peer), key)
+}
+
+// SetMD5KeyIPv6 is SetMD5Key for a peer reached over IPv6.
+func (s *Socket) SetMD5KeyIPv6(peer common.IPv6Address, key []byte) error {
+	return s.setMD5Key(peer, key)
+}
+
+func (s *Socket) setMD5Key(peer common.IPv6Address, key []byte) error {
+	if len(key) > MaxMD5KeyLength {
+		return fmt.Errorf("MD5 key too long: %d bytes (maximum %d)", len(key), MaxMD5KeyLength)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(key) > 0 && s.aoKeys[peer] != nil {
+		return fmt.Errorf("peer %s has TCP-AO keys", hostPort(peer, 0))
+	}
+	if len(key) == 0 {
+		delete(s.md5Keys, peer)
+		key = nil
+	} else {
+		if s.md5Keys == nil {
+			s.md5Keys = make(map[common.IPv6Address][]byte)
+		}
+		key = append([]byte(nil), key...)
+		s.md5Keys[peer] = key
+	}
+
+	if s.conn != nil && s.remoteAddr == peer {
+		s.conn.mu.Lock()
+		s.conn.setMD5Key(key)
+		s.conn.mu.Unlock()
+	}
+	return nil
+}
+
+// setMD5Key sets the connection's MD5 signature key, or removes it if key
+// is nil. Called with c.mu held, or before the connection is shared.
+func (c *Connection) setMD5Key(key []byte) {
+	c.md5Key = key
+	if key != nil {
+		c.gso = false
+	}
+}
+
+// signMD5 signs a segment the connection sends, if it has a key.
+func (c *Connection) signMD5(seg *Segment) error {
+	if c.md5Key == nil {
+		return nil
+	}
+	if err := signMD5(seg, c.localKey(), c.remoteKey(), c.md5Key); err != nil {
+		return err
+	}
+	seg.Checksum = 0
+	checksum, err := c.checksum(seg)
+	if err != nil {
+		return err
+	}
+	seg.Checksum = checksum
+	return nil
+}
+
+// verifyMD5 checks the signature of a segment the connection received.
+func (c *Connection) verifyMD5(seg *Segment) bool {
+	return verifyMD5(seg, c.remoteKey(), c.localKey(), c.md5Key)
+}
+
+// signMD5 adds an MD5 signature option to seg, sent from src to dst,
+// replacing any it has, as a retransmitted segment does. The checksum is
+// left for the caller to compute.
+func signMD5(seg *Segment, src, dst common.IPv6Address, key []byte) error {
+	opts := stripOption(seg.Options, OptionKindMD5)
+	if MinHeaderLength+len(opts)+md5OptionLength > MaxHeaderLength {
+		return fmt.Errorf("no room for the MD5 signature option: %d bytes of options", len(opts))
+	}
+	opts = append(opts, OptionKindMD5, md5OptionLength)
+	seg.Options = append(opts, make([]byte, md5.Size)...)
+	digest := md5Digest(seg, src, dst, key)
+	copy(seg.Options[len(seg.Options)-md5.Size:], digest[:])
+	return nil
+}
+
+// verifyMD5 reports whether seg, received from src by dst, carries the
+// signature key calls for: a valid one if key is set, and none if not.
+func verifyMD5(seg *Segment, src, dst common.IPv6Address, key []byte) bool {
+	sig, ok := md5Option(seg.Options)
+	if key == nil || !ok {
+		return key == nil && !ok
+	}
+	digest := md5Digest(seg, src, dst, key)
+	return subtle.ConstantTimeCompare(sig, digest[:]) == 1
+}
+
+// md5Digest computes the MD5 signature of seg, sent from src to dst, over
+// the pseudo-header, the header without options and with a zero checksum,
+// the data and the key (RFC 2385 Section 2.0). The IPv6 pseudo-header is
+// used for IPv6, as in Linux.
+func md5Digest(seg *Segment, src, dst common.IPv6Address, key []byte) [md5.Size]byte {
+	headerLength := MinHeaderLength + (len(seg.Options)+3)/4*4
+	b := appendPseudoHeader(make([]byte, 0, 40+MinHeaderLength), src, dst, headerLength+len(seg.Data))
+	b = appendHeader(b, seg, headerLength)
+
+	h := md5.New()
+	h.Write(b)
+	h.Write(seg.Data)
+	h.Write(key)
+	var digest [md5.Size]byte
+	h.Sum(digest[:0])
+	return digest
+}
+
+// appendPseudoHeader appends the pseudo-header of a segment of length
+// bytes sent from src to dst, of the family of dst, as the checksum covers
+// it.
+func appendPseudoHeader(b []byte, src, dst common.IPv6Address, length int) []byte {
+	if dst4, ok := dst.To4(); ok {
+		src4, _ := ipv4Of(src)
+		b = append(append(b, src4[:]...), dst4[:]...)
+		b = append(b, 0, byte(common.ProtocolTCP))
+		return binary.BigEndian.AppendUint16(b, uint16(length))
+	}
+	b = append(append(b, src[:]...), dst[:]...)
+	b = binary.BigEndian.AppendUint32(b, uint32(length))
+	return append(b, 0, 0, 0, byte(common.ProtocolTCP))
+}
+
+// appendHeader appends the fixed header of seg with a zero checksum, for a
+// header of headerLength bytes with the options.
+func appendHeader(b []byte, seg *Segment, headerLength int) []byte {
+	b = binary.BigEndian.AppendUint16(b, seg.SourcePort)
+	b = binary.BigEndian.AppendUint16(b, seg.DestinationPort)
+	b = binary.BigEndian.AppendUint32(b, seg.SequenceNumber)
+	b = binary.BigEndian.AppendUint32(b, seg.AckNumber)
+	b = append(b, uint8(headerLength/4)<<4, seg.Flags)
+	b = binary.BigEndian.AppendUint16(b, seg.WindowSize)
+	b = append(b, 0, 0)
+	return binary.BigEndian.AppendUint16(b, seg.UrgentPointer)
+}
+
+// md5Option returns the digest of the MD5 signature option in opts.
+func md5Option(opts []byte) ([]byte, bool) {
+	i, ok := findOption(opts, OptionKindMD5)
+	if !ok || opts[i+1] != md5OptionLength {
+		return nil, false
+	}
+	return opts[i+2 : i+md5OptionLength], true
+}
+
+// findOption returns the offset in opts of the first option of kind.
+func findOption(opts []byte, kind uint8) (int, bool) {
+	for i := 0; i < len(opts); {
+		switch opts[i] {
+		case OptionKindEOL:
+			return 0, false
+		case OptionKindNOP:
+			i++
+			continue
+		}
+		if i+1 >= len(opts) || opts[i+1] < 2 || i+int(opts[i+1]) > len(opts) {
+			return 0, false
+		}
+		if opts[i] == kind {
+			return i, true
+		}
+		i += int(opts[i+1])
+	}
+	return 0, false
+}
+
+// stripOption returns a copy of opts without the option of kind, and
+// without the padding after the end of the list.
+func stripOption(opts []byte, kind uint8) []byte {
+	stripped := make([]byte, 0, len(opts)+md5OptionLength)
+	for i := 0; i < len(opts); {
+		switch opts[i] {
+		case OptionKindEOL:
+			return stripped
+		case OptionKindNOP:
+			stripped = append(stripped, OptionKindNOP)
+			i++
+			continue
+		}
+		if i+1 >= len(opts) || opts[i+1] < 2 || i+int(opts[i+1]) > len(opts) {
+			return append(stripped, opts[i:]...)
+		}
+		length := int(opts[i+1])
+		if opts[i] != kind {
+			stripped = append(stripped, opts[i:i+length]...)
+		}
+		i += length
+	}
+	return stripped
+}
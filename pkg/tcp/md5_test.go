@@ -0,0 +1,275 @@
+package tcp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// signedPeers returns a client and server test peer with the MD5 keys
+// given, before the handshake.
+func signedPeers(clientKey, serverKey string) (*testPeer, *testPeer) {
+	client, server := newTestPeer(true, nil), newTestPeer(false, nil)
+	if clientKey != "" {
+		client.conn.setMD5Key([]byte(clientKey))
+	}
+	if serverKey != "" {
+		server.conn.setMD5Key([]byte(serverKey))
+	}
+	return client, server
+}
+
+func TestMD5Signature(t *testing.T) {
+	src, dst := ipKey(testClientIP), ipKey(testServerIP)
+	src6, dst6 := common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 1}, common.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 2}
+	key := []byte("secret")
+
+	for _, addrs := range [][2]common.IPv6Address{{src, dst}, {src6, dst6}} {
+		seg := NewSegment(179, 50000, 1000, 2000, FlagACK|FlagPSH, 65535, []byte("update"))
+		seg.Options = BuildMSSOption(1460)
+		if err := signMD5(seg, addrs[0], addrs[1], key); err != nil {
+			t.Fatalf("signMD5() error = %v", err)
+		}
+		if _, ok := md5Option(seg.Options); !ok || !bytes.HasPrefix(seg.Options, BuildMSSOption(1460)) {
+			t.Fatalf("options = %x, want the MSS and MD5 options", seg.Options)
+		}
+		if !verifyMD5(seg, addrs[0], addrs[1], key) {
+			t.Error("verifyMD5() = false for the signed segment")
+		}
+
+		// Signing again, as a retransmission does, replaces the option
+		n := len(seg.Options)
+		if err := signMD5(seg, addrs[0], addrs[1], key); err != nil || len(seg.Options) != n {
+			t.Errorf("signing again: %d bytes of options, %v; want %d", len(seg.Options), err, n)
+		}
+
+		tampered := *seg
+		tampered.Data = []byte("Update")
+		moved := *seg
+		moved.SequenceNumber++
+		tests := []struct {
+			name string
+			seg  *Segment
+			src  common.IPv6Address
+			key  []byte
+		}{
+			{"wrong key", seg, addrs[0], []byte("other")},
+			{"no key", seg, addrs[0], nil},
+			{"changed data", &tampered, addrs[0], key},
+			{"changed header", &moved, addrs[0], key},
+			{"other source", seg, addrs[1], key},
+			{"unsigned", NewSegment(179, 50000, 1000, 2000, FlagACK, 65535, nil), addrs[0], key},
+		}
+		for _, tt := range tests {
+			if verifyMD5(tt.seg, tt.src, addrs[1], tt.key) {
+				t.Errorf("%s: verifyMD5() = true, want false", tt.name)
+			}
+		}
+	}
+}
+
+func TestStripOption(t *testing.T) {
+	md5Opt := append([]byte{OptionKindMD5, md5OptionLength}, make([]byte, 16)...)
+	tests := []struct {
+		name string
+		opts []byte
+		want []byte
+	}{
+		{"none", nil, nil},
+		{"only", md5Opt, nil},
+		{"between", append(append(BuildMSSOption(1460), md5Opt...), OptionKindNOP, OptionKindNOP),
+			append(BuildMSSOption(1460), OptionKindNOP, OptionKindNOP)},
+		{"padding", append(BuildWindowScaleOption(7), OptionKindEOL), BuildWindowScaleOption(7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripOption(tt.opts, OptionKindMD5); !bytes.Equal(got, tt.want) {
+				t.Errorf("stripOption() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMD5Connection(t *testing.T) {
+	client, server := signedPeers("secret", "secret")
+	client.conn.gso = true
+	client.conn.setMD5Key([]byte("secret"))
+	if client.conn.gso {
+		t.Error("GSO still enabled with an MD5 key")
+	}
+	var sent []*Segment
+	run := func() {
+		for len(client.out)+len(server.out) > 0 {
+			sent = appendClones(sent, client.out)
+			deliver(t, client, server)
+			sent = appendClones(sent, server.out)
+			deliver(t, server, client)
+		}
+	}
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	run()
+	if err := client.conn.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	run()
+	if string(server.data) != "hello" {
+		t.Fatalf("server received %q, want %q", server.data, "hello")
+	}
+	for _, seg := range sent {
+		if _, ok := md5Option(seg.Options); !ok {
+			t.Errorf("segment %v not signed", seg)
+		}
+	}
+
+	// An unsigned RST, as an attacker would forge it, is dropped
+	rst := NewSegment(50000, 80, server.conn.rcvNxt, 0, FlagRST, 0, nil)
+	rst.Checksum, _ = rst.CalculateChecksum(testClientIP, testServerIP)
+	if err := server.conn.HandleSegment(rst); err == nil {
+		t.Error("HandleSegment() of an unsigned RST succeeded, want error")
+	}
+	if got := server.conn.GetState(); got != StateEstablished {
+		t.Errorf("state after an unsigned RST = %s, want ESTABLISHED", got)
+	}
+	if got := server.conn.Stats().MD5Failures; got != 1 {
+		t.Errorf("MD5Failures = %d, want 1", got)
+	}
+}
+
+func TestMD5Mismatch(t *testing.T) {
+	tests := []struct {
+		name                 string
+		clientKey, serverKey string
+	}{
+		{"wrong key", "secret", "other"},
+		{"unsigned", "", "secret"},
+		{"unexpected signature", "secret", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := signedPeers(tt.clientKey, tt.serverKey)
+			if err := client.conn.ActiveOpen(); err != nil {
+				t.Fatalf("ActiveOpen() error = %v", err)
+			}
+			if err := server.conn.HandleSegment(client.out[0]); err == nil {
+				t.Error("HandleSegment() of the SYN succeeded, want error")
+			}
+			if len(server.out) != 0 || server.conn.GetState() != StateListen {
+				t.Errorf("server sent %v in state %s, want nothing in LISTEN", server.out, server.conn.GetState())
+			}
+		})
+	}
+}
+
+func TestMD5Listener(t *testing.T) {
+	var sent []*Segment
+	listener := NewSocket(testServerIP, 179)
+	listener.SetSendFunc(func(seg *Segment, src, dst common.IPv4Address) error {
+		sent = append(sent, seg)
+		return nil
+	})
+	if err := listener.SetMD5Key(testClientIP, make([]byte, MaxMD5KeyLength+1)); err == nil {
+		t.Error("SetMD5Key() with a long key succeeded, want error")
+	}
+	if err := listener.SetMD5Key(testClientIP, []byte("secret")); err != nil {
+		t.Fatalf("SetMD5Key() error = %v", err)
+	}
+	if err := listener.Listen(1); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	// An unsigned SYN from the peer with a key is not answered; other
+	// peers need no signature
+	syn := NewSegment(50000, 179, 1000, 0, FlagSYN, 65535, nil)
+	syn.Checksum, _ = syn.CalculateChecksum(testClientIP, testServerIP)
+	listener.HandleIncomingSegment(syn, testClientIP, testServerIP)
+	if len(sent) != 0 {
+		t.Fatalf("sent %v to an unsigned SYN, want nothing", sent)
+	}
+	other := common.IPv4Address{10, 0, 0, 3}
+	syn = NewSegment(50000, 179, 1000, 0, FlagSYN, 65535, nil)
+	syn.Checksum, _ = syn.CalculateChecksum(other, testServerIP)
+	if err := listener.HandleIncomingSegment(syn, other, testServerIP); err != nil {
+		t.Fatalf("HandleIncomingSegment() error = %v", err)
+	}
+	if len(sent) != 1 || !verifyMD5(sent[0], ipKey(testServerIP), ipKey(other), nil) {
+		t.Fatalf("sent %v to a peer without a key, want an unsigned SYN-ACK", sent)
+	}
+	sent = nil
+
+	// The peer with the key is answered with a signed SYN-ACK
+	syn = NewSegment(50000, 179, 1000, 0, FlagSYN, 65535, nil)
+	if err := signMD5(syn, ipKey(testClientIP), ipKey(testServerIP), []byte("secret")); err != nil {
+		t.Fatalf("signMD5() error = %v", err)
+	}
+	syn.Checksum, _ = syn.CalculateChecksum(testClientIP, testServerIP)
+	if err := listener.HandleIncomingSegment(syn, testClientIP, testServerIP); err != nil {
+		t.Fatalf("HandleIncomingSegment() error = %v", err)
+	}
+	if len(sent) != 1 || sent[0].Flags != FlagSYN|FlagACK {
+		t.Fatalf("sent %v, want a SYN-ACK", sent)
+	}
+	if !verifyMD5(sent[0], ipKey(testServerIP), ipKey(testClientIP), []byte("secret")) {
+		t.Error("SYN-ACK not signed with the key")
+	}
+}
+
+func TestMD5TimeWait(t *testing.T) {
+	client, server := signedPeers("secret", "secret")
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+	tw := newTestTimeWait(t, TimeWaitConfig{})
+	client.conn.timeWait = tw
+	client.conn.Close()
+	exchange(t, client, server)
+	server.conn.Close()
+	exchange(t, client, server)
+	if !tw.Contains(testClientIP, 50000, testServerIP, 80) {
+		t.Fatal("client not in TIME_WAIT")
+	}
+
+	// The FIN again, signed and unsigned: only the signed one is answered
+	fin := NewSegment(80, 50000, server.conn.sndNxt-1, server.conn.rcvNxt, FlagFIN|FlagACK, 65535, nil)
+	fin.Checksum, _ = fin.CalculateChecksum(testServerIP, testClientIP)
+	if handled, err := tw.HandleSegment(fin, testServerIP, testClientIP); !handled || err != nil {
+		t.Fatalf("HandleSegment() of an unsigned FIN = %v, %v", handled, err)
+	}
+	if len(client.out) != 0 {
+		t.Fatalf("answered an unsigned FIN with %v", client.out)
+	}
+	if err := signMD5(fin, ipKey(testServerIP), ipKey(testClientIP), []byte("secret")); err != nil {
+		t.Fatalf("signMD5() error = %v", err)
+	}
+	fin.Checksum = 0
+	fin.Checksum, _ = fin.CalculateChecksum(testServerIP, testClientIP)
+	if handled, err := tw.HandleSegment(fin, testServerIP, testClientIP); !handled || err != nil {
+		t.Fatalf("HandleSegment() of a signed FIN = %v, %v", handled, err)
+	}
+	if len(client.out) != 1 || !verifyMD5(client.out[0], ipKey(testClientIP), ipKey(testServerIP), []byte("secret")) {
+		t.Errorf("answered the signed FIN with %v, want a signed ACK", client.out)
+	}
+}
+
+func TestMD5Export(t *testing.T) {
+	client, server := signedPeers("secret", "secret")
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	state, err := client.conn.exportState(nil)
+	if err != nil {
+		t.Fatalf("exportState() error = %v", err)
+	}
+	s, _, err := importSocket(state)
+	if err != nil {
+		t.Fatalf("importSocket() error = %v", err)
+	}
+	if string(s.conn.md5Key) != "secret" || string(s.md5Keys[ipKey(testServerIP)]) != "secret" {
+		t.Errorf("imported key = %q, want %q", s.conn.md5Key, "secret")
+	}
+}
@@ -0,0 +1,450 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ports"
+)
+
+// ErrExported is the error of a connection handed to another stack with
+// Socket.Export.
+var ErrExported = errors.New("connection exported")
+
+// migrationMagic starts the exported state of a connection, followed by
+// migrationVersion, the version of the encoding. A version changes when
+// fields are added or removed, and Import rejects versions it does not
+// know.
+const (
+	migrationMagic   = "TCPM"
+	migrationVersion = 5
+)
+
+// Flags of the exported state
+const (
+	migratePassive = 1 << iota
+	migrateGSO
+	migrateTSRecent
+	migrateNoDelay
+	migrateKeepAlive
+	migrateOOBInline
+	migrateUrgent
+	migrateSACK
+)
+
+// Export hands the socket's connection over for another stack, usually in
+// a new process, to restore with Demultiplexer.Import, as in a live
+// upgrade. It returns the state of the connection: its addresses,
+// sequence numbers, windows, timers, options and keys, the data sent
+// and not yet acknowledged, the data queued to send and the data received
+// and not yet read.
+//
+// The connection must be ESTABLISHED or CLOSE_WAIT. Export takes it out
+// of this stack without telling the peer: it is closed, without sending
+// a FIN or RST, its port is released and reads and writes on the socket
+// fail with ErrExported. Segments that arrive before the new stack takes
+// over are answered with an RST, so the interface should stop delivering
+// them to this stack first.
+func (s *Socket) Export() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.conn
+	if c == nil {
+		return nil, fmt.Errorf("socket not connected")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.state.GetState()
+	if state != StateEstablished && state != StateCloseWait {
+		return nil, fmt.Errorf("cannot export connection in state %s", state)
+	}
+
+	s.recv.mu.Lock()
+	unread := append([]byte(nil), s.recv.data...)
+	s.recv.mu.Unlock()
+
+	data, err := c.exportState(unread)
+	if err != nil {
+		return nil, err
+	}
+
+	c.err = ErrExported
+	c.abort()
+	s.recv.shutdown()
+	return data, nil
+}
+
+// exportState encodes the state of the connection, with the data received
+// and not yet read. Called with c.mu held.
+func (c *Connection) exportState(unread []byte) ([]byte, error) {
+	b := append([]byte(migrationMagic), migrationVersion)
+
+	var flags byte
+	if c.passive {
+		flags |= migratePassive
+	}
+	if c.gso {
+		flags |= migrateGSO
+	}
+	if c.hasTSRecent {
+		flags |= migrateTSRecent
+	}
+	if c.opts.noDelay {
+		flags |= migrateNoDelay
+	}
+	if c.opts.keepAlive {
+		flags |= migrateKeepAlive
+	}
+	if c.opts.oobInline {
+		flags |= migrateOOBInline
+	}
+	if c.sndUrgent {
+		flags |= migrateUrgent
+	}
+	if c.sackOK {
+		flags |= migrateSACK
+	}
+	b = append(b, byte(c.state.GetState()), flags)
+
+	local, remote := c.localKey(), c.remoteKey()
+	b = append(b, local[:]...)
+	b = binary.BigEndian.AppendUint16(b, c.LocalPort)
+	b = append(b, remote[:]...)
+	b = binary.BigEndian.AppendUint16(b, c.RemotePort)
+
+	// Sequence space and windows
+	for _, v := range []uint32{c.iss, c.sndUna, c.sndNxt, c.irs, c.rcvNxt} {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	b = binary.BigEndian.AppendUint16(b, c.sndWnd)
+	b = binary.BigEndian.AppendUint16(b, c.rcvWnd)
+	b = binary.BigEndian.AppendUint16(b, c.mss)
+	b = append(b, c.windowScale)
+	b = binary.BigEndian.AppendUint32(b, c.tsRecent)
+	b = binary.BigEndian.AppendUint32(b, c.sndUp)
+
+	// Retransmission and congestion control
+	for _, d := range []time.Duration{c.rto, c.srtt, c.rttvar} {
+		b = binary.BigEndian.AppendUint64(b, uint64(d))
+	}
+	b = binary.BigEndian.AppendUint32(b, c.cwnd)
+	b = binary.BigEndian.AppendUint32(b, c.ssthresh)
+
+	// Socket options
+	b = binary.BigEndian.AppendUint32(b, uint32(c.opts.sendBuffer))
+	b = binary.BigEndian.AppendUint32(b, uint32(c.opts.receiveBuffer))
+	b = append(b, c.opts.ttl, c.opts.tos)
+	for _, d := range []time.Duration{c.opts.linger, c.opts.keepAliveIdle, c.opts.keepAliveInterval} {
+		b = binary.BigEndian.AppendUint64(b, uint64(d))
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(c.opts.keepAliveCount))
+	b = binary.BigEndian.AppendUint64(b, uint64(c.opts.maxPacingRate))
+	b = appendBlob(b, []byte(c.opts.device))
+	b = appendBlob(b, c.md5Key)
+	b = appendAO(b, c.ao)
+
+	// Data: outstanding segments, then queued and unread data
+	entries := c.retransmitQueue.Entries()
+	b = binary.BigEndian.AppendUint32(b, uint32(len(entries)))
+	for _, e := range entries {
+		seg, err := e.Segment.Serialize()
+		if err != nil {
+			return nil, fmt.Errorf("failed to export segment %d: %w", e.SeqNum, err)
+		}
+		b = binary.BigEndian.AppendUint32(b, uint32(e.RetryCount))
+		b = appendBlob(b, seg)
+	}
+	c.sendBuffer.mu.Lock()
+	b = appendBlob(b, c.sendBuffer.buffer)
+	c.sendBuffer.mu.Unlock()
+	return appendBlob(b, unread), nil
+}
+
+// appendAO encodes TCP-AO state: the key chain, empty without TCP-AO, then
+// the current key, RNextKeyID and the SNEs.
+func appendAO(b []byte, a *aoConn) []byte {
+	if a == nil {
+		return binary.BigEndian.AppendUint16(b, 0)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(a.keys)))
+	for _, k := range a.keys {
+		var exclude byte
+		if k.ExcludeOptions {
+			exclude = 1
+		}
+		b = append(b, k.SendID, k.RecvID, byte(k.Algorithm), exclude)
+		b = appendBlob(b, k.Key)
+	}
+	b = append(b, a.current.SendID, a.rnext)
+	for _, s := range []sne{a.sndSNE, a.rcvSNE} {
+		var started byte
+		if s.started {
+			started = 1
+		}
+		b = binary.BigEndian.AppendUint32(b, s.high)
+		b = binary.BigEndian.AppendUint32(b, s.seq)
+		b = append(b, started)
+	}
+	return b
+}
+
+func appendBlob(b, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// Import restores a connection exported with Socket.Export and adds it,
+// binding its local port. The connection goes on where the exporting
+// stack left it: outstanding segments are retransmitted when the
+// retransmission timer expires, queued data is sent, and data there was
+// no time to read is returned by the socket's first reads.
+func (d *Demultiplexer) Import(data []byte) (*Socket, error) {
+	s, state, err := importSocket(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import connection: %w", err)
+	}
+	c := s.conn
+
+	binding, err := d.ports.Bind(bindAddr(s.localAddr), s.localPort, ports.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to import connection: %w", err)
+	}
+	d.mu.RLock()
+	s.sendFunc, s.sendFuncIPv6 = d.sendFunc, d.sendFuncIPv6
+	d.mu.RUnlock()
+	s.demux = d
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := d.addConn(c, binding); err != nil {
+		binding.Release()
+		return nil, fmt.Errorf("failed to import connection: %w", err)
+	}
+	c.state.SetState(state)
+	c.track(StateClosed, state)
+
+	c.armRetransmitTimer(true)
+	c.armKeepAlive()
+	if c.sendBuffer.Len() > 0 {
+		c.sendData()
+	}
+	return s, nil
+}
+
+// importSocket decodes an exported connection into a socket whose
+// connection is still CLOSED, and returns it with the state the
+// connection enters once it is added.
+func importSocket(data []byte) (*Socket, State, error) {
+	r := &migrationReader{data: data}
+	if magic := r.bytes(len(migrationMagic)); string(magic) != migrationMagic {
+		return nil, 0, fmt.Errorf("not an exported connection")
+	}
+	if v := r.uint8(); r.err == nil && v != migrationVersion {
+		return nil, 0, fmt.Errorf("unsupported version %d", v)
+	}
+
+	state, flags := State(r.uint8()), r.uint8()
+	var local, remote common.IPv6Address
+	copy(local[:], r.bytes(16))
+	localPort := r.uint16()
+	copy(remote[:], r.bytes(16))
+	remotePort := r.uint16()
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if state != StateEstablished && state != StateCloseWait {
+		return nil, 0, fmt.Errorf("invalid state %s", state)
+	}
+	if localPort == 0 || remotePort == 0 {
+		return nil, 0, fmt.Errorf("invalid port")
+	}
+
+	c := newConnectionFor(local, localPort, remote, remotePort)
+	c.passive = flags&migratePassive != 0
+	c.gso = flags&migrateGSO != 0
+	c.hasTSRecent = flags&migrateTSRecent != 0
+	c.sackOK = flags&migrateSACK != 0
+
+	c.iss, c.sndUna, c.sndNxt, c.irs, c.rcvNxt = r.uint32(), r.uint32(), r.uint32(), r.uint32(), r.uint32()
+	c.sndWnd, c.rcvWnd, c.mss = r.uint16(), r.uint16(), r.uint16()
+	c.windowScale = r.uint8()
+	c.tsRecent = r.uint32()
+	c.sndUp, c.sndUrgent = r.uint32(), flags&migrateUrgent != 0
+
+	c.rto, c.srtt, c.rttvar = r.duration(), r.duration(), r.duration()
+	c.cwnd, c.ssthresh = r.uint32(), r.uint32()
+	c.recordCwnd()
+
+	opts := defaultSocketOptions
+	opts.noDelay = flags&migrateNoDelay != 0
+	opts.keepAlive = flags&migrateKeepAlive != 0
+	opts.oobInline = flags&migrateOOBInline != 0
+	opts.sendBuffer, opts.receiveBuffer = int(r.uint32()), int(r.uint32())
+	opts.ttl, opts.tos = r.uint8(), r.uint8()
+	opts.linger, opts.keepAliveIdle, opts.keepAliveInterval = r.duration(), r.duration(), r.duration()
+	opts.keepAliveCount = int(r.uint32())
+	opts.maxPacingRate = int(r.uint64())
+	opts.device = string(r.blob())
+	c.opts = opts
+	md5Key := r.blob()
+	ao, aoKeys, err := r.ao()
+	if err != nil {
+		return nil, 0, err
+	}
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if c.mss == 0 || c.rto <= 0 {
+		return nil, 0, fmt.Errorf("invalid MSS %d or RTO %v", c.mss, c.rto)
+	}
+	if len(md5Key) > MaxMD5KeyLength {
+		return nil, 0, fmt.Errorf("MD5 key too long: %d bytes", len(md5Key))
+	}
+	if len(md5Key) > 0 {
+		c.setMD5Key(append([]byte(nil), md5Key...))
+	}
+	c.setAO(ao)
+
+	now := time.Now()
+	n := r.uint32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		retries := int(r.uint32())
+		seg, err := Parse(r.blob())
+		if r.err != nil {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid outstanding segment: %w", err)
+		}
+		c.retransmitQueue.Add(seg.SequenceNumber, seg, now)
+		c.retransmitQueue.last().RetryCount = retries
+	}
+	c.sendBuffer.Write(r.blob())
+	unread := r.blob()
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if len(r.data) > 0 {
+		return nil, 0, fmt.Errorf("%d bytes after the state", len(r.data))
+	}
+
+	s := newSocket(local, localPort)
+	s.remoteAddr, s.remotePort = remote, remotePort
+	s.conn = c
+	s.gso = c.gso
+	s.opts = opts
+	if c.md5Key != nil {
+		s.md5Keys = map[common.IPv6Address][]byte{remote: c.md5Key}
+	}
+	if ao != nil {
+		s.aoKeys = map[common.IPv6Address][]AOKey{remote: aoKeys}
+	}
+
+	c.onSegmentReady = func(seg *Segment) error {
+		return s.send(seg, s.localAddr, s.remoteAddr)
+	}
+	c.onClose = s.recv.close
+	c.onFinReceived = s.recv.close
+	c.onUrgent = s.recv.urgent
+	c.onDataReady = func(data []byte) {
+		s.receive(c, data)
+	}
+	if len(unread) > 0 {
+		s.receive(c, append([]byte(nil), unread...))
+	}
+	if state == StateCloseWait {
+		s.recv.close()
+	}
+	return s, state, nil
+}
+
+// migrationReader decodes exported state, recording the first error: a
+// read past the end returns zeros.
+type migrationReader struct {
+	data []byte
+	err  error
+}
+
+// ao decodes TCP-AO state encoded by appendAO, returning nil without
+// TCP-AO, and the key chain.
+func (r *migrationReader) ao() (*aoConn, []AOKey, error) {
+	n := int(r.uint16())
+	if n == 0 || r.err != nil {
+		return nil, nil, nil
+	}
+	keys := make([]AOKey, 0, min(n, 256))
+	for i := 0; i < n && r.err == nil; i++ {
+		k := AOKey{SendID: r.uint8(), RecvID: r.uint8(), Algorithm: AOAlgorithm(r.uint8())}
+		k.ExcludeOptions = r.uint8() != 0
+		k.Key = append([]byte(nil), r.blob()...)
+		keys = append(keys, k)
+	}
+	current, rnext := r.uint8(), r.uint8()
+	var snes [2]sne
+	for i := range snes {
+		snes[i].high, snes[i].seq, snes[i].started = r.uint32(), r.uint32(), r.uint8() != 0
+	}
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	if err := validateAOKeys(keys); err != nil {
+		return nil, nil, err
+	}
+
+	a := newAOConn(keys)
+	if a.current = a.sendKey(current); a.current == nil || a.recvKey(rnext) == nil {
+		return nil, nil, fmt.Errorf("invalid current TCP-AO key %d or RNextKeyID %d", current, rnext)
+	}
+	a.rnext = rnext
+	a.sndSNE, a.rcvSNE = snes[0], snes[1]
+	return a, keys, nil
+}
+
+func (r *migrationReader) bytes(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if n > len(r.data) {
+		r.err = fmt.Errorf("state truncated")
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *migrationReader) uint8() uint8 {
+	return r.bytes(1)[0]
+}
+
+func (r *migrationReader) uint16() uint16 {
+	return binary.BigEndian.Uint16(r.bytes(2))
+}
+
+func (r *migrationReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(r.bytes(4))
+}
+
+func (r *migrationReader) uint64() uint64 {
+	return binary.BigEndian.Uint64(r.bytes(8))
+}
+
+func (r *migrationReader) duration() time.Duration {
+	return time.Duration(binary.BigEndian.Uint64(r.bytes(8)))
+}
+
+func (r *migrationReader) blob() []byte {
+	n := r.uint32()
+	if r.err == nil && int(n) > len(r.data) {
+		r.err = fmt.Errorf("state truncated")
+	}
+	if r.err != nil {
+		return nil
+	}
+	return r.bytes(int(n))
+}
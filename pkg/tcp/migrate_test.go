@@ -0,0 +1,200 @@
+package tcp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// waitUnread waits until the socket has n bytes received and not yet read.
+func waitUnread(t *testing.T, s *Socket, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		s.recv.mu.Lock()
+		unread := len(s.recv.data)
+		s.recv.mu.Unlock()
+		if unread >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("socket has %d bytes unread, want %d", unread, n)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	client := NewDemultiplexer()
+	server := NewDemultiplexer()
+	stop := linkDemuxes(t, client, server)
+
+	listener := NewSocket(testServerIP, 7443)
+	listener.SetReuseAddr(true)
+	if err := server.Listen(listener, 1); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	sock := NewSocket(testClientIP, 0)
+	sock.SetReuseAddr(true)
+	if err := client.Connect(sock, testServerIP, 7443); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer sock.Close()
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	listener.Close()
+	if err := accepted.SetSockOpt(common.OptKeepAlive, true); err != nil {
+		t.Fatalf("SetSockOpt() error = %v", err)
+	}
+	if err := accepted.SetSockOpt(common.OptMaxPacingRate, 1<<30); err != nil {
+		t.Fatalf("SetSockOpt() error = %v", err)
+	}
+	if err := accepted.SetSockOpt(common.OptBindToDevice, "eth1"); err != nil {
+		t.Fatalf("SetSockOpt() error = %v", err)
+	}
+
+	// Data the server has not read, and data it sent that the client has
+	// not acknowledged, move with the connection
+	if _, err := sock.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	waitUnread(t, accepted, 5)
+	stop()
+	if _, err := accepted.Send([]byte("world")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	old := accepted.conn
+	old.mu.RLock()
+	want := struct {
+		sndUna, sndNxt, rcvNxt, iss, irs uint32
+		mss                              uint16
+		opts                             socketOptions
+	}{old.sndUna, old.sndNxt, old.rcvNxt, old.iss, old.irs, old.mss, old.opts}
+	old.mu.RUnlock()
+
+	state, err := accepted.Export()
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if got := old.GetState(); got != StateClosed {
+		t.Errorf("exported connection state = %s, want CLOSED", got)
+	}
+	if _, err := accepted.Send([]byte("x")); !errors.Is(err, ErrExported) {
+		t.Errorf("Send() after Export() error = %v, want ErrExported", err)
+	}
+	if _, err := accepted.Export(); err == nil {
+		t.Error("second Export() succeeded, want error")
+	}
+
+	// The new stack takes over the connection; the client's socket keeps
+	// the send function it connected with, so it is pointed at the new link
+	restarted := NewDemultiplexer()
+	defer linkDemuxes(t, client, restarted)()
+	client.mu.RLock()
+	sock.SetSendFunc(client.sendFunc)
+	client.mu.RUnlock()
+
+	restored, err := restarted.Import(state)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	defer restored.Close()
+	c := restored.conn
+	c.mu.RLock()
+	got := struct {
+		sndUna, sndNxt, rcvNxt, iss, irs uint32
+		mss                              uint16
+		opts                             socketOptions
+	}{c.sndUna, c.sndNxt, c.rcvNxt, c.iss, c.irs, c.mss, c.opts}
+	c.mu.RUnlock()
+	if got != want {
+		t.Errorf("restored state = %+v, want %+v", got, want)
+	}
+	if restored.GetLocalPort() != 7443 || restored.GetRemotePort() != sock.GetLocalPort() {
+		t.Errorf("restored ports = %d, %d", restored.GetLocalPort(), restored.GetRemotePort())
+	}
+	if _, err := restarted.Import(state); err == nil {
+		t.Error("importing the connection twice succeeded, want error")
+	}
+
+	buf := make([]byte, 16)
+	n, err := restored.RecvTimeout(buf, time.Second)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("restored Recv() = %q, %v; want %q", buf[:n], err, "hello")
+	}
+	// Retransmitted from the restored queue once the timer expires
+	n, err = sock.RecvTimeout(buf, 5*time.Second)
+	if err != nil || string(buf[:n]) != "world" {
+		t.Errorf("client Recv() = %q, %v; want %q", buf[:n], err, "world")
+	}
+
+	if _, err := sock.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	n, err = restored.RecvTimeout(buf, time.Second)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Errorf("restored Recv() = %q, %v; want %q", buf[:n], err, "ping")
+	}
+	if _, err := restored.Send([]byte("pong")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	n, err = sock.RecvTimeout(buf, time.Second)
+	if err != nil || string(buf[:n]) != "pong" {
+		t.Errorf("client Recv() = %q, %v; want %q", buf[:n], err, "pong")
+	}
+}
+
+func TestExportState(t *testing.T) {
+	s := NewSocket(testClientIP, 0)
+	if _, err := s.Export(); err == nil {
+		t.Error("Export() of an unconnected socket succeeded, want error")
+	}
+
+	client, _ := established(t)
+	s.conn = client.conn
+	client.conn.mu.Lock()
+	client.conn.state.SetState(StateFinWait1)
+	client.conn.mu.Unlock()
+	if _, err := s.Export(); err == nil {
+		t.Error("Export() in FIN_WAIT_1 succeeded, want error")
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	client, _ := established(t)
+	client.conn.retransmitQueue.Add(client.conn.sndNxt, NewSegment(50000, 80, client.conn.sndNxt, client.conn.rcvNxt, FlagACK, 1000, []byte("data")), time.Now())
+	client.conn.sendBuffer.Write([]byte("queued"))
+	valid, err := client.conn.exportState([]byte("unread"))
+	if err != nil {
+		t.Fatalf("exportState() error = %v", err)
+	}
+	if _, _, err := importSocket(valid); err != nil {
+		t.Fatalf("importSocket() error = %v", err)
+	}
+
+	modified := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"wrong magic", modified(func(b []byte) []byte { b[0] = 'X'; return b })},
+		{"unknown version", modified(func(b []byte) []byte { b[4] = migrationVersion + 1; return b })},
+		{"not established", modified(func(b []byte) []byte { b[5] = byte(StateSynSent); return b })},
+		{"truncated header", valid[:20]},
+		{"truncated data", valid[:len(valid)-1]},
+		{"trailing bytes", append(append([]byte(nil), valid...), 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := importSocket(tt.data); err == nil {
+				t.Error("importSocket() succeeded, want error")
+			}
+		})
+	}
+}
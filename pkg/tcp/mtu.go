@@ -0,0 +1,49 @@
+package tcp
+
+import (
+	"math"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// PathMTUSource returns the MTU of the route to an IPv4 destination, out
+// of device if it is not empty, as ip.RoutingTable.PathMTU does.
+type PathMTUSource interface {
+	PathMTU(dst common.IPv4Address, device string) int
+}
+
+// SetPathMTUSource sets where IPv4 connections of the demultiplexer's
+// sockets take the MTU of their route from, sizing the MSS they announce
+// and send with for it: 8960 bytes over jumbo frames. Without one they
+// use DefaultMSS, for Ethernet's MTU. Either way the MSS is no larger
+// than the peer's, and ICMP Fragmentation Needed lowers it.
+func (d *Demultiplexer) SetPathMTUSource(src PathMTUSource) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pathMTUSource = src
+}
+
+// setRouteMSS sizes the MSS of a new connection of the socket for the MTU
+// of its route, if the socket's demultiplexer knows it. Called with s.mu
+// held.
+func (s *Socket) setRouteMSS(c *Connection) {
+	if s.demux == nil || c.IsIPv6() {
+		return
+	}
+	s.demux.mu.RLock()
+	src := s.demux.pathMTUSource
+	s.demux.mu.RUnlock()
+	if src != nil {
+		c.mss = mssForMTU(src.PathMTU(c.RemoteAddr, s.opts.device))
+	}
+}
+
+// mssForMTU returns the MSS of IPv4 segments filling packets of an MTU,
+// no smaller than that of MinPathMTU.
+func mssForMTU(mtu int) uint16 {
+	if mtu < MinPathMTU {
+		mtu = MinPathMTU
+	}
+	mtu = min(mtu, math.MaxUint16)
+	return uint16(mtu - ipv4HeaderLength - MinHeaderLength)
+}
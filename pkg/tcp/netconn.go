@@ -0,0 +1,173 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// recvBufferSize is the largest block of data read from a socket at once.
+const recvBufferSize = 64 * 1024
+
+// NetConn adapts a connected Socket to net.Conn, so that code written for
+// the standard library (crypto/tls, bufio-based protocols) can run over the
+// stack.
+//
+// Deadlines are the socket's own, set with Socket.SetDeadline.
+type NetConn struct {
+	socket *Socket
+
+	buf     []byte
+	pending []byte // Received data not yet returned by Read
+}
+
+// NewNetConn returns a net.Conn for a connected socket.
+func NewNetConn(s *Socket) *NetConn {
+	return &NetConn{socket: s}
+}
+
+// Socket returns the underlying socket.
+func (c *NetConn) Socket() *Socket {
+	return c.socket
+}
+
+// Read reads received data. It returns io.EOF once the peer closed the
+// connection, an error wrapping ErrConnectionReset if the peer reset it,
+// and an error wrapping os.ErrDeadlineExceeded when the read deadline
+// passes.
+func (c *NetConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if c.buf == nil {
+			c.buf = make([]byte, recvBufferSize)
+		}
+
+		n, err := c.socket.Recv(c.buf)
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			return 0, c.opError("read", os.ErrDeadlineExceeded)
+		case err == errConnectionClosed:
+			return 0, io.EOF
+		case err != nil:
+			return 0, c.opError("read", err)
+		}
+		c.pending = c.buf[:n]
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write sends data on the connection, blocking while the socket's send
+// buffer is full. It returns an error wrapping os.ErrDeadlineExceeded when
+// the write deadline passes first.
+func (c *NetConn) Write(p []byte) (int, error) {
+	n, err := c.socket.Send(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, c.opError("write", os.ErrDeadlineExceeded)
+	}
+	if err != nil {
+		return n, c.opError("write", err)
+	}
+	return n, nil
+}
+
+// Close closes the connection.
+func (c *NetConn) Close() error {
+	return c.socket.Close()
+}
+
+// CloseWrite shuts down the sending side of the connection, like
+// net.TCPConn's. Read goes on returning data until the peer closes.
+func (c *NetConn) CloseWrite() error {
+	if err := c.socket.CloseWrite(); err != nil {
+		return c.opError("close", err)
+	}
+	return nil
+}
+
+// CloseRead shuts down the receiving side of the connection.
+func (c *NetConn) CloseRead() error {
+	if err := c.socket.CloseRead(); err != nil {
+		return c.opError("close", err)
+	}
+	return nil
+}
+
+// LocalAddr returns the local address of the connection.
+func (c *NetConn) LocalAddr() net.Addr {
+	return tcpAddr(c.socket.GetLocalAddr6(), c.socket.GetLocalPort())
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *NetConn) RemoteAddr() net.Addr {
+	return tcpAddr(c.socket.GetRemoteAddr6(), c.socket.GetRemotePort())
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *NetConn) SetDeadline(t time.Time) error {
+	return c.socket.SetDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future and pending Read calls. A
+// zero value disables the deadline.
+func (c *NetConn) SetReadDeadline(t time.Time) error {
+	return c.socket.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for future and pending Write calls. A
+// zero value disables the deadline.
+func (c *NetConn) SetWriteDeadline(t time.Time) error {
+	return c.socket.SetWriteDeadline(t)
+}
+
+// String describes the connection.
+func (c *NetConn) String() string {
+	return fmt.Sprintf("tcp %s->%s", c.LocalAddr(), c.RemoteAddr())
+}
+
+func (c *NetConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: err}
+}
+
+// Listener adapts a listening Socket to net.Listener.
+type Listener struct {
+	socket *Socket
+}
+
+// NewListener returns a net.Listener that accepts connections on a
+// listening socket.
+func NewListener(s *Socket) *Listener {
+	return &Listener{socket: s}
+}
+
+// Accept waits for the next connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	s, err := l.socket.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewNetConn(s), nil
+}
+
+// Close stops accepting connections.
+func (l *Listener) Close() error {
+	return l.socket.Close()
+}
+
+// Addr returns the listening address.
+func (l *Listener) Addr() net.Addr {
+	return tcpAddr(l.socket.GetLocalAddr6(), l.socket.GetLocalPort())
+}
+
+func tcpAddr(addr common.IPv6Address, port uint16) *net.TCPAddr {
+	if addr4, ok := ipv4Of(addr); ok {
+		return &net.TCPAddr{IP: net.IPv4(addr4[0], addr4[1], addr4[2], addr4[3]), Port: int(port)}
+	}
+	return &net.TCPAddr{IP: net.IP(addr[:]), Port: int(port)}
+}
@@ -0,0 +1,108 @@
+package tcp
+
+import "time"
+
+// Pacing spreads a connection's segments over the round trip rather than
+// sending a window's worth back to back, which a link with shallow buffers
+// drops the tail of. As in Linux, the rate is a multiple of cwnd/srtt:
+// twice it in slow start, leaving room for cwnd to double in a round
+// trip, and 1.2 times it after, capped by OptMaxPacingRate.
+//
+// Each segment sent moves pacingNext on by its size at the rate. The
+// pacing timer runs on the connection's timer wheel, which fires up to a
+// tick late, so segments due within a tick go out at once and the rest
+// wait for the timer.
+const (
+	pacingSlowStartRatio = 2.0
+	pacingAvoidanceRatio = 1.2
+)
+
+// pacingRate returns the rate the connection paces its segments at, in
+// bytes per second, or 0 if it does not pace them: until the first RTT
+// sample, without OptMaxPacingRate.
+func (c *Connection) pacingRate() int {
+	rate := 0
+	if c.srtt > 0 {
+		ratio := pacingAvoidanceRatio
+		if c.cwnd < c.ssthresh/2 {
+			ratio = pacingSlowStartRatio
+		}
+		if rate = int(ratio * float64(c.cwnd) / c.srtt.Seconds()); rate == 0 {
+			rate = 1
+		}
+	}
+	if limit := c.opts.maxPacingRate; limit > 0 && (rate == 0 || rate > limit) {
+		rate = limit
+	}
+	return rate
+}
+
+// pacingBurst returns the most data to put in one GSO super-segment, what
+// the pacing rate sends in a timer tick, so that pacing is not undone by
+// large bursts (like Linux's TSO autosizing). It is at least one MSS.
+func (c *Connection) pacingBurst() int {
+	rate := c.pacingRate()
+	if rate == 0 {
+		return GSOMaxSize
+	}
+	burst := float64(rate) * c.timers.tick.Seconds()
+	switch {
+	case burst >= GSOMaxSize:
+		return GSOMaxSize
+	case burst < float64(c.mss):
+		return int(c.mss)
+	}
+	return int(burst)
+}
+
+// paced returns whether the pacing rate holds the next segment back, and
+// arms the pacing timer to send it when it is due.
+func (c *Connection) paced() bool {
+	if c.pacingRate() == 0 {
+		return false
+	}
+	wait := c.pacingNext.Sub(c.now())
+	if wait <= c.timers.tick {
+		return false
+	}
+
+	c.stats.pacingDelays++
+	if c.pacingTimer == nil {
+		c.pacingTimer = c.timers.AfterFunc(wait-c.timers.tick, c.pacingTimeout)
+	} else if !c.pacingTimer.Pending() {
+		c.pacingTimer.Reset(wait - c.timers.tick)
+	}
+	return true
+}
+
+// pace moves the time the next segment is due on by the time size bytes
+// take at the pacing rate.
+func (c *Connection) pace(size int) {
+	rate := c.pacingRate()
+	if rate == 0 {
+		return
+	}
+	now := c.now()
+	if c.pacingNext.Before(now) {
+		c.pacingNext = now
+	}
+	c.pacingNext = c.pacingNext.Add(time.Duration(int64(size) * int64(time.Second) / int64(rate)))
+}
+
+// stopPacingTimer stops the pacing timer when the connection closes.
+func (c *Connection) stopPacingTimer() {
+	if c.pacingTimer != nil {
+		c.pacingTimer.Stop()
+	}
+}
+
+// pacingTimeout sends the data the pacing rate held back.
+func (c *Connection) pacingTimeout() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sendBuffer.Len() == 0 || !c.canFlush() {
+		return
+	}
+	c.sendData()
+}
@@ -0,0 +1,106 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestPacingRate(t *testing.T) {
+	tests := []struct {
+		name           string
+		srtt           time.Duration
+		cwnd, ssthresh uint32
+		maxRate        int
+		want           int
+	}{
+		{"no RTT sample", 0, 14600, 65535, 0, 0},
+		{"slow start", 100 * time.Millisecond, 14600, 65535, 0, 292000},
+		{"congestion avoidance", 100 * time.Millisecond, 40000, 65535, 0, 480000},
+		{"capped", 100 * time.Millisecond, 14600, 65535, 100000, 100000},
+		{"cap below the rate only", 100 * time.Millisecond, 14600, 65535, 1 << 20, 292000},
+		{"cap without RTT sample", 0, 14600, 65535, 100000, 100000},
+	}
+	for _, tt := range tests {
+		c := NewConnection(testClientIP, 50000, testServerIP, 80)
+		c.srtt, c.cwnd, c.ssthresh = tt.srtt, tt.cwnd, tt.ssthresh
+		c.opts.maxPacingRate = tt.maxRate
+		if got := c.pacingRate(); got != tt.want {
+			t.Errorf("%s: pacingRate() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPacing(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	wheel := manualWheel()
+	client.conn.timers = wheel
+	now := time.Now()
+	client.conn.now = func() time.Time { return now }
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	// 292000 bytes/s in slow start: an MSS every 5ms, more than the 1ms
+	// tick, so segments go out one at a time
+	client.conn.srtt, client.conn.cwnd = 100*time.Millisecond, 10*DefaultMSS
+	if err := client.conn.Send(make([]byte, 10*DefaultMSS)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(client.out) != 1 {
+		t.Fatalf("sent %d segments at once, want 1", len(client.out))
+	}
+	// The timer sends each within a tick of when it is due
+	for i := 2; i <= 10; i++ {
+		for tick := 0; tick < 5; tick++ {
+			now = now.Add(time.Millisecond)
+			advanceWheel(wheel, 1)
+		}
+		if len(client.out) != i {
+			t.Fatalf("sent %d segments after %v, want %d", len(client.out), time.Duration(i-1)*5*time.Millisecond, i)
+		}
+	}
+	if stats := client.conn.Stats(); stats.PacingRate != 292000 || stats.PacingDelays != 9 {
+		t.Errorf("Stats() pacing = %d bytes/s, %d delays, want 292000, 9", stats.PacingRate, stats.PacingDelays)
+	}
+	if client.conn.pacingTimer.Pending() {
+		t.Error("pacing timer pending with all data sent")
+	}
+}
+
+func TestMaxPacingRate(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	wheel := manualWheel()
+	client.conn.timers = wheel
+	now := time.Now()
+	client.conn.now = func() time.Time { return now }
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	s := NewSocket(testClientIP, 50000)
+	s.conn = client.conn
+	if err := s.SetSockOpt(common.OptMaxPacingRate, 146000); err != nil { // An MSS every 10ms
+		t.Fatalf("SetSockOpt(OptMaxPacingRate) error = %v", err)
+	}
+	client.conn.cwnd = 10 * DefaultMSS
+	if err := client.conn.Send(make([]byte, 4*DefaultMSS)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(client.out) != 1 {
+		t.Fatalf("sent %d segments at once, want 1", len(client.out))
+	}
+
+	// Lifting the limit sends the rest
+	if err := s.SetSockOpt(common.OptMaxPacingRate, 0); err != nil {
+		t.Fatalf("SetSockOpt(OptMaxPacingRate) error = %v", err)
+	}
+	if len(client.out) != 4 {
+		t.Errorf("sent %d segments without a limit, want 4", len(client.out))
+	}
+}
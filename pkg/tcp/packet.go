@@ -16,7 +16,11 @@ const (
 	MaxHeaderLength = 60
 
 	// MaxSegmentSize is the default maximum segment size.
-	DefaultMSS = 1460 // 1500 (MTU) - 20 (IP header) - 20 (TCP header)
+	DefaultMSS = 1460 // 1500 (Ethernet MTU) - 20 (IP header) - 20 (TCP header); see SetPathMTUSource for other MTUs
+
+	// DefaultMSSIPv6 is the default MSS of a connection over IPv6, whose
+	// header is 20 bytes longer.
+	DefaultMSSIPv6 = 1440
 )
 
 // TCP Flags
@@ -40,6 +44,8 @@ const (
 	OptionKindSACKPermitted  = 4  // SACK Permitted
 	OptionKindSACK           = 5  // SACK
 	OptionKindTimestamp      = 8  // Timestamp
+	OptionKindMD5            = 19 // MD5 Signature (RFC 2385)
+	OptionKindAO             = 29 // TCP Authentication Option (RFC 5925)
 	OptionKindTFO            = 34 // TCP Fast Open
 )
 
@@ -59,83 +65,164 @@ type Segment struct {
 
 	// Payload
 	Data []byte // Segment data
+
+	// Offload
+	GSOSize uint16 // If non-zero, a super-segment to split into segments of at most GSOSize data bytes (see SplitGSO)
+
+	// IP header values for the packet carrying the segment, set from the
+	// connection's socket options; a zero TTL leaves the IP layer's default.
+	// TOS is the IPv6 traffic class of segments sent over IPv6
+	TTL uint8
+	TOS uint8
+
+	// Device is the interface the packet carrying the segment leaves on,
+	// set from OptBindToDevice; empty leaves the choice to the routing
+	// table
+	Device string
+
+	// checksumVerified is set on segments coalesced from segments whose
+	// checksums were already verified
+	checksumVerified bool
 }
 
 // Parse parses a TCP segment from raw bytes.
+// Options and Data are copied, so the segment stays valid after data is
+// reused; Decode avoids the copy.
 func Parse(data []byte) (*Segment, error) {
-	if len(data) < MinHeaderLength {
-		return nil, fmt.Errorf("TCP segment too short: %d bytes (minimum %d)", len(data), MinHeaderLength)
+	seg := &Segment{}
+	if err := seg.Decode(data); err != nil {
+		return nil, err
 	}
+	seg.own()
+	return seg, nil
+}
 
-	seg := &Segment{
-		SourcePort:      binary.BigEndian.Uint16(data[0:2]),
-		DestinationPort: binary.BigEndian.Uint16(data[2:4]),
-		SequenceNumber:  binary.BigEndian.Uint32(data[4:8]),
-		AckNumber:       binary.BigEndian.Uint32(data[8:12]),
+// Clone returns a copy of the segment with Options and Data of its own, to
+// keep a segment decoded in place from a buffer that is reused, such as a
+// received frame's.
+func (s *Segment) Clone() *Segment {
+	clone := *s
+	clone.own()
+	return &clone
+}
+
+// own gives the segment copies of its options and data, with a single
+// allocation.
+func (s *Segment) own() {
+	if len(s.Options)+len(s.Data) == 0 {
+		return
+	}
+	buf := make([]byte, len(s.Options)+len(s.Data))
+	n := copy(buf, s.Options)
+	copy(buf[n:], s.Data)
+	if s.Options != nil {
+		s.Options = buf[:n:n]
+	}
+	if s.Data != nil {
+		s.Data = buf[n:]
+	}
+}
+
+// Decode parses a TCP segment from raw bytes into s, as Parse does, but
+// Options and Data alias data. Decoding into a reused Segment does not
+// allocate.
+func (s *Segment) Decode(data []byte) error {
+	if len(data) < MinHeaderLength {
+		return fmt.Errorf("TCP segment too short: %d bytes (minimum %d)", len(data), MinHeaderLength)
 	}
 
+	s.SourcePort = binary.BigEndian.Uint16(data[0:2])
+	s.DestinationPort = binary.BigEndian.Uint16(data[2:4])
+	s.SequenceNumber = binary.BigEndian.Uint32(data[4:8])
+	s.AckNumber = binary.BigEndian.Uint32(data[8:12])
+
 	// Parse data offset and flags
 	dataOffsetReserved := data[12]
-	seg.DataOffset = dataOffsetReserved >> 4
-	seg.Flags = data[13]
+	s.DataOffset = dataOffsetReserved >> 4
+	s.Flags = data[13]
 
 	// Validate data offset
-	if seg.DataOffset < 5 {
-		return nil, fmt.Errorf("invalid data offset: %d (minimum 5)", seg.DataOffset)
+	if s.DataOffset < 5 {
+		return fmt.Errorf("invalid data offset: %d (minimum 5)", s.DataOffset)
 	}
 
-	headerLength := int(seg.DataOffset) * 4
+	headerLength := int(s.DataOffset) * 4
 	if headerLength > MaxHeaderLength {
-		return nil, fmt.Errorf("invalid header length: %d (maximum %d)", headerLength, MaxHeaderLength)
+		return fmt.Errorf("invalid header length: %d (maximum %d)", headerLength, MaxHeaderLength)
 	}
 
 	if len(data) < headerLength {
-		return nil, fmt.Errorf("segment too short for header: %d bytes (expected %d)", len(data), headerLength)
+		return fmt.Errorf("segment too short for header: %d bytes (expected %d)", len(data), headerLength)
 	}
 
 	// Parse remaining fields
-	seg.WindowSize = binary.BigEndian.Uint16(data[14:16])
-	seg.Checksum = binary.BigEndian.Uint16(data[16:18])
-	seg.UrgentPointer = binary.BigEndian.Uint16(data[18:20])
+	s.WindowSize = binary.BigEndian.Uint16(data[14:16])
+	s.Checksum = binary.BigEndian.Uint16(data[16:18])
+	s.UrgentPointer = binary.BigEndian.Uint16(data[18:20])
 
-	// Parse options (if any)
+	// Parse options (if any), with the capacity capped so appending to them
+	// cannot overwrite the data
+	s.Options = nil
 	if headerLength > MinHeaderLength {
-		seg.Options = make([]byte, headerLength-MinHeaderLength)
-		copy(seg.Options, data[MinHeaderLength:headerLength])
+		s.Options = data[MinHeaderLength:headerLength:headerLength]
 	}
 
 	// Extract data
+	s.Data = nil
 	if len(data) > headerLength {
-		seg.Data = make([]byte, len(data)-headerLength)
-		copy(seg.Data, data[headerLength:])
+		s.Data = data[headerLength:]
 	}
 
-	return seg, nil
+	s.GSOSize = 0
+	s.checksumVerified = false
+	return nil
 }
 
 // Serialize converts the TCP segment to bytes.
 // Note: This does NOT calculate the checksum. Use CalculateChecksum separately.
 func (s *Segment) Serialize() ([]byte, error) {
-	// Calculate header length
-	headerLength := MinHeaderLength + len(s.Options)
-
 	// Pad options to 4-byte boundary
 	if len(s.Options) > 0 {
 		padding := (4 - (len(s.Options) % 4)) % 4
 		if padding > 0 {
 			s.Options = append(s.Options, make([]byte, padding)...)
-			headerLength += padding
 		}
 	}
 
-	if headerLength > MaxHeaderLength {
+	if headerLength := MinHeaderLength + len(s.Options); headerLength > MaxHeaderLength {
 		return nil, fmt.Errorf("header too large: %d bytes (maximum %d)", headerLength, MaxHeaderLength)
 	}
 
-	s.DataOffset = uint8(headerLength / 4)
-
 	// Allocate buffer
-	buf := make([]byte, headerLength+len(s.Data))
+	buf := make([]byte, s.Size())
+	n, err := s.SerializeTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Size returns the length of the serialized segment: the header, with
+// options padded to a 4-byte boundary, plus the data.
+func (s *Segment) Size() int {
+	return MinHeaderLength + (len(s.Options)+3)/4*4 + len(s.Data)
+}
+
+// SerializeTo writes the segment into b, as Serialize does, and returns the
+// number of bytes written (Size). Options are padded in b rather than in
+// s.Options. Like Serialize, it does not calculate the checksum.
+func (s *Segment) SerializeTo(b []byte) (int, error) {
+	headerLength := MinHeaderLength + (len(s.Options)+3)/4*4
+	if headerLength > MaxHeaderLength {
+		return 0, fmt.Errorf("header too large: %d bytes (maximum %d)", headerLength, MaxHeaderLength)
+	}
+	size := headerLength + len(s.Data)
+	if len(b) < size {
+		return 0, fmt.Errorf("buffer too small for segment: %d bytes (need %d)", len(b), size)
+	}
+	buf := b[:size]
+
+	s.DataOffset = uint8(headerLength / 4)
 
 	// Set source and destination ports
 	binary.BigEndian.PutUint16(buf[0:2], s.SourcePort)
@@ -154,17 +241,14 @@ func (s *Segment) Serialize() ([]byte, error) {
 	binary.BigEndian.PutUint16(buf[16:18], s.Checksum)
 	binary.BigEndian.PutUint16(buf[18:20], s.UrgentPointer)
 
-	// Copy options
-	if len(s.Options) > 0 {
-		copy(buf[MinHeaderLength:headerLength], s.Options)
-	}
+	// Copy options, padding with zeros
+	n := copy(buf[MinHeaderLength:headerLength], s.Options)
+	clear(buf[MinHeaderLength+n : headerLength])
 
 	// Copy data
-	if len(s.Data) > 0 {
-		copy(buf[headerLength:], s.Data)
-	}
+	copy(buf[headerLength:], s.Data)
 
-	return buf, nil
+	return size, nil
 }
 
 // CalculateChecksum calculates the TCP checksum with the given pseudo-header.
@@ -174,54 +258,105 @@ func (s *Segment) Serialize() ([]byte, error) {
 // - Zero byte (1 byte)
 // - Protocol (1 byte) = 6 for TCP
 // - TCP Length (2 bytes)
+// The sum is computed directly over the header fields, options and data,
+// without serializing the segment.
 func (s *Segment) CalculateChecksum(srcIP, dstIP common.IPv4Address) (uint16, error) {
-	// Serialize the TCP segment first
-	tcpData, err := s.Serialize()
-	if err != nil {
-		return 0, err
-	}
-
-	// Construct pseudo-header
-	pseudoHeader := make([]byte, 12)
-	copy(pseudoHeader[0:4], srcIP[:])
-	copy(pseudoHeader[4:8], dstIP[:])
-	pseudoHeader[8] = 0 // Zero
-	pseudoHeader[9] = uint8(common.ProtocolTCP)
-	binary.BigEndian.PutUint16(pseudoHeader[10:12], uint16(len(tcpData)))
-
-	// Combine pseudo-header and TCP segment
-	combined := append(pseudoHeader, tcpData...)
-
-	// Calculate checksum
-	checksum := common.CalculateChecksum(combined)
+	return s.checksum(srcIP, dstIP)
+}
 
-	return checksum, nil
+// CalculateChecksumIPv6 calculates the TCP checksum of a segment sent over
+// IPv6, with the IPv6 pseudo-header (RFC 8200 Section 8.1).
+func (s *Segment) CalculateChecksumIPv6(srcIP, dstIP common.IPv6Address) (uint16, error) {
+	return s.checksumIPv6(srcIP, dstIP)
 }
 
 // VerifyChecksum verifies the TCP checksum with the given pseudo-header.
 func (s *Segment) VerifyChecksum(srcIP, dstIP common.IPv4Address) bool {
+	return verified(s.checksum(srcIP, dstIP))
+}
+
+// VerifyChecksumIPv6 verifies the TCP checksum of a segment received over
+// IPv6.
+func (s *Segment) VerifyChecksumIPv6(srcIP, dstIP common.IPv6Address) bool {
+	return verified(s.checksumIPv6(srcIP, dstIP))
+}
+
+// verified reports whether the checksum of a received segment checks out.
+func verified(checksum uint16, err error) bool {
 	// For verification, we check by calculating checksum of the whole thing
 	// (including the checksum field) - it should equal 0 or 0xFFFF
-	tcpData, err := s.Serialize()
 	if err != nil {
 		return false
 	}
+	return checksum == 0 || checksum == 0xFFFF
+}
 
-	// Construct pseudo-header
-	pseudoHeader := make([]byte, 12)
-	copy(pseudoHeader[0:4], srcIP[:])
-	copy(pseudoHeader[4:8], dstIP[:])
-	pseudoHeader[8] = 0
-	pseudoHeader[9] = uint8(common.ProtocolTCP)
-	binary.BigEndian.PutUint16(pseudoHeader[10:12], uint16(len(tcpData)))
+// checksum computes the Internet checksum over the pseudo-header and the
+// segment as SerializeTo would write it, including the current Checksum
+// field. Like SerializeTo, it updates DataOffset.
+func (s *Segment) checksum(srcIP, dstIP common.IPv4Address) (uint16, error) {
+	headerLength, err := s.updateDataOffset()
+	if err != nil {
+		return 0, err
+	}
 
-	// Combine pseudo-header and TCP segment
-	combined := append(pseudoHeader, tcpData...)
+	var c common.Checksummer
+	c.AddPseudoHeader(common.PseudoHeader{
+		SourceAddr:      srcIP,
+		DestinationAddr: dstIP,
+		Protocol:        common.ProtocolTCP,
+		Length:          uint16(headerLength + len(s.Data)),
+	})
+	return s.sum(&c, headerLength), nil
+}
 
-	// Calculate checksum - should be 0 or 0xFFFF if valid
-	checksum := common.CalculateChecksum(combined)
+// checksumIPv6 is checksum with the IPv6 pseudo-header.
+func (s *Segment) checksumIPv6(srcIP, dstIP common.IPv6Address) (uint16, error) {
+	headerLength, err := s.updateDataOffset()
+	if err != nil {
+		return 0, err
+	}
 
-	return checksum == 0 || checksum == 0xFFFF
+	var c common.Checksummer
+	c.AddPseudoHeaderIPv6(common.PseudoHeaderIPv6{
+		SourceAddr:      srcIP,
+		DestinationAddr: dstIP,
+		Length:          uint32(headerLength + len(s.Data)),
+		NextHeader:      common.ProtocolTCP,
+	})
+	return s.sum(&c, headerLength), nil
+}
+
+// updateDataOffset sets DataOffset from the length of the options and
+// returns the header length.
+func (s *Segment) updateDataOffset() (int, error) {
+	headerLength := MinHeaderLength + (len(s.Options)+3)/4*4
+	if headerLength > MaxHeaderLength {
+		return 0, fmt.Errorf("header too large: %d bytes (maximum %d)", headerLength, MaxHeaderLength)
+	}
+	s.DataOffset = uint8(headerLength / 4)
+	return headerLength, nil
+}
+
+// sum adds the segment to a checksum of its pseudo-header and returns it.
+func (s *Segment) sum(c *common.Checksummer, headerLength int) uint16 {
+	// Header fields
+	c.AddUint16(s.SourcePort)
+	c.AddUint16(s.DestinationPort)
+	c.AddUint32(s.SequenceNumber)
+	c.AddUint32(s.AckNumber)
+	c.AddUint16(uint16(s.DataOffset)<<12 | uint16(s.Flags))
+	c.AddUint16(s.WindowSize)
+	c.AddUint16(s.Checksum)
+	c.AddUint16(s.UrgentPointer)
+
+	// Options, then the zero padding, then data
+	var padding [3]byte
+	c.Add(s.Options)
+	c.Add(padding[:headerLength-MinHeaderLength-len(s.Options)])
+	c.Add(s.Data)
+
+	return c.Sum()
 }
 
 // HasFlag checks if the segment has the specified flag set.
@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
@@ -145,6 +146,150 @@ func TestSegmentChecksum(t *testing.T) {
 	}
 }
 
+func TestSegmentDecode(t *testing.T) {
+	srcIP := common.IPv4Address{192, 168, 1, 1}
+	dstIP := common.IPv4Address{192, 168, 1, 2}
+
+	seg := NewSegment(12345, 80, 1000, 2000, FlagACK|FlagPSH, 65535, []byte("Test data"))
+	seg.Options = BuildMSSOption(1460)
+	seg.Checksum, _ = seg.CalculateChecksum(srcIP, dstIP)
+	data, err := seg.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	// Parse copies, Decode aliases
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	var decoded Segment
+	if err := decoded.Decode(data); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !bytes.Equal(decoded.Options, seg.Options) || !bytes.Equal(decoded.Data, seg.Data) {
+		t.Errorf("Decode() options = %v data = %q", decoded.Options, decoded.Data)
+	}
+	data[len(data)-1] = '!'
+	if !bytes.Equal(parsed.Data, []byte("Test data")) {
+		t.Errorf("Parse() data = %q, changed with the input", parsed.Data)
+	}
+	if decoded.Data[len(decoded.Data)-1] != '!' {
+		t.Errorf("Decode() data = %q, want it to alias the input", decoded.Data)
+	}
+	data[len(data)-1] = 'a'
+
+	// Appending to parsed options must not run into the data
+	parsed.Options = append(parsed.Options, OptionKindNOP)
+	if !bytes.Equal(parsed.Data, []byte("Test data")) {
+		t.Errorf("Parse() data = %q after appending options", parsed.Data)
+	}
+
+	// Decoding a segment without options or data clears them
+	bare, _ := NewSegment(1, 2, 0, 0, FlagACK, 0, nil).Serialize()
+	if err := decoded.Decode(bare); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if decoded.Options != nil || decoded.Data != nil {
+		t.Errorf("Decode() options = %v data = %v, want nil", decoded.Options, decoded.Data)
+	}
+
+	buf := make([]byte, 1500)
+	allocs := testing.AllocsPerRun(100, func() {
+		decoded.Decode(data)
+		decoded.VerifyChecksum(srcIP, dstIP)
+		decoded.SerializeTo(buf)
+	})
+	if allocs != 0 {
+		t.Errorf("Decode()/VerifyChecksum()/SerializeTo() allocs = %v, want 0", allocs)
+	}
+	if !decoded.VerifyChecksum(srcIP, dstIP) {
+		t.Error("VerifyChecksum() = false for a decoded segment")
+	}
+
+	// A clone keeps the decoded segment once the input is reused
+	clone := decoded.Clone()
+	data[len(data)-1] = '!'
+	if !bytes.Equal(clone.Data, []byte("Test data")) || !bytes.Equal(clone.Options, seg.Options) {
+		t.Errorf("Clone() options = %v data = %q, changed with the input", clone.Options, clone.Data)
+	}
+	if !clone.VerifyChecksum(srcIP, dstIP) {
+		t.Error("VerifyChecksum() = false for a clone")
+	}
+}
+
+func TestSegmentSerializeTo(t *testing.T) {
+	seg := NewSegment(12345, 80, 1000, 2000, FlagSYN, 65535, []byte("x"))
+	seg.Options = BuildWindowScaleOption(7) // 3 bytes, padded to 4
+
+	buf := bytes.Repeat([]byte{0xFF}, 100)
+	n, err := seg.SerializeTo(buf)
+	if err != nil {
+		t.Fatalf("SerializeTo() error = %v", err)
+	}
+	if len(seg.Options) != 3 {
+		t.Errorf("SerializeTo() changed options to %v", seg.Options)
+	}
+	want, _ := seg.Serialize()
+	if diff := common.DiffBytes(want, buf[:n]); diff != "" {
+		t.Errorf("SerializeTo() differs from Serialize(): %s", diff)
+	}
+	if _, err := seg.SerializeTo(buf[:n-1]); err == nil {
+		t.Error("SerializeTo() into a short buffer succeeded")
+	}
+}
+
+func TestSegmentChecksumWithoutSerializing(t *testing.T) {
+	srcIP := common.IPv4Address{192, 168, 1, 1}
+	dstIP := common.IPv4Address{192, 168, 1, 2}
+
+	tests := []struct {
+		name    string
+		options []byte
+		data    []byte
+	}{
+		{"bare", nil, nil},
+		{"odd data", nil, []byte("odd")},
+		{"mss", BuildMSSOption(1460), []byte("even")},
+		{"padded options", BuildWindowScaleOption(7), []byte("odd")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seg := NewSegment(12345, 80, 0xDEADBEEF, 0x01020304, FlagACK|FlagPSH, 65535, tt.data)
+			seg.Options = tt.options
+			seg.UrgentPointer = 7
+
+			// Calculated before Serialize pads the options
+			got, err := seg.CalculateChecksum(srcIP, dstIP)
+			if err != nil {
+				t.Fatalf("CalculateChecksum() error = %v", err)
+			}
+
+			// Reference: the checksum over the pseudo-header and the serialized segment
+			data, err := seg.Serialize()
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			ph := common.PseudoHeader{SourceAddr: srcIP, DestinationAddr: dstIP, Protocol: common.ProtocolTCP, Length: uint16(len(data))}
+			want := common.CalculateChecksum(append(ph.Bytes(), data...))
+			if got != want {
+				t.Errorf("CalculateChecksum() = 0x%04X, want 0x%04X", got, want)
+			}
+
+			seg.Checksum = got
+			allocs := testing.AllocsPerRun(100, func() {
+				if !seg.VerifyChecksum(srcIP, dstIP) {
+					t.Error("VerifyChecksum() = false")
+				}
+			})
+			if allocs != 0 {
+				t.Errorf("VerifyChecksum() allocs = %v, want 0", allocs)
+			}
+		})
+	}
+}
+
 func TestSegmentFlags(t *testing.T) {
 	seg := NewSegment(12345, 80, 1000, 2000, 0, 65535, nil)
 
@@ -212,3 +357,30 @@ func TestSegmentString(t *testing.T) {
 	// (We're not testing exact format, just that it's not empty)
 	t.Logf("Segment string: %s", str)
 }
+
+// benchmarkSegment returns a serialized segment with options and data.
+func benchmarkSegment() []byte {
+	seg := NewSegment(12345, 80, 1000, 2000, FlagACK|FlagPSH, 65535, make([]byte, 1400))
+	seg.Options = BuildMSSOption(1460)
+	data, _ := seg.Serialize()
+	return data
+}
+
+func BenchmarkParse(b *testing.B) {
+	data := benchmarkSegment()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = Parse(data)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	data := benchmarkSegment()
+	var seg Segment
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = seg.Decode(data)
+	}
+}
@@ -0,0 +1,46 @@
+package tcp
+
+import "sync"
+
+// Freelists for the objects the send path makes for every data segment:
+// the Segment and the retransmit queue's entry for it. Both are recycled
+// once the peer acknowledges the segment, rather than left to the garbage
+// collector, which at high rates spends a good part of the stack's time
+// on them. The segment's data comes from the send buffer and is not
+// pooled.
+//
+// A segment is only recycled once nothing of the stack's refers to it, so
+// the send functions a segment passes through must not keep it past the
+// ACK: they serialize it, or Clone it to keep it. Those that send later
+// do: pacing holds data back in the send buffer before it is made into
+// segments, and a qdisc shaper or the memory link queues a copy of the
+// serialized frame.
+
+// maxFreeEntries bounds the entries a retransmit queue keeps for reuse,
+// enough for a full window of 64 KB segments, or GSO super-segments.
+const maxFreeEntries = 256
+
+// segmentPool holds recycled segments, zeroed.
+var segmentPool = sync.Pool{
+	New: func() any { return new(Segment) },
+}
+
+// newPooledSegment is NewSegment for a segment from segmentPool, which
+// the retransmit queue recycles once it is acknowledged (see addPooled).
+func newPooledSegment(srcPort, dstPort uint16, seqNum, ackNum uint32, flags uint8, window uint16, data []byte) *Segment {
+	seg := segmentPool.Get().(*Segment)
+	seg.SourcePort, seg.DestinationPort = srcPort, dstPort
+	seg.SequenceNumber, seg.AckNumber = seqNum, ackNum
+	seg.DataOffset = 5
+	seg.Flags, seg.WindowSize = flags, window
+	seg.Data = data
+	return seg
+}
+
+// releaseSegment returns a segment to segmentPool. It is zeroed, so that
+// anything still holding it sees an empty segment rather than the next
+// one's.
+func releaseSegment(seg *Segment) {
+	*seg = Segment{}
+	segmentPool.Put(seg)
+}
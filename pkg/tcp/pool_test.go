@@ -0,0 +1,138 @@
+package tcp
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+	"github.com/therealutkarshpriyadarshi/network/pkg/qdisc"
+)
+
+// TestPooledSegmentsAfterRecycle sends paced data through a shaper on a
+// memory link, the send functions that hold data past onSegmentReady, and
+// checks that the data arrives intact although the sender recycles each
+// segment once it is acknowledged: they keep copies, not the segments.
+func TestPooledSegmentsAfterRecycle(t *testing.T) {
+	epA, epB := memory.NewPipe(memory.Config{Seed: 1})
+	shaper, err := qdisc.New(epA, qdisc.Config{Rate: 4 << 20})
+	if err != nil {
+		t.Fatalf("qdisc.New() error = %v", err)
+	}
+	t.Cleanup(func() { shaper.Close() })
+
+	client := NewConnection(testClientIP, 50893, testServerIP, 8893)
+	server := NewConnection(testServerIP, 8893, testClientIP, 50893)
+	server.state.SetState(StateListen)
+	client.opts.maxPacingRate = 1 << 20 // About 1ms between segments
+
+	type sent struct {
+		seg *Segment
+		seq uint32
+	}
+	var (
+		mu       sync.Mutex
+		segments []sent
+		received []byte
+	)
+	send := func(dev ethernet.Device, c *Connection, record bool) {
+		c.onSegmentReady = func(seg *Segment) error {
+			if record && len(seg.Data) > 0 {
+				mu.Lock()
+				segments = append(segments, sent{seg, seg.SequenceNumber})
+				mu.Unlock()
+			}
+			data, err := seg.Serialize()
+			if err != nil {
+				return err
+			}
+			pkt, err := ip.NewPacket(c.LocalAddr, c.RemoteAddr, common.ProtocolTCP, data).Serialize()
+			if err != nil {
+				return err
+			}
+			return dev.WriteFrame(ethernet.NewFrame(common.BroadcastMAC, dev.MACAddress(), common.EtherTypeIPv4, pkt))
+		}
+	}
+	send(shaper, client, true)
+	send(epB, server, false)
+	server.onDataReady = func(data []byte) {
+		mu.Lock()
+		received = append(received, data...)
+		mu.Unlock()
+	}
+	client.timeWait = newTestTimeWait(t, TimeWaitConfig{})
+	server.timeWait = newTestTimeWait(t, TimeWaitConfig{})
+
+	receive := func(dev ethernet.Device, c *Connection) {
+		for {
+			frame, err := dev.ReadFrame()
+			if err != nil {
+				return
+			}
+			pkt, err := ip.Parse(frame.Payload)
+			if err != nil {
+				t.Errorf("ip.Parse() error = %v", err)
+				return
+			}
+			seg, err := Parse(pkt.Payload)
+			if err != nil {
+				t.Errorf("Parse() error = %v", err)
+				return
+			}
+			if !seg.VerifyChecksum(pkt.Source, pkt.Destination) {
+				t.Errorf("segment %d: bad checksum", seg.SequenceNumber)
+			}
+			c.HandleSegment(seg)
+		}
+	}
+	go receive(epB, server)
+	go receive(shaper, client)
+
+	if err := client.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	waitFor(t, func() bool { return client.GetState() == StateEstablished })
+
+	want := make([]byte, 32*DefaultMSS)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+	if err := client.Send(want); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	waitFor(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.sndUna == client.sndNxt && client.sendBuffer.Len() == 0
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !bytes.Equal(received, want) {
+		t.Fatalf("received %d bytes, not the %d sent", len(received), len(want))
+	}
+	if len(segments) < 32 {
+		t.Fatalf("sent %d data segments, want at least 32", len(segments))
+	}
+	// Recycled: zeroed, or reused for a later segment
+	for _, s := range segments {
+		if s.seg.SequenceNumber == s.seq && s.seg.Data != nil {
+			t.Errorf("segment %d not recycled after its ACK", s.seq)
+		}
+	}
+}
+
+// waitFor polls cond until it holds, for up to five seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,46 @@
+package tcp
+
+import "fmt"
+
+// Flush sends the data in the send buffer that Nagle's algorithm holds
+// back, with PSH on its last segment. What the send or congestion window
+// holds back goes out as soon as the window opens, instead of waiting
+// for the outstanding data to be acknowledged; data written after Flush is
+// subject to Nagle's algorithm again.
+func (c *Connection) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.canFlush() {
+		return c.sendStateError()
+	}
+	if c.sendBuffer.Len() == 0 {
+		return nil
+	}
+	c.sndPush = c.sndNxt + uint32(c.sendBuffer.Len())
+	c.pushPending = true
+	return c.sendBuffered(true)
+}
+
+// flushing returns whether the next segment carries data a Flush pushed,
+// which Nagle's algorithm does not hold back. Called with c.mu held.
+func (c *Connection) flushing() bool {
+	if c.pushPending && !seqBefore(c.sndNxt, c.sndPush) {
+		c.pushPending = false
+	}
+	return c.pushPending
+}
+
+// Flush sends the small writes Nagle's algorithm holds back, with PSH, for
+// a protocol that has finished a message and waits for the answer. With
+// OptNoDelay set, writes go out at once and Flush has nothing to do.
+func (s *Socket) Flush() error {
+	s.mu.RLock()
+	conn := s.conn
+	s.mu.RUnlock()
+
+	if conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	return conn.Flush()
+}
@@ -0,0 +1,102 @@
+package tcp
+
+import (
+	"testing"
+)
+
+func TestPushLastSegment(t *testing.T) {
+	client, server := established(t)
+	client.conn.cwnd = 4 * uint32(client.conn.mss)
+
+	// Only the segment ending the write carries PSH
+	mss := int(client.conn.mss)
+	if err := client.conn.Send(make([]byte, 2*mss+10)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(client.out) != 3 {
+		t.Fatalf("sent %d segments, want 3", len(client.out))
+	}
+	for i, seg := range client.out {
+		if want := i == len(client.out)-1; seg.HasFlag(FlagPSH) != want {
+			t.Errorf("segment %d PSH = %v, want %v", i, seg.HasFlag(FlagPSH), want)
+		}
+	}
+	exchange(t, client, server)
+	if len(server.data) != 2*mss+10 {
+		t.Errorf("server received %d bytes, want %d", len(server.data), 2*mss+10)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	client, server := established(t)
+	client.conn.opts.noDelay = false
+
+	// Nagle's algorithm holds the second write until Flush
+	client.conn.Send([]byte("a"))
+	client.conn.Send([]byte("b"))
+	if len(client.out) != 1 {
+		t.Fatalf("sent %d segments with data unacknowledged, want 1", len(client.out))
+	}
+	if err := client.conn.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(client.out) != 2 || string(client.out[1].Data) != "b" || !client.out[1].HasFlag(FlagPSH) {
+		t.Fatalf("after Flush() sent %v, want the held data with PSH", client.out)
+	}
+	if err := client.conn.Flush(); err != nil {
+		t.Errorf("Flush() with nothing held error = %v", err)
+	}
+	if len(client.out) != 2 {
+		t.Errorf("second Flush() sent %d more segments, want none", len(client.out)-2)
+	}
+	exchange(t, client, server)
+
+	// Writes after Flush are held again
+	client.conn.Send([]byte("c"))
+	client.conn.Send([]byte("d"))
+	if len(client.out) != 1 {
+		t.Errorf("sent %d segments after Flush with data unacknowledged, want 1", len(client.out))
+	}
+	exchange(t, client, server)
+	if string(server.data) != "abcd" {
+		t.Errorf("server received %q, want %q", server.data, "abcd")
+	}
+}
+
+func TestFlushWindow(t *testing.T) {
+	client, server := established(t)
+	client.conn.opts.noDelay = false
+	mss := int(client.conn.mss)
+	client.conn.cwnd = uint32(mss)
+
+	// The congestion window holds back the tail of the write, and Nagle's
+	// algorithm would keep holding it while data is outstanding
+	client.conn.Send([]byte("a"))
+	client.conn.Send(make([]byte, mss+10))
+	if len(client.out) != 2 {
+		t.Fatalf("sent %d segments, want 2", len(client.out))
+	}
+	if err := client.conn.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(client.out) != 2 {
+		t.Fatalf("Flush() sent past the window: %v", client.out)
+	}
+
+	// The ACK of the first segment opens the window with the second still
+	// outstanding; the flushed data goes out anyway
+	sent := client.out
+	client.out = nil
+	deliver(t, &testPeer{out: sent[:1]}, server)
+	deliver(t, server, client)
+	if len(client.out) != 1 || len(client.out[0].Data) != 10 {
+		t.Fatalf("after the ACK sent %v, want the flushed 10 bytes", client.out)
+	}
+}
+
+func TestSocketFlushNotConnected(t *testing.T) {
+	s := NewSocket(testClientIP, 0)
+	if err := s.Flush(); err == nil {
+		t.Error("Flush() of an unconnected socket succeeded, want error")
+	}
+}
@@ -0,0 +1,387 @@
+package tcp
+
+import (
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+// Loss detection with RACK-TLP (RFC 8985), when the peer sends SACK
+// blocks. Rather than counting duplicate ACKs, RACK deems a segment lost
+// once a segment sent after it was delivered and a reordering window has
+// passed: the RTT of the delivered segment plus a fraction of the minimum
+// RTT, so that reordering in the network is not mistaken for loss. A
+// segment delivered out of order shows reordering, and a DSACK (RFC 2883)
+// reporting a needless retransmission widens the window.
+//
+// The Tail Loss Probe covers losses at the end of a flight, which no
+// later delivery reveals: two SRTTs after the last transmission with no
+// ACK, the connection sends one segment, new data or the last segment
+// again, so that the peer's ACK lets RACK find the losses before the
+// retransmission timeout.
+//
+// Peers that do not send SACK blocks are left to fast retransmit on three
+// duplicate ACKs.
+const (
+	// rackReoWndPersist is the number of loss recoveries a widened
+	// reordering window lasts (RFC 8985 Section 6.2)
+	rackReoWndPersist = 16
+
+	// tlpDelayedAck is added to the probe timeout when a single segment
+	// is outstanding, whose ACK the peer may delay (WCDelAckT in RFC 8985
+	// Section 7.2)
+	tlpDelayedAck = 200 * time.Millisecond
+
+	// dupThresh is the number of segments SACKed after one that makes it
+	// lost without waiting for reordering, when none was seen
+	dupThresh = 3
+)
+
+// rackState is the RACK-TLP state of a connection.
+type rackState struct {
+	// The most recently sent segment delivered: when it was sent, where
+	// it ends and its RTT. Segments sent before it are lost after their
+	// RTT and the reordering window.
+	xmitTime time.Time
+	endSeq   uint32
+	rtt      time.Duration
+
+	minRTT     time.Duration
+	fack       uint32 // Highest end of a segment delivered
+	reordering bool   // A segment was delivered out of order
+	reoWndIncr int    // Minimum RTT quarters added to the window by DSACKs
+	reoWndLeft int    // Recoveries until reoWndIncr resets
+	sackSeen   bool   // The peer sends SACK blocks
+
+	// Loss recovery, until sndUna reaches recoveryEnd. After a
+	// retransmission timeout, which answered for the losses of the
+	// flight already, recovery does not cut the window again.
+	inRecovery  bool
+	afterRTO    bool
+	recoveryEnd uint32
+
+	// A loss probe is outstanding up to tlpEnd, and whether it was a
+	// retransmission of the segment starting at tlpSeq
+	tlpOutstanding bool
+	tlpRetrans     bool
+	tlpSeq, tlpEnd uint32
+}
+
+// sackBlocks returns the SACK blocks of an ACK, and its DSACK block if it
+// reports data received twice (RFC 2883 Section 4): the first block,
+// below the cumulative ACK or inside the second block.
+func (c *Connection) sackBlocks(seg *Segment) (blocks []SACKBlock, dsack *SACKBlock) {
+	if !c.sackOK || len(seg.Options) == 0 {
+		return nil, nil
+	}
+	blocks, err := seg.GetSACKBlocks()
+	if err != nil || len(blocks) == 0 {
+		return nil, nil
+	}
+	first := blocks[0]
+	if !seqAfter(first.RightEdge, seg.AckNumber) ||
+		len(blocks) > 1 && !seqBefore(first.LeftEdge, blocks[1].LeftEdge) && !seqAfter(first.RightEdge, blocks[1].RightEdge) {
+		return blocks[1:], &first
+	}
+	return blocks, nil
+}
+
+// rackSACK marks the segments an ACK selectively acknowledges, and
+// returns them and its DSACK block, if any. Called before the ACK moves
+// sndUna.
+func (c *Connection) rackSACK(seg *Segment) ([]*RetransmitEntry, *SACKBlock) {
+	if seqBefore(c.rack.fack, c.sndUna) || seqAfter(c.rack.fack, c.sndNxt) {
+		c.rack.fack = c.sndUna
+	}
+	blocks, dsack := c.sackBlocks(seg)
+	return c.retransmitQueue.MarkSACKed(blocks), dsack
+}
+
+// rackAck runs RACK for an ACK: it updates the state from the segments
+// the ACK newly delivered, and retransmits those it finds lost. The ACK
+// has already moved sndUna.
+func (c *Connection) rackAck(delivered []*RetransmitEntry, dsack *SACKBlock, now time.Time) {
+	if dsack != nil {
+		c.rack.reoWndIncr++
+		c.rack.reoWndLeft = rackReoWndPersist
+	}
+	for _, e := range delivered {
+		c.rackDelivered(e, now)
+	}
+
+	if c.rack.afterRTO && !seqBefore(c.sndUna, c.rack.recoveryEnd) {
+		c.rack.afterRTO = false
+	}
+	if c.rack.inRecovery && !seqBefore(c.sndUna, c.rack.recoveryEnd) {
+		c.rack.inRecovery = false
+		if c.rack.reoWndLeft > 0 {
+			if c.rack.reoWndLeft--; c.rack.reoWndLeft == 0 {
+				c.rack.reoWndIncr = 0
+			}
+		}
+	}
+	c.tlpAck(dsack)
+
+	// F-RTO decides first whether the segments a timeout left are lost
+	if c.rack.sackSeen && c.frto.step == frtoIdle {
+		c.rackDetectLoss(now)
+	}
+}
+
+// rackDelivered updates the state with a segment the peer received (RFC
+// 8985 Section 6.2, steps 1 to 3).
+func (c *Connection) rackDelivered(e *RetransmitEntry, now time.Time) {
+	end := e.SeqNum + segmentLength(e.Segment)
+	if e.SACKed {
+		c.rack.sackSeen = true
+	}
+
+	// Without timestamps, an ACK of a retransmitted segment sooner than
+	// the minimum RTT must be for the original
+	rtt := now.Sub(e.SentTime)
+	if e.RetryCount > 0 && rtt < c.rack.minRTT {
+		return
+	}
+	if c.rack.minRTT == 0 || rtt < c.rack.minRTT {
+		c.rack.minRTT = rtt
+	}
+	if e.SentTime.After(c.rack.xmitTime) || e.SentTime.Equal(c.rack.xmitTime) && seqAfter(end, c.rack.endSeq) {
+		c.rack.xmitTime, c.rack.endSeq, c.rack.rtt = e.SentTime, end, rtt
+	}
+
+	if seqBefore(end, c.rack.fack) && e.RetryCount == 0 {
+		c.rack.reordering = true
+	} else if seqAfter(end, c.rack.fack) {
+		c.rack.fack = end
+	}
+}
+
+// rackReoWnd returns the reordering window (RFC 8985 Section 6.2, step
+// 4). Until reordering is seen, it is zero in recovery and once enough
+// segments after a hole were SACKed, as with duplicate ACKs.
+func (c *Connection) rackReoWnd(sacked int) time.Duration {
+	if !c.rack.reordering && (c.rack.inRecovery || sacked >= dupThresh) {
+		return 0
+	}
+	return min(time.Duration(c.rack.reoWndIncr+1)*c.rack.minRTT/4, c.srtt)
+}
+
+// rackDetectLoss marks the segments sent before the last one delivered,
+// longer ago than its RTT and the reordering window, as lost, retransmits
+// the lost segments the congestion window has room for, and arms the reordering timer for those not lost yet (RFC 8985
+// Section 6.2, step 5).
+func (c *Connection) rackDetectLoss(now time.Time) {
+	entries := c.retransmitQueue.Entries()
+	reoWnd := c.rackReoWnd(c.retransmitQueue.SACKed())
+
+	var wait time.Duration
+	lost, pending := 0, 0
+	for _, e := range entries {
+		if e.Lost {
+			pending++
+		}
+		if e.SACKed || e.Lost {
+			continue
+		}
+		end := e.SeqNum + segmentLength(e.Segment)
+		if !c.rack.xmitTime.After(e.SentTime) && !(c.rack.xmitTime.Equal(e.SentTime) && seqAfter(c.rack.endSeq, end)) {
+			continue
+		}
+		if remaining := e.SentTime.Add(c.rack.rtt + reoWnd).Sub(now); remaining > 0 {
+			if remaining > wait {
+				wait = remaining
+			}
+			continue
+		}
+		e.Lost = true
+		lost++
+	}
+
+	if lost > 0 {
+		c.stats.rackLosses += uint64(lost)
+		if !c.rack.inRecovery && !c.rack.afterRTO {
+			c.enterRecovery()
+		}
+	}
+	if lost+pending > 0 {
+		c.retransmitLost(entries, now)
+	}
+	if wait > 0 {
+		c.armRACKTimer(wait)
+	} else if c.rackTimer != nil {
+		c.rackTimer.Stop()
+	}
+}
+
+// enterRecovery halves the congestion window once for the losses of a
+// flight, until what was outstanding is acknowledged.
+func (c *Connection) enterRecovery() {
+	c.rack.inRecovery, c.rack.recoveryEnd = true, c.sndNxt
+	c.ssthresh = (c.sndNxt - c.sndUna) / 2
+	if c.ssthresh < uint32(c.mss)*2 {
+		c.ssthresh = uint32(c.mss) * 2
+	}
+	c.cwnd = c.ssthresh
+	c.recordCwnd()
+}
+
+// retransmitLost sends the segments marked lost again, oldest first, as
+// the congestion window allows, though at least one.
+func (c *Connection) retransmitLost(entries []*RetransmitEntry, now time.Time) {
+	pipe, sent := c.retransmitQueue.Pipe(), 0
+	for _, e := range entries {
+		if !e.Lost {
+			continue
+		}
+		size := int(segmentLength(e.Segment))
+		if sent > 0 && pipe+size > int(c.cwnd) {
+			break
+		}
+		seg := c.retransmit(e.Segment)
+		c.retransmitQueue.UpdateSentTime(e.SeqNum, now)
+		c.stats.retransmissions++
+		stackCounters.retransmissions.Add(1)
+		logger.Debug("RACK retransmit", c.logID(), logging.F("seq", seg.SequenceNumber), logging.F("cwnd", c.cwnd))
+		pipe += size
+		sent++
+	}
+	c.armRetransmitTimer(true)
+}
+
+// armRACKTimer arms the reordering timer, to detect losses again when the
+// window of the oldest segment not yet lost has passed.
+func (c *Connection) armRACKTimer(d time.Duration) {
+	if c.rackTimer == nil {
+		c.rackTimer = c.timers.AfterFunc(d, c.rackTimeout)
+	} else {
+		c.rackTimer.Reset(d)
+	}
+}
+
+// rackTimeout runs loss detection when the reordering timer expires.
+func (c *Connection) rackTimeout() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.retransmitQueue.Len() == 0 || !c.canFlush() {
+		return
+	}
+	c.rackDetectLoss(time.Now())
+}
+
+// armLossProbe schedules a tail loss probe two SRTTs after the last
+// transmission, before the retransmission timer (RFC 8985 Section 7.2).
+// No probe is sent during loss recovery, after a timeout or otherwise,
+// while one is outstanding or before an RTT is measured.
+func (c *Connection) armLossProbe() {
+	if !c.sackOK || c.srtt == 0 || c.rack.inRecovery || c.rack.afterRTO || c.rack.tlpOutstanding ||
+		c.retransmitQueue.Len() == 0 || !c.state.GetState().IsConnectionEstablished() {
+		c.stopLossProbe()
+		return
+	}
+	pto := 2 * c.srtt
+	if c.sndNxt-c.sndUna <= uint32(c.mss) {
+		pto += tlpDelayedAck
+	}
+	if pto < c.timers.tick {
+		pto = c.timers.tick
+	}
+	pto = min(pto, c.rto)
+
+	if c.tlpTimer == nil {
+		c.tlpTimer = c.timers.AfterFunc(pto, c.lossProbeTimeout)
+	} else {
+		c.tlpTimer.Reset(pto)
+	}
+}
+
+// stopLossProbe stops the loss probe timer.
+func (c *Connection) stopLossProbe() {
+	if c.tlpTimer != nil {
+		c.tlpTimer.Stop()
+	}
+}
+
+// lossProbeTimeout sends a tail loss probe (RFC 8985 Section 7.3): new
+// data if the windows allow, the last segment again otherwise.
+func (c *Connection) lossProbeTimeout() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := c.retransmitQueue.Entries()
+	if len(entries) == 0 || c.rack.inRecovery || !c.canFlush() {
+		return
+	}
+	c.rack.tlpOutstanding, c.rack.tlpRetrans = true, false
+
+	sent := c.sndNxt
+	if c.sendBuffer.Len() > 0 {
+		c.sendBuffered(true)
+	}
+	if c.sndNxt == sent {
+		last := entries[len(entries)-1]
+		seg := c.retransmit(last.Segment)
+		c.retransmitQueue.UpdateSentTime(last.SeqNum, time.Now())
+		c.stats.retransmissions++
+		stackCounters.retransmissions.Add(1)
+		c.rack.tlpRetrans, c.rack.tlpSeq = true, last.SeqNum
+		logger.Debug("tail loss probe", c.logID(), logging.F("seq", seg.SequenceNumber))
+	}
+	c.rack.tlpEnd = c.sndNxt
+	c.stats.lossProbes++
+	c.armRetransmitTimer(true)
+}
+
+// tlpAck ends an outstanding loss probe once an ACK covers it (RFC 8985
+// Section 7.4). A retransmitted probe the peer does not report with a
+// DSACK repaired a loss, which the congestion window answers for.
+func (c *Connection) tlpAck(dsack *SACKBlock) {
+	if !c.rack.tlpOutstanding || seqBefore(c.sndUna, c.rack.tlpEnd) {
+		return
+	}
+	c.rack.tlpOutstanding = false
+	if !c.rack.tlpRetrans || dsack != nil && seqBefore(dsack.LeftEdge, c.rack.tlpEnd) && seqAfter(dsack.RightEdge, c.rack.tlpSeq) {
+		return
+	}
+	c.ssthresh = c.cwnd / 2
+	if c.ssthresh < uint32(c.mss)*2 {
+		c.ssthresh = uint32(c.mss) * 2
+	}
+	c.cwnd = c.ssthresh
+	c.recordCwnd()
+}
+
+// stopLossTimers stops the reordering and loss probe timers when the
+// connection closes.
+func (c *Connection) stopLossTimers() {
+	if c.rackTimer != nil {
+		c.rackTimer.Stop()
+	}
+	c.stopLossProbe()
+}
+
+// rackRTO resets loss detection on a retransmission timeout (RFC 8985
+// Section 6.3). Segments after the first, which the timeout sent again,
+// are lost unless SACKed, and go out as the ACKs of the first make room;
+// while F-RTO checks whether the timeout was spurious, they wait for it
+// to decide.
+func (c *Connection) rackRTO() {
+	c.rack.inRecovery, c.rack.tlpOutstanding = false, false
+	c.rack.afterRTO, c.rack.recoveryEnd = true, c.sndNxt
+	c.stopLossTimers()
+	if c.rack.sackSeen && c.frto.step == frtoIdle {
+		if head := c.retransmitQueue.GetFirst(); head != nil {
+			c.rackMarkLost(head.SequenceNumber+segmentLength(head), c.sndNxt)
+		}
+	}
+}
+
+// rackMarkLost marks the segments starting from start and before end lost,
+// unless SACKed.
+func (c *Connection) rackMarkLost(start, end uint32) {
+	for _, e := range c.retransmitQueue.Range(start, end) {
+		if !e.SACKed {
+			e.Lost = true
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package tcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// sackPeers returns an established client and server that negotiated
+// SACK, with the client's timers on a manual wheel and room in its
+// congestion window for ten segments.
+func sackPeers(t *testing.T) (*testPeer, *testPeer, *TimerWheel) {
+	t.Helper()
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	wheel := manualWheel()
+	client.conn.timers = wheel
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+	if !client.conn.sackOK || !server.conn.sackOK {
+		t.Fatalf("sackOK = %v and %v after the handshake, want true", client.conn.sackOK, server.conn.sackOK)
+	}
+	client.conn.cwnd = 10 * DefaultMSS
+	return client, server, wheel
+}
+
+// sendSegments has the client send n segments of one MSS, and returns
+// them.
+func sendSegments(t *testing.T, client *testPeer, n int) []*Segment {
+	t.Helper()
+	if err := client.conn.Send(make([]byte, n*DefaultMSS)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	segs := client.out
+	client.out = nil
+	if len(segs) != n {
+		t.Fatalf("sent %d segments, want %d", len(segs), n)
+	}
+	return segs
+}
+
+// ackWithSACK passes the client an ACK from the server of ack, with SACK
+// blocks for segments, as a peer keeping out-of-order data sends.
+func ackWithSACK(t *testing.T, client, server *testPeer, ack uint32, sacked ...*Segment) {
+	t.Helper()
+	var blocks []SACKBlock
+	for _, seg := range sacked {
+		blocks = append(blocks, SACKBlock{seg.SequenceNumber, seg.SequenceNumber + uint32(len(seg.Data))})
+	}
+	seg := NewSegment(80, 50000, server.conn.sndNxt, ack, FlagACK, 65535, nil)
+	seg.Options = BuildSACKOption(blocks)
+	seg.Checksum, _ = server.conn.checksum(seg)
+	server.out = append(server.out, seg)
+	deliver(t, server, client)
+}
+
+// backdate moves the send times of the client's outstanding segments back
+// by d, as if d had passed. The segments are sent before the RTT is set,
+// which would pace them.
+func backdate(client *testPeer, d time.Duration) {
+	for _, e := range client.conn.retransmitQueue.Entries() {
+		e.SentTime = e.SentTime.Add(-d)
+	}
+	client.conn.rack.xmitTime = client.conn.rack.xmitTime.Add(-d)
+}
+
+func TestRACKLoss(t *testing.T) {
+	client, server, _ := sackPeers(t)
+	segs := sendSegments(t, client, 5)
+	una := segs[0].SequenceNumber
+	backdate(client, 100*time.Millisecond)
+	client.conn.srtt = 100 * time.Millisecond
+
+	// The first segment is lost; SACKs of the three after it make it
+	// lost at once, as none was reordered, rather than after the
+	// reordering window
+	ackWithSACK(t, client, server, una, segs[1])
+	ackWithSACK(t, client, server, una, segs[1], segs[2])
+	if len(client.out) != 0 {
+		t.Fatalf("retransmitted %v after 2 SACKed segments, want nothing", client.out)
+	}
+	ackWithSACK(t, client, server, una, segs[1], segs[2], segs[3])
+	if len(client.out) != 1 || client.out[0].SequenceNumber != una {
+		t.Fatalf("retransmitted %v, want the first segment", client.out)
+	}
+	if !client.conn.rack.inRecovery || client.conn.cwnd != 5*DefaultMSS/2 {
+		t.Errorf("cwnd = %d in recovery %v, want %d in recovery", client.conn.cwnd, client.conn.rack.inRecovery, 5*DefaultMSS/2)
+	}
+	if stats := client.conn.Stats(); stats.RACKLosses != 1 || stats.Retransmissions != 1 {
+		t.Errorf("Stats() = %d losses, %d retransmissions, want 1 and 1", stats.RACKLosses, stats.Retransmissions)
+	}
+
+	// The ACK of all of it ends recovery
+	client.out = nil
+	ackWithSACK(t, client, server, client.conn.sndNxt)
+	if client.conn.rack.inRecovery || client.conn.retransmitQueue.Len() != 0 {
+		t.Errorf("in recovery %v with %d segments outstanding, want neither", client.conn.rack.inRecovery, client.conn.retransmitQueue.Len())
+	}
+}
+
+func TestRACKReorderingWindow(t *testing.T) {
+	client, server, wheel := sackPeers(t)
+	segs := sendSegments(t, client, 6)
+	backdate(client, 100*time.Millisecond)
+	client.conn.srtt = 100 * time.Millisecond
+
+	// One SACKed segment after the first could be reordering: the first
+	// is lost only once a quarter of the minimum RTT more has passed
+	ackWithSACK(t, client, server, segs[0].SequenceNumber, segs[1])
+	if len(client.out) != 0 {
+		t.Fatalf("retransmitted %v at once, want nothing inside the reordering window", client.out)
+	}
+	if client.conn.rackTimer == nil || !client.conn.rackTimer.Pending() {
+		t.Fatal("reordering timer not running")
+	}
+
+	// It was: the first segment arrives inside the window
+	ackWithSACK(t, client, server, segs[3].SequenceNumber)
+	if len(client.out) != 0 || !client.conn.rack.reordering {
+		t.Fatalf("retransmitted %v, reordering seen %v, want nothing and reordering seen", client.out, client.conn.rack.reordering)
+	}
+	if client.conn.rackTimer.Pending() {
+		t.Error("reordering timer running with no segment sent before one delivered")
+	}
+
+	// Once the window has passed, the segments sent before one SACKed
+	// are lost
+	ackWithSACK(t, client, server, segs[3].SequenceNumber, segs[5])
+	if len(client.out) != 0 {
+		t.Fatalf("retransmitted %v at once, want nothing inside the reordering window", client.out)
+	}
+	backdate(client, 50*time.Millisecond)
+	advanceWheel(wheel, 50)
+	if len(client.out) != 2 || client.out[0].SequenceNumber != segs[3].SequenceNumber || client.out[1].SequenceNumber != segs[4].SequenceNumber {
+		t.Fatalf("retransmitted %v after the reordering window, want the 2 segments before the SACKed one", client.out)
+	}
+}
+
+func TestRACKWithoutSACKBlocks(t *testing.T) {
+	// A peer permitting SACK but sending none is left to three duplicate
+	// ACKs
+	client, server, _ := sackPeers(t)
+	segs := sendSegments(t, client, 4)
+	for i := 0; i < 3; i++ {
+		ackWithSACK(t, client, server, segs[0].SequenceNumber)
+	}
+	if len(client.out) != 1 || client.out[0].SequenceNumber != segs[0].SequenceNumber {
+		t.Errorf("retransmitted %v after 3 duplicate ACKs, want the first segment", client.out)
+	}
+}
+
+func TestTailLossProbe(t *testing.T) {
+	client, server, wheel := sackPeers(t)
+	segs := sendSegments(t, client, 2)
+
+	// The last segment is lost: the probe sends it again well before the
+	// retransmission timeout
+	client.out = segs[:1]
+	exchange(t, client, server)
+	cwnd := client.conn.cwnd
+	if client.conn.tlpTimer == nil || !client.conn.tlpTimer.Pending() {
+		t.Fatal("loss probe timer not running")
+	}
+	for i := 0; i < int((2*client.conn.srtt+tlpDelayedAck)/time.Millisecond)+2 && len(client.out) == 0; i++ {
+		advanceWheel(wheel, 1)
+	}
+	if len(client.out) != 1 || !bytes.Equal(client.out[0].Data, segs[1].Data) {
+		t.Fatalf("probe sent %v, want the last segment", client.out)
+	}
+	if stats := client.conn.Stats(); stats.LossProbes != 1 {
+		t.Errorf("Stats().LossProbes = %d, want 1", stats.LossProbes)
+	}
+
+	// The probe repaired a loss, which halves the congestion window the
+	// ACK grew
+	exchange(t, client, server)
+	if len(server.data) != 2*DefaultMSS {
+		t.Errorf("server received %d bytes, want %d", len(server.data), 2*DefaultMSS)
+	}
+	if want := (cwnd + DefaultMSS) / 2; client.conn.rack.tlpOutstanding || client.conn.cwnd != want {
+		t.Errorf("cwnd = %d with probe outstanding %v, want %d and none", client.conn.cwnd, client.conn.rack.tlpOutstanding, want)
+	}
+	if client.conn.tlpTimer.Pending() {
+		t.Error("loss probe timer running with nothing outstanding")
+	}
+}
+
+func TestSACKBlocks(t *testing.T) {
+	c := NewConnection(testClientIP, 50000, testServerIP, 80)
+	c.sackOK = true
+	tests := []struct {
+		name   string
+		blocks []SACKBlock
+		want   int
+		dsack  bool
+	}{
+		{"none", nil, 0, false},
+		{"above the ACK", []SACKBlock{{2000, 3000}, {4000, 5000}}, 2, false},
+		{"DSACK below the ACK", []SACKBlock{{500, 1000}, {2000, 3000}}, 1, true},
+		{"DSACK inside the next block", []SACKBlock{{2000, 2500}, {2000, 3000}}, 1, true},
+	}
+	for _, tt := range tests {
+		seg := NewSegment(80, 50000, 1, 1000, FlagACK, 65535, nil)
+		seg.Options = BuildSACKOption(tt.blocks)
+		blocks, dsack := c.sackBlocks(seg)
+		if len(blocks) != tt.want || (dsack != nil) != tt.dsack {
+			t.Errorf("%s: sackBlocks() = %v, %v, want %d blocks, DSACK %v", tt.name, blocks, dsack, tt.want, tt.dsack)
+		}
+	}
+}
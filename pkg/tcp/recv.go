@@ -2,6 +2,10 @@
 package tcp
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"sync"
 )
 
@@ -103,3 +107,147 @@ func (rb *ReceiveBuffer) Clear() {
 
 	rb.buffer = rb.buffer[:0]
 }
+
+// errConnectionClosed is returned by reads once the data before the peer's
+// FIN, or the connection closing, was read.
+var errConnectionClosed = errors.New("connection closed")
+
+// socketQueue holds the data a connection has delivered to a Socket until
+// the application reads it. Its size is bounded by the receive window the
+// connection advertises, not by the queue itself.
+type socketQueue struct {
+	mu       sync.Mutex
+	data     []byte
+	closed   bool
+	shutDown bool          // Reading was shut down; data is dropped
+	ready    chan struct{} // Signalled when data is added or the queue closed
+
+	// The urgent mark's offset in data, and the urgent byte taken out of
+	// the stream, if any
+	mark   int
+	marked bool
+	oob    byte
+	hasOOB bool
+}
+
+func newSocketQueue() *socketQueue {
+	return &socketQueue{ready: make(chan struct{}, 1)}
+}
+
+// push adds delivered data to the queue, and returns false if it was
+// dropped because reading was shut down.
+func (q *socketQueue) push(data []byte) bool {
+	q.mu.Lock()
+	if q.shutDown {
+		q.mu.Unlock()
+		return false
+	}
+	q.data = append(q.data, data...)
+	q.mu.Unlock()
+	q.signal()
+	return true
+}
+
+// shutdown closes the queue and drops its data, returning how many bytes
+// were dropped.
+func (q *socketQueue) shutdown() int {
+	q.mu.Lock()
+	n := len(q.data)
+	q.data, q.closed, q.shutDown = nil, true, true
+	q.marked, q.hasOOB = false, false
+	q.mu.Unlock()
+	q.signal()
+	return n
+}
+
+// close marks the end of the data, once the peer sent its FIN or the
+// connection is closed.
+func (q *socketQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *socketQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// read copies queued data into buf, waiting until there is some, the queue
+// is closed, ctx is done or the deadline passes. Data that does not fit buf
+// stays queued for the next read.
+func (q *socketQueue) read(ctx context.Context, buf []byte, deadline <-chan struct{}) (int, error) {
+	for {
+		q.mu.Lock()
+		if len(q.data) > 0 {
+			data := q.data
+			if q.marked && q.mark > 0 {
+				data = data[:q.mark] // A read stops at the urgent mark
+			}
+			n := copy(buf, data)
+			q.data = q.data[n:]
+			if q.marked {
+				if q.mark == 0 {
+					q.marked = false
+				} else {
+					q.mark -= n
+				}
+			}
+			more := len(q.data) > 0 || q.closed
+			q.mu.Unlock()
+			if more {
+				// Another reader may be waiting for the rest
+				q.signal()
+			}
+			return n, nil
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			q.signal()
+			return 0, errConnectionClosed
+		}
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-deadline:
+			return 0, fmt.Errorf("receive: %w", os.ErrDeadlineExceeded)
+		}
+	}
+}
+
+// urgent sets the urgent mark at the end of the data queued so far, and
+// keeps the urgent byte for readOOB unless it is inline, where the mark
+// is.
+func (q *socketQueue) urgent(b byte, inline bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shutDown {
+		return
+	}
+	q.mark, q.marked = len(q.data), true
+	q.oob, q.hasOOB = b, !inline
+}
+
+// atMark returns whether the next read starts at the urgent mark.
+func (q *socketQueue) atMark() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.marked && q.mark == 0
+}
+
+// readOOB returns the urgent byte taken out of the stream, once.
+func (q *socketQueue) readOOB() (byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.hasOOB {
+		return 0, false
+	}
+	q.hasOOB = false
+	return q.oob, true
+}
@@ -0,0 +1,100 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+var (
+	// ErrConnectionRefused is the error of a connection whose SYN the peer
+	// answered with an RST, as for a port nothing listens on.
+	ErrConnectionRefused = errors.New("connection refused")
+
+	// ErrConnectionReset is the error of a connection the peer reset.
+	ErrConnectionReset = errors.New("connection reset by peer")
+)
+
+// handleReset processes a segment with RST set (RFC 793 Section 3.9, with
+// the RFC 5961 Section 3.2 checks against blind resets). An RST that does
+// not check out is dropped, or in the synchronized states answered with a
+// challenge ACK, so a peer that really lost the connection resets it
+// again with the right sequence number. Called with c.mu held.
+func (c *Connection) handleReset(seg *Segment) {
+	state := c.state.GetState()
+	switch state {
+	case StateListen, StateClosed:
+		return
+
+	case StateSynSent:
+		// Only an RST acknowledging our SYN is acceptable
+		if !seg.HasFlag(FlagACK) || !seqAfter(seg.AckNumber, c.iss) || seqAfter(seg.AckNumber, c.sndNxt) {
+			logger.Debug("ignoring unacceptable RST", c.logID(), logging.F("ack", seg.AckNumber))
+			return
+		}
+		c.err = ErrConnectionRefused
+		c.abort()
+		return
+	}
+
+	if seg.SequenceNumber != c.rcvNxt {
+		if c.inReceiveWindow(seg.SequenceNumber) {
+			c.sendChallengeACK()
+		}
+		logger.Debug("ignoring RST out of sequence", c.logID(), logging.F("seq", seg.SequenceNumber), logging.F("rcv_nxt", c.rcvNxt))
+		return
+	}
+
+	switch state {
+	case StateSynReceived:
+		// A passive open is dropped and the listener goes on listening;
+		// an active (simultaneous) open is refused
+		if c.passive {
+			c.err = ErrConnectionReset
+		} else {
+			c.err = ErrConnectionRefused
+		}
+	case StateEstablished, StateFinWait1, StateFinWait2, StateCloseWait:
+		c.err = ErrConnectionReset
+	}
+	// In CLOSING and LAST_ACK the peer just ends the close early
+	logger.Debug("connection reset", c.logID(), logging.F("state", state))
+	c.abort()
+}
+
+// inReceiveWindow returns whether seq falls in the receive window.
+func (c *Connection) inReceiveWindow(seq uint32) bool {
+	if c.rcvWnd == 0 {
+		return seq == c.rcvNxt
+	}
+	return !seqBefore(seq, c.rcvNxt) && seqBefore(seq, c.rcvNxt+uint32(c.rcvWnd))
+}
+
+// sendAck sends a bare ACK of everything received.
+func (c *Connection) sendAck() {
+	ack := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagACK, c.rcvWnd, nil)
+	ack.Checksum, _ = c.checksum(ack)
+	if c.onSegmentReady != nil {
+		c.transmit(ack)
+	}
+}
+
+// abortError returns the error that aborted the connection, for reads and
+// writes on it to fail with, or nil if it was not aborted for an error.
+// Called with c.mu held.
+func (c *Connection) abortError() error {
+	if !c.aborted {
+		return nil
+	}
+	return c.err
+}
+
+// sendStateError returns the error of a send the state does not allow.
+// Called with c.mu held.
+func (c *Connection) sendStateError() error {
+	if err := c.abortError(); err != nil {
+		return err
+	}
+	return fmt.Errorf("cannot send data in state %s", c.state.GetState())
+}
@@ -0,0 +1,205 @@
+package tcp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// established returns a client and a server test peer with the handshake
+// done.
+func established(t *testing.T) (*testPeer, *testPeer) {
+	t.Helper()
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+	return client, server
+}
+
+// injectReset hands conn an RST from its peer with the given sequence and
+// acknowledgment numbers.
+func injectReset(t *testing.T, conn *Connection, seq, ack uint32, flags uint8) {
+	t.Helper()
+	rst := NewSegment(conn.RemotePort, conn.LocalPort, seq, ack, FlagRST|flags, 0, nil)
+	rst.Checksum, _ = rst.CalculateChecksum(conn.RemoteAddr, conn.LocalAddr)
+	if err := conn.HandleSegment(rst); err != nil {
+		t.Fatalf("HandleSegment(RST) error = %v", err)
+	}
+}
+
+func TestResetStates(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T) *Connection
+		wantErr error
+	}{
+		{"SYN_RECEIVED", func(t *testing.T) *Connection {
+			client, server := newTestPeer(true, nil), newTestPeer(false, nil)
+			client.conn.ActiveOpen()
+			deliver(t, client, server)
+			return server.conn
+		}, ErrConnectionReset},
+		{"ESTABLISHED", func(t *testing.T) *Connection {
+			client, _ := established(t)
+			return client.conn
+		}, ErrConnectionReset},
+		{"FIN_WAIT_1", func(t *testing.T) *Connection {
+			client, _ := established(t)
+			client.conn.Close()
+			return client.conn
+		}, ErrConnectionReset},
+		{"FIN_WAIT_2", func(t *testing.T) *Connection {
+			client, server := established(t)
+			client.conn.Close()
+			deliver(t, client, server)
+			deliver(t, server, client)
+			return client.conn
+		}, ErrConnectionReset},
+		{"CLOSE_WAIT", func(t *testing.T) *Connection {
+			client, server := established(t)
+			client.conn.Close()
+			deliver(t, client, server)
+			return server.conn
+		}, ErrConnectionReset},
+		{"LAST_ACK", func(t *testing.T) *Connection {
+			client, server := established(t)
+			client.conn.Close()
+			deliver(t, client, server)
+			server.conn.Close()
+			return server.conn
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := tt.setup(t)
+			if got := conn.GetState().String(); got != tt.name {
+				t.Fatalf("state = %v, want %v", got, tt.name)
+			}
+
+			// An RST outside the window is ignored
+			injectReset(t, conn, conn.rcvNxt+uint32(conn.rcvWnd)+1000, 0, 0)
+			if got := conn.GetState().String(); got != tt.name {
+				t.Fatalf("state = %v after an RST outside the window, want %v", got, tt.name)
+			}
+
+			injectReset(t, conn, conn.rcvNxt, 0, 0)
+			if got := conn.GetState(); got != StateClosed {
+				t.Errorf("state = %v after the RST, want %v", got, StateClosed)
+			}
+			if err := conn.Err(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Err() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResetChallengeAck(t *testing.T) {
+	client, _ := established(t)
+
+	// An RST in the window but not at its left edge may be blind; the
+	// challenge ACK lets a real peer reset with the right number
+	injectReset(t, client.conn, client.conn.rcvNxt+10, 0, 0)
+	if got := client.conn.GetState(); got != StateEstablished {
+		t.Fatalf("state = %v after an inexact RST, want %v", got, StateEstablished)
+	}
+	if len(client.out) != 1 || !client.out[0].HasFlag(FlagACK) || client.out[0].AckNumber != client.conn.rcvNxt {
+		t.Errorf("answered the RST with %v, want a challenge ACK", client.out)
+	}
+}
+
+func TestResetSynSent(t *testing.T) {
+	conn := newTestPeer(true, nil).conn
+	if err := conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+
+	// An RST must acknowledge the SYN
+	injectReset(t, conn, 0, conn.iss+1000, FlagACK)
+	injectReset(t, conn, 0, 0, 0)
+	if got := conn.GetState(); got != StateSynSent {
+		t.Fatalf("state = %v after unacceptable RSTs, want %v", got, StateSynSent)
+	}
+
+	injectReset(t, conn, 0, conn.sndNxt, FlagACK)
+	if got := conn.GetState(); got != StateClosed {
+		t.Errorf("state = %v, want %v", got, StateClosed)
+	}
+	if err := conn.Err(); !errors.Is(err, ErrConnectionRefused) {
+		t.Errorf("Err() = %v, want %v", err, ErrConnectionRefused)
+	}
+}
+
+func TestResetMidTransfer(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server, s := readingPeer(DefaultReceiveBufferSize)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+	if err := client.conn.Send([]byte("partial")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	// Data already received is read, then the reset is reported
+	injectReset(t, server.conn, server.conn.rcvNxt, 0, 0)
+	buf := make([]byte, 100)
+	if n, err := s.Recv(buf); err != nil || string(buf[:n]) != "partial" {
+		t.Fatalf("Recv() = %q, %v, want %q", buf[:n], err, "partial")
+	}
+	if _, err := s.RecvTimeout(buf, time.Second); !errors.Is(err, ErrConnectionReset) {
+		t.Errorf("Recv() after the RST error = %v, want %v", err, ErrConnectionReset)
+	}
+	if _, err := s.Send([]byte("more")); !errors.Is(err, ErrConnectionReset) {
+		t.Errorf("Send() after the RST error = %v, want %v", err, ErrConnectionReset)
+	}
+}
+
+func TestConnectRefusedByReset(t *testing.T) {
+	client := NewDemultiplexer()
+	server := NewDemultiplexer()
+	defer linkDemuxes(t, client, server)()
+
+	// Nothing listens, so the server's demultiplexer answers with an RST
+	sock := NewSocket(testClientIP, 0)
+	start := time.Now()
+	err := client.Connect(sock, testServerIP, 8084)
+	if !errors.Is(err, ErrConnectionRefused) {
+		t.Fatalf("Connect() error = %v, want %v", err, ErrConnectionRefused)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Connect() failed after %v, want the RST to end it at once", elapsed)
+	}
+}
+
+func TestResetPendingConnection(t *testing.T) {
+	listener := NewSocket(common.IPv4Address{}, 80)
+	if err := listener.Listen(1); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	client := newTestPeer(true, nil)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	syn := client.out[0]
+	if err := listener.HandleIncomingSegment(syn, testClientIP, testServerIP); err != nil {
+		t.Fatalf("HandleIncomingSegment(SYN) error = %v", err)
+	}
+
+	rst := NewSegment(client.conn.LocalPort, 80, syn.SequenceNumber+1, 0, FlagRST, 0, nil)
+	rst.Checksum, _ = rst.CalculateChecksum(testClientIP, testServerIP)
+	if err := listener.HandleIncomingSegment(rst, testClientIP, testServerIP); err != nil {
+		t.Fatalf("HandleIncomingSegment(RST) error = %v", err)
+	}
+	if n := len(listener.pendingConns); n != 0 {
+		t.Errorf("%d connections pending after the RST, want 0", n)
+	}
+}
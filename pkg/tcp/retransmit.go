@@ -2,6 +2,8 @@
 package tcp
 
 import (
+	"slices"
+	"sort"
 	"sync"
 	"time"
 )
@@ -12,34 +14,151 @@ type RetransmitEntry struct {
 	Segment   *Segment
 	SentTime  time.Time
 	RetryCount int
+
+	// Loss detection state (RFC 8985): the peer selectively acknowledged
+	// the segment, or RACK found it lost and it waits to be sent again
+	SACKed bool
+	Lost   bool
+
+	pooled bool // Segment came from segmentPool, and goes back once acknowledged
 }
 
 // RetransmitQueue manages segments that need to be retransmitted.
+//
+// The segments are kept in sequence order in a ring, so that those an ACK
+// acknowledges are found with a binary search and taken out by moving the
+// head of the ring, and the segment to send again by its sequence number.
+// A segment the ACK acknowledges only part of, such as a GSO
+// super-segment, stays at the head, the queue counting what is in flight
+// from the ACK on. The SACK blocks the peer reports are merged into a
+// scoreboard (RFC 6675), from which Holes returns what the peer is
+// missing.
+//
+// Entries taken out by RemoveBefore are retired rather than dropped, but
+// may still be in use by the caller, which has Recycle put them on the
+// queue's freelist once it is done with them.
 type RetransmitQueue struct {
-	entries []*RetransmitEntry
-	mu      sync.Mutex
+	// The entries are the n from head on, wrapping around the ring, whose
+	// size is a power of two
+	ring []*RetransmitEntry
+	head int
+	n    int
+	mu   sync.Mutex
+
+	acked      uint32      // Highest sequence number RemoveBefore was given
+	scoreboard []SACKBlock // Ranges SACKed above the ACK, in order and merged
+	sacked     int         // Entries SACKed
+
+	retired []*RetransmitEntry // Removed, waiting for Recycle
+	free    []*RetransmitEntry // Recycled, for Add to reuse
 }
 
+// minRingSize is the size of a queue's ring once the first segment is
+// added.
+const minRingSize = 16
+
 // NewRetransmitQueue creates a new retransmit queue.
 func NewRetransmitQueue() *RetransmitQueue {
-	return &RetransmitQueue{
-		entries: make([]*RetransmitEntry, 0),
-	}
+	return &RetransmitQueue{}
 }
 
-// Add adds a segment to the retransmit queue.
+// Add adds a segment to the retransmit queue, after those already queued.
 func (rq *RetransmitQueue) Add(seqNum uint32, seg *Segment, sentTime time.Time) {
+	rq.add(seqNum, seg, sentTime, false)
+}
+
+// addPooled adds a segment from newPooledSegment, which Recycle releases
+// once it is acknowledged.
+func (rq *RetransmitQueue) addPooled(seqNum uint32, seg *Segment, sentTime time.Time) {
+	rq.add(seqNum, seg, sentTime, true)
+}
+
+func (rq *RetransmitQueue) add(seqNum uint32, seg *Segment, sentTime time.Time, pooled bool) {
 	rq.mu.Lock()
 	defer rq.mu.Unlock()
 
-	entry := &RetransmitEntry{
-		SeqNum:     seqNum,
-		Segment:    seg,
-		SentTime:   sentTime,
-		RetryCount: 0,
+	var entry *RetransmitEntry
+	if n := len(rq.free); n > 0 {
+		entry = rq.free[n-1]
+		rq.free[n-1] = nil
+		rq.free = rq.free[:n-1]
+	} else {
+		entry = new(RetransmitEntry)
+	}
+	*entry = RetransmitEntry{
+		SeqNum:   seqNum,
+		Segment:  seg,
+		SentTime: sentTime,
+		pooled:   pooled,
+	}
+
+	if rq.n == len(rq.ring) {
+		rq.grow()
+	}
+	rq.set(rq.n, entry)
+	rq.n++
+}
+
+// grow doubles the size of the ring, moving the entries to its start.
+func (rq *RetransmitQueue) grow() {
+	size := 2 * len(rq.ring)
+	if size < minRingSize {
+		size = minRingSize
 	}
+	ring := make([]*RetransmitEntry, size)
+	for i := 0; i < rq.n; i++ {
+		ring[i] = rq.at(i)
+	}
+	rq.ring, rq.head = ring, 0
+}
+
+// at returns the i'th entry from the head.
+func (rq *RetransmitQueue) at(i int) *RetransmitEntry {
+	return rq.ring[(rq.head+i)&(len(rq.ring)-1)]
+}
+
+// set sets the i'th entry from the head.
+func (rq *RetransmitQueue) set(i int, entry *RetransmitEntry) {
+	rq.ring[(rq.head+i)&(len(rq.ring)-1)] = entry
+}
+
+// entryEnd returns the sequence number after an entry's segment.
+func entryEnd(entry *RetransmitEntry) uint32 {
+	return entry.SeqNum + segmentLength(entry.Segment)
+}
 
-	rq.entries = append(rq.entries, entry)
+// ackedCount returns the number of entries at the head that end at or
+// before seqNum.
+func (rq *RetransmitQueue) ackedCount(seqNum uint32) int {
+	return sort.Search(rq.n, func(i int) bool {
+		return seqAfter(entryEnd(rq.at(i)), seqNum)
+	})
+}
+
+// search returns the index of the first entry starting at or after
+// seqNum.
+func (rq *RetransmitQueue) search(seqNum uint32) int {
+	return sort.Search(rq.n, func(i int) bool {
+		return !seqBefore(rq.at(i).SeqNum, seqNum)
+	})
+}
+
+// index returns the index of the entry starting at seqNum, or -1.
+func (rq *RetransmitQueue) index(seqNum uint32) int {
+	if i := rq.search(seqNum); i < rq.n && rq.at(i).SeqNum == seqNum {
+		return i
+	}
+	return -1
+}
+
+// una returns the oldest sequence number outstanding: the start of the
+// head entry, or how far into it an ACK got.
+func (rq *RetransmitQueue) una() uint32 {
+	first := rq.at(0)
+	if seqAfter(rq.acked, first.SeqNum) && seqBefore(rq.acked, entryEnd(first)) {
+		return rq.acked
+	}
+	return first.SeqNum
 }
 
 // Remove removes a segment from the retransmit queue by sequence number.
@@ -47,28 +166,236 @@ func (rq *RetransmitQueue) Remove(seqNum uint32) {
 	rq.mu.Lock()
 	defer rq.mu.Unlock()
 
-	for i, entry := range rq.entries {
-		if entry.SeqNum == seqNum {
-			rq.entries = append(rq.entries[:i], rq.entries[i+1:]...)
-			return
-		}
+	i := rq.index(seqNum)
+	if i < 0 {
+		return
+	}
+	if rq.at(i).SACKed {
+		rq.sacked--
 	}
+	for ; i < rq.n-1; i++ {
+		rq.set(i, rq.at(i+1))
+	}
+	rq.set(rq.n-1, nil)
+	rq.n--
 }
 
-// RemoveBefore removes all segments with sequence numbers less than the given value.
+// RemoveBefore removes all segments that end at or before the given
+// sequence number, that is, are fully acknowledged by it. A partly
+// acknowledged segment, such as a GSO super-segment, stays queued.
 func (rq *RetransmitQueue) RemoveBefore(seqNum uint32) {
 	rq.mu.Lock()
 	defer rq.mu.Unlock()
 
-	newEntries := make([]*RetransmitEntry, 0)
-	for _, entry := range rq.entries {
-		// Use sequence number comparison that handles wraparound
-		if !seqBefore(entry.SeqNum, seqNum) {
-			newEntries = append(newEntries, entry)
+	rq.acked = seqNum
+	k := rq.ackedCount(seqNum)
+	for i := 0; i < k; i++ {
+		entry := rq.at(i)
+		if entry.SACKed {
+			rq.sacked--
+		}
+		rq.retired = append(rq.retired, entry)
+		rq.set(i, nil)
+	}
+	rq.head = (rq.head + k) & (len(rq.ring) - 1)
+	rq.n -= k
+	rq.trimScoreboard(seqNum)
+}
+
+// Recycle puts the entries RemoveBefore took out on the freelist, with
+// their segments if they came from newPooledSegment. The caller must no
+// longer use them, nor the entries Acked, MarkSACKed and Entries
+// returned.
+func (rq *RetransmitQueue) Recycle() {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	for i, entry := range rq.retired {
+		if entry.pooled {
+			releaseSegment(entry.Segment)
+		}
+		*entry = RetransmitEntry{}
+		if len(rq.free) < maxFreeEntries {
+			rq.free = append(rq.free, entry)
+		}
+		rq.retired[i] = nil
+	}
+	rq.retired = rq.retired[:0]
+}
+
+// Acked returns the segments an ACK fully acknowledges that were not
+// selectively acknowledged before, ahead of RemoveBefore taking them out.
+func (rq *RetransmitQueue) Acked(ack uint32) []*RetransmitEntry {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	var acked []*RetransmitEntry
+	for i, k := 0, rq.ackedCount(ack); i < k; i++ {
+		if entry := rq.at(i); !entry.SACKed {
+			acked = append(acked, entry)
+		}
+	}
+	return acked
+}
+
+// MarkSACKed marks the segments SACK blocks cover in full as selectively
+// acknowledged, and returns those not marked before. The blocks are added
+// to the scoreboard.
+func (rq *RetransmitQueue) MarkSACKed(blocks []SACKBlock) []*RetransmitEntry {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	var sacked []*RetransmitEntry
+	for _, block := range blocks {
+		if rq.n == 0 {
+			break
+		}
+		rq.score(block)
+		for i := rq.search(block.LeftEdge); i < rq.n; i++ {
+			entry := rq.at(i)
+			if seqAfter(entryEnd(entry), block.RightEdge) {
+				break
+			}
+			if !entry.SACKed {
+				entry.SACKed, entry.Lost = true, false
+				rq.sacked++
+				sacked = append(sacked, entry)
+			}
+		}
+	}
+	return sacked
+}
+
+// score merges a SACK block into the scoreboard, within what is
+// outstanding.
+func (rq *RetransmitQueue) score(block SACKBlock) {
+	if low := rq.una(); seqBefore(block.LeftEdge, low) {
+		block.LeftEdge = low
+	}
+	if high := entryEnd(rq.at(rq.n - 1)); seqAfter(block.RightEdge, high) {
+		block.RightEdge = high
+	}
+	if !seqAfter(block.RightEdge, block.LeftEdge) {
+		return
+	}
+
+	// The ranges overlapping or touching the block are replaced by their
+	// union with it
+	sb := rq.scoreboard
+	i := sort.Search(len(sb), func(i int) bool { return !seqBefore(sb[i].RightEdge, block.LeftEdge) })
+	j := i
+	for ; j < len(sb) && !seqAfter(sb[j].LeftEdge, block.RightEdge); j++ {
+		if seqBefore(sb[j].LeftEdge, block.LeftEdge) {
+			block.LeftEdge = sb[j].LeftEdge
+		}
+		if seqAfter(sb[j].RightEdge, block.RightEdge) {
+			block.RightEdge = sb[j].RightEdge
+		}
+	}
+	rq.scoreboard = slices.Replace(sb, i, j, block)
+}
+
+// trimScoreboard drops what an ACK of seqNum covers from the scoreboard.
+func (rq *RetransmitQueue) trimScoreboard(seqNum uint32) {
+	n := 0
+	for n < len(rq.scoreboard) && !seqAfter(rq.scoreboard[n].RightEdge, seqNum) {
+		n++
+	}
+	rq.scoreboard = append(rq.scoreboard[:0], rq.scoreboard[n:]...)
+	if len(rq.scoreboard) > 0 && seqBefore(rq.scoreboard[0].LeftEdge, seqNum) {
+		rq.scoreboard[0].LeftEdge = seqNum
+	}
+}
+
+// Holes returns the sequence ranges the peer has not acknowledged, either
+// cumulatively or selectively, below the highest one it SACKed: the holes
+// of RFC 6675, oldest first.
+func (rq *RetransmitQueue) Holes() []SACKBlock {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if rq.n == 0 {
+		return nil
+	}
+	var holes []SACKBlock
+	next := rq.una()
+	for _, run := range rq.scoreboard {
+		if seqAfter(run.LeftEdge, next) {
+			holes = append(holes, SACKBlock{LeftEdge: next, RightEdge: run.LeftEdge})
 		}
+		next = run.RightEdge
 	}
+	return holes
+}
+
+// SACKed returns the number of outstanding segments selectively
+// acknowledged.
+func (rq *RetransmitQueue) SACKed() int {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	return rq.sacked
+}
+
+// Pipe returns the data in flight: that neither selectively acknowledged
+// nor lost, counted from the ACK for a partly acknowledged segment (the
+// pipe of RFC 6675).
+func (rq *RetransmitQueue) Pipe() int {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
 
-	rq.entries = newEntries
+	pipe := 0
+	for i := 0; i < rq.n; i++ {
+		if entry := rq.at(i); !entry.SACKed && !entry.Lost {
+			pipe += int(segmentLength(entry.Segment))
+		}
+	}
+	if rq.n > 0 {
+		if first := rq.at(0); !first.SACKed && !first.Lost {
+			pipe -= int(rq.una() - first.SeqNum)
+		}
+	}
+	return pipe
+}
+
+// Entries returns the outstanding segments, oldest first.
+func (rq *RetransmitQueue) Entries() []*RetransmitEntry {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	return rq.between(0, rq.n)
+}
+
+// Range returns the outstanding segments starting from start and before
+// end, oldest first.
+func (rq *RetransmitQueue) Range(start, end uint32) []*RetransmitEntry {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	return rq.between(rq.search(start), rq.search(end))
+}
+
+// between returns a copy of the entries from i to j.
+func (rq *RetransmitQueue) between(i, j int) []*RetransmitEntry {
+	if i >= j {
+		return nil
+	}
+	entries := make([]*RetransmitEntry, 0, j-i)
+	for ; i < j; i++ {
+		entries = append(entries, rq.at(i))
+	}
+	return entries
+}
+
+// last returns the newest entry, or nil.
+func (rq *RetransmitQueue) last() *RetransmitEntry {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if rq.n == 0 {
+		return nil
+	}
+	return rq.at(rq.n - 1)
 }
 
 // GetExpired returns all segments that have exceeded the given timeout.
@@ -79,8 +406,8 @@ func (rq *RetransmitQueue) GetExpired(timeout time.Duration) []*RetransmitEntry
 	expired := make([]*RetransmitEntry, 0)
 	now := time.Now()
 
-	for _, entry := range rq.entries {
-		if now.Sub(entry.SentTime) > timeout {
+	for i := 0; i < rq.n; i++ {
+		if entry := rq.at(i); now.Sub(entry.SentTime) > timeout {
 			expired = append(expired, entry)
 		}
 	}
@@ -88,18 +415,51 @@ func (rq *RetransmitQueue) GetExpired(timeout time.Duration) []*RetransmitEntry
 	return expired
 }
 
-// UpdateSentTime updates the sent time for a segment.
+// UpdateSentTime updates the sent time for a segment sent again.
 func (rq *RetransmitQueue) UpdateSentTime(seqNum uint32, sentTime time.Time) {
 	rq.mu.Lock()
 	defer rq.mu.Unlock()
 
-	for _, entry := range rq.entries {
-		if entry.SeqNum == seqNum {
-			entry.SentTime = sentTime
-			entry.RetryCount++
-			return
+	if i := rq.index(seqNum); i >= 0 {
+		entry := rq.at(i)
+		entry.SentTime = sentTime
+		entry.RetryCount++
+		entry.Lost = false
+	}
+}
+
+// RTTSample returns the round-trip time measured by an ACK: the time since
+// the most recently sent segment it fully acknowledges was sent.
+// Retransmitted segments are ambiguous and give no sample (Karn's
+// algorithm).
+func (rq *RetransmitQueue) RTTSample(ack uint32, now time.Time) (time.Duration, bool) {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	var latest *RetransmitEntry
+	for i, k := 0, rq.ackedCount(ack); i < k; i++ {
+		entry := rq.at(i)
+		if entry.RetryCount == 0 && (latest == nil || entry.SentTime.After(latest.SentTime)) {
+			latest = entry
 		}
 	}
+	if latest == nil {
+		return 0, false
+	}
+	return now.Sub(latest.SentTime), true
+}
+
+// segmentLength returns the sequence space a segment occupies: its data,
+// plus one each for SYN and FIN.
+func segmentLength(seg *Segment) uint32 {
+	n := uint32(len(seg.Data))
+	if seg.HasFlag(FlagSYN) {
+		n++
+	}
+	if seg.HasFlag(FlagFIN) {
+		n++
+	}
+	return n
 }
 
 // GetFirst returns the first segment in the retransmit queue.
@@ -107,11 +467,11 @@ func (rq *RetransmitQueue) GetFirst() *Segment {
 	rq.mu.Lock()
 	defer rq.mu.Unlock()
 
-	if len(rq.entries) == 0 {
+	if rq.n == 0 {
 		return nil
 	}
 
-	return rq.entries[0].Segment
+	return rq.at(0).Segment
 }
 
 // Len returns the number of entries in the retransmit queue.
@@ -119,7 +479,7 @@ func (rq *RetransmitQueue) Len() int {
 	rq.mu.Lock()
 	defer rq.mu.Unlock()
 
-	return len(rq.entries)
+	return rq.n
 }
 
 // Clear clears the retransmit queue.
@@ -127,7 +487,10 @@ func (rq *RetransmitQueue) Clear() {
 	rq.mu.Lock()
 	defer rq.mu.Unlock()
 
-	rq.entries = rq.entries[:0]
+	clear(rq.ring)
+	rq.head, rq.n = 0, 0
+	rq.scoreboard = rq.scoreboard[:0]
+	rq.sacked = 0
 }
 
 // seqBefore returns true if seq1 is before seq2 (handling wraparound).
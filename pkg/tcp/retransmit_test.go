@@ -1,8 +1,15 @@
 package tcp
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 	"testing"
 	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/linkstate"
 )
 
 func TestRetransmitQueue(t *testing.T) {
@@ -84,6 +91,254 @@ func TestRetransmitQueueExpired(t *testing.T) {
 	}
 }
 
+func TestRetransmitQueueRecycle(t *testing.T) {
+	rq := NewRetransmitQueue()
+	now := time.Now()
+	seg := newPooledSegment(12345, 80, 1000, 0, FlagACK, 65535, []byte("data"))
+	rq.addPooled(1000, seg, now)
+	rq.Add(1004, NewSegment(12345, 80, 1004, 0, FlagACK, 65535, []byte("data")), now)
+
+	// Acknowledged entries stay usable until Recycle
+	entry := rq.Entries()[0]
+	rq.RemoveBefore(1004)
+	if rq.Len() != 1 || entry.SeqNum != 1000 || len(entry.Segment.Data) != 4 {
+		t.Fatalf("after RemoveBefore(): Len() = %d, entry %+v, want 1 and the entry intact", rq.Len(), entry)
+	}
+	rq.Recycle()
+	if entry.Segment != nil || seg.SequenceNumber != 0 || seg.Data != nil {
+		t.Errorf("after Recycle(): entry %+v, segment %+v, want both released", entry, seg)
+	}
+
+	// The next Add reuses the entry
+	rq.Add(1008, NewSegment(12345, 80, 1008, 0, FlagACK, 65535, nil), now)
+	if got := rq.Entries()[1]; got != entry || got.SeqNum != 1008 {
+		t.Errorf("Add() after Recycle() queued %p (%+v), want the recycled entry %p", got, got, entry)
+	}
+}
+
+func TestRetransmitAfterSendFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{"dropped", errors.New("dropped"), false},
+		{"no route", fmt.Errorf("ip: %w: 10.0.0.2", ip.ErrNoRoute), true},
+		{"link down", linkstate.ErrLinkDown, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestPeer(true, nil)
+			server := newTestPeer(false, nil)
+			client.conn.timers = manualWheel()
+			if err := client.conn.ActiveOpen(); err != nil {
+				t.Fatalf("ActiveOpen() error = %v", err)
+			}
+			exchange(t, client, server)
+
+			// A segment the layers below drop is lost, and one they
+			// cannot send reported, but either is sent again
+			send := client.conn.onSegmentReady
+			client.conn.onSegmentReady = func(*Segment) error { return tt.err }
+			err := client.conn.Send([]byte("data"))
+			if tt.wantErr && !errors.Is(err, tt.err) {
+				t.Fatalf("Send() error = %v, want %v", err, tt.err)
+			} else if !tt.wantErr && err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			client.conn.onSegmentReady = send
+
+			w := client.conn.timers
+			var due []*Timer
+			for len(due) == 0 {
+				due = w.advance(due)
+			}
+			due[0].f()
+
+			exchange(t, client, server)
+			if string(server.data) != "data" {
+				t.Errorf("server received %q, want %q", server.data, "data")
+			}
+		})
+	}
+}
+
+// fillQueue returns a queue of n segments of size bytes from seq on.
+func fillQueue(n int, seq uint32, size int) *RetransmitQueue {
+	rq := NewRetransmitQueue()
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		s := seq + uint32(i*size)
+		rq.Add(s, NewSegment(12345, 80, s, 0, FlagACK, 65535, make([]byte, size)), now)
+	}
+	return rq
+}
+
+func TestRetransmitQueueRing(t *testing.T) {
+	// Segments across the wraparound of the sequence space, and of the
+	// ring as it is trimmed and grows
+	start := uint32(0xFFFFFF00)
+	rq := fillQueue(10, start, 100)
+	rq.RemoveBefore(start + 500)
+	rq.Recycle()
+	now := time.Now()
+	for i := 10; i < 40; i++ {
+		s := start + uint32(i*100)
+		rq.Add(s, NewSegment(12345, 80, s, 0, FlagACK, 65535, make([]byte, 100)), now)
+	}
+
+	entries := rq.Entries()
+	if len(entries) != 35 || rq.Len() != 35 {
+		t.Fatalf("Len() = %d with %d entries, want 35", rq.Len(), len(entries))
+	}
+	for i, e := range entries {
+		if want := start + uint32((i+5)*100); e.SeqNum != want {
+			t.Fatalf("Entries()[%d].SeqNum = %#x, want %#x", i, e.SeqNum, want)
+		}
+	}
+	if head := rq.GetFirst(); head.SequenceNumber != start+500 {
+		t.Errorf("GetFirst().SequenceNumber = %#x, want %#x", head.SequenceNumber, uint32(start+500))
+	}
+
+	// Lookups by sequence number
+	rq.UpdateSentTime(start+2000, now)
+	if e := rq.Entries()[15]; e.RetryCount != 1 {
+		t.Errorf("UpdateSentTime() retried %+v, want the segment at %#x", e, uint32(start+2000))
+	}
+	if got := rq.Range(start+1000, start+1300); len(got) != 3 || got[0].SeqNum != start+1000 {
+		t.Errorf("Range() = %d entries from %#x, want 3 from %#x", len(got), got[0].SeqNum, uint32(start+1000))
+	}
+	rq.Remove(start + 1000)
+	if rq.Len() != 34 || rq.Entries()[5].SeqNum != start+1100 {
+		t.Errorf("after Remove(): Len() = %d, want 34 with the segment gone", rq.Len())
+	}
+	if got := rq.Acked(start + 1150); len(got) != 5 {
+		t.Errorf("Acked() = %d entries, want 5", len(got))
+	}
+}
+
+func TestRetransmitQueuePartialAck(t *testing.T) {
+	// A super-segment of three, of which the ACK covers one and a half
+	rq := NewRetransmitQueue()
+	sentTime := time.Now().Add(-time.Millisecond)
+	rq.Add(1000, NewSegment(12345, 80, 1000, 0, FlagACK, 65535, make([]byte, 300)), sentTime)
+	rq.Add(1300, NewSegment(12345, 80, 1300, 0, FlagACK, 65535, make([]byte, 100)), sentTime)
+
+	if _, ok := rq.RTTSample(1150, time.Now()); ok {
+		t.Error("RTTSample() of a partial ACK gave a sample, want none")
+	}
+	rq.RemoveBefore(1150)
+	if rq.Len() != 2 || rq.GetFirst().SequenceNumber != 1000 {
+		t.Fatalf("Len() = %d after a partial ACK, want the super-segment still queued", rq.Len())
+	}
+	if pipe := rq.Pipe(); pipe != 250 {
+		t.Errorf("Pipe() = %d, want 250", pipe)
+	}
+	if holes := rq.Holes(); holes != nil {
+		t.Errorf("Holes() = %v with nothing SACKed, want none", holes)
+	}
+
+	// A SACK block reaching below the ACK counts from it
+	rq.MarkSACKed([]SACKBlock{{LeftEdge: 1100, RightEdge: 1200}})
+	if holes := rq.Holes(); len(holes) != 0 {
+		t.Errorf("Holes() = %v, want none", holes)
+	}
+	rq.RemoveBefore(1300)
+	if rq.Len() != 1 || rq.Pipe() != 100 {
+		t.Errorf("Len() = %d, Pipe() = %d after the ACK of the rest, want 1 and 100", rq.Len(), rq.Pipe())
+	}
+}
+
+func TestRetransmitQueueScoreboard(t *testing.T) {
+	tests := []struct {
+		name   string
+		acks   [][]SACKBlock
+		ack    uint32 // Cumulative ACK after the SACKs, if any
+		holes  []SACKBlock
+		sacked int
+	}{
+		{
+			name:   "one block",
+			acks:   [][]SACKBlock{{{LeftEdge: 1200, RightEdge: 1400}}},
+			holes:  []SACKBlock{{LeftEdge: 1000, RightEdge: 1200}},
+			sacked: 2,
+		},
+		{
+			name:   "blocks merged",
+			acks:   [][]SACKBlock{{{LeftEdge: 1200, RightEdge: 1300}}, {{LeftEdge: 1300, RightEdge: 1400}, {LeftEdge: 1600, RightEdge: 1700}}},
+			holes:  []SACKBlock{{LeftEdge: 1000, RightEdge: 1200}, {LeftEdge: 1400, RightEdge: 1600}},
+			sacked: 3,
+		},
+		{
+			name:   "partial blocks",
+			acks:   [][]SACKBlock{{{LeftEdge: 1250, RightEdge: 1450}}},
+			holes:  []SACKBlock{{LeftEdge: 1000, RightEdge: 1250}},
+			sacked: 1,
+		},
+		{
+			name:   "beyond what was sent",
+			acks:   [][]SACKBlock{{{LeftEdge: 1900, RightEdge: 2500}}},
+			holes:  []SACKBlock{{LeftEdge: 1000, RightEdge: 1900}},
+			sacked: 1,
+		},
+		{
+			name:   "ACK of a hole",
+			acks:   [][]SACKBlock{{{LeftEdge: 1200, RightEdge: 1300}, {LeftEdge: 1500, RightEdge: 1600}}},
+			ack:    1300,
+			holes:  []SACKBlock{{LeftEdge: 1300, RightEdge: 1500}},
+			sacked: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rq := fillQueue(10, 1000, 100)
+			for _, blocks := range tt.acks {
+				rq.MarkSACKed(blocks)
+			}
+			if tt.ack != 0 {
+				rq.RemoveBefore(tt.ack)
+			}
+			if holes := rq.Holes(); !slices.Equal(holes, tt.holes) {
+				t.Errorf("Holes() = %v, want %v", holes, tt.holes)
+			}
+			if sacked := rq.SACKed(); sacked != tt.sacked {
+				t.Errorf("SACKed() = %d, want %d", sacked, tt.sacked)
+			}
+		})
+	}
+}
+
+// BenchmarkRetransmitQueue measures a connection's queue with window
+// segments outstanding: per iteration, an ACK of the oldest, a SACK of one
+// ahead of a hole, the hole sent again and a new segment sent.
+func BenchmarkRetransmitQueue(b *testing.B) {
+	for _, window := range []int{16, 1024, 16384} {
+		b.Run(strconv.Itoa(window), func(b *testing.B) {
+			const size = 1000
+			rq := fillQueue(window, 0, size)
+			segs := make([]*Segment, window)
+			for i, e := range rq.Entries() {
+				segs[i] = e.Segment
+			}
+			now := time.Now()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				una := uint32((i + 1) * size)
+				hole := una + uint32(window/2*size)
+				rq.MarkSACKed([]SACKBlock{{LeftEdge: hole + size, RightEdge: hole + 2*size}})
+				rq.UpdateSentTime(hole, now)
+				rq.RemoveBefore(una)
+				rq.Recycle()
+				s := una + uint32((window-1)*size)
+				rq.Add(s, segs[i%window], now)
+			}
+		})
+	}
+}
+
 func TestSeqComparison(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -2,9 +2,14 @@
 package tcp
 
 import (
+	"errors"
 	"sync"
 )
 
+// ErrSendBufferFull is returned by Send when the data would take the
+// connection past its send buffer size (see OptSendBuffer).
+var ErrSendBufferFull = errors.New("send buffer full")
+
 // SendBuffer manages the send buffer for a TCP connection.
 type SendBuffer struct {
 	buffer []byte
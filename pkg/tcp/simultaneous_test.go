@@ -0,0 +1,319 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/link/memory"
+)
+
+// crossingPeers returns two test peers that both open actively, as the two
+// ends of a simultaneous open.
+func crossingPeers(t *testing.T) (*testPeer, *testPeer) {
+	t.Helper()
+	a := newTestPeer(true, nil)
+	b := newTestPeer(true, nil)
+	b.conn = NewConnection(testServerIP, 80, testClientIP, 50000)
+	b.conn.onSegmentReady = func(seg *Segment) error {
+		b.out = append(b.out, seg)
+		return nil
+	}
+	b.conn.onDataReady = func(data []byte) { b.data = append(b.data, data...) }
+	b.conn.timeWait = newTestTimeWait(t, TimeWaitConfig{})
+	a.conn.timeWait = newTestTimeWait(t, TimeWaitConfig{})
+
+	for _, p := range []*testPeer{a, b} {
+		if err := p.conn.ActiveOpen(); err != nil {
+			t.Fatalf("ActiveOpen() error = %v", err)
+		}
+	}
+	return a, b
+}
+
+// cross delivers the segments both peers have sent to the other, as if
+// they passed on the wire.
+func cross(t *testing.T, a, b *testPeer) {
+	t.Helper()
+	fromA, fromB := &testPeer{out: a.out}, &testPeer{out: b.out}
+	a.out, b.out = nil, nil
+	deliver(t, fromA, b)
+	deliver(t, fromB, a)
+}
+
+func TestSimultaneousOpen(t *testing.T) {
+	a, b := crossingPeers(t)
+
+	// The SYNs cross; each side answers with a SYN-ACK repeating its SYN
+	cross(t, a, b)
+	for _, p := range []*testPeer{a, b} {
+		if got := p.conn.GetState(); got != StateSynReceived {
+			t.Fatalf("state after crossing SYNs = %s, want SYN_RECEIVED", got)
+		}
+		if len(p.out) != 1 || p.out[0].Flags != FlagSYN|FlagACK || p.out[0].SequenceNumber != p.conn.iss {
+			t.Fatalf("sent %v, want a SYN-ACK at the ISS", p.out)
+		}
+	}
+	if head := a.conn.retransmitQueue.GetFirst(); head == nil || !head.HasFlag(FlagACK) {
+		t.Errorf("retransmit queue head = %v, want the SYN-ACK", head)
+	}
+
+	// The SYN-ACKs cross and establish both sides
+	cross(t, a, b)
+	for _, p := range []*testPeer{a, b} {
+		if got := p.conn.GetState(); got != StateEstablished {
+			t.Errorf("state after crossing SYN-ACKs = %s, want ESTABLISHED", got)
+		}
+		if p.conn.sndUna != p.conn.iss+1 || p.conn.retransmitQueue.Len() != 0 {
+			t.Errorf("sndUna = %d with %d segments outstanding, want %d and none", p.conn.sndUna, p.conn.retransmitQueue.Len(), p.conn.iss+1)
+		}
+	}
+	if a.conn.rcvNxt != b.conn.iss+1 || b.conn.rcvNxt != a.conn.iss+1 {
+		t.Errorf("rcvNxt = %d, %d; want %d, %d", a.conn.rcvNxt, b.conn.rcvNxt, b.conn.iss+1, a.conn.iss+1)
+	}
+
+	if err := a.conn.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, a, b)
+	if string(b.data) != "hello" {
+		t.Errorf("received %q, want %q", b.data, "hello")
+	}
+}
+
+func TestSimultaneousOpenLostSynAck(t *testing.T) {
+	a, b := crossingPeers(t)
+	cross(t, a, b)
+
+	// b's SYN-ACK is lost. a's establishes b, whose data then establishes
+	// a: it carries the ACK of a's SYN.
+	b.out = nil
+	deliver(t, a, b)
+	if got := b.conn.GetState(); got != StateEstablished {
+		t.Fatalf("b state = %s, want ESTABLISHED", got)
+	}
+	if err := b.conn.Send([]byte("data")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, b, a)
+	if got := a.conn.GetState(); got != StateEstablished {
+		t.Errorf("a state = %s, want ESTABLISHED", got)
+	}
+	if string(a.data) != "data" {
+		t.Errorf("a received %q, want %q", a.data, "data")
+	}
+}
+
+func TestSynReceivedRetransmittedSyn(t *testing.T) {
+	a, b := crossingPeers(t)
+	syn := b.out[0]
+	cross(t, a, b)
+	a.out = nil
+
+	// b's SYN again means a's SYN-ACK did not arrive; a sends it again
+	deliver(t, &testPeer{out: []*Segment{syn}}, a)
+	if len(a.out) != 1 || a.out[0].Flags != FlagSYN|FlagACK {
+		t.Fatalf("answer to a retransmitted SYN = %v, want the SYN-ACK", a.out)
+	}
+	if got := a.conn.GetState(); got != StateSynReceived {
+		t.Errorf("state = %s, want SYN_RECEIVED", got)
+	}
+}
+
+func TestSynReceivedFin(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	deliver(t, client, server)
+	deliver(t, server, client)
+
+	// The ACK of the handshake is lost and the client closes at once: its
+	// FIN completes the handshake
+	client.out = nil
+	if err := client.conn.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	deliver(t, client, server)
+	if got := server.conn.GetState(); got != StateCloseWait {
+		t.Errorf("server state = %s, want CLOSE_WAIT", got)
+	}
+	deliver(t, server, client)
+	if got := client.conn.GetState(); got != StateFinWait2 {
+		t.Errorf("client state = %s, want FIN_WAIT_2", got)
+	}
+}
+
+func TestSimultaneousClose(t *testing.T) {
+	tests := []struct {
+		name    string
+		lostACK bool // a's ACK of b's FIN is lost, so b sends its FIN again
+	}{
+		{"crossing FINs", false},
+		{"lost ACK", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := crossingPeers(t)
+			cross(t, a, b)
+			cross(t, a, b)
+
+			for _, p := range []*testPeer{a, b} {
+				if err := p.conn.Close(); err != nil {
+					t.Fatalf("Close() error = %v", err)
+				}
+			}
+			cross(t, a, b)
+			for _, p := range []*testPeer{a, b} {
+				if got := p.conn.GetState(); got != StateClosing {
+					t.Fatalf("state after crossing FINs = %s, want CLOSING", got)
+				}
+			}
+
+			if tt.lostACK {
+				a.out = nil
+			}
+			cross(t, a, b)
+			if got := a.conn.GetState(); got != StateClosed {
+				t.Errorf("a state = %s, want CLOSED after TIME_WAIT began", got)
+			}
+			if !a.conn.timeWait.Contains(testClientIP, 50000, testServerIP, 80) {
+				t.Error("a not in TIME_WAIT")
+			}
+
+			if tt.lostACK {
+				if got := b.conn.GetState(); got != StateClosing {
+					t.Fatalf("b state without the ACK = %s, want CLOSING", got)
+				}
+				// a's TIME_WAIT entry answers the retransmitted FIN
+				b.conn.retransmitTimeout()
+				for _, seg := range b.out {
+					if handled, err := a.conn.timeWait.HandleSegment(seg, testServerIP, testClientIP); !handled || err != nil {
+						t.Fatalf("TIME_WAIT HandleSegment() = %v, %v", handled, err)
+					}
+				}
+				b.out = nil
+				deliver(t, a, b)
+			}
+			if got := b.conn.GetState(); got != StateClosed {
+				t.Errorf("b state = %s, want CLOSED after TIME_WAIT began", got)
+			}
+			if !b.conn.timeWait.Contains(testServerIP, 80, testClientIP, 50000) {
+				t.Error("b not in TIME_WAIT")
+			}
+		})
+	}
+}
+
+// linkPeer is a connection whose segments go out over one end of an
+// in-memory link.
+type linkPeer struct {
+	conn *Connection
+	ep   *memory.Endpoint
+}
+
+// linkPeers returns two connections joined by an in-memory link, both
+// opening actively, as the two ends of a simultaneous open.
+func linkPeers(t *testing.T) (*linkPeer, *linkPeer) {
+	t.Helper()
+	epA, epB := memory.NewPipe(memory.Config{Seed: 1})
+	t.Cleanup(func() { epA.Close() })
+	a := &linkPeer{conn: NewConnection(testClientIP, 50000, testServerIP, 80), ep: epA}
+	b := &linkPeer{conn: NewConnection(testServerIP, 80, testClientIP, 50000), ep: epB}
+	for _, p := range []*linkPeer{a, b} {
+		ep := p.ep
+		p.conn.onSegmentReady = func(seg *Segment) error {
+			data, err := seg.Serialize()
+			if err != nil {
+				return err
+			}
+			return ep.WritePacket(data)
+		}
+		p.conn.timeWait = newTestTimeWait(t, TimeWaitConfig{})
+	}
+	for _, p := range []*linkPeer{a, b} {
+		if err := p.conn.ActiveOpen(); err != nil {
+			t.Fatalf("ActiveOpen() error = %v", err)
+		}
+	}
+	return a, b
+}
+
+// crossLink takes the segment each peer sent off the other's end of the
+// link, both before either is handled so that they cross on it, then
+// delivers them, and returns them.
+func crossLink(t *testing.T, a, b *linkPeer) (fromA, fromB *Segment) {
+	t.Helper()
+	segs := make([]*Segment, 2)
+	for i, p := range []*linkPeer{b, a} {
+		data, err := p.ep.ReadPacketTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("ReadPacketTimeout() error = %v", err)
+		}
+		if segs[i], err = Parse(data); err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+	}
+	for i, p := range []*linkPeer{b, a} {
+		if err := p.conn.HandleSegment(segs[i]); err != nil {
+			t.Fatalf("HandleSegment() error = %v", err)
+		}
+	}
+	return segs[0], segs[1]
+}
+
+func TestSimultaneousOpenOverLink(t *testing.T) {
+	a, b := linkPeers(t)
+
+	// The SYNs cross on the link, then the SYN-ACKs answering them
+	if fromA, fromB := crossLink(t, a, b); fromA.Flags != FlagSYN || fromB.Flags != FlagSYN {
+		t.Fatalf("crossed %v and %v, want two SYNs", fromA, fromB)
+	}
+	for _, p := range []*linkPeer{a, b} {
+		if got := p.conn.GetState(); got != StateSynReceived {
+			t.Fatalf("state after crossing SYNs = %s, want SYN_RECEIVED", got)
+		}
+	}
+	if fromA, fromB := crossLink(t, a, b); fromA.Flags != FlagSYN|FlagACK || fromB.Flags != FlagSYN|FlagACK {
+		t.Fatalf("crossed %v and %v, want two SYN-ACKs", fromA, fromB)
+	}
+	for _, p := range []*linkPeer{a, b} {
+		if got := p.conn.GetState(); got != StateEstablished {
+			t.Errorf("state after crossing SYN-ACKs = %s, want ESTABLISHED", got)
+		}
+	}
+	if a.conn.rcvNxt != b.conn.iss+1 || b.conn.rcvNxt != a.conn.iss+1 {
+		t.Errorf("rcvNxt = %d, %d; want %d, %d", a.conn.rcvNxt, b.conn.rcvNxt, b.conn.iss+1, a.conn.iss+1)
+	}
+}
+
+func TestSimultaneousCloseOverLink(t *testing.T) {
+	a, b := linkPeers(t)
+	crossLink(t, a, b)
+	crossLink(t, a, b)
+
+	// Both close before either FIN arrives: the FINs cross on the link,
+	// then the ACKs of them
+	for _, p := range []*linkPeer{a, b} {
+		if err := p.conn.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+	if fromA, fromB := crossLink(t, a, b); !fromA.HasFlag(FlagFIN) || !fromB.HasFlag(FlagFIN) {
+		t.Fatalf("crossed %v and %v, want two FINs", fromA, fromB)
+	}
+	for _, p := range []*linkPeer{a, b} {
+		if got := p.conn.GetState(); got != StateClosing {
+			t.Fatalf("state after crossing FINs = %s, want CLOSING", got)
+		}
+	}
+	if fromA, fromB := crossLink(t, a, b); fromA.Flags != FlagACK || fromB.Flags != FlagACK {
+		t.Fatalf("crossed %v and %v, want two ACKs", fromA, fromB)
+	}
+	if got := a.conn.GetState(); got != StateClosed || !a.conn.timeWait.Contains(testClientIP, 50000, testServerIP, 80) {
+		t.Errorf("a state = %s, want CLOSED in TIME_WAIT", got)
+	}
+	if got := b.conn.GetState(); got != StateClosed || !b.conn.timeWait.Contains(testServerIP, 80, testClientIP, 50000) {
+		t.Errorf("b state = %s, want CLOSED in TIME_WAIT", got)
+	}
+}
@@ -2,18 +2,24 @@
 package tcp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ports"
 )
 
 // Socket represents a TCP socket.
 type Socket struct {
-	localAddr  common.IPv4Address
+	// Addresses in the form they are looked up by (see ipKey)
+	localAddr  common.IPv6Address
 	localPort  uint16
-	remoteAddr common.IPv4Address
+	remoteAddr common.IPv6Address
 	remotePort uint16
 
 	conn *Connection
@@ -25,35 +31,152 @@ type Socket struct {
 	pendingConns   map[string]*Connection // Key: "remoteIP:remotePort"
 	pendingConnsMu sync.Mutex
 
-	// For sending packets
-	sendFunc func(*Segment, common.IPv4Address, common.IPv4Address) error
+	// What a full accept queue does with connection attempts, and the
+	// keys of pending connections it holds back (see backlog.go)
+	backlogPolicy BacklogPolicy
+	held          []string
+	overflows     uint64
 
-	// Data channel
-	dataReady chan []byte
+	// OnAcceptQueueFull callback, whether the queue filled since Accept
+	// last made room, and whether the callback is due
+	onQueueFull     func(AcceptQueueStats)
+	queueFull       bool
+	queueFullNotice bool
+
+	// For sending packets, over IPv4 and IPv6
+	sendFunc     func(*Segment, common.IPv4Address, common.IPv4Address) error
+	sendFuncIPv6 func(*Segment, common.IPv6Address, common.IPv6Address) error
+
+	// TCP Fast Open, nil if disabled
+	fastOpen *TFOState
+
+	// Send GSO super-segments
+	gso bool
+
+	// MD5 signature keys and TCP-AO key chains by peer address (see
+	// SetMD5Key and SetAOKeys)
+	md5Keys map[common.IPv6Address][]byte
+	aoKeys  map[common.IPv6Address][]AOKey
+
+	// Demultiplexer the socket was added to, if any
+	demux *Demultiplexer
+
+	// Local port binding, held by a listener or until a connection takes it
+	binding   *ports.Binding
+	reuseAddr bool
+
+	// Options set with SetSockOpt
+	opts socketOptions
+
+	// Data received and not yet read
+	recv *socketQueue
+
+	// Set by CloseWrite
+	writeClosed bool
+
+	// Deadlines set with SetDeadline
+	readDeadline  *deadline
+	writeDeadline *deadline
 
 	mu sync.RWMutex
 }
 
-// NewSocket creates a new TCP socket.
+// NewSocket creates a new TCP socket. A socket bound to the zero address
+// is dual-stack: it listens on, and connects over, IPv4 and IPv6.
 func NewSocket(localAddr common.IPv4Address, localPort uint16) *Socket {
+	return newSocket(ipKey(localAddr), localPort)
+}
+
+// NewSocketIPv6 creates a new TCP socket bound to an IPv6 address. A socket
+// bound to the unspecified address is dual-stack, as with NewSocket.
+func NewSocketIPv6(localAddr common.IPv6Address, localPort uint16) *Socket {
+	return newSocket(localAddr, localPort)
+}
+
+func newSocket(localAddr common.IPv6Address, localPort uint16) *Socket {
 	return &Socket{
-		localAddr:    localAddr,
-		localPort:    localPort,
-		isListening:  false,
-		backlog:      128,
-		acceptQueue:  make(chan *Connection, 128),
-		pendingConns: make(map[string]*Connection),
-		dataReady:    make(chan []byte, 100),
+		localAddr:     localAddr,
+		localPort:     localPort,
+		isListening:   false,
+		backlog:       128,
+		acceptQueue:   make(chan *Connection, 128),
+		pendingConns:  make(map[string]*Connection),
+		recv:          newSocketQueue(),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		opts:          defaultSocketOptions,
 	}
 }
 
-// SetSendFunc sets the function to call when sending segments.
+// DefaultConnectTimeout is how long Connect and ConnectWithData wait for
+// the connection to open.
+const DefaultConnectTimeout = 10 * time.Second
+
+// SetSendFunc sets the function to call when sending segments. Data
+// segments are recycled once the peer acknowledges them, so f must
+// serialize a segment, or Clone it, rather than keep it.
 func (s *Socket) SetSendFunc(f func(*Segment, common.IPv4Address, common.IPv4Address) error) {
 	s.sendFunc = f
 }
 
+// SetSendFuncIPv6 sets the function to call when sending segments over
+// IPv6.
+func (s *Socket) SetSendFuncIPv6(f func(*Segment, common.IPv6Address, common.IPv6Address) error) {
+	s.sendFuncIPv6 = f
+}
+
+// send sends a segment from local to remote with the send function of the
+// family of remote.
+func (s *Socket) send(seg *Segment, local, remote common.IPv6Address) error {
+	return sendFor(seg, local, remote, s.sendFunc, s.sendFuncIPv6)
+}
+
+// SetFastOpen enables TCP Fast Open (RFC 7413) with the cookies of tfo, or
+// disables it if tfo is nil. A listening socket then accepts data in SYNs
+// carrying a valid cookie. A connecting socket sends data passed to
+// ConnectWithData in its SYN once it has a cookie for the server; sockets
+// should share a TFOState so that cookies from earlier connections are
+// reused.
+func (s *Socket) SetFastOpen(tfo *TFOState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fastOpen = tfo
+}
+
+// SetGSO enables or disables generic segmentation offload for the
+// socket's connections, including those accepted later. A connection with
+// GSO sends as much data as its windows allow as one super-segment, with
+// GSOSize set to the MSS, so the layers below handle it once rather than
+// once per MSS. The send function must split super-segments into wire
+// segments with SplitGSO; hook.Pipeline's EncapsulateTCP stage does.
+func (s *Socket) SetGSO(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gso = enabled
+	if s.conn != nil {
+		s.conn.mu.Lock()
+		s.conn.gso = enabled
+		s.conn.mu.Unlock()
+	}
+}
+
+// SetReuseAddr lets the socket bind a local port that other sockets which
+// also set it are using, and listen on a port with connections in
+// TIME_WAIT, like SO_REUSEADDR. It applies to Demultiplexer.Listen and
+// Connect calls made afterwards.
+func (s *Socket) SetReuseAddr(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reuseAddr = enabled
+}
+
 // Bind binds the socket to a local address and port.
 func (s *Socket) Bind(addr common.IPv4Address, port uint16) error {
+	return s.BindIPv6(ipKey(addr), port)
+}
+
+// BindIPv6 binds the socket to a local IPv6 address and port.
+func (s *Socket) BindIPv6(addr common.IPv6Address, port uint16) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -62,7 +185,9 @@ func (s *Socket) Bind(addr common.IPv4Address, port uint16) error {
 	return nil
 }
 
-// Listen puts the socket in listening mode.
+// Listen puts the socket in listening mode, with room for backlog
+// connections waiting for Accept, at least one. What becomes of
+// connection attempts beyond them is set with SetBacklogPolicy.
 func (s *Socket) Listen(backlog int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -71,9 +196,13 @@ func (s *Socket) Listen(backlog int) error {
 		return fmt.Errorf("socket already listening")
 	}
 
+	if backlog < 1 {
+		backlog = 1
+	}
 	s.isListening = true
 	s.backlog = backlog
 	s.acceptQueue = make(chan *Connection, backlog)
+	s.trackListener(true)
 
 	return nil
 }
@@ -81,167 +210,452 @@ func (s *Socket) Listen(backlog int) error {
 // Accept accepts a new connection.
 // Blocks until a connection is available.
 func (s *Socket) Accept() (*Socket, error) {
-	if !s.isListening {
+	return s.AcceptContext(context.Background())
+}
+
+// AcceptContext accepts a new connection, blocking until one is available,
+// ctx is done or the read deadline passes.
+func (s *Socket) AcceptContext(ctx context.Context) (*Socket, error) {
+	s.mu.RLock()
+	listening, queue := s.isListening, s.acceptQueue
+	s.mu.RUnlock()
+	if !listening {
 		return nil, fmt.Errorf("socket not listening")
 	}
 
 	// Wait for a connection
-	conn, ok := <-s.acceptQueue
-	if !ok {
-		return nil, fmt.Errorf("accept queue closed")
+	var conn *Connection
+	select {
+	case c, ok := <-queue:
+		if !ok {
+			return nil, fmt.Errorf("accept queue closed")
+		}
+		conn = c
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.readDeadline.wait():
+		return nil, fmt.Errorf("accept: %w", os.ErrDeadlineExceeded)
 	}
+	s.admitHeld()
 
 	// Create a new socket for this connection
 	newSocket := &Socket{
-		localAddr:  conn.LocalAddr,
-		localPort:  conn.LocalPort,
-		remoteAddr: conn.RemoteAddr,
-		remotePort: conn.RemotePort,
-		conn:       conn,
-		sendFunc:   s.sendFunc,
-		dataReady:  make(chan []byte, 100),
+		localAddr:    conn.localKey(),
+		localPort:    conn.LocalPort,
+		remoteAddr:   conn.remoteKey(),
+		remotePort:   conn.RemotePort,
+		conn:         conn,
+		sendFunc:     s.sendFunc,
+		sendFuncIPv6: s.sendFuncIPv6,
+		gso:          s.gso,
+		reuseAddr:    s.reuseAddr,
+		opts:         s.opts,
+		recv:         newSocketQueue(),
+
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
 	}
 
 	// Set up connection callbacks
 	conn.onSegmentReady = func(seg *Segment) error {
-		if newSocket.sendFunc != nil {
-			return newSocket.sendFunc(seg, conn.LocalAddr, conn.RemoteAddr)
-		}
-		return nil
+		return newSocket.send(seg, newSocket.localAddr, newSocket.remoteAddr)
 	}
 
-	conn.onDataReady = func(data []byte) {
-		newSocket.dataReady <- data
-	}
+	conn.onClose = newSocket.recv.close
+	conn.onFinReceived = newSocket.recv.close
+	conn.onUrgent = newSocket.recv.urgent
 
-	conn.onClose = func() {
-		close(newSocket.dataReady)
-	}
+	// Data may have arrived before the connection was accepted
+	conn.setDataReady(func(data []byte) {
+		newSocket.receive(conn, data)
+	})
 
 	return newSocket, nil
 }
 
 // Connect connects to a remote address and port.
 func (s *Socket) Connect(remoteAddr common.IPv4Address, remotePort uint16) error {
+	return s.ConnectWithData(remoteAddr, remotePort, nil)
+}
+
+// ConnectContext connects to a remote address and port, waiting until the
+// connection opens, fails or ctx is done. A connection that has not opened
+// when ctx is done is abandoned.
+func (s *Socket) ConnectContext(ctx context.Context, remoteAddr common.IPv4Address, remotePort uint16) error {
+	return s.ConnectWithDataContext(ctx, remoteAddr, remotePort, nil)
+}
+
+// ConnectWithData connects to a remote address and port and sends data.
+// With Fast Open enabled and a cookie cached for the server, the data goes
+// in the SYN and reaches the server a round trip sooner; otherwise it is
+// sent once the connection is established.
+func (s *Socket) ConnectWithData(remoteAddr common.IPv4Address, remotePort uint16, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultConnectTimeout)
+	defer cancel()
+	return s.ConnectWithDataContext(ctx, remoteAddr, remotePort, data)
+}
+
+// ConnectWithDataContext is ConnectWithData, waiting until ctx is done
+// rather than DefaultConnectTimeout.
+func (s *Socket) ConnectWithDataContext(ctx context.Context, remoteAddr common.IPv4Address, remotePort uint16, data []byte) error {
+	return s.connect(ctx, ipKey(remoteAddr), remotePort, data)
+}
+
+// ConnectIPv6 connects to a remote IPv6 address and port. An IPv4-mapped
+// address connects over IPv4.
+func (s *Socket) ConnectIPv6(remoteAddr common.IPv6Address, remotePort uint16) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultConnectTimeout)
+	defer cancel()
+	return s.ConnectIPv6Context(ctx, remoteAddr, remotePort)
+}
+
+// ConnectIPv6Context is ConnectIPv6, waiting until ctx is done rather than
+// DefaultConnectTimeout.
+func (s *Socket) ConnectIPv6Context(ctx context.Context, remoteAddr common.IPv6Address, remotePort uint16) error {
+	return s.connect(ctx, remoteAddr, remotePort, nil)
+}
+
+// connect connects to a remote address in the form it is looked up by.
+func (s *Socket) connect(ctx context.Context, remoteAddr common.IPv6Address, remotePort uint16, data []byte) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
 	if s.conn != nil {
+		s.mu.Unlock()
 		return fmt.Errorf("socket already connected")
 	}
+	if !s.localAddr.IsUnspecified() && isIPv4(s.localAddr) != isIPv4(remoteAddr) {
+		s.mu.Unlock()
+		return fmt.Errorf("cannot connect to %s from %s: address family mismatch",
+			hostPort(remoteAddr, remotePort), hostPort(s.localAddr, s.localPort))
+	}
+
+	if err := timeWaitTable.reuse(fourTuple{s.localAddr, s.localPort, remoteAddr, remotePort}); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("failed to connect: %w", err)
+	}
 
 	s.remoteAddr = remoteAddr
 	s.remotePort = remotePort
 
 	// Create connection
-	s.conn = NewConnection(s.localAddr, s.localPort, remoteAddr, remotePort)
+	s.conn = newConnectionFor(s.localAddr, s.localPort, remoteAddr, remotePort)
 
 	// Set up callbacks
 	s.conn.onSegmentReady = func(seg *Segment) error {
-		if s.sendFunc != nil {
-			return s.sendFunc(seg, s.localAddr, remoteAddr)
-		}
-		return nil
+		return s.send(seg, s.localAddr, remoteAddr)
 	}
 
-	s.conn.onDataReady = func(data []byte) {
-		s.dataReady <- data
+	conn := s.conn
+	conn.onDataReady = func(data []byte) {
+		s.receive(conn, data)
+	}
+
+	s.conn.onClose = s.recv.close
+	s.conn.onFinReceived = s.recv.close
+	s.conn.onUrgent = s.recv.urgent
+
+	s.conn.gso = s.gso
+	s.conn.setOptions(s.opts)
+	s.setRouteMSS(s.conn)
+	s.conn.setMD5Key(s.md5Keys[remoteAddr])
+	s.conn.setAO(newAOConn(s.aoKeys[remoteAddr]))
+
+	if s.demux != nil {
+		conn.mu.Lock()
+		err := s.demux.addConn(conn, s.binding)
+		conn.mu.Unlock()
+		if err != nil {
+			s.binding.Release()
+		}
+		s.binding = nil
+		if err != nil {
+			s.conn = nil
+			s.mu.Unlock()
+			return fmt.Errorf("failed to connect: %w", err)
+		}
 	}
 
-	s.conn.onClose = func() {
-		close(s.dataReady)
+	fastOpen := s.fastOpen != nil
+	if fastOpen {
+		s.conn.tfo = NewTFOConnection(s.fastOpen)
+		s.conn.tfo.QueueData(data)
 	}
 
 	// Initiate connection
-	if err := s.conn.ActiveOpen(); err != nil {
+	if err := conn.ActiveOpen(); err != nil {
+		s.mu.Unlock()
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 
-	// Wait for connection to be established (with timeout)
-	timeout := time.After(10 * time.Second)
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+	// Release the lock while waiting so HandleIncomingSegment can deliver the SYN-ACK
+	s.mu.Unlock()
 
+	// Wait for connection to be established
 	for {
+		switch conn.GetState() {
+		case StateSynSent, StateSynReceived:
+		case StateClosed:
+			if err := conn.Err(); err != nil {
+				return fmt.Errorf("connection failed: %w", err)
+			}
+			return fmt.Errorf("connection failed")
+		default:
+			// Established, or already closing if the peer sent a FIN at once
+			if !fastOpen && len(data) > 0 {
+				_, err := s.SendContext(ctx, data)
+				return err
+			}
+			return nil
+		}
+
 		select {
-		case <-timeout:
-			return fmt.Errorf("connection timeout")
-		case <-ticker.C:
-			if s.conn.GetState() == StateEstablished {
-				return nil
+		case <-conn.stateChanged:
+		case <-ctx.Done():
+			err := conn.Err()
+			conn.mu.Lock()
+			if state := conn.state.GetState(); state == StateSynSent || state == StateSynReceived {
+				conn.abort()
 			}
-			if s.conn.GetState() == StateClosed {
-				return fmt.Errorf("connection failed")
+			conn.mu.Unlock()
+			if err != nil {
+				return fmt.Errorf("connection timeout: %w (%w)", ctx.Err(), err)
 			}
+			return fmt.Errorf("connection timeout: %w", ctx.Err())
 		}
 	}
 }
 
-// Send sends data over the connection.
+// Send sends data over the connection. It blocks while the send buffer
+// (see OptSendBuffer) is full, until the peer acknowledges enough data for
+// the rest to fit, and returns how much was queued if the connection can
+// no longer send.
 func (s *Socket) Send(data []byte) (int, error) {
+	return s.SendContext(context.Background(), data)
+}
+
+// SendContext is Send, giving up waiting for send buffer space when ctx is
+// done or the write deadline passes. It returns how much of data was
+// queued.
+func (s *Socket) SendContext(ctx context.Context, data []byte) (int, error) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
+	conn := s.conn
+	s.mu.RUnlock()
 
-	if s.conn == nil {
+	if conn == nil {
 		return 0, fmt.Errorf("not connected")
 	}
 
-	if err := s.conn.Send(data); err != nil {
-		return 0, err
-	}
+	sent := 0
+	for {
+		n, err := conn.write(data[sent:])
+		sent += n
+		if err != nil && !errors.Is(err, ErrSendBufferFull) {
+			return sent, err
+		}
+		if sent == len(data) {
+			return sent, nil
+		}
 
-	return len(data), nil
+		select {
+		case <-conn.writable:
+		case <-ctx.Done():
+			return sent, ctx.Err()
+		case <-s.writeDeadline.wait():
+			return sent, fmt.Errorf("send: %w", os.ErrDeadlineExceeded)
+		}
+	}
 }
 
-// Recv receives data from the connection.
-// Blocks until data is available.
+// Recv receives data from the connection into buf, returning how much was
+// read. Blocks until data is available or the read deadline passes; data
+// that does not fit buf is returned by the next call.
 func (s *Socket) Recv(buf []byte) (int, error) {
-	data, ok := <-s.dataReady
-	if !ok {
-		return 0, fmt.Errorf("connection closed")
-	}
+	return s.RecvContext(context.Background(), buf)
+}
 
-	n := copy(buf, data)
-	return n, nil
+// RecvContext is Recv, giving up when ctx is done.
+func (s *Socket) RecvContext(ctx context.Context, buf []byte) (int, error) {
+	n, err := s.recv.read(ctx, buf, s.readDeadline.wait())
+	s.consumed(n)
+	if err == errConnectionClosed {
+		// A reset connection reports the reset rather than EOF
+		s.mu.RLock()
+		conn := s.conn
+		s.mu.RUnlock()
+		if conn != nil {
+			conn.mu.RLock()
+			if abortErr := conn.abortError(); abortErr != nil {
+				err = abortErr
+			}
+			conn.mu.RUnlock()
+		}
+	}
+	return n, err
 }
 
 // RecvTimeout receives data with a timeout.
 func (s *Socket) RecvTimeout(buf []byte, timeout time.Duration) (int, error) {
-	select {
-	case data, ok := <-s.dataReady:
-		if !ok {
-			return 0, fmt.Errorf("connection closed")
-		}
-		n := copy(buf, data)
-		return n, nil
-	case <-time.After(timeout):
-		return 0, fmt.Errorf("receive timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	n, err := s.RecvContext(ctx, buf)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return n, fmt.Errorf("receive timeout")
+	}
+	return n, err
+}
+
+// consumed hands the number of bytes the application read back to the
+// connection, whose receive window they reopen.
+func (s *Socket) consumed(n int) {
+	s.mu.RLock()
+	conn := s.conn
+	s.mu.RUnlock()
+	if conn != nil && n > 0 {
+		conn.consumed(n)
 	}
 }
 
-// Close closes the socket.
+// Close closes the socket. How a connection is closed depends on
+// OptLinger: by default Close sends a FIN and returns, a zero linger time
+// resets the connection, and a positive one waits that long for the FIN to
+// be acknowledged.
 func (s *Socket) Close() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
 	if s.isListening {
 		close(s.acceptQueue)
 		s.isListening = false
+		s.resetHeld()
+		s.trackListener(false)
+		if s.demux != nil {
+			s.demux.removeListener(s, endpoint{s.localAddr, s.localPort})
+		}
+		s.mu.Unlock()
 		return nil
 	}
 
-	if s.conn != nil {
-		return s.conn.Close()
+	// The lock is released so that the ACK of the FIN can be delivered
+	conn, linger, writeClosed := s.conn, s.opts.linger, s.writeClosed
+	s.writeClosed = true
+	s.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	s.shutdownRead(conn)
+	if linger == 0 {
+		return conn.reset()
+	}
+	if !writeClosed {
+		if err := conn.Close(); err != nil {
+			return err
+		}
+	}
+	if linger > 0 {
+		waitFINAcked(conn, linger)
 	}
+	return nil
+}
+
+// CloseWrite shuts down the sending side of the connection (a half-close).
+// The FIN is sent once the data already queued has gone out, and the
+// socket goes on receiving until the peer's FIN, after which Recv fails
+// once the data is read.
+func (s *Socket) CloseWrite() error {
+	s.mu.Lock()
+	conn, writeClosed := s.conn, s.writeClosed
+	s.writeClosed = true
+	s.mu.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("socket not connected")
+	}
+	if writeClosed {
+		return nil
+	}
+	return conn.Close()
+}
+
+// CloseRead shuts down the receiving side of the socket. Data not yet read
+// is discarded, Recv fails, and data that arrives later is acknowledged
+// and dropped.
+func (s *Socket) CloseRead() error {
+	s.mu.RLock()
+	conn := s.conn
+	s.mu.RUnlock()
 
+	if conn == nil {
+		return fmt.Errorf("socket not connected")
+	}
+	s.shutdownRead(conn)
 	return nil
 }
 
+// shutdownRead discards the receive queue, reopening the window for what
+// it held.
+func (s *Socket) shutdownRead(conn *Connection) {
+	if n := s.recv.shutdown(); n > 0 {
+		conn.consumed(n)
+	}
+}
+
+// receive queues data the connection delivered, counting it against the
+// receive window, unless reading was shut down.
+func (s *Socket) receive(conn *Connection, data []byte) {
+	conn.recvQueued.Add(int64(len(data)))
+	if !s.recv.push(data) {
+		conn.recvQueued.Add(-int64(len(data)))
+	}
+}
+
+// waitFINAcked waits up to timeout for the peer to acknowledge the FIN
+// Close queued, which it can only do once the data ahead of it was sent.
+func waitFINAcked(conn *Connection, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		switch conn.GetState() {
+		case StateFinWait2, StateTimeWait, StateClosed:
+			return
+		}
+		select {
+		case <-conn.stateChanged:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
 // HandleIncomingSegment handles an incoming TCP segment.
 // This should be called by the network stack when a TCP segment is received.
 func (s *Socket) HandleIncomingSegment(seg *Segment, srcIP common.IPv4Address, dstIP common.IPv4Address) error {
+	return s.handleSegment(seg, ipKey(srcIP), ipKey(dstIP))
+}
+
+// HandleIncomingSegmentIPv6 handles an incoming TCP segment received over
+// IPv6.
+func (s *Socket) HandleIncomingSegmentIPv6(seg *Segment, srcIP common.IPv6Address, dstIP common.IPv6Address) error {
+	return s.handleSegment(seg, srcIP, dstIP)
+}
+
+// handleSegment is HandleIncomingSegment with the addresses in the form
+// they are looked up by.
+func (s *Socket) handleSegment(seg *Segment, srcIP common.IPv6Address, dstIP common.IPv6Address) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	err := s.handleSegmentLocked(seg, srcIP, dstIP)
+	notify := s.takeQueueFullNotice()
+	s.mu.Unlock()
+	notify()
+	return err
+}
+
+// handleSegmentLocked is handleSegment with s.mu held.
+func (s *Socket) handleSegmentLocked(seg *Segment, srcIP common.IPv6Address, dstIP common.IPv6Address) error {
+	// A connection in TIME_WAIT no longer has a socket of its own
+	if handled, err := timeWaitTable.handleSegment(seg, srcIP, dstIP); handled {
+		return err
+	}
 
 	if s.isListening {
 		return s.handleListeningSegment(seg, srcIP, dstIP)
@@ -256,8 +670,8 @@ func (s *Socket) HandleIncomingSegment(seg *Segment, srcIP common.IPv4Address, d
 }
 
 // handleListeningSegment handles segments for a listening socket.
-func (s *Socket) handleListeningSegment(seg *Segment, srcIP common.IPv4Address, dstIP common.IPv4Address) error {
-	connKey := fmt.Sprintf("%s:%d", srcIP, seg.SourcePort)
+func (s *Socket) handleListeningSegment(seg *Segment, srcIP common.IPv6Address, dstIP common.IPv6Address) error {
+	connKey := hostPort(srcIP, seg.SourcePort)
 
 	// Check if we have a pending connection
 	s.pendingConnsMu.Lock()
@@ -270,37 +684,54 @@ func (s *Socket) handleListeningSegment(seg *Segment, srcIP common.IPv4Address,
 			return err
 		}
 
-		// Check if connection is established
-		if conn.GetState() == StateEstablished {
-			// Move to accept queue
+		// A reset connection is forgotten; the listener goes on listening
+		if conn.GetState() == StateClosed {
 			s.pendingConnsMu.Lock()
 			delete(s.pendingConns, connKey)
 			s.pendingConnsMu.Unlock()
+			s.unhold(connKey)
+			return nil
+		}
 
-			select {
-			case s.acceptQueue <- conn:
-				// Connection added to accept queue
-			default:
-				// Accept queue full - drop connection
-				conn.Close()
+		// An established connection moves to the accept queue, if it is
+		// not already held back for room in it
+		if conn.GetState() == StateEstablished && !slices.Contains(s.held, connKey) {
+			queued, err := s.queueConn(connKey, conn)
+			if err != nil || queued {
+				return err
 			}
+			return s.establishedOverflow(connKey, conn)
 		}
 
 		return nil
 	}
 
-	// New connection attempt
+	// New connection attempt, turned away while the accept queue is full
 	if seg.HasFlag(FlagSYN) && !seg.HasFlag(FlagACK) {
+		if s.acceptQueueFull() {
+			s.overflow()
+			if s.backlogPolicy == BacklogReset {
+				return s.sendRST(seg, dstIP, srcIP)
+			}
+			return nil
+		}
+
 		// Create new connection
-		newConn := NewConnection(dstIP, s.localPort, srcIP, seg.SourcePort)
+		newConn := newConnectionFor(dstIP, s.localPort, srcIP, seg.SourcePort)
 
 		// Set up callbacks
 		newConn.onSegmentReady = func(outSeg *Segment) error {
-			if s.sendFunc != nil {
-				return s.sendFunc(outSeg, dstIP, srcIP)
-			}
-			return nil
+			return s.send(outSeg, dstIP, srcIP)
+		}
+
+		if s.fastOpen != nil {
+			newConn.tfo = NewTFOConnection(s.fastOpen)
 		}
+		newConn.gso = s.gso
+		newConn.setOptions(s.opts)
+		s.setRouteMSS(newConn)
+		newConn.setMD5Key(s.md5Keys[srcIP])
+		newConn.setAO(newAOConn(s.aoKeys[srcIP]))
 
 		// Transition to LISTEN state
 		newConn.state.SetState(StateListen)
@@ -322,8 +753,33 @@ func (s *Socket) handleListeningSegment(seg *Segment, srcIP common.IPv4Address,
 	return s.sendRST(seg, dstIP, srcIP)
 }
 
-// sendRST sends a RST segment.
-func (s *Socket) sendRST(seg *Segment, srcIP common.IPv4Address, dstIP common.IPv4Address) error {
+// sendRST sends a RST segment answering seg, unless seg is itself an RST
+// (RFC 793 Section 3.4).
+func (s *Socket) sendRST(seg *Segment, srcIP common.IPv6Address, dstIP common.IPv6Address) error {
+	if seg.HasFlag(FlagRST) {
+		return nil
+	}
+	rst, err := newReset(seg, srcIP, dstIP)
+	if err != nil {
+		return err
+	}
+	// A peer with TCP-AO keys gets an unsigned RST, which it drops: without
+	// a connection there are no ISNs to derive traffic keys from
+	if key := s.md5Keys[dstIP]; key != nil {
+		if err := signMD5(rst, srcIP, dstIP, key); err != nil {
+			return err
+		}
+		rst.Checksum = 0
+		if rst.Checksum, err = checksumFor(rst, srcIP, dstIP); err != nil {
+			return err
+		}
+	}
+
+	return s.send(rst, srcIP, dstIP)
+}
+
+// newReset builds the RST answering seg, to be sent from srcIP to dstIP.
+func newReset(seg *Segment, srcIP common.IPv6Address, dstIP common.IPv6Address) (*Segment, error) {
 	var rst *Segment
 
 	if seg.HasFlag(FlagACK) {
@@ -341,21 +797,24 @@ func (s *Socket) sendRST(seg *Segment, srcIP common.IPv4Address, dstIP common.IP
 		rst = NewSegment(seg.DestinationPort, seg.SourcePort, 0, seg.SequenceNumber+seqLen, FlagRST|FlagACK, 0, nil)
 	}
 
-	checksum, err := rst.CalculateChecksum(srcIP, dstIP)
+	checksum, err := checksumFor(rst, srcIP, dstIP)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	rst.Checksum = checksum
 
-	if s.sendFunc != nil {
-		return s.sendFunc(rst, srcIP, dstIP)
-	}
-
-	return nil
+	return rst, nil
 }
 
-// GetLocalAddr returns the local address.
+// GetLocalAddr returns the local address, or the zero address for a socket
+// bound to an IPv6 address.
 func (s *Socket) GetLocalAddr() common.IPv4Address {
+	addr, _ := ipv4Of(s.localAddr)
+	return addr
+}
+
+// GetLocalAddr6 returns the local address, IPv4-mapped for an IPv4 one.
+func (s *Socket) GetLocalAddr6() common.IPv6Address {
 	return s.localAddr
 }
 
@@ -364,8 +823,16 @@ func (s *Socket) GetLocalPort() uint16 {
 	return s.localPort
 }
 
-// GetRemoteAddr returns the remote address.
+// GetRemoteAddr returns the remote address, or the zero address for a
+// connection over IPv6.
 func (s *Socket) GetRemoteAddr() common.IPv4Address {
+	addr, _ := ipv4Of(s.remoteAddr)
+	return addr
+}
+
+// GetRemoteAddr6 returns the remote address, IPv4-mapped for a connection
+// over IPv4.
+func (s *Socket) GetRemoteAddr6() common.IPv6Address {
 	return s.remoteAddr
 }
 
@@ -0,0 +1,287 @@
+package tcp
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+)
+
+const (
+	// DefaultSendBufferSize is the default limit on data queued to send
+	// and not yet acknowledged.
+	DefaultSendBufferSize = 4 << 20
+
+	// DefaultReceiveBufferSize is the default limit on data received and
+	// not yet read, which is also the largest receive window advertised.
+	DefaultReceiveBufferSize = 65535
+
+	// MinBufferSize is the smallest send or receive buffer size accepted.
+	MinBufferSize = 2048
+
+	// Keepalive defaults (RFC 1122 Section 4.2.3.6, and Linux's
+	// tcp_keepalive_intvl and tcp_keepalive_probes)
+	DefaultKeepAliveIdle     = 2 * time.Hour
+	DefaultKeepAliveInterval = 75 * time.Second
+	DefaultKeepAliveCount    = 9
+)
+
+// socketOptions are the options set with Socket.SetSockOpt. A socket
+// applies them to its connection and hands them to the connections it
+// accepts.
+type socketOptions struct {
+	noDelay       bool
+	sendBuffer    int
+	receiveBuffer int
+	ttl           uint8
+	tos           uint8
+	linger        time.Duration // Negative if Close does not linger
+	oobInline     bool          // Urgent data stays in the stream
+	maxPacingRate int           // Bytes per second, 0 for no limit
+	device        string        // Interface bound to, empty for any
+
+	keepAlive         bool
+	keepAliveIdle     time.Duration
+	keepAliveInterval time.Duration
+	keepAliveCount    int
+}
+
+// defaultSocketOptions are the options of a new socket. Unlike BSD
+// sockets, connections send small segments without waiting by default;
+// clearing OptNoDelay enables Nagle's algorithm.
+var defaultSocketOptions = socketOptions{
+	noDelay:           true,
+	sendBuffer:        DefaultSendBufferSize,
+	receiveBuffer:     DefaultReceiveBufferSize,
+	ttl:               ip.DefaultTTL,
+	linger:            -1,
+	keepAliveIdle:     DefaultKeepAliveIdle,
+	keepAliveInterval: DefaultKeepAliveInterval,
+	keepAliveCount:    DefaultKeepAliveCount,
+}
+
+// SetSockOpt sets a socket option. Each option takes a value of the type
+// documented with its constant; a value of another type, or out of range,
+// is rejected with common.ErrInvalidOptionValue.
+//
+// Options apply to the socket's connection at once, including one already
+// established, and a listening socket's options are inherited by the
+// connections it accepts:
+//
+//   - OptReuseAddr is SetReuseAddr.
+//   - OptNoDelay false enables Nagle's algorithm (RFC 896): a segment
+//     smaller than the MSS waits while data is unacknowledged. Setting it
+//     sends any data held back.
+//   - OptSendBuffer limits the data queued to send and not yet
+//     acknowledged by the peer. Socket.Send blocks, and Connection.Send
+//     fails with ErrSendBufferFull, rather than exceed it.
+//   - OptReceiveBuffer limits the data received and not yet read. The
+//     receive window advertised is the space left, at most 65535 bytes.
+//   - OptTTL and OptTOS set the TTL and TOS octet of the IP packets
+//     carrying the connection's segments.
+//   - OptLinger zero makes Close reset the connection, discarding queued
+//     data. A positive duration makes Close wait up to that long for the
+//     peer to acknowledge the FIN.
+//   - OptKeepAlive probes a connection idle for OptKeepAliveIdle, every
+//     OptKeepAliveInterval, and aborts it with ErrConnectionTimedOut after
+//     OptKeepAliveCount probes go unanswered.
+//   - OptOOBInline leaves urgent data in the stream, where Recv returns
+//     it, rather than taking the urgent byte out for RecvOOB (see
+//     SendUrgent).
+//   - OptMaxPacingRate caps the rate, in bytes per second, at which the
+//     connection paces its segments (see pacingRate). It paces at that
+//     rate even before the round-trip time is known.
+//   - OptBindToDevice names the interface the connection's segments leave
+//     on (Segment.Device), whatever the route to the peer, and a
+//     Demultiplexer with a SourceSelector picks the local address of a
+//     socket connecting from an unspecified one among the routes out of
+//     it. An empty name unbinds the socket.
+func (s *Socket) SetSockOpt(opt common.SocketOption, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	opts := s.opts
+	switch opt {
+	case common.OptReuseAddr:
+		enabled, err := common.BoolOption(opt, value)
+		if err != nil {
+			return err
+		}
+		s.reuseAddr = enabled
+		return nil
+	case common.OptNoDelay:
+		enabled, err := common.BoolOption(opt, value)
+		if err != nil {
+			return err
+		}
+		opts.noDelay = enabled
+	case common.OptKeepAlive:
+		enabled, err := common.BoolOption(opt, value)
+		if err != nil {
+			return err
+		}
+		opts.keepAlive = enabled
+	case common.OptOOBInline:
+		enabled, err := common.BoolOption(opt, value)
+		if err != nil {
+			return err
+		}
+		opts.oobInline = enabled
+	case common.OptSendBuffer:
+		size, err := common.IntOption(opt, value, MinBufferSize, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		opts.sendBuffer = size
+	case common.OptReceiveBuffer:
+		size, err := common.IntOption(opt, value, MinBufferSize, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		opts.receiveBuffer = size
+	case common.OptTTL:
+		ttl, err := common.IntOption(opt, value, 1, 255)
+		if err != nil {
+			return err
+		}
+		opts.ttl = uint8(ttl)
+	case common.OptTOS:
+		tos, err := common.IntOption(opt, value, 0, 255)
+		if err != nil {
+			return err
+		}
+		opts.tos = uint8(tos)
+	case common.OptLinger:
+		linger, err := common.DurationOption(opt, value, math.MinInt64)
+		if err != nil {
+			return err
+		}
+		if linger < 0 {
+			linger = -1
+		}
+		opts.linger = linger
+	case common.OptKeepAliveIdle, common.OptKeepAliveInterval:
+		d, err := common.DurationOption(opt, value, time.Second)
+		if err != nil {
+			return err
+		}
+		if opt == common.OptKeepAliveIdle {
+			opts.keepAliveIdle = d
+		} else {
+			opts.keepAliveInterval = d
+		}
+	case common.OptKeepAliveCount:
+		count, err := common.IntOption(opt, value, 1, 255)
+		if err != nil {
+			return err
+		}
+		opts.keepAliveCount = count
+	case common.OptMaxPacingRate:
+		rate, err := common.IntOption(opt, value, 0, math.MaxInt)
+		if err != nil {
+			return err
+		}
+		opts.maxPacingRate = rate
+	case common.OptBindToDevice:
+		device, err := common.StringOption(opt, value, common.MaxDeviceNameLength)
+		if err != nil {
+			return err
+		}
+		opts.device = device
+	default:
+		return fmt.Errorf("%s: %w", opt, common.ErrOptionNotSupported)
+	}
+
+	s.opts = opts
+	if s.conn != nil {
+		s.conn.mu.Lock()
+		s.conn.setOptions(opts)
+		s.conn.mu.Unlock()
+	}
+	return nil
+}
+
+// GetSockOpt returns the value of a socket option, of the type
+// SetSockOpt takes.
+func (s *Socket) GetSockOpt(opt common.SocketOption) (any, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch opt {
+	case common.OptReuseAddr:
+		return s.reuseAddr, nil
+	case common.OptNoDelay:
+		return s.opts.noDelay, nil
+	case common.OptKeepAlive:
+		return s.opts.keepAlive, nil
+	case common.OptOOBInline:
+		return s.opts.oobInline, nil
+	case common.OptSendBuffer:
+		return s.opts.sendBuffer, nil
+	case common.OptReceiveBuffer:
+		return s.opts.receiveBuffer, nil
+	case common.OptTTL:
+		return int(s.opts.ttl), nil
+	case common.OptTOS:
+		return int(s.opts.tos), nil
+	case common.OptLinger:
+		return s.opts.linger, nil
+	case common.OptKeepAliveIdle:
+		return s.opts.keepAliveIdle, nil
+	case common.OptKeepAliveInterval:
+		return s.opts.keepAliveInterval, nil
+	case common.OptKeepAliveCount:
+		return s.opts.keepAliveCount, nil
+	case common.OptMaxPacingRate:
+		return s.opts.maxPacingRate, nil
+	case common.OptBindToDevice:
+		return s.opts.device, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", opt, common.ErrOptionNotSupported)
+	}
+}
+
+// SetTrafficClass sets the DSCP (0-63) of the IP packets carrying the
+// connection's segments, over IPv4 or IPv6: the upper six bits of the
+// OptTOS octet, whose ECN bits are left alone. A DSCP of zero leaves the
+// default DSCP of the route, if it has one.
+func (s *Socket) SetTrafficClass(dscp uint8) error {
+	if dscp > 63 {
+		return fmt.Errorf("%s: %w: DSCP %d not in [0, 63]", common.OptTOS, common.ErrInvalidOptionValue, dscp)
+	}
+	s.mu.RLock()
+	tos := s.opts.tos
+	s.mu.RUnlock()
+	return s.SetSockOpt(common.OptTOS, int(dscp<<2|tos&0x3))
+}
+
+// TrafficClass returns the DSCP set with SetTrafficClass.
+func (s *Socket) TrafficClass() uint8 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.opts.tos >> 2
+}
+
+// setOptions applies socket options to the connection. The caller holds
+// c.mu.
+func (c *Connection) setOptions(opts socketOptions) {
+	nagleOff := opts.noDelay && !c.opts.noDelay
+	keepAliveChanged := opts.keepAlive != c.opts.keepAlive || opts.keepAliveIdle != c.opts.keepAliveIdle
+	pacingChanged := opts.maxPacingRate != c.opts.maxPacingRate
+	c.opts = opts
+	c.rcvWnd = uint16(c.freeReceiveSpace())
+	c.wakeWriters()
+	if keepAliveChanged && (!opts.keepAlive || c.state.GetState().IsConnectionEstablished()) {
+		c.armKeepAlive()
+	}
+
+	// Data held back at the old rate goes out at the new one
+	if pacingChanged {
+		c.pacingNext = time.Time{}
+	}
+	if (nagleOff || pacingChanged) && c.sendBuffer.Len() > 0 && c.state.GetState().CanSendData() {
+		c.sendData()
+	}
+}
@@ -0,0 +1,256 @@
+package tcp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestSetSockOpt(t *testing.T) {
+	tests := []struct {
+		opt     common.SocketOption
+		value   any
+		wantErr error
+	}{
+		{common.OptReuseAddr, true, nil},
+		{common.OptNoDelay, false, nil},
+		{common.OptKeepAlive, true, nil},
+		{common.OptOOBInline, true, nil},
+		{common.OptSendBuffer, 1 << 16, nil},
+		{common.OptReceiveBuffer, 8192, nil},
+		{common.OptTTL, 32, nil},
+		{common.OptTOS, 0xb8, nil},
+		{common.OptLinger, 5 * time.Second, nil},
+		{common.OptLinger, time.Duration(-1), nil},
+		{common.OptKeepAliveIdle, time.Minute, nil},
+		{common.OptKeepAliveInterval, 10 * time.Second, nil},
+		{common.OptKeepAliveCount, 3, nil},
+		{common.OptMaxPacingRate, 1 << 20, nil},
+		{common.OptBindToDevice, "eth1", nil},
+		{common.OptBindToDevice, "", nil},
+		{common.OptNoDelay, 1, common.ErrInvalidOptionValue},
+		{common.OptTTL, 0, common.ErrInvalidOptionValue},
+		{common.OptTTL, 256, common.ErrInvalidOptionValue},
+		{common.OptTOS, uint8(1), common.ErrInvalidOptionValue},
+		{common.OptSendBuffer, 100, common.ErrInvalidOptionValue},
+		{common.OptKeepAliveIdle, time.Millisecond, common.ErrInvalidOptionValue},
+		{common.OptKeepAliveCount, 0, common.ErrInvalidOptionValue},
+		{common.OptMaxPacingRate, -1, common.ErrInvalidOptionValue},
+		{common.OptBindToDevice, 1, common.ErrInvalidOptionValue},
+		{common.OptBindToDevice, "a-very-long-name", common.ErrInvalidOptionValue},
+		{common.SocketOption(0), true, common.ErrOptionNotSupported},
+	}
+
+	for _, tt := range tests {
+		s := NewSocket(testClientIP, 50000)
+		err := s.SetSockOpt(tt.opt, tt.value)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("SetSockOpt(%v, %v) error = %v, want %v", tt.opt, tt.value, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+
+		got, err := s.GetSockOpt(tt.opt)
+		if err != nil {
+			t.Fatalf("GetSockOpt(%v) error = %v", tt.opt, err)
+		}
+		if got != tt.value {
+			t.Errorf("GetSockOpt(%v) = %v, want %v", tt.opt, got, tt.value)
+		}
+	}
+}
+
+func TestSetTrafficClass(t *testing.T) {
+	s := NewSocket(testClientIP, 50000)
+	if err := s.SetSockOpt(common.OptTOS, 0x02); err != nil { // ECT(0)
+		t.Fatalf("SetSockOpt(OptTOS) error = %v", err)
+	}
+
+	if err := s.SetTrafficClass(46); err != nil {
+		t.Fatalf("SetTrafficClass() error = %v", err)
+	}
+	if got := s.TrafficClass(); got != 46 {
+		t.Errorf("TrafficClass() = %d, want 46", got)
+	}
+	if got, _ := s.GetSockOpt(common.OptTOS); got != 0xba {
+		t.Errorf("GetSockOpt(OptTOS) = %#x, want 0xba with the ECN bits kept", got)
+	}
+
+	if err := s.SetTrafficClass(64); !errors.Is(err, common.ErrInvalidOptionValue) {
+		t.Errorf("SetTrafficClass(64) error = %v, want %v", err, common.ErrInvalidOptionValue)
+	}
+}
+
+func TestSockOptInFlight(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	s := NewSocket(testClientIP, 50000)
+	s.conn = client.conn
+	for opt, value := range map[common.SocketOption]any{
+		common.OptTTL:           9,
+		common.OptTOS:           0x28,
+		common.OptReceiveBuffer: 4096,
+		common.OptBindToDevice:  "eth1",
+	} {
+		if err := s.SetSockOpt(opt, value); err != nil {
+			t.Fatalf("SetSockOpt(%v) error = %v", opt, err)
+		}
+	}
+
+	if err := client.conn.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(client.out) != 1 {
+		t.Fatalf("sent %d segments, want 1", len(client.out))
+	}
+	if seg := client.out[0]; seg.TTL != 9 || seg.TOS != 0x28 || seg.WindowSize != 4096 || seg.Device != "eth1" {
+		t.Errorf("segment TTL = %d, TOS = %#x, window = %d, device %q, want 9, 0x28, 4096 and eth1", seg.TTL, seg.TOS, seg.WindowSize, seg.Device)
+	}
+	exchange(t, client, server)
+
+	// The send buffer limit counts unacknowledged data
+	if err := s.SetSockOpt(common.OptSendBuffer, MinBufferSize); err != nil {
+		t.Fatalf("SetSockOpt(OptSendBuffer) error = %v", err)
+	}
+	if err := client.conn.Send(make([]byte, MinBufferSize)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := client.conn.Send([]byte{1}); !errors.Is(err, ErrSendBufferFull) {
+		t.Errorf("Send() past the send buffer error = %v, want %v", err, ErrSendBufferFull)
+	}
+	exchange(t, client, server)
+	if err := client.conn.Send([]byte{1}); err != nil {
+		t.Errorf("Send() after the ACK error = %v", err)
+	}
+}
+
+func TestNagle(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	s := NewSocket(testClientIP, 50000)
+	s.conn = client.conn
+	if err := s.SetSockOpt(common.OptNoDelay, false); err != nil {
+		t.Fatalf("SetSockOpt(OptNoDelay) error = %v", err)
+	}
+
+	// The first small segment goes out; the next waits for its ACK
+	client.conn.Send([]byte("a"))
+	client.conn.Send([]byte("b"))
+	client.conn.Send([]byte("c"))
+	if len(client.out) != 1 {
+		t.Fatalf("sent %d segments with data unacknowledged, want 1", len(client.out))
+	}
+	deliver(t, client, server)
+	deliver(t, server, client)
+	if len(client.out) != 1 || string(client.out[0].Data) != "bc" {
+		t.Fatalf("after the ACK sent %v, want one segment with the held data", client.out)
+	}
+
+	// A full segment is not held back
+	deliver(t, client, server)
+	client.conn.Send([]byte("d"))
+	client.conn.Send(make([]byte, DefaultMSS))
+	if len(client.out) != 1 || len(client.out[0].Data) != DefaultMSS {
+		t.Fatalf("sent %v, want one full segment", client.out)
+	}
+
+	// Setting NoDelay sends what was held
+	if err := s.SetSockOpt(common.OptNoDelay, true); err != nil {
+		t.Fatalf("SetSockOpt(OptNoDelay) error = %v", err)
+	}
+	if len(client.out) != 2 || len(client.out[1].Data) != 1 {
+		t.Errorf("after setting NoDelay sent %d segments, want 2", len(client.out))
+	}
+	exchange(t, client, server)
+	if got := string(server.data); len(got) != 4+DefaultMSS || got[:4] != "abcd" {
+		t.Errorf("server received %d bytes, want %d", len(got), 4+DefaultMSS)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	wheel := manualWheel()
+	client.conn.timers = wheel
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	s := NewSocket(testClientIP, 50000)
+	s.conn = client.conn
+	for opt, value := range map[common.SocketOption]any{
+		common.OptKeepAlive:         true,
+		common.OptKeepAliveIdle:     time.Second,
+		common.OptKeepAliveInterval: time.Second,
+		common.OptKeepAliveCount:    2,
+	} {
+		if err := s.SetSockOpt(opt, value); err != nil {
+			t.Fatalf("SetSockOpt(%v) error = %v", opt, err)
+		}
+	}
+
+	// An idle connection is probed, and a live peer answers
+	advanceWheel(wheel, 1000)
+	if len(client.out) != 1 {
+		t.Fatalf("sent %d segments after the idle time, want 1 probe", len(client.out))
+	}
+	if probe := client.out[0]; probe.SequenceNumber != client.conn.sndUna-1 || len(probe.Data) != 0 {
+		t.Errorf("probe seq = %d, want %d", probe.SequenceNumber, client.conn.sndUna-1)
+	}
+	deliver(t, client, server)
+	if len(server.out) != 1 || !server.out[0].HasFlag(FlagACK) {
+		t.Fatalf("server answered the probe with %v, want an ACK", server.out)
+	}
+	deliver(t, server, client)
+	if client.conn.keepAliveProbes != 0 {
+		t.Errorf("%d probes outstanding after the answer, want 0", client.conn.keepAliveProbes)
+	}
+
+	// A dead peer is given up on once the probes run out
+	advanceWheel(wheel, 1000+2*1000)
+	if got := client.conn.GetState(); got != StateClosed {
+		t.Fatalf("state = %v after unanswered probes, want %v", got, StateClosed)
+	}
+	if err := client.conn.Err(); !errors.Is(err, ErrConnectionTimedOut) {
+		t.Errorf("Err() = %v, want %v", err, ErrConnectionTimedOut)
+	}
+}
+
+func TestLingerReset(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	s := NewSocket(testClientIP, 50000)
+	s.conn = client.conn
+	if err := s.SetSockOpt(common.OptLinger, time.Duration(0)); err != nil {
+		t.Fatalf("SetSockOpt(OptLinger) error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if len(client.out) != 1 || !client.out[0].HasFlag(FlagRST) || client.out[0].SequenceNumber != client.conn.sndNxt {
+		t.Errorf("Close() sent %v, want a RST at sndNxt", client.out)
+	}
+	if got := client.conn.GetState(); got != StateClosed {
+		t.Errorf("state = %v, want %v", got, StateClosed)
+	}
+}
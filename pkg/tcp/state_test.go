@@ -50,7 +50,28 @@ func TestStateMachineTransitions(t *testing.T) {
 			expectedState: StateEstablished,
 			expectError:   false,
 		},
+		{
+			name:          "SYN_SENT -> SYN_RECEIVED (simultaneous open)",
+			initialState:  StateSynSent,
+			event:         EventReceiveSyn,
+			expectedState: StateSynReceived,
+			expectError:   false,
+		},
 		// SYN_RECEIVED state transitions
+		{
+			name:          "SYN_RECEIVED -> CLOSE_WAIT (receive FIN)",
+			initialState:  StateSynReceived,
+			event:         EventReceiveFin,
+			expectedState: StateCloseWait,
+			expectError:   false,
+		},
+		{
+			name:          "SYN_RECEIVED -> FIN_WAIT_1 (close)",
+			initialState:  StateSynReceived,
+			event:         EventClose,
+			expectedState: StateFinWait1,
+			expectError:   false,
+		},
 		{
 			name:          "SYN_RECEIVED -> ESTABLISHED (receive ACK)",
 			initialState:  StateSynReceived,
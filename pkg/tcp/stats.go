@@ -0,0 +1,309 @@
+// Package tcp implements per-connection statistics.
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ipv6"
+	"github.com/therealutkarshpriyadarshi/network/pkg/linkstate"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+const (
+	// cwndHistoryLen is how many congestion window changes a connection
+	// remembers.
+	cwndHistoryLen = 64
+
+	// RTO bounds (RFC 6298)
+	minRTO = time.Second
+	maxRTO = 60 * time.Second
+)
+
+// CwndSample records the congestion window after a change.
+type CwndSample struct {
+	Time     time.Time
+	Cwnd     uint32
+	Ssthresh uint32
+}
+
+// connCounters holds a connection's counters. It is guarded by the
+// connection's mutex.
+type connCounters struct {
+	created          time.Time
+	bytesSent        uint64
+	bytesReceived    uint64
+	segmentsSent     uint64
+	segmentsReceived uint64
+	retransmissions  uint64
+	dupAcks          uint64
+	checksumErrors   uint64
+	segmentsRejected uint64
+	md5Failures      uint64
+	aoFailures       uint64
+	pacingDelays     uint64
+	lossProbes       uint64
+	rackLosses       uint64
+	spuriousRTOs     uint64
+	cwndHistory      []CwndSample
+}
+
+// ConnStats is a snapshot of a connection's counters and state.
+type ConnStats struct {
+	LocalAddr  common.IPv4Address
+	LocalPort  uint16
+	RemoteAddr common.IPv4Address
+	RemotePort uint16
+	State      State
+	Created    time.Time
+
+	// Addresses of a connection over IPv6, which leaves LocalAddr and
+	// RemoteAddr zero
+	LocalAddr6  common.IPv6Address
+	RemoteAddr6 common.IPv6Address
+
+	// Traffic. Byte counts are payload bytes; sent counts include
+	// retransmissions.
+	BytesSent        uint64
+	BytesReceived    uint64
+	SegmentsSent     uint64
+	SegmentsReceived uint64
+	Retransmissions  uint64
+	DupAcks          uint64
+	ChecksumErrors   uint64
+	SegmentsRejected uint64 // Segments failing the sequence or acknowledgment checks
+	MD5Failures      uint64 // Segments dropped for a missing, unexpected or wrong MD5 signature
+	AOFailures       uint64 // Segments dropped for a missing, unexpected or wrong TCP-AO MAC
+	LossProbes       uint64 // Tail loss probes sent (RFC 8985)
+	RACKLosses       uint64 // Segments RACK found lost
+	SpuriousRTOs     uint64 // Retransmission timeouts F-RTO found spurious (RFC 5682)
+
+	// RTT estimates (RFC 6298); zero until the first sample
+	SRTT   time.Duration
+	RTTVar time.Duration
+	RTO    time.Duration
+
+	// Congestion and flow control
+	Cwnd          uint32
+	Ssthresh      uint32
+	CwndHistory   []CwndSample // Oldest first, up to the last 64 changes
+	MSS           uint16
+	SendWindow    uint16
+	RecvWindow    uint16
+	BytesInFlight uint32
+
+	// Pacing: the rate in bytes per second, zero if not paced, and the
+	// times it held back a segment the windows allowed
+	PacingRate   int
+	PacingDelays uint64
+}
+
+// StackStats holds TCP counters summed over all connections (the TCP group
+// of RFC 4022).
+type StackStats struct {
+	ActiveOpens      uint64 // Connections opened with ActiveOpen
+	PassiveOpens     uint64 // Connections opened by a SYN in LISTEN
+	Established      uint64 // Connections that reached ESTABLISHED
+	SegmentsSent     uint64
+	SegmentsReceived uint64 // Segments that passed checksum verification
+	Retransmissions  uint64
+	ResetsSent       uint64
+	ResetsReceived   uint64
+	ChecksumErrors   uint64
+	SegmentsRejected uint64 // Segments failing the sequence or acknowledgment checks
+	ChallengeACKs    uint64 // ACKs sent to challenge a suspicious RST, SYN or ACK
+	MD5Failures      uint64 // Segments dropped for a missing, unexpected or wrong MD5 signature
+	AOFailures       uint64 // Segments dropped for a missing, unexpected or wrong TCP-AO MAC
+	ListenOverflows  uint64 // Connection attempts a full accept queue turned away or held back
+}
+
+// stackCounters are the package-wide counters behind GetStackStats.
+var stackCounters struct {
+	activeOpens      atomic.Uint64
+	passiveOpens     atomic.Uint64
+	established      atomic.Uint64
+	segmentsSent     atomic.Uint64
+	segmentsReceived atomic.Uint64
+	retransmissions  atomic.Uint64
+	resetsSent       atomic.Uint64
+	resetsReceived   atomic.Uint64
+	checksumErrors   atomic.Uint64
+	segmentsRejected atomic.Uint64
+	challengeACKs    atomic.Uint64
+	md5Failures      atomic.Uint64
+	aoFailures       atomic.Uint64
+	listenOverflows  atomic.Uint64
+}
+
+// GetStackStats returns a snapshot of the TCP counters for all connections.
+func GetStackStats() StackStats {
+	return StackStats{
+		ActiveOpens:      stackCounters.activeOpens.Load(),
+		PassiveOpens:     stackCounters.passiveOpens.Load(),
+		Established:      stackCounters.established.Load(),
+		SegmentsSent:     stackCounters.segmentsSent.Load(),
+		SegmentsReceived: stackCounters.segmentsReceived.Load(),
+		Retransmissions:  stackCounters.retransmissions.Load(),
+		ResetsSent:       stackCounters.resetsSent.Load(),
+		ResetsReceived:   stackCounters.resetsReceived.Load(),
+		ChecksumErrors:   stackCounters.checksumErrors.Load(),
+		SegmentsRejected: stackCounters.segmentsRejected.Load(),
+		ChallengeACKs:    stackCounters.challengeACKs.Load(),
+		MD5Failures:      stackCounters.md5Failures.Load(),
+		AOFailures:       stackCounters.aoFailures.Load(),
+		ListenOverflows:  stackCounters.listenOverflows.Load(),
+	}
+}
+
+// Stats returns a snapshot of the connection's statistics.
+func (c *Connection) Stats() *ConnStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return &ConnStats{
+		LocalAddr:  c.LocalAddr,
+		LocalPort:  c.LocalPort,
+		RemoteAddr: c.RemoteAddr,
+		RemotePort: c.RemotePort,
+		State:      c.state.GetState(),
+		Created:    c.stats.created,
+
+		LocalAddr6:  c.LocalAddr6,
+		RemoteAddr6: c.RemoteAddr6,
+
+		BytesSent:        c.stats.bytesSent,
+		BytesReceived:    c.stats.bytesReceived,
+		SegmentsSent:     c.stats.segmentsSent,
+		SegmentsReceived: c.stats.segmentsReceived,
+		Retransmissions:  c.stats.retransmissions,
+		DupAcks:          c.stats.dupAcks,
+		ChecksumErrors:   c.stats.checksumErrors,
+		SegmentsRejected: c.stats.segmentsRejected,
+		MD5Failures:      c.stats.md5Failures,
+		AOFailures:       c.stats.aoFailures,
+		LossProbes:       c.stats.lossProbes,
+		RACKLosses:       c.stats.rackLosses,
+		SpuriousRTOs:     c.stats.spuriousRTOs,
+
+		SRTT:   c.srtt,
+		RTTVar: c.rttvar,
+		RTO:    c.rto,
+
+		Cwnd:          c.cwnd,
+		Ssthresh:      c.ssthresh,
+		CwndHistory:   append([]CwndSample(nil), c.stats.cwndHistory...),
+		MSS:           c.mss,
+		SendWindow:    c.sndWnd,
+		RecvWindow:    c.rcvWnd,
+		BytesInFlight: c.sndNxt - c.sndUna,
+
+		PacingRate:   c.pacingRate(),
+		PacingDelays: c.stats.pacingDelays,
+	}
+}
+
+// Stats returns the statistics of the socket's connection, or nil if it
+// has none.
+func (s *Socket) Stats() *ConnStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Stats()
+}
+
+// String returns a one-line summary of the statistics.
+func (cs *ConnStats) String() string {
+	local, remote := ipKey(cs.LocalAddr), ipKey(cs.RemoteAddr)
+	if !cs.RemoteAddr6.IsUnspecified() {
+		local, remote = cs.LocalAddr6, cs.RemoteAddr6
+	}
+	return fmt.Sprintf("%s -> %s %s: sent %d bytes/%d segs, received %d bytes/%d segs, retrans %d, dupacks %d, srtt %v, rto %v, cwnd %d, ssthresh %d",
+		hostPort(local, cs.LocalPort), hostPort(remote, cs.RemotePort), cs.State,
+		cs.BytesSent, cs.SegmentsSent, cs.BytesReceived, cs.SegmentsReceived,
+		cs.Retransmissions, cs.DupAcks, cs.SRTT, cs.RTO, cs.Cwnd, cs.Ssthresh)
+}
+
+// transmit sends a segment, counting it in the connection's statistics.
+func (c *Connection) transmit(seg *Segment) error {
+	c.stats.segmentsSent++
+	c.stats.bytesSent += uint64(len(seg.Data))
+	stackCounters.segmentsSent.Add(1)
+	seg.TTL, seg.TOS = c.opts.ttl, c.opts.tos
+	seg.Device = c.opts.device
+	if seg.HasFlag(FlagRST) {
+		stackCounters.resetsSent.Add(1)
+	}
+	if err := c.signMD5(seg); err != nil {
+		return err
+	}
+	if err := c.signAO(seg); err != nil {
+		return err
+	}
+	if logger.Enabled(logging.LevelTrace) {
+		logger.Packet("tx", seg, c.logID(), logging.F("state", c.state.GetState()))
+	}
+	return c.onSegmentReady(seg)
+}
+
+// sendLost reports whether an error from the layers below means only that
+// they dropped the segment, which is then lost as on the wire, rather
+// than that they cannot send it: there is no route to the peer, or the
+// link is down.
+func sendLost(err error) bool {
+	for _, hard := range []error{ip.ErrNoRoute, ipv6.ErrNoRoute, icmp.ErrNetUnreachable, icmp.ErrHostUnreachable, linkstate.ErrLinkDown} {
+		if errors.Is(err, hard) {
+			return false
+		}
+	}
+	return true
+}
+
+// sampleRTT updates the RTT estimate from the segments an ACK covers.
+func (c *Connection) sampleRTT(ack uint32) {
+	if rtt, ok := c.retransmitQueue.RTTSample(ack, time.Now()); ok {
+		c.updateRTT(rtt)
+	}
+}
+
+// updateRTT folds an RTT measurement into SRTT, RTTVAR and the RTO
+// (RFC 6298 Section 2).
+func (c *Connection) updateRTT(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = rtt
+		c.rttvar = rtt / 2
+	} else {
+		diff := c.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		c.rttvar = (3*c.rttvar + diff) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < minRTO {
+		c.rto = minRTO
+	}
+	if c.rto > maxRTO {
+		c.rto = maxRTO
+	}
+}
+
+// recordCwnd appends the congestion window to its history if it changed.
+func (c *Connection) recordCwnd() {
+	h := c.stats.cwndHistory
+	if n := len(h); n > 0 && h[n-1].Cwnd == c.cwnd && h[n-1].Ssthresh == c.ssthresh {
+		return
+	}
+	if len(h) == cwndHistoryLen {
+		h = append(h[:0], h[1:]...)
+	}
+	c.stats.cwndHistory = append(h, CwndSample{Time: time.Now(), Cwnd: c.cwnd, Ssthresh: c.ssthresh})
+}
@@ -0,0 +1,92 @@
+package tcp
+
+import (
+	"testing"
+)
+
+func TestConnectionStats(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	// The SYN-ACK gives the first RTT sample
+	stats := client.conn.Stats()
+	if stats.State != StateEstablished || stats.SRTT <= 0 || stats.RTO != minRTO {
+		t.Errorf("after handshake state = %v, SRTT = %v, RTO = %v", stats.State, stats.SRTT, stats.RTO)
+	}
+	srtt := stats.SRTT
+
+	// Lose a data segment, then three duplicate ACKs trigger fast
+	// retransmit
+	if err := client.conn.Send(make([]byte, 1000)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	client.out = nil
+	dupAck := NewSegment(server.conn.LocalPort, server.conn.RemotePort, server.conn.sndNxt, server.conn.rcvNxt, FlagACK, 65535, nil)
+	dupAck.Checksum, _ = dupAck.CalculateChecksum(testServerIP, testClientIP)
+	for i := 0; i < 3; i++ {
+		if err := client.conn.HandleSegment(dupAck); err != nil {
+			t.Fatalf("HandleSegment() error = %v", err)
+		}
+	}
+	exchange(t, client, server)
+
+	stats = client.conn.Stats()
+	tests := []struct {
+		name      string
+		got, want uint64
+	}{
+		{"SegmentsSent", stats.SegmentsSent, 4}, // SYN, ACK, data, retransmission
+		{"BytesSent", stats.BytesSent, 2000},
+		{"SegmentsReceived", stats.SegmentsReceived, 5}, // SYN-ACK, 3 duplicate ACKs, ACK
+		{"Retransmissions", stats.Retransmissions, 1},
+		{"DupAcks", stats.DupAcks, 3},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+	if got := server.conn.Stats().BytesReceived; got != 1000 {
+		t.Errorf("server BytesReceived = %d, want 1000", got)
+	}
+
+	// The retransmitted segment is ambiguous and gives no RTT sample
+	if stats.SRTT != srtt {
+		t.Errorf("SRTT = %v after an ACK of a retransmission, want %v", stats.SRTT, srtt)
+	}
+
+	// The history starts with the initial window and records fast
+	// recovery
+	h := stats.CwndHistory
+	if len(h) < 2 || h[0].Cwnd != DefaultMSS*2 || h[0].Ssthresh != 65535 {
+		t.Fatalf("cwnd history = %+v", h)
+	}
+	found := false
+	for _, s := range h {
+		found = found || s.Ssthresh == DefaultMSS*2
+	}
+	if !found {
+		t.Errorf("cwnd history %+v lacks the fast recovery ssthresh", h)
+	}
+}
+
+func TestCwndHistoryBounded(t *testing.T) {
+	c := NewConnection(testClientIP, 1, testServerIP, 2)
+	for i := 0; i < 2*cwndHistoryLen; i++ {
+		c.updateCongestionWindow(100)
+	}
+	h := c.Stats().CwndHistory
+	if len(h) != cwndHistoryLen || h[len(h)-1].Cwnd != c.cwnd {
+		t.Errorf("history has %d samples ending at %d, want %d ending at %d", len(h), h[len(h)-1].Cwnd, cwndHistoryLen, c.cwnd)
+	}
+}
+
+func TestSocketStatsUnconnected(t *testing.T) {
+	if s := NewSocket(testClientIP, 1).Stats(); s != nil {
+		t.Errorf("Stats() = %v for an unconnected socket, want nil", s)
+	}
+}
@@ -0,0 +1,180 @@
+// Package tcp implements the table of open connections and listeners.
+package tcp
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// ConnInfo describes an open connection or a listening socket.
+type ConnInfo struct {
+	LocalAddr  common.IPv4Address
+	LocalPort  uint16
+	RemoteAddr common.IPv4Address // Zero for a listener
+	RemotePort uint16
+	State      State
+
+	// Addresses of a connection over IPv6 or a listener bound to an IPv6
+	// address, which leaves LocalAddr and RemoteAddr zero
+	LocalAddr6  common.IPv6Address
+	RemoteAddr6 common.IPv6Address
+
+	// SendQueue is the number of bytes not yet acknowledged by the peer,
+	// including bytes not yet sent. For a listener it is the backlog.
+	SendQueue int
+
+	// RecvQueue is the number of bytes received but not yet read by the
+	// application. For a listener it is the number of connections waiting
+	// for Accept.
+	RecvQueue int
+}
+
+// connTable tracks the connections that have left CLOSED and LISTEN but
+// not yet returned to CLOSED, and the listening sockets.
+//
+// Lock order: a connection's or socket's mutex may be held while taking
+// connTable.mu, so Connections never holds connTable.mu while locking them.
+var connTable = struct {
+	mu        sync.Mutex
+	conns     map[*Connection]struct{}
+	listeners map[*Socket]struct{}
+}{
+	conns:     make(map[*Connection]struct{}),
+	listeners: make(map[*Socket]struct{}),
+}
+
+// Connections returns the open connections, including those in TIME_WAIT,
+// and listening sockets, sorted by local then remote endpoint.
+func Connections() []ConnInfo {
+	connTable.mu.Lock()
+	conns := make([]*Connection, 0, len(connTable.conns))
+	for c := range connTable.conns {
+		conns = append(conns, c)
+	}
+	listeners := make([]*Socket, 0, len(connTable.listeners))
+	for s := range connTable.listeners {
+		listeners = append(listeners, s)
+	}
+	connTable.mu.Unlock()
+
+	infos := make([]ConnInfo, 0, len(conns)+len(listeners))
+	for _, s := range listeners {
+		infos = append(infos, s.listenInfo())
+	}
+	for _, c := range conns {
+		infos = append(infos, c.info())
+	}
+	infos = append(infos, timeWaitTable.infos()...)
+
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := &infos[i], &infos[j]
+		aLocal, aRemote := a.keys()
+		bLocal, bRemote := b.keys()
+		if aLocal != bLocal {
+			return bytes.Compare(aLocal[:], bLocal[:]) < 0
+		}
+		if a.LocalPort != b.LocalPort {
+			return a.LocalPort < b.LocalPort
+		}
+		if aRemote != bRemote {
+			return bytes.Compare(aRemote[:], bRemote[:]) < 0
+		}
+		return a.RemotePort < b.RemotePort
+	})
+	return infos
+}
+
+// setAddrs sets the addresses, given in the form they are looked up by:
+// LocalAddr and RemoteAddr if both are IPv4, or LocalAddr6 and
+// RemoteAddr6.
+func (info *ConnInfo) setAddrs(local, remote common.IPv6Address) {
+	local4, localOK := ipv4Of(local)
+	remote4, remoteOK := ipv4Of(remote)
+	if localOK && remoteOK {
+		info.LocalAddr, info.RemoteAddr = local4, remote4
+		return
+	}
+	info.LocalAddr6, info.RemoteAddr6 = local, remote
+}
+
+// keys returns the addresses in the form they are looked up by.
+func (info *ConnInfo) keys() (local, remote common.IPv6Address) {
+	if !info.LocalAddr6.IsUnspecified() || !info.RemoteAddr6.IsUnspecified() {
+		return info.LocalAddr6, info.RemoteAddr6
+	}
+	return ipKey(info.LocalAddr), ipKey(info.RemoteAddr)
+}
+
+// info describes the connection.
+func (c *Connection) info() ConnInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return ConnInfo{
+		LocalAddr:   c.LocalAddr,
+		LocalPort:   c.LocalPort,
+		RemoteAddr:  c.RemoteAddr,
+		RemotePort:  c.RemotePort,
+		State:       c.state.GetState(),
+		LocalAddr6:  c.LocalAddr6,
+		RemoteAddr6: c.RemoteAddr6,
+		SendQueue:   c.sendQueued(),
+		RecvQueue:   len(c.earlyData) + int(c.recvQueued.Load()),
+	}
+}
+
+// listenInfo describes the listening socket.
+func (s *Socket) listenInfo() ConnInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	info := ConnInfo{
+		LocalPort: s.localPort,
+		State:     StateListen,
+		SendQueue: s.backlog,
+		RecvQueue: len(s.acceptQueue),
+	}
+	info.setAddrs(s.localAddr, common.IPv6Address{})
+	return info
+}
+
+// track updates the table after the connection moved from one state to
+// another, and wakes a Connect waiting for the connection to open. Called
+// with c.mu held.
+func (c *Connection) track(from, to State) {
+	select {
+	case c.stateChanged <- struct{}{}:
+	default:
+	}
+
+	wasOpen := from != StateClosed && from != StateListen
+	isOpen := to != StateClosed && to != StateListen
+	if wasOpen == isOpen {
+		return
+	}
+	if !isOpen && c.demux != nil {
+		c.demux.removeConn(c)
+	}
+
+	connTable.mu.Lock()
+	defer connTable.mu.Unlock()
+	if isOpen {
+		connTable.conns[c] = struct{}{}
+	} else {
+		delete(connTable.conns, c)
+	}
+}
+
+// trackListener adds or removes a listening socket. Called with s.mu held.
+func (s *Socket) trackListener(listening bool) {
+	connTable.mu.Lock()
+	defer connTable.mu.Unlock()
+	if listening {
+		connTable.listeners[s] = struct{}{}
+	} else {
+		delete(connTable.listeners, s)
+	}
+}
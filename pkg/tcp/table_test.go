@@ -0,0 +1,84 @@
+package tcp
+
+import "testing"
+
+// inTable reports whether the connection is in the connection table.
+func inTable(c *Connection) bool {
+	connTable.mu.Lock()
+	defer connTable.mu.Unlock()
+	_, ok := connTable.conns[c]
+	return ok
+}
+
+func TestConnectionTable(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	if inTable(server.conn) {
+		t.Error("listening connection is in the table")
+	}
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	if !inTable(client.conn) {
+		t.Error("connection in SYN_SENT is not in the table")
+	}
+	exchange(t, client, server)
+
+	// Unacknowledged data counts in the send queue, unread data in the
+	// receive queue
+	server.conn.onDataReady = nil
+	if err := client.conn.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := client.conn.info(); got.SendQueue != 5 || got.State != StateEstablished {
+		t.Errorf("client info = %+v, want 5 bytes queued in ESTABLISHED", got)
+	}
+	exchange(t, client, server)
+	if got := client.conn.info().SendQueue; got != 0 {
+		t.Errorf("client send queue = %d after ACK, want 0", got)
+	}
+	if got := server.conn.info(); got.RecvQueue != 5 || got.RemotePort != 50000 {
+		t.Errorf("server info = %+v, want 5 bytes unread from port 50000", got)
+	}
+
+	// Both leave the table once closed, the active closer's 4-tuple going
+	// to the TIME_WAIT table
+	client.conn.Close()
+	exchange(t, client, server)
+	server.conn.Close()
+	exchange(t, client, server)
+	if inTable(server.conn) {
+		t.Errorf("server in %v is still in the table", server.conn.GetState())
+	}
+	if inTable(client.conn) {
+		t.Errorf("client in %v is still in the table", client.conn.GetState())
+	}
+	infos := client.conn.timeWait.infos()
+	if len(infos) != 1 || infos[0].State != StateTimeWait || infos[0].LocalPort != 50000 {
+		t.Errorf("TIME_WAIT table lists %+v, want the client", infos)
+	}
+}
+
+func TestListenerTable(t *testing.T) {
+	s := NewSocket(testServerIP, 8081)
+	if err := s.Listen(16); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	find := func() (ConnInfo, bool) {
+		for _, info := range Connections() {
+			if info.LocalAddr == testServerIP && info.LocalPort == 8081 {
+				return info, true
+			}
+		}
+		return ConnInfo{}, false
+	}
+	if info, ok := find(); !ok || info.State != StateListen || info.SendQueue != 16 {
+		t.Errorf("listener info = %+v (found %v), want LISTEN with backlog 16", info, ok)
+	}
+
+	s.Close()
+	if _, ok := find(); ok {
+		t.Error("closed listener is still listed")
+	}
+}
@@ -0,0 +1,262 @@
+package tcp
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultTimerTick is the resolution of the connection timer wheel.
+const DefaultTimerTick = 10 * time.Millisecond
+
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits // Slots per level
+	wheelMask   = wheelSize - 1
+	wheelLevels = 4 // Covers wheelSize^4 ticks, 46 hours at DefaultTimerTick
+	wheelSpan   = 1 << (wheelBits * wheelLevels)
+)
+
+// defaultTimerWheel runs the timers of every connection.
+var defaultTimerWheel = NewTimerWheel(DefaultTimerTick)
+
+// TimerWheel is a hierarchical timing wheel (Varghese and Lauck) that runs
+// many timers on one goroutine. Starting, stopping and resetting a timer
+// take constant time and allocate nothing beyond the Timer itself, so tens
+// of thousands of connections can each keep several timers without a
+// runtime timer apiece.
+//
+// Level 0 has a slot per tick; each higher level has a slot per full turn
+// of the level below. A timer is filed at the lowest level that reaches
+// its expiry, and moves down a level each time the level below completes
+// a turn, until it fires from level 0. Timers fire up to one tick late,
+// never early.
+//
+// The wheel's goroutine runs only while timers are pending. Timer functions
+// run on it one at a time, so they must not block.
+type TimerWheel struct {
+	tick  time.Duration
+	start time.Time
+
+	mu      sync.Mutex
+	now     uint64 // Ticks processed since start
+	pending int
+	running bool
+	slots   [wheelLevels][wheelSize]timerList
+}
+
+// Timer is a timer on a TimerWheel.
+type Timer struct {
+	wheel   *TimerWheel
+	f       func()
+	expires uint64 // Tick at which the timer fires
+
+	// Position in the wheel, while pending
+	list       *timerList
+	prev, next *Timer
+}
+
+// timerList is a doubly linked list of timers in one slot.
+type timerList struct {
+	head *Timer
+}
+
+// NewTimerWheel creates a timer wheel with the given resolution.
+func NewTimerWheel(tick time.Duration) *TimerWheel {
+	if tick <= 0 {
+		tick = DefaultTimerTick
+	}
+	return &TimerWheel{tick: tick, start: time.Now()}
+}
+
+// AfterFunc starts a timer that calls f on the wheel's goroutine after d.
+func (w *TimerWheel) AfterFunc(d time.Duration, f func()) *Timer {
+	t := &Timer{wheel: w, f: f}
+	w.mu.Lock()
+	w.schedule(t, d)
+	w.mu.Unlock()
+	return t
+}
+
+// Pending returns the number of timers waiting to fire.
+func (w *TimerWheel) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pending
+}
+
+// Stop stops the timer. It returns false if the timer had already fired or
+// been stopped.
+func (t *Timer) Stop() bool {
+	w := t.wheel
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t.list == nil {
+		return false
+	}
+	t.list.remove(t)
+	w.pending--
+	return true
+}
+
+// Pending reports whether the timer is waiting to fire.
+func (t *Timer) Pending() bool {
+	t.wheel.mu.Lock()
+	defer t.wheel.mu.Unlock()
+	return t.list != nil
+}
+
+// Reset restarts the timer to fire after d, whether or not it was pending.
+// It returns whether the timer was pending.
+func (t *Timer) Reset(d time.Duration) bool {
+	w := t.wheel
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wasPending := t.list != nil
+	if wasPending {
+		t.list.remove(t)
+		w.pending--
+	}
+	w.schedule(t, d)
+	return wasPending
+}
+
+// schedule files a timer to fire after d. The caller holds w.mu.
+func (w *TimerWheel) schedule(t *Timer, d time.Duration) {
+	if w.pending == 0 {
+		// Nothing is filed, so the wheel can jump to the current time
+		// instead of catching up tick by tick
+		if now := w.elapsed(); now > w.now {
+			w.now = now
+		}
+	}
+
+	ticks := uint64((d + w.tick - 1) / w.tick)
+	if d <= 0 {
+		ticks = 0
+	}
+	t.expires = w.now + max(ticks, 1)
+	w.add(t)
+	w.pending++
+
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+}
+
+// add files a timer at the level that reaches its expiry. The caller holds
+// w.mu.
+func (w *TimerWheel) add(t *Timer) {
+	delta := t.expires - w.now
+	expires := t.expires
+	if delta >= wheelSpan {
+		// Beyond the wheel: file at the far end, to be refiled from there
+		expires = w.now + wheelSpan - 1
+		delta = wheelSpan - 1
+	}
+
+	level := 0
+	for delta >= 1<<(wheelBits*(level+1)) {
+		level++
+	}
+	slot := (expires >> (wheelBits * level)) & wheelMask
+	w.slots[level][slot].push(t)
+}
+
+// run advances the wheel in real time until no timers are pending.
+func (w *TimerWheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	var due []*Timer
+	for range ticker.C {
+		w.mu.Lock()
+		target := w.elapsed()
+		for w.now < target {
+			due = w.advance(due)
+		}
+		if w.pending == 0 {
+			w.running = false
+		}
+		running := w.running
+		w.mu.Unlock()
+
+		// Fire outside the lock, so timer functions can use the wheel
+		for i, t := range due {
+			t.f()
+			due[i] = nil
+		}
+		due = due[:0]
+
+		if !running {
+			return
+		}
+	}
+}
+
+// advance processes one tick, moving timers down from the higher levels
+// whose turn has come and appending the timers that expire to due. The
+// caller holds w.mu.
+func (w *TimerWheel) advance(due []*Timer) []*Timer {
+	w.now++
+
+	// Refile the next slot of each level whose lower level completed a turn
+	for level := 1; level < wheelLevels; level++ {
+		if w.now&(1<<(wheelBits*level)-1) != 0 {
+			break
+		}
+		list := &w.slots[level][(w.now>>(wheelBits*level))&wheelMask]
+		for t := list.head; t != nil; {
+			next := t.next
+			list.remove(t)
+			w.add(t)
+			t = next
+		}
+	}
+
+	list := &w.slots[0][w.now&wheelMask]
+	for t := list.head; t != nil; {
+		next := t.next
+		list.remove(t)
+		if t.expires > w.now {
+			// Filed at the far end of the wheel; not due yet
+			w.add(t)
+		} else {
+			w.pending--
+			due = append(due, t)
+		}
+		t = next
+	}
+	return due
+}
+
+// elapsed returns the number of ticks since the wheel was created.
+func (w *TimerWheel) elapsed() uint64 {
+	return uint64(time.Since(w.start) / w.tick)
+}
+
+// push adds a timer to the list.
+func (l *timerList) push(t *Timer) {
+	t.list = l
+	t.prev = nil
+	t.next = l.head
+	if l.head != nil {
+		l.head.prev = t
+	}
+	l.head = t
+}
+
+// remove removes a timer from the list.
+func (l *timerList) remove(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		l.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.list, t.prev, t.next = nil, nil, nil
+}
@@ -0,0 +1,234 @@
+package tcp
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// manualWheel returns a wheel whose goroutine is never started, so the test
+// advances it by hand.
+func manualWheel() *TimerWheel {
+	w := NewTimerWheel(time.Millisecond)
+	w.running = true
+	return w
+}
+
+func TestTimerWheelLevels(t *testing.T) {
+	w := manualWheel()
+	ticks := []uint64{1, 2, 63, 64, 65, 4095, 4096, 4097, 300000, wheelSpan - 1, wheelSpan + 5}
+
+	fired := make(map[uint64]uint64) // Delay -> tick fired at
+	for _, n := range ticks {
+		n := n
+		w.AfterFunc(time.Duration(n)*w.tick, func() { fired[n] = w.now })
+	}
+	if got := w.Pending(); got != len(ticks) {
+		t.Fatalf("Pending() = %d, want %d", got, len(ticks))
+	}
+
+	var due []*Timer
+	base := w.now
+	last := base + ticks[len(ticks)-1]
+	for w.now < last {
+		due = w.advance(due)
+		for _, timer := range due {
+			timer.f()
+		}
+		due = due[:0]
+	}
+
+	for _, n := range ticks {
+		if got, ok := fired[n]; !ok || got-base != n {
+			t.Errorf("timer for %d ticks fired at %d (fired %v), want %d", n, got-base, ok, n)
+		}
+	}
+	if got := w.Pending(); got != 0 {
+		t.Errorf("Pending() = %d, want 0", got)
+	}
+}
+
+func TestTimerWheelStopReset(t *testing.T) {
+	w := manualWheel()
+	fired := make(map[string]uint64) // Timer -> tick fired at
+	a := w.AfterFunc(5*w.tick, func() { fired["a"] = w.now })
+	b := w.AfterFunc(5*w.tick, func() { fired["b"] = w.now })
+	c := w.AfterFunc(5*w.tick, func() { fired["c"] = w.now })
+
+	if !b.Stop() {
+		t.Error("Stop() = false for a pending timer")
+	}
+	if b.Stop() {
+		t.Error("second Stop() = true")
+	}
+	if !c.Reset(100 * w.tick) {
+		t.Error("Reset() = false for a pending timer")
+	}
+
+	var due []*Timer
+	base := w.now
+	for w.now < base+200 {
+		due = w.advance(due)
+		for _, timer := range due {
+			timer.f()
+		}
+		due = due[:0]
+	}
+
+	want := map[string]uint64{"a": base + 5, "c": base + 100}
+	if len(fired) != len(want) || fired["a"] != want["a"] || fired["c"] != want["c"] {
+		t.Errorf("fired %v, want %v", fired, want)
+	}
+	if a.Pending() || a.Stop() {
+		t.Error("fired timer is still pending")
+	}
+}
+
+func TestTimerWheelRealTime(t *testing.T) {
+	w := NewTimerWheel(time.Millisecond)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var late []time.Duration
+	start := time.Now()
+	for _, d := range []time.Duration{5 * time.Millisecond, 20 * time.Millisecond, 10 * time.Millisecond} {
+		d := d
+		wg.Add(1)
+		w.AfterFunc(d, func() {
+			defer wg.Done()
+			mu.Lock()
+			late = append(late, time.Since(start)-d)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	for _, l := range late {
+		if l < 0 {
+			t.Errorf("timer fired %v early", -l)
+		}
+	}
+
+	// The goroutine stops once idle, and a new timer starts it again
+	deadline := time.Now().Add(time.Second)
+	for {
+		w.mu.Lock()
+		running := w.running
+		w.mu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("wheel goroutine still running with no timers")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan struct{})
+	w.AfterFunc(time.Millisecond, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire after the wheel went idle")
+	}
+}
+
+func TestRetransmitTimeout(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	client.conn.timers = manualWheel()
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	if err := client.conn.Send([]byte("lost")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	client.out = nil // The segment is lost
+
+	// Expire the retransmission timer, with no tail loss probe ahead of
+	// it as the peer does not SACK
+	client.conn.sackOK = false
+	client.conn.stopLossProbe()
+	w := client.conn.timers
+	rto := client.conn.rto
+	var due []*Timer
+	for len(due) == 0 {
+		due = w.advance(due)
+	}
+	due[0].f()
+
+	if len(client.out) != 1 || string(client.out[0].Data) != "lost" {
+		t.Fatalf("retransmitted %v, want the lost segment", client.out)
+	}
+	if client.conn.cwnd != DefaultMSS {
+		t.Errorf("cwnd = %d, want %d", client.conn.cwnd, DefaultMSS)
+	}
+	if client.conn.rto != 2*rto {
+		t.Errorf("rto = %v, want %v", client.conn.rto, 2*rto)
+	}
+	if !client.conn.retransmitTimer.Pending() {
+		t.Error("retransmission timer not restarted")
+	}
+
+	// Once the data is acknowledged the timer stops
+	exchange(t, client, server)
+	if string(server.data) != "lost" {
+		t.Errorf("server received %q, want %q", server.data, "lost")
+	}
+	if client.conn.retransmitTimer.Pending() {
+		t.Error("retransmission timer still running with nothing outstanding")
+	}
+}
+
+func BenchmarkTimerWheel(b *testing.B) {
+	w := NewTimerWheel(DefaultTimerTick)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.AfterFunc(time.Minute, func() {}).Stop()
+	}
+}
+
+func BenchmarkTimeAfterFunc(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		time.AfterFunc(time.Minute, func() {}).Stop()
+	}
+}
+
+// The reset benchmarks restart one of many pending timers per iteration, as
+// connections do on every ACK.
+const benchConnections = 10000
+
+func BenchmarkTimerWheelReset(b *testing.B) {
+	w := NewTimerWheel(DefaultTimerTick)
+	timers := make([]*Timer, benchConnections)
+	for i := range timers {
+		timers[i] = w.AfterFunc(time.Minute, func() {})
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		timers[i%len(timers)].Reset(time.Minute)
+	}
+	b.StopTimer()
+	for _, t := range timers {
+		t.Stop()
+	}
+}
+
+func BenchmarkTimeTimerReset(b *testing.B) {
+	timers := make([]*time.Timer, benchConnections)
+	for i := range timers {
+		timers[i] = time.AfterFunc(time.Minute, func() {})
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		timers[i%len(timers)].Reset(time.Minute)
+	}
+	b.StopTimer()
+	for _, t := range timers {
+		t.Stop()
+	}
+}
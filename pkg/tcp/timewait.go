@@ -0,0 +1,365 @@
+package tcp
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+const (
+	// DefaultMSL is the default maximum segment lifetime. Connections stay
+	// in TIME_WAIT for twice this.
+	DefaultMSL = time.Minute
+
+	// DefaultTimeWaitEntries is the default number of connections kept in
+	// TIME_WAIT before the least recently used is evicted.
+	DefaultTimeWaitEntries = 16384
+)
+
+// ErrTimeWait is returned by Connect for a 4-tuple still in TIME_WAIT when
+// the table does not allow reusing it.
+var ErrTimeWait = errors.New("4-tuple in TIME_WAIT")
+
+// TimeWaitConfig configures a TimeWaitTable.
+type TimeWaitConfig struct {
+	MSL          time.Duration // Maximum segment lifetime (default DefaultMSL)
+	MaxEntries   int           // Entries kept before LRU eviction (default DefaultTimeWaitEntries)
+	ReuseConnect bool          // Let Connect take over a 4-tuple in TIME_WAIT
+}
+
+// TimeWaitStats holds the counters of a TimeWaitTable.
+type TimeWaitStats struct {
+	Entries int    // Connections in TIME_WAIT
+	Added   uint64 // Connections that entered TIME_WAIT
+	Expired uint64 // Entries removed after 2*MSL
+	Evicted uint64 // Entries removed to make room
+	Reused  uint64 // Entries taken over by a new connection
+}
+
+// TimeWaitTable holds the connections in TIME_WAIT, keyed by 4-tuple.
+//
+// A connection that enters TIME_WAIT hands the little state TIME_WAIT needs
+// to the table and closes at once, releasing its buffers; the table then
+// answers for the 4-tuple until 2*MSL have passed. It re-acknowledges a
+// retransmitted FIN, ignores RSTs (RFC 1337), and lets a new SYN reopen the
+// 4-tuple early when it cannot be confused with the old connection: its
+// timestamp is newer, or without timestamps, its sequence number is beyond
+// the old connection's (RFC 6191, RFC 1122 Section 4.2.2.13). The table is
+// bounded, evicting the least recently used entry when full.
+type TimeWaitTable struct {
+	timers *TimerWheel
+
+	mu      sync.Mutex
+	config  TimeWaitConfig
+	entries map[fourTuple]*timeWaitEntry
+	lru     list.List // Of *timeWaitEntry, most recently used first
+	stats   TimeWaitStats
+}
+
+// fourTuple identifies a connection from the local side, with the
+// addresses in the form they are looked up by (see ipKey).
+type fourTuple struct {
+	localAddr  common.IPv6Address
+	localPort  uint16
+	remoteAddr common.IPv6Address
+	remotePort uint16
+}
+
+// timeWaitEntry is the state kept for a connection in TIME_WAIT.
+type timeWaitEntry struct {
+	key      fourTuple
+	sndNxt   uint32
+	rcvNxt   uint32
+	rcvWnd   uint16
+	tsRecent uint32 // Last timestamp received, if hasTS
+	hasTS    bool
+	md5Key   []byte  // MD5 signature key, if the connection had one
+	ao       *aoConn // TCP-AO state, if the connection used it
+	iss, irs uint32  // Initial sequence numbers, for TCP-AO
+
+	send  func(*Segment) error
+	timer *Timer
+	elem  *list.Element
+}
+
+// timeWaitTable is the table connections use.
+var timeWaitTable, _ = NewTimeWaitTable(TimeWaitConfig{})
+
+// DefaultTimeWaitTable returns the TIME_WAIT table used by connections.
+func DefaultTimeWaitTable() *TimeWaitTable {
+	return timeWaitTable
+}
+
+// NewTimeWaitTable creates a TIME_WAIT table.
+func NewTimeWaitTable(config TimeWaitConfig) (*TimeWaitTable, error) {
+	t := &TimeWaitTable{
+		timers:  defaultTimerWheel,
+		entries: make(map[fourTuple]*timeWaitEntry),
+	}
+	if err := t.SetConfig(config); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetConfig changes the table's configuration. A lower MaxEntries evicts
+// entries at once; a new MSL applies to connections entering TIME_WAIT
+// afterwards.
+func (t *TimeWaitTable) SetConfig(config TimeWaitConfig) error {
+	// Apply defaults
+	if config.MSL == 0 {
+		config.MSL = DefaultMSL
+	}
+	if config.MSL < 0 {
+		return fmt.Errorf("invalid MSL: %v", config.MSL)
+	}
+	if config.MaxEntries == 0 {
+		config.MaxEntries = DefaultTimeWaitEntries
+	}
+	if config.MaxEntries < 0 {
+		return fmt.Errorf("invalid maximum entries: %d", config.MaxEntries)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.config = config
+	for len(t.entries) > config.MaxEntries {
+		t.evictOldest()
+	}
+	return nil
+}
+
+// Stats returns the table's counters.
+func (t *TimeWaitTable) Stats() TimeWaitStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := t.stats
+	stats.Entries = len(t.entries)
+	return stats
+}
+
+// Contains reports whether a 4-tuple is in TIME_WAIT.
+func (t *TimeWaitTable) Contains(localAddr common.IPv4Address, localPort uint16, remoteAddr common.IPv4Address, remotePort uint16) bool {
+	return t.contains(fourTuple{ipKey(localAddr), localPort, ipKey(remoteAddr), remotePort})
+}
+
+// ContainsIPv6 reports whether the 4-tuple of a connection over IPv6 is in
+// TIME_WAIT.
+func (t *TimeWaitTable) ContainsIPv6(localAddr common.IPv6Address, localPort uint16, remoteAddr common.IPv6Address, remotePort uint16) bool {
+	return t.contains(fourTuple{localAddr, localPort, remoteAddr, remotePort})
+}
+
+func (t *TimeWaitTable) contains(key fourTuple) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.entries[key]
+	return ok
+}
+
+// usesPort reports whether a connection in TIME_WAIT uses a local port on
+// addr, or on any address if addr is unspecified.
+func (t *TimeWaitTable) usesPort(addr common.IPv6Address, port uint16) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.entries {
+		if key.localPort == port && (key.localAddr == addr || addr.IsUnspecified()) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleSegment processes a segment received from srcIP for dstIP if its
+// 4-tuple is in TIME_WAIT, and reports whether it did. A SYN that may
+// reopen the 4-tuple removes the entry and is not handled, so that it can
+// be passed to a listener.
+func (t *TimeWaitTable) HandleSegment(seg *Segment, srcIP, dstIP common.IPv4Address) (bool, error) {
+	return t.handleSegment(seg, ipKey(srcIP), ipKey(dstIP))
+}
+
+// HandleSegmentIPv6 is HandleSegment for a segment received over IPv6.
+func (t *TimeWaitTable) HandleSegmentIPv6(seg *Segment, srcIP, dstIP common.IPv6Address) (bool, error) {
+	return t.handleSegment(seg, srcIP, dstIP)
+}
+
+// handleSegment is HandleSegment with the addresses in the form they are
+// looked up by.
+func (t *TimeWaitTable) handleSegment(seg *Segment, srcIP, dstIP common.IPv6Address) (bool, error) {
+	key := fourTuple{dstIP, seg.DestinationPort, srcIP, seg.SourcePort}
+
+	t.mu.Lock()
+	e, ok := t.entries[key]
+	if !ok {
+		t.mu.Unlock()
+		return false, nil
+	}
+	if !verifyMD5(seg, srcIP, dstIP, e.md5Key) || !verifyAO(e.ao, seg, srcIP, dstIP, e.iss, e.irs) {
+		// Dropped, as the connection would have dropped it
+		t.mu.Unlock()
+		return true, nil
+	}
+
+	switch {
+	case seg.HasFlag(FlagRST):
+		// Ignore, so a stray RST cannot end TIME_WAIT early (RFC 1337)
+		t.mu.Unlock()
+		return true, nil
+
+	case seg.HasFlag(FlagSYN) && !seg.HasFlag(FlagACK):
+		if e.acceptsSYN(seg) {
+			t.remove(e)
+			t.stats.Reused++
+			t.mu.Unlock()
+			logger.Debug("TIME_WAIT reused", logging.F("local", hostPort(key.localAddr, key.localPort)),
+				logging.F("remote", hostPort(key.remoteAddr, key.remotePort)))
+			return false, nil
+		}
+
+	case seg.HasFlag(FlagFIN):
+		// The peer did not get our ACK of its FIN; restart the wait
+		e.timer.Reset(2 * t.config.MSL)
+	}
+	t.lru.MoveToFront(e.elem)
+
+	// Acknowledge anything else but bare ACKs
+	if len(seg.Data) == 0 && seg.Flags&(FlagSYN|FlagFIN) == 0 {
+		t.mu.Unlock()
+		return true, nil
+	}
+	ack := NewSegment(key.localPort, key.remotePort, e.sndNxt, e.rcvNxt, FlagACK, e.rcvWnd, nil)
+	if e.ao != nil {
+		if err := e.ao.sign(ack, key.localAddr, key.remoteAddr, e.iss, e.irs); err != nil {
+			t.mu.Unlock()
+			return true, err
+		}
+	}
+	send, md5Key := e.send, e.md5Key
+	t.mu.Unlock()
+
+	if md5Key != nil {
+		if err := signMD5(ack, key.localAddr, key.remoteAddr, md5Key); err != nil {
+			return true, err
+		}
+	}
+	ack.Checksum, _ = checksumFor(ack, key.localAddr, key.remoteAddr)
+	if send == nil {
+		return true, nil
+	}
+	return true, send(ack)
+}
+
+// reuse removes a 4-tuple's entry for Connect, if the configuration
+// allows, and returns ErrTimeWait otherwise.
+func (t *TimeWaitTable) reuse(key fourTuple) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	if !t.config.ReuseConnect {
+		return ErrTimeWait
+	}
+	t.remove(e)
+	t.stats.Reused++
+	return nil
+}
+
+// add puts a connection entering TIME_WAIT in the table. Called with c.mu
+// held.
+func (t *TimeWaitTable) add(c *Connection) {
+	key := c.tuple()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if old, ok := t.entries[key]; ok {
+		t.remove(old)
+	}
+	for len(t.entries) >= t.config.MaxEntries {
+		t.evictOldest()
+	}
+
+	e := &timeWaitEntry{
+		key:      key,
+		sndNxt:   c.sndNxt,
+		rcvNxt:   c.rcvNxt,
+		rcvWnd:   c.rcvWnd,
+		tsRecent: c.tsRecent,
+		hasTS:    c.hasTSRecent,
+		md5Key:   c.md5Key,
+		ao:       c.ao,
+		iss:      c.iss,
+		irs:      c.irs,
+		send:     c.onSegmentReady,
+	}
+	e.elem = t.lru.PushFront(e)
+	e.timer = t.timers.AfterFunc(2*t.config.MSL, func() { t.expire(e) })
+	t.entries[key] = e
+	t.stats.Added++
+}
+
+// expire removes an entry whose 2*MSL have passed.
+func (t *TimeWaitTable) expire(e *timeWaitEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// The entry may have been removed and its 4-tuple reused meanwhile
+	if t.entries[e.key] != e {
+		return
+	}
+	t.remove(e)
+	t.stats.Expired++
+}
+
+// evictOldest removes the least recently used entry. Called with t.mu held.
+func (t *TimeWaitTable) evictOldest() {
+	e := t.lru.Back().Value.(*timeWaitEntry)
+	t.remove(e)
+	t.stats.Evicted++
+}
+
+// remove removes an entry. Called with t.mu held.
+func (t *TimeWaitTable) remove(e *timeWaitEntry) {
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	t.lru.Remove(e.elem)
+	delete(t.entries, e.key)
+}
+
+// infos describes the entries for Connections.
+func (t *TimeWaitTable) infos() []ConnInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	infos := make([]ConnInfo, 0, len(t.entries))
+	for key := range t.entries {
+		info := ConnInfo{
+			LocalPort:  key.localPort,
+			RemotePort: key.remotePort,
+			State:      StateTimeWait,
+		}
+		info.setAddrs(key.localAddr, key.remoteAddr)
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// acceptsSYN reports whether a SYN may reopen the entry's 4-tuple: its
+// timestamp is newer than the last one received, or, if either side has
+// no timestamp, its sequence number is beyond the old connection's.
+func (e *timeWaitEntry) acceptsSYN(syn *Segment) bool {
+	if e.hasTS && len(syn.Options) > 0 {
+		if tsVal, _, err := syn.GetTimestamp(); err == nil {
+			return seqAfter(tsVal, e.tsRecent)
+		}
+	}
+	return seqAfter(syn.SequenceNumber, e.rcvNxt)
+}
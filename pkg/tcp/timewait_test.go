@@ -0,0 +1,224 @@
+package tcp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestTimeWait returns a table on a manual wheel with a 10 tick MSL.
+func newTestTimeWait(t *testing.T, config TimeWaitConfig) *TimeWaitTable {
+	t.Helper()
+	config.MSL = 10 * time.Millisecond
+	table, err := NewTimeWaitTable(config)
+	if err != nil {
+		t.Fatalf("NewTimeWaitTable() error = %v", err)
+	}
+	table.timers = manualWheel()
+	return table
+}
+
+// advanceWheel advances a manual wheel by n ticks, firing due timers.
+func advanceWheel(w *TimerWheel, n int) {
+	var due []*Timer
+	for i := 0; i < n; i++ {
+		due = w.advance(due)
+		for _, timer := range due {
+			timer.f()
+		}
+		due = due[:0]
+	}
+}
+
+// timeWaitConn returns a connection to hand to a table, with its sent
+// segments appended to out.
+func timeWaitConn(remotePort uint16, rcvNxt uint32, out *[]*Segment) *Connection {
+	conn := NewConnection(testServerIP, 80, testClientIP, remotePort)
+	conn.sndNxt = 5000
+	conn.rcvNxt = rcvNxt
+	conn.onSegmentReady = func(seg *Segment) error {
+		*out = append(*out, seg)
+		return nil
+	}
+	return conn
+}
+
+func TestTimeWaitClose(t *testing.T) {
+	table := newTestTimeWait(t, TimeWaitConfig{})
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	client.conn.timeWait = table
+	closed := false
+	client.conn.onClose = func() { closed = true }
+
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+	if err := client.conn.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	exchange(t, client, server)
+	if err := server.conn.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	fin := server.out[0]
+	exchange(t, client, server)
+
+	// The connection closed at once and left its 4-tuple to the table
+	if got := client.conn.GetState(); got != StateClosed {
+		t.Errorf("client state = %s, want %s", got, StateClosed)
+	}
+	if !closed {
+		t.Error("onClose not called")
+	}
+	if !table.Contains(testClientIP, 50000, testServerIP, 80) {
+		t.Fatal("4-tuple not in TIME_WAIT")
+	}
+
+	// A retransmitted FIN is acknowledged again and restarts the wait
+	advanceWheel(table.timers, 15)
+	handled, err := table.HandleSegment(fin, testServerIP, testClientIP)
+	if !handled || err != nil {
+		t.Fatalf("HandleSegment(FIN) = %v, %v; want true, nil", handled, err)
+	}
+	if len(client.out) != 1 || client.out[0].Flags != FlagACK || client.out[0].AckNumber != fin.SequenceNumber+1 {
+		t.Fatalf("sent %v, want an ACK of the FIN", client.out)
+	}
+	if !client.out[0].VerifyChecksum(testClientIP, testServerIP) {
+		t.Error("ACK has a bad checksum")
+	}
+	client.out = nil
+
+	// An RST is ignored
+	rst := NewSegment(80, 50000, fin.SequenceNumber+1, 0, FlagRST, 0, nil)
+	if handled, _ := table.HandleSegment(rst, testServerIP, testClientIP); !handled {
+		t.Error("HandleSegment(RST) = false, want true")
+	}
+	if len(client.out) != 0 {
+		t.Errorf("sent %v in reply to an RST", client.out)
+	}
+
+	advanceWheel(table.timers, 15)
+	if !table.Contains(testClientIP, 50000, testServerIP, 80) {
+		t.Fatal("FIN did not restart the TIME_WAIT timer")
+	}
+	advanceWheel(table.timers, 10)
+	if table.Contains(testClientIP, 50000, testServerIP, 80) {
+		t.Error("4-tuple still in TIME_WAIT after 2*MSL")
+	}
+	if stats := table.Stats(); stats.Added != 1 || stats.Expired != 1 || stats.Entries != 0 {
+		t.Errorf("Stats() = %+v, want 1 added and 1 expired", stats)
+	}
+}
+
+func TestTimeWaitSYN(t *testing.T) {
+	tests := []struct {
+		name       string
+		tsRecent   uint32 // Zero for no timestamps
+		seq        uint32
+		tsVal      uint32 // Zero for no timestamp option
+		wantReused bool
+	}{
+		{"higher sequence", 0, 2000, 0, true},
+		{"old sequence", 0, 500, 0, false},
+		{"newer timestamp", 100, 500, 101, true},
+		{"old timestamp", 100, 2000, 99, false},
+		{"no timestamp in SYN", 100, 2000, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTestTimeWait(t, TimeWaitConfig{})
+			var out []*Segment
+			conn := timeWaitConn(50000, 1000, &out)
+			conn.tsRecent, conn.hasTSRecent = tt.tsRecent, tt.tsRecent != 0
+			table.add(conn)
+
+			syn := NewSegment(50000, 80, tt.seq, 0, FlagSYN, 65535, nil)
+			if tt.tsVal != 0 {
+				syn.Options = BuildTimestampOption(tt.tsVal, 0)
+			}
+			handled, err := table.HandleSegment(syn, testClientIP, testServerIP)
+			if err != nil {
+				t.Fatalf("HandleSegment() error = %v", err)
+			}
+
+			if handled == tt.wantReused {
+				t.Errorf("HandleSegment() = %v, want %v", handled, !tt.wantReused)
+			}
+			if got := table.Contains(testServerIP, 80, testClientIP, 50000); got == tt.wantReused {
+				t.Errorf("Contains() = %v, want %v", got, !tt.wantReused)
+			}
+			// A SYN that cannot reopen the 4-tuple gets the old connection's ACK
+			if !tt.wantReused && (len(out) != 1 || out[0].AckNumber != 1000) {
+				t.Errorf("sent %v, want an ACK for 1000", out)
+			}
+			if stats := table.Stats(); (stats.Reused == 1) != tt.wantReused {
+				t.Errorf("Stats().Reused = %d", stats.Reused)
+			}
+		})
+	}
+}
+
+func TestTimeWaitEviction(t *testing.T) {
+	table := newTestTimeWait(t, TimeWaitConfig{MaxEntries: 2})
+	var out []*Segment
+	table.add(timeWaitConn(1, 1000, &out))
+	table.add(timeWaitConn(2, 1000, &out))
+
+	// A retransmitted FIN makes port 1 the most recently used
+	fin := NewSegment(1, 80, 999, 5000, FlagFIN|FlagACK, 65535, nil)
+	if handled, _ := table.HandleSegment(fin, testClientIP, testServerIP); !handled {
+		t.Fatal("HandleSegment(FIN) = false, want true")
+	}
+	table.add(timeWaitConn(3, 1000, &out))
+
+	for port, want := range map[uint16]bool{1: true, 2: false, 3: true} {
+		if got := table.Contains(testServerIP, 80, testClientIP, port); got != want {
+			t.Errorf("Contains(port %d) = %v, want %v", port, got, want)
+		}
+	}
+
+	if err := table.SetConfig(TimeWaitConfig{MSL: table.config.MSL, MaxEntries: 1}); err != nil {
+		t.Fatalf("SetConfig() error = %v", err)
+	}
+	if stats := table.Stats(); stats.Entries != 1 || stats.Evicted != 2 {
+		t.Errorf("Stats() = %+v, want 1 entry and 2 evicted", stats)
+	}
+	if table.timers.Pending() != 1 {
+		t.Errorf("wheel has %d timers pending, want 1", table.timers.Pending())
+	}
+}
+
+func TestTimeWaitReuseConnect(t *testing.T) {
+	for _, reuse := range []bool{false, true} {
+		table := newTestTimeWait(t, TimeWaitConfig{ReuseConnect: reuse})
+		var out []*Segment
+		table.add(timeWaitConn(50000, 1000, &out))
+
+		err := table.reuse(fourTuple{ipKey(testServerIP), 80, ipKey(testClientIP), 50000})
+		if reuse && err != nil {
+			t.Errorf("reuse() error = %v, want nil", err)
+		}
+		if !reuse && !errors.Is(err, ErrTimeWait) {
+			t.Errorf("reuse() error = %v, want %v", err, ErrTimeWait)
+		}
+		if got := table.Contains(testServerIP, 80, testClientIP, 50000); got == reuse {
+			t.Errorf("ReuseConnect %v: Contains() = %v", reuse, got)
+		}
+
+		// Other 4-tuples are free either way
+		if err := table.reuse(fourTuple{ipKey(testServerIP), 80, ipKey(testClientIP), 50001}); err != nil {
+			t.Errorf("reuse() of a free 4-tuple error = %v", err)
+		}
+	}
+}
+
+func TestNewTimeWaitTableErrors(t *testing.T) {
+	for _, config := range []TimeWaitConfig{{MSL: -time.Second}, {MaxEntries: -1}} {
+		if _, err := NewTimeWaitTable(config); err == nil {
+			t.Errorf("NewTimeWaitTable(%+v) error = nil, want an error", config)
+		}
+	}
+}
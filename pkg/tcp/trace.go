@@ -0,0 +1,39 @@
+// Package tcp implements connection tracing through pkg/logging.
+package tcp
+
+import (
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+// logger is the "tcp" logging subsystem.
+var logger = logging.New("tcp")
+
+// connID identifies a connection in log records.
+type connID fourTuple
+
+// String formats the connection as "local:port-remote:port".
+func (id connID) String() string {
+	return hostPort(id.localAddr, id.localPort) + "-" + hostPort(id.remoteAddr, id.remotePort)
+}
+
+// logID returns the field identifying the connection in log records.
+// It reads no locked state, so it is safe to use while c.mu is held.
+func (c *Connection) logID() logging.Field {
+	return logging.F("conn", connID(c.tuple()))
+}
+
+// transition applies an event to the state machine, logging the change and
+// updating the connection table.
+func (c *Connection) transition(event Event) error {
+	from := c.state.GetState()
+	if err := c.state.Transition(event); err != nil {
+		logger.Debug("invalid transition", c.logID(), logging.F("state", from), logging.F("event", event))
+		return err
+	}
+	to := c.state.GetState()
+	c.track(from, to)
+	if logger.Enabled(logging.LevelDebug) {
+		logger.Debug("state change", c.logID(), logging.F("from", from), logging.F("to", to), logging.F("event", event))
+	}
+	return nil
+}
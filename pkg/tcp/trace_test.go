@@ -0,0 +1,55 @@
+package tcp
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+func TestConnectionTrace(t *testing.T) {
+	var mu sync.Mutex
+	var records []logging.Record
+	logging.SetLogger(logging.LoggerFunc(func(r logging.Record) {
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, r)
+	}))
+	logging.SetLevel("tcp", logging.LevelTrace)
+	t.Cleanup(func() {
+		logging.SetLogger(nil)
+		logging.SetLevel("tcp", logging.DefaultLevel)
+	})
+
+	client := newTestPeer(true, nil)
+	server := newTestPeer(false, nil)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	// Every segment is traced in each direction, and both ends log
+	// reaching ESTABLISHED
+	packets := make(map[string]int)
+	var established int
+	for _, r := range records {
+		fields := make(map[string]any)
+		for _, f := range r.Fields {
+			fields[f.Key] = f.Value
+		}
+		switch r.Message {
+		case "packet":
+			packets[fields["dir"].(string)]++
+		case "state change":
+			if fields["to"] == StateEstablished {
+				established++
+			}
+		}
+	}
+	if packets["tx"] != 3 || packets["rx"] != 3 {
+		t.Errorf("traced %d tx and %d rx segments, want 3 of each", packets["tx"], packets["rx"])
+	}
+	if established != 2 {
+		t.Errorf("logged %d transitions to ESTABLISHED, want 2", established)
+	}
+}
@@ -0,0 +1,127 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrNoUrgentData is returned by Socket.RecvOOB when no urgent byte is
+// waiting to be read.
+var ErrNoUrgentData = errors.New("no urgent data")
+
+// SendUrgent sends data whose last byte is urgent (RFC 6093). Unlike
+// Send, the data goes out at once, without waiting for Nagle's algorithm.
+// Urgent data is only there for peers that need it; new protocols should
+// not use it.
+func (c *Connection) SendUrgent(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(data) == 0 {
+		return fmt.Errorf("no urgent data to send")
+	}
+	if !c.state.GetState().CanSendData() {
+		return c.sendStateError()
+	}
+	if queued := c.sendQueued(); queued+len(data) > c.opts.sendBuffer {
+		return fmt.Errorf("%w: %d bytes queued, limit %d", ErrSendBufferFull, queued, c.opts.sendBuffer)
+	}
+
+	c.sendBuffer.Write(data)
+	c.sndUp = c.sndNxt + uint32(c.sendBuffer.Len())
+	c.sndUrgent = true
+	return c.sendBuffered(true)
+}
+
+// markUrgent sets the urgent pointer of a data segment sent before the
+// end of the urgent data. It points to the byte after the last urgent
+// byte (RFC 6093 Section 3), or as far as it reaches when that byte is
+// more than 65535 bytes ahead. Called with c.mu held.
+func (c *Connection) markUrgent(seg *Segment) {
+	if !c.sndUrgent {
+		return
+	}
+	if !seqAfter(c.sndUp, seg.SequenceNumber) {
+		c.sndUrgent = false
+		return
+	}
+	seg.Flags |= FlagURG
+	seg.UrgentPointer = uint16(min(c.sndUp-seg.SequenceNumber, math.MaxUint16))
+}
+
+// deliverUrgent delivers in-order data from seg, noting its urgent
+// pointer first. Once the urgent byte arrives, in this segment or a later
+// one, it is handed to onUrgent and, unless OptOOBInline is set, taken
+// out of the stream. Without onUrgent, as before a connection is accepted,
+// urgent data is delivered inline. Called with c.mu held.
+func (c *Connection) deliverUrgent(seg *Segment, data []byte) {
+	if seg.HasFlag(FlagURG) && seg.UrgentPointer > 0 {
+		// A later pointer replaces one still pending
+		up := seg.SequenceNumber + uint32(seg.UrgentPointer)
+		if !c.rcvUrgent || seqAfter(up, c.rcvUp) {
+			c.rcvUp, c.rcvUrgent = up, true
+		}
+	}
+
+	i := c.rcvUp - 1 - seg.SequenceNumber
+	if !c.rcvUrgent || i >= uint32(len(data)) {
+		c.deliver(data)
+		return
+	}
+	c.rcvUrgent = false
+	if c.onUrgent == nil {
+		c.deliver(data)
+		return
+	}
+
+	if i > 0 {
+		c.deliver(data[:i])
+	}
+	c.onUrgent(data[i], c.opts.oobInline)
+	rest := data[i+1:]
+	if c.opts.oobInline {
+		rest = data[i:]
+	}
+	if len(rest) > 0 {
+		c.deliver(rest)
+	}
+}
+
+// SendUrgent sends data whose last byte is urgent, like send with MSG_OOB.
+// The peer's socket returns that byte from RecvOOB, or in the stream with
+// OptOOBInline set, and its AtMark reports when reading reaches it. Unlike
+// Send, SendUrgent does not wait for send buffer space: it fails with
+// ErrSendBufferFull if the data does not fit.
+func (s *Socket) SendUrgent(data []byte) (int, error) {
+	s.mu.RLock()
+	conn := s.conn
+	s.mu.RUnlock()
+
+	if conn == nil {
+		return 0, fmt.Errorf("not connected")
+	}
+	if err := conn.SendUrgent(data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// RecvOOB returns the urgent byte the peer sent last, which Recv leaves
+// out of the stream, or fails with ErrNoUrgentData if there is none or it
+// was read. With OptOOBInline set, the urgent byte stays in the stream
+// and RecvOOB always fails.
+func (s *Socket) RecvOOB() (byte, error) {
+	if b, ok := s.recv.readOOB(); ok {
+		return b, nil
+	}
+	return 0, ErrNoUrgentData
+}
+
+// AtMark reports whether the next Recv starts at the urgent mark: at the
+// urgent byte with OptOOBInline set, and otherwise at the byte that
+// followed it. Recv stops short of the mark, so that a reader can tell
+// the data before the urgent byte from the data after it.
+func (s *Socket) AtMark() bool {
+	return s.recv.atMark()
+}
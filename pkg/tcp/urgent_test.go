@@ -0,0 +1,182 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// urgentPeers returns an established client and a reading server peer
+// with its socket.
+func urgentPeers(t *testing.T) (*testPeer, *testPeer, *Socket) {
+	t.Helper()
+	client := newTestPeer(true, nil)
+	server, s := readingPeer(DefaultReceiveBufferSize)
+	server.conn.onUrgent = s.recv.urgent
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+	return client, server, s
+}
+
+// recv reads what the socket has queued, up to the urgent mark.
+func recv(t *testing.T, s *Socket) string {
+	t.Helper()
+	buf := make([]byte, 64<<10)
+	n, err := s.RecvTimeout(buf, time.Second)
+	if err != nil {
+		t.Fatalf("RecvTimeout() error = %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestSendUrgent(t *testing.T) {
+	client, server, s := urgentPeers(t)
+
+	if err := client.conn.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := client.conn.SendUrgent([]byte("ab!")); err != nil {
+		t.Fatalf("SendUrgent() error = %v", err)
+	}
+	urgent := client.out[len(client.out)-1]
+	if !urgent.HasFlag(FlagURG) || urgent.UrgentPointer != 3 {
+		t.Errorf("urgent segment flags = %#x, pointer = %d; want URG and 3", urgent.Flags, urgent.UrgentPointer)
+	}
+	if err := client.conn.Send([]byte("world")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if last := client.out[len(client.out)-1]; last.HasFlag(FlagURG) {
+		t.Errorf("segment after the urgent data has URG set, pointer %d", last.UrgentPointer)
+	}
+	exchange(t, client, server)
+
+	// The urgent byte is taken out of the stream, and reads stop at the
+	// mark it leaves
+	if s.AtMark() {
+		t.Error("AtMark() = true before the data ahead of the mark was read")
+	}
+	if got := recv(t, s); got != "helloab" {
+		t.Errorf("Recv() before the mark = %q, want %q", got, "helloab")
+	}
+	if !s.AtMark() {
+		t.Error("AtMark() = false at the mark")
+	}
+	if b, err := s.RecvOOB(); err != nil || b != '!' {
+		t.Errorf("RecvOOB() = %q, %v; want '!'", b, err)
+	}
+	if _, err := s.RecvOOB(); !errors.Is(err, ErrNoUrgentData) {
+		t.Errorf("second RecvOOB() error = %v, want ErrNoUrgentData", err)
+	}
+	if got := recv(t, s); got != "world" {
+		t.Errorf("Recv() after the mark = %q, want %q", got, "world")
+	}
+	if s.AtMark() {
+		t.Error("AtMark() = true past the mark")
+	}
+}
+
+func TestSendUrgentInline(t *testing.T) {
+	client, server, s := urgentPeers(t)
+	if err := s.SetSockOpt(common.OptOOBInline, true); err != nil {
+		t.Fatalf("SetSockOpt() error = %v", err)
+	}
+
+	if err := client.conn.Send([]byte("abc")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := client.conn.SendUrgent([]byte("!")); err != nil {
+		t.Fatalf("SendUrgent() error = %v", err)
+	}
+	if err := client.conn.Send([]byte("def")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	// The mark is at the urgent byte, which stays in the stream
+	if got := recv(t, s); got != "abc" {
+		t.Errorf("Recv() before the mark = %q, want %q", got, "abc")
+	}
+	if !s.AtMark() {
+		t.Error("AtMark() = false at the mark")
+	}
+	if _, err := s.RecvOOB(); !errors.Is(err, ErrNoUrgentData) {
+		t.Errorf("RecvOOB() error = %v with OptOOBInline, want ErrNoUrgentData", err)
+	}
+	if got := recv(t, s); got != "!def" {
+		t.Errorf("Recv() from the mark = %q, want %q", got, "!def")
+	}
+}
+
+func TestSendUrgentSpansSegments(t *testing.T) {
+	client, server, s := urgentPeers(t)
+	client.conn.gso = true // Urgent data is never sent as a super-segment
+	client.conn.cwnd = 4 * uint32(client.conn.mss)
+
+	data := bytes.Repeat([]byte("u"), 2*int(client.conn.mss)+10)
+	data[len(data)-1] = '!'
+	if err := client.conn.SendUrgent(data); err != nil {
+		t.Fatalf("SendUrgent() error = %v", err)
+	}
+	if len(client.out) != 3 {
+		t.Fatalf("sent %d segments, want 3", len(client.out))
+	}
+	for i, seg := range client.out {
+		if want := uint16(len(data) - i*int(client.conn.mss)); !seg.HasFlag(FlagURG) || seg.UrgentPointer != want || seg.GSOSize != 0 {
+			t.Errorf("segment %d: URG %v, pointer %d, GSO size %d; want URG, pointer %d and no GSO",
+				i, seg.HasFlag(FlagURG), seg.UrgentPointer, seg.GSOSize, want)
+		}
+	}
+
+	// Only the last byte is urgent, however many segments pointed to it
+	exchange(t, client, server)
+	if got := recv(t, s); got != string(data[:len(data)-1]) {
+		t.Errorf("Recv() = %d bytes, want %d", len(got), len(data)-1)
+	}
+	if b, err := s.RecvOOB(); err != nil || b != '!' {
+		t.Errorf("RecvOOB() = %q, %v; want '!'", b, err)
+	}
+}
+
+func TestUrgentRetransmission(t *testing.T) {
+	client, server, s := urgentPeers(t)
+	if err := client.conn.SendUrgent([]byte("xy")); err != nil {
+		t.Fatalf("SendUrgent() error = %v", err)
+	}
+
+	// The ACK is lost and the segment sent again; its urgent byte is
+	// delivered once. The segment is recycled once acknowledged, so what is
+	// sent again is a clone of it
+	seg := client.out[0].Clone()
+	exchange(t, client, server)
+	deliver(t, &testPeer{out: []*Segment{seg}}, server)
+	server.out = nil
+	if got := recv(t, s); got != "x" {
+		t.Errorf("Recv() = %q, want %q", got, "x")
+	}
+	if b, err := s.RecvOOB(); err != nil || b != 'y' {
+		t.Errorf("RecvOOB() = %q, %v; want 'y'", b, err)
+	}
+	if _, err := s.RecvOOB(); !errors.Is(err, ErrNoUrgentData) {
+		t.Errorf("second RecvOOB() error = %v, want ErrNoUrgentData", err)
+	}
+}
+
+func TestSendUrgentErrors(t *testing.T) {
+	client, _, _ := urgentPeers(t)
+	if err := client.conn.SendUrgent(nil); err == nil {
+		t.Error("SendUrgent(nil) succeeded, want error")
+	}
+	if err := client.conn.SendUrgent(make([]byte, client.conn.opts.sendBuffer+1)); !errors.Is(err, ErrSendBufferFull) {
+		t.Errorf("SendUrgent() of more than the send buffer error = %v, want ErrSendBufferFull", err)
+	}
+
+	s := NewSocket(testClientIP, 0)
+	if _, err := s.SendUrgent([]byte("!")); err == nil {
+		t.Error("SendUrgent() on an unconnected socket succeeded, want error")
+	}
+}
@@ -0,0 +1,99 @@
+package tcp
+
+import (
+	"math"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/logging"
+)
+
+// challengeACKLimit is the most challenge ACKs a connection sends a
+// second, so that spoofed segments cannot turn it into an ACK flood (RFC
+// 5961 Section 7).
+const challengeACKLimit = 10
+
+// validateSegment applies the acceptance tests of a synchronized
+// connection to seg and returns the part of it to process, or nil to drop
+// it. Called with c.mu held.
+//
+// A segment must overlap the receive window (RFC 793 Section 3.3); one
+// that does not, such as a retransmission of data already received, is
+// answered with an ACK. Data before rcvNxt is trimmed. A SYN (RFC 5961
+// Section 4) or an ACK of data not yet sent (Section 5) is answered with
+// a challenge ACK: the peer of a live connection just ignores it, and one
+// that really restarted sends an RST at the right sequence number.
+func (c *Connection) validateSegment(seg *Segment) *Segment {
+	if !c.acceptable(seg) {
+		c.rejectSegment(seg, "segment outside the receive window")
+		c.sendAck()
+		return nil
+	}
+
+	if seg.HasFlag(FlagSYN) {
+		c.rejectSegment(seg, "SYN on a synchronized connection")
+		c.sendChallengeACK()
+		return nil
+	}
+
+	if seg.HasFlag(FlagACK) {
+		// MAX.SND.WND is taken as the largest window the peer can offer
+		if seqAfter(seg.AckNumber, c.sndNxt) || seqBefore(seg.AckNumber, c.sndUna-math.MaxUint16) {
+			c.rejectSegment(seg, "ACK outside the send window")
+			c.sendChallengeACK()
+			return nil
+		}
+	} else {
+		// Every segment after the handshake carries an ACK
+		c.rejectSegment(seg, "segment without ACK")
+		return nil
+	}
+
+	if seqBefore(seg.SequenceNumber, c.rcvNxt) {
+		trimmed := *seg
+		trimmed.Data = seg.Data[c.rcvNxt-seg.SequenceNumber:]
+		trimmed.SequenceNumber = c.rcvNxt
+		if trim := c.rcvNxt - seg.SequenceNumber; seg.HasFlag(FlagURG) && uint32(seg.UrgentPointer) > trim {
+			trimmed.UrgentPointer -= uint16(trim)
+		} else {
+			trimmed.Flags &^= FlagURG
+			trimmed.UrgentPointer = 0
+		}
+		return &trimmed
+	}
+	return seg
+}
+
+// acceptable is the RFC 793 acceptance test: the segment, counting SYN and
+// FIN, must start or end in the receive window. With the window closed
+// only a segment at rcvNxt is acceptable, for its ACK, and processData
+// drops its data.
+func (c *Connection) acceptable(seg *Segment) bool {
+	length := segmentLength(seg)
+	if c.rcvWnd == 0 || length == 0 {
+		return c.inReceiveWindow(seg.SequenceNumber)
+	}
+	return c.inReceiveWindow(seg.SequenceNumber) || c.inReceiveWindow(seg.SequenceNumber+length-1)
+}
+
+// rejectSegment counts and logs a segment that failed validation.
+func (c *Connection) rejectSegment(seg *Segment, reason string) {
+	c.stats.segmentsRejected++
+	stackCounters.segmentsRejected.Add(1)
+	logger.Debug("rejected segment", c.logID(), logging.F("reason", reason),
+		logging.F("seq", seg.SequenceNumber), logging.F("ack", seg.AckNumber), logging.F("rcv_nxt", c.rcvNxt))
+}
+
+// sendChallengeACK sends a challenge ACK, unless the connection already
+// sent challengeACKLimit of them in the last second.
+func (c *Connection) sendChallengeACK() {
+	now := time.Now()
+	if now.Sub(c.challengeStart) >= time.Second {
+		c.challengeStart, c.challengeACKs = now, 0
+	}
+	if c.challengeACKs >= challengeACKLimit {
+		return
+	}
+	c.challengeACKs++
+	stackCounters.challengeACKs.Add(1)
+	c.sendAck()
+}
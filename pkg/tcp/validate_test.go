@@ -0,0 +1,119 @@
+package tcp
+
+import (
+	"testing"
+)
+
+func TestValidateSegment(t *testing.T) {
+	tests := []struct {
+		name     string
+		seq      int64 // Relative to the server's rcvNxt
+		ack      int64 // Relative to the server's sndNxt
+		flags    uint8
+		data     string
+		wantData string // Delivered to the application
+		wantAck  bool   // Answered with an ACK
+	}{
+		{"in order", 0, 0, FlagACK, "new", "new", true},
+		{"duplicate", -5, 0, FlagACK, "old", "", true},
+		{"overlapping", -3, 0, FlagACK, "oldnew", "new", true},
+		{"beyond the window", 70000, 0, FlagACK, "far", "", true},
+		{"SYN", 0, 0, FlagSYN | FlagACK, "", "", true},
+		{"ACK of unsent data", 0, 1000, FlagACK, "new", "", true},
+		{"ACK from long ago", 0, -100000, FlagACK, "new", "", true},
+		{"no ACK", 0, 0, 0, "new", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := established(t)
+			if err := client.conn.Send([]byte("hello")); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			exchange(t, client, server)
+			server.data = nil
+			rcvNxt, sndUna := server.conn.rcvNxt, server.conn.sndUna
+
+			seg := NewSegment(50000, 80, uint32(int64(rcvNxt)+tt.seq), uint32(int64(server.conn.sndNxt)+tt.ack), tt.flags, 65535, []byte(tt.data))
+			seg.Checksum, _ = seg.CalculateChecksum(testClientIP, testServerIP)
+			if err := server.conn.HandleSegment(seg); err != nil {
+				t.Fatalf("HandleSegment() error = %v", err)
+			}
+
+			if got := string(server.data); got != tt.wantData {
+				t.Errorf("delivered %q, want %q", got, tt.wantData)
+			}
+			if want := rcvNxt + uint32(len(tt.wantData)); server.conn.rcvNxt != want {
+				t.Errorf("rcvNxt = %d, want %d", server.conn.rcvNxt, want)
+			}
+			if server.conn.sndUna != sndUna {
+				t.Errorf("sndUna = %d, want %d", server.conn.sndUna, sndUna)
+			}
+			if got := len(server.out) > 0 && server.out[0].HasFlag(FlagACK); got != tt.wantAck {
+				t.Errorf("answered with %v, want an ACK = %v", server.out, tt.wantAck)
+			}
+			if got := server.conn.GetState(); got != StateEstablished {
+				t.Errorf("state = %v, want %v", got, StateEstablished)
+			}
+		})
+	}
+}
+
+func TestChallengeACKLimit(t *testing.T) {
+	client, server := established(t)
+
+	for i := 0; i < 3*challengeACKLimit; i++ {
+		syn := NewSegment(50000, 80, server.conn.rcvNxt, 0, FlagSYN, 65535, nil)
+		syn.Checksum, _ = syn.CalculateChecksum(testClientIP, testServerIP)
+		if err := server.conn.HandleSegment(syn); err != nil {
+			t.Fatalf("HandleSegment(SYN) error = %v", err)
+		}
+	}
+	if len(server.out) != challengeACKLimit {
+		t.Errorf("sent %d challenge ACKs, want %d", len(server.out), challengeACKLimit)
+	}
+
+	// The connection is unharmed
+	server.out = nil
+	if err := client.conn.Send([]byte("still here")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+	if got := string(server.data); got != "still here" {
+		t.Errorf("server received %q, want %q", got, "still here")
+	}
+}
+
+func TestZeroWindowAck(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server, _ := readingPeer(MinBufferSize)
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+	if err := client.conn.Send(make([]byte, MinBufferSize)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+	if server.conn.rcvWnd != 0 {
+		t.Fatalf("window = %d, want 0", server.conn.rcvWnd)
+	}
+
+	// With the window closed the server's own data is still acknowledged,
+	// though the segment carrying the ACK also carries data
+	if err := server.conn.Send([]byte("reply")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	deliver(t, server, client)
+	seg := NewSegment(50000, 80, server.conn.rcvNxt, server.conn.sndNxt, FlagACK, 65535, []byte("x"))
+	seg.Checksum, _ = seg.CalculateChecksum(testClientIP, testServerIP)
+	if err := server.conn.HandleSegment(seg); err != nil {
+		t.Fatalf("HandleSegment() error = %v", err)
+	}
+	if server.conn.sndUna != server.conn.sndNxt {
+		t.Error("ACK on a zero window not processed")
+	}
+	if got := server.conn.recvQueued.Load(); got != MinBufferSize {
+		t.Errorf("%d bytes queued, want %d: the data does not fit the window", got, MinBufferSize)
+	}
+}
@@ -0,0 +1,111 @@
+package tcp
+
+import (
+	"math"
+)
+
+// freeReceiveSpace returns how much more data the receive buffer can
+// hold: its size less the data delivered and not yet read.
+func (c *Connection) freeReceiveSpace() int {
+	free := c.opts.receiveBuffer - int(c.recvQueued.Load()) - len(c.earlyData)
+	if free < 0 {
+		return 0
+	}
+	return min(free, math.MaxUint16)
+}
+
+// shrinkReceiveWindow advertises the receive buffer's free space after
+// data was delivered. The right edge of the window stays where it was, as
+// the data taken from the window is the data added to the buffer.
+func (c *Connection) shrinkReceiveWindow() {
+	if free := c.freeReceiveSpace(); free < int(c.rcvWnd) {
+		c.rcvWnd = uint16(free)
+	}
+}
+
+// readWindowUpdate reopens the receive window after the application read
+// data, and returns whether to tell the peer. Small increases are not
+// advertised until they add up to an MSS or half the buffer (receiver-side
+// silly window avoidance, RFC 1122 Section 4.2.3.3).
+func (c *Connection) readWindowUpdate() bool {
+	free := c.freeReceiveSpace()
+	if free-int(c.rcvWnd) < min(c.opts.receiveBuffer/2, int(c.mss)) {
+		return false
+	}
+	c.rcvWnd = uint16(free)
+	return true
+}
+
+// consumed accounts for n bytes the application read from the socket, and
+// sends a window update if reading opened the window far enough.
+func (c *Connection) consumed(n int) {
+	c.recvQueued.Add(-int64(n))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.readWindowUpdate() || !c.state.GetState().IsConnectionEstablished() {
+		return
+	}
+	update := NewSegment(c.LocalPort, c.RemotePort, c.sndNxt, c.rcvNxt, FlagACK, c.rcvWnd, nil)
+	update.Checksum, _ = c.checksum(update)
+	if c.onSegmentReady != nil {
+		c.transmit(update)
+	}
+}
+
+// wakeWriters tells a Socket.Send waiting for send buffer space to look
+// again, because data was acknowledged or the connection can no longer
+// send.
+func (c *Connection) wakeWriters() {
+	select {
+	case c.writable <- struct{}{}:
+	default:
+	}
+}
+
+// armPersistTimer starts the persist timer when data is waiting on a
+// zero send window with nothing outstanding, so that no retransmission
+// would find out when the window opens. Each expiry sends a window probe
+// and backs off like the retransmission timer (RFC 1122 Section
+// 4.2.2.17).
+func (c *Connection) armPersistTimer() {
+	if c.sndWnd != 0 || c.sendBuffer.Len() == 0 || c.retransmitQueue.Len() > 0 {
+		c.stopPersistTimer()
+		return
+	}
+	if c.persistTimer == nil {
+		c.persistBackoff = c.rto
+		c.persistTimer = c.timers.AfterFunc(c.persistBackoff, c.persistTimeout)
+	} else if !c.persistTimer.Pending() {
+		c.persistBackoff = c.rto
+		c.persistTimer.Reset(c.persistBackoff)
+	}
+}
+
+// stopPersistTimer stops the persist timer once the send window opens or
+// the connection closes.
+func (c *Connection) stopPersistTimer() {
+	if c.persistTimer != nil {
+		c.persistTimer.Stop()
+	}
+}
+
+// persistTimeout sends a zero window probe. The probe is the segment a
+// keepalive sends, which the peer answers with an ACK carrying its window.
+func (c *Connection) persistTimeout() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sndWnd != 0 || c.sendBuffer.Len() == 0 || !c.canFlush() {
+		return
+	}
+
+	probe := NewSegment(c.LocalPort, c.RemotePort, c.sndUna-1, c.rcvNxt, FlagACK, c.rcvWnd, nil)
+	probe.Checksum, _ = c.checksum(probe)
+	if c.onSegmentReady != nil {
+		c.transmit(probe)
+	}
+
+	c.persistBackoff = min(c.persistBackoff*2, maxRTO)
+	c.persistTimer.Reset(c.persistBackoff)
+}
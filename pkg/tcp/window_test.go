@@ -0,0 +1,179 @@
+package tcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// readingPeer returns a server test peer whose data is queued in a socket,
+// as for an accepted connection, with a receive buffer of size bytes.
+func readingPeer(size int) (*testPeer, *Socket) {
+	server := newTestPeer(false, nil)
+	s := NewSocket(testServerIP, 80)
+	s.conn = server.conn
+	s.opts.receiveBuffer = size
+	server.conn.setOptions(s.opts)
+	server.conn.onDataReady = func(data []byte) { s.receive(server.conn, data) }
+	server.conn.onFinReceived = s.recv.close
+	server.conn.onClose = s.recv.close
+	return server, s
+}
+
+func TestReceiveWindow(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server, s := readingPeer(4096)
+	wheel := manualWheel()
+	client.conn.timers = wheel
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+	if client.conn.sndWnd != 4096 {
+		t.Fatalf("client send window = %d after handshake, want 4096", client.conn.sndWnd)
+	}
+
+	// The window closes as the buffer fills, and the client stops
+	data := make([]byte, 6000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := client.conn.Send(data); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+	if server.conn.rcvWnd != 0 || client.conn.sndWnd != 0 {
+		t.Fatalf("windows = %d and %d with the buffer full, want 0", server.conn.rcvWnd, client.conn.sndWnd)
+	}
+	if got := client.conn.sendBuffer.Len(); got != 6000-4096 {
+		t.Fatalf("client holds %d bytes, want %d", got, 6000-4096)
+	}
+	if client.conn.persistTimer == nil || !client.conn.persistTimer.Pending() {
+		t.Fatal("persist timer not running with a zero window")
+	}
+
+	// Reading a little does not reopen the window; reading enough sends a
+	// window update and the rest of the data follows
+	buf := make([]byte, 100)
+	if _, err := s.RecvTimeout(buf, time.Second); err != nil {
+		t.Fatalf("RecvTimeout() error = %v", err)
+	}
+	if len(server.out) != 0 {
+		t.Errorf("sent %v after reading 100 bytes, want nothing", server.out)
+	}
+	got := append([]byte(nil), buf...)
+	buf = make([]byte, 4096)
+	n, err := s.RecvTimeout(buf, time.Second)
+	if err != nil {
+		t.Fatalf("RecvTimeout() error = %v", err)
+	}
+	got = append(got, buf[:n]...)
+	if len(server.out) != 1 || server.out[0].WindowSize != 4096 {
+		t.Fatalf("sent %v after emptying the buffer, want a window update", server.out)
+	}
+	exchange(t, client, server)
+	n, err = s.RecvTimeout(buf, time.Second)
+	if err != nil {
+		t.Fatalf("RecvTimeout() error = %v", err)
+	}
+	got = append(got, buf[:n]...)
+	if !bytes.Equal(got, data) {
+		t.Errorf("server read %d bytes, want the %d sent", len(got), len(data))
+	}
+	if client.conn.persistTimer.Pending() {
+		t.Error("persist timer still running after the window opened")
+	}
+}
+
+func TestZeroWindowProbe(t *testing.T) {
+	client := newTestPeer(true, nil)
+	server, s := readingPeer(MinBufferSize)
+	wheel := manualWheel()
+	client.conn.timers = wheel
+	if err := client.conn.ActiveOpen(); err != nil {
+		t.Fatalf("ActiveOpen() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	if err := client.conn.Send(make([]byte, 2*MinBufferSize)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	exchange(t, client, server)
+
+	// The window update the read sends is lost
+	if _, err := s.RecvTimeout(make([]byte, MinBufferSize), time.Second); err != nil {
+		t.Fatalf("RecvTimeout() error = %v", err)
+	}
+	server.out = nil
+
+	// The persist timer probes the window and the answer reopens it
+	advanceWheel(wheel, int(client.conn.rto/wheel.tick)+1)
+	if len(client.out) != 1 || client.out[0].SequenceNumber != client.conn.sndUna-1 {
+		t.Fatalf("sent %v when the persist timer expired, want a window probe", client.out)
+	}
+	exchange(t, client, server)
+	if got := server.conn.rcvNxt - server.conn.irs - 1; got != 2*MinBufferSize {
+		t.Errorf("server received %d bytes, want %d", got, 2*MinBufferSize)
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	client := NewDemultiplexer()
+	server := NewDemultiplexer()
+	defer linkDemuxes(t, client, server)()
+
+	listener := NewSocket(common.IPv4Address{}, 8081)
+	listener.SetReuseAddr(true)
+	if err := listener.SetSockOpt(common.OptReceiveBuffer, 4096); err != nil {
+		t.Fatalf("SetSockOpt(OptReceiveBuffer) error = %v", err)
+	}
+	if err := server.Listen(listener, 1); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	sock := NewSocket(testClientIP, 0)
+	if err := sock.SetSockOpt(common.OptSendBuffer, 8192); err != nil {
+		t.Fatalf("SetSockOpt(OptSendBuffer) error = %v", err)
+	}
+	if err := client.Connect(sock, testServerIP, 8081); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer sock.Close()
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+
+	// Send blocks on the full send buffer until the slow reader catches up
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		_, err := sock.Send(data)
+		sent <- err
+	}()
+
+	var got []byte
+	buf := make([]byte, 1000)
+	for len(got) < len(data) {
+		n, err := accepted.RecvTimeout(buf, 5*time.Second)
+		if err != nil {
+			t.Fatalf("RecvTimeout() error = %v after %d bytes", err, len(got))
+		}
+		got = append(got, buf[:n]...)
+		if queued := accepted.conn.recvQueued.Load(); queued > 4096 {
+			t.Fatalf("%d bytes queued, more than the receive buffer", queued)
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("received data differs from the data sent")
+	}
+}
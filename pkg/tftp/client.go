@@ -0,0 +1,185 @@
+package tftp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ClientConfig configures a Client.
+type ClientConfig struct {
+	// BlockSize is the block size to ask servers for. 0 means
+	// DefaultBlockSize, which needs no negotiation.
+	BlockSize int
+
+	// Timeout is how long to wait for a reply before retransmitting. 0
+	// means DefaultTimeout.
+	Timeout time.Duration
+
+	// ServerTimeout, if not 0, asks the server with the timeout option to
+	// wait that long before retransmitting. It is whole seconds, from 1 to
+	// 255.
+	ServerTimeout time.Duration
+
+	// Retries is how many times a packet is retransmitted before giving up.
+	// 0 means DefaultRetries.
+	Retries int
+}
+
+// Client reads and writes files on TFTP servers.
+type Client struct {
+	listen ListenFunc
+	config ClientConfig
+}
+
+// NewClient creates a client with the default configuration.
+func NewClient(listen ListenFunc) *Client {
+	return &Client{listen: listen}
+}
+
+// NewClientWithConfig creates a client with a custom configuration.
+func NewClientWithConfig(listen ListenFunc, config ClientConfig) (*Client, error) {
+	if config.BlockSize != 0 && (config.BlockSize < MinBlockSize || config.BlockSize > MaxBlockSize) {
+		return nil, fmt.Errorf("invalid block size %d (must be %d to %d)", config.BlockSize, MinBlockSize, MaxBlockSize)
+	}
+	if config.Timeout < 0 {
+		return nil, fmt.Errorf("invalid timeout %s", config.Timeout)
+	}
+	if config.ServerTimeout != 0 {
+		if _, ok := parseTimeout(timeoutOption(config.ServerTimeout)); !ok || config.ServerTimeout%time.Second != 0 {
+			return nil, fmt.Errorf("invalid server timeout %s (must be whole seconds from 1 to %d)", config.ServerTimeout, maxTimeoutOption)
+		}
+	}
+	if config.Retries < 0 {
+		return nil, fmt.Errorf("invalid retries %d", config.Retries)
+	}
+	return &Client{listen: listen, config: config}, nil
+}
+
+// Get reads a file from a server into w, and returns the number of bytes
+// read.
+func (c *Client) Get(server net.Addr, filename string, w io.Writer) (int64, error) {
+	t, req, err := c.start(server, OpReadRequest, filename)
+	if err != nil {
+		return 0, err
+	}
+	defer t.conn.Close()
+
+	pkt, err := t.receive()
+	if err != nil {
+		return 0, err
+	}
+	switch pkt.Opcode {
+	case OpOptionAck:
+		if err := t.acceptOptions(pkt.Options, req.Options); err != nil {
+			return 0, err
+		}
+		if err := t.send(&Packet{Opcode: OpAck, Block: 0}); err != nil {
+			return 0, err
+		}
+		return t.receiveData(w, nil)
+	case OpData:
+		// The server ignored the options
+		return t.receiveData(w, pkt)
+	default:
+		return 0, t.abort(&Error{ErrIllegalOperation, "unexpected " + pkt.Opcode.String()})
+	}
+}
+
+// Put writes r to a file on a server, and returns the number of bytes
+// written.
+func (c *Client) Put(server net.Addr, filename string, r io.Reader) (int64, error) {
+	t, req, err := c.start(server, OpWriteRequest, filename)
+	if err != nil {
+		return 0, err
+	}
+	defer t.conn.Close()
+
+	pkt, err := t.receive()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case pkt.Opcode == OpOptionAck:
+		if err := t.acceptOptions(pkt.Options, req.Options); err != nil {
+			return 0, err
+		}
+	case pkt.Opcode == OpAck && pkt.Block == 0:
+		// The server ignored the options
+	default:
+		return 0, t.abort(&Error{ErrIllegalOperation, "unexpected " + pkt.String()})
+	}
+	return t.sendData(r)
+}
+
+// start opens a transfer on an ephemeral port and sends its request.
+func (c *Client) start(server net.Addr, op Opcode, filename string) (*transfer, *Packet, error) {
+	conn, err := c.listen(0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open transfer port: %w", err)
+	}
+
+	timeout, retries := c.config.Timeout, c.config.Retries
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	t := newTransfer(conn, server, false, timeout, retries)
+
+	req := &Packet{Opcode: op, Filename: filename, Mode: ModeOctet, Options: c.options()}
+	if err := t.send(req); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return t, req, nil
+}
+
+// options returns the options to request.
+func (c *Client) options() map[string]string {
+	options := make(map[string]string)
+	if c.config.BlockSize != 0 && c.config.BlockSize != DefaultBlockSize {
+		options[OptionBlockSize] = strconv.Itoa(c.config.BlockSize)
+	}
+	if c.config.ServerTimeout != 0 {
+		options[OptionTimeout] = timeoutOption(c.config.ServerTimeout)
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	return options
+}
+
+// timeoutOption returns the timeout option value of a timeout.
+func timeoutOption(timeout time.Duration) string {
+	return strconv.Itoa(int(timeout / time.Second))
+}
+
+// acceptOptions applies the options a server acknowledged. A server may
+// only acknowledge options it was asked for, and may lower the block size
+// but not raise it.
+func (t *transfer) acceptOptions(acked, requested map[string]string) error {
+	for name, value := range acked {
+		want, ok := requested[name]
+		if !ok {
+			return t.abort(&Error{ErrOptionRefused, "unrequested option " + name})
+		}
+		switch name {
+		case OptionBlockSize:
+			size, ok := parseBlockSize(value)
+			max, _ := strconv.Atoi(want)
+			if !ok || size > max {
+				return t.abort(&Error{ErrOptionRefused, "invalid block size " + value})
+			}
+			t.blockSize = size
+		case OptionTimeout:
+			if value != want {
+				return t.abort(&Error{ErrOptionRefused, "invalid timeout " + value})
+			}
+		}
+	}
+	return nil
+}
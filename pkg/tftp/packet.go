@@ -0,0 +1,293 @@
+// Package tftp implements the Trivial File Transfer Protocol (RFC 1350) with
+// option negotiation (RFC 2347) of the block size (RFC 2348) and timeout
+// (RFC 2349), over the stack's UDP sockets.
+//
+// TFTP sends one block at a time and waits for its acknowledgment, so a
+// transfer recovers from every lost datagram by retransmission on timeout.
+// Each transfer runs between two ports of its own (transfer identifiers),
+// which the ListenFunc a Client or Server is given opens.
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TFTP packet formats (RFC 1350 Section 5, RFC 2347):
+//
+//	RRQ/WRQ: | Opcode (2) | Filename | 0 | Mode | 0 | Option | 0 | Value | 0 | ...
+//	DATA:    | Opcode (2) | Block (2) | Data (0 to block size) |
+//	ACK:     | Opcode (2) | Block (2) |
+//	ERROR:   | Opcode (2) | Error code (2) | Message | 0 |
+//	OACK:    | Opcode (2) | Option | 0 | Value | 0 | ...
+
+const (
+	// Port is the UDP port TFTP servers listen for requests on.
+	Port = 69
+
+	// DefaultBlockSize is the block size of transfers that do not
+	// negotiate one.
+	DefaultBlockSize = 512
+
+	// MinBlockSize and MaxBlockSize are the limits of the blksize option.
+	MinBlockSize = 8
+	MaxBlockSize = 65464
+
+	// ModeOctet transfers files as raw bytes. ModeNetASCII is accepted
+	// too, but its data is transferred unchanged.
+	ModeOctet    = "octet"
+	ModeNetASCII = "netascii"
+
+	// Option names.
+	OptionBlockSize = "blksize"
+	OptionTimeout   = "timeout"
+
+	// headerLength is the length of the opcode and block number of DATA
+	// and ACK packets.
+	headerLength = 4
+)
+
+// Opcode is a TFTP packet type.
+type Opcode uint16
+
+// TFTP opcodes.
+const (
+	OpReadRequest  Opcode = 1
+	OpWriteRequest Opcode = 2
+	OpData         Opcode = 3
+	OpAck          Opcode = 4
+	OpError        Opcode = 5
+	OpOptionAck    Opcode = 6
+)
+
+// String returns a human-readable name for the opcode.
+func (op Opcode) String() string {
+	switch op {
+	case OpReadRequest:
+		return "RRQ"
+	case OpWriteRequest:
+		return "WRQ"
+	case OpData:
+		return "DATA"
+	case OpAck:
+		return "ACK"
+	case OpError:
+		return "ERROR"
+	case OpOptionAck:
+		return "OACK"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint16(op))
+	}
+}
+
+// ErrorCode is the code of a TFTP ERROR packet.
+type ErrorCode uint16
+
+// TFTP error codes.
+const (
+	ErrNotDefined        ErrorCode = 0
+	ErrFileNotFound      ErrorCode = 1
+	ErrAccessViolation   ErrorCode = 2
+	ErrDiskFull          ErrorCode = 3
+	ErrIllegalOperation  ErrorCode = 4
+	ErrUnknownTransferID ErrorCode = 5
+	ErrFileExists        ErrorCode = 6
+	ErrNoSuchUser        ErrorCode = 7
+	ErrOptionRefused     ErrorCode = 8
+)
+
+// Error is an error a TFTP peer reported in an ERROR packet, or one
+// reported to it.
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("tftp error %d: %s", e.Code, e.Message)
+}
+
+// Packet is a TFTP packet. The fields used depend on the opcode.
+type Packet struct {
+	Opcode Opcode
+
+	// RRQ and WRQ
+	Filename string
+	Mode     string
+
+	// RRQ, WRQ and OACK
+	Options map[string]string
+
+	// DATA and ACK
+	Block uint16
+	Data  []byte
+
+	// ERROR
+	ErrorCode    ErrorCode
+	ErrorMessage string
+}
+
+// Parse parses a TFTP packet. Option names are made lower case, as they
+// are case-insensitive.
+func Parse(data []byte) (*Packet, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("packet too short: %d bytes", len(data))
+	}
+
+	p := &Packet{Opcode: Opcode(binary.BigEndian.Uint16(data[0:2]))}
+	body := data[2:]
+
+	switch p.Opcode {
+	case OpReadRequest, OpWriteRequest:
+		fields, err := splitStrings(body)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 2 || len(fields)%2 != 0 {
+			return nil, fmt.Errorf("malformed %s: %d fields", p.Opcode, len(fields))
+		}
+		p.Filename = fields[0]
+		p.Mode = strings.ToLower(fields[1])
+		if p.Options, err = parseOptions(fields[2:]); err != nil {
+			return nil, err
+		}
+
+	case OpOptionAck:
+		fields, err := splitStrings(body)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("malformed OACK: %d fields", len(fields))
+		}
+		if p.Options, err = parseOptions(fields); err != nil {
+			return nil, err
+		}
+
+	case OpData, OpAck:
+		if len(body) < 2 {
+			return nil, fmt.Errorf("%s too short: %d bytes", p.Opcode, len(data))
+		}
+		p.Block = binary.BigEndian.Uint16(body[0:2])
+		if p.Opcode == OpData {
+			p.Data = body[2:]
+		}
+
+	case OpError:
+		if len(body) < 2 {
+			return nil, fmt.Errorf("ERROR too short: %d bytes", len(data))
+		}
+		p.ErrorCode = ErrorCode(binary.BigEndian.Uint16(body[0:2]))
+		// Tolerate a missing terminator
+		msg := body[2:]
+		if i := bytes.IndexByte(msg, 0); i >= 0 {
+			msg = msg[:i]
+		}
+		p.ErrorMessage = string(msg)
+
+	default:
+		return nil, fmt.Errorf("unknown opcode %d", uint16(p.Opcode))
+	}
+	return p, nil
+}
+
+// Serialize converts the packet to bytes. Options are written in name
+// order.
+func (p *Packet) Serialize() ([]byte, error) {
+	data := binary.BigEndian.AppendUint16(nil, uint16(p.Opcode))
+
+	switch p.Opcode {
+	case OpReadRequest, OpWriteRequest:
+		if p.Filename == "" {
+			return nil, fmt.Errorf("%s has no filename", p.Opcode)
+		}
+		data = appendString(data, p.Filename)
+		data = appendString(data, p.Mode)
+		data = appendOptions(data, p.Options)
+	case OpOptionAck:
+		data = appendOptions(data, p.Options)
+	case OpData:
+		if len(p.Data) > MaxBlockSize {
+			return nil, fmt.Errorf("block too large: %d bytes", len(p.Data))
+		}
+		data = binary.BigEndian.AppendUint16(data, p.Block)
+		data = append(data, p.Data...)
+	case OpAck:
+		data = binary.BigEndian.AppendUint16(data, p.Block)
+	case OpError:
+		data = binary.BigEndian.AppendUint16(data, uint16(p.ErrorCode))
+		data = appendString(data, p.ErrorMessage)
+	default:
+		return nil, fmt.Errorf("unknown opcode %d", uint16(p.Opcode))
+	}
+	return data, nil
+}
+
+// String returns a human-readable representation of the packet.
+func (p *Packet) String() string {
+	switch p.Opcode {
+	case OpReadRequest, OpWriteRequest:
+		return fmt.Sprintf("%s{%q, %s, %v}", p.Opcode, p.Filename, p.Mode, p.Options)
+	case OpOptionAck:
+		return fmt.Sprintf("OACK{%v}", p.Options)
+	case OpData:
+		return fmt.Sprintf("DATA{Block=%d, %d bytes}", p.Block, len(p.Data))
+	case OpAck:
+		return fmt.Sprintf("ACK{Block=%d}", p.Block)
+	case OpError:
+		return fmt.Sprintf("ERROR{%d, %q}", p.ErrorCode, p.ErrorMessage)
+	default:
+		return p.Opcode.String()
+	}
+}
+
+// errorPacket returns the ERROR packet reporting err.
+func errorPacket(err *Error) *Packet {
+	return &Packet{Opcode: OpError, ErrorCode: err.Code, ErrorMessage: err.Message}
+}
+
+// splitStrings splits a sequence of zero-terminated strings.
+func splitStrings(b []byte) ([]string, error) {
+	if len(b) == 0 || b[len(b)-1] != 0 {
+		return nil, fmt.Errorf("unterminated string")
+	}
+	return strings.Split(string(b[:len(b)-1]), "\x00"), nil
+}
+
+// parseOptions parses option name and value pairs.
+func parseOptions(fields []string) (map[string]string, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	options := make(map[string]string, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		name := strings.ToLower(fields[i])
+		if name == "" {
+			return nil, fmt.Errorf("empty option name")
+		}
+		options[name] = fields[i+1]
+	}
+	return options, nil
+}
+
+// appendString appends a zero-terminated string.
+func appendString(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
+
+// appendOptions appends options in name order.
+func appendOptions(b []byte, options map[string]string) []byte {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b = appendString(b, name)
+		b = appendString(b, options[name])
+	}
+	return b
+}
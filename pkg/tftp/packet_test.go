@@ -0,0 +1,116 @@
+package tftp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		pkt  *Packet
+	}{
+		{"RRQ", &Packet{Opcode: OpReadRequest, Filename: "boot/kernel", Mode: ModeOctet}},
+		{"WRQ with options", &Packet{
+			Opcode:   OpWriteRequest,
+			Filename: "upload.bin",
+			Mode:     ModeOctet,
+			Options:  map[string]string{OptionBlockSize: "1428", OptionTimeout: "3"},
+		}},
+		{"DATA", &Packet{Opcode: OpData, Block: 65535, Data: []byte("hello")}},
+		{"empty DATA", &Packet{Opcode: OpData, Block: 7, Data: []byte{}}},
+		{"ACK", &Packet{Opcode: OpAck, Block: 42}},
+		{"ERROR", &Packet{Opcode: OpError, ErrorCode: ErrFileNotFound, ErrorMessage: "file not found"}},
+		{"OACK", &Packet{Opcode: OpOptionAck, Options: map[string]string{OptionBlockSize: "1024"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.pkt.Serialize()
+			if err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			parsed, err := Parse(data)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(parsed, tt.pkt) {
+				t.Errorf("Parse() = %+v, want %+v", parsed, tt.pkt)
+			}
+		})
+	}
+}
+
+func TestParseKnownRequest(t *testing.T) {
+	// RRQ for "a.txt" in mode "OCTET" with "BLKSIZE" 1024; mode and option
+	// names are case-insensitive
+	data := []byte("\x00\x01a.txt\x00OCTET\x00BLKSIZE\x001024\x00")
+
+	pkt, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := &Packet{
+		Opcode:   OpReadRequest,
+		Filename: "a.txt",
+		Mode:     ModeOctet,
+		Options:  map[string]string{OptionBlockSize: "1024"},
+	}
+	if !reflect.DeepEqual(pkt, want) {
+		t.Errorf("Parse() = %+v, want %+v", pkt, want)
+	}
+
+	serialized, err := want.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if wantData := []byte("\x00\x01a.txt\x00octet\x00blksize\x001024\x00"); !bytes.Equal(serialized, wantData) {
+		t.Errorf("Serialize() = %q, want %q", serialized, wantData)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte{0x00}},
+		{"unknown opcode", []byte{0x00, 0x09}},
+		{"unterminated filename", []byte("\x00\x01a.txt")},
+		{"no mode", []byte("\x00\x01a.txt\x00")},
+		{"option without value", []byte("\x00\x01a.txt\x00octet\x00blksize\x00")},
+		{"empty option name", []byte("\x00\x01a.txt\x00octet\x00\x001024\x00")},
+		{"short DATA", []byte{0x00, 0x03, 0x00}},
+		{"short ACK", []byte{0x00, 0x04}},
+		{"short ERROR", []byte{0x00, 0x05, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Errorf("Parse() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestSerializeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		pkt  *Packet
+	}{
+		{"no filename", &Packet{Opcode: OpReadRequest, Mode: ModeOctet}},
+		{"block too large", &Packet{Opcode: OpData, Data: make([]byte, MaxBlockSize+1)}},
+		{"unknown opcode", &Packet{Opcode: 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.pkt.Serialize(); err == nil {
+				t.Errorf("Serialize() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ServerConfig configures a Server.
+type ServerConfig struct {
+	// Listen opens the port of each transfer.
+	Listen ListenFunc
+
+	// ReadFile opens a file a client reads. nil refuses reads.
+	ReadFile func(filename string) (io.ReadCloser, error)
+
+	// WriteFile creates a file a client writes. nil refuses writes. The
+	// file is closed when the transfer ends, even if it fails.
+	WriteFile func(filename string) (io.WriteCloser, error)
+
+	Timeout      time.Duration // Until options change it; 0 means DefaultTimeout
+	Retries      int           // 0 means DefaultRetries
+	MaxBlockSize int           // Largest block size granted; 0 means MaxBlockSize
+
+	// OnTransfer, if not nil, is called when each transfer ends.
+	OnTransfer func(Transfer)
+}
+
+// Transfer describes a transfer a server has finished.
+type Transfer struct {
+	Opcode    Opcode // OpReadRequest or OpWriteRequest
+	Filename  string
+	Peer      net.Addr
+	BlockSize int
+	Bytes     int64
+	Err       error
+}
+
+// Server serves TFTP requests. Each transfer runs in a goroutine of its
+// own, on a port opened for it.
+type Server struct {
+	config ServerConfig
+
+	mu        sync.Mutex
+	listeners map[net.PacketConn]struct{}
+	transfers map[net.PacketConn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a server.
+func NewServer(config ServerConfig) (*Server, error) {
+	if config.Listen == nil {
+		return nil, fmt.Errorf("listen function is nil")
+	}
+	if config.ReadFile == nil && config.WriteFile == nil {
+		return nil, fmt.Errorf("neither reads nor writes are allowed")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Retries == 0 {
+		config.Retries = DefaultRetries
+	}
+	if config.MaxBlockSize == 0 {
+		config.MaxBlockSize = MaxBlockSize
+	}
+	if config.MaxBlockSize < DefaultBlockSize || config.MaxBlockSize > MaxBlockSize {
+		return nil, fmt.Errorf("invalid maximum block size %d (must be %d to %d)", config.MaxBlockSize, DefaultBlockSize, MaxBlockSize)
+	}
+
+	return &Server{
+		config:    config,
+		listeners: make(map[net.PacketConn]struct{}),
+		transfers: make(map[net.PacketConn]struct{}),
+	}, nil
+}
+
+// Serve answers the requests received on conn, usually bound to Port,
+// until conn or the server is closed. It returns nil once the server is
+// closed.
+func (s *Server) Serve(conn net.PacketConn) error {
+	if !s.track(s.listeners, conn) {
+		return fmt.Errorf("server closed")
+	}
+	defer s.untrack(s.listeners, conn)
+
+	buf := make([]byte, headerLength+MaxBlockSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		req, err := Parse(buf[:n])
+		if err != nil || (req.Opcode != OpReadRequest && req.Opcode != OpWriteRequest) {
+			reply(conn, from, &Error{ErrIllegalOperation, "expected RRQ or WRQ"})
+			continue
+		}
+
+		tc, err := s.config.Listen(0)
+		if err != nil {
+			reply(conn, from, &Error{ErrNotDefined, "no transfer port available"})
+			continue
+		}
+		if !s.track(s.transfers, tc) {
+			tc.Close()
+			return nil
+		}
+		s.wg.Add(1)
+		go s.serveTransfer(tc, from, req)
+	}
+}
+
+// Close stops serving: it closes the connections Serve was given and
+// those of transfers in progress, and waits for the transfers to end.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.listeners {
+		conn.Close()
+	}
+	for conn := range s.transfers {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// track adds a connection to a set Close closes, unless the server is
+// closed.
+func (s *Server) track(set map[net.PacketConn]struct{}, conn net.PacketConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	set[conn] = struct{}{}
+	return true
+}
+
+// untrack removes a connection from a set.
+func (s *Server) untrack(set map[net.PacketConn]struct{}, conn net.PacketConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(set, conn)
+}
+
+// serveTransfer runs a transfer for a request.
+func (s *Server) serveTransfer(conn net.PacketConn, peer net.Addr, req *Packet) {
+	defer s.wg.Done()
+	defer func() {
+		s.untrack(s.transfers, conn)
+		conn.Close()
+	}()
+
+	t := newTransfer(conn, peer, true, s.config.Timeout, s.config.Retries)
+	var n int64
+	var err error
+	if req.Mode != ModeOctet && req.Mode != ModeNetASCII {
+		err = t.abort(&Error{ErrIllegalOperation, "unsupported mode " + req.Mode})
+	} else if req.Opcode == OpReadRequest {
+		n, err = s.serveRead(t, req)
+	} else {
+		n, err = s.serveWrite(t, req)
+	}
+
+	if s.config.OnTransfer != nil {
+		s.config.OnTransfer(Transfer{
+			Opcode:    req.Opcode,
+			Filename:  req.Filename,
+			Peer:      peer,
+			BlockSize: t.blockSize,
+			Bytes:     n,
+			Err:       err,
+		})
+	}
+}
+
+// serveRead sends a file to a client.
+func (s *Server) serveRead(t *transfer, req *Packet) (int64, error) {
+	if s.config.ReadFile == nil {
+		return 0, t.abort(&Error{ErrAccessViolation, "reads are not allowed"})
+	}
+	f, err := s.config.ReadFile(req.Filename)
+	if err != nil {
+		return 0, t.abort(fileError(err))
+	}
+	defer f.Close()
+
+	// Acknowledged options are answered with ACK 0 before data is sent
+	if oack := s.negotiate(t, req.Options); oack != nil {
+		if err := t.send(oack); err != nil {
+			return 0, err
+		}
+		for {
+			pkt, err := t.receive()
+			if err != nil {
+				return 0, err
+			}
+			if pkt.Opcode == OpAck && pkt.Block == 0 {
+				break
+			}
+		}
+	}
+	return t.sendData(f)
+}
+
+// serveWrite receives a file from a client.
+func (s *Server) serveWrite(t *transfer, req *Packet) (int64, error) {
+	if s.config.WriteFile == nil {
+		return 0, t.abort(&Error{ErrAccessViolation, "writes are not allowed"})
+	}
+	f, err := s.config.WriteFile(req.Filename)
+	if err != nil {
+		return 0, t.abort(fileError(err))
+	}
+
+	// The OACK, if options are acknowledged, stands for ACK 0
+	ack := s.negotiate(t, req.Options)
+	if ack == nil {
+		ack = &Packet{Opcode: OpAck, Block: 0}
+	}
+	if err := t.send(ack); err != nil {
+		f.Close()
+		return 0, err
+	}
+
+	n, err := t.receiveData(f, nil)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// negotiate applies the options of a request the server accepts, and
+// returns the OACK acknowledging them, or nil if it accepts none. A block
+// size larger than MaxBlockSize is lowered to it; unknown options and
+// invalid values are ignored.
+func (s *Server) negotiate(t *transfer, options map[string]string) *Packet {
+	acked := make(map[string]string)
+	if value, ok := options[OptionBlockSize]; ok {
+		if size, ok := parseBlockSize(value); ok {
+			size = min(size, s.config.MaxBlockSize)
+			t.blockSize = size
+			acked[OptionBlockSize] = strconv.Itoa(size)
+		}
+	}
+	if value, ok := options[OptionTimeout]; ok {
+		if timeout, ok := parseTimeout(value); ok {
+			t.timeout = timeout
+			acked[OptionTimeout] = value
+		}
+	}
+	if len(acked) == 0 {
+		return nil
+	}
+	return &Packet{Opcode: OpOptionAck, Options: acked}
+}
+
+// fileError returns the TFTP error for a failure to open a file.
+func fileError(err error) *Error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return &Error{ErrFileNotFound, "file not found"}
+	case errors.Is(err, fs.ErrPermission):
+		return &Error{ErrAccessViolation, "access violation"}
+	case errors.Is(err, fs.ErrExist):
+		return &Error{ErrFileExists, "file already exists"}
+	default:
+		return &Error{ErrNotDefined, err.Error()}
+	}
+}
+
+// reply sends an ERROR packet from a server's request port.
+func reply(conn net.PacketConn, to net.Addr, err *Error) {
+	if data, serr := errorPacket(err).Serialize(); serr == nil {
+		conn.WriteTo(data, to)
+	}
+}
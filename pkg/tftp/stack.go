@@ -0,0 +1,17 @@
+package tftp
+
+import (
+	"net"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// StackListener returns a ListenFunc that opens connections on addr with
+// udp.Listen, bound to their ports with demux and sending with send.
+// Closing a connection unbinds its port.
+func StackListener(demux *udp.Demultiplexer, addr common.IPv4Address, send func(*udp.Packet, udp.Address) error) ListenFunc {
+	return func(port uint16) (net.PacketConn, error) {
+		return udp.Listen(demux, addr, port, send)
+	}
+}
@@ -0,0 +1,235 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultTimeout is how long a transfer waits for a reply before
+	// retransmitting.
+	DefaultTimeout = time.Second
+
+	// DefaultRetries is how many times a packet is retransmitted before a
+	// transfer gives up.
+	DefaultRetries = 5
+
+	// maxTimeoutOption is the largest timeout option value, in seconds.
+	maxTimeoutOption = 255
+)
+
+// ErrTimeout is returned when a peer stops answering.
+var ErrTimeout = errors.New("transfer timed out")
+
+// ListenFunc opens a UDP connection on a local port, or on an ephemeral
+// port if port is 0. StackListener returns one for the stack's UDP sockets.
+type ListenFunc func(port uint16) (net.PacketConn, error)
+
+// transfer is one side of a transfer: the lock-step exchange of packets
+// between its connection and the peer's transfer identifier.
+type transfer struct {
+	conn      net.PacketConn
+	peer      net.Addr
+	locked    bool // Whether the peer's port is known
+	blockSize int
+	timeout   time.Duration
+	retries   int
+
+	last     []byte    // Last packet sent, retransmitted on timeout
+	deadline time.Time // When last is retransmitted
+	buf      []byte
+}
+
+func newTransfer(conn net.PacketConn, peer net.Addr, locked bool, timeout time.Duration, retries int) *transfer {
+	return &transfer{
+		conn:      conn,
+		peer:      peer,
+		locked:    locked,
+		blockSize: DefaultBlockSize,
+		timeout:   timeout,
+		retries:   retries,
+		deadline:  time.Now().Add(timeout),
+		buf:       make([]byte, headerLength+MaxBlockSize),
+	}
+}
+
+// send sends a packet to the peer, and keeps it to retransmit.
+func (t *transfer) send(pkt *Packet) error {
+	data, err := pkt.Serialize()
+	if err != nil {
+		return err
+	}
+	t.last = data
+	t.deadline = time.Now().Add(t.timeout)
+	if _, err := t.conn.WriteTo(data, t.peer); err != nil {
+		return fmt.Errorf("failed to send %s: %w", pkt.Opcode, err)
+	}
+	return nil
+}
+
+// abort tells the peer of an error, once, and returns it.
+func (t *transfer) abort(err *Error) error {
+	if data, serr := errorPacket(err).Serialize(); serr == nil {
+		t.conn.WriteTo(data, t.peer)
+	}
+	return err
+}
+
+// receive waits for the next packet from the peer, retransmitting the last
+// packet sent each time the timeout passes without a reply. Packets from
+// other ports are answered with an unknown transfer ID error and dropped;
+// an ERROR packet from the peer is returned as an *Error.
+//
+// The timeout runs from when the last packet was sent, so packets the
+// caller ignores, such as duplicate ACKs, do not put off retransmission.
+func (t *transfer) receive() (*Packet, error) {
+	for retries := 0; ; {
+		if err := t.conn.SetReadDeadline(t.deadline); err != nil {
+			return nil, err
+		}
+		n, from, err := t.conn.ReadFrom(t.buf)
+		if err != nil {
+			var ne net.Error
+			if !errors.As(err, &ne) || !ne.Timeout() {
+				return nil, err
+			}
+			if retries == t.retries {
+				return nil, fmt.Errorf("%w: no reply from %s", ErrTimeout, t.peer)
+			}
+			retries++
+			t.deadline = time.Now().Add(t.timeout)
+			if t.last != nil {
+				t.conn.WriteTo(t.last, t.peer)
+			}
+			continue
+		}
+
+		if !t.fromPeer(from) {
+			if data, err := errorPacket(&Error{ErrUnknownTransferID, "unknown transfer ID"}).Serialize(); err == nil {
+				t.conn.WriteTo(data, from)
+			}
+			continue
+		}
+
+		pkt, err := Parse(t.buf[:n])
+		if err != nil {
+			return nil, t.abort(&Error{ErrIllegalOperation, err.Error()})
+		}
+		if pkt.Opcode == OpError {
+			return nil, &Error{Code: pkt.ErrorCode, Message: pkt.ErrorMessage}
+		}
+		return pkt, nil
+	}
+}
+
+// fromPeer reports whether a packet is from the peer. Until the peer's
+// port is known, any port of the peer's address is taken as it: a server
+// answers a request from a port of its own.
+func (t *transfer) fromPeer(from net.Addr) bool {
+	if t.locked {
+		return from.String() == t.peer.String()
+	}
+	fromHost, _, err1 := net.SplitHostPort(from.String())
+	peerHost, _, err2 := net.SplitHostPort(t.peer.String())
+	if err1 != nil || err2 != nil || fromHost != peerHost {
+		return false
+	}
+	t.peer = from
+	t.locked = true
+	return true
+}
+
+// sendData sends r block by block, each once the previous is acknowledged,
+// and returns the number of bytes sent.
+func (t *transfer) sendData(r io.Reader) (int64, error) {
+	data := make([]byte, t.blockSize)
+	var total int64
+	for block := uint16(1); ; block++ {
+		n, err := io.ReadFull(r, data)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return total, t.abort(&Error{ErrNotDefined, "read failed: " + err.Error()})
+		}
+		if err := t.send(&Packet{Opcode: OpData, Block: block, Data: data[:n]}); err != nil {
+			return total, err
+		}
+
+		// Duplicate acknowledgments of the previous block are dropped, not
+		// answered, or every block would be sent twice from then on (the
+		// Sorcerer's Apprentice bug, RFC 1123 Section 4.2.3.1)
+		for {
+			pkt, err := t.receive()
+			if err != nil {
+				return total, err
+			}
+			if pkt.Opcode != OpAck {
+				return total, t.abort(&Error{ErrIllegalOperation, "expected ACK, got " + pkt.Opcode.String()})
+			}
+			if pkt.Block == block {
+				break
+			}
+		}
+
+		total += int64(n)
+		if n < t.blockSize {
+			return total, nil
+		}
+	}
+}
+
+// receiveData writes the blocks received to w, acknowledging each, and
+// returns the number of bytes received. first is the first DATA packet if
+// it has been received already.
+func (t *transfer) receiveData(w io.Writer, first *Packet) (int64, error) {
+	var total int64
+	expected := uint16(1)
+	pkt := first
+	for {
+		if pkt == nil {
+			var err error
+			if pkt, err = t.receive(); err != nil {
+				return total, err
+			}
+		}
+		if pkt.Opcode != OpData {
+			return total, t.abort(&Error{ErrIllegalOperation, "expected DATA, got " + pkt.Opcode.String()})
+		}
+
+		switch pkt.Block {
+		case expected:
+			if len(pkt.Data) > t.blockSize {
+				return total, t.abort(&Error{ErrIllegalOperation, "block larger than the block size"})
+			}
+			if _, err := w.Write(pkt.Data); err != nil {
+				return total, t.abort(&Error{ErrDiskFull, "write failed: " + err.Error()})
+			}
+			total += int64(len(pkt.Data))
+			if err := t.send(&Packet{Opcode: OpAck, Block: expected}); err != nil {
+				return total, err
+			}
+			if len(pkt.Data) < t.blockSize {
+				return total, nil
+			}
+			expected++
+		case expected - 1:
+			// Our acknowledgment was lost; send it again
+			t.conn.WriteTo(t.last, t.peer)
+		}
+		pkt = nil
+	}
+}
+
+// parseBlockSize parses a blksize option value.
+func parseBlockSize(value string) (int, bool) {
+	size, err := strconv.Atoi(value)
+	return size, err == nil && size >= MinBlockSize && size <= MaxBlockSize
+}
+
+// parseTimeout parses a timeout option value.
+func parseTimeout(value string) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(value)
+	return time.Duration(seconds) * time.Second, err == nil && seconds >= 1 && seconds <= maxTimeoutOption
+}
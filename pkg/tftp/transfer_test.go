@@ -0,0 +1,457 @@
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+var (
+	serverIP = common.IPv4Address{192, 168, 1, 1}
+	clientIP = common.IPv4Address{192, 168, 1, 10}
+)
+
+// network connects two hosts' UDP demultiplexers, dropping the datagrams
+// drop selects.
+type network struct {
+	hosts map[common.IPv4Address]*udp.Demultiplexer
+	drop  func(from common.IPv4Address, pkt *Packet) bool
+	sent  atomic.Int64
+}
+
+func newNetwork() *network {
+	return &network{hosts: map[common.IPv4Address]*udp.Demultiplexer{
+		serverIP: udp.NewDemultiplexer(),
+		clientIP: udp.NewDemultiplexer(),
+	}}
+}
+
+// listen returns the ListenFunc of a host.
+func (n *network) listen(host common.IPv4Address) ListenFunc {
+	return StackListener(n.hosts[host], host, func(datagram *udp.Packet, to udp.Address) error {
+		n.sent.Add(1)
+		if n.drop != nil {
+			if pkt, err := Parse(datagram.Data); err == nil && n.drop(host, pkt) {
+				return nil
+			}
+		}
+		// Datagrams to closed ports are lost, as on a real network
+		n.hosts[to.IP].Deliver(datagram, udp.Address{IP: host, Port: datagram.SourcePort})
+		return nil
+	})
+}
+
+// files is an in-memory file store for a server.
+type files struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (f *files) read(name string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.files[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f *files) write(name string) (io.WriteCloser, error) {
+	return &file{files: f, name: name}, nil
+}
+
+// file is a file being written; it is stored when closed.
+type file struct {
+	bytes.Buffer
+	files *files
+	name  string
+}
+
+func (f *file) Close() error {
+	f.files.mu.Lock()
+	defer f.files.mu.Unlock()
+	f.files.files[f.name] = f.Bytes()
+	return nil
+}
+
+func (f *files) get(name string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.files[name]
+}
+
+// testServer runs a server on serverIP:69 of a network and returns its
+// file store and the transfers it finished.
+func testServer(t *testing.T, n *network, config ServerConfig) (*files, <-chan Transfer) {
+	t.Helper()
+
+	store := &files{files: make(map[string][]byte)}
+	transfers := make(chan Transfer, 16)
+	config.Listen = n.listen(serverIP)
+	config.ReadFile = store.read
+	config.WriteFile = store.write
+	config.OnTransfer = func(tr Transfer) { transfers <- tr }
+	if config.Timeout == 0 {
+		config.Timeout = 20 * time.Millisecond
+	}
+
+	s, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	conn, err := config.Listen(Port)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go s.Serve(conn)
+	t.Cleanup(func() { s.Close() })
+	return store, transfers
+}
+
+func testClient(t *testing.T, n *network, config ClientConfig) *Client {
+	t.Helper()
+
+	if config.Timeout == 0 {
+		config.Timeout = 20 * time.Millisecond
+	}
+	c, err := NewClientWithConfig(n.listen(clientIP), config)
+	if err != nil {
+		t.Fatalf("NewClientWithConfig() error = %v", err)
+	}
+	return c
+}
+
+// pattern returns n bytes of test data.
+func pattern(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+var serverAddr = udp.Address{IP: serverIP, Port: Port}
+
+func TestTransfer(t *testing.T) {
+	sizes := []int{0, 1, 511, 512, 513, 4096, 10000}
+	for _, blockSize := range []int{0, 1024} {
+		for _, size := range sizes {
+			t.Run(fmt.Sprintf("blksize %d size %d", blockSize, size), func(t *testing.T) {
+				n := newNetwork()
+				store, transfers := testServer(t, n, ServerConfig{})
+				c := testClient(t, n, ClientConfig{BlockSize: blockSize})
+				data := pattern(size)
+
+				written, err := c.Put(serverAddr, "file", bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("Put() error = %v", err)
+				}
+				if written != int64(size) {
+					t.Errorf("Put() = %d bytes, want %d", written, size)
+				}
+				tr := <-transfers
+				if tr.Err != nil || tr.Opcode != OpWriteRequest || tr.Bytes != int64(size) {
+					t.Errorf("server transfer = %+v, want a %d-byte WRQ", tr, size)
+				}
+				if !bytes.Equal(store.get("file"), data) {
+					t.Fatalf("stored file differs from the one put")
+				}
+
+				var buf bytes.Buffer
+				read, err := c.Get(serverAddr, "file", &buf)
+				if err != nil {
+					t.Fatalf("Get() error = %v", err)
+				}
+				if read != int64(size) || !bytes.Equal(buf.Bytes(), data) {
+					t.Errorf("Get() = %d bytes, want the %d bytes put", read, size)
+				}
+				if tr := <-transfers; tr.Err != nil || tr.Opcode != OpReadRequest {
+					t.Errorf("server transfer = %+v, want a successful RRQ", tr)
+				}
+			})
+		}
+	}
+}
+
+func TestTransferWithLoss(t *testing.T) {
+	n := newNetwork()
+	var count atomic.Int64
+	n.drop = func(from common.IPv4Address, pkt *Packet) bool {
+		return count.Add(1)%4 == 0
+	}
+	store, _ := testServer(t, n, ServerConfig{})
+	c := testClient(t, n, ClientConfig{BlockSize: 256, Retries: 10})
+	data := pattern(20 * 256)
+
+	if _, err := c.Put(serverAddr, "lossy", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	// The server only knows the transfer is done once its last ACK is sent
+	deadline := time.Now().Add(time.Second)
+	for !bytes.Equal(store.get("lossy"), data) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !bytes.Equal(store.get("lossy"), data) {
+		t.Fatalf("stored file differs from the one put")
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.Get(serverAddr, "lossy", &buf); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Get() returned different data")
+	}
+}
+
+func TestDuplicateAcksAreNotAnswered(t *testing.T) {
+	// The peer acknowledges each block's predecessor again before the
+	// block itself, as a receiver does when its ACK crosses a
+	// retransmission. The sender must answer only the new ACK, or every
+	// block would be sent twice from then on. The timeout is long enough
+	// that nothing is retransmitted on its own, so each packet the peer
+	// reads must be the next block
+	n := newNetwork()
+	conn, err := n.listen(serverIP)(0)
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	defer conn.Close()
+	peerConn, err := n.listen(clientIP)(0)
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	defer peerConn.Close()
+
+	data := pattern(4 * DefaultBlockSize)
+	tr := newTransfer(conn, peerConn.LocalAddr(), true, time.Minute, 0)
+	done := make(chan error, 1)
+	go func() {
+		_, err := tr.sendData(bytes.NewReader(data))
+		done <- err
+	}()
+
+	ack := func(block uint16) {
+		b, _ := (&Packet{Opcode: OpAck, Block: block}).Serialize()
+		peerConn.WriteTo(b, conn.LocalAddr())
+	}
+	buf := make([]byte, headerLength+DefaultBlockSize)
+	peerConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for block := uint16(1); block <= 5; block++ {
+		nr, _, err := peerConn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom() error = %v", err)
+		}
+		pkt, err := Parse(buf[:nr])
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if pkt.Opcode != OpData || pkt.Block != block {
+			t.Fatalf("received %s, want DATA block %d", pkt, block)
+		}
+		ack(block - 1)
+		ack(block - 1)
+		ack(block)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("sendData() error = %v", err)
+	}
+}
+
+func TestOptionNegotiation(t *testing.T) {
+	n := newNetwork()
+	var largest atomic.Int64
+	n.drop = func(from common.IPv4Address, pkt *Packet) bool {
+		if pkt.Opcode == OpData && int64(len(pkt.Data)) > largest.Load() {
+			largest.Store(int64(len(pkt.Data)))
+		}
+		return false
+	}
+	store, transfers := testServer(t, n, ServerConfig{MaxBlockSize: 1024})
+	store.files["f"] = pattern(5000)
+	c := testClient(t, n, ClientConfig{BlockSize: 4096, ServerTimeout: 2 * time.Second})
+
+	var buf bytes.Buffer
+	if _, err := c.Get(serverAddr, "f", &buf); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), store.get("f")) {
+		t.Errorf("Get() returned different data")
+	}
+	if tr := <-transfers; tr.BlockSize != 1024 {
+		t.Errorf("BlockSize = %d, want 1024 (the server's maximum)", tr.BlockSize)
+	}
+	if got := largest.Load(); got != 1024 {
+		t.Errorf("largest block = %d bytes, want 1024", got)
+	}
+}
+
+func TestAcceptOptions(t *testing.T) {
+	requested := map[string]string{OptionBlockSize: "1024", OptionTimeout: "3"}
+	tests := []struct {
+		name  string
+		acked map[string]string
+		want  int // Block size, or 0 for an error
+	}{
+		{"all", map[string]string{OptionBlockSize: "1024", OptionTimeout: "3"}, 1024},
+		{"lower block size", map[string]string{OptionBlockSize: "600"}, 600},
+		{"none", nil, DefaultBlockSize},
+		{"higher block size", map[string]string{OptionBlockSize: "2048"}, 0},
+		{"invalid block size", map[string]string{OptionBlockSize: "4"}, 0},
+		{"changed timeout", map[string]string{OptionTimeout: "5"}, 0},
+		{"unrequested", map[string]string{"tsize": "100"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := newNetwork().listen(clientIP)(0)
+			if err != nil {
+				t.Fatalf("listen() error = %v", err)
+			}
+			defer conn.Close()
+			tr := newTransfer(conn, serverAddr, true, time.Second, 1)
+
+			err = tr.acceptOptions(tt.acked, requested)
+			if tt.want == 0 {
+				var terr *Error
+				if !errors.As(err, &terr) || terr.Code != ErrOptionRefused {
+					t.Errorf("acceptOptions() error = %v, want option refused", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("acceptOptions() error = %v", err)
+			}
+			if tr.blockSize != tt.want {
+				t.Errorf("blockSize = %d, want %d", tr.blockSize, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransferErrors(t *testing.T) {
+	// Files can be read but not written
+	n := newNetwork()
+	store := &files{files: map[string][]byte{}}
+	s, err := NewServer(ServerConfig{Listen: n.listen(serverIP), ReadFile: store.read})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	conn, err := n.listen(serverIP)(Port)
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	go s.Serve(conn)
+	defer s.Close()
+	c := testClient(t, n, ClientConfig{Retries: 1})
+
+	var terr *Error
+	_, err = c.Get(serverAddr, "missing", io.Discard)
+	if !errors.As(err, &terr) || terr.Code != ErrFileNotFound {
+		t.Errorf("Get() error = %v, want file not found", err)
+	}
+	_, err = c.Put(serverAddr, "new", bytes.NewReader([]byte("x")))
+	if !errors.As(err, &terr) || terr.Code != ErrAccessViolation {
+		t.Errorf("Put() error = %v, want access violation", err)
+	}
+
+	// Nothing answers on another host
+	_, err = c.Get(udp.Address{IP: clientIP, Port: Port}, "f", io.Discard)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Get() error = %v, want %v", err, ErrTimeout)
+	}
+}
+
+func TestUnknownTransferID(t *testing.T) {
+	n := newNetwork()
+	conn, err := n.listen(clientIP)(0)
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	defer conn.Close()
+	stray, err := n.listen(serverIP)(0)
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	defer stray.Close()
+
+	peer := udp.Address{IP: serverIP, Port: 50000}
+	tr := newTransfer(conn, peer, true, 50*time.Millisecond, 0)
+
+	// A packet from another port of the peer's host is refused
+	data, _ := (&Packet{Opcode: OpAck, Block: 1}).Serialize()
+	stray.WriteTo(data, conn.LocalAddr())
+	if _, err := tr.receive(); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("receive() error = %v, want %v", err, ErrTimeout)
+	}
+
+	buf := make([]byte, 512)
+	stray.SetReadDeadline(time.Now().Add(time.Second))
+	nr, _, err := stray.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	pkt, err := Parse(buf[:nr])
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if pkt.Opcode != OpError || pkt.ErrorCode != ErrUnknownTransferID {
+		t.Errorf("reply = %s, want unknown transfer ID error", pkt)
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	listen := newNetwork().listen(serverIP)
+	store := &files{files: map[string][]byte{}}
+	tests := []struct {
+		name   string
+		config ServerConfig
+	}{
+		{"no listen", ServerConfig{ReadFile: store.read}},
+		{"no files", ServerConfig{Listen: listen}},
+		{"block size too small", ServerConfig{Listen: listen, ReadFile: store.read, MaxBlockSize: 100}},
+		{"block size too large", ServerConfig{Listen: listen, ReadFile: store.read, MaxBlockSize: MaxBlockSize + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewServer(tt.config); err == nil {
+				t.Errorf("NewServer() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestNewClientWithConfig(t *testing.T) {
+	listen := newNetwork().listen(clientIP)
+	tests := []struct {
+		name   string
+		config ClientConfig
+	}{
+		{"block size too small", ClientConfig{BlockSize: 4}},
+		{"block size too large", ClientConfig{BlockSize: MaxBlockSize + 1}},
+		{"negative timeout", ClientConfig{Timeout: -time.Second}},
+		{"fractional server timeout", ClientConfig{ServerTimeout: 1500 * time.Millisecond}},
+		{"server timeout too long", ClientConfig{ServerTimeout: 256 * time.Second}},
+		{"negative retries", ClientConfig{Retries: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClientWithConfig(listen, tt.config); err == nil {
+				t.Errorf("NewClientWithConfig() error = nil, want error")
+			}
+		})
+	}
+}
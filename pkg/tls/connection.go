@@ -6,56 +6,142 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
+	"time"
+)
+
+// State is the handshake state of a connection.
+type State int32
+
+const (
+	StateNew         State = iota // Handshake not started
+	StateHandshaking              // Handshake in progress
+	StateEstablished              // Handshake complete, application data flows
+	StateFailed                   // Handshake failed; the connection is unusable
+	StateClosed                   // Connection closed
 )
 
 // Conn represents a TLS connection wrapping a network connection.
+//
+// Records pass through a record layer that validates their framing and
+// follows the plaintext handshake; the cryptography is delegated to
+// crypto/tls. The handshake runs on the first call to Handshake, Read or
+// Write.
 type Conn struct {
 	tlsConn *tls.Conn
 	config  *Config
+	records *recordLayer
+	state   atomic.Int32
 }
 
 // Server wraps a connection with TLS as a server.
 func Server(conn net.Conn, config *Config) *Conn {
-	stdConfig := config.ToStdTLSConfig()
-	tlsConn := tls.Server(conn, stdConfig)
-
+	records := newRecordLayer(conn)
 	return &Conn{
-		tlsConn: tlsConn,
+		tlsConn: tls.Server(records, config.ToStdTLSConfig()),
 		config:  config,
+		records: records,
 	}
 }
 
 // Client wraps a connection with TLS as a client.
 func Client(conn net.Conn, config *Config) *Conn {
-	stdConfig := config.ToStdTLSConfig()
-	tlsConn := tls.Client(conn, stdConfig)
-
+	records := newRecordLayer(conn)
 	return &Conn{
-		tlsConn: tlsConn,
+		tlsConn: tls.Client(records, config.ToStdTLSConfig()),
 		config:  config,
+		records: records,
 	}
 }
 
-// Handshake performs the TLS handshake.
+// WrapConn wraps a client connection with TLS and completes the handshake.
+// The connection is closed if the handshake fails.
+func WrapConn(conn net.Conn, config *Config) (*Conn, error) {
+	c := Client(conn, config)
+	if err := c.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Handshake performs the TLS handshake. It is safe to call more than once;
+// later calls return the result of the first.
 func (c *Conn) Handshake() error {
-	return c.tlsConn.Handshake()
+	c.state.CompareAndSwap(int32(StateNew), int32(StateHandshaking))
+
+	if err := c.tlsConn.Handshake(); err != nil {
+		c.state.CompareAndSwap(int32(StateHandshaking), int32(StateFailed))
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+
+	c.state.CompareAndSwap(int32(StateHandshaking), int32(StateEstablished))
+	return nil
+}
+
+// State returns the handshake state of the connection.
+func (c *Conn) State() State {
+	return State(c.state.Load())
+}
+
+// RecordStats returns the records exchanged so far.
+func (c *Conn) RecordStats() RecordStats {
+	return c.records.stats()
 }
 
 // Read reads data from the TLS connection.
 func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
 	return c.tlsConn.Read(b)
 }
 
 // Write writes data to the TLS connection.
 func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
 	return c.tlsConn.Write(b)
 }
 
 // Close closes the TLS connection.
 func (c *Conn) Close() error {
+	c.state.Store(int32(StateClosed))
 	return c.tlsConn.Close()
 }
 
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.records.Conn
+}
+
+// LocalAddr returns the local address of the underlying connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.tlsConn.LocalAddr()
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.tlsConn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the underlying
+// connection; they also bound the handshake.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.tlsConn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.tlsConn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.tlsConn.SetWriteDeadline(t)
+}
+
 // ConnectionState returns the TLS connection state.
 func (c *Conn) ConnectionState() tls.ConnectionState {
 	return c.tlsConn.ConnectionState()
@@ -127,6 +213,47 @@ func (c *Conn) SecureRead(n int) ([]byte, error) {
 // String returns a description of the TLS connection.
 func (c *Conn) String() string {
 	state := c.tlsConn.ConnectionState()
-	return fmt.Sprintf("TLS{Version=%s, CipherSuite=%s, ServerName=%s}",
-		TLSVersion(state.Version), CipherSuite(state.CipherSuite), state.ServerName)
+	return fmt.Sprintf("TLS{State=%s, Version=%s, CipherSuite=%s, ServerName=%s}",
+		c.State(), TLSVersion(state.Version), CipherSuite(state.CipherSuite), state.ServerName)
+}
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "NEW"
+	case StateHandshaking:
+		return "HANDSHAKING"
+	case StateEstablished:
+		return "ESTABLISHED"
+	case StateFailed:
+		return "FAILED"
+	case StateClosed:
+		return "CLOSED"
+	default:
+		return fmt.Sprintf("State(%d)", int32(s))
+	}
+}
+
+// listener is a net.Listener whose connections are TLS server connections.
+type listener struct {
+	net.Listener
+	config *Config
+}
+
+// WrapListener returns a listener that wraps each accepted connection with
+// TLS as a server. The handshake runs on the connection's first Read or
+// Write, in the goroutine serving it, so a slow client does not hold up
+// Accept.
+func WrapListener(l net.Listener, config *Config) net.Listener {
+	return &listener{Listener: l, config: config}
+}
+
+// Accept waits for the next connection and wraps it with TLS.
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return Server(conn, l.config), nil
 }
@@ -0,0 +1,261 @@
+package tls
+
+import (
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testCertOnce sync.Once
+	testCertPEM  []byte
+	testKeyPEM   []byte
+	testCertErr  error
+)
+
+// testConfigs returns a server config with a self-signed certificate for
+// "localhost" and a client config that trusts it.
+func testConfigs(t *testing.T) (server, client *Config) {
+	t.Helper()
+
+	testCertOnce.Do(func() {
+		testCertPEM, testKeyPEM, testCertErr = GenerateSelfSignedCert("localhost")
+	})
+	if testCertErr != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", testCertErr)
+	}
+
+	cert, err := LoadCertificate(testCertPEM, testKeyPEM)
+	if err != nil {
+		t.Fatalf("LoadCertificate() error = %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(testCertPEM)
+
+	server = DefaultConfig()
+	server.Certificates = append(server.Certificates, cert)
+	client = DefaultConfig()
+	client.RootCAs = roots
+	client.ServerName = "localhost"
+	return server, client
+}
+
+// loopbackConns returns the two ends of a loopback TCP connection. Unlike
+// net.Pipe it buffers writes, as the handshake expects: a client aborting
+// on a bad certificate sends its alert while the server is still writing.
+func loopbackConns(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	b, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	a, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	a.SetDeadline(deadline)
+	b.SetDeadline(deadline)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestHandshake(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    TLSVersion
+		serverSent []HandshakeType
+	}{
+		{
+			name:    "TLS 1.2",
+			version: VersionTLS12,
+			serverSent: []HandshakeType{
+				HandshakeServerHello, HandshakeCertificate,
+				HandshakeServerKeyExchange, HandshakeServerHelloDone,
+			},
+		},
+		{
+			name:       "TLS 1.3",
+			version:    VersionTLS13,
+			serverSent: []HandshakeType{HandshakeServerHello},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig, clientConfig := testConfigs(t)
+			serverConfig.MaxVersion = tt.version
+			serverConfig.NextProtos = []string{"http/1.1"}
+			clientConfig.NextProtos = []string{"http/1.1"}
+
+			a, b := loopbackConns(t)
+			server := Server(a, serverConfig)
+			if server.State() != StateNew {
+				t.Errorf("State() = %v, want NEW", server.State())
+			}
+
+			// The server handshakes implicitly on its first Read
+			received := make(chan string, 1)
+			go func() {
+				buf := make([]byte, 64)
+				n, err := server.Read(buf)
+				if err != nil {
+					t.Errorf("server Read() error = %v", err)
+				}
+				received <- string(buf[:n])
+			}()
+
+			client, err := WrapConn(b, clientConfig)
+			if err != nil {
+				t.Fatalf("WrapConn() error = %v", err)
+			}
+			if _, err := client.Write([]byte("hello")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if got := <-received; got != "hello" {
+				t.Errorf("server received %q, want %q", got, "hello")
+			}
+
+			if client.State() != StateEstablished || server.State() != StateEstablished {
+				t.Errorf("states = %v/%v, want ESTABLISHED", client.State(), server.State())
+			}
+			if client.GetVersion() != tt.version {
+				t.Errorf("GetVersion() = %v, want %v", client.GetVersion(), tt.version)
+			}
+			if client.GetNegotiatedProtocol() != "http/1.1" {
+				t.Errorf("GetNegotiatedProtocol() = %q, want http/1.1", client.GetNegotiatedProtocol())
+			}
+
+			stats := server.RecordStats()
+			if len(stats.HandshakeRead) == 0 || stats.HandshakeRead[0] != HandshakeClientHello {
+				t.Errorf("server HandshakeRead = %v, want client_hello first", stats.HandshakeRead)
+			}
+			if !equalTypes(stats.HandshakeWritten, tt.serverSent) {
+				t.Errorf("server HandshakeWritten = %v, want %v", stats.HandshakeWritten, tt.serverSent)
+			}
+			if stats.RecordsRead == 0 || client.RecordStats().RecordsWritten != stats.RecordsRead {
+				t.Errorf("client wrote %d records, server read %d", client.RecordStats().RecordsWritten, stats.RecordsRead)
+			}
+
+			client.Close()
+			if client.State() != StateClosed {
+				t.Errorf("State() after Close = %v, want CLOSED", client.State())
+			}
+		})
+	}
+}
+
+func equalTypes(a, b []HandshakeType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHandshakeUntrustedCertificate(t *testing.T) {
+	serverConfig, clientConfig := testConfigs(t)
+	clientConfig.RootCAs = x509.NewCertPool()
+
+	a, b := loopbackConns(t)
+	server := Server(a, serverConfig)
+	go server.Handshake()
+
+	if _, err := WrapConn(b, clientConfig); err == nil {
+		t.Fatal("WrapConn() with untrusted certificate succeeded")
+	}
+}
+
+func TestServerRejectsPlaintext(t *testing.T) {
+	serverConfig, _ := testConfigs(t)
+
+	a, b := loopbackConns(t)
+	server := Server(a, serverConfig)
+	go io.WriteString(b, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	err := server.Handshake()
+	if !errors.Is(err, ErrRecordType) {
+		t.Errorf("Handshake() error = %v, want ErrRecordType", err)
+	}
+	if server.State() != StateFailed {
+		t.Errorf("State() = %v, want FAILED", server.State())
+	}
+	if _, err := server.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() after failed handshake succeeded")
+	}
+}
+
+// pipeListener hands out the server ends of in-memory connections.
+type pipeListener struct {
+	conns chan net.Conn
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	c, ok := <-l.conns
+	if !ok {
+		return nil, io.EOF
+	}
+	return c, nil
+}
+
+func (l *pipeListener) Close() error   { close(l.conns); return nil }
+func (l *pipeListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestWrapListener(t *testing.T) {
+	serverConfig, clientConfig := testConfigs(t)
+
+	pl := &pipeListener{conns: make(chan net.Conn, 1)}
+	l := WrapListener(pl, serverConfig)
+
+	a, b := loopbackConns(t)
+	pl.conns <- a
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	go func() {
+		// Echo one message
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		conn.Write(buf[:n])
+	}()
+
+	client, err := WrapConn(b, clientConfig)
+	if err != nil {
+		t.Fatalf("WrapConn() error = %v", err)
+	}
+	client.Write([]byte("ping"))
+	reply, err := client.SecureRead(4)
+	if err != nil {
+		t.Fatalf("SecureRead() error = %v", err)
+	}
+	if string(reply) != "ping" {
+		t.Errorf("reply = %q, want %q", reply, "ping")
+	}
+
+	l.Close()
+	if _, err := l.Accept(); err == nil {
+		t.Error("Accept() after Close succeeded")
+	}
+}
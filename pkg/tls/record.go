@@ -0,0 +1,200 @@
+package tls
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ContentType is the type of a TLS record (RFC 8446 Section 5.1).
+type ContentType uint8
+
+const (
+	ContentChangeCipherSpec ContentType = 20
+	ContentAlert            ContentType = 21
+	ContentHandshake        ContentType = 22
+	ContentApplicationData  ContentType = 23
+)
+
+// HandshakeType is the type of a handshake message (RFC 8446 Section 4).
+type HandshakeType uint8
+
+const (
+	HandshakeHelloRequest        HandshakeType = 0
+	HandshakeClientHello         HandshakeType = 1
+	HandshakeServerHello         HandshakeType = 2
+	HandshakeNewSessionTicket    HandshakeType = 4
+	HandshakeEndOfEarlyData      HandshakeType = 5
+	HandshakeEncryptedExtensions HandshakeType = 8
+	HandshakeCertificate         HandshakeType = 11
+	HandshakeServerKeyExchange   HandshakeType = 12
+	HandshakeCertificateRequest  HandshakeType = 13
+	HandshakeServerHelloDone     HandshakeType = 14
+	HandshakeCertificateVerify   HandshakeType = 15
+	HandshakeClientKeyExchange   HandshakeType = 16
+	HandshakeFinished            HandshakeType = 20
+	HandshakeKeyUpdate           HandshakeType = 24
+)
+
+const (
+	// RecordHeaderLength is the size of the record header: content type,
+	// legacy version and payload length.
+	RecordHeaderLength = 5
+
+	// MaxPlaintextLength is the largest plaintext fragment (2^14 bytes).
+	MaxPlaintextLength = 1 << 14
+
+	// MaxRecordLength is the largest record payload accepted from a peer:
+	// a plaintext fragment plus the TLS 1.2 ciphertext expansion allowance,
+	// which also covers TLS 1.3's smaller one.
+	MaxRecordLength = MaxPlaintextLength + 2048
+
+	// handshakeHeaderLength is the size of a handshake message header:
+	// message type and 24-bit length.
+	handshakeHeaderLength = 4
+)
+
+var (
+	// ErrRecordType is returned for a record with an unknown content type,
+	// which usually means the peer is not speaking TLS.
+	ErrRecordType = errors.New("tls: unknown record type")
+
+	// ErrRecordVersion is returned for a record whose version is not 3.x.
+	ErrRecordVersion = errors.New("tls: invalid record version")
+
+	// ErrRecordOverflow is returned for a record longer than MaxRecordLength.
+	ErrRecordOverflow = errors.New("tls: record overflow")
+
+	// ErrEmptyRecord is returned for a handshake or alert record without
+	// payload, which RFC 8446 forbids.
+	ErrEmptyRecord = errors.New("tls: empty record")
+)
+
+// Record is a single TLS record.
+type Record struct {
+	Type    ContentType
+	Version TLSVersion // Legacy record version, 0x0301 or 0x0303 in practice
+	Payload []byte
+}
+
+// ParseRecordHeader validates a record header and returns the content
+// type, version and payload length.
+func ParseRecordHeader(header []byte) (ContentType, TLSVersion, int, error) {
+	if len(header) < RecordHeaderLength {
+		return 0, 0, 0, fmt.Errorf("record header too short: %d bytes", len(header))
+	}
+
+	typ := ContentType(header[0])
+	version := TLSVersion(binary.BigEndian.Uint16(header[1:3]))
+	length := int(binary.BigEndian.Uint16(header[3:5]))
+
+	if !typ.valid() {
+		return 0, 0, 0, fmt.Errorf("%w: %d", ErrRecordType, header[0])
+	}
+	if version>>8 != 3 {
+		return 0, 0, 0, fmt.Errorf("%w: 0x%04x", ErrRecordVersion, uint16(version))
+	}
+	if length > MaxRecordLength {
+		return 0, 0, 0, fmt.Errorf("%w: %d bytes", ErrRecordOverflow, length)
+	}
+	if length == 0 && (typ == ContentHandshake || typ == ContentAlert) {
+		return 0, 0, 0, fmt.Errorf("%w: %s", ErrEmptyRecord, typ)
+	}
+
+	return typ, version, length, nil
+}
+
+// ReadRecord reads and validates one record.
+func ReadRecord(r io.Reader) (*Record, error) {
+	var header [RecordHeaderLength]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	typ, version, length, err := ParseRecordHeader(header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return &Record{Type: typ, Version: version, Payload: payload}, nil
+}
+
+// Serialize encodes the record with its header.
+func (r *Record) Serialize() ([]byte, error) {
+	if len(r.Payload) > MaxRecordLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrRecordOverflow, len(r.Payload))
+	}
+
+	buf := make([]byte, RecordHeaderLength+len(r.Payload))
+	buf[0] = byte(r.Type)
+	binary.BigEndian.PutUint16(buf[1:3], uint16(r.Version))
+	binary.BigEndian.PutUint16(buf[3:5], uint16(len(r.Payload)))
+	copy(buf[RecordHeaderLength:], r.Payload)
+
+	return buf, nil
+}
+
+func (t ContentType) valid() bool {
+	return t >= ContentChangeCipherSpec && t <= ContentApplicationData
+}
+
+// String returns the name of the content type.
+func (t ContentType) String() string {
+	switch t {
+	case ContentChangeCipherSpec:
+		return "change_cipher_spec"
+	case ContentAlert:
+		return "alert"
+	case ContentHandshake:
+		return "handshake"
+	case ContentApplicationData:
+		return "application_data"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+// String returns the name of the handshake message type.
+func (t HandshakeType) String() string {
+	switch t {
+	case HandshakeHelloRequest:
+		return "hello_request"
+	case HandshakeClientHello:
+		return "client_hello"
+	case HandshakeServerHello:
+		return "server_hello"
+	case HandshakeNewSessionTicket:
+		return "new_session_ticket"
+	case HandshakeEndOfEarlyData:
+		return "end_of_early_data"
+	case HandshakeEncryptedExtensions:
+		return "encrypted_extensions"
+	case HandshakeCertificate:
+		return "certificate"
+	case HandshakeServerKeyExchange:
+		return "server_key_exchange"
+	case HandshakeCertificateRequest:
+		return "certificate_request"
+	case HandshakeServerHelloDone:
+		return "server_hello_done"
+	case HandshakeCertificateVerify:
+		return "certificate_verify"
+	case HandshakeClientKeyExchange:
+		return "client_key_exchange"
+	case HandshakeFinished:
+		return "finished"
+	case HandshakeKeyUpdate:
+		return "key_update"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
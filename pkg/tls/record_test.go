@@ -0,0 +1,104 @@
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestReadRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    *Record
+		wantErr error
+	}{
+		{
+			name:  "handshake",
+			input: []byte{22, 3, 1, 0, 3, 1, 2, 3},
+			want:  &Record{Type: ContentHandshake, Version: VersionTLS10, Payload: []byte{1, 2, 3}},
+		},
+		{
+			name:  "empty application data",
+			input: []byte{23, 3, 3, 0, 0},
+			want:  &Record{Type: ContentApplicationData, Version: VersionTLS12, Payload: []byte{}},
+		},
+		{name: "plain HTTP", input: []byte("GET / HTTP/1.1\r\n"), wantErr: ErrRecordType},
+		{name: "bad version", input: []byte{22, 2, 0, 0, 1, 0}, wantErr: ErrRecordVersion},
+		{name: "overflow", input: []byte{23, 3, 3, 0x48, 0x01}, wantErr: ErrRecordOverflow},
+		{name: "empty handshake", input: []byte{22, 3, 3, 0, 0}, wantErr: ErrEmptyRecord},
+		{name: "truncated header", input: []byte{22, 3}, wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated payload", input: []byte{22, 3, 3, 0, 4, 1}, wantErr: io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadRecord(bytes.NewReader(tt.input))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ReadRecord() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadRecord() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadRecord() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordSerialize(t *testing.T) {
+	record := &Record{Type: ContentAlert, Version: VersionTLS12, Payload: []byte{2, 40}}
+
+	data, err := record.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if want := []byte{21, 3, 3, 0, 2, 2, 40}; !bytes.Equal(data, want) {
+		t.Errorf("Serialize() = %v, want %v", data, want)
+	}
+
+	parsed, err := ReadRecord(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadRecord() error = %v", err)
+	}
+	if !reflect.DeepEqual(parsed, record) {
+		t.Errorf("round trip = %+v, want %+v", parsed, record)
+	}
+
+	record.Payload = make([]byte, MaxRecordLength+1)
+	if _, err := record.Serialize(); !errors.Is(err, ErrRecordOverflow) {
+		t.Errorf("Serialize() oversized error = %v, want ErrRecordOverflow", err)
+	}
+}
+
+func TestFollowHandshake(t *testing.T) {
+	var d recordDirection
+
+	// A ClientHello split across records, followed by two messages in one
+	hello := []byte{byte(HandshakeClientHello), 0, 0, 5, 1, 2, 3, 4, 5}
+	d.observe(ContentHandshake, hello[:2])
+	d.observe(ContentHandshake, hello[2:6])
+	d.observe(ContentHandshake, hello[6:])
+	d.observe(ContentHandshake, []byte{
+		byte(HandshakeCertificate), 0, 0, 1, 9,
+		byte(HandshakeServerHelloDone), 0, 0, 0,
+	})
+
+	// Nothing is visible once the direction is encrypted
+	d.observe(ContentChangeCipherSpec, []byte{1})
+	d.observe(ContentHandshake, []byte{byte(HandshakeFinished), 0, 0, 0})
+
+	want := []HandshakeType{HandshakeClientHello, HandshakeCertificate, HandshakeServerHelloDone}
+	if !reflect.DeepEqual(d.messages, want) {
+		t.Errorf("messages = %v, want %v", d.messages, want)
+	}
+	if d.records != 6 {
+		t.Errorf("records = %d, want 6", d.records)
+	}
+}
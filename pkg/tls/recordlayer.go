@@ -0,0 +1,165 @@
+package tls
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// RecordStats describes the records exchanged on a connection.
+type RecordStats struct {
+	RecordsRead    uint64
+	RecordsWritten uint64
+	BytesRead      uint64 // Record payload bytes, excluding headers
+	BytesWritten   uint64
+
+	// HandshakeRead and HandshakeWritten list the handshake messages in the
+	// order they were exchanged. Messages after ChangeCipherSpec (TLS 1.2)
+	// or ServerHello (TLS 1.3) are encrypted and not listed.
+	HandshakeRead    []HandshakeType
+	HandshakeWritten []HandshakeType
+}
+
+// recordLayer sits between crypto/tls and the transport. It frames the byte
+// stream into records in both directions, rejects malformed records from
+// the peer before they reach the TLS state machine, and follows the
+// plaintext handshake messages.
+type recordLayer struct {
+	net.Conn
+
+	// Read side, used only by the goroutine reading the connection
+	header  [RecordHeaderLength]byte
+	buf     []byte
+	pending []byte // Validated record bytes not yet returned by Read
+
+	// Write side: bytes of an incomplete record written so far
+	partial []byte
+
+	mu  sync.Mutex
+	in  recordDirection
+	out recordDirection
+}
+
+// recordDirection follows the records in one direction.
+type recordDirection struct {
+	records   uint64
+	bytes     uint64
+	messages  []HandshakeType
+	encrypted bool
+
+	// Handshake messages may span records: the header read so far and the
+	// body bytes left to skip
+	msgHeader []byte
+	skip      int
+}
+
+func newRecordLayer(conn net.Conn) *recordLayer {
+	return &recordLayer{Conn: conn}
+}
+
+// Read returns the bytes of whole, validated records.
+func (l *recordLayer) Read(p []byte) (int, error) {
+	if len(l.pending) == 0 {
+		if _, err := io.ReadFull(l.Conn, l.header[:]); err != nil {
+			return 0, err
+		}
+		typ, _, length, err := ParseRecordHeader(l.header[:])
+		if err != nil {
+			return 0, err
+		}
+
+		l.buf = append(l.buf[:0], l.header[:]...)
+		l.buf = append(l.buf, make([]byte, length)...)
+		if _, err := io.ReadFull(l.Conn, l.buf[RecordHeaderLength:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		l.mu.Lock()
+		l.in.observe(typ, l.buf[RecordHeaderLength:])
+		l.mu.Unlock()
+		l.pending = l.buf
+	}
+
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+// Write follows the records in p and passes it to the transport unchanged.
+func (l *recordLayer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	l.partial = append(l.partial, p...)
+	for len(l.partial) >= RecordHeaderLength {
+		length := int(binary.BigEndian.Uint16(l.partial[3:5]))
+		if len(l.partial) < RecordHeaderLength+length {
+			break
+		}
+		l.out.observe(ContentType(l.partial[0]), l.partial[RecordHeaderLength:RecordHeaderLength+length])
+		l.partial = l.partial[RecordHeaderLength+length:]
+	}
+	if len(l.partial) == 0 {
+		l.partial = nil
+	}
+	l.mu.Unlock()
+
+	return l.Conn.Write(p)
+}
+
+// stats returns a snapshot of the record counters.
+func (l *recordLayer) stats() RecordStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return RecordStats{
+		RecordsRead:      l.in.records,
+		RecordsWritten:   l.out.records,
+		BytesRead:        l.in.bytes,
+		BytesWritten:     l.out.bytes,
+		HandshakeRead:    append([]HandshakeType(nil), l.in.messages...),
+		HandshakeWritten: append([]HandshakeType(nil), l.out.messages...),
+	}
+}
+
+// observe accounts for one record.
+func (d *recordDirection) observe(typ ContentType, payload []byte) {
+	d.records++
+	d.bytes += uint64(len(payload))
+
+	switch typ {
+	case ContentChangeCipherSpec, ContentApplicationData:
+		// Everything that follows is encrypted (TLS 1.3 wraps its encrypted
+		// handshake in application_data records)
+		d.encrypted = true
+	case ContentHandshake:
+		if !d.encrypted {
+			d.followHandshake(payload)
+		}
+	}
+}
+
+// followHandshake records the types of the handshake messages in data.
+func (d *recordDirection) followHandshake(data []byte) {
+	for len(data) > 0 {
+		// Skip the body of the current message
+		if d.skip > 0 {
+			n := min(d.skip, len(data))
+			d.skip -= n
+			data = data[n:]
+			continue
+		}
+
+		n := min(handshakeHeaderLength-len(d.msgHeader), len(data))
+		d.msgHeader = append(d.msgHeader, data[:n]...)
+		data = data[n:]
+
+		if len(d.msgHeader) == handshakeHeaderLength {
+			d.messages = append(d.messages, HandshakeType(d.msgHeader[0]))
+			d.skip = int(d.msgHeader[1])<<16 | int(d.msgHeader[2])<<8 | int(d.msgHeader[3])
+			d.msgHeader = d.msgHeader[:0]
+		}
+	}
+}
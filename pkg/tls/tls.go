@@ -49,6 +49,7 @@ type Config struct {
 	Certificates       []tls.Certificate
 	RootCAs            *x509.CertPool
 	ServerName         string
+	NextProtos         []string // ALPN protocols, in order of preference
 }
 
 // DefaultConfig returns a secure default TLS configuration.
@@ -76,6 +77,7 @@ func (c *Config) ToStdTLSConfig() *tls.Config {
 		Certificates:       c.Certificates,
 		RootCAs:            c.RootCAs,
 		ServerName:         c.ServerName,
+		NextProtos:         c.NextProtos,
 	}
 
 	if len(c.CipherSuites) > 0 {
@@ -0,0 +1,286 @@
+package tunnel
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// ChaCha20-Poly1305 AEAD (RFC 8439). The standard library has no exported
+// implementation, and the tunnel needs nothing but sealing and opening
+// its messages, so this is the plain portable construction.
+
+const (
+	chachaKeySize   = 32
+	chachaNonceSize = 12
+	poly1305TagSize = 16
+	chachaBlockSize = 64
+)
+
+var errOpen = errors.New("message authentication failed")
+
+// chachaPoly is a ChaCha20-Poly1305 AEAD with a fixed key.
+type chachaPoly struct {
+	key [chachaKeySize]byte
+}
+
+// Compile-time check that chachaPoly implements cipher.AEAD.
+var _ cipher.AEAD = (*chachaPoly)(nil)
+
+// newChaChaPoly returns a ChaCha20-Poly1305 AEAD using key.
+func newChaChaPoly(key [chachaKeySize]byte) *chachaPoly {
+	return &chachaPoly{key: key}
+}
+
+// NonceSize returns the size of the nonces Seal and Open take.
+func (c *chachaPoly) NonceSize() int { return chachaNonceSize }
+
+// Overhead returns how much longer a ciphertext is than its plaintext.
+func (c *chachaPoly) Overhead() int { return poly1305TagSize }
+
+// Seal encrypts and authenticates plaintext, authenticates additionalData,
+// and appends the result to dst.
+func (c *chachaPoly) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != chachaNonceSize {
+		panic("tunnel: invalid ChaCha20-Poly1305 nonce size")
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+poly1305TagSize)
+	ciphertext := out[:len(plaintext)]
+	chachaXOR(ciphertext, plaintext, &c.key, nonce, 1)
+
+	var tag [poly1305TagSize]byte
+	c.tag(&tag, nonce, ciphertext, additionalData)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+// Open authenticates and decrypts ciphertext, authenticates
+// additionalData, and appends the plaintext to dst.
+func (c *chachaPoly) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != chachaNonceSize {
+		panic("tunnel: invalid ChaCha20-Poly1305 nonce size")
+	}
+	if len(ciphertext) < poly1305TagSize {
+		return nil, errOpen
+	}
+	tagged := ciphertext[len(ciphertext)-poly1305TagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-poly1305TagSize]
+
+	var tag [poly1305TagSize]byte
+	c.tag(&tag, nonce, ciphertext, additionalData)
+	if subtle.ConstantTimeCompare(tag[:], tagged) != 1 {
+		return nil, errOpen
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	chachaXOR(out, ciphertext, &c.key, nonce, 1)
+	return ret, nil
+}
+
+// tag computes the Poly1305 tag of a message, keyed with the first
+// ChaCha20 block under nonce.
+func (c *chachaPoly) tag(tag *[poly1305TagSize]byte, nonce, ciphertext, additionalData []byte) {
+	var block [chachaBlockSize]byte
+	chachaBlock(&block, &c.key, nonce, 0)
+	var polyKey [32]byte
+	copy(polyKey[:], block[:32])
+
+	n := pad16(len(additionalData)) + pad16(len(ciphertext)) + 16
+	macData := make([]byte, n)
+	copy(macData, additionalData)
+	copy(macData[pad16(len(additionalData)):], ciphertext)
+	binary.LittleEndian.PutUint64(macData[n-16:], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(macData[n-8:], uint64(len(ciphertext)))
+	poly1305(tag, macData, &polyKey)
+}
+
+// pad16 rounds n up to a multiple of 16.
+func pad16(n int) int {
+	return (n + 15) &^ 15
+}
+
+// sliceForAppend extends in by n bytes, returning the whole slice and the
+// n new bytes.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	return head, head[len(in):]
+}
+
+// chachaXOR XORs src with the ChaCha20 key stream starting at block
+// counter into dst.
+func chachaXOR(dst, src []byte, key *[chachaKeySize]byte, nonce []byte, counter uint32) {
+	var block [chachaBlockSize]byte
+	for len(src) > 0 {
+		chachaBlock(&block, key, nonce, counter)
+		counter++
+		n := len(src)
+		if n > chachaBlockSize {
+			n = chachaBlockSize
+		}
+		subtle.XORBytes(dst[:n], src[:n], block[:n])
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+// chachaBlock computes a ChaCha20 block (RFC 8439 Section 2.3).
+func chachaBlock(out *[chachaBlockSize]byte, key *[chachaKeySize]byte, nonce []byte, counter uint32) {
+	var s [16]uint32
+	s[0], s[1], s[2], s[3] = 0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	for i := 0; i < 8; i++ {
+		s[4+i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	s[12] = counter
+	s[13] = binary.LittleEndian.Uint32(nonce[0:])
+	s[14] = binary.LittleEndian.Uint32(nonce[4:])
+	s[15] = binary.LittleEndian.Uint32(nonce[8:])
+
+	x := s
+	for i := 0; i < 10; i++ {
+		quarterRound(&x, 0, 4, 8, 12)
+		quarterRound(&x, 1, 5, 9, 13)
+		quarterRound(&x, 2, 6, 10, 14)
+		quarterRound(&x, 3, 7, 11, 15)
+		quarterRound(&x, 0, 5, 10, 15)
+		quarterRound(&x, 1, 6, 11, 12)
+		quarterRound(&x, 2, 7, 8, 13)
+		quarterRound(&x, 3, 4, 9, 14)
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[4*i:], x[i]+s[i])
+	}
+}
+
+// quarterRound is the ChaCha quarter round on words a, b, c and d of x.
+func quarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+}
+
+// poly1305 computes the Poly1305 tag of msg (RFC 8439 Section 2.5), in 26
+// bit limbs so every product fits in 64 bits.
+func poly1305(out *[poly1305TagSize]byte, msg []byte, key *[32]byte) {
+	const mask = 1<<26 - 1
+
+	// r, clamped
+	r0 := binary.LittleEndian.Uint32(key[0:]) & 0x3ffffff
+	r1 := (binary.LittleEndian.Uint32(key[3:]) >> 2) & 0x3ffff03
+	r2 := (binary.LittleEndian.Uint32(key[6:]) >> 4) & 0x3ffc0ff
+	r3 := (binary.LittleEndian.Uint32(key[9:]) >> 6) & 0x3f03fff
+	r4 := (binary.LittleEndian.Uint32(key[12:]) >> 8) & 0x00fffff
+	s1, s2, s3, s4 := r1*5, r2*5, r3*5, r4*5
+
+	var h0, h1, h2, h3, h4 uint32
+	var block [16]byte
+	for len(msg) > 0 {
+		hibit := uint32(1 << 24)
+		m := msg
+		if len(msg) < 16 {
+			block = [16]byte{}
+			copy(block[:], msg)
+			block[len(msg)] = 1
+			m = block[:]
+			hibit = 0
+			msg = nil
+		} else {
+			msg = msg[16:]
+		}
+
+		h0 += binary.LittleEndian.Uint32(m[0:]) & mask
+		h1 += (binary.LittleEndian.Uint32(m[3:]) >> 2) & mask
+		h2 += (binary.LittleEndian.Uint32(m[6:]) >> 4) & mask
+		h3 += (binary.LittleEndian.Uint32(m[9:]) >> 6) & mask
+		h4 += (binary.LittleEndian.Uint32(m[12:]) >> 8) | hibit
+
+		// h *= r, mod 2^130 - 5
+		d0 := uint64(h0)*uint64(r0) + uint64(h1)*uint64(s4) + uint64(h2)*uint64(s3) + uint64(h3)*uint64(s2) + uint64(h4)*uint64(s1)
+		d1 := uint64(h0)*uint64(r1) + uint64(h1)*uint64(r0) + uint64(h2)*uint64(s4) + uint64(h3)*uint64(s3) + uint64(h4)*uint64(s2)
+		d2 := uint64(h0)*uint64(r2) + uint64(h1)*uint64(r1) + uint64(h2)*uint64(r0) + uint64(h3)*uint64(s4) + uint64(h4)*uint64(s3)
+		d3 := uint64(h0)*uint64(r3) + uint64(h1)*uint64(r2) + uint64(h2)*uint64(r1) + uint64(h3)*uint64(r0) + uint64(h4)*uint64(s4)
+		d4 := uint64(h0)*uint64(r4) + uint64(h1)*uint64(r3) + uint64(h2)*uint64(r2) + uint64(h3)*uint64(r1) + uint64(h4)*uint64(r0)
+
+		c := d0 >> 26
+		h0 = uint32(d0) & mask
+		d1 += c
+		c = d1 >> 26
+		h1 = uint32(d1) & mask
+		d2 += c
+		c = d2 >> 26
+		h2 = uint32(d2) & mask
+		d3 += c
+		c = d3 >> 26
+		h3 = uint32(d3) & mask
+		d4 += c
+		c = d4 >> 26
+		h4 = uint32(d4) & mask
+		h0 += uint32(c) * 5
+		h1 += h0 >> 26
+		h0 &= mask
+	}
+
+	// Fully carry h
+	c := h1 >> 26
+	h1 &= mask
+	h2 += c
+	c = h2 >> 26
+	h2 &= mask
+	h3 += c
+	c = h3 >> 26
+	h3 &= mask
+	h4 += c
+	c = h4 >> 26
+	h4 &= mask
+	h0 += c * 5
+	c = h0 >> 26
+	h0 &= mask
+	h1 += c
+
+	// g = h - (2^130 - 5), taken if it does not underflow
+	g0 := h0 + 5
+	c = g0 >> 26
+	g0 &= mask
+	g1 := h1 + c
+	c = g1 >> 26
+	g1 &= mask
+	g2 := h2 + c
+	c = g2 >> 26
+	g2 &= mask
+	g3 := h3 + c
+	c = g3 >> 26
+	g3 &= mask
+	g4 := h4 + c - 1<<26
+
+	sel := (g4 >> 31) - 1 // All ones if g did not underflow
+	h0 = h0&^sel | g0&sel
+	h1 = h1&^sel | g1&sel
+	h2 = h2&^sel | g2&sel
+	h3 = h3&^sel | g3&sel
+	h4 = h4&^sel | g4&sel
+
+	// h = h % 2^128 + s
+	h0 = h0 | h1<<26
+	h1 = h1>>6 | h2<<20
+	h2 = h2>>12 | h3<<14
+	h3 = h3>>18 | h4<<8
+
+	f := uint64(h0) + uint64(binary.LittleEndian.Uint32(key[16:]))
+	binary.LittleEndian.PutUint32(out[0:], uint32(f))
+	f = uint64(h1) + uint64(binary.LittleEndian.Uint32(key[20:])) + f>>32
+	binary.LittleEndian.PutUint32(out[4:], uint32(f))
+	f = uint64(h2) + uint64(binary.LittleEndian.Uint32(key[24:])) + f>>32
+	binary.LittleEndian.PutUint32(out[8:], uint32(f))
+	f = uint64(h3) + uint64(binary.LittleEndian.Uint32(key[28:])) + f>>32
+	binary.LittleEndian.PutUint32(out[12:], uint32(f))
+}
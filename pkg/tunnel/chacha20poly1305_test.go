@@ -0,0 +1,66 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("DecodeString() error = %v", err)
+	}
+	return b
+}
+
+// TestChaChaPoly checks the AEAD test vector of RFC 8439 Section 2.8.2.
+func TestChaChaPoly(t *testing.T) {
+	var key [chachaKeySize]byte
+	for i := range key {
+		key[i] = 0x80 + byte(i)
+	}
+	nonce := mustHex(t, "070000004041424344454647")
+	aad := mustHex(t, "50515253c0c1c2c3c4c5c6c7")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	want := mustHex(t, "d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d6"+
+		"3dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b36"+
+		"92ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc"+
+		"3ff4def08e4b7a9de576d26586cec64b6116"+
+		"1ae10b594f09e26a7e902ecbd0600691")
+
+	aead := newChaChaPoly(key)
+	sealed := aead.Seal(nil, nonce, plaintext, aad)
+	if !bytes.Equal(sealed, want) {
+		t.Fatalf("Seal() = %x, want %x", sealed, want)
+	}
+
+	opened, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Open() = %q, want %q", opened, plaintext)
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[10] ^= 1
+	if _, err := aead.Open(nil, nonce, tampered, aad); err == nil {
+		t.Error("Open() of a tampered ciphertext succeeded")
+	}
+	if _, err := aead.Open(nil, nonce, sealed, aad[1:]); err == nil {
+		t.Error("Open() with other additional data succeeded")
+	}
+}
+
+// TestPoly1305 checks the test vector of RFC 8439 Section 2.5.2.
+func TestPoly1305(t *testing.T) {
+	var key [32]byte
+	copy(key[:], mustHex(t, "85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b"))
+	var tag [poly1305TagSize]byte
+	poly1305(&tag, []byte("Cryptographic Forum Research Group"), &key)
+	if want := mustHex(t, "a8061dc1305136c6c22b8baf0c0127a9"); !bytes.Equal(tag[:], want) {
+		t.Errorf("poly1305() = %x, want %x", tag, want)
+	}
+}
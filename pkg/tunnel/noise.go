@@ -0,0 +1,317 @@
+package tunnel
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// The handshake is Noise IK (Noise Protocol Framework, revision 34,
+// Section 7.5): the initiator knows the responder's static key in advance,
+// and sends its own encrypted in the first message.
+//
+//	<- s
+//	...
+//	-> e, es, s, ss
+//	<- e, ee, se
+//
+// It runs over Curve25519, ChaCha20-Poly1305 and SHA-256. WireGuard uses
+// BLAKE2s as the hash, which the standard library lacks.
+
+// protocolName names the Noise protocol, and is hashed into every
+// handshake.
+const protocolName = "Noise_IK_25519_ChaChaPoly_SHA256"
+
+// KeySize is the size of Curve25519 keys.
+const KeySize = 32
+
+// Key is a Curve25519 private or public key.
+type Key [KeySize]byte
+
+// GenerateKey returns a new random private key.
+func GenerateKey() (Key, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+	var k Key
+	copy(k[:], priv.Bytes())
+	return k, nil
+}
+
+// PublicKey returns the public key of the private key k.
+func (k Key) PublicKey() Key {
+	priv, err := ecdh.X25519().NewPrivateKey(k[:])
+	if err != nil {
+		panic(err) // Every 32-byte string is an X25519 private key
+	}
+	var pub Key
+	copy(pub[:], priv.PublicKey().Bytes())
+	return pub
+}
+
+// IsZero reports whether the key is all zeros, as an unset key is.
+func (k Key) IsZero() bool {
+	return k == Key{}
+}
+
+// String returns the key in base64, as WireGuard shows keys.
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// ParseKey parses a key in base64.
+func ParseKey(s string) (Key, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid key: %w", err)
+	}
+	if len(b) != KeySize {
+		return Key{}, fmt.Errorf("invalid key length: %d bytes (want %d)", len(b), KeySize)
+	}
+	var k Key
+	copy(k[:], b)
+	return k, nil
+}
+
+// dh returns the X25519 shared secret of a private and a public key. It
+// fails for public keys of low order, whose secret is all zeros.
+func dh(priv, pub Key) ([32]byte, error) {
+	var secret [32]byte
+	p, err := ecdh.X25519().NewPrivateKey(priv[:])
+	if err != nil {
+		return secret, err
+	}
+	q, err := ecdh.X25519().NewPublicKey(pub[:])
+	if err != nil {
+		return secret, err
+	}
+	b, err := p.ECDH(q)
+	if err != nil {
+		return secret, fmt.Errorf("key exchange failed: %w", err)
+	}
+	copy(secret[:], b)
+	return secret, nil
+}
+
+// symmetricState is the Noise SymmetricState: the chaining key, the
+// handshake hash and the key of the messages so far.
+type symmetricState struct {
+	ck     [32]byte // Chaining key
+	h      [32]byte // Handshake hash
+	k      [32]byte
+	hasKey bool
+	n      uint64
+}
+
+// init starts the handshake of the protocol with a prologue.
+func (s *symmetricState) init(prologue []byte) {
+	copy(s.h[:], protocolName) // Exactly the hash length, so not hashed
+	s.ck = s.h
+	s.mixHash(prologue)
+}
+
+// mixHash mixes data into the handshake hash.
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h[:])
+	h.Write(data)
+	h.Sum(s.h[:0])
+}
+
+// mixKey mixes the output of a key exchange into the chaining key, and
+// derives the key of the next handshake messages from it.
+func (s *symmetricState) mixKey(ikm []byte) {
+	s.ck, s.k = hkdf(s.ck[:], ikm)
+	s.hasKey = true
+	s.n = 0
+}
+
+// encryptAndHash encrypts plaintext once there is a key, and mixes the
+// result into the handshake hash.
+func (s *symmetricState) encryptAndHash(dst, plaintext []byte) []byte {
+	if !s.hasKey {
+		s.mixHash(plaintext)
+		return append(dst, plaintext...)
+	}
+	start := len(dst)
+	dst = newChaChaPoly(s.k).Seal(dst, nonce(s.n), plaintext, s.h[:])
+	s.n++
+	s.mixHash(dst[start:])
+	return dst
+}
+
+// decryptAndHash is the reverse of encryptAndHash.
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	if !s.hasKey {
+		s.mixHash(ciphertext)
+		return ciphertext, nil
+	}
+	plaintext, err := newChaChaPoly(s.k).Open(nil, nonce(s.n), ciphertext, s.h[:])
+	if err != nil {
+		return nil, err
+	}
+	s.n++
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split returns the two transport keys: the initiator sends with the
+// first and the responder with the second.
+func (s *symmetricState) split() (k1, k2 [32]byte) {
+	return hkdf(s.ck[:], nil)
+}
+
+// hkdf is the HKDF of the Noise specification (Section 4.3), producing two
+// outputs.
+func hkdf(ck, ikm []byte) (out1, out2 [32]byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{0x01})
+	mac.Sum(out1[:0])
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1[:])
+	mac.Write([]byte{0x02})
+	mac.Sum(out2[:0])
+	return out1, out2
+}
+
+// nonce returns the ChaCha20-Poly1305 nonce of message n: 32 zero bits
+// followed by n in little-endian.
+func nonce(n uint64) []byte {
+	b := make([]byte, chachaNonceSize)
+	binary.LittleEndian.PutUint64(b[4:], n)
+	return b
+}
+
+// handshake is one side of an IK handshake.
+type handshake struct {
+	symmetricState
+	static          Key // Local private key
+	remote          Key // Remote static public key
+	ephemeral       Key // Local ephemeral private key
+	remoteEphemeral Key
+}
+
+// newHandshake starts a handshake between static and the responder's
+// public key. Both sides pass the responder's key: it is the pre-message.
+func newHandshake(static, responder Key, prologue []byte) *handshake {
+	hs := &handshake{static: static}
+	hs.init(prologue)
+	hs.mixHash(responder[:])
+	return hs
+}
+
+// writeInitiation returns the initiator's message: its ephemeral key, its
+// static key and the payload, the latter two encrypted.
+func (hs *handshake) writeInitiation(remote Key, payload []byte) ([]byte, error) {
+	hs.remote = remote
+	var err error
+	if hs.ephemeral, err = GenerateKey(); err != nil {
+		return nil, err
+	}
+	e := hs.ephemeral.PublicKey()
+	msg := append([]byte(nil), e[:]...)
+	hs.mixHash(e[:])
+
+	if err := hs.mixDH(hs.ephemeral, hs.remote); err != nil { // es
+		return nil, err
+	}
+	s := hs.static.PublicKey()
+	msg = hs.encryptAndHash(msg, s[:])
+	if err := hs.mixDH(hs.static, hs.remote); err != nil { // ss
+		return nil, err
+	}
+	return hs.encryptAndHash(msg, payload), nil
+}
+
+// readInitiation processes the initiator's message on the responder, and
+// returns the initiator's static key and the payload.
+func (hs *handshake) readInitiation(msg []byte) (Key, []byte, error) {
+	if len(msg) < KeySize+KeySize+poly1305TagSize+poly1305TagSize {
+		return Key{}, nil, fmt.Errorf("initiation too short: %d bytes", len(msg))
+	}
+	var re Key
+	copy(re[:], msg[:KeySize])
+	hs.mixHash(re[:])
+	if err := hs.mixDH(hs.static, re); err != nil { // es
+		return Key{}, nil, err
+	}
+
+	s, err := hs.decryptAndHash(msg[KeySize : 2*KeySize+poly1305TagSize])
+	if err != nil {
+		return Key{}, nil, fmt.Errorf("failed to decrypt static key: %w", err)
+	}
+	copy(hs.remote[:], s)
+	if err := hs.mixDH(hs.static, hs.remote); err != nil { // ss
+		return Key{}, nil, err
+	}
+
+	payload, err := hs.decryptAndHash(msg[2*KeySize+poly1305TagSize:])
+	if err != nil {
+		return Key{}, nil, fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+	hs.remoteEphemeral = re
+	return hs.remote, payload, nil
+}
+
+// writeResponse returns the responder's message: its ephemeral key and
+// the encrypted payload.
+func (hs *handshake) writeResponse(payload []byte) ([]byte, error) {
+	var err error
+	if hs.ephemeral, err = GenerateKey(); err != nil {
+		return nil, err
+	}
+	e := hs.ephemeral.PublicKey()
+	msg := append([]byte(nil), e[:]...)
+	hs.mixHash(e[:])
+
+	if err := hs.mixDH(hs.ephemeral, hs.remoteEphemeral); err != nil { // ee
+		return nil, err
+	}
+	if err := hs.mixDH(hs.ephemeral, hs.remote); err != nil { // se
+		return nil, err
+	}
+	return hs.encryptAndHash(msg, payload), nil
+}
+
+// readResponse processes the responder's message on the initiator, and
+// returns the payload.
+func (hs *handshake) readResponse(msg []byte) ([]byte, error) {
+	if len(msg) < KeySize+poly1305TagSize {
+		return nil, fmt.Errorf("response too short: %d bytes", len(msg))
+	}
+	var re Key
+	copy(re[:], msg[:KeySize])
+	hs.mixHash(re[:])
+	if err := hs.mixDH(hs.ephemeral, re); err != nil { // ee
+		return nil, err
+	}
+	if err := hs.mixDH(hs.static, re); err != nil { // se
+		return nil, err
+	}
+
+	payload, err := hs.decryptAndHash(msg[KeySize:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+	return payload, nil
+}
+
+// mixDH mixes the shared secret of priv and pub into the chaining key.
+func (hs *handshake) mixDH(priv, pub Key) error {
+	secret, err := dh(priv, pub)
+	if err != nil {
+		return err
+	}
+	hs.mixKey(secret[:])
+	return nil
+}
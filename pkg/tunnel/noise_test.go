@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"bytes"
+	"testing"
+)
+
+func mustGenerateKey(t *testing.T) Key {
+	t.Helper()
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	return k
+}
+
+func TestHandshake(t *testing.T) {
+	initiator, responder := mustGenerateKey(t), mustGenerateKey(t)
+	prologue := []byte("test")
+
+	i := newHandshake(initiator, responder.PublicKey(), prologue)
+	msg1, err := i.writeInitiation(responder.PublicKey(), []byte("hello"))
+	if err != nil {
+		t.Fatalf("writeInitiation() error = %v", err)
+	}
+
+	r := newHandshake(responder, responder.PublicKey(), prologue)
+	remote, payload, err := r.readInitiation(msg1)
+	if err != nil {
+		t.Fatalf("readInitiation() error = %v", err)
+	}
+	if remote != initiator.PublicKey() || string(payload) != "hello" {
+		t.Errorf("readInitiation() = %s, %q, want %s, %q", remote, payload, initiator.PublicKey(), "hello")
+	}
+
+	msg2, err := r.writeResponse(nil)
+	if err != nil {
+		t.Fatalf("writeResponse() error = %v", err)
+	}
+	if _, err := i.readResponse(msg2); err != nil {
+		t.Fatalf("readResponse() error = %v", err)
+	}
+
+	ik1, ik2 := i.split()
+	rk1, rk2 := r.split()
+	if ik1 != rk1 || ik2 != rk2 || ik1 == ik2 {
+		t.Error("split() keys differ between the sides, or are the same for both directions")
+	}
+	if i.h != r.h {
+		t.Error("handshake hashes differ")
+	}
+}
+
+func TestHandshakeMismatch(t *testing.T) {
+	initiator, responder, other := mustGenerateKey(t), mustGenerateKey(t), mustGenerateKey(t)
+	tests := []struct {
+		name      string
+		target    Key    // Responder key the initiator uses
+		prologue  []byte // Of the responder
+		corrupted bool
+	}{
+		{"wrong responder key", other.PublicKey(), nil, false},
+		{"different prologue", responder.PublicKey(), []byte("other"), false},
+		{"corrupted", responder.PublicKey(), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newHandshake(initiator, tt.target, nil)
+			msg, err := i.writeInitiation(tt.target, nil)
+			if err != nil {
+				t.Fatalf("writeInitiation() error = %v", err)
+			}
+			if tt.corrupted {
+				msg[len(msg)-1] ^= 1
+			}
+			r := newHandshake(responder, responder.PublicKey(), tt.prologue)
+			if _, _, err := r.readInitiation(msg); err == nil {
+				t.Error("readInitiation() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	k := mustGenerateKey(t)
+	parsed, err := ParseKey(k.String())
+	if err != nil {
+		t.Fatalf("ParseKey() error = %v", err)
+	}
+	if !bytes.Equal(parsed[:], k[:]) {
+		t.Errorf("ParseKey(%s) = %s", k, parsed)
+	}
+	for _, s := range []string{"not base64!", "AAAA"} {
+		if _, err := ParseKey(s); err == nil {
+			t.Errorf("ParseKey(%q) succeeded, want error", s)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package tunnel
+
+import (
+	"time"
+)
+
+// replayWindowSize is how far behind the highest counter received a
+// transport message may arrive and still be accepted.
+const replayWindowSize = 64
+
+// session is the keys of one completed handshake and their counters.
+type session struct {
+	localIndex  uint32 // Index the peer addresses the session's messages to
+	remoteIndex uint32 // Index the messages sent on the session carry
+	send, recv  *chachaPoly
+	sendCounter uint64
+	replay      replayWindow
+	created     time.Time
+	initiator   bool // Whether the local side started the handshake
+}
+
+// newSession creates a session from the transport keys of a handshake.
+func newSession(hs *handshake, initiator bool, localIndex, remoteIndex uint32, now time.Time) *session {
+	k1, k2 := hs.split()
+	s := &session{
+		localIndex:  localIndex,
+		remoteIndex: remoteIndex,
+		created:     now,
+		initiator:   initiator,
+	}
+	if initiator {
+		s.send, s.recv = newChaChaPoly(k1), newChaChaPoly(k2)
+	} else {
+		s.send, s.recv = newChaChaPoly(k2), newChaChaPoly(k1)
+	}
+	return s
+}
+
+// replayWindow tracks the counters received on a session, rejecting
+// repeated and too old ones (RFC 6479).
+type replayWindow struct {
+	highest uint64 // Highest counter accepted
+	bitmap  uint64 // Bit i set if highest-i was accepted
+	started bool
+}
+
+// check reports whether counter is new and within the window. It does not
+// record it: only authenticated messages must move the window.
+func (w *replayWindow) check(counter uint64) bool {
+	switch {
+	case !w.started || counter > w.highest:
+		return true
+	case w.highest-counter >= replayWindowSize:
+		return false
+	default:
+		return w.bitmap&(1<<(w.highest-counter)) == 0
+	}
+}
+
+// accept records counter as received.
+func (w *replayWindow) accept(counter uint64) {
+	switch {
+	case !w.started:
+		w.started = true
+		w.highest, w.bitmap = counter, 1
+	case counter > w.highest:
+		shift := counter - w.highest
+		if shift >= replayWindowSize {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= shift
+		}
+		w.bitmap |= 1
+		w.highest = counter
+	default:
+		w.bitmap |= 1 << (w.highest - counter)
+	}
+}
@@ -0,0 +1,32 @@
+package tunnel
+
+import "testing"
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	steps := []struct {
+		counter uint64
+		want    bool
+	}{
+		{0, true},
+		{0, false}, // Repeated
+		{2, true},
+		{1, true}, // Out of order, within the window
+		{1, false},
+		{100, true},
+		{37, true},
+		{36, false}, // Just out of the window
+		{37, false},
+		{200, true},
+		{100, false},
+	}
+	for i, step := range steps {
+		got := w.check(step.counter)
+		if got != step.want {
+			t.Fatalf("step %d: check(%d) = %v, want %v", i, step.counter, got, step.want)
+		}
+		if got {
+			w.accept(step.counter)
+		}
+	}
+}
@@ -0,0 +1,687 @@
+// Package tunnel implements an encrypted point-to-point IP tunnel in the
+// style of WireGuard, over the stack's UDP sockets.
+//
+// The two ends authenticate each other by their static Curve25519 keys in
+// a Noise IK handshake, and exchange IP packets encrypted with
+// ChaCha20-Poly1305 under the keys it yields. The initiator repeats the
+// handshake every RekeyAfter, so no key protects more than a few minutes
+// of traffic, and keepalives tell the ends apart from a dead peer.
+//
+// Unlike WireGuard, the hash is SHA-256 and there is no cookie mechanism
+// against handshake floods; the two are not wire compatible.
+package tunnel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMTU is the largest packet a tunnel carries by default: an
+	// Ethernet MTU less the overhead of an IPv6 underlay.
+	DefaultMTU = 1420
+
+	// DefaultRekeyAfter is how old a session gets before the initiator
+	// replaces it with a new handshake. Sessions are not used past one and
+	// a half times it.
+	DefaultRekeyAfter = 120 * time.Second
+
+	// DefaultRekeyAfterMessages is how many messages a session sends
+	// before either side replaces it.
+	DefaultRekeyAfterMessages = 1 << 60
+
+	// DefaultKeepaliveTimeout is how long a tunnel waits after receiving a
+	// packet for one it could reply with, before sending a keepalive.
+	DefaultKeepaliveTimeout = 10 * time.Second
+
+	// RekeyTimeout is how long an initiator waits for the response to its
+	// handshake before retrying.
+	RekeyTimeout = 5 * time.Second
+
+	// rekeyAttemptTime is how long an initiator retries a handshake before
+	// giving up and dropping the packets waiting for it.
+	rekeyAttemptTime = 90 * time.Second
+
+	// rejectAfterMessages is the last counter a session sends with, well
+	// short of the nonces running out.
+	rejectAfterMessages = ^uint64(0) - 1<<13
+
+	// tickInterval is how often timers are checked.
+	tickInterval = 250 * time.Millisecond
+
+	// queueLen is the number of packets kept, waiting for a handshake or to
+	// be read.
+	queueLen = 256
+)
+
+// Message formats, all integers little-endian:
+//
+// Initiation (116 bytes):
+// +----------+--------------+------------+----------------------+------------------------+
+// | Type (1) | Reserved (3) | Sender (4) | Ephemeral key (32)   | Static key (32+16)     |
+// +----------+--------------+------------+----------------------+------------------------+
+// | Timestamp (12+16)                                                                    |
+// +--------------------------------------------------------------------------------------+
+//
+// Response (60 bytes):
+// +----------+--------------+------------+--------------+--------------------+-----------+
+// | Type (2) | Reserved (3) | Sender (4) | Receiver (4) | Ephemeral key (32) | Empty (16)|
+// +----------+--------------+------------+--------------+--------------------+-----------+
+//
+// Transport:
+// +----------+--------------+--------------+-------------+---------------------------+
+// | Type (4) | Reserved (3) | Receiver (4) | Counter (8) | Packet, padded (n*16+16)  |
+// +----------+--------------+--------------+-------------+---------------------------+
+//
+// Sender and receiver are the indexes each side gave the session; the
+// static key and timestamp are the encrypted handshake payload. A transport
+// message with an empty packet is a keepalive.
+
+const (
+	messageInitiation = 1
+	messageResponse   = 2
+	messageTransport  = 4
+
+	timestampSize       = 12
+	initiationSize      = 8 + KeySize + KeySize + poly1305TagSize + timestampSize + poly1305TagSize
+	responseSize        = 12 + KeySize + poly1305TagSize
+	transportHeaderSize = 16
+)
+
+var (
+	// ErrClosed is returned by the methods of a closed tunnel.
+	ErrClosed = errors.New("tunnel closed")
+
+	// ErrNoPeer is returned by WritePacket before the address of the peer
+	// is known.
+	ErrNoPeer = errors.New("peer address unknown")
+)
+
+// Config configures a Tunnel.
+type Config struct {
+	PrivateKey    Key // Of the local end
+	PeerPublicKey Key // The only key the tunnel accepts handshakes from
+
+	// Peer is where the peer is reached. A tunnel without it waits for the
+	// peer's handshake; either way it follows the address authenticated
+	// messages come from, so the peer can roam.
+	Peer net.Addr
+
+	Name     string // Default "tun-noise"
+	MTU      int    // Default DefaultMTU
+	Prologue []byte // Bound into the handshake; both ends must agree
+
+	RekeyAfter          time.Duration // Default DefaultRekeyAfter
+	RekeyAfterMessages  uint64        // Default DefaultRekeyAfterMessages
+	KeepaliveTimeout    time.Duration // Default DefaultKeepaliveTimeout
+	PersistentKeepalive time.Duration // Keepalive interval regardless of traffic, as through NAT; 0 disables
+}
+
+// Stats are the counters of a tunnel.
+type Stats struct {
+	Handshakes uint64 // Completed
+	Sent       uint64 // Packets sent
+	Received   uint64 // Packets received
+	Keepalives uint64 // Keepalives sent
+	Invalid    uint64 // Messages dropped as malformed, unauthenticated or replayed
+	Dropped    uint64 // Packets dropped waiting for a handshake or to be read
+}
+
+// pendingHandshake is a handshake the tunnel initiated, waiting for the
+// response.
+type pendingHandshake struct {
+	hs      *handshake
+	index   uint32
+	sent    time.Time // When the last initiation was sent
+	started time.Time // When the first was
+}
+
+// Tunnel is one end of an encrypted tunnel. Like a TUN device it reads and
+// writes IP packets: those written are sent to the peer, and those the
+// peer sends are returned by ReadPacket.
+type Tunnel struct {
+	conn   net.PacketConn
+	config Config
+	public Key
+
+	mu            sync.Mutex
+	peer          net.Addr
+	current       *session // Session packets are sent on
+	previous      *session // Replaced session, still received on
+	next          *session // Session from a handshake the peer initiated, until the peer uses it
+	pending       *pendingHandshake
+	peerTimestamp [timestampSize]byte // Of the newest initiation accepted, against replays
+	lastTimestamp [timestampSize]byte // Of the newest initiation sent
+	queue         [][]byte            // Packets waiting for a session
+	lastSent      time.Time
+	lastData      time.Time // When a packet was last received
+	keepaliveDue  bool      // Whether a packet was received since the last message sent
+	stats         Stats
+	closed        bool
+
+	packets chan []byte
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	now func() time.Time // Clock, replaced in tests
+}
+
+// New creates a tunnel end sending and receiving over conn, a UDP socket.
+// The tunnel closes conn when it is closed.
+func New(conn net.PacketConn, config Config) (*Tunnel, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("connection is nil")
+	}
+	if config.PrivateKey.IsZero() {
+		return nil, fmt.Errorf("private key not set")
+	}
+	if config.PeerPublicKey.IsZero() {
+		return nil, fmt.Errorf("peer public key not set")
+	}
+	if config.Name == "" {
+		config.Name = "tun-noise"
+	}
+	if config.MTU == 0 {
+		config.MTU = DefaultMTU
+	}
+	if config.MTU < 0 {
+		return nil, fmt.Errorf("invalid MTU: %d", config.MTU)
+	}
+	if config.RekeyAfter == 0 {
+		config.RekeyAfter = DefaultRekeyAfter
+	}
+	if config.RekeyAfterMessages == 0 {
+		config.RekeyAfterMessages = DefaultRekeyAfterMessages
+	}
+	if config.KeepaliveTimeout == 0 {
+		config.KeepaliveTimeout = DefaultKeepaliveTimeout
+	}
+	config.Prologue = append([]byte(nil), config.Prologue...)
+
+	return &Tunnel{
+		conn:    conn,
+		config:  config,
+		public:  config.PrivateKey.PublicKey(),
+		peer:    config.Peer,
+		packets: make(chan []byte, queueLen),
+		done:    make(chan struct{}),
+		now:     time.Now,
+	}, nil
+}
+
+// Start starts processing received messages and timers, and starts the
+// handshake if the peer's address is known.
+func (t *Tunnel) Start() {
+	t.wg.Add(2)
+	go t.receive()
+	go t.run()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.initiate(t.now())
+}
+
+// Close stops the tunnel and closes its connection, unblocking
+// ReadPacket.
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	close(t.done)
+	err := t.conn.Close()
+	t.wg.Wait()
+	return err
+}
+
+// Name returns the tunnel name.
+func (t *Tunnel) Name() string {
+	return t.config.Name
+}
+
+// MTU returns the largest packet the tunnel carries.
+func (t *Tunnel) MTU() int {
+	return t.config.MTU
+}
+
+// PublicKey returns the public key of the local end.
+func (t *Tunnel) PublicKey() Key {
+	return t.public
+}
+
+// Peer returns the address the peer is reached at, or nil if it is not
+// known yet.
+func (t *Tunnel) Peer() net.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.peer
+}
+
+// Stats returns the tunnel's counters.
+func (t *Tunnel) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// ReadPacket blocks until the peer sends an IP packet.
+func (t *Tunnel) ReadPacket() ([]byte, error) {
+	select {
+	case packet := <-t.packets:
+		return packet, nil
+	case <-t.done:
+		return nil, ErrClosed
+	}
+}
+
+// WritePacket sends an IP packet to the peer. Without a session the packet
+// waits for the handshake, which the write starts if need be.
+func (t *Tunnel) WritePacket(packet []byte) error {
+	if len(packet) > t.config.MTU {
+		return fmt.Errorf("packet too large: %d bytes (MTU %d)", len(packet), t.config.MTU)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrClosed
+	}
+	now := t.now()
+	if s := t.usable(t.current, now); s != nil {
+		return t.sendTransport(s, packet, now)
+	}
+
+	if t.peer == nil {
+		return ErrNoPeer
+	}
+	if len(t.queue) == queueLen {
+		t.queue = t.queue[1:]
+		t.stats.Dropped++
+	}
+	t.queue = append(t.queue, append([]byte(nil), packet...))
+	t.initiate(now)
+	return nil
+}
+
+// receive processes the messages the peer sends until the tunnel is closed.
+func (t *Tunnel) receive() {
+	defer t.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-t.done:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		t.handle(buf[:n], addr)
+	}
+}
+
+// run checks the timers until the tunnel is closed.
+func (t *Tunnel) run() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.tick()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// handle processes a message received from addr.
+func (t *Tunnel) handle(data []byte, from net.Addr) {
+	if len(data) < 4 || data[1] != 0 || data[2] != 0 || data[3] != 0 {
+		t.invalid()
+		return
+	}
+
+	var packet []byte
+	t.mu.Lock()
+	now := t.now()
+	switch {
+	case data[0] == messageInitiation && len(data) == initiationSize:
+		t.handleInitiation(data, from, now)
+	case data[0] == messageResponse && len(data) == responseSize:
+		t.handleResponse(data, from, now)
+	case data[0] == messageTransport && len(data) >= transportHeaderSize+poly1305TagSize:
+		packet = t.handleTransport(data, from, now)
+	default:
+		t.stats.Invalid++
+	}
+	t.mu.Unlock()
+
+	if packet == nil {
+		return
+	}
+	select {
+	case t.packets <- packet:
+	default:
+		t.mu.Lock()
+		t.stats.Dropped++
+		t.mu.Unlock()
+	}
+}
+
+// invalid counts an invalid message.
+func (t *Tunnel) invalid() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.Invalid++
+}
+
+// handleInitiation answers the peer's handshake. The session it creates
+// becomes next, and is only sent on once the peer has used it, which
+// proves the initiation was not replayed.
+func (t *Tunnel) handleInitiation(data []byte, from net.Addr, now time.Time) {
+	hs := newHandshake(t.config.PrivateKey, t.public, t.config.Prologue)
+	remote, timestamp, err := hs.readInitiation(data[8:])
+	if err != nil || remote != t.config.PeerPublicKey || bytes.Compare(timestamp, t.peerTimestamp[:]) <= 0 {
+		t.stats.Invalid++
+		return
+	}
+
+	response, err := hs.writeResponse(nil)
+	if err != nil {
+		return
+	}
+	copy(t.peerTimestamp[:], timestamp)
+	t.peer = from
+
+	sender := binary.LittleEndian.Uint32(data[4:8])
+	index := t.newIndex()
+	t.next = newSession(hs, false, index, sender, now)
+
+	msg := make([]byte, 12, responseSize)
+	msg[0] = messageResponse
+	binary.LittleEndian.PutUint32(msg[4:8], index)
+	binary.LittleEndian.PutUint32(msg[8:12], sender)
+	t.send(append(msg, response...), now)
+}
+
+// handleResponse completes the handshake the tunnel initiated.
+func (t *Tunnel) handleResponse(data []byte, from net.Addr, now time.Time) {
+	receiver := binary.LittleEndian.Uint32(data[8:12])
+	if t.pending == nil || receiver != t.pending.index {
+		t.stats.Invalid++
+		return
+	}
+	if _, err := t.pending.hs.readResponse(data[12:]); err != nil {
+		t.stats.Invalid++
+		return
+	}
+
+	sender := binary.LittleEndian.Uint32(data[4:8])
+	s := newSession(t.pending.hs, true, t.pending.index, sender, now)
+	t.pending = nil
+	t.previous, t.current = t.current, s
+	t.peer = from
+	t.stats.Handshakes++
+
+	// The first message on the session confirms it to the responder
+	if len(t.queue) == 0 {
+		t.sendTransport(s, nil, now)
+	}
+	t.flush(now)
+}
+
+// handleTransport decrypts a transport message, and returns the packet it
+// carries, or nil for a keepalive.
+func (t *Tunnel) handleTransport(data []byte, from net.Addr, now time.Time) []byte {
+	receiver := binary.LittleEndian.Uint32(data[4:8])
+	counter := binary.LittleEndian.Uint64(data[8:16])
+
+	var s *session
+	for _, candidate := range []*session{t.current, t.next, t.previous} {
+		if candidate != nil && candidate.localIndex == receiver {
+			s = candidate
+			break
+		}
+	}
+	if s == nil || now.Sub(s.created) >= t.rejectAfter() || !s.replay.check(counter) {
+		t.stats.Invalid++
+		return nil
+	}
+	plaintext, err := s.recv.Open(nil, nonce(counter), data[transportHeaderSize:], nil)
+	if err != nil {
+		t.stats.Invalid++
+		return nil
+	}
+	s.replay.accept(counter)
+	t.peer = from
+
+	if s == t.next {
+		t.previous, t.current, t.next = t.current, s, nil
+		t.stats.Handshakes++
+		t.flush(now)
+	}
+
+	if len(plaintext) == 0 {
+		return nil // Keepalive
+	}
+	packet := unpad(plaintext)
+	if packet == nil {
+		t.stats.Invalid++
+		return nil
+	}
+	t.stats.Received++
+	t.lastData = now
+	t.keepaliveDue = true
+	return packet
+}
+
+// tick retries handshakes, expires sessions and sends keepalives.
+func (t *Tunnel) tick() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	now := t.now()
+
+	if t.pending != nil && now.Sub(t.pending.started) >= rekeyAttemptTime {
+		t.pending = nil
+		t.stats.Dropped += uint64(len(t.queue))
+		t.queue = nil
+	}
+	if t.pending != nil && now.Sub(t.pending.sent) >= RekeyTimeout {
+		t.initiate(now)
+	}
+
+	t.current = t.usable(t.current, now)
+	t.previous = t.usable(t.previous, now)
+	t.next = t.usable(t.next, now)
+	if t.current == nil {
+		if len(t.queue) > 0 {
+			t.initiate(now)
+		}
+		return
+	}
+
+	switch {
+	case t.keepaliveDue && now.Sub(t.lastData) >= t.config.KeepaliveTimeout:
+		t.sendTransport(t.current, nil, now)
+	case t.config.PersistentKeepalive > 0 && now.Sub(t.lastSent) >= t.config.PersistentKeepalive:
+		t.sendTransport(t.current, nil, now)
+	}
+}
+
+// initiate sends a handshake initiation, unless one was sent within the
+// rekey timeout.
+func (t *Tunnel) initiate(now time.Time) {
+	if t.peer == nil || t.closed {
+		return
+	}
+	started := now
+	if t.pending != nil {
+		if now.Sub(t.pending.sent) < RekeyTimeout {
+			return
+		}
+		started = t.pending.started
+	}
+
+	hs := newHandshake(t.config.PrivateKey, t.config.PeerPublicKey, t.config.Prologue)
+	timestamp := t.timestamp(now)
+	initiation, err := hs.writeInitiation(t.config.PeerPublicKey, timestamp[:])
+	if err != nil {
+		return
+	}
+	index := t.newIndex()
+	t.pending = &pendingHandshake{hs: hs, index: index, sent: now, started: started}
+
+	msg := make([]byte, 8, initiationSize)
+	msg[0] = messageInitiation
+	binary.LittleEndian.PutUint32(msg[4:8], index)
+	t.send(append(msg, initiation...), now)
+}
+
+// sendTransport encrypts and sends a packet on s; a nil packet is a
+// keepalive. It starts a new handshake once s is due to be replaced.
+func (t *Tunnel) sendTransport(s *session, packet []byte, now time.Time) error {
+	counter := s.sendCounter
+	s.sendCounter++
+
+	msg := make([]byte, transportHeaderSize, transportHeaderSize+len(packet)+16+poly1305TagSize)
+	msg[0] = messageTransport
+	binary.LittleEndian.PutUint32(msg[4:8], s.remoteIndex)
+	binary.LittleEndian.PutUint64(msg[8:16], counter)
+	msg = s.send.Seal(msg, nonce(counter), t.pad(packet), nil)
+
+	err := t.send(msg, now)
+	if err == nil {
+		if len(packet) == 0 {
+			t.stats.Keepalives++
+		} else {
+			t.stats.Sent++
+		}
+	}
+
+	if s.sendCounter >= t.config.RekeyAfterMessages || (s.initiator && now.Sub(s.created) >= t.config.RekeyAfter) {
+		t.initiate(now)
+	}
+	return err
+}
+
+// flush sends the packets waiting for a session.
+func (t *Tunnel) flush(now time.Time) {
+	queue := t.queue
+	t.queue = nil
+	for _, packet := range queue {
+		t.sendTransport(t.current, packet, now)
+	}
+}
+
+// send sends a message to the peer.
+func (t *Tunnel) send(msg []byte, now time.Time) error {
+	if t.peer == nil {
+		return ErrNoPeer
+	}
+	if _, err := t.conn.WriteTo(msg, t.peer); err != nil {
+		return fmt.Errorf("failed to send to %s: %w", t.peer, err)
+	}
+	t.lastSent = now
+	t.keepaliveDue = false
+	return nil
+}
+
+// usable returns s if it can still be sent on, and nil otherwise.
+func (t *Tunnel) usable(s *session, now time.Time) *session {
+	if s == nil || now.Sub(s.created) >= t.rejectAfter() || s.sendCounter >= rejectAfterMessages {
+		return nil
+	}
+	return s
+}
+
+// rejectAfter is the age past which sessions are not used.
+func (t *Tunnel) rejectAfter() time.Duration {
+	return t.config.RekeyAfter * 3 / 2
+}
+
+// newIndex returns a random session index not in use.
+func (t *Tunnel) newIndex() uint32 {
+	var b [4]byte
+	for {
+		rand.Read(b[:])
+		index := binary.LittleEndian.Uint32(b[:])
+		inUse := t.pending != nil && t.pending.index == index
+		for _, s := range []*session{t.current, t.previous, t.next} {
+			inUse = inUse || (s != nil && s.localIndex == index)
+		}
+		if !inUse {
+			return index
+		}
+	}
+}
+
+// timestamp returns the timestamp of an initiation sent at now, in
+// TAI64N: seconds and nanoseconds, big-endian, so that later timestamps
+// compare greater. Each is greater than the last, even for a clock that
+// stands still or steps back.
+func (t *Tunnel) timestamp(now time.Time) [timestampSize]byte {
+	var ts [timestampSize]byte
+	binary.BigEndian.PutUint64(ts[0:8], uint64(now.Unix())+1<<62+10)
+	binary.BigEndian.PutUint32(ts[8:12], uint32(now.Nanosecond()))
+	if bytes.Compare(ts[:], t.lastTimestamp[:]) <= 0 {
+		ts = t.lastTimestamp
+		for i := timestampSize - 1; i >= 0; i-- {
+			ts[i]++
+			if ts[i] != 0 {
+				break
+			}
+		}
+	}
+	t.lastTimestamp = ts
+	return ts
+}
+
+// pad pads a packet, no larger than the MTU, to a multiple of 16 bytes or
+// to the MTU, hiding its exact length.
+func (t *Tunnel) pad(packet []byte) []byte {
+	n := pad16(len(packet))
+	if n > t.config.MTU {
+		n = t.config.MTU
+	}
+	padded := make([]byte, n)
+	copy(padded, packet)
+	return padded
+}
+
+// unpad returns the IP packet at the start of a padded plaintext, or nil
+// if it does not hold one.
+func unpad(plaintext []byte) []byte {
+	n := 0
+	switch plaintext[0] >> 4 {
+	case 4:
+		if len(plaintext) >= 20 {
+			n = int(binary.BigEndian.Uint16(plaintext[2:4]))
+		}
+	case 6:
+		if len(plaintext) >= 40 {
+			n = 40 + int(binary.BigEndian.Uint16(plaintext[4:6]))
+		}
+	}
+	if n == 0 || n > len(plaintext) {
+		return nil
+	}
+	return plaintext[:n]
+}
@@ -0,0 +1,411 @@
+package tunnel
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ip"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// network delivers the datagrams written to its connections to the
+// connection bound to the destination address, dropping them if its
+// queue is full, as UDP would.
+type network struct {
+	mu    sync.Mutex
+	conns map[udp.Address]*conn
+}
+
+// conn is a PacketConn on a network, which records what it sends.
+type conn struct {
+	net  *network
+	addr udp.Address
+	in   chan datagram
+	done chan struct{}
+	once sync.Once
+
+	mu   sync.Mutex
+	sent [][]byte
+}
+
+// datagram is a datagram in flight on a network.
+type datagram struct {
+	data []byte
+	from udp.Address
+}
+
+func (n *network) conn(port uint16) *conn {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conns == nil {
+		n.conns = make(map[udp.Address]*conn)
+	}
+	addr := udp.Address{IP: common.IPv4Address{192, 0, 2, 1}, Port: port}
+	c := &conn{net: n, addr: addr, in: make(chan datagram, 64), done: make(chan struct{})}
+	n.conns[addr] = c
+	return c
+}
+
+func (c *conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case d := <-c.in:
+		return copy(p, d.data), d.from, nil
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	data := append([]byte(nil), p...)
+	c.mu.Lock()
+	c.sent = append(c.sent, data)
+	c.mu.Unlock()
+	c.deliver(data, addr.(udp.Address))
+	return len(p), nil
+}
+
+// deliver sends data from c to addr.
+func (c *conn) deliver(data []byte, to udp.Address) {
+	c.net.mu.Lock()
+	peer, ok := c.net.conns[to]
+	c.net.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case peer.in <- datagram{data, c.addr}:
+	default:
+	}
+}
+
+// transports returns the transport messages c has sent.
+func (c *conn) transports() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var msgs [][]byte
+	for _, msg := range c.sent {
+		if msg[0] == messageTransport {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func (c *conn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr                { return c.addr }
+func (c *conn) SetDeadline(t time.Time) error      { return nil }
+func (c *conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *conn) SetWriteDeadline(t time.Time) error { return nil }
+
+// clock is a clock the test sets, shared by both ends.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testTunnels are the two ends of a tunnel: a, which knows where b is, and
+// b, which waits for a's handshake.
+type testTunnels struct {
+	a, b         *Tunnel
+	aConn, bConn *conn
+	clock        *clock
+}
+
+func newTestTunnels(t *testing.T, config Config) *testTunnels {
+	t.Helper()
+	aKey, bKey := mustGenerateKey(t), mustGenerateKey(t)
+	var n network
+	tt := &testTunnels{aConn: n.conn(51820), bConn: n.conn(51821), clock: &clock{now: time.Unix(1000, 0)}}
+
+	aConfig, bConfig := config, config
+	aConfig.PrivateKey, aConfig.PeerPublicKey, aConfig.Peer = aKey, bKey.PublicKey(), tt.bConn.addr
+	bConfig.PrivateKey, bConfig.PeerPublicKey = bKey, aKey.PublicKey()
+
+	var err error
+	if tt.a, err = New(tt.aConn, aConfig); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if tt.b, err = New(tt.bConn, bConfig); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	tt.a.now, tt.b.now = tt.clock.Now, tt.clock.Now
+	tt.b.Start()
+	tt.a.Start()
+	t.Cleanup(func() {
+		tt.a.Close()
+		tt.b.Close()
+	})
+	return tt
+}
+
+// testPacket returns an IPv4 packet carrying payload.
+func testPacket(t *testing.T, payload string) []byte {
+	t.Helper()
+	pkt := ip.NewPacket(common.IPv4Address{10, 8, 0, 1}, common.IPv4Address{10, 8, 0, 2}, common.ProtocolUDP, []byte(payload))
+	data, err := pkt.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	return data
+}
+
+// readPacket reads a packet from tun, failing the test if none arrives.
+func readPacket(t *testing.T, tun *Tunnel) []byte {
+	t.Helper()
+	packets := make(chan []byte, 1)
+	go func() {
+		if packet, err := tun.ReadPacket(); err == nil {
+			packets <- packet
+		}
+	}()
+	select {
+	case packet := <-packets:
+		return packet
+	case <-time.After(time.Second):
+		t.Fatal("no packet received")
+		return nil
+	}
+}
+
+// waitFor waits for cond to hold, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	tt := newTestTunnels(t, Config{})
+
+	// Sent before the handshake completes, so it waits for it
+	ping := testPacket(t, "ping")
+	if err := tt.a.WritePacket(ping); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	if got := readPacket(t, tt.b); !bytes.Equal(got, ping) {
+		t.Errorf("b received %x, want %x", got, ping)
+	}
+	if peer := tt.b.Peer(); peer != tt.aConn.addr {
+		t.Errorf("b.Peer() = %v, want %v", peer, tt.aConn.addr)
+	}
+
+	pong := testPacket(t, "pong")
+	if err := tt.b.WritePacket(pong); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	if got := readPacket(t, tt.a); !bytes.Equal(got, pong) {
+		t.Errorf("a received %x, want %x", got, pong)
+	}
+
+	for _, tun := range []*Tunnel{tt.a, tt.b} {
+		if stats := tun.Stats(); stats.Handshakes != 1 || stats.Sent != 1 || stats.Received != 1 || stats.Invalid != 0 {
+			t.Errorf("%s Stats() = %+v, want 1 handshake, 1 sent, 1 received", tun.PublicKey(), stats)
+		}
+	}
+
+	// Padding hides the packet length
+	for _, msg := range tt.aConn.transports() {
+		if (len(msg)-transportHeaderSize-poly1305TagSize)%16 != 0 {
+			t.Errorf("transport message of %d bytes not padded", len(msg))
+		}
+	}
+
+	if err := tt.a.WritePacket(make([]byte, DefaultMTU+1)); err == nil {
+		t.Error("WritePacket() larger than the MTU succeeded")
+	}
+}
+
+func TestTunnelReplay(t *testing.T) {
+	tt := newTestTunnels(t, Config{})
+	if err := tt.a.WritePacket(testPacket(t, "once")); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	readPacket(t, tt.b)
+
+	msgs := tt.aConn.transports()
+	replayed := msgs[len(msgs)-1]
+	tt.aConn.deliver(replayed, tt.bConn.addr)
+	waitFor(t, "the replay to be rejected", func() bool { return tt.b.Stats().Invalid == 1 })
+
+	// Neither is a forged one accepted
+	forged := append([]byte(nil), replayed...)
+	forged[transportHeaderSize] ^= 1
+	forged[8]++ // Counter
+	tt.aConn.deliver(forged, tt.bConn.addr)
+	waitFor(t, "the forgery to be rejected", func() bool { return tt.b.Stats().Invalid == 2 })
+
+	if stats := tt.b.Stats(); stats.Received != 1 {
+		t.Errorf("Stats() = %+v, want 1 received", stats)
+	}
+}
+
+func TestTunnelRekey(t *testing.T) {
+	tt := newTestTunnels(t, Config{})
+	if err := tt.a.WritePacket(testPacket(t, "first")); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	readPacket(t, tt.b)
+
+	tt.a.mu.Lock()
+	first := tt.a.current.localIndex
+	tt.a.mu.Unlock()
+
+	// Past RekeyAfter, the next packet goes on the old session and starts
+	// a new handshake
+	tt.clock.advance(DefaultRekeyAfter)
+	if err := tt.a.WritePacket(testPacket(t, "second")); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	readPacket(t, tt.b)
+	waitFor(t, "the rekey", func() bool { return tt.a.Stats().Handshakes == 2 })
+	waitFor(t, "the peer to confirm the new session", func() bool { return tt.b.Stats().Handshakes == 2 })
+
+	tt.a.mu.Lock()
+	rotated, previous := tt.a.current.localIndex, tt.a.previous.localIndex
+	tt.a.mu.Unlock()
+	if rotated == first || previous != first {
+		t.Errorf("current session %d, previous %d, want a new one replacing %d", rotated, previous, first)
+	}
+
+	// The old session is dropped once it is too old
+	third := testPacket(t, "third")
+	tt.clock.advance(DefaultRekeyAfter)
+	tt.a.tick()
+	tt.a.mu.Lock()
+	gone := tt.a.previous == nil
+	tt.a.mu.Unlock()
+	if !gone {
+		t.Error("session older than RejectAfter kept")
+	}
+	if err := tt.b.WritePacket(third); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	if got := readPacket(t, tt.a); !bytes.Equal(got, third) {
+		t.Errorf("a received %x, want %x", got, third)
+	}
+}
+
+func TestTunnelRekeyAfterMessages(t *testing.T) {
+	tt := newTestTunnels(t, Config{RekeyAfterMessages: 3})
+	for i := 0; i < 4; i++ {
+		if err := tt.a.WritePacket(testPacket(t, "data")); err != nil {
+			t.Fatalf("WritePacket() error = %v", err)
+		}
+		readPacket(t, tt.b)
+	}
+	waitFor(t, "the rekey", func() bool { return tt.a.Stats().Handshakes >= 2 })
+}
+
+func TestTunnelKeepalive(t *testing.T) {
+	tt := newTestTunnels(t, Config{})
+	if err := tt.a.WritePacket(testPacket(t, "data")); err != nil {
+		t.Fatalf("WritePacket() error = %v", err)
+	}
+	readPacket(t, tt.b)
+	keepalives := tt.a.Stats().Keepalives // The one confirming the handshake
+
+	// b got data and has nothing to reply with
+	tt.clock.advance(DefaultKeepaliveTimeout - time.Second)
+	tt.b.tick()
+	if n := tt.b.Stats().Keepalives; n != 0 {
+		t.Fatalf("%d keepalives sent before the keepalive timeout", n)
+	}
+	tt.clock.advance(time.Second)
+	tt.b.tick()
+	if n := tt.b.Stats().Keepalives; n != 1 {
+		t.Errorf("%d keepalives sent at the keepalive timeout, want 1", n)
+	}
+	tt.b.tick()
+	if n := tt.b.Stats().Keepalives; n != 1 {
+		t.Errorf("%d keepalives sent without new data, want 1", n)
+	}
+
+	// Keepalives are not delivered as packets
+	if n := tt.a.Stats().Received; n != 0 {
+		t.Errorf("a received %d packets, want 0", n)
+	}
+	if n := tt.a.Stats().Keepalives; n != keepalives {
+		t.Errorf("a sent %d keepalives, want %d", n, keepalives)
+	}
+}
+
+func TestTunnelPersistentKeepalive(t *testing.T) {
+	tt := newTestTunnels(t, Config{PersistentKeepalive: 25 * time.Second})
+	waitFor(t, "the handshake", func() bool { return tt.a.Stats().Handshakes == 1 })
+	before := tt.a.Stats().Keepalives
+
+	tt.clock.advance(25 * time.Second)
+	tt.a.tick()
+	if n := tt.a.Stats().Keepalives; n != before+1 {
+		t.Errorf("%d keepalives sent, want %d", n, before+1)
+	}
+}
+
+func TestTunnelUnknownPeer(t *testing.T) {
+	tt := newTestTunnels(t, Config{})
+	var n network
+	n.conns = map[udp.Address]*conn{tt.bConn.addr: tt.bConn}
+	stranger, err := New(n.conn(51822), Config{
+		PrivateKey:    mustGenerateKey(t),
+		PeerPublicKey: tt.b.PublicKey(),
+		Peer:          tt.bConn.addr,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	invalid := tt.b.Stats().Invalid
+	stranger.Start()
+	defer stranger.Close()
+
+	waitFor(t, "the handshake to be rejected", func() bool { return tt.b.Stats().Invalid == invalid+1 })
+	if stats := stranger.Stats(); stats.Handshakes != 0 {
+		t.Errorf("Stats() = %+v, want no handshake", stats)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	var n network
+	key := mustGenerateKey(t)
+	tests := []struct {
+		name   string
+		conn   net.PacketConn
+		config Config
+	}{
+		{"no connection", nil, Config{PrivateKey: key, PeerPublicKey: key}},
+		{"no private key", n.conn(1), Config{PeerPublicKey: key}},
+		{"no peer key", n.conn(1), Config{PrivateKey: key}},
+		{"negative MTU", n.conn(1), Config{PrivateKey: key, PeerPublicKey: key, MTU: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.conn, tt.config); err == nil {
+				t.Error("New() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package tuntap
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"syscall"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// TAP is a virtual Ethernet device. Frames written by the stack appear on the
+// kernel interface, and frames sent by the kernel are returned by ReadFrame.
+type TAP struct {
+	*link
+
+	mu         sync.RWMutex
+	macAddress common.MACAddress
+}
+
+// Compile-time check that TAP implements ethernet.Device.
+var _ ethernet.Device = (*TAP)(nil)
+
+// OpenTAP creates a TAP device with the given name (e.g., "tap0" or "tap%d").
+// The stack side of the device is given a random locally administered MAC
+// address, distinct from the one the kernel assigns to its own side.
+func OpenTAP(name string) (*TAP, error) {
+	l, err := newLink(name, syscall.IFF_TAP|syscall.IFF_NO_PI)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := randomMAC()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return &TAP{
+		link:       l,
+		macAddress: mac,
+	}, nil
+}
+
+// MACAddress returns the MAC address used by the stack on this device.
+func (t *TAP) MACAddress() common.MACAddress {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.macAddress
+}
+
+// SetMACAddress overrides the MAC address used by the stack on this device.
+func (t *TAP) SetMACAddress(mac common.MACAddress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.macAddress = mac
+}
+
+// ReadFrame reads a single Ethernet frame from the device.
+// The frame's Payload lives in a pooled buffer; call Frame.Release once it
+// is no longer needed.
+func (t *TAP) ReadFrame() (*ethernet.Frame, error) {
+	buf := common.NewPooledBuffer(ethernet.TaggedFrameSize(t.MTU()))
+
+	n, err := t.file.Read(buf.Bytes())
+	if err != nil {
+		buf.Release()
+		return nil, fmt.Errorf("failed to read from %s: %w", t.name, err)
+	}
+	buf.SetLen(n)
+
+	return ethernet.ParseBuffer(buf)
+}
+
+// WriteFrame writes an Ethernet frame to the device.
+func (t *TAP) WriteFrame(frame *ethernet.Frame) error {
+	buf := common.NewPooledBuffer(frame.Size())
+	defer buf.Release()
+	data := buf.Bytes()
+	if _, err := frame.SerializeTo(data); err != nil {
+		return fmt.Errorf("failed to serialize frame: %w", err)
+	}
+
+	if _, err := t.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", t.name, err)
+	}
+
+	return nil
+}
+
+// randomMAC generates a random unicast, locally administered MAC address.
+func randomMAC() (common.MACAddress, error) {
+	var mac common.MACAddress
+	if _, err := rand.Read(mac[:]); err != nil {
+		return mac, fmt.Errorf("failed to generate MAC address: %w", err)
+	}
+
+	// Clear the multicast bit and set the locally administered bit
+	mac[0] = (mac[0] &^ 0x01) | 0x02
+	return mac, nil
+}
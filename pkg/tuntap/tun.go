@@ -0,0 +1,53 @@
+package tuntap
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// TUN is a virtual point-to-point IP device. Each read returns one raw IP
+// packet (IPv4 or IPv6) with no link-layer header.
+type TUN struct {
+	*link
+}
+
+// OpenTUN creates a TUN device with the given name (e.g., "tun0" or "tun%d").
+func OpenTUN(name string) (*TUN, error) {
+	l, err := newLink(name, syscall.IFF_TUN|syscall.IFF_NO_PI)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TUN{link: l}, nil
+}
+
+// ReadPacket reads a single IP packet from the device.
+func (t *TUN) ReadPacket() ([]byte, error) {
+	// The MTU bounds kernel-generated packets; leave headroom in case it
+	// is changed underneath us.
+	size := t.mtu
+	if size < 65535 {
+		size = 65535
+	}
+	buf := make([]byte, size)
+
+	n, err := t.file.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from %s: %w", t.name, err)
+	}
+
+	return buf[:n], nil
+}
+
+// WritePacket writes a single IP packet to the device.
+func (t *TUN) WritePacket(packet []byte) error {
+	if len(packet) == 0 {
+		return fmt.Errorf("empty packet")
+	}
+
+	if _, err := t.file.Write(packet); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", t.name, err)
+	}
+
+	return nil
+}
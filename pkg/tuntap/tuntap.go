@@ -0,0 +1,266 @@
+// Package tuntap implements Linux TUN/TAP virtual network devices.
+//
+// A TAP device carries Ethernet frames and implements ethernet.Device, so the
+// whole stack (ARP, IP, TCP, UDP) can run against it exactly as it would against
+// a physical NIC opened with ethernet.OpenInterface. A TUN device carries raw IP
+// packets and is useful for exercising the IP layer and above without ARP.
+//
+// Both devices are created through /dev/net/tun and require CAP_NET_ADMIN.
+// Because the kernel side of the device is an ordinary network interface, tests
+// can assign it an address and talk to the custom stack without touching real
+// hardware.
+package tuntap
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+const (
+	// CloneDevice is the path of the TUN/TAP clone device.
+	CloneDevice = "/dev/net/tun"
+
+	// ifNameSize is IFNAMSIZ from <linux/if.h>.
+	ifNameSize = 16
+)
+
+// ifReq mirrors struct ifreq from <linux/if.h> (40 bytes on Linux).
+type ifReq struct {
+	Name [ifNameSize]byte
+	Data [24]byte
+}
+
+// newIfReq creates an ifreq for the named interface.
+func newIfReq(name string) (*ifReq, error) {
+	if len(name) >= ifNameSize {
+		return nil, fmt.Errorf("interface name too long: %q (maximum %d bytes)", name, ifNameSize-1)
+	}
+	req := &ifReq{}
+	copy(req.Name[:], name)
+	return req, nil
+}
+
+// name returns the interface name stored in the ifreq.
+func (r *ifReq) name() string {
+	for i, b := range r.Name {
+		if b == 0 {
+			return string(r.Name[:i])
+		}
+	}
+	return string(r.Name[:])
+}
+
+// flags returns ifr_flags.
+func (r *ifReq) flags() uint16 {
+	return *(*uint16)(unsafe.Pointer(&r.Data[0]))
+}
+
+// setFlags sets ifr_flags.
+func (r *ifReq) setFlags(flags uint16) {
+	*(*uint16)(unsafe.Pointer(&r.Data[0])) = flags
+}
+
+// setInt32 sets the int-sized union member (ifr_mtu, ifr_ifindex).
+func (r *ifReq) setInt32(v int32) {
+	*(*int32)(unsafe.Pointer(&r.Data[0])) = v
+}
+
+// int32 returns the int-sized union member.
+func (r *ifReq) int32() int32 {
+	return *(*int32)(unsafe.Pointer(&r.Data[0]))
+}
+
+// setIPv4 stores an AF_INET sockaddr (ifr_addr, ifr_netmask).
+func (r *ifReq) setIPv4(addr common.IPv4Address) {
+	*(*uint16)(unsafe.Pointer(&r.Data[0])) = syscall.AF_INET
+	r.Data[2] = 0 // Port
+	r.Data[3] = 0
+	copy(r.Data[4:8], addr[:])
+}
+
+// ioctl issues an ioctl with an ifreq argument.
+func ioctl(fd uintptr, request uintptr, req *ifReq) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(req)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// controlIoctl issues an interface ioctl on a throwaway AF_INET socket.
+// Interface configuration requests (flags, MTU, addresses) are not valid on
+// the TUN/TAP file descriptor itself.
+func controlIoctl(request uintptr, req *ifReq) error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create control socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	return ioctl(uintptr(fd), request, req)
+}
+
+// openDevice creates (or attaches to) a TUN/TAP device.
+// The name may contain a "%d" pattern, in which case the kernel picks the
+// first free number. The returned name is the one assigned by the kernel.
+func openDevice(name string, flags uint16) (*os.File, string, error) {
+	req, err := newIfReq(name)
+	if err != nil {
+		return nil, "", err
+	}
+	req.setFlags(flags)
+
+	fd, err := syscall.Open(CloneDevice, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open %s: %w (you may need root/sudo)", CloneDevice, err)
+	}
+
+	if err := ioctl(uintptr(fd), syscall.TUNSETIFF, req); err != nil {
+		syscall.Close(fd)
+		return nil, "", fmt.Errorf("failed to create device %q: %w", name, err)
+	}
+
+	// Non-blocking mode lets the Go runtime poller drive reads, so Close (or a
+	// deadline) unblocks a pending ReadFrame instead of hanging forever.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, "", fmt.Errorf("failed to set non-blocking mode: %w", err)
+	}
+
+	ifname := req.name()
+	return os.NewFile(uintptr(fd), CloneDevice+":"+ifname), ifname, nil
+}
+
+// link holds the state shared by TUN and TAP devices.
+type link struct {
+	file  *os.File
+	name  string
+	index int
+	mtu   int
+}
+
+// Name returns the kernel interface name.
+func (l *link) Name() string {
+	return l.name
+}
+
+// Index returns the kernel interface index.
+func (l *link) Index() int {
+	return l.index
+}
+
+// MTU returns the device MTU.
+func (l *link) MTU() int {
+	return l.mtu
+}
+
+// Close closes the device. Non-persistent devices are removed by the kernel.
+func (l *link) Close() error {
+	return l.file.Close()
+}
+
+// SetUp brings the kernel side of the device up (or down).
+func (l *link) SetUp(up bool) error {
+	req, err := newIfReq(l.name)
+	if err != nil {
+		return err
+	}
+
+	if err := controlIoctl(syscall.SIOCGIFFLAGS, req); err != nil {
+		return fmt.Errorf("failed to get flags for %s: %w", l.name, err)
+	}
+
+	flags := req.flags()
+	if up {
+		flags |= syscall.IFF_UP
+	} else {
+		flags &^= syscall.IFF_UP
+	}
+	req.setFlags(flags)
+
+	if err := controlIoctl(syscall.SIOCSIFFLAGS, req); err != nil {
+		return fmt.Errorf("failed to set flags for %s: %w", l.name, err)
+	}
+	return nil
+}
+
+// SetMTU changes the device MTU.
+func (l *link) SetMTU(mtu int) error {
+	req, err := newIfReq(l.name)
+	if err != nil {
+		return err
+	}
+	req.setInt32(int32(mtu))
+
+	if err := controlIoctl(syscall.SIOCSIFMTU, req); err != nil {
+		return fmt.Errorf("failed to set MTU for %s: %w", l.name, err)
+	}
+	l.mtu = mtu
+	return nil
+}
+
+// SetKernelAddress assigns an IPv4 address and netmask to the kernel side of
+// the device. This is what the host's own network stack will use to talk to
+// the custom stack on the other end of the device.
+func (l *link) SetKernelAddress(addr, netmask common.IPv4Address) error {
+	req, err := newIfReq(l.name)
+	if err != nil {
+		return err
+	}
+	req.setIPv4(addr)
+	if err := controlIoctl(syscall.SIOCSIFADDR, req); err != nil {
+		return fmt.Errorf("failed to set address on %s: %w", l.name, err)
+	}
+
+	req, _ = newIfReq(l.name)
+	req.setIPv4(netmask)
+	if err := controlIoctl(syscall.SIOCSIFNETMASK, req); err != nil {
+		return fmt.Errorf("failed to set netmask on %s: %w", l.name, err)
+	}
+	return nil
+}
+
+// queryLink fills in the interface index and MTU after creation.
+func queryLink(name string) (index, mtu int, err error) {
+	req, err := newIfReq(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := controlIoctl(syscall.SIOCGIFINDEX, req); err != nil {
+		return 0, 0, fmt.Errorf("failed to get index for %s: %w", name, err)
+	}
+	index = int(req.int32())
+
+	req, _ = newIfReq(name)
+	if err := controlIoctl(syscall.SIOCGIFMTU, req); err != nil {
+		return 0, 0, fmt.Errorf("failed to get MTU for %s: %w", name, err)
+	}
+	mtu = int(req.int32())
+
+	return index, mtu, nil
+}
+
+// newLink opens a device and queries its kernel attributes.
+func newLink(name string, flags uint16) (*link, error) {
+	file, ifname, err := openDevice(name, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	index, mtu, err := queryLink(ifname)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &link{
+		file:  file,
+		name:  ifname,
+		index: index,
+		mtu:   mtu,
+	}, nil
+}
@@ -0,0 +1,125 @@
+package tuntap
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// requireTUN skips the test unless TUN/TAP devices can be created.
+func requireTUN(t *testing.T) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("TUN/TAP tests require root")
+	}
+	if _, err := os.Stat(CloneDevice); err != nil {
+		t.Skipf("%s not available: %v", CloneDevice, err)
+	}
+}
+
+func TestNewIfReq(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifname  string
+		wantErr bool
+	}{
+		{"short name", "tap0", false},
+		{"pattern", "tap%d", false},
+		{"maximum length", "abcdefghijklmno", false},
+		{"too long", "abcdefghijklmnop", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := newIfReq(tt.ifname)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newIfReq() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && req.name() != tt.ifname {
+				t.Errorf("name() = %q, want %q", req.name(), tt.ifname)
+			}
+		})
+	}
+}
+
+func TestRandomMAC(t *testing.T) {
+	mac, err := randomMAC()
+	if err != nil {
+		t.Fatalf("randomMAC() error = %v", err)
+	}
+
+	if mac[0]&0x01 != 0 {
+		t.Errorf("randomMAC() = %s, want unicast address", mac)
+	}
+	if mac[0]&0x02 == 0 {
+		t.Errorf("randomMAC() = %s, want locally administered address", mac)
+	}
+}
+
+func TestOpenTUN(t *testing.T) {
+	requireTUN(t)
+
+	tun, err := OpenTUN("nstun%d")
+	if err != nil {
+		t.Fatalf("OpenTUN() error = %v", err)
+	}
+	defer tun.Close()
+
+	if tun.Name() == "" || tun.Name() == "nstun%d" {
+		t.Errorf("Name() = %q, want kernel-assigned name", tun.Name())
+	}
+	if tun.Index() <= 0 {
+		t.Errorf("Index() = %d, want > 0", tun.Index())
+	}
+	if err := tun.SetMTU(1400); err != nil {
+		t.Fatalf("SetMTU() error = %v", err)
+	}
+	if tun.MTU() != 1400 {
+		t.Errorf("MTU() = %d, want 1400", tun.MTU())
+	}
+	if err := tun.WritePacket(nil); err == nil {
+		t.Error("WritePacket(nil) should fail")
+	}
+}
+
+func TestTAPARPResolve(t *testing.T) {
+	requireTUN(t)
+
+	tap, err := OpenTAP("nstap%d")
+	if err != nil {
+		t.Fatalf("OpenTAP() error = %v", err)
+	}
+	defer tap.Close()
+
+	kernelIP := common.IPv4Address{10, 213, 0, 1}
+	stackIP := common.IPv4Address{10, 213, 0, 2}
+
+	if err := tap.SetKernelAddress(kernelIP, common.IPv4Address{255, 255, 255, 0}); err != nil {
+		t.Fatalf("SetKernelAddress() error = %v", err)
+	}
+	if err := tap.SetUp(true); err != nil {
+		t.Fatalf("SetUp() error = %v", err)
+	}
+
+	// Run the ARP handler on the stack side and resolve the kernel side
+	handler := arp.NewHandler(tap, stackIP)
+	handler.SetTimeout(500 * time.Millisecond)
+	handler.SetMaxRetries(5)
+
+	stop, err := handler.Start()
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer close(stop)
+
+	mac, err := handler.Resolve(kernelIP)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if mac == tap.MACAddress() {
+		t.Errorf("Resolve() = %s, want kernel MAC distinct from stack MAC", mac)
+	}
+}
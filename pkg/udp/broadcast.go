@@ -0,0 +1,122 @@
+package udp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// DefaultMulticastTTL is the default TTL of the multicast datagrams a
+// socket sends, which keeps them on the local network (RFC 1112 Section
+// 6.1).
+const DefaultMulticastTTL = 1
+
+var (
+	// ErrBroadcastNotPermitted is returned by SendTo for a broadcast
+	// destination on a socket without OptBroadcast set, as EACCES is.
+	ErrBroadcastNotPermitted = errors.New("broadcast not permitted")
+
+	// ErrNotMulticast is returned for a group that is not a multicast
+	// address.
+	ErrNotMulticast = errors.New("not a multicast address")
+)
+
+// JoinGroup makes the socket receive the datagrams sent to a multicast
+// group, on the port it is bound to. Joining the group on the network,
+// with IGMP, is up to the caller.
+func (s *Socket) JoinGroup(group common.IPv4Address) error {
+	if !group.IsMulticast() {
+		return fmt.Errorf("%s: %w", group, ErrNotMulticast)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("socket is closed")
+	}
+	if s.groups == nil {
+		s.groups = make(map[common.IPv4Address]struct{})
+	}
+	s.groups[group] = struct{}{}
+	return nil
+}
+
+// LeaveGroup stops the socket receiving datagrams sent to a group it
+// joined.
+func (s *Socket) LeaveGroup(group common.IPv4Address) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, joined := s.groups[group]; !joined {
+		return fmt.Errorf("group %s not joined", group)
+	}
+	delete(s.groups, group)
+	return nil
+}
+
+// isBroadcast returns whether ip is the limited broadcast address or the
+// directed broadcast address of a network of the socket's demultiplexer.
+// Called with s.mu held.
+func (s *Socket) isBroadcast(ip common.IPv4Address) bool {
+	return ip.IsBroadcast() || (s.directedBroadcast != nil && s.directedBroadcast(ip))
+}
+
+// accepts returns whether the socket receives a datagram sent to the
+// broadcast or multicast address dst. A socket bound to a unicast address
+// receives neither; one bound to the wildcard address receives broadcasts
+// and the groups it joined.
+func (s *Socket) accepts(dst common.IPv4Address, broadcast bool) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.localAddr.IsIPv6() || (s.localAddr.IP != (common.IPv4Address{}) && s.localAddr.IP != dst) {
+		return false
+	}
+	if broadcast {
+		return true
+	}
+	_, joined := s.groups[dst]
+	return joined
+}
+
+// AddNetwork adds a network the stack has an address on, given an address
+// in it and its mask, so that datagrams to its directed broadcast address
+// are treated as broadcasts.
+func (d *Demultiplexer) AddNetwork(addr, mask common.IPv4Address) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.broadcasts[common.DirectedBroadcast(addr, mask)] = struct{}{}
+}
+
+// isDirectedBroadcast returns whether ip is the directed broadcast address
+// of one of the demultiplexer's networks.
+func (d *Demultiplexer) isDirectedBroadcast(ip common.IPv4Address) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.broadcasts[ip]
+	return ok
+}
+
+// DeliverTo delivers an incoming UDP packet sent to dst. A unicast packet
+// goes to the socket bound to its port, as with Deliver. A broadcast or
+// multicast one goes to that socket only if it accepts it, and is
+// otherwise dropped without an error: no ICMP error is sent about
+// broadcast or multicast datagrams (RFC 1122 Section 4.1.3.1).
+func (d *Demultiplexer) DeliverTo(pkt *Packet, srcAddr Address, dst common.IPv4Address) error {
+	broadcast := dst.IsBroadcast() || d.isDirectedBroadcast(dst)
+	if !broadcast && !dst.IsMulticast() {
+		return d.Deliver(pkt, srcAddr)
+	}
+
+	d.mu.RLock()
+	socket, exists := d.sockets[pkt.DestinationPort]
+	d.mu.RUnlock()
+
+	if !exists || !socket.accepts(dst, broadcast) {
+		counters.noPorts.Add(1)
+		return nil
+	}
+	return socket.Receive(pkt.Data, srcAddr)
+}
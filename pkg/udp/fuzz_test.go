@@ -0,0 +1,29 @@
+package udp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/internal/fuzzseed"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func FuzzParse(f *testing.F) {
+	seeds := append(fuzzseed.IPv4Payloads(f, uint8(common.ProtocolUDP)), fuzzseed.IPv6Payloads(f, uint8(common.ProtocolUDP))...)
+	fuzzseed.Add(f, seeds)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt, err := Parse(data)
+		if err != nil {
+			return
+		}
+
+		serialized, err := pkt.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		if !bytes.Equal(serialized, data[:pkt.Length]) {
+			t.Errorf("Serialize() = %x, want %x", serialized, data[:pkt.Length])
+		}
+	})
+}
@@ -29,51 +29,108 @@ type Packet struct {
 
 	// Payload
 	Data []byte // Packet data
+
+	// IP header values for the packet carrying the datagram, set by
+	// Socket.SendTo from the socket's options; a zero TTL leaves the IP
+	// layer's default. TOS is the IPv6 traffic class of datagrams sent
+	// over IPv6
+	TTL uint8
+	TOS uint8
+
+	// Device is the interface the datagram is to leave on, set by
+	// Socket.SendTo from OptBindToDevice; empty leaves the choice to the
+	// routing table
+	Device string
 }
 
 // Parse parses a UDP packet from raw bytes.
+// Data is copied, so the packet stays valid after data is reused; Decode
+// avoids the copy.
 func Parse(data []byte) (*Packet, error) {
-	if len(data) < HeaderLength {
-		return nil, fmt.Errorf("UDP packet too short: %d bytes (minimum %d)", len(data), HeaderLength)
+	pkt := &Packet{}
+	if err := pkt.Decode(data); err != nil {
+		return nil, err
 	}
+	if pkt.Data != nil {
+		pkt.Data = append([]byte(nil), pkt.Data...)
+	}
+	return pkt, nil
+}
 
-	pkt := &Packet{
-		SourcePort:      binary.BigEndian.Uint16(data[0:2]),
-		DestinationPort: binary.BigEndian.Uint16(data[2:4]),
-		Length:          binary.BigEndian.Uint16(data[4:6]),
-		Checksum:        binary.BigEndian.Uint16(data[6:8]),
+// Clone returns a copy of the packet with Data of its own, to keep a
+// packet decoded from a buffer that is reused.
+func (p *Packet) Clone() *Packet {
+	clone := *p
+	if p.Data != nil {
+		clone.Data = append([]byte(nil), p.Data...)
 	}
+	return &clone
+}
+
+// Decode parses a UDP packet from raw bytes into p, as Parse does, but Data
+// aliases data. Decoding into a reused Packet does not allocate.
+func (p *Packet) Decode(data []byte) error {
+	if len(data) < HeaderLength {
+		return fmt.Errorf("UDP packet too short: %d bytes (minimum %d)", len(data), HeaderLength)
+	}
+
+	p.SourcePort = binary.BigEndian.Uint16(data[0:2])
+	p.DestinationPort = binary.BigEndian.Uint16(data[2:4])
+	p.Length = binary.BigEndian.Uint16(data[4:6])
+	p.Checksum = binary.BigEndian.Uint16(data[6:8])
 
 	// Validate length field
-	if int(pkt.Length) < HeaderLength {
-		return nil, fmt.Errorf("invalid UDP length: %d (minimum %d)", pkt.Length, HeaderLength)
+	if int(p.Length) < HeaderLength {
+		return fmt.Errorf("invalid UDP length: %d (minimum %d)", p.Length, HeaderLength)
 	}
 
-	if int(pkt.Length) > len(data) {
-		return nil, fmt.Errorf("UDP length mismatch: header says %d, got %d bytes", pkt.Length, len(data))
+	if int(p.Length) > len(data) {
+		return fmt.Errorf("UDP length mismatch: header says %d, got %d bytes", p.Length, len(data))
 	}
 
 	// Extract data
-	if int(pkt.Length) > HeaderLength {
-		pkt.Data = make([]byte, int(pkt.Length)-HeaderLength)
-		copy(pkt.Data, data[HeaderLength:pkt.Length])
+	p.Data = nil
+	if int(p.Length) > HeaderLength {
+		p.Data = data[HeaderLength:p.Length:p.Length]
 	}
 
-	return pkt, nil
+	return nil
 }
 
 // Serialize converts the UDP packet to bytes.
 // Note: This does NOT calculate the checksum. Use CalculateChecksum separately.
 func (p *Packet) Serialize() ([]byte, error) {
-	// Calculate length
-	length := HeaderLength + len(p.Data)
-	if length > MaxPacketSize {
+	if length := p.Size(); length > MaxPacketSize {
 		return nil, fmt.Errorf("UDP packet too large: %d bytes (maximum %d)", length, MaxPacketSize)
 	}
-	p.Length = uint16(length)
 
 	// Allocate buffer
-	buf := make([]byte, length)
+	buf := make([]byte, p.Size())
+	n, err := p.SerializeTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Size returns the length of the serialized packet.
+func (p *Packet) Size() int {
+	return HeaderLength + len(p.Data)
+}
+
+// SerializeTo writes the packet into b, as Serialize does, and returns the
+// number of bytes written (Size). It updates Length.
+func (p *Packet) SerializeTo(b []byte) (int, error) {
+	// Calculate length
+	length := p.Size()
+	if length > MaxPacketSize {
+		return 0, fmt.Errorf("UDP packet too large: %d bytes (maximum %d)", length, MaxPacketSize)
+	}
+	if len(b) < length {
+		return 0, fmt.Errorf("buffer too small for UDP packet: %d bytes (need %d)", len(b), length)
+	}
+	p.Length = uint16(length)
+	buf := b[:length]
 
 	// Set source and destination ports
 	binary.BigEndian.PutUint16(buf[0:2], p.SourcePort)
@@ -86,11 +143,9 @@ func (p *Packet) Serialize() ([]byte, error) {
 	binary.BigEndian.PutUint16(buf[6:8], p.Checksum)
 
 	// Copy data
-	if len(p.Data) > 0 {
-		copy(buf[HeaderLength:], p.Data)
-	}
+	copy(buf[HeaderLength:], p.Data)
 
-	return buf, nil
+	return length, nil
 }
 
 // CalculateChecksum calculates the UDP checksum with the given pseudo-header.
@@ -100,33 +155,28 @@ func (p *Packet) Serialize() ([]byte, error) {
 // - Zero byte (1 byte)
 // - Protocol (1 byte) = 17 for UDP
 // - UDP Length (2 bytes)
+// The sum is computed directly over the header fields and data, without
+// serializing the packet.
 func (p *Packet) CalculateChecksum(srcIP, dstIP common.IPv4Address) (uint16, error) {
-	// Serialize the UDP packet first
-	udpData, err := p.Serialize()
+	return nonZero(p.checksum(srcIP, dstIP))
+}
+
+// CalculateChecksumIPv6 calculates the UDP checksum of a packet sent over
+// IPv6, with the IPv6 pseudo-header (RFC 8200 Section 8.1).
+func (p *Packet) CalculateChecksumIPv6(srcIP, dstIP common.IPv6Address) (uint16, error) {
+	return nonZero(p.checksumIPv6(srcIP, dstIP))
+}
+
+// nonZero returns a computed checksum as sent: a UDP checksum of 0 means
+// no checksum, so if the calculated checksum is 0, we should use 0xFFFF
+// instead (per RFC 768).
+func nonZero(checksum uint16, err error) (uint16, error) {
 	if err != nil {
 		return 0, err
 	}
-
-	// Construct pseudo-header
-	pseudoHeader := make([]byte, 12)
-	copy(pseudoHeader[0:4], srcIP[:])
-	copy(pseudoHeader[4:8], dstIP[:])
-	pseudoHeader[8] = 0 // Zero
-	pseudoHeader[9] = uint8(common.ProtocolUDP)
-	binary.BigEndian.PutUint16(pseudoHeader[10:12], p.Length)
-
-	// Combine pseudo-header and UDP packet
-	combined := append(pseudoHeader, udpData...)
-
-	// Calculate checksum
-	checksum := common.CalculateChecksum(combined)
-
-	// UDP checksum of 0 means no checksum, so if the calculated checksum is 0,
-	// we should use 0xFFFF instead (per RFC 768)
 	if checksum == 0 {
 		checksum = 0xFFFF
 	}
-
 	return checksum, nil
 }
 
@@ -137,28 +187,86 @@ func (p *Packet) VerifyChecksum(srcIP, dstIP common.IPv4Address) bool {
 		return true
 	}
 
+	return verified(p.checksum(srcIP, dstIP))
+}
+
+// VerifyChecksumIPv6 verifies the UDP checksum of a packet received over
+// IPv6. The checksum is mandatory over IPv6, so a zero checksum fails.
+func (p *Packet) VerifyChecksumIPv6(srcIP, dstIP common.IPv6Address) bool {
+	if p.Checksum == 0 {
+		counters.checksumErrors.Add(1)
+		return false
+	}
+	return verified(p.checksumIPv6(srcIP, dstIP))
+}
+
+// verified reports whether the checksum of a received packet checks out.
+func verified(checksum uint16, err error) bool {
+	if err != nil {
+		return false
+	}
+
 	// For verification, we check by calculating checksum of the whole thing
 	// (including the checksum field) - it should equal 0 or 0xFFFF
-	udpData, err := p.Serialize()
-	if err != nil {
+	if checksum != 0 && checksum != 0xFFFF {
+		counters.checksumErrors.Add(1)
 		return false
 	}
+	return true
+}
 
-	// Construct pseudo-header
-	pseudoHeader := make([]byte, 12)
-	copy(pseudoHeader[0:4], srcIP[:])
-	copy(pseudoHeader[4:8], dstIP[:])
-	pseudoHeader[8] = 0
-	pseudoHeader[9] = uint8(common.ProtocolUDP)
-	binary.BigEndian.PutUint16(pseudoHeader[10:12], p.Length)
+// checksum computes the Internet checksum over the pseudo-header and the
+// packet as SerializeTo would write it, including the current Checksum
+// field, without serializing it. Like SerializeTo, it updates Length.
+func (p *Packet) checksum(srcIP, dstIP common.IPv4Address) (uint16, error) {
+	if err := p.updateLength(); err != nil {
+		return 0, err
+	}
 
-	// Combine pseudo-header and UDP packet
-	combined := append(pseudoHeader, udpData...)
+	var c common.Checksummer
+	c.AddPseudoHeader(common.PseudoHeader{
+		SourceAddr:      srcIP,
+		DestinationAddr: dstIP,
+		Protocol:        common.ProtocolUDP,
+		Length:          p.Length,
+	})
+	return p.sum(&c), nil
+}
 
-	// Calculate checksum - should be 0 or 0xFFFF if valid
-	checksum := common.CalculateChecksum(combined)
+// checksumIPv6 is checksum with the IPv6 pseudo-header.
+func (p *Packet) checksumIPv6(srcIP, dstIP common.IPv6Address) (uint16, error) {
+	if err := p.updateLength(); err != nil {
+		return 0, err
+	}
+
+	var c common.Checksummer
+	c.AddPseudoHeaderIPv6(common.PseudoHeaderIPv6{
+		SourceAddr:      srcIP,
+		DestinationAddr: dstIP,
+		Length:          uint32(p.Length),
+		NextHeader:      common.ProtocolUDP,
+	})
+	return p.sum(&c), nil
+}
+
+// updateLength sets Length to the size of the packet.
+func (p *Packet) updateLength() error {
+	length := p.Size()
+	if length > MaxPacketSize {
+		return fmt.Errorf("UDP packet too large: %d bytes (maximum %d)", length, MaxPacketSize)
+	}
+	p.Length = uint16(length)
+	return nil
+}
 
-	return checksum == 0 || checksum == 0xFFFF
+// sum adds the packet to a checksum of its pseudo-header and returns it.
+func (p *Packet) sum(c *common.Checksummer) uint16 {
+	c.AddUint16(p.SourcePort)
+	c.AddUint16(p.DestinationPort)
+	c.AddUint16(p.Length)
+	c.AddUint16(p.Checksum)
+	c.Add(p.Data)
+	return c.Sum()
 }
 
 // String returns a human-readable representation of the UDP packet.
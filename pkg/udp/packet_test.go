@@ -281,6 +281,38 @@ func TestVerifyChecksum(t *testing.T) {
 	}
 }
 
+func TestChecksumIPv6(t *testing.T) {
+	srcIP, _ := common.ParseIPv6("2001:db8::1")
+	dstIP, _ := common.ParseIPv6("2001:db8::2")
+
+	p := NewPacket(8080, 53, []byte("Hello!"))
+	checksum, err := p.CalculateChecksumIPv6(srcIP, dstIP)
+	if err != nil {
+		t.Fatalf("CalculateChecksumIPv6() error = %v", err)
+	}
+	p.Checksum = checksum
+
+	// The checksum covers the serialized packet and the IPv6 pseudo-header
+	data, _ := p.Serialize()
+	ph := common.PseudoHeaderIPv6{SourceAddr: srcIP, DestinationAddr: dstIP, Length: uint32(len(data)), NextHeader: common.ProtocolUDP}
+	if got := common.CalculateChecksum(append(ph.Bytes(), data...)); got != 0 {
+		t.Errorf("checksum over the pseudo-header and packet = 0x%04X, want 0", got)
+	}
+
+	if !p.VerifyChecksumIPv6(srcIP, dstIP) {
+		t.Error("VerifyChecksumIPv6() = false, want true")
+	}
+	if p.VerifyChecksumIPv6(dstIP, dstIP) {
+		t.Error("VerifyChecksumIPv6() with the wrong source = true, want false")
+	}
+
+	// The checksum is mandatory over IPv6
+	p.Checksum = 0
+	if p.VerifyChecksumIPv6(srcIP, dstIP) {
+		t.Error("VerifyChecksumIPv6() of a zero checksum = true, want false")
+	}
+}
+
 func TestNewPacket(t *testing.T) {
 	srcPort := uint16(8080)
 	dstPort := uint16(80)
@@ -354,6 +386,59 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
+func TestDecodeAndSerializeTo(t *testing.T) {
+	srcIP := common.IPv4Address{192, 168, 1, 1}
+	dstIP := common.IPv4Address{192, 168, 1, 2}
+
+	pkt := NewPacket(8080, 53, []byte("query"))
+	pkt.Checksum, _ = pkt.CalculateChecksum(srcIP, dstIP)
+	want, _ := pkt.Serialize()
+
+	buf := bytes.Repeat([]byte{0xFF}, 100)
+	n, err := pkt.SerializeTo(buf)
+	if err != nil {
+		t.Fatalf("SerializeTo() error = %v", err)
+	}
+	if n != pkt.Size() || !bytes.Equal(buf[:n], want) {
+		t.Errorf("SerializeTo() = %x, want %x", buf[:n], want)
+	}
+	if _, err := pkt.SerializeTo(buf[:n-1]); err == nil {
+		t.Error("SerializeTo() into a short buffer succeeded")
+	}
+
+	// Decode aliases the input; Parse copies it
+	var decoded Packet
+	if err := decoded.Decode(buf[:n]); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	parsed, err := Parse(buf[:n])
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	buf[HeaderLength] = 'Q'
+	if string(decoded.Data) != "Query" || string(parsed.Data) != "query" {
+		t.Errorf("Decode() data = %q, Parse() data = %q", decoded.Data, parsed.Data)
+	}
+	buf[HeaderLength] = 'q'
+
+	allocs := testing.AllocsPerRun(100, func() {
+		decoded.Decode(want)
+		decoded.VerifyChecksum(srcIP, dstIP)
+		decoded.SerializeTo(buf)
+	})
+	if allocs != 0 {
+		t.Errorf("Decode()/VerifyChecksum()/SerializeTo() allocs = %v, want 0", allocs)
+	}
+
+	// A clone keeps the decoded data once the input is reused
+	clone := decoded.Clone()
+	want[HeaderLength] = 'Q'
+	if string(clone.Data) != "query" {
+		t.Errorf("Clone() data = %q, changed with the input", clone.Data)
+	}
+	want[HeaderLength] = 'q'
+}
+
 func TestString(t *testing.T) {
 	pkt := NewPacket(8080, 80, []byte("Test"))
 	str := pkt.String()
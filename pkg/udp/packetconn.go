@@ -0,0 +1,203 @@
+package udp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// Network returns "udp", so that Address satisfies net.Addr.
+func (a Address) Network() string {
+	return "udp"
+}
+
+// PacketConn adapts a bound Socket to net.PacketConn, so that protocols
+// written against the standard interface (such as QUIC) can run over this
+// stack.
+type PacketConn struct {
+	socket *Socket
+
+	// send transmits a datagram built by the socket; the network stack
+	// wraps it in an IP packet
+	send func(*Packet, Address) error
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	deadlineReset chan struct{}
+}
+
+// NewPacketConn creates a PacketConn over a bound socket. send is called
+// with each outgoing UDP packet and its destination.
+func NewPacketConn(s *Socket, send func(*Packet, Address) error) *PacketConn {
+	return &PacketConn{
+		socket:        s,
+		send:          send,
+		deadlineReset: make(chan struct{}),
+	}
+}
+
+// Socket returns the underlying socket.
+func (c *PacketConn) Socket() *Socket {
+	return c.socket
+}
+
+// ReadFrom reads the next datagram into p. It blocks until one arrives,
+// the read deadline passes or the socket is closed.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		deadline, reset := c.readDeadline, c.deadlineReset
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case msg, ok := <-c.socket.receiveBuf:
+			stopTimer(timer)
+			if !ok {
+				return 0, nil, c.opError("read", net.ErrClosed)
+			}
+			c.socket.dequeued(msg)
+			return copy(p, msg.Data), msg.From, nil
+		case err := <-c.socket.errs:
+			stopTimer(timer)
+			return 0, nil, c.opError("read", err)
+		case <-timeout:
+			return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+		case <-reset:
+			// The deadline changed; wait again with the new one
+			stopTimer(timer)
+		}
+	}
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
+
+// WriteTo sends p to addr, which must be an Address or a *net.UDPAddr.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	to, err := toAddress(addr)
+	if err != nil {
+		return 0, c.opError("write", err)
+	}
+
+	pkt, err := c.socket.SendTo(p, to)
+	if err != nil {
+		return 0, c.opError("write", err)
+	}
+	if err := c.send(pkt, to); err != nil {
+		return 0, c.opError("write", err)
+	}
+	return len(p), nil
+}
+
+// toAddress converts a net.Addr to a UDP address of this stack.
+func toAddress(addr net.Addr) (Address, error) {
+	switch a := addr.(type) {
+	case Address:
+		return a, nil
+	case *Address:
+		return *a, nil
+	case *net.UDPAddr:
+		if ip4 := a.IP.To4(); ip4 != nil {
+			var ip common.IPv4Address
+			copy(ip[:], ip4)
+			return Address{IP: ip, Port: uint16(a.Port)}, nil
+		}
+		ip16 := a.IP.To16()
+		if ip16 == nil {
+			return Address{}, fmt.Errorf("invalid IP address: %s", a)
+		}
+		var ip common.IPv6Address
+		copy(ip[:], ip16)
+		return Address{IPv6: ip, Port: uint16(a.Port)}, nil
+	default:
+		return Address{}, fmt.Errorf("unsupported address type %T", addr)
+	}
+}
+
+// Close closes the socket, unblocking pending reads.
+func (c *PacketConn) Close() error {
+	return c.socket.Close()
+}
+
+// LocalAddr returns the address the socket is bound to.
+func (c *PacketConn) LocalAddr() net.Addr {
+	addr, _ := c.socket.LocalAddr()
+	return addr
+}
+
+// SetDeadline sets the read deadline; writes never block.
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for ReadFrom, including calls already
+// blocked. A zero time disables it.
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	close(c.deadlineReset)
+	c.deadlineReset = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline is a no-op: writes hand the packet to the stack
+// without waiting.
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: c.LocalAddr(), Err: err}
+}
+
+// Listen opens a PacketConn on a socket bound to addr and port, or to an
+// ephemeral port if port is 0, with demux delivering its datagrams.
+// Closing the connection unbinds its port.
+func Listen(demux *Demultiplexer, addr common.IPv4Address, port uint16, send func(*Packet, Address) error) (net.PacketConn, error) {
+	socket := NewSocket()
+
+	// The demultiplexer chooses the port, so the socket is bound to it
+	// after
+	port, err := demux.Bind(socket, port)
+	if err != nil {
+		return nil, err
+	}
+	if err := socket.Bind(Address{IP: addr, Port: port}); err != nil {
+		demux.Unbind(port)
+		return nil, fmt.Errorf("failed to bind socket: %w", err)
+	}
+	return &boundConn{PacketConn: NewPacketConn(socket, send), demux: demux, port: port}, nil
+}
+
+// boundConn is a connection Listen opened.
+type boundConn struct {
+	*PacketConn
+	demux *Demultiplexer
+	port  uint16
+}
+
+// Close unbinds the connection's port and closes its socket.
+func (c *boundConn) Close() error {
+	c.demux.Unbind(c.port)
+	return c.PacketConn.Close()
+}
@@ -0,0 +1,92 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+func TestPacketConn(t *testing.T) {
+	local := Address{IP: common.IPv4Address{10, 0, 0, 1}, Port: 5000}
+	remote := Address{IP: common.IPv4Address{10, 0, 0, 2}, Port: 6000}
+
+	s := NewSocket()
+	if err := s.Bind(local); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+
+	var sent *Packet
+	var sentTo Address
+	pc := NewPacketConn(s, func(pkt *Packet, to Address) error {
+		sent, sentTo = pkt, to
+		return nil
+	})
+
+	// WriteTo accepts both address types
+	udpAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 6000}
+	for _, addr := range []net.Addr{remote, udpAddr} {
+		if _, err := pc.WriteTo([]byte("ping"), addr); err != nil {
+			t.Fatalf("WriteTo(%v) error = %v", addr, err)
+		}
+		if sentTo != remote || sent.SourcePort != local.Port || sent.DestinationPort != remote.Port {
+			t.Errorf("sent %v to %v, want %v", sent, sentTo, remote)
+		}
+	}
+	if _, err := pc.WriteTo([]byte("x"), &net.UDPAddr{IP: net.ParseIP("::1"), Port: 1}); err == nil {
+		t.Error("WriteTo() to an IPv6 address succeeded")
+	}
+
+	s.Receive([]byte("pong"), remote)
+	buf := make([]byte, 16)
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	if string(buf[:n]) != "pong" || from != remote {
+		t.Errorf("ReadFrom() = %q from %v, want %q from %v", buf[:n], from, "pong", remote)
+	}
+	if pc.LocalAddr() != local || pc.LocalAddr().Network() != "udp" {
+		t.Errorf("LocalAddr() = %v, want %v", pc.LocalAddr(), local)
+	}
+}
+
+func TestPacketConnDeadline(t *testing.T) {
+	s := NewSocket()
+	s.Bind(Address{Port: 5000})
+	pc := NewPacketConn(s, func(*Packet, Address) error { return nil })
+
+	pc.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, _, err := pc.ReadFrom(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("ReadFrom() error = %v, want deadline exceeded", err)
+	}
+
+	// Moving the deadline into the past unblocks a pending read
+	pc.SetReadDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := pc.ReadFrom(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	pc.SetReadDeadline(time.Now())
+	if err := <-done; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("ReadFrom() error = %v, want deadline exceeded", err)
+	}
+
+	// Close unblocks reads with net.ErrClosed
+	pc.SetReadDeadline(time.Time{})
+	go func() {
+		_, _, err := pc.ReadFrom(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	pc.Close()
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("ReadFrom() after Close error = %v, want net.ErrClosed", err)
+	}
+}
@@ -1,11 +1,16 @@
 package udp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ports"
 )
 
 const (
@@ -15,21 +20,44 @@ const (
 	// DefaultReceiveTimeout is the default timeout for receiving packets.
 	DefaultReceiveTimeout = 5 * time.Second
 
-	// EphemeralPortStart is the start of the ephemeral port range.
-	EphemeralPortStart = 49152
+	// EphemeralPortStart is the start of the default ephemeral port range.
+	EphemeralPortStart = ports.EphemeralStart
 
-	// EphemeralPortEnd is the end of the ephemeral port range.
-	EphemeralPortEnd = 65535
+	// EphemeralPortEnd is the end of the default ephemeral port range.
+	EphemeralPortEnd = ports.EphemeralEnd
 )
 
-// Address represents a UDP endpoint (IP address and port).
+// Address represents a UDP endpoint (IP address and port). An IPv6
+// endpoint has IPv6 set and IP zero. With both zero the address is the
+// wildcard address of both families, so a socket bound to it is
+// dual-stack.
 type Address struct {
 	IP   common.IPv4Address
 	Port uint16
+	IPv6 common.IPv6Address
+}
+
+// IsIPv6 returns true if this is an IPv6 endpoint.
+func (a Address) IsIPv6() bool {
+	return !a.IPv6.IsUnspecified()
+}
+
+// isWildcard returns true if the address is the wildcard address.
+func (a Address) isWildcard() bool {
+	return !a.IsIPv6() && a.IP == common.IPv4Address{}
+}
+
+// sameFamily returns whether a socket bound to a can exchange datagrams
+// with b: a wildcard address goes with either family.
+func (a Address) sameFamily(b Address) bool {
+	return a.isWildcard() || b.isWildcard() || a.IsIPv6() == b.IsIPv6()
 }
 
 // String returns a human-readable representation of the address.
 func (a Address) String() string {
+	if a.IsIPv6() {
+		return fmt.Sprintf("[%s]:%d", a.IPv6, a.Port)
+	}
 	return fmt.Sprintf("%s:%d", a.IP, a.Port)
 }
 
@@ -44,8 +72,18 @@ type Socket struct {
 	// Local address (IP and port this socket is bound to)
 	localAddr Address
 
-	// Receive buffer
+	// Receive buffer, and the bytes of data queued in it
 	receiveBuf chan Message
+	queued     atomic.Int64
+
+	// Options set with SetSockOpt
+	opts socketOptions
+
+	// Multicast groups joined with JoinGroup
+	groups map[common.IPv4Address]struct{}
+
+	// Pending ICMP error, returned by the next receive
+	errs chan error
 
 	// Socket state
 	bound  bool
@@ -56,12 +94,18 @@ type Socket struct {
 
 	// Handler function for receiving packets (set by the network stack)
 	receiveHandler func(*Packet, Address)
+
+	// Reports whether an address is a directed broadcast address (set by
+	// the demultiplexer the socket is bound with)
+	directedBroadcast func(common.IPv4Address) bool
 }
 
 // NewSocket creates a new UDP socket.
 func NewSocket() *Socket {
 	return &Socket{
 		receiveBuf: make(chan Message, DefaultReceiveBufferSize),
+		errs:       make(chan error, 1),
+		opts:       defaultSocketOptions,
 		bound:      false,
 		closed:     false,
 	}
@@ -83,6 +127,7 @@ func (s *Socket) Bind(addr Address) error {
 
 	s.localAddr = addr
 	s.bound = true
+	s.trackBinding(true)
 
 	return nil
 }
@@ -102,6 +147,10 @@ func (s *Socket) LocalAddr() (Address, error) {
 // SendTo sends data to the specified destination address.
 // This returns the UDP packet that should be sent (the caller is responsible
 // for wrapping it in an IP packet and sending it on the network).
+// Sending to a broadcast address needs OptBroadcast set; datagrams to a
+// multicast group are sent with the OptMulticastTTL TTL (the hop limit,
+// over IPv6). A socket bound to an address of one family cannot send to
+// the other.
 func (s *Socket) SendTo(data []byte, to Address) (*Packet, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -114,8 +163,23 @@ func (s *Socket) SendTo(data []byte, to Address) (*Packet, error) {
 		return nil, fmt.Errorf("socket not bound")
 	}
 
+	if !s.localAddr.sameFamily(to) {
+		return nil, fmt.Errorf("cannot send to %s from %s: address family mismatch", to, s.localAddr)
+	}
+
+	ttl := s.opts.ttl
+	switch {
+	case to.IP.IsMulticast() || to.IPv6.IsMulticast():
+		ttl = s.opts.multicastTTL
+	case s.isBroadcast(to.IP) && !s.opts.broadcast:
+		return nil, fmt.Errorf("%s: %w", to, ErrBroadcastNotPermitted)
+	}
+
 	// Create UDP packet
 	pkt := NewPacket(s.localAddr.Port, to.Port, data)
+	pkt.TTL, pkt.TOS = ttl, s.opts.tos
+	pkt.Device = s.opts.device
+	counters.datagramsSent.Add(1)
 
 	return pkt, nil
 }
@@ -123,6 +187,19 @@ func (s *Socket) SendTo(data []byte, to Address) (*Packet, error) {
 // RecvFrom receives data from the socket with a timeout.
 // It returns the data and the source address.
 func (s *Socket) RecvFrom(timeout time.Duration) ([]byte, Address, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	data, from, err := s.RecvFromContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, Address{}, fmt.Errorf("receive timeout")
+	}
+	return data, from, err
+}
+
+// RecvFromContext receives data from the socket, blocking until a datagram
+// arrives or ctx is done. It returns the data and the source address.
+func (s *Socket) RecvFromContext(ctx context.Context) ([]byte, Address, error) {
 	s.mu.RLock()
 	if s.closed {
 		s.mu.RUnlock()
@@ -134,12 +211,15 @@ func (s *Socket) RecvFrom(timeout time.Duration) ([]byte, Address, error) {
 	}
 	s.mu.RUnlock()
 
-	// Wait for message or timeout
+	// Wait for message or cancellation
 	select {
 	case msg := <-s.receiveBuf:
+		s.dequeued(msg)
 		return msg.Data, msg.From, nil
-	case <-time.After(timeout):
-		return nil, Address{}, fmt.Errorf("receive timeout")
+	case err := <-s.errs:
+		return nil, Address{}, err
+	case <-ctx.Done():
+		return nil, Address{}, ctx.Err()
 	}
 }
 
@@ -164,13 +244,35 @@ func (s *Socket) Receive(data []byte, from Address) error {
 	}
 	copy(msg.Data, data)
 
-	// Try to send to receive buffer
+	// Try to send to receive buffer, within both its datagram and byte
+	// limits
+	if s.queued.Add(int64(len(data))) <= int64(s.opts.receiveBuffer) {
+		select {
+		case s.receiveBuf <- msg:
+			counters.datagramsReceived.Add(1)
+			return nil
+		default:
+		}
+	}
+	// Buffer full, drop packet
+	s.queued.Add(-int64(len(data)))
+	counters.receiveErrors.Add(1)
+	return fmt.Errorf("receive buffer full, packet dropped")
+}
+
+// dequeued removes a message read by the application from the bytes
+// queued in the receive buffer.
+func (s *Socket) dequeued(msg Message) {
+	s.queued.Add(-int64(len(msg.Data)))
+}
+
+// ReportError records an error, such as one ICMP reported for a datagram
+// the socket sent, for the next receive to return. Only the first of the
+// errors reported before a receive is kept.
+func (s *Socket) ReportError(err error) {
 	select {
-	case s.receiveBuf <- msg:
-		return nil
+	case s.errs <- err:
 	default:
-		// Buffer full, drop packet
-		return fmt.Errorf("receive buffer full, packet dropped")
 	}
 }
 
@@ -185,6 +287,7 @@ func (s *Socket) Close() error {
 
 	s.closed = true
 	close(s.receiveBuf)
+	s.trackBinding(false)
 
 	return nil
 }
@@ -204,47 +307,74 @@ func (s *Socket) IsBound() bool {
 }
 
 // Demultiplexer manages UDP sockets and routes incoming packets to the correct socket.
+// Ports are bound with a ports.Manager, on the address the socket is bound
+// to; the demultiplexer delivers to one socket per port. The port manager
+// tracks IPv4 addresses, so a socket bound to an IPv6 address holds its
+// port on every address.
 type Demultiplexer struct {
-	// Map of port -> socket
-	sockets map[uint16]*Socket
+	// Port manager
+	ports *ports.Manager
+
+	// Map of port -> socket, and the socket's binding
+	sockets  map[uint16]*Socket
+	bindings map[uint16]*ports.Binding
 
-	// Next ephemeral port to assign
-	nextEphemeralPort uint16
+	// Directed broadcast addresses of the networks added with AddNetwork
+	broadcasts map[common.IPv4Address]struct{}
 
 	// Mutex for thread-safety
 	mu sync.RWMutex
 }
 
-// NewDemultiplexer creates a new UDP demultiplexer.
+// NewDemultiplexer creates a new UDP demultiplexer with a port manager of its
+// own.
 func NewDemultiplexer() *Demultiplexer {
+	m, _ := ports.New(ports.Config{}) // The defaults are valid
+	return NewDemultiplexerWithPorts(m)
+}
+
+// NewDemultiplexerWithPorts creates a new UDP demultiplexer binding ports
+// with m.
+func NewDemultiplexerWithPorts(m *ports.Manager) *Demultiplexer {
 	return &Demultiplexer{
-		sockets:           make(map[uint16]*Socket),
-		nextEphemeralPort: EphemeralPortStart,
+		ports:      m,
+		sockets:    make(map[uint16]*Socket),
+		bindings:   make(map[uint16]*ports.Binding),
+		broadcasts: make(map[common.IPv4Address]struct{}),
 	}
 }
 
+// Ports returns the demultiplexer's port manager.
+func (d *Demultiplexer) Ports() *ports.Manager {
+	return d.ports
+}
+
 // Bind binds a socket to a port.
 // If the requested port is 0, an ephemeral port is assigned.
 func (d *Demultiplexer) Bind(socket *Socket, port uint16) (uint16, error) {
+	socket.mu.Lock()
+	addr := socket.localAddr.IP
+	socket.directedBroadcast = d.isDirectedBroadcast
+	socket.mu.Unlock()
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	// Assign ephemeral port if requested
-	if port == 0 {
-		assignedPort, err := d.allocateEphemeralPort()
-		if err != nil {
-			return 0, err
-		}
-		port = assignedPort
-	}
-
 	// Check if port is already in use
 	if _, exists := d.sockets[port]; exists {
 		return 0, fmt.Errorf("port %d already in use", port)
 	}
 
+	// Assign ephemeral port if requested
+	binding, err := d.ports.Bind(addr, port, ports.Options{})
+	if err != nil {
+		return 0, err
+	}
+	port = binding.Port
+
 	// Register socket
 	d.sockets[port] = socket
+	d.bindings[port] = binding
 
 	return port, nil
 }
@@ -259,6 +389,8 @@ func (d *Demultiplexer) Unbind(port uint16) error {
 	}
 
 	delete(d.sockets, port)
+	d.bindings[port].Release()
+	delete(d.bindings, port)
 	return nil
 }
 
@@ -268,9 +400,16 @@ func (d *Demultiplexer) Deliver(pkt *Packet, srcAddr Address) error {
 	socket, exists := d.sockets[pkt.DestinationPort]
 	d.mu.RUnlock()
 
+	if exists {
+		socket.mu.RLock()
+		exists = socket.localAddr.sameFamily(srcAddr)
+		socket.mu.RUnlock()
+	}
+
 	if !exists {
 		// No socket bound to this port - packet is dropped
 		// In a real implementation, we might send an ICMP Port Unreachable
+		counters.noPorts.Add(1)
 		return fmt.Errorf("no socket bound to port %d", pkt.DestinationPort)
 	}
 
@@ -278,29 +417,28 @@ func (d *Demultiplexer) Deliver(pkt *Packet, srcAddr Address) error {
 	return socket.Receive(pkt.Data, srcAddr)
 }
 
-// allocateEphemeralPort allocates an ephemeral port.
-// Must be called with d.mu held.
-func (d *Demultiplexer) allocateEphemeralPort() (uint16, error) {
-	// Try to find a free port
-	startPort := d.nextEphemeralPort
-	for {
-		if _, exists := d.sockets[d.nextEphemeralPort]; !exists {
-			port := d.nextEphemeralPort
-			d.nextEphemeralPort++
-			if d.nextEphemeralPort > EphemeralPortEnd {
-				d.nextEphemeralPort = EphemeralPortStart
-			}
-			return port, nil
-		}
-
-		d.nextEphemeralPort++
-		if d.nextEphemeralPort > EphemeralPortEnd {
-			d.nextEphemeralPort = EphemeralPortStart
-		}
+// DeliverICMPError reports an ICMP error about a datagram sent from one of
+// the demultiplexer's ports to the socket bound to it, which returns the
+// error from its next receive. It reports whether a socket was found.
+func (d *Demultiplexer) DeliverICMPError(msg *icmp.Message) bool {
+	icmpErr := msg.Err()
+	if icmpErr == nil {
+		return false
+	}
+	orig, err := msg.Original()
+	if err != nil || orig.Protocol != common.ProtocolUDP {
+		return false
+	}
+	local, remote := orig.Ports()
 
-		// Check if we've wrapped around
-		if d.nextEphemeralPort == startPort {
-			return 0, fmt.Errorf("no ephemeral ports available")
-		}
+	d.mu.RLock()
+	socket, exists := d.sockets[local]
+	d.mu.RUnlock()
+	if !exists {
+		return false
 	}
+
+	counters.icmpErrors.Add(1)
+	socket.ReportError(fmt.Errorf("%s: %w", Address{IP: orig.Destination, Port: remote}, icmpErr))
+	return true
 }
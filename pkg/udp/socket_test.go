@@ -1,10 +1,13 @@
 package udp
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/icmp"
 )
 
 func TestNewSocket(t *testing.T) {
@@ -137,6 +140,29 @@ func TestSocketSendTo(t *testing.T) {
 	}
 }
 
+func TestSocketTrafficClass(t *testing.T) {
+	s := NewSocket()
+	if err := s.Bind(Address{IP: common.IPv4Address{192, 168, 1, 100}, Port: 8081}); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	defer s.Close()
+
+	if err := s.SetTrafficClass(34); err != nil {
+		t.Fatalf("SetTrafficClass() error = %v", err)
+	}
+	pkt, err := s.SendTo([]byte("video"), Address{IP: common.IPv4Address{192, 168, 1, 1}, Port: 5004})
+	if err != nil {
+		t.Fatalf("SendTo() error = %v", err)
+	}
+	if pkt.TOS != 34<<2 || s.TrafficClass() != 34 {
+		t.Errorf("TOS = %#x, TrafficClass() = %d, want %#x, 34", pkt.TOS, s.TrafficClass(), 34<<2)
+	}
+
+	if err := s.SetTrafficClass(64); !errors.Is(err, common.ErrInvalidOptionValue) {
+		t.Errorf("SetTrafficClass(64) error = %v, want %v", err, common.ErrInvalidOptionValue)
+	}
+}
+
 func TestSocketReceive(t *testing.T) {
 	s := NewSocket()
 	localAddr := Address{
@@ -199,6 +225,35 @@ func TestSocketRecvFromTimeout(t *testing.T) {
 	}
 }
 
+func TestSocketRecvFromContext(t *testing.T) {
+	s := NewSocket()
+	if err := s.Bind(Address{IP: common.IPv4Address{192, 168, 1, 100}, Port: 8080}); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+
+	// Cancelling the context unblocks a waiting receive
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, _, err := s.RecvFromContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("RecvFromContext() error = %v, want %v", err, context.Canceled)
+	}
+
+	from := Address{IP: common.IPv4Address{192, 168, 1, 1}, Port: 5353}
+	if err := s.Receive([]byte("hello"), from); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	data, got, err := s.RecvFromContext(context.Background())
+	if err != nil {
+		t.Fatalf("RecvFromContext() error = %v", err)
+	}
+	if string(data) != "hello" || got != from {
+		t.Errorf("RecvFromContext() = %q from %v, want %q from %v", data, got, "hello", from)
+	}
+}
+
 func TestSocketClose(t *testing.T) {
 	s := NewSocket()
 	localAddr := Address{
@@ -249,6 +304,56 @@ func TestAddressString(t *testing.T) {
 	if addr.String() != expected {
 		t.Errorf("Address.String() = %v, want %v", addr.String(), expected)
 	}
+
+	ip6, _ := common.ParseIPv6("2001:db8::1")
+	addr = Address{IPv6: ip6, Port: 53}
+	if got, want := addr.String(), "[2001:db8::1]:53"; got != want {
+		t.Errorf("Address.String() = %v, want %v", got, want)
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	ip6, _ := common.ParseIPv6("2001:db8::1")
+	peer6, _ := common.ParseIPv6("2001:db8::2")
+	v4 := Address{IP: common.IPv4Address{192, 168, 1, 1}, Port: 12345}
+	v6 := Address{IPv6: peer6, Port: 12345}
+
+	tests := []struct {
+		name   string
+		bind   Address
+		wantV4 bool
+		wantV6 bool
+	}{
+		{"wildcard", Address{Port: 5000}, true, true},
+		{"IPv4", Address{IP: common.IPv4Address{192, 168, 1, 100}, Port: 5000}, true, false},
+		{"IPv6", Address{IPv6: ip6, Port: 5000}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDemultiplexer()
+			s := NewSocket()
+			if err := s.Bind(tt.bind); err != nil {
+				t.Fatalf("Bind() error = %v", err)
+			}
+			if _, err := d.Bind(s, 5000); err != nil {
+				t.Fatalf("Demultiplexer.Bind() error = %v", err)
+			}
+
+			for _, peer := range []struct {
+				from Address
+				want bool
+			}{{v4, tt.wantV4}, {v6, tt.wantV6}} {
+				err := d.Deliver(NewPacket(peer.from.Port, 5000, []byte("x")), peer.from)
+				if got := err == nil; got != peer.want {
+					t.Errorf("Deliver() from %v error = %v, want delivered = %v", peer.from, err, peer.want)
+				}
+				if _, err := s.SendTo([]byte("x"), peer.from); (err == nil) != peer.want {
+					t.Errorf("SendTo(%v) error = %v, want sent = %v", peer.from, err, peer.want)
+				}
+			}
+		})
+	}
 }
 
 func TestNewDemultiplexer(t *testing.T) {
@@ -414,3 +519,209 @@ func TestDemultiplexerEphemeralPortAllocation(t *testing.T) {
 		ports[port] = true
 	}
 }
+
+func TestDemultiplexerReservedPorts(t *testing.T) {
+	d := NewDemultiplexer()
+	d.Ports().Reserve(EphemeralPortStart)
+
+	port, err := d.Bind(NewSocket(), 0)
+	if err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	if port != EphemeralPortStart+1 {
+		t.Errorf("Bind() returned port %d, want %d past the reserved port", port, EphemeralPortStart+1)
+	}
+
+	// Reserved ports can still be bound explicitly
+	if _, err := d.Bind(NewSocket(), EphemeralPortStart); err != nil {
+		t.Errorf("Bind() to a reserved port error = %v", err)
+	}
+}
+
+func TestDemultiplexerDeliverICMPError(t *testing.T) {
+	d := NewDemultiplexer()
+	s := NewSocket()
+	s.Bind(Address{IP: common.IPv4Address{10, 0, 0, 1}})
+	port, err := d.Bind(s, 0)
+	if err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+
+	// The quoted IP header and UDP header of a datagram to 10.0.0.2:53
+	quoted := []byte{
+		0x45, 0x00, 0x00, 0x24, 0x00, 0x00, 0x40, 0x00,
+		0x40, 0x11, 0x00, 0x00,
+		10, 0, 0, 1,
+		10, 0, 0, 2,
+		byte(port >> 8), byte(port), 0x00, 0x35, 0x00, 0x10, 0x00, 0x00,
+	}
+	if !d.DeliverICMPError(icmp.NewDestinationUnreachable(icmp.CodePortUnreachable, quoted)) {
+		t.Fatal("DeliverICMPError() = false, want true")
+	}
+	if d.DeliverICMPError(icmp.NewEchoReply(1, 1, quoted)) {
+		t.Error("DeliverICMPError() of an Echo Reply = true, want false")
+	}
+
+	_, _, err = s.RecvFrom(time.Second)
+	if !errors.Is(err, icmp.ErrPortUnreachable) {
+		t.Fatalf("RecvFrom() error = %v, want %v", err, icmp.ErrPortUnreachable)
+	}
+	if _, _, err := s.RecvFrom(10 * time.Millisecond); errors.Is(err, icmp.ErrPortUnreachable) {
+		t.Error("RecvFrom() returned the error twice")
+	}
+}
+
+func TestSocketSockOpt(t *testing.T) {
+	s := NewSocket()
+	if err := s.Bind(Address{IP: common.IPv4Address{192, 168, 1, 100}, Port: 8080}); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	if err := s.SetSockOpt(common.OptNoDelay, true); !errors.Is(err, common.ErrOptionNotSupported) {
+		t.Errorf("SetSockOpt(OptNoDelay) error = %v, want %v", err, common.ErrOptionNotSupported)
+	}
+	if err := s.SetSockOpt(common.OptTTL, "1"); !errors.Is(err, common.ErrInvalidOptionValue) {
+		t.Errorf("SetSockOpt(OptTTL, \"1\") error = %v, want %v", err, common.ErrInvalidOptionValue)
+	}
+
+	// TTL, TOS and the device are set on the datagrams sent
+	if err := s.SetSockOpt(common.OptTTL, 1); err != nil {
+		t.Fatalf("SetSockOpt(OptTTL) error = %v", err)
+	}
+	if err := s.SetSockOpt(common.OptTOS, 0x10); err != nil {
+		t.Fatalf("SetSockOpt(OptTOS) error = %v", err)
+	}
+	if err := s.SetSockOpt(common.OptBindToDevice, "eth1"); err != nil {
+		t.Fatalf("SetSockOpt(OptBindToDevice) error = %v", err)
+	}
+	pkt, err := s.SendTo([]byte("x"), Address{IP: common.IPv4Address{192, 168, 1, 1}, Port: 53})
+	if err != nil {
+		t.Fatalf("SendTo() error = %v", err)
+	}
+	if pkt.TTL != 1 || pkt.TOS != 0x10 || pkt.Device != "eth1" {
+		t.Errorf("SendTo() TTL = %d, TOS = %#x, device %q, want 1, 0x10 and eth1", pkt.TTL, pkt.TOS, pkt.Device)
+	}
+
+	// Datagrams past the receive buffer size are dropped until it is read
+	if err := s.SetSockOpt(common.OptReceiveBuffer, 100); err != nil {
+		t.Fatalf("SetSockOpt(OptReceiveBuffer) error = %v", err)
+	}
+	if got, _ := s.GetSockOpt(common.OptReceiveBuffer); got != 100 {
+		t.Errorf("GetSockOpt(OptReceiveBuffer) = %v, want 100", got)
+	}
+	from := Address{IP: common.IPv4Address{192, 168, 1, 1}, Port: 53}
+	if err := s.Receive(make([]byte, 60), from); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	if err := s.Receive(make([]byte, 60), from); err == nil {
+		t.Error("Receive() past the receive buffer succeeded")
+	}
+	if _, _, err := s.RecvFrom(100 * time.Millisecond); err != nil {
+		t.Fatalf("RecvFrom() error = %v", err)
+	}
+	if err := s.Receive(make([]byte, 60), from); err != nil {
+		t.Errorf("Receive() after the read error = %v", err)
+	}
+}
+
+func TestSocketSendBroadcast(t *testing.T) {
+	d := NewDemultiplexer()
+	d.AddNetwork(common.IPv4Address{192, 168, 1, 100}, common.IPv4Address{255, 255, 255, 0})
+	s := NewSocket()
+	if err := s.Bind(Address{Port: 8081}); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	if _, err := d.Bind(s, 8081); err != nil {
+		t.Fatalf("Demultiplexer.Bind() error = %v", err)
+	}
+
+	for _, ip := range []common.IPv4Address{common.IPv4Broadcast, {192, 168, 1, 255}} {
+		to := Address{IP: ip, Port: 67}
+		if _, err := s.SendTo([]byte("x"), to); !errors.Is(err, ErrBroadcastNotPermitted) {
+			t.Errorf("SendTo(%v) error = %v, want %v", to, err, ErrBroadcastNotPermitted)
+		}
+	}
+
+	if err := s.SetSockOpt(common.OptBroadcast, true); err != nil {
+		t.Fatalf("SetSockOpt(OptBroadcast) error = %v", err)
+	}
+	if _, err := s.SendTo([]byte("x"), Address{IP: common.IPv4Address{192, 168, 1, 255}, Port: 67}); err != nil {
+		t.Errorf("SendTo() with OptBroadcast error = %v", err)
+	}
+
+	// Multicast needs no option, and goes out with the multicast TTL
+	pkt, err := s.SendTo([]byte("x"), Address{IP: common.IPv4Address{239, 1, 2, 3}, Port: 5000})
+	if err != nil {
+		t.Fatalf("SendTo(multicast) error = %v", err)
+	}
+	if pkt.TTL != DefaultMulticastTTL {
+		t.Errorf("SendTo(multicast) TTL = %d, want %d", pkt.TTL, DefaultMulticastTTL)
+	}
+}
+
+func TestDemultiplexerDeliverTo(t *testing.T) {
+	group := common.IPv4Address{239, 1, 2, 3}
+	tests := []struct {
+		name   string
+		bindIP common.IPv4Address
+		join   bool
+		dst    common.IPv4Address
+		want   bool
+	}{
+		{"limited broadcast", common.IPv4Address{}, false, common.IPv4Broadcast, true},
+		{"directed broadcast", common.IPv4Address{}, false, common.IPv4Address{192, 168, 1, 255}, true},
+		{"broadcast to a unicast binding", common.IPv4Address{192, 168, 1, 100}, false, common.IPv4Broadcast, false},
+		{"joined group", common.IPv4Address{}, true, group, true},
+		{"bound to the group", group, true, group, true},
+		{"group not joined", common.IPv4Address{}, false, group, false},
+		{"unicast", common.IPv4Address{192, 168, 1, 100}, false, common.IPv4Address{192, 168, 1, 100}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDemultiplexer()
+			d.AddNetwork(common.IPv4Address{192, 168, 1, 100}, common.IPv4Address{255, 255, 255, 0})
+			s := NewSocket()
+			if err := s.Bind(Address{IP: tt.bindIP, Port: 5000}); err != nil {
+				t.Fatalf("Bind() error = %v", err)
+			}
+			if _, err := d.Bind(s, 5000); err != nil {
+				t.Fatalf("Demultiplexer.Bind() error = %v", err)
+			}
+			if tt.join {
+				if err := s.JoinGroup(group); err != nil {
+					t.Fatalf("JoinGroup() error = %v", err)
+				}
+			}
+
+			from := Address{IP: common.IPv4Address{192, 168, 1, 1}, Port: 12345}
+			err := d.DeliverTo(NewPacket(from.Port, 5000, []byte("hello")), from, tt.dst)
+			if err != nil {
+				t.Fatalf("DeliverTo() error = %v", err)
+			}
+			if got := len(s.receiveBuf) == 1; got != tt.want {
+				t.Errorf("delivered = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocketLeaveGroup(t *testing.T) {
+	s := NewSocket()
+	if err := s.JoinGroup(common.IPv4Address{192, 168, 1, 1}); !errors.Is(err, ErrNotMulticast) {
+		t.Errorf("JoinGroup(unicast) error = %v, want %v", err, ErrNotMulticast)
+	}
+
+	group := common.IPv4Address{224, 0, 0, 251}
+	if err := s.JoinGroup(group); err != nil {
+		t.Fatalf("JoinGroup() error = %v", err)
+	}
+	if err := s.LeaveGroup(group); err != nil {
+		t.Fatalf("LeaveGroup() error = %v", err)
+	}
+	if err := s.LeaveGroup(group); err == nil {
+		t.Error("LeaveGroup() of a group left succeeded")
+	}
+	if s.accepts(group, false) {
+		t.Error("socket accepts datagrams of a group it left")
+	}
+}
@@ -0,0 +1,140 @@
+package udp
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+const (
+	// DefaultReceiveBufferBytes is the default limit on the bytes of data
+	// queued in a socket's receive buffer, Linux's net.core.rmem_default.
+	// The buffer also holds at most DefaultReceiveBufferSize datagrams.
+	DefaultReceiveBufferBytes = 212992
+
+	// DefaultTTL is the default TTL of the datagrams a socket sends.
+	DefaultTTL = 64
+)
+
+// socketOptions are the options set with Socket.SetSockOpt.
+type socketOptions struct {
+	receiveBuffer int
+	ttl           uint8
+	tos           uint8
+	broadcast     bool
+	multicastTTL  uint8
+	device        string
+}
+
+var defaultSocketOptions = socketOptions{
+	receiveBuffer: DefaultReceiveBufferBytes,
+	ttl:           DefaultTTL,
+	multicastTTL:  DefaultMulticastTTL,
+}
+
+// SetSockOpt sets a socket option, of the type documented with its
+// constant. UDP sockets support:
+//
+//   - OptReceiveBuffer, the bytes of data queued for receive before
+//     datagrams are dropped. Lowering it does not drop datagrams already
+//     queued.
+//   - OptTTL and OptTOS, set on the packets returned by SendTo for the
+//     caller to apply to their IP header.
+//   - OptBroadcast, which SendTo needs to send to a broadcast address.
+//   - OptMulticastTTL, the TTL of datagrams sent to a multicast group in
+//     place of OptTTL.
+//   - OptBindToDevice, the interface set on the packets returned by
+//     SendTo, for the caller to send them out of and to pick their source
+//     address from (see ip.RoutingTable.SelectSource).
+func (s *Socket) SetSockOpt(opt common.SocketOption, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch opt {
+	case common.OptReceiveBuffer:
+		size, err := common.IntOption(opt, value, 1, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		s.opts.receiveBuffer = size
+	case common.OptTTL:
+		ttl, err := common.IntOption(opt, value, 1, 255)
+		if err != nil {
+			return err
+		}
+		s.opts.ttl = uint8(ttl)
+	case common.OptTOS:
+		tos, err := common.IntOption(opt, value, 0, 255)
+		if err != nil {
+			return err
+		}
+		s.opts.tos = uint8(tos)
+	case common.OptBroadcast:
+		broadcast, err := common.BoolOption(opt, value)
+		if err != nil {
+			return err
+		}
+		s.opts.broadcast = broadcast
+	case common.OptMulticastTTL:
+		// A zero TTL on a Packet means the IP layer's default
+		ttl, err := common.IntOption(opt, value, 1, 255)
+		if err != nil {
+			return err
+		}
+		s.opts.multicastTTL = uint8(ttl)
+	case common.OptBindToDevice:
+		device, err := common.StringOption(opt, value, common.MaxDeviceNameLength)
+		if err != nil {
+			return err
+		}
+		s.opts.device = device
+	default:
+		return fmt.Errorf("%s: %w", opt, common.ErrOptionNotSupported)
+	}
+	return nil
+}
+
+// GetSockOpt returns the value of a socket option, of the type
+// SetSockOpt takes.
+func (s *Socket) GetSockOpt(opt common.SocketOption) (any, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch opt {
+	case common.OptReceiveBuffer:
+		return s.opts.receiveBuffer, nil
+	case common.OptTTL:
+		return int(s.opts.ttl), nil
+	case common.OptTOS:
+		return int(s.opts.tos), nil
+	case common.OptBroadcast:
+		return s.opts.broadcast, nil
+	case common.OptMulticastTTL:
+		return int(s.opts.multicastTTL), nil
+	case common.OptBindToDevice:
+		return s.opts.device, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", opt, common.ErrOptionNotSupported)
+	}
+}
+
+// SetTrafficClass sets the DSCP (0-63) of the datagrams the socket sends,
+// in the upper six bits of the TOS of the packets SendTo returns. Zero
+// leaves the default DSCP of the route, if it has one.
+func (s *Socket) SetTrafficClass(dscp uint8) error {
+	if dscp > 63 {
+		return fmt.Errorf("%s: %w: DSCP %d not in [0, 63]", common.OptTOS, common.ErrInvalidOptionValue, dscp)
+	}
+	s.mu.RLock()
+	tos := s.opts.tos
+	s.mu.RUnlock()
+	return s.SetSockOpt(common.OptTOS, int(dscp<<2|tos&0x3))
+}
+
+// TrafficClass returns the DSCP set with SetTrafficClass.
+func (s *Socket) TrafficClass() uint8 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.opts.tos >> 2
+}
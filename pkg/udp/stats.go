@@ -0,0 +1,35 @@
+package udp
+
+import "sync/atomic"
+
+// Stats holds UDP counters for the whole stack (the UDP group of RFC 4113).
+type Stats struct {
+	DatagramsSent     uint64 // Datagrams built by SendTo
+	DatagramsReceived uint64 // Datagrams queued on a socket
+	NoPorts           uint64 // Datagrams for a port with no socket
+	ReceiveErrors     uint64 // Datagrams dropped because a socket's queue was full
+	ChecksumErrors    uint64 // Datagrams that failed checksum verification
+	ICMPErrors        uint64 // ICMP errors reported to a socket
+}
+
+// counters are the package-wide counters behind GetStats.
+var counters struct {
+	datagramsSent     atomic.Uint64
+	datagramsReceived atomic.Uint64
+	noPorts           atomic.Uint64
+	receiveErrors     atomic.Uint64
+	checksumErrors    atomic.Uint64
+	icmpErrors        atomic.Uint64
+}
+
+// GetStats returns a snapshot of the UDP counters.
+func GetStats() Stats {
+	return Stats{
+		DatagramsSent:     counters.datagramsSent.Load(),
+		DatagramsReceived: counters.datagramsReceived.Load(),
+		NoPorts:           counters.noPorts.Load(),
+		ReceiveErrors:     counters.receiveErrors.Load(),
+		ChecksumErrors:    counters.checksumErrors.Load(),
+		ICMPErrors:        counters.icmpErrors.Load(),
+	}
+}
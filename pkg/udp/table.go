@@ -0,0 +1,59 @@
+package udp
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// BindingInfo describes a bound socket.
+type BindingInfo struct {
+	LocalAddr Address
+	RecvQueue int // Datagrams waiting for RecvFrom
+}
+
+// bindings tracks the sockets that are bound and not yet closed.
+var bindings = struct {
+	mu      sync.Mutex
+	sockets map[*Socket]struct{}
+}{
+	sockets: make(map[*Socket]struct{}),
+}
+
+// Bindings returns the bound sockets, sorted by local address.
+func Bindings() []BindingInfo {
+	bindings.mu.Lock()
+	sockets := make([]*Socket, 0, len(bindings.sockets))
+	for s := range bindings.sockets {
+		sockets = append(sockets, s)
+	}
+	bindings.mu.Unlock()
+
+	infos := make([]BindingInfo, 0, len(sockets))
+	for _, s := range sockets {
+		s.mu.RLock()
+		infos = append(infos, BindingInfo{LocalAddr: s.localAddr, RecvQueue: len(s.receiveBuf)})
+		s.mu.RUnlock()
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i].LocalAddr, infos[j].LocalAddr
+		if a.IP != b.IP {
+			return bytes.Compare(a.IP[:], b.IP[:]) < 0
+		}
+		return a.Port < b.Port
+	})
+	return infos
+}
+
+// trackBinding adds or removes a socket from the bindings. Called with
+// s.mu held.
+func (s *Socket) trackBinding(bound bool) {
+	bindings.mu.Lock()
+	defer bindings.mu.Unlock()
+	if bound {
+		bindings.sockets[s] = struct{}{}
+	} else {
+		delete(bindings.sockets, s)
+	}
+}
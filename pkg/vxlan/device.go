@@ -0,0 +1,223 @@
+package vxlan
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// maxPacketSize is the largest UDP payload a Device reads.
+const maxPacketSize = 65535
+
+// Config configures a Device.
+type Config struct {
+	VNI  uint32            // Network identifier of the overlay
+	Name string            // Default "vxlan<VNI>"
+	MAC  common.MACAddress // Address of the stack on the overlay; default random
+
+	// Remotes are the VTEPs frames to broadcast, multicast and unknown
+	// unicast addresses are flooded to, as copies (head-end replication).
+	Remotes []common.IPv4Address
+
+	Port       uint16        // Destination UDP port; default Port
+	AgeingTime time.Duration // Of learned FDB entries; default DefaultAgeingTime
+}
+
+// Stats are the counters of a Device.
+type Stats struct {
+	Encapsulated uint64 // Frames written to the underlay, each copy counted
+	Decapsulated uint64 // Frames received from the underlay
+	Flooded      uint64 // Frames written that were flooded to Remotes
+	Dropped      uint64 // Packets received that were malformed or for another VNI
+	Errors       uint64 // Frames that could not be written
+}
+
+// Device is a VXLAN tunnel endpoint on one overlay network. It implements
+// ethernet.Device, so the stack runs over the overlay as over any link:
+// frames written to it are encapsulated and sent over conn, and the frames
+// decapsulated from what conn receives are returned by ReadFrame.
+type Device struct {
+	conn   net.PacketConn
+	config Config
+	mac    common.MACAddress
+	fdb    *FDB
+
+	encapsulated atomic.Uint64
+	decapsulated atomic.Uint64
+	flooded      atomic.Uint64
+	dropped      atomic.Uint64
+	errors       atomic.Uint64
+
+	closeOnce sync.Once
+}
+
+// Compile-time check that Device implements ethernet.Device.
+var _ ethernet.Device = (*Device)(nil)
+
+// New creates a VTEP sending and receiving over conn, a UDP socket
+// normally bound to Port. The device closes conn when it is closed.
+func New(conn net.PacketConn, config Config) (*Device, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("connection is nil")
+	}
+	if config.VNI > MaxVNI {
+		return nil, fmt.Errorf("VNI %d out of range (maximum %d)", config.VNI, MaxVNI)
+	}
+	if config.Name == "" {
+		config.Name = fmt.Sprintf("vxlan%d", config.VNI)
+	}
+	if config.Port == 0 {
+		config.Port = Port
+	}
+	config.Remotes = append([]common.IPv4Address(nil), config.Remotes...)
+
+	mac := config.MAC
+	if mac == (common.MACAddress{}) {
+		if _, err := rand.Read(mac[:]); err != nil {
+			return nil, fmt.Errorf("failed to generate MAC address: %w", err)
+		}
+		// Clear the multicast bit and set the locally administered bit
+		mac[0] = (mac[0] &^ 0x01) | 0x02
+	}
+
+	return &Device{
+		conn:   conn,
+		config: config,
+		mac:    mac,
+		fdb:    NewFDB(config.AgeingTime),
+	}, nil
+}
+
+// Name returns the device name.
+func (d *Device) Name() string {
+	return d.config.Name
+}
+
+// MACAddress returns the address of the stack on the overlay.
+func (d *Device) MACAddress() common.MACAddress {
+	return d.mac
+}
+
+// Index returns 0: the device has no kernel interface.
+func (d *Device) Index() int {
+	return 0
+}
+
+// VNI returns the network identifier of the overlay.
+func (d *Device) VNI() uint32 {
+	return d.config.VNI
+}
+
+// FDB returns the device's forwarding database, to add static entries to
+// or inspect.
+func (d *Device) FDB() *FDB {
+	return d.fdb
+}
+
+// ReadFrame blocks until a frame for the device's VNI is received, and
+// learns the VTEP its source address is behind. Packets that are not VXLAN
+// or are for another VNI are dropped.
+func (d *Device) ReadFrame() (*ethernet.Frame, error) {
+	for {
+		buf := make([]byte, maxPacketSize)
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		vtep, ok := sourceAddress(addr)
+		if !ok {
+			d.dropped.Add(1)
+			continue
+		}
+		vni, frame, err := Decapsulate(buf[:n])
+		if err != nil || vni != d.config.VNI {
+			d.dropped.Add(1)
+			continue
+		}
+
+		d.fdb.Learn(frame.Source, vtep)
+		d.decapsulated.Add(1)
+		return frame, nil
+	}
+}
+
+// WriteFrame encapsulates frame and sends it to the VTEP its destination
+// is behind, or floods it to the remotes if that is not known.
+func (d *Device) WriteFrame(frame *ethernet.Frame) error {
+	data, err := Encapsulate(d.config.VNI, frame)
+	if err != nil {
+		d.errors.Add(1)
+		return err
+	}
+
+	if frame.IsUnicast() {
+		if vtep, ok := d.fdb.Lookup(frame.Destination); ok {
+			return d.send(data, vtep)
+		}
+	}
+
+	d.flooded.Add(1)
+	var firstErr error
+	for _, vtep := range d.config.Remotes {
+		if err := d.send(data, vtep); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// send sends an encapsulated frame to vtep.
+func (d *Device) send(data []byte, vtep common.IPv4Address) error {
+	to := &net.UDPAddr{IP: net.IPv4(vtep[0], vtep[1], vtep[2], vtep[3]), Port: int(d.config.Port)}
+	if _, err := d.conn.WriteTo(data, to); err != nil {
+		d.errors.Add(1)
+		return fmt.Errorf("failed to send to VTEP %s: %w", vtep, err)
+	}
+	d.encapsulated.Add(1)
+	return nil
+}
+
+// Stats returns the device's counters.
+func (d *Device) Stats() Stats {
+	return Stats{
+		Encapsulated: d.encapsulated.Load(),
+		Decapsulated: d.decapsulated.Load(),
+		Flooded:      d.flooded.Load(),
+		Dropped:      d.dropped.Load(),
+		Errors:       d.errors.Load(),
+	}
+}
+
+// Close closes the underlying connection, unblocking ReadFrame.
+func (d *Device) Close() error {
+	var err error
+	d.closeOnce.Do(func() { err = d.conn.Close() })
+	return err
+}
+
+// sourceAddress returns the IPv4 address a packet came from.
+func sourceAddress(addr net.Addr) (common.IPv4Address, bool) {
+	switch a := addr.(type) {
+	case udp.Address:
+		return a.IP, !a.IsIPv6()
+	case *udp.Address:
+		return a.IP, !a.IsIPv6()
+	case *net.UDPAddr:
+		ip4 := a.IP.To4()
+		if ip4 == nil {
+			return common.IPv4Address{}, false
+		}
+		var source common.IPv4Address
+		copy(source[:], ip4)
+		return source, true
+	default:
+		return common.IPv4Address{}, false
+	}
+}
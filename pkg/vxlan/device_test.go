@@ -0,0 +1,200 @@
+package vxlan
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/udp"
+)
+
+// underlay delivers the datagrams written to its connections to the
+// connection bound to the destination address.
+type underlay struct {
+	mu    sync.Mutex
+	conns map[common.IPv4Address]*underlayConn
+}
+
+// underlayConn is a PacketConn on an underlay.
+type underlayConn struct {
+	net  *underlay
+	addr common.IPv4Address
+	in   chan datagram
+	done chan struct{}
+	once sync.Once
+}
+
+// datagram is a datagram in flight on an underlay.
+type datagram struct {
+	data []byte
+	from udp.Address
+}
+
+func (u *underlay) conn(addr common.IPv4Address) *underlayConn {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.conns == nil {
+		u.conns = make(map[common.IPv4Address]*underlayConn)
+	}
+	c := &underlayConn{net: u, addr: addr, in: make(chan datagram, 16), done: make(chan struct{})}
+	u.conns[addr] = c
+	return c
+}
+
+func (c *underlayConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case d := <-c.in:
+		return copy(p, d.data), d.from, nil
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *underlayConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	to := addr.(*net.UDPAddr)
+	var dst common.IPv4Address
+	copy(dst[:], to.IP.To4())
+	if to.Port != Port {
+		return 0, net.ErrClosed
+	}
+
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+	if peer, ok := c.net.conns[dst]; ok {
+		peer.in <- datagram{append([]byte(nil), p...), udp.Address{IP: c.addr, Port: Port}}
+	}
+	return len(p), nil
+}
+
+func (c *underlayConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *underlayConn) LocalAddr() net.Addr                { return udp.Address{IP: c.addr, Port: Port} }
+func (c *underlayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *underlayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *underlayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// readFrame reads a frame from d, failing the test if none arrives.
+func readFrame(t *testing.T, d *Device) *ethernet.Frame {
+	t.Helper()
+	frames := make(chan *ethernet.Frame, 1)
+	go func() {
+		if frame, err := d.ReadFrame(); err == nil {
+			frames <- frame
+		}
+	}()
+	select {
+	case frame := <-frames:
+		return frame
+	case <-time.After(time.Second):
+		t.Fatal("no frame received")
+		return nil
+	}
+}
+
+func TestDevice(t *testing.T) {
+	vtep3 := common.IPv4Address{192, 0, 2, 3}
+	var u underlay
+	conn1, conn3 := u.conn(vtep1), u.conn(vtep3)
+	d1, err := New(conn1, Config{VNI: 100, MAC: macA, Remotes: []common.IPv4Address{vtep2, vtep3}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	d2, err := New(u.conn(vtep2), Config{VNI: 100, MAC: macB, Remotes: []common.IPv4Address{vtep1, vtep3}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer d1.Close()
+	defer d2.Close()
+
+	if d1.Name() != "vxlan100" {
+		t.Errorf("Name() = %q, want vxlan100", d1.Name())
+	}
+
+	// A broadcast goes to every remote, and teaches d2 where macA is
+	if err := d1.WriteFrame(ethernet.NewFrame(common.BroadcastMAC, macA, common.EtherTypeARP, make([]byte, 28))); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	if frame := readFrame(t, d2); frame.Source != macA || !frame.IsBroadcast() {
+		t.Errorf("received %s, want the broadcast from %s", frame, macA)
+	}
+	if vtep, ok := d2.FDB().Lookup(macA); !ok || vtep != vtep1 {
+		t.Errorf("Lookup(%s) = %s, %v, want %s, true", macA, vtep, ok, vtep1)
+	}
+	if len(conn3.in) != 1 {
+		t.Errorf("%d frames flooded to %s, want 1", len(conn3.in), vtep3)
+	}
+
+	// The reply is sent to vtep1 only
+	if err := d2.WriteFrame(ethernet.NewFrame(macA, macB, common.EtherTypeARP, make([]byte, 28))); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	if frame := readFrame(t, d1); frame.Source != macB || frame.Destination != macA {
+		t.Errorf("received %s, want the reply from %s", frame, macB)
+	}
+	if len(conn3.in) != 1 {
+		t.Errorf("%d frames sent to %s, want the flooded one only", len(conn3.in), vtep3)
+	}
+
+	if stats := d2.Stats(); stats.Encapsulated != 1 || stats.Flooded != 0 || stats.Decapsulated != 1 {
+		t.Errorf("Stats() = %+v, want 1 encapsulated, 0 flooded, 1 decapsulated", stats)
+	}
+	if stats := d1.Stats(); stats.Encapsulated != 2 || stats.Flooded != 1 {
+		t.Errorf("Stats() = %+v, want 2 encapsulated, 1 flooded", stats)
+	}
+}
+
+func TestDeviceOtherVNI(t *testing.T) {
+	var u underlay
+	d1, err := New(u.conn(vtep1), Config{VNI: 100, MAC: macA, Remotes: []common.IPv4Address{vtep2}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	d2, err := New(u.conn(vtep2), Config{VNI: 200, MAC: macB})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer d1.Close()
+
+	if err := d1.WriteFrame(ethernet.NewFrame(common.BroadcastMAC, macA, common.EtherTypeARP, nil)); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := d2.ReadFrame()
+		done <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for d2.Stats().Dropped == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	d2.Close()
+	if err := <-done; err == nil {
+		t.Error("ReadFrame() returned a frame of another VNI")
+	}
+	if d2.FDB().Len() != 0 {
+		t.Errorf("FDB().Len() = %d, want nothing learned from another VNI", d2.FDB().Len())
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	var u underlay
+	if _, err := New(nil, Config{}); err == nil {
+		t.Error("New() with no connection succeeded")
+	}
+	if _, err := New(u.conn(vtep1), Config{VNI: MaxVNI + 1}); err == nil {
+		t.Error("New() with VNI out of range succeeded")
+	}
+	d, err := New(u.conn(vtep1), Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if mac := d.MACAddress(); mac == (common.MACAddress{}) || mac.IsMulticast() || mac[0]&0x02 == 0 {
+		t.Errorf("MACAddress() = %s, want a random locally administered unicast address", mac)
+	}
+}
@@ -0,0 +1,160 @@
+package vxlan
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+// DefaultAgeingTime is how long a learned FDB entry lives without a frame
+// from its MAC address refreshing it.
+const DefaultAgeingTime = 300 * time.Second
+
+// Entry is a forwarding database entry: the VTEP an inner MAC address is
+// behind.
+type Entry struct {
+	MAC     common.MACAddress
+	VTEP    common.IPv4Address
+	Static  bool      // Added rather than learned; static entries never age out
+	Updated time.Time // When the entry was learned or last refreshed
+}
+
+// FDB maps the MAC addresses of an overlay network to the VTEPs they are
+// behind, as a learning bridge maps them to ports.
+type FDB struct {
+	ageing time.Duration
+
+	mu      sync.RWMutex
+	entries map[common.MACAddress]*Entry
+
+	now func() time.Time // Clock, replaced in tests
+}
+
+// NewFDB creates an empty forwarding database whose learned entries age out
+// after ageing; 0 means DefaultAgeingTime.
+func NewFDB(ageing time.Duration) *FDB {
+	if ageing <= 0 {
+		ageing = DefaultAgeingTime
+	}
+	return &FDB{
+		ageing:  ageing,
+		entries: make(map[common.MACAddress]*Entry),
+		now:     time.Now,
+	}
+}
+
+// Learn records that mac is behind vtep, as a frame from it has shown. It
+// reports whether the entry is new or moved to another VTEP. Static
+// entries are left alone, as are broadcast and multicast addresses, which
+// no frame is legitimately sent from.
+func (f *FDB) Learn(mac common.MACAddress, vtep common.IPv4Address) bool {
+	if mac.IsMulticast() {
+		return false
+	}
+	now := f.now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.entries[mac]
+	switch {
+	case !ok:
+		f.entries[mac] = &Entry{MAC: mac, VTEP: vtep, Updated: now}
+		return true
+	case e.Static:
+		return false
+	}
+	moved := e.VTEP != vtep
+	e.VTEP = vtep
+	e.Updated = now
+	return moved
+}
+
+// Add adds a static entry, replacing any entry for mac.
+func (f *FDB) Add(mac common.MACAddress, vtep common.IPv4Address) {
+	now := f.now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[mac] = &Entry{MAC: mac, VTEP: vtep, Static: true, Updated: now}
+}
+
+// Remove removes the entry for mac, and reports whether there was one.
+func (f *FDB) Remove(mac common.MACAddress) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.entries[mac]; !ok {
+		return false
+	}
+	delete(f.entries, mac)
+	return true
+}
+
+// Lookup returns the VTEP mac is behind. Learned entries past the ageing
+// time are not returned.
+func (f *FDB) Lookup(mac common.MACAddress) (common.IPv4Address, bool) {
+	now := f.now()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	e, ok := f.entries[mac]
+	if !ok || f.expired(e, now) {
+		return common.IPv4Address{}, false
+	}
+	return e.VTEP, true
+}
+
+// Expire removes the learned entries past the ageing time, and returns how
+// many it removed.
+func (f *FDB) Expire() int {
+	now := f.now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	removed := 0
+	for mac, e := range f.entries {
+		if f.expired(e, now) {
+			delete(f.entries, mac)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Flush removes every learned entry, keeping the static ones, as when the
+// topology of the underlay changes.
+func (f *FDB) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for mac, e := range f.entries {
+		if !e.Static {
+			delete(f.entries, mac)
+		}
+	}
+}
+
+// Entries returns a copy of the entries, sorted by MAC address.
+func (f *FDB) Entries() []Entry {
+	f.mu.RLock()
+	entries := make([]Entry, 0, len(f.entries))
+	for _, e := range f.entries {
+		entries = append(entries, *e)
+	}
+	f.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].MAC[:], entries[j].MAC[:]) < 0
+	})
+	return entries
+}
+
+// Len returns the number of entries.
+func (f *FDB) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.entries)
+}
+
+// expired reports whether e is a learned entry past the ageing time.
+func (f *FDB) expired(e *Entry, now time.Time) bool {
+	return !e.Static && now.Sub(e.Updated) >= f.ageing
+}
@@ -0,0 +1,65 @@
+package vxlan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+)
+
+var (
+	vtep1 = common.IPv4Address{192, 0, 2, 1}
+	vtep2 = common.IPv4Address{192, 0, 2, 2}
+)
+
+func TestFDB(t *testing.T) {
+	now := time.Unix(1000, 0)
+	f := NewFDB(time.Minute)
+	f.now = func() time.Time { return now }
+
+	if !f.Learn(macA, vtep1) {
+		t.Error("Learn() of a new address = false, want true")
+	}
+	if f.Learn(macA, vtep1) {
+		t.Error("Learn() of a known address = true, want false")
+	}
+	if !f.Learn(macA, vtep2) {
+		t.Error("Learn() of a moved address = false, want true")
+	}
+	if vtep, ok := f.Lookup(macA); !ok || vtep != vtep2 {
+		t.Errorf("Lookup() = %s, %v, want %s, true", vtep, ok, vtep2)
+	}
+	if f.Learn(common.BroadcastMAC, vtep1) {
+		t.Error("Learn() of the broadcast address = true, want false")
+	}
+
+	// Static entries are not overridden by learning, nor aged out
+	f.Add(macB, vtep1)
+	if f.Learn(macB, vtep2) {
+		t.Error("Learn() over a static entry = true, want false")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := f.Lookup(macA); ok {
+		t.Error("Lookup() of an aged out entry succeeded")
+	}
+	if vtep, ok := f.Lookup(macB); !ok || vtep != vtep1 {
+		t.Errorf("Lookup() of a static entry = %s, %v, want %s, true", vtep, ok, vtep1)
+	}
+	if n := f.Expire(); n != 1 {
+		t.Errorf("Expire() = %d, want 1", n)
+	}
+	entries := f.Entries()
+	if len(entries) != 1 || entries[0].MAC != macB || !entries[0].Static {
+		t.Errorf("Entries() = %+v, want the static entry only", entries)
+	}
+
+	f.Learn(macA, vtep1)
+	f.Flush()
+	if f.Len() != 1 {
+		t.Errorf("Len() after Flush() = %d, want 1", f.Len())
+	}
+	if !f.Remove(macB) || f.Remove(macB) {
+		t.Error("Remove() should succeed once")
+	}
+}
@@ -0,0 +1,76 @@
+// Package vxlan implements Virtual eXtensible LAN (RFC 7348) tunnels over
+// the stack's UDP sockets.
+//
+// A Device carries the Ethernet frames of one overlay network, identified
+// by its VNI, to the other tunnel endpoints (VTEPs) of that network. It
+// learns which VTEP each inner MAC address is behind from the frames it
+// receives, and floods frames to unknown, broadcast and multicast
+// destinations to the VTEPs it is configured with.
+package vxlan
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+// VXLAN header format (RFC 7348 Section 5), followed by the inner frame:
+// +---------------+-------------------------------------------+
+// | Flags (8)     | Reserved (24)                             |
+// +---------------+-------------------------------+-----------+
+// | VXLAN Network Identifier (24)                 | Reserved  |
+// +-----------------------------------------------+-----------+
+//
+// Only the I flag (0x08), marking the VNI valid, is defined.
+
+const (
+	// Port is the UDP port assigned to VXLAN.
+	Port = 4789
+
+	// HeaderSize is the size of the VXLAN header (8 bytes).
+	HeaderSize = 8
+
+	// MaxVNI is the largest VXLAN network identifier.
+	MaxVNI = 1<<24 - 1
+
+	// Overhead is what encapsulation adds to an inner frame on the wire:
+	// the outer IPv4, UDP and VXLAN headers (36 bytes). The outer Ethernet
+	// header comes on top.
+	Overhead = 20 + 8 + HeaderSize
+
+	// flagVNI marks the VNI valid.
+	flagVNI = 0x08
+)
+
+// Encapsulate returns the UDP payload carrying frame on network vni.
+func Encapsulate(vni uint32, frame *ethernet.Frame) ([]byte, error) {
+	if vni > MaxVNI {
+		return nil, fmt.Errorf("VNI %d out of range (maximum %d)", vni, MaxVNI)
+	}
+	data := make([]byte, HeaderSize+frame.Size())
+	data[0] = flagVNI
+	binary.BigEndian.PutUint32(data[4:8], vni<<8)
+	if _, err := frame.SerializeTo(data[HeaderSize:]); err != nil {
+		return nil, fmt.Errorf("failed to serialize inner frame: %w", err)
+	}
+	return data, nil
+}
+
+// Decapsulate returns the network and the inner frame of a VXLAN UDP
+// payload. The frame aliases data.
+func Decapsulate(data []byte) (uint32, *ethernet.Frame, error) {
+	if len(data) < HeaderSize {
+		return 0, nil, fmt.Errorf("packet too short: %d bytes", len(data))
+	}
+	if data[0]&flagVNI == 0 {
+		return 0, nil, fmt.Errorf("VNI flag not set (flags 0x%02x)", data[0])
+	}
+	vni := binary.BigEndian.Uint32(data[4:8]) >> 8
+
+	frame, err := ethernet.Parse(data[HeaderSize:])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid inner frame: %w", err)
+	}
+	return vni, frame, nil
+}
@@ -0,0 +1,61 @@
+package vxlan
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+)
+
+var (
+	macA = common.MACAddress{0x02, 0, 0, 0, 0, 0x0a}
+	macB = common.MACAddress{0x02, 0, 0, 0, 0, 0x0b}
+)
+
+func TestEncapsulate(t *testing.T) {
+	frame := ethernet.NewFrame(macB, macA, common.EtherTypeIPv4, bytes.Repeat([]byte{0xab}, 100))
+	data, err := Encapsulate(5000, frame)
+	if err != nil {
+		t.Fatalf("Encapsulate() error = %v", err)
+	}
+	want := []byte{0x08, 0, 0, 0, 0x00, 0x13, 0x88, 0}
+	if !bytes.Equal(data[:HeaderSize], want) {
+		t.Errorf("header = % x, want % x", data[:HeaderSize], want)
+	}
+
+	vni, inner, err := Decapsulate(data)
+	if err != nil {
+		t.Fatalf("Decapsulate() error = %v", err)
+	}
+	if vni != 5000 {
+		t.Errorf("VNI = %d, want 5000", vni)
+	}
+	if inner.Destination != macB || inner.Source != macA || inner.EtherType != common.EtherTypeIPv4 ||
+		!bytes.Equal(inner.Payload, frame.Payload) {
+		t.Errorf("inner frame = %s, want %s", inner, frame)
+	}
+
+	if _, err := Encapsulate(MaxVNI+1, frame); err == nil {
+		t.Error("Encapsulate() with VNI out of range succeeded")
+	}
+}
+
+func TestDecapsulateInvalid(t *testing.T) {
+	frame := ethernet.NewFrame(macB, macA, common.EtherTypeIPv4, nil).Serialize()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte{0x08, 0, 0, 0}},
+		{"VNI flag clear", append([]byte{0, 0, 0, 0, 0, 0, 1, 0}, frame...)},
+		{"no inner frame", []byte{0x08, 0, 0, 0, 0, 0, 1, 0, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Decapsulate(tt.data); err == nil {
+				t.Error("Decapsulate() succeeded, want error")
+			}
+		})
+	}
+}
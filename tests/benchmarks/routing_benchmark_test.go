@@ -313,6 +313,44 @@ func BenchmarkRoutingTableConcurrent(b *testing.B) {
 	})
 }
 
+// BenchmarkRoutingTableLookupFlowECMP measures flow lookups over a
+// multipath default route
+func BenchmarkRoutingTableLookupFlowECMP(b *testing.B) {
+	for _, paths := range []int{2, 4, 8} {
+		b.Run(fmt.Sprintf("Paths_%d", paths), func(b *testing.B) {
+			var hops []ip.NextHop
+			for i := 0; i < paths; i++ {
+				hops = append(hops, ip.NextHop{
+					Gateway: common.IPv4Address{192, 168, byte(i), 1},
+					Interface: fmt.Sprintf("eth%d", i),
+				})
+			}
+			route, err := ip.NewMultipathRoute(common.IPv4Address{}, common.IPv4Address{}, 0, hops...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			rt := ip.NewRoutingTable()
+			rt.AddRoute(route)
+
+			key := ip.FlowKey{
+				Source: common.IPv4Address{10, 0, 0, 1},
+				Destination: common.IPv4Address{8, 8, 8, 8},
+				Protocol: common.ProtocolTCP,
+				DestinationPort: 443,
+			}
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				key.SourcePort = uint16(i)
+				if _, _, err := rt.LookupFlow(key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 // BenchmarkRoutingTableCIDRLookup measures CIDR-based lookups
 func BenchmarkRoutingTableCIDRLookup(b *testing.B) {
 	prefixLengths := []int{8, 16, 24, 32}
@@ -0,0 +1,424 @@
+//go:build conformance
+
+// Conformance tests of the TCP stack against the Linux kernel's
+//
+// Each test creates a veth pair with one end in a new network namespace,
+// runs the stack on the other end and talks to kernel sockets in the
+// namespace, so that the handshake, data transfer, retransmission, FIN and
+// RST handling and flow control are checked against a real peer rather
+// than against the stack itself.
+//
+// These tests require:
+// - Root privileges (for namespaces, veth devices and raw sockets)
+// - The ip command from iproute2
+//
+// Run with: sudo go test -tags=conformance ./tests/conformance/...
+
+package conformance
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/arp"
+	"github.com/therealutkarshpriyadarshi/network/pkg/common"
+	"github.com/therealutkarshpriyadarshi/network/pkg/ethernet"
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+var (
+	// stackAddr is the stack's address on the veth pair. It is not
+	// configured on the host, so the host kernel ignores its segments.
+	stackAddr = common.IPv4Address{10, 77, 0, 1}
+
+	// kernelAddr is the address of the kernel end, inside the namespace.
+	kernelAddr = common.IPv4Address{10, 77, 0, 2}
+)
+
+// Legacy ethtool commands that turn an offload on or off
+const (
+	ethtoolSetTxChecksum = 0x17 // ETHTOOL_STXCSUM
+	ethtoolSetSG         = 0x19 // ETHTOOL_SSG
+	ethtoolSetTSO        = 0x1f // ETHTOOL_STSO
+	ethtoolSetGSO        = 0x24 // ETHTOOL_SGSO
+	ethtoolSetGRO        = 0x2c // ETHTOOL_SGRO
+)
+
+// harness is a stack on one end of a veth pair whose other end is in a
+// namespace of its own.
+type harness struct {
+	t         *testing.T
+	namespace string
+
+	iface    *ethernet.Interface
+	arp      *arp.Handler
+	pipeline *hook.Pipeline
+	demux    *tcp.Demultiplexer
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newHarness sets up the namespace, the veth pair and the stack. All of it
+// is removed when the test ends.
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("conformance tests require root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("conformance tests require the ip command")
+	}
+
+	// Names are unique per process so that runs do not collide
+	id := fmt.Sprintf("%d%d", os.Getpid()%10000, time.Now().UnixNano()%1000)
+	h := &harness{t: t, namespace: "conf" + id, done: make(chan struct{})}
+	local, peer := "cfa"+id, "cfb"+id
+
+	h.ip("netns", "add", h.namespace)
+	t.Cleanup(func() { h.ipQuiet("netns", "del", h.namespace) })
+	h.ip("link", "add", local, "type", "veth", "peer", "name", peer)
+	t.Cleanup(func() { h.ipQuiet("link", "del", local) })
+
+	// The kernel would otherwise hand the stack GSO super-segments and
+	// segments with partial checksums
+	for _, cmd := range []uint32{ethtoolSetTSO, ethtoolSetGSO, ethtoolSetGRO, ethtoolSetSG, ethtoolSetTxChecksum} {
+		for _, name := range []string{local, peer} {
+			if err := ethtoolSet(name, cmd, 0); err != nil {
+				t.Fatalf("failed to disable offload %#x on %s: %v", cmd, name, err)
+			}
+		}
+	}
+
+	h.ip("link", "set", peer, "netns", h.namespace)
+	h.ip("-n", h.namespace, "addr", "add", kernelAddr.String()+"/24", "dev", peer)
+	h.ip("-n", h.namespace, "link", "set", peer, "up")
+	h.ip("-n", h.namespace, "link", "set", "lo", "up")
+	if err := os.WriteFile("/proc/sys/net/ipv6/conf/"+local+"/disable_ipv6", []byte("1"), 0); err != nil {
+		t.Logf("failed to disable IPv6 on %s: %v", local, err)
+	}
+	h.ip("link", "set", local, "up")
+
+	iface, err := ethernet.OpenInterface(local)
+	if err != nil {
+		t.Fatalf("OpenInterface() error = %v", err)
+	}
+	h.iface = iface
+	h.arp = arp.NewHandler(iface, stackAddr)
+	h.pipeline = hook.NewPipeline()
+	h.demux = tcp.NewDemultiplexer()
+	h.demux.SetSendFunc(h.pipeline.TCPSendFunc())
+
+	p := h.pipeline
+	p.SetHandler(hook.EthernetRx, p.DemuxEthernet(h.handleARP))
+	p.SetHandler(hook.IPRx, p.DemuxIP(nil))
+	p.SetHandler(hook.TCPRx, func(pkt *hook.Packet) error {
+		return h.demux.Deliver(pkt.TCP, pkt.Source, pkt.Destination)
+	})
+	p.SetHandler(hook.TCPTx, p.EncapsulateTCP())
+	p.SetHandler(hook.IPTx, h.transmit)
+	p.Register(hook.IPRx, -100, "local-address", func(pkt *hook.Packet) hook.Verdict {
+		if pkt.IP.Destination != stackAddr {
+			return hook.Drop
+		}
+		return hook.Continue
+	})
+
+	h.wg.Add(1)
+	go h.run()
+	t.Cleanup(func() {
+		close(h.done)
+		iface.Close()
+		h.wg.Wait()
+	})
+	return h
+}
+
+// ip runs the ip command, failing the test if it fails.
+func (h *harness) ip(args ...string) {
+	h.t.Helper()
+	if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+		h.t.Fatalf("ip %v: %v: %s", args, err, out)
+	}
+}
+
+// ipQuiet runs the ip command, ignoring failures.
+func (h *harness) ipQuiet(args ...string) {
+	exec.Command("ip", args...).Run()
+}
+
+// ethtoolSet sets an offload of a device with a legacy ethtool ioctl.
+func ethtoolSet(name string, cmd, value uint32) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	// struct ifreq with ifr_data pointing at a struct ethtool_value
+	ev := &struct{ cmd, data uint32 }{cmd, value}
+	var ifr struct {
+		name [unix.IFNAMSIZ]byte
+		data unsafe.Pointer
+		_    [16]byte
+	}
+	copy(ifr.name[:unix.IFNAMSIZ-1], name)
+	ifr.data = unsafe.Pointer(ev)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// run reads frames from the interface into the pipeline until the test
+// ends.
+func (h *harness) run() {
+	defer h.wg.Done()
+	for {
+		frame, err := h.iface.ReadFrame()
+		select {
+		case <-h.done:
+			return
+		default:
+		}
+		if err != nil {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		h.pipeline.ReceiveFrame(h.iface.Name(), frame)
+	}
+}
+
+// handleARP answers and learns from ARP frames.
+func (h *harness) handleARP(pkt *hook.Packet) error {
+	if pkt.Frame.EtherType != common.EtherTypeARP {
+		return nil
+	}
+	packet, err := arp.Parse(pkt.Frame.Payload)
+	if err != nil {
+		return err
+	}
+	return h.arp.HandlePacket(packet)
+}
+
+// transmit sends a packet to the kernel end, which is on the link.
+func (h *harness) transmit(pkt *hook.Packet) error {
+	data, err := pkt.IP.Serialize()
+	if err != nil {
+		return err
+	}
+	write := func(mac common.MACAddress) {
+		h.iface.WriteFrame(ethernet.NewFrame(mac, h.iface.MACAddress(), common.EtherTypeIPv4, data))
+	}
+
+	if mac, found := h.arp.Cache().Get(pkt.IP.Destination); found {
+		write(mac)
+		return nil
+	}
+	// Resolve in the background so the reader can process the ARP reply
+	dst := pkt.IP.Destination
+	go func() {
+		if mac, err := h.arp.Resolve(dst); err == nil {
+			write(mac)
+		}
+	}()
+	return nil
+}
+
+// inNamespace runs fn on an OS thread in the test's namespace. Sockets fn
+// creates stay in the namespace after it returns.
+func (h *harness) inNamespace(fn func() error) error {
+	errc := make(chan error, 1)
+	go func() {
+		// The thread is not unlocked, so it exits with the goroutine
+		// rather than returning to the pool in the wrong namespace
+		runtime.LockOSThread()
+
+		ns, err := os.Open("/run/netns/" + h.namespace)
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer ns.Close()
+		if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+			errc <- fmt.Errorf("setns: %w", err)
+			return
+		}
+		errc <- fn()
+	}()
+	return <-errc
+}
+
+// kernelListen returns a kernel listener in the namespace.
+func (h *harness) kernelListen() *net.TCPListener {
+	h.t.Helper()
+	var ln *net.TCPListener
+	err := h.inNamespace(func() error {
+		var err error
+		ln, err = net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IP(kernelAddr[:])})
+		return err
+	})
+	if err != nil {
+		h.t.Fatalf("failed to listen in namespace: %v", err)
+	}
+	h.t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// kernelDial connects a kernel socket in the namespace to the stack.
+func (h *harness) kernelDial(port uint16) (*net.TCPConn, error) {
+	var conn *net.TCPConn
+	err := h.inNamespace(func() error {
+		c, err := net.DialTimeout("tcp4", fmt.Sprintf("%s:%d", stackAddr, port), 10*time.Second)
+		if err != nil {
+			return err
+		}
+		conn = c.(*net.TCPConn)
+		return nil
+	})
+	return conn, err
+}
+
+// dial connects a stack socket to the kernel listener.
+func (h *harness) dial(ln *net.TCPListener) (net.Conn, error) {
+	sock := tcp.NewSocket(stackAddr, 0)
+	if err := h.demux.Connect(sock, kernelAddr, uint16(ln.Addr().(*net.TCPAddr).Port)); err != nil {
+		return nil, err
+	}
+	return tcp.NewNetConn(sock), nil
+}
+
+// connect returns a stack connection and the kernel connection it is
+// connected to.
+func (h *harness) connect() (stack net.Conn, kernel *net.TCPConn) {
+	h.t.Helper()
+	ln := h.kernelListen()
+
+	accepted := make(chan *net.TCPConn, 1)
+	go func() {
+		ln.SetDeadline(time.Now().Add(15 * time.Second))
+		c, err := ln.AcceptTCP()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+
+	stack, err := h.dial(ln)
+	if err != nil {
+		h.t.Fatalf("Connect() error = %v", err)
+	}
+	kernel, ok := <-accepted
+	if !ok {
+		h.t.Fatal("kernel did not accept the connection")
+	}
+	h.t.Cleanup(func() {
+		stack.Close()
+		kernel.Close()
+	})
+	return stack, kernel
+}
+
+// listen returns a stack listener on port.
+func (h *harness) listen(port uint16) net.Listener {
+	h.t.Helper()
+	sock := tcp.NewSocket(stackAddr, port)
+	if err := h.demux.Listen(sock, 16); err != nil {
+		h.t.Fatalf("Listen() error = %v", err)
+	}
+	ln := tcp.NewListener(sock)
+	h.t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// segmentLog records the segments passing a hook point.
+type segmentLog struct {
+	mu       sync.Mutex
+	segments []tcp.Segment
+}
+
+// watch records the segments passing point from now on.
+func (h *harness) watch(point hook.Point) *segmentLog {
+	h.t.Helper()
+	log := &segmentLog{}
+	handle, err := h.pipeline.Register(point, 0, "watch", func(pkt *hook.Packet) hook.Verdict {
+		seg := *pkt.TCP
+		seg.Options = append([]byte(nil), seg.Options...)
+		seg.Data = append([]byte(nil), seg.Data...)
+		log.mu.Lock()
+		log.segments = append(log.segments, seg)
+		log.mu.Unlock()
+		return hook.Continue
+	})
+	if err != nil {
+		h.t.Fatalf("Register() error = %v", err)
+	}
+	h.t.Cleanup(func() { h.pipeline.Unregister(handle) })
+	return log
+}
+
+// all returns the segments recorded so far.
+func (l *segmentLog) all() []tcp.Segment {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]tcp.Segment(nil), l.segments...)
+}
+
+// find returns the first recorded segment match accepts.
+func (l *segmentLog) find(match func(*tcp.Segment) bool) (*tcp.Segment, bool) {
+	for _, seg := range l.all() {
+		if match(&seg) {
+			return &seg, true
+		}
+	}
+	return nil, false
+}
+
+// drop drops the first n segments passing point that match accepts, and
+// records them.
+func (h *harness) drop(point hook.Point, n int, match func(*tcp.Segment) bool) *segmentLog {
+	h.t.Helper()
+	dropped := &segmentLog{}
+	handle, err := h.pipeline.Register(point, -50, "drop", func(pkt *hook.Packet) hook.Verdict {
+		dropped.mu.Lock()
+		defer dropped.mu.Unlock()
+		if len(dropped.segments) < n && match(pkt.TCP) {
+			dropped.segments = append(dropped.segments, *pkt.TCP)
+			return hook.Drop
+		}
+		return hook.Continue
+	})
+	if err != nil {
+		h.t.Fatalf("Register() error = %v", err)
+	}
+	h.t.Cleanup(func() { h.pipeline.Unregister(handle) })
+	return dropped
+}
+
+// hasData reports whether a segment carries data.
+func hasData(seg *tcp.Segment) bool {
+	return len(seg.Data) > 0
+}
+
+// eventually polls cond until it holds or timeout passes.
+func eventually(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
@@ -0,0 +1,404 @@
+//go:build conformance
+
+package conformance
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/network/pkg/hook"
+	"github.com/therealutkarshpriyadarshi/network/pkg/tcp"
+)
+
+// stackSocket returns the socket of a stack connection.
+func stackSocket(c net.Conn) *tcp.Socket {
+	return c.(*tcp.NetConn).Socket()
+}
+
+// randomData returns n random bytes.
+func randomData(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("rand.Read() error = %v", err)
+	}
+	return data
+}
+
+// readAll reads from r until EOF or n bytes, within timeout.
+func readAll(t *testing.T, c net.Conn, n int, timeout time.Duration) []byte {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(timeout))
+	got := make([]byte, 0, n)
+	buf := make([]byte, 64*1024)
+	for len(got) < n {
+		m, err := c.Read(buf)
+		got = append(got, buf[:m]...)
+		if err != nil {
+			t.Fatalf("Read() after %d of %d bytes error = %v", len(got), n, err)
+		}
+	}
+	return got
+}
+
+// writeAsync writes data to c in the background and returns a channel
+// receiving the result.
+func writeAsync(c net.Conn, data []byte) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Write(data)
+		errc <- err
+	}()
+	return errc
+}
+
+func TestActiveOpen(t *testing.T) {
+	h := newHarness(t)
+	tx := h.watch(hook.TCPTx)
+	rx := h.watch(hook.TCPRx)
+
+	stack, kernel := h.connect()
+	if got := stackSocket(stack).GetState(); got != tcp.StateEstablished {
+		t.Errorf("stack state = %v, want %v", got, tcp.StateEstablished)
+	}
+	if got, want := kernel.RemoteAddr().String(), stack.LocalAddr().String(); got != want {
+		t.Errorf("kernel peer = %s, want %s", got, want)
+	}
+
+	syn, ok := tx.find(func(s *tcp.Segment) bool { return s.Flags == tcp.FlagSYN })
+	if !ok {
+		t.Fatal("stack sent no SYN")
+	}
+	if _, err := syn.GetMSS(); err != nil {
+		t.Errorf("SYN GetMSS() error = %v", err)
+	}
+
+	synAck, ok := rx.find(func(s *tcp.Segment) bool { return s.Flags == tcp.FlagSYN|tcp.FlagACK })
+	if !ok {
+		t.Fatal("kernel sent no SYN-ACK")
+	}
+	if synAck.AckNumber != syn.SequenceNumber+1 {
+		t.Errorf("SYN-ACK acknowledges %d, want %d", synAck.AckNumber, syn.SequenceNumber+1)
+	}
+	if mss, err := synAck.GetMSS(); err != nil || mss != 1460 {
+		t.Errorf("SYN-ACK MSS = %d, %v, want 1460", mss, err)
+	}
+
+	// The handshake ends with an ACK of the SYN-ACK
+	if !eventually(time.Second, func() bool {
+		_, ok := tx.find(func(s *tcp.Segment) bool {
+			return s.Flags&(tcp.FlagSYN|tcp.FlagACK) == tcp.FlagACK && s.AckNumber == synAck.SequenceNumber+1
+		})
+		return ok
+	}) {
+		t.Error("stack did not acknowledge the SYN-ACK")
+	}
+}
+
+func TestPassiveOpen(t *testing.T) {
+	h := newHarness(t)
+	ln := h.listen(8080)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+
+	kernel, err := h.kernelDial(8080)
+	if err != nil {
+		t.Fatalf("kernel dial error = %v", err)
+	}
+	defer kernel.Close()
+
+	select {
+	case stack, ok := <-accepted:
+		if !ok {
+			t.Fatal("Accept() failed")
+		}
+		defer stack.Close()
+		if got := stackSocket(stack).GetState(); got != tcp.StateEstablished {
+			t.Errorf("stack state = %v, want %v", got, tcp.StateEstablished)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("stack did not accept the connection")
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	h := newHarness(t)
+	ln := h.kernelListen()
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	// The kernel answers the SYN with an RST
+	start := time.Now()
+	sock := tcp.NewSocket(stackAddr, 0)
+	err := h.demux.Connect(sock, kernelAddr, uint16(addr.Port))
+	if err == nil {
+		t.Fatal("Connect() to a closed port succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Connect() failed after %v, want the RST to end it promptly", elapsed)
+	}
+}
+
+func TestDataTransfer(t *testing.T) {
+	h := newHarness(t)
+	stack, kernel := h.connect()
+
+	tests := []struct {
+		name     string
+		from, to net.Conn
+	}{
+		{"stack to kernel", stack, kernel},
+		{"kernel to stack", kernel, stack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := randomData(t, 1<<20)
+			errc := writeAsync(tt.from, data)
+			got := readAll(t, tt.to, len(data), 30*time.Second)
+			if err := <-errc; err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("received data differs from the data sent")
+			}
+		})
+	}
+}
+
+func TestStackRetransmits(t *testing.T) {
+	h := newHarness(t)
+	stack, kernel := h.connect()
+
+	// Lose the stack's first data segment
+	dropped := h.drop(hook.TCPTx, 1, hasData)
+	tx := h.watch(hook.TCPTx)
+
+	data := randomData(t, 64*1024)
+	errc := writeAsync(stack, data)
+	got := readAll(t, kernel, len(data), 30*time.Second)
+	if err := <-errc; err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("kernel received data that differs from the data sent")
+	}
+
+	lost := dropped.all()
+	if len(lost) != 1 {
+		t.Fatalf("dropped %d segments, want 1", len(lost))
+	}
+	if _, ok := tx.find(func(s *tcp.Segment) bool { return hasData(s) && s.SequenceNumber == lost[0].SequenceNumber }); !ok {
+		t.Errorf("stack did not retransmit the segment at %d", lost[0].SequenceNumber)
+	}
+}
+
+func TestKernelRetransmits(t *testing.T) {
+	h := newHarness(t)
+	stack, kernel := h.connect()
+
+	// Lose the kernel's first data segment; the stack must not deliver the
+	// data after the hole until the retransmission fills it
+	dropped := h.drop(hook.TCPRx, 1, hasData)
+	rx := h.watch(hook.TCPRx)
+
+	data := randomData(t, 64*1024)
+	errc := writeAsync(kernel, data)
+	got := readAll(t, stack, len(data), 30*time.Second)
+	if err := <-errc; err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("stack received data that differs from the data sent")
+	}
+
+	lost := dropped.all()
+	if len(lost) != 1 {
+		t.Fatalf("dropped %d segments, want 1", len(lost))
+	}
+	if _, ok := rx.find(func(s *tcp.Segment) bool { return hasData(s) && s.SequenceNumber == lost[0].SequenceNumber }); !ok {
+		t.Errorf("kernel did not retransmit the segment at %d", lost[0].SequenceNumber)
+	}
+}
+
+func TestKernelCloses(t *testing.T) {
+	h := newHarness(t)
+	stack, kernel := h.connect()
+	rx := h.watch(hook.TCPRx)
+
+	if _, err := kernel.Write([]byte("last words")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := kernel.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The data before the FIN is delivered, then EOF
+	got := readAll(t, stack, len("last words"), 5*time.Second)
+	if string(got) != "last words" {
+		t.Errorf("Read() = %q, want %q", got, "last words")
+	}
+	stack.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stack.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() after FIN error = %v, want EOF", err)
+	}
+	if _, ok := rx.find(func(s *tcp.Segment) bool { return s.HasFlag(tcp.FlagFIN) }); !ok {
+		t.Error("kernel sent no FIN")
+	}
+	if got := stackSocket(stack).GetState(); got != tcp.StateCloseWait {
+		t.Errorf("stack state = %v, want %v", got, tcp.StateCloseWait)
+	}
+
+	// Closing completes the passive close
+	if err := stack.Close(); err != nil {
+		t.Fatalf("stack Close() error = %v", err)
+	}
+	if !eventually(5*time.Second, func() bool { return stackSocket(stack).GetState() == tcp.StateClosed }) {
+		t.Errorf("stack state = %v, want %v", stackSocket(stack).GetState(), tcp.StateClosed)
+	}
+}
+
+func TestStackCloses(t *testing.T) {
+	h := newHarness(t)
+	stack, kernel := h.connect()
+
+	if _, err := stack.Write([]byte("last words")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := stack.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	kernel.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(kernel)
+	if err != nil {
+		t.Fatalf("kernel ReadAll() error = %v", err)
+	}
+	if string(got) != "last words" {
+		t.Errorf("kernel read %q, want %q", got, "last words")
+	}
+
+	// The kernel's FIN moves the stack to TIME_WAIT
+	kernel.Close()
+	if !eventually(5*time.Second, func() bool {
+		state := stackSocket(stack).GetState()
+		return state == tcp.StateTimeWait || state == tcp.StateClosed
+	}) {
+		t.Errorf("stack state = %v, want %v", stackSocket(stack).GetState(), tcp.StateTimeWait)
+	}
+}
+
+func TestKernelResets(t *testing.T) {
+	h := newHarness(t)
+	stack, kernel := h.connect()
+	rx := h.watch(hook.TCPRx)
+
+	// Closing with a zero linger time sends an RST
+	if err := kernel.SetLinger(0); err != nil {
+		t.Fatalf("SetLinger() error = %v", err)
+	}
+	kernel.Close()
+
+	if !eventually(5*time.Second, func() bool { return stackSocket(stack).GetState() == tcp.StateClosed }) {
+		t.Errorf("stack state = %v, want %v", stackSocket(stack).GetState(), tcp.StateClosed)
+	}
+	if _, ok := rx.find(func(s *tcp.Segment) bool { return s.HasFlag(tcp.FlagRST) }); !ok {
+		t.Error("kernel sent no RST")
+	}
+	stack.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stack.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() after RST succeeded")
+	}
+}
+
+func TestStackResetsUnknownSegment(t *testing.T) {
+	h := newHarness(t)
+	tx := h.watch(hook.TCPTx)
+
+	// Nothing listens on the port, so the stack answers the SYN with an RST
+	_, err := h.kernelDial(9)
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("kernel dial error = %v, want %v", err, syscall.ECONNREFUSED)
+	}
+	if _, ok := tx.find(func(s *tcp.Segment) bool { return s.HasFlag(tcp.FlagRST) }); !ok {
+		t.Error("stack sent no RST")
+	}
+}
+
+func TestKernelReceiveWindow(t *testing.T) {
+	h := newHarness(t)
+	stack, kernel := h.connect()
+
+	// A kernel receiver that does not read fills its buffer and closes its
+	// window; the stack must stop at the window and resume when it opens
+	kernel.SetReadBuffer(4096)
+	rx := h.watch(hook.TCPRx)
+
+	data := randomData(t, 512*1024)
+	errc := writeAsync(stack, data)
+
+	if !eventually(10*time.Second, func() bool {
+		_, ok := rx.find(func(s *tcp.Segment) bool { return s.WindowSize == 0 && !s.HasFlag(tcp.FlagRST) })
+		return ok
+	}) {
+		t.Log("kernel never advertised a zero window")
+	}
+
+	got := readAll(t, kernel, len(data), 60*time.Second)
+	if err := <-errc; err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("kernel received data that differs from the data sent")
+	}
+}
+
+func TestStackReceiveWindow(t *testing.T) {
+	h := newHarness(t)
+	stack, kernel := h.connect()
+	tx := h.watch(hook.TCPTx)
+	rx := h.watch(hook.TCPRx)
+
+	// The kernel must not send beyond the window the stack advertises
+	data := randomData(t, 1<<20)
+	errc := writeAsync(kernel, data)
+	got := readAll(t, stack, len(data), 60*time.Second)
+	if err := <-errc; err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("stack received data that differs from the data sent")
+	}
+
+	// Every data segment lies within the last window advertised before it
+	var rightEdge uint32
+	var haveEdge bool
+	acks, segs := tx.all(), rx.all()
+	for _, ack := range acks {
+		if ack.HasFlag(tcp.FlagACK) {
+			edge := ack.AckNumber + uint32(ack.WindowSize)
+			if !haveEdge || int32(edge-rightEdge) > 0 {
+				rightEdge, haveEdge = edge, true
+			}
+		}
+	}
+	for _, seg := range segs {
+		if hasData(&seg) && haveEdge && int32(seg.SequenceNumber+uint32(len(seg.Data))-rightEdge) > 0 {
+			t.Errorf("kernel sent %d bytes at %d, beyond the window edge %d", len(seg.Data), seg.SequenceNumber, rightEdge)
+			break
+		}
+	}
+}
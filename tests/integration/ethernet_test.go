@@ -247,8 +247,17 @@ func TestEthernetVLANTagging(t *testing.T) {
 		t.Fatalf("Parse failed: %v", err)
 	}
 
-	if parsed.EtherType != vlanEtherType {
-		t.Errorf("EtherType = 0x%04X, want 0x8100", parsed.EtherType)
+	// The tag is stripped into Frame.VLAN and EtherType is the inner type
+	if len(parsed.VLAN) != 1 || parsed.VLAN[0].VID != 100 {
+		t.Errorf("VLAN = %v, want one tag with VID 100", parsed.VLAN)
+	}
+	if parsed.EtherType != common.EtherTypeIPv4 {
+		t.Errorf("EtherType = 0x%04X, want 0x0800", uint16(parsed.EtherType))
+	}
+
+	// Re-serializing restores the original tagged frame
+	if !bytes.Equal(parsed.Serialize(), data) {
+		t.Error("Tagged frame did not round-trip")
 	}
 
 	t.Log("VLAN-tagged frame handled correctly")